@@ -0,0 +1,20 @@
+// Copyright 2020, Square, Inc.
+
+package main
+
+import (
+	"log"
+
+	"github.com/square/spincycle/v2/all-in-one"
+	jrapp "github.com/square/spincycle/v2/job-runner/app"
+	rmapp "github.com/square/spincycle/v2/request-manager/app"
+)
+
+func main() {
+	s := allinone.NewServer(rmapp.Defaults(), jrapp.Defaults())
+	if err := s.Boot(""); err != nil {
+		log.Fatalf("Error starting Spin Cycle: %s", err)
+	}
+	err := s.Run(true)
+	log.Fatalf("Spin Cycle stopped: %s", err)
+}
@@ -0,0 +1,95 @@
+// Copyright 2020, Square, Inc.
+
+package allinone
+
+import (
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"net"
+	"strings"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/server"
+	gms "github.com/dolthub/go-mysql-server/sql"
+)
+
+//go:generate cp ../request-manager/resources/request_manager_schema.sql schema.sql
+
+// schema is a copy of request-manager/resources/request_manager_schema.sql
+// because files outside the module cannot be embedded. TestSchema checks that
+// it's up to date; run "go generate" after changing the schema.
+//
+//go:embed schema.sql
+var schema string
+
+// memoryDB is an in-memory, MySQL-compatible database for storage "memory".
+// The RM connects to it with the MySQL driver, like any MySQL server, so it
+// listens on a random loopback port. All data is lost when it's closed.
+type memoryDB struct {
+	srv *server.Server
+	dsn string
+}
+
+// startMemoryDB starts a memoryDB with the Request Manager schema loaded.
+func startMemoryDB() (*memoryDB, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	db := memory.NewDatabase(MEMORY_DB_NAME)
+	provider := memory.NewDBProvider(db)
+	engine := sqle.NewDefault(provider)
+	srv, err := server.NewServer(
+		server.Config{Protocol: "tcp", Listener: ln},
+		engine,
+		gms.NewContext,
+		memory.NewSessionBuilder(provider),
+		nil,
+	)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	go srv.Start() // returns when closed
+
+	m := &memoryDB{
+		srv: srv,
+		dsn: fmt.Sprintf("root@tcp(%s)/%s", ln.Addr(), MEMORY_DB_NAME),
+	}
+	if err := m.load(schema); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("error loading schema: %s", err)
+	}
+	return m, nil
+}
+
+// load runs the SQL statements, like the schema, which are separated by ";".
+func (m *memoryDB) load(stmts string) error {
+	db, err := m.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, stmt := range strings.Split(stmts, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open returns a connection pool to the database. It's the RM MakeDbConnPool
+// factory, which does not use request_manager.mysql for storage "memory".
+func (m *memoryDB) open() (*sql.DB, error) {
+	return sql.Open("mysql", m.dsn+"?parseTime=true")
+}
+
+// Close stops the database. Its data is lost.
+func (m *memoryDB) Close() error {
+	return m.srv.Close()
+}
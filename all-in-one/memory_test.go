@@ -0,0 +1,18 @@
+// Copyright 2020, Square, Inc.
+
+package allinone
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	rmSchema, err := ioutil.ReadFile("../request-manager/resources/request_manager_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema != string(rmSchema) {
+		t.Errorf("schema.sql is not request_manager_schema.sql, run go generate")
+	}
+}
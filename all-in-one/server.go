@@ -0,0 +1,255 @@
+// Copyright 2020, Square, Inc.
+
+// Package allinone runs the Request Manager (RM) and Job Runner (JR) in one
+// process for small installs: labs, demos, and integration tests where running
+// two daemons is overkill. The RM and JR are the same as when run separately,
+// with the same APIs and config, and they talk to each other over loopback
+// (by default, 127.0.0.1).
+//
+// By default, the RM stores requests in an in-memory database, so no MySQL is
+// needed, but requests are lost when the process exits. The in-memory database
+// is MySQL-compatible because the RM uses MySQL-specific SQL; SQLite is not
+// supported. Set storage: mysql in the config file to use MySQL instead.
+//
+// This package is its own Go module because the in-memory database is a large
+// dependency that the RM and JR do not need.
+package allinone
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/config"
+	jrapp "github.com/square/spincycle/v2/job-runner/app"
+	jrserver "github.com/square/spincycle/v2/job-runner/server"
+	rmapp "github.com/square/spincycle/v2/request-manager/app"
+	rmserver "github.com/square/spincycle/v2/request-manager/server"
+	"github.com/square/spincycle/v2/shutdown"
+)
+
+const (
+	STORAGE_MEMORY = "memory"
+	STORAGE_MYSQL  = "mysql"
+
+	// MEMORY_DB_NAME is the name of the in-memory database (storage "memory").
+	MEMORY_DB_NAME = "spincycle"
+)
+
+// RMStartTimeout is how long Run waits for the RM API to listen before running
+// the JR, which sends job logs and heartbeats to the RM.
+var RMStartTimeout = 10 * time.Second
+
+// Server runs an RM and a JR. Their app contexts are used like the contexts of
+// separate RM and JR servers, except the LoadConfig hooks, which are set to
+// return the request_manager and job_runner sections of the all-in-one config.
+type Server struct {
+	rmCtx rmapp.Context
+	jrCtx jrapp.Context
+	rm    *rmserver.Server
+	jr    *jrserver.Server
+	cfg   config.AllInOne
+	memDB *memoryDB // storage "memory"
+
+	shutdownSignals []os.Signal // OS signals that call Stop if Run(true)
+	stopMux         sync.Mutex
+	stopped         bool
+	rmRunning       bool // Run started the RM, so Stop stops it
+	jrRunning       bool // Run started the JR, so Stop stops it
+}
+
+func NewServer(rmCtx rmapp.Context, jrCtx jrapp.Context) *Server {
+	return &Server{
+		rmCtx:   rmCtx,
+		jrCtx:   jrCtx,
+		stopMux: sync.Mutex{},
+	}
+}
+
+// Boot loads the all-in-one config file and boots the RM and JR. If cfgFile is
+// empty, the config file is chosen like the RM and JR config files (see
+// config.Load). It must be called before calling Run.
+func (s *Server) Boot(cfgFile string) error {
+	rmCfg, jrCfg := config.Defaults()
+	s.cfg = config.AllInOne{
+		RequestManager: rmCfg,
+		JobRunner:      jrCfg,
+	}
+	if err := config.Load(cfgFile, &s.cfg); err != nil {
+		return fmt.Errorf("error loading config: %s", err)
+	}
+	var err error
+	s.shutdownSignals, err = shutdown.Signals(s.cfg.RequestManager.Server.ShutdownSignals)
+	if err != nil {
+		return fmt.Errorf("error loading config: request_manager.server.shutdown_signals: %s", err)
+	}
+
+	switch s.cfg.Storage {
+	case "", STORAGE_MEMORY:
+		s.memDB, err = startMemoryDB()
+		if err != nil {
+			return fmt.Errorf("error starting in-memory database: %s", err)
+		}
+		s.rmCtx.Factories.MakeDbConnPool = func(rmapp.Context) (*sql.DB, error) {
+			return s.memDB.open()
+		}
+	case STORAGE_MYSQL:
+	default:
+		return fmt.Errorf("error loading config: invalid storage %q: valid values are %s and %s", s.cfg.Storage, STORAGE_MEMORY, STORAGE_MYSQL)
+	}
+
+	s.rmCtx.Hooks.LoadConfig = func(rmapp.Context) (config.RequestManager, error) {
+		return s.cfg.RequestManager, nil
+	}
+	s.jrCtx.Hooks.LoadConfig = func(jrapp.Context) (config.JobRunner, error) {
+		return s.cfg.JobRunner, nil
+	}
+
+	s.rm = rmserver.NewServer(s.rmCtx)
+	if err := s.rm.Boot(); err != nil {
+		s.closeMemoryDB()
+		return fmt.Errorf("error booting Request Manager: %s", err)
+	}
+	s.jr = jrserver.NewServer(s.jrCtx)
+	if err := s.jr.Boot(); err != nil {
+		s.closeMemoryDB()
+		return fmt.Errorf("error booting Job Runner: %s", err)
+	}
+	return nil
+}
+
+// Run runs the RM, waits for its API to listen, then runs the JR. It returns
+// when either stops, after stopping the other.
+//
+// If stopOnSignal = true, the server will listen for shutdown signals from the
+// OS (config request_manager.server.shutdown_signals, default TERM and INT) and
+// call Stop when those signals are received. Else, the caller must call Stop.
+func (s *Server) Run(stopOnSignal bool) error {
+	if s.rm == nil {
+		panic("Server.Run called before Server.Boot")
+	}
+
+	rmErr := make(chan error, 1)
+	jrErr := make(chan error, 1)
+	if !s.running(&s.rmRunning) {
+		return fmt.Errorf("server stopped")
+	}
+	go func() { rmErr <- s.rm.Run(false) }()
+
+	rmURL := s.cfg.JobRunner.RMClient.ServerURL
+	timeout := time.Now().Add(RMStartTimeout)
+	for {
+		err := config.CheckAddr(rmURL, time.Second)
+		if err == nil {
+			break
+		}
+		select {
+		case err := <-rmErr:
+			return fmt.Errorf("Request Manager stopped: %v", err)
+		default:
+		}
+		if time.Now().After(timeout) {
+			s.Stop()
+			return fmt.Errorf("Request Manager not listening at job_runner.rm_client.url %s after %s: %s", rmURL, RMStartTimeout, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !s.running(&s.jrRunning) {
+		s.Stop()
+		<-rmErr
+		return fmt.Errorf("server stopped")
+	}
+	go func() { jrErr <- s.jr.Run(false) }()
+	log.Infof("Running Request Manager and Job Runner in one process")
+
+	if stopOnSignal {
+		go func() {
+			sig := shutdown.Wait(s.shutdownSignals)
+			log.Infof("received %s signal, shutting down", sig)
+			if err := s.Stop(); err != nil {
+				log.Errorf("error shutting down server: %s", err)
+			}
+		}()
+	}
+
+	// When either stops, stop the other, then return the first error
+	var err error
+	select {
+	case err = <-rmErr:
+		err = s.runErr("Request Manager", err)
+		s.Stop()
+		<-jrErr
+	case err = <-jrErr:
+		err = s.runErr("Job Runner", err)
+		s.Stop()
+		<-rmErr
+	}
+	return err
+}
+
+// runErr returns the error from running the RM or JR, or nil if it stopped
+// because Stop was called, which makes its API return an error.
+func (s *Server) runErr(name string, err error) error {
+	s.stopMux.Lock()
+	defer s.stopMux.Unlock()
+	if err == nil || s.stopped {
+		return nil
+	}
+	return fmt.Errorf("%s: %s", name, err)
+}
+
+// running sets the running flag of the RM or JR unless the server was stopped,
+// in which case it returns false and the RM or JR must not be run.
+func (s *Server) running(flag *bool) bool {
+	s.stopMux.Lock()
+	defer s.stopMux.Unlock()
+	if s.stopped {
+		return false
+	}
+	*flag = true
+	return true
+}
+
+// Stop stops the JR, then the RM, if Run started them, then the in-memory
+// database, if any. The JR is stopped first because it suspends running job
+// chains and sends them to the RM, which resumes them on restart (not with
+// storage "memory": requests are lost). Once Stop has been called, the server
+// cannot be reused.
+func (s *Server) Stop() error {
+	s.stopMux.Lock()
+	defer s.stopMux.Unlock()
+	if s.stopped {
+		return nil
+	}
+	s.stopped = true
+
+	var jrErr, rmErr error
+	if s.jrRunning {
+		jrErr = s.jr.Stop()
+	}
+	if s.rmRunning {
+		rmErr = s.rm.Stop()
+	}
+	s.closeMemoryDB()
+	if jrErr != nil {
+		return fmt.Errorf("error stopping Job Runner: %s", jrErr)
+	}
+	if rmErr != nil {
+		return fmt.Errorf("error stopping Request Manager: %s", rmErr)
+	}
+	return nil
+}
+
+// closeMemoryDB closes the in-memory database, if any, after the RM is stopped.
+func (s *Server) closeMemoryDB() {
+	if s.memDB == nil {
+		return
+	}
+	if err := s.memDB.Close(); err != nil {
+		log.Errorf("error closing in-memory database: %s", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2020, Square, Inc.
+
+package allinone_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	allinone "github.com/square/spincycle/v2/all-in-one"
+	jrapp "github.com/square/spincycle/v2/job-runner/app"
+	rmapp "github.com/square/spincycle/v2/request-manager/app"
+)
+
+// freeAddr returns a loopback address with a port that's free, probably.
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// bootServer boots a server with storage "memory" on free ports.
+func bootServer(t *testing.T) (*allinone.Server, string, string) {
+	dir, err := ioutil.TempDir("", "spincycle-all-in-one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	specsDir := filepath.Join(dir, "specs")
+	if err := os.Mkdir(specsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rmAddr := freeAddr(t)
+	jrAddr := freeAddr(t)
+	cfg := fmt.Sprintf(`---
+storage: memory
+request_manager:
+  server:
+    addr: %s
+  specs:
+    dir: %s
+  jr_client:
+    url: http://%s
+job_runner:
+  server:
+    addr: %s
+  rm_client:
+    url: http://%s
+`, rmAddr, specsDir, jrAddr, jrAddr, rmAddr)
+	cfgFile := filepath.Join(dir, "all-in-one.yaml")
+	if err := ioutil.WriteFile(cfgFile, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := allinone.NewServer(rmapp.Defaults(), jrapp.Defaults())
+	if err := s.Boot(cfgFile); err != nil {
+		t.Fatal(err)
+	}
+	return s, rmAddr, jrAddr
+}
+
+func TestRunStop(t *testing.T) {
+	s, rmAddr, jrAddr := bootServer(t)
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- s.Run(false) }()
+
+	// Wait for the JR API, which Run runs after the RM API is listening
+	timeout := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := http.Get("http://" + jrAddr + "/api/v1/status/running"); err == nil {
+			break
+		}
+		if time.Now().After(timeout) {
+			t.Fatal("Job Runner API not listening after 10s")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// The RM uses the in-memory database
+	resp, err := http.Get("http://" + rmAddr + "/api/v1/requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/v1/requests: status %d, expected %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Error(err)
+	}
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("Run returned error %v, expected nil after Stop", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
+
+	// Stop again is a no-op
+	if err := s.Stop(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStopBeforeRun(t *testing.T) {
+	s, _, _ := bootServer(t)
+
+	// Stop does not stop the RM and JR, which were not run, so it doesn't block
+	stopErr := make(chan error, 1)
+	go func() { stopErr <- s.Stop() }()
+	select {
+	case err := <-stopErr:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Stop blocked, expected it to return")
+	}
+
+	if err := s.Run(false); err == nil {
+		t.Errorf("Run returned nil after Stop, expected an error")
+	}
+}
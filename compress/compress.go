@@ -0,0 +1,175 @@
+// Copyright 2020, Square, Inc.
+
+// Package compress provides pluggable compression codecs for large payloads:
+// job chains and suspended job chains (SJC) sent between the Request Manager and
+// Job Runner, and stored by the Request Manager. Codecs are registered by name,
+// which is also the HTTP Content-Encoding. GZIP is built in. Other codecs, like
+// zstd, are registered by embedders with Register before the server boots.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// GZIP is the name of the built-in gzip codec.
+const GZIP = "gzip"
+
+// Codec compresses and decompresses data. Codecs must be safe for concurrent use.
+type Codec interface {
+	// Name returns the codec name, which is also its HTTP Content-Encoding,
+	// like "gzip" or "zstd".
+	Name() string
+
+	// Compress returns data compressed.
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress returns data decompressed.
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	codecsMux = &sync.RWMutex{}
+	codecs    = map[string]Codec{
+		GZIP: Gzip{},
+	}
+)
+
+// Register registers a codec by its name, replacing any codec with the same name.
+func Register(c Codec) {
+	codecsMux.Lock()
+	defer codecsMux.Unlock()
+	codecs[strings.ToLower(c.Name())] = c
+}
+
+// Get returns the registered codec with the given name. The name is
+// case-insensitive. An error is returned if no codec has the name.
+func Get(name string) (Codec, error) {
+	codecsMux.RLock()
+	defer codecsMux.RUnlock()
+	c, ok := codecs[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression codec %q: registered codecs are %s", name, strings.Join(names(), ", "))
+	}
+	return c, nil
+}
+
+// Negotiate returns the first registered codec listed in an HTTP Accept-Encoding
+// header value, ignoring quality values except q=0, which excludes a codec. It
+// returns nil if the header does not list a registered codec.
+func Negotiate(acceptEncoding string) Codec {
+	for _, enc := range strings.Split(acceptEncoding, ",") {
+		f := strings.Split(enc, ";")
+		if len(f) > 1 && strings.Replace(strings.TrimSpace(f[1]), " ", "", -1) == "q=0" {
+			continue
+		}
+		if c, err := Get(f[0]); err == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// AcceptEncoding returns an HTTP Accept-Encoding header value listing all
+// registered codecs.
+func AcceptEncoding() string {
+	codecsMux.RLock()
+	defer codecsMux.RUnlock()
+	return strings.Join(names(), ", ")
+}
+
+// names returns the sorted names of registered codecs. The caller must hold codecsMux.
+func names() []string {
+	n := make([]string, 0, len(codecs))
+	for name := range codecs {
+		n = append(n, name)
+	}
+	sort.Strings(n)
+	return n
+}
+
+// --------------------------------------------------------------------------
+
+// Gzip is the built-in gzip codec.
+type Gzip struct{}
+
+var _ Codec = Gzip{}
+
+func (Gzip) Name() string {
+	return GZIP
+}
+
+func (Gzip) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (Gzip) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// --------------------------------------------------------------------------
+
+// packMagic starts packed data. It cannot start JSON, so data stored before
+// compression was enabled is still read as-is by Unpack.
+const packMagic = 0x00
+
+// Pack compresses data with the named codec and prefixes it with the codec name
+// so that Unpack knows how to decompress it. If name is empty, data is returned
+// as-is. This is used to store data, like job chains, which is later read by Unpack.
+func Pack(name string, data []byte) ([]byte, error) {
+	if name == "" {
+		return data, nil
+	}
+	c, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	z, err := c.Compress(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s compress: %s", c.Name(), err)
+	}
+	packed := make([]byte, 0, len(z)+len(c.Name())+2)
+	packed = append(packed, packMagic)
+	packed = append(packed, c.Name()...)
+	packed = append(packed, packMagic)
+	return append(packed, z...), nil
+}
+
+// Unpack decompresses data packed by Pack. Data that was not packed is returned
+// as-is, so Unpack is safe to use on data stored with and without compression.
+func Unpack(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != packMagic {
+		return data, nil
+	}
+	end := bytes.IndexByte(data[1:], packMagic)
+	if end < 0 {
+		return nil, fmt.Errorf("invalid packed data: no codec name terminator")
+	}
+	c, err := Get(string(data[1 : end+1]))
+	if err != nil {
+		return nil, err
+	}
+	unpacked, err := c.Decompress(data[end+2:])
+	if err != nil {
+		return nil, fmt.Errorf("%s decompress: %s", c.Name(), err)
+	}
+	return unpacked, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2020, Square, Inc.
+
+package compress_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/square/spincycle/v2/compress"
+)
+
+// reverse is a fake codec to test that registered codecs are pluggable
+type reverse struct{}
+
+func (reverse) Name() string { return "reverse" }
+
+func (reverse) Compress(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i := range data {
+		out[len(data)-1-i] = data[i]
+	}
+	return out, nil
+}
+
+func (r reverse) Decompress(data []byte) ([]byte, error) {
+	return r.Compress(data)
+}
+
+func TestPackUnpack(t *testing.T) {
+	compress.Register(reverse{})
+	data := []byte(`{"requestId":"abc","jobs":{}}`)
+
+	for _, name := range []string{"gzip", "reverse"} {
+		packed, err := compress.Pack(name, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(packed, data) {
+			t.Errorf("%s: data not compressed", name)
+		}
+		unpacked, err := compress.Unpack(packed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(unpacked, data) {
+			t.Errorf("%s: got %s, expected %s", name, unpacked, data)
+		}
+	}
+
+	// No codec = data as-is, and data that wasn't packed is read as-is
+	packed, err := compress.Pack("", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packed, data) {
+		t.Errorf("got %s, expected %s", packed, data)
+	}
+	unpacked, err := compress.Unpack(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unpacked, data) {
+		t.Errorf("got %s, expected %s", unpacked, data)
+	}
+
+	if _, err := compress.Pack("zstd", data); err == nil {
+		t.Errorf("no error packing with unregistered codec, expected one")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	c := compress.Negotiate("br, gzip;q=0.8")
+	if c == nil || c.Name() != compress.GZIP {
+		t.Errorf("got %v, expected gzip codec", c)
+	}
+	if c := compress.Negotiate("br, gzip;q=0"); c != nil {
+		t.Errorf("got %s codec, expected nil", c.Name())
+	}
+	if c := compress.Negotiate(""); c != nil {
+		t.Errorf("got %s codec, expected nil", c.Name())
+	}
+}
+
+func TestTransportAndMiddleware(t *testing.T) {
+	payload := strings.Repeat(`{"id":"job1","type":"noop"},`, 100)
+
+	var gotEncoding string
+	e := echo.New()
+	e.Use(compress.Middleware())
+	e.POST("/job-chains", func(c echo.Context) error {
+		body, err := ioutil.ReadAll(c.Request().Body)
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, string(body))
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding = r.Header.Get("Content-Encoding")
+		e.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: &compress.Transport{Codec: compress.Gzip{}}}
+	resp, err := client.Post(ts.URL+"/job-chains", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotEncoding != compress.GZIP {
+		t.Errorf("request Content-Encoding = %q, expected gzip", gotEncoding)
+	}
+	if string(body) != payload {
+		t.Errorf("got response %q, expected payload echoed", body)
+	}
+
+	// Unknown encoding is rejected
+	req, _ := http.NewRequest("POST", ts.URL+"/job-chains", strings.NewReader(payload))
+	req.Header.Set("Content-Encoding", "lz4")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("got HTTP status %d, expected %d", resp.StatusCode, http.StatusUnsupportedMediaType)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2020, Square, Inc.
+
+package compress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MinSize is the minimum payload size, in bytes, to compress. Smaller payloads,
+// like most API responses, are not worth compressing.
+var MinSize = 1024
+
+// Transport is an http.RoundTripper that compresses request payloads with Codec
+// and decompresses responses encoded with any registered codec. It is used by
+// Request Manager and Job Runner clients when the http client section of the
+// config sets compression. If Codec is nil, only responses are decompressed.
+// Request payloads of unknown length, like streamed job chains, are not
+// compressed because that would buffer them.
+type Transport struct {
+	Base  http.RoundTripper // default: http.DefaultTransport
+	Codec Codec
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	// Don't modify the caller's request (see http.RoundTripper)
+	req = req.Clone(req.Context())
+	if t.Codec != nil && req.ContentLength > 0 && req.Header.Get("Content-Encoding") == "" {
+		payload, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) >= MinSize {
+			if payload, err = t.Codec.Compress(payload); err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Encoding", t.Codec.Name())
+		}
+		req.ContentLength = int64(len(payload))
+		req.Body = ioutil.NopCloser(bytes.NewReader(payload))
+	}
+	req.Header.Set("Accept-Encoding", AcceptEncoding())
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	enc := resp.Header.Get("Content-Encoding")
+	if enc == "" {
+		return resp, nil
+	}
+	c, err := Get(enc)
+	if err != nil {
+		return resp, nil // not ours; let the caller deal with it
+	}
+	z, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.Decompress(z)
+	if err != nil {
+		return nil, err
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = int64(len(body))
+	resp.Uncompressed = true
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// Middleware returns Echo middleware that decompresses request payloads encoded
+// with a registered codec (Content-Encoding), and compresses responses with the
+// first registered codec that the client accepts (Accept-Encoding). Requests
+// encoded with an unknown codec are rejected with HTTP 415.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if enc := req.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+				codec, err := Get(enc)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusUnsupportedMediaType, err.Error())
+				}
+				z, err := ioutil.ReadAll(req.Body)
+				req.Body.Close()
+				if err != nil {
+					return err
+				}
+				payload, err := codec.Decompress(z)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+				}
+				req.Header.Del("Content-Encoding")
+				req.ContentLength = int64(len(payload))
+				req.Body = ioutil.NopCloser(bytes.NewReader(payload))
+			}
+
+			codec := Negotiate(req.Header.Get("Accept-Encoding"))
+			if codec == nil {
+				return next(c)
+			}
+
+			// Buffer the response, then compress it if it's big enough
+			res := c.Response()
+			rw := res.Writer
+			bw := &bufferedWriter{ResponseWriter: rw}
+			res.Writer = bw
+			err := next(c)
+			res.Writer = rw
+			if ferr := bw.flush(codec); ferr != nil {
+				return ferr
+			}
+			return err
+		}
+	}
+}
+
+// bufferedWriter buffers a response so it can be compressed when complete.
+type bufferedWriter struct {
+	http.ResponseWriter
+	buf  bytes.Buffer
+	code int
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+func (w *bufferedWriter) flush(codec Codec) error {
+	if w.code == 0 {
+		return nil // nothing written, e.g. handler returned an error
+	}
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	body := w.buf.Bytes()
+	if len(body) >= MinSize {
+		z, err := codec.Compress(body)
+		if err != nil {
+			return err
+		}
+		body = z
+		h.Set("Content-Encoding", codec.Name())
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.code)
+	_, err := w.ResponseWriter.Write(body)
+	return err
+}
@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
@@ -22,16 +23,47 @@ const (
 	DEFAULT_ADDR_JOB_RUNNER      = "127.0.0.1:32307"
 	DEFAULT_MYSQL_DSN            = "root:@tcp(localhost:3306)/spincycle_development"
 	DEFAULT_SPECS_DIR            = "specs/"
+	DEFAULT_TOKEN_MAX_TTL        = "720h" // 30 days
+	DEFAULT_REGISTRY_TIMEOUT     = "60s"
+	DEFAULT_HEARTBEAT_INTERVAL   = "10s"
+	DEFAULT_PROGRESS_INTERVAL    = "1s"
+	DEFAULT_PROGRESS_BATCH_SIZE  = 500
+	DEFAULT_CHAIN_RETENTION      = "5m"
+	DEFAULT_JR_CLIENT_RETRY      = 2
+	DEFAULT_JR_CLIENT_RETRY_WAIT = "500ms"
+	DEFAULT_SLOW_JOB_FACTOR      = 2.0
+	DEFAULT_CONCURRENCY_MIN      = 1
+
+	DEFAULT_JOB_LOG_MAX_OUTPUT_KB = 1024 // 1 MB
+	DEFAULT_JOB_LOG_MAX_ERROR_KB  = 64
+	DEFAULT_CACHE_MAX_ENTRIES     = 10000
+
+	DEFAULT_JOB_LOG_WAL_BATCH_SIZE     = 500
+	DEFAULT_JOB_LOG_WAL_FLUSH_INTERVAL = "100ms"
+	DEFAULT_JOB_LOG_WAL_MAX_PENDING    = 100000
+
+	DEFAULT_BACKFILL_CHUNK_SIZE = 1000
+	DEFAULT_BACKFILL_THROTTLE   = "100ms"
+
+	TRIGGER_SOURCE_WEBHOOK = "webhook" // built-in trigger source: POST /api/v1/triggers/${name}
 )
 
-// Load loads a config file into the struct pointed to by configStruct.
+// Load loads a config file into the struct pointed to by configStruct. If cfgFile
+// is empty, the config file is the first command line arg that is not a flag
+// (like --validate-only), else a default config file based on the ENVIRONMENT
+// environment variable. Unknown config options are an error.
 func Load(cfgFile string, configStruct interface{}) error {
 	var required bool
+	if cfgFile == "" {
+		for _, arg := range os.Args[1:] {
+			if !strings.HasPrefix(arg, "-") {
+				cfgFile = arg
+				break
+			}
+		}
+	}
 	if cfgFile != "" {
 		required = true // required if specified
-	} else if len(os.Args) > 1 {
-		cfgFile = os.Args[1]
-		required = true // required if specified
 	} else {
 		// Default config file not required
 		switch os.Getenv("ENVIRONMENT") {
@@ -55,8 +87,10 @@ func Load(cfgFile string, configStruct interface{}) error {
 		return err
 	}
 
-	// Unmarshal the contents of the file into the provided struct.
-	return yaml.Unmarshal(data, configStruct)
+	// Unmarshal the contents of the file into the provided struct. Strict
+	// because an unknown option is probably a typo that would silently
+	// use the default value.
+	return yaml.UnmarshalStrict(data, configStruct)
 }
 
 // NewTLSConfig creates a tls.Config from the given cert, key, and ca files.
@@ -93,8 +127,30 @@ func Defaults() (RequestManager, JobRunner) {
 		Specs: Specs{
 			Dir: DEFAULT_SPECS_DIR,
 		},
+		Auth: Auth{
+			TokenMaxTTL: DEFAULT_TOKEN_MAX_TTL,
+		},
 		JRClient: HTTPClient{
 			ServerURL: "http://" + DEFAULT_ADDR_JOB_RUNNER,
+			Retry:     DEFAULT_JR_CLIENT_RETRY,
+			RetryWait: DEFAULT_JR_CLIENT_RETRY_WAIT,
+		},
+		Registry: Registry{
+			Timeout: DEFAULT_REGISTRY_TIMEOUT,
+		},
+		JobLog: JobLog{
+			WAL: JobLogWAL{
+				BatchSize:     DEFAULT_JOB_LOG_WAL_BATCH_SIZE,
+				FlushInterval: DEFAULT_JOB_LOG_WAL_FLUSH_INTERVAL,
+				MaxPending:    DEFAULT_JOB_LOG_WAL_MAX_PENDING,
+			},
+		},
+		Cache: Cache{
+			MaxEntries: DEFAULT_CACHE_MAX_ENTRIES,
+		},
+		Backfill: Backfill{
+			ChunkSize: DEFAULT_BACKFILL_CHUNK_SIZE,
+			Throttle:  DEFAULT_BACKFILL_THROTTLE,
 		},
 	}
 	jrCfg := JobRunner{
@@ -104,6 +160,19 @@ func Defaults() (RequestManager, JobRunner) {
 		RMClient: HTTPClient{
 			ServerURL: "http://" + DEFAULT_ADDR_REQUEST_MANAGER,
 		},
+		Registration: Registration{
+			Interval: DEFAULT_HEARTBEAT_INTERVAL,
+		},
+		Progress: Progress{
+			Interval:  DEFAULT_PROGRESS_INTERVAL,
+			BatchSize: DEFAULT_PROGRESS_BATCH_SIZE,
+		},
+		JobLog: JobLogLimits{
+			MaxStdoutKB: DEFAULT_JOB_LOG_MAX_OUTPUT_KB,
+			MaxStderrKB: DEFAULT_JOB_LOG_MAX_OUTPUT_KB,
+			MaxErrorKB:  DEFAULT_JOB_LOG_MAX_ERROR_KB,
+		},
+		ChainRetention: DEFAULT_CHAIN_RETENTION,
 	}
 	return rmCfg, jrCfg
 }
@@ -129,19 +198,48 @@ func Env(envar, def string) string {
 //       cert_file: myorg.crt
 //       key_file: myorg.key
 //       ca_file: myorg.ca
+//       verify_client: true
+//       allowed_sans: ["spincycle-jr.myorg.local"]
 //   mysql:
 //     dsn: "spincycle@tcp(spin-mysql.local:3306)/spincycle_production"
+//     compression: gzip
 //   specs:
 //     dir: /data/app/spin-rm/specs/
 //   auth:
 //     admin_roles: ["dba"]
 //     strict: true
+//     token_max_ttl: 168h
 //   jr_client:
 //     url: https://spincycle-jr.myorg.local:32307
 //     tls:
 //       cert_file: myorg.crt
 //       key_file: myorg.key
 //       ca_file: myorg.ca
+//       server_name: spincycle-jr.myorg.local
+//     compression: gzip
+//   shadow:
+//     requests: ["stop-host"]
+//     jr_client:
+//       url: https://spincycle-jr-canary.myorg.local:32307
+//   maintenance:
+//     enabled: true
+//     reason: "upgrading to v2.1"
+//   registry:
+//     timeout: 60s
+//   add_job:
+//     types: ["collect-diagnostics"]
+//   job_log:
+//     max_tries: 3
+//     retention: 720h
+//     wal:
+//       dir: /var/lib/spincycle/jl-wal
+//   triggers:
+//     - name: deploy-finished
+//       source: webhook
+//       request: warm-cache
+//       args:
+//         app: "{{.app}}"
+//       dedup_key: "{{.deployId}}"
 //
 // The reciprocal top-level config is JobRunner.
 type RequestManager struct {
@@ -150,6 +248,15 @@ type RequestManager struct {
 	Specs    Specs      `yaml:"specs"`     // request specs
 	Auth     Auth       `yaml:"auth"`      // auth plugin
 	JRClient HTTPClient `yaml:"jr_client"` // RM to JR internal communication
+	Shadow   Shadow     `yaml:"shadow"`    // shadow runs on another JR pool
+
+	Maintenance Maintenance `yaml:"maintenance"` // reject new requests
+	Registry    Registry    `yaml:"registry"`    // Job Runner registration
+	AddJob      AddJob      `yaml:"add_job"`     // jobs that can be added to running requests
+	JobLog      JobLog      `yaml:"job_log"`     // job log retention
+	Triggers    []Trigger   `yaml:"triggers"`    // start requests on external messages
+	Cache       Cache       `yaml:"cache"`       // cache request status reads
+	Backfill    Backfill    `yaml:"backfill"`    // online data migrations
 }
 
 // JobRunner represents the top-level layout for a Job Runner (JR) YAML config file.
@@ -168,11 +275,108 @@ type RequestManager struct {
 //       cert_file: myorg.crt
 //       key_file: myorg.key
 //       ca_file: myorg.ca
+//     compression: gzip
+//   registration:
+//     enabled: true
+//     capacity: 100
+//     labels:
+//       zone: us-east-1a
+//   progress:
+//     batch: true
+//   sandboxes:
+//     - types: [shell-command]
+//       user: nobody
+//       limits:
+//         nofile: 1024
+//   workspaces:
+//     quota_mb: 512
 //
 // The reciprocal top-level config is RequestManager.
 type JobRunner struct {
 	Server   Server     `yaml:"server"`    // API addr and TLS
 	RMClient HTTPClient `yaml:"rm_client"` // JR to RM internal communication
+
+	Registration Registration `yaml:"registration"` // register with the RM
+	Progress     Progress     `yaml:"progress"`     // report request progress to the RM
+	Sandboxes    []Sandbox    `yaml:"sandboxes"`    // run untrusted job types with fewer privileges
+	Workspaces   Workspaces   `yaml:"workspaces"`   // scratch directories for jobs
+	JobLog       JobLogLimits `yaml:"job_log"`      // size limits of job log entries
+	Concurrency  Concurrency  `yaml:"concurrency"`  // max jobs of one request running at once
+
+	// ChainRetention is how long the status of a job chain is kept in memory
+	// after the chain is done, so GET /api/v1/job-chains returns it and a late
+	// stop for the request succeeds. Then it's removed.
+	//
+	// The default is DEFAULT_CHAIN_RETENTION.
+	ChainRetention string `yaml:"chain_retention"`
+
+	// FaultInjection enables Job Runner PUT /api/v1/faults to inject failures
+	// for chaos testing: delayed and failed jobs, dropped Request Manager calls,
+	// and crashes. Never enable it in production.
+	//
+	// The default is disabled.
+	FaultInjection bool `yaml:"fault_injection"`
+
+	// Profiling enables net/http/pprof endpoints (/debug/pprof/) and Job Runner
+	// POST /api/v1/job-chains/{requestId}/profile, which the Request Manager
+	// uses to capture profiles while a request runs and attach them to it.
+	//
+	// The default is disabled.
+	Profiling bool `yaml:"profiling"`
+
+	// CheckpointDir enables local checkpointing: running job chains are saved
+	// in this directory after every job, and when the Job Runner starts, it
+	// recovers the job chains it was running when it crashed. Requests still
+	// running on this Job Runner are resumed, and other checkpoints are discarded.
+	//
+	// The default is disabled (no directory): the Request Manager recovers
+	// requests from a dead Job Runner from their job logs.
+	CheckpointDir string `yaml:"checkpoint_dir"`
+
+	// OutboxDir enables the outbox: when the Request Manager is unreachable,
+	// job log entries, finished requests, and suspended job chains are saved
+	// in this directory and sent, in order, with backoff when the RM is back.
+	// Reports left in the directory are sent when the Job Runner starts.
+	//
+	// The default is disabled (no directory): reports are retried a few times,
+	// then lost.
+	OutboxDir string `yaml:"outbox_dir"`
+
+	// SlowJobs are slow job watchdogs: the expected duration of job types. A
+	// job that runs longer than a multiple of its expected duration is logged,
+	// counted (metric jobs_slow), and sent to a webhook, if set. The job is not
+	// stopped: slow jobs are only made visible early.
+	//
+	// The default is no watchdogs.
+	SlowJobs []SlowJob `yaml:"slow_jobs"`
+}
+
+// AllInOne represents the top-level layout for an all-in-one YAML config file:
+// the RequestManager and JobRunner configs, for running both in one process.
+// An all-in-one config file looks like:
+//
+//   ---
+//   storage: memory
+//   request_manager:
+//     specs:
+//       dir: specs/
+//   job_runner:
+//     sandboxes:
+//       - types: [shell-command]
+//         user: nobody
+//
+// By default (Defaults), the RM listens on 127.0.0.1:32308, the JR on
+// 127.0.0.1:32307, and each uses the other at those addresses (loopback).
+type AllInOne struct {
+	// Storage is where the RM stores requests: "memory" for an in-memory
+	// database, which is lost when the process exits, or "mysql" for the
+	// MySQL database configured by request_manager.mysql.
+	//
+	// The default is "memory".
+	Storage string `yaml:"storage"`
+
+	RequestManager RequestManager `yaml:"request_manager"`
+	JobRunner      JobRunner      `yaml:"job_runner"`
 }
 
 // --------------------------------------------------------------------------
@@ -184,10 +388,422 @@ type Auth struct {
 	// Callers with one of these roles are admins (allowed all ops) for all requests.
 	AdminRoles []string `yaml:"admin_roles"`
 
+	// Callers with one of these roles, and no admin role, are read-only: they
+	// can view requests, job logs, and status, but every API call that changes
+	// something (any HTTP method other than GET) is denied, except managing
+	// their own API tokens, which are read-only too. For dashboards and callers
+	// that should only look.
+	ReadOnlyRoles []string `yaml:"read_only_roles"`
+
 	// Strict requires all requests to have ACLs, else callers are denied unless
 	// they have an admin role. Strict is disabled by default which, with the default
 	// auth plugin, allows all callers (no auth).
 	Strict bool `yaml:"strict"`
+
+	// TokenMaxTTL is the default and maximum time.Duration string that API
+	// tokens are valid. Callers create API tokens (spinc login) and use them
+	// instead of the auth plugin to authenticate. Tokens work with or without
+	// an auth plugin.
+	//
+	// The default is DEFAULT_TOKEN_MAX_TTL.
+	TokenMaxTTL string `yaml:"token_max_ttl"`
+}
+
+// The shadow section of RequestManager configures shadow runs. When a request
+// of a shadowed type is started, a copy of its job chain is also run on the
+// shadow Job Runner pool, and the results of both runs can be compared with
+// the GET /api/v1/requests/${requestId}/shadow endpoint. This is used to test
+// new job code on real requests. Shadow jobs run for real, so the shadow JR
+// pool must run jobs that are safe to run twice, like dry-run versions.
+type Shadow struct {
+	// Requests are the request names (types) to shadow.
+	//
+	// The default is no requests: shadow runs are disabled.
+	Requests []string `yaml:"requests"`
+
+	// JRClient configures the client for the shadow Job Runner pool, like the
+	// jr_client section. The url is required if requests are set.
+	JRClient HTTPClient `yaml:"jr_client"`
+}
+
+// The maintenance section of RequestManager sets maintenance mode on startup.
+// In maintenance mode, new requests are rejected (HTTP 503) but existing requests
+// can be queried and stopped, which is used to drain the system before upgrades.
+// Admins can change maintenance mode at runtime with PUT /api/v1/maintenance.
+type Maintenance struct {
+	// Enabled enables maintenance mode on startup.
+	//
+	// The default is disabled.
+	Enabled bool `yaml:"enabled"`
+
+	// Reason is returned to callers when a new request is rejected.
+	Reason string `yaml:"reason"`
+}
+
+// The add_job section of RequestManager configures which jobs operators can add
+// to running requests with POST /api/v1/requests/${requestId}/jobs, for example
+// to collect diagnostics after a job. Added jobs run after a given job and before
+// the last job in the request; they are not part of any sequence, so they are
+// never retried by a sequence retry.
+type AddJob struct {
+	// Types are the job types that can be added. Only pre-approved jobs that
+	// are safe to run at any point in any request should be listed.
+	//
+	// The default is no types: jobs cannot be added.
+	Types []string `yaml:"types"`
+}
+
+// The job_log section of RequestManager configures job log retention. By default,
+// every try of every job is kept until deleted with the job log API.
+type JobLog struct {
+	// MaxTries is how many tries of each job to keep: the most recent tries.
+	// Older tries are deleted when a try is saved, so 1 keeps only the last
+	// try. The default is 0: all tries are kept.
+	MaxTries uint `yaml:"max_tries"`
+
+	// MaxPerRequest is the maximum number of job log entries (tries) kept per
+	// request. When a request exceeds it, its oldest tries are deleted, but the
+	// last try of every job is kept, so a request with more jobs exceeds it.
+	// The default is 0: no maximum.
+	MaxPerRequest uint `yaml:"max_per_request"`
+
+	// Retention is how long job logs of finished requests are kept, at least.
+	// The purge and delete APIs do not delete job logs of requests finished
+	// more recently, and purge deletes older job logs by default. Job logs are
+	// not purged automatically.
+	//
+	// The default is no retention: job logs can be deleted anytime, and purge
+	// requires an age.
+	Retention string `yaml:"retention"`
+
+	// WAL enables high-volume mode for job log writes.
+	WAL JobLogWAL `yaml:"wal"`
+}
+
+// The wal section of job_log enables high-volume mode for job log writes, for
+// fan-out chains that finish thousands of jobs per second. Job logs are appended
+// to a write-ahead log (WAL) on local disk, which is fast, and a background flusher
+// inserts them into MySQL in batches. The WAL survives short MySQL outages and
+// Request Manager restarts: job logs not inserted yet are inserted when MySQL
+// is back or on startup. Reads include job logs not inserted yet, but only on
+// the Request Manager instance that received them, so reads from other instances
+// can lag by up to FlushInterval.
+type JobLogWAL struct {
+	// Dir is the WAL directory, which must exist and should be on local disk.
+	// Each Request Manager instance must have its own directory.
+	//
+	// The default is no directory: high-volume mode is disabled, and every job
+	// log is inserted into MySQL before it's acknowledged.
+	Dir string `yaml:"dir"`
+
+	// BatchSize is the max number of job logs inserted at once. The flusher
+	// flushes when this many job logs are pending, else every FlushInterval.
+	//
+	// The default is DEFAULT_JOB_LOG_WAL_BATCH_SIZE.
+	BatchSize uint `yaml:"batch_size"`
+
+	// FlushInterval is how often pending job logs are inserted, like "100ms".
+	//
+	// The default is DEFAULT_JOB_LOG_WAL_FLUSH_INTERVAL.
+	FlushInterval string `yaml:"flush_interval"`
+
+	// MaxPending is the max number of job logs in the WAL that are not inserted
+	// yet. When reached, for example because MySQL has been down for a while,
+	// new job logs are rejected, and Job Runners retry them.
+	//
+	// The default is DEFAULT_JOB_LOG_WAL_MAX_PENDING.
+	MaxPending uint `yaml:"max_pending"`
+}
+
+// The cache section of RequestManager enables a short-TTL in-memory cache of
+// request status and find queries made through the API (GET /api/v1/requests,
+// POST /api/v1/requests/status, and GET /api/v1/status/running), which absorbs
+// polling by dashboards. Progress updates and request state changes received
+// by the API invalidate cached results. Every RM instance has its own cache.
+type Cache struct {
+	// TTL is how long results are cached, like "2s". Other changes, like
+	// requests recovered from a dead Job Runner, are seen after at most this
+	// long.
+	//
+	// The default is disabled (no TTL).
+	TTL string `yaml:"ttl"`
+
+	// MaxEntries is the max number of cached requests and query results.
+	//
+	// The default is DEFAULT_CACHE_MAX_ENTRIES.
+	MaxEntries uint `yaml:"max_entries"`
+}
+
+// Backfill configures online data migrations (backfills) in the Request Manager.
+// A backfill migrates existing data after a schema migration, like setting a new
+// column in every job log entry. It runs in the background in chunks, so large
+// tables are migrated without a long maintenance window. Progress is saved after
+// every chunk, so a backfill resumes where it stopped when the Request Manager
+// restarts. Only one Request Manager instance runs a backfill at a time.
+type Backfill struct {
+	// ChunkSize is the max number of rows migrated per chunk. Smaller chunks
+	// hold row locks for less time.
+	//
+	// The default is DEFAULT_BACKFILL_CHUNK_SIZE.
+	ChunkSize uint `yaml:"chunk_size"`
+
+	// Throttle is how long to wait between chunks, like "100ms", to limit the
+	// load on MySQL and replication lag.
+	//
+	// The default is DEFAULT_BACKFILL_THROTTLE.
+	Throttle string `yaml:"throttle"`
+
+	// Disabled disables running backfills on this Request Manager instance.
+	// Progress can still be reported.
+	//
+	// The default is false (enabled).
+	Disabled bool `yaml:"disabled"`
+}
+
+// A trigger in the triggers section of RequestManager starts a request for each
+// message received from a source: the built-in webhook receiver (POST
+// /api/v1/triggers/${name}) or a trigger source plugin, like an SQS queue or Kafka
+// topic consumer. The message payload must be a JSON object. Its fields are
+// mapped to request args with Go text/template templates, like "{{.host}}".
+// Messages that cannot be processed are saved in the trigger error queue
+// (GET /api/v1/triggers/${name}/errors) and, for plugin sources, returned to
+// the source to move to its own error queue.
+type Trigger struct {
+	// Name uniquely identifies the trigger. Requests are created by user
+	// "trigger:<name>".
+	Name string `yaml:"name"`
+
+	// Source is "webhook" or the name of a trigger source plugin. Each plugin
+	// source can be used by only one trigger.
+	Source string `yaml:"source"`
+
+	// Request is the request name (type) to create and start.
+	Request string `yaml:"request"`
+
+	// Args maps request arg names to templates executed with the payload.
+	// It's an error if a template uses a payload field that does not exist.
+	// Request args not set use their defaults, if optional.
+	Args map[string]string `yaml:"args"`
+
+	// DedupKey is a template executed with the payload, like "{{.eventId}}".
+	// A message with the same dedup key as a previous message of the trigger
+	// is ignored, so a message delivered more than once starts one request.
+	//
+	// The default is no dedup: every message starts a request.
+	DedupKey string `yaml:"dedup_key"`
+}
+
+// The registry section of RequestManager configures Job Runner registration.
+// Job Runners with registration enabled (JobRunner.Registration) register with
+// the Request Manager on startup and send heartbeats. New and resumed job chains
+// are sent to the live Job Runner running the fewest job chains, preferring those
+// below capacity. If no Job Runners are registered, job chains are sent to
+// jr_client.url, which is how Job Runners without registration are used.
+type Registry struct {
+	// Timeout is how long after its last heartbeat a Job Runner is presumed dead.
+	// Its running requests are suspended and resumed on another Job Runner from
+	// the last completed jobs, so jobs that were running are run again. It must
+	// be several times the Job Runner registration.interval.
+	//
+	// The default is DEFAULT_REGISTRY_TIMEOUT.
+	Timeout string `yaml:"timeout"`
+}
+
+// The registration section of JobRunner configures registering with the Request
+// Manager. When enabled, the Job Runner registers on startup, sends a heartbeat
+// every interval, and deregisters on shutdown. Upgrade Request Managers before
+// enabling it because older Request Managers do not have the registry API.
+type Registration struct {
+	// Enabled enables registration.
+	//
+	// The default is disabled.
+	Enabled bool `yaml:"enabled"`
+
+	// Interval is how often to send a heartbeat.
+	//
+	// The default is DEFAULT_HEARTBEAT_INTERVAL.
+	Interval string `yaml:"interval"`
+
+	// Capacity is how many job chains the Job Runner should run. It is not
+	// a hard limit: the Request Manager prefers other Job Runners when this
+	// one is at capacity.
+	//
+	// The default is zero: no limit.
+	Capacity uint `yaml:"capacity"`
+
+	// Labels describe the Job Runner, like its zone. They are reported by
+	// GET /api/v1/job-runners.
+	Labels map[string]string `yaml:"labels"`
+
+	// Capabilities are what the Job Runner can run, like docker or gpu. Job
+	// chains with jobs that need them (spec needs:) are sent only to Job Runners
+	// that have them all, and other Job Runners reject them. Capabilities are
+	// checked even if registration is not enabled.
+	Capabilities []string `yaml:"capabilities"`
+}
+
+// The progress section of JobRunner configures how the Job Runner reports request
+// progress (finished jobs counts) to the Request Manager. Every interval, it sends
+// only the counts that changed since last sent. By default, it sends one call per
+// request; with batching, it sends all changed counts in a few calls. Upgrade
+// Request Managers before enabling batching because older Request Managers do not
+// have the batch progress API.
+type Progress struct {
+	// Interval is how often to send changed finished jobs counts.
+	//
+	// The default is DEFAULT_PROGRESS_INTERVAL.
+	Interval string `yaml:"interval"`
+
+	// Batch enables sending changed counts in batches.
+	//
+	// The default is disabled: one call per request.
+	Batch bool `yaml:"batch"`
+
+	// BatchSize is the maximum number of counts in one batch. More changed
+	// counts are sent in several batches.
+	//
+	// The default is DEFAULT_PROGRESS_BATCH_SIZE.
+	BatchSize uint `yaml:"batch_size"`
+}
+
+// The sandboxes section of JobRunner configures sandboxes for untrusted job types.
+// Jobs run in the Job Runner process, so a sandbox cannot restrict a job itself;
+// it restricts the processes that the job runs. A job of a sandboxed type must
+// implement job.Sandboxed and run its processes with job.Sandbox.Command, which
+// runs them as the sandbox user, in a private work directory, with the sandbox
+// limits. Else, the job fails without running.
+type Sandbox struct {
+	// Types are the job types to run in the sandbox. A job type can be in only
+	// one sandbox.
+	Types []string `yaml:"types"`
+
+	// User is the OS user to run job processes as. To run them as another user,
+	// the Job Runner must run as root.
+	//
+	// The default is the Job Runner user.
+	User string `yaml:"user"`
+
+	// WorkDir is the directory in which a private work directory is made for
+	// every try of a job. It's owned by User and removed when the try is done.
+	//
+	// The default is the OS temp directory.
+	WorkDir string `yaml:"work_dir"`
+
+	// Limits are resource limits (ulimits) for job processes, like nofile: 1024.
+	// The names and units are those of prlimit(1): as, core, cpu, data, fsize,
+	// locks, memlock, nofile, nproc, rss, stack. Limits require Linux and the
+	// prlimit command.
+	//
+	// The default is no limits.
+	Limits map[string]uint64 `yaml:"limits"`
+}
+
+// The workspaces section of JobRunner configures job workspaces: a scratch
+// directory for every job run, given to jobs that implement job.UsesWorkspace.
+// A workspace is kept between tries of a job, and removed when the job is done
+// running or, at the latest, when its job chain is done.
+type Workspaces struct {
+	// Dir is the directory in which workspaces are made, one per request and
+	// job: <dir>/<request ID>/<job ID>.
+	//
+	// The default is spincycle-workspaces in the OS temp directory.
+	Dir string `yaml:"dir"`
+
+	// QuotaMB is the max size of a workspace, in megabytes. A job whose workspace
+	// is larger fails: it's stopped if it's running.
+	//
+	// The default is no quota.
+	QuotaMB uint64 `yaml:"quota_mb"`
+
+	// ArtifactsDir enables promoting files from workspaces into artifacts, which
+	// are kept after the workspace is removed: <artifacts_dir>/<request ID>/<job ID>/<file>.
+	// Artifacts are never removed by the Job Runner.
+	//
+	// The default is disabled (no directory).
+	ArtifactsDir string `yaml:"artifacts_dir"`
+}
+
+// The job_log section of JobRunner limits the size of job log entries (JLEs) that
+// the Job Runner sends to the Request Manager. A field larger than its limit is
+// truncated: its head and tail are kept, and a line between them says how many
+// bytes were omitted. A limit of zero is no limit.
+type JobLogLimits struct {
+	// MaxStdoutKB and MaxStderrKB are the max sizes of job stdout and stderr,
+	// in kilobytes.
+	//
+	// The defaults are DEFAULT_JOB_LOG_MAX_OUTPUT_KB.
+	MaxStdoutKB uint `yaml:"max_stdout_kb"`
+	MaxStderrKB uint `yaml:"max_stderr_kb"`
+
+	// MaxErrorKB is the max size of the job error, in kilobytes.
+	//
+	// The default is DEFAULT_JOB_LOG_MAX_ERROR_KB.
+	MaxErrorKB uint `yaml:"max_error_kb"`
+
+	// Spill saves the full value of truncated fields as artifacts, and the
+	// truncated field says where: <artifacts_dir>/<request ID>/<job ID>/job-log/try-<N>.<field>.
+	// It requires Workspaces.ArtifactsDir.
+	//
+	// The default is disabled.
+	Spill bool `yaml:"spill"`
+}
+
+// The slow_jobs section of JobRunner configures slow job watchdogs. A watchdog
+// warns once per job run (all tries) when a job of one of its types runs longer
+// than Factor times Expected.
+type SlowJob struct {
+	// Types are the job types to watch. A job type can be in only one watchdog.
+	Types []string `yaml:"types"`
+
+	// Expected is how long jobs of these types are expected to run, as a
+	// time.Duration string. It is required.
+	Expected string `yaml:"expected"`
+
+	// Factor is the multiple of Expected after which a job is slow. It must be
+	// at least 1.
+	//
+	// The default is DEFAULT_SLOW_JOB_FACTOR.
+	Factor float64 `yaml:"factor"`
+
+	// Webhook is a URL to which a proto.SlowJobEvent is POSTed as JSON when a
+	// job is slow.
+	//
+	// The default is no webhook.
+	Webhook string `yaml:"webhook"`
+}
+
+// The concurrency section of JobRunner limits how many jobs of one request (job
+// chain) run at once. Runnable jobs over the limit are held (proto.HOLD_CONCURRENCY)
+// until a running job finishes. Finally jobs run after a request is stopped are
+// not limited.
+type Concurrency struct {
+	// Max is the max number of jobs of one request running at once.
+	//
+	// The default is zero: no limit.
+	Max uint `yaml:"max"`
+
+	// Adaptive enables an AIMD controller that adjusts the limit of each request
+	// between Min and Max based on its jobs: the limit is halved when a job
+	// fails, needs retries, or is slow (runs longer than SlowFactor times the
+	// average duration of its job type in the request), and increased by one
+	// after every limit jobs finish healthy. The limit starts at Max. It lets
+	// requests back off when a downstream system browns out, which a static
+	// Max does not. Adaptive requires Max.
+	//
+	// The default is disabled: the limit is always Max.
+	Adaptive bool `yaml:"adaptive"`
+
+	// Min is the lowest limit the adaptive controller backs off to. It must be
+	// between 1 and Max.
+	//
+	// The default is DEFAULT_CONCURRENCY_MIN.
+	Min uint `yaml:"min"`
+
+	// SlowFactor is the multiple of the average duration of a job type after
+	// which the adaptive controller counts a job as slow. It must be at least 1.
+	//
+	// The default is DEFAULT_SLOW_JOB_FACTOR.
+	SlowFactor float64 `yaml:"slow_factor"`
 }
 
 // The specs section of RequestManager configures the request specs.
@@ -216,6 +832,15 @@ type Server struct {
 	//
 	// The default is not using TLS.
 	TLS TLS `yaml:"tls"`
+
+	// ShutdownSignals are the OS signals that gracefully shut down the server.
+	// Signal names are platform-specific, like "TERM" or "INT" on all platforms,
+	// "HUP" on Unix, or "CTRL_SHUTDOWN" on Windows. See package shutdown. Set
+	// this when the container or service manager stops the server with a
+	// different signal, or to stop shutting down on INT (Ctrl-C), for example.
+	//
+	// The default is shutdown.DefaultSignals: INT and TERM.
+	ShutdownSignals []string `yaml:"shutdown_signals"`
 }
 
 // HTTPClient represents sections jr_client (RequestManager.JRClient) and rm_client
@@ -237,6 +862,31 @@ type HTTPClient struct {
 	//
 	// The default is not using TLS.
 	TLS `yaml:"tls"`
+
+	// Compression is the name of the codec used to compress request payloads,
+	// like "gzip". Only payloads larger than compress.MinSize are compressed.
+	// The destination API decompresses payloads with any registered codec,
+	// and responses are always decompressed. See package compress.
+	//
+	// The default is no compression.
+	Compression string `yaml:"compression"`
+
+	// StreamJobs is the number of jobs at which job chains are streamed as
+	// NDJSON instead of sent as one JSON document, so very large job chains
+	// are not buffered in memory. Streamed job chains are not compressed.
+	// Only the Request Manager jr_client uses this.
+	//
+	// The default is zero: job chains are never streamed.
+	StreamJobs uint `yaml:"stream_jobs"`
+
+	// Retry is how many times a call to the destination API is retried when it
+	// cannot be reached or responds HTTP 502 or 504. RetryWait is the wait before
+	// the first retry, like "500ms", doubled on each later retry. Only the
+	// Request Manager jr_client uses these.
+	//
+	// The defaults are DEFAULT_JR_CLIENT_RETRY and DEFAULT_JR_CLIENT_RETRY_WAIT.
+	Retry     uint   `yaml:"retry"`
+	RetryWait string `yaml:"retry_wait"`
 }
 
 // Configuration for a SQL database.
@@ -261,6 +911,14 @@ type MySQL struct {
 	// The default is no TLS.
 	TLS `yaml:"tls"`
 
+	// Compression is the name of the codec used to compress job chains and
+	// suspended job chains stored in MySQL, like "gzip". Data stored without
+	// compression, or with another registered codec, is still readable, so
+	// this can be changed at any time. See package compress.
+	//
+	// The default is no compression.
+	Compression string `yaml:"compression"`
+
 	// Path to mysql CLI. This is only used for testing.
 	CLIPath string `yaml:"cli_path"`
 }
@@ -268,7 +926,8 @@ type MySQL struct {
 // TLS represents the tls sections for Server, HTTPClient, and MySQL. Each tls
 // section is unique, allowing different TLS files for each section.
 //
-// There are no defaults. Specify all files, or none.
+// There are no defaults for the files. Specify all files, or none, except
+// that a server does not need a CA file unless VerifyClient is true.
 type TLS struct {
 	// The certificate file to use.
 	CertFile string `yaml:"cert_file"`
@@ -278,4 +937,26 @@ type TLS struct {
 
 	// The CA file to use.
 	CAFile string `yaml:"ca_file"`
+
+	// ServerName is the SAN that the destination API certificate must have.
+	// Only used by the jr_client and rm_client sections. The default is the
+	// host in the client url.
+	ServerName string `yaml:"server_name"`
+
+	// VerifyClient requires clients to present a certificate signed by CAFile,
+	// i.e. mutual TLS. Only used by server sections. The default is false.
+	VerifyClient bool `yaml:"verify_client"`
+
+	// AllowedSANs restricts clients to those with a certificate that has one of
+	// these DNS or URI SANs. Only used by server sections when VerifyClient is
+	// true. The default is any client certificate signed by CAFile.
+	AllowedSANs []string `yaml:"allowed_sans"`
+
+	// ReloadInterval is how often to check the files for changes. Changed files
+	// are reloaded without restarting, so certificates can be rotated in place.
+	// It is a time.Duration string; "0s" disables reloading. Not used by the
+	// mysql section.
+	//
+	// The default is DEFAULT_TLS_RELOAD_INTERVAL.
+	ReloadInterval string `yaml:"reload_interval"`
 }
@@ -5,6 +5,7 @@ package config_test
 import (
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/go-test/deep"
@@ -116,3 +117,173 @@ rm_client:
 		t.Error(diff)
 	}
 }
+
+func TestLoadConfigUnknownOption(t *testing.T) {
+	// Misspelled option (regsitration) is an error, not silently ignored
+	content := []byte(`
+---
+regsitration:
+  enabled: true
+`)
+	fileName := createTempFile(t, content)
+	defer os.Remove(fileName)
+
+	var actualConfig config.JobRunner
+	err := config.Load(fileName, &actualConfig)
+	if err == nil {
+		t.Fatal("no error, expected one for unknown option")
+	}
+	if !strings.Contains(err.Error(), "regsitration") {
+		t.Errorf("error does not contain unknown option: %s", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	specsDir, err := ioutil.TempDir("", "spincycle-specs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(specsDir)
+
+	// Defaults are valid
+	rmCfg, jrCfg := config.Defaults()
+	rmCfg.Specs.Dir = specsDir
+	if err := rmCfg.Validate(); err != nil {
+		t.Errorf("default RM config not valid: %s", err)
+	}
+	if err := jrCfg.Validate(); err != nil {
+		t.Errorf("default JR config not valid: %s", err)
+	}
+
+	// All errors are reported, one per line, prefixed by the option
+	rmCfg.Server.Addr = "localhost"
+	rmCfg.Registry.Timeout = "0s"
+	rmCfg.JRClient.RetryWait = "1x"
+	rmCfg.JRClient.ServerURL = "https://jr.local:32307"
+	rmCfg.Shadow.Requests = []string{"deploy"}
+	rmCfg.MySQL.TLS.CAFile = "/does/not/exist.ca"
+	err = rmCfg.Validate()
+	if err == nil {
+		t.Fatal("no error, expected one")
+	}
+	expect := []string{
+		`server.addr: invalid address "localhost": address localhost: missing port in address`,
+		`mysql.tls: cert_file, key_file, and ca_file must all be set`,
+		`mysql.tls.ca_file: stat /does/not/exist.ca: no such file or directory`,
+		`jr_client.tls.ca_file: required for https url`,
+		`jr_client.retry_wait: invalid duration "1x"`,
+		`shadow.jr_client.url: required when shadow.requests set`,
+		`registry.timeout: invalid duration "0s": must be greater than zero`,
+	}
+	if diff := deep.Equal(strings.Split(err.Error(), "\n"), expect); diff != nil {
+		t.Error(diff)
+	}
+
+	jrCfg.RMClient.ServerURL = "127.0.0.1:32308" // no scheme
+	jrCfg.RMClient.Compression = "zip"
+	jrCfg.Server.TLS.VerifyClient = true
+	err = jrCfg.Validate()
+	if err == nil {
+		t.Fatal("no error, expected one")
+	}
+	for _, s := range []string{"server.tls.ca_file", "rm_client.url", "rm_client.compression"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error does not contain %s: %s", s, err)
+		}
+	}
+}
+
+func TestValidateTriggers(t *testing.T) {
+	specsDir, err := ioutil.TempDir("", "spincycle-specs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(specsDir)
+
+	rmCfg, _ := config.Defaults()
+	rmCfg.Specs.Dir = specsDir
+	rmCfg.Triggers = []config.Trigger{
+		{Name: "deploy", Source: config.TRIGGER_SOURCE_WEBHOOK, Request: "warm-cache", Args: map[string]string{"app": "{{.app}}"}, DedupKey: "{{.id}}"},
+		{Name: "sqs", Source: "sqs", Request: "warm-cache"},
+	}
+	if err := rmCfg.Validate(); err != nil {
+		t.Errorf("triggers not valid: %s", err)
+	}
+
+	rmCfg.Triggers = []config.Trigger{
+		{Name: "deploy", Source: config.TRIGGER_SOURCE_WEBHOOK, Request: "warm-cache"},
+		{Name: "deploy", Source: "sqs", DedupKey: "{{.id"},
+		{Name: "a b", Source: "sqs", Request: "warm-cache"},
+	}
+	err = rmCfg.Validate()
+	if err == nil {
+		t.Fatal("no error, expected one")
+	}
+	expect := []string{
+		`triggers[1].name: duplicate value "deploy"`,
+		`triggers[1].request: required`,
+		`triggers[1].dedup_key: invalid template: template: triggers[1].dedup_key:1: unclosed action`,
+		`triggers[2].name: invalid name "a b": must be usable in a URL path`,
+		`triggers[2].source: source "sqs" used by another trigger`,
+	}
+	if diff := deep.Equal(strings.Split(err.Error(), "\n"), expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestValidateSlowJobs(t *testing.T) {
+	_, jrCfg := config.Defaults()
+	jrCfg.SlowJobs = []config.SlowJob{
+		{Types: []string{"deploy", "restart"}, Expected: "5m", Factor: 3, Webhook: "https://alerts.local/slow"},
+		{Types: []string{"backup"}, Expected: "1h"},
+	}
+	if err := jrCfg.Validate(); err != nil {
+		t.Errorf("slow_jobs not valid: %s", err)
+	}
+
+	jrCfg.SlowJobs = []config.SlowJob{
+		{Types: []string{"deploy"}, Expected: "5m", Factor: 0.5},
+		{Types: []string{"deploy"}, Webhook: "alerts.local"},
+		{Expected: "0s"},
+	}
+	err := jrCfg.Validate()
+	if err == nil {
+		t.Fatal("no error, expected one")
+	}
+	expect := []string{
+		`slow_jobs[0].factor: invalid factor 0.5: must be at least 1`,
+		`slow_jobs[1].types: job type "deploy" in another watchdog`,
+		`slow_jobs[1].expected: required`,
+		`slow_jobs[1].webhook: invalid URL "alerts.local": must be an http or https URL`,
+		`slow_jobs[2].types: required`,
+		`slow_jobs[2].expected: invalid duration "0s": must be greater than zero`,
+	}
+	if diff := deep.Equal(strings.Split(err.Error(), "\n"), expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestValidateConcurrency(t *testing.T) {
+	_, jrCfg := config.Defaults()
+	jrCfg.Concurrency = config.Concurrency{Max: 16, Adaptive: true, Min: 2, SlowFactor: 3}
+	if err := jrCfg.Validate(); err != nil {
+		t.Errorf("concurrency not valid: %s", err)
+	}
+
+	jrCfg.Concurrency = config.Concurrency{Max: 2, Min: 4, SlowFactor: 0.5}
+	err := jrCfg.Validate()
+	if err == nil {
+		t.Fatal("no error, expected one")
+	}
+	expect := "concurrency.min: invalid min 4: must be at most max (2)\n" +
+		"concurrency.slow_factor: invalid factor 0.5: must be at least 1"
+	if err.Error() != expect {
+		t.Errorf("got error:\n%s\nexpected:\n%s", err, expect)
+	}
+
+	jrCfg.Concurrency = config.Concurrency{Adaptive: true}
+	err = jrCfg.Validate()
+	if err == nil || err.Error() != "concurrency.adaptive: requires max" {
+		t.Errorf("got error %v, expected concurrency.adaptive: requires max", err)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2020, Square, Inc.
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DEFAULT_TLS_RELOAD_INTERVAL is how often TLS files are checked for changes
+// if TLS.ReloadInterval is not set.
+const DEFAULT_TLS_RELOAD_INTERVAL = "1m"
+
+// NewServerTLSConfig creates a tls.Config for a Server from the given server.tls
+// section. The cert and key files are required. The CA file is required only if
+// VerifyClient is true. The files are reloaded when they change on disk, so
+// certificates can be rotated without restarting the server.
+func NewServerTLSConfig(cfg TLS) (*tls.Config, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("cert_file and key_file required")
+	}
+	if cfg.VerifyClient && cfg.CAFile == "" {
+		return nil, fmt.Errorf("ca_file required when verify_client is true")
+	}
+	files, err := newTLSFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := files.get()
+			c := &tls.Config{
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+			}
+			if cfg.VerifyClient {
+				c.ClientAuth = tls.RequireAndVerifyClientCert
+				c.VerifyConnection = func(cs tls.ConnectionState) error {
+					return verifySAN(cs.PeerCertificates[0], cfg.AllowedSANs)
+				}
+			}
+			return c, nil
+		},
+	}
+
+	return tlsConfig, nil
+}
+
+// NewClientTLSConfig creates a tls.Config for an HTTPClient from the given
+// http client tls section. The CA file is required. The cert and key files are
+// optional; if given, the client presents them for mutual TLS. The server
+// certificate must be signed by the CA and have a SAN matching ServerName,
+// or the host of the server URL if ServerName is not set. Like NewServerTLSConfig,
+// the files are reloaded when they change on disk.
+func NewClientTLSConfig(cfg TLS) (*tls.Config, error) {
+	if cfg.CAFile == "" {
+		return nil, fmt.Errorf("ca_file required")
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, fmt.Errorf("cert_file and key_file must both be set or not set")
+	}
+	files, err := newTLSFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: cfg.ServerName,
+		// The server certificate is verified by VerifyConnection against the
+		// current (possibly reloaded) CA pool, which a static RootCAs cannot do.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("server did not present a certificate")
+			}
+			if cs.ServerName == "" {
+				return fmt.Errorf("server name not set, cannot verify server certificate")
+			}
+			_, pool := files.get()
+			opts := x509.VerifyOptions{
+				DNSName:       cs.ServerName,
+				Roots:         pool,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, c := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(c)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+	if cfg.CertFile != "" {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := files.get()
+			return cert, nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// verifySAN returns an error if allowed is not empty and cert does not have
+// any of the allowed DNS or URI SANs.
+func verifySAN(cert *x509.Certificate, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.URIs))
+	sans = append(sans, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, san := range sans {
+		for _, a := range allowed {
+			if san == a {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("client certificate SANs %v not in allowed SANs", sans)
+}
+
+// --------------------------------------------------------------------------
+
+// tlsFiles loads and holds a cert and CA pool from a tls section. On get, if
+// ReloadInterval has elapsed since the last check, the files are reloaded if
+// any of them changed.
+type tlsFiles struct {
+	cfg      TLS
+	interval time.Duration
+	*sync.Mutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTime   time.Time // latest mod time of all files when last loaded
+	lastCheck time.Time
+}
+
+func newTLSFiles(cfg TLS) (*tlsFiles, error) {
+	reload := cfg.ReloadInterval
+	if reload == "" {
+		reload = DEFAULT_TLS_RELOAD_INTERVAL
+	}
+	interval, err := time.ParseDuration(reload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reload_interval %s: %s", reload, err)
+	}
+	f := &tlsFiles{
+		cfg:      cfg,
+		interval: interval,
+		Mutex:    &sync.Mutex{},
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *tlsFiles) get() (*tls.Certificate, *x509.CertPool) {
+	f.Lock()
+	defer f.Unlock()
+	if f.interval > 0 && time.Since(f.lastCheck) >= f.interval {
+		if modTime, err := f.latestModTime(); err != nil {
+			log.Printf("Error checking TLS files, using current files: %s", err)
+		} else if modTime.After(f.modTime) {
+			if err := f.load(); err != nil {
+				log.Printf("Error reloading TLS files, using current files: %s", err)
+			} else {
+				log.Printf("Reloaded TLS files %s %s %s", f.cfg.CertFile, f.cfg.KeyFile, f.cfg.CAFile)
+			}
+		}
+		f.lastCheck = time.Now()
+	}
+	return f.cert, f.pool
+}
+
+// load loads the files. The caller must lock f, except in newTLSFiles.
+func (f *tlsFiles) load() error {
+	modTime, err := f.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert := &tls.Certificate{}
+	if f.cfg.CertFile != "" {
+		*cert, err = tls.LoadX509KeyPair(f.cfg.CertFile, f.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls.LoadX509KeyPair: %s", err)
+		}
+	}
+
+	var pool *x509.CertPool
+	if f.cfg.CAFile != "" {
+		caCert, err := ioutil.ReadFile(f.cfg.CAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return fmt.Errorf("no certificates in CA file %s", f.cfg.CAFile)
+		}
+	}
+
+	f.cert = cert
+	f.pool = pool
+	f.modTime = modTime
+	f.lastCheck = time.Now()
+	return nil
+}
+
+func (f *tlsFiles) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{f.cfg.CertFile, f.cfg.KeyFile, f.cfg.CAFile} {
+		if file == "" {
+			continue
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2020, Square, Inc.
+
+package config_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/square/spincycle/v2/config"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// writeCert writes a cert signed by ca with the given DNS SAN, returning the
+// cert and key file names.
+func (ca testCA) writeCert(t *testing.T, dir, name, san string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: san},
+		DNSNames:     []string{san},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func startTLSServer(t *testing.T, cfg config.TLS) *httptest.Server {
+	tlsConfig, err := config.NewServerTLSConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	return ts
+}
+
+func get(t *testing.T, cfg config.TLS, url string) error {
+	tlsConfig, err := config.NewClientTLSConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := c.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spincycle-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(caFile, ca.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	serverCert, serverKey := ca.writeCert(t, dir, "server", "spincycle-rm.local")
+	jrCert, jrKey := ca.writeCert(t, dir, "jr", "spincycle-jr.local")
+	otherCert, otherKey := ca.writeCert(t, dir, "other", "other.local")
+
+	ts := startTLSServer(t, config.TLS{
+		CertFile:     serverCert,
+		KeyFile:      serverKey,
+		CAFile:       caFile,
+		VerifyClient: true,
+		AllowedSANs:  []string{"spincycle-jr.local"},
+	})
+	defer ts.Close()
+
+	// Client with allowed SAN and correct server name
+	clientCfg := config.TLS{
+		CertFile:   jrCert,
+		KeyFile:    jrKey,
+		CAFile:     caFile,
+		ServerName: "spincycle-rm.local",
+	}
+	if err := get(t, clientCfg, ts.URL); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	// Client cert SAN not allowed
+	clientCfg.CertFile = otherCert
+	clientCfg.KeyFile = otherKey
+	if err := get(t, clientCfg, ts.URL); err == nil {
+		t.Errorf("no error with client SAN not allowed")
+	}
+
+	// No client cert
+	if err := get(t, config.TLS{CAFile: caFile, ServerName: "spincycle-rm.local"}, ts.URL); err == nil {
+		t.Errorf("no error without client cert")
+	}
+
+	// Server cert doesn't have expected SAN
+	clientCfg.CertFile = jrCert
+	clientCfg.KeyFile = jrKey
+	clientCfg.ServerName = "spincycle-jr.local"
+	if err := get(t, clientCfg, ts.URL); err == nil {
+		t.Errorf("no error with wrong server name")
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spincycle-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldCA := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(caFile, oldCA.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	serverCert, serverKey := oldCA.writeCert(t, dir, "server", "spincycle-rm.local")
+
+	ts := startTLSServer(t, config.TLS{
+		CertFile:       serverCert,
+		KeyFile:        serverKey,
+		ReloadInterval: "1ns",
+	})
+	defer ts.Close()
+
+	// Client trusts a new CA, so it can only connect after the server cert
+	// is rotated to one signed by the new CA
+	newCA := newTestCA(t)
+	newCAFile := filepath.Join(dir, "new-ca.crt")
+	if err := ioutil.WriteFile(newCAFile, newCA.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	clientCfg := config.TLS{CAFile: newCAFile, ServerName: "spincycle-rm.local"}
+	if err := get(t, clientCfg, ts.URL); err == nil {
+		t.Fatal("no error before server cert rotated")
+	}
+
+	// Rotate server cert in place. Bump mod time in case the file system time
+	// resolution is too coarse to see the change.
+	newCA.writeCert(t, dir, "server", "spincycle-rm.local")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(serverCert, future, future)
+	os.Chtimes(serverKey, future, future)
+
+	if err := get(t, clientCfg, ts.URL); err != nil {
+		t.Errorf("got error %s after server cert rotated, expected nil", err)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2020, Square, Inc.
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/square/spincycle/v2/compress"
+)
+
+// Validate checks the Request Manager config for invalid values, missing files,
+// and conflicting options, so misconfiguration is reported at startup rather
+// than when the value is first used. Empty values are valid where defaults are
+// used. It does not connect to anything; see CheckAddr.
+//
+// All errors are returned in one error, one per line, prefixed by the config
+// option, like "registry.timeout: invalid duration "1x"".
+func (c RequestManager) Validate() error {
+	v := &validator{}
+	v.server("server", c.Server)
+	if c.MySQL.DSN == "" {
+		v.errorf("mysql.dsn", "required")
+	}
+	// MySQL TLS is used only if all files are set, so a partial tls section
+	// would silently connect without TLS
+	t := c.MySQL.TLS
+	if (t.CertFile != "" || t.KeyFile != "" || t.CAFile != "") && (t.CertFile == "" || t.KeyFile == "" || t.CAFile == "") {
+		v.errorf("mysql.tls", "cert_file, key_file, and ca_file must all be set")
+	}
+	v.tlsFiles("mysql.tls", t)
+	v.compression("mysql.compression", c.MySQL.Compression)
+	v.dir("specs.dir", c.Specs.Dir)
+	v.positiveDuration("auth.token_max_ttl", c.Auth.TokenMaxTTL)
+	v.httpClient("jr_client", c.JRClient)
+	if len(c.Shadow.Requests) > 0 {
+		if c.Shadow.JRClient.ServerURL == "" {
+			v.errorf("shadow.jr_client.url", "required when shadow.requests set")
+		} else if c.Shadow.JRClient.ServerURL == c.JRClient.ServerURL {
+			v.errorf("shadow.jr_client.url", "same as jr_client.url: shadow runs must run on another Job Runner pool")
+		}
+	}
+	if c.Shadow.JRClient.ServerURL != "" {
+		v.httpClient("shadow.jr_client", c.Shadow.JRClient)
+	}
+	v.positiveDuration("registry.timeout", c.Registry.Timeout)
+	v.unique("add_job.types", c.AddJob.Types)
+	v.positiveDuration("job_log.retention", c.JobLog.Retention)
+	if c.JobLog.WAL.Dir != "" {
+		v.dir("job_log.wal.dir", c.JobLog.WAL.Dir)
+		v.positiveDuration("job_log.wal.flush_interval", c.JobLog.WAL.FlushInterval)
+	}
+	v.triggers("triggers", c.Triggers)
+	v.positiveDuration("cache.ttl", c.Cache.TTL)
+	return v.err()
+}
+
+// Validate checks the Job Runner config like RequestManager.Validate. Sandboxes
+// are validated by the Job Runner because that requires looking up OS users.
+func (c JobRunner) Validate() error {
+	v := &validator{}
+	v.server("server", c.Server)
+	v.httpClient("rm_client", c.RMClient)
+	v.positiveDuration("registration.interval", c.Registration.Interval)
+	v.positiveDuration("progress.interval", c.Progress.Interval)
+	v.positiveDuration("chain_retention", c.ChainRetention)
+	v.slowJobs("slow_jobs", c.SlowJobs)
+	v.concurrency("concurrency", c.Concurrency)
+	if c.JobLog.Spill && c.Workspaces.ArtifactsDir == "" {
+		v.errorf("job_log.spill", "requires workspaces.artifacts_dir")
+	}
+	return v.err()
+}
+
+// CheckAddr returns an error if it cannot connect to the host and port of the
+// URL within the timeout. The port defaults to 80 for http and 443 for https.
+// It's used to check that a config is usable (--validate-only), not at startup,
+// because the other API might start later.
+func CheckAddr(rawURL string, timeout time.Duration) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+// validator collects config errors.
+type validator struct {
+	errs []string
+}
+
+func (v *validator) errorf(option, format string, a ...interface{}) {
+	v.errs = append(v.errs, option+": "+fmt.Sprintf(format, a...))
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(v.errs, "\n"))
+}
+
+func (v *validator) server(option string, s Server) {
+	if s.Addr == "" {
+		v.errorf(option+".addr", "required")
+	} else if _, _, err := net.SplitHostPort(s.Addr); err != nil {
+		v.errorf(option+".addr", "invalid address %q: %s", s.Addr, err)
+	}
+	t := s.TLS
+	if t.CertFile != "" || t.KeyFile != "" || t.CAFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			v.errorf(option+".tls", "cert_file and key_file required")
+		}
+	}
+	if t.VerifyClient && t.CAFile == "" {
+		v.errorf(option+".tls.ca_file", "required when verify_client is true")
+	}
+	if len(t.AllowedSANs) > 0 && !t.VerifyClient {
+		v.errorf(option+".tls.allowed_sans", "requires verify_client: true")
+	}
+	v.tlsFiles(option+".tls", t)
+	v.duration(option+".tls.reload_interval", t.ReloadInterval)
+}
+
+func (v *validator) httpClient(option string, c HTTPClient) {
+	if c.ServerURL == "" {
+		v.errorf(option+".url", "required")
+	} else if u, err := url.Parse(c.ServerURL); err != nil {
+		v.errorf(option+".url", "invalid URL %q: %s", c.ServerURL, err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		v.errorf(option+".url", "invalid URL %q: scheme must be http or https", c.ServerURL)
+	} else if u.Host == "" {
+		v.errorf(option+".url", "invalid URL %q: no host", c.ServerURL)
+	} else if u.Scheme == "https" && c.TLS.CAFile == "" {
+		v.errorf(option+".tls.ca_file", "required for https url")
+	} else if u.Scheme == "http" && c.TLS.CAFile != "" {
+		v.errorf(option+".url", "must be https when tls is set")
+	}
+	v.clientTLS(option+".tls", c.TLS)
+	v.duration(option+".tls.reload_interval", c.TLS.ReloadInterval)
+	v.compression(option+".compression", c.Compression)
+	v.duration(option+".retry_wait", c.RetryWait)
+}
+
+func (v *validator) clientTLS(option string, t TLS) {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		v.errorf(option, "cert_file and key_file must be set together")
+	}
+	if t.CertFile != "" && t.CAFile == "" {
+		v.errorf(option+".ca_file", "required when cert_file is set")
+	}
+	v.tlsFiles(option, t)
+}
+
+func (v *validator) tlsFiles(option string, t TLS) {
+	v.file(option+".cert_file", t.CertFile)
+	v.file(option+".key_file", t.KeyFile)
+	v.file(option+".ca_file", t.CAFile)
+}
+
+func (v *validator) file(option, file string) {
+	if file == "" {
+		return
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		v.errorf(option, "%s", err)
+	} else if fi.IsDir() {
+		v.errorf(option, "%s is a directory, expected a file", file)
+	}
+}
+
+func (v *validator) dir(option, dir string) {
+	if dir == "" {
+		return
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		v.errorf(option, "%s", err)
+	} else if !fi.IsDir() {
+		v.errorf(option, "%s is not a directory", dir)
+	}
+}
+
+// duration checks a time.Duration string. Zero is valid because some options
+// use it to disable something, like tls.reload_interval.
+func (v *validator) duration(option, d string) {
+	if d == "" {
+		return
+	}
+	if n, err := time.ParseDuration(d); err != nil || n < 0 {
+		v.errorf(option, "invalid duration %q", d)
+	}
+}
+
+func (v *validator) positiveDuration(option, d string) {
+	if d == "" {
+		return
+	}
+	if n, err := time.ParseDuration(d); err != nil || n <= 0 {
+		v.errorf(option, "invalid duration %q: must be greater than zero", d)
+	}
+}
+
+func (v *validator) compression(option, name string) {
+	if name == "" {
+		return
+	}
+	if _, err := compress.Get(name); err != nil {
+		v.errorf(option, "%s", err)
+	}
+}
+
+func (v *validator) slowJobs(option string, slowJobs []SlowJob) {
+	types := map[string]bool{}
+	for i, sj := range slowJobs {
+		opt := fmt.Sprintf("%s[%d]", option, i)
+		if len(sj.Types) == 0 {
+			v.errorf(opt+".types", "required")
+		}
+		for _, t := range sj.Types {
+			if types[t] {
+				v.errorf(opt+".types", "job type %q in another watchdog", t)
+			}
+			types[t] = true
+		}
+		if sj.Expected == "" {
+			v.errorf(opt+".expected", "required")
+		} else {
+			v.positiveDuration(opt+".expected", sj.Expected)
+		}
+		if sj.Factor != 0 && sj.Factor < 1 {
+			v.errorf(opt+".factor", "invalid factor %g: must be at least 1", sj.Factor)
+		}
+		if sj.Webhook != "" {
+			if u, err := url.Parse(sj.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				v.errorf(opt+".webhook", "invalid URL %q: must be an http or https URL", sj.Webhook)
+			}
+		}
+	}
+}
+
+func (v *validator) concurrency(option string, c Concurrency) {
+	if c.Adaptive && c.Max == 0 {
+		v.errorf(option+".adaptive", "requires max")
+	}
+	if c.Min != 0 && c.Max != 0 && c.Min > c.Max {
+		v.errorf(option+".min", "invalid min %d: must be at most max (%d)", c.Min, c.Max)
+	}
+	if c.SlowFactor != 0 && c.SlowFactor < 1 {
+		v.errorf(option+".slow_factor", "invalid factor %g: must be at least 1", c.SlowFactor)
+	}
+}
+
+func (v *validator) triggers(option string, triggers []Trigger) {
+	names := map[string]bool{}
+	sources := map[string]bool{}
+	for i, t := range triggers {
+		opt := fmt.Sprintf("%s[%d]", option, i)
+		if t.Name == "" {
+			v.errorf(opt+".name", "required")
+		} else if names[t.Name] {
+			v.errorf(opt+".name", "duplicate value %q", t.Name)
+		} else if strings.ContainsAny(t.Name, "/?#% ") {
+			v.errorf(opt+".name", "invalid name %q: must be usable in a URL path", t.Name)
+		}
+		names[t.Name] = true
+		if t.Source == "" {
+			v.errorf(opt+".source", "required")
+		} else if t.Source != TRIGGER_SOURCE_WEBHOOK {
+			if sources[t.Source] {
+				v.errorf(opt+".source", "source %q used by another trigger", t.Source)
+			}
+			sources[t.Source] = true
+		}
+		if t.Request == "" {
+			v.errorf(opt+".request", "required")
+		}
+		for arg, text := range t.Args {
+			v.template(opt+".args."+arg, text)
+		}
+		v.template(opt+".dedup_key", t.DedupKey)
+	}
+}
+
+func (v *validator) template(option, text string) {
+	if text == "" {
+		return
+	}
+	if _, err := template.New(option).Parse(text); err != nil {
+		v.errorf(option, "invalid template: %s", err)
+	}
+}
+
+func (v *validator) unique(option string, vals []string) {
+	seen := map[string]bool{}
+	for _, val := range vals {
+		if seen[val] {
+			v.errorf(option, "duplicate value %q", val)
+		}
+		seen[val] = true
+	}
+}
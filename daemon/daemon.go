@@ -0,0 +1,147 @@
+// Copyright 2020, Square, Inc.
+
+// Package daemon provides the command line of the server daemons, request-manager
+// and job-runner: subcommands like run, validate-config, and version. The first
+// arg is the command; the default is run, so "request-manager [config file]"
+// works as before commands. The remaining args are passed to the command, and
+// the config file is the first of them that is not a flag (see config.Load).
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/labstack/echo/v4"
+)
+
+// A Command is one subcommand of a daemon, like "run".
+type Command struct {
+	Name string // like "validate-config"
+	Args string // args for usage, like "[config file]"
+	Desc string // one line for usage
+
+	// Run runs the command with the args after the command name, and returns
+	// when the command is done. An error exits non-zero.
+	Run func(args []string) error
+}
+
+const (
+	DEFAULT_COMMAND = "run"
+
+	// VALIDATE_ONLY is the flag used to validate the config before commands
+	// (--validate-only). It's the same as command validate-config.
+	VALIDATE_ONLY   = "validate-only"
+	VALIDATE_CONFIG = "validate-config"
+)
+
+// ErrHelp is returned by Parse if help is requested.
+var ErrHelp = errors.New("help requested")
+
+// Parse returns the command named by the first arg, and the remaining args. If
+// the first arg is not a command, it's the default command (run), or command
+// validate-config if flag --validate-only is given, and the remaining args are
+// all args but --validate-only. Parse returns ErrHelp if the first arg is
+// "help" or a help flag, and an error if the first arg looks like a command (no
+// dot or path separator) but is not a command or a file.
+func Parse(args []string, cmds []Command) (Command, []string, error) {
+	byName := map[string]Command{}
+	for _, c := range cmds {
+		byName[c.Name] = c
+	}
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			return Command{}, nil, ErrHelp
+		}
+		if c, ok := byName[args[0]]; ok {
+			return c, args[1:], nil
+		}
+		// Not a command, so it's a config file, unless it looks like a command,
+		// like a command of the other daemon
+		if arg := args[0]; !strings.HasPrefix(arg, "-") && !strings.ContainsAny(arg, `./\`) {
+			if _, err := os.Stat(arg); err != nil {
+				return Command{}, nil, fmt.Errorf("unknown command: %s", arg)
+			}
+		}
+	}
+
+	name := DEFAULT_COMMAND
+	rest := []string{}
+	for _, arg := range args {
+		if arg == "-"+VALIDATE_ONLY || arg == "--"+VALIDATE_ONLY {
+			name = VALIDATE_CONFIG
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	c, ok := byName[name]
+	if !ok {
+		return Command{}, nil, fmt.Errorf("unknown command: %s", name)
+	}
+	return c, rest, nil
+}
+
+// Main runs the command given on the command line and exits: zero if it returns
+// nil, else non-zero after printing the error. The command line must be only the
+// args of the command, like the config file, so os.Args is set to the daemon
+// name and the command args before the command is run. It's called by main.
+func Main(name string, cmds []Command) {
+	c, args, err := Parse(os.Args[1:], cmds)
+	if err != nil {
+		if err != ErrHelp {
+			fmt.Fprintf(os.Stderr, "%s\n\n", err)
+		}
+		Usage(os.Stderr, name, cmds)
+		os.Exit(2)
+	}
+	os.Args = append([]string{os.Args[0]}, args...)
+	if err := c.Run(args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", name, c.Name, err)
+		os.Exit(1)
+	}
+}
+
+// Usage prints the usage of the daemon: its commands.
+func Usage(w io.Writer, name string, cmds []Command) {
+	fmt.Fprintf(w, "Usage: %s [command] [args] [config file]\n\nCommands:\n", name)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range cmds {
+		desc := c.Desc
+		if c.Name == DEFAULT_COMMAND {
+			desc += " (default)"
+		}
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.Name, c.Args, desc)
+	}
+	fmt.Fprintf(tw, "  help\tPrint this help\n")
+	tw.Flush()
+}
+
+// PrintRoutes prints the API routes, one per line: method, path, and handler,
+// sorted by path and method.
+func PrintRoutes(w io.Writer, routes []*echo.Route) {
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, r := range routes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Method, r.Path, handlerName(r.Name))
+	}
+	tw.Flush()
+}
+
+// handlerName returns the short name of a route handler: "createRequestHandler"
+// for "github.com/square/spincycle/v2/request-manager/api.(*API).createRequestHandler-fm".
+func handlerName(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
@@ -0,0 +1,93 @@
+// Copyright 2020, Square, Inc.
+
+package daemon_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/labstack/echo/v4"
+
+	"github.com/square/spincycle/v2/daemon"
+)
+
+var cmds = []daemon.Command{
+	{Name: "run", Args: "[config file]", Desc: "Run it"},
+	{Name: "validate-config", Args: "[config file]", Desc: "Validate config"},
+	{Name: "version", Desc: "Print version"},
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		args []string
+		cmd  string
+		rest []string
+		err  string
+	}{
+		{args: []string{}, cmd: "run", rest: []string{}},
+		{args: []string{"run"}, cmd: "run", rest: []string{}},
+		{args: []string{"run", "config.yaml"}, cmd: "run", rest: []string{"config.yaml"}},
+		{args: []string{"config.yaml"}, cmd: "run", rest: []string{"config.yaml"}},
+		{args: []string{"/etc/spincycle/rm.yaml"}, cmd: "run", rest: []string{"/etc/spincycle/rm.yaml"}},
+		{args: []string{"validate-config", "config.yaml"}, cmd: "validate-config", rest: []string{"config.yaml"}},
+		{args: []string{"--validate-only", "config.yaml"}, cmd: "validate-config", rest: []string{"config.yaml"}},
+		{args: []string{"config.yaml", "-validate-only"}, cmd: "validate-config", rest: []string{"config.yaml"}},
+		{args: []string{"version"}, cmd: "version", rest: []string{}},
+		{args: []string{"migrate"}, err: "unknown command: migrate"},
+		{args: []string{"help"}, err: daemon.ErrHelp.Error()},
+		{args: []string{"--help"}, err: daemon.ErrHelp.Error()},
+	}
+	for _, tt := range tests {
+		c, rest, err := daemon.Parse(tt.args, cmds)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%v: got error %v, expected %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %s", tt.args, err)
+			continue
+		}
+		if c.Name != tt.cmd {
+			t.Errorf("%v: got command %s, expected %s", tt.args, c.Name, tt.cmd)
+		}
+		if diff := deep.Equal(rest, tt.rest); diff != nil {
+			t.Errorf("%v: %v", tt.args, diff)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var out bytes.Buffer
+	daemon.Usage(&out, "request-manager", cmds)
+	expect := `Usage: request-manager [command] [args] [config file]
+
+Commands:
+  run [config file]              Run it (default)
+  validate-config [config file]  Validate config
+  version                        Print version
+  help                           Print this help
+`
+	if out.String() != expect {
+		t.Errorf("got usage:\n%s\nexpected:\n%s", out.String(), expect)
+	}
+}
+
+func TestPrintRoutes(t *testing.T) {
+	routes := []*echo.Route{
+		{Method: "POST", Path: "/api/v1/requests", Name: "github.com/square/spincycle/v2/request-manager/api.(*API).createRequestHandler-fm"},
+		{Method: "GET", Path: "/version", Name: "github.com/square/spincycle/v2/request-manager/api.(*API).versionHandler-fm"},
+		{Method: "GET", Path: "/api/v1/requests", Name: "github.com/square/spincycle/v2/request-manager/api.(*API).findRequestsHandler-fm"},
+	}
+	var out bytes.Buffer
+	daemon.PrintRoutes(&out, routes)
+	expect := `GET   /api/v1/requests  findRequestsHandler
+POST  /api/v1/requests  createRequestHandler
+GET   /version          versionHandler
+`
+	if out.String() != expect {
+		t.Errorf("got routes:\n%s\nexpected:\n%s", out.String(), expect)
+	}
+}
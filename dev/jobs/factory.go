@@ -6,7 +6,6 @@ package jobs
 import (
 	"bytes"
 	"encoding/json"
-	"os/exec"
 	"strings"
 	"sync"
 	"time"
@@ -42,7 +41,9 @@ type ShellCommand struct {
 	Args []string `json:"args,omitempty"` // args to cmd
 
 	// While running
-	status string
+	status  string
+	sandbox job.Sandbox
+	execs   job.Execs
 	*sync.RWMutex
 
 	// Meta
@@ -97,8 +98,8 @@ func (j *ShellCommand) Run(jobData map[string]interface{}) (job.Return, error) {
 	j.setStatus("runnning " + j.Cmd)
 	defer j.setStatus("done running " + j.Cmd)
 
-	// Create the cmd to run
-	cmd := exec.Command(j.Cmd, j.Args...)
+	// Create the cmd to run, in the sandbox if the job type is sandboxed
+	cmd := j.sandbox.Command(j.Cmd, j.Args...)
 
 	// Capture STDOUT and STDERR
 	var stdout bytes.Buffer
@@ -107,9 +108,14 @@ func (j *ShellCommand) Run(jobData map[string]interface{}) (job.Return, error) {
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	// Run the cmd and wait for it to return
+	// Run the cmd and wait for it to return, recording it in the exec audit
 	exit := int64(0)
-	err := cmd.Run()
+	var err error
+	if j.execs != nil {
+		err = j.execs.Run(cmd)
+	} else {
+		err = cmd.Run()
+	}
 	ret := job.Return{
 		Exit:   exit,
 		Error:  err,
@@ -126,6 +132,16 @@ func (j *ShellCommand) Run(jobData map[string]interface{}) (job.Return, error) {
 	return ret, nil
 }
 
+// SetSandbox is a job.Sandboxed interface method.
+func (j *ShellCommand) SetSandbox(sb job.Sandbox) {
+	j.sandbox = sb
+}
+
+// SetExecs is a job.AuditsExecs interface method.
+func (j *ShellCommand) SetExecs(e job.Execs) {
+	j.execs = e
+}
+
 // Stop is a job.Job interface method.
 func (j *ShellCommand) Stop() error {
 	return nil
@@ -24,6 +24,43 @@ func (e RequestNotFound) Error() string {
 
 // --------------------------------------------------------------------------
 
+var _ error = ShadowNotFound{}
+
+type ShadowNotFound struct {
+	RequestId string
+}
+
+func (e ShadowNotFound) Error() string {
+	return fmt.Sprintf("no shadow run for request %s", e.RequestId)
+}
+
+// --------------------------------------------------------------------------
+
+var _ error = TokenNotFound{}
+
+type TokenNotFound struct {
+	TokenId string
+}
+
+func (e TokenNotFound) Error() string {
+	return fmt.Sprintf("token %s not found", e.TokenId)
+}
+
+// --------------------------------------------------------------------------
+
+var _ error = ProfileNotFound{}
+
+type ProfileNotFound struct {
+	RequestId string
+	ProfileId string
+}
+
+func (e ProfileNotFound) Error() string {
+	return fmt.Sprintf("profile %s not found for request %s", e.ProfileId, e.RequestId)
+}
+
+// --------------------------------------------------------------------------
+
 var _ error = JobNotFound{}
 
 type JobNotFound struct {
@@ -37,6 +74,66 @@ func (e JobNotFound) Error() string {
 
 // --------------------------------------------------------------------------
 
+var _ error = GroupNotFound{}
+
+type GroupNotFound struct {
+	GroupId string
+}
+
+func (e GroupNotFound) Error() string {
+	return fmt.Sprintf("request group %s not found", e.GroupId)
+}
+
+// --------------------------------------------------------------------------
+
+var _ error = TriggerNotFound{}
+
+type TriggerNotFound struct {
+	Name string
+}
+
+func (e TriggerNotFound) Error() string {
+	return fmt.Sprintf("trigger %s not found", e.Name)
+}
+
+// --------------------------------------------------------------------------
+
+var _ error = BackfillNotFound{}
+
+type BackfillNotFound struct {
+	Name string
+}
+
+func (e BackfillNotFound) Error() string {
+	return fmt.Sprintf("backfill %s not found", e.Name)
+}
+
+// --------------------------------------------------------------------------
+
+var _ error = ExportNotFound{}
+
+type ExportNotFound struct {
+	RequestId string
+}
+
+func (e ExportNotFound) Error() string {
+	return fmt.Sprintf("export of request %s not found", e.RequestId)
+}
+
+// --------------------------------------------------------------------------
+
+var _ error = PresetNotFound{}
+
+type PresetNotFound struct {
+	Name string
+}
+
+func (e PresetNotFound) Error() string {
+	return fmt.Sprintf("preset %s not found", e.Name)
+}
+
+// --------------------------------------------------------------------------
+
 var _ error = DbError{}
 
 // Error represents a generic database error. This struct is not superfluous,
@@ -98,3 +195,35 @@ type ValidationError struct {
 func (e ValidationError) Error() string {
 	return e.Message
 }
+
+// --------------------------------------------------------------------------
+
+var _ error = RequestRejected{}
+
+// RequestRejected is returned when the PreCreateRequest hook rejects a new
+// request, like for a naming or quota policy. Reason is the hook error.
+type RequestRejected struct {
+	Type   string
+	Reason string
+}
+
+func (e RequestRejected) Error() string {
+	return fmt.Sprintf("%s request rejected: %s", e.Type, e.Reason)
+}
+
+// --------------------------------------------------------------------------
+
+var _ error = DuplicateRequest{}
+
+// DuplicateRequest is returned when creating a request with the same dedup key
+// as an unfinished (pending, running, or suspended) request. If Reject is false
+// (dedupPolicy: return), the caller gets the unfinished request instead.
+type DuplicateRequest struct {
+	RequestId string // unfinished request
+	DedupKey  string
+	Reject    bool // dedupPolicy: error
+}
+
+func (e DuplicateRequest) Error() string {
+	return fmt.Sprintf("request %s with the same dedup key (%s) is not finished", e.RequestId, e.DedupKey)
+}
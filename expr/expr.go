@@ -0,0 +1,548 @@
+// Copyright 2020, Square, Inc.
+
+// Package expr parses and evaluates the boolean expressions of sequence spec
+// assertions (assert:), like "len(failed_hosts) == 0", and the expressions of
+// derived request args (args: derived: expr:), like "len(hosts) / 4". The Request
+// Manager parses them to check specs and evaluates derived args when a request
+// is created, and the Job Runner evaluates assertions over jobArgs and job data
+// when a sequence completes.
+//
+// An expression compares values with ==, !=, <, <=, >, >= and combines them with
+// &&, ||, !, and parentheses. Values are jobArg names, numbers, strings in single
+// or double quotes, true, false, null, and len(value): the length of a string,
+// list, or map (0 for null). Numbers of any type compare as numbers, and strings
+// compare lexically. It's an error to use an unknown jobArg or to compare values
+// of different types with <, <=, >, >=.
+//
+// Arithmetic operators +, -, *, /, % (modulo), and unary - bind tighter than
+// comparisons, with the usual precedence. They operate on numbers, and + also
+// concatenates strings. Results are float64. It's an error to divide by zero.
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a parsed expression. It's safe for concurrent use.
+type Expr struct {
+	src  string
+	root node
+	vars []string
+}
+
+// Parse parses the expression.
+func Parse(s string) (*Expr, error) {
+	p := &parser{src: s, vars: map[string]bool{}}
+	if err := p.lex(); err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %s", s, err)
+	}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tEOF {
+		err = fmt.Errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %s", s, err)
+	}
+	vars := make([]string, 0, len(p.vars))
+	for v := range p.vars {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	return &Expr{src: s, root: root, vars: vars}, nil
+}
+
+// Eval evaluates the expression with the values of jobArgs. It returns an error
+// if the expression uses a jobArg not in args or does not evaluate to true or false.
+func (e *Expr) Eval(args map[string]interface{}) (bool, error) {
+	v, err := e.root.eval(args)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s is %v, not true or false", e.src, v)
+	}
+	return b, nil
+}
+
+// Value evaluates the expression with the values of jobArgs and returns its value,
+// which can be of any type. Numbers are float64. It returns an error if the
+// expression uses a jobArg not in args.
+func (e *Expr) Value(args map[string]interface{}) (interface{}, error) {
+	return e.root.eval(args)
+}
+
+// Vars returns the names of the jobArgs used by the expression, sorted.
+func (e *Expr) Vars() []string {
+	return e.vars
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// --------------------------------------------------------------------------
+
+type node interface {
+	eval(args map[string]interface{}) (interface{}, error)
+}
+
+type literal struct {
+	val interface{}
+}
+
+func (n literal) eval(map[string]interface{}) (interface{}, error) {
+	return n.val, nil
+}
+
+type variable struct {
+	name string
+}
+
+func (n variable) eval(args map[string]interface{}) (interface{}, error) {
+	v, ok := args[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown jobArg %s", n.name)
+	}
+	return v, nil
+}
+
+type lenCall struct {
+	arg node
+}
+
+func (n lenCall) eval(args map[string]interface{}) (interface{}, error) {
+	v, err := n.arg.eval(args)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return float64(0), nil
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return float64(rv.Len()), nil
+	}
+	return nil, fmt.Errorf("len of %v (%T): not a string, list, or map", v, v)
+}
+
+type not struct {
+	arg node
+}
+
+func (n not) eval(args map[string]interface{}) (interface{}, error) {
+	b, err := evalBool(n.arg, args)
+	if err != nil {
+		return nil, err
+	}
+	return !b, nil
+}
+
+type logical struct {
+	op          string // && or ||
+	left, right node
+}
+
+func (n logical) eval(args map[string]interface{}) (interface{}, error) {
+	l, err := evalBool(n.left, args)
+	if err != nil {
+		return nil, err
+	}
+	if (n.op == "&&" && !l) || (n.op == "||" && l) {
+		return l, nil
+	}
+	return evalBool(n.right, args)
+}
+
+type compare struct {
+	op          string
+	left, right node
+}
+
+func (n compare) eval(args map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(args)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(args)
+	if err != nil {
+		return nil, err
+	}
+	l, r = number(l), number(r)
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(l, r), nil
+	case "!=":
+		return !reflect.DeepEqual(l, r), nil
+	}
+	var c int
+	switch lv := l.(type) {
+	case float64:
+		rv, ok := r.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare %v %s %v", l, n.op, r)
+		}
+		if lv < rv {
+			c = -1
+		} else if lv > rv {
+			c = 1
+		}
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare %v %s %v", l, n.op, r)
+		}
+		c = strings.Compare(lv, rv)
+	default:
+		return nil, fmt.Errorf("cannot compare %v %s %v", l, n.op, r)
+	}
+	switch n.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default: // >=
+		return c >= 0, nil
+	}
+}
+
+type arith struct {
+	op          string // + - * / %
+	left, right node
+}
+
+func (n arith) eval(args map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(args)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(args)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "+" {
+		if ls, ok := l.(string); ok {
+			if rs, ok := r.(string); ok {
+				return ls + rs, nil
+			}
+		}
+	}
+	lv, lok := number(l).(float64)
+	rv, rok := number(r).(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("cannot compute %v %s %v: not numbers", l, n.op, r)
+	}
+	switch n.op {
+	case "+":
+		return lv + rv, nil
+	case "-":
+		return lv - rv, nil
+	case "*":
+		return lv * rv, nil
+	}
+	if rv == 0 {
+		return nil, fmt.Errorf("cannot compute %v %s %v: division by zero", l, n.op, r)
+	}
+	if n.op == "/" {
+		return lv / rv, nil
+	}
+	return math.Mod(lv, rv), nil // %
+}
+
+func evalBool(n node, args map[string]interface{}) (bool, error) {
+	v, err := n.eval(args)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%v is not true or false", v)
+	}
+	return b, nil
+}
+
+// number returns numbers of any type as float64 so they compare as numbers,
+// like int jobArgs and float64 job data decoded from JSON. Other values are
+// returned as is.
+func number(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	return v
+}
+
+// --------------------------------------------------------------------------
+
+const (
+	tEOF = iota
+	tIdent
+	tNumber
+	tString
+	tOp
+)
+
+type token struct {
+	kind int
+	val  string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q at %d", t.val, t.pos)
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	n      int
+	vars   map[string]bool
+}
+
+var ops = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "+", "-", "*", "/", "%"}
+
+func (p *parser) lex() error {
+	s := p.src
+	i := 0
+LEX:
+	for i < len(s) {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{kind: tIdent, val: s[i:j], pos: i})
+			i = j
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1])) && !p.afterValue()):
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			p.tokens = append(p.tokens, token{kind: tNumber, val: s[i:j], pos: i})
+			i = j
+		case c == '"' || c == '\'':
+			j := strings.IndexByte(s[i+1:], s[i])
+			if j < 0 {
+				return fmt.Errorf("unterminated string at %d", i)
+			}
+			p.tokens = append(p.tokens, token{kind: tString, val: s[i+1 : i+1+j], pos: i})
+			i += j + 2
+		default:
+			for _, op := range ops {
+				if strings.HasPrefix(s[i:], op) {
+					p.tokens = append(p.tokens, token{kind: tOp, val: op, pos: i})
+					i += len(op)
+					continue LEX
+				}
+			}
+			return fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	p.tokens = append(p.tokens, token{kind: tEOF, pos: i})
+	return nil
+}
+
+// afterValue returns true if the last token ends a value, so a - that follows
+// it is subtraction, like "a -1", not a negative number.
+func (p *parser) afterValue() bool {
+	if len(p.tokens) == 0 {
+		return false
+	}
+	t := p.tokens[len(p.tokens)-1]
+	return t.kind == tIdent || t.kind == tNumber || t.kind == tString || (t.kind == tOp && t.val == ")")
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.n]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.n]
+	if t.kind != tEOF {
+		p.n++
+	}
+	return t
+}
+
+func (p *parser) isOp(vals ...string) bool {
+	t := p.peek()
+	if t.kind != tOp {
+		return false
+	}
+	for _, v := range vals {
+		if t.val == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isOp("!") {
+		p.next()
+		arg, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return not{arg: arg}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (node, error) {
+	left, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("==", "!=", "<", "<=", ">", ">=") {
+		op := p.next().val
+		right, err := p.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		return compare{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdd() (node, error) {
+	left, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+", "-") {
+		op := p.next().val
+		right, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		left = arith{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseMul() (node, error) {
+	left, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*", "/", "%") {
+		op := p.next().val
+		right, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		left = arith{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseValue() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tNumber:
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t)
+		}
+		return literal{val: f}, nil
+	case tString:
+		return literal{val: t.val}, nil
+	case tIdent:
+		switch t.val {
+		case "true":
+			return literal{val: true}, nil
+		case "false":
+			return literal{val: false}, nil
+		case "null":
+			return literal{val: nil}, nil
+		case "len":
+			if p.isOp("(") {
+				p.next()
+				arg, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				if !p.isOp(")") {
+					return nil, fmt.Errorf("expected ) instead of %s", p.peek())
+				}
+				p.next()
+				return lenCall{arg: arg}, nil
+			}
+		}
+		p.vars[t.val] = true
+		return variable{name: t.val}, nil
+	case tOp:
+		if t.val == "-" {
+			arg, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			return arith{op: "-", left: literal{val: float64(0)}, right: arg}, nil
+		}
+		if t.val == "(" {
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.isOp(")") {
+				return nil, fmt.Errorf("expected ) instead of %s", p.peek())
+			}
+			p.next()
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s", t)
+}
@@ -0,0 +1,165 @@
+// Copyright 2020, Square, Inc.
+
+package expr_test
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/expr"
+)
+
+func TestEval(t *testing.T) {
+	args := map[string]interface{}{
+		"failed_hosts": []string{},
+		"hosts":        []interface{}{"h1", "h2"},
+		"env":          "staging",
+		"count":        3,
+		"ratio":        0.5,
+		"done":         true,
+		"owner":        nil,
+	}
+	tests := map[string]bool{
+		`len(failed_hosts) == 0`:                  true,
+		`len(hosts) > len(failed_hosts)`:          true,
+		`len(hosts) == 2 && env == "staging"`:     true,
+		`env == 'prod' || count >= 3`:             true,
+		`!(count < 3)`:                            true,
+		`count == 3.0`:                            true,
+		`ratio <= 0.5 && ratio > -1`:              true,
+		`done`:                                    true,
+		`!done`:                                   false,
+		`owner == null`:                           true,
+		`len(owner) == 0`:                         true,
+		`env != "staging" || len(env) != 7`:       false,
+		`env < "t"`:                               true,
+		`count > 3 || (env == "prod" && done)`:    false,
+		`len(failed_hosts)==0&&len(hosts)==2`:     true,
+		`  len( hosts )  ==  2  `:                 true,
+		`count != 3 && unknown_is_not_evaluated`:  false,
+		`count == 3 || unknown_is_not_evaluated`:  true,
+		`len("abc") == 3 && env == "staging"`:     true,
+		`false || (true && !false) && count != 0`: true,
+	}
+	for s, expect := range tests {
+		e, err := expr.Parse(s)
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+		got, err := e.Eval(args)
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+		if got != expect {
+			t.Errorf("%s = %t, expected %t", s, got, expect)
+		}
+	}
+
+	// Eval errors
+	for _, s := range []string{
+		`len(nope) == 0`, // unknown jobArg
+		`count`,          // not a bool
+		`env < 3`,        // different types
+		`len(count) > 0`, // len of number
+		`!env`,           // not a bool
+	} {
+		e, err := expr.Parse(s)
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+		if _, err := e.Eval(args); err == nil {
+			t.Errorf("%s: no eval error, expected one", s)
+		}
+	}
+}
+
+func TestValue(t *testing.T) {
+	args := map[string]interface{}{
+		"hosts":   []interface{}{"h1", "h2", "h3", "h4"},
+		"count":   3,
+		"ratio":   0.5,
+		"cluster": "db1",
+	}
+	tests := map[string]interface{}{
+		`len(hosts) / 4`:            float64(1),
+		`count * 2 + 1`:             float64(7),
+		`count * (2 + 1)`:           float64(9),
+		`count-1`:                   float64(2),
+		`count -1`:                  float64(2),
+		`-count + 10`:               float64(7),
+		`10 % count`:                float64(1),
+		`ratio * len(hosts)`:        float64(2),
+		`cluster + "-replica"`:      "db1-replica",
+		`count * 2 > len(hosts)`:    true,
+		`len(hosts) - 1 == count`:   true,
+		`cluster`:                   "db1",
+		`-1 + count`:                float64(2),
+		`(count + 1) * -2`:          float64(-8),
+		`len(hosts) / 2 / 2 == 1.0`: true,
+	}
+	for s, expect := range tests {
+		e, err := expr.Parse(s)
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+		got, err := e.Value(args)
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+		if got != expect {
+			t.Errorf("%s = %v (%T), expected %v (%T)", s, got, got, expect, expect)
+		}
+	}
+
+	// Value errors
+	for _, s := range []string{
+		`count / 0`,       // division by zero
+		`count % (1 - 1)`, // division by zero
+		`cluster * 2`,     // not numbers
+		`cluster + count`, // string and number
+		`nope + 1`,        // unknown jobArg
+	} {
+		e, err := expr.Parse(s)
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+		if _, err := e.Value(args); err == nil {
+			t.Errorf("%s: no value error, expected one", s)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{
+		``,
+		`len(hosts == 0`,
+		`count ==`,
+		`count == 1 2`,
+		`env == "staging`,
+		`count = 1`,
+		`count == 1 &&`,
+		`(count == 1`,
+		`count == 1)`,
+		`count +`,
+		`count * / 2`,
+	} {
+		if _, err := expr.Parse(s); err == nil {
+			t.Errorf("%q: no parse error, expected one", s)
+		}
+	}
+
+	e, err := expr.Parse(`len(failed_hosts) == 0 && env != "prod" && len(failed_hosts) < max`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(e.Vars(), []string{"env", "failed_hosts", "max"}); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2020, Square, Inc.
+
+// Package gate provides the built-in gate job: a manual approval step in a
+// request. When a gate job runs, it waits until an operator approves it (spinc
+// approve), then completes so the request continues. If the gate has an expiry
+// and is not approved in time, it fails. While waiting, its running job status
+// state is proto.STATE_WAITING_APPROVAL.
+//
+// Gate jobs have type JOB_TYPE. The package also provides the built-in poll-until
+// job (POLL_JOB_TYPE): a gate that waits for an external condition instead of an
+// approval. The Request Manager and Job Runner make both with a factory from
+// NewFactory, which makes all other job types with the jobs factory.
+package gate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/square/spincycle/v2/job"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// JOB_TYPE is the job type of gate jobs in request specs.
+const JOB_TYPE = "gate"
+
+// EXPIRY_ARG is the optional job arg of a gate job: how long to wait for approval
+// (duration string like "4h"). If not set, the gate waits until it's approved or
+// the request is stopped.
+const EXPIRY_ARG = "expiry"
+
+// ErrNotWaiting is returned by Gates.Approve if the job is not a gate job that
+// is waiting for approval, like a gate that was already approved.
+var ErrNotWaiting = errors.New("job is not a gate waiting for approval")
+
+// Gates are the gate jobs waiting for approval on a Job Runner. A nil *Gates has
+// no gates, so it cannot approve any. It's safe for concurrent use.
+type Gates struct {
+	mux     sync.Mutex
+	waiting map[string]chan string // requestId/jobId => approving user
+}
+
+// NewGates makes an empty Gates.
+func NewGates() *Gates {
+	return &Gates{
+		waiting: map[string]chan string{},
+	}
+}
+
+// Approve approves the gate job, which completes. It returns ErrNotWaiting if
+// the job is not waiting for approval.
+func (g *Gates) Approve(requestId, jobId, user string) error {
+	if g == nil {
+		return ErrNotWaiting
+	}
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	approved, ok := g.waiting[requestId+"/"+jobId]
+	if !ok {
+		return ErrNotWaiting
+	}
+	delete(g.waiting, requestId+"/"+jobId)
+	approved <- user // buffered
+	return nil
+}
+
+// Waiting returns true if the gate job is waiting for approval.
+func (g *Gates) Waiting(requestId, jobId string) bool {
+	if g == nil {
+		return false
+	}
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	_, ok := g.waiting[requestId+"/"+jobId]
+	return ok
+}
+
+// wait adds the gate job and returns a chan that receives the approving user.
+// The returned func removes the gate job if it was not approved.
+func (g *Gates) wait(requestId, jobId string) (<-chan string, func()) {
+	approved := make(chan string, 1)
+	g.mux.Lock()
+	g.waiting[requestId+"/"+jobId] = approved
+	g.mux.Unlock()
+	return approved, func() {
+		g.mux.Lock()
+		if g.waiting[requestId+"/"+jobId] == approved {
+			delete(g.waiting, requestId+"/"+jobId)
+		}
+		g.mux.Unlock()
+	}
+}
+
+// --------------------------------------------------------------------------
+
+// NewFactory returns a job factory that makes gate and poll-until jobs, and makes
+// all other job types with jf. Gate jobs made by the Job Runner wait for approval
+// in gates. The Request Manager only creates gate jobs, so it passes nil gates.
+// If jf is a job.TypeLister, the returned factory is too, listing JOB_TYPE and
+// POLL_JOB_TYPE with its types.
+func NewFactory(jf job.Factory, gates *Gates) job.Factory {
+	f := factory{
+		jf:    jf,
+		gates: gates,
+	}
+	if tl, ok := jf.(job.TypeLister); ok {
+		return listerFactory{factory: f, tl: tl}
+	}
+	return f
+}
+
+type factory struct {
+	jf    job.Factory
+	gates *Gates
+}
+
+func (f factory) Make(id job.Id) (job.Job, error) {
+	if id.Type == JOB_TYPE {
+		return &Job{
+			id:       id,
+			gates:    f.gates,
+			stopChan: make(chan struct{}),
+		}, nil
+	}
+	if id.Type == POLL_JOB_TYPE {
+		return &PollJob{
+			id:       id,
+			stopChan: make(chan struct{}),
+		}, nil
+	}
+	return f.jf.Make(id)
+}
+
+type listerFactory struct {
+	factory
+	tl job.TypeLister
+}
+
+func (f listerFactory) Types() []string {
+	types := append([]string{JOB_TYPE, POLL_JOB_TYPE}, f.tl.Types()...)
+	sort.Strings(types)
+	return types
+}
+
+// --------------------------------------------------------------------------
+
+// Job is a gate job. See package doc.
+type Job struct {
+	id    job.Id
+	gates *Gates
+
+	Expiry string `json:"expiry,omitempty"` // EXPIRY_ARG
+
+	mux      sync.Mutex
+	status   string
+	stopChan chan struct{}
+	stopped  bool
+}
+
+func (j *Job) Create(jobArgs map[string]interface{}) error {
+	v, ok := jobArgs[EXPIRY_ARG]
+	if !ok || v == nil {
+		return nil
+	}
+	expiry, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("%s job arg is %T, expected a duration string like \"4h\"", EXPIRY_ARG, v)
+	}
+	d, err := time.ParseDuration(expiry)
+	if err != nil {
+		return fmt.Errorf("invalid %s job arg: %s", EXPIRY_ARG, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("invalid %s job arg: %s, must be greater than zero", EXPIRY_ARG, expiry)
+	}
+	j.Expiry = expiry
+	return nil
+}
+
+func (j *Job) Serialize() ([]byte, error) {
+	return json.Marshal(j)
+}
+
+func (j *Job) Deserialize(bytes []byte) error {
+	if len(bytes) == 0 {
+		return nil
+	}
+	return json.Unmarshal(bytes, j)
+}
+
+// Run waits until the gate is approved, the expiry passes, or the job is stopped.
+// It returns STATE_COMPLETE if approved, else STATE_FAIL if expired.
+func (j *Job) Run(jobData map[string]interface{}) (job.Return, error) {
+	if j.gates == nil {
+		return job.Return{State: proto.STATE_FAIL, Exit: 1}, fmt.Errorf("gate jobs can only run in the Job Runner")
+	}
+	approved, done := j.gates.wait(j.id.RequestId, j.id.Id)
+	defer done()
+
+	var expired <-chan time.Time
+	status := "waiting for approval of job " + j.id.Id
+	if j.Expiry != "" {
+		d, err := time.ParseDuration(j.Expiry)
+		if err != nil {
+			return job.Return{State: proto.STATE_FAIL, Exit: 1}, fmt.Errorf("invalid expiry: %s", err)
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		expired = timer.C
+		status += fmt.Sprintf(" (expires at %s)", time.Now().Add(d).UTC().Format(time.RFC3339))
+	}
+	j.setStatus(status)
+
+	select {
+	case user := <-approved:
+		j.setStatus("approved by " + user)
+		return job.Return{State: proto.STATE_COMPLETE, Stdout: fmt.Sprintf("approved by %s\n", user)}, nil
+	case <-expired:
+		j.setStatus("expired")
+		return job.Return{
+			State: proto.STATE_FAIL,
+			Exit:  1,
+			Error: fmt.Errorf("not approved within %s", j.Expiry),
+		}, nil
+	case <-j.stopChan:
+		j.setStatus("stopped")
+		return job.Return{State: proto.STATE_STOPPED}, nil
+	}
+}
+
+func (j *Job) Stop() error {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if !j.stopped {
+		close(j.stopChan)
+		j.stopped = true
+	}
+	return nil
+}
+
+func (j *Job) Status() string {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	return j.status
+}
+
+func (j *Job) Id() job.Id {
+	return j.id
+}
+
+// WaitingApproval returns true while the gate job waits for approval. The Job
+// Runner reports its running job status state as proto.STATE_WAITING_APPROVAL.
+func (j *Job) WaitingApproval() bool {
+	return j.gates.Waiting(j.id.RequestId, j.id.Id)
+}
+
+func (j *Job) setStatus(status string) {
+	j.mux.Lock()
+	j.status = status
+	j.mux.Unlock()
+}
@@ -0,0 +1,168 @@
+// Copyright 2020, Square, Inc.
+
+package gate_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/gate"
+	"github.com/square/spincycle/v2/job"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+// run runs the gate job and waits until it's waiting for approval.
+func run(t *testing.T, j job.Job, gates *gate.Gates) chan job.Return {
+	retChan := make(chan job.Return, 1)
+	go func() {
+		ret, err := j.Run(map[string]interface{}{})
+		if err != nil {
+			t.Error(err)
+		}
+		retChan <- ret
+	}()
+	for i := 0; i < 100; i++ {
+		if gates.Waiting(j.Id().RequestId, j.Id().Id) {
+			return retChan
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("gate job not waiting for approval")
+	return nil
+}
+
+func makeGate(t *testing.T, gates *gate.Gates, jobArgs map[string]interface{}) job.Job {
+	jf := gate.NewFactory(&mock.JobFactory{}, gates)
+	j, err := jf.Make(job.NewIdWithRequestId(gate.JOB_TYPE, "approve-failover", "job1", "req1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Create(jobArgs); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func TestApprove(t *testing.T) {
+	gates := gate.NewGates()
+	j := makeGate(t, gates, map[string]interface{}{})
+
+	// Not waiting yet
+	if err := gates.Approve("req1", "job1", "finch"); err != gate.ErrNotWaiting {
+		t.Errorf("got err %v, expected ErrNotWaiting", err)
+	}
+
+	retChan := run(t, j, gates)
+	if !j.(*gate.Job).WaitingApproval() {
+		t.Error("WaitingApproval is false, expected true")
+	}
+	if err := gates.Approve("req1", "job1", "finch"); err != nil {
+		t.Fatal(err)
+	}
+	ret := <-retChan
+	if ret.State != proto.STATE_COMPLETE {
+		t.Errorf("got state %s, expected COMPLETE", proto.StateName[ret.State])
+	}
+	if ret.Stdout != "approved by finch\n" {
+		t.Errorf("got stdout %q, expected %q", ret.Stdout, "approved by finch\n")
+	}
+
+	// Already approved
+	if err := gates.Approve("req1", "job1", "finch"); err != gate.ErrNotWaiting {
+		t.Errorf("got err %v, expected ErrNotWaiting", err)
+	}
+	if gates.Waiting("req1", "job1") {
+		t.Error("gate waiting after approval")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	gates := gate.NewGates()
+	j := makeGate(t, gates, map[string]interface{}{gate.EXPIRY_ARG: "200ms"})
+
+	// Expiry is serialized so the JR gets it from the job bytes
+	bytes, err := j.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jf := gate.NewFactory(&mock.JobFactory{}, gates)
+	j, _ = jf.Make(j.Id())
+	if err := j.Deserialize(bytes); err != nil {
+		t.Fatal(err)
+	}
+
+	ret := <-run(t, j, gates)
+	if ret.State != proto.STATE_FAIL {
+		t.Errorf("got state %s, expected FAIL", proto.StateName[ret.State])
+	}
+	if ret.Error == nil {
+		t.Error("no error, expected one")
+	}
+	if gates.Waiting("req1", "job1") {
+		t.Error("gate waiting after expiry")
+	}
+}
+
+func TestStop(t *testing.T) {
+	gates := gate.NewGates()
+	j := makeGate(t, gates, map[string]interface{}{})
+	retChan := run(t, j, gates)
+	if err := j.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	ret := <-retChan
+	if ret.State != proto.STATE_STOPPED {
+		t.Errorf("got state %s, expected STOPPED", proto.StateName[ret.State])
+	}
+	if gates.Waiting("req1", "job1") {
+		t.Error("gate waiting after stop")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	for _, expiry := range []interface{}{"4 hours", "-1h", "0s", 60} {
+		jf := gate.NewFactory(&mock.JobFactory{}, nil)
+		j, _ := jf.Make(job.NewIdWithRequestId(gate.JOB_TYPE, "approve-failover", "job1", "req1"))
+		if err := j.Create(map[string]interface{}{gate.EXPIRY_ARG: expiry}); err == nil {
+			t.Errorf("expiry %v: no error, expected one", expiry)
+		}
+	}
+
+	// RM makes gate jobs without gates: they cannot run
+	j := makeGate(t, nil, map[string]interface{}{})
+	ret, err := j.Run(map[string]interface{}{})
+	if err == nil {
+		t.Error("no error, expected one")
+	}
+	if ret.State != proto.STATE_FAIL {
+		t.Errorf("got state %s, expected FAIL", proto.StateName[ret.State])
+	}
+}
+
+type typeLister struct {
+	mock.JobFactory
+}
+
+func (typeLister) Types() []string {
+	return []string{"restart-host", "diag"}
+}
+
+func TestFactory(t *testing.T) {
+	jf := gate.NewFactory(&mock.JobFactory{}, nil)
+	if _, ok := jf.(job.TypeLister); ok {
+		t.Error("factory is a job.TypeLister, expected not")
+	}
+
+	jf = gate.NewFactory(&typeLister{}, nil)
+	tl, ok := jf.(job.TypeLister)
+	if !ok {
+		t.Fatal("factory is not a job.TypeLister")
+	}
+	expect := []string{"diag", "gate", "poll-until", "restart-host"}
+	if diff := deep.Equal(tl.Types(), expect); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2020, Square, Inc.
+
+package gate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/square/spincycle/v2/expr"
+	"github.com/square/spincycle/v2/job"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// POLL_JOB_TYPE is the job type of poll-until jobs in request specs: a gate that
+// waits for an external condition instead of an approval, like "wait for
+// replication to catch up". A poll-until job GETs POLL_URL_ARG every interval
+// until the condition is true, then completes. The condition is true when the
+// response status is 2xx and, if POLL_UNTIL_ARG is set, the expression evaluates
+// to true over the fields of the JSON object in the response body, the job data,
+// and the job args it uses (in that precedence), like "lag_seconds <= maxLag".
+const POLL_JOB_TYPE = "poll-until"
+
+// Job args of poll-until jobs. Only POLL_URL_ARG is required.
+const (
+	POLL_URL_ARG      = "url"      // http or https URL to GET
+	POLL_UNTIL_ARG    = "until"    // expr expression, like "lag_seconds < 5"
+	POLL_INTERVAL_ARG = "interval" // duration between polls (default DEFAULT_POLL_INTERVAL)
+	POLL_TIMEOUT_ARG  = "timeout"  // how long to poll before failing (default no timeout)
+)
+
+const DEFAULT_POLL_INTERVAL = "10s"
+
+// MAX_POLL_BODY is the max size of a response body read by a poll-until job.
+const MAX_POLL_BODY = 1 << 20 // 1 MB
+
+// PollClient is the HTTP client of poll-until jobs. A poll that takes longer
+// than its timeout is false.
+var PollClient = &http.Client{Timeout: 30 * time.Second}
+
+// PollJob is a poll-until job. See POLL_JOB_TYPE.
+type PollJob struct {
+	id job.Id
+
+	URL      string `json:"url"`
+	Until    string `json:"until,omitempty"`
+	Interval string `json:"interval"`
+	Timeout  string `json:"timeout,omitempty"`
+
+	// Job args used by Until, saved on Create because the JR does not have them
+	Args map[string]interface{} `json:"args,omitempty"`
+
+	mux      sync.Mutex
+	status   string
+	stopChan chan struct{}
+	stopped  bool
+}
+
+func (j *PollJob) Create(jobArgs map[string]interface{}) error {
+	var err error
+	if j.URL, err = stringArg(jobArgs, POLL_URL_ARG); err != nil {
+		return err
+	}
+	if j.URL == "" {
+		return job.ErrArgNotSet{Arg: POLL_URL_ARG}
+	}
+	if u, err := url.Parse(j.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid %s job arg %q: must be an http or https URL", POLL_URL_ARG, j.URL)
+	}
+
+	if j.Until, err = stringArg(jobArgs, POLL_UNTIL_ARG); err != nil {
+		return err
+	}
+	if j.Until != "" {
+		until, err := expr.Parse(j.Until)
+		if err != nil {
+			return fmt.Errorf("invalid %s job arg: %s", POLL_UNTIL_ARG, err)
+		}
+		for _, v := range until.Vars() {
+			if val, ok := jobArgs[v]; ok {
+				if j.Args == nil {
+					j.Args = map[string]interface{}{}
+				}
+				j.Args[v] = val
+			}
+		}
+	}
+
+	if j.Interval, err = durationArg(jobArgs, POLL_INTERVAL_ARG); err != nil {
+		return err
+	}
+	if j.Interval == "" {
+		j.Interval = DEFAULT_POLL_INTERVAL
+	}
+	if j.Timeout, err = durationArg(jobArgs, POLL_TIMEOUT_ARG); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (j *PollJob) Serialize() ([]byte, error) {
+	return json.Marshal(j)
+}
+
+func (j *PollJob) Deserialize(bytes []byte) error {
+	if len(bytes) == 0 {
+		return nil
+	}
+	return json.Unmarshal(bytes, j)
+}
+
+// Run polls until the condition is true, the timeout passes, or the job is
+// stopped. It returns STATE_COMPLETE if the condition is true, else STATE_FAIL
+// if timed out.
+func (j *PollJob) Run(jobData map[string]interface{}) (job.Return, error) {
+	interval, err := time.ParseDuration(j.Interval)
+	if err != nil {
+		return job.Return{State: proto.STATE_FAIL, Exit: 1}, fmt.Errorf("invalid interval: %s", err)
+	}
+	var until *expr.Expr
+	if j.Until != "" {
+		if until, err = expr.Parse(j.Until); err != nil {
+			return job.Return{State: proto.STATE_FAIL, Exit: 1}, err
+		}
+	}
+	var timedOut <-chan time.Time
+	if j.Timeout != "" {
+		d, err := time.ParseDuration(j.Timeout)
+		if err != nil {
+			return job.Return{State: proto.STATE_FAIL, Exit: 1}, fmt.Errorf("invalid timeout: %s", err)
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timedOut = timer.C
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for n := 1; ; n++ {
+		ok, result := j.poll(until, jobData)
+		if ok {
+			j.setStatus(fmt.Sprintf("condition true after %d polls", n))
+			return job.Return{State: proto.STATE_COMPLETE, Stdout: fmt.Sprintf("condition true after %d polls: %s\n", n, result)}, nil
+		}
+		j.setStatus(fmt.Sprintf("polling %s every %s, poll %d: %s", j.URL, j.Interval, n, result))
+		select {
+		case <-ticker.C:
+		case <-timedOut:
+			j.setStatus("timed out")
+			return job.Return{
+				State: proto.STATE_FAIL,
+				Exit:  1,
+				Error: fmt.Errorf("condition not true within %s after %d polls, last: %s", j.Timeout, n, result),
+			}, nil
+		case <-j.stopChan:
+			j.setStatus("stopped")
+			return job.Return{State: proto.STATE_STOPPED}, nil
+		}
+	}
+}
+
+// poll GETs the URL once and returns true if the condition is true, and the
+// result for the job status and output, like "HTTP 503".
+func (j *PollJob) poll(until *expr.Expr, jobData map[string]interface{}) (bool, string) {
+	resp, err := PollClient.Get(j.URL)
+	if err != nil {
+		return false, err.Error()
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: MAX_POLL_BODY})
+	if err != nil {
+		return false, fmt.Sprintf("HTTP %d, error reading response: %s", resp.StatusCode, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, fmt.Sprintf("HTTP %d", resp.StatusCode)
+	}
+	if until == nil {
+		return true, fmt.Sprintf("HTTP %d", resp.StatusCode)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return false, fmt.Sprintf("HTTP %d, response is not a JSON object: %s", resp.StatusCode, err)
+	}
+	args := make(map[string]interface{}, len(j.Args)+len(jobData)+len(fields))
+	for k, v := range j.Args {
+		args[k] = v
+	}
+	for k, v := range jobData {
+		args[k] = v
+	}
+	for k, v := range fields {
+		args[k] = v
+	}
+	ok, err := until.Eval(args)
+	if err != nil {
+		return false, fmt.Sprintf("HTTP %d, error evaluating %s: %s", resp.StatusCode, until, err)
+	}
+	return ok, fmt.Sprintf("HTTP %d, %s is %t", resp.StatusCode, until, ok)
+}
+
+func (j *PollJob) Stop() error {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if !j.stopped {
+		close(j.stopChan)
+		j.stopped = true
+	}
+	return nil
+}
+
+func (j *PollJob) Status() string {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	return j.status
+}
+
+func (j *PollJob) Id() job.Id {
+	return j.id
+}
+
+func (j *PollJob) setStatus(status string) {
+	j.mux.Lock()
+	j.status = status
+	j.mux.Unlock()
+}
+
+// stringArg returns the optional string job arg, or an empty string if not set.
+func stringArg(jobArgs map[string]interface{}, arg string) (string, error) {
+	v, ok := jobArgs[arg]
+	if !ok || v == nil {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s job arg is %T, expected a string", arg, v)
+	}
+	return s, nil
+}
+
+// durationArg returns the optional duration string job arg, like "5m", or an
+// empty string if not set. It must be greater than zero.
+func durationArg(jobArgs map[string]interface{}, arg string) (string, error) {
+	v, ok := jobArgs[arg]
+	if !ok || v == nil {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s job arg is %T, expected a duration string like \"5m\"", arg, v)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s job arg: %s", arg, err)
+	}
+	if d <= 0 {
+		return "", fmt.Errorf("invalid %s job arg: %s, must be greater than zero", arg, s)
+	}
+	return s, nil
+}
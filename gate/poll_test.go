@@ -0,0 +1,175 @@
+// Copyright 2020, Square, Inc.
+
+package gate_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/square/spincycle/v2/gate"
+	"github.com/square/spincycle/v2/job"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+func makePoll(t *testing.T, jobArgs map[string]interface{}) job.Job {
+	jf := gate.NewFactory(&mock.JobFactory{}, nil)
+	j, err := jf.Make(job.NewIdWithRequestId(gate.POLL_JOB_TYPE, "wait-replication", "job1", "req1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Create(jobArgs); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+// pollServer returns the responses in order, repeating the last one, and the
+// number of polls.
+func pollServer(responses ...string) (*httptest.Server, func() int) {
+	var mux sync.Mutex
+	n := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		resp := responses[len(responses)-1]
+		if n < len(responses) {
+			resp = responses[n]
+		}
+		n++
+		mux.Unlock()
+		if resp == "503" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(resp))
+	}))
+	return ts, func() int { mux.Lock(); defer mux.Unlock(); return n }
+}
+
+func TestPollStatus(t *testing.T) {
+	// Without until, a 2xx response is true
+	ts, polls := pollServer("503", "503", "ok")
+	defer ts.Close()
+	j := makePoll(t, map[string]interface{}{
+		gate.POLL_URL_ARG:      ts.URL,
+		gate.POLL_INTERVAL_ARG: "10ms",
+	})
+	ret, err := j.Run(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.State != proto.STATE_COMPLETE {
+		t.Errorf("got state %s, expected COMPLETE", proto.StateName[ret.State])
+	}
+	if polls() != 3 {
+		t.Errorf("%d polls, expected 3", polls())
+	}
+	if !strings.HasPrefix(ret.Stdout, "condition true after 3 polls") {
+		t.Errorf("got stdout %q, expected 'condition true after 3 polls'", ret.Stdout)
+	}
+}
+
+func TestPollUntil(t *testing.T) {
+	// Until is evaluated over the JSON response, job data, and job args
+	ts, polls := pollServer(`{"lag":30}`, "503", `not json`, `{"lag":4}`)
+	defer ts.Close()
+	j := makePoll(t, map[string]interface{}{
+		gate.POLL_URL_ARG:      ts.URL,
+		gate.POLL_UNTIL_ARG:    "lag < maxLag && ready",
+		"maxLag":               5,
+		gate.POLL_INTERVAL_ARG: "10ms",
+		gate.POLL_TIMEOUT_ARG:  "5s",
+	})
+
+	// Until and its job args are serialized so the JR gets them from the job bytes
+	bytes, err := j.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jf := gate.NewFactory(&mock.JobFactory{}, nil)
+	j, _ = jf.Make(j.Id())
+	if err := j.Deserialize(bytes); err != nil {
+		t.Fatal(err)
+	}
+
+	ret, err := j.Run(map[string]interface{}{"ready": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.State != proto.STATE_COMPLETE {
+		t.Errorf("got state %s, expected COMPLETE", proto.StateName[ret.State])
+	}
+	if polls() != 4 {
+		t.Errorf("%d polls, expected 4", polls())
+	}
+}
+
+func TestPollTimeout(t *testing.T) {
+	ts, _ := pollServer(`{"lag":30}`)
+	defer ts.Close()
+	j := makePoll(t, map[string]interface{}{
+		gate.POLL_URL_ARG:      ts.URL,
+		gate.POLL_UNTIL_ARG:    "lag < 5",
+		gate.POLL_INTERVAL_ARG: "10ms",
+		gate.POLL_TIMEOUT_ARG:  "100ms",
+	})
+	ret, err := j.Run(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.State != proto.STATE_FAIL {
+		t.Errorf("got state %s, expected FAIL", proto.StateName[ret.State])
+	}
+	if ret.Error == nil || !strings.Contains(ret.Error.Error(), "is false") {
+		t.Errorf("got error %v, expected the last result", ret.Error)
+	}
+}
+
+func TestPollStop(t *testing.T) {
+	ts, polls := pollServer("503")
+	defer ts.Close()
+	j := makePoll(t, map[string]interface{}{
+		gate.POLL_URL_ARG:      ts.URL,
+		gate.POLL_INTERVAL_ARG: "10ms",
+	})
+	retChan := make(chan job.Return, 1)
+	go func() {
+		ret, _ := j.Run(map[string]interface{}{})
+		retChan <- ret
+	}()
+	for i := 0; i < 100 && polls() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(j.Status(), "HTTP 503") {
+		t.Errorf("got status %q, expected it to contain HTTP 503", j.Status())
+	}
+	if err := j.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	ret := <-retChan
+	if ret.State != proto.STATE_STOPPED {
+		t.Errorf("got state %s, expected STOPPED", proto.StateName[ret.State])
+	}
+}
+
+func TestPollCreate(t *testing.T) {
+	for _, args := range []map[string]interface{}{
+		{},
+		{gate.POLL_URL_ARG: "db01:8080/status"},
+		{gate.POLL_URL_ARG: "ftp://db01/status"},
+		{gate.POLL_URL_ARG: 8080},
+		{gate.POLL_URL_ARG: "http://db01/status", gate.POLL_UNTIL_ARG: "lag <"},
+		{gate.POLL_URL_ARG: "http://db01/status", gate.POLL_INTERVAL_ARG: "0s"},
+		{gate.POLL_URL_ARG: "http://db01/status", gate.POLL_TIMEOUT_ARG: "10 minutes"},
+	} {
+		jf := gate.NewFactory(&mock.JobFactory{}, nil)
+		j, _ := jf.Make(job.NewIdWithRequestId(gate.POLL_JOB_TYPE, "wait-replication", "job1", "req1"))
+		if err := j.Create(args); err == nil {
+			t.Errorf("args %v: no error, expected one", args)
+		}
+	}
+}
@@ -6,18 +6,33 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/orcaman/concurrent-map"
 
+	"github.com/square/spincycle/v2/compress"
+	"github.com/square/spincycle/v2/config"
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/gate"
+	"github.com/square/spincycle/v2/job"
 	"github.com/square/spincycle/v2/job-runner/app"
 	"github.com/square/spincycle/v2/job-runner/chain"
+	"github.com/square/spincycle/v2/job-runner/fault"
+	"github.com/square/spincycle/v2/job-runner/profile"
 	"github.com/square/spincycle/v2/job-runner/status"
+	"github.com/square/spincycle/v2/metrics"
 	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/validate"
 	v "github.com/square/spincycle/v2/version"
 )
 
@@ -33,6 +48,18 @@ var (
 
 	// Error when Job Runner is shutting down and not starting new job chains
 	ErrShuttingDown = errors.New("Job Runner is shutting down - no new job chains are being started")
+
+	// Error when fault injection is not enabled (config fault_injection)
+	ErrNoFaultInjection = errors.New("fault injection is not enabled")
+
+	// Error when profiling is not enabled (config profiling)
+	ErrNoProfiling = errors.New("profiling is not enabled")
+)
+
+const (
+	// Default and max duration of CPU profiles captured for a job chain
+	DEFAULT_PROFILE_DURATION = 30 * time.Second
+	MAX_PROFILE_DURATION     = 10 * time.Minute
 )
 
 // api provides controllers for endpoints it registers with a router.
@@ -43,6 +70,13 @@ type API struct {
 	stat             status.Manager
 	shutdownChan     chan struct{}
 	baseURL          string
+	jobFactory       job.Factory
+	gates            *gate.Gates
+	capabilities     []string
+	faults           *fault.Injector
+	chainRepo        chain.Repo
+	retainer         *chain.Retainer
+	profiler         *profile.Capturer
 	// --
 	echo *echo.Echo
 }
@@ -53,7 +87,14 @@ type Config struct {
 	TraverserRepo    cmap.ConcurrentMap
 	StatusManager    status.Manager
 	ShutdownChan     chan struct{}
-	BaseURL          string // returned in location header when starting/resuming job chains
+	BaseURL          string            // returned in location header when starting/resuming job chains
+	JobFactory       job.Factory       // job types listed by GET job-types if a job.TypeLister
+	Gates            *gate.Gates       // gate jobs approved by POST job-chains/{requestId}/jobs/{jobId}/approve
+	Capabilities     []string          // job chains with jobs that need other capabilities are rejected
+	Faults           *fault.Injector   // nil unless fault injection enabled (chaos testing)
+	ChainRepo        chain.Repo        // running chains listed by GET job-chains
+	Retainer         *chain.Retainer   // done chains listed by GET job-chains, and late stops
+	Profiler         *profile.Capturer // nil unless profiling enabled
 }
 
 // NewAPI creates a new API struct. It initializes an echo web server within the
@@ -66,6 +107,13 @@ func NewAPI(cfg Config) *API {
 		stat:             cfg.StatusManager,
 		shutdownChan:     cfg.ShutdownChan,
 		baseURL:          cfg.BaseURL,
+		jobFactory:       cfg.JobFactory,
+		gates:            cfg.Gates,
+		capabilities:     cfg.Capabilities,
+		faults:           cfg.Faults,
+		chainRepo:        cfg.ChainRepo,
+		retainer:         cfg.Retainer,
+		profiler:         cfg.Profiler,
 		// --
 		echo: echo.New(),
 	}
@@ -73,18 +121,43 @@ func NewAPI(cfg Config) *API {
 	// //////////////////////////////////////////////////////////////////////
 	// Routes
 	// //////////////////////////////////////////////////////////////////////
-	api.echo.POST(API_ROOT+"job-chains", api.newJobChainHandler)                 // start running new job chain
-	api.echo.POST(API_ROOT+"job-chains/resume", api.resumeJobChainHandler)       // resume suspended job chain
-	api.echo.PUT(API_ROOT+"job-chains/:requestId/stop", api.stopJobChainHandler) // stop job chain
-
-	api.echo.GET(API_ROOT+"status/running", api.statusRunningHandler) // return running jobs -> []proto.JobStatus
+	api.echo.POST(API_ROOT+"job-chains", api.newJobChainHandler)                               // start running new job chain
+	api.echo.POST(API_ROOT+"job-chains/resume", api.resumeJobChainHandler)                     // resume suspended job chain
+	api.echo.PUT(API_ROOT+"job-chains/:requestId/stop", api.stopJobChainHandler)               // stop job chain
+	api.echo.PUT(API_ROOT+"job-chains/:requestId/pause", api.pauseJobChainHandler)             // pause job chain: don't start new jobs
+	api.echo.PUT(API_ROOT+"job-chains/:requestId/resume", api.resumePausedJobChainHandler)     // resume paused job chain
+	api.echo.POST(API_ROOT+"job-chains/:requestId/jobs", api.addJobHandler)                    // add job to running job chain
+	api.echo.POST(API_ROOT+"job-chains/:requestId/jobs/:jobId/approve", api.approveJobHandler) // approve gate job waiting for approval
+	api.echo.GET(API_ROOT+"job-chains", api.listJobChainsHandler)                              // running and retained chains -> []proto.ChainStatus
+	api.echo.GET(API_ROOT+"job-chains/:requestId", api.getJobChainHandler)                     // running or retained chain -> proto.ChainStatus
+	api.echo.GET(API_ROOT+"job-chains/:requestId/snapshot", api.snapshotJobChainHandler)       // running chain -> proto.ChainSnapshot
+	api.echo.POST(API_ROOT+"job-chains/:requestId/profile", api.profileJobChainHandler)        // capture profiles while chain runs, sent to RM
+
+	api.echo.GET(API_ROOT+"status/running", api.statusRunningHandler)       // return running jobs -> []proto.JobStatus
+	api.echo.GET(API_ROOT+"status/scheduling", api.statusSchedulingHandler) // return scheduling latency -> proto.SchedulingStatus
+	api.echo.GET(API_ROOT+"status/debug", api.statusDebugHandler)           // return goroutine and runner accounting -> proto.RunnerDebug
+	api.echo.GET(API_ROOT+"job-types", api.jobTypesHandler)                 // return job types the factory can make -> []string
+	api.echo.GET(API_ROOT+"faults", api.getFaultsHandler)                   // return injected faults -> proto.Faults
+	api.echo.PUT(API_ROOT+"faults", api.setFaultsHandler)                   // set injected faults (chaos testing)
+	api.echo.GET("/metrics", api.metricsHandler)
 	api.echo.GET("/version", api.versionHandler)
 
+	// net/http/pprof, only if profiling enabled
+	if api.profiler != nil {
+		api.echo.GET("/debug/pprof/cmdline", echo.WrapHandler(http.HandlerFunc(pprof.Cmdline)))
+		api.echo.GET("/debug/pprof/profile", echo.WrapHandler(http.HandlerFunc(pprof.Profile)))
+		api.echo.GET("/debug/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+		api.echo.POST("/debug/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+		api.echo.GET("/debug/pprof/trace", echo.WrapHandler(http.HandlerFunc(pprof.Trace)))
+		api.echo.GET("/debug/pprof/*", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+	}
+
 	// //////////////////////////////////////////////////////////////////////
 	// Middleware and hooks
 	// //////////////////////////////////////////////////////////////////////
 	api.echo.Use(middleware.Recover())
 	api.echo.Use(middleware.Logger())
+	api.echo.Use(compress.Middleware()) // job chains and SJCs can be multi-MB
 
 	// Called before every route
 	api.echo.Use((func(next echo.HandlerFunc) echo.HandlerFunc {
@@ -97,15 +170,26 @@ func NewAPI(cfg Config) *API {
 	return api
 }
 
+func (api *API) Router() *echo.Echo {
+	return api.echo
+}
+
 // Run API server.
 func (api *API) Run() error {
-	var err error
-	if api.appCtx.Config.Server.TLS.CertFile != "" && api.appCtx.Config.Server.TLS.KeyFile != "" {
-		err = api.echo.StartTLS(api.appCtx.Config.Server.Addr, api.appCtx.Config.Server.TLS.CertFile, api.appCtx.Config.Server.TLS.KeyFile)
-	} else {
-		err = api.echo.Start(api.appCtx.Config.Server.Addr)
+	if api.appCtx.Config.Server.TLS.CertFile == "" || api.appCtx.Config.Server.TLS.KeyFile == "" {
+		return api.echo.Start(api.appCtx.Config.Server.Addr)
 	}
-	return err
+
+	// The server TLS config reloads the cert files when they change, and
+	// verifies client certs if server.tls.verify_client is true
+	tlsConfig, err := config.NewServerTLSConfig(api.appCtx.Config.Server.TLS)
+	if err != nil {
+		return fmt.Errorf("error loading server TLS config: %s", err)
+	}
+	s := api.echo.TLSServer
+	s.Addr = api.appCtx.Config.Server.Addr
+	s.TLSConfig = tlsConfig
+	return api.echo.StartServer(s)
 }
 
 // Stop stops the API when it's running. When Stop is called, Run returns
@@ -138,12 +222,23 @@ func (api *API) newJobChainHandler(c echo.Context) error {
 	default:
 	}
 
-	// Convert the payload into a proto.JobChain and validate.
+	// Convert the payload into a proto.JobChain and validate. Large job chains
+	// are streamed as NDJSON and read one job at a time, rejecting the chain
+	// on the first job that's not PENDING.
 	var jc proto.JobChain
-	if err := c.Bind(&jc); err != nil {
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), proto.CONTENT_TYPE_NDJSON) {
+		var err error
+		jc, err = proto.ReadJobChain(c.Request().Body, validate.NewJob)
+		if err != nil {
+			return handleError(chain.ErrInvalidChain{Message: "invalid job chain stream: " + err.Error()})
+		}
+	} else if err := c.Bind(&jc); err != nil {
 		return err
 	}
-	if err := chain.Validate(jc, true); err != nil {
+	if err := validate.Chain(jc, true); err != nil {
+		return handleError(err)
+	}
+	if err := validate.Capabilities(jc, api.capabilities); err != nil {
 		return handleError(err)
 	}
 
@@ -190,7 +285,10 @@ func (api *API) resumeJobChainHandler(c echo.Context) error {
 	if err := c.Bind(&sjc); err != nil {
 		return err
 	}
-	if err := chain.Validate(*sjc.JobChain, false); err != nil {
+	if err := validate.SJC(sjc); err != nil {
+		return handleError(err)
+	}
+	if err := validate.Capabilities(*sjc.JobChain, api.capabilities); err != nil {
 		return handleError(err)
 	}
 
@@ -225,9 +323,15 @@ func (api *API) resumeJobChainHandler(c echo.Context) error {
 func (api *API) stopJobChainHandler(c echo.Context) error {
 	requestId := c.Param("requestId")
 
-	// Get the traverser to the repo.
+	// Get the traverser to the repo. If the chain is done, there's nothing to
+	// stop: the request is finishing, or finished, in the RM.
 	val, exists := api.traverserRepo.Get(requestId)
 	if !exists {
+		if api.retainer != nil {
+			if _, done := api.retainer.Get(requestId); done {
+				return nil
+			}
+		}
 		return handleError(ErrTraverserNotFound)
 	}
 	traverser, ok := val.(chain.Traverser)
@@ -247,11 +351,217 @@ func (api *API) stopJobChainHandler(c echo.Context) error {
 	return nil
 }
 
+// PUT <API_ROOT>/job-chains/{requestId}/pause
+// Pause a running job chain: it doesn't start new jobs until resumed. Running
+// jobs keep running. The chain is not suspended.
+func (api *API) pauseJobChainHandler(c echo.Context) error {
+	traverser, err := api.getTraverser(c.Param("requestId"))
+	if err != nil {
+		return handleError(err)
+	}
+	if err := traverser.Pause(); err != nil {
+		return handleError(err)
+	}
+	return nil
+}
+
+// PUT <API_ROOT>/job-chains/{requestId}/resume
+// Resume a paused job chain. (POST job-chains/resume resumes a suspended one.)
+func (api *API) resumePausedJobChainHandler(c echo.Context) error {
+	traverser, err := api.getTraverser(c.Param("requestId"))
+	if err != nil {
+		return handleError(err)
+	}
+	if err := traverser.Resume(); err != nil {
+		return handleError(err)
+	}
+	return nil
+}
+
+// getTraverser gets the traverser for the request from the repo.
+func (api *API) getTraverser(requestId string) (chain.Traverser, error) {
+	val, exists := api.traverserRepo.Get(requestId)
+	if !exists {
+		return nil, ErrTraverserNotFound
+	}
+	traverser, ok := val.(chain.Traverser)
+	if !ok {
+		return nil, ErrInvalidTraverser
+	}
+	return traverser, nil
+}
+
+// POST <API_ROOT>/job-chains/{requestId}/jobs
+// Add a job to a running job chain. The payload is a proto.AddChainJob.
+func (api *API) addJobHandler(c echo.Context) error {
+	requestId := c.Param("requestId")
+
+	var aj proto.AddChainJob
+	if err := c.Bind(&aj); err != nil {
+		return err
+	}
+
+	// Get the traverser from the repo.
+	val, exists := api.traverserRepo.Get(requestId)
+	if !exists {
+		return handleError(ErrTraverserNotFound)
+	}
+	traverser, ok := val.(chain.Traverser)
+	if !ok {
+		return handleError(ErrInvalidTraverser)
+	}
+
+	if err := traverser.AddJob(aj.Job, aj.After); err != nil {
+		return handleError(err)
+	}
+
+	return nil
+}
+
+// POST <API_ROOT>/job-chains/{requestId}/jobs/{jobId}/approve
+// Approve a gate job (package gate) waiting for approval in a running job chain.
+// The payload is a proto.ApproveJob.
+func (api *API) approveJobHandler(c echo.Context) error {
+	requestId := c.Param("requestId")
+
+	var aj proto.ApproveJob
+	if err := c.Bind(&aj); err != nil {
+		return err
+	}
+
+	if _, err := api.getTraverser(requestId); err != nil {
+		return handleError(err)
+	}
+	if err := api.gates.Approve(requestId, c.Param("jobId"), aj.User); err != nil {
+		return handleError(err)
+	}
+
+	return nil
+}
+
+// GET <API_ROOT>/job-chains
+// Running chains and chains that are done but retained (config chain_retention),
+// sorted by request ID.
+func (api *API) listJobChainsHandler(c echo.Context) error {
+	all := []proto.ChainStatus{}
+	seen := map[string]bool{}
+	if api.retainer != nil {
+		for _, cs := range api.retainer.All() {
+			all = append(all, cs)
+			seen[cs.RequestId] = true
+		}
+	}
+	if api.chainRepo != nil {
+		chains, err := api.chainRepo.GetAll()
+		if err != nil {
+			return handleError(err)
+		}
+		for _, ch := range chains {
+			if seen[ch.RequestId()] {
+				continue // done and retained, but not removed yet
+			}
+			all = append(all, chainStatus(ch))
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].RequestId < all[j].RequestId })
+	return c.JSON(http.StatusOK, all)
+}
+
+// GET <API_ROOT>/job-chains/{requestId}
+// Chain if running or retained, else 404.
+func (api *API) getJobChainHandler(c echo.Context) error {
+	requestId := c.Param("requestId")
+	if api.retainer != nil {
+		if cs, ok := api.retainer.Get(requestId); ok {
+			return c.JSON(http.StatusOK, cs)
+		}
+	}
+	if api.chainRepo != nil {
+		ch, err := api.chainRepo.Get(requestId)
+		if err == nil {
+			return c.JSON(http.StatusOK, chainStatus(ch))
+		}
+		if err != chain.ErrNotFound {
+			return handleError(err)
+		}
+	}
+	return handleError(ErrTraverserNotFound)
+}
+
+// GET <API_ROOT>/job-chains/{requestId}/snapshot
+// Consistent snapshot of a running chain, else 404 (done chains are not kept).
+func (api *API) snapshotJobChainHandler(c echo.Context) error {
+	if api.chainRepo == nil {
+		return handleError(ErrTraverserNotFound)
+	}
+	ch, err := api.chainRepo.Get(c.Param("requestId"))
+	if err != nil {
+		if err == chain.ErrNotFound {
+			return handleError(ErrTraverserNotFound)
+		}
+		return handleError(err)
+	}
+	return c.JSON(http.StatusOK, ch.Snapshot())
+}
+
+func chainStatus(ch *chain.Chain) proto.ChainStatus {
+	state := ch.State()
+	if ch.Paused() {
+		state = proto.STATE_PAUSED
+	}
+	return proto.ChainStatus{
+		RequestId:    ch.RequestId(),
+		State:        state,
+		FinishedJobs: ch.FinishedJobs(),
+		Progress:     ch.Progress(),
+		Held:         ch.HeldJobs(),
+	}
+}
+
+// POST <API_ROOT>/job-chains/{requestId}/profile
+// Capture a CPU profile while the job chain runs, for the duration (default 30s)
+// or until the chain is done, then a heap profile, and send them to the RM to
+// attach to the request. The payload is a proto.ProfileCapture. It returns once
+// capturing has started. 501 if profiling is not enabled.
+func (api *API) profileJobChainHandler(c echo.Context) error {
+	if api.profiler == nil {
+		return handleError(ErrNoProfiling)
+	}
+	requestId := c.Param("requestId")
+
+	var pc proto.ProfileCapture
+	if err := c.Bind(&pc); err != nil {
+		return err
+	}
+	d := DEFAULT_PROFILE_DURATION
+	if pc.Duration != "" {
+		var err error
+		d, err = time.ParseDuration(pc.Duration)
+		if err != nil || d <= 0 || d > MAX_PROFILE_DURATION {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid duration %q: must be greater than zero and at most %s", pc.Duration, MAX_PROFILE_DURATION))
+		}
+	}
+
+	if _, err := api.getTraverser(requestId); err != nil {
+		return handleError(err)
+	}
+	running := func() bool {
+		_, ok := api.traverserRepo.Get(requestId)
+		return ok
+	}
+	if err := api.profiler.Capture(requestId, d, running); err != nil {
+		return handleError(err)
+	}
+	return nil
+}
+
 // GET <API_ROOT>/status/running
+// Query parameter held=true also returns held jobs (proto.JobStatus.Hold).
 func (api *API) statusRunningHandler(c echo.Context) error {
 	f := proto.StatusFilter{
 		RequestId: c.QueryParam("requestId"),
 		OrderBy:   c.QueryParam("orderBy"),
+		Held:      c.QueryParam("held") == "true",
 	}
 	jobs, err := api.stat.Running(f)
 	if err != nil {
@@ -260,6 +570,102 @@ func (api *API) statusRunningHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, jobs)
 }
 
+// GET <API_ROOT>/status/scheduling
+func (api *API) statusSchedulingHandler(c echo.Context) error {
+	f := proto.StatusFilter{
+		RequestId: c.QueryParam("requestId"),
+	}
+	status, err := api.stat.Scheduling(f)
+	if err != nil {
+		return handleError(err)
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// GET <API_ROOT>/status/debug
+// Goroutine and job runner accounting per running chain, to diagnose leaked
+// goroutines and stuck reapers. Optional query param requestId filters by chain.
+func (api *API) statusDebugHandler(c echo.Context) error {
+	f := proto.StatusFilter{
+		RequestId: c.QueryParam("requestId"),
+	}
+	debug, err := api.stat.Debug(f)
+	if err != nil {
+		return handleError(err)
+	}
+	return c.JSON(http.StatusOK, debug)
+}
+
+// GET <API_ROOT>/job-types
+// Job types that the job factory can make, sorted, if it's a job.TypeLister,
+// else 501. The RM checks job chains against them before sending them.
+func (api *API) jobTypesHandler(c echo.Context) error {
+	tl, ok := api.jobFactory.(job.TypeLister)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "job factory does not list job types")
+	}
+	types := append([]string{}, tl.Types()...) // copy to sort
+	sort.Strings(types)
+	return c.JSON(http.StatusOK, types)
+}
+
+// GET <API_ROOT>/faults
+// Faults being injected, if fault injection is enabled, else 501.
+func (api *API) getFaultsHandler(c echo.Context) error {
+	if api.faults == nil {
+		return handleError(ErrNoFaultInjection)
+	}
+	return c.JSON(http.StatusOK, api.faults.Get())
+}
+
+// PUT <API_ROOT>/faults
+// Set faults to inject (proto.Faults), replacing the previous faults. An empty
+// proto.Faults stops injecting failures. 501 if fault injection is not enabled.
+func (api *API) setFaultsHandler(c echo.Context) error {
+	if api.faults == nil {
+		return handleError(ErrNoFaultInjection)
+	}
+	var f proto.Faults
+	if err := c.Bind(&f); err != nil {
+		return err
+	}
+	if err := api.faults.Set(f); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, api.faults.Get())
+}
+
+// GET /metrics
+// Scheduling latency metrics in Prometheus text format. Wait times are seconds.
+// If the metrics plugin is Prometheus, its metrics are included.
+func (api *API) metricsHandler(c echo.Context) error {
+	status, err := api.stat.Scheduling(proto.StatusFilter{})
+	if err != nil {
+		return handleError(err)
+	}
+	var buf bytes.Buffer
+	metric := func(name, help, typ string, jrVal float64, chainVal func(proto.SchedulingStats) float64) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		fmt.Fprintf(&buf, "%s %g\n", name, jrVal)
+		for _, ss := range status.Chains {
+			fmt.Fprintf(&buf, "%s{request_id=%q} %g\n", name, ss.RequestId, chainVal(ss))
+		}
+	}
+	jr := status.JobRunner
+	metric("spincycle_jr_jobs_queued", "Runnable jobs waiting for a runner.", "gauge",
+		float64(jr.Queued), func(ss proto.SchedulingStats) float64 { return float64(ss.Queued) })
+	metric("spincycle_jr_jobs_started", "Jobs picked up by a runner.", "gauge",
+		float64(jr.Started), func(ss proto.SchedulingStats) float64 { return float64(ss.Started) })
+	metric("spincycle_jr_scheduling_wait_seconds_avg", "Average time jobs waited for a runner.", "gauge",
+		seconds(jr.WaitAvg), func(ss proto.SchedulingStats) float64 { return seconds(ss.WaitAvg) })
+	metric("spincycle_jr_scheduling_wait_seconds_max", "Longest time a job waited for a runner.", "gauge",
+		seconds(jr.WaitMax), func(ss proto.SchedulingStats) float64 { return seconds(ss.WaitMax) })
+	if p, ok := api.appCtx.Plugins.Metrics.(*metrics.Prometheus); ok {
+		p.Write(&buf) // jobs run and chains running (Plugins.Metrics)
+	}
+	return c.Blob(http.StatusOK, "text/plain; version=0.0.4", buf.Bytes())
+}
+
 func (api *API) versionHandler(c echo.Context) error {
 	return c.String(http.StatusOK, v.Version())
 }
@@ -270,18 +676,29 @@ func (api *API) chainLocation(requestId string) string {
 	return api.baseURL + API_ROOT + "job-chains/" + requestId
 }
 
+func seconds(ns int64) float64 {
+	return time.Duration(ns).Seconds()
+}
+
 func handleError(err error) *echo.HTTPError {
 	switch err.(type) {
 	case chain.ErrInvalidChain:
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case serr.RequestNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	default:
 		switch err {
-		case ErrTraverserNotFound:
+		case ErrTraverserNotFound, chain.ErrNotRunning, chain.ErrNotPausable:
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
-		case ErrDuplicateTraverser:
+		case gate.ErrNotWaiting:
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
-		case ErrShuttingDown:
+		case ErrDuplicateTraverser:
+			// Not 400 so the RM knows the job chain was already sent
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		case ErrShuttingDown, profile.ErrBusy:
 			return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+		case ErrNoFaultInjection, ErrNoProfiling:
+			return echo.NewHTTPError(http.StatusNotImplemented, err.Error())
 		default:
 			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
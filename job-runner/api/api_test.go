@@ -7,13 +7,22 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-test/deep"
 	"github.com/orcaman/concurrent-map"
 
+	"github.com/square/spincycle/v2/gate"
+	"github.com/square/spincycle/v2/job"
+	jr "github.com/square/spincycle/v2/job-runner"
 	"github.com/square/spincycle/v2/job-runner/api"
 	"github.com/square/spincycle/v2/job-runner/app"
 	"github.com/square/spincycle/v2/job-runner/chain"
+	"github.com/square/spincycle/v2/job-runner/fault"
+	"github.com/square/spincycle/v2/job-runner/profile"
+	"github.com/square/spincycle/v2/job-runner/status"
 	"github.com/square/spincycle/v2/proto"
 	testutil "github.com/square/spincycle/v2/test"
 	"github.com/square/spincycle/v2/test/mock"
@@ -133,8 +142,8 @@ func TestNewJobChainMultipleTraverser(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if statusCode != http.StatusBadRequest {
-		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	if statusCode != http.StatusConflict {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusConflict)
 	}
 }
 
@@ -209,6 +218,87 @@ func TestNewJobChainSuccess(t *testing.T) {
 	}
 }
 
+func TestNewJobChainCapabilities(t *testing.T) {
+	traverserRepo = cmap.New()
+	server = httptest.NewServer(api.NewAPI(api.Config{
+		AppCtx:           app.Defaults(),
+		TraverserFactory: &mock.TraverserFactory{},
+		TraverserRepo:    traverserRepo,
+		StatusManager:    &mock.JRStatus{},
+		ShutdownChan:     make(chan struct{}),
+		Capabilities:     []string{"docker"},
+	}))
+	defer cleanup()
+
+	jobs := testutil.InitJobs(2)
+	job := jobs["job2"]
+	job.Needs = []string{"docker", "gpu"}
+	jobs["job2"] = job
+	jobChain := proto.JobChain{
+		RequestId:     "abc",
+		Jobs:          jobs,
+		AdjacencyList: map[string][]string{"job1": {"job2"}},
+	}
+	payload, _ := json.Marshal(jobChain)
+	statusCode, _, err := testutil.MakeHTTPRequest("POST", baseURL()+"job-chains", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+
+	job.Needs = []string{"docker"}
+	jobChain.Jobs["job2"] = job
+	payload, _ = json.Marshal(jobChain)
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"job-chains", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+}
+
+// Test a job chain streamed by the JR client.
+func TestNewJobChainStream(t *testing.T) {
+	var gotChain proto.JobChain
+	tf := &mock.TraverserFactory{
+		MakeFunc: func(jc *proto.JobChain) (chain.Traverser, error) {
+			gotChain = *jc
+			return &mock.Traverser{}, nil
+		},
+	}
+	setup(tf)
+	defer cleanup()
+
+	jobChain := proto.JobChain{
+		RequestId: "abc",
+		Jobs:      testutil.InitJobs(3),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+			"job2": {"job3"},
+		},
+	}
+	jrc := jr.NewStreamingClient(&http.Client{}, 1)
+	if _, err := jrc.NewJobChain(server.URL, jobChain); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(gotChain, jobChain); diff != nil {
+		t.Error(diff)
+	}
+
+	// Invalid job is rejected when read
+	job := jobChain.Jobs["job2"]
+	job.State = proto.STATE_COMPLETE
+	jobChain.Jobs["job2"] = job
+	jobChain.RequestId = "def"
+	_, err := jrc.NewJobChain(server.URL, jobChain)
+	if _, ok := err.(jr.ErrChainRejected); !ok {
+		t.Errorf("got error %v, expected jr.ErrChainRejected (400 status code)", err)
+	}
+}
+
 // Test successfully resuming a job chain.
 func TestResumeJobChainSuccess(t *testing.T) {
 	requestId := "abc"
@@ -367,8 +457,8 @@ func TestResumeJobChainMultipleTraverser(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if statusCode != http.StatusBadRequest {
-		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	if statusCode != http.StatusConflict {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusConflict)
 	}
 }
 
@@ -428,6 +518,159 @@ func TestStopJobChainHandlerSuccess(t *testing.T) {
 	}
 }
 
+func TestPauseJobChainHandler(t *testing.T) {
+	requestId := "abcd1234"
+	setup(&mock.TraverserFactory{})
+	defer cleanup()
+
+	// Not running
+	statusCode, _, err := testutil.MakeHTTPRequest("PUT", baseURL()+"job-chains/"+requestId+"/pause", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+
+	trav := &mock.Traverser{}
+	traverserRepo.Set(requestId, trav)
+	for _, op := range []string{"pause", "resume"} {
+		statusCode, _, err := testutil.MakeHTTPRequest("PUT", baseURL()+"job-chains/"+requestId+"/"+op, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statusCode != http.StatusOK {
+			t.Errorf("%s: response status = %d, expected %d", op, statusCode, http.StatusOK)
+		}
+	}
+
+	// Chain done, stopped, or suspended
+	trav.ResumeErr = chain.ErrNotPausable
+	statusCode, _, err = testutil.MakeHTTPRequest("PUT", baseURL()+"job-chains/"+requestId+"/resume", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+}
+
+func TestAddJobHandler(t *testing.T) {
+	requestId := "abcd1234"
+	setup(&mock.TraverserFactory{})
+	defer cleanup()
+
+	// No traverser for the request
+	payload, _ := json.Marshal(proto.AddChainJob{After: "job1", Job: proto.Job{Id: "job9", Type: "diag"}})
+	statusCode, _, err := testutil.MakeHTTPRequest("POST", baseURL()+"job-chains/"+requestId+"/jobs", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+
+	var gotJob proto.Job
+	var gotAfter string
+	trav := &mock.Traverser{
+		AddJobFunc: func(job proto.Job, after string) error {
+			gotJob = job
+			gotAfter = after
+			if after == "job4" {
+				return chain.ErrInvalidChain{Message: "job job4 is the last job in the job chain"}
+			}
+			return nil
+		},
+	}
+	traverserRepo.Set(requestId, trav)
+
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"job-chains/"+requestId+"/jobs", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if gotJob.Id != "job9" || gotAfter != "job1" {
+		t.Errorf("added job %s after %s, expected job9 after job1", gotJob.Id, gotAfter)
+	}
+
+	// Job cannot be added
+	payload, _ = json.Marshal(proto.AddChainJob{After: "job4", Job: proto.Job{Id: "job9", Type: "diag"}})
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"job-chains/"+requestId+"/jobs", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+}
+
+func TestApproveJobHandler(t *testing.T) {
+	requestId := "abcd1234"
+	traverserRepo = cmap.New()
+	appCtx := app.Defaults()
+	gates := gate.NewGates()
+	server = httptest.NewServer(api.NewAPI(api.Config{
+		AppCtx:           appCtx,
+		TraverserFactory: &mock.TraverserFactory{},
+		TraverserRepo:    traverserRepo,
+		StatusManager:    &mock.JRStatus{},
+		ShutdownChan:     make(chan struct{}),
+		Gates:            gates,
+	}))
+	defer cleanup()
+
+	// No traverser for the request
+	payload, _ := json.Marshal(proto.ApproveJob{User: "finch"})
+	statusCode, _, err := testutil.MakeHTTPRequest("POST", baseURL()+"job-chains/"+requestId+"/jobs/job1/approve", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+
+	// Job not waiting for approval
+	traverserRepo.Set(requestId, &mock.Traverser{})
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"job-chains/"+requestId+"/jobs/job1/approve", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+
+	// Gate job waiting for approval
+	j, err := gate.NewFactory(&mock.JobFactory{}, gates).Make(job.NewIdWithRequestId(gate.JOB_TYPE, "approve", "job1", requestId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	retChan := make(chan job.Return, 1)
+	go func() {
+		ret, _ := j.Run(map[string]interface{}{})
+		retChan <- ret
+	}()
+	for i := 0; i < 100 && !gates.Waiting(requestId, "job1"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"job-chains/"+requestId+"/jobs/job1/approve", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	select {
+	case ret := <-retChan:
+		if ret.State != proto.STATE_COMPLETE || ret.Stdout != "approved by finch\n" {
+			t.Errorf("got state %s stdout %q, expected COMPLETE approved by finch", proto.StateName[ret.State], ret.Stdout)
+		}
+	case <-time.After(time.Second):
+		t.Error("gate job not approved")
+	}
+}
+
 func TestGetVersion(t *testing.T) {
 	setup(&mock.TraverserFactory{})
 	defer cleanup()
@@ -449,3 +692,383 @@ func TestGetVersion(t *testing.T) {
 		t.Errorf("got version '%s', expected '%s'", gotVersion, expectVersion)
 	}
 }
+
+func TestMetrics(t *testing.T) {
+	// Metrics come from the real status manager, which gets scheduling
+	// stats from the traversers
+	traverserRepo = cmap.New()
+	traverserRepo.Set("req1", &mock.Traverser{
+		SchedStats: proto.SchedulingStats{RequestId: "req1", Queued: 2, Started: 4, WaitTotal: 4e9, WaitAvg: 1e9, WaitMax: 3e9},
+	})
+	server = httptest.NewServer(api.NewAPI(api.Config{
+		AppCtx:           app.Defaults(),
+		TraverserFactory: &mock.TraverserFactory{},
+		TraverserRepo:    traverserRepo,
+		StatusManager:    status.NewManager(traverserRepo),
+		ShutdownChan:     make(chan struct{}),
+	}))
+	defer cleanup()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", resp.StatusCode, http.StatusOK)
+	}
+	for _, line := range []string{
+		"spincycle_jr_jobs_queued 2",
+		`spincycle_jr_jobs_queued{request_id="req1"} 2`,
+		"spincycle_jr_scheduling_wait_seconds_max 3",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("metric %q not reported:\n%s", line, body)
+		}
+	}
+}
+
+// typeLister is a job factory that lists its job types (job.TypeLister).
+type typeLister struct {
+	mock.JobFactory
+	types []string
+}
+
+func (f *typeLister) Types() []string {
+	return f.types
+}
+
+func TestJobTypes(t *testing.T) {
+	jobTypes := func(jf job.Factory, types *[]string) int {
+		server = httptest.NewServer(api.NewAPI(api.Config{
+			AppCtx:           app.Defaults(),
+			TraverserFactory: &mock.TraverserFactory{},
+			TraverserRepo:    cmap.New(),
+			StatusManager:    &mock.JRStatus{},
+			ShutdownChan:     make(chan struct{}),
+			JobFactory:       jf,
+		}))
+		defer cleanup()
+		var respStruct interface{}
+		if types != nil {
+			respStruct = types
+		}
+		statusCode, _, err := testutil.MakeHTTPRequest("GET", baseURL()+"job-types", nil, respStruct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return statusCode
+	}
+
+	// Sorted, and the factory's list is not modified
+	jf := &typeLister{types: []string{"shell-command", "noop", "sleep"}}
+	var types []string
+	statusCode := jobTypes(jf, &types)
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if diff := deep.Equal(types, []string{"noop", "shell-command", "sleep"}); diff != nil {
+		t.Error(diff)
+	}
+	if jf.types[0] != "shell-command" {
+		t.Errorf("factory job types sorted in place: %v", jf.types)
+	}
+
+	// Factory does not list job types
+	statusCode = jobTypes(&mock.JobFactory{}, nil)
+	if statusCode != http.StatusNotImplemented {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotImplemented)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	faults := func(injector *fault.Injector, method string, payload []byte) (int, proto.Faults) {
+		server = httptest.NewServer(api.NewAPI(api.Config{
+			AppCtx:           app.Defaults(),
+			TraverserFactory: &mock.TraverserFactory{},
+			TraverserRepo:    cmap.New(),
+			StatusManager:    &mock.JRStatus{},
+			ShutdownChan:     make(chan struct{}),
+			Faults:           injector,
+		}))
+		defer cleanup()
+		var f proto.Faults
+		var respStruct interface{}
+		if injector != nil {
+			respStruct = &f
+		}
+		statusCode, _, err := testutil.MakeHTTPRequest(method, baseURL()+"faults", payload, respStruct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return statusCode, f
+	}
+
+	// Fault injection not enabled
+	statusCode, _ := faults(nil, "PUT", []byte(`{"dropRMCalls":1}`))
+	if statusCode != http.StatusNotImplemented {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotImplemented)
+	}
+
+	injector := fault.NewInjector()
+	statusCode, f := faults(injector, "PUT", []byte(`{"failJobTypes":["shell-command"],"dropRMCalls":0.5}`))
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	expect := proto.Faults{FailJobTypes: []string{"shell-command"}, DropRMCalls: 0.5}
+	if diff := deep.Equal(f, expect); diff != nil {
+		t.Error(diff)
+	}
+	statusCode, f = faults(injector, "GET", nil)
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if diff := deep.Equal(f, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	requestId := "abcd1234"
+	sent := make(chan proto.Profile, 2)
+	rmc := &mock.RMClient{
+		AttachProfileFunc: func(reqId string, p proto.Profile) error {
+			sent <- p
+			return nil
+		},
+	}
+	repo := cmap.New()
+	newServer := func(profiler *profile.Capturer) {
+		server = httptest.NewServer(api.NewAPI(api.Config{
+			AppCtx:           app.Defaults(),
+			TraverserFactory: &mock.TraverserFactory{},
+			TraverserRepo:    repo,
+			StatusManager:    &mock.JRStatus{},
+			ShutdownChan:     make(chan struct{}),
+			Profiler:         profiler,
+		}))
+	}
+
+	// net/http/pprof endpoints, except profile and trace which take seconds
+	pprofEndpoints := []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/debug/pprof/"},
+		{"GET", "/debug/pprof/heap"},
+		{"GET", "/debug/pprof/goroutine"},
+		{"GET", "/debug/pprof/cmdline"},
+		{"GET", "/debug/pprof/symbol"},
+		{"POST", "/debug/pprof/symbol"},
+	}
+
+	// Profiling not enabled: no profile endpoint or pprof endpoints
+	newServer(nil)
+	statusCode, _, err := testutil.MakeHTTPRequest("POST", baseURL()+"job-chains/"+requestId+"/profile", []byte(`{}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotImplemented {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotImplemented)
+	}
+	for _, e := range pprofEndpoints {
+		statusCode, _, err = testutil.MakeHTTPRequest(e.method, server.URL+e.path, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statusCode != http.StatusNotFound {
+			t.Errorf("%s %s: response status = %d, expected %d", e.method, e.path, statusCode, http.StatusNotFound)
+		}
+	}
+	cleanup()
+
+	newServer(profile.NewCapturer(rmc))
+	defer cleanup()
+	for _, e := range pprofEndpoints {
+		statusCode, _, err = testutil.MakeHTTPRequest(e.method, server.URL+e.path, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statusCode != http.StatusOK {
+			t.Errorf("%s %s: response status = %d, expected %d", e.method, e.path, statusCode, http.StatusOK)
+		}
+	}
+
+	// Chain not running
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"job-chains/"+requestId+"/profile", []byte(`{}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+
+	repo.Set(requestId, &mock.Traverser{})
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"job-chains/"+requestId+"/profile", []byte(`{"duration":"1h"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"job-chains/"+requestId+"/profile", []byte(`{"duration":"100ms"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	for _, expect := range []string{proto.PROFILE_CPU, proto.PROFILE_HEAP} {
+		select {
+		case p := <-sent:
+			if p.Type != expect || p.RequestId != requestId || len(p.Data) == 0 {
+				t.Errorf("got %s profile for request %s (%d bytes), expected %s profile with data", p.Type, p.RequestId, len(p.Data), expect)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %s profile", expect)
+		}
+	}
+}
+
+func TestJobChains(t *testing.T) {
+	chainRepo := chain.NewMemoryRepo()
+	retainer := chain.NewRetainer(time.Minute, nil)
+	server = httptest.NewServer(api.NewAPI(api.Config{
+		AppCtx:           app.Defaults(),
+		TraverserFactory: &mock.TraverserFactory{},
+		TraverserRepo:    cmap.New(),
+		StatusManager:    &mock.JRStatus{},
+		ShutdownChan:     make(chan struct{}),
+		ChainRepo:        chainRepo,
+		Retainer:         retainer,
+	}))
+	defer cleanup()
+
+	running := chain.NewChain(&proto.JobChain{RequestId: "req2", Jobs: testutil.InitJobs(1)}, map[string]uint{}, map[string]uint{}, map[string]uint{})
+	running.SetState(proto.STATE_RUNNING)
+	chainRepo.Add(running)
+	done := chain.NewChain(&proto.JobChain{RequestId: "req1", Jobs: testutil.InitJobs(1)}, map[string]uint{}, map[string]uint{}, map[string]uint{})
+	done.SetState(proto.STATE_FAIL)
+	retainer.Add(done)
+
+	var all []proto.ChainStatus
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", baseURL()+"job-chains", nil, &all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if len(all) != 2 || all[0].RequestId != "req1" || all[0].State != proto.STATE_FAIL || all[1].RequestId != "req2" || all[1].State != proto.STATE_RUNNING {
+		t.Errorf("got %+v, expected req1 (FAIL) and req2 (RUNNING)", all)
+	}
+
+	var cs proto.ChainStatus
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", baseURL()+"job-chains/req1", nil, &cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if cs.RequestId != "req1" || cs.DoneAt == 0 {
+		t.Errorf("got %+v, expected retained req1", cs)
+	}
+
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", baseURL()+"job-chains/req3", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+
+	// Stopping a retained chain is not an error: it's already done
+	statusCode, _, err = testutil.MakeHTTPRequest("PUT", baseURL()+"job-chains/req1/stop", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+}
+
+func TestJobChainSnapshot(t *testing.T) {
+	chainRepo := chain.NewMemoryRepo()
+	server = httptest.NewServer(api.NewAPI(api.Config{
+		AppCtx:           app.Defaults(),
+		TraverserFactory: &mock.TraverserFactory{},
+		TraverserRepo:    cmap.New(),
+		StatusManager:    &mock.JRStatus{},
+		ShutdownChan:     make(chan struct{}),
+		ChainRepo:        chainRepo,
+	}))
+	defer cleanup()
+
+	jc := &proto.JobChain{
+		RequestId:     "req1",
+		Jobs:          testutil.InitJobs(2),
+		AdjacencyList: map[string][]string{"job1": {"job2"}},
+	}
+	running := chain.NewChain(jc, map[string]uint{}, map[string]uint{}, map[string]uint{})
+	running.SetState(proto.STATE_RUNNING)
+	running.SetJobState("job1", proto.STATE_RUNNING)
+	running.IncrementJobTries("job1", 1)
+	chainRepo.Add(running)
+
+	var snap proto.ChainSnapshot
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", baseURL()+"job-chains/req1/snapshot", nil, &snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if snap.RequestId != "req1" || snap.State != proto.STATE_RUNNING || snap.Version == 0 || len(snap.Jobs) != 2 {
+		t.Fatalf("got %+v, expected running req1 with 2 jobs", snap)
+	}
+	if snap.Jobs[0].Id != "job1" || snap.Jobs[0].State != proto.STATE_RUNNING || snap.Jobs[0].Tries != 1 {
+		t.Errorf("got job %+v, expected job1 running on try 1", snap.Jobs[0])
+	}
+
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", baseURL()+"job-chains/req2/snapshot", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+}
+
+func TestJobChainPaused(t *testing.T) {
+	chainRepo := chain.NewMemoryRepo()
+	server = httptest.NewServer(api.NewAPI(api.Config{
+		AppCtx:           app.Defaults(),
+		TraverserFactory: &mock.TraverserFactory{},
+		TraverserRepo:    cmap.New(),
+		StatusManager:    &mock.JRStatus{},
+		ShutdownChan:     make(chan struct{}),
+		ChainRepo:        chainRepo,
+	}))
+	defer cleanup()
+
+	paused := chain.NewChain(&proto.JobChain{RequestId: "req1", Jobs: testutil.InitJobs(1)}, map[string]uint{}, map[string]uint{}, map[string]uint{})
+	paused.SetState(proto.STATE_RUNNING)
+	paused.SetPaused(true)
+	chainRepo.Add(paused)
+
+	var cs proto.ChainStatus
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", baseURL()+"job-chains/req1", nil, &cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if cs.State != proto.STATE_PAUSED {
+		t.Errorf("got state %s, expected PAUSED", proto.StateName[cs.State])
+	}
+}
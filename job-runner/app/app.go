@@ -7,13 +7,18 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/square/spincycle/v2/compress"
 	"github.com/square/spincycle/v2/config"
+	"github.com/square/spincycle/v2/job-runner/chain"
+	"github.com/square/spincycle/v2/job-runner/runner"
+	"github.com/square/spincycle/v2/metrics"
 	"github.com/square/spincycle/v2/request-manager"
 )
 
 type Context struct {
 	Hooks     Hooks
 	Factories Factories
+	Plugins   Plugins
 
 	Config config.JobRunner
 }
@@ -45,6 +50,25 @@ type Hooks struct {
 	ServerURL func(Context) (string, error)
 }
 
+// Plugins allow users to provide custom components. All plugins are optional;
+// the defaults are sufficient to run the Job Runner.
+type Plugins struct {
+	// TokenProvider provides delegated tokens for jobs that make downstream
+	// calls as the user who made the request (job.Authenticated). If nil,
+	// these jobs only get the user, no token.
+	TokenProvider runner.TokenProvider
+
+	// Metrics reports jobs run and chains running. The default, metrics.Nop,
+	// reports nothing. If it's a *metrics.Prometheus, its metrics are also
+	// returned by GET /metrics.
+	Metrics metrics.Metrics
+
+	// MakeJobQueue makes the queue of runnable jobs of each job chain, which
+	// decides the order in which they're run, like a priority heap. If nil,
+	// jobs run in the order they become runnable (chain.NewFIFOQueue).
+	MakeJobQueue chain.QueueFactory
+}
+
 func Defaults() Context {
 	return Context{
 		Factories: Factories{
@@ -54,6 +78,9 @@ func Defaults() Context {
 			LoadConfig: LoadConfig,
 			ServerURL:  ServerURL,
 		},
+		Plugins: Plugins{
+			Metrics: metrics.Nop{},
+		},
 	}
 }
 
@@ -89,12 +116,8 @@ func ServerURL(appCtx Context) (string, error) {
 func MakeRequestManagerClient(appCtx Context) (rm.Client, error) {
 	cfg := appCtx.Config
 	httpClient := &http.Client{}
-	if cfg.RMClient.TLS.CertFile != "" && cfg.RMClient.TLS.KeyFile != "" && cfg.RMClient.TLS.CAFile != "" {
-		tlsConfig, err := config.NewTLSConfig(
-			cfg.RMClient.TLS.CAFile,
-			cfg.RMClient.TLS.CertFile,
-			cfg.RMClient.TLS.KeyFile,
-		)
+	if cfg.RMClient.TLS.CAFile != "" {
+		tlsConfig, err := config.NewClientTLSConfig(cfg.RMClient.TLS)
 		if err != nil {
 			return nil, fmt.Errorf("error loading RM client TLS config: %s", err)
 		}
@@ -102,6 +125,13 @@ func MakeRequestManagerClient(appCtx Context) (rm.Client, error) {
 			Transport: &http.Transport{TLSClientConfig: tlsConfig},
 		}
 	}
+	if cfg.RMClient.Compression != "" {
+		codec, err := compress.Get(cfg.RMClient.Compression)
+		if err != nil {
+			return nil, fmt.Errorf("error loading RM client compression: %s", err)
+		}
+		httpClient.Transport = &compress.Transport{Base: httpClient.Transport, Codec: codec}
+	}
 	rmc := rm.NewClient(httpClient, cfg.RMClient.ServerURL)
 	return rmc, nil
 }
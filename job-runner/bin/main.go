@@ -1,19 +1,62 @@
-// Copyright 2017-2018, Square, Inc.
+// Copyright 2017-2020, Square, Inc.
 
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
 
+	"github.com/square/spincycle/v2/daemon"
+	"github.com/square/spincycle/v2/job-runner/api"
 	"github.com/square/spincycle/v2/job-runner/app"
 	"github.com/square/spincycle/v2/job-runner/server"
+	"github.com/square/spincycle/v2/version"
 )
 
 func main() {
-	s := server.NewServer(app.Defaults())
-	if err := s.Boot(); err != nil {
-		log.Fatalf("Error starting Job Runner: %s", err)
-	}
-	err := s.Run(true)
-	log.Fatalf("Job Runner stopped: %s", err)
+	daemon.Main("job-runner", []daemon.Command{
+		{
+			Name: "run",
+			Args: "[config file]",
+			Desc: "Run the Job Runner",
+			Run: func(args []string) error {
+				s := server.NewServer(app.Defaults())
+				if err := s.Boot(); err != nil {
+					return fmt.Errorf("Error starting Job Runner: %s", err)
+				}
+				err := s.Run(true)
+				return fmt.Errorf("Job Runner stopped: %s", err)
+			},
+		},
+		{
+			Name: "validate-config",
+			Args: "[config file]",
+			Desc: "Validate config, check that addresses are reachable, and exit",
+			Run: func(args []string) error {
+				s := server.NewServer(app.Defaults())
+				if err := s.Validate(); err != nil {
+					return fmt.Errorf("Job Runner config is not valid: %s", err)
+				}
+				log.Printf("Job Runner config is valid")
+				return nil
+			},
+		},
+		{
+			Name: "dump-routes",
+			Desc: "Print API routes, and exit",
+			Run: func(args []string) error {
+				daemon.PrintRoutes(os.Stdout, api.NewAPI(api.Config{AppCtx: app.Defaults()}).Router().Routes())
+				return nil
+			},
+		},
+		{
+			Name: "version",
+			Desc: "Print version, and exit",
+			Run: func(args []string) error {
+				fmt.Println("job-runner " + version.Version())
+				return nil
+			},
+		},
+	})
 }
@@ -6,7 +6,10 @@ package chain
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/square/spincycle/v2/proto"
 )
@@ -22,16 +25,44 @@ type Chain struct {
 	sequenceTries     map[string]uint // Number of sequence retries attempted so far
 	latestRunJobTries map[string]uint // job.Id -> number of times tried for current sequence try
 	totalJobTries     map[string]uint // job.Id -> total number of times tried
+
+	// job.Id -> copy of job.Data before the first sequence try, restored on
+	// sequence retry. Guarded by jobsMux.
+	jobData map[string]map[string]interface{}
+
+	// job.Id -> copy of job.Data when the job started running, saved in a
+	// checkpoint instead of job.Data, which the job changes while it runs.
+	// Guarded by jobsMux.
+	runData map[string]map[string]interface{}
+
+	paused bool // traverser not starting new jobs, guarded by jobsMux
+
+	progress uint // highest Progress returned, guarded by jobsMux
+
+	// job.Id -> why the runnable job is not running yet. Guarded by jobsMux.
+	holds map[string]jobHold
+
+	// Snapshot version, incremented (atomically) by every change to the chain
+	// state, job states, tries, or holds while holding the lock that guards it.
+	version uint64
+}
+
+type jobHold struct {
+	hold   string // proto.HOLD_* const
+	detail string
+	since  time.Time
 }
 
 // NewChain takes a JobChain proto and maps of sequence + jobs tries, and turns them
 // into a Chain that the JR can use.
 func NewChain(jc *proto.JobChain, sequenceTries map[string]uint, totalJobTries map[string]uint, latestRunJobTries map[string]uint) *Chain {
+	jobData := make(map[string]map[string]interface{}, len(jc.Jobs))
 	for jobName, job := range jc.Jobs {
 		if job.Data == nil {
 			job.Data = map[string]interface{}{}
 		}
 		jc.Jobs[jobName] = job
+		jobData[job.Id] = copyData(job.Data)
 	}
 	return &Chain{
 		jobsMux:           &sync.RWMutex{},
@@ -40,6 +71,9 @@ func NewChain(jc *proto.JobChain, sequenceTries map[string]uint, totalJobTries m
 		triesMux:          &sync.RWMutex{},
 		totalJobTries:     totalJobTries,
 		latestRunJobTries: latestRunJobTries,
+		jobData:           jobData,
+		runData:           map[string]map[string]interface{}{},
+		holds:             map[string]jobHold{},
 	}
 }
 
@@ -60,7 +94,8 @@ func (c *Chain) NextJobs(jobId string) proto.Jobs {
 }
 
 // IsRunnable returns true if the job is runnable. A job is runnable iff its
-// state is PENDING and all immediately previous jobs are state COMPLETE.
+// state is PENDING and all immediately previous jobs are state COMPLETE. A finally
+// job is also runnable when its sequence is done but failed (see FinallyJobs).
 func (c *Chain) IsRunnable(jobId string) bool {
 	c.jobsMux.RLock()
 	defer c.jobsMux.RUnlock()
@@ -143,6 +178,27 @@ func (c *Chain) IsDoneRunning() (done bool, complete bool) {
 	return true, complete
 }
 
+// FinallyJobs returns the finally jobs (proto.Job.Finally) that are ready to run.
+// Finally jobs run after the other jobs in their sequence, even if those jobs
+// failed or stopped: a pending finally job is ready to run when its sequence
+// started and no job between the sequence start job and the finally job is
+// running or will run, including previous finally jobs. If stopped is true, the
+// chain was stopped, so pending jobs that are not finally jobs will not run.
+func (c *Chain) FinallyJobs(stopped bool) proto.Jobs {
+	c.jobsMux.RLock()
+	defer c.jobsMux.RUnlock()
+	var jobs proto.Jobs
+	for _, job := range c.jobChain.Jobs {
+		if !job.Finally || job.State != proto.STATE_PENDING {
+			continue
+		}
+		if !stopped && c.isRunnable(job.Id) || stopped && c.finallyReady(job, true) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
 // FailedJobs returns the number of failed jobs. This is used by reapers to
 // determine if a chain failed, or if it can be finalized as stopped or suspended.
 func (c *Chain) FailedJobs() uint {
@@ -183,6 +239,7 @@ func (c *Chain) IncrementJobTries(jobId string, delta int) {
 		// that's monotonically increasing across all sequence retries.
 		c.totalJobTries[jobId] += uint(delta)
 	}
+	atomic.AddUint64(&c.version, 1)
 	// Job count wrt current sequence try can reset to zero
 	cur := int(c.latestRunJobTries[jobId])
 	if cur+delta < 0 { // shouldn't happen
@@ -205,6 +262,7 @@ func (c *Chain) IncrementSequenceTries(jobId string, delta int) {
 	c.triesMux.Lock()
 	cur := int(c.sequenceTries[seqId])
 	c.sequenceTries[seqId] = uint(cur + delta)
+	atomic.AddUint64(&c.version, 1)
 	c.triesMux.Unlock()
 }
 
@@ -229,6 +287,7 @@ func (c *Chain) IncrementFinishedJobs(delta int) {
 		panic(fmt.Sprintf("IncrementFinishedJobs cur %d + delta %d < 0", cur, delta))
 	}
 	c.jobChain.FinishedJobs = uint(cur + delta)
+	atomic.AddUint64(&c.version, 1)
 	return
 }
 
@@ -238,6 +297,43 @@ func (c *Chain) FinishedJobs() uint {
 	return c.jobChain.FinishedJobs
 }
 
+// Progress returns the percentage of the chain's work done (proto.Request.Progress):
+// the weight of complete jobs over the weight of all jobs. A job without a weight
+// weighs 1, so if no job has a weight, it's the percentage of jobs complete.
+//
+// Progress never decreases. When a sequence is retried, its jobs are rolled back
+// to pending, but progress holds at the highest value returned until the retry
+// gets further than the previous try. It's 100 only when every job is complete.
+func (c *Chain) Progress() uint {
+	c.jobsMux.Lock()
+	defer c.jobsMux.Unlock()
+	var done, total uint64
+	for _, job := range c.jobChain.Jobs {
+		w := uint64(job.Weight)
+		if w == 0 {
+			w = 1
+		}
+		total += w
+		if job.State == proto.STATE_COMPLETE {
+			done += w
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	if done == total {
+		c.progress = 100
+		return c.progress
+	}
+	if pct := uint(done * 100 / total); pct > c.progress {
+		c.progress = pct
+	}
+	if c.progress > 99 { // job added after all were complete
+		c.progress = 99
+	}
+	return c.progress
+}
+
 func (c *Chain) ToSuspended() proto.SuspendedJobChain {
 	c.triesMux.RLock()
 	seqTries := c.sequenceTries
@@ -255,11 +351,115 @@ func (c *Chain) ToSuspended() proto.SuspendedJobChain {
 	return sjc
 }
 
+// Checkpoint returns a copy of the chain as a SuspendedJobChain that's safe to
+// save while the chain runs. Unlike ToSuspended, running jobs are included as
+// they are (STATE_RUNNING) with the job data they started with, and their try
+// counts do not include the try they're running.
+func (c *Chain) Checkpoint() proto.SuspendedJobChain {
+	c.jobsMux.RLock()
+	jc := *c.jobChain
+	jc.Jobs = make(map[string]proto.Job, len(c.jobChain.Jobs))
+	for id, job := range c.jobChain.Jobs {
+		if job.State == proto.STATE_RUNNING {
+			job.Data = copyData(c.runData[id])
+		} else {
+			job.Data = copyData(job.Data)
+		}
+		jc.Jobs[id] = job
+	}
+	jc.AdjacencyList = make(map[string][]string, len(c.jobChain.AdjacencyList))
+	for id, next := range c.jobChain.AdjacencyList {
+		jc.AdjacencyList[id] = next // AddJob replaces slices, never changes them
+	}
+	c.jobsMux.RUnlock()
+
+	c.triesMux.RLock()
+	defer c.triesMux.RUnlock()
+	return proto.SuspendedJobChain{
+		RequestId:         jc.RequestId,
+		JobChain:          &jc,
+		TotalJobTries:     copyTries(c.totalJobTries),
+		LatestRunJobTries: copyTries(c.latestRunJobTries),
+		SequenceTries:     copyTries(c.sequenceTries),
+	}
+}
+
+// Snapshot returns an internally consistent copy of the chain state, job states,
+// and tries, taken while holding all chain locks, so no change is half seen.
+// Its version increases every time any of them changes, so two snapshots with
+// the same version are the same. Job data is not included.
+func (c *Chain) Snapshot() proto.ChainSnapshot {
+	c.jobsMux.RLock()
+	defer c.jobsMux.RUnlock()
+	c.triesMux.RLock()
+	defer c.triesMux.RUnlock()
+
+	snap := proto.ChainSnapshot{
+		RequestId:     c.jobChain.RequestId,
+		Version:       atomic.LoadUint64(&c.version),
+		TakenAt:       time.Now().UnixNano(),
+		State:         c.jobChain.State,
+		Paused:        c.paused,
+		FinishedJobs:  c.jobChain.FinishedJobs,
+		Jobs:          make([]proto.ChainSnapshotJob, 0, len(c.jobChain.Jobs)),
+		AdjacencyList: make(map[string][]string, len(c.jobChain.AdjacencyList)),
+		SequenceTries: copyTries(c.sequenceTries),
+	}
+	for id, job := range c.jobChain.Jobs {
+		snap.Jobs = append(snap.Jobs, proto.ChainSnapshotJob{
+			Id:         id,
+			Name:       job.Name,
+			Type:       job.Type,
+			State:      job.State,
+			SequenceId: job.SequenceId,
+			Tries:      c.latestRunJobTries[id],
+			TotalTries: c.totalJobTries[id],
+			Hold:       c.holds[id].hold,
+		})
+	}
+	sort.Slice(snap.Jobs, func(i, j int) bool { return snap.Jobs[i].Id < snap.Jobs[j].Id })
+	for id, next := range c.jobChain.AdjacencyList {
+		snap.AdjacencyList[id] = append([]string{}, next...)
+	}
+	return snap
+}
+
 // RequestId returns the request id of the job chain.
 func (c *Chain) RequestId() string {
 	return c.jobChain.RequestId
 }
 
+// User returns the user who made the request.
+func (c *Chain) User() string {
+	return c.jobChain.User
+}
+
+// Globals returns the request globals, which must not be modified.
+func (c *Chain) Globals() map[string]interface{} {
+	return c.jobChain.Globals
+}
+
+// Overrides returns the request overrides, which must not be modified.
+func (c *Chain) Overrides() map[string]string {
+	return c.jobChain.Overrides
+}
+
+// StrictFailure returns true if the chain fails on the first job failure that
+// cannot be retried (see proto.JobChain.StrictFailure).
+func (c *Chain) StrictFailure() bool {
+	return c.jobChain.StrictFailure
+}
+
+// Webhooks returns the sequence webhooks, which must not be modified.
+func (c *Chain) Webhooks() []proto.SequenceWebhook {
+	return c.jobChain.Webhooks
+}
+
+// Escalations returns the sequence escalations, which must not be modified.
+func (c *Chain) Escalations() []proto.SequenceEscalation {
+	return c.jobChain.Escalations
+}
+
 // JobState returns the state of a given job.
 func (c *Chain) JobState(jobId string) byte {
 	c.jobsMux.RLock()
@@ -268,8 +468,24 @@ func (c *Chain) JobState(jobId string) byte {
 }
 
 // SetState sets the chain's state.
+// RunningJobs returns the number of jobs with state STATE_RUNNING.
+func (c *Chain) RunningJobs() uint {
+	c.jobsMux.RLock()
+	defer c.jobsMux.RUnlock()
+	n := uint(0)
+	for _, job := range c.jobChain.Jobs {
+		if job.State == proto.STATE_RUNNING {
+			n++
+		}
+	}
+	return n
+}
+
 func (c *Chain) SetState(state byte) {
+	c.jobsMux.Lock()
 	c.jobChain.State = state
+	atomic.AddUint64(&c.version, 1)
+	c.jobsMux.Unlock()
 }
 
 // State returns the chain's state.
@@ -277,19 +493,211 @@ func (c *Chain) State() byte {
 	return c.jobChain.State
 }
 
+// SetPaused sets whether the chain is paused. The traverser sets it when the
+// chain is paused or resumed; it does not change the chain state.
+func (c *Chain) SetPaused(paused bool) {
+	c.jobsMux.Lock()
+	c.paused = paused
+	atomic.AddUint64(&c.version, 1)
+	c.jobsMux.Unlock()
+}
+
+// Paused returns true if the chain is paused.
+func (c *Chain) Paused() bool {
+	c.jobsMux.RLock()
+	defer c.jobsMux.RUnlock()
+	return c.paused
+}
+
+// HoldJob records why the runnable job is not running yet: proto.HOLD_* const
+// and details, like when the hold ends. The traverser calls UnholdJob when the
+// hold ends.
+func (c *Chain) HoldJob(jobId, hold, detail string) {
+	c.jobsMux.Lock()
+	c.holds[jobId] = jobHold{
+		hold:   hold,
+		detail: detail,
+		since:  time.Now(),
+	}
+	atomic.AddUint64(&c.version, 1)
+	c.jobsMux.Unlock()
+}
+
+// UnholdJob removes the hold recorded by HoldJob, if any.
+func (c *Chain) UnholdJob(jobId string) {
+	c.jobsMux.Lock()
+	if _, ok := c.holds[jobId]; ok {
+		delete(c.holds, jobId)
+		atomic.AddUint64(&c.version, 1)
+	}
+	c.jobsMux.Unlock()
+}
+
+// HeldJobs returns the status of held jobs, longest held first, or nil if no
+// jobs are held.
+func (c *Chain) HeldJobs() []proto.JobStatus {
+	c.jobsMux.RLock()
+	if len(c.holds) == 0 {
+		c.jobsMux.RUnlock()
+		return nil
+	}
+	held := make([]proto.JobStatus, 0, len(c.holds))
+	for jobId, h := range c.holds {
+		job := c.jobChain.Jobs[jobId]
+		held = append(held, proto.JobStatus{
+			RequestId:  c.jobChain.RequestId,
+			JobId:      job.Id,
+			Type:       job.Type,
+			Name:       job.Name,
+			Desc:       job.Desc,
+			State:      job.State,
+			SequenceId: job.SequenceId,
+			Hold:       h.hold,
+			HoldDetail: h.detail,
+			HeldSince:  h.since.UnixNano(),
+		})
+	}
+	c.jobsMux.RUnlock()
+	for i := range held {
+		held[i].SequenceTry = c.SequenceTries(held[i].JobId)
+	}
+	sort.Slice(held, func(i, j int) bool {
+		if held[i].HeldSince == held[j].HeldSince {
+			return held[i].JobId < held[j].JobId
+		}
+		return held[i].HeldSince < held[j].HeldSince
+	})
+	return held
+}
+
 // Set the state of a job in the chain.
 func (c *Chain) SetJobState(jobId string, state byte) {
 	c.jobsMux.Lock() // -- lock
 	j := c.jobChain.Jobs[jobId]
 	j.State = state
 	c.jobChain.Jobs[jobId] = j
+	if state == proto.STATE_RUNNING {
+		c.runData[jobId] = copyData(j.Data)
+	}
+	atomic.AddUint64(&c.version, 1)
 	c.jobsMux.Unlock() // -- unlock
 }
 
+// SetJobReentry sets why the job was stopped (proto.STOP_REASON_* const) and its
+// last job.Reentrant token, which the job gets when it's run again on resume.
+// Both are empty when the job is done, so a sequence retry starts over.
+func (c *Chain) SetJobReentry(jobId, stopReason, token string) {
+	c.jobsMux.Lock()
+	defer c.jobsMux.Unlock()
+	j := c.jobChain.Jobs[jobId]
+	j.StopReason = stopReason
+	j.ReentryToken = token
+	c.jobChain.Jobs[jobId] = j
+}
+
+// SaveJobData saves a copy of the job's current data to restore on sequence retry.
+// The traverser calls it for a sequence start job before the first sequence try,
+// after previous jobs outside the sequence have copied their job data to it.
+// Other jobs are saved when the chain is made, before any job runs.
+func (c *Chain) SaveJobData(jobId string) {
+	c.jobsMux.Lock()
+	defer c.jobsMux.Unlock()
+	c.jobData[jobId] = copyData(c.jobChain.Jobs[jobId].Data)
+}
+
+// RestoreJobData restores the job's data to the copy saved before the first
+// sequence try, so a sequence retry does not see job data changes from the failed
+// try. It returns false and does nothing if the job has KeepData set. The job data
+// map is changed in place because copies of the job share it.
+func (c *Chain) RestoreJobData(jobId string) bool {
+	c.jobsMux.Lock()
+	defer c.jobsMux.Unlock()
+	job := c.jobChain.Jobs[jobId]
+	if job.KeepData {
+		return false
+	}
+	for k := range job.Data {
+		delete(job.Data, k)
+	}
+	for k, v := range c.jobData[jobId] {
+		job.Data[k] = v
+	}
+	return true
+}
+
+// AddJob adds a job to run after the given job and before the last job in the
+// chain, which must not be ready to run. The job is its own sequence with no
+// sequence retries, so it's not retried with other jobs, and if it fails the
+// chain fails like any other job. The after job cannot be in a sequence that can
+// be retried because a sequence retry rolls back every completed job downstream
+// of the sequence start job, which would include the added job. AddJob returns
+// the job as added, with job data copied from the after job if it completed.
+func (c *Chain) AddJob(job proto.Job, after string) (proto.Job, error) {
+	c.jobsMux.Lock()
+	defer c.jobsMux.Unlock()
+	if job.Id == "" {
+		return job, ErrInvalidChain{Message: "job id is empty"}
+	}
+	if _, ok := c.jobChain.Jobs[job.Id]; ok {
+		return job, ErrInvalidChain{Message: fmt.Sprintf("job %s already exists in job chain", job.Id)}
+	}
+	afterJob, ok := c.jobChain.Jobs[after]
+	if !ok {
+		return job, ErrInvalidChain{Message: fmt.Sprintf("job %s not found in job chain", after)}
+	}
+	switch afterJob.State {
+	case proto.STATE_PENDING, proto.STATE_RUNNING, proto.STATE_COMPLETE:
+	default:
+		return job, ErrInvalidChain{Message: fmt.Sprintf("job %s did not complete (state %s)", after, proto.StateName[afterJob.State])}
+	}
+	if seqStartJob := c.sequenceStartJob(after); seqStartJob.SequenceRetry > 0 {
+		return job, ErrInvalidChain{Message: fmt.Sprintf("job %s is in sequence %s which can be retried", after, seqStartJob.Id)}
+	}
+
+	// Job chains have one last job (see Validate), so the added job must run
+	// before it
+	var lastJobId string
+	for id := range c.jobChain.Jobs {
+		if len(c.jobChain.AdjacencyList[id]) == 0 {
+			lastJobId = id
+			break
+		}
+	}
+	if after == lastJobId {
+		return job, ErrInvalidChain{Message: fmt.Sprintf("job %s is the last job in the job chain", after)}
+	}
+	if c.jobChain.Jobs[lastJobId].State != proto.STATE_PENDING || c.isRunnable(lastJobId) {
+		return job, ErrInvalidChain{Message: fmt.Sprintf("last job %s is running or ready to run", lastJobId)}
+	}
+
+	job.State = proto.STATE_PENDING
+	job.SequenceId = job.Id
+	job.SequenceRetry = 0
+	job.SequenceRetryWait = ""
+	job.Data = map[string]interface{}{}
+	if afterJob.State == proto.STATE_COMPLETE {
+		for k, v := range afterJob.Data {
+			job.Data[k] = v
+		}
+	}
+	c.jobChain.Jobs[job.Id] = job
+	c.jobData[job.Id] = copyData(job.Data)
+
+	// Copy the next jobs of the after job, don't append to the slice in place,
+	// which could be shared with the original proto
+	next := make([]string, 0, len(c.jobChain.AdjacencyList[after])+1)
+	next = append(next, c.jobChain.AdjacencyList[after]...)
+	c.jobChain.AdjacencyList[after] = append(next, job.Id)
+	c.jobChain.AdjacencyList[job.Id] = []string{lastJobId}
+	atomic.AddUint64(&c.version, 1)
+	return job, nil
+}
+
 // -------------------------------------------------------------------------- //
 
 // isRunnable returns true if the job is runnable. A job is runnable iff its
-// state is PENDING and all immediately previous jobs are state COMPLETE.
+// state is PENDING and all immediately previous jobs are state COMPLETE, or
+// it's a finally job that's ready to run.
 func (c *Chain) isRunnable(jobId string) bool {
 	// CALLER MUST LOCK c.jobsMux!
 	job := c.jobChain.Jobs[jobId]
@@ -297,14 +705,65 @@ func (c *Chain) isRunnable(jobId string) bool {
 		return false
 	}
 	// Check that all previous jobs are complete.
-	for _, job := range c.previousJobs(jobId) {
-		if job.State != proto.STATE_COMPLETE {
+	for _, prev := range c.previousJobs(jobId) {
+		if prev.State != proto.STATE_COMPLETE {
+			return job.Finally && c.finallyReady(job, false)
+		}
+	}
+	return true
+}
+
+// finallyReady returns true if the pending finally job is ready to run even
+// though previous jobs did not complete. See FinallyJobs.
+func (c *Chain) finallyReady(job proto.Job, stopped bool) bool {
+	// CALLER MUST LOCK c.jobsMux!
+	if !c.sequenceStarted(job) {
+		return false
+	}
+
+	// Every path back from the finally job leads to its sequence start job,
+	// so the jobs between them are the jobs in the sequence before it
+	prev := map[string][]string{}
+	for id, next := range c.jobChain.AdjacencyList {
+		for _, nextId := range next {
+			prev[nextId] = append(prev[nextId], id)
+		}
+	}
+	toVisit := append([]string{}, prev[job.Id]...)
+	visited := map[string]bool{job.Id: true}
+	for len(toVisit) > 0 {
+		id := toVisit[0]
+		toVisit = toVisit[1:]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		j := c.jobChain.Jobs[id]
+		switch j.State {
+		case proto.STATE_RUNNING:
 			return false
+		case proto.STATE_PENDING:
+			// Finally jobs in sequences that started will run, and other
+			// runnable jobs will run unless the chain was stopped
+			if j.Finally && c.sequenceStarted(j) || !j.Finally && !stopped && c.isRunnable(id) {
+				return false
+			}
+		}
+		if id != job.SequenceId {
+			toVisit = append(toVisit, prev[id]...)
 		}
 	}
 	return true
 }
 
+// sequenceStarted returns true if the start job of the job's sequence is not
+// pending, i.e. it ran or is running.
+func (c *Chain) sequenceStarted(job proto.Job) bool {
+	// CALLER MUST LOCK c.jobsMux!
+	start, ok := c.jobChain.Jobs[job.SequenceId]
+	return ok && start.State != proto.STATE_PENDING
+}
+
 // Just like CanRetrySequence but without read locking jobsMux. Used within methods
 // that already read lock the jobsMux to avoid nested read locks.
 func (c *Chain) canRetrySequence(jobId string) bool {
@@ -333,6 +792,25 @@ func (c *Chain) previousJobs(jobId string) proto.Jobs {
 	return prevJobs
 }
 
+// copyData returns a shallow copy of job data. Values are not copied, so jobs
+// must replace, not modify, values like slices and maps for sequence retries to
+// restore them.
+func copyData(data map[string]interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		cp[k] = v
+	}
+	return cp
+}
+
+func copyTries(tries map[string]uint) map[string]uint {
+	cp := make(map[string]uint, len(tries))
+	for k, v := range tries {
+		cp[k] = v
+	}
+	return cp
+}
+
 // contains returns whether or not a slice of strings contains a specific string.
 func contains(s []string, t string) bool {
 	for _, i := range s {
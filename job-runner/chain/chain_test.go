@@ -7,8 +7,10 @@ import (
 	"sort"
 	"testing"
 
+	"github.com/go-test/deep"
 	"github.com/square/spincycle/v2/proto"
 	testutil "github.com/square/spincycle/v2/test"
+	"github.com/square/spincycle/v2/validate"
 )
 
 func TestNewChain(t *testing.T) {
@@ -248,6 +250,91 @@ func TestIsRunnable(t *testing.T) {
 	}
 }
 
+func TestFinallyJobs(t *testing.T) {
+	// Job chain, one sequence with finally jobs 5 and 6:
+	//       2 - 3
+	//      /     \
+	// -> 1        5 - 6 - 7
+	//      \     /
+	//       - 4 -
+	// Job 7 (sequence end) also runs after jobs 3 and 4
+	jobs := testutil.InitJobs(7)
+	for _, id := range []string{"job5", "job6"} {
+		job := jobs[id]
+		job.Finally = true
+		jobs[id] = job
+	}
+	jc := &proto.JobChain{
+		Jobs: jobs,
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job4"},
+			"job2": {"job3"},
+			"job3": {"job5", "job7"},
+			"job4": {"job5", "job7"},
+			"job5": {"job6"},
+			"job6": {"job7"},
+		},
+	}
+	c := NewChain(jc, map[string]uint{"job1": 1}, make(map[string]uint), make(map[string]uint))
+
+	finallyJobs := func(stopped bool) []string {
+		ids := []string{}
+		for _, job := range c.FinallyJobs(stopped) {
+			ids = append(ids, job.Id)
+		}
+		return ids
+	}
+
+	// Sequence not started: no finally jobs, even if stopped
+	if got := finallyJobs(true); len(got) != 0 {
+		t.Errorf("finally jobs %v before sequence started, expected none", got)
+	}
+
+	// Job 2 failed but job 4 is still running
+	c.SetJobState("job1", proto.STATE_COMPLETE)
+	c.SetJobState("job2", proto.STATE_FAIL)
+	c.SetJobState("job4", proto.STATE_RUNNING)
+	if got := finallyJobs(false); len(got) != 0 {
+		t.Errorf("finally jobs %v while job running, expected none", got)
+	}
+
+	// Job 4 done: job 5 runs although job 3 did not, then job 6 runs
+	// although job 5 failed, but not job 7
+	c.SetJobState("job4", proto.STATE_COMPLETE)
+	if got := finallyJobs(false); !reflect.DeepEqual(got, []string{"job5"}) {
+		t.Errorf("finally jobs %v, expected [job5]", got)
+	}
+	if !c.IsRunnable("job5") || c.IsRunnable("job6") {
+		t.Errorf("job5 runnable %t, job6 runnable %t, expected true, false", c.IsRunnable("job5"), c.IsRunnable("job6"))
+	}
+	if done, _ := c.IsDoneRunning(); done {
+		t.Error("chain done, expected finally job to run")
+	}
+	c.SetJobState("job5", proto.STATE_FAIL)
+	if got := finallyJobs(false); !reflect.DeepEqual(got, []string{"job6"}) {
+		t.Errorf("finally jobs %v, expected [job6]", got)
+	}
+	c.SetJobState("job6", proto.STATE_COMPLETE)
+	if c.IsRunnable("job7") {
+		t.Error("job7 runnable, expected not runnable because sequence failed")
+	}
+	if done, complete := c.IsDoneRunning(); !done || complete {
+		t.Errorf("done %t, complete %t, expected true, false", done, complete)
+	}
+
+	// Chain stopped after job 2 stopped: job 4 is runnable but does not run
+	c.SetJobState("job2", proto.STATE_STOPPED)
+	c.SetJobState("job4", proto.STATE_PENDING)
+	c.SetJobState("job5", proto.STATE_PENDING)
+	c.SetJobState("job6", proto.STATE_PENDING)
+	if got := finallyJobs(false); len(got) != 0 {
+		t.Errorf("finally jobs %v while job runnable, expected none", got)
+	}
+	if got := finallyJobs(true); !reflect.DeepEqual(got, []string{"job5"}) {
+		t.Errorf("finally jobs %v when stopped, expected [job5]", got)
+	}
+}
+
 func TestIsDoneRunning(t *testing.T) {
 	// A chain is not done (and not complete) if any job is running
 	jc := &proto.JobChain{
@@ -832,3 +919,180 @@ func TestIsDoneRetryableSequenceTrueUnknown(t *testing.T) {
 		t.Errorf("done = %v, expected %v. complete = %v, expected %v.", actualDone, expectDone, actualComplete, expectComplete)
 	}
 }
+
+func TestAddJob(t *testing.T) {
+	jc := &proto.JobChain{
+		Jobs: testutil.InitJobs(4),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+			"job2": {"job4"},
+			"job3": {"job4"},
+		},
+	}
+	c := NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	c.SetJobState("job1", proto.STATE_COMPLETE)
+	c.SetJobState("job2", proto.STATE_FAIL)
+	jc.Jobs["job1"].Data["host"] = "db1"
+
+	// Invalid: no id, duplicate id, unknown after job, after job failed,
+	// after last job
+	invalid := []struct {
+		id    string
+		after string
+	}{
+		{"", "job1"},
+		{"job2", "job1"},
+		{"job5", "job9"},
+		{"job5", "job2"},
+		{"job5", "job4"},
+	}
+	for _, i := range invalid {
+		if _, err := c.AddJob(proto.Job{Id: i.id}, i.after); err == nil {
+			t.Errorf("add job %q after %s: no error, expected one", i.id, i.after)
+		} else if _, ok := err.(ErrInvalidChain); !ok {
+			t.Errorf("add job %q after %s: got %T, expected ErrInvalidChain", i.id, i.after, err)
+		}
+	}
+
+	job, err := c.AddJob(proto.Job{Id: "job5", Type: "diag", State: proto.STATE_COMPLETE, SequenceId: "job1"}, "job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != proto.STATE_PENDING || job.SequenceId != "job5" || job.SequenceRetry != 0 {
+		t.Errorf("got job %+v, expected PENDING in its own sequence", job)
+	}
+	if job.Data["host"] != "db1" {
+		t.Errorf("got job data %v, expected job1 data", job.Data)
+	}
+	if !c.IsRunnable("job5") {
+		t.Error("job5 not runnable, expected it to be")
+	}
+	expectAdj := map[string][]string{
+		"job1": {"job2", "job3", "job5"},
+		"job2": {"job4"},
+		"job3": {"job4"},
+		"job5": {"job4"},
+	}
+	if !reflect.DeepEqual(jc.AdjacencyList, expectAdj) {
+		t.Errorf("adjacency list = %v, expected %v", jc.AdjacencyList, expectAdj)
+	}
+	if err := validate.Graph(*jc); err != nil {
+		t.Errorf("job chain not valid after adding job: %s", err)
+	}
+
+	// Last job ready to run
+	c.SetJobState("job2", proto.STATE_COMPLETE)
+	c.SetJobState("job3", proto.STATE_COMPLETE)
+	c.SetJobState("job5", proto.STATE_COMPLETE)
+	if _, err := c.AddJob(proto.Job{Id: "job6"}, "job3"); err == nil {
+		t.Error("add job before runnable last job: no error, expected one")
+	}
+}
+
+func TestAddJobRetryableSequence(t *testing.T) {
+	jc := &proto.JobChain{
+		Jobs: testutil.InitJobsWithSequenceRetry(3, 1),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+			"job2": {"job3"},
+		},
+	}
+	c := NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	if _, err := c.AddJob(proto.Job{Id: "job4"}, "job1"); err == nil {
+		t.Error("add job in retryable sequence: no error, expected one")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	jc := &proto.JobChain{
+		RequestId: "req1",
+		Jobs:      testutil.InitJobs(3),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+		},
+	}
+	c := NewChain(jc, map[string]uint{}, map[string]uint{}, map[string]uint{})
+	c.SetState(proto.STATE_RUNNING)
+	c.SetJobState("job1", proto.STATE_COMPLETE)
+	c.IncrementJobTries("job1", 1)
+	c.IncrementFinishedJobs(1)
+	c.SetJobState("job2", proto.STATE_RUNNING)
+	c.IncrementJobTries("job2", 1)
+	c.HoldJob("job3", proto.HOLD_PACE, "")
+
+	snap1 := c.Snapshot()
+	expect := proto.ChainSnapshot{
+		RequestId:    "req1",
+		Version:      snap1.Version,
+		TakenAt:      snap1.TakenAt,
+		State:        proto.STATE_RUNNING,
+		FinishedJobs: 1,
+		Jobs: []proto.ChainSnapshotJob{
+			{Id: "job1", State: proto.STATE_COMPLETE, SequenceId: "job1", Tries: 1, TotalTries: 1},
+			{Id: "job2", State: proto.STATE_RUNNING, SequenceId: "job1", Tries: 1, TotalTries: 1},
+			{Id: "job3", State: proto.STATE_PENDING, SequenceId: "job1", Hold: proto.HOLD_PACE},
+		},
+		AdjacencyList: map[string][]string{"job1": {"job2", "job3"}},
+		SequenceTries: map[string]uint{},
+	}
+	if diff := deep.Equal(snap1, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// No changes, same version; every change increases it
+	if snap := c.Snapshot(); snap.Version != snap1.Version {
+		t.Errorf("version %d, expected %d (no changes)", snap.Version, snap1.Version)
+	}
+	c.UnholdJob("job3")
+	snap2 := c.Snapshot()
+	if snap2.Version <= snap1.Version {
+		t.Errorf("version %d, expected > %d", snap2.Version, snap1.Version)
+	}
+	c.UnholdJob("job3") // not held: no change
+	if snap := c.Snapshot(); snap.Version != snap2.Version {
+		t.Errorf("version %d, expected %d (job not held)", snap.Version, snap2.Version)
+	}
+
+	// Snapshot is a copy
+	snap2.AdjacencyList["job1"][0] = "changed"
+	if snap := c.Snapshot(); snap.AdjacencyList["job1"][0] != "job2" {
+		t.Error("snapshot adjacency list shared with chain")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	jobs := testutil.InitJobs(3)
+	for id, w := range map[string]uint{"job1": 1, "job2": 6, "job3": 0} { // job3 weighs 1
+		job := jobs[id]
+		job.Weight = w
+		jobs[id] = job
+	}
+	jc := &proto.JobChain{Jobs: jobs}
+	c := NewChain(jc, map[string]uint{}, map[string]uint{}, map[string]uint{})
+
+	if p := c.Progress(); p != 0 {
+		t.Errorf("progress %d, expected 0", p)
+	}
+	c.SetJobState("job1", proto.STATE_COMPLETE)
+	c.SetJobState("job2", proto.STATE_COMPLETE)
+	if p := c.Progress(); p != 87 { // 7/8
+		t.Errorf("progress %d, expected 87", p)
+	}
+
+	// Sequence retry rolls back jobs, but progress does not decrease
+	c.SetJobState("job2", proto.STATE_PENDING)
+	if p := c.Progress(); p != 87 {
+		t.Errorf("progress %d, expected 87 after roll back", p)
+	}
+
+	// 100 only when every job is complete
+	c.SetJobState("job2", proto.STATE_COMPLETE)
+	c.SetJobState("job3", proto.STATE_COMPLETE)
+	if p := c.Progress(); p != 100 {
+		t.Errorf("progress %d, expected 100", p)
+	}
+	c.SetJobState("job3", proto.STATE_PENDING)
+	if p := c.Progress(); p != 99 {
+		t.Errorf("progress %d, expected 99 when not every job is complete", p)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2020, Square, Inc.
+
+package chain
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/config"
+)
+
+// latencyWeight is the weight of the latest duration in the moving average
+// duration of a job type.
+const latencyWeight = 0.2
+
+// A limiter limits how many jobs of one chain run at once (config.Concurrency).
+// If adaptive, it's an AIMD controller: the limit is halved, down to min, when
+// a job fails, needs retries, or is slow, and increased by 1/limit, up to max,
+// when a job finishes healthy, so it increases by one after limit healthy jobs.
+// Jobs that started before the last decrease do not decrease the limit again:
+// they ran at the previous limit, so one failure spike halves the limit once.
+//
+// Jobs waiting for a slot get one in the order they asked. A nil limiter does
+// not limit.
+type limiter struct {
+	max        float64
+	min        float64
+	adaptive   bool
+	slowFactor float64
+	logger     *log.Entry
+
+	limit   float64
+	running uint
+	epoch   uint                     // incremented on every decrease
+	waiting []chan uint              // receives the slot of a waiting job
+	avg     map[string]time.Duration // moving average duration by job type
+	*sync.Mutex
+}
+
+// newLimiter makes the limiter of one chain. It returns nil if there is no max.
+func newLimiter(cfg config.Concurrency, logger *log.Entry) *limiter {
+	if cfg.Max == 0 {
+		return nil
+	}
+	min := cfg.Min
+	if min == 0 {
+		min = config.DEFAULT_CONCURRENCY_MIN
+	}
+	if min > cfg.Max {
+		min = cfg.Max
+	}
+	slowFactor := cfg.SlowFactor
+	if slowFactor == 0 {
+		slowFactor = config.DEFAULT_SLOW_JOB_FACTOR
+	}
+	return &limiter{
+		max:        float64(cfg.Max),
+		min:        float64(min),
+		adaptive:   cfg.Adaptive,
+		slowFactor: slowFactor,
+		logger:     logger,
+		limit:      float64(cfg.Max),
+		waiting:    []chan uint{},
+		avg:        map[string]time.Duration{},
+		Mutex:      &sync.Mutex{},
+	}
+}
+
+// acquire waits for a slot to run a job. If it must wait, it calls held (if not
+// nil) with the current limit. It returns the slot, which the caller passes to
+// release when the job is done, and false if stopped while waiting.
+func (l *limiter) acquire(stopChan <-chan struct{}, held func(limit uint)) (slot uint, ok bool) {
+	if l == nil {
+		return 0, true
+	}
+	l.Lock()
+	if len(l.waiting) == 0 && l.running < l.slots() {
+		l.running++
+		slot = l.epoch
+		l.Unlock()
+		return slot, true
+	}
+	ready := make(chan uint, 1)
+	l.waiting = append(l.waiting, ready)
+	limit := l.slots()
+	l.Unlock()
+
+	if held != nil {
+		held(limit)
+	}
+	select {
+	case slot = <-ready:
+		return slot, true
+	case <-stopChan:
+		l.Lock()
+		defer l.Unlock()
+		select {
+		case <-ready:
+			// Given a slot while being stopped: give it to the next job
+			l.running--
+			l.grant()
+		default:
+			for i := range l.waiting {
+				if l.waiting[i] == ready {
+					l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+					break
+				}
+			}
+		}
+		return 0, false
+	}
+}
+
+// release frees the slot of a job that ran for duration d. If the limiter is
+// adaptive, healthy is false if the job failed or needed retries, and the limit
+// is adjusted.
+func (l *limiter) release(slot uint, jobType string, d time.Duration, healthy bool) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.running--
+	if l.adaptive {
+		prev := l.slots()
+		avg, seen := l.avg[jobType]
+		slow := seen && d > time.Duration(float64(avg)*l.slowFactor)
+		if seen {
+			l.avg[jobType] = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(avg))
+		} else {
+			l.avg[jobType] = d
+		}
+		if !healthy || slow {
+			if slot == l.epoch {
+				l.limit /= 2
+				if l.limit < l.min {
+					l.limit = l.min
+				}
+				l.epoch++
+				if l.slots() != prev {
+					l.logger.Warnf("concurrency decreased to %d: job type %s failed=%t slow=%t (%s)", l.slots(), jobType, !healthy, slow, d)
+				}
+			}
+		} else if l.limit < l.max {
+			l.limit += 1 / l.limit
+			if l.limit > l.max {
+				l.limit = l.max
+			}
+			if l.slots() != prev {
+				l.logger.Infof("concurrency increased to %d", l.slots())
+			}
+		}
+	}
+	l.grant()
+}
+
+// full returns the current limit and true if every slot is taken, so a job
+// asking for one would wait.
+func (l *limiter) full() (limit uint, full bool) {
+	if l == nil {
+		return 0, false
+	}
+	l.Lock()
+	defer l.Unlock()
+	return l.slots(), len(l.waiting) > 0 || l.running >= l.slots()
+}
+
+// cancel frees a slot that was acquired but not used to run a job. Unlike
+// release, it does not adjust the limit.
+func (l *limiter) cancel() {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.running--
+	l.grant()
+}
+
+// grant gives free slots to waiting jobs, first come, first served. The caller
+// must hold the lock.
+func (l *limiter) grant() {
+	for len(l.waiting) > 0 && l.running < l.slots() {
+		l.running++
+		l.waiting[0] <- l.epoch
+		l.waiting[0] = nil
+		l.waiting = l.waiting[1:]
+	}
+}
+
+// slots returns the current limit as a number of jobs. The caller must hold the
+// lock.
+func (l *limiter) slots() uint {
+	return uint(l.limit)
+}
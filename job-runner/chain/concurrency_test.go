@@ -0,0 +1,143 @@
+// Copyright 2020, Square, Inc.
+
+package chain
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/config"
+)
+
+func TestLimiterNoMax(t *testing.T) {
+	l := newLimiter(config.Concurrency{}, log.WithFields(log.Fields{}))
+	if l != nil {
+		t.Fatal("got a limiter, expected nil for no max")
+	}
+	for i := 0; i < 100; i++ {
+		if _, ok := l.acquire(nil, nil); !ok {
+			t.Fatal("acquire returned false, expected true")
+		}
+	}
+	l.release(0, "jtype", time.Second, false)
+}
+
+func TestLimiterMax(t *testing.T) {
+	l := newLimiter(config.Concurrency{Max: 2}, log.WithFields(log.Fields{}))
+	stopChan := make(chan struct{})
+
+	// Two jobs run without waiting
+	for i := 0; i < 2; i++ {
+		if _, ok := l.acquire(stopChan, func(uint) { t.Error("held, expected no wait") }); !ok {
+			t.Fatal("acquire returned false, expected true")
+		}
+	}
+
+	// Third waits until a job is done
+	var heldLimit uint
+	heldChan := make(chan struct{})
+	doneChan := make(chan bool)
+	go func() {
+		_, ok := l.acquire(stopChan, func(limit uint) { heldLimit = limit; close(heldChan) })
+		doneChan <- ok
+	}()
+	<-heldChan
+	if heldLimit != 2 {
+		t.Errorf("held with limit %d, expected 2", heldLimit)
+	}
+	select {
+	case <-doneChan:
+		t.Fatal("acquire returned before a job was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release(0, "jtype", time.Second, false) // not adaptive: failure ignored
+	select {
+	case ok := <-doneChan:
+		if !ok {
+			t.Error("acquire returned false, expected true")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire did not return after a job was done")
+	}
+
+	// Fourth waits, then is stopped: it does not run, and its slot is not
+	// counted after the other jobs are done
+	go func() {
+		_, ok := l.acquire(stopChan, nil)
+		doneChan <- ok
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(stopChan)
+	if ok := <-doneChan; ok {
+		t.Error("acquire returned true, expected false after stop")
+	}
+	l.release(0, "jtype", time.Second, true)
+	l.release(0, "jtype", time.Second, true)
+	if l.running != 0 || len(l.waiting) != 0 {
+		t.Errorf("%d running and %d waiting, expected 0 and 0", l.running, len(l.waiting))
+	}
+	if l.slots() != 2 {
+		t.Errorf("limit %d, expected 2 (not adaptive)", l.slots())
+	}
+}
+
+func TestLimiterAdaptive(t *testing.T) {
+	l := newLimiter(config.Concurrency{Max: 8, Min: 2, Adaptive: true}, log.WithFields(log.Fields{}))
+	stopChan := make(chan struct{})
+
+	// Two jobs start at limit 8, and both fail: the limit is halved once
+	// because both ran at the same limit
+	slot1, _ := l.acquire(stopChan, nil)
+	slot2, _ := l.acquire(stopChan, nil)
+	l.release(slot1, "jtype", time.Second, false)
+	if l.slots() != 4 {
+		t.Errorf("limit %d, expected 4", l.slots())
+	}
+	l.release(slot2, "jtype", time.Second, false)
+	if l.slots() != 4 {
+		t.Errorf("limit %d, expected 4 (same failure spike)", l.slots())
+	}
+
+	// A job started after the decrease fails: halved again, not below min
+	for _, expect := range []uint{2, 2} {
+		slot, _ := l.acquire(stopChan, nil)
+		l.release(slot, "jtype", time.Second, false)
+		if l.slots() != expect {
+			t.Errorf("limit %d, expected %d", l.slots(), expect)
+		}
+	}
+
+	// Healthy jobs increase the limit by one after limit jobs: 2 + 1/2 + 1/2.5
+	// + 1/2.9 = 3.24
+	for i, expect := range []uint{2, 2, 3} {
+		slot, _ := l.acquire(stopChan, nil)
+		l.release(slot, "jtype", time.Second, true)
+		if l.slots() != expect {
+			t.Errorf("job %d: limit %d, expected %d", i, l.slots(), expect)
+		}
+	}
+
+	// A healthy but slow job (more than 2x the average of its type) decreases
+	// the limit. A job type never seen before is not slow.
+	slot, _ := l.acquire(stopChan, nil)
+	l.release(slot, "other", 10*time.Second, true)
+	if l.slots() != 3 {
+		t.Errorf("limit %d, expected 3 (new job type not slow)", l.slots())
+	}
+	slot, _ = l.acquire(stopChan, nil)
+	l.release(slot, "jtype", 3*time.Second, true)
+	if l.slots() != 2 {
+		t.Errorf("limit %d, expected 2 (slow job)", l.slots())
+	}
+
+	// The limit never increases above max
+	for i := 0; i < 100; i++ {
+		slot, _ := l.acquire(stopChan, nil)
+		l.release(slot, "jtype", time.Second, true)
+	}
+	if l.slots() != 8 {
+		t.Errorf("limit %d, expected max 8", l.slots())
+	}
+}
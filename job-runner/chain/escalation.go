@@ -0,0 +1,70 @@
+// Copyright 2020, Square, Inc.
+
+package chain
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/job-runner/runner"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// escalate starts the escalations (spec escalations:) of the sequences that a job
+// about to run is in and returns a func that the caller must call when the job is
+// done. When a step's After elapses and the job is a gate job still waiting for
+// approval (runner.Status.Waiting), the step is notified: an approval-pending
+// proto.SequenceEvent is sent to its URL. Each step is notified at most once per
+// run, which includes all tries, and steps of a sequence are independent, so a
+// later step is notified although an earlier one failed to send. It does nothing
+// if the traverser has no Notifier.
+func (t *traverser) escalate(job proto.Job, r runner.Runner, logger *log.Entry) (done func()) {
+	if t.notifier == nil {
+		return func() {}
+	}
+	started := time.Now()
+	var timers []*time.Timer
+	for _, esc := range t.chain.Escalations() {
+		if !contains(esc.JobIds, job.Id) {
+			continue
+		}
+		after, err := time.ParseDuration(esc.After)
+		if err != nil || after <= 0 {
+			// Checked when specs are loaded, so only if the chain was changed
+			logger.Warnf("sequence %s escalation %d: invalid after %q, ignoring", esc.Sequence, esc.Step, esc.After)
+			continue
+		}
+		esc := esc
+		timers = append(timers, time.AfterFunc(after, func() {
+			if !r.Status().Waiting {
+				return
+			}
+			pending := time.Since(started)
+			ev := proto.SequenceEvent{
+				Event:      proto.SEQUENCE_EVENT_APPROVAL_PENDING,
+				RequestId:  t.chain.RequestId(),
+				Sequence:   esc.Sequence,
+				StartJobId: esc.StartJobId,
+				Try:        t.chain.SequenceTries(esc.StartJobId),
+				JobId:      job.Id,
+				Escalation: esc.Step,
+				Pending:    pending.Nanoseconds(),
+				Ts:         time.Now().UTC(),
+			}
+			wh := proto.SequenceWebhook{
+				URL:        esc.URL,
+				Sequence:   esc.Sequence,
+				StartJobId: esc.StartJobId,
+				JobIds:     esc.JobIds,
+			}
+			logger.Warnf("gate job waiting for approval for %s, escalating to %s (sequence %s step %d)", pending.Round(time.Second), esc.URL, esc.Sequence, esc.Step)
+			t.notifier.Notify(wh, ev)
+		}))
+	}
+	return func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}
+}
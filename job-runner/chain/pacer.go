@@ -0,0 +1,114 @@
+// Copyright 2020, Square, Inc.
+
+package chain
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/job-runner/runner"
+)
+
+var (
+	// PaceMinDelay is the delay between starting branches of a paced fan-out
+	// after its jobs first report throttling.
+	PaceMinDelay = 1 * time.Second
+
+	// PaceMaxDelay is the max delay between starting branches of a paced fan-out.
+	PaceMaxDelay = 1 * time.Minute
+)
+
+// A pacer paces starting the branches (expanded sequences) of one paced fan-out
+// (spec each: with pace: true). It's the job.Feedback of every job in the fan-out.
+// Branches start without delay until a job reports throttling. Then the delay
+// between branch starts is doubled every time a job reports throttling, from
+// PaceMinDelay to PaceMaxDelay, and halved every time a branch starts without
+// throttling reported since the previous branch start, until it's zero again.
+// Branches already running are not slowed; jobs that are throttled must still
+// retry or back off on their own.
+type pacer struct {
+	id        string // Job.Pace
+	logger    *log.Entry
+	delay     time.Duration // between branch starts
+	next      time.Time     // earliest start of the next branch
+	throttled bool          // since the last branch start
+	*sync.Mutex
+}
+
+func newPacer(id string, logger *log.Entry) *pacer {
+	return &pacer{
+		id:     id,
+		logger: logger.WithFields(log.Fields{"pace": id}),
+		Mutex:  &sync.Mutex{},
+	}
+}
+
+// Throttled implements job.Feedback.
+func (p *pacer) Throttled(n uint) {
+	if n == 0 {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.throttled = true
+	prev := p.delay
+	for i := uint(0); i < n && p.delay < PaceMaxDelay; i++ {
+		p.delay *= 2
+		if p.delay < PaceMinDelay {
+			p.delay = PaceMinDelay
+		}
+	}
+	if p.delay > PaceMaxDelay {
+		p.delay = PaceMaxDelay
+	}
+	if p.delay != prev {
+		p.logger.Infof("fan-out throttled %d times, slowing branch starts: %s between starts", n, p.delay)
+	}
+}
+
+// wait waits until the next branch can start. Concurrent callers start one
+// delay apart. If it must wait, it calls held (if not nil) with when the branch
+// starts. It returns false if stopped while waiting.
+func (p *pacer) wait(stopChan <-chan struct{}, held func(start time.Time)) bool {
+	p.Lock()
+	if !p.throttled && p.delay > 0 {
+		p.delay /= 2
+		if p.delay < PaceMinDelay {
+			p.delay = 0
+		}
+		if p.delay == 0 {
+			p.logger.Infof("fan-out not throttled, branch starts no longer slowed")
+		}
+	}
+	p.throttled = false
+	now := time.Now()
+	start := p.next
+	if start.Before(now) {
+		start = now
+	}
+	p.next = start.Add(p.delay)
+	p.Unlock()
+
+	d := time.Until(start)
+	if d <= 0 {
+		return true
+	}
+	if held != nil {
+		held(start)
+	}
+	select {
+	case <-time.After(d):
+		return true
+	case <-stopChan:
+		return false
+	}
+}
+
+// setFeedback gives the pacer to the job of the runner, see job.Paced.
+func setFeedback(r runner.Runner, p *pacer) {
+	if pr, ok := r.(runner.PacedRunner); ok {
+		pr.SetFeedback(p)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2020, Square, Inc.
+
+package chain
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestPacer(t *testing.T) {
+	defer func(min, max time.Duration) { PaceMinDelay, PaceMaxDelay = min, max }(PaceMinDelay, PaceMaxDelay)
+	PaceMinDelay = 50 * time.Millisecond
+	PaceMaxDelay = 200 * time.Millisecond
+
+	p := newPacer("fanout1", log.WithFields(log.Fields{}))
+	stopChan := make(chan struct{})
+
+	// Not throttled: branches start without delay
+	t0 := time.Now()
+	for i := 0; i < 3; i++ {
+		if !p.wait(stopChan, nil) {
+			t.Fatal("wait returned false, expected true")
+		}
+	}
+	if d := time.Since(t0); d >= PaceMinDelay {
+		t.Errorf("unthrottled branches started in %s, expected no delay", d)
+	}
+
+	// Throttled: min delay, doubled every time, up to max delay
+	p.Throttled(0)
+	if p.delay != 0 {
+		t.Errorf("delay %s after throttled 0 times, expected 0", p.delay)
+	}
+	p.Throttled(1)
+	if p.delay != PaceMinDelay {
+		t.Errorf("delay %s, expected %s", p.delay, PaceMinDelay)
+	}
+	p.Throttled(1)
+	if p.delay != 2*PaceMinDelay {
+		t.Errorf("delay %s, expected %s", p.delay, 2*PaceMinDelay)
+	}
+	p.Throttled(5)
+	if p.delay != PaceMaxDelay {
+		t.Errorf("delay %s, expected %s", p.delay, PaceMaxDelay)
+	}
+
+	// Throttled since the last start, so the delay is kept for this start.
+	// The next start without throttling halves it.
+	t0 = time.Now()
+	p.wait(stopChan, nil) // now
+	p.wait(stopChan, nil) // +200ms, halved to 100ms
+	if d := time.Since(t0); d < PaceMaxDelay {
+		t.Errorf("second branch started after %s, expected >= %s", d, PaceMaxDelay)
+	}
+	if p.delay != PaceMaxDelay/2 {
+		t.Errorf("delay %s, expected %s", p.delay, PaceMaxDelay/2)
+	}
+
+	// Halved below min delay: no delay
+	p.wait(stopChan, nil) // +100ms, halved to 50ms
+	p.wait(stopChan, nil) // +50ms, halved to 0
+	if p.delay != 0 {
+		t.Errorf("delay %s, expected 0", p.delay)
+	}
+
+	// Stopped while waiting
+	p.Throttled(3)
+	p.wait(stopChan, nil)
+	close(stopChan)
+	if p.wait(stopChan, nil) {
+		t.Error("wait returned true after stop, expected false")
+	}
+}
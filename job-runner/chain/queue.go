@@ -0,0 +1,83 @@
+// Copyright 2020, Square, Inc.
+
+package chain
+
+import (
+	"sync"
+
+	"github.com/square/spincycle/v2/proto"
+)
+
+// A Queue holds the runnable jobs of one job chain until the traverser runs them.
+// The traverser pushes jobs as they become runnable and pops them to run, so the
+// queue decides the order in which runnable jobs are dispatched. If the chain
+// has a concurrency limit, a job is popped only when a slot is free to run it,
+// so jobs over the limit wait in the queue. The default, NewFIFOQueue,
+// dispatches jobs in the order they become runnable. Other queues, like a
+// priority heap or a queue per sequence, are made by a QueueFactory given to the
+// Job Runner (app.Plugins.MakeJobQueue).
+//
+// Push and Close are called from one goroutine, and Pop from another, so a queue
+// must be safe for concurrent use.
+type Queue interface {
+	// Push adds a runnable job. It must not block: the running reaper waits
+	// for it.
+	Push(proto.Job)
+
+	// Pop removes and returns the next job to run. It blocks until a job is
+	// pushed or the queue is closed. It returns false when the queue is closed
+	// and empty.
+	Pop() (proto.Job, bool)
+
+	// Close is called after the last Push. Pop returns the remaining jobs,
+	// then false.
+	Close()
+}
+
+// A QueueFactory makes the queue of a job chain. It's called once per chain,
+// before the chain runs.
+type QueueFactory func(*Chain) Queue
+
+type fifoQueue struct {
+	cond   *sync.Cond
+	jobs   []proto.Job
+	closed bool
+}
+
+// NewFIFOQueue makes an unbounded first in, first out queue. It's the default
+// queue of every job chain.
+func NewFIFOQueue() Queue {
+	return &fifoQueue{
+		cond: sync.NewCond(&sync.Mutex{}),
+		jobs: []proto.Job{},
+	}
+}
+
+func (q *fifoQueue) Push(job proto.Job) {
+	q.cond.L.Lock()
+	q.jobs = append(q.jobs, job)
+	q.cond.L.Unlock()
+	q.cond.Signal()
+}
+
+func (q *fifoQueue) Pop() (proto.Job, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for len(q.jobs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.jobs) == 0 {
+		return proto.Job{}, false
+	}
+	job := q.jobs[0]
+	q.jobs[0] = proto.Job{} // release job data
+	q.jobs = q.jobs[1:]
+	return job, true
+}
+
+func (q *fifoQueue) Close() {
+	q.cond.L.Lock()
+	q.closed = true
+	q.cond.L.Unlock()
+	q.cond.Broadcast()
+}
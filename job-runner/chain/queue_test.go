@@ -0,0 +1,228 @@
+// Copyright 2020, Square, Inc.
+
+package chain_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/config"
+	"github.com/square/spincycle/v2/job-runner/chain"
+	"github.com/square/spincycle/v2/job-runner/runner"
+	"github.com/square/spincycle/v2/proto"
+	testutil "github.com/square/spincycle/v2/test"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+func TestFIFOQueue(t *testing.T) {
+	q := chain.NewFIFOQueue()
+	q.Push(proto.Job{Id: "job1"})
+	q.Push(proto.Job{Id: "job2"})
+
+	// Pop blocks until a job is pushed
+	popped := make(chan string)
+	go func() {
+		for job, ok := q.Pop(); ok; job, ok = q.Pop() {
+			popped <- job.Id
+		}
+		close(popped)
+	}()
+	got := []string{<-popped, <-popped}
+	select {
+	case id := <-popped:
+		t.Fatalf("popped %s from empty queue", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Remaining jobs are popped after Close, then Pop returns false
+	q.Push(proto.Job{Id: "job3"})
+	q.Close()
+	for id := range popped {
+		got = append(got, id)
+	}
+	if diff := deep.Equal(got, []string{"job1", "job2", "job3"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+// lifoQueue runs the most recently pushed job first.
+type lifoQueue struct {
+	cond   *sync.Cond
+	jobs   []proto.Job
+	pushed []string
+	popped []string
+	closed bool
+}
+
+func (q *lifoQueue) Push(job proto.Job) {
+	q.cond.L.Lock()
+	q.jobs = append(q.jobs, job)
+	q.pushed = append(q.pushed, job.Id)
+	q.cond.L.Unlock()
+	q.cond.Signal()
+}
+
+func (q *lifoQueue) Pop() (proto.Job, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for len(q.jobs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.jobs) == 0 {
+		return proto.Job{}, false
+	}
+	job := q.jobs[len(q.jobs)-1]
+	q.jobs = q.jobs[:len(q.jobs)-1]
+	q.popped = append(q.popped, job.Id)
+	return job, true
+}
+
+func (q *lifoQueue) Close() {
+	q.cond.L.Lock()
+	q.closed = true
+	q.cond.L.Unlock()
+	q.cond.Broadcast()
+}
+
+// Traverser made by the factory runs jobs popped from the queue made for the chain.
+func TestTraverserQueue(t *testing.T) {
+	// Job Chain:
+	//     2
+	//   /   \
+	// 1 - 3 - 5
+	//   \   /
+	//     4
+	var q *lifoQueue
+	var madeFor string
+	qf := func(c *chain.Chain) chain.Queue {
+		madeFor = c.RequestId()
+		q = &lifoQueue{cond: sync.NewCond(&sync.Mutex{})}
+		return q
+	}
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+			"job2": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+			"job3": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+			"job4": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+			"job5": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+		},
+	}
+	tf := chain.NewTraverserFactory(chain.TraverserFactoryConfig{
+		ChainRepo:     chain.NewMemoryRepo(),
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		QueueFactory:  qf,
+	})
+	jc := &proto.JobChain{
+		RequestId: "test_traverser_queue",
+		Jobs:      testutil.InitJobs(5),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3", "job4"},
+			"job2": {"job5"},
+			"job3": {"job5"},
+			"job4": {"job5"},
+		},
+	}
+	tr, err := tf.Make(jc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Run()
+
+	if madeFor != "test_traverser_queue" {
+		t.Errorf("queue made for request %q, expected test_traverser_queue", madeFor)
+	}
+	if jc.State != proto.STATE_COMPLETE {
+		t.Errorf("chain state = %s, expected COMPLETE", proto.StateName[jc.State])
+	}
+	q.cond.L.Lock()
+	pushed := q.pushed
+	q.cond.L.Unlock()
+	if len(pushed) != 5 || pushed[0] != "job1" || pushed[4] != "job5" {
+		t.Errorf("pushed jobs %v, expected job1, then jobs 2-4, then job5", pushed)
+	}
+}
+
+// With a concurrency limit, jobs wait in the queue for a slot, so they run in
+// the order they're popped, not the order they ask for a slot.
+func TestTraverserQueueOrder(t *testing.T) {
+	// Job Chain:
+	//     2
+	//   /   \
+	// 1 - 3 - 7
+	//   \ ... /
+	//     6
+	// One job runs at a time. The first of jobs 2-6 that's popped runs right
+	// away, then the others are popped while it runs, in the reverse order they
+	// were pushed (LIFO), and run in that order.
+	q := &lifoQueue{cond: sync.NewCond(&sync.Mutex{})}
+	qf := func(c *chain.Chain) chain.Queue { return q }
+	var mux sync.Mutex
+	var ran []string
+	runners := map[string]*mock.Runner{}
+	for i := 1; i <= 7; i++ {
+		jobId := fmt.Sprintf("job%d", i)
+		runners[jobId] = &mock.Runner{RunFunc: func(jobData map[string]interface{}) byte {
+			mux.Lock()
+			ran = append(ran, jobId)
+			mux.Unlock()
+			time.Sleep(50 * time.Millisecond) // until jobs 2-6 are queued
+			return proto.STATE_COMPLETE
+		}}
+	}
+	tf := chain.NewTraverserFactory(chain.TraverserFactoryConfig{
+		ChainRepo:     chain.NewMemoryRepo(),
+		RunnerFactory: &mock.RunnerFactory{RunnersToReturn: runners},
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		QueueFactory:  qf,
+		Concurrency:   config.Concurrency{Max: 1},
+	})
+	jc := &proto.JobChain{
+		RequestId: "test_traverser_queue_order",
+		Jobs:      testutil.InitJobs(7),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3", "job4", "job5", "job6"},
+			"job2": {"job7"},
+			"job3": {"job7"},
+			"job4": {"job7"},
+			"job5": {"job7"},
+			"job6": {"job7"},
+		},
+	}
+	tr, err := tf.Make(jc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Run()
+
+	if jc.State != proto.STATE_COMPLETE {
+		t.Errorf("chain state = %s, expected COMPLETE", proto.StateName[jc.State])
+	}
+	q.cond.L.Lock()
+	pushed := q.pushed
+	popped := q.popped
+	q.cond.L.Unlock()
+	if diff := deep.Equal(ran, popped); diff != nil {
+		t.Errorf("ran %v, expected popped order %v: %v", ran, popped, diff)
+	}
+	if len(pushed) != 7 || len(ran) != 7 {
+		t.Fatalf("pushed %v, ran %v, expected 7 jobs", pushed, ran)
+	}
+	pos := map[string]int{}
+	for i, jobId := range pushed {
+		pos[jobId] = i
+	}
+	for i := 3; i < 6; i++ {
+		if pos[ran[i]] > pos[ran[i-1]] {
+			t.Errorf("ran %v, expected jobs after %s in reverse order pushed %v", ran, ran[1], pushed)
+			break
+		}
+	}
+}
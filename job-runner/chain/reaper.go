@@ -83,7 +83,22 @@ type ChainReaperFactory struct {
 	RMCRetryWait time.Duration  // time to wait between tries to send info to RM
 	DoneJobChan  chan proto.Job // chan jobs are reaped from
 	RunJobChan   chan proto.Job // (running reaper) chan jobs to run are sent to
+	AddJobChan   chan addJob    // (running reaper) chan jobs to add are received on
 	RunnerRepo   runner.Repo    // (stopped + suspended reapers) repo of job runners
+	Notifier     Notifier       // (running + suspended reapers) sequence webhooks, optional
+	StopChain    func()         // (running reaper) stops the chain on strict failure
+	Checkpointer Checkpointer   // (running reaper) saves chain checkpoints, optional
+
+	// (stopped reaper) runs a finally job, returning it in its final state
+	RunFinally func(proto.Job) proto.Job
+}
+
+// addJob is a job to add to a running chain. traverser.AddJob sends it to the
+// running reaper, which replies on errChan.
+type addJob struct {
+	job     proto.Job
+	after   string
+	errChan chan error
 }
 
 // Make a JobReaper for use on a running job chain.
@@ -99,8 +114,13 @@ func (f *ChainReaperFactory) MakeRunning() JobReaper {
 			stopChan:          make(chan struct{}),
 			doneChan:          make(chan struct{}),
 			stopMux:           &sync.Mutex{},
+			notifier:          f.Notifier,
 		},
-		runJobChan: f.RunJobChan,
+		runJobChan:   f.RunJobChan,
+		addJobChan:   f.AddJobChan,
+		stopChain:    f.StopChain,
+		checkpointer: f.Checkpointer,
+		finally:      map[string]bool{},
 	}
 }
 
@@ -117,6 +137,7 @@ func (f *ChainReaperFactory) MakeSuspended() JobReaper {
 			stopChan:          make(chan struct{}),
 			doneChan:          make(chan struct{}),
 			stopMux:           &sync.Mutex{},
+			notifier:          f.Notifier,
 		},
 		runnerRepo: f.RunnerRepo,
 	}
@@ -137,6 +158,7 @@ func (f *ChainReaperFactory) MakeStopped() JobReaper {
 			stopMux:           &sync.Mutex{},
 		},
 		runnerRepo: f.RunnerRepo,
+		runFinally: f.RunFinally,
 	}
 }
 
@@ -145,13 +167,20 @@ func (f *ChainReaperFactory) MakeStopped() JobReaper {
 // Job Reaper for running chains.
 type RunningChainReaper struct {
 	reaper
-	runJobChan chan proto.Job // enqueue next jobs to run here
+	runJobChan   chan proto.Job  // enqueue next jobs to run here
+	addJobChan   chan addJob     // jobs to add to the chain
+	stopChain    func()          // stops the chain on strict failure
+	checkpointer Checkpointer    // saves chain checkpoints (optional)
+	finally      map[string]bool // finally jobs enqueued but not reaped yet
 }
 
 // Run reaps jobs when they finish running. For each job reaped, if...
 // - chain is done: save final state + send to RM.
 // - job failed:    retry sequence if possible.
 // - job completed: prepared subsequent jobs and enqueue if runnable.
+// Jobs are added to the chain between reaping jobs, so adding a job does not
+// race with reaping the job it runs after. If checkpointing is enabled, the
+// chain is checkpointed when the reaper starts and after every change.
 func (r *RunningChainReaper) Run() {
 	defer close(r.doneChan)
 
@@ -161,6 +190,12 @@ func (r *RunningChainReaper) Run() {
 		r.Finalize(complete)
 		return
 	}
+	r.checkpoint()
+
+	// The traverser enqueued the runnable jobs, including finally jobs
+	for _, job := range r.chain.FinallyJobs(false) {
+		r.finally[job.Id] = true
+	}
 
 REAPER:
 	for {
@@ -171,6 +206,10 @@ REAPER:
 			if done {
 				break REAPER
 			}
+			r.checkpoint()
+		case aj := <-r.addJobChan:
+			aj.errChan <- r.addJob(aj.job, aj.after)
+			r.checkpoint()
 		case <-r.stopChan:
 			// Don't Finalize the chain when stopping - the stopped or suspended
 			// reaper will take care of that.
@@ -200,17 +239,21 @@ func (r *RunningChainReaper) Stop() {
 // to continue running the chain (or recognizes that the chain is done running).
 //
 // If chain is done: save final state + stop running more jobs.
-// If job failed:    retry sequence if possible.
+// If job failed:    retry sequence if possible, else stop chain if strict failure.
 // If job completed: prepared subsequent jobs and enqueue if runnable.
+// Then finally jobs that are ready to run are enqueued.
 func (r *RunningChainReaper) Reap(job proto.Job) {
 	jLogger := r.logger.WithFields(log.Fields{"job_id": job.Id, "sequence_id": job.SequenceId, "sequence_try": r.chain.SequenceTries(job.Id)})
 
 	// Set the final state of the job in the chain.
 	r.chain.SetJobState(job.Id, job.State)
+	delete(r.finally, job.Id)
 
 	switch job.State {
 	case proto.STATE_COMPLETE:
 		r.chain.IncrementFinishedJobs(1)
+		r.notifySequences(proto.SEQUENCE_EVENT_START, job)
+		r.notifySequences(proto.SEQUENCE_EVENT_COMPLETE, job)
 
 		for _, nextJob := range r.chain.NextJobs(job.Id) {
 			nextJLogger := jLogger.WithFields(log.Fields{"next_job_id": nextJob.Id})
@@ -223,6 +266,11 @@ func (r *RunningChainReaper) Reap(job proto.Job) {
 				nextJob.Data[k] = v
 			}
 
+			// Finally jobs are enqueued below
+			if nextJob.Finally {
+				continue
+			}
+
 			if !r.chain.IsRunnable(nextJob.Id) {
 				nextJLogger.Infof("next job not runnable")
 				continue
@@ -230,13 +278,30 @@ func (r *RunningChainReaper) Reap(job proto.Job) {
 			nextJLogger.Infof("enqueueing next job")
 			r.runJobChan <- nextJob
 		}
+		r.enqueueFinally(jLogger)
 	case proto.STATE_STOPPED:
 		jLogger.Infof("job stopped")
+		r.enqueueFinally(jLogger)
 	default:
 		// Job was NOT successful. The job.Runner already did job retries.
 		// Retry sequence if possible.
 		if !r.chain.CanRetrySequence(job.Id) {
 			jLogger.Warn("job failed, no sequence tries left")
+			r.notifySequences(proto.SEQUENCE_EVENT_FAIL, job)
+
+			// Strict failure: don't run independent jobs and sequences, stop
+			// the chain now. Stopping switches this reaper for the stopped
+			// reaper, which blocks until Run returns, so it's done in a
+			// goroutine. The stopped reaper fails the chain because this
+			// job failed. It also runs finally jobs.
+			if r.chain.StrictFailure() && r.stopChain != nil {
+				if done, _ := r.chain.IsDoneRunning(); !done {
+					jLogger.Warn("strict failure: stopping job chain")
+					go r.stopChain()
+					return
+				}
+			}
+			r.enqueueFinally(jLogger)
 			return
 		}
 		jLogger.Warn("job failed, retrying sequence")
@@ -245,6 +310,49 @@ func (r *RunningChainReaper) Reap(job proto.Job) {
 	}
 }
 
+// enqueueFinally enqueues finally jobs that are ready to run. They're ready when
+// the jobs before them in their sequence are done, which is not always when the
+// previous jobs complete, like when a job fails. A finally job stays pending
+// until it runs, so it's only enqueued once.
+func (r *RunningChainReaper) enqueueFinally(jLogger *log.Entry) {
+	for _, job := range r.chain.FinallyJobs(false) {
+		if r.finally[job.Id] {
+			continue
+		}
+		r.finally[job.Id] = true
+		jLogger.Infof("enqueueing finally job %s", job.Id)
+		r.runJobChan <- job
+	}
+}
+
+// addJob adds a job to the chain (see Chain.AddJob) and enqueues it if the job
+// it runs after has already completed.
+func (r *RunningChainReaper) addJob(job proto.Job, after string) error {
+	job, err := r.chain.AddJob(job, after)
+	if err != nil {
+		return err
+	}
+	jLogger := r.logger.WithFields(log.Fields{"job_id": job.Id})
+	jLogger.Infof("added job %s (%s) after job %s", job.Name, job.Type, after)
+	if r.chain.IsRunnable(job.Id) {
+		jLogger.Infof("enqueueing added job")
+		r.runJobChan <- job
+	}
+	return nil
+}
+
+// checkpoint saves a checkpoint of the chain if checkpointing is enabled. An
+// error is only logged: the chain keeps running, and the last checkpoint saved
+// is used if the Job Runner crashes.
+func (r *RunningChainReaper) checkpoint() {
+	if r.checkpointer == nil {
+		return
+	}
+	if err := r.checkpointer.Save(r.chain.Checkpoint()); err != nil {
+		r.logger.Warnf("error saving checkpoint: %s", err)
+	}
+}
+
 // Finalize determines the final state of the chain and sends it to the Request Manager.
 func (r *RunningChainReaper) Finalize(complete bool) {
 	finishedAt := time.Now().UTC()
@@ -353,6 +461,8 @@ func (r *SuspendedChainReaper) Reap(job proto.Job) {
 		// This gets the chain ready to be resumed later on.
 		if r.chain.CanRetrySequence(job.Id) {
 			r.prepareSequenceRetry(job)
+		} else {
+			r.notifySequences(proto.SEQUENCE_EVENT_FAIL, job)
 		}
 	case proto.STATE_UNKNOWN:
 		jLogger.Warn("job state unknown")
@@ -361,10 +471,14 @@ func (r *SuspendedChainReaper) Reap(job proto.Job) {
 		// This gets the chain ready to be resumed later on.
 		if r.chain.CanRetrySequence(job.Id) {
 			r.prepareSequenceRetry(job)
+		} else {
+			r.notifySequences(proto.SEQUENCE_EVENT_FAIL, job)
 		}
 	case proto.STATE_COMPLETE:
 		jLogger.Infof("job completed")
 		r.chain.IncrementFinishedJobs(1)
+		r.notifySequences(proto.SEQUENCE_EVENT_START, job)
+		r.notifySequences(proto.SEQUENCE_EVENT_COMPLETE, job)
 		// Copy job data to all child jobs.
 		for _, nextJob := range r.chain.NextJobs(job.Id) {
 			for k, v := range job.Data {
@@ -431,9 +545,12 @@ func (r *SuspendedChainReaper) Finalize() {
 type StoppedChainReaper struct {
 	reaper
 	runnerRepo runner.Repo
+	runFinally func(proto.Job) proto.Job // runs a finally job (optional)
 }
 
 // Run reaps jobs when they finish running. For each job reaped, its state is saved.
+// When no jobs are running, it runs finally jobs of sequences that started, then
+// finalizes the chain.
 func (r *StoppedChainReaper) Run() {
 	defer close(r.doneChan)
 
@@ -445,9 +562,10 @@ func (r *StoppedChainReaper) Run() {
 	time.Sleep(runnerRepoWait)
 
 	// If there are already no jobs left to reap, the running reaper must have
-	// finished and finalized the chain before it got switched out for this reaper.
-	// There's nothing left to do, so return right away.
-	if r.runnerRepo.Count() == 0 {
+	// finished and finalized the chain before it got switched out for this reaper,
+	// unless there are finally jobs to run. If not, there's nothing left to do,
+	// so return right away.
+	if r.runnerRepo.Count() == 0 && (r.runFinally == nil || len(r.chain.FinallyJobs(true)) == 0) {
 		return
 	}
 
@@ -475,6 +593,7 @@ REAPER:
 		}
 	}
 
+	r.runFinallyJobs()
 	r.Finalize()
 }
 
@@ -493,6 +612,45 @@ func (r *StoppedChainReaper) Stop() {
 	return
 }
 
+// runFinallyJobs runs the finally jobs that are ready to run now that no other
+// jobs are running, and reaps them, until no more are ready, like finally jobs
+// that run after other finally jobs. If the reaper is stopped, it stops the
+// finally jobs still running, which Finalize marks as failed.
+func (r *StoppedChainReaper) runFinallyJobs() {
+	if r.runFinally == nil {
+		return
+	}
+	for {
+		select {
+		case <-r.stopChan:
+			return
+		default:
+		}
+		jobs := r.chain.FinallyJobs(true)
+		if len(jobs) == 0 {
+			return
+		}
+		doneChan := make(chan proto.Job, len(jobs))
+		for _, job := range jobs {
+			r.logger.Infof("running finally job %s", job.Id)
+			go func(job proto.Job) {
+				doneChan <- r.runFinally(job)
+			}(job)
+		}
+		for range jobs {
+			select {
+			case job := <-doneChan:
+				r.Reap(job)
+			case <-r.stopChan:
+				for _, runner := range r.runnerRepo.Items() {
+					go runner.Stop(proto.STOP_REASON_USER)
+				}
+				return
+			}
+		}
+	}
+}
+
 // reap takes a done job and saves its state.
 func (r *StoppedChainReaper) Reap(job proto.Job) {
 	jLogger := r.logger.WithFields(log.Fields{"job_id": job.Id, "sequence_id": job.SequenceId, "sequence_try": r.chain.SequenceTries(job.Id)})
@@ -547,6 +705,7 @@ type reaper struct {
 	stopped           bool
 	stopChan          chan struct{}
 	doneChan          chan struct{}
+	notifier          Notifier
 }
 
 // Sends the final state of the chain to the Request Manager, retrying a few times
@@ -558,6 +717,7 @@ func (r *reaper) sendFinalState(finishedAt time.Time) {
 		State:        r.chain.State(),
 		FinishedAt:   finishedAt,
 		FinishedJobs: r.chain.FinishedJobs(),
+		Progress:     r.chain.Progress(),
 	}
 	err := retry.Do(r.finalizeTries, r.finalizeRetryWait,
 		func() error {
@@ -601,7 +761,10 @@ func (r *reaper) prepareSequenceRetry(failedJob proto.Job) proto.Job {
 		sequenceJobsToRetry = append(sequenceJobsToRetry, failedJob)
 	}
 
-	// Roll back completed sequence jobs
+	// Roll back completed sequence jobs. Only jobs reachable from the sequence
+	// start job are rolled back, so when the sequence is one branch of an each:
+	// expansion, sibling branches (their own sequences) are not touched: they
+	// keep running or stay complete, and the branch end job waits for this one.
 	// @todo: SPIN-501: Sequence retries don't work right for parallel jobs in the same sequence
 	finishedJobs := 0
 	for _, job := range sequenceJobsToRetry {
@@ -610,6 +773,12 @@ func (r *reaper) prepareSequenceRetry(failedJob proto.Job) proto.Job {
 			finishedJobs += 1
 		}
 
+		// Nested sequences rerun from scratch, too, so roll back their try
+		// count. Else each retry of this sequence uses up their retries.
+		if job.Id != sequenceStartJob.Id && r.chain.IsSequenceStartJob(job.Id) {
+			r.chain.IncrementSequenceTries(job.Id, -1*int(r.chain.SequenceTries(job.Id)))
+		}
+
 		// Job current try count is per-seq try, so roll back to zero.
 		// Job total try count never decrements, so this call only affects
 		// current try count.
@@ -619,6 +788,12 @@ func (r *reaper) prepareSequenceRetry(failedJob proto.Job) proto.Job {
 
 		// Roll back job state to pending so it's runnable again
 		r.chain.SetJobState(job.Id, proto.STATE_PENDING)
+
+		// Roll back job data so the sequence retry starts from the same
+		// inputs as the first try, unless the job keeps its changes
+		if !r.chain.RestoreJobData(job.Id) {
+			seqLogger.Infof("job %s keeps job data on sequence retry", job.Id)
+		}
 	}
 
 	// Roll back finished job count
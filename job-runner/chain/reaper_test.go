@@ -191,6 +191,142 @@ func TestRunningReapFail(t *testing.T) {
 	}
 }
 
+// runningChainReaper.Reap enqueues finally jobs when their sequence is done
+func TestRunningReapFinally(t *testing.T) {
+	// Job Chain:
+	//     2
+	//   /   \
+	// 1      3 - 4
+	//   \   /
+	//     5
+	// Job 3 is a finally job, job 4 runs after jobs 2, 3, and 5.
+	// Testing when job 2 fails, then job 5 completes.
+
+	reqId := "test_running_reap_finally"
+	factory := defaultFactory(reqId)
+	jobs := testutil.InitJobs(5)
+	job3 := jobs["job3"]
+	job3.Finally = true
+	jobs["job3"] = job3
+	jc := &proto.JobChain{
+		RequestId: reqId,
+		Jobs:      jobs,
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job5"},
+			"job2": {"job3", "job4"},
+			"job3": {"job4"},
+			"job5": {"job3", "job4"},
+		},
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	factory.Chain = c
+	runJobChan := make(chan proto.Job, 5)
+	factory.RunJobChan = runJobChan
+	reaper := factory.MakeRunning()
+
+	c.IncrementSequenceTries("job1", 1)
+	c.SetJobState("job1", proto.STATE_COMPLETE)
+	c.SetJobState("job2", proto.STATE_RUNNING)
+	c.SetJobState("job5", proto.STATE_RUNNING)
+
+	// Job 2 failed, but job 5 is still running
+	reaper.(*chain.RunningChainReaper).Reap(proto.Job{Id: "job2", State: proto.STATE_FAIL})
+	select {
+	case gotJob := <-runJobChan:
+		t.Errorf("got job %s from runJobChan, expected no job", gotJob.Id)
+	default:
+	}
+
+	// Job 5 completed: finally job 3 runs once, job 4 does not run
+	reaper.(*chain.RunningChainReaper).Reap(proto.Job{Id: "job5", State: proto.STATE_COMPLETE})
+	reaper.(*chain.RunningChainReaper).Reap(proto.Job{Id: "job1", State: proto.STATE_COMPLETE})
+	close(runJobChan)
+	gotJobs := []string{}
+	for job := range runJobChan {
+		gotJobs = append(gotJobs, job.Id)
+	}
+	if diff := deep.Equal(gotJobs, []string{"job3"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+// runningChainReaper.Reap sends sequence events to sequence webhooks
+func TestRunningReapWebhooks(t *testing.T) {
+	// Job Chain:
+	// 1 - 2 - 3
+	// Testing when job 1 completes, then job 2 fails
+
+	reqId := "test_running_reap_webhooks"
+	factory := defaultFactory(reqId)
+	jc := &proto.JobChain{
+		RequestId: reqId,
+		Jobs:      testutil.InitJobs(3),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+			"job2": {"job3"},
+		},
+		Webhooks: []proto.SequenceWebhook{
+			{
+				URL:        "http://example.com/seq",
+				Sequence:   "seq",
+				StartJobId: "job1",
+				EndJobId:   "job3",
+				JobIds:     []string{"job1", "job2", "job3"},
+				Args:       map[string]interface{}{"host": "host1"},
+			},
+			{
+				URL:        "http://example.com/inner",
+				Events:     []string{proto.SEQUENCE_EVENT_COMPLETE},
+				Sequence:   "inner",
+				StartJobId: "job2",
+				EndJobId:   "job2",
+				JobIds:     []string{"job2"},
+			},
+		},
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	factory.Chain = c
+	factory.RunJobChan = make(chan proto.Job, 5)
+
+	var urls []string
+	var events []proto.SequenceEvent
+	factory.Notifier = &mock.Notifier{
+		NotifyFunc: func(wh proto.SequenceWebhook, ev proto.SequenceEvent) {
+			urls = append(urls, wh.URL)
+			events = append(events, ev)
+		},
+	}
+	reaper := factory.MakeRunning()
+
+	c.IncrementSequenceTries("job1", 1)
+	c.SetJobState("job1", proto.STATE_RUNNING)
+	reaper.(*chain.RunningChainReaper).Reap(proto.Job{Id: "job1", State: proto.STATE_COMPLETE})
+
+	c.SetJobState("job2", proto.STATE_RUNNING)
+	reaper.(*chain.RunningChainReaper).Reap(proto.Job{Id: "job2", State: proto.STATE_FAIL})
+
+	// Outer sequence started and failed, inner sequence only notified on complete
+	expectURLs := []string{"http://example.com/seq", "http://example.com/seq"}
+	if diff := deep.Equal(urls, expectURLs); diff != nil {
+		t.Error(diff)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, expected 2: %+v", len(events), events)
+	}
+	for i, expect := range []proto.SequenceEvent{
+		{Event: proto.SEQUENCE_EVENT_START, RequestId: reqId, Sequence: "seq", StartJobId: "job1", Try: 1, Args: map[string]interface{}{"host": "host1"}},
+		{Event: proto.SEQUENCE_EVENT_FAIL, RequestId: reqId, Sequence: "seq", StartJobId: "job1", Try: 1, JobId: "job2", Args: map[string]interface{}{"host": "host1"}},
+	} {
+		if events[i].Ts.IsZero() {
+			t.Errorf("event %d Ts not set", i)
+		}
+		events[i].Ts = expect.Ts
+		if diff := deep.Equal(events[i], expect); diff != nil {
+			t.Error(diff)
+		}
+	}
+}
+
 // runningChainReaper.Reap on an "unknown" state job (no sequence retry)
 func TestRunningReapUnknown(t *testing.T) {
 	// Job Chain:
@@ -331,6 +467,209 @@ func TestRunningReapFailRetry(t *testing.T) {
 	}
 }
 
+// runningChainReaper.Reap rolls back job data on sequence retry
+func TestRunningReapFailRetryJobData(t *testing.T) {
+	// Job Chain:
+	// 1 - 2 - 3
+	// Testing when job 3 fails (+ sequence is retryable) after jobs 1 and 2
+	// changed job data. Job 2 keeps its job data.
+
+	reqId := "test_running_reap_fail_retry_job_data"
+	factory := defaultFactory(reqId)
+	jobs := testutil.InitJobsWithSequenceRetry(3, 1)
+	job2 := jobs["job2"]
+	job2.KeepData = true
+	jobs["job2"] = job2
+	jc := &proto.JobChain{
+		RequestId: reqId,
+		Jobs:      jobs,
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+			"job2": {"job3"},
+		},
+		FinishedJobs: 2,
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	factory.Chain = c
+
+	runJobChan := make(chan proto.Job, 5)
+	factory.RunJobChan = runJobChan
+	reaper := factory.MakeRunning()
+
+	// Job data from a previous job outside the sequence, saved by the traverser
+	// before the first sequence try
+	jc.Jobs["job1"].Data["host"] = "host1"
+	c.SaveJobData("job1")
+	c.IncrementSequenceTries("job1", 1)
+
+	// First sequence try: jobs change job data, which is copied to next jobs
+	jc.Jobs["job1"].Data["host"] = "host2"
+	jc.Jobs["job1"].Data["try"] = 1
+	for k, v := range jc.Jobs["job1"].Data {
+		jc.Jobs["job2"].Data[k] = v
+	}
+	jc.Jobs["job2"].Data["state"] = "carried"
+	for k, v := range jc.Jobs["job2"].Data {
+		jc.Jobs["job3"].Data[k] = v
+	}
+	jc.Jobs["job3"].Data["partial"] = true
+	c.SetJobState("job1", proto.STATE_COMPLETE)
+	c.SetJobState("job2", proto.STATE_COMPLETE)
+	c.SetJobState("job3", proto.STATE_RUNNING)
+
+	// Job 3 has just failed.
+	job := jc.Jobs["job3"]
+	job.State = proto.STATE_FAIL
+	reaper.(*chain.RunningChainReaper).Reap(job)
+
+	select {
+	case gotJob := <-runJobChan:
+		if gotJob.Id != "job1" {
+			t.Errorf("got job %s from runJobChan, expected job %s", gotJob.Id, "job1")
+		}
+		if diff := deep.Equal(gotJob.Data, map[string]interface{}{"host": "host1"}); diff != nil {
+			t.Errorf("job1 data not rolled back: %v", diff)
+		}
+	default:
+		t.Errorf("no job sent to runJobChan - expected to get job1")
+	}
+
+	// Job 2 keeps its job data
+	expectData := map[string]interface{}{"host": "host2", "try": 1, "state": "carried"}
+	if diff := deep.Equal(jc.Jobs["job2"].Data, expectData); diff != nil {
+		t.Errorf("job2 data changed: %v", diff)
+	}
+
+	if len(jc.Jobs["job3"].Data) != 0 {
+		t.Errorf("job3 data not rolled back: %v", jc.Jobs["job3"].Data)
+	}
+}
+
+// runningChainReaper.Reap retries only the failed branch of an each: expansion
+func TestRunningReapFailRetryBranch(t *testing.T) {
+	// Job Chain:
+	//      2 - 3 - 4
+	//     /         \
+	//  1 -           - 8
+	//     \         /
+	//      5 - 6 - 7
+	// Jobs 2-4 and 5-7 are two branches (sequences) of an each: expansion,
+	// and job 6 is a nested sequence in the second branch. Testing when job 7
+	// fails while the first branch is still running.
+
+	reqId := "test_running_reap_fail_retry_branch"
+	factory := defaultFactory(reqId)
+	jobs := testutil.InitJobsWithSequenceRetry(8, 0)
+	for id, seqId := range map[string]string{
+		"job2": "job2", "job3": "job2", "job4": "job2",
+		"job5": "job5", "job6": "job6", "job7": "job5",
+	} {
+		j := jobs[id]
+		j.SequenceId = seqId
+		if id == seqId {
+			j.SequenceRetry = 1
+		}
+		jobs[id] = j
+	}
+	jc := &proto.JobChain{
+		RequestId: reqId,
+		Jobs:      jobs,
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job5"},
+			"job2": {"job3"},
+			"job3": {"job4"},
+			"job4": {"job8"},
+			"job5": {"job6"},
+			"job6": {"job7"},
+			"job7": {"job8"},
+		},
+		FinishedJobs: 5,
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	factory.Chain = c
+
+	runJobChan := make(chan proto.Job, 5)
+	factory.RunJobChan = runJobChan
+	reaper := factory.MakeRunning()
+
+	for _, id := range []string{"job1", "job2", "job5", "job6"} {
+		c.IncrementSequenceTries(id, 1)
+	}
+	for _, id := range []string{"job1", "job2", "job3", "job5", "job6"} {
+		c.IncrementJobTries(id, 1)
+		c.SetJobState(id, proto.STATE_COMPLETE)
+	}
+	c.SetJobState("job4", proto.STATE_RUNNING)
+	c.SetJobState("job7", proto.STATE_RUNNING)
+
+	// Job 7 has just failed.
+	job := proto.Job{
+		Id:         "job7",
+		State:      proto.STATE_FAIL,
+		SequenceId: "job5",
+	}
+	reaper.(*chain.RunningChainReaper).Reap(job)
+
+	// Only the failed branch is (re)sent to runJobChan
+	select {
+	case gotJob := <-runJobChan:
+		if gotJob.Id != "job5" {
+			t.Errorf("got job %s from runJobChan, expected job %s", gotJob.Id, "job5")
+		}
+	default:
+		t.Errorf("no job sent to runJobChan - expected to get job5")
+	}
+	select {
+	case gotJob := <-runJobChan:
+		t.Errorf("job %s sent to runJobChan - expected only job5", gotJob.Id)
+	default:
+	}
+
+	// Failed branch is rolled back, the other branch and jobs outside the
+	// expansion are not
+	expectState := map[string]byte{
+		"job1": proto.STATE_COMPLETE,
+		"job2": proto.STATE_COMPLETE,
+		"job3": proto.STATE_COMPLETE,
+		"job4": proto.STATE_RUNNING,
+		"job5": proto.STATE_PENDING,
+		"job6": proto.STATE_PENDING,
+		"job7": proto.STATE_PENDING,
+		"job8": proto.STATE_PENDING,
+	}
+	for id, expect := range expectState {
+		if got := c.JobState(id); got != expect {
+			t.Errorf("%s state in chain = %d, expected state = %d", id, got, expect)
+		}
+	}
+	if got := c.FinishedJobs(); got != 3 {
+		t.Errorf("got %d finished jobs, expected 3", got)
+	}
+
+	// Sequence try counts: the failed branch is not incremented yet (that's
+	// done in traverser.runJobs), the other branch is untouched, and the
+	// nested sequence in the failed branch starts over
+	expectTries := map[string]uint{
+		"job1": 1,
+		"job2": 1,
+		"job5": 1,
+		"job6": 0,
+	}
+	for id, expect := range expectTries {
+		if got := c.SequenceTries(id); got != expect {
+			t.Errorf("sequence %s try count = %d, expected %d", id, got, expect)
+		}
+	}
+	for _, id := range []string{"job5", "job6", "job7"} {
+		if cur, _ := c.JobTries(id); cur != 0 {
+			t.Errorf("%s current try count = %d, expected 0", id, cur)
+		}
+	}
+	if cur, _ := c.JobTries("job3"); cur != 1 {
+		t.Errorf("job3 current try count = %d, expected 1", cur)
+	}
+}
+
 // runningChainReaper.Reap on a "unknown" state job whose sequence can be retried
 func TestRunningReapUnknownRetry(t *testing.T) {
 	// Job Chain:
@@ -916,6 +1255,88 @@ func TestStoppedReaper(t *testing.T) {
 	}
 }
 
+// stoppedChainReaper.Run runs finally jobs when no jobs are running
+func TestStoppedReaperFinally(t *testing.T) {
+	// Job Chain:
+	// 1 - 2 - 3 - 4
+	//      \_____/
+	// Job 3 is a finally job, job 2 running
+
+	reqId := "test_stopped_reaper_finally"
+	jobs := testutil.InitJobs(4)
+	job3 := jobs["job3"]
+	job3.Finally = true
+	jobs["job3"] = job3
+	jc := &proto.JobChain{
+		RequestId: reqId,
+		Jobs:      jobs,
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+			"job2": {"job3", "job4"},
+			"job3": {"job4"},
+		},
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+
+	var receivedState byte
+	rmc := &mock.RMClient{
+		FinishRequestFunc: func(fr proto.FinishRequest) error {
+			receivedState = fr.State
+			return nil
+		},
+	}
+	doneJobChan := make(chan proto.Job)
+	runnerRepo := runner.NewRepo()
+	ran := []string{}
+	factory := &chain.ChainReaperFactory{
+		Chain:        c,
+		RMClient:     rmc,
+		Logger:       log.WithFields(log.Fields{"requestId": reqId}),
+		RMCTries:     5,
+		RMCRetryWait: 50 * time.Millisecond,
+		DoneJobChan:  doneJobChan,
+		RunJobChan:   make(chan proto.Job),
+		RunnerRepo:   runnerRepo,
+		RunFinally: func(job proto.Job) proto.Job {
+			ran = append(ran, job.Id)
+			job.State = proto.STATE_COMPLETE
+			return job
+		},
+	}
+	reaper := factory.MakeStopped()
+
+	c.IncrementSequenceTries("job1", 1)
+	c.SetJobState("job1", proto.STATE_COMPLETE)
+	c.SetJobState("job2", proto.STATE_RUNNING)
+	runnerRepo.Set("job2", &mock.Runner{})
+
+	doneChan := make(chan struct{})
+	go func() {
+		reaper.Run()
+		close(doneChan)
+	}()
+
+	job2 := jc.Jobs["job2"]
+	job2.State = proto.STATE_STOPPED
+	doneJobChan <- job2
+	runnerRepo.Remove("job2")
+
+	<-doneChan // wait for reaper to finish
+
+	if diff := deep.Equal(ran, []string{"job3"}); diff != nil {
+		t.Error(diff)
+	}
+	if c.JobState("job3") != proto.STATE_COMPLETE {
+		t.Errorf("got state %s for job3, expected state %s", proto.StateName[c.JobState("job3")], proto.StateName[proto.STATE_COMPLETE])
+	}
+	if c.JobState("job4") != proto.STATE_PENDING {
+		t.Errorf("got state %s for job4, expected state %s", proto.StateName[c.JobState("job4")], proto.StateName[proto.STATE_PENDING])
+	}
+	if receivedState != proto.STATE_STOPPED {
+		t.Errorf("chain state %s sent to RM client, expected state %s", proto.StateName[receivedState], proto.StateName[proto.STATE_STOPPED])
+	}
+}
+
 // test stoppedChainReaper.Run + .Stop
 func TestStoppedReaperStop(t *testing.T) {
 	// Job Chain:
@@ -0,0 +1,105 @@
+// Copyright 2020, Square, Inc.
+
+package chain
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/square/spincycle/v2/metrics"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// A Retainer keeps the status of chains that are done (complete, failed, stopped,
+// or suspended) in memory for a while after they're done, so they can still be
+// queried and a late stop does not fail, then removes them. Only the status is
+// kept, not the chain, so retained chains use little memory. Chains are retained
+// for exactly the retention: Get and All do not return chains retained longer,
+// even if GC has not removed them yet.
+type Retainer struct {
+	retention time.Duration
+	metrics   metrics.Metrics
+	chains    map[string]proto.ChainStatus // keyed on request ID
+	*sync.Mutex
+}
+
+// NewRetainer makes a Retainer that keeps done chains for the retention. If the
+// retention is zero, chains are not retained.
+func NewRetainer(retention time.Duration, m metrics.Metrics) *Retainer {
+	if m == nil {
+		m = metrics.Nop{}
+	}
+	return &Retainer{
+		retention: retention,
+		metrics:   m,
+		chains:    map[string]proto.ChainStatus{},
+		Mutex:     &sync.Mutex{},
+	}
+}
+
+// Add retains the status of the chain, which is done.
+func (r *Retainer) Add(c *Chain) {
+	if r.retention <= 0 {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.chains[c.RequestId()] = proto.ChainStatus{
+		RequestId:    c.RequestId(),
+		State:        c.State(),
+		FinishedJobs: c.FinishedJobs(),
+		Progress:     c.Progress(),
+		DoneAt:       time.Now().UnixNano(),
+	}
+	r.metrics.Gauge(metrics.CHAINS_RETAINED, float64(len(r.chains)), nil)
+}
+
+// Get returns the status of the chain if it's retained.
+func (r *Retainer) Get(requestId string) (proto.ChainStatus, bool) {
+	r.Lock()
+	defer r.Unlock()
+	cs, ok := r.chains[requestId]
+	if !ok || r.expired(cs, time.Now()) {
+		return proto.ChainStatus{}, false
+	}
+	return cs, true
+}
+
+// All returns the status of all retained chains, sorted by request ID.
+func (r *Retainer) All() []proto.ChainStatus {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	all := make([]proto.ChainStatus, 0, len(r.chains))
+	for _, cs := range r.chains {
+		if !r.expired(cs, now) {
+			all = append(all, cs)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].RequestId < all[j].RequestId })
+	return all
+}
+
+// GC removes chains retained longer than the retention as of now, and returns
+// how many were removed. It's called periodically by the Job Runner server.
+func (r *Retainer) GC(now time.Time) int {
+	r.Lock()
+	defer r.Unlock()
+	n := 0
+	for requestId, cs := range r.chains {
+		if r.expired(cs, now) {
+			delete(r.chains, requestId)
+			n++
+		}
+	}
+	if n > 0 {
+		r.metrics.Count(metrics.CHAINS_COLLECTED, int64(n), nil)
+	}
+	r.metrics.Gauge(metrics.CHAINS_RETAINED, float64(len(r.chains)), nil)
+	return n
+}
+
+func (r *Retainer) expired(cs proto.ChainStatus, now time.Time) bool {
+	return now.Sub(time.Unix(0, cs.DoneAt)) > r.retention
+}
@@ -0,0 +1,67 @@
+// Copyright 2020, Square, Inc.
+
+package chain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/spincycle/v2/job-runner/chain"
+	"github.com/square/spincycle/v2/proto"
+	testutil "github.com/square/spincycle/v2/test"
+)
+
+func TestRetainer(t *testing.T) {
+	r := chain.NewRetainer(time.Minute, nil)
+
+	for _, requestId := range []string{"req2", "req1"} {
+		jc := &proto.JobChain{
+			RequestId: requestId,
+			Jobs:      testutil.InitJobs(1),
+		}
+		c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+		c.SetState(proto.STATE_COMPLETE)
+		r.Add(c)
+	}
+
+	cs, ok := r.Get("req1")
+	if !ok {
+		t.Fatal("req1 not retained")
+	}
+	if cs.RequestId != "req1" || cs.State != proto.STATE_COMPLETE || cs.DoneAt == 0 {
+		t.Errorf("got %+v, expected req1 in state COMPLETE with DoneAt set", cs)
+	}
+	if _, ok := r.Get("req3"); ok {
+		t.Error("req3 retained, expected it not to be")
+	}
+
+	all := r.All()
+	if len(all) != 2 || all[0].RequestId != "req1" || all[1].RequestId != "req2" {
+		t.Errorf("got %+v, expected req1 and req2", all)
+	}
+
+	// Nothing expired yet
+	if n := r.GC(time.Now()); n != 0 {
+		t.Errorf("GC removed %d chains, expected 0", n)
+	}
+
+	// Both expired after the retention
+	if n := r.GC(time.Now().Add(2 * time.Minute)); n != 2 {
+		t.Errorf("GC removed %d chains, expected 2", n)
+	}
+	if _, ok := r.Get("req1"); ok {
+		t.Error("req1 still retained after GC")
+	}
+}
+
+func TestRetainerDisabled(t *testing.T) {
+	r := chain.NewRetainer(0, nil)
+	jc := &proto.JobChain{
+		RequestId: "req1",
+		Jobs:      testutil.InitJobs(1),
+	}
+	r.Add(chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint)))
+	if _, ok := r.Get("req1"); ok {
+		t.Error("req1 retained with zero retention, expected not retained")
+	}
+}
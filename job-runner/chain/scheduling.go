@@ -0,0 +1,67 @@
+// Copyright 2020, Square, Inc.
+
+package chain
+
+import (
+	"sync"
+	"time"
+
+	"github.com/square/spincycle/v2/proto"
+)
+
+// schedulingStats tracks scheduling latency for one chain: the time between a
+// job being runnable (received by runJobs) and its runner starting to run it.
+// Time spent in a sequence retry wait is deliberate, so it's not counted.
+type schedulingStats struct {
+	*sync.Mutex
+	queued    uint
+	started   uint
+	waitTotal time.Duration
+	waitMax   time.Duration
+}
+
+func newSchedulingStats() *schedulingStats {
+	return &schedulingStats{Mutex: &sync.Mutex{}}
+}
+
+// queue counts a job waiting for a runner and returns when it started waiting,
+// which the caller passes to dequeue.
+func (s *schedulingStats) queue() time.Time {
+	s.Lock()
+	s.queued++
+	s.Unlock()
+	return time.Now()
+}
+
+// dequeue uncounts a waiting job. If the job started running, its wait time
+// is recorded; otherwise (e.g. error making its runner), it's not.
+func (s *schedulingStats) dequeue(queuedAt time.Time, started bool) {
+	wait := time.Now().Sub(queuedAt)
+	s.Lock()
+	defer s.Unlock()
+	s.queued--
+	if !started {
+		return
+	}
+	s.started++
+	s.waitTotal += wait
+	if wait > s.waitMax {
+		s.waitMax = wait
+	}
+}
+
+func (s *schedulingStats) stats(requestId string) proto.SchedulingStats {
+	s.Lock()
+	defer s.Unlock()
+	ss := proto.SchedulingStats{
+		RequestId: requestId,
+		Queued:    s.queued,
+		Started:   s.started,
+		WaitTotal: s.waitTotal.Nanoseconds(),
+		WaitMax:   s.waitMax.Nanoseconds(),
+	}
+	if s.started > 0 {
+		ss.WaitAvg = ss.WaitTotal / int64(s.started)
+	}
+	return ss
+}
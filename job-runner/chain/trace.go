@@ -0,0 +1,221 @@
+// Copyright 2020, Square, Inc.
+
+package chain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/square/spincycle/v2/job-runner/runner"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// Trace event types.
+const (
+	TRACE_JOB_START = "job-start" // runner.Run called
+	TRACE_JOB_DONE  = "job-done"  // runner.Run returned, or runner could not be made
+)
+
+// A TraceEvent is one job state transition in a chain run, in the order that
+// the traverser observed it.
+type TraceEvent struct {
+	Seq      uint   `json:"seq"`      // 1-indexed order of the event in the trace
+	Type     string `json:"type"`     // TRACE_* const
+	JobId    string `json:"jobId"`    // job the event is for
+	State    byte   `json:"state"`    // job proto.STATE_* after the event
+	Tries    uint   `json:"tries"`    // runner.Return.Tries (TRACE_JOB_DONE only)
+	SeqTries uint   `json:"seqTries"` // sequence tries when the event happened
+}
+
+// A Trace is the ordered sequence of job state transitions of one chain run.
+// It is recorded by a TraceRecorder and replayed by a Replayer.
+type Trace struct {
+	RequestId string       `json:"requestId"`
+	Events    []TraceEvent `json:"events"`
+}
+
+// A TraceRecorder records a Trace of a chain run. Set TraverserConfig.Recorder
+// to record the traverser's chain run. A nil TraceRecorder records nothing.
+type TraceRecorder struct {
+	*sync.Mutex
+	trace Trace
+}
+
+// NewTraceRecorder returns a new TraceRecorder for the request.
+func NewTraceRecorder(requestId string) *TraceRecorder {
+	return &TraceRecorder{
+		Mutex: &sync.Mutex{},
+		trace: Trace{
+			RequestId: requestId,
+			Events:    []TraceEvent{},
+		},
+	}
+}
+
+// Record records one event. Events are ordered by when Record is called.
+func (r *TraceRecorder) Record(ev TraceEvent) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	ev.Seq = uint(len(r.trace.Events) + 1)
+	r.trace.Events = append(r.trace.Events, ev)
+}
+
+// Trace returns a copy of the events recorded so far.
+func (r *TraceRecorder) Trace() Trace {
+	r.Lock()
+	defer r.Unlock()
+	events := make([]TraceEvent, len(r.trace.Events))
+	copy(events, r.trace.Events)
+	return Trace{
+		RequestId: r.trace.RequestId,
+		Events:    events,
+	}
+}
+
+// -------------------------------------------------------------------------- //
+
+// A Replayer re-drives a traverser with the job results in a recorded Trace.
+// It is a runner.Factory that makes runners which do not run real jobs: each
+// runner waits until the trace says its job starts, then waits until the trace
+// says its job is done and returns the recorded final state and tries. This
+// forces jobs to start and finish in exactly the recorded order, so races in
+// the traverser and reapers can be reproduced deterministically. (Jobs started
+// concurrently are released in trace order, but the traverser records their
+// start events before calling runner.Run, so a trace recorded during replay can
+// order those start events differently.)
+//
+// If the traverser does something the trace does not (e.g. runs a job that is
+// not next in the trace), the job waits Timeout then fails, and Err returns
+// the divergence.
+type Replayer struct {
+	Timeout time.Duration // how long a runner waits for its turn (default: 5s)
+	// --
+	trace   Trace
+	mux     *sync.Mutex
+	next    int           // index of next event in trace
+	changed chan struct{} // closed and replaced when next changes
+	err     error         // first divergence from trace
+}
+
+var _ runner.Factory = &Replayer{}
+
+// NewReplayer returns a Replayer for the trace.
+func NewReplayer(trace Trace) *Replayer {
+	return &Replayer{
+		Timeout: 5 * time.Second,
+		trace:   trace,
+		mux:     &sync.Mutex{},
+		changed: make(chan struct{}),
+	}
+}
+
+// Make makes a runner for the job that replays the job's events in the trace.
+func (rp *Replayer) Make(job proto.Job, req runner.Request, prevTries, totalTries uint) (runner.Runner, error) {
+	return &replayRunner{
+		rp:       rp,
+		job:      job,
+		stopChan: make(chan struct{}),
+		mux:      &sync.Mutex{},
+	}, nil
+}
+
+// Done returns true if all events in the trace have been replayed.
+func (rp *Replayer) Done() bool {
+	rp.mux.Lock()
+	defer rp.mux.Unlock()
+	return rp.next == len(rp.trace.Events)
+}
+
+// Err returns the first divergence from the trace, or nil if there is none.
+func (rp *Replayer) Err() error {
+	rp.mux.Lock()
+	defer rp.mux.Unlock()
+	return rp.err
+}
+
+// wait blocks until the next event in the trace is evType for jobId, then
+// consumes and returns it.
+func (rp *Replayer) wait(evType, jobId string, stopChan chan struct{}) (TraceEvent, error) {
+	timeout := time.After(rp.Timeout)
+	for {
+		rp.mux.Lock()
+		if rp.next < len(rp.trace.Events) {
+			ev := rp.trace.Events[rp.next]
+			if ev.Type == evType && ev.JobId == jobId {
+				rp.next++
+				close(rp.changed)
+				rp.changed = make(chan struct{})
+				rp.mux.Unlock()
+				return ev, nil
+			}
+		}
+		changed := rp.changed
+		rp.mux.Unlock()
+
+		select {
+		case <-changed:
+		case <-stopChan:
+			return TraceEvent{}, fmt.Errorf("stopped")
+		case <-timeout:
+			rp.mux.Lock()
+			defer rp.mux.Unlock()
+			err := fmt.Errorf("job %s %s not next in trace at event %d of %d", jobId, evType, rp.next+1, len(rp.trace.Events))
+			if rp.err == nil {
+				rp.err = err
+			}
+			return TraceEvent{}, err
+		}
+	}
+}
+
+type replayRunner struct {
+	rp       *Replayer
+	job      proto.Job
+	stopChan chan struct{}
+	mux      *sync.Mutex
+	stopped  bool
+}
+
+func (r *replayRunner) Run(jobData map[string]interface{}) runner.Return {
+	if _, err := r.rp.wait(TRACE_JOB_START, r.job.Id, r.stopChan); err != nil {
+		return r.errReturn()
+	}
+	ev, err := r.rp.wait(TRACE_JOB_DONE, r.job.Id, r.stopChan)
+	if err != nil {
+		return r.errReturn()
+	}
+	return runner.Return{
+		FinalState: ev.State,
+		Tries:      ev.Tries,
+	}
+}
+
+func (r *replayRunner) errReturn() runner.Return {
+	select {
+	case <-r.stopChan:
+		return runner.Return{FinalState: proto.STATE_STOPPED, Tries: 1}
+	default:
+		return runner.Return{FinalState: proto.STATE_FAIL, Tries: 1}
+	}
+}
+
+func (r *replayRunner) Stop(reason string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if !r.stopped {
+		close(r.stopChan)
+		r.stopped = true
+	}
+	return nil
+}
+
+func (r *replayRunner) Status() runner.Status {
+	return runner.Status{
+		Job:    r.job,
+		Status: "replay",
+	}
+}
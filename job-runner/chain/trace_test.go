@@ -0,0 +1,157 @@
+// Copyright 2020, Square, Inc.
+
+package chain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/square/spincycle/v2/job-runner/chain"
+	"github.com/square/spincycle/v2/job-runner/runner"
+	"github.com/square/spincycle/v2/proto"
+	testutil "github.com/square/spincycle/v2/test"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+// Job Chain:
+//      2
+//     / \
+// -> 1   4
+//     \ /
+//      3
+func traceTestChain(requestId string) *chain.Chain {
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(4),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+			"job2": {"job4"},
+			"job3": {"job4"},
+		},
+	}
+	return chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+}
+
+func doneOrder(trace chain.Trace) []string {
+	jobIds := []string{}
+	for _, ev := range trace.Events {
+		if ev.Type == chain.TRACE_JOB_DONE {
+			jobIds = append(jobIds, ev.JobId)
+		}
+	}
+	return jobIds
+}
+
+func TestTraceRecordAndReplay(t *testing.T) {
+	requestId := "test_trace_replay"
+
+	// Record a run where job3 finishes before job2
+	job3Done := make(chan struct{})
+	complete := func(jobData map[string]interface{}) byte { return proto.STATE_COMPLETE }
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{RunFunc: complete, RunReturn: runner.Return{Tries: 1}},
+			"job2": &mock.Runner{RunFunc: func(jobData map[string]interface{}) byte {
+				<-job3Done
+				return proto.STATE_COMPLETE
+			}, RunReturn: runner.Return{Tries: 1}},
+			"job3": &mock.Runner{RunFunc: func(jobData map[string]interface{}) byte {
+				close(job3Done)
+				return proto.STATE_COMPLETE
+			}, RunReturn: runner.Return{Tries: 1}},
+			"job4": &mock.Runner{RunFunc: complete, RunReturn: runner.Return{Tries: 1}},
+		},
+	}
+	recorder := chain.NewTraceRecorder(requestId)
+	c := traceTestChain(requestId)
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chain.NewMemoryRepo(),
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+		Recorder:      recorder,
+	})
+	traverser.Run()
+
+	if c.State() != proto.STATE_COMPLETE {
+		t.Fatalf("chain state = %d, expected %d", c.State(), proto.STATE_COMPLETE)
+	}
+	trace := recorder.Trace()
+	if len(trace.Events) != 8 {
+		t.Fatalf("got %d trace events, expected 8: %+v", len(trace.Events), trace.Events)
+	}
+	expectOrder := []string{"job1", "job3", "job2", "job4"}
+	if diff := deep.Equal(doneOrder(trace), expectOrder); diff != nil {
+		t.Error(diff)
+	}
+
+	// Replay the trace with new a chain. Jobs should finish in the same order.
+	replayer := chain.NewReplayer(trace)
+	replayRecorder := chain.NewTraceRecorder(requestId)
+	c = traceTestChain(requestId)
+	traverser = chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chain.NewMemoryRepo(),
+		RunnerFactory: replayer,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+		Recorder:      replayRecorder,
+	})
+	traverser.Run()
+
+	if err := replayer.Err(); err != nil {
+		t.Errorf("replay error: %s", err)
+	}
+	if !replayer.Done() {
+		t.Errorf("replayer not done, expected all events replayed")
+	}
+	if c.State() != proto.STATE_COMPLETE {
+		t.Errorf("replayed chain state = %d, expected %d", c.State(), proto.STATE_COMPLETE)
+	}
+	if diff := deep.Equal(doneOrder(replayRecorder.Trace()), expectOrder); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestTraceReplayDiverges(t *testing.T) {
+	requestId := "test_trace_diverge"
+
+	// job4 cannot run before job2 and job3
+	trace := chain.Trace{
+		RequestId: requestId,
+		Events: []chain.TraceEvent{
+			{Seq: 1, Type: chain.TRACE_JOB_START, JobId: "job1", State: proto.STATE_RUNNING},
+			{Seq: 2, Type: chain.TRACE_JOB_DONE, JobId: "job1", State: proto.STATE_COMPLETE, Tries: 1},
+			{Seq: 3, Type: chain.TRACE_JOB_START, JobId: "job4", State: proto.STATE_RUNNING},
+		},
+	}
+	replayer := chain.NewReplayer(trace)
+	replayer.Timeout = 50 * time.Millisecond
+	c := traceTestChain(requestId)
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chain.NewMemoryRepo(),
+		RunnerFactory: replayer,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
+	traverser.Run()
+
+	if replayer.Err() == nil {
+		t.Errorf("no replay error, expected one")
+	}
+	if replayer.Done() {
+		t.Errorf("replayer done, expected events not replayed")
+	}
+	if c.State() != proto.STATE_FAIL {
+		t.Errorf("chain state = %d, expected %d", c.State(), proto.STATE_FAIL)
+	}
+}
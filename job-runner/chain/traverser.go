@@ -4,13 +4,16 @@ package chain
 
 import (
 	"fmt"
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/square/spincycle/v2/config"
 	"github.com/square/spincycle/v2/job-runner/runner"
+	"github.com/square/spincycle/v2/metrics"
 	"github.com/square/spincycle/v2/proto"
 	rm "github.com/square/spincycle/v2/request-manager"
 	"github.com/square/spincycle/v2/retry"
@@ -19,6 +22,13 @@ import (
 var (
 	// Returned when Stop is called but the chain has already been suspended.
 	ErrShuttingDown = fmt.Errorf("chain not stopped because traverser is shutting down")
+
+	// Returned when AddJob is called but the chain is done, stopped, or suspended.
+	ErrNotRunning = fmt.Errorf("job not added because chain is not running")
+
+	// Returned when Pause or Resume is called but the chain is done, stopped,
+	// or suspended.
+	ErrNotPausable = fmt.Errorf("chain not paused or resumed because it is not running")
 )
 
 const (
@@ -55,6 +65,34 @@ type Traverser interface {
 	// Running returns all currently running jobs. The status.Manager uses this
 	// to report running status.
 	Running() []proto.JobStatus
+
+	// Held returns runnable jobs that are not running yet, and why. The
+	// status.Manager uses this to report held jobs.
+	Held() []proto.JobStatus
+
+	// Scheduling returns scheduling latency stats for the chain. The
+	// status.Manager uses this to report scheduling status.
+	Scheduling() proto.SchedulingStats
+
+	// Debug returns goroutine and job runner accounting for the chain. The
+	// status.Manager uses this to report debug status.
+	Debug() proto.ChainDebug
+
+	// AddJob adds a job to the running chain after the given job (see
+	// Chain.AddJob). It returns ErrNotRunning if the chain is not running,
+	// or ErrInvalidChain if the job cannot be added.
+	AddJob(job proto.Job, after string) error
+
+	// Pause makes a traverser stop starting new jobs until Resume is called.
+	// Running jobs keep running, and jobs that become runnable wait. The chain
+	// is not suspended, so the request keeps running in the RM. Pausing a
+	// paused chain, or resuming a chain that's not paused, does nothing. Both
+	// return ErrNotPausable if the chain is done, stopped, or suspended.
+	Pause() error
+
+	// Resume makes a paused traverser start jobs again, starting the jobs
+	// that became runnable while it was paused.
+	Resume() error
 }
 
 // A TraverserFactory makes a new Traverser.
@@ -63,19 +101,40 @@ type TraverserFactory interface {
 	MakeFromSJC(*proto.SuspendedJobChain) (Traverser, error)
 }
 
+// A Checkpointer saves checkpoints of running chains so that a Job Runner that
+// crashes can recover them when it restarts (see package checkpoint). Traversers
+// save a checkpoint after every job is reaped and remove it when the chain is done.
+type Checkpointer interface {
+	Save(proto.SuspendedJobChain) error
+	Remove(requestId string) error
+}
+
+// TraverserFactoryConfig configures the traversers made by a TraverserFactory:
+// the TraverserConfig fields that are the same for every chain.
+type TraverserFactoryConfig struct {
+	ChainRepo     Repo
+	RunnerFactory runner.Factory
+	RMClient      rm.Client
+	ShutdownChan  chan struct{}
+	Metrics       metrics.Metrics    // optional: report jobs run (default metrics.Nop)
+	Retainer      *Retainer          // optional: retain chains when done
+	Checkpointer  Checkpointer       // optional: checkpoint chains while running
+	Watchdog      *Watchdog          // optional: warn about slow jobs
+	Workspaces    *runner.Workspaces // optional: remove job workspaces when done
+	QueueFactory  QueueFactory       // optional: make the queue of each chain (default NewFIFOQueue)
+	Concurrency   config.Concurrency // optional: limit jobs running at once per chain (default no limit)
+}
+
 type traverserFactory struct {
-	chainRepo    Repo
-	rf           runner.Factory
-	rmc          rm.Client
-	shutdownChan chan struct{}
+	cfg      TraverserFactoryConfig
+	notifier Notifier
 }
 
-func NewTraverserFactory(chainRepo Repo, rf runner.Factory, rmc rm.Client, shutdownChan chan struct{}) TraverserFactory {
+// NewTraverserFactory makes a TraverserFactory.
+func NewTraverserFactory(cfg TraverserFactoryConfig) TraverserFactory {
 	return &traverserFactory{
-		chainRepo:    chainRepo,
-		rf:           rf,
-		rmc:          rmc,
-		shutdownChan: shutdownChan,
+		cfg:      cfg,
+		notifier: NewNotifier(&http.Client{Timeout: defaultTimeout}),
 	}
 }
 
@@ -89,6 +148,18 @@ func (f *traverserFactory) Make(jobChain *proto.JobChain) (Traverser, error) {
 
 // MakeFromSJC makes a Traverser from a suspended job chain.
 func (f *traverserFactory) MakeFromSJC(sjc *proto.SuspendedJobChain) (Traverser, error) {
+	// Tries maps can be missing (null in JSON) if no job has run. The chain
+	// updates them, so make them.
+	if sjc.TotalJobTries == nil {
+		sjc.TotalJobTries = map[string]uint{}
+	}
+	if sjc.LatestRunJobTries == nil {
+		sjc.LatestRunJobTries = map[string]uint{}
+	}
+	if sjc.SequenceTries == nil {
+		sjc.SequenceTries = map[string]uint{}
+	}
+
 	// Convert/wrap chain from proto to Go object.
 	chain := NewChain(sjc.JobChain, sjc.SequenceTries, sjc.TotalJobTries, sjc.LatestRunJobTries)
 	logger := log.WithFields(log.Fields{"request_id": sjc.RequestId})
@@ -126,7 +197,7 @@ func (f *traverserFactory) make(chain *Chain) (Traverser, error) {
 	// Add chain to repo. This used to save the chain in Redis, if configured,
 	// but now it's only an in-memory map. The only functionality it serves is
 	// preventing this JR instance from running the same job chain.
-	if err := f.chainRepo.Add(chain); err != nil {
+	if err := f.cfg.ChainRepo.Add(chain); err != nil {
 		return nil, fmt.Errorf("error adding job chain: %s", err)
 	}
 
@@ -136,13 +207,23 @@ func (f *traverserFactory) make(chain *Chain) (Traverser, error) {
 	// chain is done.
 	cfg := TraverserConfig{
 		Chain:         chain,
-		ChainRepo:     f.chainRepo,
-		RunnerFactory: f.rf,
-		RMClient:      f.rmc,
-		ShutdownChan:  f.shutdownChan,
+		ChainRepo:     f.cfg.ChainRepo,
+		RunnerFactory: f.cfg.RunnerFactory,
+		RMClient:      f.cfg.RMClient,
+		Notifier:      f.notifier,
+		Metrics:       f.cfg.Metrics,
+		Retainer:      f.cfg.Retainer,
+		Checkpointer:  f.cfg.Checkpointer,
+		Watchdog:      f.cfg.Watchdog,
+		Workspaces:    f.cfg.Workspaces,
+		Concurrency:   f.cfg.Concurrency,
+		ShutdownChan:  f.cfg.ShutdownChan,
 		StopTimeout:   defaultTimeout,
 		SendTimeout:   defaultTimeout,
 	}
+	if f.cfg.QueueFactory != nil {
+		cfg.Queue = f.cfg.QueueFactory(chain)
+	}
 	return NewTraverser(cfg), nil
 }
 
@@ -153,23 +234,42 @@ type traverser struct {
 	reaper        JobReaper
 
 	shutdownChan chan struct{}  // indicates JR is shutting down
-	runJobChan   chan proto.Job // jobs to be run
+	runJobChan   chan proto.Job // jobs to be run, pushed to queue
+	queue        Queue          // runnable jobs in the order to run them
 	doneJobChan  chan proto.Job // jobs that are done
+	addJobChan   chan addJob    // jobs to add, received by running reaper
+	runningChan  chan struct{}  // closed when running reaper is done
 	doneChan     chan struct{}  // closed when traverser finishes running
 
 	stopMux     *sync.RWMutex // lock around checks to stopped
 	stopped     bool          // has traverser been stopped
 	suspended   bool          // has traverser been suspended
 	stopChan    chan struct{} // don't run jobs in runJobs
+	resumeChan  chan struct{} // closed on resume; nil unless paused (guarded by stopMux)
 	pendingChan chan struct{} // runJobs closes on return
 	pending     int64         // N runJob goroutines are pending runnerRepo.Set
+	goroutines  int64         // N runJob goroutines (Debug)
+	queued      int64         // N jobs pushed to queue, not popped (Debug)
+	sending     int64         // N runJob goroutines sending to doneJobChan (Debug)
+
+	chain        *Chain
+	chainRepo    Repo               // stores all currently running chains
+	retainer     *Retainer          // retains chain when done (optional)
+	checkpointer Checkpointer       // checkpoints chain while running (optional)
+	watchdog     *Watchdog          // warns about slow jobs (optional)
+	notifier     Notifier           // sends sequence events: webhooks (reaper) and escalations (optional)
+	workspaces   *runner.Workspaces // removes job workspaces when done (optional)
+	rf           runner.Factory
+	runnerRepo   runner.Repo // stores actively running jobs
+	rmc          rm.Client
+	recorder     *TraceRecorder // records job state transitions (optional)
+	metrics      metrics.Metrics
+	sched        *schedulingStats
+	limiter      *limiter // limits jobs running at once (nil if no limit)
+	logger       *log.Entry
 
-	chain      *Chain
-	chainRepo  Repo // stores all currently running chains
-	rf         runner.Factory
-	runnerRepo runner.Repo // stores actively running jobs
-	rmc        rm.Client
-	logger     *log.Entry
+	pacers    map[string]*pacer // paced fan-outs, keyed on Job.Pace
+	pacersMux *sync.Mutex
 
 	stopTimeout time.Duration // Time to wait for jobs to stop
 	sendTimeout time.Duration // Time to wait for a job to send on doneJobChan.
@@ -183,6 +283,15 @@ type TraverserConfig struct {
 	ShutdownChan  chan struct{}
 	StopTimeout   time.Duration
 	SendTimeout   time.Duration
+	Recorder      *TraceRecorder     // optional: record a replayable trace of the run
+	Notifier      Notifier           // optional: send sequence events to sequence webhooks and escalations
+	Metrics       metrics.Metrics    // optional: report jobs run (default metrics.Nop)
+	Retainer      *Retainer          // optional: retain the chain when done
+	Checkpointer  Checkpointer       // optional: checkpoint the chain while running
+	Watchdog      *Watchdog          // optional: warn about slow jobs
+	Workspaces    *runner.Workspaces // optional: remove job workspaces when done
+	Queue         Queue              // optional: order runnable jobs are run (default NewFIFOQueue)
+	Concurrency   config.Concurrency // optional: limit jobs running at once (default no limit)
 }
 
 func NewTraverser(cfg TraverserConfig) *traverser {
@@ -191,11 +300,22 @@ func NewTraverser(cfg TraverserConfig) *traverser {
 	// Channels used to communicate between traverser + reaper(s)
 	doneJobChan := make(chan proto.Job)
 	runJobChan := make(chan proto.Job)
+	addJobChan := make(chan addJob)
 
 	// Each traverser has its own runner repo because it's keyed on job ID and
 	// job IDs are unique per-chain, not globally.
 	runnerRepo := runner.NewRepo()
 
+	m := cfg.Metrics
+	if m == nil {
+		m = metrics.Nop{}
+	}
+
+	q := cfg.Queue
+	if q == nil {
+		q = NewFIFOQueue()
+	}
+
 	// Reaper factory makes one of three reapers: running, stopped, or suspended
 	// reaper. Normally, only the running reaper is used. Its swapped out for
 	// one of the other two if the request is stopped or suspended, respectively.
@@ -208,27 +328,47 @@ func NewTraverser(cfg TraverserConfig) *traverser {
 		Logger:       logger,
 		DoneJobChan:  doneJobChan,
 		RunJobChan:   runJobChan,
+		AddJobChan:   addJobChan,
 		RunnerRepo:   runnerRepo,
+		Notifier:     cfg.Notifier,
+		Checkpointer: cfg.Checkpointer,
 	}
 
-	return &traverser{
+	t := &traverser{
 		reaperFactory: reaperFactory,
 		logger:        logger,
 		chain:         cfg.Chain,
 		chainRepo:     cfg.ChainRepo,
+		retainer:      cfg.Retainer,
+		checkpointer:  cfg.Checkpointer,
+		watchdog:      cfg.Watchdog,
+		notifier:      cfg.Notifier,
+		workspaces:    cfg.Workspaces,
+		pacers:        map[string]*pacer{},
+		pacersMux:     &sync.Mutex{},
 		rf:            cfg.RunnerFactory,
 		runnerRepo:    runnerRepo,
 		shutdownChan:  cfg.ShutdownChan,
 		runJobChan:    runJobChan,
+		queue:         q,
 		doneJobChan:   doneJobChan,
+		addJobChan:    addJobChan,
+		runningChan:   make(chan struct{}),
 		doneChan:      make(chan struct{}),
 		stopChan:      make(chan struct{}),
 		pendingChan:   make(chan struct{}),
 		rmc:           cfg.RMClient,
+		recorder:      cfg.Recorder,
+		metrics:       m,
+		sched:         newSchedulingStats(),
+		limiter:       newLimiter(cfg.Concurrency, logger),
 		stopMux:       &sync.RWMutex{},
 		stopTimeout:   cfg.StopTimeout,
 		sendTimeout:   cfg.SendTimeout,
 	}
+	reaperFactory.StopChain = t.stopOnFailure
+	reaperFactory.RunFinally = t.runFinally
+	return t
 }
 
 // Run runs all jobs in the chain and blocks until the chain finishes running, is
@@ -237,7 +377,23 @@ func (t *traverser) Run() {
 	t.logger.Infof("traverser.Run call")
 	defer t.logger.Infof("traverser.Run return")
 
-	defer t.chainRepo.Remove(t.chain.RequestId())
+	// When done, retain the chain status before removing the chain so it can
+	// always be queried while retained. The chain was finished or suspended in
+	// the RM, so its checkpoint and job workspaces are no longer needed.
+	defer func() {
+		if t.retainer != nil {
+			t.retainer.Add(t.chain)
+		}
+		t.chainRepo.Remove(t.chain.RequestId())
+		if t.checkpointer != nil {
+			if err := t.checkpointer.Remove(t.chain.RequestId()); err != nil {
+				t.logger.Warnf("error removing checkpoint: %s", err)
+			}
+		}
+		if err := t.workspaces.Remove(t.chain.RequestId()); err != nil {
+			t.logger.Warnf("error removing job workspaces: %s", err)
+		}
+	}()
 
 	// Start a goroutine to run jobs. This consumes runJobChan. When jobs are done,
 	// they're sent to doneJobChan, which a reaper consumes. This goroutine returns
@@ -254,17 +410,16 @@ func (t *traverser) Run() {
 	// doneJobChan and sends the next jobs to be run to runJobChan. Stop()
 	// calls t.reaper.Stop(), which is this reaper. The close(t.runJobChan)
 	// causes runJobs() (started above ^) to return.
-	runningReaperChan := make(chan struct{})
 	t.reaper = t.reaperFactory.MakeRunning() // t.reaper = runningReaper
 	go func() {
-		defer close(runningReaperChan) // indicate reaper is done (see select below)
-		defer close(t.runJobChan)      // stop runJobs goroutine
+		defer close(t.runningChan) // indicate reaper is done (see select below)
+		defer close(t.runJobChan)  // stop runJobs goroutine
 		t.reaper.Run()
 	}()
 
 	// Wait for running reaper to be done or traverser to be shut down.
 	select {
-	case <-runningReaperChan:
+	case <-t.runningChan:
 		// If running reaper is done because traverser was stopped, we will
 		// wait for Stop() to finish. Otherwise, the chain finished normally
 		// (completed or failed) and we can return right away.
@@ -302,6 +457,12 @@ func (t *traverser) Run() {
 // all jobs have finished and the stopped reaper has send the chain's final state
 // to the RM.
 func (t *traverser) Stop() error {
+	return t.stop(proto.STOP_REASON_USER)
+}
+
+// stop stops the chain like Stop, giving the reason (proto.STOP_REASON_* const)
+// to running jobs.
+func (t *traverser) stop(reason string) error {
 	// Don't do anything if the traverser has already been stopped or suspended.
 	t.stopMux.Lock()
 	defer t.stopMux.Unlock()
@@ -329,7 +490,7 @@ func (t *traverser) Stop() error {
 	// stopped reaper so that when the jobs finish and are sent on doneJobChan,
 	// they are reaped correctly.
 	timeout := time.After(t.stopTimeout)
-	err := t.stopRunningJobs(timeout)
+	err := t.stopRunningJobs(timeout, reason)
 	if err != nil {
 		// Don't return the error yet - we still want to wait for the stop
 		// reaper to be done.
@@ -349,6 +510,77 @@ func (t *traverser) Stop() error {
 	return err
 }
 
+// stopOnFailure stops the chain like Stop. The running reaper calls it when a
+// job fails and cannot be retried if the chain has strict failure.
+func (t *traverser) stopOnFailure() {
+	if err := t.stop(proto.STOP_REASON_FAILURE); err != nil && err != ErrShuttingDown {
+		t.logger.Errorf("error stopping job chain on strict failure: %s", err)
+	}
+}
+
+// Pause stops the traverser from starting new jobs until Resume is called.
+func (t *traverser) Pause() error {
+	t.stopMux.Lock()
+	defer t.stopMux.Unlock()
+	if t.stopped || t.suspended || t.isDone() {
+		return ErrNotPausable
+	}
+	if t.resumeChan != nil {
+		return nil // already paused
+	}
+	t.resumeChan = make(chan struct{})
+	t.chain.SetPaused(true)
+	t.logger.Infof("paused: not starting new jobs")
+	return nil
+}
+
+// Resume starts jobs that are waiting because the traverser was paused.
+func (t *traverser) Resume() error {
+	t.stopMux.Lock()
+	defer t.stopMux.Unlock()
+	if t.stopped || t.suspended || t.isDone() {
+		return ErrNotPausable
+	}
+	if t.resumeChan == nil {
+		return nil // not paused
+	}
+	close(t.resumeChan)
+	t.resumeChan = nil
+	t.chain.SetPaused(false)
+	t.logger.Infof("resumed")
+	return nil
+}
+
+// isDone returns true if the running reaper is done: the chain is complete or
+// failed.
+func (t *traverser) isDone() bool {
+	select {
+	case <-t.runningChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitIfPaused blocks while the traverser is paused, holding the job. It returns
+// false if the traverser is stopped or shutting down while waiting, else true.
+func (t *traverser) waitIfPaused(jobId string) bool {
+	t.stopMux.RLock()
+	resumeChan := t.resumeChan
+	t.stopMux.RUnlock()
+	if resumeChan == nil {
+		return true
+	}
+	t.chain.HoldJob(jobId, proto.HOLD_PAUSED, "")
+	defer t.chain.UnholdJob(jobId)
+	select {
+	case <-resumeChan:
+		return true
+	case <-t.stopChan:
+		return false
+	}
+}
+
 func (t *traverser) Running() []proto.JobStatus {
 	runners := t.runnerRepo.Items()                       // map[string]Runner keyed on jobId
 	jobStatus := make([]proto.JobStatus, 0, len(runners)) // for each runner
@@ -360,36 +592,139 @@ func (t *traverser) Running() []proto.JobStatus {
 			JobId:     rs.Job.Id,
 			Type:      rs.Job.Type,
 			Name:      rs.Job.Name,
+			Desc:      rs.Job.Desc,
 			State:     t.chain.JobState(rs.Job.Id),
 			StartedAt: rs.StartedAt.UnixNano(),
 			Try:       rs.Try,
 			Status:    rs.Status,
+
+			SequenceId:  t.chain.SequenceStartJob(rs.Job.Id).Id,
+			SequenceTry: t.chain.SequenceTries(rs.Job.Id),
+		}
+		if rs.Waiting && js.State == proto.STATE_RUNNING {
+			js.State = proto.STATE_WAITING_APPROVAL
+		}
+		if rs.LockWait != "" {
+			js.Hold = proto.HOLD_SINGLETON
+			js.HoldDetail = "held by " + rs.LockWait
 		}
 		jobStatus = append(jobStatus, js)
 	}
 	return jobStatus
 }
 
+func (t *traverser) Held() []proto.JobStatus {
+	return t.chain.HeldJobs()
+}
+
+func (t *traverser) Scheduling() proto.SchedulingStats {
+	return t.sched.stats(t.chain.RequestId())
+}
+
+func (t *traverser) Debug() proto.ChainDebug {
+	t.stopMux.RLock()
+	var reaper string
+	select {
+	case <-t.doneChan:
+		reaper = proto.REAPER_DONE
+	default:
+		switch {
+		case t.suspended:
+			reaper = proto.REAPER_SUSPENDED
+		case t.stopped:
+			reaper = proto.REAPER_STOPPED
+		case t.isDone():
+			reaper = proto.REAPER_DONE
+		default:
+			reaper = proto.REAPER_RUNNING
+		}
+	}
+	paused := t.resumeChan != nil
+	t.stopMux.RUnlock()
+	return proto.ChainDebug{
+		RequestId:   t.chain.RequestId(),
+		Reaper:      reaper,
+		Paused:      paused,
+		Goroutines:  atomic.LoadInt64(&t.goroutines),
+		Pending:     atomic.LoadInt64(&t.pending),
+		Runners:     t.runnerRepo.Count(),
+		JobsRunning: t.chain.RunningJobs(),
+		Queued:      atomic.LoadInt64(&t.queued),
+		DoneBacklog: atomic.LoadInt64(&t.sending),
+	}
+}
+
+// AddJob sends the job to the running reaper, which adds it to the chain between
+// reaping jobs. If the running reaper is done or being stopped, the chain is not
+// running and no more jobs can be added.
+func (t *traverser) AddJob(job proto.Job, after string) error {
+	aj := addJob{
+		job:     job,
+		after:   after,
+		errChan: make(chan error, 1),
+	}
+	select {
+	case t.addJobChan <- aj:
+	case <-t.stopChan:
+		return ErrNotRunning
+	case <-t.runningChan:
+		return ErrNotRunning
+	}
+	return <-aj.errChan
+}
+
 // -------------------------------------------------------------------------- //
 
-// runJobs loops on the runJobChan, and runs each job that comes through the
-// channel. When the job is done, it sends the job out through the doneJobChan
-// which is being consumed by a reaper.
+// runJobs queues every job that comes through the runJobChan, and runs each job
+// in the order of the queue. When the job is done, it sends the job out through
+// the doneJobChan which is being consumed by a reaper.
 func (t *traverser) runJobs() {
 	t.logger.Info("runJobs call")
 	defer t.logger.Info("runJobs return")
 	defer close(t.pendingChan)
 
-	// Run all jobs that come in on runJobChan. The loop exits when runJobChan
-	// is closed in the runningReaper goroutine in Run().
-	for job := range t.runJobChan {
+	// Queue all jobs that come in on runJobChan. The queue is closed when
+	// runJobChan is closed in the runningReaper goroutine in Run(). Pushing
+	// doesn't block, so runningReaper doesn't block on the unbuffered chan.
+	// Jobs queued while every slot is taken are held until they're popped.
+	go func() {
+		for job := range t.runJobChan {
+			atomic.AddInt64(&t.queued, 1)
+			if limit, full := t.limiter.full(); full {
+				t.chain.HoldJob(job.Id, proto.HOLD_CONCURRENCY, fmt.Sprintf("limit %d", limit))
+			}
+			t.queue.Push(job)
+		}
+		t.queue.Close()
+	}()
+
+	// Run all jobs popped from the queue. The loop exits when the queue is
+	// closed and empty. If the chain has a concurrency limit, which is
+	// adjusted by how jobs do if it's adaptive, a slot is acquired before
+	// popping the next job, so runnable jobs wait in the queue and the queue
+	// decides the order they run in. The job keeps the slot until it's done,
+	// including while it waits to run (paused, paced, or sequence retry wait).
+	// Once stopped, acquire returns false when it would wait, so the queue is
+	// drained without waiting for slots.
+	for {
+		slot, acquired := t.limiter.acquire(t.stopChan, nil)
+		job, ok := t.queue.Pop()
+		if !ok {
+			if acquired {
+				t.limiter.cancel()
+			}
+			break
+		}
+		atomic.AddInt64(&t.queued, -1)
+		t.chain.UnholdJob(job.Id)
+
 		// Don't run the job if traverser stopped or shutting down. In this case,
-		// drain runJobChan to prevent runningReaper from blocking (the chan is
-		// unbuffered). As long as we do not add job to runner repo, or do anything
-		// to the job, it's like the job never ran; it stays pending and tries=0.
+		// drain the queue. As long as we do not add job to runner repo, or do
+		// anything to the job, it's like the job never ran; it stays pending and
+		// tries=0.
 		//
 		// Must check before running goroutine because Run() closes runJobChan
-		// when the runningReaper is done. Then this loop will end and close
+		// when the runningReaper is done. Then the queue is closed, this loop will end and close
 		// pendingChan which stopRunningJobs blocks on. Since this check happens
 		// in loop not goroutine, a closed pendingChan means it's been checked
 		// for all jobs and either the job did not run or it did with pending+1
@@ -398,6 +733,9 @@ func (t *traverser) runJobs() {
 		select {
 		case <-t.stopChan:
 			log.Infof("not running job %s: traverser stopped or shutting down", job.Id)
+			if acquired {
+				t.limiter.cancel()
+			}
 			continue
 		default:
 		}
@@ -405,40 +743,83 @@ func (t *traverser) runJobs() {
 		// Signal to stopRunningJobs that there's +1 goroutine that's going
 		// to add itself to runnerRepo
 		atomic.AddInt64(&t.pending, 1)
+		atomic.AddInt64(&t.goroutines, 1)
 
 		// Explicitly pass the job into the func, or all goroutines would share
 		// the same loop "job" variable.
 		go func(job proto.Job) {
+			defer atomic.AddInt64(&t.goroutines, -1)
 			jLogger := t.logger.WithFields(log.Fields{"job_id": job.Id, "sequence_id": job.SequenceId, "sequence_try": t.chain.SequenceTries(job.Id)})
 
+			// If the chain is paused, wait until it's resumed before running
+			// the job. If it's stopped instead, the job stays pending like it
+			// never ran.
+			if !t.waitIfPaused(job.Id) {
+				jLogger.Infof("traverser was stopped while paused - not running job")
+				t.limiter.cancel()
+				atomic.AddInt64(&t.pending, -1)
+				return
+			}
+
+			// If this is the first job of a paced fan-out branch, wait for
+			// the pace of the fan-out, which slows when its jobs are throttled
+			if job.PaceStart {
+				held := func(start time.Time) {
+					t.chain.HoldJob(job.Id, proto.HOLD_PACE, "until "+start.UTC().Format(time.RFC3339))
+				}
+				ok := t.pacer(job.Pace).wait(t.stopChan, held)
+				t.chain.UnholdJob(job.Id)
+				if !ok {
+					jLogger.Infof("traverser was stopped - exiting pace wait early and not running job")
+					t.limiter.cancel()
+					atomic.AddInt64(&t.pending, -1)
+					return
+				}
+			}
+
 			// If this is sequence start job (which currently means sequenceId == job.Id),
 			// wait for duration of SequenceRetryWait, then increment sequence try count.
 			if t.chain.IsSequenceStartJob(job.Id) {
 				if t.chain.SequenceTries(job.Id) != 0 {
 					jLogger.Infof(fmt.Sprintf("waiting %s before retrying sequence", job.SequenceRetryWait))
 					retryWait, _ := time.ParseDuration(job.SequenceRetryWait) // checked that this parses in RM
+					until := time.Now().Add(retryWait).UTC().Format(time.RFC3339)
+					t.chain.HoldJob(job.Id, proto.HOLD_SEQUENCE_RETRY, "until "+until)
 					select {
 					case <-time.After(retryWait): // wait before retry
+						t.chain.UnholdJob(job.Id)
 					case <-t.stopChan:
+						t.chain.UnholdJob(job.Id)
 						jLogger.Infof("traverser was stopped - exiting sequence retry wait early and not running job")
+						t.limiter.cancel()
 						atomic.AddInt64(&t.pending, -1)
 						return
 					}
+				} else {
+					// First sequence try: save job data (which includes job data
+					// from previous jobs) to restore on sequence retry
+					t.chain.SaveJobData(job.Id)
 				}
 				t.chain.IncrementSequenceTries(job.Id, 1)
 				jLogger.Infof("sequence try %d", t.chain.SequenceTries(job.Id))
 			}
 
+			// Job is runnable and waiting for a runner. Scheduling latency
+			// is from now until the runner starts running the job.
+			queuedAt := t.sched.queue()
+
 			// Always send the finished job to doneJobChan to be reaped. If the
 			// reaper isn't reaping any more jobs (if this job took too long to
 			// finish after being stopped), sending to doneJobChan won't be
 			// possible - timeout after a while so we don't leak this goroutine.
 			defer func() {
+				atomic.AddInt64(&t.sending, 1)
 				select {
 				case t.doneJobChan <- job: // reap the done job
 				case <-time.After(t.sendTimeout):
 					jLogger.Warnf("timed out sending job to doneJobChan")
 				}
+				atomic.AddInt64(&t.sending, -1)
 				// Remove the job's runner from the repo (if it was ever added)
 				// AFTER sending it to doneJobChan. This avoids a race condition
 				// when the stopped + suspended reapers check if the runnerRepo
@@ -452,17 +833,25 @@ func (t *traverser) runJobs() {
 			// last counts.
 			curTries, totalTries := t.chain.JobTries(job.Id)
 
-			runner, err := t.rf.Make(job, t.chain.RequestId(), curTries, totalTries)
+			runner, err := t.rf.Make(job, t.request(), curTries, totalTries)
 			if err != nil {
 				// Problem creating the job runner - treat job as failed.
 				// Send a JobLog to the RM so that it knows this job failed.
 				atomic.AddInt64(&t.pending, -1)
+				t.sched.dequeue(queuedAt, false)
+				t.limiter.release(slot, job.Type, 0, false)
 				job.State = proto.STATE_FAIL
 				err = fmt.Errorf("problem creating job runner: %s", err)
 				t.sendJL(job, err)
+				t.record(TRACE_JOB_DONE, job, 0)
 				return
 			}
 
+			// Jobs in a paced fan-out report throttling to its pacer (job.Paced)
+			if job.Pace != "" {
+				setFeedback(runner, t.pacer(job.Pace))
+			}
+
 			// --------------------------------------------------------------
 
 			// Add the runner to the repo. Runners in the repo are used
@@ -475,20 +864,112 @@ func (t *traverser) runJobs() {
 			// Run the job. This is a blocking operation that could take a long time.
 			jLogger.Infof("running job")
 			t.chain.SetJobState(job.Id, proto.STATE_RUNNING)
+			job.State = proto.STATE_RUNNING
+			t.record(TRACE_JOB_START, job, 0)
+			t.sched.dequeue(queuedAt, true)
+			startTime := time.Now()
+			watched := t.watchdog.Watch(t.chain.RequestId(), job, jLogger)
+			escalated := t.escalate(job, runner, jLogger)
 			ret := runner.Run(job.Data)
+			watched()
+			escalated()
+			failed := ret.FinalState == proto.STATE_FAIL || ret.Tries > 1
+			t.limiter.release(slot, job.Type, time.Since(startTime), !failed)
 			jLogger.Infof("job done: state=%s (%d)", proto.StateName[ret.FinalState], ret.FinalState)
+			tags := metrics.Tags{"type": job.Type, "state": proto.StateName[ret.FinalState]}
+			t.metrics.Count(metrics.JOBS_RUN, 1, tags)
+			t.metrics.Timing(metrics.JOB_DURATION, time.Since(startTime), tags)
 
 			// We don't pass the Chain to the job runner, so it can't call this
 			// itself. Instead, it returns how many tries it did, and we set it.
 			t.chain.IncrementJobTries(job.Id, int(ret.Tries))
 
+			// A stopped job runs again if the chain is resumed, so save why
+			// and how far it got (job.Reentrant). Else, it's done with them.
+			if ret.FinalState == proto.STATE_STOPPED {
+				t.chain.SetJobReentry(job.Id, proto.STOP_REASON_SUSPENDED, ret.Token)
+			} else {
+				t.chain.SetJobReentry(job.Id, "", "")
+			}
+
 			// Set job final state because this job is about to be reaped on
 			// the doneJobChan, sent in this goroutine's defer func at top ^.
 			job.State = ret.FinalState
+			t.record(TRACE_JOB_DONE, job, ret.Tries)
 		}(job)
 	}
 }
 
+// runFinally runs a finally job after the chain was stopped and returns the job
+// in its final state. The stopped reaper calls it when no other jobs are running.
+// Unlike jobs run by runJobs, it blocks, and it runs although stopChan is closed.
+func (t *traverser) runFinally(job proto.Job) proto.Job {
+	jLogger := t.logger.WithFields(log.Fields{"job_id": job.Id, "sequence_id": job.SequenceId})
+	curTries, totalTries := t.chain.JobTries(job.Id)
+	runner, err := t.rf.Make(job, t.request(), curTries, totalTries)
+	if err != nil {
+		job.State = proto.STATE_FAIL
+		t.sendJL(job, fmt.Errorf("problem creating job runner: %s", err))
+		t.record(TRACE_JOB_DONE, job, 0)
+		return job
+	}
+	t.runnerRepo.Set(job.Id, runner)
+	defer t.runnerRepo.Remove(job.Id)
+
+	jLogger.Infof("running finally job")
+	t.chain.SetJobState(job.Id, proto.STATE_RUNNING)
+	job.State = proto.STATE_RUNNING
+	t.record(TRACE_JOB_START, job, 0)
+	startTime := time.Now()
+	ret := runner.Run(job.Data)
+	jLogger.Infof("finally job done: state=%s (%d)", proto.StateName[ret.FinalState], ret.FinalState)
+	tags := metrics.Tags{"type": job.Type, "state": proto.StateName[ret.FinalState]}
+	t.metrics.Count(metrics.JOBS_RUN, 1, tags)
+	t.metrics.Timing(metrics.JOB_DURATION, time.Since(startTime), tags)
+
+	t.chain.IncrementJobTries(job.Id, int(ret.Tries))
+	t.chain.SetJobReentry(job.Id, "", "")
+	job.State = ret.FinalState
+	t.record(TRACE_JOB_DONE, job, ret.Tries)
+	return job
+}
+
+// pacer returns the pacer of the paced fan-out, making it on first use.
+func (t *traverser) pacer(id string) *pacer {
+	t.pacersMux.Lock()
+	defer t.pacersMux.Unlock()
+	p, ok := t.pacers[id]
+	if !ok {
+		p = newPacer(id, t.logger)
+		t.pacers[id] = p
+	}
+	return p
+}
+
+// request returns the request of the chain for the runner factory.
+func (t *traverser) request() runner.Request {
+	return runner.Request{
+		Id:        t.chain.RequestId(),
+		User:      t.chain.User(),
+		Globals:   t.chain.Globals(),
+		Overrides: t.chain.Overrides(),
+	}
+}
+
+// record records a trace event for the job if the traverser has a recorder.
+func (t *traverser) record(evType string, job proto.Job, tries uint) {
+	if t.recorder == nil {
+		return
+	}
+	t.recorder.Record(TraceEvent{
+		Type:     evType,
+		JobId:    job.Id,
+		State:    job.State,
+		Tries:    tries,
+		SeqTries: t.chain.SequenceTries(job.Id),
+	})
+}
+
 // sendJL sends a job log to the Request Manager.
 func (t *traverser) sendJL(job proto.Job, err error) {
 	_, totalTries := t.chain.JobTries(job.Id)
@@ -554,7 +1035,7 @@ func (t *traverser) shutdown() {
 	// suspended reaper so that when the jobs finish and are sent on doneJobChan,
 	// they are reaped correctly.
 	timeout := time.After(t.stopTimeout)
-	err := t.stopRunningJobs(timeout)
+	err := t.stopRunningJobs(timeout, proto.STOP_REASON_SUSPENDED)
 	if err != nil {
 		t.logger.Errorf("problem suspending job chain: %s", err)
 	}
@@ -571,8 +1052,8 @@ func (t *traverser) shutdown() {
 	close(t.doneChan)
 }
 
-// stopRunningJobs stops all currently running jobs.
-func (t *traverser) stopRunningJobs(timeout <-chan time.Time) error {
+// stopRunningJobs stops all currently running jobs for the reason (proto.STOP_REASON_* const).
+func (t *traverser) stopRunningJobs(timeout <-chan time.Time, reason string) error {
 	// To stop all running jobs without race coditions, we need to know:
 	//   1. runJobs is done, won't start any more goroutines
 	//   2. All in-flight runJob goroutines have added themselves to runner repo
@@ -583,7 +1064,7 @@ func (t *traverser) stopRunningJobs(timeout <-chan time.Time) error {
 	//
 	// The shutdown sequence is:
 	//   1. close(stopChan): runJob goroutines (RGs) don't run if closed. It's
-	//      as if the job never ran. This allows runJobChan to drain and prevents
+	//      as if the job never ran. This allows the queue to drain and prevents
 	//      runnerRepo from blocking because the chan is unbuffered. This is done
 	//      in the for loop, before launching the goroutine, so that a closed
 	//      pendingChan (step 4) guarantees that runJobs either didn't run an
@@ -597,7 +1078,8 @@ func (t *traverser) stopRunningJobs(timeout <-chan time.Time) error {
 	//      that sends to runJobChan, it must be closed like this so runningReaper
 	//      doesn't panic on "send on closed channel".
 	//   4. close(pendingChan): Given step 3 and step 1, eventually runJobChan
-	//      will drain and runJobs() will return, closing pendingChan when it does.
+	//      and the queue will drain and runJobs() will return, closing pendingChan
+	//      when it does.
 	//   5. Call stopRunningJobs: This func waits for step 4, which ensures no
 	//      more RGs. And given step 1, we're assured that all in-flight RGs
 	//      have added themsevs to pending count. Therefore, this func waits for
@@ -633,7 +1115,7 @@ func (t *traverser) stopRunningJobs(timeout <-chan time.Time) error {
 		wg.Add(1)
 		go func(runner runner.Runner) {
 			defer wg.Done()
-			if err := runner.Stop(); err != nil {
+			if err := runner.Stop(reason); err != nil {
 				t.logger.Errorf("problem stopping job runner (job id = %s): %s", jobId, err)
 				hadError = true
 			}
@@ -3,14 +3,19 @@
 package chain_test
 
 import (
+	"bytes"
+	"fmt"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-test/deep"
+	"github.com/square/spincycle/v2/config"
 	"github.com/square/spincycle/v2/job-runner/chain"
 	"github.com/square/spincycle/v2/job-runner/runner"
+	"github.com/square/spincycle/v2/metrics"
 	"github.com/square/spincycle/v2/proto"
 	testutil "github.com/square/spincycle/v2/test"
 	"github.com/square/spincycle/v2/test/mock"
@@ -51,7 +56,15 @@ func TestRunComplete(t *testing.T) {
 		},
 	}
 	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
-	traverser := chain.NewTraverser(chain.TraverserConfig{c, chainRepo, rf, rmc, shutdownChan, timeout, timeout})
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
 
 	traverser.Run()
 
@@ -65,6 +78,172 @@ func TestRunComplete(t *testing.T) {
 	}
 }
 
+// Done chain is retained after it's removed from the repo.
+func TestRunRetain(t *testing.T) {
+	requestId := "test_run_retain"
+	chainRepo := chain.NewMemoryRepo()
+	retainer := chain.NewRetainer(time.Minute, nil)
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+		},
+	}
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(1),
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+		Retainer:      retainer,
+	})
+
+	traverser.Run()
+
+	if _, err := chainRepo.Get(requestId); err != chain.ErrNotFound {
+		t.Error("chain still in repo, expected it to be removed")
+	}
+	cs, ok := retainer.Get(requestId)
+	if !ok {
+		t.Fatal("chain not retained")
+	}
+	if cs.State != proto.STATE_COMPLETE {
+		t.Errorf("retained chain state = %d, expected %d", cs.State, proto.STATE_COMPLETE)
+	}
+}
+
+type checkpoints struct {
+	saved   []proto.SuspendedJobChain
+	removed []string
+}
+
+func (c *checkpoints) Save(sjc proto.SuspendedJobChain) error {
+	c.saved = append(c.saved, sjc)
+	return nil
+}
+
+func (c *checkpoints) Remove(requestId string) error {
+	c.removed = append(c.removed, requestId)
+	return nil
+}
+
+// Chain is checkpointed after every job, and the checkpoint is removed when done.
+func TestRunCheckpoint(t *testing.T) {
+	// Job Chain:
+	// -> 1 -> 2
+
+	requestId := "test_run_checkpoint"
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{
+				RunReturn:    runner.Return{FinalState: proto.STATE_COMPLETE},
+				AddedJobData: map[string]interface{}{"k1": "v1"},
+			},
+			"job2": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+		},
+	}
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(2),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+		},
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	cp := &checkpoints{}
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chain.NewMemoryRepo(),
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+		Checkpointer:  cp,
+	})
+
+	traverser.Run()
+
+	if c.State() != proto.STATE_COMPLETE {
+		t.Errorf("chain state = %d, expected %d", c.State(), proto.STATE_COMPLETE)
+	}
+	// Saved when the reaper started and after job1; not after job2 because
+	// the chain was done
+	if len(cp.saved) != 2 {
+		t.Fatalf("%d checkpoints saved, expected 2", len(cp.saved))
+	}
+	last := cp.saved[1]
+	if last.RequestId != requestId {
+		t.Errorf("checkpoint request ID %s, expected %s", last.RequestId, requestId)
+	}
+	if s := last.JobChain.Jobs["job1"].State; s != proto.STATE_COMPLETE {
+		t.Errorf("job1 state %s in checkpoint, expected COMPLETE", proto.StateName[s])
+	}
+	if v := last.JobChain.Jobs["job2"].Data["k1"]; v != "v1" {
+		t.Errorf("job2 data k1 = %v in checkpoint, expected v1 from job1", v)
+	}
+	if diff := deep.Equal(cp.removed, []string{requestId}); diff != nil {
+		t.Errorf("checkpoints removed: %v", diff)
+	}
+}
+
+// Jobs run are reported to the metrics plugin.
+func TestRunMetrics(t *testing.T) {
+	// Job Chain:
+	// -> 1 -> 2
+
+	requestId := "test_run_metrics"
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+			"job2": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_FAIL}},
+		},
+	}
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(2),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+		},
+	}
+	for id, job := range jc.Jobs {
+		job.Type = "restart"
+		jc.Jobs[id] = job
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	m := metrics.NewPrometheus("spincycle")
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chain.NewMemoryRepo(),
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+		Metrics:       m,
+	})
+
+	traverser.Run()
+
+	var buf bytes.Buffer
+	m.Write(&buf)
+	for _, line := range []string{
+		`spincycle_jobs_run_total{state="COMPLETE",type="restart"} 1`,
+		`spincycle_jobs_run_total{state="FAIL",type="restart"} 1`,
+		`spincycle_job_duration_seconds_count{state="FAIL",type="restart"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("metric %s not reported, got:\n%s", line, buf.String())
+		}
+	}
+}
+
 // Not all jobs in the chain complete successfully.
 func TestRunNotComplete(t *testing.T) {
 	// Job Chain:
@@ -97,7 +276,15 @@ func TestRunNotComplete(t *testing.T) {
 		},
 	}
 	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
-	traverser := chain.NewTraverser(chain.TraverserConfig{c, chainRepo, rf, rmc, shutdownChan, timeout, timeout})
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
 
 	traverser.Run()
 
@@ -114,6 +301,91 @@ func TestRunNotComplete(t *testing.T) {
 	}
 }
 
+func TestRunStrictFailure(t *testing.T) {
+	// Job Chain:
+	//      2
+	//     / \
+	// -> 1   4
+	//     \ /
+	//      3
+	// Job 3 fails while job 2 is running. The chain has strict failure, so
+	// job 2 is stopped and the chain fails without waiting for it.
+
+	requestId := "test_run_strict_failure"
+	chainRepo := chain.NewMemoryRepo()
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+			"job2": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_STOPPED}, RunBlock: make(chan struct{}), RunWg: &runWg},
+			"job3": &mock.Runner{
+				RunFunc: func(jobData map[string]interface{}) byte {
+					runWg.Wait() // job2 running
+					return proto.STATE_FAIL
+				},
+			},
+			"job4": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+		},
+	}
+	var finished proto.FinishRequest
+	rmc := &mock.RMClient{
+		FinishRequestFunc: func(fr proto.FinishRequest) error {
+			finished = fr
+			return nil
+		},
+	}
+	shutdownChan := make(chan struct{})
+
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(4),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+			"job2": {"job4"},
+			"job3": {"job4"},
+		},
+		StrictFailure: true,
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
+
+	doneChan := make(chan struct{})
+	go func() {
+		traverser.Run()
+		close(doneChan)
+	}()
+	select {
+	case <-doneChan:
+	case <-time.After(2 * time.Second):
+		t.Fatal("traverser did not finish running within 2 seconds")
+	}
+
+	if c.State() != proto.STATE_FAIL {
+		t.Errorf("chain state = %s, expected FAIL", proto.StateName[c.State()])
+	}
+	if finished.State != proto.STATE_FAIL {
+		t.Errorf("final state sent to RM = %s, expected FAIL", proto.StateName[finished.State])
+	}
+	if c.JobState("job2") != proto.STATE_STOPPED {
+		t.Errorf("job2 state = %s, expected STOPPED", proto.StateName[c.JobState("job2")])
+	}
+	if reason := rf.RunnersToReturn["job2"].StopReason; reason != proto.STOP_REASON_FAILURE {
+		t.Errorf("job2 stop reason = %q, expected %q", reason, proto.STOP_REASON_FAILURE)
+	}
+	if c.JobState("job4") != proto.STATE_PENDING {
+		t.Errorf("job4 state = %s, expected PENDING", proto.StateName[c.JobState("job4")])
+	}
+}
+
 // Test sequence retry wait.
 func TestSequenceRetryWait(t *testing.T) {
 	longWait := "2s"
@@ -163,7 +435,15 @@ func testSequenceRetryWait(t *testing.T, sequenceRetryWait string) time.Duration
 		},
 	}
 	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
-	traverser := chain.NewTraverser(chain.TraverserConfig{c, chainRepo, rf, rmc, shutdownChan, timeout, timeout})
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
 
 	start := time.Now()
 	traverser.Run()
@@ -195,7 +475,7 @@ func TestResume(t *testing.T) {
 		"job6": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE, Tries: 1}},
 	}
 	rf := &mock.RunnerFactory{
-		MakeFunc: func(job proto.Job, requestId string, prevTryNo uint, totalTries uint) (runner.Runner, error) {
+		MakeFunc: func(job proto.Job, req runner.Request, prevTryNo uint, totalTries uint) (runner.Runner, error) {
 			if job.Id == "job3" {
 				gotTotalTries = totalTries
 			}
@@ -204,7 +484,13 @@ func TestResume(t *testing.T) {
 	}
 	rmc := &mock.RMClient{}
 	shutdownChan := make(chan struct{})
-	tf := chain.NewTraverserFactory(chainRepo, rf, rmc, shutdownChan)
+	tf := chain.NewTraverserFactory(chain.TraverserFactoryConfig{
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		Metrics:       metrics.Nop{},
+	})
 
 	jobs := map[string]proto.Job{
 		"job1": proto.Job{
@@ -345,7 +631,15 @@ func TestJobUnknownState(t *testing.T) {
 	for _, j := range jc.Jobs {
 		j.State = proto.STATE_UNKNOWN
 	}
-	traverser := chain.NewTraverser(chain.TraverserConfig{c, chainRepo, rf, rmc, shutdownChan, timeout, timeout})
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
 
 	traverser.Run()
 
@@ -387,7 +681,15 @@ func TestRunJobsRunnerError(t *testing.T) {
 		Jobs:      testutil.InitJobs(1),
 	}
 	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
-	traverser := chain.NewTraverser(chain.TraverserConfig{c, chainRepo, rf, rmc, shutdownChan, timeout, timeout})
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
 
 	traverser.Run()
 
@@ -447,7 +749,15 @@ func TestStop(t *testing.T) {
 		},
 	}
 	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
-	traverser := chain.NewTraverser(chain.TraverserConfig{c, chainRepo, rf, rmc, shutdownChan, timeout, timeout})
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
 
 	// Start the traverser.
 	doneChan := make(chan struct{})
@@ -482,6 +792,9 @@ func TestStop(t *testing.T) {
 	if c.JobState("job3") != proto.STATE_STOPPED {
 		t.Errorf("job3 state = %d, expected %d", c.JobState("job3"), proto.STATE_STOPPED)
 	}
+	if reason := rf.RunnersToReturn["job3"].StopReason; reason != proto.STOP_REASON_USER {
+		t.Errorf("job3 stop reason = %q, expected %q", reason, proto.STOP_REASON_USER)
+	}
 	if c.JobState("job4") != proto.STATE_PENDING {
 		t.Errorf("job4 state = %d, expected %d", c.JobState("job4"), proto.STATE_PENDING)
 	}
@@ -531,7 +844,15 @@ func TestStopRunnerHangs(t *testing.T) {
 		},
 	}
 	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
-	traverser := chain.NewTraverser(chain.TraverserConfig{c, chainRepo, rf, rmc, shutdownChan, timeout, timeout})
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
 
 	// Start the traverser.
 	go func() {
@@ -609,7 +930,15 @@ func TestStopDoneRunning(t *testing.T) {
 		},
 	}
 	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
-	traverser := chain.NewTraverser(chain.TraverserConfig{c, chainRepo, rf, rmc, shutdownChan, timeout, timeout})
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
 
 	traverser.Run()
 
@@ -657,7 +986,15 @@ func TestStopAfterSuspend(t *testing.T) {
 		},
 	}
 	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
-	traverser := chain.NewTraverser(chain.TraverserConfig{c, chainRepo, rf, rmc, shutdownChan, timeout, timeout})
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
 
 	go func() {
 		traverser.Run()
@@ -744,7 +1081,15 @@ func TestSuspend(t *testing.T) {
 		},
 	}
 	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
-	traverser := chain.NewTraverser(chain.TraverserConfig{c, chainRepo, rf, rmc, shutdownChan, timeout, timeout})
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
 
 	// Start the traverser.
 	doneChan := make(chan struct{})
@@ -785,6 +1130,9 @@ func TestSuspend(t *testing.T) {
 	if c.JobState("job3") != proto.STATE_STOPPED { // job3 stopped by suspend
 		t.Errorf("job3 state = %d, expected %d", c.JobState("job3"), proto.STATE_STOPPED)
 	}
+	if reason := rf.RunnersToReturn["job3"].StopReason; reason != proto.STOP_REASON_SUSPENDED {
+		t.Errorf("job3 stop reason = %q, expected %q", reason, proto.STOP_REASON_SUSPENDED)
+	}
 	if c.JobState("job4") != proto.STATE_PENDING { // job4 never started
 		t.Errorf("job4 state = %d, expected %d", c.JobState("job4"), proto.STATE_PENDING)
 	}
@@ -891,7 +1239,15 @@ func TestRunning(t *testing.T) {
 		},
 	}
 	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
-	traverser := chain.NewTraverser(chain.TraverserConfig{c, chainRepo, rf, &mock.RMClient{}, make(chan struct{}), timeout, timeout})
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
 
 	// Start the traverser.
 	doneChan := make(chan struct{})
@@ -913,6 +1269,9 @@ func TestRunning(t *testing.T) {
 			State:     proto.STATE_RUNNING,
 			Status:    "job2 running",
 			Try:       2,
+
+			SequenceId:  "job1",
+			SequenceTry: 1,
 		},
 		{
 			RequestId: requestId,
@@ -922,6 +1281,9 @@ func TestRunning(t *testing.T) {
 			State:     proto.STATE_RUNNING,
 			Status:    "job3 running",
 			Try:       3,
+
+			SequenceId:  "job1",
+			SequenceTry: 1,
 		},
 	}
 	gotRunning := traverser.Running()
@@ -950,3 +1312,632 @@ func TestRunning(t *testing.T) {
 		t.Errorf("chain state = %d, expected %d", c.State(), proto.STATE_COMPLETE)
 	}
 }
+
+func TestScheduling(t *testing.T) {
+	// Job Chain:
+	// -> 1 -> 2
+	requestId := "test_scheduling"
+	chainRepo := chain.NewMemoryRepo()
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+			"job2": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+		},
+	}
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(2),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+		},
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
+
+	traverser.Run()
+
+	ss := traverser.Scheduling()
+	if ss.RequestId != requestId {
+		t.Errorf("got request ID %s, expected %s", ss.RequestId, requestId)
+	}
+	if ss.Queued != 0 {
+		t.Errorf("got %d queued jobs, expected 0", ss.Queued)
+	}
+	if ss.Started != 2 {
+		t.Errorf("got %d started jobs, expected 2", ss.Started)
+	}
+	if ss.WaitMax > ss.WaitTotal || ss.WaitAvg != ss.WaitTotal/2 {
+		t.Errorf("invalid wait times: %+v", ss)
+	}
+}
+
+func TestDebug(t *testing.T) {
+	// Job Chain:
+	// -> 1 -> 2
+	// Job 2 blocks until Debug shows it running
+	requestId := "test_debug"
+	chainRepo := chain.NewMemoryRepo()
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	job2Block := make(chan struct{})
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+			"job2": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}, RunBlock: job2Block, RunWg: &runWg},
+		},
+	}
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(2),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+		},
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
+
+	doneChan := make(chan struct{})
+	go func() {
+		traverser.Run()
+		close(doneChan)
+	}()
+
+	// Job 1 goroutine can still be returning after job 2 starts running
+	debug := func(done func(proto.ChainDebug) bool) proto.ChainDebug {
+		deadline := time.Now().Add(time.Second)
+		for {
+			got := traverser.Debug()
+			if done(got) || time.Now().After(deadline) {
+				return got
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	runWg.Wait()
+	got := debug(func(cd proto.ChainDebug) bool { return cd.Goroutines == 1 })
+	if got.RequestId != requestId || got.Reaper != proto.REAPER_RUNNING {
+		t.Errorf("got request ID %s, reaper %s; expected %s, %s", got.RequestId, got.Reaper, requestId, proto.REAPER_RUNNING)
+	}
+	if got.Runners != 1 || got.JobsRunning != 1 || got.Goroutines != 1 || got.Queued != 0 || got.DoneBacklog != 0 {
+		t.Errorf("got %+v, expected 1 runner, 1 job running, 1 goroutine, 0 queued, 0 done backlog", got)
+	}
+
+	close(job2Block)
+	select {
+	case <-doneChan:
+	case <-time.After(time.Second):
+		t.Fatal("traverser did not finish")
+	}
+
+	got = debug(func(cd proto.ChainDebug) bool { return cd.Goroutines == 0 })
+	expect := proto.ChainDebug{RequestId: requestId, Reaper: proto.REAPER_DONE}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestEscalate(t *testing.T) {
+	// Job Chain:
+	// -> 1 -> 2
+	// Job 1 is a gate job waiting for approval until its sequence escalations
+	// are notified. Job 2 is not waiting, so it's not escalated.
+	requestId := "test_escalate"
+	var mux sync.Mutex
+	events := []proto.SequenceEvent{}
+	urls := []string{}
+	escalated := make(chan struct{})
+	notifier := &mock.Notifier{
+		NotifyFunc: func(wh proto.SequenceWebhook, ev proto.SequenceEvent) {
+			mux.Lock()
+			defer mux.Unlock()
+			events = append(events, ev)
+			urls = append(urls, wh.URL)
+			if len(events) == 2 {
+				close(escalated)
+			}
+		},
+	}
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{
+				StatusResp: runner.Status{Waiting: true},
+				RunFunc: func(jobData map[string]interface{}) byte {
+					select {
+					case <-escalated:
+					case <-time.After(time.Second):
+					}
+					return proto.STATE_COMPLETE
+				},
+			},
+			"job2": &mock.Runner{
+				RunFunc: func(jobData map[string]interface{}) byte {
+					time.Sleep(50 * time.Millisecond)
+					return proto.STATE_COMPLETE
+				},
+			},
+		},
+	}
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(2),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+		},
+		Escalations: []proto.SequenceEscalation{
+			{URL: "https://pager.example.com/oncall", After: "10ms", Step: 1, Sequence: "failover", StartJobId: "job1", JobIds: []string{"job1", "job2"}},
+			{URL: "https://pager.example.com/manager", After: "30ms", Step: 2, Sequence: "failover", StartJobId: "job1", JobIds: []string{"job1", "job2"}},
+			{URL: "https://pager.example.com/other", After: "10ms", Step: 1, Sequence: "other", StartJobId: "job2", JobIds: []string{"job2"}},
+		},
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chain.NewMemoryRepo(),
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+		Notifier:      notifier,
+	})
+
+	traverser.Run()
+
+	if c.State() != proto.STATE_COMPLETE {
+		t.Errorf("chain state = %d, expected %d", c.State(), proto.STATE_COMPLETE)
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	if diff := deep.Equal(urls, []string{"https://pager.example.com/oncall", "https://pager.example.com/manager"}); diff != nil {
+		t.Fatal(diff)
+	}
+	for i, ev := range events {
+		if ev.Event != proto.SEQUENCE_EVENT_APPROVAL_PENDING || ev.RequestId != requestId || ev.JobId != "job1" || ev.Sequence != "failover" || ev.StartJobId != "job1" {
+			t.Errorf("got event %+v, expected approval-pending for job1 in sequence failover", ev)
+		}
+		if ev.Escalation != uint(i+1) {
+			t.Errorf("got escalation %d, expected %d", ev.Escalation, i+1)
+		}
+		if ev.Pending < int64(10*time.Millisecond) {
+			t.Errorf("got pending %s, expected at least 10ms", time.Duration(ev.Pending))
+		}
+	}
+}
+
+func TestRunConcurrency(t *testing.T) {
+	// Job Chain:
+	//      2
+	//    / 3 \
+	// -> 1 4 -> 6
+	//    \ 5 /
+	// With max 2, jobs 2-5 run no more than two at once
+	requestId := "test_run_concurrency"
+	chainRepo := chain.NewMemoryRepo()
+	var mux sync.Mutex
+	running, maxRunning := 0, 0
+	runFunc := func(jobData map[string]interface{}) byte {
+		mux.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mux.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mux.Lock()
+		running--
+		mux.Unlock()
+		return proto.STATE_COMPLETE
+	}
+	runners := map[string]*mock.Runner{}
+	for i := 1; i <= 6; i++ {
+		runners[fmt.Sprintf("job%d", i)] = &mock.Runner{RunFunc: runFunc}
+	}
+	rf := &mock.RunnerFactory{RunnersToReturn: runners}
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(6),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3", "job4", "job5"},
+			"job2": {"job6"},
+			"job3": {"job6"},
+			"job4": {"job6"},
+			"job5": {"job6"},
+		},
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+		Concurrency:   config.Concurrency{Max: 2},
+	})
+
+	traverser.Run()
+
+	if c.State() != proto.STATE_COMPLETE {
+		t.Errorf("chain state = %d, expected %d", c.State(), proto.STATE_COMPLETE)
+	}
+	if maxRunning != 2 {
+		t.Errorf("max %d jobs running at once, expected 2", maxRunning)
+	}
+}
+
+func TestSuspendConcurrencyWait(t *testing.T) {
+	// Job Chain:
+	//       2
+	//     /
+	// -> 1
+	//     \
+	//       3
+	// 2 and 3 start sequences. The chain runs one job at a time, so one of 2
+	// and 3 runs while the other waits for a slot. Chain is suspended while
+	// waiting: the waiting job does not use a try of its sequence.
+	requestId := "test_suspend_concurrency_wait"
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	stopped := func() *mock.Runner {
+		return &mock.Runner{
+			RunReturn: runner.Return{FinalState: proto.STATE_STOPPED, Tries: 1},
+			RunBlock:  make(chan struct{}),
+			RunWg:     &runWg,
+		}
+	}
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE, Tries: 1}},
+			"job2": stopped(),
+			"job3": stopped(),
+		},
+	}
+	var receivedSJC proto.SuspendedJobChain
+	receivedSJCChan := make(chan struct{})
+	rmc := &mock.RMClient{
+		SuspendRequestFunc: func(reqId string, sjc proto.SuspendedJobChain) error {
+			receivedSJC = sjc
+			close(receivedSJCChan)
+			return nil
+		},
+	}
+	shutdownChan := make(chan struct{})
+
+	jobs := testutil.InitJobs(3)
+	for _, id := range []string{"job2", "job3"} {
+		job := jobs[id]
+		job.SequenceId = id
+		jobs[id] = job
+	}
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      jobs,
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+		},
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chain.NewMemoryRepo(),
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  shutdownChan,
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+		Concurrency:   config.Concurrency{Max: 1},
+	})
+
+	doneChan := make(chan struct{})
+	go func() {
+		traverser.Run()
+		close(doneChan)
+	}()
+
+	// Wait until one job is running and the other is held for a slot
+	runWg.Wait()
+	running, waiting := "job2", "job3"
+	if c.JobState("job3") == proto.STATE_RUNNING {
+		running, waiting = "job3", "job2"
+	}
+	heldTimeout := time.After(time.Second)
+	for {
+		held := traverser.Held()
+		if len(held) == 1 && held[0].JobId == waiting && held[0].Hold == proto.HOLD_CONCURRENCY {
+			break
+		}
+		select {
+		case <-heldTimeout:
+			t.Fatalf("%s not held for concurrency, got held jobs %+v", waiting, held)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// Suspend the traverser
+	close(shutdownChan)
+	select {
+	case <-receivedSJCChan:
+	case <-time.After(time.Second):
+		t.Fatal("SJC not sent within 1 second of shutdown signal")
+	}
+	select {
+	case <-doneChan:
+	case <-time.After(time.Second):
+		t.Fatal("traverser.Run didn't return within 1 second of shutdown signal")
+	}
+
+	if c.JobState(waiting) != proto.STATE_PENDING {
+		t.Errorf("%s state = %s, expected PENDING", waiting, proto.StateName[c.JobState(waiting)])
+	}
+	expectedSequenceTries := map[string]uint{
+		"job1":  1,
+		running: 1,
+	}
+	if diff := deep.Equal(receivedSJC.SequenceTries, expectedSequenceTries); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestAddJob(t *testing.T) {
+	// Job Chain:
+	// -> 1 -> 2 -> 3
+	// Add job 5 after job 1 while job 2 is running:
+	// -> 1 -> 2 -> 3
+	//     \       /
+	//      5 ----
+	requestId := "test_add_job"
+	chainRepo := chain.NewMemoryRepo()
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	job2Block := make(chan struct{})
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+			"job2": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}, RunBlock: job2Block, RunWg: &runWg},
+			"job3": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+			"job5": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+		},
+	}
+
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(3),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+			"job2": {"job3"},
+		},
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
+
+	doneChan := make(chan struct{})
+	go func() {
+		traverser.Run()
+		close(doneChan)
+	}()
+
+	// Wait until job 2 is running, so job 1 is complete
+	runWg.Wait()
+
+	// Cannot add a job after the last job
+	err := traverser.AddJob(proto.Job{Id: "job5", Type: "diag"}, "job3")
+	if _, ok := err.(chain.ErrInvalidChain); !ok {
+		t.Errorf("add job after last job: got err %v, expected ErrInvalidChain", err)
+	}
+
+	// Job 1 is complete, so job 5 runs right away
+	if err := traverser.AddJob(proto.Job{Id: "job5", Type: "diag"}, "job1"); err != nil {
+		t.Fatal(err)
+	}
+	close(job2Block)
+
+	select {
+	case <-doneChan:
+	case <-time.After(time.Second):
+		t.Fatal("traverser did not finish running within 1 second")
+	}
+
+	if c.State() != proto.STATE_COMPLETE {
+		t.Errorf("chain state = %s, expected COMPLETE", proto.StateName[c.State()])
+	}
+	if c.JobState("job5") != proto.STATE_COMPLETE {
+		t.Errorf("job5 state = %s, expected COMPLETE", proto.StateName[c.JobState("job5")])
+	}
+	if c.FinishedJobs() != 4 {
+		t.Errorf("finished jobs = %d, expected 4", c.FinishedJobs())
+	}
+
+	// Chain is done, so no more jobs can be added
+	if err := traverser.AddJob(proto.Job{Id: "job6", Type: "diag"}, "job1"); err != chain.ErrNotRunning {
+		t.Errorf("add job to done chain: got err %v, expected ErrNotRunning", err)
+	}
+}
+
+func TestPause(t *testing.T) {
+	// Job Chain:
+	// -> 1 -> 2 -> 3
+	// Pause while job 2 is running: job 2 finishes, job 3 waits until resumed
+	requestId := "test_pause"
+	chainRepo := chain.NewMemoryRepo()
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	job2Block := make(chan struct{})
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+			"job2": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}, RunBlock: job2Block, RunWg: &runWg},
+			"job3": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+		},
+	}
+
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(3),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+			"job2": {"job3"},
+		},
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
+
+	doneChan := make(chan struct{})
+	go func() {
+		traverser.Run()
+		close(doneChan)
+	}()
+
+	// Wait until job 2 is running, then pause (twice is ok)
+	runWg.Wait()
+	if err := traverser.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if err := traverser.Pause(); err != nil {
+		t.Errorf("pause paused chain: got err %v, expected nil", err)
+	}
+	if !c.Paused() {
+		t.Errorf("chain not paused")
+	}
+	close(job2Block)
+
+	select {
+	case <-doneChan:
+		t.Fatal("traverser finished running while paused")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if c.JobState("job2") != proto.STATE_COMPLETE {
+		t.Errorf("job2 state = %s, expected COMPLETE", proto.StateName[c.JobState("job2")])
+	}
+	if c.JobState("job3") != proto.STATE_PENDING {
+		t.Errorf("job3 state = %s, expected PENDING", proto.StateName[c.JobState("job3")])
+	}
+
+	// Job 3 is held because the chain is paused
+	held := traverser.Held()
+	if len(held) != 1 {
+		t.Fatalf("got %d held jobs, expected 1: %+v", len(held), held)
+	}
+	if held[0].JobId != "job3" || held[0].Hold != proto.HOLD_PAUSED || held[0].HeldSince == 0 {
+		t.Errorf("got held job %+v, expected job3 held %s", held[0], proto.HOLD_PAUSED)
+	}
+
+	if err := traverser.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-doneChan:
+	case <-time.After(time.Second):
+		t.Fatal("traverser did not finish running within 1 second of resume")
+	}
+	if held := traverser.Held(); len(held) != 0 {
+		t.Errorf("got held jobs %+v after resume, expected none", held)
+	}
+	if c.State() != proto.STATE_COMPLETE {
+		t.Errorf("chain state = %s, expected COMPLETE", proto.StateName[c.State()])
+	}
+	if c.Paused() {
+		t.Errorf("chain paused after resume")
+	}
+
+	// Chain is done, so it cannot be paused
+	if err := traverser.Pause(); err != chain.ErrNotPausable {
+		t.Errorf("pause done chain: got err %v, expected ErrNotPausable", err)
+	}
+}
+
+func TestPauseStop(t *testing.T) {
+	// Job Chain:
+	// -> 1 -> 2
+	// Stop while paused after job 1: job 2 never runs
+	requestId := "test_pause_stop"
+	chainRepo := chain.NewMemoryRepo()
+	var runWg sync.WaitGroup
+	runWg.Add(1)
+	job1Block := make(chan struct{})
+	rf := &mock.RunnerFactory{
+		RunnersToReturn: map[string]*mock.Runner{
+			"job1": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}, RunBlock: job1Block, RunWg: &runWg},
+			"job2": &mock.Runner{RunReturn: runner.Return{FinalState: proto.STATE_COMPLETE}},
+		},
+	}
+
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(2),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+		},
+	}
+	c := chain.NewChain(jc, make(map[string]uint), make(map[string]uint), make(map[string]uint))
+	traverser := chain.NewTraverser(chain.TraverserConfig{
+		Chain:         c,
+		ChainRepo:     chainRepo,
+		RunnerFactory: rf,
+		RMClient:      &mock.RMClient{},
+		ShutdownChan:  make(chan struct{}),
+		StopTimeout:   timeout,
+		SendTimeout:   timeout,
+	})
+
+	doneChan := make(chan struct{})
+	go func() {
+		traverser.Run()
+		close(doneChan)
+	}()
+
+	runWg.Wait()
+	if err := traverser.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	close(job1Block)
+	time.Sleep(100 * time.Millisecond) // give time for job 2 to wait
+
+	if err := traverser.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-doneChan:
+	case <-time.After(time.Second):
+		t.Fatal("traverser did not finish running within 1 second of stop")
+	}
+	if c.JobState("job2") != proto.STATE_PENDING {
+		t.Errorf("job2 state = %s, expected PENDING", proto.StateName[c.JobState("job2")])
+	}
+	if err := traverser.Resume(); err != chain.ErrNotPausable {
+		t.Errorf("resume stopped chain: got err %v, expected ErrNotPausable", err)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2020, Square, Inc.
+
+package chain
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/config"
+	"github.com/square/spincycle/v2/metrics"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/retry"
+)
+
+// A Watchdog warns about slow jobs: jobs that run longer than a multiple of the
+// expected duration of their type (Job Runner config slow_jobs). When a job is
+// slow, it logs a warning, counts metrics.JOBS_SLOW, and POSTs a proto.SlowJobEvent
+// to the webhook, if any. It does not stop the job, it only makes it visible
+// early. A nil *Watchdog watches nothing.
+type Watchdog struct {
+	watches map[string]watch // keyed on job type
+	metrics metrics.Metrics
+	client  *http.Client
+}
+
+type watch struct {
+	expected  time.Duration
+	threshold time.Duration // expected * factor
+	webhook   string
+}
+
+// NewWatchdog makes a Watchdog from the slow jobs config, which must be valid
+// (config.JobRunner.Validate). It returns nil if there are no slow jobs.
+func NewWatchdog(cfg []config.SlowJob, m metrics.Metrics) (*Watchdog, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+	if m == nil {
+		m = metrics.Nop{}
+	}
+	w := &Watchdog{
+		watches: map[string]watch{},
+		metrics: m,
+		client:  &http.Client{Timeout: defaultTimeout},
+	}
+	for i, sj := range cfg {
+		expected, err := time.ParseDuration(sj.Expected)
+		if err != nil || expected <= 0 {
+			return nil, fmt.Errorf("slow_jobs[%d].expected: invalid duration %q", i, sj.Expected)
+		}
+		factor := sj.Factor
+		if factor == 0 {
+			factor = config.DEFAULT_SLOW_JOB_FACTOR
+		}
+		for _, jobType := range sj.Types {
+			w.watches[jobType] = watch{
+				expected:  expected,
+				threshold: time.Duration(float64(expected) * factor),
+				webhook:   sj.Webhook,
+			}
+		}
+	}
+	return w, nil
+}
+
+// Watch starts watching a job that's about to run and returns a func that the
+// caller must call when the job is done. The job is slow at most once per run,
+// which includes all its tries.
+func (w *Watchdog) Watch(requestId string, job proto.Job, logger *log.Entry) (done func()) {
+	if w == nil {
+		return func() {}
+	}
+	wt, ok := w.watches[job.Type]
+	if !ok {
+		return func() {}
+	}
+	started := time.Now()
+	timer := time.AfterFunc(wt.threshold, func() {
+		w.slow(requestId, job, wt, time.Since(started), logger)
+	})
+	return func() { timer.Stop() }
+}
+
+func (w *Watchdog) slow(requestId string, job proto.Job, wt watch, running time.Duration, logger *log.Entry) {
+	logger.Warnf("slow job: running %s, expected %s (slow after %s)", running.Round(time.Second), wt.expected, wt.threshold)
+	w.metrics.Count(metrics.JOBS_SLOW, 1, metrics.Tags{"type": job.Type})
+	if wt.webhook == "" {
+		return
+	}
+	ev := proto.SlowJobEvent{
+		RequestId: requestId,
+		JobId:     job.Id,
+		JobName:   job.Name,
+		Type:      job.Type,
+		Expected:  wt.expected.Nanoseconds(),
+		Threshold: wt.threshold.Nanoseconds(),
+		Running:   running.Nanoseconds(),
+		Ts:        time.Now().UTC(),
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		logger.Errorf("cannot marshal slow job event: %s", err)
+		return
+	}
+	// Already running in the timer goroutine, so it's ok to block on retries
+	err = retry.Do(webhookTries, webhookRetryWait,
+		func() error { return postJSON(w.client, wt.webhook, payload) },
+		func(err error) { logger.Warnf("error sending slow job event to %s: %s (retrying)", wt.webhook, err) },
+	)
+	if err != nil {
+		logger.Errorf("failed to send slow job event to %s: %s", wt.webhook, err)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2020, Square, Inc.
+
+package chain_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/config"
+	"github.com/square/spincycle/v2/job-runner/chain"
+	"github.com/square/spincycle/v2/metrics"
+	"github.com/square/spincycle/v2/proto"
+)
+
+func TestWatchdog(t *testing.T) {
+	events := make(chan proto.SlowJobEvent, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev proto.SlowJobEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		events <- ev
+	}))
+	defer ts.Close()
+
+	m := metrics.NewPrometheus("")
+	wd, err := chain.NewWatchdog([]config.SlowJob{
+		{Types: []string{"slow"}, Expected: "10ms", Factor: 2, Webhook: ts.URL},
+	}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.WithFields(log.Fields{"request_id": "req1"})
+
+	// Done before 20ms (2 x 10ms): not slow
+	done := wd.Watch("req1", proto.Job{Id: "job1", Name: "a", Type: "slow"}, logger)
+	done()
+
+	// Not watched
+	done = wd.Watch("req1", proto.Job{Id: "job2", Name: "b", Type: "fast"}, logger)
+	time.Sleep(50 * time.Millisecond)
+	done()
+
+	// Running longer than 20ms: slow, but only once
+	done = wd.Watch("req1", proto.Job{Id: "job3", Name: "c", Type: "slow"}, logger)
+	var ev proto.SlowJobEvent
+	select {
+	case ev = <-events:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for slow job event")
+	}
+	done()
+	if ev.RequestId != "req1" || ev.JobId != "job3" || ev.JobName != "c" || ev.Type != "slow" {
+		t.Errorf("got event %+v, expected req1 job3 (c) type slow", ev)
+	}
+	if ev.Expected != (10*time.Millisecond).Nanoseconds() || ev.Threshold != (20*time.Millisecond).Nanoseconds() {
+		t.Errorf("got expected %d threshold %d, expected 10ms and 20ms", ev.Expected, ev.Threshold)
+	}
+	if ev.Running < ev.Threshold {
+		t.Errorf("running %d less than threshold %d", ev.Running, ev.Threshold)
+	}
+	select {
+	case ev = <-events:
+		t.Errorf("got another event %+v, expected one", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	var buf bytes.Buffer
+	m.Write(&buf)
+	if !strings.Contains(buf.String(), `jobs_slow_total{type="slow"} 1`) {
+		t.Errorf("metrics %q do not count 1 slow job", buf.String())
+	}
+
+	// No slow jobs, no watchdog, which watches nothing
+	wd, err = chain.NewWatchdog(nil, m)
+	if err != nil || wd != nil {
+		t.Errorf("got watchdog %v, error %v; expected nil, nil", wd, err)
+	}
+	wd.Watch("req1", proto.Job{Id: "job1", Type: "slow"}, logger)()
+}
@@ -0,0 +1,121 @@
+// Copyright 2020, Square, Inc.
+
+package chain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/retry"
+)
+
+const (
+	// Number of times to attempt sending a sequence event to a webhook.
+	webhookTries = 3
+	// Time to wait between attempts to send a sequence event to a webhook.
+	webhookRetryWait = time.Second
+)
+
+// A Notifier sends sequence events to sequence webhooks (spec webhooks:). The
+// running and suspended reapers send events as jobs are reaped: start when the
+// first job of a sequence completes, complete when its last job completes, and
+// fail when one of its jobs fails and the sequence cannot be retried.
+type Notifier interface {
+	// Notify sends the event to the webhook. It does not block: errors are
+	// retried and logged, not returned.
+	Notify(wh proto.SequenceWebhook, ev proto.SequenceEvent)
+}
+
+type httpNotifier struct {
+	client *http.Client
+}
+
+// NewNotifier makes a Notifier that POSTs events as JSON using the given client.
+// A webhook must return HTTP status 2xx, else the event is retried.
+func NewNotifier(client *http.Client) Notifier {
+	return &httpNotifier{
+		client: client,
+	}
+}
+
+func (n *httpNotifier) Notify(wh proto.SequenceWebhook, ev proto.SequenceEvent) {
+	logger := log.WithFields(log.Fields{"request_id": ev.RequestId, "sequence": ev.Sequence, "sequence_id": ev.StartJobId, "event": ev.Event})
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		logger.Errorf("cannot marshal sequence event: %s", err)
+		return
+	}
+	go func() {
+		err := retry.Do(webhookTries, webhookRetryWait,
+			func() error { return postJSON(n.client, wh.URL, payload) },
+			func(err error) { logger.Warnf("error sending sequence event to %s: %s (retrying)", wh.URL, err) },
+		)
+		if err != nil {
+			logger.Errorf("failed to send sequence event to %s: %s", wh.URL, err)
+		}
+	}()
+}
+
+// postJSON POSTs the JSON payload to the URL, which must return HTTP status 2xx.
+func postJSON(client *http.Client, url string, payload []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) // so the connection can be reused
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notifySequences sends the event to the webhooks of sequences that the job
+// starts (event start), completes (event complete), or is part of (event fail).
+// It does nothing if the reaper has no Notifier.
+func (r *reaper) notifySequences(event string, job proto.Job) {
+	if r.notifier == nil {
+		return
+	}
+	for _, wh := range r.chain.Webhooks() {
+		switch event {
+		case proto.SEQUENCE_EVENT_START:
+			if wh.StartJobId != job.Id {
+				continue
+			}
+		case proto.SEQUENCE_EVENT_COMPLETE:
+			if wh.EndJobId != job.Id {
+				continue
+			}
+		case proto.SEQUENCE_EVENT_FAIL:
+			if !contains(wh.JobIds, job.Id) {
+				continue
+			}
+		}
+		if len(wh.Events) > 0 && !contains(wh.Events, event) {
+			continue
+		}
+		ev := proto.SequenceEvent{
+			Event:      event,
+			RequestId:  r.chain.RequestId(),
+			Sequence:   wh.Sequence,
+			StartJobId: wh.StartJobId,
+			Try:        r.chain.SequenceTries(wh.StartJobId),
+			Args:       wh.Args,
+			Ts:         time.Now().UTC(),
+		}
+		if event == proto.SEQUENCE_EVENT_FAIL {
+			ev.JobId = job.Id
+		}
+		r.logger.WithFields(log.Fields{"sequence_id": wh.StartJobId}).Infof("sequence %s %s, notifying %s", wh.Sequence, event, wh.URL)
+		r.notifier.Notify(wh, ev)
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2020, Square, Inc.
+
+// Package checkpoint saves checkpoints of running job chains on local disk and
+// recovers them when the Job Runner restarts after a crash. Without checkpoints,
+// the Request Manager recovers requests from a dead Job Runner from their job
+// chain and job log, which loses job data and sequence tries. It's only used if
+// checkpointing is enabled in the Job Runner config (config.JobRunner.CheckpointDir).
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/proto"
+	rm "github.com/square/spincycle/v2/request-manager"
+)
+
+const ext = ".json"
+
+// A Store saves checkpoints in a directory, one file per request named by
+// request ID. It implements chain.Checkpointer. It's safe for concurrent use
+// with different request IDs; the traverser running a chain is the only writer
+// of its checkpoint.
+type Store struct {
+	dir string
+}
+
+// NewStore makes a Store that saves checkpoints in the directory, creating it
+// if it does not exist.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create checkpoint dir: %s", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Save saves the checkpoint, replacing the previous one for the request. The
+// file is replaced atomically, so a crash while saving leaves the previous
+// checkpoint.
+func (s *Store) Save(sjc proto.SuspendedJobChain) error {
+	file, err := s.file(sjc.RequestId)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(sjc)
+	if err != nil {
+		return fmt.Errorf("cannot marshal checkpoint: %s", err)
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, bytes, 0600); err != nil {
+		return fmt.Errorf("cannot write checkpoint: %s", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return fmt.Errorf("cannot write checkpoint: %s", err)
+	}
+	return nil
+}
+
+// Load returns the checkpoint for the request.
+func (s *Store) Load(requestId string) (proto.SuspendedJobChain, error) {
+	var sjc proto.SuspendedJobChain
+	file, err := s.file(requestId)
+	if err != nil {
+		return sjc, err
+	}
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return sjc, fmt.Errorf("cannot read checkpoint: %s", err)
+	}
+	if err := json.Unmarshal(bytes, &sjc); err != nil {
+		return sjc, fmt.Errorf("invalid checkpoint %s: %s", file, err)
+	}
+	if sjc.JobChain == nil || sjc.RequestId != requestId {
+		return sjc, fmt.Errorf("invalid checkpoint %s: no job chain for request %s", file, requestId)
+	}
+	return sjc, nil
+}
+
+// Remove removes the checkpoint for the request. It's not an error if there's
+// no checkpoint.
+func (s *Store) Remove(requestId string) error {
+	file, err := s.file(requestId)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove checkpoint: %s", err)
+	}
+	return nil
+}
+
+// List returns the request IDs of all checkpoints, sorted.
+func (s *Store) List() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read checkpoint dir: %s", err)
+	}
+	ids := []string{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ext) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(f.Name(), ext))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *Store) file(requestId string) (string, error) {
+	if requestId == "" || strings.ContainsAny(requestId, `/\.`) {
+		return "", fmt.Errorf("invalid request ID %q", requestId)
+	}
+	return filepath.Join(s.dir, requestId+ext), nil
+}
+
+// --------------------------------------------------------------------------
+
+// Recovery recovers the job chains checkpointed by a Job Runner that crashed.
+// It's run once when the Job Runner starts, before it runs new job chains.
+type Recovery struct {
+	Store        *Store
+	RMClient     rm.Client
+	JobRunnerURL string // URL of this Job Runner, as reported to the RM
+
+	// Resume runs the job chain on this Job Runner. If it returns an error,
+	// the job chain is handed back to the RM instead.
+	Resume func(*proto.SuspendedJobChain) error
+}
+
+// Report reports what Recovery.Run did with each checkpoint, by request ID.
+type Report struct {
+	Resumed    []string // running again on this Job Runner
+	HandedBack []string // suspended in the RM, which resumes it on any Job Runner
+	Discarded  []string // done, not running on this Job Runner, or invalid
+	Failed     []string // not recovered, checkpoint kept for the next start
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("%d resumed %v, %d handed back %v, %d discarded %v, %d failed %v",
+		len(r.Resumed), r.Resumed, len(r.HandedBack), r.HandedBack,
+		len(r.Discarded), r.Discarded, len(r.Failed), r.Failed)
+}
+
+// Run reconciles every checkpoint with the RM and recovers it:
+//
+//   - If the request is still running on this Job Runner according to the RM,
+//     which means the RM did not recover it yet, it's resumed from the checkpoint.
+//     If it cannot be resumed, it's handed back to the RM as a suspended job
+//     chain, the same as when the Job Runner shuts down.
+//   - Else, the request is done or was recovered by the RM, so the checkpoint
+//     is discarded.
+//
+// Jobs that were running when the Job Runner crashed are run again on the same
+// try. Errors are logged, and the checkpoint is kept to try again on the next
+// start. Run logs and returns a report.
+func (r Recovery) Run() Report {
+	var report Report
+	ids, err := r.Store.List()
+	if err != nil {
+		log.Errorf("checkpoint recovery: %s", err)
+		return report
+	}
+	for _, id := range ids {
+		switch r.recover(id) {
+		case resumed:
+			report.Resumed = append(report.Resumed, id)
+		case handedBack:
+			report.HandedBack = append(report.HandedBack, id)
+		case discarded:
+			report.Discarded = append(report.Discarded, id)
+		default:
+			report.Failed = append(report.Failed, id)
+		}
+	}
+	if len(ids) > 0 {
+		log.Infof("checkpoint recovery: %s", report)
+	}
+	return report
+}
+
+const (
+	failed = iota
+	resumed
+	handedBack
+	discarded
+)
+
+func (r Recovery) recover(requestId string) int {
+	reqLogger := log.WithFields(log.Fields{"request_id": requestId})
+
+	sjc, err := r.Store.Load(requestId)
+	if err != nil {
+		reqLogger.Warnf("checkpoint recovery: discarding checkpoint: %s", err)
+		r.remove(requestId)
+		return discarded
+	}
+
+	req, err := r.RMClient.GetRequest(requestId)
+	if err != nil {
+		reqLogger.Errorf("checkpoint recovery: cannot get request from Request Manager: %s", err)
+		return failed
+	}
+	if req.State != proto.STATE_RUNNING || req.JobRunnerURL != r.JobRunnerURL {
+		reqLogger.Infof("checkpoint recovery: discarding checkpoint: request is %s on Job Runner %q",
+			proto.StateName[req.State], req.JobRunnerURL)
+		r.remove(requestId)
+		return discarded
+	}
+
+	interrupted(&sjc)
+	err = r.Resume(&sjc)
+	if err == nil {
+		// The traverser replaces the checkpoint as the chain runs
+		reqLogger.Infof("checkpoint recovery: resumed job chain")
+		return resumed
+	}
+	reqLogger.Warnf("checkpoint recovery: cannot resume job chain, handing it back to the Request Manager: %s", err)
+	if err := r.RMClient.SuspendRequest(requestId, sjc); err != nil {
+		reqLogger.Errorf("checkpoint recovery: cannot suspend request: %s", err)
+		return failed
+	}
+	reqLogger.Infof("checkpoint recovery: suspended request, it will be resumed on another Job Runner")
+	r.remove(requestId)
+	return handedBack
+}
+
+func (r Recovery) remove(requestId string) {
+	if err := r.Store.Remove(requestId); err != nil {
+		log.WithFields(log.Fields{"request_id": requestId}).Warnf("checkpoint recovery: %s", err)
+	}
+}
+
+// interrupted changes a checkpoint into a suspended job chain, as if the Job
+// Runner had suspended the chain instead of crashing. Jobs that were running are
+// stopped on the try they were running: it's counted, and the Job Runner runs
+// it again when the chain is resumed, telling the job it was interrupted.
+func interrupted(sjc *proto.SuspendedJobChain) {
+	sjc.JobChain.State = proto.STATE_SUSPENDED
+	if sjc.TotalJobTries == nil {
+		sjc.TotalJobTries = map[string]uint{}
+	}
+	if sjc.LatestRunJobTries == nil {
+		sjc.LatestRunJobTries = map[string]uint{}
+	}
+	if sjc.SequenceTries == nil {
+		sjc.SequenceTries = map[string]uint{}
+	}
+	for id, job := range sjc.JobChain.Jobs {
+		if job.State != proto.STATE_RUNNING {
+			continue
+		}
+		job.State = proto.STATE_STOPPED
+		job.StopReason = proto.STOP_REASON_INTERRUPTED
+		sjc.JobChain.Jobs[id] = job
+		sjc.TotalJobTries[id]++
+		sjc.LatestRunJobTries[id]++
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2020, Square, Inc.
+
+package checkpoint_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/job-runner/checkpoint"
+	"github.com/square/spincycle/v2/proto"
+	testutil "github.com/square/spincycle/v2/test"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+const jrURL = "https://jr1:32307"
+
+func newStore(t *testing.T) (*checkpoint.Store, string) {
+	dir, err := ioutil.TempDir("", "spincycle-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := checkpoint.NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+// Checkpoint with job1 complete and job2 running on its first try
+func runningSJC(requestId string) proto.SuspendedJobChain {
+	jc := &proto.JobChain{
+		RequestId: requestId,
+		Jobs:      testutil.InitJobs(2),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+		},
+		State: proto.STATE_RUNNING,
+	}
+	job1 := jc.Jobs["job1"]
+	job1.State = proto.STATE_COMPLETE
+	jc.Jobs["job1"] = job1
+	job2 := jc.Jobs["job2"]
+	job2.State = proto.STATE_RUNNING
+	job2.Data = map[string]interface{}{"k1": "v1"}
+	jc.Jobs["job2"] = job2
+	return proto.SuspendedJobChain{
+		RequestId:         requestId,
+		JobChain:          jc,
+		TotalJobTries:     map[string]uint{"job1": 1},
+		LatestRunJobTries: map[string]uint{"job1": 1},
+		SequenceTries:     map[string]uint{"job1": 1},
+	}
+}
+
+func TestStore(t *testing.T) {
+	s, dir := newStore(t)
+	defer os.RemoveAll(dir)
+
+	if err := s.Save(runningSJC("req2")); err != nil {
+		t.Fatal(err)
+	}
+	sjc := runningSJC("req1")
+	if err := s.Save(sjc); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(ids, []string{"req1", "req2"}); diff != nil {
+		t.Error(diff)
+	}
+
+	got, err := s.Load("req1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got, sjc); diff != nil {
+		t.Error(diff)
+	}
+
+	if err := s.Remove("req1"); err != nil {
+		t.Error(err)
+	}
+	if err := s.Remove("req1"); err != nil {
+		t.Errorf("error removing checkpoint twice: %s, expected nil", err)
+	}
+	if _, err := s.Load("req1"); err == nil {
+		t.Error("no error loading removed checkpoint")
+	}
+	if err := s.Save(proto.SuspendedJobChain{RequestId: "../req3"}); err == nil {
+		t.Error("no error saving checkpoint with invalid request ID")
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	s, dir := newStore(t)
+	defer os.RemoveAll(dir)
+
+	// req1: running on this JR, resumed
+	// req2: running on this JR, cannot be resumed, handed back
+	// req3: completed, discarded
+	// req4: RM recovered it, running on another JR, discarded
+	// req5: RM error, failed
+	// req6: invalid checkpoint, discarded
+	for _, id := range []string{"req1", "req2", "req3", "req4", "req5"} {
+		if err := s.Save(runningSJC(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "req6.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := map[string]proto.Request{
+		"req1": {Id: "req1", State: proto.STATE_RUNNING, JobRunnerURL: jrURL},
+		"req2": {Id: "req2", State: proto.STATE_RUNNING, JobRunnerURL: jrURL},
+		"req3": {Id: "req3", State: proto.STATE_COMPLETE, JobRunnerURL: jrURL},
+		"req4": {Id: "req4", State: proto.STATE_RUNNING, JobRunnerURL: "https://jr2:32307"},
+	}
+	var suspended []proto.SuspendedJobChain
+	rmc := &mock.RMClient{
+		GetRequestFunc: func(id string) (proto.Request, error) {
+			req, ok := reqs[id]
+			if !ok {
+				return req, errors.New("RM unavailable")
+			}
+			return req, nil
+		},
+		SuspendRequestFunc: func(id string, sjc proto.SuspendedJobChain) error {
+			suspended = append(suspended, sjc)
+			return nil
+		},
+	}
+	var resumed []proto.SuspendedJobChain
+	r := checkpoint.Recovery{
+		Store:        s,
+		RMClient:     rmc,
+		JobRunnerURL: jrURL,
+		Resume: func(sjc *proto.SuspendedJobChain) error {
+			if sjc.RequestId == "req2" {
+				return errors.New("cannot resume")
+			}
+			resumed = append(resumed, *sjc)
+			return nil
+		},
+	}
+
+	got := r.Run()
+	expect := checkpoint.Report{
+		Resumed:    []string{"req1"},
+		HandedBack: []string{"req2"},
+		Discarded:  []string{"req3", "req4", "req6"},
+		Failed:     []string{"req5"},
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Running job2 is stopped on its first try, which is counted, so it's
+	// run again on the same try
+	if len(resumed) != 1 {
+		t.Fatalf("%d chains resumed, expected 1", len(resumed))
+	}
+	sjc := resumed[0]
+	if s := sjc.JobChain.Jobs["job2"].State; s != proto.STATE_STOPPED {
+		t.Errorf("job2 state %s, expected STOPPED", proto.StateName[s])
+	}
+	if r := sjc.JobChain.Jobs["job2"].StopReason; r != proto.STOP_REASON_INTERRUPTED {
+		t.Errorf("job2 stop reason %q, expected %q", r, proto.STOP_REASON_INTERRUPTED)
+	}
+	if sjc.LatestRunJobTries["job2"] != 1 || sjc.TotalJobTries["job2"] != 1 {
+		t.Errorf("job2 tries %d (total %d), expected 1 (1)", sjc.LatestRunJobTries["job2"], sjc.TotalJobTries["job2"])
+	}
+	if v := sjc.JobChain.Jobs["job2"].Data["k1"]; v != "v1" {
+		t.Errorf("job2 data k1 = %v, expected v1", v)
+	}
+	if sjc.JobChain.State != proto.STATE_SUSPENDED {
+		t.Errorf("chain state %s, expected SUSPENDED", proto.StateName[sjc.JobChain.State])
+	}
+	if len(suspended) != 1 || suspended[0].RequestId != "req2" {
+		t.Errorf("suspended %+v, expected req2", suspended)
+	}
+
+	// Resumed and failed checkpoints are kept: the traverser saves the
+	// resumed chain, and failed ones are tried again on the next start
+	ids, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(ids, []string{"req1", "req5"}); diff != nil {
+		t.Error(diff)
+	}
+}
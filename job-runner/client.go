@@ -7,13 +7,71 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 
 	"github.com/square/spincycle/v2/proto"
 )
 
+// ErrChainRejected is returned when the JR rejects a job chain or suspended job
+// chain because it is invalid (HTTP 400). It is also returned when the JR rejects
+// a job added to a running job chain, and approving a job that is not a gate job
+// waiting for approval. Sending the same job chain or job again
+// will not succeed.
+type ErrChainRejected struct {
+	Message string
+}
+
+func (e ErrChainRejected) Error() string {
+	return "Job Runner rejected the job chain: " + e.Message
+}
+
+// ErrChainRunning is returned when the JR is already running a job chain for
+// the request (HTTP 409), so the job chain or suspended job chain was sent to
+// it before.
+type ErrChainRunning struct {
+	RequestId string
+}
+
+func (e ErrChainRunning) Error() string {
+	return fmt.Sprintf("Job Runner is already running request %s", e.RequestId)
+}
+
+// ErrBusy is returned when the JR is not accepting new job chains (HTTP 503)
+// because it is shutting down. Another JR might accept the job chain.
+type ErrBusy struct {
+	Message string
+}
+
+func (e ErrBusy) Error() string {
+	return "Job Runner is not accepting job chains: " + e.Message
+}
+
+// ErrNotFound is returned when the JR is not running the request's job chain
+// (HTTP 404), for example because it finished.
+type ErrNotFound struct {
+	RequestId string
+}
+
+func (e ErrNotFound) Error() string {
+	return fmt.Sprintf("Job Runner is not running request %s", e.RequestId)
+}
+
+// ErrUnavailable is returned when the JR cannot be reached or responds with
+// a transient error (HTTP 502 or 504), after all retries.
+type ErrUnavailable struct {
+	Err error
+}
+
+func (e ErrUnavailable) Error() string {
+	return "Job Runner unavailable: " + e.Err.Error()
+}
+
 // A Client is an HTTP client used for interacting with the JR API.
 type Client interface {
 	// NewJobChain takes a job chain, and sends it to the JR to be run immediately.
@@ -25,13 +83,54 @@ type Client interface {
 	// StopRequest stops the job chain that corresponds to a given request Id. The
 	// baseURL should point to the Job Runner running this request.
 	StopRequest(baseURL string, requestId string) error
+	// PauseRequest pauses the job chain that corresponds to a given request Id:
+	// the JR doesn't start new jobs until ResumeRequest is called. The baseURL
+	// should point to the Job Runner running this request.
+	PauseRequest(baseURL string, requestId string) error
+	// ResumeRequest resumes the paused job chain that corresponds to a given
+	// request Id. The baseURL should point to the Job Runner running this request.
+	ResumeRequest(baseURL string, requestId string) error
+	// AddJob adds a job to the running job chain that corresponds to a given
+	// request Id. The baseURL should point to the Job Runner running this request.
+	AddJob(baseURL string, requestId string, aj proto.AddChainJob) error
+	// Profile makes the Job Runner capture profiles while the job chain that
+	// corresponds to a given request Id runs, and send them to the RM. The
+	// baseURL should point to the Job Runner running this request.
+	Profile(baseURL string, requestId string, pc proto.ProfileCapture) error
+	// ApproveJob approves the gate job (package gate) waiting for approval in the
+	// job chain that corresponds to a given request Id. It returns ErrChainRejected
+	// if the job is not waiting for approval. The baseURL should point to the Job
+	// Runner running this request.
+	ApproveJob(baseURL string, requestId, jobId string, aj proto.ApproveJob) error
 
 	// Running reports running jobs. If no filters, all requests and jobs are reported.
 	Running(baseURL string, f proto.StatusFilter) ([]proto.JobStatus, error)
+
+	// JobTypes returns the job types that the JR job factory can make. It returns
+	// nil and no error if the job factory does not list job types (HTTP 501).
+	JobTypes(baseURL string) ([]string, error)
+}
+
+// ClientConfig configures a Client made by NewClientWithConfig.
+type ClientConfig struct {
+	// StreamJobs is the number of jobs at which job chains are streamed as
+	// NDJSON (see proto.WriteJobChain) instead of marshaled into one JSON
+	// document. If zero, job chains are never streamed.
+	StreamJobs uint
+
+	// Retry is how many times a call is retried on a transient failure: the JR
+	// cannot be reached, or it responds HTTP 502 or 504. Other errors are not
+	// retried. RetryWait is the wait before the first retry, doubled on each
+	// later retry.
+	Retry     uint
+	RetryWait time.Duration
 }
 
 type client struct {
 	*http.Client
+	streamJobs uint
+	retry      uint
+	retryWait  time.Duration
 }
 
 // NewClient takes an http.Client and base API URL and creates a Client.
@@ -41,29 +140,51 @@ func NewClient(c *http.Client) Client {
 	}
 }
 
+// NewStreamingClient creates a Client that streams job chains with at least
+// streamJobs jobs as NDJSON (see proto.WriteJobChain) instead of marshaling them
+// into one JSON document. If streamJobs is zero, chains are never streamed,
+// like NewClient.
+func NewStreamingClient(c *http.Client, streamJobs uint) Client {
+	return NewClientWithConfig(c, ClientConfig{StreamJobs: streamJobs})
+}
+
+// NewClientWithConfig creates a Client that streams and retries as configured.
+func NewClientWithConfig(c *http.Client, cfg ClientConfig) Client {
+	return &client{
+		Client:     c,
+		streamJobs: cfg.StreamJobs,
+		retry:      cfg.Retry,
+		retryWait:  cfg.RetryWait,
+	}
+}
+
 func (c *client) NewJobChain(baseURL string, jobChain proto.JobChain) (*url.URL, error) {
 	var chainURL *url.URL
 
 	// POST /api/v1/job-chains
 	url := baseURL + "/api/v1/job-chains"
 
-	// Create the payload.
-	payload, err := json.Marshal(jobChain)
-	if err != nil {
-		return chainURL, err
+	// Make the request, streaming large job chains.
+	var resp *http.Response
+	var err error
+	if c.streamJobs > 0 && uint(len(jobChain.Jobs)) >= c.streamJobs {
+		resp, err = c.try(func() (*http.Response, []byte, error) {
+			return c.postStream(url, jobChain)
+		}, jobChain.RequestId)
+	} else {
+		var payload []byte
+		payload, err = json.Marshal(jobChain)
+		if err != nil {
+			return chainURL, err
+		}
+		resp, err = c.try(func() (*http.Response, []byte, error) {
+			return c.post(url, payload)
+		}, jobChain.RequestId)
 	}
-
-	// Make the request.
-	resp, body, err := c.post(url, payload)
 	if err != nil {
 		return chainURL, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return chainURL, fmt.Errorf("jr.Client.NewJobChain - unsuccessful status code: %d (response body: %s)",
-			resp.StatusCode, string(body))
-	}
-
 	// Retrieve the URL of the JR host that's running the job chain.
 	chainURL, err = resp.Location()
 	if err != nil {
@@ -86,16 +207,13 @@ func (c *client) ResumeJobChain(baseURL string, sjc proto.SuspendedJobChain) (*u
 	}
 
 	// Make the request.
-	resp, body, err := c.post(url, payload)
+	resp, err := c.try(func() (*http.Response, []byte, error) {
+		return c.post(url, payload)
+	}, sjc.RequestId)
 	if err != nil {
 		return chainURL, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return chainURL, fmt.Errorf("jr.Client.ResumeJobChain - unsuccessful status code: %d (response body: %s)",
-			resp.StatusCode, string(body))
-	}
-
 	// Retrieve the URL of the JR host that's running the job chain.
 	chainURL, err = resp.Location()
 	if err != nil {
@@ -110,16 +228,73 @@ func (c *client) StopRequest(baseURL string, requestId string) error {
 	url := fmt.Sprintf(baseURL+"/api/v1/job-chains/%s/stop", requestId)
 
 	// Make the request.
-	resp, body, err := c.put(url)
+	_, err := c.try(func() (*http.Response, []byte, error) {
+		return c.put(url)
+	}, requestId)
+	return err
+}
+
+func (c *client) PauseRequest(baseURL string, requestId string) error {
+	// PUT /api/v1/job-chains/${requestId}/pause
+	url := fmt.Sprintf(baseURL+"/api/v1/job-chains/%s/pause", requestId)
+	_, err := c.try(func() (*http.Response, []byte, error) {
+		return c.put(url)
+	}, requestId)
+	return err
+}
+
+func (c *client) ResumeRequest(baseURL string, requestId string) error {
+	// PUT /api/v1/job-chains/${requestId}/resume
+	url := fmt.Sprintf(baseURL+"/api/v1/job-chains/%s/resume", requestId)
+	_, err := c.try(func() (*http.Response, []byte, error) {
+		return c.put(url)
+	}, requestId)
+	return err
+}
+
+func (c *client) AddJob(baseURL string, requestId string, aj proto.AddChainJob) error {
+	// POST /api/v1/job-chains/${requestId}/jobs
+	url := fmt.Sprintf(baseURL+"/api/v1/job-chains/%s/jobs", requestId)
+
+	// Create the payload.
+	payload, err := json.Marshal(aj)
 	if err != nil {
 		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unsuccessful status code: %d (response body: %s)",
-			resp.StatusCode, string(body))
+	// Make the request.
+	_, err = c.try(func() (*http.Response, []byte, error) {
+		return c.post(url, payload)
+	}, requestId)
+	return err
+}
+
+func (c *client) Profile(baseURL string, requestId string, pc proto.ProfileCapture) error {
+	// POST /api/v1/job-chains/${requestId}/profile
+	url := fmt.Sprintf(baseURL+"/api/v1/job-chains/%s/profile", requestId)
+
+	payload, err := json.Marshal(pc)
+	if err != nil {
+		return err
 	}
-	return nil
+	_, err = c.try(func() (*http.Response, []byte, error) {
+		return c.post(url, payload)
+	}, requestId)
+	return err
+}
+
+func (c *client) ApproveJob(baseURL string, requestId, jobId string, aj proto.ApproveJob) error {
+	// POST /api/v1/job-chains/${requestId}/jobs/${jobId}/approve
+	url := fmt.Sprintf(baseURL+"/api/v1/job-chains/%s/jobs/%s/approve", requestId, jobId)
+
+	payload, err := json.Marshal(aj)
+	if err != nil {
+		return err
+	}
+	_, err = c.try(func() (*http.Response, []byte, error) {
+		return c.post(url, payload)
+	}, requestId)
+	return err
 }
 
 func (c *client) Running(baseURL string, f proto.StatusFilter) ([]proto.JobStatus, error) {
@@ -139,8 +314,81 @@ func (c *client) Running(baseURL string, f proto.StatusFilter) ([]proto.JobStatu
 	return status, nil
 }
 
+func (c *client) JobTypes(baseURL string) ([]string, error) {
+	// GET /api/v1/job-types
+	url := baseURL + "/api/v1/job-types"
+	resp, body, err := c.get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotImplemented {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unsuccessful status code: %d (response body: %s)", resp.StatusCode, string(body))
+	}
+	types := []string{}
+	if err := json.Unmarshal(body, &types); err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
 // ------------------------------------------------------------------------- //
 
+// try makes a request by calling f, retrying transient failures, and returns
+// the response if HTTP 200, else a typed error: ErrChainRejected, ErrBusy,
+// ErrNotFound, or ErrUnavailable. Other HTTP statuses return a generic error.
+func (c *client) try(f func() (*http.Response, []byte, error), requestId string) (*http.Response, error) {
+	wait := c.retryWait
+	for i := uint(0); ; i++ {
+		resp, body, err := f()
+		if err == nil && resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		transient := true
+		if err == nil {
+			switch resp.StatusCode {
+			case http.StatusBadGateway, http.StatusGatewayTimeout:
+				err = fmt.Errorf("HTTP status %d (response body: %s)", resp.StatusCode, string(body))
+			case http.StatusBadRequest:
+				return nil, ErrChainRejected{Message: errorMessage(body)}
+			case http.StatusConflict:
+				return nil, ErrChainRunning{RequestId: requestId}
+			case http.StatusServiceUnavailable:
+				return nil, ErrBusy{Message: errorMessage(body)}
+			case http.StatusNotFound:
+				return nil, ErrNotFound{RequestId: requestId}
+			default:
+				transient = false
+				err = fmt.Errorf("unsuccessful status code: %d (response body: %s)", resp.StatusCode, string(body))
+			}
+		}
+		if !transient {
+			return nil, err
+		}
+		if i == c.retry {
+			return nil, ErrUnavailable{Err: err}
+		}
+		log.Warnf("request %s: Job Runner call failed, retrying in %s: %s", requestId, wait, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// errorMessage returns the message of a JR API error response, which is
+// JSON like {"message":"..."}, or the whole body if it's not.
+func errorMessage(body []byte) string {
+	var e struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &e); err == nil && e.Message != "" {
+		return e.Message
+	}
+	return string(body)
+}
+
 func (c *client) get(url string) (*http.Response, []byte, error) {
 	// Create the request.
 	req, err := http.NewRequest("GET", url, nil)
@@ -189,8 +437,27 @@ func (c *client) post(url string, payload []byte) (*http.Response, []byte, error
 	return resp, body, nil
 }
 
+// postStream posts the job chain as NDJSON. The chain is written to the request
+// body as it's sent, so the whole payload is never in memory.
+func (c *client) postStream(url string, jobChain proto.JobChain) (*http.Response, []byte, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(proto.WriteJobChain(pw, jobChain))
+	}()
+	defer pr.Close() // unblock writer if request fails
+
+	req, err := http.NewRequest("POST", url, pr)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", proto.CONTENT_TYPE_NDJSON)
+	return c.do(req)
+}
+
 func (c *client) do(req *http.Request) (*http.Response, []byte, error) {
-	req.Header.Set("Content-Type", "application/json")
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	resp, err := c.Client.Do(req)
 	if err != nil {
 		return nil, nil, fmt.Errorf("http.Client.Do: %s", err)
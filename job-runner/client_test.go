@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 	jr "github.com/square/spincycle/v2/job-runner"
@@ -211,6 +212,51 @@ func TestStopRequest(t *testing.T) {
 	}
 }
 
+func TestAddJob(t *testing.T) {
+	// Job Runner rejects the job
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message":"job j1 is the last job in the job chain"}`))
+	}))
+	c := jr.NewClient(&http.Client{})
+
+	err := c.AddJob(ts.URL, "2", proto.AddChainJob{After: "j1", Job: proto.Job{Id: "j9"}})
+	if expect := (jr.ErrChainRejected{Message: "job j1 is the last job in the job chain"}); err != expect {
+		t.Errorf("err = %v, expected %v", err, expect)
+	}
+	ts.Close()
+
+	// Successful response status code.
+	var path string
+	var method string
+	var got proto.AddChainJob
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		method = r.Method
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusOK)
+	}))
+	c = jr.NewClient(&http.Client{})
+
+	aj := proto.AddChainJob{After: "j1", Job: proto.Job{Id: "j9", Name: "diag", Type: "diag"}}
+	err = c.AddJob(ts.URL, "2", aj)
+	if err != nil {
+		t.Errorf("err = %s, expected nil", err)
+	}
+	ts.Close()
+
+	expectedPath := "/api/v1/job-chains/2/jobs"
+	if path != expectedPath {
+		t.Errorf("url path = %s, expected %s", path, expectedPath)
+	}
+	if method != "POST" {
+		t.Errorf("request method = %s, expected POST", method)
+	}
+	if diff := deep.Equal(got, aj); diff != nil {
+		t.Error(diff)
+	}
+}
+
 func TestRunning(t *testing.T) {
 	var path string
 	var method string
@@ -256,3 +302,134 @@ func TestRunning(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestJobTypes(t *testing.T) {
+	var path string
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`["noop","sleep"]`))
+		} else {
+			w.Write([]byte(`{"message":"job factory does not list job types"}`))
+		}
+	}))
+	defer ts.Close()
+	c := jr.NewClient(&http.Client{})
+
+	types, err := c.JobTypes(ts.URL)
+	if err != nil {
+		t.Errorf("err = %s, expected nil", err)
+	}
+	if path != "/api/v1/job-types" {
+		t.Errorf("url path = %s, expected /api/v1/job-types", path)
+	}
+	if diff := deep.Equal(types, []string{"noop", "sleep"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// JR job factory does not list job types: nil, not an error
+	status = http.StatusNotImplemented
+	types, err = c.JobTypes(ts.URL)
+	if err != nil {
+		t.Errorf("err = %s, expected nil", err)
+	}
+	if types != nil {
+		t.Errorf("got job types %v, expected nil", types)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	var status int
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+	c := jr.NewClient(&http.Client{})
+	jc := proto.JobChain{RequestId: "req1"}
+
+	status = http.StatusBadRequest
+	body = `{"message":"invalid job chain: no first job"}`
+	_, err := c.NewJobChain(ts.URL, jc)
+	if expect := (jr.ErrChainRejected{Message: "invalid job chain: no first job"}); err != expect {
+		t.Errorf("got error %#v, expected %#v", err, expect)
+	}
+
+	status = http.StatusConflict
+	body = `{"message":"traverser already exists"}`
+	_, err = c.NewJobChain(ts.URL, jc)
+	if expect := (jr.ErrChainRunning{RequestId: "req1"}); err != expect {
+		t.Errorf("got error %#v, expected %#v", err, expect)
+	}
+
+	status = http.StatusServiceUnavailable
+	body = `{"message":"Job Runner is shutting down"}`
+	_, err = c.ResumeJobChain(ts.URL, proto.SuspendedJobChain{RequestId: "req1", JobChain: &jc})
+	if expect := (jr.ErrBusy{Message: "Job Runner is shutting down"}); err != expect {
+		t.Errorf("got error %#v, expected %#v", err, expect)
+	}
+
+	status = http.StatusNotFound
+	body = `{"message":"traverser not found"}`
+	err = c.StopRequest(ts.URL, "req1")
+	if expect := (jr.ErrNotFound{RequestId: "req1"}); err != expect {
+		t.Errorf("got error %#v, expected %#v", err, expect)
+	}
+
+	status = http.StatusInternalServerError
+	body = "boom"
+	err = c.StopRequest(ts.URL, "req1")
+	if err == nil {
+		t.Error("no error, expected one")
+	}
+	switch err.(type) {
+	case jr.ErrChainRejected, jr.ErrBusy, jr.ErrNotFound, jr.ErrUnavailable:
+		t.Errorf("got %T, expected a generic error", err)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	calls := 0
+	fail := 2
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Add("Location", "location")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	c := jr.NewClientWithConfig(&http.Client{}, jr.ClientConfig{Retry: 2, RetryWait: time.Millisecond})
+	jc := proto.JobChain{RequestId: "req1"}
+
+	// Two transient failures then success
+	if _, err := c.NewJobChain(ts.URL, jc); err != nil {
+		t.Errorf("err = %s, expected nil", err)
+	}
+	if calls != 3 {
+		t.Errorf("%d calls, expected 3", calls)
+	}
+
+	// Three transient failures exceeds retries
+	calls = 0
+	fail = 3
+	err := c.StopRequest(ts.URL, "req1")
+	if _, ok := err.(jr.ErrUnavailable); !ok {
+		t.Errorf("got error %v (%T), expected jr.ErrUnavailable", err, err)
+	}
+	if calls != 3 {
+		t.Errorf("%d calls, expected 3", calls)
+	}
+
+	// JR cannot be reached
+	ts.Close()
+	_, err = c.ResumeJobChain(ts.URL, proto.SuspendedJobChain{RequestId: "req1", JobChain: &jc})
+	if _, ok := err.(jr.ErrUnavailable); !ok {
+		t.Errorf("got error %v (%T), expected jr.ErrUnavailable", err, err)
+	}
+}
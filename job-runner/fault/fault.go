@@ -0,0 +1,206 @@
+// Copyright 2020, Square, Inc.
+
+// Package fault injects failures into the Job Runner for chaos testing: it
+// delays job tries, fails job types, drops calls to the Request Manager, and
+// crashes the Job Runner mid-sequence. It's only used if fault injection is
+// enabled in the Job Runner config (config.JobRunner.FaultInjection), which
+// must never be done in production.
+package fault
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/job"
+	"github.com/square/spincycle/v2/proto"
+	rm "github.com/square/spincycle/v2/request-manager"
+)
+
+// Exit is called to crash the Job Runner. It's a variable for testing.
+var Exit = os.Exit
+
+// ErrInjected is returned for an injected failure.
+type ErrInjected struct {
+	Fault string
+}
+
+func (e ErrInjected) Error() string {
+	return "injected fault: " + e.Fault
+}
+
+// An Injector decides which failures to inject according to the faults set by
+// Job Runner PUT /api/v1/faults. It's safe for concurrent use.
+type Injector struct {
+	faults   proto.Faults
+	delay    time.Duration
+	failType map[string]bool
+	tries    uint // job tries since faults were set
+	*sync.Mutex
+}
+
+// NewInjector makes an Injector that injects no failures until faults are set.
+func NewInjector() *Injector {
+	return &Injector{
+		failType: map[string]bool{},
+		Mutex:    &sync.Mutex{},
+	}
+}
+
+// Set sets the faults to inject, replacing the previous faults. The zero value
+// stops injecting failures.
+func (i *Injector) Set(f proto.Faults) error {
+	if f.DelayProbability < 0 || f.DelayProbability > 1 {
+		return fmt.Errorf("invalid delayProbability %g: must be between 0 and 1", f.DelayProbability)
+	}
+	if f.DropRMCalls < 0 || f.DropRMCalls > 1 {
+		return fmt.Errorf("invalid dropRMCalls %g: must be between 0 and 1", f.DropRMCalls)
+	}
+	var delay time.Duration
+	if f.Delay != "" {
+		var err error
+		delay, err = time.ParseDuration(f.Delay)
+		if err != nil || delay < 0 {
+			return fmt.Errorf("invalid delay %q: must be a duration like 5s", f.Delay)
+		}
+	}
+	failType := map[string]bool{}
+	for _, t := range f.FailJobTypes {
+		failType[t] = true
+	}
+
+	i.Lock()
+	defer i.Unlock()
+	i.faults = f
+	i.delay = delay
+	i.failType = failType
+	i.tries = 0
+	log.Warnf("fault injection: injecting %+v", f)
+	return nil
+}
+
+// Get returns the faults being injected.
+func (i *Injector) Get() proto.Faults {
+	i.Lock()
+	defer i.Unlock()
+	return i.faults
+}
+
+// BeforeTry is called before a job try. If the try is delayed, it returns how
+// long to wait before running the job. If the job type fails, it returns
+// ErrInjected and the job is not run.
+func (i *Injector) BeforeTry(jobId job.Id) (time.Duration, error) {
+	i.Lock()
+	defer i.Unlock()
+	if i.failType[jobId.Type] {
+		return 0, ErrInjected{Fault: "job type " + jobId.Type + " fails"}
+	}
+	if i.delay > 0 && rand.Float64() < i.faults.DelayProbability {
+		return i.delay, nil
+	}
+	return 0, nil
+}
+
+// AfterTry is called after a job try, once its job log entry was sent. After
+// CrashAfterTries tries, it crashes the Job Runner: it exits without suspending
+// running job chains, like a Job Runner that dies mid-sequence.
+func (i *Injector) AfterTry(jobId job.Id) {
+	i.Lock()
+	defer i.Unlock()
+	if i.faults.CrashAfterTries == 0 {
+		return
+	}
+	i.tries++
+	if i.tries >= i.faults.CrashAfterTries {
+		log.Errorf("fault injection: crashing after %d job tries (last: job %s)", i.tries, jobId.Id)
+		Exit(1)
+	}
+}
+
+// DropRMCall returns ErrInjected if a call to the RM should fail without being
+// sent.
+func (i *Injector) DropRMCall(call string) error {
+	i.Lock()
+	defer i.Unlock()
+	if i.faults.DropRMCalls > 0 && rand.Float64() < i.faults.DropRMCalls {
+		return ErrInjected{Fault: "dropped RM call " + call}
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------------
+
+// RMClient is an rm.Client that drops the calls that the Job Runner makes to
+// the Request Manager according to the Injector. Other calls are not dropped.
+type RMClient struct {
+	rm.Client
+	i *Injector
+}
+
+// NewRMClient wraps the rm.Client to drop calls.
+func NewRMClient(rmc rm.Client, i *Injector) RMClient {
+	return RMClient{
+		Client: rmc,
+		i:      i,
+	}
+}
+
+func (c RMClient) CreateJL(requestId string, jl proto.JobLog) error {
+	if err := c.i.DropRMCall("CreateJL"); err != nil {
+		return err
+	}
+	return c.Client.CreateJL(requestId, jl)
+}
+
+func (c RMClient) FinishRequest(fr proto.FinishRequest) error {
+	if err := c.i.DropRMCall("FinishRequest"); err != nil {
+		return err
+	}
+	return c.Client.FinishRequest(fr)
+}
+
+func (c RMClient) SuspendRequest(requestId string, sjc proto.SuspendedJobChain) error {
+	if err := c.i.DropRMCall("SuspendRequest"); err != nil {
+		return err
+	}
+	return c.Client.SuspendRequest(requestId, sjc)
+}
+
+func (c RMClient) UpdateProgress(prg proto.RequestProgress) error {
+	if err := c.i.DropRMCall("UpdateProgress"); err != nil {
+		return err
+	}
+	return c.Client.UpdateProgress(prg)
+}
+
+func (c RMClient) UpdateProgressBatch(batch []proto.RequestProgress) error {
+	if err := c.i.DropRMCall("UpdateProgressBatch"); err != nil {
+		return err
+	}
+	return c.Client.UpdateProgressBatch(batch)
+}
+
+func (c RMClient) Heartbeat(jr proto.JobRunner) error {
+	if err := c.i.DropRMCall("Heartbeat"); err != nil {
+		return err
+	}
+	return c.Client.Heartbeat(jr)
+}
+
+func (c RMClient) AcquireLock(l proto.SingletonLock) (proto.SingletonLock, error) {
+	if err := c.i.DropRMCall("AcquireLock"); err != nil {
+		return proto.SingletonLock{}, err
+	}
+	return c.Client.AcquireLock(l)
+}
+
+func (c RMClient) ReleaseLock(l proto.SingletonLock) error {
+	if err := c.i.DropRMCall("ReleaseLock"); err != nil {
+		return err
+	}
+	return c.Client.ReleaseLock(l)
+}
@@ -0,0 +1,102 @@
+// Copyright 2020, Square, Inc.
+
+package fault_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/spincycle/v2/job"
+	"github.com/square/spincycle/v2/job-runner/fault"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+func TestSetInvalid(t *testing.T) {
+	i := fault.NewInjector()
+	for _, f := range []proto.Faults{
+		{DelayProbability: 1.5, Delay: "1s"},
+		{DropRMCalls: -0.1},
+		{DelayProbability: 1, Delay: "soon"},
+	} {
+		if err := i.Set(f); err == nil {
+			t.Errorf("no error setting %+v, expected an error", f)
+		}
+	}
+	if got := i.Get(); got.DelayProbability != 0 || got.DropRMCalls != 0 || got.Delay != "" {
+		t.Errorf("got faults %+v, expected none set", got)
+	}
+}
+
+func TestBeforeTry(t *testing.T) {
+	i := fault.NewInjector()
+	id := job.NewId("shell-command", "job1", "j1")
+
+	// No faults until set
+	delay, err := i.BeforeTry(id)
+	if delay != 0 || err != nil {
+		t.Errorf("got delay %s, error %v, expected no fault", delay, err)
+	}
+
+	err = i.Set(proto.Faults{DelayProbability: 1, Delay: "2s", FailJobTypes: []string{"shell-command"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = i.BeforeTry(id)
+	if _, ok := err.(fault.ErrInjected); !ok {
+		t.Errorf("got error %v (%T), expected fault.ErrInjected", err, err)
+	}
+	delay, err = i.BeforeTry(job.NewId("noop", "job2", "j2"))
+	if err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+	if delay != 2*time.Second {
+		t.Errorf("got delay %s, expected 2s", delay)
+	}
+}
+
+func TestAfterTry(t *testing.T) {
+	defer func(exit func(int)) { fault.Exit = exit }(fault.Exit)
+	exitCode := -1
+	fault.Exit = func(code int) { exitCode = code }
+
+	i := fault.NewInjector()
+	if err := i.Set(proto.Faults{CrashAfterTries: 2}); err != nil {
+		t.Fatal(err)
+	}
+	id := job.NewId("noop", "job1", "j1")
+	i.AfterTry(id)
+	if exitCode != -1 {
+		t.Errorf("crashed after 1 try, expected crash after 2")
+	}
+	i.AfterTry(id)
+	if exitCode != 1 {
+		t.Errorf("exit code %d, expected crash (exit 1) after 2 tries", exitCode)
+	}
+}
+
+func TestRMClient(t *testing.T) {
+	var sent int
+	rmc := &mock.RMClient{
+		CreateJLFunc: func(string, proto.JobLog) error {
+			sent++
+			return nil
+		},
+	}
+	i := fault.NewInjector()
+	c := fault.NewRMClient(rmc, i)
+
+	if err := c.CreateJL("req1", proto.JobLog{}); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+	if err := i.Set(proto.Faults{DropRMCalls: 1}); err != nil {
+		t.Fatal(err)
+	}
+	err := c.CreateJL("req1", proto.JobLog{})
+	if _, ok := err.(fault.ErrInjected); !ok {
+		t.Errorf("got error %v (%T), expected fault.ErrInjected", err, err)
+	}
+	if sent != 1 {
+		t.Errorf("%d job logs sent, expected 1 (second call dropped)", sent)
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2020, Square, Inc.
+
+// Package outbox queues the reports that the Job Runner sends to the Request
+// Manager when the RM is down, and sends them when it's back: job log entries,
+// finished requests, and suspended job chains. Without the outbox, these reports
+// are lost when the RM is down for longer than the JR retries them, like during
+// RM maintenance. It's only used if the outbox is enabled in the Job Runner
+// config (config.JobRunner.OutboxDir).
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/metrics"
+	"github.com/square/spincycle/v2/proto"
+	rm "github.com/square/spincycle/v2/request-manager"
+)
+
+const (
+	// RETRY_WAIT is the wait before the first retry when the RM is down. It
+	// doubles after every failed retry, up to MAX_RETRY_WAIT.
+	RETRY_WAIT     = 1 * time.Second
+	MAX_RETRY_WAIT = 1 * time.Minute
+
+	ext = ".json"
+)
+
+// Report types
+const (
+	CREATE_JL       = "CreateJL"
+	FINISH_REQUEST  = "FinishRequest"
+	SUSPEND_REQUEST = "SuspendRequest"
+)
+
+// A report is one call to the RM: Type and the args of the call.
+type report struct {
+	Seq       uint64                   `json:"-"` // file name, order of reports
+	Type      string                   `json:"type"`
+	RequestId string                   `json:"requestId"`
+	JL        *proto.JobLog            `json:"jl,omitempty"`
+	FR        *proto.FinishRequest     `json:"fr,omitempty"`
+	SJC       *proto.SuspendedJobChain `json:"sjc,omitempty"`
+}
+
+func (r report) valid() bool {
+	switch r.Type {
+	case CREATE_JL:
+		return r.JL != nil
+	case FINISH_REQUEST:
+		return r.FR != nil
+	case SUSPEND_REQUEST:
+		return r.SJC != nil
+	}
+	return false
+}
+
+// Unreachable returns true if the error from an RM call means the RM is down or
+// failed, so the call can be sent again later: a network error or HTTP status
+// 5xx. Other errors, like 404 request not found, mean the call is invalid.
+func Unreachable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var apiErr rm.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatus >= 500
+	}
+	return false
+}
+
+// RMClient is an rm.Client that queues CreateJL, FinishRequest, and
+// SuspendRequest calls in a local directory when the RM is unreachable, and
+// returns nil, so the caller does not retry, block, or drop the report. Run
+// sends queued reports in order, oldest first, retrying with backoff until the
+// RM is back. While reports are queued, new reports are queued behind them
+// without calling the RM, so the RM receives the job log entries of a request
+// before it's finished or suspended. Other calls are not queued.
+//
+// Reports are saved one file per report, so they survive a JR restart:
+// NewRMClient loads reports left by the last run, and Run sends them first.
+// The number of queued reports is reported as metric rm_reports_queued.
+type RMClient struct {
+	rm.Client
+	dir     string
+	metrics metrics.Metrics
+	sendMux *sync.Mutex // serializes send
+
+	mux     *sync.Mutex // guards the fields below
+	queue   []report    // oldest first
+	seq     uint64      // of the last report
+	running bool        // Run called
+	stopped bool        // Stop called
+
+	retryChan chan struct{}
+	stopChan  chan struct{}
+	doneChan  chan struct{}
+}
+
+// NewRMClient wraps the rm.Client to queue reports in the directory, creating
+// it if it does not exist. Reports left in the directory are queued.
+func NewRMClient(rmc rm.Client, dir string, m metrics.Metrics) (*RMClient, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create outbox dir: %s", err)
+	}
+	c := &RMClient{
+		Client:    rmc,
+		dir:       dir,
+		metrics:   m,
+		sendMux:   &sync.Mutex{},
+		mux:       &sync.Mutex{},
+		retryChan: make(chan struct{}, 1),
+		stopChan:  make(chan struct{}),
+		doneChan:  make(chan struct{}),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	if len(c.queue) > 0 {
+		log.Infof("outbox: %d reports to the RM queued by the last run", len(c.queue))
+	}
+	c.metrics.Gauge(metrics.RM_REPORTS_QUEUED, float64(len(c.queue)), nil)
+	return c, nil
+}
+
+func (c *RMClient) CreateJL(requestId string, jl proto.JobLog) error {
+	return c.send(report{Type: CREATE_JL, RequestId: requestId, JL: &jl})
+}
+
+func (c *RMClient) FinishRequest(fr proto.FinishRequest) error {
+	return c.send(report{Type: FINISH_REQUEST, RequestId: fr.RequestId, FR: &fr})
+}
+
+func (c *RMClient) SuspendRequest(requestId string, sjc proto.SuspendedJobChain) error {
+	return c.send(report{Type: SUSPEND_REQUEST, RequestId: requestId, SJC: &sjc})
+}
+
+// Queued returns the number of queued reports.
+func (c *RMClient) Queued() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.queue)
+}
+
+// Run sends queued reports until Stop is called. It's called once in a
+// goroutine.
+func (c *RMClient) Run() {
+	c.mux.Lock()
+	if c.stopped {
+		c.mux.Unlock()
+		return
+	}
+	c.running = true
+	c.mux.Unlock()
+	defer close(c.doneChan)
+	wait := time.Duration(0) // send reports left by the last run right away
+	for {
+		if c.Queued() > 0 {
+			select {
+			case <-c.stopChan:
+				return
+			case <-time.After(wait):
+			}
+			if c.retry() {
+				wait = 0
+				continue
+			}
+			if wait == 0 {
+				wait = RETRY_WAIT
+			} else if wait *= 2; wait > MAX_RETRY_WAIT {
+				wait = MAX_RETRY_WAIT
+			}
+			log.Warnf("outbox: RM unreachable, retrying %d queued reports in %s", c.Queued(), wait)
+			continue
+		}
+		select {
+		case <-c.stopChan:
+			return
+		case <-c.retryChan:
+			wait = RETRY_WAIT
+		}
+	}
+}
+
+// Stop stops Run, if running. Queued reports are kept in the directory and
+// sent on the next start. Reports are still queued after Stop, but not sent.
+func (c *RMClient) Stop() {
+	c.mux.Lock()
+	if c.stopped {
+		c.mux.Unlock()
+		return
+	}
+	c.stopped = true
+	running := c.running
+	c.mux.Unlock()
+	close(c.stopChan)
+	if running {
+		<-c.doneChan
+	}
+	if n := c.Queued(); n > 0 {
+		log.Warnf("outbox: %d reports to the RM still queued, will send on next start", n)
+	}
+}
+
+// --------------------------------------------------------------------------
+
+// send sends the report to the RM, or queues it if reports are already queued
+// or the RM is unreachable. It returns errors from the RM that are not queued,
+// and errors queueing the report.
+//
+// Sends are serialized, so checking the queue, calling the RM, and queueing the
+// report if the RM is unreachable are atomic. Else, a report could be sent while
+// an earlier one is being queued, like a FinishRequest before the last job log
+// entry of the request. While the RM is unreachable, only the first send waits
+// for the call to fail; the reports behind it are queued without calling the RM.
+func (c *RMClient) send(r report) error {
+	c.sendMux.Lock()
+	defer c.sendMux.Unlock()
+	c.mux.Lock()
+	queued := len(c.queue) > 0
+	c.mux.Unlock()
+	if !queued {
+		err := c.call(r)
+		if !Unreachable(err) {
+			return err
+		}
+		log.Warnf("outbox: RM unreachable, queueing %s for request %s: %s", r.Type, r.RequestId, err)
+	}
+	if err := c.enqueue(r); err != nil {
+		return fmt.Errorf("RM unreachable and cannot queue %s: %s", r.Type, err)
+	}
+	select {
+	case c.retryChan <- struct{}{}:
+	default: // retry already signaled
+	}
+	return nil
+}
+
+// call makes the RM call of the report.
+func (c *RMClient) call(r report) error {
+	switch r.Type {
+	case CREATE_JL:
+		return c.Client.CreateJL(r.RequestId, *r.JL)
+	case FINISH_REQUEST:
+		return c.Client.FinishRequest(*r.FR)
+	case SUSPEND_REQUEST:
+		return c.Client.SuspendRequest(r.RequestId, *r.SJC)
+	}
+	return fmt.Errorf("invalid report type: %s", r.Type)
+}
+
+// retry sends queued reports, oldest first, until the queue is empty or the RM
+// is unreachable. Reports that the RM rejects for other reasons, like request
+// not found, cannot be sent, so they're logged and removed. It returns true if
+// the queue is empty.
+func (c *RMClient) retry() bool {
+	for {
+		c.mux.Lock()
+		if len(c.queue) == 0 {
+			c.mux.Unlock()
+			return true
+		}
+		r := c.queue[0]
+		c.mux.Unlock()
+
+		err := c.call(r)
+		if Unreachable(err) {
+			return false
+		}
+		if err != nil {
+			log.Errorf("outbox: RM rejected queued %s for request %s, discarding it: %s", r.Type, r.RequestId, err)
+		}
+
+		// send only appends, so the report sent is still the first
+		c.mux.Lock()
+		c.queue = c.queue[1:]
+		n := len(c.queue)
+		c.mux.Unlock()
+		if err := os.Remove(c.file(r.Seq)); err != nil && !os.IsNotExist(err) {
+			log.Errorf("outbox: cannot remove sent report: %s", err)
+		}
+		c.metrics.Gauge(metrics.RM_REPORTS_QUEUED, float64(n), nil)
+		if n == 0 {
+			log.Infof("outbox: RM reachable, all queued reports sent")
+		}
+	}
+}
+
+// enqueue saves the report in the directory and appends it to the queue. The
+// file is written atomically, so a crash while saving does not leave a partial
+// report.
+func (c *RMClient) enqueue(r report) error {
+	bytes, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.seq++
+	r.Seq = c.seq
+	file := c.file(r.Seq)
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, bytes, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return err
+	}
+	c.queue = append(c.queue, r)
+	c.metrics.Gauge(metrics.RM_REPORTS_QUEUED, float64(len(c.queue)), nil)
+	return nil
+}
+
+// load queues the reports in the directory, in order. Invalid reports are
+// logged and removed.
+func (c *RMClient) load() error {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("cannot read outbox dir: %s", err)
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ext) {
+			continue
+		}
+		file := filepath.Join(c.dir, f.Name())
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), ext), 10, 64)
+		if err != nil {
+			continue // not a report
+		}
+		var r report
+		bytes, err := ioutil.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(bytes, &r)
+		}
+		if err == nil && !r.valid() {
+			err = fmt.Errorf("no %s args", r.Type)
+		}
+		if err != nil {
+			log.Errorf("outbox: discarding invalid report %s: %s", file, err)
+			os.Remove(file)
+			continue
+		}
+		r.Seq = seq
+		c.queue = append(c.queue, r)
+		if seq > c.seq {
+			c.seq = seq
+		}
+	}
+	sort.Slice(c.queue, func(i, j int) bool { return c.queue[i].Seq < c.queue[j].Seq })
+	return nil
+}
+
+func (c *RMClient) file(seq uint64) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%020d%s", seq, ext))
+}
@@ -0,0 +1,294 @@
+// Copyright 2020, Square, Inc.
+
+package outbox_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/job-runner/outbox"
+	"github.com/square/spincycle/v2/metrics"
+	"github.com/square/spincycle/v2/proto"
+	rm "github.com/square/spincycle/v2/request-manager"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+// fakeRM is an RM that is down (calls return err) or up (calls are recorded).
+type fakeRM struct {
+	*sync.Mutex
+	err   error
+	calls []string
+}
+
+func newFakeRM(err error) *fakeRM {
+	return &fakeRM{Mutex: &sync.Mutex{}, err: err}
+}
+
+func (f *fakeRM) call(call string) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.calls = append(f.calls, call)
+	return nil
+}
+
+func (f *fakeRM) setErr(err error) {
+	f.Lock()
+	f.err = err
+	f.Unlock()
+}
+
+func (f *fakeRM) called() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string{}, f.calls...)
+}
+
+func (f *fakeRM) client() *mock.RMClient {
+	return &mock.RMClient{
+		CreateJLFunc: func(requestId string, jl proto.JobLog) error {
+			return f.call("CreateJL " + requestId + " " + jl.JobId)
+		},
+		FinishRequestFunc: func(fr proto.FinishRequest) error {
+			return f.call("FinishRequest " + fr.RequestId + " " + proto.StateName[fr.State])
+		},
+		SuspendRequestFunc: func(requestId string, sjc proto.SuspendedJobChain) error {
+			return f.call("SuspendRequest " + requestId)
+		},
+	}
+}
+
+var errDown = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+func outboxDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func waitSent(t *testing.T, c *outbox.RMClient) {
+	timeout := time.After(2 * time.Second)
+	for c.Queued() > 0 {
+		select {
+		case <-timeout:
+			t.Fatalf("%d reports queued, expected 0", c.Queued())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	errs := []struct {
+		err    error
+		expect bool
+	}{
+		{errDown, true},
+		{rm.APIError{HTTPStatus: 503, Message: "too many job log entries pending"}, true},
+		{rm.APIError{HTTPStatus: 500}, true},
+		{rm.APIError{HTTPStatus: 400, Message: "invalid job log"}, false},
+		{proto.Error{Message: "request not found", HTTPStatus: 404}, false},
+		{nil, false},
+	}
+	for _, e := range errs {
+		if got := outbox.Unreachable(e.err); got != e.expect {
+			t.Errorf("Unreachable(%v) = %t, expected %t", e.err, got, e.expect)
+		}
+	}
+}
+
+func TestQueueWhileDown(t *testing.T) {
+	dir := outboxDir(t)
+	defer os.RemoveAll(dir)
+
+	f := newFakeRM(errDown)
+	m := metrics.NewPrometheus("")
+	c, err := outbox.NewRMClient(f.client(), dir, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RM down: reports are queued, not returned as errors
+	if err := c.CreateJL("req1", proto.JobLog{JobId: "job1"}); err != nil {
+		t.Errorf("CreateJL returned error %v, expected nil", err)
+	}
+	f.setErr(nil)
+	// RM up, but reports are queued, so these are queued behind them
+	if err := c.CreateJL("req1", proto.JobLog{JobId: "job2"}); err != nil {
+		t.Errorf("CreateJL returned error %v, expected nil", err)
+	}
+	if err := c.FinishRequest(proto.FinishRequest{RequestId: "req1", State: proto.STATE_COMPLETE}); err != nil {
+		t.Errorf("FinishRequest returned error %v, expected nil", err)
+	}
+	if err := c.SuspendRequest("req2", proto.SuspendedJobChain{RequestId: "req2"}); err != nil {
+		t.Errorf("SuspendRequest returned error %v, expected nil", err)
+	}
+	if n := c.Queued(); n != 4 {
+		t.Errorf("%d reports queued, expected 4", n)
+	}
+	if calls := f.called(); len(calls) != 0 {
+		t.Errorf("RM called while reports queued: %v", calls)
+	}
+	var buf bytes.Buffer
+	m.Write(&buf)
+	if !strings.Contains(buf.String(), "rm_reports_queued 4") {
+		t.Errorf("rm_reports_queued not 4, got metrics:\n%s", buf.String())
+	}
+
+	// Queued reports are sent in order
+	go c.Run()
+	waitSent(t, c)
+	c.Stop()
+	expect := []string{
+		"CreateJL req1 job1",
+		"CreateJL req1 job2",
+		"FinishRequest req1 COMPLETE",
+		"SuspendRequest req2",
+	}
+	if diff := deep.Equal(f.called(), expect); diff != nil {
+		t.Error(diff)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("%d files in outbox dir, expected 0", len(files))
+	}
+
+	// Nothing queued: reports are sent right away
+	if err := c.CreateJL("req3", proto.JobLog{JobId: "job1"}); err != nil {
+		t.Errorf("CreateJL returned error %v, expected nil", err)
+	}
+	if calls := f.called(); len(calls) != 5 || calls[4] != "CreateJL req3 job1" {
+		t.Errorf("CreateJL req3 not sent: %v", calls)
+	}
+}
+
+func TestNotQueued(t *testing.T) {
+	dir := outboxDir(t)
+	defer os.RemoveAll(dir)
+
+	notFound := proto.Error{Message: "request not found", HTTPStatus: 404}
+	c, err := outbox.NewRMClient(newFakeRM(notFound).client(), dir, metrics.Nop{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.FinishRequest(proto.FinishRequest{RequestId: "req1", State: proto.STATE_COMPLETE})
+	if err != notFound {
+		t.Errorf("got error %v, expected request not found", err)
+	}
+	if n := c.Queued(); n != 0 {
+		t.Errorf("%d reports queued, expected 0", n)
+	}
+}
+
+func TestSendOnRestart(t *testing.T) {
+	dir := outboxDir(t)
+	defer os.RemoveAll(dir)
+
+	f := newFakeRM(errDown)
+	c, err := outbox.NewRMClient(f.client(), dir, metrics.Nop{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Run()
+	c.CreateJL("req1", proto.JobLog{JobId: "job1"})
+	c.FinishRequest(proto.FinishRequest{RequestId: "req1", State: proto.STATE_FAIL})
+	c.Stop()
+
+	// Invalid report is discarded
+	if err := ioutil.WriteFile(dir+"/00000000000000000009.json", []byte(`{"type":"CreateJL"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart with RM up: reports from the last run are sent first
+	f.setErr(nil)
+	c, err = outbox.NewRMClient(f.client(), dir, metrics.Nop{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Queued(); n != 2 {
+		t.Errorf("%d reports queued, expected 2", n)
+	}
+	go c.Run()
+	waitSent(t, c)
+	c.Stop()
+	expect := []string{
+		"CreateJL req1 job1",
+		"FinishRequest req1 FAIL",
+	}
+	if diff := deep.Equal(f.called(), expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSendOrderWhileQueueing(t *testing.T) {
+	dir := outboxDir(t)
+	defer os.RemoveAll(dir)
+
+	// The first CreateJL call blocks until released, then fails because the RM
+	// was down. The RM is back for all other calls.
+	f := newFakeRM(nil)
+	jlCalled := make(chan struct{})
+	releaseJL := make(chan struct{})
+	first := true
+	rmc := f.client()
+	rmc.CreateJLFunc = func(requestId string, jl proto.JobLog) error {
+		if first {
+			first = false
+			close(jlCalled)
+			<-releaseJL
+			return errDown
+		}
+		return f.call("CreateJL " + requestId + " " + jl.JobId)
+	}
+	c, err := outbox.NewRMClient(rmc, dir, metrics.Nop{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jlSent := make(chan error, 1)
+	go func() { jlSent <- c.CreateJL("req1", proto.JobLog{JobId: "job1"}) }()
+	<-jlCalled
+
+	// FinishRequest while the job log entry is being sent cannot overtake it:
+	// it's queued behind the job log entry, which is queued when its call fails
+	frSent := make(chan error, 1)
+	go func() {
+		frSent <- c.FinishRequest(proto.FinishRequest{RequestId: "req1", State: proto.STATE_COMPLETE})
+	}()
+	select {
+	case err := <-frSent:
+		frSent <- err
+		t.Error("FinishRequest returned while CreateJL was being sent, expected it to wait")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(releaseJL)
+	if err := <-jlSent; err != nil {
+		t.Errorf("CreateJL returned error %v, expected nil", err)
+	}
+	if err := <-frSent; err != nil {
+		t.Errorf("FinishRequest returned error %v, expected nil", err)
+	}
+
+	go c.Run()
+	waitSent(t, c)
+	c.Stop()
+	expect := []string{
+		"CreateJL req1 job1",
+		"FinishRequest req1 COMPLETE",
+	}
+	if diff := deep.Equal(f.called(), expect); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020, Square, Inc.
+
+// Package profile captures pprof profiles of the Job Runner while a request's
+// job chain runs and sends them to the Request Manager, which attaches them to
+// the request. This helps find which job types slow down a Job Runner. It's
+// only used if profiling is enabled in the Job Runner config
+// (config.JobRunner.Profiling).
+package profile
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/proto"
+	rm "github.com/square/spincycle/v2/request-manager"
+)
+
+// ErrBusy is returned by Capture when a capture is already running. Only one
+// CPU profile can be captured at a time.
+var ErrBusy = errors.New("already capturing profiles")
+
+// How often Capture checks if the job chain is still running.
+var CheckInterval = time.Second
+
+// A Capturer captures profiles and sends them to the RM. It's safe for
+// concurrent use.
+type Capturer struct {
+	rmc  rm.Client
+	host string
+	busy bool
+	*sync.Mutex
+}
+
+// NewCapturer makes a Capturer that sends profiles to the RM.
+func NewCapturer(rmc rm.Client) *Capturer {
+	host, _ := os.Hostname()
+	return &Capturer{
+		rmc:   rmc,
+		host:  host,
+		Mutex: &sync.Mutex{},
+	}
+}
+
+// Capture captures a CPU profile for the duration, or until running returns
+// false (the job chain is done), then a heap profile, and sends both to the RM
+// for the request. It returns immediately and captures in a goroutine. It
+// returns ErrBusy if a capture is already running.
+func (c *Capturer) Capture(requestId string, d time.Duration, running func() bool) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.busy {
+		return ErrBusy
+	}
+	c.busy = true
+	go func() {
+		defer func() {
+			c.Lock()
+			c.busy = false
+			c.Unlock()
+		}()
+		c.capture(requestId, d, running)
+	}()
+	return nil
+}
+
+func (c *Capturer) capture(requestId string, d time.Duration, running func() bool) {
+	logger := log.WithFields(log.Fields{"request_id": requestId})
+
+	// CPU profile. This fails if a CPU profile is being captured another way,
+	// like GET /debug/pprof/profile, but the heap profile is still captured.
+	var buf bytes.Buffer
+	startedAt := time.Now().UTC()
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		logger.Errorf("cannot capture CPU profile: %s", err)
+	} else {
+		logger.Infof("capturing CPU profile for %s", d)
+		timeout := time.After(d)
+		ticker := time.NewTicker(CheckInterval)
+	WAIT:
+		for {
+			select {
+			case <-timeout:
+				break WAIT
+			case <-ticker.C:
+				if !running() {
+					break WAIT
+				}
+			}
+		}
+		ticker.Stop()
+		pprof.StopCPUProfile()
+		c.send(requestId, proto.PROFILE_CPU, startedAt, buf.Bytes())
+	}
+
+	// Heap profile: a snapshot, so started and finished at the same time
+	buf.Reset()
+	startedAt = time.Now().UTC()
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		logger.Errorf("cannot capture heap profile: %s", err)
+		return
+	}
+	c.send(requestId, proto.PROFILE_HEAP, startedAt, buf.Bytes())
+}
+
+func (c *Capturer) send(requestId, profileType string, startedAt time.Time, data []byte) {
+	p := proto.Profile{
+		RequestId:  requestId,
+		Type:       profileType,
+		JobRunner:  c.host,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now().UTC(),
+		Size:       len(data),
+		Data:       data,
+	}
+	if err := c.rmc.AttachProfile(requestId, p); err != nil {
+		log.Errorf("request %s: error sending %s profile (%d bytes) to the Request Manager: %s", requestId, profileType, len(data), err)
+		return
+	}
+	log.Infof("request %s: sent %s profile (%d bytes) to the Request Manager", requestId, profileType, len(data))
+}
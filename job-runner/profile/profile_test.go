@@ -0,0 +1,143 @@
+// Copyright 2020, Square, Inc.
+
+package profile_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/square/spincycle/v2/job-runner/profile"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+func TestCapture(t *testing.T) {
+	sent := make(chan proto.Profile, 2)
+	rmc := &mock.RMClient{
+		AttachProfileFunc: func(reqId string, p proto.Profile) error {
+			if reqId != p.RequestId {
+				t.Errorf("attached to request %s, profile request %s", reqId, p.RequestId)
+			}
+			sent <- p
+			return nil
+		},
+	}
+	c := profile.NewCapturer(rmc)
+
+	err := c.Capture("abcd1234", 100*time.Millisecond, func() bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only one capture at a time
+	err = c.Capture("efgh5678", time.Second, func() bool { return true })
+	if err != profile.ErrBusy {
+		t.Errorf("got error %v, expected profile.ErrBusy", err)
+	}
+
+	// CPU profile, then heap profile
+	for _, expectType := range []string{proto.PROFILE_CPU, proto.PROFILE_HEAP} {
+		select {
+		case p := <-sent:
+			if p.Type != expectType || p.RequestId != "abcd1234" {
+				t.Errorf("got %s profile of request %s, expected %s profile of request abcd1234", p.Type, p.RequestId, expectType)
+			}
+			if p.Size == 0 || p.Size != len(p.Data) {
+				t.Errorf("%s profile: size %d, %d bytes of data", p.Type, p.Size, len(p.Data))
+			}
+			if p.FinishedAt.Before(p.StartedAt) {
+				t.Errorf("%s profile: finished at %s before started at %s", p.Type, p.FinishedAt, p.StartedAt)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %s profile", expectType)
+		}
+	}
+
+	// Not busy after sending both profiles
+	err = waitNotBusy(c, "efgh5678", time.Millisecond, func() bool { return true })
+	if err != nil {
+		t.Error(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sent:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for profiles of second capture")
+		}
+	}
+}
+
+func TestCaptureChainDone(t *testing.T) {
+	defer func(d time.Duration) { profile.CheckInterval = d }(profile.CheckInterval)
+	profile.CheckInterval = 10 * time.Millisecond
+
+	sent := make(chan proto.Profile, 2)
+	rmc := &mock.RMClient{
+		AttachProfileFunc: func(reqId string, p proto.Profile) error {
+			sent <- p
+			return nil
+		},
+	}
+	c := profile.NewCapturer(rmc)
+
+	// Job chain done: CPU profile stops before the duration
+	start := time.Now()
+	if err := c.Capture("abcd1234", time.Hour, func() bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-sent:
+		if p.Type != proto.PROFILE_CPU {
+			t.Errorf("got %s profile, expected %s", p.Type, proto.PROFILE_CPU)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for CPU profile, expected capture to stop when chain done")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("CPU profile took %s, expected it to stop when chain done", d)
+	}
+}
+
+func TestCaptureSendError(t *testing.T) {
+	sent := make(chan proto.Profile, 2)
+	rmc := &mock.RMClient{
+		AttachProfileFunc: func(reqId string, p proto.Profile) error {
+			sent <- p
+			return fmt.Errorf("forced error")
+		},
+	}
+	c := profile.NewCapturer(rmc)
+
+	// Heap profile is still sent when sending the CPU profile fails
+	if err := c.Capture("abcd1234", time.Millisecond, func() bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	for _, expectType := range []string{proto.PROFILE_CPU, proto.PROFILE_HEAP} {
+		select {
+		case p := <-sent:
+			if p.Type != expectType {
+				t.Errorf("got %s profile, expected %s", p.Type, expectType)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %s profile", expectType)
+		}
+	}
+}
+
+// waitNotBusy calls Capture until it does not return ErrBusy: the previous
+// capture sends its last profile before it's done.
+func waitNotBusy(c *profile.Capturer, requestId string, d time.Duration, running func() bool) error {
+	timeout := time.After(5 * time.Second)
+	for {
+		err := c.Capture(requestId, d, running)
+		if err != profile.ErrBusy {
+			return err
+		}
+		select {
+		case <-timeout:
+			return fmt.Errorf("capturer still busy, expected previous capture to be done")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
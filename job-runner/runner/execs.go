@@ -0,0 +1,88 @@
+// Copyright 2020, Square, Inc.
+
+package runner
+
+import (
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/square/spincycle/v2/job"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// MaxExecs is the maximum number of commands recorded per job try. More are
+// run but not recorded; the number dropped is logged by the Job Runner.
+var MaxExecs = 1000
+
+// tryExecs runs and records the external commands of a job during one try. It
+// implements job.Execs and is safe for concurrent use because jobs can run
+// commands from several goroutines.
+type tryExecs struct {
+	records []proto.ExecRecord
+	dropped int
+	*sync.Mutex
+}
+
+var _ job.Execs = &tryExecs{}
+
+func newTryExecs() *tryExecs {
+	return &tryExecs{
+		records: []proto.ExecRecord{},
+		Mutex:   &sync.Mutex{},
+	}
+}
+
+func (e *tryExecs) Run(cmd *exec.Cmd) error {
+	rec := proto.ExecRecord{
+		Args: append([]string{}, cmd.Args...),
+		Dir:  cmd.Dir,
+	}
+	if len(rec.Args) == 0 {
+		rec.Args = []string{cmd.Path}
+	}
+	t0 := time.Now()
+	rec.StartedAt = t0.UnixNano()
+	err := cmd.Run()
+	rec.Duration = int64(time.Since(t0))
+	rec.Exit = exitCode(cmd)
+	if rec.Exit == -1 && err != nil {
+		rec.Error = err.Error()
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	if len(e.records) >= MaxExecs {
+		e.dropped++
+	} else {
+		e.records = append(e.records, rec)
+	}
+	return err
+}
+
+// List returns the commands run, in order started, or nil if none. It also
+// returns the number of commands run but not recorded because of MaxExecs.
+func (e *tryExecs) List() ([]proto.ExecRecord, int) {
+	if e == nil {
+		return nil, 0
+	}
+	e.Lock()
+	defer e.Unlock()
+	if len(e.records) == 0 {
+		return nil, e.dropped
+	}
+	list := make([]proto.ExecRecord, len(e.records))
+	copy(list, e.records)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].StartedAt < list[j].StartedAt })
+	return list, e.dropped
+}
+
+// exitCode returns the exit code of the command run by cmd.Run, or -1 if it did
+// not start or was killed by a signal.
+func exitCode(cmd *exec.Cmd) int {
+	if cmd.ProcessState == nil {
+		return -1
+	}
+	return cmd.ProcessState.ExitCode()
+}
@@ -3,6 +3,8 @@
 package runner
 
 import (
+	"time"
+
 	"github.com/square/spincycle/v2/job"
 	"github.com/square/spincycle/v2/proto"
 	rm "github.com/square/spincycle/v2/request-manager"
@@ -17,27 +19,70 @@ import (
 // a monotonically increasing global counter of how many times the job was run.
 // This count is used for the proto.JobLog.Try field which cannot repeat a number
 // because the job_log table primary key is <request_id, job_id, try>.
+//
+// What every job of the request is given, like its globals, is passed in a Request.
+// Jobs of sandboxed types are given a sandbox, see job.Sandboxed, and jobs that
+// implement job.UsesWorkspace are given a workspace. Job log entries are limited
+// by JobLogLimits.
 type Factory interface {
-	Make(job proto.Job, requestId string, prevTries, totalTries uint) (Runner, error)
+	Make(job proto.Job, req Request, prevTries, totalTries uint) (Runner, error)
+}
+
+// Request is the request of the job passed to Factory.Make.
+type Request struct {
+	Id        string
+	User      string                 // optional: given to jobs that implement job.Authenticated
+	Globals   map[string]interface{} // optional: copy given to jobs that implement job.UsesGlobals
+	Overrides map[string]string      // optional: copy given to jobs that implement job.UsesOverrides
+}
+
+// A TokenProvider provides delegated tokens for jobs to make downstream calls
+// as the user who made the request rather than as the Job Runner. It's a Job
+// Runner plugin: app.Plugins.TokenProvider.
+type TokenProvider interface {
+	// Token returns a token for the user to run the job. It's called before
+	// every try of a job that implements job.Authenticated. If an error is
+	// returned, the try fails without running the job.
+	Token(user string, jobId job.Id) (string, error)
+}
+
+// Faults injects failures into job tries for chaos testing. It's implemented
+// by job-runner/fault.Injector and only used if fault injection is enabled.
+type Faults interface {
+	// BeforeTry returns how long to delay the try, or an error to fail the
+	// try without running the job.
+	BeforeTry(jobId job.Id) (time.Duration, error)
+
+	// AfterTry is called after the try and its job log entry was sent.
+	AfterTry(jobId job.Id)
+}
+
+// RunnerFactoryConfig configures the runners made by a Factory.
+type RunnerFactoryConfig struct {
+	JobFactory    job.Factory
+	RMClient      rm.Client
+	TokenProvider TokenProvider          // optional: tokens for jobs that implement job.Authenticated
+	Sandboxes     map[string]job.Sandbox // optional: sandboxes keyed on job type (NewSandboxes)
+	Workspaces    *Workspaces            // optional: workspaces for jobs that implement job.UsesWorkspace (NewWorkspaces)
+	Faults        Faults                 // optional: set only if fault injection is enabled
+	JobLogLimits  *JobLogLimits          // optional: limit job log entries (NewJobLogLimits)
 }
 
 type factory struct {
-	jf  job.Factory
-	rmc rm.Client
+	cfg RunnerFactoryConfig
 }
 
-// NewRunnerFactory makes a RunnerFactory.
-func NewFactory(jf job.Factory, rmc rm.Client) Factory {
+// NewFactory makes a Factory.
+func NewFactory(cfg RunnerFactoryConfig) Factory {
 	return &factory{
-		jf:  jf,
-		rmc: rmc,
+		cfg: cfg,
 	}
 }
 
 // Make a runner for a new job.
-func (f *factory) Make(pJob proto.Job, requestId string, prevTries, totalTries uint) (Runner, error) {
+func (f *factory) Make(pJob proto.Job, req Request, prevTries, totalTries uint) (Runner, error) {
 	// Instantiate a "blank" job of the given type.
-	realJob, err := f.jf.Make(job.NewIdWithRequestId(pJob.Type, pJob.Name, pJob.Id, requestId))
+	realJob, err := f.cfg.JobFactory.Make(job.NewIdWithRequestId(pJob.Type, pJob.Name, pJob.Id, req.Id))
 	if err != nil {
 		return nil, err
 	}
@@ -48,6 +93,34 @@ func (f *factory) Make(pJob proto.Job, requestId string, prevTries, totalTries u
 		return nil, err
 	}
 
+	// Give the job a copy of the request globals so it cannot change them
+	// for other jobs
+	if gj, ok := realJob.(job.UsesGlobals); ok {
+		cp := make(map[string]interface{}, len(req.Globals))
+		for k, v := range req.Globals {
+			cp[k] = v
+		}
+		gj.SetGlobals(cp)
+	}
+
+	// Same for request overrides
+	if oj, ok := realJob.(job.UsesOverrides); ok {
+		cp := make(map[string]string, len(req.Overrides))
+		for k, v := range req.Overrides {
+			cp[k] = v
+		}
+		oj.SetOverrides(cp)
+	}
+
 	// Job should be ready to run. Create and return a runner for it.
-	return NewRunner(pJob, realJob, requestId, prevTries, totalTries, f.rmc), nil
+	r := NewRunner(pJob, realJob, req.Id, prevTries, totalTries, f.cfg.RMClient).(*runner)
+	r.user = req.User
+	r.tp = f.cfg.TokenProvider
+	r.ws = f.cfg.Workspaces
+	r.faults = f.cfg.Faults
+	r.jll = f.cfg.JobLogLimits
+	if sb, ok := f.cfg.Sandboxes[pJob.Type]; ok {
+		r.sandbox = &sb
+	}
+	return r, nil
 }
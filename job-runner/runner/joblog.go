@@ -0,0 +1,111 @@
+// Copyright 2020, Square, Inc.
+
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/config"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// JobLogLimits limits the size of the stdout, stderr, and error of job log
+// entries sent to the RM. A field larger than its limit is truncated: its head
+// and tail are kept, and a marker line between them says how many bytes were
+// omitted. If spilling is enabled, the full value of a truncated field is saved
+// in the artifacts dir first: <artifacts_dir>/<request ID>/<job ID>/job-log/try-<N>.<field>,
+// and the marker has its path. A nil JobLogLimits does not limit job log entries.
+type JobLogLimits struct {
+	maxStdout int    // bytes, 0 = no limit
+	maxStderr int    // bytes, 0 = no limit
+	maxError  int    // bytes, 0 = no limit
+	spillDir  string // artifacts dir, empty = spilling disabled
+}
+
+// NewJobLogLimits makes JobLogLimits from the job_log config. Spilling requires
+// the artifacts dir of the workspaces config.
+func NewJobLogLimits(cfg config.JobLogLimits, artifactsDir string) (*JobLogLimits, error) {
+	if cfg.Spill && artifactsDir == "" {
+		return nil, fmt.Errorf("spill requires workspaces.artifacts_dir")
+	}
+	l := &JobLogLimits{
+		maxStdout: int(cfg.MaxStdoutKB * 1024),
+		maxStderr: int(cfg.MaxStderrKB * 1024),
+		maxError:  int(cfg.MaxErrorKB * 1024),
+	}
+	if cfg.Spill {
+		l.spillDir = artifactsDir
+	}
+	return l, nil
+}
+
+// apply truncates the fields of the job log entry that are larger than their limit.
+func (l *JobLogLimits) apply(jl *proto.JobLog, logger *log.Entry) {
+	if l == nil {
+		return
+	}
+	jl.Stdout = l.limit(jl, "stdout", jl.Stdout, l.maxStdout, logger)
+	jl.Stderr = l.limit(jl, "stderr", jl.Stderr, l.maxStderr, logger)
+	jl.Error = l.limit(jl, "error", jl.Error, l.maxError, logger)
+}
+
+func (l *JobLogLimits) limit(jl *proto.JobLog, field, val string, max int, logger *log.Entry) string {
+	if max == 0 || len(val) <= max {
+		return val
+	}
+	var path string
+	if l.spillDir != "" {
+		var err error
+		path, err = l.spill(jl, field, val)
+		if err != nil {
+			logger.Warnf("cannot save full job log %s: %s", field, err)
+		}
+	}
+	logger.Warnf("job log %s truncated: %d bytes, max %d", field, len(val), max)
+	return Truncate(val, max, path)
+}
+
+// spill saves the full value of the field and returns its path.
+func (l *JobLogLimits) spill(jl *proto.JobLog, field, val string) (string, error) {
+	if !validName(jl.RequestId) || !validName(jl.JobId) {
+		return "", fmt.Errorf("invalid request ID %q or job ID %q", jl.RequestId, jl.JobId)
+	}
+	dir := filepath.Join(l.spillDir, jl.RequestId, jl.JobId, "job-log")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("try-%d.%s", jl.Try, field))
+	if err := ioutil.WriteFile(path, []byte(val), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Truncate returns the head and tail of s, max bytes in total, with a marker line
+// between them that says how many bytes were omitted and, if path is set, where
+// the full value was saved. The head and tail are cut on UTF-8 character boundaries.
+// If s is not longer than max, it's returned as is.
+func Truncate(s string, max int, path string) string {
+	if len(s) <= max {
+		return s
+	}
+	head := max / 2
+	for head > 0 && !utf8.RuneStart(s[head]) {
+		head--
+	}
+	tail := len(s) - (max - max/2)
+	for tail < len(s) && !utf8.RuneStart(s[tail]) {
+		tail++
+	}
+	marker := fmt.Sprintf("\n[... %d bytes omitted ...]\n", tail-head)
+	if path != "" {
+		marker = fmt.Sprintf("\n[... %d bytes omitted, full output: %s ...]\n", tail-head, path)
+	}
+	return s[:head] + marker + s[tail:]
+}
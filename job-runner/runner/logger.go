@@ -0,0 +1,126 @@
+// Copyright 2020, Square, Inc.
+
+package runner
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/job"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// MaxLogEntries is the maximum number of entries saved per job try. More entries
+// are written to the Job Runner log but not saved in the job log entry, which
+// notes how many were dropped.
+var MaxLogEntries = 1000
+
+// tryLog collects the entries logged by a job during one try. It's safe for
+// concurrent use because jobs can log from several goroutines.
+type tryLog struct {
+	level   int
+	logger  *log.Entry
+	entries []proto.LogEntry
+	dropped int
+	*sync.Mutex
+}
+
+func newTryLog(level string, logger *log.Entry) *tryLog {
+	n, ok := proto.LogLevels[level]
+	if !ok {
+		n = proto.LogLevels[proto.LOG_LEVEL_INFO]
+	}
+	return &tryLog{
+		level:  n,
+		logger: logger,
+		Mutex:  &sync.Mutex{},
+	}
+}
+
+// Entries returns the entries logged, or nil if none.
+func (l *tryLog) Entries() []proto.LogEntry {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.dropped == 0 {
+		return l.entries
+	}
+	return append(l.entries, proto.LogEntry{
+		Ts:    time.Now().UnixNano(),
+		Level: proto.LOG_LEVEL_WARN,
+		Msg:   fmt.Sprintf("%d log entries dropped (max %d per try)", l.dropped, MaxLogEntries),
+	})
+}
+
+func (l *tryLog) add(level string, fields map[string]interface{}, format string, args []interface{}) {
+	if proto.LogLevels[level] < l.level {
+		return
+	}
+	e := proto.LogEntry{
+		Ts:     time.Now().UnixNano(),
+		Level:  level,
+		Msg:    fmt.Sprintf(format, args...),
+		Fields: fields,
+	}
+
+	// Write to the JR log, too, so entries are seen while the job runs
+	jrLogger := l.logger.WithFields(log.Fields(fields))
+	switch level {
+	case proto.LOG_LEVEL_DEBUG:
+		jrLogger.Debug(e.Msg)
+	case proto.LOG_LEVEL_INFO:
+		jrLogger.Info(e.Msg)
+	case proto.LOG_LEVEL_WARN:
+		jrLogger.Warn(e.Msg)
+	default:
+		jrLogger.Error(e.Msg)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if len(l.entries) >= MaxLogEntries {
+		l.dropped++
+		return
+	}
+	l.entries = append(l.entries, e)
+}
+
+// jobLogger implements job.Logger for a tryLog.
+type jobLogger struct {
+	l      *tryLog
+	fields map[string]interface{}
+}
+
+var _ job.Logger = jobLogger{}
+
+func (j jobLogger) Debugf(format string, args ...interface{}) {
+	j.l.add(proto.LOG_LEVEL_DEBUG, j.fields, format, args)
+}
+
+func (j jobLogger) Infof(format string, args ...interface{}) {
+	j.l.add(proto.LOG_LEVEL_INFO, j.fields, format, args)
+}
+
+func (j jobLogger) Warnf(format string, args ...interface{}) {
+	j.l.add(proto.LOG_LEVEL_WARN, j.fields, format, args)
+}
+
+func (j jobLogger) Errorf(format string, args ...interface{}) {
+	j.l.add(proto.LOG_LEVEL_ERROR, j.fields, format, args)
+}
+
+func (j jobLogger) WithFields(fields map[string]interface{}) job.Logger {
+	all := make(map[string]interface{}, len(j.fields)+len(fields))
+	for k, v := range j.fields {
+		all[k] = v
+	}
+	for k, v := range fields {
+		all[k] = v
+	}
+	return jobLogger{l: j.l, fields: all}
+}
@@ -0,0 +1,60 @@
+// Copyright 2020, Square, Inc.
+
+package runner
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/square/spincycle/v2/job"
+)
+
+// MaxResources is the maximum number of resources saved per job try. More are
+// ignored because the Request Manager indexes every resource of every try.
+var MaxResources = 1000
+
+// tryResources collects the resources affected by a job during one try. It
+// implements job.Resources and is safe for concurrent use because jobs can
+// report from several goroutines.
+type tryResources struct {
+	affected map[string]bool
+	*sync.Mutex
+}
+
+var _ job.Resources = &tryResources{}
+
+func newTryResources() *tryResources {
+	return &tryResources{
+		affected: map[string]bool{},
+		Mutex:    &sync.Mutex{},
+	}
+}
+
+func (r *tryResources) Affect(resources ...string) {
+	r.Lock()
+	defer r.Unlock()
+	for _, res := range resources {
+		if res == "" || len(r.affected) >= MaxResources {
+			continue
+		}
+		r.affected[res] = true
+	}
+}
+
+// List returns the resources affected, sorted, or nil if none.
+func (r *tryResources) List() []string {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	if len(r.affected) == 0 {
+		return nil
+	}
+	list := make([]string, 0, len(r.affected))
+	for res := range r.affected {
+		list = append(list, res)
+	}
+	sort.Strings(list)
+	return list
+}
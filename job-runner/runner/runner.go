@@ -5,9 +5,13 @@ package runner
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
 	"sync"
 	"time"
 
+	"github.com/square/spincycle/v2/expr"
 	"github.com/square/spincycle/v2/job"
 	"github.com/square/spincycle/v2/proto"
 	rm "github.com/square/spincycle/v2/request-manager"
@@ -23,9 +27,24 @@ const (
 	JOB_LOG_RETRY_WAIT = 500 * time.Millisecond
 )
 
+// SingletonWait is the time to wait between attempts to acquire a singleton
+// lock held by another job (policy queue).
+var SingletonWait = 5 * time.Second
+
+// MinThrottledWait and MaxThrottledWait bound the wait before retrying a job
+// that failed with a throttled error (proto.ERROR_CLASS_THROTTLED). The wait is
+// twice the job retry wait, at least MinThrottledWait, and doubles every
+// consecutive throttled try up to MaxThrottledWait. It's never shorter than the
+// job retry wait.
+var (
+	MinThrottledWait = 1 * time.Second
+	MaxThrottledWait = 5 * time.Minute
+)
+
 type Return struct {
-	FinalState byte // Final proto.STATE_*. Determines if/how chain continues running.
-	Tries      uint // Number of tries this run, not including any previous tries
+	FinalState byte   // Final proto.STATE_*. Determines if/how chain continues running.
+	Tries      uint   // Number of tries this run, not including any previous tries
+	Token      string // Last job.Reentrant token, saved if the job was stopped
 }
 
 type Status struct {
@@ -34,6 +53,13 @@ type Status struct {
 	Try       uint      // total tries, not current sequence try (proto.JobLog.Try)
 	Status    string    // real-time job status (job.Job.Status())
 	Sleeping  bool      // if sleeping between tries
+	Waiting   bool      // if a gate job waiting for approval (package gate)
+	LockWait  string    // if waiting for the singleton lock, its holder: "request <id> job <id>"
+}
+
+// approvalWaiter is implemented by gate jobs (package gate).
+type approvalWaiter interface {
+	WaitingApproval() bool
 }
 
 // A Runner runs and manages one job in a job chain. The job must implement the
@@ -46,9 +72,10 @@ type Runner interface {
 	// of retry attempts, Run returns the final state of the job.
 	Run(jobData map[string]interface{}) Return
 
-	// Stop stops the job if it's running. The job is responsible for stopping
-	// quickly because Stop blocks while waiting for the job to stop.
-	Stop() error
+	// Stop stops the job if it's running. The reason (proto.STOP_REASON_* const)
+	// is given to jobs that implement job.ReasonStopper. The job is responsible
+	// for stopping quickly because Stop blocks while waiting for the job to stop.
+	Stop(reason string) error
 
 	// Status returns the job try count and real-time status. The runner handles
 	// the try count. The underlying job.Job must handle async, real-time status
@@ -56,12 +83,25 @@ type Runner interface {
 	Status() Status
 }
 
+// A PacedRunner gives a job.Paced job the Feedback of the fan-out it's in.
+// Runners made by the Factory implement it. SetFeedback must be called before Run.
+type PacedRunner interface {
+	SetFeedback(job.Feedback)
+}
+
 // A runner represents all information needed to run a job.
 type runner struct {
 	pJob    proto.Job
-	realJob job.Job   // the actual job interface to run
-	reqId   string    // the request id the job belongs to
-	rmc     rm.Client // client used to send JLs to the RM
+	realJob job.Job       // the actual job interface to run
+	reqId   string        // the request id the job belongs to
+	rmc     rm.Client     // client used to send JLs to the RM
+	user    string        // user who made the request (job.Auth.User)
+	tp      TokenProvider // optional: delegated tokens for job.Authenticated
+	sandbox *job.Sandbox  // optional: sandbox if job type is sandboxed
+	ws      *Workspaces   // optional: workspaces for job.UsesWorkspace
+	faults  Faults        // optional: inject failures (chaos testing)
+	jll     *JobLogLimits // optional: size limits of job log entries
+	fb      job.Feedback  // optional: pace of the job's fan-out for job.Paced
 	// --
 	jobId      string
 	jobName    string
@@ -75,6 +115,9 @@ type runner struct {
 	logger    *log.Entry
 	startTime time.Time
 	sleeping  bool
+	lockWait  string         // singleton lock holder if waiting for it
+	token     string         // last job.Reentrant token
+	workspace *job.Workspace // made on first try if job.UsesWorkspace
 }
 
 // NewRunner takes a proto.Job struct and its corresponding job.Job interface, and
@@ -100,6 +143,7 @@ func NewRunner(pJob proto.Job, realJob job.Job, reqId string, prevTries, totalTr
 		Mutex:     &sync.Mutex{},
 		logger:    log.WithFields(log.Fields{"request_id": reqId, "job_id": pJob.Id}),
 		startTime: time.Now().UTC(),
+		token:     pJob.ReentryToken,
 	}
 }
 
@@ -107,9 +151,24 @@ func (r *runner) Run(jobData map[string]interface{}) Return {
 	// The chain.traverser that's calling us only cares about the final state
 	// of the job. If maxTries > 1, the intermediate states are only logged if
 	// the run fails.
+	// Singleton job: hold the lock for all tries so other jobs don't run
+	// between them
+	if r.pJob.Singleton != "" {
+		ret, ok := r.lockSingleton()
+		if !ok {
+			return ret
+		}
+		defer r.unlockSingleton()
+	}
+
+	// Workspace is kept between tries, so remove it when done running
+	defer r.removeWorkspace()
+
 	finalState := proto.STATE_PENDING
 	tries := uint(1)         // number of tries this run
 	tryNo := 1 + r.prevTries // this run + past tries (on resume/retry)
+	throttled := uint(0)     // consecutive throttled tries
+	stopReason := r.pJob.StopReason
 TRY_LOOP:
 	for tryNo <= r.maxTries {
 		tryLogger := r.logger.WithFields(log.Fields{
@@ -126,10 +185,66 @@ TRY_LOOP:
 			break TRY_LOOP
 		}
 
+		// On retries, give the job its retry arg overrides (if any) in jobData
+		// for the duration of this try only.
+		var restoreData func()
+		if tryNo > 1 && len(r.pJob.RetryArgs) > 0 {
+			tryLogger.Infof("job retry args: %v", r.pJob.RetryArgs)
+			restoreData = applyRetryArgs(jobData, r.pJob.RetryArgs)
+		}
+
+		// Give the job a new logger for this try if it implements job.Logging.
+		// Its entries are saved in the JL.
+		tryLog := r.setLogger(tryLogger)
+
+		// Give the job its fan-out feedback if it implements job.Paced
+		if pj, ok := r.realJob.(job.Paced); ok {
+			fb := r.fb
+			if fb == nil {
+				fb = noFeedback{}
+			}
+			pj.SetFeedback(fb)
+		}
+
+		// Collect the resources affected by this try if the job implements
+		// job.AffectsResources. They're saved in the JL.
+		var tryRes *tryResources
+		if aj, ok := r.realJob.(job.AffectsResources); ok {
+			tryRes = newTryResources()
+			aj.SetResources(tryRes)
+		}
+
+		// Run and record the external commands of this try if the job
+		// implements job.AuditsExecs. They're saved in the JL.
+		var tryEx *tryExecs
+		if ej, ok := r.realJob.(job.AuditsExecs); ok {
+			tryEx = newTryExecs()
+			ej.SetExecs(tryEx)
+		}
+
+		// Tell the job if it ran before, if it implements job.Reentrant. Only
+		// the first try after resuming is resumed.
+		rj, reentrant := r.realJob.(job.Reentrant)
+		if reentrant {
+			rj.SetReentry(job.Reentry{
+				Try:        r.totalTries,
+				Resumed:    stopReason != "",
+				StopReason: stopReason,
+				Token:      r.token,
+			})
+			stopReason = ""
+		}
+
 		// Run the job. Use a separate method so we can easily recover from a panic
 		// in job.Run.
 		tryLogger.Infof("job start")
 		startedAt, finishedAt, jobRet, runErr := r.runJob(jobData)
+		if restoreData != nil {
+			restoreData()
+		}
+		if reentrant {
+			r.token = rj.ReentryToken()
+		}
 		runtime := time.Duration(finishedAt-startedAt) * time.Nanosecond
 		tryLogger.Infof("job return: runtime=%s, state=%s (%d), exit=%d, err=%v", runtime, proto.StateName[jobRet.State], jobRet.State, jobRet.Exit, runErr)
 
@@ -155,6 +270,11 @@ TRY_LOOP:
 			}
 		}
 
+		execs, dropped := tryEx.List()
+		if dropped > 0 {
+			tryLogger.Warnf("job ran %d more commands than recorded (max %d per try)", dropped, MaxExecs)
+		}
+
 		// Create a JL and send it to the RM.
 		jl := proto.JobLog{
 			RequestId:  r.reqId,
@@ -167,15 +287,21 @@ TRY_LOOP:
 			State:      jobRet.State,
 			Exit:       jobRet.Exit,
 			Error:      errMsg,
+			ErrorClass: jobRet.ErrorClass,
 			Stdout:     jobRet.Stdout,
 			Stderr:     jobRet.Stderr,
+			Log:        tryLog.Entries(),
+			Resources:  tryRes.List(),
+			Execs:      execs,
 		}
-		err := retry.Do(JOB_LOG_TRIES, JOB_LOG_RETRY_WAIT,
-			func() error { return r.rmc.CreateJL(r.reqId, jl) },
-			func(err error) { tryLogger.Warnf("error sending job log entry: %s (retrying)", err) },
-		)
-		if err != nil {
-			tryLogger.Errorf("failed to send job log entry: %s (%+v)", err, jl)
+		if jobRet.State == proto.STATE_COMPLETE {
+			// Save final job data so the RM can seed it when rerunning
+			// downstream jobs (see RM request.Manager.Rerun)
+			jl.Data = jobData
+		}
+		r.sendJL(jl, tryLogger)
+		if r.faults != nil {
+			r.faults.AfterTry(r.realJob.Id())
 		}
 
 		// Set final job state to this job state
@@ -196,6 +322,21 @@ TRY_LOOP:
 			break TRY_LOOP
 		}
 
+		// Don't retry a permanent error: it will fail again. Wait longer to
+		// retry a throttled error to let the downstream system recover.
+		wait := r.retryWait
+		switch jobRet.ErrorClass {
+		case proto.ERROR_CLASS_PERMANENT:
+			tryLogger.Warnf("job failed with permanent error: not retrying")
+			break TRY_LOOP
+		case proto.ERROR_CLASS_THROTTLED:
+			throttled++
+			wait = throttledWait(r.retryWait, throttled)
+			tryLogger.Warnf("job throttled %d times in a row: waiting %s before retry", throttled, wait)
+		default:
+			throttled = 0
+		}
+
 		// Wait between retries. Can be stopped while waiting which is why we
 		// need to increment tryNo first. At this point, we're effectively on
 		// the next try. E.g. try 1 fails, we're waiting for try 2, then we're
@@ -207,7 +348,7 @@ TRY_LOOP:
 		r.sleeping = true
 		r.Unlock()
 		select {
-		case <-time.After(r.retryWait):
+		case <-time.After(wait):
 			r.Lock()
 			r.sleeping = false
 			r.Unlock()
@@ -221,6 +362,175 @@ TRY_LOOP:
 	return Return{
 		FinalState: finalState,
 		Tries:      tries,
+		Token:      r.token,
+	}
+}
+
+// throttledWait returns how long to wait before retrying a job after n
+// consecutive throttled tries. See MinThrottledWait.
+func throttledWait(retryWait time.Duration, n uint) time.Duration {
+	wait := retryWait
+	if wait < MinThrottledWait {
+		wait = MinThrottledWait
+	}
+	for i := uint(0); i < n && wait < MaxThrottledWait; i++ {
+		wait *= 2
+	}
+	if wait > MaxThrottledWait {
+		wait = MaxThrottledWait
+	}
+	if wait < retryWait {
+		wait = retryWait
+	}
+	return wait
+}
+
+func (r *runner) SetFeedback(fb job.Feedback) {
+	r.fb = fb
+}
+
+// noFeedback is the job.Feedback of jobs not in a paced fan-out.
+type noFeedback struct{}
+
+func (noFeedback) Throttled(uint) {}
+
+// sendJL sends the job log entry to the RM, retrying on error. Fields larger
+// than the job log limits are truncated first.
+func (r *runner) sendJL(jl proto.JobLog, logger *log.Entry) {
+	r.jll.apply(&jl, logger)
+	err := retry.Do(JOB_LOG_TRIES, JOB_LOG_RETRY_WAIT,
+		func() error { return r.rmc.CreateJL(r.reqId, jl) },
+		func(err error) { logger.Warnf("error sending job log entry: %s (retrying)", err) },
+	)
+	if err != nil {
+		logger.Errorf("failed to send job log entry: %s (%+v)", err, jl)
+	}
+}
+
+// lockSingleton acquires the singleton lock for the job. If another job holds
+// the lock, it waits for the lock (policy queue) or fails the job without running
+// it (policy fail). The job also fails if the lock cannot be acquired because
+// of an RM error. It returns false and the final state if the job must not run:
+// failed, or stopped while waiting.
+func (r *runner) lockSingleton() (Return, bool) {
+	l := proto.SingletonLock{
+		Name:      r.pJob.Singleton,
+		RequestId: r.reqId,
+		JobId:     r.pJob.Id,
+	}
+	logger := r.logger.WithFields(log.Fields{"singleton": l.Name, "try": r.totalTries})
+	defer func() {
+		r.Lock()
+		r.lockWait = ""
+		r.Unlock()
+	}()
+	for {
+		if r.stopped() {
+			logger.Infof("job stopped before start")
+			return Return{FinalState: proto.STATE_STOPPED, Tries: 1}, false
+		}
+
+		var holder proto.SingletonLock
+		err := retry.Do(JOB_LOG_TRIES, JOB_LOG_RETRY_WAIT,
+			func() error {
+				var err error
+				holder, err = r.rmc.AcquireLock(l)
+				return err
+			},
+			func(err error) { logger.Warnf("error acquiring singleton lock: %s (retrying)", err) },
+		)
+		if err != nil {
+			return r.failSingleton(fmt.Sprintf("cannot acquire singleton lock %s: %s", l.Name, err), logger), false
+		}
+		if holder.RequestId == l.RequestId && holder.JobId == l.JobId {
+			logger.Infof("acquired singleton lock")
+			return Return{}, true
+		}
+
+		msg := fmt.Sprintf("singleton lock %s held by request %s job %s", l.Name, holder.RequestId, holder.JobId)
+		if r.pJob.SingletonPolicy == proto.SINGLETON_POLICY_FAIL {
+			return r.failSingleton(msg, logger), false
+		}
+		r.Lock()
+		if r.lockWait == "" {
+			logger.Infof("%s, waiting", msg)
+		}
+		r.lockWait = fmt.Sprintf("request %s job %s", holder.RequestId, holder.JobId)
+		r.Unlock()
+		select {
+		case <-time.After(SingletonWait):
+		case <-r.stopChan:
+			logger.Infof("job stopped while waiting for singleton lock")
+			return Return{FinalState: proto.STATE_STOPPED, Tries: 1}, false
+		}
+	}
+}
+
+// failSingleton fails a singleton job that was not run, sending a job log entry
+// with the reason.
+func (r *runner) failSingleton(errMsg string, logger *log.Entry) Return {
+	logger.Errorf("job failed: %s", errMsg)
+	now := time.Now().UnixNano()
+	r.sendJL(proto.JobLog{
+		RequestId:  r.reqId,
+		JobId:      r.pJob.Id,
+		Name:       r.pJob.Name,
+		Type:       r.pJob.Type,
+		Try:        r.totalTries,
+		StartedAt:  now,
+		FinishedAt: now,
+		State:      proto.STATE_FAIL,
+		Exit:       1,
+		Error:      errMsg,
+	}, logger)
+	return Return{FinalState: proto.STATE_FAIL, Tries: 1}
+}
+
+// unlockSingleton releases the singleton lock for the job. If it cannot be
+// released, other jobs acquire it after the request stops running.
+func (r *runner) unlockSingleton() {
+	l := proto.SingletonLock{
+		Name:      r.pJob.Singleton,
+		RequestId: r.reqId,
+		JobId:     r.pJob.Id,
+	}
+	logger := r.logger.WithField("singleton", l.Name)
+	err := retry.Do(JOB_LOG_TRIES, JOB_LOG_RETRY_WAIT,
+		func() error { return r.rmc.ReleaseLock(l) },
+		func(err error) { logger.Warnf("error releasing singleton lock: %s (retrying)", err) },
+	)
+	if err != nil {
+		logger.Errorf("failed to release singleton lock: %s", err)
+		return
+	}
+	logger.Infof("released singleton lock")
+}
+
+// applyRetryArgs sets the retry arg overrides in jobData and returns a func that
+// restores the original jobData values. Values that the job changed while running
+// are not restored so that they're still passed on to the next jobs.
+func applyRetryArgs(jobData map[string]interface{}, retryArgs map[string]interface{}) func() {
+	type orig struct {
+		val interface{}
+		set bool
+	}
+	prev := map[string]orig{}
+	for k, v := range retryArgs {
+		pv, ok := jobData[k]
+		prev[k] = orig{val: pv, set: ok}
+		jobData[k] = v
+	}
+	return func() {
+		for k, o := range prev {
+			if !reflect.DeepEqual(jobData[k], retryArgs[k]) {
+				continue // changed by job
+			}
+			if o.set {
+				jobData[k] = o.val
+			} else {
+				delete(jobData, k)
+			}
+		}
 	}
 }
 
@@ -245,13 +555,180 @@ func (r *runner) runJob(jobData map[string]interface{}) (startedAt, finishedAt i
 	// time. Run will return when a job finishes running (either by
 	// its own accord or by being forced to finish when Stop is called).
 	startedAt = time.Now().UnixNano()
+	if err := r.setAuth(); err != nil {
+		return startedAt, time.Now().UnixNano(), job.Return{State: proto.STATE_FAIL, Exit: 1}, err
+	}
+	cleanup, err := r.setSandbox()
+	if err != nil {
+		return startedAt, time.Now().UnixNano(), job.Return{State: proto.STATE_FAIL, Exit: 1}, err
+	}
+	defer cleanup()
+	overQuota, err := r.setWorkspace()
+	if err != nil {
+		return startedAt, time.Now().UnixNano(), job.Return{State: proto.STATE_FAIL, Exit: 1}, err
+	}
+	if r.faults != nil {
+		delay, err := r.faults.BeforeTry(r.realJob.Id())
+		if err != nil {
+			return startedAt, time.Now().UnixNano(), job.Return{State: proto.STATE_FAIL, Exit: 1}, err
+		}
+		if delay > 0 {
+			r.logger.Warnf("fault injection: delaying job %s", delay)
+			select {
+			case <-time.After(delay):
+			case <-r.stopChan:
+			}
+		}
+	}
 	jobRet, runErr := r.realJob.Run(jobData)
 	finishedAt = time.Now().UnixNano()
+	if err := overQuota(); err != nil {
+		jobRet.State = proto.STATE_FAIL
+		runErr = err
+	}
+	if jobRet.State == proto.STATE_COMPLETE && len(r.pJob.Asserts) > 0 {
+		if err := r.checkAsserts(jobData); err != nil {
+			jobRet.State = proto.STATE_FAIL
+			jobRet.Exit = 1
+			runErr = err
+		}
+	}
 
 	return startedAt, finishedAt, jobRet, runErr
 }
 
-func (r *runner) Stop() error {
+// setAuth gives the job its auth context if it implements job.Authenticated.
+// A new token is gotten for every try because a token can expire between tries.
+func (r *runner) setAuth() error {
+	aj, ok := r.realJob.(job.Authenticated)
+	if !ok {
+		return nil
+	}
+	auth := job.Auth{User: r.user}
+	if r.tp != nil && r.user != "" {
+		token, err := r.tp.Token(r.user, r.realJob.Id())
+		if err != nil {
+			return fmt.Errorf("cannot get token for user %s: %s", r.user, err)
+		}
+		auth.Token = token
+	}
+	aj.SetAuth(auth)
+	return nil
+}
+
+// setLogger gives the job a new logger if it implements job.Logging, and returns
+// the log of the try. It returns nil if the job does not implement job.Logging.
+func (r *runner) setLogger(logger *log.Entry) *tryLog {
+	lj, ok := r.realJob.(job.Logging)
+	if !ok {
+		return nil
+	}
+	l := newTryLog(r.pJob.LogLevel, logger)
+	lj.SetLogger(jobLogger{l: l})
+	return l
+}
+
+// setSandbox gives the job a sandbox with a new private work dir if its type is
+// sandboxed. The returned func removes the work dir after the try. A job of a
+// sandboxed type that does not implement job.Sandboxed is not run because it
+// would run its processes outside the sandbox.
+func (r *runner) setSandbox() (func(), error) {
+	if r.sandbox == nil {
+		return func() {}, nil
+	}
+	sj, ok := r.realJob.(job.Sandboxed)
+	if !ok {
+		return nil, fmt.Errorf("job type %s is sandboxed but does not implement job.Sandboxed", r.pJob.Type)
+	}
+	sb := *r.sandbox
+	dir, err := ioutil.TempDir(sb.Dir, "spincycle-"+r.pJob.Id+"-")
+	if err != nil {
+		return nil, fmt.Errorf("cannot make sandbox work dir: %s", err)
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			r.logger.Warnf("error removing sandbox work dir %s: %s", dir, err)
+		}
+	}
+	if sb.User != "" {
+		if err := os.Chown(dir, int(sb.Uid), int(sb.Gid)); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("cannot change owner of sandbox work dir to %s: %s", sb.User, err)
+		}
+	}
+	sb.Dir = dir
+	sj.SetSandbox(sb)
+	return cleanup, nil
+}
+
+// setWorkspace gives the job its workspace if it implements job.UsesWorkspace,
+// making the workspace on the first try. The returned func returns an error if
+// the workspace is larger than its quota after the try. While the job runs, it's
+// stopped if the workspace becomes larger than the quota.
+func (r *runner) setWorkspace() (func() error, error) {
+	wj, ok := r.realJob.(job.UsesWorkspace)
+	if !ok || r.ws == nil {
+		return func() error { return nil }, nil
+	}
+	if r.workspace == nil {
+		ws, err := r.ws.make(r.reqId, r.pJob.Id, r.sandbox)
+		if err != nil {
+			return nil, fmt.Errorf("cannot make workspace: %s", err)
+		}
+		r.workspace = &ws
+	}
+	wj.SetWorkspace(*r.workspace)
+	if r.workspace.Quota == 0 {
+		return func() error { return nil }, nil
+	}
+	return watchWorkspace(*r.workspace, func(err error) {
+		r.logger.Warnf("stopping job: %s", err)
+		if err := r.stopJob(proto.STOP_REASON_QUOTA); err != nil {
+			r.logger.Warnf("error stopping job: %s", err)
+		}
+	}), nil
+}
+
+// removeWorkspace removes the workspace of the job, if it was made.
+func (r *runner) removeWorkspace() {
+	if r.workspace == nil {
+		return
+	}
+	if err := r.ws.remove(r.reqId, r.pJob.Id); err != nil {
+		r.logger.Warnf("error removing workspace %s: %s", r.workspace.Dir, err)
+	}
+}
+
+// checkAsserts checks the sequence assertions of the last job in a sequence
+// over its job data and args (jobArgs used by the assertions). Job data takes
+// precedence because jobs set it while running. It returns an error for the
+// first assertion that is not true.
+func (r *runner) checkAsserts(jobData map[string]interface{}) error {
+	args := make(map[string]interface{}, len(r.pJob.Args)+len(jobData))
+	for k, v := range r.pJob.Args {
+		args[k] = v
+	}
+	for k, v := range jobData {
+		args[k] = v
+	}
+	for _, a := range r.pJob.Asserts {
+		e, err := expr.Parse(a)
+		if err != nil {
+			return fmt.Errorf("cannot check assertion: %s", err)
+		}
+		ok, err := e.Eval(args)
+		if err != nil {
+			return fmt.Errorf("cannot check assertion %s: %s", a, err)
+		}
+		if !ok {
+			return fmt.Errorf("assertion failed: %s", a)
+		}
+		r.logger.Infof("assertion true: %s", a)
+	}
+	return nil
+}
+
+func (r *runner) Stop(reason string) error {
 	r.Lock() // LOCK
 
 	// Return if stop was already called.
@@ -266,8 +743,16 @@ func (r *runner) Stop() error {
 
 	r.Unlock() // UNLOCK
 
-	r.logger.Infof("stopping the job")
-	return r.realJob.Stop() // this is a blocking operation that should return quickly
+	r.logger.Infof("stopping the job (%s)", reason)
+	return r.stopJob(reason) // this is a blocking operation that should return quickly
+}
+
+// stopJob stops the job, giving it the reason if it implements job.ReasonStopper.
+func (r *runner) stopJob(reason string) error {
+	if rs, ok := r.realJob.(job.ReasonStopper); ok {
+		return rs.StopWithReason(reason)
+	}
+	return r.realJob.Stop()
 }
 
 func (r *runner) stopped() bool {
@@ -286,6 +771,10 @@ func (r *runner) Runtime() float64 {
 func (r *runner) Status() Status {
 	// Get real-time status before locking in case it's slow
 	status := r.realJob.Status()
+	var waiting bool
+	if aw, ok := r.realJob.(approvalWaiter); ok {
+		waiting = aw.WaitingApproval()
+	}
 
 	r.Lock()
 	defer r.Unlock()
@@ -294,6 +783,9 @@ func (r *runner) Status() Status {
 	if r.sleeping {
 		status = "(retry sleep) " + status
 	}
+	if r.lockWait != "" {
+		status = "(singleton wait: " + r.lockWait + ") " + status
+	}
 
 	return Status{
 		Job:       r.pJob,
@@ -301,5 +793,7 @@ func (r *runner) Status() Status {
 		Try:       r.totalTries,
 		Status:    status,
 		Sleeping:  r.sleeping,
+		Waiting:   waiting,
+		LockWait:  r.lockWait,
 	}
 }
@@ -3,11 +3,20 @@
 package runner_test
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-test/deep"
+	"github.com/square/spincycle/v2/config"
 	"github.com/square/spincycle/v2/job"
+	"github.com/square/spincycle/v2/job-runner/fault"
 	"github.com/square/spincycle/v2/job-runner/runner"
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/test/mock"
@@ -23,7 +32,10 @@ func TestFactory(t *testing.T) {
 		MakeErr:  mock.ErrJob,
 	}
 	rmc := &mock.RMClient{}
-	rf := runner.NewFactory(jf, rmc)
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: jf,
+		RMClient:   rmc,
+	})
 
 	pJob := proto.Job{
 		Id:    "j1",
@@ -31,7 +43,7 @@ func TestFactory(t *testing.T) {
 		Bytes: []byte{},
 	}
 
-	jr, err := rf.Make(pJob, "abc", 0, 0)
+	jr, err := rf.Make(pJob, runner.Request{Id: "abc"}, 0, 0)
 	if err != mock.ErrJob {
 		t.Errorf("err = nil, expected %s", mock.ErrJob)
 	}
@@ -82,6 +94,64 @@ func TestRunFail(t *testing.T) {
 	}
 }
 
+func TestRunErrorClass(t *testing.T) {
+	defer func(min, max time.Duration) {
+		runner.MinThrottledWait = min
+		runner.MaxThrottledWait = max
+	}(runner.MinThrottledWait, runner.MaxThrottledWait)
+	runner.MinThrottledWait = 10 * time.Millisecond
+	runner.MaxThrottledWait = 30 * time.Millisecond
+
+	var sentJLs []proto.JobLog
+	rmc := &mock.RMClient{
+		CreateJLFunc: func(reqId string, jl proto.JobLog) error {
+			sentJLs = append(sentJLs, jl)
+			return nil
+		},
+	}
+	pJob := proto.Job{Id: "failJob", Type: "jtype", Retry: 3}
+
+	// Permanent error fails now, with retries left
+	mJob := &mock.Job{
+		RunReturn: job.Return{State: proto.STATE_FAIL, ErrorClass: proto.ERROR_CLASS_PERMANENT},
+	}
+	ret := runner.NewRunner(pJob, mJob, "abc", 0, 0, rmc).Run(noJobData)
+	if ret.FinalState != proto.STATE_FAIL {
+		t.Errorf("final state = %d, expected %d", ret.FinalState, proto.STATE_FAIL)
+	}
+	if ret.Tries != 1 || len(sentJLs) != 1 {
+		t.Fatalf("tries = %d, JLs = %d, expected 1 and 1", ret.Tries, len(sentJLs))
+	}
+	if sentJLs[0].ErrorClass != proto.ERROR_CLASS_PERMANENT {
+		t.Errorf("JL error class = %q, expected %q", sentJLs[0].ErrorClass, proto.ERROR_CLASS_PERMANENT)
+	}
+
+	// Throttled errors are retried after a longer wait that doubles every try:
+	// 20ms, then 30ms (max), then complete
+	sentJLs = nil
+	tries := 0
+	mJob = &mock.Job{
+		RunFunc: func(jobData map[string]interface{}) (job.Return, error) {
+			tries++
+			if tries < 3 {
+				return job.Return{State: proto.STATE_FAIL, ErrorClass: proto.ERROR_CLASS_THROTTLED}, nil
+			}
+			return job.Return{State: proto.STATE_COMPLETE}, nil
+		},
+	}
+	start := time.Now()
+	ret = runner.NewRunner(pJob, mJob, "abc", 0, 0, rmc).Run(noJobData)
+	if ret.FinalState != proto.STATE_COMPLETE {
+		t.Errorf("final state = %d, expected %d", ret.FinalState, proto.STATE_COMPLETE)
+	}
+	if ret.Tries != 3 {
+		t.Errorf("tries = %d, expected 3", ret.Tries)
+	}
+	if d := time.Now().Sub(start); d < 50*time.Millisecond {
+		t.Errorf("ran in %s, expected at least 50ms of throttled waits", d)
+	}
+}
+
 func TestRunSuccess(t *testing.T) {
 	attemptNumber := 0
 	// Create a mock job that will succeed on the third of four retries.
@@ -160,7 +230,7 @@ func TestRunStop(t *testing.T) {
 	// Sleep for a second to allow the runner to get to the state where it's sleeping for the
 	// duration of the retry delay.
 	time.Sleep(1 * time.Second)
-	err := jr.Stop()
+	err := jr.Stop(proto.STOP_REASON_USER)
 	if err != nil {
 		t.Errorf("err = %s, expected nil", err)
 	}
@@ -172,12 +242,47 @@ func TestRunStop(t *testing.T) {
 	}
 
 	// Make sure calling stop on an already stopped job doesn't panic.
-	err = jr.Stop()
+	err = jr.Stop(proto.STOP_REASON_USER)
 	if err != nil {
 		t.Errorf("err = %s, expected nil", err)
 	}
 }
 
+func TestRunStopReason(t *testing.T) {
+	stopChan := make(chan struct{})
+	mJob := &mock.ReasonStopperJob{}
+	mJob.RunFunc = func(jobData map[string]interface{}) (job.Return, error) {
+		<-stopChan
+		return job.Return{State: proto.STATE_FAIL}, nil
+	}
+	mJob.StopFunc = func() error {
+		close(stopChan)
+		return nil
+	}
+	pJob := proto.Job{
+		Id:    "stopReasonJob",
+		Type:  "jtype",
+		Bytes: []byte{},
+	}
+	jr := runner.NewRunner(pJob, mJob, "abc", 0, 0, &mock.RMClient{})
+
+	retChan := make(chan runner.Return)
+	go func() {
+		retChan <- jr.Run(noJobData)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := jr.Stop(proto.STOP_REASON_SUSPENDED); err != nil {
+		t.Errorf("err = %s, expected nil", err)
+	}
+	ret := <-retChan
+	if ret.FinalState != proto.STATE_STOPPED {
+		t.Errorf("final state = %s, expected STATE_STOPPED", proto.StateName[ret.FinalState])
+	}
+	if mJob.StopReason != proto.STOP_REASON_SUSPENDED {
+		t.Errorf("stop reason = %q, expected %q", mJob.StopReason, proto.STOP_REASON_SUSPENDED)
+	}
+}
+
 func TestRunStatus(t *testing.T) {
 	pJob := proto.Job{
 		Id:   "j1",
@@ -326,3 +431,986 @@ func TestRunResumed(t *testing.T) {
 		t.Errorf("jle.Try = %d, expected 3", gotJLE.Try)
 	}
 }
+
+func TestRunRetryArgs(t *testing.T) {
+	gotData := []map[string]interface{}{}
+	mJob := &mock.Job{
+		RunFunc: func(jobData map[string]interface{}) (job.Return, error) {
+			cp := map[string]interface{}{}
+			for k, v := range jobData {
+				cp[k] = v
+			}
+			gotData = append(gotData, cp)
+			if len(gotData) < 3 {
+				return job.Return{State: proto.STATE_FAIL}, nil
+			}
+			jobData["out"] = "done"
+			return job.Return{State: proto.STATE_COMPLETE}, nil
+		},
+	}
+	pJob := proto.Job{
+		Id:        "retryArgsJob",
+		Type:      "jtype",
+		Retry:     2,
+		RetryArgs: map[string]interface{}{"endpoint": "fallback", "batchSize": "10"},
+	}
+	rmc := &mock.RMClient{
+		CreateJLFunc: func(reqId string, jl proto.JobLog) error { return nil },
+	}
+	jr := runner.NewRunner(pJob, mJob, "abc", 0, 0, rmc)
+
+	jobData := map[string]interface{}{"endpoint": "primary"}
+	ret := jr.Run(jobData)
+	if ret.FinalState != proto.STATE_COMPLETE {
+		t.Errorf("final state = %d, expected %d", ret.FinalState, proto.STATE_COMPLETE)
+	}
+
+	expectData := []map[string]interface{}{
+		{"endpoint": "primary"},
+		{"endpoint": "fallback", "batchSize": "10"},
+		{"endpoint": "fallback", "batchSize": "10"},
+	}
+	if diff := deep.Equal(gotData, expectData); diff != nil {
+		t.Error(diff)
+	}
+
+	// Overrides are only for the job tries, not the job data passed on
+	expectData = []map[string]interface{}{{"endpoint": "primary", "out": "done"}}
+	if diff := deep.Equal([]map[string]interface{}{jobData}, expectData); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRunAuth(t *testing.T) {
+	// Job implements job.Authenticated, so it gets the user and a new token
+	// every try. The first try fails, the token provider fails on the second
+	// try, which fails the try without running the job, and the third try
+	// succeeds.
+	aJob := &mock.AuthJob{}
+	runs := 0
+	aJob.RunFunc = func(jobData map[string]interface{}) (job.Return, error) {
+		runs++
+		if runs == 1 {
+			return job.Return{State: proto.STATE_FAIL}, nil
+		}
+		return job.Return{State: proto.STATE_COMPLETE}, nil
+	}
+	tokens := 0
+	tp := &mock.TokenProvider{
+		TokenFunc: func(user string, jobId job.Id) (string, error) {
+			tokens++
+			if tokens == 2 {
+				return "", fmt.Errorf("token service unavailable")
+			}
+			return fmt.Sprintf("%s-%s-token%d", user, jobId.Id, tokens), nil
+		},
+	}
+	var jls []proto.JobLog
+	rmc := &mock.RMClient{
+		CreateJLFunc: func(reqId string, jl proto.JobLog) error {
+			jls = append(jls, jl)
+			return nil
+		},
+	}
+	pJob := proto.Job{
+		Id:    "authJob",
+		Type:  "jtype",
+		Bytes: []byte{},
+		Retry: 2,
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory:    &mock.SameJobFactory{Job: aJob},
+		RMClient:      rmc,
+		TokenProvider: tp,
+	})
+	jr, err := rf.Make(pJob, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret := jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_COMPLETE {
+		t.Errorf("final state = %d, expected %d", ret.FinalState, proto.STATE_COMPLETE)
+	}
+	if runs != 2 {
+		t.Errorf("job ran %d times, expected 2", runs)
+	}
+	expect := []job.Auth{
+		{User: "finch", Token: "finch-authJob-token1"},
+		{User: "finch", Token: "finch-authJob-token3"},
+	}
+	if diff := deep.Equal(aJob.Auths, expect); diff != nil {
+		t.Error(diff)
+	}
+	if len(jls) != 3 {
+		t.Fatalf("got %d JLs, expected 3", len(jls))
+	}
+	if jls[1].State != proto.STATE_FAIL || !strings.Contains(jls[1].Error, "token service unavailable") {
+		t.Errorf("got JL %+v, expected failed try with token error", jls[1])
+	}
+}
+
+func TestMakeGlobals(t *testing.T) {
+	// Job implements job.UsesGlobals, so it gets a copy of the request globals
+	gJob := &mock.GlobalsJob{}
+	globals := map[string]interface{}{"region": "us-east-1"}
+	pJob := proto.Job{
+		Id:    "globalsJob",
+		Type:  "jtype",
+		Bytes: []byte{},
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: &mock.SameJobFactory{Job: gJob},
+		RMClient:   &mock.RMClient{},
+	})
+	if _, err := rf.Make(pJob, runner.Request{Id: "abc", User: "finch", Globals: globals}, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(gJob.Globals, globals); diff != nil {
+		t.Error(diff)
+	}
+	gJob.Globals["region"] = "changed"
+	if globals["region"] != "us-east-1" {
+		t.Errorf("job changed request globals")
+	}
+}
+
+func TestMakeOverrides(t *testing.T) {
+	// Job implements job.UsesOverrides, so it gets a copy of the request overrides
+	oJob := &mock.OverridesJob{}
+	overrides := map[string]string{"dns-endpoint": "https://dns.staging.example.com"}
+	pJob := proto.Job{
+		Id:    "overridesJob",
+		Type:  "jtype",
+		Bytes: []byte{},
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: &mock.SameJobFactory{Job: oJob},
+		RMClient:   &mock.RMClient{},
+	})
+	if _, err := rf.Make(pJob, runner.Request{Id: "abc", User: "finch", Overrides: overrides}, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(oJob.Overrides, overrides); diff != nil {
+		t.Error(diff)
+	}
+	oJob.Overrides["dns-endpoint"] = "changed"
+	if overrides["dns-endpoint"] != "https://dns.staging.example.com" {
+		t.Errorf("job changed request overrides")
+	}
+
+	// No overrides: job gets an empty map, not nil
+	oJob.Overrides = nil
+	if _, err := rf.Make(pJob, runner.Request{Id: "abc", User: "finch"}, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if oJob.Overrides == nil || len(oJob.Overrides) != 0 {
+		t.Errorf("got overrides %v, expected empty map", oJob.Overrides)
+	}
+}
+
+func TestRunSandbox(t *testing.T) {
+	// Job type is sandboxed and job implements job.Sandboxed, so it gets a
+	// sandbox with a new private work dir every try, removed after the try
+	workDir, err := ioutil.TempDir("", "spincycle-runner-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+	sandboxes, err := runner.NewSandboxes([]config.Sandbox{{Types: []string{"jtype"}, WorkDir: workDir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sJob := &mock.SandboxedJob{}
+	runs := 0
+	sJob.RunFunc = func(jobData map[string]interface{}) (job.Return, error) {
+		runs++
+		dir := sJob.Sandboxes[len(sJob.Sandboxes)-1].Dir
+		if filepath.Dir(dir) != workDir {
+			t.Errorf("sandbox dir %s not in work dir %s", dir, workDir)
+		}
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("sandbox dir does not exist while job running: %s", err)
+		}
+		if runs == 1 {
+			return job.Return{State: proto.STATE_FAIL}, nil
+		}
+		return job.Return{State: proto.STATE_COMPLETE}, nil
+	}
+	pJob := proto.Job{
+		Id:    "sandboxedJob",
+		Type:  "jtype",
+		Bytes: []byte{},
+		Retry: 1,
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: &mock.SameJobFactory{Job: sJob},
+		RMClient:   &mock.RMClient{},
+		Sandboxes:  sandboxes,
+	})
+	jr, err := rf.Make(pJob, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_COMPLETE {
+		t.Errorf("final state = %d, expected %d", ret.FinalState, proto.STATE_COMPLETE)
+	}
+	if len(sJob.Sandboxes) != 2 || sJob.Sandboxes[0].Dir == sJob.Sandboxes[1].Dir {
+		t.Errorf("got sandboxes %+v, expected 2 with different dirs", sJob.Sandboxes)
+	}
+	files, err := ioutil.ReadDir(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("%d sandbox dirs not removed", len(files))
+	}
+
+	// Job of a sandboxed type that does not implement job.Sandboxed fails
+	// without running
+	var jl proto.JobLog
+	rmc := &mock.RMClient{
+		CreateJLFunc: func(reqId string, l proto.JobLog) error {
+			jl = l
+			return nil
+		},
+	}
+	mJob := &mock.Job{RunReturn: job.Return{State: proto.STATE_COMPLETE}}
+	rf = runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: &mock.JobFactory{MockJobs: map[string]*mock.Job{"jtype": mJob}},
+		RMClient:   rmc,
+		Sandboxes:  sandboxes,
+	})
+	pJob.Retry = 0
+	jr, err = rf.Make(pJob, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret = jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_FAIL {
+		t.Errorf("final state = %d, expected %d", ret.FinalState, proto.STATE_FAIL)
+	}
+	if !strings.Contains(jl.Error, "job.Sandboxed") {
+		t.Errorf("got JL error %q, expected sandboxed error", jl.Error)
+	}
+}
+
+func TestNewSandboxes(t *testing.T) {
+	cfgs := [][]config.Sandbox{
+		{{}}, // no types
+		{{Types: []string{"a"}}, {Types: []string{"b", "a"}}}, // a in two sandboxes
+		{{Types: []string{"a"}, Limits: map[string]uint64{"bogus": 1}}},
+		{{Types: []string{"a"}, WorkDir: "/does/not/exist"}},
+		{{Types: []string{"a"}, User: "spincycle-no-such-user"}},
+	}
+	for _, cfg := range cfgs {
+		if _, err := runner.NewSandboxes(cfg); err == nil {
+			t.Errorf("no error for %+v, expected one", cfg)
+		}
+	}
+}
+
+func TestRunLogger(t *testing.T) {
+	defer func(n int) { runner.MaxLogEntries = n }(runner.MaxLogEntries)
+	runner.MaxLogEntries = 3
+
+	// Job implements job.Logging, so it gets a new logger every try. Entries
+	// at or above the job log level are saved in the JL of the try.
+	lJob := &mock.LoggingJob{}
+	runs := 0
+	lJob.RunFunc = func(jobData map[string]interface{}) (job.Return, error) {
+		runs++
+		l := lJob.Loggers[len(lJob.Loggers)-1]
+		l.Debugf("try %d", runs)
+		l.WithFields(map[string]interface{}{"host": "db1"}).Infof("checked host")
+		if runs == 1 {
+			l.Errorf("host down")
+			return job.Return{State: proto.STATE_FAIL}, nil
+		}
+		for i := 0; i < 3; i++ {
+			l.Warnf("retrying")
+		}
+		return job.Return{State: proto.STATE_COMPLETE}, nil
+	}
+	var jls []proto.JobLog
+	rmc := &mock.RMClient{
+		CreateJLFunc: func(reqId string, jl proto.JobLog) error {
+			jls = append(jls, jl)
+			return nil
+		},
+	}
+	pJob := proto.Job{
+		Id:       "logJob",
+		Type:     "jtype",
+		Bytes:    []byte{},
+		Retry:    1,
+		LogLevel: proto.LOG_LEVEL_INFO,
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: &mock.SameJobFactory{Job: lJob},
+		RMClient:   rmc,
+	})
+	jr, err := rf.Make(pJob, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret := jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_COMPLETE {
+		t.Errorf("final state = %d, expected %d", ret.FinalState, proto.STATE_COMPLETE)
+	}
+	if len(lJob.Loggers) != 2 {
+		t.Errorf("job given %d loggers, expected 2 (one per try)", len(lJob.Loggers))
+	}
+	if len(jls) != 2 {
+		t.Fatalf("got %d JLs, expected 2", len(jls))
+	}
+
+	// Debug entry not saved at level info
+	var got []string
+	for _, e := range jls[0].Log {
+		got = append(got, e.Level+": "+e.Msg)
+		if e.Ts == 0 {
+			t.Errorf("entry %+v has no timestamp", e)
+		}
+	}
+	if diff := deep.Equal(got, []string{"info: checked host", "error: host down"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(jls[0].Log[0].Fields, map[string]interface{}{"host": "db1"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Entries over MaxLogEntries are dropped
+	got = nil
+	for _, e := range jls[1].Log {
+		got = append(got, e.Level+": "+e.Msg)
+	}
+	expect := []string{
+		"info: checked host",
+		"warn: retrying",
+		"warn: retrying",
+		"warn: 1 log entries dropped (max 3 per try)",
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Debug entries saved at level debug
+	pJob.LogLevel = proto.LOG_LEVEL_DEBUG
+	jls = nil
+	runs = 1
+	jr, err = rf.Make(pJob, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jr.Run(noJobData)
+	if len(jls) != 1 || len(jls[0].Log) == 0 || jls[0].Log[0].Msg != "try 2" {
+		t.Errorf("got JLs %+v, expected debug entry first", jls)
+	}
+}
+
+func TestRunReentrant(t *testing.T) {
+	// Job was suspended on try 1 after processing host2. When resumed, it's
+	// told so on the first try, which fails after host3, then it's retried.
+	rJob := &mock.ReentrantJob{}
+	rJob.RunFunc = func(jobData map[string]interface{}) (job.Return, error) {
+		if len(rJob.Reentries) == 1 {
+			rJob.Token = "host3"
+			return job.Return{State: proto.STATE_FAIL}, nil
+		}
+		rJob.Token = "host4"
+		return job.Return{State: proto.STATE_COMPLETE}, nil
+	}
+	pJob := proto.Job{
+		Id:           "reJob",
+		Type:         "jtype",
+		Bytes:        []byte{},
+		Retry:        1,
+		StopReason:   proto.STOP_REASON_SUSPENDED,
+		ReentryToken: "host2",
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: &mock.SameJobFactory{Job: rJob},
+		RMClient:   &mock.RMClient{},
+	})
+	jr, err := rf.Make(pJob, runner.Request{Id: "abc", User: "finch"}, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret := jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_COMPLETE {
+		t.Errorf("final state = %d, expected %d", ret.FinalState, proto.STATE_COMPLETE)
+	}
+	if ret.Token != "host4" {
+		t.Errorf("got token %s, expected host4", ret.Token)
+	}
+	expect := []job.Reentry{
+		{Try: 2, Resumed: true, StopReason: proto.STOP_REASON_SUSPENDED, Token: "host2"},
+		{Try: 3, Token: "host3"},
+	}
+	if diff := deep.Equal(rJob.Reentries, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+type feedback struct {
+	n uint
+}
+
+func (f *feedback) Throttled(n uint) {
+	f.n += n
+}
+
+func TestRunPaced(t *testing.T) {
+	// Job is throttled twice on its first try, which fails, then it's retried
+	pJob := &mock.PacedJob{}
+	pJob.RunFunc = func(jobData map[string]interface{}) (job.Return, error) {
+		if len(pJob.Feedbacks) == 1 {
+			pJob.Feedbacks[0].Throttled(2)
+			return job.Return{State: proto.STATE_FAIL}, nil
+		}
+		return job.Return{State: proto.STATE_COMPLETE}, nil
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: &mock.SameJobFactory{Job: pJob},
+		RMClient:   &mock.RMClient{},
+	})
+	jr, err := rf.Make(proto.Job{Id: "pJob", Type: "jtype", Retry: 1, Pace: "fanout1"}, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb := &feedback{}
+	jr.(runner.PacedRunner).SetFeedback(fb)
+
+	ret := jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_COMPLETE {
+		t.Errorf("final state = %d, expected %d", ret.FinalState, proto.STATE_COMPLETE)
+	}
+	if len(pJob.Feedbacks) != 2 {
+		t.Fatalf("SetFeedback called %d times, expected 2 (every try)", len(pJob.Feedbacks))
+	}
+	if fb.n != 2 {
+		t.Errorf("throttled %d times, expected 2", fb.n)
+	}
+
+	// A job not in a paced fan-out gets feedback that does nothing
+	pJob.Feedbacks = nil
+	jr, err = rf.Make(proto.Job{Id: "pJob", Type: "jtype"}, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jr.Run(noJobData)
+	if len(pJob.Feedbacks) != 1 || pJob.Feedbacks[0] == nil {
+		t.Errorf("got feedback %v, expected 1 non-nil", pJob.Feedbacks)
+	}
+}
+
+func TestRunResources(t *testing.T) {
+	// Job affects resources on both tries: the first fails, so the JL of each
+	// try has only the resources affected during it, sorted and deduped
+	rJob := &mock.ResourcesJob{}
+	rJob.RunFunc = func(jobData map[string]interface{}) (job.Return, error) {
+		res := rJob.Resources[len(rJob.Resources)-1]
+		if len(rJob.Resources) == 1 {
+			res.Affect("host2", "host1")
+			res.Affect("host1", "")
+			return job.Return{State: proto.STATE_FAIL}, nil
+		}
+		res.Affect("db01")
+		return job.Return{State: proto.STATE_COMPLETE}, nil
+	}
+	var sentJLs []proto.JobLog
+	rmc := &mock.RMClient{
+		CreateJLFunc: func(reqId string, jl proto.JobLog) error {
+			sentJLs = append(sentJLs, jl)
+			return nil
+		},
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: &mock.SameJobFactory{Job: rJob},
+		RMClient:   rmc,
+	})
+	jr, err := rf.Make(proto.Job{Id: "rJob", Type: "jtype", Retry: 1}, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_COMPLETE {
+		t.Errorf("final state = %d, expected %d", ret.FinalState, proto.STATE_COMPLETE)
+	}
+	if len(sentJLs) != 2 {
+		t.Fatalf("got %d JLs, expected 2", len(sentJLs))
+	}
+	if diff := deep.Equal(sentJLs[0].Resources, []string{"host1", "host2"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(sentJLs[1].Resources, []string{"db01"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRunExecs(t *testing.T) {
+	// Job runs commands on both tries: the first fails, so the JL of each try
+	// has only the commands run during it
+	eJob := &mock.ExecsJob{}
+	eJob.RunFunc = func(jobData map[string]interface{}) (job.Return, error) {
+		ex := eJob.Execs[len(eJob.Execs)-1]
+		if len(eJob.Execs) == 1 {
+			if err := ex.Run(exec.Command("true")); err != nil {
+				return job.Return{}, err
+			}
+			ex.Run(exec.Command("sh", "-c", "exit 3"))
+			return job.Return{State: proto.STATE_FAIL}, nil
+		}
+		ex.Run(exec.Command("/nonexistent/cmd", "arg"))
+		return job.Return{State: proto.STATE_COMPLETE}, nil
+	}
+	var sentJLs []proto.JobLog
+	rmc := &mock.RMClient{
+		CreateJLFunc: func(reqId string, jl proto.JobLog) error {
+			sentJLs = append(sentJLs, jl)
+			return nil
+		},
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: &mock.SameJobFactory{Job: eJob},
+		RMClient:   rmc,
+	})
+	jr, err := rf.Make(proto.Job{Id: "eJob", Type: "jtype", Retry: 1}, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_COMPLETE {
+		t.Errorf("final state = %d, expected %d", ret.FinalState, proto.STATE_COMPLETE)
+	}
+	if len(sentJLs) != 2 {
+		t.Fatalf("got %d JLs, expected 2", len(sentJLs))
+	}
+
+	execs := sentJLs[0].Execs
+	if len(execs) != 2 {
+		t.Fatalf("got %d execs on try 1, expected 2: %+v", len(execs), execs)
+	}
+	if diff := deep.Equal(execs[0].Args, []string{"true"}); diff != nil {
+		t.Error(diff)
+	}
+	if execs[0].Exit != 0 || execs[0].Error != "" {
+		t.Errorf("exec 1: exit = %d, error = %q, expected 0 and no error", execs[0].Exit, execs[0].Error)
+	}
+	if execs[0].StartedAt == 0 || execs[0].Duration <= 0 {
+		t.Errorf("exec 1: started at %d, duration %d, expected both set", execs[0].StartedAt, execs[0].Duration)
+	}
+	if diff := deep.Equal(execs[1].Args, []string{"sh", "-c", "exit 3"}); diff != nil {
+		t.Error(diff)
+	}
+	if execs[1].Exit != 3 || execs[1].Error != "" {
+		t.Errorf("exec 2: exit = %d, error = %q, expected 3 and no error", execs[1].Exit, execs[1].Error)
+	}
+
+	execs = sentJLs[1].Execs
+	if len(execs) != 1 {
+		t.Fatalf("got %d execs on try 2, expected 1: %+v", len(execs), execs)
+	}
+	if execs[0].Exit != -1 || execs[0].Error == "" {
+		t.Errorf("exec 1: exit = %d, error = %q, expected -1 and an error", execs[0].Exit, execs[0].Error)
+	}
+}
+
+func TestRunWorkspace(t *testing.T) {
+	defer func(d time.Duration) { runner.WorkspaceCheckInterval = d }(runner.WorkspaceCheckInterval)
+	runner.WorkspaceCheckInterval = 50 * time.Millisecond
+
+	tmpDir, err := ioutil.TempDir("", "spincycle-workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	artifactsDir := filepath.Join(tmpDir, "artifacts")
+	workspaces, err := runner.NewWorkspaces(config.Workspaces{
+		Dir:          filepath.Join(tmpDir, "ws"),
+		QuotaMB:      1,
+		ArtifactsDir: artifactsDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Try 1 writes more than the quota and is stopped while running, so it
+	// fails. Try 2 gets the same workspace, removes the big file, and promotes
+	// a small one.
+	var jls []proto.JobLog
+	rmc := &mock.RMClient{
+		CreateJLFunc: func(reqId string, jl proto.JobLog) error {
+			jls = append(jls, jl)
+			return nil
+		},
+	}
+	stopChan := make(chan struct{})
+	wJob := &mock.WorkspaceJob{}
+	wJob.StopFunc = func() error {
+		close(stopChan)
+		return nil
+	}
+	var artifact string
+	wJob.RunFunc = func(jobData map[string]interface{}) (job.Return, error) {
+		ws := wJob.Workspaces[len(wJob.Workspaces)-1]
+		big := filepath.Join(ws.Dir, "big")
+		if len(wJob.Workspaces) == 1 {
+			if err := ioutil.WriteFile(big, make([]byte, 2*1024*1024), 0600); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-stopChan:
+			case <-time.After(2 * time.Second):
+				t.Error("job not stopped when over quota")
+			}
+			return job.Return{State: proto.STATE_FAIL}, nil
+		}
+		if err := os.Remove(big); err != nil {
+			t.Errorf("big file from try 1 not in workspace: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(ws.Dir, "report.txt"), []byte("ok"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ws.Promote("../report.txt"); err == nil {
+			t.Error("no error promoting path outside workspace")
+		}
+		var err error
+		artifact, err = ws.Promote("report.txt")
+		if err != nil {
+			t.Error(err)
+		}
+		return job.Return{State: proto.STATE_COMPLETE}, nil
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: &mock.SameJobFactory{Job: wJob},
+		RMClient:   rmc,
+		Workspaces: workspaces,
+	})
+	jr, err := rf.Make(proto.Job{Id: "wJob", Type: "jtype", Retry: 1}, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret := jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_COMPLETE {
+		t.Errorf("final state = %d, expected %d", ret.FinalState, proto.STATE_COMPLETE)
+	}
+	if len(wJob.Workspaces) != 2 || wJob.Workspaces[0].Dir != wJob.Workspaces[1].Dir {
+		t.Fatalf("got workspaces %+v, expected 2 with the same dir", wJob.Workspaces)
+	}
+	if len(jls) != 2 || !strings.Contains(jls[0].Error, "quota") {
+		t.Errorf("got job logs %+v, expected 2 with quota error in the first", jls)
+	}
+	if _, err := os.Stat(wJob.Workspaces[0].Dir); !os.IsNotExist(err) {
+		t.Errorf("workspace not removed after job done: %v", err)
+	}
+	if expect := filepath.Join(artifactsDir, "abc", "wJob", "report.txt"); artifact != expect {
+		t.Errorf("artifact %s, expected %s", artifact, expect)
+	}
+	if bytes, err := ioutil.ReadFile(artifact); err != nil || string(bytes) != "ok" {
+		t.Errorf("artifact %q (error %v), expected \"ok\"", bytes, err)
+	}
+
+	// Traverser removes all workspaces of the request when the chain is done
+	if err := os.MkdirAll(filepath.Join(tmpDir, "ws", "abc", "job2"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := workspaces.Remove("abc"); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "ws", "abc")); !os.IsNotExist(err) {
+		t.Errorf("request workspaces not removed: %v", err)
+	}
+}
+
+func TestRunAsserts(t *testing.T) {
+	var jl proto.JobLog
+	rmc := &mock.RMClient{
+		CreateJLFunc: func(reqId string, l proto.JobLog) error {
+			jl = l
+			return nil
+		},
+	}
+	mJob := &mock.Job{RunReturn: job.Return{State: proto.STATE_COMPLETE}}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: &mock.JobFactory{MockJobs: map[string]*mock.Job{"noop": mJob}},
+		RMClient:   rmc,
+	})
+	pJob := proto.Job{
+		Id:      "sink",
+		Type:    "noop",
+		Args:    map[string]interface{}{"hosts": []string{"h1", "h2"}},
+		Asserts: []string{"len(hosts) > 0", "len(failed_hosts) == 0"},
+	}
+
+	// Job data set by upstream jobs (failed_hosts) and jobArgs (hosts) are
+	// checked when the job completes
+	jobData := map[string]interface{}{"failed_hosts": []interface{}{}}
+	jr, err := rf.Make(pJob, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := jr.Run(jobData)
+	if ret.FinalState != proto.STATE_COMPLETE {
+		t.Errorf("final state = %s, expected COMPLETE (JL error: %s)", proto.StateName[ret.FinalState], jl.Error)
+	}
+
+	// Assertion not true: job fails like it failed itself, so the sequence
+	// is retried or the chain fails
+	jobData = map[string]interface{}{"failed_hosts": []interface{}{"h2"}}
+	jr, err = rf.Make(pJob, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret = jr.Run(jobData)
+	if ret.FinalState != proto.STATE_FAIL {
+		t.Errorf("final state = %s, expected FAIL", proto.StateName[ret.FinalState])
+	}
+	if jl.Error != "assertion failed: len(failed_hosts) == 0" {
+		t.Errorf("got JL error %q, expected assertion failed", jl.Error)
+	}
+
+	// Job arg used by an assertion not set: job fails
+	jr, err = rf.Make(pJob, runner.Request{Id: "abc", User: "finch"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret = jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_FAIL {
+		t.Errorf("final state = %s, expected FAIL", proto.StateName[ret.FinalState])
+	}
+	if !strings.Contains(jl.Error, "unknown jobArg failed_hosts") {
+		t.Errorf("got JL error %q, expected unknown jobArg", jl.Error)
+	}
+}
+
+func TestRunSingletonQueue(t *testing.T) {
+	defer func(d time.Duration) { runner.SingletonWait = d }(runner.SingletonWait)
+	runner.SingletonWait = 100 * time.Millisecond
+
+	ran := false
+	mJob := &mock.Job{
+		RunFunc: func(jobData map[string]interface{}) (job.Return, error) {
+			ran = true
+			return job.Return{State: proto.STATE_COMPLETE}, nil
+		},
+	}
+	pJob := proto.Job{
+		Id:        "j1",
+		Type:      "restart",
+		Singleton: "restart/host1",
+	}
+
+	// Lock held by another request for the first 2 tries
+	var mux sync.Mutex
+	tries := 0
+	var released proto.SingletonLock
+	rmc := &mock.RMClient{
+		AcquireLockFunc: func(l proto.SingletonLock) (proto.SingletonLock, error) {
+			mux.Lock()
+			defer mux.Unlock()
+			tries++
+			if tries <= 2 {
+				return proto.SingletonLock{Name: l.Name, RequestId: "other", JobId: "j9"}, nil
+			}
+			return l, nil
+		},
+		ReleaseLockFunc: func(l proto.SingletonLock) error {
+			released = l
+			return nil
+		},
+	}
+	jr := runner.NewRunner(pJob, mJob, "abc", 0, 0, rmc)
+
+	ret := jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_COMPLETE {
+		t.Errorf("final state = %s, expected STATE_COMPLETE", proto.StateName[ret.FinalState])
+	}
+	if !ran {
+		t.Error("job not run")
+	}
+	if tries != 3 {
+		t.Errorf("acquired lock on try %d, expected 3", tries)
+	}
+	expectLock := proto.SingletonLock{Name: "restart/host1", RequestId: "abc", JobId: "j1"}
+	if diff := deep.Equal(released, expectLock); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRunSingletonFail(t *testing.T) {
+	mJob := &mock.Job{
+		RunFunc: func(jobData map[string]interface{}) (job.Return, error) {
+			t.Error("job run, expected it to fail without running")
+			return job.Return{State: proto.STATE_COMPLETE}, nil
+		},
+	}
+	pJob := proto.Job{
+		Id:              "j1",
+		Type:            "restart",
+		Singleton:       "restart",
+		SingletonPolicy: proto.SINGLETON_POLICY_FAIL,
+	}
+	var jls []proto.JobLog
+	released := false
+	rmc := &mock.RMClient{
+		AcquireLockFunc: func(l proto.SingletonLock) (proto.SingletonLock, error) {
+			return proto.SingletonLock{Name: l.Name, RequestId: "other", JobId: "j9"}, nil
+		},
+		ReleaseLockFunc: func(l proto.SingletonLock) error {
+			released = true
+			return nil
+		},
+		CreateJLFunc: func(reqId string, jl proto.JobLog) error {
+			jls = append(jls, jl)
+			return nil
+		},
+	}
+	jr := runner.NewRunner(pJob, mJob, "abc", 0, 0, rmc)
+
+	ret := jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_FAIL {
+		t.Errorf("final state = %s, expected STATE_FAIL", proto.StateName[ret.FinalState])
+	}
+	if released {
+		t.Error("lock released, expected no release of lock not held")
+	}
+	if len(jls) != 1 {
+		t.Fatalf("got %d JLs, expected 1", len(jls))
+	}
+	if jls[0].State != proto.STATE_FAIL || !strings.Contains(jls[0].Error, "held by request other job j9") {
+		t.Errorf("got JL state %s error %q, expected STATE_FAIL and lock holder", proto.StateName[jls[0].State], jls[0].Error)
+	}
+}
+
+func TestRunFaults(t *testing.T) {
+	// The injector fails the job type, so the job does not run and the try
+	// fails with the injected error
+	ran := false
+	mJob := &mock.Job{
+		RunFunc: func(jobData map[string]interface{}) (job.Return, error) {
+			ran = true
+			return job.Return{State: proto.STATE_COMPLETE}, nil
+		},
+	}
+	var jl proto.JobLog
+	rmc := &mock.RMClient{
+		CreateJLFunc: func(reqId string, j proto.JobLog) error {
+			jl = j
+			return nil
+		},
+	}
+	injector := fault.NewInjector()
+	if err := injector.Set(proto.Faults{FailJobTypes: []string{"jtype"}}); err != nil {
+		t.Fatal(err)
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: &mock.JobFactory{MockJobs: map[string]*mock.Job{"jtype": mJob}},
+		RMClient:   rmc,
+		Faults:     injector,
+	})
+	jr, err := rf.Make(proto.Job{Id: "j1", Type: "jtype", Bytes: []byte{}}, runner.Request{Id: "abc"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := jr.Run(noJobData)
+	if ret.FinalState != proto.STATE_FAIL {
+		t.Errorf("final state = %s, expected STATE_FAIL", proto.StateName[ret.FinalState])
+	}
+	if ran {
+		t.Errorf("job ran, expected it not to run")
+	}
+	if jl.Error != "injected fault: job type jtype fails" {
+		t.Errorf("job log error = %q, expected injected fault", jl.Error)
+	}
+}
+
+func TestRunJobLogLimits(t *testing.T) {
+	var jl proto.JobLog
+	rmc := &mock.RMClient{
+		CreateJLFunc: func(reqId string, l proto.JobLog) error {
+			jl = l
+			return nil
+		},
+	}
+	stdout := strings.Repeat("a", 1024) + strings.Repeat("b", 1024) + strings.Repeat("c", 1024)
+	mJob := &mock.Job{RunReturn: job.Return{State: proto.STATE_COMPLETE, Stdout: stdout, Stderr: "err"}}
+	jf := &mock.JobFactory{MockJobs: map[string]*mock.Job{"jtype": mJob}}
+	pJob := proto.Job{Id: "j1", Type: "jtype"}
+
+	// Stdout larger than max is truncated to its head and tail, stderr is not
+	jll, err := runner.NewJobLogLimits(config.JobLogLimits{MaxStdoutKB: 2, MaxStderrKB: 2}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory:   jf,
+		RMClient:     rmc,
+		JobLogLimits: jll,
+	})
+	jr, err := rf.Make(pJob, runner.Request{Id: "abc"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jr.Run(noJobData)
+	expect := strings.Repeat("a", 1024) + "\n[... 1024 bytes omitted ...]\n" + strings.Repeat("c", 1024)
+	if jl.Stdout != expect {
+		t.Errorf("got stdout %q..., expected head and tail", jl.Stdout[1000:1100])
+	}
+	if jl.Stderr != "err" {
+		t.Errorf("got stderr %q, expected err", jl.Stderr)
+	}
+
+	// Spill saves full stdout in the artifacts dir
+	artifactsDir, err := ioutil.TempDir("", "spincycle-artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(artifactsDir)
+	_, err = runner.NewJobLogLimits(config.JobLogLimits{MaxStdoutKB: 2, Spill: true}, "")
+	if err == nil {
+		t.Error("no error with spill and no artifacts dir, expected one")
+	}
+	jll, err = runner.NewJobLogLimits(config.JobLogLimits{MaxStdoutKB: 2, Spill: true}, artifactsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf = runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory:   jf,
+		RMClient:     rmc,
+		JobLogLimits: jll,
+	})
+	jr, err = rf.Make(pJob, runner.Request{Id: "abc"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jr.Run(noJobData)
+	path := filepath.Join(artifactsDir, "abc", "j1", "job-log", "try-1.stdout")
+	if !strings.Contains(jl.Stdout, "full output: "+path) {
+		t.Errorf("stdout does not have spill path %s", path)
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bytes) != stdout {
+		t.Errorf("spilled stdout is %d bytes, expected %d", len(bytes), len(stdout))
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := runner.Truncate("short", 10, ""); got != "short" {
+		t.Errorf("got %q, expected short", got)
+	}
+	// Multi-byte characters are not cut: "é" is 2 bytes
+	got := runner.Truncate("éééééé", 5, "")
+	if got != "é\n[... 8 bytes omitted ...]\né" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2020, Square, Inc.
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"strconv"
+
+	"github.com/square/spincycle/v2/config"
+	"github.com/square/spincycle/v2/job"
+)
+
+// limitNames are the resource limits that a sandbox can set: prlimit(1) names.
+var limitNames = map[string]bool{
+	"as":      true,
+	"core":    true,
+	"cpu":     true,
+	"data":    true,
+	"fsize":   true,
+	"locks":   true,
+	"memlock": true,
+	"nofile":  true,
+	"nproc":   true,
+	"rss":     true,
+	"stack":   true,
+}
+
+// NewSandboxes validates the sandboxes config and returns the sandbox for each
+// sandboxed job type. The Dir of each sandbox is the directory in which a private
+// work dir is made for every try of a job.
+func NewSandboxes(cfg []config.Sandbox) (map[string]job.Sandbox, error) {
+	sandboxes := map[string]job.Sandbox{}
+	for i, c := range cfg {
+		if len(c.Types) == 0 {
+			return nil, fmt.Errorf("sandbox %d: no job types", i)
+		}
+
+		sb := job.Sandbox{
+			Dir:    c.WorkDir,
+			Limits: c.Limits,
+		}
+
+		if c.User != "" {
+			if runtime.GOOS == "windows" {
+				return nil, fmt.Errorf("sandbox %d: user is not supported on Windows", i)
+			}
+			u, err := user.Lookup(c.User)
+			if err != nil {
+				return nil, fmt.Errorf("sandbox %d: %s", i, err)
+			}
+			uid, err := strconv.ParseUint(u.Uid, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("sandbox %d: user %s: invalid uid %s", i, c.User, u.Uid)
+			}
+			gid, err := strconv.ParseUint(u.Gid, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("sandbox %d: user %s: invalid gid %s", i, c.User, u.Gid)
+			}
+			if os.Geteuid() != 0 && int(uid) != os.Geteuid() {
+				return nil, fmt.Errorf("sandbox %d: Job Runner must run as root to run jobs as user %s", i, c.User)
+			}
+			sb.User = c.User
+			sb.Uid = uint32(uid)
+			sb.Gid = uint32(gid)
+		}
+
+		if sb.Dir == "" {
+			sb.Dir = os.TempDir()
+		}
+		if fi, err := os.Stat(sb.Dir); err != nil {
+			return nil, fmt.Errorf("sandbox %d: work_dir: %s", i, err)
+		} else if !fi.IsDir() {
+			return nil, fmt.Errorf("sandbox %d: work_dir: %s is not a directory", i, sb.Dir)
+		}
+
+		if len(c.Limits) > 0 {
+			if runtime.GOOS != "linux" {
+				return nil, fmt.Errorf("sandbox %d: limits require Linux", i)
+			}
+			for name := range c.Limits {
+				if !limitNames[name] {
+					return nil, fmt.Errorf("sandbox %d: invalid limit: %s", i, name)
+				}
+			}
+			if _, err := exec.LookPath("prlimit"); err != nil {
+				return nil, fmt.Errorf("sandbox %d: limits require prlimit: %s", i, err)
+			}
+		}
+
+		for _, t := range c.Types {
+			if _, ok := sandboxes[t]; ok {
+				return nil, fmt.Errorf("sandbox %d: job type %s is in another sandbox", i, t)
+			}
+			sandboxes[t] = sb
+		}
+	}
+	return sandboxes, nil
+}
@@ -0,0 +1,246 @@
+// Copyright 2020, Square, Inc.
+
+package runner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/spincycle/v2/config"
+	"github.com/square/spincycle/v2/job"
+)
+
+// WorkspaceCheckInterval is how often the size of a workspace with a quota is
+// checked while its job runs.
+var WorkspaceCheckInterval = 10 * time.Second
+
+// Workspaces makes and removes job workspaces, see job.UsesWorkspace. A workspace
+// is a directory per request and job: <dir>/<request ID>/<job ID>. It's made on
+// the first try of the job, removed by the runner when the job is done running,
+// and removed by the traverser when the chain is done, which removes workspaces
+// of jobs that the runner could not remove. It's safe for concurrent use.
+type Workspaces struct {
+	dir          string
+	quota        uint64 // bytes, 0 = no quota
+	artifactsDir string // empty = promoting disabled
+}
+
+// NewWorkspaces makes Workspaces from the workspaces config, creating the
+// workspaces and artifacts dirs if they do not exist.
+func NewWorkspaces(cfg config.Workspaces) (*Workspaces, error) {
+	w := &Workspaces{
+		dir:          cfg.Dir,
+		quota:        cfg.QuotaMB * 1024 * 1024,
+		artifactsDir: cfg.ArtifactsDir,
+	}
+	if w.dir == "" {
+		w.dir = filepath.Join(os.TempDir(), "spincycle-workspaces")
+	}
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return nil, fmt.Errorf("dir: %s", err)
+	}
+	if w.artifactsDir != "" {
+		if err := os.MkdirAll(w.artifactsDir, 0755); err != nil {
+			return nil, fmt.Errorf("artifacts_dir: %s", err)
+		}
+	}
+	return w, nil
+}
+
+// Remove removes the workspaces of all jobs of the request. It's not an error if
+// there are none. Remove on a nil Workspaces does nothing.
+func (w *Workspaces) Remove(requestId string) error {
+	if w == nil {
+		return nil
+	}
+	if !validName(requestId) {
+		return fmt.Errorf("invalid request ID %q", requestId)
+	}
+	if err := os.RemoveAll(filepath.Join(w.dir, requestId)); err != nil {
+		return fmt.Errorf("cannot remove workspaces: %s", err)
+	}
+	return nil
+}
+
+// make makes the workspace of the job if it does not exist. If the job is
+// sandboxed, the workspace is owned by the sandbox user.
+func (w *Workspaces) make(requestId, jobId string, sb *job.Sandbox) (job.Workspace, error) {
+	if !validName(requestId) {
+		return job.Workspace{}, fmt.Errorf("invalid request ID %q", requestId)
+	}
+	if !validName(jobId) {
+		return job.Workspace{}, fmt.Errorf("invalid job ID %q", jobId)
+	}
+	reqDir := filepath.Join(w.dir, requestId)
+	if err := os.MkdirAll(reqDir, 0755); err != nil {
+		return job.Workspace{}, err
+	}
+	dir := filepath.Join(reqDir, jobId)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return job.Workspace{}, err
+	}
+	if sb != nil && sb.User != "" {
+		if err := os.Chown(dir, int(sb.Uid), int(sb.Gid)); err != nil {
+			return job.Workspace{}, fmt.Errorf("cannot change owner to %s: %s", sb.User, err)
+		}
+	}
+	return job.Workspace{
+		Dir:   dir,
+		Quota: w.quota,
+		Promote: func(path string) (string, error) {
+			return w.promote(requestId, jobId, dir, path)
+		},
+	}, nil
+}
+
+// remove removes the workspace of the job.
+func (w *Workspaces) remove(requestId, jobId string) error {
+	if err := os.RemoveAll(filepath.Join(w.dir, requestId, jobId)); err != nil {
+		return fmt.Errorf("cannot remove workspace: %s", err)
+	}
+	return nil
+}
+
+// promote moves the file or dir at the path, relative to the workspace dir, to
+// the same path in the artifacts dir of the job: <artifacts_dir>/<request ID>/<job ID>.
+func (w *Workspaces) promote(requestId, jobId, dir, path string) (string, error) {
+	if w.artifactsDir == "" {
+		return "", fmt.Errorf("cannot promote %s: artifacts not enabled (workspaces.artifacts_dir)", path)
+	}
+	rel := filepath.Clean(path)
+	if filepath.IsAbs(rel) || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("cannot promote %s: path must be relative to and in the workspace", path)
+	}
+	src := filepath.Join(dir, rel)
+	dst := filepath.Join(w.artifactsDir, requestId, jobId, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", fmt.Errorf("cannot promote %s: %s", path, err)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		// Artifacts dir can be on another file system, so copy and remove
+		if err := copyPath(src, dst); err != nil {
+			return "", fmt.Errorf("cannot promote %s: %s", path, err)
+		}
+		if err := os.RemoveAll(src); err != nil {
+			return "", fmt.Errorf("cannot promote %s: %s", path, err)
+		}
+	}
+	return dst, nil
+}
+
+// watchWorkspace checks the size of the workspace every WorkspaceCheckInterval
+// and calls stop if it's larger than the quota. The returned func stops watching
+// and returns an error if the workspace is larger than the quota.
+func watchWorkspace(ws job.Workspace, stop func(error)) func() error {
+	doneChan := make(chan struct{})
+	var over error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(WorkspaceCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-doneChan:
+				return
+			}
+			if err := checkQuota(ws); err != nil {
+				over = err
+				stop(err)
+				return
+			}
+		}
+	}()
+	return func() error {
+		close(doneChan)
+		wg.Wait()
+		if over != nil {
+			return over
+		}
+		return checkQuota(ws)
+	}
+}
+
+// checkQuota returns an error if the workspace is larger than its quota.
+func checkQuota(ws job.Workspace) error {
+	if ws.Quota == 0 {
+		return nil
+	}
+	size, err := dirSize(ws.Dir)
+	if err != nil {
+		return fmt.Errorf("cannot get workspace size: %s", err)
+	}
+	if size > ws.Quota {
+		return fmt.Errorf("workspace size %d bytes exceeds quota %d bytes", size, ws.Quota)
+	}
+	return nil
+}
+
+// dirSize returns the total size of all regular files in the dir.
+func dirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // removed by job while walking
+			}
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += uint64(fi.Size())
+		}
+		return nil
+	})
+	return size, err
+}
+
+// copyPath copies the file or dir src to dst, which must not exist.
+func copyPath(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm())
+		case fi.Mode().IsRegular():
+			return copyFile(path, target, fi.Mode().Perm())
+		default:
+			return nil // skip symlinks, devices, etc.
+		}
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// validName returns true if the request or job ID can be used as a dir name.
+func validName(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\.`)
+}
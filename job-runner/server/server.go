@@ -6,36 +6,55 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
-	"os/signal"
+	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/orcaman/concurrent-map"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/square/spincycle/v2/config"
+	"github.com/square/spincycle/v2/gate"
 	"github.com/square/spincycle/v2/job-runner/api"
 	"github.com/square/spincycle/v2/job-runner/app"
 	"github.com/square/spincycle/v2/job-runner/chain"
+	"github.com/square/spincycle/v2/job-runner/checkpoint"
+	"github.com/square/spincycle/v2/job-runner/fault"
+	"github.com/square/spincycle/v2/job-runner/outbox"
+	"github.com/square/spincycle/v2/job-runner/profile"
 	"github.com/square/spincycle/v2/job-runner/runner"
 	"github.com/square/spincycle/v2/job-runner/status"
 	"github.com/square/spincycle/v2/jobs"
+	"github.com/square/spincycle/v2/metrics"
+	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/request-manager"
+	"github.com/square/spincycle/v2/shutdown"
+	"github.com/square/spincycle/v2/validate"
 )
 
 type Server struct {
 	appCtx        app.Context
 	api           *api.API
 	traverserRepo cmap.ConcurrentMap
+	trFactory     chain.TraverserFactory
 	chainRepo     chain.Repo
+	retainer      *chain.Retainer
 	rmc           rm.Client
-
-	shutdownChan chan struct{}
-	apiStopped   chan struct{}
-	stopMux      sync.Mutex
-	stopped      bool
+	metrics       metrics.Metrics
+	heartbeat     *status.Heartbeat // nil if registration disabled
+	heartbeatFreq time.Duration
+	finishedJobs  *status.FinishedJobs
+	progressFreq  time.Duration
+	recovery      *checkpoint.Recovery // nil if checkpointing disabled
+	outbox        *outbox.RMClient     // nil if outbox disabled
+
+	shutdownChan    chan struct{}
+	apiStopped      chan struct{}
+	shutdownSignals []os.Signal // OS signals that call Stop if Run(true)
+	stopMux         sync.Mutex
+	stopped         bool
 }
 
 func NewServer(appCtx app.Context) *Server {
@@ -52,9 +71,10 @@ func NewServer(appCtx app.Context) *Server {
 // hook has been provided, it will be called to run the API instead of the default
 // api.Run.
 //
-// If stopOnSignal = true, the server will listen for TERM and INT signals from the
-// OS and call Stop to shut itself down when those signals are received. Else, the
-// caller must call Stop to shut down the server.
+// If stopOnSignal = true, the server will listen for shutdown signals from the OS
+// (config server.shutdown_signals, default TERM and INT) and call Stop to shut
+// itself down when those signals are received. Else, the caller must call Stop
+// to shut down the server, e.g. from a Windows service control handler.
 func (s *Server) Run(stopOnSignal bool) error {
 	if s.api == nil {
 		panic("Server.Run called before Server.Boot")
@@ -63,32 +83,63 @@ func (s *Server) Run(stopOnSignal bool) error {
 		return fmt.Errorf("server stopped")
 	}
 
-	// If stopOnSignal = true, watch for TERM + INT signals from the OS and shut
+	// If stopOnSignal = true, watch for shutdown signals from the OS and shut
 	// down the Job Runner when we receive them.
 	if stopOnSignal {
 		go s.waitForShutdown()
 	}
 
-	// Every second, send updated finished jobs counts for all running chains.
-	// This is best effort, so no error handling or logger here. When a chain
-	// completes, its final finished jobs count is sent with FinishRequest.
+	// Every progress.interval, send changed finished jobs counts for all running
+	// chains. This is best effort, so no error handling or logger here. When a
+	// chain completes, its final finished jobs count is sent with FinishRequest.
+	// The number of running chains is reported, and done chains retained longer
+	// than chain_retention are removed, at the same time.
 	go func() {
-		finishedJobs := status.FinishedJobs{
-			ChainRepo: s.chainRepo,
-			RMC:       s.rmc,
-		}
-		ticker := time.NewTicker(1 * time.Second)
+		ticker := time.NewTicker(s.progressFreq)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				finishedJobs.Update()
+				s.finishedJobs.Update()
+				s.metrics.Gauge(metrics.CHAINS_RUNNING, float64(s.traverserRepo.Count()), nil)
+				s.retainer.GC(time.Now())
 			case <-s.shutdownChan:
 				return
 			}
 		}
 	}()
 
+	// If registration is enabled, register with the RM now and send heartbeats
+	// until shutdown. The RM sends job chains to registered JRs, and recovers
+	// requests from JRs that stop sending heartbeats.
+	if s.heartbeat != nil {
+		go func() {
+			s.heartbeat.Send()
+			ticker := time.NewTicker(s.heartbeatFreq)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					s.heartbeat.Send()
+				case <-s.shutdownChan:
+					return
+				}
+			}
+		}()
+	}
+
+	// If the outbox is enabled, send reports queued by the last run and reports
+	// queued while the RM is unreachable until shutdown
+	if s.outbox != nil {
+		go s.outbox.Run()
+	}
+
+	// If checkpointing is enabled, recover the job chains that were running
+	// when this JR crashed before running the API, which receives new chains
+	if s.recovery != nil {
+		s.recovery.Run()
+	}
+
 	// Run the API - this will block until the API is stopped (or encounters
 	// some fatal error). If the RunAPI hook has been provided, call that instead
 	// of the default api.Run.
@@ -111,6 +162,82 @@ func (s *Server) Run(stopOnSignal bool) error {
 	return nil
 }
 
+// loadConfig loads the config with the LoadConfig hook, overrides it with env
+// vars, and validates it.
+func (s *Server) loadConfig() (config.JobRunner, error) {
+	cfg, err := s.appCtx.Hooks.LoadConfig(s.appCtx)
+	if err != nil {
+		return cfg, fmt.Errorf("error loading config: %s", err)
+	}
+	// Override with env vars, if set
+	cfg.Server.Addr = config.Env("SPINCYCLE_SERVER_ADDR", cfg.Server.Addr)
+	cfg.Server.TLS.CertFile = config.Env("SPINCYCLE_SERVER_TLS_CERT_FILE", cfg.Server.TLS.CertFile)
+	cfg.Server.TLS.KeyFile = config.Env("SPINCYCLE_SERVER_TLS_KEY_FILE", cfg.Server.TLS.KeyFile)
+	cfg.Server.TLS.CAFile = config.Env("SPINCYCLE_SERVER_TLS_CA_FILE", cfg.Server.TLS.CAFile)
+	if sigs := config.Env("SPINCYCLE_SERVER_SHUTDOWN_SIGNALS", ""); sigs != "" {
+		cfg.Server.ShutdownSignals = strings.Split(sigs, ",")
+	}
+	cfg.RMClient.ServerURL = config.Env("SPINCYCLE_RM_CLIENT_URL", cfg.RMClient.ServerURL)
+	cfg.RMClient.TLS.CertFile = config.Env("SPINCYCLE_RM_CLIENT_TLS_CERT_FILE", cfg.RMClient.TLS.CertFile)
+	cfg.RMClient.TLS.KeyFile = config.Env("SPINCYCLE_RM_CLIENT_TLS_KEY_FILE", cfg.RMClient.TLS.KeyFile)
+	cfg.RMClient.TLS.CAFile = config.Env("SPINCYCLE_RM_CLIENT_TLS_CA_FILE", cfg.RMClient.TLS.CAFile)
+	cfg.RMClient.TLS.ServerName = config.Env("SPINCYCLE_RM_CLIENT_TLS_SERVER_NAME", cfg.RMClient.TLS.ServerName)
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config:\n%s", err)
+	}
+	if _, err := shutdown.Signals(cfg.Server.ShutdownSignals); err != nil {
+		return cfg, fmt.Errorf("invalid config:\nserver.shutdown_signals: %s", err)
+	}
+	if _, err := runner.NewSandboxes(cfg.Sandboxes); err != nil {
+		return cfg, fmt.Errorf("invalid config:\nsandboxes: %s", err)
+	}
+	if _, err := runner.NewWorkspaces(cfg.Workspaces); err != nil {
+		return cfg, fmt.Errorf("invalid config:\nworkspaces.%s", err)
+	}
+	if _, err := runner.NewJobLogLimits(cfg.JobLog, cfg.Workspaces.ArtifactsDir); err != nil {
+		return cfg, fmt.Errorf("invalid config:\njob_log.%s", err)
+	}
+	return cfg, nil
+}
+
+// serverURL returns the URL that this JR reports to the RM from the ServerURL
+// hook. The RM connects to it, so it must have a host: a server.addr like
+// ":32307" is valid for listening but not for the RM.
+func (s *Server) serverURL() (string, error) {
+	baseURL, err := s.appCtx.Hooks.ServerURL(s.appCtx)
+	if err != nil {
+		return "", fmt.Errorf("error getting base server URL: %s", err)
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("invalid base server URL %q: no host; set server.addr to the address of this Job Runner", baseURL)
+	}
+	return baseURL, nil
+}
+
+// Validate loads and validates the config, then checks that the Request Manager
+// in the config is reachable. It does not boot or run the server. It's used to
+// check a config before deploying it (--validate-only).
+func (s *Server) Validate() error {
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return err
+	}
+	s.appCtx.Config = cfg
+
+	var errs []string
+	if _, err := s.serverURL(); err != nil {
+		errs = append(errs, "server.addr: "+err.Error())
+	}
+	if err := config.CheckAddr(cfg.RMClient.ServerURL, 5*time.Second); err != nil {
+		errs = append(errs, fmt.Sprintf("rm_client.url: cannot connect: %s", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
 // Boot sets up the server. It must be called before calling Run.
 func (s *Server) Boot() error {
 	// Only run Boot once.
@@ -125,21 +252,15 @@ func (s *Server) Boot() error {
 		return fmt.Errorf("Only one of RunAPI and StopAPI hooks provided - either both or neither must be provided.")
 	}
 
-	// Load config file
-	cfg, err := s.appCtx.Hooks.LoadConfig(s.appCtx)
+	cfg, err := s.loadConfig()
 	if err != nil {
-		return fmt.Errorf("error loading config: %s", err)
+		return err
 	}
-	// Override with env vars, if set
-	cfg.Server.Addr = config.Env("SPINCYCLE_SERVER_ADDR", cfg.Server.Addr)
-	cfg.Server.TLS.CertFile = config.Env("SPINCYCLE_SERVER_TLS_CERT_FILE", cfg.Server.TLS.CertFile)
-	cfg.Server.TLS.KeyFile = config.Env("SPINCYCLE_SERVER_TLS_KEY_FILE", cfg.Server.TLS.KeyFile)
-	cfg.Server.TLS.CAFile = config.Env("SPINCYCLE_SERVER_TLS_CA_FILE", cfg.Server.TLS.CAFile)
-	cfg.RMClient.ServerURL = config.Env("SPINCYCLE_RM_CLIENT_URL", cfg.RMClient.ServerURL)
-	cfg.RMClient.TLS.CertFile = config.Env("SPINCYCLE_RM_CLIENT_TLS_CERT_FILE", cfg.RMClient.TLS.CertFile)
-	cfg.RMClient.TLS.KeyFile = config.Env("SPINCYCLE_RM_CLIENT_TLS_KEY_FILE", cfg.RMClient.TLS.KeyFile)
-	cfg.RMClient.TLS.CAFile = config.Env("SPINCYCLE_RM_CLIENT_TLS_CA_FILE", cfg.RMClient.TLS.CAFile)
 	s.appCtx.Config = cfg
+	s.shutdownSignals, err = shutdown.Signals(cfg.Server.ShutdownSignals)
+	if err != nil {
+		return fmt.Errorf("error loading config: server.shutdown_signals: %s", err)
+	}
 	cfgstr, _ := json.MarshalIndent(cfg, "", "  ")
 	log.Printf("Config: %s", cfgstr)
 
@@ -149,6 +270,32 @@ func (s *Server) Boot() error {
 	if err != nil {
 		return fmt.Errorf("MakeRequestManagerClient: %s", err)
 	}
+
+	// Fault injection (chaos testing only): failures are set by PUT faults,
+	// none until then. RM calls are dropped by wrapping the RM client.
+	var faults runner.Faults
+	var injector *fault.Injector
+	if cfg.FaultInjection {
+		log.Warnf("Fault injection enabled: do not use in production")
+		injector = fault.NewInjector()
+		faults = injector
+		rmc = fault.NewRMClient(rmc, injector)
+	}
+
+	s.metrics = s.appCtx.Plugins.Metrics
+	if s.metrics == nil {
+		s.metrics = metrics.Nop{}
+	}
+
+	// Outbox (optional) queues job logs and final chain reports on local disk
+	// while the RM is unreachable, and sends them in Run when it's back
+	if cfg.OutboxDir != "" {
+		s.outbox, err = outbox.NewRMClient(rmc, cfg.OutboxDir, s.metrics)
+		if err != nil {
+			return fmt.Errorf("error loading config: outbox_dir: %s", err)
+		}
+		rmc = s.outbox
+	}
 	s.rmc = rmc
 
 	// Chain repo holds running job chains in memory. It's primarily used by
@@ -157,13 +304,81 @@ func (s *Server) Boot() error {
 	s.chainRepo = chain.NewMemoryRepo()
 
 	// Runner Factory makes a job.Runner to run one job. It's used by chain.Traversers
-	// to run jobs.
-	rf := runner.NewFactory(jobs.Factory, rmc)
+	// to run jobs. The job factory makes built-in gate jobs, which wait in gates
+	// for approval via the API, and all other jobs with the jobs package factory.
+	// The token provider plugin (optional) gives jobs delegated tokens, sandboxes
+	// (optional) restrict the processes that untrusted jobs run, and workspaces
+	// are scratch dirs for jobs.
+	sandboxes, err := runner.NewSandboxes(cfg.Sandboxes)
+	if err != nil {
+		return fmt.Errorf("error loading config: sandboxes: %s", err)
+	}
+	workspaces, err := runner.NewWorkspaces(cfg.Workspaces)
+	if err != nil {
+		return fmt.Errorf("error loading config: workspaces.%s", err)
+	}
+	jobLogLimits, err := runner.NewJobLogLimits(cfg.JobLog, cfg.Workspaces.ArtifactsDir)
+	if err != nil {
+		return fmt.Errorf("error loading config: job_log.%s", err)
+	}
+	gates := gate.NewGates()
+	jf := gate.NewFactory(jobs.Factory, gates)
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory:    jf,
+		RMClient:      rmc,
+		TokenProvider: s.appCtx.Plugins.TokenProvider,
+		Sandboxes:     sandboxes,
+		Workspaces:    workspaces,
+		Faults:        faults,
+		JobLogLimits:  jobLogLimits,
+	})
+
+	// Chain retainer keeps the status of done chains for chain_retention, then
+	// they're removed (GC) in Run
+	if cfg.ChainRetention == "" {
+		cfg.ChainRetention = config.DEFAULT_CHAIN_RETENTION
+	}
+	retention, err := time.ParseDuration(cfg.ChainRetention)
+	if err != nil || retention <= 0 {
+		return fmt.Errorf("error loading config: chain_retention: invalid duration %q", cfg.ChainRetention)
+	}
+	s.retainer = chain.NewRetainer(retention, s.metrics)
+
+	// Checkpoint store (optional) saves running chains on local disk so they
+	// can be recovered in Run if this JR crashes
+	var store *checkpoint.Store
+	var checkpointer chain.Checkpointer
+	if cfg.CheckpointDir != "" {
+		store, err = checkpoint.NewStore(cfg.CheckpointDir)
+		if err != nil {
+			return fmt.Errorf("error loading config: checkpoint_dir: %s", err)
+		}
+		checkpointer = store
+	}
+
+	// Slow job watchdog (optional) warns about jobs running longer than expected
+	watchdog, err := chain.NewWatchdog(cfg.SlowJobs, s.metrics)
+	if err != nil {
+		return fmt.Errorf("error loading config: %s", err)
+	}
 
 	// Traverser Factory is used by API to make a new chain.Traverser to run a
 	// job chain. These are stored in a Traverser Repo (just a map) so API can
 	// keep track of what's running.
-	trFactory := chain.NewTraverserFactory(s.chainRepo, rf, rmc, s.shutdownChan)
+	trFactory := chain.NewTraverserFactory(chain.TraverserFactoryConfig{
+		ChainRepo:     s.chainRepo,
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  s.shutdownChan,
+		Metrics:       s.metrics,
+		Retainer:      s.retainer,
+		Checkpointer:  checkpointer,
+		Watchdog:      watchdog,
+		Workspaces:    workspaces,
+		QueueFactory:  s.appCtx.Plugins.MakeJobQueue,
+		Concurrency:   cfg.Concurrency,
+	})
+	s.trFactory = trFactory
 	s.traverserRepo = cmap.New()
 
 	// Status Manager reports what's happening in the JR
@@ -172,9 +387,16 @@ func (s *Server) Boot() error {
 	// Base URL is what this JR reports itself as, e.g. https://spin-jr.prod.local:32307
 	// The RM saves this so it knows which JR to query to get the status of a
 	// given request.
-	baseURL, err := s.appCtx.Hooks.ServerURL(s.appCtx)
+	baseURL, err := s.serverURL()
 	if err != nil {
-		return fmt.Errorf("error getting base server URL: %s", err)
+		return err
+	}
+
+	// Profiling (optional): pprof endpoints, and profiles captured while
+	// a request runs, which are sent to the RM
+	var profiler *profile.Capturer
+	if cfg.Profiling {
+		profiler = profile.NewCapturer(rmc)
 	}
 
 	// The API instance
@@ -185,9 +407,62 @@ func (s *Server) Boot() error {
 		StatusManager:    stat,
 		ShutdownChan:     s.shutdownChan,
 		BaseURL:          baseURL,
+		JobFactory:       jf,
+		Gates:            gates,
+		Capabilities:     cfg.Registration.Capabilities,
+		Faults:           injector,
+		ChainRepo:        s.chainRepo,
+		Retainer:         s.retainer,
+		Profiler:         profiler,
 	}
 	s.api = api.NewAPI(apiCfg)
 
+	if store != nil {
+		s.recovery = &checkpoint.Recovery{
+			Store:        store,
+			RMClient:     rmc,
+			JobRunnerURL: baseURL,
+			Resume:       s.resume,
+		}
+	}
+
+	// Progress (finished jobs counts) is sent in Run
+	if cfg.Progress.Interval == "" {
+		cfg.Progress.Interval = config.DEFAULT_PROGRESS_INTERVAL
+	}
+	s.progressFreq, err = time.ParseDuration(cfg.Progress.Interval)
+	if err != nil || s.progressFreq <= 0 {
+		return fmt.Errorf("error loading config: progress.interval: invalid duration %q", cfg.Progress.Interval)
+	}
+	s.finishedJobs = &status.FinishedJobs{
+		ChainRepo: s.chainRepo,
+		RMC:       rmc,
+		Batch:     cfg.Progress.Batch,
+		BatchSize: cfg.Progress.BatchSize,
+	}
+
+	// Registration with the RM, if enabled: heartbeats are sent in Run
+	if cfg.Registration.Enabled {
+		if cfg.Registration.Interval == "" {
+			cfg.Registration.Interval = config.DEFAULT_HEARTBEAT_INTERVAL
+		}
+		s.heartbeatFreq, err = time.ParseDuration(cfg.Registration.Interval)
+		if err != nil || s.heartbeatFreq <= 0 {
+			return fmt.Errorf("error loading config: registration.interval: invalid duration %q", cfg.Registration.Interval)
+		}
+		s.heartbeat = &status.Heartbeat{
+			ChainRepo: s.chainRepo,
+			RMC:       rmc,
+			JobRunner: proto.JobRunner{
+				URL:          baseURL,
+				Labels:       cfg.Registration.Labels,
+				Capabilities: cfg.Registration.Capabilities,
+				Capacity:     cfg.Registration.Capacity,
+				StartedAt:    time.Now().UTC(),
+			},
+		}
+	}
+
 	return nil
 }
 
@@ -197,7 +472,7 @@ func (s *Server) Boot() error {
 // to Run will return an error.
 //
 // If stopOnSignal was set when calling Run, Stop will automatically be called by
-// the server on receiving a TERM or INT signal from the OS. Otherwise, you must
+// the server on receiving a shutdown signal from the OS. Otherwise, you must
 // call Stop when you want to shut down the Job Runner.
 func (s *Server) Stop() error {
 	// Only stop once. We lock the whole Stop call, so that, if Stop is called
@@ -229,6 +504,20 @@ WAIT_FOR_TRAVERSERS:
 		}
 	}
 
+	// Stop sending queued reports after traversers suspended their chains, so
+	// reports queued by suspending are saved and sent on the next start
+	if s.outbox != nil {
+		s.outbox.Stop()
+	}
+
+	// Deregister after traversers suspended their chains, so the RM stops
+	// sending job chains to this JR and does not presume it dead
+	if s.heartbeat != nil {
+		if err := s.rmc.Deregister(s.heartbeat.JobRunner.URL); err != nil {
+			log.Errorf("error deregistering from Request Manager: %s", err)
+		}
+	}
+
 	// Stop the API, using the StopAPI hook if provided and api.Stop otherwise.
 	var err error
 	if s.appCtx.Hooks.StopAPI != nil {
@@ -251,12 +540,30 @@ func (s *Server) API() *api.API {
 
 // --------------------------------------------------------------------------
 
-// Catch TERM and INT signals to gracefully shut down the Job Runner
-func (s *Server) waitForShutdown() {
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+// resume runs a job chain recovered from a checkpoint, like the API does for a
+// suspended job chain sent by the RM.
+func (s *Server) resume(sjc *proto.SuspendedJobChain) error {
+	if err := validate.SJC(*sjc); err != nil {
+		return err
+	}
+	t, err := s.trFactory.MakeFromSJC(sjc)
+	if err != nil {
+		return err
+	}
+	if !s.traverserRepo.SetIfAbsent(sjc.RequestId, t) {
+		return api.ErrDuplicateTraverser
+	}
+	go func() {
+		defer s.traverserRepo.Remove(sjc.RequestId)
+		t.Run()
+	}()
+	return nil
+}
 
-	<-sigChan
+// Catch shutdown signals (default: TERM and INT) to gracefully shut down the Job Runner
+func (s *Server) waitForShutdown() {
+	sig := shutdown.Wait(s.shutdownSignals)
+	log.Infof("received %s signal, shutting down", sig)
 
 	err := s.Stop()
 	if err != nil {
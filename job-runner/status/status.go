@@ -4,6 +4,9 @@
 package status
 
 import (
+	"runtime"
+	"sort"
+
 	"github.com/orcaman/concurrent-map"
 	log "github.com/sirupsen/logrus"
 
@@ -15,6 +18,8 @@ import (
 
 type Manager interface {
 	Running(proto.StatusFilter) ([]proto.JobStatus, error)
+	Scheduling(proto.StatusFilter) (proto.SchedulingStatus, error)
+	Debug(proto.StatusFilter) (proto.RunnerDebug, error)
 }
 
 type manager struct {
@@ -29,57 +34,186 @@ func NewManager(traverserRepo cmap.ConcurrentMap) *manager {
 }
 
 func (m *manager) Running(f proto.StatusFilter) ([]proto.JobStatus, error) {
-	var traversers []chain.Traverser
-
-	// If filter by request ID, get only that request; else, get all
-	if f.RequestId == "" {
-		items := m.traverserRepo.Items() // returns map[reqId]interface{}
-		traversers = make([]chain.Traverser, 0, len(items))
-		for _, v := range items {
-			traversers = append(traversers, v.(chain.Traverser))
-		}
-	} else {
-		v, ok := m.traverserRepo.Get(f.RequestId) // returns interface{}
-		if !ok {
-			return nil, serr.RequestNotFound{f.RequestId}
-		}
-		traversers = []chain.Traverser{v.(chain.Traverser)}
+	traversers, err := m.traversers(f)
+	if err != nil {
+		return nil, err
 	}
 
-	// Get currently running jobs in each traverser/chain
+	// Get currently running jobs in each traverser/chain, and held jobs
+	// if the filter asks for them
 	running := []proto.JobStatus{}
 	for _, tr := range traversers {
 		status := tr.Running()
 		running = append(running, status...)
+		if f.Held {
+			running = append(running, tr.Held()...)
+		}
 	}
 
 	return running, nil
 }
 
+// Scheduling returns scheduling latency stats for each running chain and,
+// aggregated, for the Job Runner. The Job Runner aggregate is only for chains
+// that are currently running (or filtered by request ID).
+func (m *manager) Scheduling(f proto.StatusFilter) (proto.SchedulingStatus, error) {
+	traversers, err := m.traversers(f)
+	if err != nil {
+		return proto.SchedulingStatus{}, err
+	}
+
+	status := proto.SchedulingStatus{
+		Chains: make([]proto.SchedulingStats, 0, len(traversers)),
+	}
+	jr := &status.JobRunner
+	for _, tr := range traversers {
+		ss := tr.Scheduling()
+		status.Chains = append(status.Chains, ss)
+		jr.Queued += ss.Queued
+		jr.Started += ss.Started
+		jr.WaitTotal += ss.WaitTotal
+		if ss.WaitMax > jr.WaitMax {
+			jr.WaitMax = ss.WaitMax
+		}
+	}
+	if jr.Started > 0 {
+		jr.WaitAvg = jr.WaitTotal / int64(jr.Started)
+	}
+	return status, nil
+}
+
+// Debug returns goroutine and job runner accounting for each running chain (or
+// the chain filtered by request ID), and the number of goroutines in the process.
+func (m *manager) Debug(f proto.StatusFilter) (proto.RunnerDebug, error) {
+	traversers, err := m.traversers(f)
+	if err != nil {
+		return proto.RunnerDebug{}, err
+	}
+
+	debug := proto.RunnerDebug{
+		Goroutines: runtime.NumGoroutine(),
+		Chains:     make([]proto.ChainDebug, 0, len(traversers)),
+	}
+	for _, tr := range traversers {
+		cd := tr.Debug()
+		debug.Chains = append(debug.Chains, cd)
+		debug.Runners += cd.Runners
+	}
+	sort.Slice(debug.Chains, func(i, j int) bool { return debug.Chains[i].RequestId < debug.Chains[j].RequestId })
+	return debug, nil
+}
+
+// traversers returns the traverser for the filter request ID, or all traversers
+// if no request ID.
+func (m *manager) traversers(f proto.StatusFilter) ([]chain.Traverser, error) {
+	if f.RequestId != "" {
+		v, ok := m.traverserRepo.Get(f.RequestId) // returns interface{}
+		if !ok {
+			return nil, serr.RequestNotFound{f.RequestId}
+		}
+		return []chain.Traverser{v.(chain.Traverser)}, nil
+	}
+	items := m.traverserRepo.Items() // returns map[reqId]interface{}
+	traversers := make([]chain.Traverser, 0, len(items))
+	for _, v := range items {
+		traversers = append(traversers, v.(chain.Traverser))
+	}
+	return traversers, nil
+}
+
 // --------------------------------------------------------------------------
 
-// FinishedJobs sends updated finished jobs counts to the Request Manager.
-// This is a singleton service that's ran in Server.Run(). Updates are best-effort.
-// The final finished jobs count for a chain is sent with FinishRequest in
-// a reaper when the chain is done.
+// FinishedJobs sends updated finished jobs counts and progress (Chain.Progress)
+// to the Request Manager. This is a singleton service that's ran in Server.Run().
+// Updates are best-effort. The final finished jobs count for a chain is sent with
+// FinishRequest in a reaper when the chain is done.
+//
+// Counts are coalesced: only counts or progress that changed since last sent are
+// sent, so a large or slow chain does not cause a call every interval. If Batch is true,
+// changed counts are sent in batches of at most BatchSize (zero is no limit)
+// with UpdateProgressBatch, else one UpdateProgress call per chain.
 type FinishedJobs struct {
 	ChainRepo chain.Repo
 	RMC       rm.Client
+	Batch     bool
+	BatchSize uint
+
+	sent map[string]proto.RequestProgress // request ID => progress last sent
 }
 
-func (f FinishedJobs) Update() {
+func (f *FinishedJobs) Update() {
 	chains, err := f.ChainRepo.GetAll()
 	if err != nil {
 		log.Warnf("FinishedJobs.Update: ChainRepo.GetAll: %s", err)
 		return
 	}
+
+	// Coalesce: only changed counts of running chains. Counts of chains that
+	// are done are forgotten.
+	sent := make(map[string]proto.RequestProgress, len(chains))
+	changed := []proto.RequestProgress{}
 	for _, chain := range chains {
 		prg := proto.RequestProgress{
 			RequestId:    chain.RequestId(),
 			FinishedJobs: chain.FinishedJobs(),
+			Progress:     chain.Progress(),
 		}
-		if err := f.RMC.UpdateProgress(prg); err != nil {
-			log.Warnf("FinishedJobs.Update: UpdateProgress: %s", err)
+		if last, ok := f.sent[prg.RequestId]; ok && last == prg {
+			sent[prg.RequestId] = last
+			continue
 		}
+		changed = append(changed, prg)
+	}
+	f.sent = sent
+
+	// Counts not sent are not saved, so they're sent again next update
+	if !f.Batch {
+		for _, prg := range changed {
+			if err := f.RMC.UpdateProgress(prg); err != nil {
+				log.Warnf("FinishedJobs.Update: UpdateProgress: %s", err)
+				continue
+			}
+			f.sent[prg.RequestId] = prg
+		}
+		return
+	}
+	for len(changed) > 0 {
+		n := len(changed)
+		if f.BatchSize > 0 && uint(n) > f.BatchSize {
+			n = int(f.BatchSize)
+		}
+		batch := changed[:n]
+		changed = changed[n:]
+		if err := f.RMC.UpdateProgressBatch(batch); err != nil {
+			log.Warnf("FinishedJobs.Update: UpdateProgressBatch: %s", err)
+			continue
+		}
+		for _, prg := range batch {
+			f.sent[prg.RequestId] = prg
+		}
+	}
+}
+
+// Heartbeat registers the Job Runner with the Request Manager and updates its
+// registration, including the number of running job chains. This is a singleton
+// service that's ran in Server.Run() if registration is enabled. Heartbeats are
+// best-effort: if the Request Manager misses heartbeats for its registry timeout,
+// it presumes the Job Runner is dead and resumes its requests elsewhere.
+type Heartbeat struct {
+	ChainRepo chain.Repo
+	RMC       rm.Client
+	JobRunner proto.JobRunner // URL, labels, capacity, and started at
+}
+
+func (h Heartbeat) Send() {
+	chains, err := h.ChainRepo.GetAll()
+	if err != nil {
+		log.Warnf("Heartbeat.Send: ChainRepo.GetAll: %s", err)
+		return
+	}
+	jr := h.JobRunner
+	jr.Running = uint(len(chains))
+	if err := h.RMC.Heartbeat(jr); err != nil {
+		log.Warnf("Heartbeat.Send: %s", err)
 	}
 }
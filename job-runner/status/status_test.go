@@ -10,6 +10,7 @@ import (
 	"github.com/orcaman/concurrent-map"
 
 	"github.com/go-test/deep"
+	"github.com/square/spincycle/v2/job-runner/chain"
 	"github.com/square/spincycle/v2/job-runner/status"
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/test"
@@ -119,3 +120,183 @@ func TestRunning(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestScheduling(t *testing.T) {
+	trRepo := cmap.New()
+	trRepo.Set("req1", &mock.Traverser{
+		SchedStats: proto.SchedulingStats{RequestId: "req1", Queued: 1, Started: 2, WaitTotal: 300, WaitAvg: 150, WaitMax: 200},
+	})
+	trRepo.Set("req2", &mock.Traverser{
+		SchedStats: proto.SchedulingStats{RequestId: "req2", Queued: 2, Started: 1, WaitTotal: 600, WaitAvg: 600, WaitMax: 600},
+	})
+	m := status.NewManager(trRepo)
+
+	got, err := m.Scheduling(proto.StatusFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := proto.SchedulingStats{Queued: 3, Started: 3, WaitTotal: 900, WaitAvg: 300, WaitMax: 600}
+	if diff := deep.Equal(got.JobRunner, expect); diff != nil {
+		t.Error(diff)
+	}
+	if len(got.Chains) != 2 {
+		t.Errorf("got %d chains, expected 2", len(got.Chains))
+	}
+
+	// Filter by request ID
+	got, err = m.Scheduling(proto.StatusFilter{RequestId: "req2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = proto.SchedulingStats{Queued: 2, Started: 1, WaitTotal: 600, WaitAvg: 600, WaitMax: 600}
+	if diff := deep.Equal(got.JobRunner, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestDebug(t *testing.T) {
+	trRepo := cmap.New()
+	trRepo.Set("req2", &mock.Traverser{
+		DebugStats: proto.ChainDebug{RequestId: "req2", Reaper: proto.REAPER_STOPPED, Goroutines: 1, Runners: 1, JobsRunning: 1},
+	})
+	trRepo.Set("req1", &mock.Traverser{
+		DebugStats: proto.ChainDebug{RequestId: "req1", Reaper: proto.REAPER_RUNNING, Goroutines: 3, Runners: 2, JobsRunning: 2, Queued: 1},
+	})
+	m := status.NewManager(trRepo)
+
+	got, err := m.Debug(proto.StatusFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Goroutines == 0 {
+		t.Errorf("got 0 goroutines, expected > 0")
+	}
+	if got.Runners != 3 {
+		t.Errorf("got %d runners, expected 3", got.Runners)
+	}
+	if len(got.Chains) != 2 || got.Chains[0].RequestId != "req1" || got.Chains[1].RequestId != "req2" {
+		t.Errorf("got chains %+v, expected req1 then req2", got.Chains)
+	}
+
+	// Filter by request ID
+	got, err = m.Debug(proto.StatusFilter{RequestId: "req2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Chains) != 1 || got.Chains[0].Reaper != proto.REAPER_STOPPED {
+		t.Errorf("got chains %+v, expected only req2", got.Chains)
+	}
+	if _, err := m.Debug(proto.StatusFilter{RequestId: "nope"}); err == nil {
+		t.Error("no error for unknown request ID, expected one")
+	}
+}
+
+func TestFinishedJobs(t *testing.T) {
+	chainRepo := chain.NewMemoryRepo()
+	chains := map[string]*chain.Chain{}
+	for _, reqId := range []string{"req1", "req2", "req3"} {
+		jc := &proto.JobChain{RequestId: reqId, Jobs: map[string]proto.Job{}}
+		chains[reqId] = chain.NewChain(jc, map[string]uint{}, map[string]uint{}, map[string]uint{})
+		if err := chainRepo.Add(chains[reqId]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var batches [][]proto.RequestProgress
+	var fail bool
+	rmc := &mock.RMClient{
+		UpdateProgressBatchFunc: func(batch []proto.RequestProgress) error {
+			if fail {
+				return mock.ErrRMClient
+			}
+			batches = append(batches, batch)
+			return nil
+		},
+	}
+	f := &status.FinishedJobs{
+		ChainRepo: chainRepo,
+		RMC:       rmc,
+		Batch:     true,
+		BatchSize: 2,
+	}
+	sent := func() []string {
+		var s []string
+		for _, batch := range batches {
+			for _, prg := range batch {
+				s = append(s, prg.RequestId)
+			}
+		}
+		sort.Strings(s)
+		batches = nil
+		return s
+	}
+
+	// First update sends all counts, in batches of at most 2
+	f.Update()
+	if len(batches) != 2 {
+		t.Errorf("sent %d batches, expected 2", len(batches))
+	}
+	if diff := deep.Equal(sent(), []string{"req1", "req2", "req3"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Then only changed counts
+	chains["req2"].IncrementFinishedJobs(1)
+	f.Update()
+	if diff := deep.Equal(sent(), []string{"req2"}); diff != nil {
+		t.Error(diff)
+	}
+	f.Update()
+	if got := sent(); len(got) != 0 {
+		t.Errorf("sent %v, expected nothing sent", got)
+	}
+
+	// Counts not sent are sent next update
+	chains["req1"].IncrementFinishedJobs(1)
+	fail = true
+	f.Update()
+	fail = false
+	f.Update()
+	if diff := deep.Equal(sent(), []string{"req1"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	chainRepo := chain.NewMemoryRepo()
+	for _, reqId := range []string{"req1", "req2"} {
+		jc := &proto.JobChain{RequestId: reqId, Jobs: map[string]proto.Job{}}
+		if err := chainRepo.Add(chain.NewChain(jc, map[string]uint{}, map[string]uint{}, map[string]uint{})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got proto.JobRunner
+	rmc := &mock.RMClient{
+		HeartbeatFunc: func(jr proto.JobRunner) error {
+			got = jr
+			return nil
+		},
+	}
+	startedAt := time.Now().UTC()
+	h := status.Heartbeat{
+		ChainRepo: chainRepo,
+		RMC:       rmc,
+		JobRunner: proto.JobRunner{
+			URL:       "http://jr1:32307",
+			Labels:    map[string]string{"zone": "a"},
+			Capacity:  10,
+			StartedAt: startedAt,
+		},
+	}
+	h.Send()
+
+	expect := proto.JobRunner{
+		URL:       "http://jr1:32307",
+		Labels:    map[string]string{"zone": "a"},
+		Capacity:  10,
+		Running:   2,
+		StartedAt: startedAt,
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
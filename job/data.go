@@ -0,0 +1,137 @@
+// Copyright 2020, Square, Inc.
+
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// A DataCodec encodes and decodes jobData values of one type. Job chains are
+// sent and saved as JSON (for example, when a chain is suspended and resumed),
+// which loses the Go type of jobData values: an int comes back as a float64, a
+// struct as a map[string]interface{}, and so on. Values of registered types are
+// saved with their type name (proto.Job MarshalJSON) and decoded by the codec,
+// so jobs get back the same types they stored.
+type DataCodec interface {
+	// Encode returns the JSON encoding of v, which is a value of the type
+	// the codec is registered for.
+	Encode(v interface{}) ([]byte, error)
+
+	// Decode returns the value encoded by Encode.
+	Decode(data []byte) (interface{}, error)
+}
+
+// jsonCodec is the default DataCodec: encoding/json with the registered type.
+type jsonCodec struct {
+	t reflect.Type
+}
+
+func (c jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (c jsonCodec) Decode(data []byte) (interface{}, error) {
+	p := reflect.New(c.t)
+	if err := json.Unmarshal(data, p.Interface()); err != nil {
+		return nil, err
+	}
+	return p.Elem().Interface(), nil
+}
+
+var (
+	dataMux   = &sync.RWMutex{}
+	dataNames = map[reflect.Type]string{}
+	dataTypes = map[string]DataCodec{}
+)
+
+// Types that do not round-trip through JSON as themselves, registered by default.
+// Strings, bools, float64, []interface{}, and map[string]interface{} already do,
+// so they are not registered.
+func init() {
+	RegisterDataType("int", int(0))
+	RegisterDataType("int8", int8(0))
+	RegisterDataType("int16", int16(0))
+	RegisterDataType("int32", int32(0))
+	RegisterDataType("int64", int64(0))
+	RegisterDataType("uint", uint(0))
+	RegisterDataType("uint8", uint8(0))
+	RegisterDataType("uint16", uint16(0))
+	RegisterDataType("uint32", uint32(0))
+	RegisterDataType("uint64", uint64(0))
+	RegisterDataType("float32", float32(0))
+	RegisterDataType("time.Duration", time.Duration(0))
+	RegisterDataType("time.Time", time.Time{})
+	RegisterDataType("[]string", []string{})
+	RegisterDataType("[]int", []int{})
+	RegisterDataType("map[string]string", map[string]string{})
+	RegisterDataType("map[string]int", map[string]int{})
+}
+
+// RegisterDataType registers the type of v, encoded with encoding/json, as name.
+// Jobs that store values of their own types in jobData should register them,
+// usually in an init func of the job package, like:
+//
+//   func init() {
+//     job.RegisterDataType("mypkg.Host", Host{})
+//   }
+//
+// Names are saved with job chains, so they must not change, and they must be
+// registered by every Job Runner that runs the jobs. It panics if name or the
+// type is already registered.
+func RegisterDataType(name string, v interface{}) {
+	t := reflect.TypeOf(v)
+	RegisterDataCodec(name, v, jsonCodec{t: t})
+}
+
+// RegisterDataCodec is like RegisterDataType but values of the type of v are
+// encoded and decoded by codec.
+func RegisterDataCodec(name string, v interface{}, codec DataCodec) {
+	t := reflect.TypeOf(v)
+	if name == "" || t == nil {
+		panic("job.RegisterDataCodec: empty name or nil value")
+	}
+	dataMux.Lock()
+	defer dataMux.Unlock()
+	if _, ok := dataTypes[name]; ok {
+		panic(fmt.Sprintf("job.RegisterDataCodec: data type %s already registered", name))
+	}
+	if other, ok := dataNames[t]; ok {
+		panic(fmt.Sprintf("job.RegisterDataCodec: type %s already registered as %s", t, other))
+	}
+	dataTypes[name] = codec
+	dataNames[t] = name
+}
+
+// EncodeData returns the registered type name of v and its encoding, or an empty
+// name and the encoding/json encoding of v if its type is not registered.
+func EncodeData(v interface{}) (string, []byte, error) {
+	dataMux.RLock()
+	name, ok := dataNames[reflect.TypeOf(v)]
+	codec := dataTypes[name]
+	dataMux.RUnlock()
+	if !ok {
+		bytes, err := json.Marshal(v)
+		return "", bytes, err
+	}
+	bytes, err := codec.Encode(v)
+	return name, bytes, err
+}
+
+// DecodeData returns the value encoded by EncodeData. If name is empty or not
+// registered, data is decoded with encoding/json into an interface{}, as if it
+// had no type, and ok is false.
+func DecodeData(name string, data []byte) (v interface{}, ok bool, err error) {
+	dataMux.RLock()
+	codec, ok := dataTypes[name]
+	dataMux.RUnlock()
+	if !ok {
+		err = json.Unmarshal(data, &v)
+		return v, false, err
+	}
+	v, err = codec.Decode(data)
+	return v, true, err
+}
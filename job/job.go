@@ -5,6 +5,8 @@
 // because everything else depends on it.
 package job
 
+import "os/exec"
+
 // A Job is the smallest, reusable building block in Spin Cycle that has meaning
 // by itself. A job should do one thing and be reusable. For example, job type
 // "net/down-ip" removes an IP address from a network interface. This job is
@@ -56,8 +58,9 @@ type Job interface {
 	Run(jobData map[string]interface{}) (Return, error)
 
 	// Stop stops a job. The Job Runner calls this method when stopping a job
-	// chain before it has completed. The job must respond to Stop while Run
-	// is executing. Stop is expected to block but also return quickly.
+	// chain before it has completed, unless the job implements ReasonStopper.
+	// The job must respond to Stop while Run is executing. Stop is expected to
+	// block but also return quickly.
 	Stop() error
 
 	// Status returns the real-time status of the job. The job must respond
@@ -140,6 +143,227 @@ type Factory interface {
 	Make(id Id) (Job, error)
 }
 
+// A TypeLister factory lists the job types it can make. It is optional. If the
+// Job Runner job factory implements it, the Request Manager checks that every
+// job type in a job chain is listed before sending the chain to the Job Runner,
+// so a chain with an unknown job type fails to start instead of failing when
+// the job runs.
+type TypeLister interface {
+	Types() []string
+}
+
+// Auth is the auth context of the request that created a job: the user who made
+// the request and, if the Job Runner has a TokenProvider plugin, a token delegated
+// by the user. Jobs use it to make downstream calls as the user rather than as
+// the Job Runner.
+type Auth struct {
+	User  string // user who made the request (proto.Request.User)
+	Token string // delegated token for User, or empty if no TokenProvider
+}
+
+// An Authenticated job receives the Auth of its request. It is optional; jobs
+// that do not make downstream calls as the user do not need to implement it.
+// The Job Runner calls SetAuth before every try of Run, so a job should not
+// save the token between tries because it can expire.
+type Authenticated interface {
+	SetAuth(Auth)
+}
+
+// A UsesGlobals job receives the globals of its request: chain-level values
+// set by the request spec (globals:), from request args or static values. Every
+// job gets the same globals without listing them in its args. It is optional;
+// jobs that do not use globals do not need to implement it. The Request Manager
+// calls SetGlobals before Create, and the Job Runner calls it after Deserialize.
+// The map is a copy, so changes do not affect other jobs.
+type UsesGlobals interface {
+	SetGlobals(globals map[string]interface{})
+}
+
+// A UsesOverrides job receives the execution overrides of its request, like
+// feature flags and endpoints, set by the caller when the request is created,
+// so a request can be tweaked (for example, in staging) without editing specs.
+// Only overrides allowed by the request spec (overrides:) can be set, and
+// overrides not set by the caller are not in the map, so a job uses its usual
+// value if an override is missing. It is optional; jobs that do not use
+// overrides do not need to implement it. The Job Runner calls SetOverrides
+// after Deserialize. The map is a copy, so changes do not affect other jobs.
+type UsesOverrides interface {
+	SetOverrides(overrides map[string]string)
+}
+
+// A DryRunner job can run without side effects, like making only read calls and
+// returning what it would have changed in Stdout. It is optional and only used
+// by spinc replay-job, which calls DryRun instead of Run unless real side effects
+// are requested. DryRun can modify jobData like Run.
+type DryRunner interface {
+	DryRun(jobData map[string]interface{}) (Return, error)
+}
+
+// Sandbox is how a sandboxed job must run processes: as User, in Dir, with
+// Limits. Jobs run in the Job Runner process, so a job runs processes with
+// Command to run them in the sandbox. Job types are sandboxed by the Job Runner
+// config (sandboxes).
+type Sandbox struct {
+	User   string            // OS user to run processes as, or empty for the Job Runner user
+	Uid    uint32            // user ID of User
+	Gid    uint32            // group ID of User
+	Dir    string            // private work dir, removed after the try
+	Limits map[string]uint64 // resource limits, prlimit(1) names and units
+}
+
+// A Sandboxed job receives the Sandbox in which to run processes. Jobs of
+// sandboxed types must implement it, else they fail without running. The Job
+// Runner calls SetSandbox before every try of Run with a new work dir, and
+// removes the work dir after the try.
+type Sandboxed interface {
+	SetSandbox(Sandbox)
+}
+
+// Workspace is a private scratch directory for a job. It's kept between tries of
+// the job and removed when the job is done running, so files that must be kept
+// are promoted into artifacts. If the job is sandboxed, Dir is owned by the
+// sandbox user.
+type Workspace struct {
+	// Dir is the workspace directory.
+	Dir string
+
+	// Quota is the max size of Dir in bytes, or zero if no quota. If Dir is
+	// larger, the job is stopped and its try fails.
+	Quota uint64
+
+	// Promote moves a file or directory, at a path relative to Dir, into the
+	// artifacts of the job and returns its new path. It returns an error if
+	// artifacts are not enabled by the Job Runner config (workspaces.artifacts_dir).
+	Promote func(path string) (string, error)
+}
+
+// A UsesWorkspace job receives a Workspace. It is optional; jobs that do not need
+// scratch space do not need to implement it. The Job Runner calls SetWorkspace
+// before every try of Run with the same Workspace.
+type UsesWorkspace interface {
+	SetWorkspace(Workspace)
+}
+
+// A ReasonStopper job learns why it's stopped, so it can clean up differently,
+// like keeping partial state when its chain is suspended (it will run again on
+// resume) but tearing it down when a user stops the request. It is optional. The
+// Job Runner calls StopWithReason instead of Stop with a proto.STOP_REASON_* const.
+// Like Stop, it must respond while Run is executing and return quickly.
+type ReasonStopper interface {
+	StopWithReason(reason string) error
+}
+
+// A Logger logs leveled, structured entries for a job try. Entries at or above
+// the job's log level (proto.Job.LogLevel, default info) are saved in the job
+// log entry of the try and written to the Job Runner log. The level is set per
+// request, so verbose debug entries can be enabled for one request.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+
+	// WithFields returns a Logger that adds the fields to every entry.
+	WithFields(fields map[string]interface{}) Logger
+}
+
+// A Logging job receives a Logger. It is optional; jobs can return everything
+// in Return.Stdout and Return.Stderr instead. The Job Runner calls SetLogger
+// before every try of Run with a new Logger, so a job should not use a Logger
+// from a previous try.
+type Logging interface {
+	SetLogger(Logger)
+}
+
+// Reentry tells a job whether it ran before the current try. When a chain is
+// suspended or its Job Runner crashes, running jobs are stopped, and they are run
+// again when the chain is resumed, possibly on another Job Runner. A job that was
+// stopped partway can use Reentry to fast-forward instead of redoing side effects.
+type Reentry struct {
+	// Try is the job try, counting all previous tries (proto.JobLog.Try).
+	Try uint
+
+	// Resumed is true if the job was stopped and its chain resumed: the job
+	// might have partially run before. It's only true on the first try after
+	// resuming.
+	Resumed bool
+
+	// StopReason is why the job was stopped (proto.STOP_REASON_* const) if
+	// Resumed is true.
+	StopReason string
+
+	// Token is the last token returned by ReentryToken after a previous try,
+	// including tries before the job was stopped, or empty if none. Tokens
+	// are kept until the job completes or fails.
+	Token string
+}
+
+// A Reentrant job learns whether it ran before the current try and saves a token
+// marking how far it got, like the last host it processed. It is optional; jobs
+// that are idempotent without knowing where they stopped do not need to implement
+// it. The Job Runner calls SetReentry before every try of Run, and ReentryToken
+// after every try, even if the job was stopped. Tokens are saved with the chain
+// when it's suspended, not while the job runs, so the Job Runner crashing loses
+// the tokens returned since the job last started.
+type Reentrant interface {
+	SetReentry(Reentry)
+	ReentryToken() string
+}
+
+// Feedback receives throttling signals from a job, like HTTP 429 responses from
+// a downstream system. It's safe for concurrent use.
+type Feedback interface {
+	// Throttled reports that the job was throttled n times since it last
+	// reported.
+	Throttled(n uint)
+}
+
+// A Paced job reports when it's throttled so the Job Runner can slow starting
+// the remaining sequences of the fan-out it's in (spec each: with pace: true).
+// It is optional; throttling is ignored for jobs that do not implement it. The
+// Job Runner calls SetFeedback before every try of Run. If the job is not in a
+// paced fan-out, the Feedback does nothing.
+type Paced interface {
+	SetFeedback(Feedback)
+}
+
+// Resources receives the resources affected by a job, like host IDs, databases,
+// and clusters. It's safe for concurrent use.
+type Resources interface {
+	// Affect reports that the job changed or used the resources. Reporting
+	// the same resource more than once is allowed.
+	Affect(resources ...string)
+}
+
+// An AffectsResources job reports the resources it affects so the Request
+// Manager can index them, which lets operators find every request that touched
+// a resource (spinc find resource=db01). It is optional. The Job Runner calls
+// SetResources before every try of Run, and the resources affected during the
+// try are saved in its job log entry (proto.JobLog.Resources). Resource names
+// are opaque strings; they should be consistent across job types so that, for
+// example, every job that affects host db01 reports "db01".
+type AffectsResources interface {
+	SetResources(Resources)
+}
+
+// Execs runs external commands for a job and records them in the exec audit of
+// the try. It's safe for concurrent use.
+type Execs interface {
+	// Run runs the command like cmd.Run and records its command line, working
+	// directory, exit code, and duration. It returns the error from cmd.Run.
+	Run(cmd *exec.Cmd) error
+}
+
+// An AuditsExecs job runs its external commands with Execs so security can
+// review what the Job Runner actually executed. It is optional, but recommended
+// for jobs that run subprocesses. The Job Runner calls SetExecs before every try
+// of Run, and the commands run during the try are saved in its job log entry
+// (proto.JobLog.Execs). Command lines are saved as is, so secrets should be
+// passed in the environment or on stdin, which are not recorded.
+type AuditsExecs interface {
+	SetExecs(Execs)
+}
+
 // Return represents return values and output from a job. State indicates how
 // the job completed. If State == proto.STATE_COMPLETE, the job completed
 // successfully. Anything else indicates that the job failed or didn't complete,
@@ -152,10 +376,17 @@ type Factory interface {
 // successful because it handled being re-ran. For example, a job could delete
 // a record, but when re-ran the record has already been deleted, so the job
 // is successful but reports Error = ErrRecordNotFound for logging.
+//
+// A failed job can classify its error with ErrorClass so the Job Runner retries
+// it appropriately: a transient error (or no class) uses a job retry after the
+// job retry wait, a throttled error uses a job retry after a longer wait that
+// doubles every consecutive throttled try, and a permanent error fails the job
+// now, without using its remaining retries.
 type Return struct {
-	State  byte   // proto/STATE_ const
-	Exit   int64  // Unix exit code
-	Error  error  // Go error
-	Stdout string // stdout output
-	Stderr string // stderr output
+	State      byte   // proto/STATE_ const
+	Exit       int64  // Unix exit code
+	Error      error  // Go error
+	Stdout     string // stdout output
+	Stderr     string // stderr output
+	ErrorClass string // proto.ERROR_CLASS_ const, optional
 }
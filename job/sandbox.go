@@ -0,0 +1,47 @@
+// Copyright 2020, Square, Inc.
+
+//go:build !windows
+// +build !windows
+
+package job
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"syscall"
+)
+
+// Command returns an exec.Cmd to run the named program with the given args in
+// the sandbox. Like exec.Command, it only sets up the command; the caller runs
+// it. If the sandbox has limits, the program is run by prlimit(1), which sets
+// the limits and then executes the program.
+func (s Sandbox) Command(name string, arg ...string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if len(s.Limits) > 0 {
+		names := make([]string, 0, len(s.Limits))
+		for n := range s.Limits {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		args := make([]string, 0, len(names)+len(arg)+2)
+		for _, n := range names {
+			args = append(args, fmt.Sprintf("--%s=%d", n, s.Limits[n]))
+		}
+		args = append(args, "--", name)
+		cmd = exec.Command("prlimit", append(args, arg...)...)
+	} else {
+		cmd = exec.Command(name, arg...)
+	}
+	if s.Dir != "" {
+		cmd.Dir = s.Dir
+		cmd.Env = append(os.Environ(), "TMPDIR="+s.Dir)
+	}
+	if s.User != "" {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{Uid: s.Uid, Gid: s.Gid},
+		}
+	}
+	return cmd
+}
@@ -0,0 +1,20 @@
+// Copyright 2020, Square, Inc.
+
+package job
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Command returns an exec.Cmd to run the named program with the given args in
+// the sandbox. On Windows, only Dir is used: the Job Runner does not allow
+// sandboxes with a user or limits.
+func (s Sandbox) Command(name string, arg ...string) *exec.Cmd {
+	cmd := exec.Command(name, arg...)
+	if s.Dir != "" {
+		cmd.Dir = s.Dir
+		cmd.Env = append(os.Environ(), "TMP="+s.Dir, "TEMP="+s.Dir)
+	}
+	return cmd
+}
@@ -0,0 +1,58 @@
+// Copyright 2020, Square, Inc.
+
+// Package metrics provides instrumentation for the Request Manager and Job
+// Runner: counters, gauges, and timers reported through the Metrics interface.
+// The default is Nop, which reports nothing. Prometheus is built in. Embedders
+// report to other backends, like StatsD or Datadog, by implementing Metrics
+// and setting it in app.Context.Plugins.Metrics before the server boots.
+package metrics
+
+import (
+	"time"
+)
+
+// Metric names. Backends can add a prefix or suffix, like Prometheus which adds
+// namespace "spincycle_" and unit suffixes "_total" and "_seconds".
+const (
+	// Request Manager
+	REQUESTS_CREATED     = "requests_created"     // count: tags type
+	REQUESTS_FINISHED    = "requests_finished"    // count: tags type, state
+	REQUEST_DURATION     = "request_duration"     // timing: tags type, state; start to finish
+	API_REQUEST_DURATION = "api_request_duration" // timing: tags method, path (route), status
+
+	// Job Runner
+	JOBS_RUN          = "jobs_run"          // count: tags type, state
+	JOB_DURATION      = "job_duration"      // timing: tags type, state; all tries
+	JOBS_SLOW         = "jobs_slow"         // count: tags type; slow job watchdogs
+	CHAINS_RUNNING    = "chains_running"    // gauge
+	CHAINS_RETAINED   = "chains_retained"   // gauge: done chains kept in memory
+	CHAINS_COLLECTED  = "chains_collected"  // count: done chains removed from memory
+	RM_REPORTS_QUEUED = "rm_reports_queued" // gauge: reports to the RM queued while it's down
+)
+
+// Tags are metric dimensions, like job type. Backends without tags, like plain
+// StatsD, can ignore them or append the values to the metric name.
+type Tags map[string]string
+
+// Metrics reports metrics to a backend. Implementations must be safe for
+// concurrent use, and they should not block: metrics are reported inline on
+// the hot paths of requests and jobs.
+type Metrics interface {
+	// Count adds delta to a counter.
+	Count(name string, delta int64, tags Tags)
+
+	// Gauge sets a gauge to value.
+	Gauge(name string, value float64, tags Tags)
+
+	// Timing records a duration, like how long a job ran.
+	Timing(name string, d time.Duration, tags Tags)
+}
+
+// Nop is a Metrics that reports nothing. It's the default.
+type Nop struct{}
+
+var _ Metrics = Nop{}
+
+func (Nop) Count(name string, delta int64, tags Tags)      {}
+func (Nop) Gauge(name string, value float64, tags Tags)    {}
+func (Nop) Timing(name string, d time.Duration, tags Tags) {}
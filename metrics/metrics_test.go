@@ -0,0 +1,59 @@
+// Copyright 2020, Square, Inc.
+
+package metrics_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/square/spincycle/v2/metrics"
+)
+
+func TestPrometheus(t *testing.T) {
+	p := metrics.NewPrometheus("spincycle")
+	p.Count(metrics.JOBS_RUN, 1, metrics.Tags{"type": "restart", "state": "COMPLETE"})
+	p.Count(metrics.JOBS_RUN, 2, metrics.Tags{"type": "restart", "state": "COMPLETE"})
+	p.Count(metrics.JOBS_RUN, 1, metrics.Tags{"type": "restart", "state": "FAIL"})
+	p.Gauge(metrics.CHAINS_RUNNING, 3, nil)
+	p.Gauge(metrics.CHAINS_RUNNING, 2, nil)
+	p.Timing(metrics.JOB_DURATION, 1500*time.Millisecond, metrics.Tags{"type": "re\"start"})
+	p.Timing(metrics.JOB_DURATION, 500*time.Millisecond, metrics.Tags{"type": "re\"start"})
+
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expect := `# TYPE spincycle_jobs_run_total counter
+spincycle_jobs_run_total{state="COMPLETE",type="restart"} 3
+spincycle_jobs_run_total{state="FAIL",type="restart"} 1
+# TYPE spincycle_chains_running gauge
+spincycle_chains_running 2
+# TYPE spincycle_job_duration_seconds summary
+spincycle_job_duration_seconds_sum{type="re\"start"} 2
+spincycle_job_duration_seconds_count{type="re\"start"} 2
+`
+	if diff := deep.Equal(buf.String(), expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// ServeHTTP writes the same
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Body.String() != expect {
+		t.Errorf("ServeHTTP wrote %q, expected %q", w.Body.String(), expect)
+	}
+}
+
+func TestPrometheusNoNamespace(t *testing.T) {
+	p := metrics.NewPrometheus("")
+	p.Count("api.requests", 1, metrics.Tags{"path": "/api/v1/requests"})
+	var buf bytes.Buffer
+	p.Write(&buf)
+	expect := "# TYPE api_requests_total counter\napi_requests_total{path=\"/api/v1/requests\"} 1\n"
+	if buf.String() != expect {
+		t.Errorf("got %q, expected %q", buf.String(), expect)
+	}
+}
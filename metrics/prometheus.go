@@ -0,0 +1,186 @@
+// Copyright 2020, Square, Inc.
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prometheus is a Metrics that holds metrics in memory and writes them in
+// Prometheus text format. Counters are named <namespace>_<name>_total, gauges
+// <namespace>_<name>, and timers are summaries (count and sum, no quantiles)
+// named <namespace>_<name>_seconds. The Request Manager and Job Runner serve
+// its metrics at GET /metrics.
+type Prometheus struct {
+	namespace string
+	mux       *sync.Mutex
+	counters  map[string]map[string]float64  // name => labels => value
+	gauges    map[string]map[string]float64  // name => labels => value
+	timers    map[string]map[string]*summary // name => labels => summary
+}
+
+type summary struct {
+	count uint64
+	sum   float64 // seconds
+}
+
+var _ Metrics = &Prometheus{}
+
+// NewPrometheus makes a Prometheus that prefixes metric names with the namespace,
+// usually "spincycle". If namespace is empty, names are not prefixed.
+func NewPrometheus(namespace string) *Prometheus {
+	return &Prometheus{
+		namespace: namespace,
+		mux:       &sync.Mutex{},
+		counters:  map[string]map[string]float64{},
+		gauges:    map[string]map[string]float64{},
+		timers:    map[string]map[string]*summary{},
+	}
+}
+
+func (p *Prometheus) Count(name string, delta int64, tags Tags) {
+	l := labels(tags)
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.counters[name] == nil {
+		p.counters[name] = map[string]float64{}
+	}
+	p.counters[name][l] += float64(delta)
+}
+
+func (p *Prometheus) Gauge(name string, value float64, tags Tags) {
+	l := labels(tags)
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.gauges[name] == nil {
+		p.gauges[name] = map[string]float64{}
+	}
+	p.gauges[name][l] = value
+}
+
+func (p *Prometheus) Timing(name string, d time.Duration, tags Tags) {
+	l := labels(tags)
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.timers[name] == nil {
+		p.timers[name] = map[string]*summary{}
+	}
+	s := p.timers[name][l]
+	if s == nil {
+		s = &summary{}
+		p.timers[name][l] = s
+	}
+	s.count++
+	s.sum += d.Seconds()
+}
+
+// Write writes all metrics in Prometheus text format, sorted by name and labels.
+func (p *Prometheus) Write(w io.Writer) error {
+	var buf bytes.Buffer
+	p.mux.Lock()
+	for _, name := range sortedNames(p.counters) {
+		n := p.name(name) + "_total"
+		fmt.Fprintf(&buf, "# TYPE %s counter\n", n)
+		for _, l := range sortedLabels(p.counters[name]) {
+			fmt.Fprintf(&buf, "%s%s %g\n", n, l, p.counters[name][l])
+		}
+	}
+	for _, name := range sortedNames(p.gauges) {
+		n := p.name(name)
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", n)
+		for _, l := range sortedLabels(p.gauges[name]) {
+			fmt.Fprintf(&buf, "%s%s %g\n", n, l, p.gauges[name][l])
+		}
+	}
+	timerNames := make([]string, 0, len(p.timers))
+	for name := range p.timers {
+		timerNames = append(timerNames, name)
+	}
+	sort.Strings(timerNames)
+	for _, name := range timerNames {
+		n := p.name(name) + "_seconds"
+		fmt.Fprintf(&buf, "# TYPE %s summary\n", n)
+		ls := make([]string, 0, len(p.timers[name]))
+		for l := range p.timers[name] {
+			ls = append(ls, l)
+		}
+		sort.Strings(ls)
+		for _, l := range ls {
+			s := p.timers[name][l]
+			fmt.Fprintf(&buf, "%s_sum%s %g\n", n, l, s.sum)
+			fmt.Fprintf(&buf, "%s_count%s %d\n", n, l, s.count)
+		}
+	}
+	p.mux.Unlock()
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ServeHTTP serves all metrics in Prometheus text format.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	p.Write(w)
+}
+
+func (p *Prometheus) name(name string) string {
+	if p.namespace == "" {
+		return sanitize(name)
+	}
+	return sanitize(p.namespace + "_" + name)
+}
+
+// labels returns the tags as Prometheus labels, like {state="FAIL",type="restart"},
+// sorted by tag name so the same tags are always the same labels.
+func labels(tags Tags) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(tags))
+	for k := range tags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	l := make([]string, len(names))
+	for i, k := range names {
+		l[i] = fmt.Sprintf("%s=\"%s\"", sanitize(k), labelEscaper.Replace(tags[k]))
+	}
+	return "{" + strings.Join(l, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sanitize replaces characters not valid in Prometheus metric and label names
+// with underscores.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func sortedNames(m map[string]map[string]float64) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedLabels(m map[string]float64) []string {
+	ls := make([]string, 0, len(m))
+	for l := range m {
+		ls = append(ls, l)
+	}
+	sort.Strings(ls)
+	return ls
+}
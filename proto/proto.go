@@ -4,11 +4,14 @@
 package proto
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/square/spincycle/v2/job"
 )
 
 // DO NOT change the state values. The raw byte values is stored in tables,
@@ -29,60 +32,299 @@ const (
 	// A request or chain can be suspended and then resumed at a later time.
 	// Jobs aren't suspended - they're stopped when a chain is suspended.
 	STATE_SUSPENDED byte = 7
+
+	// A chain can be paused in the Job Runner: it doesn't start new jobs until
+	// it's resumed. Only chains are paused, not requests or jobs: the request
+	// stays running in the RM, and running jobs keep running.
+	STATE_PAUSED byte = 8
+
+	// A gate job (package gate) waits for approval. Only running job status
+	// (JobStatus.State) reports it: in the job chain, the job is running.
+	STATE_WAITING_APPROVAL byte = 9
 )
 
 var StateName = map[byte]string{
-	STATE_UNKNOWN:   "UNKNOWN",
-	STATE_PENDING:   "PENDING",
-	STATE_RUNNING:   "RUNNING",
-	STATE_COMPLETE:  "COMPLETE",
-	STATE_FAIL:      "FAIL",
-	STATE_RESERVED:  "RESERVED",
-	STATE_STOPPED:   "STOPPED",
-	STATE_SUSPENDED: "SUSPENDED",
+	STATE_UNKNOWN:          "UNKNOWN",
+	STATE_PENDING:          "PENDING",
+	STATE_RUNNING:          "RUNNING",
+	STATE_COMPLETE:         "COMPLETE",
+	STATE_FAIL:             "FAIL",
+	STATE_RESERVED:         "RESERVED",
+	STATE_STOPPED:          "STOPPED",
+	STATE_SUSPENDED:        "SUSPENDED",
+	STATE_PAUSED:           "PAUSED",
+	STATE_WAITING_APPROVAL: "WAITING_APPROVAL",
 }
 
 var StateValue = map[string]byte{
-	"UNKNOWN":   STATE_UNKNOWN,
-	"PENDING":   STATE_PENDING,
-	"RUNNING":   STATE_RUNNING,
-	"COMPLETE":  STATE_COMPLETE,
-	"FAIL":      STATE_FAIL,
-	"RESERVED":  STATE_RESERVED,
-	"STOPPED":   STATE_STOPPED,
-	"SUSPENDED": STATE_SUSPENDED,
+	"UNKNOWN":          STATE_UNKNOWN,
+	"PENDING":          STATE_PENDING,
+	"RUNNING":          STATE_RUNNING,
+	"COMPLETE":         STATE_COMPLETE,
+	"FAIL":             STATE_FAIL,
+	"RESERVED":         STATE_RESERVED,
+	"STOPPED":          STATE_STOPPED,
+	"SUSPENDED":        STATE_SUSPENDED,
+	"PAUSED":           STATE_PAUSED,
+	"WAITING_APPROVAL": STATE_WAITING_APPROVAL,
 }
 
 const (
-	REQUEST_OP_START = "start"
-	REQUEST_OP_STOP  = "stop"
+	REQUEST_OP_START   = "start"
+	REQUEST_OP_STOP    = "stop"
+	REQUEST_OP_ADD_JOB = "add-job"
+	REQUEST_OP_APPROVE = "approve"
 )
 
+// REQUEST_OPS are all request ops, in the order listed in error messages.
+var REQUEST_OPS = []string{REQUEST_OP_START, REQUEST_OP_STOP, REQUEST_OP_ADD_JOB, REQUEST_OP_APPROVE}
+
+// RUN_AS_HEADER is the HTTP header to create or stop requests on behalf of
+// another user (run-as), like "X-Spincycle-Run-As: alice". Only callers with an
+// admin role can run as another user. The request user is the header value,
+// and the caller is recorded as the request operator.
+const RUN_AS_HEADER = "X-Spincycle-Run-As"
+
 // Job represents one job in a job chain. Jobs are identified by Id, which
 // must be unique within a job chain.
 type Job struct {
 	Id                string                 `json:"id"`                          // unique id
 	Name              string                 `json:"name"`                        // name of the job
 	Type              string                 `json:"type"`                        // user-specific job type
+	Desc              string                 `json:"desc,omitempty"`              // human-readable description (spec desc:)
 	Bytes             []byte                 `json:"bytes,omitempty"`             // return value of Job.Serialize method
 	State             byte                   `json:"state"`                       // STATE_* const
 	Args              map[string]interface{} `json:"args,omitempty"`              // the jobArgs a job was created with
+	ArgSources        map[string]ArgSource   `json:"argSources,omitempty"`        // where each of Args came from (provenance)
 	Data              map[string]interface{} `json:"data,omitempty"`              // job-specific data during Job.Run
 	Retry             uint                   `json:"retry"`                       // retry N times if first run fails
 	RetryWait         string                 `json:"retryWait,omitempty"`         // wait between tries (duration string: "N{ms|s|m|h}", default: 0s)
+	RetryArgs         map[string]interface{} `json:"retryArgs,omitempty"`         // jobData overrides set on every try after the first
+	KeepData          bool                   `json:"keepData,omitempty"`          // keep jobData changes on sequence retry
 	SequenceId        string                 `json:"sequenceId"`                  // Job.Id of first job in sequence
 	SequenceRetry     uint                   `json:"sequenceRetry"`               // retry sequence N times if first run fails. Only set for first job in sequence.
 	SequenceRetryWait string                 `json:"sequenceRetryWait,omitempty"` // wait between sequence tries (duration string: "N{ms|s|m|h}", default: 0s)
+	SequenceSkippable bool                   `json:"sequenceSkippable,omitempty"` // sequence can be skipped when it fails (RerunRequest.SkipSequence). Only set for first job in sequence.
+	Singleton         string                 `json:"singleton,omitempty"`         // singleton lock name, empty if not a singleton job
+	SingletonPolicy   string                 `json:"singletonPolicy,omitempty"`   // SINGLETON_POLICY_* const (default: queue)
+	LogLevel          string                 `json:"logLevel,omitempty"`          // LOG_LEVEL_* const of job.Logger entries saved (default: info)
+	Pace              string                 `json:"pace,omitempty"`              // ID of the paced fan-out (spec pace:) the job is in, empty if none
+	PaceStart         bool                   `json:"paceStart,omitempty"`         // first job of a fan-out branch, started at the pace of the fan-out
+	StopReason        string                 `json:"stopReason,omitempty"`        // STOP_REASON_* const if stopped to be run again on resume
+	ReentryToken      string                 `json:"reentryToken,omitempty"`      // last token from job.Reentrant before it was stopped
+	Needs             []string               `json:"needs,omitempty"`             // Job Runner capabilities (JobRunner.Capabilities) required to run the job (spec needs:)
+	Asserts           []string               `json:"asserts,omitempty"`           // sequence assertions (spec assert:) checked when the job completes. Only set for last job in sequence.
+	Finally           bool                   `json:"finally,omitempty"`           // job in the sequence finally: block (spec finally:), run even if the sequence fails or is stopped
+	Weight            uint                   `json:"weight,omitempty"`            // relative amount of work, for Request.Progress (0 is 1)
+
+	// Type names of Data values decoded without a registered job.DataCodec,
+	// kept so that they are not lost when the job is encoded again.
+	unknownDataTypes map[string]string
+}
+
+// jobJSON is a Job as JSON with Data values encoded by job.EncodeData and the
+// type name of each one that has a registered type.
+type jobJSON struct {
+	*jobAlias
+	Data      map[string]json.RawMessage `json:"data,omitempty"`
+	DataTypes map[string]string          `json:"dataTypes,omitempty"`
+}
+
+type jobAlias Job // without MarshalJSON and UnmarshalJSON
+
+// MarshalJSON encodes the job with the type of each Data value that has a
+// registered type (job.RegisterDataType), so UnmarshalJSON decodes the same
+// types instead of float64, map[string]interface{}, and so on.
+func (j Job) MarshalJSON() ([]byte, error) {
+	v := jobJSON{jobAlias: (*jobAlias)(&j)}
+	if len(j.Data) > 0 {
+		v.Data = make(map[string]json.RawMessage, len(j.Data))
+		for k, val := range j.Data {
+			name, bytes, err := job.EncodeData(val)
+			if err != nil {
+				return nil, fmt.Errorf("cannot encode job data %s: %s", k, err)
+			}
+			if name == "" {
+				name = j.unknownDataTypes[k]
+			}
+			if name != "" {
+				if v.DataTypes == nil {
+					v.DataTypes = map[string]string{}
+				}
+				v.DataTypes[k] = name
+			}
+			v.Data[k] = bytes
+		}
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes a job encoded by MarshalJSON. Data values with an
+// unregistered type are decoded like encoding/json does.
+func (j *Job) UnmarshalJSON(b []byte) error {
+	v := jobJSON{jobAlias: (*jobAlias)(j)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	j.Data = nil
+	j.unknownDataTypes = nil
+	if v.Data == nil {
+		return nil
+	}
+	j.Data = make(map[string]interface{}, len(v.Data))
+	for k, raw := range v.Data {
+		name := v.DataTypes[k]
+		val, ok, err := job.DecodeData(name, raw)
+		if err != nil {
+			return fmt.Errorf("cannot decode job data %s (type %s): %s", k, name, err)
+		}
+		if !ok && name != "" {
+			if j.unknownDataTypes == nil {
+				j.unknownDataTypes = map[string]string{}
+			}
+			j.unknownDataTypes[k] = name
+		}
+		j.Data[k] = val
+	}
+	return nil
+}
+
+// Why a job was stopped before it finished. Jobs that implement job.ReasonStopper
+// get it when stopped. Suspended and interrupted jobs are run again when their
+// chain is resumed (Job.StopReason), and jobs that implement job.Reentrant get it
+// on resume.
+const (
+	STOP_REASON_SUSPENDED   = "suspended"   // chain suspended, like when the Job Runner shut down
+	STOP_REASON_INTERRUPTED = "interrupted" // Job Runner crashed or died while the job was running
+	STOP_REASON_USER        = "user"        // request stopped by a user (spinc stop)
+	STOP_REASON_FAILURE     = "failure"     // another job failed and the request has strict failure
+	STOP_REASON_QUOTA       = "quota"       // job workspace larger than its quota
+)
+
+// Why a job failed, returned by the job (job.Return.ErrorClass). The Job Runner
+// uses it to decide how to retry the job. Failed jobs that do not classify their
+// error are retried like transient errors.
+const (
+	ERROR_CLASS_TRANSIENT = "transient" // might succeed if retried: retry after the job retry wait
+	ERROR_CLASS_PERMANENT = "permanent" // will fail again: fail now, even if the job has retries left
+	ERROR_CLASS_THROTTLED = "throttled" // downstream system is overloaded: retry after a longer wait
+)
+
+// Job log levels, lowest to highest. A job logs entries with the job.Logger
+// given to jobs that implement job.Logging. Entries below the job's log level
+// (Job.LogLevel) are not saved.
+const (
+	LOG_LEVEL_DEBUG = "debug"
+	LOG_LEVEL_INFO  = "info"
+	LOG_LEVEL_WARN  = "warn"
+	LOG_LEVEL_ERROR = "error"
+)
+
+// LogLevels maps valid log levels to their order, lowest first.
+var LogLevels = map[string]int{
+	LOG_LEVEL_DEBUG: 0,
+	LOG_LEVEL_INFO:  1,
+	LOG_LEVEL_WARN:  2,
+	LOG_LEVEL_ERROR: 3,
 }
 
 // JobChain represents a directed acyclic graph of jobs for one request.
 // Job chains are identified by RequestId, which must be globally unique.
 type JobChain struct {
-	RequestId     string              `json:"requestId"`     // unique identifier for the chain
-	Jobs          map[string]Job      `json:"jobs"`          // Job.Id => job
-	AdjacencyList map[string][]string `json:"adjacencyList"` // Job.Id => next []Job.Id
-	State         byte                `json:"state"`         // STATE_* const
-	FinishedJobs  uint                `json:"finishedJobs"`  // number of jobs that ran and finished with state = STATE_COMPLETE
+	RequestId     string              `json:"requestId"`      // unique identifier for the chain
+	Jobs          map[string]Job      `json:"jobs"`           // Job.Id => job
+	AdjacencyList map[string][]string `json:"adjacencyList"`  // Job.Id => next []Job.Id
+	State         byte                `json:"state"`          // STATE_* const
+	FinishedJobs  uint                `json:"finishedJobs"`   // number of jobs that ran and finished with state = STATE_COMPLETE
+	User          string              `json:"user,omitempty"` // user who made the request (job.Auth.User)
+
+	// Globals are read-only values given to every job that implements
+	// job.UsesGlobals, set by the request spec (globals:)
+	Globals map[string]interface{} `json:"globals,omitempty"`
+
+	// Overrides are read-only execution overrides given to every job that
+	// implements job.UsesOverrides, set when the request is created
+	// (CreateRequest.Overrides)
+	Overrides map[string]string `json:"overrides,omitempty"`
+
+	// Webhooks of sequences in the chain (sequence spec webhooks:), notified
+	// by the Job Runner
+	Webhooks []SequenceWebhook `json:"webhooks,omitempty"`
+
+	// Escalations of sequences in the chain (sequence spec escalations:),
+	// notified by the Job Runner while gate jobs wait for approval
+	Escalations []SequenceEscalation `json:"escalations,omitempty"`
+
+	// StrictFailure makes the Job Runner stop the chain and fail it when a job
+	// fails and its sequence cannot be retried, instead of running independent
+	// jobs and sequences. Set by the request spec (strictFailure:) or when
+	// the request is created (CreateRequest.StrictFailure).
+	StrictFailure bool `json:"strictFailure,omitempty"`
+}
+
+// Sequence events sent to sequence webhooks.
+const (
+	SEQUENCE_EVENT_START    = "start"    // first job of the sequence ran (every sequence try)
+	SEQUENCE_EVENT_COMPLETE = "complete" // every job of the sequence completed
+	SEQUENCE_EVENT_FAIL     = "fail"     // a job of the sequence failed and the sequence cannot be retried
+
+	// A gate job of the sequence has waited for approval longer than an
+	// escalation step (SequenceEscalation). Only sent to escalations.
+	SEQUENCE_EVENT_APPROVAL_PENDING = "approval-pending"
+)
+
+// SequenceWebhook is a webhook for one sequence in a job chain. The sequence is
+// identified by its first and last jobs; JobIds are all its jobs, including
+// jobs of its subsequences.
+type SequenceWebhook struct {
+	URL        string                 `json:"url"`
+	Events     []string               `json:"events,omitempty"` // SEQUENCE_EVENT_* consts, all if empty
+	Sequence   string                 `json:"sequence"`         // sequence name
+	StartJobId string                 `json:"startJobId"`
+	EndJobId   string                 `json:"endJobId"`
+	JobIds     []string               `json:"jobIds"`
+	Args       map[string]interface{} `json:"args,omitempty"` // exported jobArgs
+}
+
+// SequenceEscalation is one step of the escalation chain of a sequence in a job
+// chain. When a gate job in the sequence has waited for approval for After, the
+// Job Runner POSTs a SequenceEvent (event approval-pending) to URL. Like webhooks,
+// the sequence is identified by its first job; JobIds are all its jobs, including
+// jobs of its subsequences.
+type SequenceEscalation struct {
+	URL        string   `json:"url"`
+	After      string   `json:"after"` // duration string: "N{ms|s|m|h}"
+	Step       uint     `json:"step"`  // position in the escalation chain of the sequence, starting at 1
+	Sequence   string   `json:"sequence"`
+	StartJobId string   `json:"startJobId"`
+	JobIds     []string `json:"jobIds"`
+}
+
+// SequenceEvent is POSTed to a sequence webhook or escalation.
+type SequenceEvent struct {
+	Event      string                 `json:"event"` // SEQUENCE_EVENT_* const
+	RequestId  string                 `json:"requestId"`
+	Sequence   string                 `json:"sequence"`
+	StartJobId string                 `json:"startJobId"`           // SequenceWebhook.StartJobId
+	Try        uint                   `json:"try"`                  // sequence try
+	JobId      string                 `json:"jobId,omitempty"`      // failed job (event fail) or gate job (event approval-pending)
+	Args       map[string]interface{} `json:"args,omitempty"`       // SequenceWebhook.Args
+	Escalation uint                   `json:"escalation,omitempty"` // SequenceEscalation.Step (event approval-pending)
+	Pending    int64                  `json:"pending,omitempty"`    // how long the gate job has waited (nanoseconds, event approval-pending)
+	Ts         time.Time              `json:"ts"`
+}
+
+// SlowJobEvent is POSTed to a slow job webhook (Job Runner config slow_jobs)
+// when a job runs longer than expected. The job keeps running.
+type SlowJobEvent struct {
+	RequestId string    `json:"requestId"`
+	JobId     string    `json:"jobId"`
+	JobName   string    `json:"jobName"`
+	Type      string    `json:"type"`      // job type
+	Expected  int64     `json:"expected"`  // expected duration (nanoseconds)
+	Threshold int64     `json:"threshold"` // duration after which the job is slow (nanoseconds)
+	Running   int64     `json:"running"`   // how long the job has been running (nanoseconds)
+	Ts        time.Time `json:"ts"`
 }
 
 // Request represents something that a user asks Spin Cycle to do.
@@ -93,6 +335,10 @@ type Request struct {
 	User  string       `json:"user"`           // the user who made the request
 	Args  []RequestArg `json:"args,omitempty"` // final request args (request_archives.args)
 
+	// Operator is the admin who made the request on behalf of User (run-as),
+	// else empty. See RUN_AS_HEADER.
+	Operator string `json:"operator,omitempty"`
+
 	CreatedAt  time.Time  `json:"createdAt"`  // when the request was created
 	StartedAt  *time.Time `json:"startedAt"`  // when the request was sent to the job runner
 	FinishedAt *time.Time `json:"finishedAt"` // when the job runner finished the request. doesn't indicate success/failure
@@ -101,7 +347,17 @@ type Request struct {
 	TotalJobs    uint      `json:"totalJobs"`    // number of jobs in the request's job chain
 	FinishedJobs uint      `json:"finishedJobs"` // number of jobs that ran and finished with state = STATE_COMPLETE
 
+	// Progress is the percentage (0-100) of the request's work done: the weight
+	// (Job.Weight) of complete jobs over the weight of all jobs. Unlike
+	// FinishedJobs, it never decreases when a sequence is retried, and it's
+	// 100 only when the request is complete.
+	Progress uint `json:"progress"`
+
 	JobRunnerURL string `json:"jrURL,omitempty"` // URL of the job runner running the request
+
+	DedupKey string `json:"dedupKey,omitempty"` // request spec dedupKey made from request args
+
+	SpecVersion string `json:"specVersion,omitempty"` // version of the request spec the job chain was made from
 }
 
 // SuspendedJobChain (SJC) represents the data required to reconstruct and resume a
@@ -127,16 +383,28 @@ type SuspendedJobChain struct {
 
 // RequestSpec represents the metadata of a request necessary to start the request.
 type RequestSpec struct {
-	Name string
-	Args []RequestArg
+	Name      string
+	Args      []RequestArg
+	Version   string            // current version of the request spec (see Request.SpecVersion)
+	Overrides []RequestOverride `json:",omitempty"` // allowed CreateRequest.Overrides
+}
+
+// RequestOverride is an execution override allowed by a request spec (overrides:).
+// If Values is set, the value must be one of them, and if Pattern is set, the
+// whole value must match the regexp.
+type RequestOverride struct {
+	Name    string
+	Desc    string   `json:",omitempty"`
+	Values  []string `json:",omitempty"`
+	Pattern string   `json:",omitempty"`
 }
 
 // RequestArg represents an request argument and its metadata.
 type RequestArg struct {
-	Pos     int // position in request spec relative to required:, optional:, or static: stanza
+	Pos     int // position in request spec relative to required:, optional:, static:, or derived: stanza
 	Name    string
 	Desc    string
-	Type    string      // required, optional, static
+	Type    string      // required, optional, static, derived
 	Given   bool        // true if Required or Optional and value given
 	Default interface{} // default value if Optional or Static
 	Value   interface{} // final value
@@ -146,6 +414,7 @@ const (
 	ARG_TYPE_REQUIRED = "required"
 	ARG_TYPE_OPTIONAL = "optional"
 	ARG_TYPE_STATIC   = "static"
+	ARG_TYPE_DERIVED  = "derived" // computed from other request args (spec args derived:)
 )
 
 // JobLog represents a log entry for a finished job.
@@ -165,6 +434,34 @@ type JobLog struct {
 	Error  string `json:"error"`  // error message
 	Stdout string `json:"stdout"` // stdout output
 	Stderr string `json:"stderr"` // stderr output
+
+	ErrorClass string `json:"errorClass,omitempty"` // ERROR_CLASS_* const returned by the job, if any
+
+	Data map[string]interface{} `json:"data,omitempty"` // job data after job completed (only if state = STATE_COMPLETE)
+	Log  []LogEntry             `json:"log,omitempty"`  // entries logged by the job during the try (job.Logger)
+
+	Resources []string `json:"resources,omitempty"` // resources affected during the try (job.Resources), sorted
+
+	Execs []ExecRecord `json:"execs,omitempty"` // external commands run during the try (job.Execs), in order started
+}
+
+// ExecRecord is one external command run by a job with its job.Execs. The exec
+// audit of a try (JobLog.Execs) is a trail of what the Job Runner executed.
+type ExecRecord struct {
+	Args      []string `json:"args"`            // command line, including the program
+	Dir       string   `json:"dir,omitempty"`   // working directory, if set
+	StartedAt int64    `json:"startedAt"`       // when started (UnixNano)
+	Duration  int64    `json:"duration"`        // how long it ran (nanoseconds)
+	Exit      int      `json:"exit"`            // exit code, or -1 if it did not start or was killed by a signal
+	Error     string   `json:"error,omitempty"` // why it did not start or exit, if Exit = -1
+}
+
+// LogEntry is one entry logged by a job with its job.Logger.
+type LogEntry struct {
+	Ts     int64                  `json:"ts"`               // when logged (UnixNano)
+	Level  string                 `json:"level"`            // LOG_LEVEL_* const
+	Msg    string                 `json:"msg"`              // message
+	Fields map[string]interface{} `json:"fields,omitempty"` // structured fields
 }
 
 type JobLogById []JobLog
@@ -175,18 +472,45 @@ func (jls JobLogById) Less(i, j int) bool {
 }
 func (jls JobLogById) Swap(i, j int) { jls[i], jls[j] = jls[j], jls[i] }
 
+// JobLogDelete represents job log entries deleted by the job log delete and purge APIs.
+type JobLogDelete struct {
+	Deleted        int64      `json:"deleted"`                  // job log entries deleted
+	FinishedBefore *time.Time `json:"finishedBefore,omitempty"` // purge: requests finished before this time
+}
+
 // JobStatus represents the status of one job in a job chain.
 type JobStatus struct {
 	RequestId string `json:"requestId"`
 	JobId     string `json:"jobId"`
 	Type      string `json:"type"`
 	Name      string `json:"name"`
+	Desc      string `json:"desc,omitempty"`   // human-readable description of job (spec desc:)
 	StartedAt int64  `json:"startedAt"`        // when job started (UnixNano)
 	State     byte   `json:"state"`            // usually proto.STATE_RUNNING
 	Status    string `json:"status,omitempty"` // real-time status, if running
 	Try       uint   `json:"try"`              // try number, can be >1+retry on sequence retry
+	// Sequence (ID of its start job) that the job belongs to, and its try number.
+	// In an each: expansion, every branch is its own sequence that retries on its
+	// own, so this is the branch retry count.
+	SequenceId  string `json:"sequenceId,omitempty"`
+	SequenceTry uint   `json:"sequenceTry,omitempty"`
+	// Why the job is held: runnable but not running yet (HOLD_* const), empty if
+	// not held, since when (UnixNano), and details like when the hold ends. A job
+	// waiting for a singleton lock is held although its state is STATE_RUNNING.
+	Hold       string `json:"hold,omitempty"`
+	HoldDetail string `json:"holdDetail,omitempty"`
+	HeldSince  int64  `json:"heldSince,omitempty"`
 }
 
+// Hold reasons (JobStatus.Hold): why a runnable job is not running yet.
+const (
+	HOLD_PAUSED         = "paused"         // request paused (spinc pause)
+	HOLD_PACE           = "pace"           // paced fan-out slowed because its jobs are throttled
+	HOLD_SEQUENCE_RETRY = "sequence-retry" // waiting sequence retryWait before retrying the sequence
+	HOLD_SINGLETON      = "singleton"      // waiting for the singleton lock held by another job
+	HOLD_CONCURRENCY    = "concurrency"    // max jobs of the request running (JR config concurrency)
+)
+
 // JobStatusByStartTime sorts []JobStatus by StartedAt ascending (oldest jobs first).
 type JobStatusByStartTime []JobStatus
 
@@ -194,10 +518,65 @@ func (js JobStatusByStartTime) Len() int           { return len(js) }
 func (js JobStatusByStartTime) Less(i, j int) bool { return js[i].StartedAt < js[j].StartedAt }
 func (js JobStatusByStartTime) Swap(i, j int)      { js[i], js[j] = js[j], js[i] }
 
+// SchedulingStats reports scheduling latency: how long runnable jobs wait
+// before a runner picks them up, and how many jobs are waiting (queue depth).
+// High wait times with fast jobs indicate an under-provisioned Job Runner.
+// Sequence retry waits are not counted. Wait times are nanoseconds.
+type SchedulingStats struct {
+	RequestId string `json:"requestId,omitempty"` // empty for Job Runner aggregate
+	Queued    uint   `json:"queued"`              // runnable jobs waiting for a runner
+	Started   uint   `json:"started"`             // jobs picked up by a runner
+	WaitTotal int64  `json:"waitTotal"`           // sum of wait times of started jobs
+	WaitAvg   int64  `json:"waitAvg"`             // WaitTotal / Started
+	WaitMax   int64  `json:"waitMax"`             // longest wait time
+}
+
+// SchedulingStatus represents scheduling latency per chain and for all chains
+// running on a Job Runner. It is returned by Job Runner GET /api/v1/status/scheduling
+type SchedulingStatus struct {
+	JobRunner SchedulingStats   `json:"jobRunner"`
+	Chains    []SchedulingStats `json:"chains"`
+}
+
+// Reapers of a running chain (ChainDebug.Reaper). The running reaper reaps done
+// jobs and runs the next jobs. When the chain is stopped or suspended, it's
+// switched for the stopped or suspended reaper, which only reaps done jobs.
+const (
+	REAPER_RUNNING   = "running"
+	REAPER_STOPPED   = "stopped"
+	REAPER_SUSPENDED = "suspended"
+	REAPER_DONE      = "done" // no reaper: chain finished, being removed
+)
+
+// ChainDebug is goroutine and job runner accounting for one chain running on a
+// Job Runner, to diagnose leaked goroutines and stuck reapers. A job goroutine
+// runs one job: it waits to run (paused, paced, sequence retry wait, concurrency
+// limit), runs the job, then sends it to the reaper.
+type ChainDebug struct {
+	RequestId   string `json:"requestId"`
+	Reaper      string `json:"reaper"`      // REAPER_* const
+	Paused      bool   `json:"paused"`      // not starting new jobs
+	Goroutines  int64  `json:"goroutines"`  // job goroutines
+	Pending     int64  `json:"pending"`     // job goroutines without a job runner yet
+	Runners     int    `json:"runners"`     // active job runners
+	JobsRunning uint   `json:"jobsRunning"` // jobs with state STATE_RUNNING, usually equal to Runners
+	Queued      int64  `json:"queued"`      // runnable jobs queued, no job goroutine yet
+	DoneBacklog int64  `json:"doneBacklog"` // done jobs waiting for the reaper to receive them
+}
+
+// RunnerDebug is goroutine and job runner accounting for the chains running on a
+// Job Runner. It is returned by Job Runner GET /api/v1/status/debug
+type RunnerDebug struct {
+	Goroutines int          `json:"goroutines"` // all goroutines in the Job Runner process
+	Runners    int          `json:"runners"`    // active job runners of all Chains
+	Chains     []ChainDebug `json:"chains"`     // sorted by RequestId
+}
+
 // RequestProgress updates request progress from the Job Runner.
 type RequestProgress struct {
 	RequestId    string `json:"requestId"`
 	FinishedJobs uint   `json:"finishedJobs"` // number of jobs that ran and finished with state = STATE_COMPLETE
+	Progress     uint   `json:"progress"`     // Request.Progress
 }
 
 // RunningStatus represents running jobs and their requests. It is returned by
@@ -207,10 +586,19 @@ type RunningStatus struct {
 	Requests map[string]Request `json:"requests"` // keyed on RequestId
 }
 
+// RequestsStatus represents the status of many requests. It is returned by
+// Request Manager POST /api/v1/requests/status given a list of request IDs.
+type RequestsStatus struct {
+	Requests []Request `json:"requests"`           // in the order given, without job chains
+	NotFound []string  `json:"notFound,omitempty"` // request IDs not found
+}
+
 // StatusFilter represents optional filters for status requests.
 type StatusFilter struct {
 	RequestId string
-	OrderBy   string // startTime
+	OrderBy   string    // startTime
+	AsOf      time.Time // status as of a past time, if not zero (RM only)
+	Held      bool      // also return held jobs: runnable but not running yet (JobStatus.Hold)
 }
 
 func (f StatusFilter) String() string {
@@ -221,17 +609,122 @@ func (f StatusFilter) String() string {
 	if f.OrderBy != "" {
 		q = append(q, "orderBy="+strings.ToLower(f.OrderBy))
 	}
+	if !f.AsOf.IsZero() {
+		q = append(q, "asOf="+url.QueryEscape(f.AsOf.UTC().Format(time.RFC3339Nano)))
+	}
+	if f.Held {
+		q = append(q, "held=true")
+	}
 	if len(q) == 0 {
 		return ""
 	}
 	return "?" + strings.Join(q, "&")
 }
 
+// RerunRequest represents the payload to create and start a new request that
+// reruns a job and every job downstream of it in a finished request. Job data
+// for the rerun is seeded from the original run of the upstream jobs.
+//
+// A rerun is pinned to the spec version of the original request: its jobs are
+// copied from the original job chain, even if the request spec has changed
+// since. Rebase is the explicit override: the whole request is rerun with a job
+// chain made from the current spec and the original request args.
+type RerunRequest struct {
+	RequestId    string `json:"requestId"`              // original request
+	JobId        string `json:"jobId"`                  // first job to rerun (start job of sequence to rerun a sequence)
+	User         string `json:"user"`                   // the user making the request
+	Rebase       bool   `json:"rebase"`                 // rerun the whole request from the current spec (jobId must be empty)
+	SkipSequence string `json:"skipSequence,omitempty"` // skip this failed skippable sequence (first job ID) and rerun every job downstream of it (jobId must be empty)
+}
+
+// CreateRequestFromChain represents the payload to create and start a request
+// from a job chain instead of a request spec, like a job chain exported from
+// another request or made by an external planner. The Request Manager does not
+// make the jobs, so their bytes and args are run as-is, which is why only admins
+// can create requests from job chains. Job IDs are kept, and job states are reset
+// to pending.
+type CreateRequestFromChain struct {
+	Type     string   `json:"type"`     // request type, for reporting; need not be a request spec
+	JobChain JobChain `json:"jobChain"` // jobs, adjacency list, and globals to run
+	User     string   `json:"user"`     // the user making the request
+}
+
+// AddJob represents the payload to add a job to a running request. The job
+// type must be one of the types allowed by the Request Manager config (add_job.types).
+// The job runs after the given job completes, before the last job in the chain.
+type AddJob struct {
+	After string                 `json:"after"`          // job ID to run after
+	Type  string                 `json:"type"`           // job type
+	Name  string                 `json:"name,omitempty"` // job name (default: type)
+	Args  map[string]interface{} `json:"args,omitempty"` // job args given to Job.Create
+}
+
+// AddChainJob represents the payload the Request Manager sends to the Job Runner
+// to add a job to a running job chain after job After.
+type AddChainJob struct {
+	After string `json:"after"`
+	Job   Job    `json:"job"`
+}
+
+// ApproveJob represents the payload the Request Manager sends to the Job Runner
+// to approve a gate job (package gate) waiting for approval.
+type ApproveJob struct {
+	User string `json:"user"` // user who approved the job
+}
+
 // CreateRequest represents the payload to create and start a new request.
 type CreateRequest struct {
 	Type string                 // the type of request being made
 	Args map[string]interface{} // the arguments for the request
 	User string                 // the user making the request
+
+	// Operator is the admin making the request on behalf of User (run-as).
+	// It's set by the API, like User.
+	Operator string `json:",omitempty"`
+
+	// StrictFailure fails the request on the first job failure that cannot
+	// be retried (see JobChain.StrictFailure). If the request spec sets
+	// strictFailure: true, the request is strict even if this is false.
+	StrictFailure bool `json:",omitempty"`
+
+	// LogLevel is the log level of every job in the request (Job.LogLevel),
+	// like "debug" to save verbose job log entries for this request only.
+	// The default is info.
+	LogLevel string `json:",omitempty"`
+
+	// Overrides are execution overrides for this request only, like feature
+	// flags and endpoints, given to every job (JobChain.Overrides). Only
+	// overrides allowed by the request spec (overrides:) can be set.
+	Overrides map[string]string `json:",omitempty"`
+}
+
+const (
+	GROUP_POLICY_PARALLEL   = "parallel"   // start all requests at once
+	GROUP_POLICY_SEQUENTIAL = "sequential" // start each request after the previous one finishes
+)
+
+// CreateRequestGroup represents the payload to create and start a request group:
+// requests that are run with a policy and stopped together.
+type CreateRequestGroup struct {
+	Policy        string          `json:"policy"`        // GROUP_POLICY_* const (default: parallel)
+	StopOnFailure bool            `json:"stopOnFailure"` // stop the group when a request fails or is stopped
+	Requests      []CreateRequest `json:"requests"`      // in run order
+}
+
+// RequestGroup represents a group of requests. Its state is RUNNING until every
+// request has finished or the group is stopped; then it's COMPLETE if every
+// request completed, STOPPED if the group was stopped, else FAIL. Requests not
+// started when the group stops are failed.
+type RequestGroup struct {
+	Id            string     `json:"id"`
+	Policy        string     `json:"policy"` // GROUP_POLICY_* const
+	StopOnFailure bool       `json:"stopOnFailure"`
+	State         byte       `json:"state"` // STATE_* const
+	User          string     `json:"user"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	FinishedAt    *time.Time `json:"finishedAt"`
+	RequestIds    []string   `json:"requestIds"`         // in run order
+	Requests      []Request  `json:"requests,omitempty"` // in run order, without job chains
 }
 
 // FinishRequest represents the payload to tell the RM that a request has finished.
@@ -240,6 +733,472 @@ type FinishRequest struct {
 	State        byte      `json:"state"`        // the final state of the chain
 	FinishedAt   time.Time `json:"finishedAt"`   // when the Job Runner finished the request
 	FinishedJobs uint      `json:"finishedJobs"` // number of jobs that ran and finished with state = STATE_COMPLETE
+	Progress     uint      `json:"progress"`     // Request.Progress
+}
+
+// ShadowRun compares a request to its shadow run: a copy of its job chain run
+// on the shadow Job Runner pool. Shadow job states are from the job log of the
+// shadow run.
+type ShadowRun struct {
+	RequestId    string      `json:"requestId"`
+	ShadowId     string      `json:"shadowId"`             // request ID of the job chain copy
+	JobRunnerURL string      `json:"jrURL,omitempty"`      // shadow JR running the copy
+	State        byte        `json:"state"`                // request state
+	ShadowState  byte        `json:"shadowState"`          // shadow run state
+	CreatedAt    time.Time   `json:"createdAt"`            // when the shadow run was started
+	FinishedAt   *time.Time  `json:"finishedAt,omitempty"` // when the shadow run finished
+	Jobs         []ShadowJob `json:"jobs"`                 // jobs in either run, sorted by job ID
+	Match        bool        `json:"match"`                // both finished with same request and job states
+	Mismatches   []string    `json:"mismatches,omitempty"` // why Match is false
+}
+
+// ShadowJob is the final state of one job in a request and its shadow run.
+type ShadowJob struct {
+	JobId       string `json:"jobId"`
+	Name        string `json:"name"`
+	State       byte   `json:"state"`       // final state in request, STATE_PENDING if not ran
+	ShadowState byte   `json:"shadowState"` // final state in shadow run, STATE_PENDING if not ran
+	Tries       uint   `json:"tries"`       // last try in request
+	ShadowTries uint   `json:"shadowTries"` // last try in shadow run
+}
+
+// Arg sources in ArgDiff.
+const (
+	ARG_SOURCE_GIVEN    = "given"    // request arg value given by caller
+	ARG_SOURCE_DEFAULT  = "default"  // optional or static request arg default value
+	ARG_SOURCE_COMPUTED = "computed" // derived request arg computed from other request args
+	ARG_SOURCE_CHANGED  = "changed"  // request arg value changed by a job or sequence
+	ARG_SOURCE_DERIVED  = "derived"  // not a request arg: set by a job or sequence
+	ARG_SOURCE_JOB      = "job"      // set by a job (Create), provenance only
+	ARG_SOURCE_EACH     = "each"     // element of an each: list, provenance only
+)
+
+// ArgSource is where a jobArg value came from, recorded when the request graph
+// is built: given by the caller, a spec default, set by a job, or an element of
+// an each: list. Renaming an arg (node args:, sets: as:) does not change where
+// its value came from.
+type ArgSource struct {
+	Source   string `json:"source"`             // ARG_SOURCE_GIVEN, _DEFAULT, _COMPUTED, _JOB, or _EACH
+	Sequence string `json:"sequence,omitempty"` // sequence of the default or the node that set it
+	Node     string `json:"node,omitempty"`     // node that set it (job or each:)
+	JobId    string `json:"jobId,omitempty"`    // job that set it
+	Arg      string `json:"arg,omitempty"`      // arg name in the job that set it, or list arg of each:
+}
+
+// RequestArgsDiff shows how the args submitted for a request were resolved into
+// the jobArgs of each job, to debug why a job got a certain value.
+type RequestArgsDiff struct {
+	RequestId string                 `json:"requestId"`
+	Submitted map[string]interface{} `json:"submitted"` // raw args as submitted (CreateRequest.Args)
+	Args      []RequestArg           `json:"args"`      // final request args
+	Jobs      []JobArgsDiff          `json:"jobs"`      // sorted by job ID
+}
+
+// JobArgsDiff is the resolved jobArgs of one job compared to the request args.
+type JobArgsDiff struct {
+	JobId string    `json:"jobId"`
+	Name  string    `json:"name"`
+	Args  []ArgDiff `json:"args"` // sorted by arg name
+}
+
+// ArgDiff is one resolved jobArg.
+type ArgDiff struct {
+	Name    string      `json:"name"`
+	Source  string      `json:"source"`            // ARG_SOURCE_* const
+	Request interface{} `json:"request,omitempty"` // final request arg value, nil if derived
+	Value   interface{} `json:"value"`             // resolved jobArg value
+	Origin  *ArgSource  `json:"origin,omitempty"`  // where the value came from, nil if not recorded
+}
+
+// RequestTimeline is when each job in a request ran, for rendering a Gantt
+// chart. It's made from the job log and, if the request is running, the jobs
+// running now. Jobs that have not run are not included.
+type RequestTimeline struct {
+	RequestId  string        `json:"requestId"`
+	Type       string        `json:"type"`
+	State      byte          `json:"state"`
+	StartedAt  *time.Time    `json:"startedAt"`
+	FinishedAt *time.Time    `json:"finishedAt"`
+	Jobs       []TimelineJob `json:"jobs"` // sorted by StartedAt, then JobId
+}
+
+// TimelineJob is one job in a RequestTimeline.
+type TimelineJob struct {
+	JobId      string        `json:"jobId"`
+	Name       string        `json:"name"`
+	Type       string        `json:"type"`
+	State      byte          `json:"state"`      // state of last try, STATE_RUNNING if running
+	StartedAt  int64         `json:"startedAt"`  // when first try started (UnixNano)
+	FinishedAt int64         `json:"finishedAt"` // when last try finished (UnixNano), 0 if running
+	Tries      []TimelineTry `json:"tries"`      // in try order
+}
+
+// TimelineTry is one try of a TimelineJob.
+type TimelineTry struct {
+	Try        uint  `json:"try"`
+	State      byte  `json:"state"`
+	StartedAt  int64 `json:"startedAt"`  // UnixNano
+	FinishedAt int64 `json:"finishedAt"` // UnixNano, 0 if running
+}
+
+// StopPreview is what stopping a running request would interrupt, so operators
+// can see the blast radius before stopping it. It's made from the job chain, the
+// job log, and the jobs running now; nothing is stopped.
+type StopPreview struct {
+	RequestId string                `json:"requestId"`
+	Type      string                `json:"type"`
+	State     byte                  `json:"state"`
+	Running   []StopPreviewJob      `json:"running"`   // jobs stopped while running (STOP_REASON_USER), sorted by JobId
+	Sequences []StopPreviewSequence `json:"sequences"` // sequences that started but would not complete, sorted by SequenceId
+	Finally   []StopPreviewJob      `json:"finally"`   // finally jobs run after the running jobs stop, sorted by JobId
+	NotRun    uint                  `json:"notRun"`    // jobs that have not run and would not run, not counting finally jobs
+}
+
+// StopPreviewJob is one job in a StopPreview.
+type StopPreviewJob struct {
+	JobId      string `json:"jobId"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	SequenceId string `json:"sequenceId"`
+}
+
+// StopPreviewSequence is a sequence in a StopPreview left partially complete.
+// Finally jobs are not counted.
+type StopPreviewSequence struct {
+	SequenceId string `json:"sequenceId"` // Job.Id of first job in sequence
+	Name       string `json:"name"`       // name of first job in sequence
+	Complete   uint   `json:"complete"`   // jobs complete
+	Total      uint   `json:"total"`      // jobs in sequence
+	Skippable  bool   `json:"skippable"`  // sequence can be skipped on rerun (Job.SequenceSkippable)
+}
+
+// CreateToken represents the payload to create an API token for the caller.
+// The token has the caller's roles, resolved when the token is used, further
+// limited to the given ops and requests, if any.
+type CreateToken struct {
+	Name     string   `json:"name"`               // user-defined description, like "laptop"
+	Ops      []string `json:"ops,omitempty"`      // REQUEST_OP_* allowed; all ops if empty
+	Requests []string `json:"requests,omitempty"` // request names allowed ("prefix*" matches prefix); all if empty
+	TTL      string   `json:"ttl,omitempty"`      // time.Duration string; default and max is auth.token_max_ttl
+	ReadOnly bool     `json:"readOnly,omitempty"` // token can only view (GET), like a read-only role
+}
+
+// Token represents an API token. The secret is only returned when the token
+// is created; it is stored hashed and cannot be retrieved again. Callers send
+// it in an "Authorization: Bearer <secret>" header.
+type Token struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	User      string     `json:"user"`               // caller name when created, used as proto.Request.User
+	Roles     []string   `json:"roles,omitempty"`    // user roles, only set when authenticated
+	Ops       []string   `json:"ops,omitempty"`      // REQUEST_OP_* allowed; all ops if empty
+	Requests  []string   `json:"requests,omitempty"` // request names allowed; all if empty
+	ReadOnly  bool       `json:"readOnly,omitempty"` // can only view (GET)
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	Secret    string     `json:"secret,omitempty"` // only set when created
+}
+
+// Maintenance represents Request Manager maintenance mode. When enabled, new
+// requests are not created (HTTP 503), but existing requests can be queried and
+// stopped. It is the payload to change maintenance mode, which only sets Enabled
+// and Reason.
+type Maintenance struct {
+	Enabled   bool       `json:"enabled"`
+	Reason    string     `json:"reason,omitempty"`    // why, returned to callers of new requests
+	User      string     `json:"user,omitempty"`      // who last changed it; not set if from config
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"` // when last changed; not set if from config
+}
+
+// Ready represents the readiness of the Request Manager, returned by GET /ready.
+// The Request Manager is ready in maintenance mode because it still serves
+// existing requests.
+type Ready struct {
+	Ready       bool        `json:"ready"`
+	Maintenance Maintenance `json:"maintenance"`
+}
+
+// JobCost represents the cost of one finished job try, which is recorded for
+// the usage API and reported to the cost reporter plugin.
+type JobCost struct {
+	RequestId   string    `json:"requestId"`
+	JobId       string    `json:"jobId"`
+	Try         uint      `json:"try"`
+	JobType     string    `json:"jobType"`
+	RequestType string    `json:"requestType"`
+	User        string    `json:"user"`      // proto.Request.User
+	Namespace   string    `json:"namespace"` // from cost reporter plugin, like a team
+	State       byte      `json:"state"`     // STATE_* const
+	Duration    int64     `json:"duration"`  // nanoseconds
+	FinishedAt  time.Time `json:"finishedAt"`
+}
+
+// UsageFilter represents how to aggregate job costs for the usage API.
+type UsageFilter struct {
+	GroupBy   string    // USAGE_GROUP_BY_* const (default: USAGE_GROUP_BY_NAMESPACE)
+	Interval  string    // USAGE_INTERVAL_* const to aggregate over time (default: whole time range)
+	Namespace string    // only this namespace
+	User      string    // only this user
+	Since     time.Time // jobs finished at or after
+	Until     time.Time // jobs finished before
+}
+
+const (
+	USAGE_GROUP_BY_NAMESPACE    = "namespace"
+	USAGE_GROUP_BY_USER         = "user"
+	USAGE_GROUP_BY_JOB_TYPE     = "jobType"
+	USAGE_GROUP_BY_REQUEST_TYPE = "requestType"
+
+	USAGE_INTERVAL_HOUR  = "hour"
+	USAGE_INTERVAL_DAY   = "day"
+	USAGE_INTERVAL_MONTH = "month"
+)
+
+// Usage represents aggregate job costs for one group, like one namespace, and
+// one period if the usage filter has an interval.
+type Usage struct {
+	Group    string     `json:"group"`            // value of the UsageFilter.GroupBy field
+	Period   *time.Time `json:"period,omitempty"` // start of period (UTC) if UsageFilter.Interval set
+	Jobs     uint       `json:"jobs"`             // job tries
+	Duration int64      `json:"duration"`         // total nanoseconds
+}
+
+const (
+	STATS_WINDOW_HOUR = "1h"
+	STATS_WINDOW_DAY  = "24h"
+	STATS_WINDOW_WEEK = "7d"
+)
+
+// JobTypeStats represents failure analytics for all job types that finished
+// jobs in the window, computed periodically by the Request Manager from job logs.
+// Job types are sorted by failure rate, highest first.
+type JobTypeStats struct {
+	Window     string        `json:"window"`     // STATS_WINDOW_* const
+	ComputedAt time.Time     `json:"computedAt"` // when the stats were computed
+	JobTypes   []JobTypeStat `json:"jobTypes"`
+}
+
+// JobTypeStat represents failure analytics for one job type. A job is failed if
+// a try failed and no try completed, and retried if it has more than one try.
+type JobTypeStat struct {
+	Type         string       `json:"type"`
+	Jobs         uint         `json:"jobs"`         // distinct jobs (request and job ID)
+	Tries        uint         `json:"tries"`        // job tries
+	FailedJobs   uint         `json:"failedJobs"`   // jobs that failed and never completed
+	RetriedJobs  uint         `json:"retriedJobs"`  // jobs with more than one try
+	FailureRate  float64      `json:"failureRate"`  // FailedJobs / Jobs
+	RetryRate    float64      `json:"retryRate"`    // RetriedJobs / Jobs
+	MeanDuration int64        `json:"meanDuration"` // nanoseconds per try
+	TopErrors    []ErrorCount `json:"topErrors,omitempty"`
+}
+
+// ErrorCount represents how many failed job tries returned the same error.
+// Errors are truncated to 200 characters so that errors which differ only at
+// the end, like by a host name, are counted together.
+type ErrorCount struct {
+	Error string `json:"error"`
+	Count uint   `json:"count"`
+}
+
+// JobRunner represents a Job Runner instance registered with the Request Manager.
+// Job Runners send it as a heartbeat. The Request Manager sets HeartbeatAt and Alive.
+type JobRunner struct {
+	URL          string            `json:"url"`                    // base URL, same as Request.JobRunnerURL
+	Labels       map[string]string `json:"labels,omitempty"`       // like zone: us-east-1a
+	Capabilities []string          `json:"capabilities,omitempty"` // like docker, gpu; jobs that need them (Job.Needs) run only on JRs that have them
+	Capacity     uint              `json:"capacity"`               // job chains it should run, 0 = no limit
+	Running      uint              `json:"running"`                // job chains running
+	StartedAt    time.Time         `json:"startedAt"`
+	HeartbeatAt  time.Time         `json:"heartbeatAt"` // last heartbeat
+	Alive        bool              `json:"alive"`       // last heartbeat within the registry timeout
+}
+
+// Singleton job policies (spec singletonPolicy) for when the singleton lock
+// is held by another job.
+const (
+	SINGLETON_POLICY_QUEUE = "queue" // wait for the lock (default)
+	SINGLETON_POLICY_FAIL  = "fail"  // fail the job without running it
+)
+
+// SingletonLock is a lock held by a singleton job while it runs, so only one job
+// of its type (or type and key arg value) runs anywhere at a time. Name is
+// Job.Singleton. Job Runners acquire the lock from the Request Manager before
+// running the job and release it after. A lock held by a request that is not
+// running (e.g. its Job Runner crashed) is stale and acquired by the next job.
+type SingletonLock struct {
+	Name       string    `json:"name"`
+	RequestId  string    `json:"requestId"` // holder
+	JobId      string    `json:"jobId"`     // holder
+	AcquiredAt time.Time `json:"acquiredAt"`
+}
+
+// JobSnapshot is what a job of a past request needs to run again outside Spin
+// Cycle, for debugging job code against real inputs (spinc replay-job): the job
+// as created (Bytes and Args), the jobData it got from upstream jobs (Job.Data),
+// and the request globals, overrides, and user. Last is the last try of the job,
+// if it ran.
+type JobSnapshot struct {
+	RequestId string                 `json:"requestId"`
+	User      string                 `json:"user"`
+	Globals   map[string]interface{} `json:"globals,omitempty"`
+	Overrides map[string]string      `json:"overrides,omitempty"`
+	Job       Job                    `json:"job"`
+	Last      *JobLog                `json:"last,omitempty"`
+}
+
+// ChainStatus is the status of a job chain in a Job Runner: running, or done
+// and retained for a while (Job Runner config chain_retention). It is returned
+// by Job Runner GET /api/v1/job-chains.
+type ChainStatus struct {
+	RequestId    string `json:"requestId"`
+	State        byte   `json:"state"`
+	FinishedJobs uint   `json:"finishedJobs"`
+	Progress     uint   `json:"progress"`         // Request.Progress
+	DoneAt       int64  `json:"doneAt,omitempty"` // when done (UnixNano), zero if running
+	// Runnable jobs not running yet, and why (JobStatus.Hold), if running
+	Held []JobStatus `json:"held,omitempty"`
+}
+
+// ChainSnapshot is an internally consistent copy of a running job chain in a Job
+// Runner: its state, job states, and tries, all taken at once. It is returned by
+// Job Runner GET /api/v1/job-chains/{requestId}/snapshot for external tools that
+// analyze running chains. Version increases every time the chain changes, so
+// two snapshots with the same version are the same, and the later of two
+// snapshots has the greater version.
+type ChainSnapshot struct {
+	RequestId     string              `json:"requestId"`
+	Version       uint64              `json:"version"`
+	TakenAt       int64               `json:"takenAt"` // when taken (UnixNano)
+	State         byte                `json:"state"`   // chain state, STATE_RUNNING until done
+	Paused        bool                `json:"paused,omitempty"`
+	FinishedJobs  uint                `json:"finishedJobs"`
+	Jobs          []ChainSnapshotJob  `json:"jobs"`          // sorted by job ID
+	AdjacencyList map[string][]string `json:"adjacencyList"` // including jobs added while running
+	SequenceTries map[string]uint     `json:"sequenceTries"` // keyed on sequence ID
+}
+
+// ChainSnapshotJob is one job in a ChainSnapshot.
+type ChainSnapshotJob struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	State      byte   `json:"state"`
+	SequenceId string `json:"sequenceId"`
+	Tries      uint   `json:"tries"`          // tries in the current sequence try
+	TotalTries uint   `json:"totalTries"`     // tries in all sequence tries
+	Hold       string `json:"hold,omitempty"` // why held (HOLD_* const) if runnable but not running
+}
+
+// Faults are failures that a Job Runner with fault injection enabled injects,
+// for chaos testing retry, suspend, and resume. They are set by Job Runner
+// PUT /api/v1/faults. The zero value injects no failures.
+type Faults struct {
+	DelayProbability float64  `json:"delayProbability"`          // chance [0, 1] that a job try is delayed
+	Delay            string   `json:"delay,omitempty"`           // how long a job try is delayed, like "5s"
+	FailJobTypes     []string `json:"failJobTypes,omitempty"`    // job types whose tries fail without running
+	DropRMCalls      float64  `json:"dropRMCalls"`               // chance [0, 1] that a call to the RM fails without being sent
+	CrashAfterTries  uint     `json:"crashAfterTries,omitempty"` // JR exits after this many job tries, zero is never
+}
+
+const (
+	PROFILE_CPU  = "cpu"
+	PROFILE_HEAP = "heap"
+)
+
+// ProfileCapture asks a Job Runner with profiling enabled to profile itself
+// while a request's job chain runs: CPU for the duration, or until the chain is
+// done, then heap. The profiles are attached to the request in the RM. It is
+// sent to RM POST /api/v1/requests/${requestId}/profiles/capture (admins only).
+type ProfileCapture struct {
+	Duration string `json:"duration,omitempty"` // how long to profile CPU, like "30s" (default 30s)
+}
+
+// Profile is a pprof profile of a Job Runner captured while a request ran and
+// attached to the request. Data is only set when the profile is sent to the RM;
+// RM GET /api/v1/requests/${requestId}/profiles/${profileId} returns it as is,
+// for go tool pprof.
+type Profile struct {
+	Id         string    `json:"id"`
+	RequestId  string    `json:"requestId"`
+	Type       string    `json:"type"`      // PROFILE_CPU or PROFILE_HEAP
+	JobRunner  string    `json:"jobRunner"` // JR hostname
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Size       int       `json:"size"`
+	Data       []byte    `json:"data,omitempty"`
+}
+
+// TriggerResult is the result of a message received by a trigger: the request
+// it started or, if Duplicate, the request started by a previous message with
+// the same dedup key.
+type TriggerResult struct {
+	Trigger   string `json:"trigger"`
+	RequestId string `json:"requestId"`
+	Duplicate bool   `json:"duplicate"`
+}
+
+// TriggerError is a message in a trigger error queue: a message that could not
+// be processed, like an invalid payload or a request that could not be started.
+type TriggerError struct {
+	Id         uint64    `json:"id"`
+	Trigger    string    `json:"trigger"`
+	Payload    string    `json:"payload"`
+	Error      string    `json:"error"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// Backfill represents the progress of an online data migration (backfill) in the
+// Request Manager: data migrated in chunks in the background after a schema
+// migration. It's returned by GET /api/v1/backfills.
+type Backfill struct {
+	Name       string     `json:"name"`
+	State      string     `json:"state"`                // BACKFILL_STATE_* const
+	LastKey    string     `json:"lastKey,omitempty"`    // key of the last row migrated; the backfill resumes after it
+	Rows       uint64     `json:"rows"`                 // rows migrated
+	Error      string     `json:"error,omitempty"`      // last error, cleared by the next chunk migrated
+	RMHost     string     `json:"rmHost,omitempty"`     // Request Manager running it
+	StartedAt  *time.Time `json:"startedAt,omitempty"`  // first chunk
+	UpdatedAt  *time.Time `json:"updatedAt,omitempty"`  // last chunk
+	FinishedAt *time.Time `json:"finishedAt,omitempty"` // done
+}
+
+// Backfill states.
+const (
+	BACKFILL_STATE_PENDING = "pending" // not started
+	BACKFILL_STATE_RUNNING = "running" // started, not done
+	BACKFILL_STATE_DONE    = "done"
+)
+
+// RequestExport is where a finished request was exported: its bundle in object
+// storage. It's returned by GET /api/v1/requests/${id}/export.
+type RequestExport struct {
+	RequestId  string    `json:"requestId"`
+	Location   string    `json:"location"` // returned by the ObjectStore plugin, like s3://bucket/key
+	Size       int64     `json:"size"`     // compressed bytes
+	ExportedAt time.Time `json:"exportedAt"`
+}
+
+// RequestBundle is the complete record of a finished request exported to object
+// storage: the request with its job chain, and every job log entry. Bundles are
+// gzip-compressed JSON.
+type RequestBundle struct {
+	Request    Request   `json:"request"` // with job chain
+	JobLogs    []JobLog  `json:"jobLogs"` // all tries
+	ExportedAt time.Time `json:"exportedAt"`
+}
+
+// RequestPreset is a named preset of a request: its type and args, like a
+// nightly backup of one database. Presets are managed by the Request Manager API
+// (/api/v1/presets) and started with 'spinc start --preset <name>'.
+type RequestPreset struct {
+	Name        string                 `json:"name"`
+	Type        string                 `json:"type"`           // request type
+	Args        map[string]interface{} `json:"args,omitempty"` // request args, can be overridden when started
+	Description string                 `json:"description,omitempty"`
+	CreatedBy   string                 `json:"createdBy,omitempty"` // can change and delete it, like admins
+	CreatedAt   time.Time              `json:"createdAt"`
+	UpdatedAt   time.Time              `json:"updatedAt"`
 }
 
 // Jobs are a list of jobs sorted by id.
@@ -262,6 +1221,9 @@ type RequestFilter struct {
 	User   string            // User who made the request.
 	Args   map[string]string // Request args to filter with
 
+	// Return only requests with a job that affected this resource (job.Resources).
+	Resource string
+
 	// Return only requests that were created and run at any point within the time
 	// range. I.e. Requests created before Since but finished after Since will
 	// still be returned, as will requests created before Until but not finished
@@ -269,11 +1231,29 @@ type RequestFilter struct {
 	Since time.Time
 	Until time.Time
 
+	// Sort requests by a SORT_* field (default: SORT_CREATED) in Order SORT_ASC
+	// or SORT_DESC (default). Requests not started or finished are last when
+	// sorting by SORT_STARTED or SORT_FINISHED.
+	Sort  string
+	Order string
+
 	// Use these options for pagination of results:
 	Limit  uint // Limit response to this many requests
 	Offset uint // Skip the first <Offset> requests. Ignored if Limit is not set.
 }
 
+// RequestFilter.Sort fields and orders.
+const (
+	SORT_CREATED  = "created"
+	SORT_STARTED  = "started"
+	SORT_FINISHED = "finished"
+	SORT_STATE    = "state"
+	SORT_TYPE     = "type"
+
+	SORT_ASC  = "asc"
+	SORT_DESC = "desc"
+)
+
 // Return the query string representation of the Request Filter.
 func (f RequestFilter) String() string {
 	params := url.Values{}
@@ -293,12 +1273,21 @@ func (f RequestFilter) String() string {
 	if f.User != "" {
 		params.Add("user", f.User)
 	}
+	if f.Resource != "" {
+		params.Add("resource", f.Resource)
+	}
 	if !f.Since.IsZero() {
 		params.Add("since", f.Since.Format(time.RFC3339Nano))
 	}
 	if !f.Until.IsZero() {
 		params.Add("until", f.Until.Format(time.RFC3339Nano))
 	}
+	if f.Sort != "" {
+		params.Add("sort", f.Sort)
+	}
+	if f.Order != "" {
+		params.Add("order", f.Order)
+	}
 	if f.Limit != 0 {
 		params.Add("limit", strconv.FormatUint(uint64(f.Limit), 10))
 	}
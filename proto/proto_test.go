@@ -3,9 +3,15 @@
 package proto_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/job"
 	"github.com/square/spincycle/v2/proto"
 )
 
@@ -30,6 +36,13 @@ func TestStatusFilterString(t *testing.T) {
 	if got != expect {
 		t.Errorf("got '%s', expected '%s'", got, expect)
 	}
+
+	f = proto.StatusFilter{RequestId: "abc", Held: true}
+	expect = "?requestId=abc&held=true"
+	got = f.String()
+	if got != expect {
+		t.Errorf("got '%s', expected '%s'", got, expect)
+	}
 }
 
 func TestRequestFilterString(t *testing.T) {
@@ -63,3 +76,153 @@ func TestRequestFilterString(t *testing.T) {
 		t.Errorf("got '%s', expected '%s'", got, expect)
 	}
 }
+
+func TestWriteReadJobChain(t *testing.T) {
+	jc := proto.JobChain{
+		RequestId: "abc",
+		User:      "finch",
+		State:     proto.STATE_PENDING,
+		Jobs: map[string]proto.Job{
+			"job1": {Id: "job1", Type: "t1", State: proto.STATE_PENDING},
+			"job2": {Id: "job2", Type: "t2", State: proto.STATE_PENDING},
+			"job3": {Id: "job3", Type: "t3", State: proto.STATE_PENDING},
+		},
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+		},
+	}
+	var buf bytes.Buffer
+	if err := proto.WriteJobChain(&buf, jc); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 5 {
+		t.Errorf("wrote %d lines, expected 5 (chain, 3 jobs, 1 adjacency list fragment):\n%s", n, buf.String())
+	}
+
+	var checked []string
+	got, err := proto.ReadJobChain(&buf, func(job proto.Job) error {
+		checked = append(checked, job.Id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got, jc); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(checked, []string{"job1", "job2", "job3"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Adjacency list fragments are merged
+	stream := `{"chain":{"requestId":"abc"}}
+{"job":{"id":"job1"}}
+{"job":{"id":"job2"}}
+{"job":{"id":"job3"}}
+{"adjacencyList":{"job1":["job2"]}}
+{"adjacencyList":{"job1":["job3"]}}
+`
+	got, err = proto.ReadJobChain(strings.NewReader(stream), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got.AdjacencyList, map[string][]string{"job1": {"job2", "job3"}}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Invalid streams
+	invalid := []string{
+		``,
+		`{"job":{"id":"job1"}}`,
+		`{"chain":{"requestId":"abc","jobs":{"job1":{"id":"job1"}}}}`,
+		"{\"chain\":{\"requestId\":\"abc\"}}\n{\"chain\":{\"requestId\":\"abc\"}}",
+		"{\"chain\":{\"requestId\":\"abc\"}}\n{\"job\":{\"id\":\"job1\"}}\n{\"job\":{\"id\":\"job1\"}}",
+		"{\"chain\":{\"requestId\":\"abc\"}}\n{}",
+		"{\"chain\":{\"requestId\":\"abc\"}}\n{\"job\":",
+	}
+	for _, s := range invalid {
+		if _, err := proto.ReadJobChain(strings.NewReader(s), nil); err == nil {
+			t.Errorf("no error reading %q, expected one", s)
+		}
+	}
+}
+
+type testHost struct {
+	Name string
+	Port int
+}
+
+func init() {
+	job.RegisterDataType("proto_test.testHost", testHost{})
+}
+
+func TestJobDataTypes(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	data := map[string]interface{}{
+		"count":   3,
+		"big":     int64(1<<62 + 1),
+		"wait":    5 * time.Second,
+		"at":      ts,
+		"hosts":   []string{"db1", "db2"},
+		"host":    testHost{Name: "db1", Port: 3306},
+		"name":    "db1",
+		"enabled": true,
+		"ratio":   0.5,
+	}
+	j := proto.Job{Id: "job1", Type: "t", Data: data}
+
+	bytes, err := json.Marshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got proto.Job
+	if err := json.Unmarshal(bytes, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got.Data, data); diff != nil {
+		t.Error(diff)
+	}
+
+	// Jobs in a job chain, like a suspended job chain sent to and from the RM
+	sjc := proto.SuspendedJobChain{
+		RequestId: "req1",
+		JobChain:  &proto.JobChain{RequestId: "req1", Jobs: map[string]proto.Job{"job1": j}},
+	}
+	bytes, err = json.Marshal(sjc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotSJC proto.SuspendedJobChain
+	if err := json.Unmarshal(bytes, &gotSJC); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(gotSJC.JobChain.Jobs["job1"].Data, data); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestJobDataUnknownType(t *testing.T) {
+	// A type that isn't registered, like by an RM that doesn't import the
+	// jobs, is decoded as untyped but keeps its type name when encoded again
+	in := `{"id":"job1","name":"","type":"t","state":0,"retry":0,"sequenceId":"","sequenceRetry":0,` +
+		`"data":{"x":{"a":1},"n":2},"dataTypes":{"x":"other.Type","n":"int"}}`
+	var j proto.Job
+	if err := json.Unmarshal([]byte(in), &j); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"x": map[string]interface{}{"a": float64(1)},
+		"n": 2,
+	}
+	if diff := deep.Equal(j.Data, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	bytes, err := json.Marshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bytes), `"dataTypes":{"n":"int","x":"other.Type"}`) {
+		t.Errorf("unknown data type not kept: %s", bytes)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020, Square, Inc.
+
+package proto
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// CONTENT_TYPE_NDJSON is the Content-Type of a streamed job chain: newline-delimited
+// JSON (NDJSON) where each line is a JobChainChunk.
+const CONTENT_TYPE_NDJSON = "application/x-ndjson"
+
+// JobChainChunk represents one line of a streamed job chain. Exactly one field
+// is set. The first line is the chain without jobs or adjacency list. Other lines
+// are one job or an adjacency list fragment, in any order. Fragments are merged,
+// so a job's next jobs can be sent in more than one fragment.
+//
+// Streaming lets very large job chains (100k+ jobs) be sent and received one
+// job at a time instead of marshaling and buffering one JSON document.
+type JobChainChunk struct {
+	Chain         *JobChain           `json:"chain,omitempty"`
+	Job           *Job                `json:"job,omitempty"`
+	AdjacencyList map[string][]string `json:"adjacencyList,omitempty"`
+}
+
+// WriteJobChain writes the job chain to w as NDJSON JobChainChunks: the chain,
+// then jobs, then one adjacency list fragment per job with next jobs. Jobs and
+// fragments are written in job ID order.
+func WriteJobChain(w io.Writer, jc JobChain) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw) // Encode appends a newline
+
+	header := jc
+	header.Jobs = nil
+	header.AdjacencyList = nil
+	if err := enc.Encode(JobChainChunk{Chain: &header}); err != nil {
+		return err
+	}
+
+	jobIds := make([]string, 0, len(jc.Jobs))
+	for id := range jc.Jobs {
+		jobIds = append(jobIds, id)
+	}
+	sort.Strings(jobIds)
+	for _, id := range jobIds {
+		job := jc.Jobs[id]
+		if err := enc.Encode(JobChainChunk{Job: &job}); err != nil {
+			return err
+		}
+	}
+
+	jobIds = jobIds[:0]
+	for id := range jc.AdjacencyList {
+		jobIds = append(jobIds, id)
+	}
+	sort.Strings(jobIds)
+	for _, id := range jobIds {
+		frag := map[string][]string{id: jc.AdjacencyList[id]}
+		if err := enc.Encode(JobChainChunk{AdjacencyList: frag}); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ReadJobChain reads a job chain written by WriteJobChain (or any NDJSON of
+// JobChainChunks) from r, one line at a time. If checkJob is not nil, it is
+// called for every job as it is read, and its error stops reading, which lets
+// the caller reject an invalid chain before reading all of it. The returned
+// chain is not validated.
+func ReadJobChain(r io.Reader, checkJob func(Job) error) (JobChain, error) {
+	var jc JobChain
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var chunk JobChainChunk
+		if err := dec.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return jc, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		if line == 1 {
+			if chunk.Chain == nil {
+				return jc, fmt.Errorf("line 1: first line must be the chain")
+			}
+			if len(chunk.Chain.Jobs) > 0 || len(chunk.Chain.AdjacencyList) > 0 {
+				return jc, fmt.Errorf("line 1: chain has jobs or adjacency list, send them on separate lines")
+			}
+			jc = *chunk.Chain
+			jc.Jobs = map[string]Job{}
+			jc.AdjacencyList = map[string][]string{}
+			continue
+		}
+
+		switch {
+		case chunk.Chain != nil:
+			return jc, fmt.Errorf("line %d: chain must be the first line only", line)
+		case chunk.Job != nil:
+			if _, ok := jc.Jobs[chunk.Job.Id]; ok {
+				return jc, fmt.Errorf("line %d: duplicate job %s", line, chunk.Job.Id)
+			}
+			if checkJob != nil {
+				if err := checkJob(*chunk.Job); err != nil {
+					return jc, fmt.Errorf("line %d: %s", line, err)
+				}
+			}
+			jc.Jobs[chunk.Job.Id] = *chunk.Job
+		case len(chunk.AdjacencyList) > 0:
+			for id, next := range chunk.AdjacencyList {
+				jc.AdjacencyList[id] = append(jc.AdjacencyList[id], next...)
+			}
+		default:
+			return jc, fmt.Errorf("line %d: empty chunk", line)
+		}
+	}
+	if jc.Jobs == nil {
+		return jc, fmt.Errorf("no chain")
+	}
+	return jc, nil
+}
@@ -7,38 +7,92 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/square/spincycle/v2/compress"
+	"github.com/square/spincycle/v2/config"
 	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/metrics"
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/request-manager/app"
 	"github.com/square/spincycle/v2/request-manager/auth"
+	"github.com/square/spincycle/v2/request-manager/backfill"
+	"github.com/square/spincycle/v2/request-manager/cost"
+	"github.com/square/spincycle/v2/request-manager/export"
+	"github.com/square/spincycle/v2/request-manager/group"
 	"github.com/square/spincycle/v2/request-manager/joblog"
+	"github.com/square/spincycle/v2/request-manager/preset"
+	"github.com/square/spincycle/v2/request-manager/profile"
+	"github.com/square/spincycle/v2/request-manager/registry"
+	"github.com/square/spincycle/v2/request-manager/report"
 	"github.com/square/spincycle/v2/request-manager/request"
+	"github.com/square/spincycle/v2/request-manager/shadow"
+	"github.com/square/spincycle/v2/request-manager/singleton"
+	"github.com/square/spincycle/v2/request-manager/stats"
 	"github.com/square/spincycle/v2/request-manager/status"
+	"github.com/square/spincycle/v2/request-manager/stoppreview"
+	"github.com/square/spincycle/v2/request-manager/timeline"
+	"github.com/square/spincycle/v2/request-manager/token"
+	"github.com/square/spincycle/v2/request-manager/trigger"
 	v "github.com/square/spincycle/v2/version"
 )
 
 const (
 	API_ROOT = "/api/v1/"
+
+	maxTriggerPayload = 1 << 20 // 1 MiB webhook payload
+	maxStatusRequests = 1000    // request IDs per POST requests/status
 )
 
 var (
 	// Error when Request Manager is shutting down and not starting new requests
 	ErrShuttingDown = errors.New("Request Manager is shutting down - no new requests are being started")
+
+	errTokensDisabled = errors.New("API tokens are not enabled")
+	errCostsDisabled  = errors.New("job cost accounting is not enabled")
+	errNoRegistry     = errors.New("Job Runner registry is not enabled")
+	errStatsDisabled  = errors.New("job type stats are not enabled")
+	errNoSingletons   = errors.New("singleton locks are not enabled")
+	errNoPrometheus   = errors.New("Prometheus metrics are not enabled")
+	errNoTriggers     = errors.New("triggers are not enabled")
+	errNoProfiles     = errors.New("profiles are not enabled")
+	errNoBackfills    = errors.New("backfills are not enabled")
+	errNoExports      = errors.New("request export is not enabled")
+	errNoPresets      = errors.New("request presets are not enabled")
 )
 
+// ErrMaintenance is returned when Request Manager is in maintenance mode and
+// not starting new requests.
+type ErrMaintenance struct {
+	Reason string
+}
+
+func (e ErrMaintenance) Error() string {
+	msg := "Request Manager is in maintenance mode - no new requests are being started"
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
 // API provides controllers for endpoints it registers with a router.
 // It satisfies the http.HandlerFunc interface.
 type API struct {
@@ -46,24 +100,63 @@ type API struct {
 	rm           request.Manager
 	sm           status.Manager
 	rr           request.Resumer
+	groups       group.Manager
 	jls          joblog.Store
+	shadow       shadow.Manager
+	tokens       token.Manager
+	costs        cost.Manager
+	registry     registry.Manager
+	stats        stats.Manager
+	singletons   singleton.Manager
+	triggers     trigger.Manager
+	profiles     profile.Manager
+	backfills    backfill.Manager
+	exports      export.Manager
+	presets      preset.Manager
+	metrics      metrics.Metrics
 	shutdownChan chan struct{}
 	// --
 	echo *echo.Echo
+
+	maintenanceMux *sync.Mutex
+	maintenance    proto.Maintenance
 }
 
 // NewAPI creates a new API struct. It initializes an echo web server within the
 // struct, and registers all of the API's routes with it.
 func NewAPI(appCtx app.Context) *API {
+	m := appCtx.Plugins.Metrics
+	if m == nil {
+		m = metrics.Nop{}
+	}
 	api := &API{
 		appCtx:       appCtx,
 		rm:           appCtx.RM,
 		sm:           appCtx.Status,
 		jls:          appCtx.JLS,
 		rr:           appCtx.RR,
+		groups:       appCtx.Groups,
+		shadow:       appCtx.Shadow,
+		tokens:       appCtx.Tokens,
+		costs:        appCtx.Costs,
+		registry:     appCtx.Registry,
+		stats:        appCtx.Stats,
+		singletons:   appCtx.Singletons,
+		triggers:     appCtx.Triggers,
+		profiles:     appCtx.Profiles,
+		backfills:    appCtx.Backfills,
+		exports:      appCtx.Exports,
+		presets:      appCtx.Presets,
+		metrics:      m,
 		shutdownChan: appCtx.ShutdownChan,
 		// --
 		echo: echo.New(),
+
+		maintenanceMux: &sync.Mutex{},
+		maintenance: proto.Maintenance{
+			Enabled: appCtx.Config.Maintenance.Enabled,
+			Reason:  appCtx.Config.Maintenance.Reason,
+		},
 	}
 
 	// //////////////////////////////////////////////////////////////////////
@@ -71,24 +164,88 @@ func NewAPI(appCtx app.Context) *API {
 	// //////////////////////////////////////////////////////////////////////
 
 	// Request
-	api.echo.POST(API_ROOT+"requests", api.createRequestHandler)                   // create
-	api.echo.GET(API_ROOT+"requests", api.findRequestsHandler)                     // list requests
-	api.echo.GET(API_ROOT+"requests/:reqId", api.getRequestHandler)                // get -> proto.Request
-	api.echo.PUT(API_ROOT+"requests/:reqId/start", api.startRequestHandler)        // start
-	api.echo.PUT(API_ROOT+"requests/:reqId/finish", api.finishRequestHandler)      // finish
-	api.echo.PUT(API_ROOT+"requests/:reqId/stop", api.stopRequestHandler)          // stop
-	api.echo.PUT(API_ROOT+"requests/:reqId/suspend", api.suspendRequestHandler)    // suspend
-	api.echo.PUT(API_ROOT+"requests/:reqId/progress", api.requestProgressHandler)  // progress
-	api.echo.GET(API_ROOT+"requests/:reqId/job-chain", api.jobChainRequestHandler) // job chain
+	api.echo.POST(API_ROOT+"requests", api.createRequestHandler)                          // create
+	api.echo.GET(API_ROOT+"requests", api.findRequestsHandler)                            // list requests
+	api.echo.POST(API_ROOT+"requests/status", api.requestsStatusHandler)                  // status of many requests -> proto.RequestsStatus
+	api.echo.POST(API_ROOT+"requests/job-chain", api.createFromChainHandler)              // create from job chain (admins only)
+	api.echo.GET(API_ROOT+"requests/:reqId", api.getRequestHandler)                       // get -> proto.Request
+	api.echo.PUT(API_ROOT+"requests/:reqId/start", api.startRequestHandler)               // start
+	api.echo.PUT(API_ROOT+"requests/:reqId/finish", api.finishRequestHandler)             // finish
+	api.echo.PUT(API_ROOT+"requests/:reqId/stop", api.stopRequestHandler)                 // stop
+	api.echo.PUT(API_ROOT+"requests/:reqId/pause", api.pauseRequestHandler)               // pause: don't start new jobs
+	api.echo.PUT(API_ROOT+"requests/:reqId/resume", api.resumeRequestHandler)             // resume paused
+	api.echo.PUT(API_ROOT+"requests/:reqId/suspend", api.suspendRequestHandler)           // suspend
+	api.echo.PUT(API_ROOT+"requests/:reqId/progress", api.requestProgressHandler)         // progress
+	api.echo.PUT(API_ROOT+"progress", api.batchProgressHandler)                           // progress of many requests
+	api.echo.GET(API_ROOT+"requests/:reqId/job-chain", api.jobChainRequestHandler)        // job chain
+	api.echo.GET(API_ROOT+"requests/:reqId/shadow", api.shadowRequestHandler)             // shadow run -> proto.ShadowRun
+	api.echo.GET(API_ROOT+"requests/:reqId/args", api.argsRequestHandler)                 // args diff -> proto.RequestArgsDiff
+	api.echo.POST(API_ROOT+"requests/:reqId/rerun", api.rerunRequestHandler)              // rerun job and downstream jobs -> new proto.Request
+	api.echo.GET(API_ROOT+"requests/:reqId/report", api.reportRequestHandler)             // report of finished request -> HTML or Markdown
+	api.echo.GET(API_ROOT+"requests/:reqId/timeline", api.timelineRequestHandler)         // when each job ran -> proto.RequestTimeline
+	api.echo.GET(API_ROOT+"requests/:reqId/stop-preview", api.stopPreviewHandler)         // what stop would interrupt -> proto.StopPreview
+	api.echo.POST(API_ROOT+"requests/:reqId/jobs", api.addJobHandler)                     // add job to running request -> proto.Job
+	api.echo.GET(API_ROOT+"requests/:reqId/jobs/:jobId/snapshot", api.jobSnapshotHandler) // job as run -> proto.JobSnapshot
+	api.echo.PUT(API_ROOT+"requests/:reqId/jobs/:jobId/approve", api.approveJobHandler)   // approve gate job waiting for approval
+	api.echo.GET(API_ROOT+"requests/:reqId/export", api.getExportHandler)                 // where exported -> proto.RequestExport
+	api.echo.POST(API_ROOT+"requests/:reqId/export", api.exportRequestHandler)            // export now (admins only) -> proto.RequestExport
+
+	// Profiles
+	api.echo.POST(API_ROOT+"requests/:reqId/profiles/capture", api.captureProfileHandler) // capture on Job Runner (admins only)
+	api.echo.POST(API_ROOT+"requests/:reqId/profiles", api.attachProfileHandler)          // attach, from Job Runner -> proto.Profile
+	api.echo.GET(API_ROOT+"requests/:reqId/profiles", api.listProfilesHandler)            // -> []proto.Profile without data
+	api.echo.GET(API_ROOT+"requests/:reqId/profiles/:profileId", api.getProfileHandler)   // -> pprof data
+
+	// Request groups
+	api.echo.POST(API_ROOT+"request-groups", api.createGroupHandler)            // create and start -> proto.RequestGroup
+	api.echo.GET(API_ROOT+"request-groups/:groupId", api.getGroupHandler)       // get -> proto.RequestGroup
+	api.echo.PUT(API_ROOT+"request-groups/:groupId/stop", api.stopGroupHandler) // stop group and its requests
 
 	// Job Log
 	api.echo.POST(API_ROOT+"requests/:reqId/log", api.createJLHandler)    // create
 	api.echo.GET(API_ROOT+"requests/:reqId/log", api.getFullJLHandler)    // per request
 	api.echo.GET(API_ROOT+"requests/:reqId/log/:jobId", api.getJLHandler) // per job
+	api.echo.DELETE(API_ROOT+"requests/:reqId/log", api.deleteJLHandler)  // delete (admins only) -> proto.JobLogDelete
+	api.echo.POST(API_ROOT+"job-log/purge", api.purgeJLHandler)           // purge old (admins only) -> proto.JobLogDelete
+
+	// Job Runners
+	api.echo.PUT(API_ROOT+"job-runners", api.heartbeatHandler)      // register or heartbeat
+	api.echo.DELETE(API_ROOT+"job-runners", api.deregisterHandler)  // deregister ?url=
+	api.echo.GET(API_ROOT+"job-runners", api.listJobRunnersHandler) // -> []proto.JobRunner
+
+	// Singleton job locks
+	api.echo.POST(API_ROOT+"singleton-locks", api.acquireLockHandler)   // acquire -> proto.SingletonLock holder
+	api.echo.DELETE(API_ROOT+"singleton-locks", api.releaseLockHandler) // release ?name=&requestId=&jobId=
+	api.echo.GET(API_ROOT+"singleton-locks", api.listLocksHandler)      // -> []proto.SingletonLock
+
+	// Triggers
+	api.echo.POST(API_ROOT+"triggers/:name", api.webhookTriggerHandler)      // webhook receiver -> proto.TriggerResult
+	api.echo.GET(API_ROOT+"triggers/:name/errors", api.triggerErrorsHandler) // error queue (admins only) -> []proto.TriggerError
+
+	// Request presets
+	api.echo.GET(API_ROOT+"presets", api.listPresetsHandler)           // -> []proto.RequestPreset
+	api.echo.GET(API_ROOT+"presets/:name", api.getPresetHandler)       // -> proto.RequestPreset
+	api.echo.PUT(API_ROOT+"presets/:name", api.putPresetHandler)       // create or replace -> proto.RequestPreset
+	api.echo.DELETE(API_ROOT+"presets/:name", api.deletePresetHandler) // delete (creator or admins only)
+
+	// Backfills (online data migrations)
+	api.echo.GET(API_ROOT+"backfills", api.listBackfillsHandler)     // -> []proto.Backfill
+	api.echo.GET(API_ROOT+"backfills/:name", api.getBackfillHandler) // -> proto.Backfill
+
+	// API tokens
+	api.echo.POST(API_ROOT+"tokens", api.createTokenHandler)            // create -> proto.Token with secret
+	api.echo.GET(API_ROOT+"tokens", api.listTokensHandler)              // list caller's tokens -> []proto.Token
+	api.echo.DELETE(API_ROOT+"tokens/:tokenId", api.revokeTokenHandler) // revoke
 
 	// Meta
 	api.echo.GET(API_ROOT+"request-list", api.requestListHandler)     // request list
 	api.echo.GET(API_ROOT+"status/running", api.statusRunningHandler) // running requests/jobs -> proto.RunningStatus
+	api.echo.GET(API_ROOT+"usage", api.usageHandler)                  // aggregate job costs -> []proto.Usage
+	api.echo.GET(API_ROOT+"stats/job-types", api.jobTypeStatsHandler) // job type failure analytics -> proto.JobTypeStats
+	api.echo.GET(API_ROOT+"maintenance", api.getMaintenanceHandler)   // -> proto.Maintenance
+	api.echo.PUT(API_ROOT+"maintenance", api.setMaintenanceHandler)   // enable/disable (admins only)
+	api.echo.GET("/ready", api.readyHandler)                          // -> proto.Ready
+	api.echo.GET("/metrics", api.metricsHandler)                      // Prometheus text format
 	api.echo.GET("/version", api.versionHandler)                      // return version.VERSION
 
 	// //////////////////////////////////////////////////////////////////////
@@ -96,13 +253,46 @@ func NewAPI(appCtx app.Context) *API {
 	// //////////////////////////////////////////////////////////////////////
 	api.echo.Use(middleware.Recover())
 	api.echo.Use(middleware.Logger())
+	api.echo.Use(compress.Middleware()) // job chains and SJCs can be multi-MB
+
+	// Metrics plugin: time every API call by route (e.g. /api/v1/requests/:reqId),
+	// not URL path, to keep the number of metrics bounded
+	api.echo.Use((func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			t0 := time.Now()
+			err := next(c)
+			status := c.Response().Status
+			if err != nil {
+				status = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				}
+			}
+			api.metrics.Timing(metrics.API_REQUEST_DURATION, time.Since(t0), metrics.Tags{
+				"method": c.Request().Method,
+				"path":   c.Path(),
+				"status": strconv.Itoa(status),
+			})
+			return err
+		}
+	}))
 
 	// Auth plugin: authenticate caller. This is called before every route.
+	// An API token (Authorization: Bearer <secret>) is used instead of the
+	// auth plugin, if given.
 	// @todo: ignore OPTION requests?
 	api.echo.Use((func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			c.Response().Header().Set("X-Spincycle-Version", v.Version())
-			caller, err := appCtx.Auth.Authenticate(c.Request())
+			var caller auth.Caller
+			var err error
+			if secret := bearerToken(c.Request()); secret != "" && api.tokens != nil {
+				var tok proto.Token
+				tok, err = api.tokens.Authenticate(secret)
+				caller = auth.Caller{Name: tok.User, Roles: tok.Roles, Token: &tok}
+			} else {
+				caller, err = appCtx.Auth.Authenticate(c.Request())
+			}
 			if err != nil {
 				return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 			}
@@ -112,10 +302,35 @@ func NewAPI(appCtx app.Context) *API {
 		}
 	}))
 
-	// SetUsername hook, overrides ^
+	// Read-only callers (auth.read_only_roles or read-only API token) can only
+	// GET, except managing their own API tokens, which are made read-only, and
+	// getting the status of many requests, which is a POST only for the payload
+	api.echo.Use((func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			method := c.Request().Method
+			if method == http.MethodGet || method == http.MethodHead {
+				return next(c)
+			}
+			if c.Path() == API_ROOT+"requests/status" {
+				return next(c)
+			}
+			if c.Path() == API_ROOT+"tokens" || c.Path() == API_ROOT+"tokens/:tokenId" {
+				return next(c)
+			}
+			if caller, ok := c.Get("caller").(auth.Caller); ok && appCtx.Auth.IsReadOnly(caller) {
+				return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("denied: caller is read-only and cannot %s %s", method, c.Request().URL.Path))
+			}
+			return next(c)
+		}
+	}))
+
+	// SetUsername hook, overrides ^ except for API tokens which have a user
 	if appCtx.Hooks.SetUsername != nil {
 		api.echo.Use((func(next echo.HandlerFunc) echo.HandlerFunc {
 			return func(c echo.Context) error {
+				if caller, ok := c.Get("caller").(auth.Caller); ok && caller.Token != nil {
+					return next(c)
+				}
 				username, err := appCtx.Hooks.SetUsername(c.Request())
 				if err != nil {
 					return err
@@ -126,22 +341,81 @@ func NewAPI(appCtx app.Context) *API {
 		}))
 	}
 
+	// Run-as (proto.RUN_AS_HEADER): admins can create and stop requests on
+	// behalf of another user, like for break-glass automation. The header value
+	// overrides the username, and the caller is the operator. Handlers write
+	// both to the audit log (auditRunAs). It's ignored when viewing, so clients
+	// can send it with every call.
+	api.echo.Use((func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			runAs := c.Request().Header.Get(proto.RUN_AS_HEADER)
+			method := c.Request().Method
+			if runAs == "" || method == http.MethodGet || method == http.MethodHead {
+				return next(c) // not run-as, or viewing (as the caller)
+			}
+			if !runAsRoutes[method+" "+c.Path()] {
+				return handleError(serr.ValidationError{Message: fmt.Sprintf("%s header is allowed only to create and stop requests and request groups", proto.RUN_AS_HEADER)}, c)
+			}
+			caller, _ := c.Get("caller").(auth.Caller)
+			if !appCtx.Auth.IsAdmin(caller) {
+				return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("denied: caller %s does not have an admin role and cannot run as another user", caller.Name))
+			}
+			operator, _ := c.Get("username").(string)
+			c.Set("operator", operator)
+			c.Set("username", runAs)
+			return next(c)
+		}
+	}))
+
 	return api
 }
 
+// runAsRoutes are the routes that allow proto.RUN_AS_HEADER, keyed on method
+// and path.
+var runAsRoutes = map[string]bool{
+	http.MethodPost + " " + API_ROOT + "requests":                    true,
+	http.MethodPut + " " + API_ROOT + "requests/:reqId/stop":         true,
+	http.MethodPost + " " + API_ROOT + "request-groups":              true,
+	http.MethodPut + " " + API_ROOT + "request-groups/:groupId/stop": true,
+}
+
+// auditRunAs logs an op done by an operator on behalf of another user (run-as).
+// It does nothing if the caller is not running as another user.
+func auditRunAs(c echo.Context, op, id string) {
+	operator, _ := c.Get("operator").(string)
+	if operator == "" {
+		return
+	}
+	user, _ := c.Get("username").(string)
+	log.WithFields(log.Fields{
+		"audit":    "run-as",
+		"op":       op,
+		"id":       id,
+		"user":     user,
+		"operator": operator,
+	}).Infof("%s %s as %s by operator %s", op, id, user, operator)
+}
+
 func (api *API) Router() *echo.Echo {
 	return api.echo
 }
 
 // Run makes the API listen on the configured address.
 func (api *API) Run() error {
-	var err error
-	if api.appCtx.Config.Server.TLS.CertFile != "" && api.appCtx.Config.Server.TLS.KeyFile != "" {
-		err = api.echo.StartTLS(api.appCtx.Config.Server.Addr, api.appCtx.Config.Server.TLS.CertFile, api.appCtx.Config.Server.TLS.KeyFile)
-	} else {
-		err = api.echo.Start(api.appCtx.Config.Server.Addr)
+	if api.appCtx.Config.Server.TLS.CertFile == "" || api.appCtx.Config.Server.TLS.KeyFile == "" {
+		return api.echo.Start(api.appCtx.Config.Server.Addr)
 	}
-	return err
+
+	// The server TLS config reloads the cert files when they change, and
+	// verifies client certs if server.tls.verify_client is true
+	tlsConfig, err := config.NewServerTLSConfig(api.appCtx.Config.Server.TLS)
+	if err != nil {
+		return fmt.Errorf("error loading server TLS config: %s", err)
+	}
+	s := api.echo.TLSServer
+	s.Addr = api.appCtx.Config.Server.Addr
+	s.TLSConfig = tlsConfig
+	return api.echo.StartServer(s)
 }
 
 // Stop stops the API when it's running. When Stop is called, Run returns
@@ -164,11 +438,10 @@ func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // POST <API_ROOT>/requests
 // Create a new request and start it.
 func (api *API) createRequestHandler(c echo.Context) error {
-	// If Request Manager is shutting down, don't start running any new requests.
-	select {
-	case <-api.shutdownChan:
-		return handleError(ErrShuttingDown, c)
-	default:
+	// If Request Manager is shutting down or in maintenance mode, don't start
+	// running any new requests.
+	if err := api.AcceptingRequests(); err != nil {
+		return handleError(err, c)
 	}
 
 	// ----------------------------------------------------------------------
@@ -189,9 +462,22 @@ func (api *API) createRequestHandler(c echo.Context) error {
 			reqParams.User = username
 		}
 	}
+	reqParams.Operator, _ = c.Get("operator").(string)
 
 	req, err := api.rm.Create(reqParams)
 	if err != nil {
+		// Same dedup key as an unfinished request: return that request (HTTP 200,
+		// not 201 created) unless the request spec dedupPolicy is error (409)
+		var dup serr.DuplicateRequest
+		if errors.As(err, &dup) && !dup.Reject {
+			existing, err := api.rm.Get(dup.RequestId)
+			if err != nil {
+				return handleError(err, c)
+			}
+			locationUrl, _ := url.Parse(API_ROOT + "requests/" + existing.Id)
+			c.Response().Header().Set("Location", locationUrl.EscapedPath())
+			return c.JSON(http.StatusOK, existing)
+		}
 		return handleError(err, c)
 	}
 
@@ -212,6 +498,8 @@ func (api *API) createRequestHandler(c echo.Context) error {
 		return handleError(err, c)
 	}
 
+	auditRunAs(c, proto.REQUEST_OP_START, req.Id)
+
 	// Set the location of the request in the response header.
 	locationUrl, _ := url.Parse(API_ROOT + "requests/" + req.Id)
 	c.Response().Header().Set("Location", locationUrl.EscapedPath())
@@ -221,6 +509,219 @@ func (api *API) createRequestHandler(c echo.Context) error {
 	return c.JSON(http.StatusCreated, req)
 }
 
+// POST <API_ROOT>/request-groups
+// Create and start a request group. The payload is a proto.CreateRequestGroup.
+// Every request is created and authorized before any is started, so if one
+// cannot be created or started by the caller, none are started.
+func (api *API) createGroupHandler(c echo.Context) error {
+	if err := api.AcceptingRequests(); err != nil {
+		return handleError(err, c)
+	}
+
+	var cg proto.CreateRequestGroup
+	if err := c.Bind(&cg); err != nil {
+		return err
+	}
+	if len(cg.Requests) == 0 {
+		return handleError(serr.ValidationError{Message: "requests is empty"}, c)
+	}
+	// Check the policy before creating requests that would only be failed
+	switch cg.Policy {
+	case "", proto.GROUP_POLICY_PARALLEL, proto.GROUP_POLICY_SEQUENTIAL:
+	default:
+		return handleError(serr.ValidationError{Message: fmt.Sprintf("invalid policy %q: valid values are %s and %s",
+			cg.Policy, proto.GROUP_POLICY_PARALLEL, proto.GROUP_POLICY_SEQUENTIAL)}, c)
+	}
+
+	user := "?" // in case we can't get a username from the context
+	if val := c.Get("username"); val != nil {
+		if username, ok := val.(string); ok {
+			user = username
+		}
+	}
+	g := proto.RequestGroup{
+		Policy:        cg.Policy,
+		StopOnFailure: cg.StopOnFailure,
+		User:          user,
+	}
+
+	// Fail requests created so far if any request cannot be created or started
+	failCreated := func() {
+		for _, id := range g.RequestIds {
+			if err := api.rm.FailPending(id); err != nil {
+				log.Errorf("error failing request %s in RM: %s", id, err)
+			}
+		}
+	}
+	operator, _ := c.Get("operator").(string)
+	caller := c.Get("caller").(auth.Caller)
+	for _, cr := range cg.Requests {
+		cr.User = user
+		cr.Operator = operator
+		req, err := api.rm.Create(cr)
+		if err != nil {
+			failCreated()
+			return handleError(err, c)
+		}
+		g.RequestIds = append(g.RequestIds, req.Id)
+		if err := api.appCtx.Auth.Authorize(caller, proto.REQUEST_OP_START, req); err != nil {
+			failCreated()
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+	}
+
+	g, err := api.groups.Create(g)
+	if err != nil {
+		failCreated()
+		return handleError(err, c)
+	}
+	auditRunAs(c, proto.REQUEST_OP_START, g.Id)
+
+	locationUrl, _ := url.Parse(API_ROOT + "request-groups/" + g.Id)
+	c.Response().Header().Set("Location", locationUrl.EscapedPath())
+	return c.JSON(http.StatusCreated, g)
+}
+
+// GET <API_ROOT>/request-groups/{groupId}
+// Get a request group with the status of its requests.
+func (api *API) getGroupHandler(c echo.Context) error {
+	g, err := api.groups.Get(c.Param("groupId"))
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, g)
+}
+
+// PUT <API_ROOT>/request-groups/{groupId}/stop
+// Stop a request group: stop its running requests and fail its pending requests.
+// The caller must be authorized to stop every unfinished request in the group.
+func (api *API) stopGroupHandler(c echo.Context) error {
+	g, err := api.groups.Get(c.Param("groupId"))
+	if err != nil {
+		return handleError(err, c)
+	}
+	caller := c.Get("caller").(auth.Caller)
+	for _, req := range g.Requests {
+		if req.State != proto.STATE_RUNNING && req.State != proto.STATE_PENDING {
+			continue
+		}
+		if err := api.appCtx.Auth.Authorize(caller, proto.REQUEST_OP_STOP, req); err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+	}
+	if err := api.groups.Stop(g.Id); err != nil {
+		return handleError(err, c)
+	}
+	auditRunAs(c, proto.REQUEST_OP_STOP, g.Id)
+	return nil
+}
+
+// POST <API_ROOT>/requests/{reqId}/rerun
+// Create and start a new request that reruns a job and every job downstream of
+// it in a finished request. The payload is a proto.RerunRequest; only jobId is
+// required, unless rebase is true: then the whole request is rerun from the
+// current spec, and jobId must not be set. Or, skipSequence skips a failed
+// skippable sequence and reruns every job downstream of it.
+func (api *API) rerunRequestHandler(c echo.Context) error {
+	// If Request Manager is shutting down or in maintenance mode, don't start
+	// running any new requests.
+	if err := api.AcceptingRequests(); err != nil {
+		return handleError(err, c)
+	}
+
+	var rr proto.RerunRequest
+	if err := c.Bind(&rr); err != nil {
+		return err
+	}
+	if rr.JobId == "" && !rr.Rebase && rr.SkipSequence == "" {
+		return handleError(serr.ValidationError{Message: "jobId is required"}, c)
+	}
+	rr.RequestId = c.Param("reqId")
+	rr.User = "?" // in case we can't get a username from the context
+	if val := c.Get("username"); val != nil {
+		if username, ok := val.(string); ok {
+			rr.User = username
+		}
+	}
+
+	req, err := api.rm.Rerun(rr)
+	if err != nil {
+		return handleError(err, c)
+	}
+
+	// Rerunning is starting a new request, so authorize the same
+	if err := api.appCtx.Auth.Authorize(c.Get("caller").(auth.Caller), proto.REQUEST_OP_START, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	if err := api.rm.Start(req.Id); err != nil {
+		if err := api.rm.FailPending(req.Id); err != nil {
+			log.Errorf("error starting request %s in RM: %s", req.Id, err)
+		}
+		return handleError(err, c)
+	}
+
+	locationUrl, _ := url.Parse(API_ROOT + "requests/" + req.Id)
+	c.Response().Header().Set("Location", locationUrl.EscapedPath())
+
+	req.JobChain = nil // don't include the job chain in the return
+	return c.JSON(http.StatusCreated, req)
+}
+
+// POST <API_ROOT>/requests/job-chain
+// Create and start a request from a job chain instead of a request spec, like a
+// job chain exported from another request or made by an external planner. The
+// payload is a proto.CreateRequestFromChain. Only admins can create requests
+// from job chains because the jobs are run as given.
+//
+// Large job chains can be streamed as NDJSON (Content-Type proto.CONTENT_TYPE_NDJSON,
+// see proto.WriteJobChain), like the RM streams job chains to the JR. Then the
+// payload is only the job chain, and the request type is the "type" query param.
+func (api *API) createFromChainHandler(c echo.Context) error {
+	if err := api.AcceptingRequests(); err != nil {
+		return handleError(err, c)
+	}
+	if !api.appCtx.Auth.IsAdmin(c.Get("caller").(auth.Caller)) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "denied: only admins can create requests from job chains")
+	}
+
+	var cr proto.CreateRequestFromChain
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), proto.CONTENT_TYPE_NDJSON) {
+		jc, err := proto.ReadJobChain(c.Request().Body, nil) // validated by rm.CreateFromChain
+		if err != nil {
+			return handleError(serr.ValidationError{Message: "invalid job chain stream: " + err.Error()}, c)
+		}
+		cr.Type = c.QueryParam("type")
+		cr.JobChain = jc
+	} else if err := c.Bind(&cr); err != nil {
+		return err
+	}
+	cr.User = "?" // in case we can't get a username from the context
+	if val := c.Get("username"); val != nil {
+		if username, ok := val.(string); ok {
+			cr.User = username
+		}
+	}
+
+	req, err := api.rm.CreateFromChain(cr)
+	if err != nil {
+		return handleError(err, c)
+	}
+
+	if err := api.rm.Start(req.Id); err != nil {
+		if err := api.rm.FailPending(req.Id); err != nil {
+			log.Errorf("error starting request %s in RM: %s", req.Id, err)
+		}
+		return handleError(err, c)
+	}
+
+	locationUrl, _ := url.Parse(API_ROOT + "requests/" + req.Id)
+	c.Response().Header().Set("Location", locationUrl.EscapedPath())
+
+	req.JobChain = nil // don't include the job chain in the return
+	return c.JSON(http.StatusCreated, req)
+}
+
 // GET <API_ROOT>/requests
 // Return a list of requests matching the filter. Requests are in descending order
 // by create time (most recent first). Requests do not have job chain or args set.
@@ -231,9 +732,12 @@ func (api *API) findRequestsHandler(c echo.Context) error {
 	fmt.Printf("%v\n", c.QueryParams())
 
 	filter := proto.RequestFilter{
-		Type: c.QueryParam("type"),
-		User: c.QueryParam("user"),
-		Args: make(map[string]string),
+		Type:     c.QueryParam("type"),
+		User:     c.QueryParam("user"),
+		Args:     make(map[string]string),
+		Resource: c.QueryParam("resource"),
+		Sort:     c.QueryParam("sort"),  // validated by rm.Find
+		Order:    c.QueryParam("order"), // validated by rm.Find
 	}
 	if states := c.QueryParams()["state"]; len(states) != 0 {
 		for _, state := range states {
@@ -312,6 +816,31 @@ func (api *API) getRequestHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, req)
 }
 
+// POST <API_ROOT>/requests/status
+// Get the status of many requests in one call. The payload is a list of request
+// IDs. Requests are returned in the same order without job chains, and IDs of
+// requests that do not exist are returned in NotFound instead of an error.
+func (api *API) requestsStatusHandler(c echo.Context) error {
+	var ids []string
+	if err := c.Bind(&ids); err != nil {
+		return err
+	}
+	if len(ids) > maxStatusRequests {
+		errMsg := fmt.Sprintf("%d request IDs given, max %d per call", len(ids), maxStatusRequests)
+		return handleError(serr.ValidationError{Message: errMsg}, c)
+	}
+	for _, id := range ids {
+		if id == "" {
+			return handleError(serr.ValidationError{Message: "empty request ID"}, c)
+		}
+	}
+	status, err := api.sm.Requests(ids)
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
 // PUT <API_ROOT>/requests/{reqId}/start
 // Start a request by sending it to the Job Runner.
 func (api *API) startRequestHandler(c echo.Context) error {
@@ -343,9 +872,24 @@ func (api *API) finishRequestHandler(c echo.Context) error {
 		return err
 	}
 
+	// The shadow JR finishes shadow runs of requests, not requests
+	shadowed, err := api.isShadow(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	if shadowed {
+		if err := api.shadow.Finish(reqId, finishParams); err != nil {
+			return handleError(err, c)
+		}
+		return nil
+	}
+
 	if err := api.rm.Finish(reqId, finishParams); err != nil {
 		return handleError(err, c)
 	}
+	if api.exports != nil {
+		api.exports.Finished(reqId)
+	}
 
 	return nil
 }
@@ -368,6 +912,101 @@ func (api *API) stopRequestHandler(c echo.Context) error {
 	if err := api.rm.Stop(reqId); err != nil {
 		return handleError(err, c)
 	}
+	auditRunAs(c, proto.REQUEST_OP_STOP, reqId)
+
+	return nil
+}
+
+// PUT <API_ROOT>/requests/{reqId}/pause
+// Pause a running request: the Job Runner doesn't start new jobs until it's
+// resumed. Pausing is a soft stop, so the caller must be authorized to stop.
+func (api *API) pauseRequestHandler(c echo.Context) error {
+	return api.pauseRequest(c, true)
+}
+
+// PUT <API_ROOT>/requests/{reqId}/resume
+// Resume a paused request.
+func (api *API) resumeRequestHandler(c echo.Context) error {
+	return api.pauseRequest(c, false)
+}
+
+func (api *API) pauseRequest(c echo.Context, pause bool) error {
+	reqId := c.Param("reqId")
+
+	req, err := api.rm.Get(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	if err := api.appCtx.Auth.Authorize(c.Get("caller").(auth.Caller), proto.REQUEST_OP_STOP, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	if pause {
+		err = api.rm.Pause(reqId)
+	} else {
+		err = api.rm.Resume(reqId)
+	}
+	if err != nil {
+		return handleError(err, c)
+	}
+
+	return nil
+}
+
+// POST <API_ROOT>/requests/{reqId}/jobs
+// Add a pre-approved job to a running request. The payload is a proto.AddJob.
+// The job type must be allowed by config add_job.types.
+func (api *API) addJobHandler(c echo.Context) error {
+	reqId := c.Param("reqId")
+
+	var aj proto.AddJob
+	if err := c.Bind(&aj); err != nil {
+		return err
+	}
+	if aj.After == "" || aj.Type == "" {
+		return handleError(serr.ValidationError{Message: "after and type are required"}, c)
+	}
+
+	// Authorize caller to add jobs to request
+	req, err := api.rm.Get(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	if err := api.appCtx.Auth.Authorize(c.Get("caller").(auth.Caller), proto.REQUEST_OP_ADD_JOB, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	job, err := api.rm.AddJob(reqId, aj)
+	if err != nil {
+		return handleError(err, c)
+	}
+
+	job.Bytes = nil // don't include the serialized job in the return
+	return c.JSON(http.StatusCreated, job)
+}
+
+// PUT <API_ROOT>/requests/{reqId}/jobs/{jobId}/approve
+// Approve a gate job waiting for approval in a running request. The caller must
+// be authorized to approve (op "approve"), and is the user who approved it.
+func (api *API) approveJobHandler(c echo.Context) error {
+	reqId := c.Param("reqId")
+
+	req, err := api.rm.Get(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	caller := c.Get("caller").(auth.Caller)
+	if err := api.appCtx.Auth.Authorize(caller, proto.REQUEST_OP_APPROVE, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	user := caller.Name
+	if val, ok := c.Get("username").(string); ok && val != "" {
+		user = val
+	}
+	if err := api.rm.ApproveJob(reqId, c.Param("jobId"), user); err != nil {
+		return handleError(err, c)
+	}
 
 	return nil
 }
@@ -382,6 +1021,22 @@ func (api *API) suspendRequestHandler(c echo.Context) error {
 		return err
 	}
 
+	// Shadow runs are not resumed, so a suspended shadow run is finished
+	shadowed, err := api.isShadow(sjc.RequestId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	if shadowed {
+		finishParams := proto.FinishRequest{
+			State:      proto.STATE_SUSPENDED,
+			FinishedAt: time.Now().UTC(),
+		}
+		if err := api.shadow.Finish(sjc.RequestId, finishParams); err != nil {
+			return handleError(err, c)
+		}
+		return nil
+	}
+
 	if err := api.rr.Suspend(sjc); err != nil {
 		return handleError(err, c)
 	}
@@ -404,6 +1059,14 @@ func (api *API) requestProgressHandler(c echo.Context) error {
 		errMsg := fmt.Sprintf("invalid proto.StatusProgress: RequestId=%s does not match request ID in URL: %s", prg.RequestId, reqId)
 		return handleError(serr.ValidationError{Message: errMsg}, c)
 	}
+	// Shadow runs have no progress; only their final state is compared
+	shadowed, err := api.isShadow(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	if shadowed {
+		return c.JSON(http.StatusOK, nil)
+	}
 	// Update
 	if err := api.sm.UpdateProgress(prg); err != nil {
 		return handleError(err, c)
@@ -411,6 +1074,35 @@ func (api *API) requestProgressHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, nil)
 }
 
+// PUT <API_ROOT>/progress
+// Update the progress of many requests in one call. Job Runners send a batch of
+// changed finished jobs counts instead of one call per request.
+func (api *API) batchProgressHandler(c echo.Context) error {
+	var batch []proto.RequestProgress
+	if err := c.Bind(&batch); err != nil {
+		return err
+	}
+	for _, prg := range batch {
+		if prg.RequestId == "" {
+			errMsg := "invalid proto.RequestProgress: RequestId is empty, must be set"
+			return handleError(serr.ValidationError{Message: errMsg}, c)
+		}
+	}
+	for _, prg := range batch {
+		shadowed, err := api.isShadow(prg.RequestId)
+		if err != nil {
+			return handleError(err, c)
+		}
+		if shadowed {
+			continue
+		}
+		if err := api.sm.UpdateProgress(prg); err != nil {
+			return handleError(err, c)
+		}
+	}
+	return c.JSON(http.StatusOK, nil)
+}
+
 // GET <API_ROOT>/requests/{reqId}/job-chain
 // Get the job chain for a request.
 func (api *API) jobChainRequestHandler(c echo.Context) error {
@@ -426,10 +1118,184 @@ func (api *API) jobChainRequestHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, jc)
 }
 
+// GET <API_ROOT>/requests/{reqId}/jobs/{jobId}/snapshot
+// Get a job as it was run: its bytes, args, and the jobData from upstream jobs,
+// to run it again locally (spinc replay-job).
+func (api *API) jobSnapshotHandler(c echo.Context) error {
+	snap, err := api.rm.JobSnapshot(c.Param("reqId"), c.Param("jobId"))
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, snap)
+}
+
+// GET <API_ROOT>/requests/{reqId}/args
+// Get the submitted args, final request args, and resolved jobArgs of a request.
+func (api *API) argsRequestHandler(c echo.Context) error {
+	reqId := c.Param("reqId")
+
+	argsDiff, err := api.rm.ArgsDiff(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+
+	return c.JSON(http.StatusOK, argsDiff)
+}
+
+// GET <API_ROOT>/requests/{reqId}/shadow
+// Get the latest shadow run of a request compared to the request.
+func (api *API) shadowRequestHandler(c echo.Context) error {
+	reqId := c.Param("reqId")
+
+	if api.shadow == nil {
+		return handleError(serr.ShadowNotFound{RequestId: reqId}, c)
+	}
+	run, err := api.shadow.Get(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+
+	return c.JSON(http.StatusOK, run)
+}
+
+// GET <API_ROOT>/requests/{reqId}/report?format=html|markdown
+// Get a self-contained report of a finished request. Default format is html.
+func (api *API) reportRequestHandler(c echo.Context) error {
+	reqId := c.Param("reqId")
+
+	format := c.QueryParam("format")
+	var contentType string
+	switch format {
+	case "", report.FORMAT_HTML:
+		format = report.FORMAT_HTML
+		contentType = echo.MIMETextHTMLCharsetUTF8
+	case report.FORMAT_MARKDOWN:
+		contentType = "text/markdown; charset=UTF-8"
+	default:
+		errMsg := fmt.Sprintf("invalid format: %s (valid formats: %s, %s)", format, report.FORMAT_HTML, report.FORMAT_MARKDOWN)
+		return handleError(serr.ValidationError{Message: errMsg}, c)
+	}
+
+	req, err := api.rm.GetWithJC(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	if req.FinishedAt == nil {
+		errMsg := fmt.Sprintf("request %s is not finished (state %s)", reqId, proto.StateName[req.State])
+		return handleError(serr.ValidationError{Message: errMsg}, c)
+	}
+	jls, err := api.jls.GetFull(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+
+	var buf bytes.Buffer
+	if err := report.Write(&buf, report.New(req, jls), format); err != nil {
+		return handleError(err, c)
+	}
+	return c.Blob(http.StatusOK, contentType, buf.Bytes())
+}
+
+// GET <API_ROOT>/requests/{reqId}/export
+// Get where a finished request was exported: the location of its bundle in
+// object storage.
+func (api *API) getExportHandler(c echo.Context) error {
+	if api.exports == nil {
+		return handleError(errNoExports, c)
+	}
+	exp, err := api.exports.Get(c.Param("reqId"))
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, exp)
+}
+
+// POST <API_ROOT>/requests/{reqId}/export
+// Export a finished request now, like one finished before exports were enabled.
+// A request already exported is exported again. Only admins can export requests.
+func (api *API) exportRequestHandler(c echo.Context) error {
+	if api.exports == nil {
+		return handleError(errNoExports, c)
+	}
+	if !api.appCtx.Auth.IsAdmin(c.Get("caller").(auth.Caller)) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "denied: only admins can export requests")
+	}
+	reqId := c.Param("reqId")
+	req, err := api.rm.Get(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	if req.FinishedAt == nil {
+		errMsg := fmt.Sprintf("request %s is not finished (state %s)", reqId, proto.StateName[req.State])
+		return handleError(serr.ValidationError{Message: errMsg}, c)
+	}
+	exp, err := api.exports.Export(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, exp)
+}
+
+// GET <API_ROOT>/requests/{reqId}/timeline
+// Get when each job ran, from the job log and, if the request is running, the
+// jobs running now.
+func (api *API) timelineRequestHandler(c echo.Context) error {
+	reqId := c.Param("reqId")
+	req, err := api.rm.Get(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	jls, err := api.jls.GetFull(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	var running []proto.JobStatus
+	if req.State == proto.STATE_RUNNING {
+		// Best effort: without running jobs, the timeline is only finished tries
+		status, err := api.sm.Running(proto.StatusFilter{RequestId: reqId})
+		if err != nil {
+			log.Warnf("request %s timeline: cannot get running jobs: %s", reqId, err)
+		}
+		running = status.Jobs
+	}
+	return c.JSON(http.StatusOK, timeline.New(req, jls, running))
+}
+
+// GET <API_ROOT>/requests/{reqId}/stop-preview
+// Get what stopping a running request would interrupt: running jobs, sequences
+// left partially complete, and finally jobs that would run. Nothing is stopped.
+func (api *API) stopPreviewHandler(c echo.Context) error {
+	reqId := c.Param("reqId")
+	req, err := api.rm.GetWithJC(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	if req.State != proto.STATE_RUNNING {
+		errMsg := fmt.Sprintf("request %s is not running (state %s)", reqId, proto.StateName[req.State])
+		return handleError(serr.ValidationError{Message: errMsg}, c)
+	}
+	jls, err := api.jls.GetFull(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	// Unlike the timeline, running jobs are required: they're what stop interrupts
+	status, err := api.sm.Running(proto.StatusFilter{RequestId: reqId})
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, stoppreview.New(req, jls, status.Jobs))
+}
+
 // GET <API_ROOT>/requests/{reqId}/log
-// Get full job log.
+// Get full job log. Query parameter tries=latest returns only the latest try of
+// each job; the default, tries=all, returns every try.
 func (api *API) getFullJLHandler(c echo.Context) error {
 	reqId := c.Param("reqId")
+	tries := c.QueryParam("tries")
+	if tries != "" && tries != "all" && tries != "latest" {
+		errMsg := fmt.Sprintf("invalid 'tries' parameter: %q, valid values are all and latest", tries)
+		return handleError(serr.ValidationError{Message: errMsg}, c)
+	}
 
 	// Get the JL from the rm.
 	jl, err := api.jls.GetFull(reqId)
@@ -437,18 +1303,45 @@ func (api *API) getFullJLHandler(c echo.Context) error {
 		return handleError(err, c)
 	}
 
+	if tries == "latest" {
+		latest := map[string]int{} // job ID => jl index
+		for i := range jl {
+			if j, ok := latest[jl[i].JobId]; !ok || jl[i].Try > jl[j].Try {
+				latest[jl[i].JobId] = i
+			}
+		}
+		filtered := make([]proto.JobLog, 0, len(latest))
+		for i := range jl {
+			if latest[jl[i].JobId] == i {
+				filtered = append(filtered, jl[i])
+			}
+		}
+		jl = filtered
+	}
+
 	// Return the JL.
 	return c.JSON(http.StatusOK, jl)
 }
 
 // GET <API_ROOT>/requests/{reqId}/log/{jobId}
-// Get a JL.
+// Get a JL: the latest try of the job, or the try given by query parameter try.
 func (api *API) getJLHandler(c echo.Context) error {
 	reqId := c.Param("reqId")
 	jobId := c.Param("jobId")
 
 	// Get the JL from the rm.
-	jl, err := api.jls.Get(reqId, jobId)
+	var jl proto.JobLog
+	var err error
+	if try := c.QueryParam("try"); try != "" {
+		n, perr := strconv.ParseUint(try, 10, 32)
+		if perr != nil {
+			errMsg := fmt.Sprintf("invalid 'try' parameter: %q is not a try number", try)
+			return handleError(serr.ValidationError{Message: errMsg}, c)
+		}
+		jl, err = api.jls.GetTry(reqId, jobId, uint(n))
+	} else {
+		jl, err = api.jls.Get(reqId, jobId)
+	}
 	if err != nil {
 		return handleError(err, c)
 	}
@@ -457,6 +1350,83 @@ func (api *API) getJLHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, jl)
 }
 
+// DELETE <API_ROOT>/requests/{reqId}/log
+// Delete the job log of a finished request. Query parameter tries=old deletes
+// all but the latest try of each job. Only admins can delete job logs, and not
+// those of requests finished within the job_log.retention config.
+func (api *API) deleteJLHandler(c echo.Context) error {
+	if !api.appCtx.Auth.IsAdmin(c.Get("caller").(auth.Caller)) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "denied: only admins can delete job logs")
+	}
+	reqId := c.Param("reqId")
+	tries := c.QueryParam("tries")
+	if tries != "" && tries != "all" && tries != "old" {
+		errMsg := fmt.Sprintf("invalid 'tries' parameter: %q, valid values are all and old", tries)
+		return handleError(serr.ValidationError{Message: errMsg}, c)
+	}
+
+	req, err := api.rm.Get(reqId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	if req.FinishedAt == nil {
+		errMsg := fmt.Sprintf("request %s is not finished (state %s)", reqId, proto.StateName[req.State])
+		return handleError(serr.ValidationError{Message: errMsg}, c)
+	}
+	if retention := api.jlRetention(); req.FinishedAt.After(time.Now().Add(-retention)) {
+		errMsg := fmt.Sprintf("request %s finished within job log retention (%s)", reqId, retention)
+		return handleError(serr.ValidationError{Message: errMsg}, c)
+	}
+
+	n, err := api.jls.Delete(reqId, tries == "old")
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, proto.JobLogDelete{Deleted: n})
+}
+
+// POST <API_ROOT>/job-log/purge
+// Delete the job logs of requests finished more than query parameter olderThan
+// (a duration, like 720h) ago. olderThan defaults to the job_log.retention config
+// and cannot be less. Only admins can purge job logs.
+func (api *API) purgeJLHandler(c echo.Context) error {
+	if !api.appCtx.Auth.IsAdmin(c.Get("caller").(auth.Caller)) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "denied: only admins can purge job logs")
+	}
+	retention := api.jlRetention()
+	olderThan := retention
+	if s := c.QueryParam("olderThan"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			errMsg := fmt.Sprintf("invalid 'olderThan' parameter: %q is not a positive duration", s)
+			return handleError(serr.ValidationError{Message: errMsg}, c)
+		}
+		if d < retention {
+			errMsg := fmt.Sprintf("invalid 'olderThan' parameter: %s is less than job log retention (%s)", d, retention)
+			return handleError(serr.ValidationError{Message: errMsg}, c)
+		}
+		olderThan = d
+	}
+	if olderThan == 0 {
+		return handleError(serr.ValidationError{Message: "olderThan is required when job_log.retention is not set"}, c)
+	}
+
+	before := time.Now().UTC().Add(-olderThan)
+	n, err := api.jls.Purge(before)
+	if err != nil {
+		return handleError(err, c)
+	}
+	log.Infof("purged %d job log entries of requests finished before %s", n, before)
+	return c.JSON(http.StatusOK, proto.JobLogDelete{Deleted: n, FinishedBefore: &before})
+}
+
+// jlRetention returns the job_log.retention config, or zero if not set. The
+// config is validated on startup.
+func (api *API) jlRetention() time.Duration {
+	d, _ := time.ParseDuration(api.appCtx.Config.JobLog.Retention)
+	return d
+}
+
 // POST <API_ROOT>/requests/{reqId}/log
 // Create a JL.
 func (api *API) createJLHandler(c echo.Context) error {
@@ -474,22 +1444,500 @@ func (api *API) createJLHandler(c echo.Context) error {
 		return handleError(err, c)
 	}
 
+	// Record job cost. This is only for chargeback, so an error does not fail
+	// the JL which was already saved.
+	if api.costs != nil {
+		if err := api.costs.Record(jl); err != nil {
+			log.Errorf("error recording cost of job %s try %d in request %s: %s", jl.JobId, jl.Try, reqId, err)
+		}
+	}
+
 	// Return the JL.
 	return c.JSON(http.StatusCreated, jl)
 }
 
+// PUT <API_ROOT>/job-runners
+// Register a Job Runner or update its registration. Job Runners with registration
+// enabled hit this endpoint on startup and then periodically as a heartbeat.
+func (api *API) heartbeatHandler(c echo.Context) error {
+	if api.registry == nil {
+		return handleError(errNoRegistry, c)
+	}
+	var jr proto.JobRunner
+	if err := c.Bind(&jr); err != nil {
+		return err
+	}
+	if err := api.registry.Heartbeat(jr); err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, nil)
+}
+
+// DELETE <API_ROOT>/job-runners?url=
+// Deregister a Job Runner. Job Runners hit this endpoint when shutting down,
+// after suspending their job chains.
+func (api *API) deregisterHandler(c echo.Context) error {
+	if api.registry == nil {
+		return handleError(errNoRegistry, c)
+	}
+	url := c.QueryParam("url")
+	if url == "" {
+		return handleError(serr.ValidationError{Message: "url query parameter is required"}, c)
+	}
+	if err := api.registry.Deregister(url); err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, nil)
+}
+
+// GET <API_ROOT>/job-runners
+// List registered Job Runners.
+func (api *API) listJobRunnersHandler(c echo.Context) error {
+	if api.registry == nil {
+		return handleError(errNoRegistry, c)
+	}
+	jrs, err := api.registry.List()
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, jrs)
+}
+
+// POST <API_ROOT>/singleton-locks
+// Acquire a singleton job lock. Job Runners hit this endpoint before running a
+// singleton job. The response is the lock holder: the job if acquired, else
+// the request and job holding the lock.
+func (api *API) acquireLockHandler(c echo.Context) error {
+	if api.singletons == nil {
+		return handleError(errNoSingletons, c)
+	}
+	var l proto.SingletonLock
+	if err := c.Bind(&l); err != nil {
+		return err
+	}
+	holder, err := api.singletons.Acquire(l)
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, holder)
+}
+
+// DELETE <API_ROOT>/singleton-locks?name=&requestId=&jobId=
+// Release a singleton job lock held by the job. Job Runners hit this endpoint
+// after running a singleton job.
+func (api *API) releaseLockHandler(c echo.Context) error {
+	if api.singletons == nil {
+		return handleError(errNoSingletons, c)
+	}
+	l := proto.SingletonLock{
+		Name:      c.QueryParam("name"),
+		RequestId: c.QueryParam("requestId"),
+		JobId:     c.QueryParam("jobId"),
+	}
+	if l.Name == "" || l.RequestId == "" || l.JobId == "" {
+		return handleError(serr.ValidationError{Message: "name, requestId, and jobId query parameters are required"}, c)
+	}
+	if err := api.singletons.Release(l); err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, nil)
+}
+
+// GET <API_ROOT>/singleton-locks
+// List held singleton job locks, including stale locks.
+func (api *API) listLocksHandler(c echo.Context) error {
+	if api.singletons == nil {
+		return handleError(errNoSingletons, c)
+	}
+	locks, err := api.singletons.List()
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, locks)
+}
+
+// POST <API_ROOT>/requests/{reqId}/profiles/capture
+// Capture CPU and heap profiles on the Job Runner running the request, which
+// must have profiling enabled. The payload is a proto.ProfileCapture. The Job
+// Runner captures the profiles asynchronously and attaches them to the request
+// when done. Only admins can capture profiles.
+func (api *API) captureProfileHandler(c echo.Context) error {
+	if !api.appCtx.Auth.IsAdmin(c.Get("caller").(auth.Caller)) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "denied: only admins can capture profiles")
+	}
+	var pc proto.ProfileCapture
+	if err := c.Bind(&pc); err != nil {
+		return err
+	}
+	if err := api.rm.CaptureProfile(c.Param("reqId"), pc); err != nil {
+		return handleError(err, c)
+	}
+	return nil
+}
+
+// POST <API_ROOT>/requests/{reqId}/profiles
+// Attach a profile to the request. Job Runners hit this endpoint when done
+// capturing a profile. The response is the profile without data.
+func (api *API) attachProfileHandler(c echo.Context) error {
+	if api.profiles == nil {
+		return handleError(errNoProfiles, c)
+	}
+	var p proto.Profile
+	if err := c.Bind(&p); err != nil {
+		return err
+	}
+	p.RequestId = c.Param("reqId")
+	p, err := api.profiles.Create(p)
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusCreated, p)
+}
+
+// GET <API_ROOT>/requests/{reqId}/profiles
+// List the profiles attached to the request, without data.
+func (api *API) listProfilesHandler(c echo.Context) error {
+	if api.profiles == nil {
+		return handleError(errNoProfiles, c)
+	}
+	profiles, err := api.profiles.List(c.Param("reqId"))
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, profiles)
+}
+
+// GET <API_ROOT>/requests/{reqId}/profiles/{profileId}
+// Download a profile attached to the request: the pprof data, which can be
+// analyzed with go tool pprof.
+func (api *API) getProfileHandler(c echo.Context) error {
+	if api.profiles == nil {
+		return handleError(errNoProfiles, c)
+	}
+	p, err := api.profiles.Get(c.Param("reqId"), c.Param("profileId"))
+	if err != nil {
+		return handleError(err, c)
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%s-%s.pprof", p.Type, p.Id))
+	return c.Blob(http.StatusOK, "application/octet-stream", p.Data)
+}
+
+// POST <API_ROOT>/triggers/${name}
+// Webhook receiver: start a request for the payload, a JSON object, if the
+// trigger source is webhook. The caller must be allowed to start the trigger
+// request. Returns 201 and the request ID, or 200 if the payload is a duplicate.
+func (api *API) webhookTriggerHandler(c echo.Context) error {
+	if api.triggers == nil {
+		return handleError(errNoTriggers, c)
+	}
+	if err := api.AcceptingRequests(); err != nil {
+		return handleError(err, c)
+	}
+	name := c.Param("name")
+	var cfg *config.Trigger
+	for i := range api.appCtx.Config.Triggers {
+		if api.appCtx.Config.Triggers[i].Name == name {
+			cfg = &api.appCtx.Config.Triggers[i]
+			break
+		}
+	}
+	if cfg == nil || cfg.Source != config.TRIGGER_SOURCE_WEBHOOK {
+		return handleError(serr.TriggerNotFound{Name: name}, c)
+	}
+	if err := api.appCtx.Auth.Authorize(c.Get("caller").(auth.Caller), proto.REQUEST_OP_START, proto.Request{Type: cfg.Request}); err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	payload, err := ioutil.ReadAll(io.LimitReader(c.Request().Body, maxTriggerPayload+1))
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxTriggerPayload {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("payload larger than %d bytes", maxTriggerPayload))
+	}
+	res, err := api.triggers.Receive(name, payload)
+	if err != nil {
+		return handleError(err, c)
+	}
+	if res.Duplicate {
+		return c.JSON(http.StatusOK, res)
+	}
+	return c.JSON(http.StatusCreated, res)
+}
+
+// GET <API_ROOT>/triggers/${name}/errors
+// Return the most recent messages in the trigger error queue, newest first.
+// Payloads can contain sensitive data, so only admins can get them.
+func (api *API) triggerErrorsHandler(c echo.Context) error {
+	if api.triggers == nil {
+		return handleError(errNoTriggers, c)
+	}
+	if !api.appCtx.Auth.IsAdmin(c.Get("caller").(auth.Caller)) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "denied: only admins can get trigger errors")
+	}
+	errs, err := api.triggers.Errors(c.Param("name"))
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, errs)
+}
+
+// GET <API_ROOT>/presets
+// List all request presets.
+func (api *API) listPresetsHandler(c echo.Context) error {
+	if api.presets == nil {
+		return handleError(errNoPresets, c)
+	}
+	presets, err := api.presets.List()
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, presets)
+}
+
+// GET <API_ROOT>/presets/${name}
+// Get a request preset.
+func (api *API) getPresetHandler(c echo.Context) error {
+	if api.presets == nil {
+		return handleError(errNoPresets, c)
+	}
+	p, err := api.presets.Get(c.Param("name"))
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, p)
+}
+
+// PUT <API_ROOT>/presets/${name}
+// Create a request preset, or replace it. The request type must exist, the args
+// must be args of the request, and the caller must be allowed to start it. Only
+// the user who created the preset, or admins, can replace it. Returns 201 if the
+// preset is created, else 200.
+func (api *API) putPresetHandler(c echo.Context) error {
+	if api.presets == nil {
+		return handleError(errNoPresets, c)
+	}
+	var p proto.RequestPreset
+	if err := c.Bind(&p); err != nil {
+		return err
+	}
+	p.Name = c.Param("name")
+
+	var spec *proto.RequestSpec
+	specs := api.rm.Specs()
+	for i := range specs {
+		if specs[i].Name == p.Type {
+			spec = &specs[i]
+			break
+		}
+	}
+	if spec == nil {
+		return handleError(serr.ValidationError{Message: fmt.Sprintf("unknown request type %q", p.Type)}, c)
+	}
+	for name := range p.Args {
+		found := false
+		for _, arg := range spec.Args {
+			if arg.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return handleError(serr.ValidationError{Message: fmt.Sprintf("request %s has no arg %s", p.Type, name)}, c)
+		}
+	}
+
+	caller := c.Get("caller").(auth.Caller)
+	if err := api.appCtx.Auth.Authorize(caller, proto.REQUEST_OP_START, proto.Request{Type: p.Type}); err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	user, _ := c.Get("username").(string)
+	created := true
+	old, err := api.presets.Get(p.Name)
+	if err == nil {
+		if old.CreatedBy != user && !api.appCtx.Auth.IsAdmin(caller) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "denied: only the preset creator or admins can replace a preset")
+		}
+		created = false
+	} else if !errors.As(err, &serr.PresetNotFound{}) {
+		return handleError(err, c)
+	}
+
+	p.CreatedBy = user
+	p, err = api.presets.Put(p)
+	if err != nil {
+		return handleError(err, c)
+	}
+	if created {
+		return c.JSON(http.StatusCreated, p)
+	}
+	return c.JSON(http.StatusOK, p)
+}
+
+// DELETE <API_ROOT>/presets/${name}
+// Delete a request preset. Only the user who created it, or admins, can delete it.
+func (api *API) deletePresetHandler(c echo.Context) error {
+	if api.presets == nil {
+		return handleError(errNoPresets, c)
+	}
+	name := c.Param("name")
+	p, err := api.presets.Get(name)
+	if err != nil {
+		return handleError(err, c)
+	}
+	caller := c.Get("caller").(auth.Caller)
+	user, _ := c.Get("username").(string)
+	if p.CreatedBy != user && !api.appCtx.Auth.IsAdmin(caller) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "denied: only the preset creator or admins can delete a preset")
+	}
+	if err := api.presets.Delete(name); err != nil {
+		return handleError(err, c)
+	}
+	return nil
+}
+
+// GET <API_ROOT>/backfills
+// Return the progress of all backfills: data migrated in the background after
+// schema migrations.
+func (api *API) listBackfillsHandler(c echo.Context) error {
+	if api.backfills == nil {
+		return handleError(errNoBackfills, c)
+	}
+	list, err := api.backfills.List()
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// GET <API_ROOT>/backfills/${name}
+// Return the progress of one backfill.
+func (api *API) getBackfillHandler(c echo.Context) error {
+	if api.backfills == nil {
+		return handleError(errNoBackfills, c)
+	}
+	b, err := api.backfills.Get(c.Param("name"))
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, b)
+}
+
+// POST <API_ROOT>/tokens
+// Create an API token for the caller. The response is the only time the token
+// secret is returned.
+func (api *API) createTokenHandler(c echo.Context) error {
+	if api.tokens == nil {
+		return handleError(errTokensDisabled, c)
+	}
+	caller := c.Get("caller").(auth.Caller)
+	if caller.Token != nil {
+		// Else a token could be used to make tokens that never expire
+		return echo.NewHTTPError(http.StatusUnauthorized, "denied: API tokens cannot create API tokens, authenticate without a token")
+	}
+
+	var ct proto.CreateToken
+	if err := c.Bind(&ct); err != nil {
+		return err
+	}
+
+	user := caller.Name
+	if username, ok := c.Get("username").(string); ok && username != "" {
+		user = username
+	}
+	if user == "" {
+		return echo.NewHTTPError(http.StatusUnauthorized, "denied: caller has no name, cannot create an API token")
+	}
+	if api.appCtx.Auth.IsReadOnly(caller) {
+		ct.ReadOnly = true
+	}
+
+	tok, err := api.tokens.Create(user, ct)
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusCreated, tok)
+}
+
+// GET <API_ROOT>/tokens
+// List the caller's API tokens. Admins can list another user's tokens with
+// query param user.
+func (api *API) listTokensHandler(c echo.Context) error {
+	if api.tokens == nil {
+		return handleError(errTokensDisabled, c)
+	}
+	caller := c.Get("caller").(auth.Caller)
+	user, _ := c.Get("username").(string)
+	if u := c.QueryParam("user"); u != "" && u != user {
+		if !api.appCtx.Auth.IsAdmin(caller) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "denied: only admins can list other users' API tokens")
+		}
+		user = u
+	}
+	tokens, err := api.tokens.List(user)
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// DELETE <API_ROOT>/tokens/{tokenId}
+// Revoke an API token. Callers can revoke their own tokens; admins can revoke
+// any token.
+func (api *API) revokeTokenHandler(c echo.Context) error {
+	if api.tokens == nil {
+		return handleError(errTokensDisabled, c)
+	}
+	tokenId := c.Param("tokenId")
+	tok, err := api.tokens.Get(tokenId)
+	if err != nil {
+		return handleError(err, c)
+	}
+	caller := c.Get("caller").(auth.Caller)
+	user, _ := c.Get("username").(string)
+	if tok.User != user && !api.appCtx.Auth.IsAdmin(caller) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "denied: only the token user or admins can revoke an API token")
+	}
+	if err := api.tokens.Revoke(tokenId); err != nil {
+		return handleError(err, c)
+	}
+	return nil
+}
+
 // GET <API_ROOT>/request-list
-// Get a list of all requests.
+// Get a list of all requests. The response has an ETag, the hash of the list,
+// so clients like spinc can cache the list and revalidate it with If-None-Match,
+// which returns 304 Not Modified if the list has not changed.
 func (api *API) requestListHandler(c echo.Context) error {
-	return c.JSON(http.StatusOK, api.rm.Specs())
+	list, err := json.Marshal(api.rm.Specs())
+	if err != nil {
+		return handleError(err, c)
+	}
+	sum := sha256.Sum256(list)
+	etag := `"` + hex.EncodeToString(sum[:])[:16] + `"`
+	c.Response().Header().Set("ETag", etag)
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSONBlob(http.StatusOK, list)
 }
 
 // GET <API_ROOT>/status/running
-// Report all requests that are running.
+// Report all requests that are running. Query parameter asOf (RFC3339Nano time)
+// reports the jobs and requests that were running then. Query parameter held=true
+// also reports held jobs: runnable but not running yet (proto.JobStatus.Hold).
 func (api *API) statusRunningHandler(c echo.Context) error {
 	f := proto.StatusFilter{
 		RequestId: c.QueryParam("requestId"),
 		OrderBy:   c.QueryParam("orderBy"),
+		Held:      c.QueryParam("held") == "true",
+	}
+	if asOf := c.QueryParam("asOf"); asOf != "" {
+		var err error
+		f.AsOf, err = time.Parse(time.RFC3339Nano, asOf)
+		if err != nil {
+			errMsg := fmt.Sprintf("invalid 'asOf' parameter: %q cannot be parsed to time.Time using RFC3339Nano format: %s", asOf, err)
+			return handleError(serr.ValidationError{Message: errMsg}, c)
+		}
 	}
 	running, err := api.sm.Running(f)
 	if err != nil {
@@ -498,6 +1946,144 @@ func (api *API) statusRunningHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, running)
 }
 
+// GET <API_ROOT>/usage
+// Return job costs aggregated by namespace (default), user, job type, or request
+// type, optionally over time by hour, day, or month. Query parameters are the
+// proto.UsageFilter fields. Since and until are RFC3339Nano times.
+func (api *API) usageHandler(c echo.Context) error {
+	if api.costs == nil {
+		return handleError(errCostsDisabled, c)
+	}
+	f := proto.UsageFilter{
+		GroupBy:   c.QueryParam("groupBy"),
+		Interval:  c.QueryParam("interval"),
+		Namespace: c.QueryParam("namespace"),
+		User:      c.QueryParam("user"),
+	}
+	if since := c.QueryParam("since"); since != "" {
+		var err error
+		f.Since, err = time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			errMsg := fmt.Sprintf("invalid 'since' parameter: %q cannot be parsed to time.Time using RFC3339Nano format: %s", since, err)
+			return handleError(serr.ValidationError{Message: errMsg}, c)
+		}
+	}
+	if until := c.QueryParam("until"); until != "" {
+		var err error
+		f.Until, err = time.Parse(time.RFC3339Nano, until)
+		if err != nil {
+			errMsg := fmt.Sprintf("invalid 'until' parameter: %q cannot be parsed to time.Time using RFC3339Nano format: %s", until, err)
+			return handleError(serr.ValidationError{Message: errMsg}, c)
+		}
+	}
+	usage, err := api.costs.Usage(f)
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, usage)
+}
+
+// GET <API_ROOT>/stats/job-types
+// Return failure rate, retry rate, mean duration, and top errors per job type
+// over the window query parameter: 1h, 24h (default), or 7d. Stats are computed
+// periodically, so they can be a few minutes old; see computedAt.
+func (api *API) jobTypeStatsHandler(c echo.Context) error {
+	if api.stats == nil {
+		return handleError(errStatsDisabled, c)
+	}
+	jts, err := api.stats.JobTypes(c.QueryParam("window"))
+	if err != nil {
+		return handleError(err, c)
+	}
+	return c.JSON(http.StatusOK, jts)
+}
+
+// GET <API_ROOT>/maintenance
+// Report maintenance mode.
+func (api *API) getMaintenanceHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, api.getMaintenance())
+}
+
+// PUT <API_ROOT>/maintenance
+// Enable or disable maintenance mode. The payload is a proto.Maintenance; only
+// enabled and reason are used. Only admins can change maintenance mode.
+func (api *API) setMaintenanceHandler(c echo.Context) error {
+	if !api.appCtx.Auth.IsAdmin(c.Get("caller").(auth.Caller)) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "denied: only admins can change maintenance mode")
+	}
+	var m proto.Maintenance
+	if err := c.Bind(&m); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	m.User, _ = c.Get("username").(string)
+	m.UpdatedAt = &now
+	api.maintenanceMux.Lock()
+	api.maintenance = m
+	api.maintenanceMux.Unlock()
+	log.Infof("maintenance mode set by %s: enabled=%t reason=%q", m.User, m.Enabled, m.Reason)
+	return c.JSON(http.StatusOK, m)
+}
+
+// GET /ready
+// Report if the Request Manager is ready, and maintenance mode. It is ready in
+// maintenance mode because existing requests can still be queried and stopped.
+func (api *API) readyHandler(c echo.Context) error {
+	ready := proto.Ready{
+		Ready:       true,
+		Maintenance: api.getMaintenance(),
+	}
+	return c.JSON(http.StatusOK, ready)
+}
+
+func (api *API) getMaintenance() proto.Maintenance {
+	api.maintenanceMux.Lock()
+	defer api.maintenanceMux.Unlock()
+	return api.maintenance
+}
+
+// AcceptingRequests returns an error if new requests cannot be started because
+// the Request Manager is shutting down or in maintenance mode.
+func (api *API) AcceptingRequests() error {
+	select {
+	case <-api.shutdownChan:
+		return ErrShuttingDown
+	default:
+	}
+	if m := api.getMaintenance(); m.Enabled {
+		return ErrMaintenance{Reason: m.Reason}
+	}
+	return nil
+}
+
+// bearerToken returns the API token secret from the Authorization header, if any.
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+}
+
+// isShadow returns true if the request ID is the shadow ID of a shadow run.
+func (api *API) isShadow(reqId string) (bool, error) {
+	if api.shadow == nil {
+		return false, nil
+	}
+	return api.shadow.IsShadow(reqId)
+}
+
+// GET /metrics
+// Metrics in Prometheus text format if the metrics plugin is Prometheus.
+func (api *API) metricsHandler(c echo.Context) error {
+	p, ok := api.metrics.(*metrics.Prometheus)
+	if !ok {
+		return handleError(errNoPrometheus, c)
+	}
+	p.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
 func (api *API) versionHandler(c echo.Context) error {
 	return c.String(http.StatusOK, v.Version())
 }
@@ -511,14 +2097,25 @@ func handleError(err error, c echo.Context) error {
 	}
 
 	switch {
-	case errors.As(err, &serr.RequestNotFound{}), errors.As(err, &serr.JobNotFound{}):
+	case errors.As(err, &serr.RequestNotFound{}), errors.As(err, &serr.JobNotFound{}), errors.As(err, &serr.ShadowNotFound{}), errors.As(err, &serr.TokenNotFound{}), errors.As(err, &serr.GroupNotFound{}),
+		errors.As(err, &serr.TriggerNotFound{}), errors.As(err, &serr.ProfileNotFound{}), errors.As(err, &serr.BackfillNotFound{}),
+		errors.As(err, &serr.ExportNotFound{}), errors.As(err, &serr.PresetNotFound{}):
 		ret.HTTPStatus = http.StatusNotFound
 	case errors.As(err, &serr.ErrInvalidCreateRequest{}):
 		ret.HTTPStatus = http.StatusBadRequest
 	case errors.As(err, &serr.ValidationError{}):
 		ret.HTTPStatus = http.StatusBadRequest
-	case errors.Is(err, ErrShuttingDown):
+	case errors.As(err, &serr.DuplicateRequest{}):
+		ret.HTTPStatus = http.StatusConflict
+	case errors.As(err, &serr.RequestRejected{}):
+		ret.HTTPStatus = http.StatusForbidden
+	case errors.Is(err, ErrShuttingDown), errors.As(err, &ErrMaintenance{}), errors.Is(err, joblog.ErrBacklog):
 		ret.HTTPStatus = http.StatusServiceUnavailable
+	case errors.Is(err, errTokensDisabled), errors.Is(err, errCostsDisabled), errors.Is(err, errNoRegistry), errors.Is(err, errStatsDisabled),
+		errors.Is(err, errNoSingletons), errors.Is(err, errNoPrometheus), errors.Is(err, errNoTriggers),
+		errors.Is(err, errNoProfiles), errors.Is(err, errNoBackfills),
+		errors.Is(err, errNoExports), errors.Is(err, errNoPresets):
+		ret.HTTPStatus = http.StatusNotImplemented
 	}
 
 	return c.JSON(ret.HTTPStatus, ret)
@@ -3,20 +3,27 @@
 package api_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-test/deep"
 	"github.com/labstack/echo/v4"
 
+	"github.com/square/spincycle/v2/config"
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/metrics"
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/request-manager/api"
 	"github.com/square/spincycle/v2/request-manager/app"
 	"github.com/square/spincycle/v2/request-manager/auth"
+	"github.com/square/spincycle/v2/request-manager/shadow"
 	testutil "github.com/square/spincycle/v2/test"
 	"github.com/square/spincycle/v2/test/mock"
 	v "github.com/square/spincycle/v2/version"
@@ -34,17 +41,22 @@ var mockAuth = mock.AuthPlugin{
 }
 
 func setup(rm *mock.RequestManager, rr *mock.RequestResumer, jls *mock.JLStore, shutdownChan chan struct{}) {
+	setupWithShadow(rm, rr, jls, nil, shutdownChan)
+}
+
+func setupWithShadow(rm *mock.RequestManager, rr *mock.RequestResumer, jls *mock.JLStore, sm shadow.Manager, shutdownChan chan struct{}) {
 	appCtx := app.Defaults()
 	appCtx.RM = rm
 	appCtx.JLS = jls
 	appCtx.RR = rr
+	appCtx.Shadow = sm
 	appCtx.Status = &mock.RMStatus{}
 	appCtx.ShutdownChan = shutdownChan
 	appCtx.Hooks.SetUsername = func(*http.Request) (string, error) {
 		return "admin", nil
 	}
 	appCtx.Plugins.Auth = mockAuth
-	appCtx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, []string{"test"}, false)
+	appCtx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, []string{"test"}, false, nil)
 	server = httptest.NewServer(api.NewAPI(appCtx))
 }
 
@@ -216,6 +228,321 @@ func TestNewRequestHandlerSuccess(t *testing.T) {
 	}
 }
 
+func TestNewRequestHandlerDuplicate(t *testing.T) {
+	payload := `{"type":"restart","args":{"host":"db1"}}`
+	existing := proto.Request{
+		Id:       "abcd1234",
+		Type:     "restart",
+		State:    proto.STATE_RUNNING,
+		DedupKey: "restart-db1",
+	}
+	dup := serr.DuplicateRequest{RequestId: existing.Id, DedupKey: existing.DedupKey}
+	started := false
+	rm := &mock.RequestManager{
+		CreateFunc: func(reqParams proto.CreateRequest) (proto.Request, error) {
+			return proto.Request{Id: "efgh5678"}, dup
+		},
+		GetFunc: func(reqId string) (proto.Request, error) {
+			return existing, nil
+		},
+		StartFunc: func(reqId string) error {
+			started = true
+			return nil
+		},
+	}
+	setup(rm, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	defer cleanup()
+
+	// dedupPolicy: return (default) returns the existing request
+	var actualReq proto.Request
+	statusCode, headers, err := testutil.MakeHTTPRequest("POST", baseURL()+"requests", []byte(payload), &actualReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if diff := deep.Equal(actualReq, existing); diff != nil {
+		t.Error(diff)
+	}
+	if headers["Location"][0] != api.API_ROOT+"requests/"+existing.Id {
+		t.Errorf("location = %s, expected %s", headers["Location"][0], api.API_ROOT+"requests/"+existing.Id)
+	}
+	if started {
+		t.Error("request started, expected no request started")
+	}
+
+	// dedupPolicy: error
+	dup.Reject = true
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"requests", []byte(payload), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusConflict {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusConflict)
+	}
+}
+
+func TestRunAs(t *testing.T) {
+	var gotCR proto.CreateRequest
+	var stopped string
+	rm := &mock.RequestManager{
+		CreateFunc: func(cr proto.CreateRequest) (proto.Request, error) {
+			gotCR = cr
+			return proto.Request{Id: "abc", User: cr.User, Operator: cr.Operator}, nil
+		},
+		StopFunc: func(reqId string) error {
+			stopped = reqId
+			return nil
+		},
+	}
+	roles := []string{"admin"}
+	ctx := app.Defaults()
+	ctx.RM = rm
+	ctx.Plugins.Auth = mock.AuthPlugin{
+		AuthenticateFunc: func(r *http.Request) (auth.Caller, error) {
+			return auth.Caller{Name: "ops", Roles: roles}, nil
+		},
+	}
+	ctx.Auth = auth.NewManager(ctx.Plugins.Auth, map[string][]auth.ACL{}, []string{"admin"}, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer func() {
+		server.CloseClientConnections()
+		server.Close()
+	}()
+	baseURL := server.URL + api.API_ROOT
+
+	do := func(method, url, payload string) int {
+		req, _ := http.NewRequest(method, url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(proto.RUN_AS_HEADER, "alice")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// Admin creates a request as alice: alice is the user, admin is the operator
+	if status := do("POST", baseURL+"requests", `{"type":"something"}`); status != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", status, http.StatusCreated)
+	}
+	if gotCR.User != "alice" || gotCR.Operator != "ops" {
+		t.Errorf("got user %q and operator %q, expected alice and ops", gotCR.User, gotCR.Operator)
+	}
+
+	// And stops it
+	if status := do("PUT", baseURL+"requests/abc/stop", ""); status != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", status, http.StatusOK)
+	}
+	if stopped != "abc" {
+		t.Errorf("request.Manager.Stop not called, expected it to be called")
+	}
+
+	// Run-as is not allowed for other ops
+	if status := do("PUT", baseURL+"requests/abc/pause", ""); status != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", status, http.StatusBadRequest)
+	}
+
+	// Callers that are not admins cannot run as another user
+	roles = []string{"engineer"}
+	gotCR = proto.CreateRequest{}
+	if status := do("POST", baseURL+"requests", `{"type":"something"}`); status != http.StatusUnauthorized {
+		t.Errorf("response status = %d, expected %d", status, http.StatusUnauthorized)
+	}
+	if gotCR.Type != "" {
+		t.Errorf("request.Manager.Create called, expected it not to be called")
+	}
+}
+
+func TestRerunRequestHandler(t *testing.T) {
+	newReq := proto.Request{
+		Id:    "newreq1",
+		State: proto.STATE_PENDING,
+	}
+	var gotRR proto.RerunRequest
+	var started string
+	rm := &mock.RequestManager{
+		RerunFunc: func(rr proto.RerunRequest) (proto.Request, error) {
+			gotRR = rr
+			return newReq, nil
+		},
+		StartFunc: func(reqId string) error {
+			started = reqId
+			return nil
+		},
+	}
+	setup(rm, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	defer cleanup()
+
+	var actualReq proto.Request
+	statusCode, headers, err := testutil.MakeHTTPRequest("POST", baseURL()+"requests/abcd1234/rerun", []byte(`{"jobId":"job2"}`), &actualReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusCreated)
+	}
+	if diff := deep.Equal(actualReq, newReq); diff != nil {
+		t.Error(diff)
+	}
+	expectRR := proto.RerunRequest{RequestId: "abcd1234", JobId: "job2", User: "admin"}
+	if diff := deep.Equal(gotRR, expectRR); diff != nil {
+		t.Error(diff)
+	}
+	if started != newReq.Id {
+		t.Errorf("started request '%s', expected %s", started, newReq.Id)
+	}
+	if len(headers["Location"]) < 1 || headers["Location"][0] != api.API_ROOT+"requests/"+newReq.Id {
+		t.Errorf("location header = %v, expected %s", headers["Location"], api.API_ROOT+"requests/"+newReq.Id)
+	}
+
+	// jobId is required
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"requests/abcd1234/rerun", []byte(`{}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+}
+
+func TestCreateFromChainHandler(t *testing.T) {
+	newReq := proto.Request{
+		Id:    "newreq1",
+		Type:  "planned",
+		State: proto.STATE_PENDING,
+	}
+	var gotCR proto.CreateRequestFromChain
+	var started string
+	rm := &mock.RequestManager{
+		CreateFromChainFunc: func(cr proto.CreateRequestFromChain) (proto.Request, error) {
+			gotCR = cr
+			return newReq, nil
+		},
+		StartFunc: func(reqId string) error {
+			started = reqId
+			return nil
+		},
+	}
+	setup(rm, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	defer cleanup()
+
+	payload := []byte(`{"type":"planned","jobChain":{"jobs":{"job1":{"id":"job1","type":"noop","sequenceId":"job1"}}}}`)
+	var actualReq proto.Request
+	statusCode, headers, err := testutil.MakeHTTPRequest("POST", baseURL()+"requests/job-chain", payload, &actualReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusCreated)
+	}
+	if diff := deep.Equal(actualReq, newReq); diff != nil {
+		t.Error(diff)
+	}
+	if gotCR.Type != "planned" || gotCR.User != "admin" || gotCR.JobChain.Jobs["job1"].Type != "noop" {
+		t.Errorf("got %+v, expected type planned, user admin, and job1", gotCR)
+	}
+	if started != newReq.Id {
+		t.Errorf("started request '%s', expected %s", started, newReq.Id)
+	}
+	if len(headers["Location"]) < 1 || headers["Location"][0] != api.API_ROOT+"requests/"+newReq.Id {
+		t.Errorf("location header = %v, expected %s", headers["Location"], api.API_ROOT+"requests/"+newReq.Id)
+	}
+
+	// Only admins
+	ctx := app.Defaults()
+	ctx.RM = rm
+	ctx.Plugins.Auth = mock.AuthPlugin{
+		AuthenticateFunc: func(r *http.Request) (auth.Caller, error) {
+			return auth.Caller{Name: "dn", Roles: []string{"dev"}}, nil
+		},
+	}
+	ctx.Auth = auth.NewManager(ctx.Plugins.Auth, map[string][]auth.ACL{}, []string{"admin"}, false, nil)
+	nonAdmin := httptest.NewServer(api.NewAPI(ctx))
+	defer nonAdmin.Close()
+	started = ""
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", nonAdmin.URL+api.API_ROOT+"requests/job-chain", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusUnauthorized {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusUnauthorized)
+	}
+	if started != "" {
+		t.Errorf("started request %s, expected no request started", started)
+	}
+}
+
+func TestCreateFromChainHandlerStream(t *testing.T) {
+	newReq := proto.Request{
+		Id:    "newreq1",
+		Type:  "planned",
+		State: proto.STATE_PENDING,
+	}
+	var gotCR proto.CreateRequestFromChain
+	rm := &mock.RequestManager{
+		CreateFromChainFunc: func(cr proto.CreateRequestFromChain) (proto.Request, error) {
+			gotCR = cr
+			return newReq, nil
+		},
+	}
+	setup(rm, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	defer cleanup()
+
+	jc := proto.JobChain{
+		Jobs: map[string]proto.Job{
+			"job1": proto.Job{Id: "job1", Type: "noop", SequenceId: "job1"},
+			"job2": proto.Job{Id: "job2", Type: "restart-host", Bytes: []byte("host1"), SequenceId: "job1"},
+		},
+		AdjacencyList: map[string][]string{"job1": []string{"job2"}},
+		Globals:       map[string]interface{}{"env": "staging"},
+	}
+	var buf bytes.Buffer
+	if err := proto.WriteJobChain(&buf, jc); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(body string) int {
+		res, err := http.Post(baseURL()+"requests/job-chain?type=planned", proto.CONTENT_TYPE_NDJSON, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if statusCode := post(buf.String()); statusCode != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusCreated)
+	}
+	if gotCR.Type != "planned" || gotCR.User != "admin" {
+		t.Errorf("got type %q user %q, expected type planned, user admin", gotCR.Type, gotCR.User)
+	}
+	if diff := deep.Equal(gotCR.JobChain, jc); diff != nil {
+		t.Error(diff)
+	}
+
+	// Invalid streams are rejected before the request is created
+	invalid := []struct {
+		name string
+		body string
+	}{
+		{"no chain", ""},
+		{"job first", `{"job":{"id":"job1","type":"noop"}}` + "\n"},
+		{"bad json", `{"chain":{}}` + "\n" + `{"job":` + "\n"},
+	}
+	for _, c := range invalid {
+		gotCR = proto.CreateRequestFromChain{}
+		if statusCode := post(c.body); statusCode != http.StatusBadRequest {
+			t.Errorf("%s: response status = %d, expected %d", c.name, statusCode, http.StatusBadRequest)
+		}
+		if gotCR.Type != "" {
+			t.Errorf("%s: created request, expected no request created", c.name)
+		}
+	}
+}
+
 func TestGetRequestHandlerSuccess(t *testing.T) {
 	reqId := "abcd1234"
 	req := proto.Request{
@@ -279,11 +606,14 @@ func TestFindRequestsHandler(t *testing.T) {
 			"arg1": "val1",
 			"arg2": "val2",
 		},
-		User:   "felixp",
-		Since:  time.Date(2020, 01, 01, 12, 34, 56, 789000000, time.UTC),
-		Until:  time.Date(2020, 01, 02, 12, 34, 56, 789000000, time.UTC),
-		Limit:  5,
-		Offset: 10,
+		User:     "felixp",
+		Resource: "db01",
+		Since:    time.Date(2020, 01, 01, 12, 34, 56, 789000000, time.UTC),
+		Until:    time.Date(2020, 01, 02, 12, 34, 56, 789000000, time.UTC),
+		Sort:     proto.SORT_STARTED,
+		Order:    proto.SORT_ASC,
+		Limit:    5,
+		Offset:   10,
 	}
 
 	var actualReqs []proto.Request
@@ -314,17 +644,72 @@ func TestFindRequestsHandler(t *testing.T) {
 			"arg1": "val1",
 			"arg2": "val2",
 		},
-		User:   "felixp",
-		Since:  time.Date(2020, 01, 01, 12, 34, 56, 789000000, time.UTC),
-		Until:  time.Date(2020, 01, 02, 12, 34, 56, 789000000, time.UTC),
-		Limit:  5,
-		Offset: 10,
+		User:     "felixp",
+		Resource: "db01",
+		Since:    time.Date(2020, 01, 01, 12, 34, 56, 789000000, time.UTC),
+		Until:    time.Date(2020, 01, 02, 12, 34, 56, 789000000, time.UTC),
+		Sort:     proto.SORT_STARTED,
+		Order:    proto.SORT_ASC,
+		Limit:    5,
+		Offset:   10,
 	}
 	if diff := deep.Equal(gotFilter, expectFilter); diff != nil {
 		t.Error(diff)
 	}
 }
 
+func TestRequestListHandlerETag(t *testing.T) {
+	specs := []proto.RequestSpec{{Name: "req1"}}
+	rm := &mock.RequestManager{
+		SpecsFunc: func() []proto.RequestSpec {
+			return specs
+		},
+	}
+	setup(rm, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	defer cleanup()
+
+	var gotSpecs []proto.RequestSpec
+	statusCode, header, err := testutil.MakeHTTPRequest("GET", baseURL()+"request-list", []byte{}, &gotSpecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Fatalf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if diff := deep.Equal(gotSpecs, specs); diff != nil {
+		t.Error(diff)
+	}
+	etag := header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag header")
+	}
+
+	// Same list: not modified
+	get := func() *http.Response {
+		req, _ := http.NewRequest("GET", baseURL()+"request-list", nil)
+		req.Header.Set("If-None-Match", etag)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+	if res := get(); res.StatusCode != http.StatusNotModified {
+		t.Errorf("response status = %d, expected %d", res.StatusCode, http.StatusNotModified)
+	}
+
+	// Changed list: new ETag
+	specs = []proto.RequestSpec{{Name: "req1"}, {Name: "req2"}}
+	res := get()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", res.StatusCode, http.StatusOK)
+	}
+	if res.Header.Get("ETag") == etag {
+		t.Errorf("ETag %s did not change", etag)
+	}
+}
+
 func TestStartRequestHandlerSuccess(t *testing.T) {
 	reqId := "abcd1234"
 	setup(&mock.RequestManager{}, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
@@ -376,82 +761,394 @@ func TestFinishRequestHandlerSuccess(t *testing.T) {
 	}
 }
 
-func TestStopRequestHandlerSuccess(t *testing.T) {
-	reqId := "abcd1234"
-	setup(&mock.RequestManager{}, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+func TestFinishRequestHandlerShadow(t *testing.T) {
+	shadowId := "shadow1234"
+	payload := []byte(fmt.Sprintf("{\"state\":%d}", proto.STATE_FAIL))
+	rm := &mock.RequestManager{
+		FinishFunc: func(r string, f proto.FinishRequest) error {
+			t.Errorf("request manager Finish called for shadow run, expected shadow manager Finish")
+			return nil
+		},
+	}
+	var gotShadowId string
+	var gotFinishParams proto.FinishRequest
+	sm := &mock.ShadowManager{
+		IsShadowFunc: func(id string) (bool, error) {
+			return id == shadowId, nil
+		},
+		FinishFunc: func(id string, f proto.FinishRequest) error {
+			gotShadowId = id
+			gotFinishParams = f
+			return nil
+		},
+	}
+	setupWithShadow(rm, &mock.RequestResumer{}, &mock.JLStore{}, sm, make(chan struct{}))
 	defer cleanup()
 
-	// Make the HTTP request.
-	statusCode, _, err := testutil.MakeHTTPRequest("PUT", baseURL()+"requests/"+reqId+"/stop", []byte{}, nil)
+	statusCode, _, err := testutil.MakeHTTPRequest("PUT", baseURL()+"requests/"+shadowId+"/finish", payload, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	// Check that the status code is what we expect.
 	if statusCode != http.StatusOK {
 		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
 	}
+	if gotShadowId != shadowId {
+		t.Errorf("shadow manager Finish called with %s, expected %s", gotShadowId, shadowId)
+	}
+	if diff := deep.Equal(gotFinishParams, proto.FinishRequest{State: proto.STATE_FAIL}); diff != nil {
+		t.Error(diff)
+	}
 }
 
-func TestSuspendRequestHandlerSuccess(t *testing.T) {
-	reqId := "729ghskd329dhj3sbjnr"
-	payload := []byte("{\"requestId\":\"729ghskd329dhj3sbjnr\",\"jobChain\":{\"requestId\":\"729ghskd329dhj3sbjnr\",\"jobs\":{\"hw48\":{\"id\":\"hw48\",\"type\":\"test\",\"bytes\":null,\"state\":6,\"args\":null,\"data\":null,\"retry\":5,\"retryWait\":\"1s\",\"sequenceId\":\"hw48\",\"sequenceRetry\":1}},\"adjacencyList\":null,\"state\":7},\"totalJobTries\":{\"hw48\":5},\"latestRunJobTries\":{\"hw48\":2},\"sequenceTries\":{\"hw48\":1}}")
-
-	// Create a mock request manager that will record the finish params it receives.
-	var rrSJC proto.SuspendedJobChain
-	rr := &mock.RequestResumer{
-		SuspendFunc: func(sjc proto.SuspendedJobChain) error {
-			rrSJC = sjc
-			return nil
+func TestRequestProgressHandlerShadow(t *testing.T) {
+	shadowId := "shadow1234"
+	payload := []byte(fmt.Sprintf("{\"requestId\":\"%s\",\"finishedJobs\":1}", shadowId))
+	var checked string
+	sm := &mock.ShadowManager{
+		IsShadowFunc: func(id string) (bool, error) {
+			checked = id
+			return true, nil
 		},
 	}
-	setup(&mock.RequestManager{}, rr, &mock.JLStore{}, make(chan struct{}))
+	setupWithShadow(&mock.RequestManager{}, &mock.RequestResumer{}, &mock.JLStore{}, sm, make(chan struct{}))
 	defer cleanup()
 
-	// Make the HTTP request.
-	statusCode, _, err := testutil.MakeHTTPRequest("PUT", baseURL()+"requests/"+reqId+"/suspend", payload, nil)
+	statusCode, _, err := testutil.MakeHTTPRequest("PUT", baseURL()+"requests/"+shadowId+"/progress", payload, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	// Check that the status code is what we expect.
 	if statusCode != http.StatusOK {
 		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
 	}
+	if checked != shadowId {
+		t.Errorf("shadow manager IsShadow called with %s, expected %s", checked, shadowId)
+	}
+}
 
-	// Check that the finish params sent to the request manager are what we expect.
-	expectedSJC := proto.SuspendedJobChain{
-		RequestId:         reqId,
-		TotalJobTries:     map[string]uint{"hw48": 5},
-		LatestRunJobTries: map[string]uint{"hw48": 2},
-		SequenceTries:     map[string]uint{"hw48": 1},
-		JobChain: &proto.JobChain{
-			RequestId: reqId,
-			Jobs: map[string]proto.Job{
-				"hw48": proto.Job{
-					Id:            "hw48",
-					Type:          "test",
-					State:         proto.STATE_STOPPED,
-					Retry:         5,
-					RetryWait:     "1s",
-					SequenceId:    "hw48",
-					SequenceRetry: 1,
-				},
-			},
-			State: proto.STATE_SUSPENDED,
+func TestBatchProgressHandler(t *testing.T) {
+	var updated []proto.RequestProgress
+	appCtx := app.Defaults()
+	appCtx.RM = &mock.RequestManager{}
+	appCtx.Shadow = &mock.ShadowManager{
+		IsShadowFunc: func(id string) (bool, error) {
+			return id == "shadow1", nil
 		},
 	}
-	if diff := deep.Equal(rrSJC, expectedSJC); diff != nil {
-		t.Error(diff)
+	appCtx.Status = &mock.RMStatus{
+		UpdateProgressFunc: func(prg proto.RequestProgress) error {
+			updated = append(updated, prg)
+			return nil
+		},
 	}
-}
-
-func TestSuspendRequestHandlerInvalidPayload(t *testing.T) {
-	payload := `"bad":"json"}` // Bad payload.
-	setup(&mock.RequestManager{}, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	appCtx.Hooks.SetUsername = func(*http.Request) (string, error) {
+		return "admin", nil
+	}
+	appCtx.Plugins.Auth = mockAuth
+	appCtx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, []string{"test"}, false, nil)
+	server = httptest.NewServer(api.NewAPI(appCtx))
 	defer cleanup()
 
-	// Make the HTTP request.
+	// Shadow runs have no progress, so shadow1 is not updated
+	batch := []proto.RequestProgress{
+		{RequestId: "req1", FinishedJobs: 3},
+		{RequestId: "shadow1", FinishedJobs: 1},
+		{RequestId: "req2", FinishedJobs: 5},
+	}
+	payload := []byte(`[{"requestId":"req1","finishedJobs":3},{"requestId":"shadow1","finishedJobs":1},{"requestId":"req2","finishedJobs":5}]`)
+	statusCode, _, err := testutil.MakeHTTPRequest("PUT", baseURL()+"progress", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	expect := []proto.RequestProgress{batch[0], batch[2]}
+	if diff := deep.Equal(updated, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Every request ID must be set, else nothing is updated
+	updated = nil
+	payload = []byte(`[{"requestId":"req1","finishedJobs":4},{"finishedJobs":1}]`)
+	statusCode, _, err = testutil.MakeHTTPRequest("PUT", baseURL()+"progress", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+	if len(updated) != 0 {
+		t.Errorf("updated %v, expected no updates", updated)
+	}
+}
+
+func TestRequestsStatusHandler(t *testing.T) {
+	var gotIds []string
+	sm := &mock.RMStatus{
+		RequestsFunc: func(ids []string) (proto.RequestsStatus, error) {
+			gotIds = ids
+			return proto.RequestsStatus{
+				Requests: []proto.Request{
+					{Id: "req1", State: proto.STATE_RUNNING},
+					{Id: "req3", State: proto.STATE_COMPLETE},
+				},
+				NotFound: []string{"req2"},
+			}, nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.Status = sm
+	ctx.Plugins.Auth = mock.AuthPlugin{
+		AuthenticateFunc: func(r *http.Request) (auth.Caller, error) {
+			return auth.Caller{Name: "dn", Roles: []string{"viewer"}}, nil
+		},
+	}
+	ctx.Auth = auth.NewManager(ctx.Plugins.Auth, map[string][]auth.ACL{}, nil, false, []string{"viewer"})
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	// Read-only callers can get status even though it's a POST
+	var got proto.RequestsStatus
+	payload := []byte(`["req1","req2","req3"]`)
+	statusCode, _, err := testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"requests/status", payload, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if diff := deep.Equal(gotIds, []string{"req1", "req2", "req3"}); diff != nil {
+		t.Error(diff)
+	}
+	if len(got.Requests) != 2 || got.Requests[1].Id != "req3" || len(got.NotFound) != 1 {
+		t.Errorf("got %+v, expected req1 and req3, req2 not found", got)
+	}
+
+	// Empty request ID
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"requests/status", []byte(`["req1",""]`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+}
+
+func TestShadowRequestHandler(t *testing.T) {
+	reqId := "abcd1234"
+	run := proto.ShadowRun{
+		RequestId:   reqId,
+		ShadowId:    "shadow1234",
+		State:       proto.STATE_COMPLETE,
+		ShadowState: proto.STATE_COMPLETE,
+		Jobs: []proto.ShadowJob{
+			{JobId: "job1", Name: "job1", State: proto.STATE_COMPLETE, ShadowState: proto.STATE_COMPLETE, Tries: 1, ShadowTries: 1},
+		},
+		Match: true,
+	}
+	sm := &mock.ShadowManager{
+		GetFunc: func(id string) (proto.ShadowRun, error) {
+			if id != reqId {
+				return proto.ShadowRun{}, serr.ShadowNotFound{RequestId: id}
+			}
+			return run, nil
+		},
+	}
+	setupWithShadow(&mock.RequestManager{}, &mock.RequestResumer{}, &mock.JLStore{}, sm, make(chan struct{}))
+	defer cleanup()
+
+	var gotRun proto.ShadowRun
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", baseURL()+"requests/"+reqId+"/shadow", []byte{}, &gotRun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if diff := deep.Equal(gotRun, run); diff != nil {
+		t.Error(diff)
+	}
+
+	// Request not shadowed
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", baseURL()+"requests/nope/shadow", []byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+}
+
+func TestStopRequestHandlerSuccess(t *testing.T) {
+	reqId := "abcd1234"
+	setup(&mock.RequestManager{}, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	defer cleanup()
+
+	// Make the HTTP request.
+	statusCode, _, err := testutil.MakeHTTPRequest("PUT", baseURL()+"requests/"+reqId+"/stop", []byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the status code is what we expect.
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+}
+
+func TestPauseRequestHandler(t *testing.T) {
+	reqId := "abcd1234"
+	var paused, resumed string
+	rm := &mock.RequestManager{
+		PauseFunc: func(r string) error {
+			paused = r
+			return nil
+		},
+		ResumeFunc: func(r string) error {
+			resumed = r
+			return nil
+		},
+	}
+	setup(rm, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	defer cleanup()
+
+	statusCode, _, err := testutil.MakeHTTPRequest("PUT", baseURL()+"requests/"+reqId+"/pause", []byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if paused != reqId {
+		t.Errorf("paused request %q, expected %s", paused, reqId)
+	}
+
+	statusCode, _, err = testutil.MakeHTTPRequest("PUT", baseURL()+"requests/"+reqId+"/resume", []byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if resumed != reqId {
+		t.Errorf("resumed request %q, expected %s", resumed, reqId)
+	}
+}
+
+func TestAddJobHandler(t *testing.T) {
+	reqId := "abcd1234"
+	var gotAJ proto.AddJob
+	rm := &mock.RequestManager{
+		AddJobFunc: func(r string, aj proto.AddJob) (proto.Job, error) {
+			if r != reqId {
+				return proto.Job{}, serr.RequestNotFound{RequestId: r}
+			}
+			if aj.After == "job9" {
+				return proto.Job{}, serr.JobNotFound{RequestId: r, JobId: aj.After}
+			}
+			gotAJ = aj
+			return proto.Job{Id: "j5", Name: "diag", Type: "collect-diagnostics", Bytes: []byte("x"), State: proto.STATE_PENDING}, nil
+		},
+	}
+	setup(rm, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	defer cleanup()
+
+	payload := []byte(`{"after":"job1","type":"collect-diagnostics","name":"diag","args":{"host":"db1"}}`)
+	var gotJob proto.Job
+	statusCode, _, err := testutil.MakeHTTPRequest("POST", baseURL()+"requests/"+reqId+"/jobs", payload, &gotJob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusCreated)
+	}
+	expectAJ := proto.AddJob{After: "job1", Type: "collect-diagnostics", Name: "diag", Args: map[string]interface{}{"host": "db1"}}
+	if diff := deep.Equal(gotAJ, expectAJ); diff != nil {
+		t.Error(diff)
+	}
+	expectJob := proto.Job{Id: "j5", Name: "diag", Type: "collect-diagnostics", State: proto.STATE_PENDING}
+	if diff := deep.Equal(gotJob, expectJob); diff != nil {
+		t.Error(diff)
+	}
+
+	// after and type are required
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"requests/"+reqId+"/jobs", []byte(`{"type":"collect-diagnostics"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+
+	// Job to run after not found
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"requests/"+reqId+"/jobs", []byte(`{"after":"job9","type":"collect-diagnostics"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+}
+
+func TestSuspendRequestHandlerSuccess(t *testing.T) {
+	reqId := "729ghskd329dhj3sbjnr"
+	payload := []byte("{\"requestId\":\"729ghskd329dhj3sbjnr\",\"jobChain\":{\"requestId\":\"729ghskd329dhj3sbjnr\",\"jobs\":{\"hw48\":{\"id\":\"hw48\",\"type\":\"test\",\"bytes\":null,\"state\":6,\"args\":null,\"data\":null,\"retry\":5,\"retryWait\":\"1s\",\"sequenceId\":\"hw48\",\"sequenceRetry\":1}},\"adjacencyList\":null,\"state\":7},\"totalJobTries\":{\"hw48\":5},\"latestRunJobTries\":{\"hw48\":2},\"sequenceTries\":{\"hw48\":1}}")
+
+	// Create a mock request manager that will record the finish params it receives.
+	var rrSJC proto.SuspendedJobChain
+	rr := &mock.RequestResumer{
+		SuspendFunc: func(sjc proto.SuspendedJobChain) error {
+			rrSJC = sjc
+			return nil
+		},
+	}
+	setup(&mock.RequestManager{}, rr, &mock.JLStore{}, make(chan struct{}))
+	defer cleanup()
+
+	// Make the HTTP request.
+	statusCode, _, err := testutil.MakeHTTPRequest("PUT", baseURL()+"requests/"+reqId+"/suspend", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the status code is what we expect.
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+
+	// Check that the finish params sent to the request manager are what we expect.
+	expectedSJC := proto.SuspendedJobChain{
+		RequestId:         reqId,
+		TotalJobTries:     map[string]uint{"hw48": 5},
+		LatestRunJobTries: map[string]uint{"hw48": 2},
+		SequenceTries:     map[string]uint{"hw48": 1},
+		JobChain: &proto.JobChain{
+			RequestId: reqId,
+			Jobs: map[string]proto.Job{
+				"hw48": proto.Job{
+					Id:            "hw48",
+					Type:          "test",
+					State:         proto.STATE_STOPPED,
+					Retry:         5,
+					RetryWait:     "1s",
+					SequenceId:    "hw48",
+					SequenceRetry: 1,
+				},
+			},
+			State: proto.STATE_SUSPENDED,
+		},
+	}
+	if diff := deep.Equal(rrSJC, expectedSJC); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSuspendRequestHandlerInvalidPayload(t *testing.T) {
+	payload := `"bad":"json"}` // Bad payload.
+	setup(&mock.RequestManager{}, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	defer cleanup()
+
+	// Make the HTTP request.
 	statusCode, _, err := testutil.MakeHTTPRequest("PUT", baseURL()+"requests/4/suspend", []byte(payload), nil)
 	if err != nil {
 		t.Fatal(err)
@@ -552,71 +1249,325 @@ func TestGetJobChainRequestHandlerSuccess(t *testing.T) {
 	}
 }
 
-func TestGetJLHandlerSuccess(t *testing.T) {
+func TestArgsRequestHandler(t *testing.T) {
 	reqId := "abcd1234"
-	jobId := "job1"
-	jl := proto.JobLog{
+	argsDiff := proto.RequestArgsDiff{
 		RequestId: reqId,
-		State:     proto.STATE_COMPLETE,
+		Submitted: map[string]interface{}{"foo": "foo-value"},
+		Args: []proto.RequestArg{
+			{Name: "foo", Type: proto.ARG_TYPE_REQUIRED, Value: "foo-value", Given: true},
+		},
+		Jobs: []proto.JobArgsDiff{
+			{
+				JobId: "job1",
+				Name:  "a",
+				Args: []proto.ArgDiff{
+					{Name: "foo", Source: proto.ARG_SOURCE_GIVEN, Request: "foo-value", Value: "foo-value"},
+					{Name: "host", Source: proto.ARG_SOURCE_DERIVED, Value: "h1"},
+				},
+			},
+		},
 	}
-	// Create a mock joblog store that will return a jl.
-	jls := &mock.JLStore{
-		GetFunc: func(r string, j string) (proto.JobLog, error) {
-			return jl, nil
+	rm := &mock.RequestManager{
+		ArgsDiffFunc: func(r string) (proto.RequestArgsDiff, error) {
+			return argsDiff, nil
 		},
 	}
-	setup(&mock.RequestManager{}, &mock.RequestResumer{}, jls, make(chan struct{}))
+	setup(rm, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
 	defer cleanup()
 
-	// Make the HTTP request.
-	var actualjl proto.JobLog
-	statusCode, _, err := testutil.MakeHTTPRequest("GET",
-		baseURL()+"requests/"+reqId+"/log/"+jobId, []byte{}, &actualjl)
+	var actual proto.RequestArgsDiff
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", baseURL()+"requests/"+reqId+"/args", []byte{}, &actual)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	// Check that the status code is what we expect.
 	if statusCode != http.StatusOK {
 		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
 	}
-
-	// Check that the job chain is what we expect.
-	if diff := deep.Equal(actualjl, jl); diff != nil {
+	if diff := deep.Equal(actual, argsDiff); diff != nil {
 		t.Error(diff)
 	}
 }
 
-func TestGetFullJLHandlerSuccess(t *testing.T) {
+func TestReportRequestHandler(t *testing.T) {
 	reqId := "abcd1234"
-	jlList := []proto.JobLog{
-		proto.JobLog{
+	finished := time.Now()
+	req := proto.Request{
+		Id:    reqId,
+		State: proto.STATE_RUNNING,
+		JobChain: &proto.JobChain{
 			RequestId: reqId,
-			State:     proto.STATE_COMPLETE,
+			Jobs:      map[string]proto.Job{"job1": {Id: "job1", Name: "a"}},
+		},
+	}
+	rm := &mock.RequestManager{
+		GetWithJCFunc: func(r string) (proto.Request, error) {
+			return req, nil
 		},
 	}
-	// Create a mock joblog store that will return a list of JLs.
 	jls := &mock.JLStore{
 		GetFullFunc: func(r string) ([]proto.JobLog, error) {
-			return jlList, nil
+			return []proto.JobLog{{RequestId: reqId, JobId: "job1", Try: 1, State: proto.STATE_FAIL, Error: "boom"}}, nil
 		},
 	}
-	setup(&mock.RequestManager{}, &mock.RequestResumer{}, jls, make(chan struct{}))
+	setup(rm, &mock.RequestResumer{}, jls, make(chan struct{}))
 	defer cleanup()
 
-	// Make the HTTP request.
-	var actualjlList []proto.JobLog
-	statusCode, _, err := testutil.MakeHTTPRequest("GET",
-		baseURL()+"requests/"+reqId+"/log", []byte{}, &actualjlList)
-	if err != nil {
-		t.Fatal(err)
+	get := func(query string) (int, string, string) {
+		resp, err := http.Get(baseURL() + "requests/" + reqId + "/report" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
 	}
 
-	// Check that the status code is what we expect.
+	// Request not finished
+	statusCode, _, _ := get("")
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+
+	req.State = proto.STATE_FAIL
+	req.FinishedAt = &finished
+
+	statusCode, contentType, body := get("")
 	if statusCode != http.StatusOK {
 		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
 	}
-
+	if contentType != echo.MIMETextHTMLCharsetUTF8 {
+		t.Errorf("Content-Type = %s, expected %s", contentType, echo.MIMETextHTMLCharsetUTF8)
+	}
+	if !strings.Contains(body, "<h3>a (job1) try 1</h3>") {
+		t.Errorf("HTML report does not have failed try:\n%s", body)
+	}
+
+	statusCode, contentType, body = get("?format=markdown")
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if !strings.HasPrefix(contentType, "text/markdown") {
+		t.Errorf("Content-Type = %s, expected text/markdown", contentType)
+	}
+	if !strings.HasPrefix(body, "# Request "+reqId) {
+		t.Errorf("Markdown report does not start with title:\n%s", body)
+	}
+
+	statusCode, _, _ = get("?format=pdf")
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+}
+
+func TestTimelineRequestHandler(t *testing.T) {
+	reqId := "abcd1234"
+	rm := &mock.RequestManager{
+		GetFunc: func(r string) (proto.Request, error) {
+			if r != reqId {
+				return proto.Request{}, serr.RequestNotFound{RequestId: r}
+			}
+			return proto.Request{Id: reqId, Type: "restart-host", State: proto.STATE_RUNNING}, nil
+		},
+	}
+	jls := &mock.JLStore{
+		GetFullFunc: func(r string) ([]proto.JobLog, error) {
+			return []proto.JobLog{{RequestId: reqId, JobId: "job1", Name: "a", Try: 1, State: proto.STATE_COMPLETE, StartedAt: 100, FinishedAt: 200}}, nil
+		},
+	}
+	sm := &mock.RMStatus{
+		RunningFunc: func(f proto.StatusFilter) (proto.RunningStatus, error) {
+			if f.RequestId != reqId {
+				t.Errorf("got status filter request id %q, expected %q", f.RequestId, reqId)
+			}
+			return proto.RunningStatus{
+				Jobs: []proto.JobStatus{{RequestId: reqId, JobId: "job2", Name: "b", Try: 1, StartedAt: 200, State: proto.STATE_RUNNING}},
+			}, nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.RM = rm
+	ctx.JLS = jls
+	ctx.Status = sm
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, nil, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	var got proto.RequestTimeline
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"requests/"+reqId+"/timeline", nil, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	expect := proto.RequestTimeline{
+		RequestId: reqId,
+		Type:      "restart-host",
+		State:     proto.STATE_RUNNING,
+		Jobs: []proto.TimelineJob{
+			{JobId: "job1", Name: "a", State: proto.STATE_COMPLETE, StartedAt: 100, FinishedAt: 200,
+				Tries: []proto.TimelineTry{{Try: 1, State: proto.STATE_COMPLETE, StartedAt: 100, FinishedAt: 200}}},
+			{JobId: "job2", Name: "b", State: proto.STATE_RUNNING, StartedAt: 200,
+				Tries: []proto.TimelineTry{{Try: 1, State: proto.STATE_RUNNING, StartedAt: 200}}},
+		},
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"requests/nope/timeline", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+}
+
+func TestStopPreviewHandler(t *testing.T) {
+	reqId := "abcd1234"
+	state := proto.STATE_RUNNING
+	rm := &mock.RequestManager{
+		GetWithJCFunc: func(r string) (proto.Request, error) {
+			if r != reqId {
+				return proto.Request{}, serr.RequestNotFound{RequestId: r}
+			}
+			return proto.Request{
+				Id:    reqId,
+				Type:  "restart-host",
+				State: state,
+				JobChain: &proto.JobChain{
+					Jobs: map[string]proto.Job{
+						"job1": {Id: "job1", Name: "a", SequenceId: "job1"},
+						"job2": {Id: "job2", Name: "b", SequenceId: "job1"},
+						"job3": {Id: "job3", Name: "c", SequenceId: "job1", Finally: true},
+					},
+				},
+			}, nil
+		},
+	}
+	jls := &mock.JLStore{
+		GetFullFunc: func(r string) ([]proto.JobLog, error) {
+			return []proto.JobLog{{RequestId: reqId, JobId: "job1", Try: 1, State: proto.STATE_COMPLETE}}, nil
+		},
+	}
+	sm := &mock.RMStatus{
+		RunningFunc: func(f proto.StatusFilter) (proto.RunningStatus, error) {
+			return proto.RunningStatus{
+				Jobs: []proto.JobStatus{{RequestId: reqId, JobId: "job2", Name: "b", Try: 1, State: proto.STATE_RUNNING}},
+			}, nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.RM = rm
+	ctx.JLS = jls
+	ctx.Status = sm
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, nil, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	var got proto.StopPreview
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"requests/"+reqId+"/stop-preview", nil, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	expect := proto.StopPreview{
+		RequestId: reqId,
+		Type:      "restart-host",
+		State:     proto.STATE_RUNNING,
+		Running:   []proto.StopPreviewJob{{JobId: "job2", Name: "b", SequenceId: "job1"}},
+		Sequences: []proto.StopPreviewSequence{{SequenceId: "job1", Name: "a", Complete: 1, Total: 2}},
+		Finally:   []proto.StopPreviewJob{{JobId: "job3", Name: "c", SequenceId: "job1"}},
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Only running requests can be stopped
+	state = proto.STATE_COMPLETE
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"requests/"+reqId+"/stop-preview", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+}
+
+func TestGetJLHandlerSuccess(t *testing.T) {
+	reqId := "abcd1234"
+	jobId := "job1"
+	jl := proto.JobLog{
+		RequestId: reqId,
+		State:     proto.STATE_COMPLETE,
+	}
+	// Create a mock joblog store that will return a jl.
+	jls := &mock.JLStore{
+		GetFunc: func(r string, j string) (proto.JobLog, error) {
+			return jl, nil
+		},
+	}
+	setup(&mock.RequestManager{}, &mock.RequestResumer{}, jls, make(chan struct{}))
+	defer cleanup()
+
+	// Make the HTTP request.
+	var actualjl proto.JobLog
+	statusCode, _, err := testutil.MakeHTTPRequest("GET",
+		baseURL()+"requests/"+reqId+"/log/"+jobId, []byte{}, &actualjl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the status code is what we expect.
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+
+	// Check that the job chain is what we expect.
+	if diff := deep.Equal(actualjl, jl); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestGetFullJLHandlerSuccess(t *testing.T) {
+	reqId := "abcd1234"
+	jlList := []proto.JobLog{
+		proto.JobLog{
+			RequestId: reqId,
+			State:     proto.STATE_COMPLETE,
+		},
+	}
+	// Create a mock joblog store that will return a list of JLs.
+	jls := &mock.JLStore{
+		GetFullFunc: func(r string) ([]proto.JobLog, error) {
+			return jlList, nil
+		},
+	}
+	setup(&mock.RequestManager{}, &mock.RequestResumer{}, jls, make(chan struct{}))
+	defer cleanup()
+
+	// Make the HTTP request.
+	var actualjlList []proto.JobLog
+	statusCode, _, err := testutil.MakeHTTPRequest("GET",
+		baseURL()+"requests/"+reqId+"/log", []byte{}, &actualjlList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the status code is what we expect.
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+
 	// Check that the job chain is what we expect.
 	if diff := deep.Equal(actualjlList, jlList); diff != nil {
 		t.Error(diff)
@@ -758,7 +1709,7 @@ func TestAuth(t *testing.T) {
 			},
 		},
 	}
-	ctx.Auth = auth.NewManager(ctx.Plugins.Auth, acls, nil, true)
+	ctx.Auth = auth.NewManager(ctx.Plugins.Auth, acls, nil, true, nil)
 
 	server := httptest.NewServer(api.NewAPI(ctx))
 	defer func() {
@@ -849,6 +1800,224 @@ func TestAuth(t *testing.T) {
 	}
 }
 
+func TestTokens(t *testing.T) {
+	tok := proto.Token{
+		Id:       "tok1",
+		User:     "dn",
+		Roles:    []string{"role2"},
+		Ops:      []string{proto.REQUEST_OP_STOP},
+		Requests: []string{"req1"},
+	}
+	var gotUser string
+	var gotCT proto.CreateToken
+	var revoked string
+	tm := &mock.TokenManager{
+		CreateFunc: func(user string, ct proto.CreateToken) (proto.Token, error) {
+			gotUser = user
+			gotCT = ct
+			t := tok
+			t.Secret = "spin_secret"
+			return t, nil
+		},
+		AuthenticateFunc: func(secret string) (proto.Token, error) {
+			if secret != "spin_secret" {
+				return proto.Token{}, fmt.Errorf("invalid API token")
+			}
+			return tok, nil
+		},
+		GetFunc: func(id string) (proto.Token, error) {
+			if id != tok.Id {
+				return proto.Token{}, serr.TokenNotFound{TokenId: id}
+			}
+			return tok, nil
+		},
+		RevokeFunc: func(id string) error {
+			revoked = id
+			return nil
+		},
+	}
+	var stopped string
+	rm := &mock.RequestManager{
+		GetFunc: func(id string) (proto.Request, error) {
+			return proto.Request{Id: id, Type: "req1"}, nil
+		},
+		StopFunc: func(id string) error {
+			stopped = id
+			return nil
+		},
+	}
+
+	ctx := app.Defaults()
+	ctx.RM = rm
+	ctx.Tokens = tm
+	ctx.Plugins.Auth = mock.AuthPlugin{
+		AuthenticateFunc: func(r *http.Request) (auth.Caller, error) {
+			return auth.Caller{Name: "dn", Roles: []string{"role2"}}, nil
+		},
+	}
+	acls := map[string][]auth.ACL{
+		"req1": []auth.ACL{{Role: "role2", Ops: []string{"start", "stop"}}},
+	}
+	ctx.Auth = auth.NewManager(ctx.Plugins.Auth, acls, nil, true, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer func() {
+		server.CloseClientConnections()
+		server.Close()
+	}()
+	baseURL := server.URL + api.API_ROOT
+
+	// Create token with plugin auth: returns secret
+	var gotTok proto.Token
+	payload := `{"name":"laptop","ops":["stop"],"requests":["req1"],"ttl":"1h"}`
+	statusCode, _, err := testutil.MakeHTTPRequest("POST", baseURL+"tokens", []byte(payload), &gotTok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusCreated)
+	}
+	if gotTok.Secret != "spin_secret" {
+		t.Errorf("got secret %q, expected spin_secret", gotTok.Secret)
+	}
+	if gotUser != "dn" {
+		t.Errorf("got user %s, expected dn", gotUser)
+	}
+	expectCT := proto.CreateToken{Name: "laptop", Ops: []string{"stop"}, Requests: []string{"req1"}, TTL: "1h"}
+	if diff := deep.Equal(gotCT, expectCT); diff != nil {
+		t.Error(diff)
+	}
+
+	// Tokens cannot create tokens
+	req, _ := http.NewRequest("POST", baseURL+"tokens", nil)
+	req.Header.Set("Authorization", "Bearer spin_secret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("response status = %d, expected %d", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	// Token is scoped to stop op
+	req, _ = http.NewRequest("PUT", baseURL+"requests/abc/stop", nil)
+	req.Header.Set("Authorization", "Bearer spin_secret")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", res.StatusCode, http.StatusOK)
+	}
+	if stopped != "abc" {
+		t.Errorf("request.Manager.Stop not called, expected it to be called")
+	}
+
+	// Invalid token
+	req, _ = http.NewRequest("PUT", baseURL+"requests/abc/stop", nil)
+	req.Header.Set("Authorization", "Bearer spin_nope")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("response status = %d, expected %d", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	// Revoke
+	statusCode, _, err = testutil.MakeHTTPRequest("DELETE", baseURL+"tokens/tok1", []byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if revoked != "tok1" {
+		t.Errorf("token.Manager.Revoke not called, expected it to be called")
+	}
+	statusCode, _, err = testutil.MakeHTTPRequest("DELETE", baseURL+"tokens/nope", []byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	var gotCT proto.CreateToken
+	tm := &mock.TokenManager{
+		CreateFunc: func(user string, ct proto.CreateToken) (proto.Token, error) {
+			gotCT = ct
+			return proto.Token{Id: "tok1", User: user, ReadOnly: ct.ReadOnly}, nil
+		},
+	}
+	stopped := false
+	rm := &mock.RequestManager{
+		GetFunc: func(id string) (proto.Request, error) {
+			return proto.Request{Id: id, Type: "req1"}, nil
+		},
+		StopFunc: func(id string) error {
+			stopped = true
+			return nil
+		},
+	}
+
+	ctx := app.Defaults()
+	ctx.RM = rm
+	ctx.Tokens = tm
+	ctx.Plugins.Auth = mock.AuthPlugin{
+		AuthenticateFunc: func(r *http.Request) (auth.Caller, error) {
+			return auth.Caller{Name: "dn", Roles: []string{"viewer"}}, nil
+		},
+	}
+	acls := map[string][]auth.ACL{
+		"req1": []auth.ACL{{Role: "viewer", Ops: []string{"start", "stop"}}},
+	}
+	ctx.Auth = auth.NewManager(ctx.Plugins.Auth, acls, nil, true, []string{"viewer"})
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer func() {
+		server.CloseClientConnections()
+		server.Close()
+	}()
+	baseURL := server.URL + api.API_ROOT
+
+	// Can view
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", baseURL+"requests/abc", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+
+	// Cannot stop even though the request ACL grants the role stop
+	statusCode, _, err = testutil.MakeHTTPRequest("PUT", baseURL+"requests/abc/stop", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusUnauthorized {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusUnauthorized)
+	}
+	if stopped {
+		t.Errorf("request.Manager.Stop called, expected read-only caller to be denied")
+	}
+
+	// Can create a token, which is read-only
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL+"tokens", []byte(`{"name":"dashboard"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusCreated)
+	}
+	if !gotCT.ReadOnly {
+		t.Errorf("created token is not read-only, expected read-only")
+	}
+}
+
 func TestGetVersion(t *testing.T) {
 	setup(&mock.RequestManager{}, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
 	defer cleanup()
@@ -870,3 +2039,1096 @@ func TestGetVersion(t *testing.T) {
 		t.Errorf("got version '%s', expected '%s'", gotVersion, expectVersion)
 	}
 }
+
+func TestMaintenance(t *testing.T) {
+	created := false
+	rm := &mock.RequestManager{
+		CreateFunc: func(reqParams proto.CreateRequest) (proto.Request, error) {
+			created = true
+			return proto.Request{Id: "abc", Type: reqParams.Type}, nil
+		},
+		GetFunc: func(id string) (proto.Request, error) {
+			return proto.Request{Id: id}, nil
+		},
+	}
+	setup(rm, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	defer cleanup()
+
+	// Enable maintenance mode
+	payload := []byte(`{"enabled":true,"reason":"upgrading"}`)
+	var m proto.Maintenance
+	statusCode, _, err := testutil.MakeHTTPRequest("PUT", baseURL()+"maintenance", payload, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if !m.Enabled || m.Reason != "upgrading" || m.User != "admin" || m.UpdatedAt == nil {
+		t.Errorf("got maintenance %+v, expected enabled by admin for reason 'upgrading'", m)
+	}
+
+	// New requests are rejected with the reason
+	var protoErr proto.Error
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"requests", []byte(`{"type":"req1"}`), &protoErr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusServiceUnavailable {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusServiceUnavailable)
+	}
+	if created {
+		t.Errorf("request created in maintenance mode")
+	}
+	expectErr := api.ErrMaintenance{Reason: "upgrading"}
+	if protoErr.Message != expectErr.Error() {
+		t.Errorf("got error message '%s', expected '%s'", protoErr.Message, expectErr.Error())
+	}
+
+	// Existing requests still work
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", baseURL()+"requests/abc", []byte{}, &proto.Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+
+	// Ready reports maintenance mode
+	var ready proto.Ready
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+"/ready", []byte{}, &ready)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if diff := deep.Equal(ready.Maintenance, m); diff != nil {
+		t.Error(diff)
+	}
+	if !ready.Ready {
+		t.Errorf("not ready, expected ready in maintenance mode")
+	}
+
+	// Disable maintenance mode and new requests work again
+	payload = []byte(`{"enabled":false}`)
+	statusCode, _, err = testutil.MakeHTTPRequest("PUT", baseURL()+"maintenance", payload, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", baseURL()+"requests", []byte(`{"type":"req1"}`), &proto.Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusCreated)
+	}
+}
+
+func TestCosts(t *testing.T) {
+	var recorded proto.JobLog
+	var gotFilter proto.UsageFilter
+	period := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	usage := []proto.Usage{
+		{Group: "team1", Period: &period, Jobs: 3, Duration: 9000000000},
+	}
+	cm := &mock.CostManager{
+		RecordFunc: func(jl proto.JobLog) error {
+			recorded = jl
+			return nil
+		},
+		UsageFunc: func(f proto.UsageFilter) ([]proto.Usage, error) {
+			gotFilter = f
+			return usage, nil
+		},
+	}
+	jls := &mock.JLStore{
+		CreateFunc: func(r string, jl proto.JobLog) (proto.JobLog, error) {
+			return jl, nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.JLS = jls
+	ctx.Costs = cm
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, nil, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	// Job log records job cost
+	payload := []byte(`{"requestId":"abcd1234","jobId":"j1","try":1,"type":"sleep","startedAt":1,"finishedAt":5,"state":3}`)
+	statusCode, _, err := testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"requests/abcd1234/log", payload, &proto.JobLog{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusCreated)
+	}
+	expectJL := proto.JobLog{RequestId: "abcd1234", JobId: "j1", Try: 1, Type: "sleep", StartedAt: 1, FinishedAt: 5, State: proto.STATE_COMPLETE}
+	if diff := deep.Equal(recorded, expectJL); diff != nil {
+		t.Error(diff)
+	}
+
+	// Usage
+	var gotUsage []proto.Usage
+	url := server.URL + api.API_ROOT + "usage?groupBy=namespace&interval=day&user=finch&since=2020-06-01T00:00:00Z"
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", url, nil, &gotUsage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	expectFilter := proto.UsageFilter{
+		GroupBy:  proto.USAGE_GROUP_BY_NAMESPACE,
+		Interval: proto.USAGE_INTERVAL_DAY,
+		User:     "finch",
+		Since:    period,
+	}
+	if diff := deep.Equal(gotFilter, expectFilter); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(gotUsage, usage); diff != nil {
+		t.Error(diff)
+	}
+
+	// Invalid time
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"usage?until=yesterday", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+}
+
+func TestJobLogRetention(t *testing.T) {
+	finished := time.Now().Add(-2 * time.Hour)
+	rm := &mock.RequestManager{
+		GetFunc: func(id string) (proto.Request, error) {
+			return proto.Request{Id: id, State: proto.STATE_COMPLETE, FinishedAt: &finished}, nil
+		},
+	}
+	var gotTry uint
+	var deleted string
+	var oldTries bool
+	var purgedBefore time.Time
+	jls := &mock.JLStore{
+		GetFullFunc: func(r string) ([]proto.JobLog, error) {
+			return []proto.JobLog{
+				{RequestId: r, JobId: "j1", Try: 1},
+				{RequestId: r, JobId: "j1", Try: 2},
+				{RequestId: r, JobId: "j2", Try: 1},
+			}, nil
+		},
+		GetTryFunc: func(r, j string, try uint) (proto.JobLog, error) {
+			gotTry = try
+			return proto.JobLog{RequestId: r, JobId: j, Try: try}, nil
+		},
+		DeleteFunc: func(r string, old bool) (int64, error) {
+			deleted = r
+			oldTries = old
+			return 2, nil
+		},
+		PurgeFunc: func(before time.Time) (int64, error) {
+			purgedBefore = before
+			return 5, nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.RM = rm
+	ctx.JLS = jls
+	ctx.Config.JobLog.Retention = "1h"
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, []string{"test"}, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	// Latest tries only
+	var got []proto.JobLog
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"requests/req1/log?tries=latest", nil, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	expect := []proto.JobLog{
+		{RequestId: "req1", JobId: "j1", Try: 2},
+		{RequestId: "req1", JobId: "j2", Try: 1},
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// One try
+	var jl proto.JobLog
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"requests/req1/log/j1?try=1", nil, &jl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if gotTry != 1 || jl.Try != 1 {
+		t.Errorf("got try %d, expected 1", gotTry)
+	}
+
+	// Delete old tries of request finished before retention
+	var del proto.JobLogDelete
+	statusCode, _, err = testutil.MakeHTTPRequest("DELETE", server.URL+api.API_ROOT+"requests/req1/log?tries=old", nil, &del)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if deleted != "req1" || !oldTries || del.Deleted != 2 {
+		t.Errorf("deleted %s (old tries %t, %d entries), expected req1 old tries (2 entries)", deleted, oldTries, del.Deleted)
+	}
+
+	// Request finished within retention
+	finished = time.Now()
+	statusCode, _, err = testutil.MakeHTTPRequest("DELETE", server.URL+api.API_ROOT+"requests/req1/log", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+
+	// Purge older than retention (default)
+	del = proto.JobLogDelete{}
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"job-log/purge", nil, &del)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if del.Deleted != 5 {
+		t.Errorf("purged %d, expected 5", del.Deleted)
+	}
+	if d := time.Since(purgedBefore); d < time.Hour || d > time.Hour+time.Minute {
+		t.Errorf("purged before %s ago, expected 1h", d)
+	}
+
+	// Cannot purge within retention
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"job-log/purge?olderThan=30m", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRequestGroups(t *testing.T) {
+	n := 0
+	var created []proto.CreateRequest
+	var failed []string
+	rm := &mock.RequestManager{
+		CreateFunc: func(cr proto.CreateRequest) (proto.Request, error) {
+			n++
+			created = append(created, cr)
+			if cr.Type == "bad" {
+				return proto.Request{}, serr.ErrInvalidCreateRequest{Message: "bad request"}
+			}
+			return proto.Request{Id: fmt.Sprintf("req%d", n), Type: cr.Type, State: proto.STATE_PENDING}, nil
+		},
+		FailPendingFunc: func(id string) error {
+			failed = append(failed, id)
+			return nil
+		},
+	}
+	var gotGroup proto.RequestGroup
+	var stopped string
+	gm := &mock.GroupManager{
+		CreateFunc: func(g proto.RequestGroup) (proto.RequestGroup, error) {
+			gotGroup = g
+			g.Id = "group1"
+			g.State = proto.STATE_RUNNING
+			return g, nil
+		},
+		GetFunc: func(id string) (proto.RequestGroup, error) {
+			if id != "group1" {
+				return proto.RequestGroup{}, serr.GroupNotFound{GroupId: id}
+			}
+			return proto.RequestGroup{
+				Id:         "group1",
+				State:      proto.STATE_RUNNING,
+				RequestIds: []string{"req1", "req2"},
+				Requests: []proto.Request{
+					{Id: "req1", State: proto.STATE_COMPLETE},
+					{Id: "req2", State: proto.STATE_RUNNING},
+				},
+			}, nil
+		},
+		StopFunc: func(id string) error {
+			stopped = id
+			return nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.RM = rm
+	ctx.Groups = gm
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, []string{"test"}, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	// Create
+	payload := []byte(`{"policy":"sequential","stopOnFailure":true,"requests":[{"Type":"stop-host","Args":{"host":"a"}},{"Type":"stop-host","Args":{"host":"b"}}]}`)
+	var g proto.RequestGroup
+	statusCode, headers, err := testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"request-groups", payload, &g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusCreated)
+	}
+	if headers["Location"][0] != api.API_ROOT+"request-groups/group1" {
+		t.Errorf("location = %s, expected %s", headers["Location"][0], api.API_ROOT+"request-groups/group1")
+	}
+	expectGroup := proto.RequestGroup{
+		Policy:        proto.GROUP_POLICY_SEQUENTIAL,
+		StopOnFailure: true,
+		User:          "test",
+		RequestIds:    []string{"req1", "req2"},
+	}
+	if diff := deep.Equal(gotGroup, expectGroup); diff != nil {
+		t.Error(diff)
+	}
+	if g.Id != "group1" {
+		t.Errorf("got group %s, expected group1", g.Id)
+	}
+	if len(created) != 2 || created[1].Args["host"] != "b" || created[1].User != "test" {
+		t.Errorf("created requests %+v, expected 2 requests made by test", created)
+	}
+
+	// A request that cannot be created fails the requests created before it
+	n = 0
+	created = nil
+	gotGroup = proto.RequestGroup{}
+	payload = []byte(`{"requests":[{"Type":"stop-host"},{"Type":"bad"}]}`)
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"request-groups", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+	if diff := deep.Equal(failed, []string{"req1"}); diff != nil {
+		t.Error(diff)
+	}
+	if gotGroup.RequestIds != nil {
+		t.Errorf("group created, expected no group")
+	}
+
+	// Invalid policy is checked before creating requests
+	created = nil
+	payload = []byte(`{"policy":"random","requests":[{"Type":"stop-host"}]}`)
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"request-groups", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+	if len(created) != 0 {
+		t.Errorf("created %d requests, expected 0", len(created))
+	}
+
+	// Get
+	g = proto.RequestGroup{}
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"request-groups/group1", nil, &g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if len(g.Requests) != 2 || g.Requests[1].State != proto.STATE_RUNNING {
+		t.Errorf("got requests %+v, expected 2 with req2 running", g.Requests)
+	}
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"request-groups/nope", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+
+	// Stop
+	statusCode, _, err = testutil.MakeHTTPRequest("PUT", server.URL+api.API_ROOT+"request-groups/group1/stop", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if stopped != "group1" {
+		t.Errorf("stopped group %q, expected group1", stopped)
+	}
+}
+
+func TestJobTypeStats(t *testing.T) {
+	var gotWindow string
+	computedAt := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	jts := proto.JobTypeStats{
+		Window:     proto.STATS_WINDOW_WEEK,
+		ComputedAt: computedAt,
+		JobTypes: []proto.JobTypeStat{
+			{Type: "restart", Jobs: 4, Tries: 6, FailedJobs: 1, RetriedJobs: 2, FailureRate: 0.25, RetryRate: 0.5, MeanDuration: 3000000000,
+				TopErrors: []proto.ErrorCount{{Error: "timeout", Count: 3}}},
+		},
+	}
+	sm := &mock.StatsManager{
+		JobTypesFunc: func(window string) (proto.JobTypeStats, error) {
+			gotWindow = window
+			if window == "1y" {
+				return proto.JobTypeStats{}, serr.ValidationError{Message: "invalid window"}
+			}
+			return jts, nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.Stats = sm
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, nil, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	var got proto.JobTypeStats
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"stats/job-types?window=7d", nil, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if gotWindow != proto.STATS_WINDOW_WEEK {
+		t.Errorf("got window %q, expected %q", gotWindow, proto.STATS_WINDOW_WEEK)
+	}
+	if diff := deep.Equal(got, jts); diff != nil {
+		t.Error(diff)
+	}
+
+	// Invalid window
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"stats/job-types?window=1y", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+}
+
+func TestStatusRunningAsOf(t *testing.T) {
+	var gotFilter proto.StatusFilter
+	sm := &mock.RMStatus{
+		RunningFunc: func(f proto.StatusFilter) (proto.RunningStatus, error) {
+			gotFilter = f
+			return proto.RunningStatus{}, nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.Status = sm
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, nil, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	asOf := time.Date(2020, 6, 1, 12, 30, 0, 0, time.UTC)
+	f := proto.StatusFilter{RequestId: "abc", AsOf: asOf}
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"status/running"+f.String(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if diff := deep.Equal(gotFilter, f); diff != nil {
+		t.Error(diff)
+	}
+
+	// Invalid time
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"status/running?asOf=yesterday", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+}
+
+func TestJobRunners(t *testing.T) {
+	var gotJR proto.JobRunner
+	var deregistered string
+	jrs := []proto.JobRunner{
+		{URL: "http://jr1:32307", Capacity: 10, Running: 2, Alive: true},
+	}
+	reg := &mock.Registry{
+		HeartbeatFunc: func(jr proto.JobRunner) error {
+			gotJR = jr
+			return nil
+		},
+		DeregisterFunc: func(url string) error {
+			deregistered = url
+			return nil
+		},
+		ListFunc: func() ([]proto.JobRunner, error) {
+			return jrs, nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.Registry = reg
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, nil, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	// Heartbeat
+	payload := []byte(`{"url":"http://jr1:32307","labels":{"zone":"a"},"capacity":10,"running":2}`)
+	statusCode, _, err := testutil.MakeHTTPRequest("PUT", server.URL+api.API_ROOT+"job-runners", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	expectJR := proto.JobRunner{URL: "http://jr1:32307", Labels: map[string]string{"zone": "a"}, Capacity: 10, Running: 2}
+	if diff := deep.Equal(gotJR, expectJR); diff != nil {
+		t.Error(diff)
+	}
+
+	// List
+	var gotJRs []proto.JobRunner
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"job-runners", nil, &gotJRs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if diff := deep.Equal(gotJRs, jrs); diff != nil {
+		t.Error(diff)
+	}
+
+	// Deregister
+	statusCode, _, err = testutil.MakeHTTPRequest("DELETE", server.URL+api.API_ROOT+"job-runners?url=http%3A%2F%2Fjr1%3A32307", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if deregistered != "http://jr1:32307" {
+		t.Errorf("deregistered %q, expected http://jr1:32307", deregistered)
+	}
+
+	// Deregister requires url
+	statusCode, _, err = testutil.MakeHTTPRequest("DELETE", server.URL+api.API_ROOT+"job-runners", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	// Default metrics plugin (Nop): not Prometheus
+	setup(&mock.RequestManager{}, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", server.URL+"/metrics", nil, nil)
+	cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotImplemented {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotImplemented)
+	}
+
+	ctx := app.Defaults()
+	ctx.Plugins.Metrics = metrics.NewPrometheus("spincycle")
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, nil, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	if _, err := http.Get(server.URL + "/version"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", resp.StatusCode, http.StatusOK)
+	}
+	expect := `spincycle_api_request_duration_seconds_count{method="GET",path="/version",status="200"} 1` + "\n"
+	if !strings.Contains(string(body), expect) {
+		t.Errorf("metric %q not reported, got:\n%s", expect, body)
+	}
+}
+
+func TestTriggers(t *testing.T) {
+	var gotName, gotPayload string
+	tm := &mock.TriggerManager{
+		ReceiveFunc: func(name string, payload []byte) (proto.TriggerResult, error) {
+			gotName = name
+			gotPayload = string(payload)
+			if string(payload) == `{"deployId":"d1"}` {
+				return proto.TriggerResult{Trigger: name, RequestId: "abc", Duplicate: true}, nil
+			}
+			return proto.TriggerResult{Trigger: name, RequestId: "abc"}, nil
+		},
+		ErrorsFunc: func(name string) ([]proto.TriggerError, error) {
+			return []proto.TriggerError{{Id: 1, Trigger: name, Payload: "x", Error: "invalid payload"}}, nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.Config.Triggers = []config.Trigger{
+		{Name: "deploy-finished", Source: config.TRIGGER_SOURCE_WEBHOOK, Request: "warm-cache"},
+		{Name: "from-queue", Source: "sqs", Request: "warm-cache"},
+	}
+	ctx.Triggers = tm
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, []string{"test"}, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	var res proto.TriggerResult
+	statusCode, _, err := testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"triggers/deploy-finished", []byte(`{"app":"web"}`), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusCreated)
+	}
+	if gotName != "deploy-finished" || gotPayload != `{"app":"web"}` {
+		t.Errorf("got trigger %s payload %s, expected deploy-finished payload {\"app\":\"web\"}", gotName, gotPayload)
+	}
+	if res.RequestId != "abc" {
+		t.Errorf("got request ID %s, expected abc", res.RequestId)
+	}
+
+	// Duplicate
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"triggers/deploy-finished", []byte(`{"deployId":"d1"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+
+	// Not a webhook trigger, or no such trigger
+	for _, name := range []string{"from-queue", "nope"} {
+		statusCode, _, err = testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"triggers/"+name, []byte(`{}`), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statusCode != http.StatusNotFound {
+			t.Errorf("%s: response status = %d, expected %d", name, statusCode, http.StatusNotFound)
+		}
+	}
+
+	// Error queue
+	var errs []proto.TriggerError
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"triggers/deploy-finished/errors", nil, &errs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if len(errs) != 1 || errs[0].Trigger != "deploy-finished" {
+		t.Errorf("got errors %+v, expected 1 for deploy-finished", errs)
+	}
+}
+
+func TestJobSnapshotHandler(t *testing.T) {
+	var gotReqId, gotJobId string
+	snap := proto.JobSnapshot{
+		RequestId: "abc",
+		User:      "finch",
+		Job:       proto.Job{Id: "a1b2", Name: "drain", Type: "drain-host", Data: map[string]interface{}{"ip": "10.0.0.1"}},
+	}
+	rm := &mock.RequestManager{
+		JobSnapshotFunc: func(reqId, jobId string) (proto.JobSnapshot, error) {
+			gotReqId = reqId
+			gotJobId = jobId
+			return snap, nil
+		},
+	}
+	setup(rm, &mock.RequestResumer{}, &mock.JLStore{}, make(chan struct{}))
+	defer cleanup()
+
+	var got proto.JobSnapshot
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"requests/abc/jobs/a1b2/snapshot", nil, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if gotReqId != "abc" || gotJobId != "a1b2" {
+		t.Errorf("got snapshot of %s %s, expected abc a1b2", gotReqId, gotJobId)
+	}
+	if diff := deep.Equal(got, snap); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	var captured proto.ProfileCapture
+	rm := &mock.RequestManager{
+		CaptureProfileFunc: func(reqId string, pc proto.ProfileCapture) error {
+			if reqId != "abc" {
+				return serr.RequestNotFound{RequestId: reqId}
+			}
+			captured = pc
+			return nil
+		},
+	}
+	var attached proto.Profile
+	pm := &mock.ProfileManager{
+		CreateFunc: func(p proto.Profile) (proto.Profile, error) {
+			attached = p
+			p.Id = "p1"
+			p.Data = nil
+			return p, nil
+		},
+		GetFunc: func(reqId, profileId string) (proto.Profile, error) {
+			if profileId != "p1" {
+				return proto.Profile{}, serr.ProfileNotFound{RequestId: reqId, ProfileId: profileId}
+			}
+			return proto.Profile{Id: "p1", RequestId: reqId, Type: proto.PROFILE_CPU, Data: []byte("pprof")}, nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.RM = rm
+	ctx.Profiles = pm
+	ctx.Plugins.Auth = mockAuth
+
+	// Only admins can capture profiles; mockAuth caller has role "test"
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, []string{"admin"}, false, nil)
+	denied := httptest.NewServer(api.NewAPI(ctx))
+	defer denied.Close()
+	statusCode, _, err := testutil.MakeHTTPRequest("POST", denied.URL+api.API_ROOT+"requests/abc/profiles/capture", []byte(`{"duration":"5s"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusUnauthorized {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusUnauthorized)
+	}
+
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, []string{"test"}, false, nil)
+	admin := httptest.NewServer(api.NewAPI(ctx))
+	defer admin.Close()
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", admin.URL+api.API_ROOT+"requests/abc/profiles/capture", []byte(`{"duration":"5s"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if captured.Duration != "5s" {
+		t.Errorf("captured duration %q, expected 5s", captured.Duration)
+	}
+
+	// Job Runner attaches profile
+	var p proto.Profile
+	payload, _ := json.Marshal(proto.Profile{Type: proto.PROFILE_CPU, JobRunner: "jr1", Data: []byte("pprof")})
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", admin.URL+api.API_ROOT+"requests/abc/profiles", payload, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusCreated)
+	}
+	if attached.RequestId != "abc" || string(attached.Data) != "pprof" {
+		t.Errorf("attached %+v, expected request abc with data", attached)
+	}
+	if p.Id != "p1" || p.Data != nil {
+		t.Errorf("got profile %+v, expected p1 without data", p)
+	}
+
+	// Download profile data
+	res, err := http.Get(admin.URL + api.API_ROOT + "requests/abc/profiles/p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(data) != "pprof" {
+		t.Errorf("got status %d data %q, expected 200 and pprof", res.StatusCode, data)
+	}
+
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", admin.URL+api.API_ROOT+"requests/abc/profiles/p2", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+}
+
+func TestBackfills(t *testing.T) {
+	bm := &mock.BackfillManager{
+		ListFunc: func() ([]proto.Backfill, error) {
+			return []proto.Backfill{
+				{Name: "b1", State: proto.BACKFILL_STATE_DONE, Rows: 5000},
+				{Name: "b2", State: proto.BACKFILL_STATE_RUNNING, LastKey: "abc", Rows: 1000, RMHost: "rm1"},
+			}, nil
+		},
+		GetFunc: func(name string) (proto.Backfill, error) {
+			if name != "b2" {
+				return proto.Backfill{}, serr.BackfillNotFound{Name: name}
+			}
+			return proto.Backfill{Name: "b2", State: proto.BACKFILL_STATE_RUNNING, Rows: 1000}, nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.Backfills = bm
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, nil, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	var list []proto.Backfill
+	statusCode, _, err := testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"backfills", nil, &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if len(list) != 2 || list[1].Name != "b2" || list[1].LastKey != "abc" {
+		t.Errorf("got backfills %+v, expected b1 and b2", list)
+	}
+
+	var b proto.Backfill
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"backfills/b2", nil, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if b.Name != "b2" || b.Rows != 1000 {
+		t.Errorf("got backfill %+v, expected b2 with 1000 rows", b)
+	}
+
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"backfills/nope", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+}
+
+func TestExports(t *testing.T) {
+	finishedAt := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	rm := &mock.RequestManager{
+		GetFunc: func(id string) (proto.Request, error) {
+			if id == "running" {
+				return proto.Request{Id: id, State: proto.STATE_RUNNING}, nil
+			}
+			return proto.Request{Id: id, State: proto.STATE_COMPLETE, FinishedAt: &finishedAt}, nil
+		},
+	}
+	var queued, exported []string
+	em := &mock.ExportManager{
+		FinishedFunc: func(id string) {
+			queued = append(queued, id)
+		},
+		ExportFunc: func(id string) (proto.RequestExport, error) {
+			exported = append(exported, id)
+			return proto.RequestExport{RequestId: id, Location: "s3://bucket/" + id + ".json.gz", Size: 100}, nil
+		},
+		GetFunc: func(id string) (proto.RequestExport, error) {
+			if id != "abc" {
+				return proto.RequestExport{}, serr.ExportNotFound{RequestId: id}
+			}
+			return proto.RequestExport{RequestId: id, Location: "s3://bucket/abc.json.gz", Size: 100}, nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.RM = rm
+	ctx.Exports = em
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{}, []string{"test"}, false, nil)
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	// Finishing a request queues its export
+	payload := []byte(fmt.Sprintf("{\"state\":%d}", proto.STATE_COMPLETE))
+	statusCode, _, err := testutil.MakeHTTPRequest("PUT", server.URL+api.API_ROOT+"requests/abc/finish", payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if diff := deep.Equal(queued, []string{"abc"}); diff != nil {
+		t.Error(diff)
+	}
+
+	var exp proto.RequestExport
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"requests/abc/export", nil, &exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if exp.Location != "s3://bucket/abc.json.gz" {
+		t.Errorf("got location %s, expected s3://bucket/abc.json.gz", exp.Location)
+	}
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"requests/nope/export", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+
+	// Export now, only if finished
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"requests/def/export", nil, &exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	statusCode, _, err = testutil.MakeHTTPRequest("POST", server.URL+api.API_ROOT+"requests/running/export", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusBadRequest)
+	}
+	if diff := deep.Equal(exported, []string{"def"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestPresets(t *testing.T) {
+	rm := &mock.RequestManager{
+		SpecsFunc: func() []proto.RequestSpec {
+			return []proto.RequestSpec{{Name: "backup-db", Args: []proto.RequestArg{{Name: "host"}, {Name: "retain"}}}}
+		},
+	}
+	saved := map[string]proto.RequestPreset{
+		"theirs": {Name: "theirs", Type: "backup-db", CreatedBy: "other"},
+	}
+	pm := &mock.PresetManager{
+		PutFunc: func(p proto.RequestPreset) (proto.RequestPreset, error) {
+			saved[p.Name] = p
+			return p, nil
+		},
+		GetFunc: func(name string) (proto.RequestPreset, error) {
+			p, ok := saved[name]
+			if !ok {
+				return p, serr.PresetNotFound{Name: name}
+			}
+			return p, nil
+		},
+		DeleteFunc: func(name string) error {
+			delete(saved, name)
+			return nil
+		},
+	}
+	ctx := app.Defaults()
+	ctx.RM = rm
+	ctx.Presets = pm
+	ctx.Plugins.Auth = mockAuth
+	ctx.Auth = auth.NewManager(mockAuth, map[string][]auth.ACL{"backup-db": {}}, nil, false, nil) // caller "test" is not an admin
+	server := httptest.NewServer(api.NewAPI(ctx))
+	defer server.Close()
+
+	// Create
+	var got proto.RequestPreset
+	body := []byte(`{"type":"backup-db","args":{"host":"db01"},"description":"Nightly backup of db01"}`)
+	statusCode, _, err := testutil.MakeHTTPRequest("PUT", server.URL+api.API_ROOT+"presets/nightly-backup-db01", body, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusCreated)
+	}
+	expect := proto.RequestPreset{
+		Name:        "nightly-backup-db01",
+		Type:        "backup-db",
+		Args:        map[string]interface{}{"host": "db01"},
+		Description: "Nightly backup of db01",
+		CreatedBy:   "test",
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Replace own preset
+	statusCode, _, err = testutil.MakeHTTPRequest("PUT", server.URL+api.API_ROOT+"presets/nightly-backup-db01", body, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+
+	// Unknown request type or arg
+	for _, body := range []string{`{"type":"nope"}`, `{"type":"backup-db","args":{"nope":"x"}}`} {
+		statusCode, _, err = testutil.MakeHTTPRequest("PUT", server.URL+api.API_ROOT+"presets/bad", []byte(body), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statusCode != http.StatusBadRequest {
+			t.Errorf("%s: response status = %d, expected %d", body, statusCode, http.StatusBadRequest)
+		}
+	}
+
+	// Only the creator or admins can replace or delete a preset
+	statusCode, _, err = testutil.MakeHTTPRequest("PUT", server.URL+api.API_ROOT+"presets/theirs", body, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusUnauthorized {
+		t.Errorf("replace: response status = %d, expected %d", statusCode, http.StatusUnauthorized)
+	}
+	statusCode, _, err = testutil.MakeHTTPRequest("DELETE", server.URL+api.API_ROOT+"presets/theirs", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusUnauthorized {
+		t.Errorf("delete: response status = %d, expected %d", statusCode, http.StatusUnauthorized)
+	}
+	statusCode, _, err = testutil.MakeHTTPRequest("DELETE", server.URL+api.API_ROOT+"presets/nightly-backup-db01", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("delete: response status = %d, expected %d", statusCode, http.StatusOK)
+	}
+	if _, ok := saved["nightly-backup-db01"]; ok {
+		t.Error("preset not deleted")
+	}
+
+	statusCode, _, err = testutil.MakeHTTPRequest("GET", server.URL+api.API_ROOT+"presets/nope", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("response status = %d, expected %d", statusCode, http.StatusNotFound)
+	}
+}
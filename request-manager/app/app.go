@@ -11,13 +11,28 @@ import (
 
 	"github.com/go-sql-driver/mysql"
 
+	"github.com/square/spincycle/v2/compress"
 	"github.com/square/spincycle/v2/config"
 	jr "github.com/square/spincycle/v2/job-runner"
+	"github.com/square/spincycle/v2/metrics"
+	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/request-manager/auth"
+	"github.com/square/spincycle/v2/request-manager/backfill"
+	"github.com/square/spincycle/v2/request-manager/cost"
+	"github.com/square/spincycle/v2/request-manager/export"
+	"github.com/square/spincycle/v2/request-manager/group"
 	"github.com/square/spincycle/v2/request-manager/joblog"
+	"github.com/square/spincycle/v2/request-manager/preset"
+	"github.com/square/spincycle/v2/request-manager/profile"
+	"github.com/square/spincycle/v2/request-manager/registry"
 	"github.com/square/spincycle/v2/request-manager/request"
+	"github.com/square/spincycle/v2/request-manager/shadow"
+	"github.com/square/spincycle/v2/request-manager/singleton"
 	"github.com/square/spincycle/v2/request-manager/spec"
+	"github.com/square/spincycle/v2/request-manager/stats"
 	"github.com/square/spincycle/v2/request-manager/status"
+	"github.com/square/spincycle/v2/request-manager/token"
+	"github.com/square/spincycle/v2/request-manager/trigger"
 )
 
 // Context represents the config, core service singletons, and 3rd-party extensions.
@@ -29,11 +44,23 @@ type Context struct {
 	Specs  spec.Specs
 
 	// Core service singletons, not user-configurable
-	RM     request.Manager
-	RR     request.Resumer
-	Status status.Manager
-	Auth   auth.Manager
-	JLS    joblog.Store
+	RM         request.Manager
+	RR         request.Resumer
+	Groups     group.Manager
+	Status     status.Manager
+	Auth       auth.Manager
+	JLS        joblog.Store
+	Shadow     shadow.Manager
+	Tokens     token.Manager
+	Costs      cost.Manager
+	Registry   registry.Manager
+	Stats      stats.Manager
+	Singletons singleton.Manager
+	Triggers   trigger.Manager
+	Profiles   profile.Manager
+	Backfills  backfill.Manager
+	Exports    export.Manager // nil if Plugins.ObjectStore is not set
+	Presets    preset.Manager
 
 	// Closed to initiate RM shutdown
 	ShutdownChan chan struct{}
@@ -71,6 +98,20 @@ type Hooks struct {
 	// returns an error.
 	SetUsername func(*http.Request) (string, error)
 
+	// PreCreateRequest is called before every new request is created (from the
+	// API, request groups, triggers, and rebased reruns) with the request spec
+	// and the create request. It can change the create request, like to add or
+	// normalize args, but not its type or the spec. If it returns an error, the
+	// request is rejected and the API returns HTTP 403 with the error. Use it to
+	// implement policies like naming and quotas.
+	PreCreateRequest func(spec.Sequence, *proto.CreateRequest) error
+
+	// PostCreateRequest is called after a request is created and saved, before
+	// it's started. It cannot fail the request. It's called synchronously, so
+	// it should return quickly. The request and its job chain must not be
+	// changed.
+	PostCreateRequest func(proto.Request)
+
 	// RunAPI runs the Request Manager API. It should block until the API is
 	// stopped via a call to StopAPI. If this hook is provided, it is called
 	// instead of api.Run(). If you provide this hook, you need to provide StopAPI
@@ -90,7 +131,23 @@ type Hooks struct {
 // completely. For example, the Auth plugin allows the user to provide a complete
 // and custom system of authentication and authorization.
 type Plugins struct {
-	Auth auth.Plugin
+	Auth         auth.Plugin
+	CostReporter cost.Reporter
+
+	// Metrics reports requests and API calls. The default, metrics.Nop,
+	// reports nothing. GET /metrics returns the metrics if it's a
+	// *metrics.Prometheus.
+	Metrics metrics.Metrics
+
+	// TriggerSources are trigger source plugins, like SQS queue or Kafka topic
+	// consumers, by name. Triggers use them by name (config trigger source).
+	// There are no default sources; the webhook source is built in.
+	TriggerSources map[string]trigger.Source
+
+	// ObjectStore is the object storage plugin, like an S3 or GCS client.
+	// If set, finished requests are exported to it (see export package).
+	// There is no default; requests are not exported.
+	ObjectStore export.ObjectStore
 }
 
 // Defaults returns a Context with default (built-in) 3rd-party extensions.
@@ -112,7 +169,9 @@ func Defaults() Context {
 			LoadSpecs:  LoadSpecs,
 		},
 		Plugins: Plugins{
-			Auth: auth.AllowAll{},
+			Auth:         auth.AllowAll{},
+			CostReporter: cost.NoReporter{},
+			Metrics:      metrics.Nop{},
 		},
 	}
 }
@@ -136,10 +195,15 @@ func LoadSpecs(ctx Context) (spec.Specs, *spec.CheckResults, error) {
 
 // MakeJobRunnerClient is the default MakeJobRunnerClient factory.
 func MakeJobRunnerClient(ctx Context) (jr.Client, error) {
+	return NewJobRunnerClient(ctx.Config.JRClient)
+}
+
+// NewJobRunnerClient makes a Job Runner client from a jr_client config section.
+// It is used by MakeJobRunnerClient, and for the shadow Job Runner client.
+func NewJobRunnerClient(jrcfg config.HTTPClient) (jr.Client, error) {
 	httpClient := &http.Client{}
-	jrcfg := ctx.Config.JRClient
-	if jrcfg.TLS.CertFile != "" && jrcfg.TLS.KeyFile != "" && jrcfg.TLS.CAFile != "" {
-		tlsConfig, err := config.NewTLSConfig(jrcfg.TLS.CAFile, jrcfg.TLS.CertFile, jrcfg.TLS.KeyFile)
+	if jrcfg.TLS.CAFile != "" {
+		tlsConfig, err := config.NewClientTLSConfig(jrcfg.TLS)
 		if err != nil {
 			return nil, fmt.Errorf("error loading JR client TLS config: %s", err)
 		}
@@ -147,7 +211,26 @@ func MakeJobRunnerClient(ctx Context) (jr.Client, error) {
 			Transport: &http.Transport{TLSClientConfig: tlsConfig},
 		}
 	}
-	jrc := jr.NewClient(httpClient)
+	if jrcfg.Compression != "" {
+		codec, err := compress.Get(jrcfg.Compression)
+		if err != nil {
+			return nil, fmt.Errorf("error loading JR client compression: %s", err)
+		}
+		httpClient.Transport = &compress.Transport{Base: httpClient.Transport, Codec: codec}
+	}
+	var retryWait time.Duration
+	if jrcfg.RetryWait != "" {
+		var err error
+		retryWait, err = time.ParseDuration(jrcfg.RetryWait)
+		if err != nil {
+			return nil, fmt.Errorf("error loading JR client config: retry_wait: invalid duration %q", jrcfg.RetryWait)
+		}
+	}
+	jrc := jr.NewClientWithConfig(httpClient, jr.ClientConfig{
+		StreamJobs: jrcfg.StreamJobs,
+		Retry:      jrcfg.Retry,
+		RetryWait:  retryWait,
+	})
 	return jrc, nil
 }
 
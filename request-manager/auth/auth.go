@@ -9,6 +9,7 @@ package auth
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/square/spincycle/v2/proto"
 )
@@ -26,6 +27,12 @@ type Caller struct {
 	// are matched against request ACL roles in specs, which are also user-defined.
 	// Roles are case-sensitive and not modified by Spin Cycle in any way.
 	Roles []string
+
+	// Token is set if the caller authenticated with an API token instead of
+	// the Plugin. Roles are then the current roles of the token user, see
+	// RoleResolver. The token ops and requests further limit what the caller
+	// is authorized to do, even if the caller has an admin role.
+	Token *proto.Token
 }
 
 // Plugin represents the auth plugin. Every request is authenticated and authorized.
@@ -54,6 +61,17 @@ type Plugin interface {
 	Authorize(c Caller, op string, req proto.Request) error
 }
 
+// RoleResolver is an optional Plugin interface to resolve the roles of a user
+// without an HTTP request. It's required for callers that authenticate with an
+// API token to have roles: a token stores only its user, and the user's roles
+// are resolved every time the token is used. If the Plugin does not implement
+// this interface, API token callers have no roles.
+type RoleResolver interface {
+	// Roles returns the current roles of the user, the same roles that
+	// Authenticate returns for the user. Access is denied (HTTP 401) on error.
+	Roles(user string) ([]string, error)
+}
+
 // AllowAll is the default Plugin which allows all callers and requests (no auth).
 type AllowAll struct{}
 
@@ -87,18 +105,20 @@ type ACL struct {
 // specs, and options from the config file. Other components use a Manager, not the
 // Plugin directly.
 type Manager struct {
-	plugin     Plugin           // user-defined or AllowAll
-	acls       map[string][]ACL // from request specs
-	adminRoles []string         // from config file
-	strict     bool             // from config file
+	plugin        Plugin           // user-defined or AllowAll
+	acls          map[string][]ACL // from request specs
+	adminRoles    []string         // from config file
+	strict        bool             // from config file
+	readOnlyRoles []string         // from config file
 }
 
-func NewManager(plugin Plugin, acls map[string][]ACL, adminRoles []string, strict bool) Manager {
+func NewManager(plugin Plugin, acls map[string][]ACL, adminRoles []string, strict bool, readOnlyRoles []string) Manager {
 	return Manager{
-		plugin:     plugin,
-		acls:       acls,
-		adminRoles: adminRoles,
-		strict:     strict,
+		plugin:        plugin,
+		acls:          acls,
+		adminRoles:    adminRoles,
+		strict:        strict,
+		readOnlyRoles: readOnlyRoles,
 	}
 }
 
@@ -108,6 +128,16 @@ func (m Manager) Authenticate(req *http.Request) (Caller, error) {
 	return m.plugin.Authenticate(req)
 }
 
+// Roles returns the current roles of the user if the plugin implements
+// RoleResolver, else it returns nil (no roles).
+func (m Manager) Roles(user string) ([]string, error) {
+	rr, ok := m.plugin.(RoleResolver)
+	if !ok {
+		return nil, nil
+	}
+	return rr.Roles(user)
+}
+
 // Authorize authorizes the request based on its ACLs, if any. If the Caller has
 // an admin role, it is allowed immediately (no further checks). Else, Caller
 // roles are matched to request ACL roles. On match, the op is matched to the
@@ -118,8 +148,22 @@ func (m Manager) Authenticate(req *http.Request) (Caller, error) {
 // mode determines the result: allow if disabled (no ACLs = allow all), deny
 // if enabled (no ACLs = deny all non-admins).
 //
+// Read-only callers (see IsReadOnly) are denied every op.
+//
 // Any return error denies the request (HTTP 401), and the error message explains why.
 func (m Manager) Authorize(caller Caller, op string, req proto.Request) error {
+	// API tokens are scoped to ops and requests regardless of roles
+	if caller.Token != nil {
+		if err := tokenAllows(*caller.Token, op, req.Type); err != nil {
+			return err
+		}
+	}
+
+	// Read-only callers can only view, which is not an op
+	if m.IsReadOnly(caller) {
+		return fmt.Errorf("denied: caller is read-only and cannot %s requests", op)
+	}
+
 	// Always allow admins, nothing more to check. This is global admin_roles from config:
 	// role which are admins for all requests regardless of request-specific ACLs.
 	if m.isAdmin(caller) {
@@ -189,6 +233,30 @@ REQUEST_ACLS:
 	return nil // allow
 }
 
+// IsAdmin returns true if the caller has an admin role.
+func (m Manager) IsAdmin(caller Caller) bool {
+	return m.isAdmin(caller)
+}
+
+// IsReadOnly returns true if the caller can only view: it authenticated with a
+// read-only API token, or it has a read-only role and no admin role.
+func (m Manager) IsReadOnly(caller Caller) bool {
+	if caller.Token != nil && caller.Token.ReadOnly {
+		return true
+	}
+	if len(m.readOnlyRoles) == 0 || m.isAdmin(caller) {
+		return false
+	}
+	for _, rorole := range m.readOnlyRoles {
+		for _, crole := range caller.Roles {
+			if crole == rorole {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // isAdmin returns true if the caller has an admin role.
 func (m Manager) isAdmin(caller Caller) bool {
 	if len(m.adminRoles) == 0 {
@@ -203,3 +271,32 @@ func (m Manager) isAdmin(caller Caller) bool {
 	}
 	return false
 }
+
+// tokenAllows returns nil if the API token scope allows the op for the request type.
+func tokenAllows(tok proto.Token, op, reqType string) error {
+	if len(tok.Ops) > 0 {
+		opMatch := false
+		for _, tokop := range tok.Ops {
+			if tokop == op {
+				opMatch = true
+				break
+			}
+		}
+		if !opMatch {
+			return fmt.Errorf("denied: API token %s does not grant %s op", tok.Id, op)
+		}
+	}
+	if len(tok.Requests) > 0 {
+		reqMatch := false
+		for _, name := range tok.Requests {
+			if name == reqType || (strings.HasSuffix(name, "*") && strings.HasPrefix(reqType, strings.TrimSuffix(name, "*"))) {
+				reqMatch = true
+				break
+			}
+		}
+		if !reqMatch {
+			return fmt.Errorf("denied: API token %s does not grant access to request %s", tok.Id, reqType)
+		}
+	}
+	return nil
+}
@@ -21,7 +21,7 @@ func TestManagerAuthenticate(t *testing.T) {
 		},
 	}
 
-	m := auth.NewManager(plugin, map[string][]auth.ACL{}, nil, true)
+	m := auth.NewManager(plugin, map[string][]auth.ACL{}, nil, true, nil)
 	gotCaller, err := m.Authenticate(nil)
 	if err != nil {
 		t.Error(err)
@@ -54,7 +54,7 @@ func TestManagerWithACLs(t *testing.T) {
 		},
 	}
 	adminRoles := []string{"finch"}
-	m := auth.NewManager(plugin, acls, adminRoles, true)
+	m := auth.NewManager(plugin, acls, adminRoles, true, nil)
 
 	caller := auth.Caller{
 		Name:  "dn",
@@ -164,7 +164,7 @@ func TestManagerNoACLs(t *testing.T) {
 			return authErr
 		},
 	}
-	m := auth.NewManager(plugin, acls, nil, true) // true = STRICT MODE
+	m := auth.NewManager(plugin, acls, nil, true, nil) // true = STRICT MODE
 
 	caller := auth.Caller{
 		Name:  "dn",
@@ -187,7 +187,7 @@ func TestManagerNoACLs(t *testing.T) {
 	}
 
 	// But turn strict mode off and no ACLs = allow all
-	m = auth.NewManager(plugin, acls, nil, false) // false = strict mode off
+	m = auth.NewManager(plugin, acls, nil, false, nil) // false = strict mode off
 	authCalled = false
 	err = m.Authorize(caller, proto.REQUEST_OP_START, req)
 	if err != nil {
@@ -198,6 +198,76 @@ func TestManagerNoACLs(t *testing.T) {
 	}
 }
 
+func TestManagerToken(t *testing.T) {
+	acls := map[string][]auth.ACL{
+		"stop-host":  nil,
+		"start-host": nil,
+		"reboot":     nil,
+	}
+	adminRoles := []string{"finch"}
+	m := auth.NewManager(auth.AllowAll{}, acls, adminRoles, false, nil)
+
+	// Token scopes limit admins, too
+	caller := auth.Caller{
+		Name:  "dn",
+		Roles: []string{"finch"},
+		Token: &proto.Token{
+			Id:       "tok1",
+			Ops:      []string{proto.REQUEST_OP_STOP},
+			Requests: []string{"reboot", "st*"},
+		},
+	}
+
+	if err := m.Authorize(caller, proto.REQUEST_OP_STOP, proto.Request{Type: "stop-host"}); err != nil {
+		t.Errorf("not allowed (%s), expected stop-host stop to be allowed by prefix", err)
+	}
+	if err := m.Authorize(caller, proto.REQUEST_OP_STOP, proto.Request{Type: "reboot"}); err != nil {
+		t.Errorf("not allowed (%s), expected reboot stop to be allowed", err)
+	}
+	if err := m.Authorize(caller, proto.REQUEST_OP_START, proto.Request{Type: "stop-host"}); err == nil {
+		t.Errorf("start allowed, expected token to deny start op")
+	}
+	if err := m.Authorize(caller, proto.REQUEST_OP_STOP, proto.Request{Type: "reboot-host"}); err == nil {
+		t.Errorf("reboot-host allowed, expected token to deny request")
+	}
+
+	// No ops or requests = token does not limit the caller
+	caller.Token = &proto.Token{Id: "tok2"}
+	if err := m.Authorize(caller, proto.REQUEST_OP_START, proto.Request{Type: "reboot"}); err != nil {
+		t.Errorf("not allowed (%s), expected unscoped token to be allowed", err)
+	}
+}
+
+func TestManagerReadOnly(t *testing.T) {
+	acls := map[string][]auth.ACL{
+		"stop-host": nil,
+	}
+	m := auth.NewManager(auth.AllowAll{}, acls, []string{"finch"}, false, []string{"viewer"})
+
+	viewer := auth.Caller{Name: "dn", Roles: []string{"viewer"}}
+	if !m.IsReadOnly(viewer) {
+		t.Errorf("viewer not read-only, expected read-only")
+	}
+	if err := m.Authorize(viewer, proto.REQUEST_OP_STOP, proto.Request{Type: "stop-host"}); err == nil {
+		t.Errorf("read-only caller allowed to stop, expected denied")
+	}
+
+	// Admin role overrides read-only role
+	admin := auth.Caller{Name: "dn", Roles: []string{"viewer", "finch"}}
+	if m.IsReadOnly(admin) {
+		t.Errorf("admin is read-only, expected admin role to override read-only role")
+	}
+
+	// Read-only token is read-only even for admins
+	admin.Token = &proto.Token{Id: "tok1", ReadOnly: true}
+	if !m.IsReadOnly(admin) {
+		t.Errorf("admin with read-only token not read-only, expected read-only")
+	}
+	if err := m.Authorize(admin, proto.REQUEST_OP_START, proto.Request{Type: "stop-host"}); err == nil {
+		t.Errorf("read-only token allowed to start, expected denied")
+	}
+}
+
 func TestAllowAll(t *testing.T) {
 	all := auth.AllowAll{}
 
@@ -211,3 +281,35 @@ func TestAllowAll(t *testing.T) {
 		t.Errorf("not allowed (%s), expected Authorize to return nil", err)
 	}
 }
+
+func TestManagerRoles(t *testing.T) {
+	// Plugin does not implement auth.RoleResolver: no roles
+	m := auth.NewManager(auth.AllowAll{}, nil, nil, false, nil)
+	roles, err := m.Roles("dn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles != nil {
+		t.Errorf("got roles %v, expected nil", roles)
+	}
+
+	plugin := mock.AuthPlugin{
+		RolesFunc: func(user string) ([]string, error) {
+			if user != "dn" {
+				return nil, fmt.Errorf("unknown user %s", user)
+			}
+			return []string{"eng"}, nil
+		},
+	}
+	m = auth.NewManager(plugin, nil, nil, false, nil)
+	roles, err = m.Roles("dn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 1 || roles[0] != "eng" {
+		t.Errorf("got roles %v, expected [eng]", roles)
+	}
+	if _, err := m.Roles("finch"); err == nil {
+		t.Errorf("no error for unknown user, expected plugin error")
+	}
+}
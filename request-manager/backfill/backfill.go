@@ -0,0 +1,371 @@
+// Copyright 2020, Square, Inc.
+
+// Package backfill runs online data migrations (backfills). A schema migration
+// (request-manager/resources/migrations) only changes the schema, like adding a
+// column or index, which MySQL does online. A backfill migrates the existing
+// data, like setting the new column in every job log entry, which can take hours
+// on large tables. Instead of a long maintenance window, the Request Manager
+// migrates data in the background in small chunks, throttled to limit the load
+// on MySQL, while it keeps serving requests.
+//
+// Progress is saved in table backfills after every chunk, so a backfill resumes
+// after the last chunk migrated when the Request Manager restarts. Only one
+// Request Manager instance runs a backfill at a time: the instance that claims
+// it. If that instance stops, another instance takes over the backfill after
+// ClaimTimeout. Backfills run in the order registered, one at a time, so a
+// backfill can depend on the data migrated by the previous ones.
+//
+// To add a backfill, append it to Backfills. New code must handle rows not yet
+// migrated until the backfill is done (Manager.Get returns state done).
+package backfill
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	log "github.com/sirupsen/logrus"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+)
+
+var (
+	// How long since its last chunk before a running backfill can be claimed
+	// by another Request Manager instance, in case the instance running it
+	// stopped without releasing it.
+	ClaimTimeout = 1 * time.Minute
+
+	// How long to wait before trying again after a chunk error, or before
+	// checking again if a backfill is claimed by another instance.
+	RetryWait = 10 * time.Second
+)
+
+// A Backfill migrates the data of one schema change in chunks.
+type Backfill struct {
+	// Name is the unique name of the backfill, like "v023_job_log_error_class".
+	// It's the key of its progress in table backfills, so it must never change.
+	Name string
+
+	// Chunk migrates at most size rows after lastKey, which is "" for the first
+	// chunk. It returns the key of the last row migrated, which is passed as
+	// lastKey to the next chunk, and the number of rows migrated. The backfill
+	// is done when Chunk returns zero rows. Rows should be selected by primary
+	// key (WHERE pk > lastKey ORDER BY pk LIMIT size) so each chunk is fast.
+	//
+	// A chunk can run again if the Request Manager stops before its progress is
+	// saved, so Chunk must be idempotent. If it returns an error, the error is
+	// saved and the same chunk is tried again after RetryWait.
+	Chunk func(ctx context.Context, db *sql.DB, lastKey string, size uint) (nextKey string, rows uint, err error)
+}
+
+// Backfills are the backfills run by the Request Manager, in order. Backfills
+// that are done are skipped, so entries can be removed once every Request
+// Manager has run them, but never reordered.
+var Backfills = []Backfill{}
+
+// A Manager runs backfills and reports their progress.
+type Manager interface {
+	// Run runs the backfills not done, in order, until all are done or the stop
+	// channel is closed. It does nothing if backfills are disabled on this
+	// instance. All errors are logged, not returned.
+	Run(stopChan <-chan struct{})
+
+	// List returns the progress of all backfills: registered backfills in order,
+	// then backfills no longer registered (done by a previous version) by name.
+	// A registered backfill not started yet is pending.
+	List() ([]proto.Backfill, error)
+
+	// Get returns the progress of one backfill, or serr.BackfillNotFound if the
+	// backfill is neither registered nor in table backfills.
+	Get(name string) (proto.Backfill, error)
+}
+
+type ManagerConfig struct {
+	Backfills   []Backfill
+	DBConnector *sql.DB
+	RMHost      string        // claims backfills run by this instance
+	ChunkSize   uint          // config.Backfill.ChunkSize
+	Throttle    time.Duration // config.Backfill.Throttle
+	Disabled    bool          // config.Backfill.Disabled
+}
+
+type manager struct {
+	backfills []Backfill
+	dbc       *sql.DB
+	rmHost    string
+	chunkSize uint
+	throttle  time.Duration
+	disabled  bool
+}
+
+// NewManager makes a Manager. It returns an error if a backfill has no name or
+// chunk func, or two backfills have the same name.
+func NewManager(cfg ManagerConfig) (Manager, error) {
+	seen := map[string]bool{}
+	for _, b := range cfg.Backfills {
+		if b.Name == "" {
+			return nil, fmt.Errorf("backfill has no name")
+		}
+		if b.Chunk == nil {
+			return nil, fmt.Errorf("backfill %s has no chunk func", b.Name)
+		}
+		if seen[b.Name] {
+			return nil, fmt.Errorf("backfill %s is registered twice", b.Name)
+		}
+		seen[b.Name] = true
+	}
+	return &manager{
+		backfills: cfg.Backfills,
+		dbc:       cfg.DBConnector,
+		rmHost:    cfg.RMHost,
+		chunkSize: cfg.ChunkSize,
+		throttle:  cfg.Throttle,
+		disabled:  cfg.Disabled,
+	}, nil
+}
+
+func (m *manager) Run(stopChan <-chan struct{}) {
+	if m.disabled || len(m.backfills) == 0 {
+		return
+	}
+
+	// Cancel the chunk running when stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		done, err := m.runAll(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Errorf("error running backfills: %s", err)
+		} else if done {
+			log.Infof("all backfills done")
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(RetryWait):
+		}
+	}
+}
+
+// runAll runs the backfills in order. It returns true if all are done. It stops
+// at the first backfill not done that it cannot run: claimed by another instance
+// or failed, because the next backfills can depend on it.
+func (m *manager) runAll(ctx context.Context) (bool, error) {
+	for _, b := range m.backfills {
+		claimed, done, lastKey, err := m.claim(ctx, b.Name)
+		if err != nil {
+			return false, err
+		}
+		if done {
+			continue
+		}
+		if !claimed {
+			log.Infof("backfill %s: running on another Request Manager, waiting", b.Name)
+			return false, nil
+		}
+		if err := m.run(ctx, b, lastKey); err != nil {
+			return false, fmt.Errorf("backfill %s: %s", b.Name, err)
+		}
+		if ctx.Err() != nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// claim claims the backfill for this instance, unless it's done or claimed by
+// another instance that updated it within ClaimTimeout. If claimed, it returns
+// the key of the last row migrated.
+func (m *manager) claim(ctx context.Context, name string) (claimed, done bool, lastKey string, err error) {
+	q := "INSERT IGNORE INTO backfills (name, state) VALUES (?, ?)"
+	if _, err = m.dbc.ExecContext(ctx, q, name, proto.BACKFILL_STATE_PENDING); err != nil {
+		return false, false, "", serr.NewDbError(err, "INSERT backfills")
+	}
+
+	q = "UPDATE backfills SET state = ?, rm_host = ?, started_at = IFNULL(started_at, NOW(6)), updated_at = NOW(6)" +
+		" WHERE name = ? AND state <> ? AND (rm_host IS NULL OR rm_host = ? OR updated_at < NOW(6) - INTERVAL ? SECOND)"
+	res, err := m.dbc.ExecContext(ctx, q, proto.BACKFILL_STATE_RUNNING, m.rmHost,
+		name, proto.BACKFILL_STATE_DONE, m.rmHost, int(ClaimTimeout.Seconds()))
+	if err != nil {
+		return false, false, "", serr.NewDbError(err, "UPDATE backfills")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, false, "", serr.NewDbError(err, "UPDATE backfills")
+	}
+
+	var state string
+	q = "SELECT state, last_key FROM backfills WHERE name = ?"
+	if err = m.dbc.QueryRowContext(ctx, q, name).Scan(&state, &lastKey); err != nil {
+		return false, false, "", serr.NewDbError(err, "SELECT backfills")
+	}
+	return n == 1, state == proto.BACKFILL_STATE_DONE, lastKey, nil
+}
+
+// run runs the claimed backfill chunk by chunk, starting after lastKey, until
+// it's done, a chunk fails, or ctx is canceled. When canceled, it releases the
+// claim so another instance can take over without waiting for ClaimTimeout.
+func (m *manager) run(ctx context.Context, b Backfill, lastKey string) error {
+	log.Infof("backfill %s: running after key %q", b.Name, lastKey)
+	for {
+		nextKey, rows, err := b.Chunk(ctx, m.dbc, lastKey, m.chunkSize)
+		if ctx.Err() != nil {
+			m.release(b.Name)
+			return nil
+		}
+		if err != nil {
+			m.saveError(b.Name, err)
+			return err
+		}
+
+		if rows == 0 {
+			q := "UPDATE backfills SET state = ?, rm_host = NULL, error = NULL, updated_at = NOW(6), finished_at = NOW(6)" +
+				" WHERE name = ? AND rm_host = ?"
+			if _, err := m.dbc.Exec(q, proto.BACKFILL_STATE_DONE, b.Name, m.rmHost); err != nil {
+				return serr.NewDbError(err, "UPDATE backfills")
+			}
+			log.Infof("backfill %s: done", b.Name)
+			return nil
+		}
+
+		q := "UPDATE backfills SET last_key = ?, rows_done = rows_done + ?, error = NULL, updated_at = NOW(6)" +
+			" WHERE name = ? AND rm_host = ?"
+		res, err := m.dbc.Exec(q, nextKey, rows, b.Name, m.rmHost)
+		if err != nil {
+			return serr.NewDbError(err, "UPDATE backfills")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return serr.NewDbError(err, "UPDATE backfills")
+		}
+		if n == 0 {
+			// Another instance claimed it after ClaimTimeout, so this instance
+			// was too slow. The chunk is idempotent, so the other instance
+			// running it again is harmless.
+			return fmt.Errorf("claimed by another Request Manager")
+		}
+		lastKey = nextKey
+
+		select {
+		case <-ctx.Done():
+			m.release(b.Name)
+			return nil
+		case <-time.After(m.throttle):
+		}
+	}
+}
+
+func (m *manager) release(name string) {
+	q := "UPDATE backfills SET rm_host = NULL WHERE name = ? AND rm_host = ?"
+	if _, err := m.dbc.Exec(q, name, m.rmHost); err != nil {
+		log.Errorf("backfill %s: error releasing claim: %s", name, err)
+	}
+}
+
+func (m *manager) saveError(name string, chunkErr error) {
+	q := "UPDATE backfills SET error = ?, updated_at = NOW(6) WHERE name = ? AND rm_host = ?"
+	if _, err := m.dbc.Exec(q, chunkErr.Error(), name, m.rmHost); err != nil {
+		log.Errorf("backfill %s: error saving error: %s", name, err)
+	}
+}
+
+func (m *manager) List() ([]proto.Backfill, error) {
+	q := "SELECT name, state, last_key, rows_done, error, rm_host, started_at, updated_at, finished_at FROM backfills"
+	rows, err := m.dbc.Query(q)
+	if err != nil {
+		return nil, serr.NewDbError(err, "SELECT backfills")
+	}
+	defer rows.Close()
+	saved := map[string]proto.Backfill{}
+	for rows.Next() {
+		b, err := scanBackfill(rows)
+		if err != nil {
+			return nil, err
+		}
+		saved[b.Name] = b
+	}
+	if err := rows.Err(); err != nil {
+		return nil, serr.NewDbError(err, "SELECT backfills")
+	}
+
+	list := make([]proto.Backfill, 0, len(m.backfills)+len(saved))
+	for _, r := range m.backfills {
+		b, ok := saved[r.Name]
+		if !ok {
+			b = proto.Backfill{Name: r.Name, State: proto.BACKFILL_STATE_PENDING}
+		}
+		list = append(list, b)
+		delete(saved, r.Name)
+	}
+	old := make([]proto.Backfill, 0, len(saved))
+	for _, b := range saved {
+		old = append(old, b)
+	}
+	sort.Slice(old, func(i, j int) bool { return old[i].Name < old[j].Name })
+	return append(list, old...), nil
+}
+
+func (m *manager) Get(name string) (proto.Backfill, error) {
+	q := "SELECT name, state, last_key, rows_done, error, rm_host, started_at, updated_at, finished_at FROM backfills WHERE name = ?"
+	b, err := scanBackfill(m.dbc.QueryRow(q, name))
+	if err == nil {
+		return b, nil
+	}
+	if err != sql.ErrNoRows {
+		return proto.Backfill{}, err
+	}
+	for _, r := range m.backfills {
+		if r.Name == name {
+			return proto.Backfill{Name: name, State: proto.BACKFILL_STATE_PENDING}, nil
+		}
+	}
+	return proto.Backfill{}, serr.BackfillNotFound{Name: name}
+}
+
+// scanner is a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanBackfill scans a row of the List and Get query. It returns sql.ErrNoRows
+// as is, other errors as serr.DbError.
+func scanBackfill(row scanner) (proto.Backfill, error) {
+	var b proto.Backfill
+	var errMsg, rmHost sql.NullString
+	var startedAt, updatedAt, finishedAt mysql.NullTime
+	err := row.Scan(&b.Name, &b.State, &b.LastKey, &b.Rows, &errMsg, &rmHost, &startedAt, &updatedAt, &finishedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return b, err
+		}
+		return b, serr.NewDbError(err, "SELECT backfills")
+	}
+	b.Error = errMsg.String
+	b.RMHost = rmHost.String
+	if startedAt.Valid {
+		b.StartedAt = &startedAt.Time
+	}
+	if updatedAt.Valid {
+		b.UpdatedAt = &updatedAt.Time
+	}
+	if finishedAt.Valid {
+		b.FinishedAt = &finishedAt.Time
+	}
+	return b, nil
+}
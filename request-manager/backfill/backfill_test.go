@@ -0,0 +1,252 @@
+// Copyright 2020, Square, Inc.
+
+package backfill_test
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/backfill"
+	testdb "github.com/square/spincycle/v2/request-manager/test/db"
+)
+
+var dbm testdb.Manager
+var dbc *sql.DB
+
+func setup(t *testing.T, dataFile string) string {
+	var err error
+	if dbm == nil {
+		dbm, err = testdb.NewManager()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbName, err := dbm.Create(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbm.Connect(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbc = db
+	return dbName
+}
+
+func teardown(t *testing.T, dbName string) {
+	if err := dbm.Destroy(dbName); err != nil {
+		t.Fatal(err)
+	}
+	dbc.Close()
+}
+
+// keyBackfill migrates keys, a sorted list, in chunks and records the keys
+// migrated.
+type keyBackfill struct {
+	keys     []string
+	migrated []string
+	*sync.Mutex
+}
+
+func newKeyBackfill(keys ...string) *keyBackfill {
+	return &keyBackfill{keys: keys, Mutex: &sync.Mutex{}}
+}
+
+func (b *keyBackfill) Chunk(ctx context.Context, db *sql.DB, lastKey string, size uint) (string, uint, error) {
+	b.Lock()
+	defer b.Unlock()
+	i := sort.SearchStrings(b.keys, lastKey)
+	if i < len(b.keys) && b.keys[i] == lastKey {
+		i++
+	}
+	n := uint(0)
+	for ; i < len(b.keys) && n < size; i++ {
+		b.migrated = append(b.migrated, b.keys[i])
+		lastKey = b.keys[i]
+		n++
+	}
+	return lastKey, n, nil
+}
+
+// //////////////////////////////////////////////////////////////////////////
+// Tests
+// //////////////////////////////////////////////////////////////////////////
+
+func TestRun(t *testing.T) {
+	dbName := setup(t, "../test/data/request-default.sql")
+	defer teardown(t, dbName)
+
+	b1 := newKeyBackfill("a", "b", "c", "d", "e")
+	b2 := newKeyBackfill() // nothing to migrate
+	m, err := backfill.NewManager(backfill.ManagerConfig{
+		Backfills: []backfill.Backfill{
+			{Name: "b1", Chunk: b1.Chunk},
+			{Name: "b2", Chunk: b2.Chunk},
+		},
+		DBConnector: dbc,
+		RMHost:      "rm1",
+		ChunkSize:   2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Before running, both are pending
+	list, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []proto.Backfill{
+		{Name: "b1", State: proto.BACKFILL_STATE_PENDING},
+		{Name: "b2", State: proto.BACKFILL_STATE_PENDING},
+	}
+	if diff := deep.Equal(list, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Run returns when all backfills are done
+	m.Run(make(chan struct{}))
+
+	if diff := deep.Equal(b1.migrated, []string{"a", "b", "c", "d", "e"}); diff != nil {
+		t.Error(diff)
+	}
+	got, err := m.Get("b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != proto.BACKFILL_STATE_DONE || got.Rows != 5 || got.LastKey != "e" || got.RMHost != "" {
+		t.Errorf("got %+v, expected done with 5 rows, last key e, no RM host", got)
+	}
+	if got.StartedAt == nil || got.FinishedAt == nil {
+		t.Errorf("started at %v, finished at %v, expected both set", got.StartedAt, got.FinishedAt)
+	}
+	got, err = m.Get("b2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != proto.BACKFILL_STATE_DONE || got.Rows != 0 {
+		t.Errorf("got %+v, expected done with 0 rows", got)
+	}
+
+	// Running again does nothing
+	b1.migrated = nil
+	m.Run(make(chan struct{}))
+	if b1.migrated != nil {
+		t.Errorf("migrated %v after done, expected nothing", b1.migrated)
+	}
+
+	_, err = m.Get("nope")
+	if _, ok := err.(serr.BackfillNotFound); !ok {
+		t.Errorf("got error %v, expected serr.BackfillNotFound", err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	dbName := setup(t, "../test/data/request-default.sql")
+	defer teardown(t, dbName)
+
+	// Another RM migrated a and b, then stopped without releasing its claim
+	_, err := dbc.Exec("INSERT INTO backfills (name, state, last_key, rows_done, rm_host, started_at, updated_at)" +
+		" VALUES ('b1', 'running', 'b', 2, 'rm2', NOW(6) - INTERVAL 1 HOUR, NOW(6) - INTERVAL 1 HOUR)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b1 := newKeyBackfill("a", "b", "c", "d")
+	m, err := backfill.NewManager(backfill.ManagerConfig{
+		Backfills:   []backfill.Backfill{{Name: "b1", Chunk: b1.Chunk}},
+		DBConnector: dbc,
+		RMHost:      "rm1",
+		ChunkSize:   10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Run(make(chan struct{}))
+
+	// Resumed after b because the claim timed out
+	if diff := deep.Equal(b1.migrated, []string{"c", "d"}); diff != nil {
+		t.Error(diff)
+	}
+	got, err := m.Get("b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != proto.BACKFILL_STATE_DONE || got.Rows != 4 {
+		t.Errorf("got %+v, expected done with 4 rows", got)
+	}
+}
+
+func TestClaimed(t *testing.T) {
+	dbName := setup(t, "../test/data/request-default.sql")
+	defer teardown(t, dbName)
+
+	// Another RM is running it
+	_, err := dbc.Exec("INSERT INTO backfills (name, state, last_key, rows_done, rm_host, started_at, updated_at)" +
+		" VALUES ('b1', 'running', 'b', 2, 'rm2', NOW(6), NOW(6))")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b1 := newKeyBackfill("a", "b", "c", "d")
+	m, err := backfill.NewManager(backfill.ManagerConfig{
+		Backfills:   []backfill.Backfill{{Name: "b1", Chunk: b1.Chunk}},
+		DBConnector: dbc,
+		RMHost:      "rm1",
+		ChunkSize:   10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Run waits for the other RM until stopped
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+	go func() {
+		m.Run(stopChan)
+		close(doneChan)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(stopChan)
+	select {
+	case <-doneChan:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after stop")
+	}
+
+	b1.Lock()
+	migrated := b1.migrated
+	b1.Unlock()
+	if migrated != nil {
+		t.Errorf("migrated %v, expected nothing", migrated)
+	}
+	got, err := m.Get("b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != proto.BACKFILL_STATE_RUNNING || got.RMHost != "rm2" || got.Rows != 2 {
+		t.Errorf("got %+v, expected running on rm2 with 2 rows", got)
+	}
+}
+
+func TestNewManager(t *testing.T) {
+	chunk := newKeyBackfill().Chunk
+	invalid := [][]backfill.Backfill{
+		{{Name: "", Chunk: chunk}},
+		{{Name: "b1"}},
+		{{Name: "b1", Chunk: chunk}, {Name: "b1", Chunk: chunk}},
+	}
+	for _, backfills := range invalid {
+		if _, err := backfill.NewManager(backfill.ManagerConfig{Backfills: backfills}); err == nil {
+			t.Errorf("no error for %+v, expected one", backfills)
+		}
+	}
+}
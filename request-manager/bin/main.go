@@ -1,19 +1,101 @@
-// Copyright 2017-2018, Square, Inc.
+// Copyright 2017-2020, Square, Inc.
 
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"os"
 
+	"github.com/square/spincycle/v2/daemon"
+	"github.com/square/spincycle/v2/request-manager/api"
 	"github.com/square/spincycle/v2/request-manager/app"
 	"github.com/square/spincycle/v2/request-manager/server"
+	"github.com/square/spincycle/v2/version"
 )
 
 func main() {
-	s := server.NewServer(app.Defaults())
-	if err := s.Boot(); err != nil {
-		log.Fatalf("Error starting Request Manager: %s", err)
-	}
-	err := s.Run(true)
-	log.Fatalf("Request Manager stopped: %s", err)
+	daemon.Main("request-manager", []daemon.Command{
+		{
+			Name: "run",
+			Args: "[config file]",
+			Desc: "Run the Request Manager",
+			Run: func(args []string) error {
+				s := server.NewServer(app.Defaults())
+				if err := s.Boot(); err != nil {
+					return fmt.Errorf("Error starting Request Manager: %s", err)
+				}
+				err := s.Run(true)
+				return fmt.Errorf("Request Manager stopped: %s", err)
+			},
+		},
+		{
+			Name: "validate-config",
+			Args: "[config file]",
+			Desc: "Validate config, check that addresses are reachable, and exit",
+			Run: func(args []string) error {
+				s := server.NewServer(app.Defaults())
+				if err := s.Validate(); err != nil {
+					return fmt.Errorf("Request Manager config is not valid: %s", err)
+				}
+				log.Printf("Request Manager config is valid")
+				return nil
+			},
+		},
+		{
+			Name: "validate-specs",
+			Args: "[config file]",
+			Desc: "Check the request specs in specs.dir like on startup, and exit",
+			Run: func(args []string) error {
+				s := server.NewServer(app.Defaults())
+				specs, err := s.ValidateSpecs()
+				if err != nil {
+					return fmt.Errorf("Request specs are not valid: %s", err)
+				}
+				log.Printf("Request specs are valid: %d sequences", len(specs.Sequences))
+				return nil
+			},
+		},
+		{
+			Name: "migrate",
+			Args: "[--dir dir] [--baseline version] [config file]",
+			Desc: "Apply schema migrations not applied to the db yet, and exit",
+			Run: func(args []string) error {
+				fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+				dir := fs.String("dir", "resources/migrations", "Migrations directory")
+				baseline := fs.String("baseline", "", "Last migration applied to a db without migration history")
+				if err := fs.Parse(args); err != nil {
+					return err
+				}
+				os.Args = append(os.Args[:1], fs.Args()...) // config file
+				s := server.NewServer(app.Defaults())
+				applied, err := s.Migrate(*dir, *baseline)
+				for _, v := range applied {
+					log.Printf("Applied migration %s", v)
+				}
+				if err != nil {
+					return err
+				}
+				log.Printf("Schema is up to date: %d migrations applied", len(applied))
+				return nil
+			},
+		},
+		{
+			Name: "dump-routes",
+			Desc: "Print API routes, and exit",
+			Run: func(args []string) error {
+				daemon.PrintRoutes(os.Stdout, api.NewAPI(app.Defaults()).Router().Routes())
+				return nil
+			},
+		},
+		{
+			Name: "version",
+			Desc: "Print version, and exit",
+			Run: func(args []string) error {
+				fmt.Println("request-manager " + version.Version())
+				return nil
+			},
+		},
+	})
 }
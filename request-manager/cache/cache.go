@@ -0,0 +1,365 @@
+// Copyright 2020, Square, Inc.
+
+// Package cache provides a short-TTL in-memory cache of request status and find
+// queries for the API. It absorbs polling by dashboards and scripts: the same
+// query made many times per TTL hits MySQL (and Job Runners for running status)
+// once.
+//
+// The cache is used by wrapping the request, status, and resumer managers given
+// to the API with NewRequestManager, NewStatusManager, and NewResumer. Changes
+// made through them invalidate cached results: a progress update invalidates the
+// cached status of the request and cached queries that returned it, and a
+// request state change also invalidates all cached find queries because the
+// request might match other filters now. Changes made elsewhere, like requests
+// recovered from a dead Job Runner, are seen when cached results expire.
+package cache
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/request"
+	"github.com/square/spincycle/v2/request-manager/status"
+)
+
+// Cache caches request status and query results for the TTL. It holds up to
+// max entries: when full, expired entries are removed, and new results are not
+// cached until there's room. Cached results are shared, so callers must not
+// modify them. It's safe for concurrent use.
+type Cache struct {
+	ttl        time.Duration
+	maxEntries int
+	mux        *sync.Mutex
+	requests   map[string]requestEntry // request ID => status
+	queries    map[string]queryEntry   // query key => result
+}
+
+type requestEntry struct {
+	req     proto.Request
+	expires time.Time
+}
+
+type queryEntry struct {
+	val     interface{}
+	ids     map[string]bool // request IDs in val
+	find    bool            // find query, invalidated by any state change
+	expires time.Time
+}
+
+// NewCache makes an empty cache with the TTL of cached results and max number
+// of entries (requests and queries). If max entries is zero, there's no max.
+func NewCache(ttl time.Duration, maxEntries uint) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: int(maxEntries),
+		mux:        &sync.Mutex{},
+		requests:   map[string]requestEntry{},
+		queries:    map[string]queryEntry{},
+	}
+}
+
+// Invalidate removes the cached status of the request and cached queries that
+// returned it. It's called when the progress of the request changes.
+func (c *Cache) Invalidate(requestId string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.requests, requestId)
+	for key, e := range c.queries {
+		if e.ids[requestId] {
+			delete(c.queries, key)
+		}
+	}
+}
+
+// StateChanged invalidates the request like Invalidate and removes all cached
+// find queries. It's called when a request is created or its state changes.
+func (c *Cache) StateChanged(requestId string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.requests, requestId)
+	for key, e := range c.queries {
+		if e.find || e.ids[requestId] {
+			delete(c.queries, key)
+		}
+	}
+}
+
+// Len returns the number of cached requests and queries, including expired ones.
+func (c *Cache) Len() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.requests) + len(c.queries)
+}
+
+func (c *Cache) getRequest(requestId string) (proto.Request, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	e, ok := c.requests[requestId]
+	if !ok || time.Now().After(e.expires) {
+		return proto.Request{}, false
+	}
+	return e.req, true
+}
+
+func (c *Cache) setRequests(requests []proto.Request) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	expires := time.Now().Add(c.ttl)
+	for _, r := range requests {
+		if !c.room() {
+			return
+		}
+		c.requests[r.Id] = requestEntry{req: r, expires: expires}
+	}
+}
+
+func (c *Cache) getQuery(key string) (interface{}, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	e, ok := c.queries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.val, true
+}
+
+func (c *Cache) setQuery(key string, val interface{}, ids []string, find bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if !c.room() {
+		return
+	}
+	e := queryEntry{
+		val:     val,
+		ids:     make(map[string]bool, len(ids)),
+		find:    find,
+		expires: time.Now().Add(c.ttl),
+	}
+	for _, id := range ids {
+		e.ids[id] = true
+	}
+	c.queries[key] = e
+}
+
+// room returns true if there's room for another entry, removing expired entries
+// if the cache is full. The caller must lock c.mux.
+func (c *Cache) room() bool {
+	if c.maxEntries == 0 || len(c.requests)+len(c.queries) < c.maxEntries {
+		return true
+	}
+	now := time.Now()
+	for id, e := range c.requests {
+		if now.After(e.expires) {
+			delete(c.requests, id)
+		}
+	}
+	for key, e := range c.queries {
+		if now.After(e.expires) {
+			delete(c.queries, key)
+		}
+	}
+	return len(c.requests)+len(c.queries) < c.maxEntries
+}
+
+// key returns the cache key of the query: its name and JSON-encoded filter,
+// which is deterministic because map keys are sorted.
+func key(query string, filter interface{}) (string, bool) {
+	bytes, err := json.Marshal(filter)
+	if err != nil {
+		return "", false // not cached
+	}
+	return query + ":" + string(bytes), true
+}
+
+// --------------------------------------------------------------------------
+
+// NewRequestManager returns a request.Manager that caches Find and invalidates
+// the cache on request changes. All other calls are passed to rm.
+func NewRequestManager(rm request.Manager, c *Cache) request.Manager {
+	return &requestManager{
+		Manager: rm,
+		c:       c,
+	}
+}
+
+type requestManager struct {
+	request.Manager
+	c *Cache
+}
+
+func (m *requestManager) Find(filter proto.RequestFilter) ([]proto.Request, error) {
+	k, ok := key("find", filter)
+	if ok {
+		if v, ok := m.c.getQuery(k); ok {
+			return v.([]proto.Request), nil
+		}
+	}
+	requests, err := m.Manager.Find(filter)
+	if err != nil || !ok {
+		return requests, err
+	}
+	ids := make([]string, len(requests))
+	for i, r := range requests {
+		ids[i] = r.Id
+	}
+	m.c.setQuery(k, requests, ids, true)
+	return requests, nil
+}
+
+func (m *requestManager) Create(newReq proto.CreateRequest) (proto.Request, error) {
+	req, err := m.Manager.Create(newReq)
+	if err == nil {
+		m.c.StateChanged(req.Id)
+	}
+	return req, err
+}
+
+func (m *requestManager) Rerun(rr proto.RerunRequest) (proto.Request, error) {
+	req, err := m.Manager.Rerun(rr)
+	if err == nil {
+		m.c.StateChanged(req.Id)
+	}
+	return req, err
+}
+
+func (m *requestManager) CreateFromChain(cr proto.CreateRequestFromChain) (proto.Request, error) {
+	req, err := m.Manager.CreateFromChain(cr)
+	if err == nil {
+		m.c.StateChanged(req.Id)
+	}
+	return req, err
+}
+
+// State changes are invalidated even on error because the request state might
+// have changed before the error, like a request that failed to start.
+
+func (m *requestManager) Start(requestId string) error {
+	defer m.c.StateChanged(requestId)
+	return m.Manager.Start(requestId)
+}
+
+func (m *requestManager) Stop(requestId string) error {
+	defer m.c.StateChanged(requestId)
+	return m.Manager.Stop(requestId)
+}
+
+func (m *requestManager) Finish(requestId string, finishParams proto.FinishRequest) error {
+	defer m.c.StateChanged(requestId)
+	return m.Manager.Finish(requestId, finishParams)
+}
+
+func (m *requestManager) FailPending(requestId string) error {
+	defer m.c.StateChanged(requestId)
+	return m.Manager.FailPending(requestId)
+}
+
+func (m *requestManager) AddJob(requestId string, aj proto.AddJob) (proto.Job, error) {
+	defer m.c.Invalidate(requestId) // total jobs
+	return m.Manager.AddJob(requestId, aj)
+}
+
+// --------------------------------------------------------------------------
+
+// NewStatusManager returns a status.Manager that caches Running and Requests,
+// and invalidates the cache on progress updates. Running status as of a past
+// time is cached like current running status.
+func NewStatusManager(sm status.Manager, c *Cache) status.Manager {
+	return &statusManager{
+		sm: sm,
+		c:  c,
+	}
+}
+
+type statusManager struct {
+	sm status.Manager
+	c  *Cache
+}
+
+func (m *statusManager) Running(f proto.StatusFilter) (proto.RunningStatus, error) {
+	k, ok := key("running", f)
+	if ok {
+		if v, ok := m.c.getQuery(k); ok {
+			return v.(proto.RunningStatus), nil
+		}
+	}
+	running, err := m.sm.Running(f)
+	if err != nil || !ok {
+		return running, err
+	}
+	ids := make([]string, 0, len(running.Requests))
+	for id := range running.Requests {
+		ids = append(ids, id)
+	}
+	m.c.setQuery(k, running, ids, false)
+	return running, nil
+}
+
+func (m *statusManager) UpdateProgress(prg proto.RequestProgress) error {
+	defer m.c.Invalidate(prg.RequestId)
+	return m.sm.UpdateProgress(prg)
+}
+
+// Requests returns the requests from the cache if all are cached, else it gets
+// all from the status manager in one call and caches them. Requests not found
+// are not cached because they might be created.
+func (m *statusManager) Requests(requestIds []string) (proto.RequestsStatus, error) {
+	cached := map[string]proto.Request{}
+	missing := []string{}
+	for _, id := range requestIds {
+		if _, ok := cached[id]; ok {
+			continue
+		}
+		if r, ok := m.c.getRequest(id); ok {
+			cached[id] = r
+			continue
+		}
+		missing = append(missing, id)
+	}
+	if len(missing) == 0 && len(requestIds) > 0 {
+		status := proto.RequestsStatus{Requests: []proto.Request{}}
+		seen := map[string]bool{}
+		for _, id := range requestIds {
+			if !seen[id] {
+				seen[id] = true
+				status.Requests = append(status.Requests, cached[id])
+			}
+		}
+		return status, nil
+	}
+	// Some requests not cached: get all from the status manager, which returns
+	// them in order, and cache them
+	status, err := m.sm.Requests(requestIds)
+	if err != nil {
+		return status, err
+	}
+	m.c.setRequests(status.Requests)
+	return status, nil
+}
+
+// --------------------------------------------------------------------------
+
+// NewResumer returns a request.Resumer that invalidates the cache when a request
+// is suspended or resumed. All calls are passed to rr.
+func NewResumer(rr request.Resumer, c *Cache) request.Resumer {
+	return &resumer{
+		Resumer: rr,
+		c:       c,
+	}
+}
+
+type resumer struct {
+	request.Resumer
+	c *Cache
+}
+
+func (r *resumer) Suspend(sjc proto.SuspendedJobChain) error {
+	defer r.c.StateChanged(sjc.RequestId)
+	return r.Resumer.Suspend(sjc)
+}
+
+func (r *resumer) Resume(requestId string) error {
+	defer r.c.StateChanged(requestId)
+	return r.Resumer.Resume(requestId)
+}
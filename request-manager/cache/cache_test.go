@@ -0,0 +1,196 @@
+// Copyright 2020, Square, Inc.
+
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/cache"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+func TestFind(t *testing.T) {
+	calls := 0
+	rm := &mock.RequestManager{
+		FindFunc: func(f proto.RequestFilter) ([]proto.Request, error) {
+			calls++
+			return []proto.Request{{Id: "req1", Type: f.Type}}, nil
+		},
+	}
+	c := cache.NewCache(time.Hour, 0)
+	m := cache.NewRequestManager(rm, c)
+
+	// Same filter is cached, different filter is not
+	f := proto.RequestFilter{Type: "a", Args: map[string]string{"x": "1", "y": "2"}}
+	for i := 0; i < 3; i++ {
+		got, err := m.Find(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(got, []proto.Request{{Id: "req1", Type: "a"}}); diff != nil {
+			t.Error(diff)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Find called %d times, expected 1", calls)
+	}
+	m.Find(proto.RequestFilter{Type: "b"})
+	if calls != 2 {
+		t.Errorf("Find called %d times, expected 2", calls)
+	}
+
+	// Progress of a request in the results invalidates them
+	c.Invalidate("req1")
+	m.Find(f)
+	if calls != 3 {
+		t.Errorf("Find called %d times after invalidate, expected 3", calls)
+	}
+
+	// Progress of another request does not, but its state change does because
+	// it might match the filter now
+	c.Invalidate("req2")
+	m.Find(f)
+	if calls != 3 {
+		t.Errorf("Find called %d times after invalidating other request, expected 3", calls)
+	}
+	if err := m.Start("req2"); err != nil {
+		t.Fatal(err)
+	}
+	m.Find(f)
+	if calls != 4 {
+		t.Errorf("Find called %d times after state change, expected 4", calls)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	calls := 0
+	rm := &mock.RequestManager{
+		FindFunc: func(f proto.RequestFilter) ([]proto.Request, error) {
+			calls++
+			return []proto.Request{}, nil
+		},
+	}
+	c := cache.NewCache(20*time.Millisecond, 1)
+	m := cache.NewRequestManager(rm, c)
+	m.Find(proto.RequestFilter{Type: "a"})
+	m.Find(proto.RequestFilter{Type: "a"})
+	if calls != 1 {
+		t.Errorf("Find called %d times, expected 1", calls)
+	}
+
+	// Cache is full (max 1 entry): other filter is not cached
+	m.Find(proto.RequestFilter{Type: "b"})
+	m.Find(proto.RequestFilter{Type: "b"})
+	if calls != 3 {
+		t.Errorf("Find called %d times, expected 3", calls)
+	}
+	if n := c.Len(); n != 1 {
+		t.Errorf("cache has %d entries, expected 1", n)
+	}
+
+	// After the TTL, the expired entry is removed to make room
+	time.Sleep(30 * time.Millisecond)
+	m.Find(proto.RequestFilter{Type: "b"})
+	m.Find(proto.RequestFilter{Type: "b"})
+	if calls != 4 {
+		t.Errorf("Find called %d times after TTL, expected 4", calls)
+	}
+}
+
+func TestRequests(t *testing.T) {
+	var gotIds []string
+	sm := &mock.RMStatus{
+		RequestsFunc: func(ids []string) (proto.RequestsStatus, error) {
+			gotIds = ids
+			status := proto.RequestsStatus{Requests: []proto.Request{}}
+			for _, id := range ids {
+				if id == "nope" {
+					status.NotFound = append(status.NotFound, id)
+					continue
+				}
+				status.Requests = append(status.Requests, proto.Request{Id: id, FinishedJobs: 1})
+			}
+			return status, nil
+		},
+	}
+	c := cache.NewCache(time.Hour, 0)
+	m := cache.NewStatusManager(sm, c)
+
+	if _, err := m.Requests([]string{"req1", "req2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// All cached: status manager not called, requests in order given, once
+	gotIds = nil
+	status, err := m.Requests([]string{"req2", "req1", "req2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotIds != nil {
+		t.Errorf("status manager called with %v, expected no call", gotIds)
+	}
+	expect := proto.RequestsStatus{Requests: []proto.Request{{Id: "req2", FinishedJobs: 1}, {Id: "req1", FinishedJobs: 1}}}
+	if diff := deep.Equal(status, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Not found requests are not cached
+	m.Requests([]string{"nope"})
+	gotIds = nil
+	status, err = m.Requests([]string{"req1", "nope"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(gotIds, []string{"req1", "nope"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(status.NotFound, []string{"nope"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Progress update invalidates the request
+	if err := m.UpdateProgress(proto.RequestProgress{RequestId: "req1", FinishedJobs: 2}); err != nil {
+		t.Fatal(err)
+	}
+	gotIds = nil
+	m.Requests([]string{"req1"})
+	if diff := deep.Equal(gotIds, []string{"req1"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRunning(t *testing.T) {
+	calls := 0
+	sm := &mock.RMStatus{
+		RunningFunc: func(f proto.StatusFilter) (proto.RunningStatus, error) {
+			calls++
+			return proto.RunningStatus{
+				Jobs:     []proto.JobStatus{{RequestId: "req1", JobId: "job1"}},
+				Requests: map[string]proto.Request{"req1": {Id: "req1"}},
+			}, nil
+		},
+	}
+	rr := &mock.RequestResumer{}
+	c := cache.NewCache(time.Hour, 0)
+	m := cache.NewStatusManager(sm, c)
+	r := cache.NewResumer(rr, c)
+
+	m.Running(proto.StatusFilter{})
+	m.Running(proto.StatusFilter{})
+	if calls != 1 {
+		t.Errorf("Running called %d times, expected 1", calls)
+	}
+
+	// Suspending a running request invalidates running status
+	if err := r.Suspend(proto.SuspendedJobChain{RequestId: "req1"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Running(proto.StatusFilter{})
+	if calls != 2 {
+		t.Errorf("Running called %d times after suspend, expected 2", calls)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 
 	"github.com/square/spincycle/v2/proto"
 )
@@ -19,9 +20,36 @@ type Client interface {
 	// and returns the request's id.
 	CreateRequest(string, map[string]interface{}) (string, error)
 
+	// CreateRequestWith creates a request with the options in the CreateRequest,
+	// like LogLevel, and returns the request's id.
+	CreateRequestWith(proto.CreateRequest) (string, error)
+
+	// CreateRequestFromChain creates and starts a request that runs the job
+	// chain, and returns the request's id. Only admins can call it.
+	CreateRequestFromChain(proto.CreateRequestFromChain) (string, error)
+
 	// GetRequest takes a request id and returns the corresponding request.
 	GetRequest(string) (proto.Request, error)
 
+	// GetRequests returns the status of many requests in one call: the requests
+	// in the order given, without job chains, and the IDs not found.
+	GetRequests([]string) (proto.RequestsStatus, error)
+
+	// RerunRequest takes a request id and job id, creates and starts a new
+	// request that reruns the job and every job downstream of it, and returns
+	// the new request's id.
+	RerunRequest(string, string) (string, error)
+
+	// CreateGroup creates and starts a request group, and returns the group.
+	CreateGroup(proto.CreateRequestGroup) (proto.RequestGroup, error)
+
+	// GetGroup takes a request group id and returns the group with its requests.
+	GetGroup(string) (proto.RequestGroup, error)
+
+	// StopGroup takes a request group id and stops the group and its requests.
+	// If the group is not running, it returns an error.
+	StopGroup(string) error
+
 	// FindRequests takes a request filter and returns a list of requests
 	// matching the filter conditions, in descending order by create time
 	// (i.e. most recent first).
@@ -38,6 +66,20 @@ type Client interface {
 	// If the request is not running, it returns an error.
 	StopRequest(string) error
 
+	// PauseRequest takes a request id and pauses the corresponding request: the
+	// Job Runner doesn't start new jobs until ResumeRequest is called. If the
+	// request is not running, it returns an error.
+	PauseRequest(string) error
+
+	// ResumeRequest takes a request id and resumes the corresponding paused
+	// request.
+	ResumeRequest(string) error
+
+	// ApproveJob takes a request id and job id and approves the corresponding
+	// gate job waiting for approval, so the request continues. If the job is
+	// not waiting for approval, it returns an error.
+	ApproveJob(string, string) error
+
 	// SuspendRequest takes a request id and a SuspendedJobChain and suspends the
 	// corresponding request. It marks the request's state as suspended and saves
 	// the SuspendedJobChain.
@@ -46,12 +88,44 @@ type Client interface {
 	// GetJobChain gets the job chain for a given request id.
 	GetJobChain(string) (proto.JobChain, error)
 
+	// GetArgsDiff gets the submitted, final request, and resolved job args
+	// for a given request id.
+	GetArgsDiff(string) (proto.RequestArgsDiff, error)
+
+	// GetReport gets a self-contained report of a finished request in the
+	// given format: "html" or "markdown".
+	GetReport(requestId, format string) ([]byte, error)
+
+	// GetTimeline gets when each job in a request ran.
+	GetTimeline(requestId string) (proto.RequestTimeline, error)
+
+	// GetStopPreview gets what stopping a running request would interrupt,
+	// without stopping it.
+	GetStopPreview(requestId string) (proto.StopPreview, error)
+
+	// GetJobSnapshot gets a job of a request as it was run, to run it again.
+	GetJobSnapshot(requestId, jobId string) (proto.JobSnapshot, error)
+
 	// GetJL gets the job log of the given request ID.
 	GetJL(string) ([]proto.JobLog, error)
 
 	// CreateJL creates a JL for a given request id.
 	CreateJL(string, proto.JobLog) error
 
+	// CaptureProfile asks the Job Runner running the request to capture
+	// profiles and attach them to the request (admins only).
+	CaptureProfile(string, proto.ProfileCapture) error
+
+	// AttachProfile attaches a profile captured by a Job Runner to the request.
+	AttachProfile(string, proto.Profile) error
+
+	// GetProfiles gets the profiles attached to a request, without their data.
+	GetProfiles(string) ([]proto.Profile, error)
+
+	// GetProfile gets the data of a profile attached to a request: a pprof
+	// profile for go tool pprof.
+	GetProfile(requestId, profileId string) ([]byte, error)
+
 	// RequestList returns a list of possible requests.
 	RequestList() ([]proto.RequestSpec, error)
 
@@ -60,6 +134,56 @@ type Client interface {
 
 	// UpdateProgress updates request progress from Job Runner.
 	UpdateProgress(proto.RequestProgress) error
+
+	// UpdateProgressBatch updates the progress of many requests in one call.
+	UpdateProgressBatch([]proto.RequestProgress) error
+
+	// CreateToken creates an API token for the caller. The returned token
+	// has the secret, which cannot be retrieved again.
+	CreateToken(proto.CreateToken) (proto.Token, error)
+
+	// ListTokens returns the caller's API tokens, without secrets.
+	ListTokens() ([]proto.Token, error)
+
+	// RevokeToken revokes the API token with the given id.
+	RevokeToken(string) error
+
+	// ListPresets returns all request presets.
+	ListPresets() ([]proto.RequestPreset, error)
+
+	// GetPreset returns the request preset with the given name.
+	GetPreset(string) (proto.RequestPreset, error)
+
+	// Heartbeat registers the Job Runner or updates its registration.
+	Heartbeat(proto.JobRunner) error
+
+	// Deregister deregisters the Job Runner with the given base URL.
+	Deregister(string) error
+
+	// AcquireLock acquires a singleton job lock and returns the lock holder:
+	// the job if acquired, else the request and job holding the lock.
+	AcquireLock(proto.SingletonLock) (proto.SingletonLock, error)
+
+	// ReleaseLock releases a singleton job lock held by the job.
+	ReleaseLock(proto.SingletonLock) error
+}
+
+// APIError is returned when the API responds with an error status other than
+// 404, which returns the proto.Error as-is. Status 5xx means the RM or its db
+// failed, so the call can be retried later; other statuses mean the call is
+// invalid.
+type APIError struct {
+	HTTPStatus int
+	Message    string // from the response body, empty if none
+}
+
+func (e APIError) Error() string {
+	if e.Message == "" {
+		// If there's no response body, then the API probably crashed and
+		// the status code is probably 500
+		return fmt.Sprintf("no response from API, check logs (HTTP status %d)", e.HTTPStatus)
+	}
+	return fmt.Sprintf("API error: %s (HTTP status %d)", e.Message, e.HTTPStatus)
 }
 
 type client struct {
@@ -93,6 +217,46 @@ func (c *client) CreateRequest(reqType string, args map[string]interface{}) (str
 	return req.Id, nil
 }
 
+func (c *client) CreateRequestWith(cr proto.CreateRequest) (string, error) {
+	// POST /api/v1/requests
+	url := c.baseUrl + "/api/v1/requests"
+
+	var req proto.Request
+	if err := c.makeRequest("POST", url, cr, &req); err != nil {
+		return "", err
+	}
+
+	return req.Id, nil
+}
+
+func (c *client) CreateRequestFromChain(cr proto.CreateRequestFromChain) (string, error) {
+	// POST /api/v1/requests/job-chain
+	url := c.baseUrl + "/api/v1/requests/job-chain"
+
+	var req proto.Request
+	if err := c.makeRequest("POST", url, cr, &req); err != nil {
+		return "", err
+	}
+
+	return req.Id, nil
+}
+
+func (c *client) RerunRequest(requestId, jobId string) (string, error) {
+	// POST /api/v1/requests/${requestId}/rerun
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/rerun"
+
+	rr := proto.RerunRequest{
+		JobId: jobId,
+	}
+
+	var req proto.Request
+	if err := c.makeRequest("POST", url, rr, &req); err != nil {
+		return "", err
+	}
+
+	return req.Id, nil
+}
+
 func (c *client) GetRequest(requestId string) (proto.Request, error) {
 	// GET /api/v1/requests/${requestId}
 	url := c.baseUrl + "/api/v1/requests/" + requestId
@@ -102,6 +266,40 @@ func (c *client) GetRequest(requestId string) (proto.Request, error) {
 	return req, err
 }
 
+func (c *client) GetRequests(requestIds []string) (proto.RequestsStatus, error) {
+	// POST /api/v1/requests/status
+	url := c.baseUrl + "/api/v1/requests/status"
+
+	var status proto.RequestsStatus
+	err := c.makeRequest("POST", url, requestIds, &status)
+	return status, err
+}
+
+func (c *client) CreateGroup(cg proto.CreateRequestGroup) (proto.RequestGroup, error) {
+	// POST /api/v1/request-groups
+	url := c.baseUrl + "/api/v1/request-groups"
+
+	var g proto.RequestGroup
+	err := c.makeRequest("POST", url, cg, &g)
+	return g, err
+}
+
+func (c *client) GetGroup(groupId string) (proto.RequestGroup, error) {
+	// GET /api/v1/request-groups/${groupId}
+	url := c.baseUrl + "/api/v1/request-groups/" + groupId
+
+	var g proto.RequestGroup
+	err := c.makeRequest("GET", url, nil, &g)
+	return g, err
+}
+
+func (c *client) StopGroup(groupId string) error {
+	// PUT /api/v1/request-groups/${groupId}/stop
+	url := c.baseUrl + "/api/v1/request-groups/" + groupId + "/stop"
+
+	return c.makeRequest("PUT", url, nil, nil)
+}
+
 func (c *client) FindRequests(filter proto.RequestFilter) ([]proto.Request, error) {
 	// GET /api/v1/requests
 	url := c.baseUrl + "/api/v1/requests?" + filter.String()
@@ -140,6 +338,27 @@ func (c *client) StopRequest(requestId string) error {
 	return c.makeRequest("PUT", url, nil, nil)
 }
 
+func (c *client) PauseRequest(requestId string) error {
+	// PUT /api/v1/requests/${requestId}/pause
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/pause"
+
+	return c.makeRequest("PUT", url, nil, nil)
+}
+
+func (c *client) ResumeRequest(requestId string) error {
+	// PUT /api/v1/requests/${requestId}/resume
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/resume"
+
+	return c.makeRequest("PUT", url, nil, nil)
+}
+
+func (c *client) ApproveJob(requestId, jobId string) error {
+	// PUT /api/v1/requests/${requestId}/jobs/${jobId}/approve
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/jobs/" + jobId + "/approve"
+
+	return c.makeRequest("PUT", url, nil, nil)
+}
+
 func (c *client) SuspendRequest(requestId string, sjc proto.SuspendedJobChain) error {
 	// PUT /api/v1/requests/${requestId}/suspend
 	url := c.baseUrl + "/api/v1/requests/" + requestId + "/suspend"
@@ -156,6 +375,51 @@ func (c *client) GetJobChain(requestId string) (proto.JobChain, error) {
 	return jc, err
 }
 
+func (c *client) GetArgsDiff(requestId string) (proto.RequestArgsDiff, error) {
+	// GET /api/v1/requests/${requestId}/args
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/args"
+
+	var argsDiff proto.RequestArgsDiff
+	err := c.makeRequest("GET", url, nil, &argsDiff)
+	return argsDiff, err
+}
+
+func (c *client) GetJobSnapshot(requestId, jobId string) (proto.JobSnapshot, error) {
+	// GET /api/v1/requests/${requestId}/jobs/${jobId}/snapshot
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/jobs/" + jobId + "/snapshot"
+
+	var snap proto.JobSnapshot
+	err := c.makeRequest("GET", url, nil, &snap)
+	return snap, err
+}
+
+func (c *client) GetTimeline(requestId string) (proto.RequestTimeline, error) {
+	// GET /api/v1/requests/${requestId}/timeline
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/timeline"
+
+	var tl proto.RequestTimeline
+	err := c.makeRequest("GET", url, nil, &tl)
+	return tl, err
+}
+
+func (c *client) GetStopPreview(requestId string) (proto.StopPreview, error) {
+	// GET /api/v1/requests/${requestId}/stop-preview
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/stop-preview"
+
+	var sp proto.StopPreview
+	err := c.makeRequest("GET", url, nil, &sp)
+	return sp, err
+}
+
+func (c *client) GetReport(requestId, format string) ([]byte, error) {
+	// GET /api/v1/requests/${requestId}/report?format=${format}
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/report?format=" + url.QueryEscape(format)
+
+	var report []byte
+	err := c.makeRequest("GET", url, nil, &report)
+	return report, err
+}
+
 func (c *client) GetJL(requestId string) ([]proto.JobLog, error) {
 	// GET /api/v1/requests/${requestId}/log
 	url := c.baseUrl + "/api/v1/requests/" + requestId + "/log"
@@ -172,6 +436,38 @@ func (c *client) CreateJL(requestId string, jl proto.JobLog) error {
 	return c.makeRequest("POST", url, jl, nil)
 }
 
+func (c *client) CaptureProfile(requestId string, pc proto.ProfileCapture) error {
+	// POST /api/v1/requests/${requestId}/profiles/capture
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/profiles/capture"
+
+	return c.makeRequest("POST", url, pc, nil)
+}
+
+func (c *client) AttachProfile(requestId string, p proto.Profile) error {
+	// POST /api/v1/requests/${requestId}/profiles
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/profiles"
+
+	return c.makeRequest("POST", url, p, nil)
+}
+
+func (c *client) GetProfiles(requestId string) ([]proto.Profile, error) {
+	// GET /api/v1/requests/${requestId}/profiles
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/profiles"
+
+	var profiles []proto.Profile
+	err := c.makeRequest("GET", url, nil, &profiles)
+	return profiles, err
+}
+
+func (c *client) GetProfile(requestId, profileId string) ([]byte, error) {
+	// GET /api/v1/requests/${requestId}/profiles/${profileId}
+	url := c.baseUrl + "/api/v1/requests/" + requestId + "/profiles/" + profileId
+
+	var data []byte
+	err := c.makeRequest("GET", url, nil, &data)
+	return data, err
+}
+
 func (c *client) RequestList() ([]proto.RequestSpec, error) {
 	// GET /api/v1/requests
 	url := c.baseUrl + "/api/v1/request-list"
@@ -194,6 +490,79 @@ func (c *client) UpdateProgress(prg proto.RequestProgress) error {
 	return c.makeRequest("PUT", url, prg, nil)
 }
 
+func (c *client) UpdateProgressBatch(batch []proto.RequestProgress) error {
+	// PUT /api/v1/progress
+	url := c.baseUrl + "/api/v1/progress"
+	return c.makeRequest("PUT", url, batch, nil)
+}
+
+func (c *client) CreateToken(ct proto.CreateToken) (proto.Token, error) {
+	// POST /api/v1/tokens
+	url := c.baseUrl + "/api/v1/tokens"
+	var tok proto.Token
+	err := c.makeRequest("POST", url, ct, &tok)
+	return tok, err
+}
+
+func (c *client) ListTokens() ([]proto.Token, error) {
+	// GET /api/v1/tokens
+	url := c.baseUrl + "/api/v1/tokens"
+	var tokens []proto.Token
+	err := c.makeRequest("GET", url, nil, &tokens)
+	return tokens, err
+}
+
+func (c *client) RevokeToken(tokenId string) error {
+	// DELETE /api/v1/tokens/${tokenId}
+	url := c.baseUrl + "/api/v1/tokens/" + tokenId
+	return c.makeRequest("DELETE", url, nil, nil)
+}
+
+func (c *client) ListPresets() ([]proto.RequestPreset, error) {
+	// GET /api/v1/presets
+	url := c.baseUrl + "/api/v1/presets"
+	var presets []proto.RequestPreset
+	err := c.makeRequest("GET", url, nil, &presets)
+	return presets, err
+}
+
+func (c *client) GetPreset(name string) (proto.RequestPreset, error) {
+	// GET /api/v1/presets/${name}
+	url := c.baseUrl + "/api/v1/presets/" + name
+	var p proto.RequestPreset
+	err := c.makeRequest("GET", url, nil, &p)
+	return p, err
+}
+
+func (c *client) Heartbeat(jr proto.JobRunner) error {
+	// PUT /api/v1/job-runners
+	url := c.baseUrl + "/api/v1/job-runners"
+	return c.makeRequest("PUT", url, jr, nil)
+}
+
+func (c *client) Deregister(jrURL string) error {
+	// DELETE /api/v1/job-runners?url=${jrURL}
+	url := c.baseUrl + "/api/v1/job-runners?url=" + url.QueryEscape(jrURL)
+	return c.makeRequest("DELETE", url, nil, nil)
+}
+
+func (c *client) AcquireLock(l proto.SingletonLock) (proto.SingletonLock, error) {
+	// POST /api/v1/singleton-locks
+	url := c.baseUrl + "/api/v1/singleton-locks"
+	var holder proto.SingletonLock
+	err := c.makeRequest("POST", url, l, &holder)
+	return holder, err
+}
+
+func (c *client) ReleaseLock(l proto.SingletonLock) error {
+	// DELETE /api/v1/singleton-locks?name=${name}&requestId=${requestId}&jobId=${jobId}
+	q := url.Values{}
+	q.Set("name", l.Name)
+	q.Set("requestId", l.RequestId)
+	q.Set("jobId", l.JobId)
+	return c.makeRequest("DELETE", c.baseUrl+"/api/v1/singleton-locks?"+q.Encode(), nil, nil)
+}
+
 // ------------------------------------------------------------------------- //
 
 // makeRequest is a helper function for making HTTP requests. The httpVerb, url,
@@ -238,9 +607,7 @@ func (c *client) makeRequest(httpVerb, url string, payloadStruct interface{}, re
 	// verbatim by the client (e.g. spinc), so it's important to make it clear.
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 		if len(body) == 0 {
-			// If there's no response body, then the API probably crashed and
-			// the status code is probably 500
-			return fmt.Errorf("no response from API, check logs (HTTP status %d)", resp.StatusCode)
+			return APIError{HTTPStatus: resp.StatusCode}
 		}
 		var perr proto.Error
 		err := json.Unmarshal(body, &perr)
@@ -251,17 +618,22 @@ func (c *client) makeRequest(httpVerb, url string, payloadStruct interface{}, re
 			} else {
 				// This can be anything from 500 errors on db error, or 401 errors
 				// if caller sends bad data
-				return fmt.Errorf("API error: %s (HTTP status %d)", perr, resp.StatusCode)
+				return APIError{HTTPStatus: resp.StatusCode, Message: perr.Message}
 			}
 		} else {
 			// If proto.Error.Message is empty, the API probably crashed and maybe
 			// the framework (Echo) sent something else. Dump whatever content body
 			// we have; it probably has some info about the error.
-			return fmt.Errorf("API error: %s (HTTP status %d)", string(body), resp.StatusCode)
+			return APIError{HTTPStatus: resp.StatusCode, Message: string(body)}
 		}
 	}
 
-	// Unmarshal the body into the struct pointed to by the respStruct argument.
+	// Return the raw body if respStruct is a *[]byte, e.g. request reports.
+	// Else unmarshal the body into the struct pointed to by respStruct.
+	if raw, ok := respStruct.(*[]byte); ok {
+		*raw = body
+		return nil
+	}
 	if respStruct != nil {
 		if err = json.Unmarshal(body, respStruct); err != nil {
 			return err
@@ -79,6 +79,25 @@ func TestCreateRequestError(t *testing.T) {
 	}
 }
 
+func TestAPIError(t *testing.T) {
+	setup(t, nil, http.StatusServiceUnavailable, `{"message":"too many job log entries pending, try again later"}`)
+	defer cleanup()
+	c := rm.NewClient(&http.Client{}, ts.URL)
+
+	err := c.CreateJL("abc", proto.JobLog{})
+	apiErr, ok := err.(rm.APIError)
+	if !ok {
+		t.Fatalf("got error %#v, expected rm.APIError", err)
+	}
+	if apiErr.HTTPStatus != http.StatusServiceUnavailable {
+		t.Errorf("got HTTP status %d, expected 503", apiErr.HTTPStatus)
+	}
+	expect := "API error: too many job log entries pending, try again later (HTTP status 503)"
+	if err.Error() != expect {
+		t.Errorf("got error %q, expected %q", err, expect)
+	}
+}
+
 func TestCreateRequestSuccess(t *testing.T) {
 	reqType := "something"
 	args := map[string]interface{}{"arg1": "val1"}
@@ -157,6 +176,36 @@ func TestGetRequestSuccess(t *testing.T) {
 	}
 }
 
+func TestGetRequestsSuccess(t *testing.T) {
+	var payload []string
+	setup(t, &payload, http.StatusOK, `{"requests":[{"id":"req1"}],"notFound":["req2"]}`)
+	defer cleanup()
+	c := rm.NewClient(&http.Client{}, ts.URL)
+
+	status, err := c.GetRequests([]string{"req1", "req2"})
+	if err != nil {
+		t.Errorf("err = %s, expected nil", err)
+	}
+	ts.Close()
+
+	expect := proto.RequestsStatus{
+		Requests: []proto.Request{{Id: "req1"}},
+		NotFound: []string{"req2"},
+	}
+	if diff := deep.Equal(status, expect); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(payload, []string{"req1", "req2"}); diff != nil {
+		t.Error(diff)
+	}
+	if path != "/api/v1/requests/status" {
+		t.Errorf("url path = %s, expected /api/v1/requests/status", path)
+	}
+	if method != "POST" {
+		t.Errorf("request method = %s, expected POST", method)
+	}
+}
+
 func TestFindRequestsSuccess(t *testing.T) {
 	setup(t, nil, http.StatusOK, "[{\"id\":\"blah\"}]")
 	defer cleanup()
@@ -435,6 +484,103 @@ func TestGetJobChainSuccess(t *testing.T) {
 	}
 }
 
+func TestGetReportSuccess(t *testing.T) {
+	reqId := "abcd1234"
+	respBody := "# Request abcd1234"
+
+	setup(t, nil, http.StatusOK, respBody)
+	defer cleanup()
+	c := rm.NewClient(&http.Client{}, ts.URL)
+
+	report, err := c.GetReport(reqId, "markdown")
+	if err != nil {
+		t.Errorf("err = %s, expected nil", err)
+	}
+	if string(report) != respBody+"\n" {
+		t.Errorf("report = %q, expected %q", report, respBody+"\n")
+	}
+
+	expectedPath := "/api/v1/requests/" + reqId + "/report"
+	if path != expectedPath {
+		t.Errorf("url path = %s, expected %s", path, expectedPath)
+	}
+	if queryString != "format=markdown" {
+		t.Errorf("query string = %s, expected format=markdown", queryString)
+	}
+}
+
+func TestGetReportError(t *testing.T) {
+	setup(t, nil, http.StatusBadRequest, `{"message":"request abcd1234 is not finished (state RUNNING)"}`)
+	defer cleanup()
+	c := rm.NewClient(&http.Client{}, ts.URL)
+
+	_, err := c.GetReport("abcd1234", "html")
+	if err == nil {
+		t.Errorf("expected an error but did not get one")
+	}
+}
+
+func TestGetTimelineSuccess(t *testing.T) {
+	reqId := "abcd1234"
+	respBody := `{"requestId":"abcd1234","type":"restart-host","state":3,"startedAt":null,"finishedAt":null,"jobs":[{"jobId":"job1","name":"a","type":"a","state":3,"startedAt":100,"finishedAt":200,"tries":[{"try":1,"state":3,"startedAt":100,"finishedAt":200}]}]}`
+
+	setup(t, nil, http.StatusOK, respBody)
+	defer cleanup()
+	c := rm.NewClient(&http.Client{}, ts.URL)
+
+	tl, err := c.GetTimeline(reqId)
+	if err != nil {
+		t.Errorf("err = %s, expected nil", err)
+	}
+	expect := proto.RequestTimeline{
+		RequestId: reqId,
+		Type:      "restart-host",
+		State:     proto.STATE_COMPLETE,
+		Jobs: []proto.TimelineJob{
+			{JobId: "job1", Name: "a", Type: "a", State: proto.STATE_COMPLETE, StartedAt: 100, FinishedAt: 200,
+				Tries: []proto.TimelineTry{{Try: 1, State: proto.STATE_COMPLETE, StartedAt: 100, FinishedAt: 200}}},
+		},
+	}
+	if diff := deep.Equal(tl, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	expectedPath := "/api/v1/requests/" + reqId + "/timeline"
+	if path != expectedPath {
+		t.Errorf("url path = %s, expected %s", path, expectedPath)
+	}
+}
+
+func TestGetStopPreviewSuccess(t *testing.T) {
+	reqId := "abcd1234"
+	respBody := `{"requestId":"abcd1234","type":"restart-host","state":2,"running":[{"jobId":"job2","name":"b","type":"b","sequenceId":"job1"}],"sequences":[{"sequenceId":"job1","name":"a","complete":1,"total":2,"skippable":false}],"finally":[],"notRun":0}`
+
+	setup(t, nil, http.StatusOK, respBody)
+	defer cleanup()
+	c := rm.NewClient(&http.Client{}, ts.URL)
+
+	sp, err := c.GetStopPreview(reqId)
+	if err != nil {
+		t.Errorf("err = %s, expected nil", err)
+	}
+	expect := proto.StopPreview{
+		RequestId: reqId,
+		Type:      "restart-host",
+		State:     proto.STATE_RUNNING,
+		Running:   []proto.StopPreviewJob{{JobId: "job2", Name: "b", Type: "b", SequenceId: "job1"}},
+		Sequences: []proto.StopPreviewSequence{{SequenceId: "job1", Name: "a", Complete: 1, Total: 2}},
+		Finally:   []proto.StopPreviewJob{},
+	}
+	if diff := deep.Equal(sp, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	expectedPath := "/api/v1/requests/" + reqId + "/stop-preview"
+	if path != expectedPath {
+		t.Errorf("url path = %s, expected %s", path, expectedPath)
+	}
+}
+
 func TestGetJLError(t *testing.T) {
 	reqId := "abcd1234"
 
@@ -0,0 +1,229 @@
+// Copyright 2020, Square, Inc.
+
+// Package cost provides job cost accounting for chargeback. Every finished job
+// try is recorded with its type, duration, and the user and namespace (like a
+// team) of its request. Recorded costs are aggregated by the usage API, and
+// each cost is reported to the Reporter plugin, which can send it to another
+// system.
+package cost
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// Reporter represents the cost reporter plugin. The default Reporter (NoReporter)
+// does not set a namespace and does not report costs, but costs are still
+// recorded for the usage API.
+//
+// To report costs, set App.Context.Plugins.CostReporter.
+type Reporter interface {
+	// Namespace returns the namespace, like a team, charged for the user's
+	// requests of the given type. It is called for every finished job try.
+	// On error, the cost is recorded without a namespace.
+	Namespace(user, requestType string) (string, error)
+
+	// Report reports the cost of one finished job try. It is called in a
+	// goroutine after the cost is recorded. Errors are logged.
+	Report(proto.JobCost) error
+}
+
+// NoReporter is the default Reporter which does nothing.
+type NoReporter struct{}
+
+// Namespace returns an empty string and nil.
+func (r NoReporter) Namespace(user, requestType string) (string, error) {
+	return "", nil
+}
+
+// Report returns nil.
+func (r NoReporter) Report(proto.JobCost) error {
+	return nil
+}
+
+// A Manager records and aggregates job costs.
+type Manager interface {
+	// Record records the cost of the job try and reports it to the Reporter.
+	// Job logs of unknown requests, like shadow runs, are ignored.
+	Record(jl proto.JobLog) error
+
+	// Usage returns job costs aggregated by the filter, ordered by period (if
+	// the filter has an interval) then group.
+	Usage(f proto.UsageFilter) ([]proto.Usage, error)
+}
+
+type ManagerConfig struct {
+	DBConnector *sql.DB  // stores job_costs
+	Reporter    Reporter // cost reporter plugin
+}
+
+type manager struct {
+	dbc      *sql.DB
+	reporter Reporter
+}
+
+func NewManager(cfg ManagerConfig) Manager {
+	reporter := cfg.Reporter
+	if reporter == nil {
+		reporter = NoReporter{}
+	}
+	return &manager{
+		dbc:      cfg.DBConnector,
+		reporter: reporter,
+	}
+}
+
+// groupByCols maps proto.USAGE_GROUP_BY_* to job_costs columns.
+var groupByCols = map[string]string{
+	proto.USAGE_GROUP_BY_NAMESPACE:    "namespace",
+	proto.USAGE_GROUP_BY_USER:         "user",
+	proto.USAGE_GROUP_BY_JOB_TYPE:     "job_type",
+	proto.USAGE_GROUP_BY_REQUEST_TYPE: "request_type",
+}
+
+// intervalFmts maps proto.USAGE_INTERVAL_* to MySQL DATE_FORMAT formats which
+// truncate finished_at to the start of the period.
+var intervalFmts = map[string]string{
+	proto.USAGE_INTERVAL_HOUR:  "%Y-%m-%d %H:00:00",
+	proto.USAGE_INTERVAL_DAY:   "%Y-%m-%d 00:00:00",
+	proto.USAGE_INTERVAL_MONTH: "%Y-%m-01 00:00:00",
+}
+
+func (m *manager) Record(jl proto.JobLog) error {
+	ctx := context.TODO()
+
+	var reqType string
+	var user sql.NullString
+	q := "SELECT type, user FROM requests WHERE request_id = ?"
+	err := m.dbc.QueryRowContext(ctx, q, jl.RequestId).Scan(&reqType, &user)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil // not a request, like a shadow run
+	case err != nil:
+		return serr.NewDbError(err, "SELECT requests")
+	}
+
+	jc := proto.JobCost{
+		RequestId:   jl.RequestId,
+		JobId:       jl.JobId,
+		Try:         jl.Try,
+		JobType:     jl.Type,
+		RequestType: reqType,
+		User:        user.String,
+		State:       jl.State,
+		FinishedAt:  time.Unix(0, jl.FinishedAt).UTC(),
+	}
+	if jl.StartedAt > 0 && jl.FinishedAt > jl.StartedAt {
+		jc.Duration = jl.FinishedAt - jl.StartedAt
+	}
+	if jl.FinishedAt == 0 {
+		jc.FinishedAt = time.Now().UTC()
+	}
+	jc.Namespace, err = m.reporter.Namespace(jc.User, jc.RequestType)
+	if err != nil {
+		log.Warnf("cost reporter: cannot get namespace for user %s, request %s: %s", jc.User, jc.RequestType, err)
+	}
+
+	q = "INSERT INTO job_costs (request_id, job_id, try, job_type, request_type, user, namespace, state, duration, finished_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err = m.dbc.ExecContext(ctx, q,
+		jc.RequestId,
+		jc.JobId,
+		jc.Try,
+		jc.JobType,
+		jc.RequestType,
+		jc.User,
+		jc.Namespace,
+		jc.State,
+		jc.Duration,
+		jc.FinishedAt,
+	)
+	if err != nil {
+		return serr.NewDbError(err, "INSERT job_costs")
+	}
+
+	go func() {
+		if err := m.reporter.Report(jc); err != nil {
+			log.Warnf("cost reporter: cannot report cost of job %s try %d in request %s: %s", jc.JobId, jc.Try, jc.RequestId, err)
+		}
+	}()
+	return nil
+}
+
+func (m *manager) Usage(f proto.UsageFilter) ([]proto.Usage, error) {
+	if f.GroupBy == "" {
+		f.GroupBy = proto.USAGE_GROUP_BY_NAMESPACE
+	}
+	col, ok := groupByCols[f.GroupBy]
+	if !ok {
+		return nil, serr.ValidationError{Message: fmt.Sprintf("invalid groupBy %q: valid values are %s, %s, %s, and %s",
+			f.GroupBy, proto.USAGE_GROUP_BY_NAMESPACE, proto.USAGE_GROUP_BY_USER, proto.USAGE_GROUP_BY_JOB_TYPE, proto.USAGE_GROUP_BY_REQUEST_TYPE)}
+	}
+	period := "''"
+	if f.Interval != "" {
+		format, ok := intervalFmts[f.Interval]
+		if !ok {
+			return nil, serr.ValidationError{Message: fmt.Sprintf("invalid interval %q: valid values are %s, %s, and %s",
+				f.Interval, proto.USAGE_INTERVAL_HOUR, proto.USAGE_INTERVAL_DAY, proto.USAGE_INTERVAL_MONTH)}
+		}
+		period = "DATE_FORMAT(finished_at, '" + format + "')"
+	}
+
+	where := []string{}
+	vals := []interface{}{}
+	if f.Namespace != "" {
+		where = append(where, "namespace = ?")
+		vals = append(vals, f.Namespace)
+	}
+	if f.User != "" {
+		where = append(where, "user = ?")
+		vals = append(vals, f.User)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "finished_at >= ?")
+		vals = append(vals, f.Since)
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "finished_at < ?")
+		vals = append(vals, f.Until)
+	}
+
+	q := "SELECT " + period + " AS period, " + col + ", COUNT(*), SUM(duration) FROM job_costs"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " GROUP BY period, " + col + " ORDER BY period, " + col
+
+	rows, err := m.dbc.QueryContext(context.TODO(), q, vals...)
+	if err != nil {
+		return nil, serr.NewDbError(err, "SELECT job_costs")
+	}
+	defer rows.Close()
+	usage := []proto.Usage{}
+	for rows.Next() {
+		var u proto.Usage
+		var p string
+		if err := rows.Scan(&p, &u.Group, &u.Jobs, &u.Duration); err != nil {
+			return nil, serr.NewDbError(err, "SELECT job_costs")
+		}
+		if p != "" {
+			t, err := time.ParseInLocation("2006-01-02 15:04:05", p, time.UTC)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse period %q: %s", p, err)
+			}
+			u.Period = &t
+		}
+		usage = append(usage, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, serr.NewDbError(err, "SELECT job_costs")
+	}
+	return usage, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2020, Square, Inc.
+
+package cost_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/cost"
+	"github.com/square/spincycle/v2/request-manager/test"
+	testdb "github.com/square/spincycle/v2/request-manager/test/db"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+var dbm testdb.Manager
+var dbc *sql.DB
+
+func setup(t *testing.T, dataFile string) string {
+	var err error
+	if dbm == nil {
+		dbm, err = testdb.NewManager()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbName, err := dbm.Create(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbm.Connect(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbc = db
+	return dbName
+}
+
+func teardown(t *testing.T, dbName string) {
+	if err := dbm.Destroy(dbName); err != nil {
+		t.Fatal(err)
+	}
+	dbc.Close()
+}
+
+// //////////////////////////////////////////////////////////////////////////
+// Tests
+// //////////////////////////////////////////////////////////////////////////
+
+func TestRecord(t *testing.T) {
+	dbName := setup(t, test.DataPath+"/job-costs.sql")
+	defer teardown(t, dbName)
+
+	reported := make(chan proto.JobCost, 1)
+	r := &mock.CostReporter{
+		NamespaceFunc: func(user, requestType string) (string, error) {
+			return "team-" + user, nil
+		},
+		ReportFunc: func(jc proto.JobCost) error {
+			reported <- jc
+			return nil
+		},
+	}
+	m := cost.NewManager(cost.ManagerConfig{DBConnector: dbc, Reporter: r})
+
+	finishedAt := time.Date(2020, 6, 2, 11, 0, 0, 0, time.UTC)
+	jl := proto.JobLog{
+		RequestId:  "costreq2____________",
+		JobId:      "e5f6",
+		Try:        1,
+		Type:       "start",
+		State:      proto.STATE_COMPLETE,
+		StartedAt:  finishedAt.Add(-3 * time.Second).UnixNano(),
+		FinishedAt: finishedAt.UnixNano(),
+	}
+	if err := m.Record(jl); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := proto.JobCost{
+		RequestId:   "costreq2____________",
+		JobId:       "e5f6",
+		Try:         1,
+		JobType:     "start",
+		RequestType: "start-host",
+		User:        "dn",
+		Namespace:   "team-dn",
+		State:       proto.STATE_COMPLETE,
+		Duration:    int64(3 * time.Second),
+		FinishedAt:  finishedAt,
+	}
+	select {
+	case jc := <-reported:
+		if diff := deep.Equal(jc, expect); diff != nil {
+			t.Error(diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job cost not reported after 1s")
+	}
+
+	usage, err := m.Usage(proto.UsageFilter{Namespace: "team-dn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectUsage := []proto.Usage{{Group: "team-dn", Jobs: 1, Duration: int64(3 * time.Second)}}
+	if diff := deep.Equal(usage, expectUsage); diff != nil {
+		t.Error(diff)
+	}
+
+	// Job logs of unknown requests (shadow runs) are not recorded
+	jl.RequestId = "shadow______________"
+	if err := m.Record(jl); err != nil {
+		t.Error(err)
+	}
+	select {
+	case jc := <-reported:
+		t.Errorf("reported job cost %+v, expected none", jc)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUsage(t *testing.T) {
+	dbName := setup(t, test.DataPath+"/job-costs.sql")
+	defer teardown(t, dbName)
+
+	m := cost.NewManager(cost.ManagerConfig{DBConnector: dbc})
+
+	usage, err := m.Usage(proto.UsageFilter{GroupBy: proto.USAGE_GROUP_BY_JOB_TYPE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []proto.Usage{
+		{Group: "check", Jobs: 2, Duration: 2000000000},
+		{Group: "stop", Jobs: 1, Duration: 2000000000},
+	}
+	if diff := deep.Equal(usage, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Over time, by hour: all in the same hour
+	usage, err = m.Usage(proto.UsageFilter{
+		Interval: proto.USAGE_INTERVAL_HOUR,
+		Since:    time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+		Until:    time.Date(2020, 6, 2, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hour := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	expect = []proto.Usage{
+		{Group: "team1", Period: &hour, Jobs: 3, Duration: 4000000000},
+	}
+	if diff := deep.Equal(usage, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Invalid group by
+	_, err = m.Usage(proto.UsageFilter{GroupBy: "host"})
+	if _, ok := err.(serr.ValidationError); !ok {
+		t.Errorf("got error %v, expected serr.ValidationError", err)
+	}
+}
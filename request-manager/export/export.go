@@ -0,0 +1,229 @@
+// Copyright 2020, Square, Inc.
+
+// Package export exports finished requests to object storage, like S3 or GCS,
+// so the complete history of every request is kept outside MySQL and job logs
+// can be purged sooner (config job_log.retention). When a request finishes, its
+// bundle (proto.RequestBundle: the request with its job chain, and every job log
+// entry) is written as gzip-compressed JSON by the ObjectStore plugin, and the
+// location returned by the plugin is saved in table request_exports.
+//
+// Exports are asynchronous: Finished queues the request and Run exports it. If
+// the queue is full, the export fails, or the Request Manager stops first, the
+// request is exported by the next sweep, which exports recently finished requests
+// not exported yet.
+package export
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/compress"
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/joblog"
+	"github.com/square/spincycle/v2/request-manager/request"
+)
+
+var (
+	// How often Run exports finished requests not exported yet.
+	SweepInterval = 5 * time.Minute
+
+	// How long ago requests could have finished to be exported by a sweep.
+	// Older requests are never exported, so enabling exports does not export
+	// all history.
+	SweepMaxAge = 24 * time.Hour
+
+	// Max number of requests exported per sweep.
+	SweepLimit = 100
+
+	// Max number of requests queued by Finished. More are exported by a sweep.
+	QueueSize = 1000
+
+	// How long an export can take, including the ObjectStore plugin Put.
+	ExportTimeout = 5 * time.Minute
+)
+
+// An ObjectStore is the object storage plugin, like an S3 or GCS client. It's
+// set in App.Context.Plugins.ObjectStore; requests are not exported without it.
+type ObjectStore interface {
+	// Put writes the object, replacing it if it exists, and returns its
+	// location, like "s3://bucket/spincycle/<key>". The location is saved
+	// and returned by the API as is. key is "<request ID>.json.gz".
+	Put(ctx context.Context, key string, data []byte) (location string, err error)
+}
+
+// A Manager exports finished requests.
+type Manager interface {
+	// Finished queues the export of a finished request. It does not block.
+	Finished(requestId string)
+
+	// Run exports queued requests, and sweeps finished requests not exported
+	// every SweepInterval, until the stop channel is closed. All errors are
+	// logged, not returned.
+	Run(stopChan <-chan struct{})
+
+	// Export exports a finished request now and returns where. If the request
+	// was already exported, it's exported again.
+	Export(requestId string) (proto.RequestExport, error)
+
+	// Get returns where a request was exported, or serr.ExportNotFound if it
+	// was not exported.
+	Get(requestId string) (proto.RequestExport, error)
+}
+
+type ManagerConfig struct {
+	Store          ObjectStore
+	RequestManager request.Manager
+	JLStore        joblog.Store
+	DBConnector    *sql.DB
+}
+
+type manager struct {
+	store ObjectStore
+	rm    request.Manager
+	jls   joblog.Store
+	dbc   *sql.DB
+	queue chan string
+}
+
+func NewManager(cfg ManagerConfig) Manager {
+	return &manager{
+		store: cfg.Store,
+		rm:    cfg.RequestManager,
+		jls:   cfg.JLStore,
+		dbc:   cfg.DBConnector,
+		queue: make(chan string, QueueSize),
+	}
+}
+
+func (m *manager) Finished(requestId string) {
+	select {
+	case m.queue <- requestId:
+	default:
+		log.Warnf("request %s: export queue full, request will be exported by the next sweep", requestId)
+	}
+}
+
+func (m *manager) Run(stopChan <-chan struct{}) {
+	ticker := time.NewTicker(SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case requestId := <-m.queue:
+			if _, err := m.Export(requestId); err != nil {
+				log.Errorf("request %s: error exporting, will retry on next sweep: %s", requestId, err)
+			}
+		case <-ticker.C:
+			if err := m.sweep(stopChan); err != nil {
+				log.Errorf("error sweeping requests to export: %s", err)
+			}
+		}
+	}
+}
+
+// sweep exports requests finished within SweepMaxAge that were not exported,
+// oldest first.
+func (m *manager) sweep(stopChan <-chan struct{}) error {
+	q := "SELECT r.request_id FROM requests r LEFT JOIN request_exports e ON e.request_id = r.request_id" +
+		" WHERE r.finished_at >= ? AND r.state IN (?, ?, ?) AND e.request_id IS NULL" +
+		" ORDER BY r.finished_at LIMIT ?"
+	finishedAfter := time.Now().UTC().Add(-SweepMaxAge)
+	rows, err := m.dbc.Query(q, finishedAfter, proto.STATE_COMPLETE, proto.STATE_FAIL, proto.STATE_STOPPED, SweepLimit)
+	if err != nil {
+		return serr.NewDbError(err, "SELECT requests")
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return serr.NewDbError(err, "SELECT requests")
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return serr.NewDbError(err, "SELECT requests")
+	}
+
+	for _, id := range ids {
+		select {
+		case <-stopChan:
+			return nil
+		default:
+		}
+		if _, err := m.Export(id); err != nil {
+			log.Errorf("request %s: error exporting: %s", id, err)
+		}
+	}
+	return nil
+}
+
+func (m *manager) Export(requestId string) (proto.RequestExport, error) {
+	req, err := m.rm.GetWithJC(requestId)
+	if err != nil {
+		return proto.RequestExport{}, err
+	}
+	if req.FinishedAt == nil {
+		return proto.RequestExport{}, fmt.Errorf("request is not finished (state %s)", proto.StateName[req.State])
+	}
+	jls, err := m.jls.GetFull(requestId)
+	if err != nil {
+		return proto.RequestExport{}, err
+	}
+
+	bundle := proto.RequestBundle{
+		Request:    req,
+		JobLogs:    jls,
+		ExportedAt: time.Now().UTC(),
+	}
+	bytes, err := json.Marshal(bundle)
+	if err != nil {
+		return proto.RequestExport{}, fmt.Errorf("cannot marshal bundle: %s", err)
+	}
+	data, err := compress.Gzip{}.Compress(bytes)
+	if err != nil {
+		return proto.RequestExport{}, fmt.Errorf("cannot compress bundle: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExportTimeout)
+	defer cancel()
+	location, err := m.store.Put(ctx, requestId+".json.gz", data)
+	if err != nil {
+		return proto.RequestExport{}, fmt.Errorf("object store: %s", err)
+	}
+
+	exp := proto.RequestExport{
+		RequestId:  requestId,
+		Location:   location,
+		Size:       int64(len(data)),
+		ExportedAt: bundle.ExportedAt,
+	}
+	q := "INSERT INTO request_exports (request_id, location, size, exported_at) VALUES (?, ?, ?, ?)" +
+		" ON DUPLICATE KEY UPDATE location = VALUES(location), size = VALUES(size), exported_at = VALUES(exported_at)"
+	if _, err := m.dbc.Exec(q, exp.RequestId, exp.Location, exp.Size, exp.ExportedAt); err != nil {
+		return proto.RequestExport{}, serr.NewDbError(err, "INSERT request_exports")
+	}
+	log.Infof("request %s: exported %d bytes to %s", requestId, exp.Size, exp.Location)
+	return exp, nil
+}
+
+func (m *manager) Get(requestId string) (proto.RequestExport, error) {
+	exp := proto.RequestExport{RequestId: requestId}
+	q := "SELECT location, size, exported_at FROM request_exports WHERE request_id = ?"
+	err := m.dbc.QueryRow(q, requestId).Scan(&exp.Location, &exp.Size, &exp.ExportedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return exp, serr.ExportNotFound{RequestId: requestId}
+		}
+		return exp, serr.NewDbError(err, "SELECT request_exports")
+	}
+	return exp, nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2020, Square, Inc.
+
+package export_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/compress"
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/export"
+	testdb "github.com/square/spincycle/v2/request-manager/test/db"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+var dbm testdb.Manager
+var dbc *sql.DB
+
+func setup(t *testing.T, dataFile string) string {
+	var err error
+	if dbm == nil {
+		dbm, err = testdb.NewManager()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbName, err := dbm.Create(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbm.Connect(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbc = db
+	return dbName
+}
+
+func teardown(t *testing.T, dbName string) {
+	if err := dbm.Destroy(dbName); err != nil {
+		t.Fatal(err)
+	}
+	dbc.Close()
+}
+
+// rm returns a mock request manager that returns every request finished.
+func rm() *mock.RequestManager {
+	return &mock.RequestManager{
+		GetWithJCFunc: func(id string) (proto.Request, error) {
+			finishedAt := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+			return proto.Request{
+				Id:         id,
+				State:      proto.STATE_COMPLETE,
+				FinishedAt: &finishedAt,
+				JobChain:   &proto.JobChain{RequestId: id, Jobs: map[string]proto.Job{"job1": {Id: "job1"}}},
+			}, nil
+		},
+	}
+}
+
+// //////////////////////////////////////////////////////////////////////////
+// Tests
+// //////////////////////////////////////////////////////////////////////////
+
+func TestExport(t *testing.T) {
+	dbName := setup(t, "../test/data/request-default.sql")
+	defer teardown(t, dbName)
+
+	objects := map[string][]byte{}
+	m := export.NewManager(export.ManagerConfig{
+		Store: &mock.ObjectStore{
+			PutFunc: func(ctx context.Context, key string, data []byte) (string, error) {
+				objects[key] = data
+				return "s3://bucket/" + key, nil
+			},
+		},
+		RequestManager: rm(),
+		JLStore: &mock.JLStore{
+			GetFullFunc: func(id string) ([]proto.JobLog, error) {
+				return []proto.JobLog{{RequestId: id, JobId: "job1", Try: 1, State: proto.STATE_COMPLETE}}, nil
+			},
+		},
+		DBConnector: dbc,
+	})
+
+	_, err := m.Get("abc")
+	if _, ok := err.(serr.ExportNotFound); !ok {
+		t.Errorf("got error %v, expected serr.ExportNotFound", err)
+	}
+
+	exp, err := m.Export("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp.Location != "s3://bucket/abc.json.gz" {
+		t.Errorf("got location %s, expected s3://bucket/abc.json.gz", exp.Location)
+	}
+
+	// Bundle is gzip-compressed JSON of the request and its job logs
+	data, ok := objects["abc.json.gz"]
+	if !ok {
+		t.Fatalf("no object abc.json.gz, got %v", objects)
+	}
+	if exp.Size != int64(len(data)) {
+		t.Errorf("got size %d, expected %d", exp.Size, len(data))
+	}
+	bytes, err := compress.Gzip{}.Decompress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle proto.RequestBundle
+	if err := json.Unmarshal(bytes, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Request.Id != "abc" || bundle.Request.JobChain == nil || len(bundle.JobLogs) != 1 {
+		t.Errorf("got bundle %+v, expected request abc with job chain and 1 job log", bundle)
+	}
+
+	// Location is saved
+	got, err := m.Get("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Location != exp.Location || got.Size != exp.Size {
+		t.Errorf("got %+v, expected %+v", got, exp)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dbName := setup(t, "../test/data/request-default.sql")
+	defer teardown(t, dbName)
+
+	// Recently finished, not exported
+	_, err := dbc.Exec("INSERT INTO requests (request_id, type, created_at, finished_at, state) VALUES" +
+		" ('recently_finished___', 'something-else', NOW(6) - INTERVAL 1 HOUR, NOW(6) - INTERVAL 1 MINUTE, 4)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(d time.Duration) { export.SweepInterval = d }(export.SweepInterval)
+	export.SweepInterval = 50 * time.Millisecond
+
+	putChan := make(chan string, 10)
+	m := export.NewManager(export.ManagerConfig{
+		Store: &mock.ObjectStore{
+			PutFunc: func(ctx context.Context, key string, data []byte) (string, error) {
+				putChan <- key
+				return "s3://bucket/" + key, nil
+			},
+		},
+		RequestManager: rm(),
+		JLStore:        &mock.JLStore{},
+		DBConnector:    dbc,
+	})
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	go m.Run(stopChan)
+
+	// Queued request is exported, then the sweep exports the recently finished
+	// request. Request 93ec156e204ety45sgf0 finished too long ago.
+	m.Finished("queued")
+	var keys []string
+	for len(keys) < 2 {
+		select {
+		case key := <-putChan:
+			keys = append(keys, key)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for exports, got %v", keys)
+		}
+	}
+	if diff := deep.Equal(keys, []string{"queued.json.gz", "recently_finished___.json.gz"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Exported requests are not exported again
+	select {
+	case key := <-putChan:
+		t.Errorf("exported %s again", key)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
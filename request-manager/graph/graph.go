@@ -5,6 +5,7 @@ package graph
 import (
 	"fmt"
 
+	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/request-manager/spec"
 )
 
@@ -30,18 +31,30 @@ type Node struct {
 	// Always valid
 	Id   string // UID within graph
 	Name string // Descriptive name for debugging and visualizing graph
+	Desc string // Human-readable description from spec node or its sequence (desc:)
 
 	// Used when node represents a node spec
 	Spec *spec.Node // Node spec that this graph node represents
 
 	// Used when node represents a job
-	JobBytes          []byte                 // return value of Job.Serialize method
-	Args              map[string]interface{} // The args the node was created with
-	Retry             uint                   // The number of times to retry a node
-	RetryWait         string                 // The time to sleep between retries
-	SequenceId        string                 // ID for first node in sequence
-	SequenceRetry     uint                   // Number of times to retry a sequence. Only set for first node in sequence.
-	SequenceRetryWait string                 // The time to sleep between sequence retries
+	JobBytes          []byte                     // return value of Job.Serialize method
+	Args              map[string]interface{}     // The args the node was created with
+	ArgSources        map[string]proto.ArgSource // Where each of Args came from
+	Retry             uint                       // The number of times to retry a node
+	RetryWait         string                     // The time to sleep between retries
+	RetryArgs         map[string]interface{}     // Arg overrides given to the job on retries
+	KeepData          bool                       // Keep job data changes on sequence retry
+	SequenceId        string                     // ID for first node in sequence
+	SequenceRetry     uint                       // Number of times to retry a sequence. Only set for first node in sequence.
+	SequenceRetryWait string                     // The time to sleep between sequence retries
+	SequenceSkippable bool                       // Sequence can be skipped when it fails. Only set for first node in sequence.
+	Singleton         string                     // Singleton lock name, empty if not a singleton
+	SingletonPolicy   string                     // proto.SINGLETON_POLICY_* const if a singleton
+	Needs             []string                   // Job Runner capabilities required to run the job
+	Pace              string                     // ID of the paced expansion (pace:) the node is in, empty if none
+	PaceStart         bool                       // First node of a sequence in the paced expansion
+	Asserts           []string                   // Assertions checked when the node completes. Only set for last node in sequence.
+	Finally           bool                       // Node is in the sequence finally: block, run even if the sequence fails or is stopped
 }
 
 // IsValidGraph asserts that g is a valid graph by ensuring that
@@ -4,6 +4,7 @@ package graph
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/square/spincycle/v2/request-manager/id"
@@ -250,6 +251,11 @@ func getMissingSets(actual map[string]bool, declared []*spec.NodeSet) []string {
 // of `sets` declarations in sequence and conditional nodes.
 // Returns the graph, the set of job args its component nodes set, and an error
 // if any should occur.
+//
+// Finally nodes are added after the leaf nodes (nodes that no other node depends
+// on), unless they depend on other finally nodes. The sink node depends on the
+// leaf nodes, too, so the sequence only completes if its nodes complete, even
+// though its finally nodes run when they fail.
 func buildSeqGraph(seqSpec *spec.Sequence, idgen id.Generator) (seqGraph *Graph, sets map[string]bool, err error) {
 	// The graph we'll be filling in
 	seqGraph, err = newSeqGraph(seqSpec.Name, idgen)
@@ -276,9 +282,16 @@ func buildSeqGraph(seqSpec *spec.Sequence, idgen id.Generator) (seqGraph *Graph,
 	//
 	// Key on node names; they should be unique within a sequence (otherwise,
 	// dependencies are ill-defined).
+	nodeSpecs := map[string]*spec.Node{} // nodes and finally nodes
+	for name, nodeSpec := range seqSpec.Nodes {
+		nodeSpecs[name] = nodeSpec
+	}
+	for name, nodeSpec := range seqSpec.Finally {
+		nodeSpecs[name] = nodeSpec
+	}
 	nodes := map[string]*Graph{}
 	nodesToAdd := map[string]*Graph{} // Nodes we've yet to add
-	for _, nodeSpec := range seqSpec.Nodes {
+	for _, nodeSpec := range nodeSpecs {
 		id, err := idgen.UID()
 		if err != nil {
 			return nil, nil, err
@@ -300,6 +313,40 @@ func buildSeqGraph(seqSpec *spec.Sequence, idgen id.Generator) (seqGraph *Graph,
 		nodesToAdd[nodeSpec.Name] = g
 	}
 
+	// Dependencies of every node: deps, and nodes that set the job args listed
+	// in after, which are resolved here so they don't have to be maintained
+	// by hand when the nodes that set the job args change
+	nodeDeps := map[string][]string{}
+	for _, nodeSpec := range seqSpec.Nodes {
+		deps, err := getNodeDeps(seqSpec, nodeSpec)
+		if err != nil {
+			return nil, nil, err
+		}
+		nodeDeps[nodeSpec.Name] = deps
+	}
+	var leaves []string
+	if len(seqSpec.Finally) > 0 {
+		isDep := map[string]bool{}
+		for _, deps := range nodeDeps {
+			for _, dep := range deps {
+				isDep[dep] = true
+			}
+		}
+		for name := range seqSpec.Nodes {
+			if !isDep[name] {
+				leaves = append(leaves, name)
+			}
+		}
+		sort.Strings(leaves) // map order is random
+		for _, nodeSpec := range seqSpec.Finally {
+			deps := nodeSpec.Dependencies
+			if len(deps) == 0 {
+				deps = leaves
+			}
+			nodeDeps[nodeSpec.Name] = deps
+		}
+	}
+
 	nodesAdded := map[string]bool{}
 
 	// Build graph by adding nodes, starting from the source node, and then
@@ -323,8 +370,9 @@ func buildSeqGraph(seqSpec *spec.Sequence, idgen id.Generator) (seqGraph *Graph,
 		// build B and nodeAdded would be false and trigger the error
 		// after this loop.
 		for nodeName, node := range nodesToAdd {
-			nodeSpec := seqSpec.Nodes[nodeName]
-			if !haveAllDeps(nodesAdded, nodeSpec.Dependencies) {
+			nodeSpec := nodeSpecs[nodeName]
+			deps := nodeDeps[nodeName]
+			if !haveAllDeps(nodesAdded, deps) {
 				continue
 			}
 
@@ -336,7 +384,7 @@ func buildSeqGraph(seqSpec *spec.Sequence, idgen id.Generator) (seqGraph *Graph,
 			}
 
 			// Insert node into graph
-			if len(nodeSpec.Dependencies) == 0 {
+			if len(deps) == 0 {
 				// Case: no dependencies; insert directly after the source node.
 				// No nodes that depend on this one have been added yet, so we
 				// can put it right before the sink node.
@@ -348,7 +396,7 @@ func buildSeqGraph(seqSpec *spec.Sequence, idgen id.Generator) (seqGraph *Graph,
 				// Case: dependencies exist; insert between all its dependencies
 				// and the sink node, since no nodes depending on this one have
 				// been added yet.
-				for _, dependencyName := range nodeSpec.Dependencies {
+				for _, dependencyName := range deps {
 					prevComponent := nodes[dependencyName]
 					err := seqGraph.InsertComponentBetween(node, prevComponent.Sink, seqGraph.Sink)
 					if err != nil {
@@ -381,6 +429,14 @@ func buildSeqGraph(seqSpec *spec.Sequence, idgen id.Generator) (seqGraph *Graph,
 		}
 	}
 
+	// Finally nodes were inserted between the leaf nodes and the sink node,
+	// so connect the leaf nodes to the sink node again
+	for _, name := range leaves {
+		leaf := nodes[name].Sink
+		seqGraph.Edges[leaf.Id] = append(seqGraph.Edges[leaf.Id], seqGraph.Sink.Id)
+		seqGraph.RevEdges[seqGraph.Sink.Id] = append(seqGraph.RevEdges[seqGraph.Sink.Id], leaf.Id)
+	}
+
 	seqGraph.Order = append(seqGraph.Order, seqGraph.Sink)
 
 	// Make sure we haven't created a deformed graph
@@ -427,7 +483,7 @@ func newNoopSeqNode(name, id string) *Node {
 	}
 }
 
-// getAllSequenceArgs returns all sequence args, i.e. required+optional+static.
+// getAllSequenceArgs returns all sequence args, i.e. required+optional+static+derived.
 // This is the minimal set of job args that the sequence starts with.
 // In the context of a wider request of which this sequence is a part, there may
 // be more job args available, but we only permit a sequence to access the job
@@ -444,9 +500,57 @@ func getAllSequenceArgs(seq *spec.Sequence) map[string]bool {
 	for _, arg := range seq.Args.Static {
 		jobArgs[*arg.Name] = true
 	}
+	for _, arg := range seq.Args.Derived {
+		jobArgs[*arg.Name] = true
+	}
 	return jobArgs
 }
 
+// getNodeDeps returns the names of the nodes that a node depends on: the nodes
+// listed in its deps, then the nodes that set the job args listed in its after.
+// It's an error if no other node in the sequence sets a job arg in after.
+func getNodeDeps(seqSpec *spec.Sequence, nodeSpec *spec.Node) ([]string, error) {
+	if len(nodeSpec.After) == 0 {
+		return nodeSpec.Dependencies, nil
+	}
+
+	// job arg -> nodes that set it, other than this node
+	setBy := map[string][]string{}
+	for _, n := range seqSpec.Nodes {
+		if n.Name == nodeSpec.Name {
+			continue
+		}
+		for _, nodeSet := range n.Sets {
+			if nodeSet != nil && nodeSet.As != nil {
+				setBy[*nodeSet.As] = append(setBy[*nodeSet.As], n.Name)
+			}
+		}
+	}
+
+	deps := []string{}
+	seen := map[string]bool{}
+	for _, dep := range nodeSpec.Dependencies {
+		if !seen[dep] {
+			deps = append(deps, dep)
+			seen[dep] = true
+		}
+	}
+	for _, arg := range nodeSpec.After {
+		setters, ok := setBy[arg]
+		if !ok {
+			return nil, fmt.Errorf("node %s: after: job arg %s is not set by another node in sequence %s", nodeSpec.Name, arg, seqSpec.Name)
+		}
+		sort.Strings(setters) // map order is random
+		for _, dep := range setters {
+			if !seen[dep] {
+				deps = append(deps, dep)
+				seen[dep] = true
+			}
+		}
+	}
+	return deps, nil
+}
+
 // haveAllDeps checks whether the set of nodes in a graph
 // satisfies all dependencies.
 func haveAllDeps(inGraph map[string]bool, dependencies []string) bool {
@@ -376,3 +376,50 @@ func verifyStep(t *testing.T, g *Graph, nodeIds, expectedNodeNames []string) {
 		}
 	}
 }
+
+func TestAfterGraph(t *testing.T) {
+	sequenceFile := "after.yaml"
+	grapher := MakeGrapher(t, sequenceFile)
+	seqGraphs, seqResults := grapher.CheckSequences()
+	if seqResults.AnyError {
+		t.Fatalf("error creating sequence graphs, expected no error: %v", seqResults)
+	}
+
+	sequence := "after-args"
+	g, ok := seqGraphs[sequence]
+	if !ok {
+		t.Fatalf("could not find sequence graph for sequence %s", sequence)
+	}
+
+	currentStep := g.Edges[g.Source.Id]
+	verifyStep(t, g, currentStep, []string{"get-hosts", "get-owners"})
+
+	// notify-owners is after get-owners, and drain-hosts is after both
+	// because it's after a job arg set by each
+	for _, id := range currentStep {
+		switch g.Nodes[id].Name {
+		case "get-hosts":
+			verifyStep(t, g, g.Edges[id], []string{"drain-hosts"})
+		case "get-owners":
+			verifyStep(t, g, g.Edges[id], []string{"notify-owners", "drain-hosts"})
+		}
+	}
+	for _, id := range getNextStep(g, currentStep) {
+		verifyStep(t, g, g.Edges[id], []string{"after-args_end"})
+	}
+}
+
+func TestFailAfterNotSetGraphCheck(t *testing.T) {
+	sequenceFile := "graph-checks.yaml"
+	grapher := MakeGrapher(t, sequenceFile)
+	_, seqResults := grapher.CheckSequences()
+	if !seqResults.AnyError {
+		t.Fatal("no error creating subsequence graph with after job arg not set by a node, expected error")
+	}
+
+	// verify that error occurred in expected subsequence
+	subsequence := "after-not-set"
+	if errs := getSeqErrors(subsequence, seqResults); len(errs) == 0 {
+		t.Fatalf("no error creating subsequence graph for sequence %s, expected error", subsequence)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/square/spincycle/v2/expr"
 	"github.com/square/spincycle/v2/job"
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/request-manager/id"
@@ -55,15 +56,30 @@ type Resolver interface {
 
 	// Build the request graph. Returns an error if any error occurs.
 	BuildRequestGraph(jobArgs map[string]interface{}) (*Graph, error)
+
+	// Globals returns the request globals set by BuildRequestGraph, or nil if
+	// the request spec has none.
+	Globals() map[string]interface{}
+
+	// Webhooks returns the webhooks of sequences built by BuildRequestGraph,
+	// or nil if no sequence has webhooks.
+	Webhooks() []proto.SequenceWebhook
+
+	// Escalations returns the escalations of sequences built by BuildRequestGraph,
+	// or nil if no sequence has escalations.
+	Escalations() []proto.SequenceEscalation
 }
 
 // resolver implements the Resolver interface.
 type resolver struct {
-	request    proto.Request             // the request spec this resolver can create job chain for
-	jobFactory job.Factory               // factory to create nodes' jobs
-	seqSpecs   map[string]*spec.Sequence // sequence name --> sequence spec
-	seqGraphs  map[string]*Graph         // sequence name --> sequence graph
-	idGen      id.Generator              // generates UIDs for jobs
+	request     proto.Request              // the request spec this resolver can create job chain for
+	jobFactory  job.Factory                // factory to create nodes' jobs
+	seqSpecs    map[string]*spec.Sequence  // sequence name --> sequence spec
+	seqGraphs   map[string]*Graph          // sequence name --> sequence graph
+	idGen       id.Generator               // generates UIDs for jobs
+	globals     map[string]interface{}     // request globals, set when building request sequence
+	webhooks    []proto.SequenceWebhook    // sequence webhooks, added when building sequences
+	escalations []proto.SequenceEscalation // sequence escalations, added when building sequences
 }
 
 // RequestArgs takes user input args and returns them as a job args map, the form
@@ -118,24 +134,60 @@ func (r *resolver) RequestArgs(jobArgs map[string]interface{}) ([]proto.RequestA
 		})
 	}
 
+	// Derived args are computed from the final values of the args above and
+	// the derived args before them, so callers cannot give them
+	if len(seq.Args.Derived) > 0 {
+		args := make(map[string]interface{}, len(reqArgs))
+		for _, arg := range reqArgs {
+			args[arg.Name] = arg.Value
+		}
+		for i, arg := range seq.Args.Derived {
+			if _, ok := jobArgs[*arg.Name]; ok {
+				return nil, fmt.Errorf("arg '%s' is derived, cannot be given", *arg.Name)
+			}
+			val, err := arg.Derive(args)
+			if err != nil {
+				return nil, fmt.Errorf("cannot derive arg '%s': %s", *arg.Name, err)
+			}
+			args[*arg.Name] = val
+			reqArgs = append(reqArgs, proto.RequestArg{
+				Pos:   i,
+				Name:  *arg.Name,
+				Desc:  arg.Desc,
+				Type:  proto.ARG_TYPE_DERIVED,
+				Value: val,
+			})
+		}
+	}
+
 	return reqArgs, nil
 }
 
 // Parameters to buildSequence helper function.
 type buildSequenceConfig struct {
-	graphName    string                 // Name of graph and its source/sink nodes
-	seqName      string                 // Name of sequence to build
-	jobArgs      map[string]interface{} // Set of job args sequence is given
-	seqRetry     uint                   // Retry info for sequence
+	graphName    string                     // Name of graph and its source/sink nodes
+	seqName      string                     // Name of sequence to build
+	jobArgs      map[string]interface{}     // Set of job args sequence is given
+	argSources   map[string]proto.ArgSource // Where each job arg came from
+	seqRetry     uint                       // Retry info for sequence
 	seqRetryWait string
+	seqSkippable bool   // Sequence can be skipped when it fails
+	seqDesc      string // Desc of sequence node, else sequence spec desc is used
 }
 
 // BuildRequestGraph returns a request graph with the given starting job args.
 func (r *resolver) BuildRequestGraph(jobArgs map[string]interface{}) (*Graph, error) {
+	// Every arg the request starts with was given by the caller. Defaults and
+	// args set by jobs are recorded as the graph is built.
+	argSources := make(map[string]proto.ArgSource, len(jobArgs))
+	for name := range jobArgs {
+		argSources[name] = proto.ArgSource{Source: proto.ARG_SOURCE_GIVEN}
+	}
 	cfg := buildSequenceConfig{
 		graphName:    "request_" + r.request.Type,
 		seqName:      r.request.Type,
 		jobArgs:      jobArgs,
+		argSources:   argSources,
 		seqRetry:     0,
 		seqRetryWait: "0s",
 	}
@@ -147,6 +199,18 @@ func (r *resolver) BuildRequestGraph(jobArgs map[string]interface{}) (*Graph, er
 	return reqGraph, nil
 }
 
+func (r *resolver) Globals() map[string]interface{} {
+	return r.globals
+}
+
+func (r *resolver) Webhooks() []proto.SequenceWebhook {
+	return r.webhooks
+}
+
+func (r *resolver) Escalations() []proto.SequenceEscalation {
+	return r.escalations
+}
+
 // buildSequence recursively builds a sequence. If a sequence graph node represents
 // a job, buildSequence creates the corresponding job. If a sequence graph node needs
 // to be expanded, i.e. it represents anything but a job, it is recursively expanded
@@ -155,6 +219,7 @@ func (r *resolver) BuildRequestGraph(jobArgs map[string]interface{}) (*Graph, er
 func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 	seqName := cfg.seqName
 	jobArgs := cfg.jobArgs
+	argSources := cfg.argSources
 
 	// Add optional and static sequence arguments to map.
 	// If this is the request sequence, then jobArgs was the output of RequestArgs,
@@ -169,11 +234,37 @@ func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 	for _, arg := range seq.Args.Optional {
 		if _, ok := jobArgs[*arg.Name]; !ok {
 			jobArgs[*arg.Name] = *arg.Default
+			argSources[*arg.Name] = proto.ArgSource{Source: proto.ARG_SOURCE_DEFAULT, Sequence: seqName}
 		}
 	}
 	for _, arg := range seq.Args.Static {
 		if _, ok := jobArgs[*arg.Name]; !ok {
 			jobArgs[*arg.Name] = *arg.Default
+			argSources[*arg.Name] = proto.ArgSource{Source: proto.ARG_SOURCE_DEFAULT, Sequence: seqName}
+		}
+	}
+	// Derived args are only in the request sequence (checked in spec), and
+	// RequestArgs checked that they can be derived
+	for _, arg := range seq.Args.Derived {
+		val, err := arg.Derive(jobArgs)
+		if err != nil {
+			return nil, fmt.Errorf("sequence %s: cannot derive arg '%s': %s", seqName, *arg.Name, err)
+		}
+		jobArgs[*arg.Name] = val
+		argSources[*arg.Name] = proto.ArgSource{Source: proto.ARG_SOURCE_COMPUTED, Sequence: seqName}
+	}
+
+	// Globals are set once from the request sequence, which is built first,
+	// before any job is created. A global is the request arg of the same name,
+	// else its static value (default).
+	if r.globals == nil && len(seq.Globals) > 0 {
+		r.globals = map[string]interface{}{}
+		for _, g := range seq.Globals {
+			if val, ok := jobArgs[*g.Name]; ok {
+				r.globals[*g.Name] = val
+			} else {
+				r.globals[*g.Name] = *g.Default // checked in spec
+			}
 		}
 	}
 
@@ -221,6 +312,7 @@ func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 		// Then, after this `for` loop, we'll wrap all seqExpansion graphs
 		// in a new graph so they have a single source and sink node.
 		expandedSeqs := []*Graph{}
+		groupKeys := []string{} // groupBy value of each expanded sequence
 
 		// If no repetition is needed, this loop will only execute once
 		// with a dummy `each:` entry `[""]:nil`.
@@ -231,7 +323,7 @@ func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 
 			// Copy the required args into a separate args map here
 			// and do the necessary remapping
-			jobArgsCopy, err := remapNodeArgs(nodeSpec, jobArgs)
+			jobArgsCopy, argSourcesCopy, err := remapNodeArgs(nodeSpec, jobArgs, argSources)
 			if err != nil {
 				return nil, err
 			}
@@ -239,12 +331,29 @@ func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 			// Given "each: foos:foo", we add jobArgs[foo] = jobArgs[foos][i],
 			// unless this is the dummy `each:` that ensures that we
 			// run this loop exactly once, in which case elt == nil.
+			// If foo is a map, its fields are also added: jobArgs[foo.bar].
 			for j, elt := range elements {
 				if elt != nil {
 					// This won't panic because we have earlier asserted that
 					// len(elements) == len(lists)
-					jobArgsCopy[*elt] = lists[j][i]
+					bindElement(jobArgsCopy, *elt, lists[j][i])
+					bindElementSource(argSourcesCopy, *elt, lists[j][i], proto.ArgSource{
+						Source:   proto.ARG_SOURCE_EACH,
+						Sequence: seqName,
+						Node:     nodeSpec.Name,
+						Arg:      strings.SplitN(nodeSpec.Each[j], ":", 2)[0],
+					})
+				}
+			}
+
+			// Given "groupBy: foo.zone", expanded sequences with the same
+			// zone are in the same group.
+			if nodeSpec.GroupBy != "" {
+				key, ok := jobArgsCopy[nodeSpec.GroupBy]
+				if !ok {
+					return nil, fmt.Errorf("in seq %s, node %s: groupBy %s not set for element %d", seqName, nodeSpec.Name, nodeSpec.GroupBy, i)
 				}
+				groupKeys = append(groupKeys, fmt.Sprint(key))
 			}
 
 			// If this is a conditional node, add the "if" job arg.
@@ -252,6 +361,7 @@ func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 			if nodeSpec.IsConditional() {
 				if ifArg, ok := jobArgs[*nodeSpec.If]; ok {
 					jobArgsCopy[*nodeSpec.If] = ifArg
+					argSourcesCopy[*nodeSpec.If] = argSources[*nodeSpec.If]
 				}
 			}
 
@@ -270,8 +380,11 @@ func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 					graphName:    "conditional_" + nodeSpec.Name,
 					seqName:      conditional,
 					jobArgs:      jobArgsCopy,
+					argSources:   argSourcesCopy,
 					seqRetry:     nodeSpec.Retry,
 					seqRetryWait: nodeSpec.RetryWait,
+					seqSkippable: nodeSpec.Skippable,
+					seqDesc:      nodeSpec.Desc,
 				}
 				reqSubgraph, err = r.buildSequence(cfg)
 				if err != nil {
@@ -283,8 +396,11 @@ func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 					graphName:    "sequence_" + nodeSpec.Name,
 					seqName:      *nodeSpec.NodeType,
 					jobArgs:      jobArgsCopy,
+					argSources:   argSourcesCopy,
 					seqRetry:     nodeSpec.Retry,
 					seqRetryWait: nodeSpec.RetryWait,
+					seqSkippable: nodeSpec.Skippable,
+					seqDesc:      nodeSpec.Desc,
 				}
 				reqSubgraph, err = r.buildSequence(cfg)
 				if err != nil {
@@ -293,7 +409,7 @@ func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 			} else {
 				// Node is a job: create the proto.Job and put
 				// it in a graph
-				reqSubgraph, err = r.buildSingleVertexGraph(nodeSpec, jobArgsCopy)
+				reqSubgraph, err = r.buildSingleVertexGraph(nodeSpec, jobArgsCopy, argSourcesCopy)
 				if err != nil {
 					return nil, fmt.Errorf("in seq %s, node %s: cannot build job: %s", seqName, nodeSpec.Name, err)
 				}
@@ -303,7 +419,7 @@ func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 
 			// If the node or sequence was determined to set any args
 			// copy them from jobArgsCopy into the main jobArgs
-			if err := setNodeArgs(nodeSpec, jobArgs, jobArgsCopy); err != nil {
+			if err := setNodeArgs(nodeSpec, jobArgs, jobArgsCopy, argSources, argSourcesCopy); err != nil {
 				return nil, err
 			}
 		} // End loop over lists
@@ -325,6 +441,12 @@ func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 			// Serialize parallel expansions if number of expanded
 			// sequences exceeds `parallel`.
 			// Each parallel expansion is wrapped between dummy nodes.
+			// With `groupBy`, parallel expansions are per group, so
+			// groups (e.g. zones) run one at a time.
+			groups := [][]*Graph{expandedSeqs}
+			if nodeSpec.GroupBy != "" {
+				groups = groupExpansions(expandedSeqs, groupKeys)
+			}
 			var parallel uint
 			if nodeSpec.Parallel == nil {
 				parallel = uint(len(expandedSeqs))
@@ -339,21 +461,47 @@ func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 
 			prev := wrappedReqSubgraph.Source
 			var count uint = 0
-			for _, c := range expandedSeqs {
-				currG.InsertComponentBetween(c, currG.Source, currG.Sink)
-				count++
-				if count == parallel {
+			for g, group := range groups {
+				for _, c := range group {
+					currG.InsertComponentBetween(c, currG.Source, currG.Sink)
+					count++
+					if count == parallel {
+						wrappedReqSubgraph.InsertComponentBetween(currG, prev, wrappedReqSubgraph.Sink)
+						prev = currG.Sink
+						currG, err = r.newReqGraph("repeat_"+nodeSpec.Name, jobArgs)
+						if err != nil {
+							return nil, err
+						}
+						count = 0
+					}
+				}
+				if count != 0 {
 					wrappedReqSubgraph.InsertComponentBetween(currG, prev, wrappedReqSubgraph.Sink)
-					prev = currG.Sink
-					currG, err = r.newReqGraph("repeat_"+nodeSpec.Name, jobArgs)
-					if err != nil {
-						return nil, err
+					if g < len(groups)-1 {
+						prev = currG.Sink
+						currG, err = r.newReqGraph("repeat_"+nodeSpec.Name, jobArgs)
+						if err != nil {
+							return nil, err
+						}
+						count = 0
 					}
-					count = 0
 				}
 			}
-			if count != 0 {
-				wrappedReqSubgraph.InsertComponentBetween(currG, prev, wrappedReqSubgraph.Sink)
+
+			// With `pace`, the Job Runner paces starting expanded
+			// sequences (branches) when their jobs report throttling.
+			// Jobs in a nested paced expansion keep its pace.
+			if nodeSpec.Pace {
+				paceId := wrappedReqSubgraph.Source.Id
+				for _, c := range expandedSeqs {
+					for _, node := range c.Nodes {
+						if node.Pace == "" {
+							node.Pace = paceId
+						}
+					}
+					c.Source.Pace = paceId
+					c.Source.PaceStart = true
+				}
 			}
 		} else if len(expandedSeqs) == 1 {
 			wrappedReqSubgraph = expandedSeqs[0]
@@ -402,21 +550,119 @@ func (r *resolver) buildSequence(cfg buildSequenceConfig) (*Graph, error) {
 	// by a recursive call to buildSequence. That is, that node is part of a
 	// subsequence of this sequence. We don't want to overwrite that sequence
 	// ID.
+	// Likewise, nodes without a description get the description of the
+	// innermost sequence that has one, so operators see what a job is doing.
 	seqId := reqGraph.Source.Id
+	seqDesc := cfg.seqDesc
+	if seqDesc == "" {
+		seqDesc = seq.Desc
+	}
 	for _, node := range reqGraph.Nodes {
 		// Don't overwrite subsequence's sequence IDs.
 		if node.SequenceId == "" {
 			node.SequenceId = seqId
 		}
+		if node.Desc == "" {
+			node.Desc = seqDesc
+		}
 	}
 
 	// Store configured retry from sequence spec on the first node in the
 	// sequence.
 	reqGraph.Source.SequenceRetry = cfg.seqRetry
 	reqGraph.Source.SequenceRetryWait = cfg.seqRetryWait
+	reqGraph.Source.SequenceSkippable = cfg.seqSkippable
+
+	// Assertions are checked by the JR when the last node in the sequence
+	// completes, over its job data and the jobArgs they use, which are saved
+	// as its args now that every node in the sequence has set its args
+	if len(seq.Asserts) > 0 {
+		sink := reqGraph.Sink
+		sink.Asserts = seq.Asserts
+		for _, a := range seq.Asserts {
+			e, err := expr.Parse(a)
+			if err != nil {
+				return nil, fmt.Errorf("sequence %s: %s", seqName, err)
+			}
+			for _, name := range e.Vars() {
+				val, ok := jobArgs[name]
+				if !ok {
+					continue // set by a job at runtime
+				}
+				if sink.Args == nil {
+					sink.Args = map[string]interface{}{}
+				}
+				sink.Args[name] = val
+			}
+		}
+	}
+
+	// Webhooks are sent the values of their args now that every node in the
+	// sequence has been built and set its args
+	var jobIds []string
+	if len(seq.Webhooks) > 0 || len(seq.Escalations) > 0 {
+		jobIds = make([]string, 0, len(reqGraph.Nodes))
+		for id := range reqGraph.Nodes {
+			jobIds = append(jobIds, id)
+		}
+		sort.Strings(jobIds)
+	}
+	if len(seq.Webhooks) > 0 {
+		for _, wh := range seq.Webhooks {
+			var args map[string]interface{}
+			for _, name := range wh.Args {
+				val, ok := jobArgs[name]
+				if !ok {
+					continue
+				}
+				if args == nil {
+					args = map[string]interface{}{}
+				}
+				args[name] = val
+			}
+			r.webhooks = append(r.webhooks, proto.SequenceWebhook{
+				URL:        wh.URL,
+				Events:     wh.Events,
+				Sequence:   seqName,
+				StartJobId: reqGraph.Source.Id,
+				EndJobId:   reqGraph.Sink.Id,
+				JobIds:     jobIds,
+				Args:       args,
+			})
+		}
+	}
+	for i, esc := range seq.Escalations {
+		r.escalations = append(r.escalations, proto.SequenceEscalation{
+			URL:        esc.URL,
+			After:      esc.After,
+			Step:       uint(i + 1),
+			Sequence:   seqName,
+			StartJobId: reqGraph.Source.Id,
+			JobIds:     jobIds,
+		})
+	}
+
 	return reqGraph, nil
 }
 
+// groupExpansions groups expanded sequences by their groupBy value (keys[i] is
+// the value of expandedSeqs[i]). Groups are in order of the first sequence
+// in each group, and sequences keep their order within a group.
+func groupExpansions(expandedSeqs []*Graph, keys []string) [][]*Graph {
+	groups := [][]*Graph{}
+	index := map[string]int{} // key -> groups index
+	for i, g := range expandedSeqs {
+		n, ok := index[keys[i]]
+		if !ok {
+			n = len(groups)
+			index[keys[i]] = n
+			groups = append(groups, []*Graph{})
+		}
+		groups[n] = append(groups[n], g)
+	}
+	return groups
+}
+
 // chooseConditional determines which path of a conditional to take
 // based on the value of the job args.
 // Assumes `n` is a conditional node.
@@ -510,25 +756,64 @@ func (r *resolver) getIterators(n *spec.Node, args map[string]interface{}) ([]*s
 	return elements, lists, nil
 }
 
+// bindElement sets jobArgs[element] = value. If value is a map, like an element
+// from a list of objects, every field is also set as jobArgs[element.field], so
+// "each: shards:shard" with shards = [{"id": 1, "host": "h1"}, ...] sets shard,
+// shard.id, and shard.host. Only top-level fields are bound.
+func bindElement(jobArgs map[string]interface{}, element string, value interface{}) {
+	jobArgs[element] = value
+	if value == nil {
+		return
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Map {
+		return
+	}
+	iter := v.MapRange()
+	for iter.Next() {
+		jobArgs[fmt.Sprintf("%s.%v", element, iter.Key().Interface())] = iter.Value().Interface()
+	}
+}
+
+// bindElementSource sets the source of every arg that bindElement sets.
+func bindElementSource(argSources map[string]proto.ArgSource, element string, value interface{}, src proto.ArgSource) {
+	argSources[element] = src
+	if value == nil {
+		return
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Map {
+		return
+	}
+	for _, key := range v.MapKeys() {
+		argSources[fmt.Sprintf("%s.%v", element, key.Interface())] = src
+	}
+}
+
 // remapeNodeArgs copies args into a new map and renames the arguments
-// as defined in the "args" clause.
+// as defined in the "args" clause. Arg sources are copied and renamed likewise.
 // A shallow copy is sufficient because args values should never
 // change.
-func remapNodeArgs(n *spec.Node, args map[string]interface{}) (map[string]interface{}, error) {
+func remapNodeArgs(n *spec.Node, args map[string]interface{}, argSources map[string]proto.ArgSource) (map[string]interface{}, map[string]proto.ArgSource, error) {
 	jobArgs2 := map[string]interface{}{}
+	argSources2 := map[string]proto.ArgSource{}
 	for _, arg := range n.Args {
 		var ok bool
 		jobArgs2[*arg.Expected], ok = args[*arg.Given]
 		if !ok {
-			return nil, fmt.Errorf("cannot create job %s: missing %s from job args", *n.NodeType, *arg.Given)
+			return nil, nil, fmt.Errorf("cannot create job %s: missing %s from job args", *n.NodeType, *arg.Given)
+		}
+		if src, ok := argSources[*arg.Given]; ok {
+			argSources2[*arg.Expected] = src
 		}
 	}
-	return jobArgs2, nil
+	return jobArgs2, argSources2, nil
 }
 
 // setNodeArgs copies the args defined in the `sets -> arg` clause into the main
-// args map under the name defined by the `sets -> as` field.
-func setNodeArgs(n *spec.Node, argsTo, argsFrom map[string]interface{}) error {
+// args map under the name defined by the `sets -> as` field. Arg sources are
+// copied likewise.
+func setNodeArgs(n *spec.Node, argsTo, argsFrom map[string]interface{}, sourcesTo, sourcesFrom map[string]proto.ArgSource) error {
 	if len(n.Sets) == 0 {
 		return nil
 	}
@@ -540,14 +825,17 @@ func setNodeArgs(n *spec.Node, argsTo, argsFrom map[string]interface{}) error {
 			return fmt.Errorf("expected %s to set %s in jobargs", *n.NodeType, *key.Arg)
 		}
 		argsTo[*key.As] = val
+		if src, ok := sourcesFrom[*key.Arg]; ok {
+			sourcesTo[*key.As] = src
+		}
 	}
 
 	return nil
 }
 
 // buildSingleVertexGraph builds a graph containing a single node.
-func (r *resolver) buildSingleVertexGraph(nodeDef *spec.Node, jobArgs map[string]interface{}) (*Graph, error) {
-	n, err := r.newNode(nodeDef, jobArgs)
+func (r *resolver) buildSingleVertexGraph(nodeDef *spec.Node, jobArgs map[string]interface{}, argSources map[string]proto.ArgSource) (*Graph, error) {
+	n, err := r.newNode(nodeDef, jobArgs, argSources)
 	if err != nil {
 		return nil, err
 	}
@@ -566,7 +854,8 @@ func (r *resolver) buildSingleVertexGraph(nodeDef *spec.Node, jobArgs map[string
 func (r *resolver) newReqGraph(name string, jobArgs map[string]interface{}) (*Graph, error) {
 	var err error
 
-	jobArgsCopy, err := remapNodeArgs(&spec.NoopNode, jobArgs)
+	// Noop nodes have no args and set none, so there are no arg sources
+	jobArgsCopy, _, err := remapNodeArgs(&spec.NoopNode, jobArgs, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -581,7 +870,7 @@ func (r *resolver) newReqGraph(name string, jobArgs map[string]interface{}) (*Gr
 		return nil, err
 	}
 
-	if err := setNodeArgs(&spec.NoopNode, jobArgs, jobArgsCopy); err != nil {
+	if err := setNodeArgs(&spec.NoopNode, jobArgs, jobArgsCopy, nil, nil); err != nil {
 		return nil, err
 	}
 
@@ -614,6 +903,9 @@ func (r *resolver) newNoopNode(name string, jobArgs map[string]interface{}) (*No
 			return nil, fmt.Errorf("Error making no-op job %s: %s", name, err)
 		}
 	}
+	if gj, ok := rj.(job.UsesGlobals); ok {
+		gj.SetGlobals(copyGlobals(r.globals))
+	}
 	if err := rj.Create(jobArgs); err != nil {
 		return nil, fmt.Errorf("Error creating no-op job %s: %s", name, err)
 	}
@@ -631,13 +923,18 @@ func (r *resolver) newNoopNode(name string, jobArgs map[string]interface{}) (*No
 }
 
 // newNode creates job described by node specs `j` and puts it in a node.
-func (r *resolver) newNode(j *spec.Node, jobArgs map[string]interface{}) (*Node, error) {
+// Args that the job sets or changes are recorded in argSources as set by the job.
+func (r *resolver) newNode(j *spec.Node, jobArgs map[string]interface{}, argSources map[string]proto.ArgSource) (*Node, error) {
 	// Make a copy of the jobArgs before this node gets created and potentially
 	// adds additional keys to the jobArgs. A shallow copy is sufficient because
 	// args values should never change.
 	originalArgs := map[string]interface{}{}
+	originalSources := map[string]proto.ArgSource{}
 	for k, v := range jobArgs {
 		originalArgs[k] = v
+		if src, ok := argSources[k]; ok {
+			originalSources[k] = src
+		}
 	}
 
 	// Make the name of this node unique within the request by assigning it an id.
@@ -652,22 +949,80 @@ func (r *resolver) newNode(j *spec.Node, jobArgs map[string]interface{}) (*Node,
 		return nil, fmt.Errorf("Error making '%s %s' job: %s", *j.NodeType, j.Name, err)
 	}
 
+	if gj, ok := rj.(job.UsesGlobals); ok {
+		gj.SetGlobals(copyGlobals(r.globals))
+	}
 	if err := rj.Create(jobArgs); err != nil {
 		return nil, fmt.Errorf("Error creating '%s %s' job: %s", *j.NodeType, j.Name, err)
 	}
+	for k, v := range jobArgs {
+		if orig, ok := originalArgs[k]; ok && reflect.DeepEqual(orig, v) {
+			continue
+		}
+		argSources[k] = proto.ArgSource{
+			Source: proto.ARG_SOURCE_JOB,
+			Node:   j.Name,
+			JobId:  id,
+			Arg:    k,
+		}
+	}
 
 	bytes, err := rj.Serialize()
 	if err != nil {
 		return nil, fmt.Errorf("Error serializing '%s %s' job: %s", *j.NodeType, j.Name, err)
 	}
 
+	var retryArgs map[string]interface{}
+	if len(j.RetryArgs) > 0 {
+		retryArgs = map[string]interface{}{}
+		for k, v := range j.RetryArgs {
+			retryArgs[k] = v
+		}
+	}
+
+	// Singleton lock name: job type, or job type and key arg value to allow
+	// one job per value (e.g. one per host) to run at a time
+	var singleton, singletonPolicy string
+	if j.Singleton {
+		singleton = *j.NodeType
+		if j.SingletonKey != "" {
+			val, ok := originalArgs[j.SingletonKey]
+			if !ok {
+				return nil, fmt.Errorf("Error making '%s %s' job: singletonKey arg %s not set", *j.NodeType, j.Name, j.SingletonKey)
+			}
+			singleton += "/" + fmt.Sprintf("%v", val)
+		}
+		singletonPolicy = j.SingletonPolicy
+		if singletonPolicy == "" {
+			singletonPolicy = proto.SINGLETON_POLICY_QUEUE
+		}
+	}
+
 	return &Node{
-		Name:      j.Name,
-		Id:        id,
-		Spec:      j, // on the next refactor, we shouldn't need to set this ourselves
-		JobBytes:  bytes,
-		Args:      originalArgs, // Args is the jobArgs map that this node was created with
-		Retry:     j.Retry,
-		RetryWait: j.RetryWait,
+		Name:            j.Name,
+		Desc:            j.Desc,
+		Id:              id,
+		Spec:            j, // on the next refactor, we shouldn't need to set this ourselves
+		JobBytes:        bytes,
+		Args:            originalArgs, // Args is the jobArgs map that this node was created with
+		ArgSources:      originalSources,
+		Retry:           j.Retry,
+		RetryWait:       j.RetryWait,
+		RetryArgs:       retryArgs,
+		KeepData:        j.KeepData,
+		Singleton:       singleton,
+		SingletonPolicy: singletonPolicy,
+		Needs:           j.Needs,
+		Finally:         j.Finally,
 	}, nil
 }
+
+// copyGlobals returns a copy of globals so a job cannot change them for other
+// jobs. It returns an empty map if there are no globals.
+func copyGlobals(globals map[string]interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(globals))
+	for k, v := range globals {
+		cp[k] = v
+	}
+	return cp
+}
@@ -4,6 +4,7 @@ package graph_test
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"testing"
 
@@ -236,6 +237,7 @@ func TestSequenceRetry(t *testing.T) {
 
 	// Verify that the sequence retries are set correctly on all nodes.
 	// Only the "sequence_decommission-cluster_begin" node should have retries.
+	// It's also the only skippable node.
 	found := false
 	sequenceStartNodeName := "sequence_pre-flight-checks_begin"
 	for _, node := range reqGraph.Nodes {
@@ -244,10 +246,16 @@ func TestSequenceRetry(t *testing.T) {
 			if node.SequenceRetry != 3 {
 				t.Errorf("%s node sequence retries = %d, expected %d", node.Name, node.SequenceRetry, 2)
 			}
+			if !node.SequenceSkippable {
+				t.Errorf("%s node not skippable, expected skippable", node.Name)
+			}
 		} else {
 			if node.SequenceRetry != 0 {
 				t.Errorf("%s node sequence retries = %d, expected %d", node.Name, node.SequenceRetry, 0)
 			}
+			if node.SequenceSkippable {
+				t.Errorf("%s node skippable, expected not skippable", node.Name)
+			}
 		}
 	}
 	if !found {
@@ -1243,3 +1251,577 @@ func createDestroy2(args map[string]interface{}) error {
 func createEndNode(args map[string]interface{}) error {
 	return nil
 }
+
+func TestEachObject(t *testing.T) {
+	sequencesFile := "each-object.yaml"
+	requestName := "each-object"
+	args := map[string]interface{}{
+		"cluster": "foo",
+	}
+
+	job := &mock.Job{
+		SetJobArgs: map[string]interface{}{
+			// Each element is an object, so each: shards:shard binds shard.id
+			// and shard.host for every element
+			"shards": []map[string]interface{}{
+				{"id": 1, "host": "host1"},
+				{"id": 2, "host": "host2"},
+			},
+		},
+	}
+	tf := &mock.JobFactory{
+		MockJobs: map[string]*mock.Job{
+			"get-shards": job,
+		},
+	}
+
+	reqGraph, err := createGraph1(t, sequencesFile, requestName, args, tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[interface{}]interface{}{}
+	for _, node := range reqGraph.Nodes {
+		if node.Name != "check-shard" {
+			continue
+		}
+		got[node.Args["id"]] = node.Args["host"]
+	}
+	expect := map[interface{}]interface{}{
+		1: "host1",
+		2: "host2",
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Logf("%#v", reqGraph.Nodes)
+		t.Error(diff)
+	}
+}
+
+func TestArgSources(t *testing.T) {
+	sequencesFile := "each-object.yaml"
+	requestName := "each-object"
+	args := map[string]interface{}{
+		"cluster": "foo",
+	}
+	job := &mock.Job{
+		SetJobArgs: map[string]interface{}{
+			"shards": []map[string]interface{}{
+				{"id": 1, "host": "host1"},
+			},
+		},
+	}
+	tf := &mock.JobFactory{
+		MockJobs: map[string]*mock.Job{
+			"get-shards": job,
+		},
+	}
+
+	reqGraph, err := createGraph1(t, sequencesFile, requestName, args, tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, node := range reqGraph.Nodes {
+		switch node.Name {
+		case "get-shards":
+			expect := map[string]proto.ArgSource{
+				"cluster": {Source: proto.ARG_SOURCE_GIVEN},
+			}
+			if diff := deep.Equal(node.ArgSources, expect); diff != nil {
+				t.Errorf("get-shards: %v", diff)
+			}
+		case "check-shard":
+			// id and host are renamed from shard.id and shard.host, which are
+			// bound from an element of shards, which get-shards set
+			each := proto.ArgSource{
+				Source:   proto.ARG_SOURCE_EACH,
+				Sequence: "each-object",
+				Node:     "check-shards",
+				Arg:      "shards",
+			}
+			expect := map[string]proto.ArgSource{
+				"id":   each,
+				"host": each,
+			}
+			if diff := deep.Equal(node.ArgSources, expect); diff != nil {
+				t.Errorf("check-shard: %v", diff)
+			}
+		}
+	}
+}
+
+// globalsJobFactory makes a new GlobalsJob for every job
+type globalsJobFactory struct {
+	jobs map[string]*mock.GlobalsJob // keyed on type
+}
+
+func (f *globalsJobFactory) Make(jid job.Id) (job.Job, error) {
+	j := &mock.GlobalsJob{Job: mock.Job{IdResp: jid}}
+	if jid.Type == "get-hosts" {
+		j.SetJobArgs = map[string]interface{}{"hosts": []string{"host1"}}
+	}
+	f.jobs[jid.Type] = j
+	return j, nil
+}
+
+func TestGlobals(t *testing.T) {
+	sequencesFile := "globals.yaml"
+	requestName := "globals"
+	args := map[string]interface{}{
+		"cluster": "foo",
+		"region":  "us-west-2",
+	}
+	tf := &globalsJobFactory{jobs: map[string]*mock.GlobalsJob{}}
+
+	specs, result := spec.ParseSpec(rmtest.SpecPath + "/" + sequencesFile)
+	if len(result.Errors) != 0 {
+		t.Fatal(result.Errors)
+	}
+	spec.ProcessSpecs(&specs)
+	gr := NewGrapher(specs, id.NewGeneratorFactory(4, 100))
+	seqGraphs, seqResults := gr.CheckSequences()
+	if seqResults.AnyError {
+		t.Fatalf("failed to create sequence graphs: %v", seqResults)
+	}
+	rf := NewResolverFactory(tf, specs.Sequences, seqGraphs, id.NewGeneratorFactory(4, 100))
+	resolver := rf.Make(proto.Request{Id: "reqABC", Type: requestName})
+	if _, err := resolver.BuildRequestGraph(args); err != nil {
+		t.Fatal(err)
+	}
+
+	// Request arg value, not default, and static value
+	expect := map[string]interface{}{
+		"region": "us-west-2",
+		"team":   "dba",
+	}
+	if diff := deep.Equal(resolver.Globals(), expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Every job gets the globals, including jobs in subsequences, without
+	// listing them in its args
+	for _, jobType := range []string{"get-hosts", "check-host"} {
+		j, ok := tf.jobs[jobType]
+		if !ok {
+			t.Fatalf("job %s not created", jobType)
+		}
+		if diff := deep.Equal(j.Globals, expect); diff != nil {
+			t.Errorf("%s: %v", jobType, diff)
+		}
+		if _, ok := j.CreatedWithArgs["region"]; jobType == "check-host" && ok {
+			t.Errorf("%s created with job arg region, expected only globals", jobType)
+		}
+	}
+
+	// Each job gets a copy
+	tf.jobs["get-hosts"].Globals["team"] = "changed"
+	if resolver.Globals()["team"] != "dba" {
+		t.Errorf("job changed globals")
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	sequencesFile := "webhooks.yaml"
+	requestName := "webhooks"
+	args := map[string]interface{}{
+		"cluster": "foo",
+	}
+	tf := &mock.JobFactory{
+		MockJobs: map[string]*mock.Job{
+			"get-hosts":  &mock.Job{SetJobArgs: map[string]interface{}{"hosts": []string{"h1", "h2"}}},
+			"check-host": &mock.Job{SetJobArgs: map[string]interface{}{"checked": true}},
+		},
+	}
+
+	specs, result := spec.ParseSpec(rmtest.SpecPath + "/" + sequencesFile)
+	if len(result.Errors) != 0 {
+		t.Fatal(result.Errors)
+	}
+	spec.ProcessSpecs(&specs)
+	gr := NewGrapher(specs, id.NewGeneratorFactory(4, 100))
+	seqGraphs, seqResults := gr.CheckSequences()
+	if seqResults.AnyError {
+		t.Fatalf("failed to create sequence graphs: %v", seqResults)
+	}
+	rf := NewResolverFactory(tf, specs.Sequences, seqGraphs, id.NewGeneratorFactory(4, 100))
+	resolver := rf.Make(proto.Request{Id: "reqABC", Type: requestName})
+	reqGraph, err := resolver.BuildRequestGraph(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One webhook per check-host sequence, with its own args and jobs
+	webhooks := resolver.Webhooks()
+	if len(webhooks) != 2 {
+		t.Fatalf("got %d webhooks, expected 2: %+v", len(webhooks), webhooks)
+	}
+	hosts := map[string]bool{}
+	for _, wh := range webhooks {
+		host, _ := wh.Args["host"].(string)
+		hosts[host] = true
+		expectArgs := map[string]interface{}{"host": host, "checked": true}
+		if diff := deep.Equal(wh.Args, expectArgs); diff != nil {
+			t.Error(diff)
+		}
+		if wh.Sequence != "check-host" || wh.URL != "https://hosts.example.com/events" {
+			t.Errorf("got sequence %s, url %s, expected check-host and spec url", wh.Sequence, wh.URL)
+		}
+		if diff := deep.Equal(wh.Events, []string{"complete", "fail"}); diff != nil {
+			t.Error(diff)
+		}
+		checkHostJobs := 0
+		for _, jobId := range wh.JobIds {
+			node, ok := reqGraph.Nodes[jobId]
+			if !ok {
+				t.Errorf("job %s not in request graph", jobId)
+				continue
+			}
+			if node.Name == "check-host" {
+				checkHostJobs++
+				if node.Args["host"] != host {
+					t.Errorf("job %s has host %v, expected %s", jobId, node.Args["host"], host)
+				}
+			}
+		}
+		if checkHostJobs != 1 {
+			t.Errorf("got %d check-host jobs in sequence, expected 1", checkHostJobs)
+		}
+		if len(reqGraph.Edges[wh.EndJobId]) != 1 || reqGraph.Nodes[wh.StartJobId].SequenceId != wh.StartJobId {
+			t.Errorf("start job %s and end job %s are not the first and last jobs of the sequence", wh.StartJobId, wh.EndJobId)
+		}
+	}
+	if !hosts["h1"] || !hosts["h2"] {
+		t.Errorf("got webhooks for hosts %v, expected h1 and h2", hosts)
+	}
+
+	// Escalations of the request sequence, in order, with every job in the request
+	escalations := resolver.Escalations()
+	if len(escalations) != 2 {
+		t.Fatalf("got %d escalations, expected 2: %+v", len(escalations), escalations)
+	}
+	for i, url := range []string{"https://pager.example.com/oncall", "https://pager.example.com/manager"} {
+		esc := escalations[i]
+		if esc.URL != url || esc.Step != uint(i+1) || esc.Sequence != requestName || esc.StartJobId != reqGraph.Source.Id {
+			t.Errorf("got escalation %+v, expected url %s, step %d of sequence %s", esc, url, i+1, requestName)
+		}
+		if len(esc.JobIds) != len(reqGraph.Nodes) {
+			t.Errorf("escalation has %d jobs, expected all %d jobs in request", len(esc.JobIds), len(reqGraph.Nodes))
+		}
+	}
+}
+
+func TestDerivedArgs(t *testing.T) {
+	specs, result := spec.ParseSpec(rmtest.SpecPath + "/derived-args.yaml")
+	if len(result.Errors) != 0 {
+		t.Fatal(result.Errors)
+	}
+	spec.ProcessSpecs(&specs)
+	gr := NewGrapher(specs, id.NewGeneratorFactory(4, 100))
+	seqGraphs, seqResults := gr.CheckSequences()
+	if seqResults.AnyError {
+		t.Fatalf("failed to create sequence graphs: %v", seqResults)
+	}
+	rf := NewResolverFactory(&testFactory{}, specs.Sequences, seqGraphs, id.NewGeneratorFactory(4, 100))
+	resolver := rf.Make(proto.Request{Id: "reqABC", Type: "derived-args"})
+
+	args := map[string]interface{}{
+		"host":  "db2",
+		"hosts": []interface{}{"db1", "db2", "db3", "db4"},
+	}
+	reqArgs, err := resolver.RequestArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectArgs := []proto.RequestArg{
+		{Pos: 0, Name: "host", Type: proto.ARG_TYPE_REQUIRED, Value: "db2", Given: true},
+		{Pos: 1, Name: "hosts", Type: proto.ARG_TYPE_REQUIRED, Value: args["hosts"], Given: true},
+		{Pos: 0, Name: "domain", Type: proto.ARG_TYPE_OPTIONAL, Value: "example.com", Default: "example.com"},
+		{Pos: 0, Name: "dc", Type: proto.ARG_TYPE_DERIVED, Value: "west"},
+		{Pos: 1, Name: "fqdn", Type: proto.ARG_TYPE_DERIVED, Value: "db2.west.example.com"},
+		{Pos: 2, Name: "batchSize", Type: proto.ARG_TYPE_DERIVED, Value: float64(2)},
+	}
+	if diff := deep.Equal(reqArgs, expectArgs); diff != nil {
+		t.Error(diff)
+	}
+
+	reqGraph, err := resolver.BuildRequestGraph(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, node := range reqGraph.Nodes {
+		if node.Name != "restart-host" {
+			continue
+		}
+		found = true
+		if node.Args["fqdn"] != "db2.west.example.com" || node.Args["batchSize"] != float64(2) {
+			t.Errorf("got job args %v, expected fqdn=db2.west.example.com, batchSize=2", node.Args)
+		}
+		expect := proto.ArgSource{Source: proto.ARG_SOURCE_COMPUTED, Sequence: "derived-args"}
+		if diff := deep.Equal(node.ArgSources["fqdn"], expect); diff != nil {
+			t.Error(diff)
+		}
+	}
+	if !found {
+		t.Error("restart-host job not in request graph")
+	}
+
+	// Derived args cannot be given
+	args["fqdn"] = "db2.example.com"
+	if _, err := resolver.RequestArgs(args); err == nil {
+		t.Error("no error for given derived arg, expected one")
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	sequencesFile := "group-by.yaml"
+	requestName := "group-by"
+	args := map[string]interface{}{
+		"cluster": "foo",
+	}
+
+	job := &mock.Job{
+		SetJobArgs: map[string]interface{}{
+			"hosts": []map[string]interface{}{
+				{"name": "h1", "zone": "a"},
+				{"name": "h2", "zone": "b"},
+				{"name": "h3", "zone": "a"},
+				{"name": "h4", "zone": "b"},
+				{"name": "h5", "zone": "a"},
+			},
+		},
+	}
+	tf := &mock.JobFactory{
+		MockJobs: map[string]*mock.Job{
+			"get-hosts": job,
+		},
+	}
+
+	reqGraph, err := createGraph1(t, sequencesFile, requestName, args, tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[string]string{} // host name -> check-host node id
+	for _, node := range reqGraph.Nodes {
+		if node.Name == "check-host" {
+			ids[node.Args["name"].(string)] = node.Id
+		}
+	}
+	if len(ids) != 5 {
+		t.Fatalf("got %d check-host nodes, expected 5: %v", len(ids), ids)
+	}
+
+	// Zone a runs first (first host is in zone a), at most 2 in parallel:
+	// h1 + h3, then h5. Then zone b: h2 + h4.
+	before := [][2]string{
+		{"h1", "h5"}, {"h3", "h5"},
+		{"h5", "h2"}, {"h5", "h4"},
+	}
+	for _, b := range before {
+		if !reaches(reqGraph, ids[b[0]], ids[b[1]]) {
+			t.Errorf("%s does not run before %s", b[0], b[1])
+		}
+	}
+	parallel := [][2]string{
+		{"h1", "h3"}, {"h2", "h4"},
+	}
+	for _, p := range parallel {
+		if reaches(reqGraph, ids[p[0]], ids[p[1]]) || reaches(reqGraph, ids[p[1]], ids[p[0]]) {
+			t.Errorf("%s and %s do not run in parallel", p[0], p[1])
+		}
+	}
+}
+
+func TestPace(t *testing.T) {
+	args := map[string]interface{}{
+		"cluster": "foo",
+	}
+	job := &mock.Job{
+		SetJobArgs: map[string]interface{}{
+			"hosts": []string{"h1", "h2", "h3"},
+		},
+	}
+	tf := &mock.JobFactory{
+		MockJobs: map[string]*mock.Job{
+			"get-hosts": job,
+		},
+	}
+	reqGraph, err := createGraph1(t, "pace.yaml", "pace", args, tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every job in the 3 expanded sequences is in the same paced fan-out,
+	// and the first job of each sequence is started at its pace
+	pace := ""
+	starts := 0
+	for _, node := range reqGraph.Nodes {
+		switch node.Name {
+		case "get-hosts":
+			if node.Pace != "" || node.PaceStart {
+				t.Errorf("get-hosts pace %q (start %t), expected none", node.Pace, node.PaceStart)
+			}
+			continue
+		case "stop-app", "start-app":
+			if node.PaceStart {
+				t.Errorf("%s is a pace start, expected only the first job of each sequence", node.Name)
+			}
+		}
+		if node.Pace == "" {
+			if node.SequenceId != reqGraph.Source.Id {
+				t.Errorf("%s (%s) not paced", node.Name, node.Id)
+			}
+			continue
+		}
+		if pace == "" {
+			pace = node.Pace
+		} else if node.Pace != pace {
+			t.Errorf("%s pace %q, expected %q", node.Name, node.Pace, pace)
+		}
+		if node.PaceStart {
+			starts++
+		}
+	}
+	if pace == "" {
+		t.Fatal("no paced jobs")
+	}
+	if starts != 3 {
+		t.Errorf("%d pace starts, expected 3", starts)
+	}
+}
+
+func TestAsserts(t *testing.T) {
+	args := map[string]interface{}{
+		"cluster": "foo",
+	}
+	job := &mock.Job{
+		SetJobArgs: map[string]interface{}{
+			"hosts": []string{"h1", "h2"},
+		},
+	}
+	tf := &mock.JobFactory{
+		MockJobs: map[string]*mock.Job{
+			"get-hosts": job,
+		},
+	}
+	reqGraph, err := createGraph1(t, "assert.yaml", "assert", args, tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the last node of the sequence has the assertions, and the jobArgs
+	// they use that are set when the request is created
+	for _, node := range reqGraph.Nodes {
+		if node.Id != reqGraph.Sink.Id && len(node.Asserts) > 0 {
+			t.Errorf("%s has assertions, expected only the sequence sink", node.Name)
+		}
+	}
+	expectAsserts := []string{"len(hosts) > 0", "len(failed_hosts) == 0"}
+	if diff := deep.Equal(reqGraph.Sink.Asserts, expectAsserts); diff != nil {
+		t.Error(diff)
+	}
+	expectArgs := map[string]interface{}{"hosts": []string{"h1", "h2"}}
+	if diff := deep.Equal(reqGraph.Sink.Args, expectArgs); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestFinally(t *testing.T) {
+	args := map[string]interface{}{
+		"cluster": "foo",
+	}
+	job := &mock.Job{
+		SetJobArgs: map[string]interface{}{
+			"hosts": []string{"h1", "h2"},
+		},
+	}
+	tf := &mock.JobFactory{
+		MockJobs: map[string]*mock.Job{
+			"get-hosts": job,
+		},
+	}
+	reqGraph, err := createGraph1(t, "finally.yaml", "finally", args, tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := map[string]*Node{}
+	for _, node := range reqGraph.Nodes {
+		nodes[node.Name] = node
+		expect := node.Name == "unlock" || node.Name == "alerts-on"
+		if node.Finally != expect {
+			t.Errorf("%s finally %t, expected %t", node.Name, node.Finally, expect)
+		}
+	}
+
+	// Finally jobs run after the sequence jobs, in order of their deps
+	if !reaches(reqGraph, nodes["restart-hosts"].Id, nodes["unlock"].Id) {
+		t.Error("restart-hosts does not reach unlock")
+	}
+	if !reaches(reqGraph, nodes["unlock"].Id, nodes["alerts-on"].Id) {
+		t.Error("unlock does not reach alerts-on")
+	}
+
+	// The end of the sequence runs after the finally jobs and the last job
+	// in the sequence
+	next := reqGraph.Edges[nodes["alerts-on"].Id]
+	if len(next) != 1 {
+		t.Fatalf("alerts-on next jobs %v, expected 1", next)
+	}
+	prev := append([]string{}, reqGraph.RevEdges[next[0]]...)
+	sort.Strings(prev)
+	expectPrev := []string{nodes["alerts-on"].Id, nodes["restart-hosts"].Id}
+	sort.Strings(expectPrev)
+	if diff := deep.Equal(prev, expectPrev); diff != nil {
+		t.Error(diff)
+	}
+}
+
+// reaches returns true if there is a path from node id a to node id b.
+func reaches(g *Graph, a, b string) bool {
+	toVisit := []string{a}
+	visited := map[string]bool{}
+	for len(toVisit) > 0 {
+		id := toVisit[0]
+		toVisit = toVisit[1:]
+		for _, next := range g.Edges[id] {
+			if next == b {
+				return true
+			}
+			if !visited[next] {
+				visited[next] = true
+				toVisit = append(toVisit, next)
+			}
+		}
+	}
+	return false
+}
+
+func TestDesc(t *testing.T) {
+	args := map[string]interface{}{
+		"cluster": "foo",
+	}
+	reqGraph, err := createGraph1(t, "desc.yaml", "desc", args, &mock.JobFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A job's desc, else the desc of the innermost sequence node or sequence
+	// that has one
+	expect := map[string]string{
+		"get-hosts":         "Finding hosts in cluster",
+		"check-shift-lb-v2": "Draining traffic from hosts",
+		"restart-app":       "Restarting hosts",
+		"notify":            "Restarting cluster",
+	}
+	got := map[string]string{}
+	for _, node := range reqGraph.Nodes {
+		if _, ok := expect[node.Name]; ok {
+			got[node.Name] = node.Desc
+		}
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
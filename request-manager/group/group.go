@@ -0,0 +1,342 @@
+// Copyright 2020, Square, Inc.
+
+// Package group provides request groups: requests created together and run
+// with a policy, in parallel or sequentially, optionally stopping the group
+// when a request fails. A group has its own state and can be stopped, which
+// stops all its requests.
+//
+// Requests are created and authorized by the API, then saved as a group by
+// Manager.Create. Groups progress in AdvanceAll, which the Request Manager
+// calls periodically, so the next request of a sequential group starts a few
+// seconds after the previous one finishes.
+package group
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/request"
+)
+
+// A Manager creates, gets, stops, and advances request groups.
+type Manager interface {
+	// Create saves a group of created (pending) requests and starts it: every
+	// request if the policy is parallel, else the first request. Policy, User,
+	// StopOnFailure, and RequestIds are used; the rest of the group is set
+	// and returned.
+	Create(g proto.RequestGroup) (proto.RequestGroup, error)
+
+	// Get returns the group with its requests.
+	Get(groupId string) (proto.RequestGroup, error)
+
+	// Stop stops a running group: running requests are stopped, and pending
+	// requests are failed.
+	Stop(groupId string) error
+
+	// AdvanceAll advances every running group: it starts the next request of
+	// sequential groups, stops groups with StopOnFailure when a request fails,
+	// and finishes groups whose requests have all finished. All errors are
+	// logged, not returned.
+	AdvanceAll()
+}
+
+type ManagerConfig struct {
+	DBConnector    *sql.DB
+	RequestManager request.Manager
+}
+
+type manager struct {
+	dbc *sql.DB
+	rm  request.Manager
+}
+
+func NewManager(cfg ManagerConfig) Manager {
+	return &manager{
+		dbc: cfg.DBConnector,
+		rm:  cfg.RequestManager,
+	}
+}
+
+func (m *manager) Create(g proto.RequestGroup) (proto.RequestGroup, error) {
+	switch g.Policy {
+	case "":
+		g.Policy = proto.GROUP_POLICY_PARALLEL
+	case proto.GROUP_POLICY_PARALLEL, proto.GROUP_POLICY_SEQUENTIAL:
+	default:
+		return g, serr.ValidationError{Message: fmt.Sprintf("invalid policy %q: valid values are %s and %s",
+			g.Policy, proto.GROUP_POLICY_PARALLEL, proto.GROUP_POLICY_SEQUENTIAL)}
+	}
+	if len(g.RequestIds) == 0 {
+		return g, serr.ValidationError{Message: "no requests"}
+	}
+
+	g.Id = xid.New().String()
+	g.State = proto.STATE_RUNNING
+	g.CreatedAt = time.Now().UTC()
+	g.FinishedAt = nil
+	started := len(g.RequestIds)
+	if g.Policy == proto.GROUP_POLICY_SEQUENTIAL {
+		started = 1
+	}
+	requestIds, err := json.Marshal(g.RequestIds)
+	if err != nil {
+		return g, err
+	}
+
+	// Save the group and set requests.group_id together, else requests could
+	// be in a group that doesn't exist (and never be started)
+	ctx := context.TODO()
+	tx, err := m.dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return g, serr.NewDbError(err, "BEGIN")
+	}
+	defer tx.Rollback()
+
+	q := "INSERT INTO request_groups (group_id, policy, stop_on_failure, state, user, request_ids, started, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err = tx.ExecContext(ctx, q, g.Id, g.Policy, g.StopOnFailure, g.State, g.User, requestIds, started, g.CreatedAt)
+	if err != nil {
+		return g, serr.NewDbError(err, "INSERT request_groups")
+	}
+
+	vals := []interface{}{g.Id}
+	for _, id := range g.RequestIds {
+		vals = append(vals, id)
+	}
+	q = "UPDATE requests SET group_id = ? WHERE request_id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(g.RequestIds)), ",") + ")"
+	_, err = tx.ExecContext(ctx, q, vals...)
+	if err != nil {
+		return g, serr.NewDbError(err, "UPDATE requests")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return g, serr.NewDbError(err, "COMMIT")
+	}
+
+	for _, id := range g.RequestIds[:started] {
+		m.start(g.Id, id)
+	}
+	return g, nil
+}
+
+func (m *manager) Get(groupId string) (proto.RequestGroup, error) {
+	g, _, err := m.get(groupId)
+	if err != nil {
+		return g, err
+	}
+	g.Requests = make([]proto.Request, len(g.RequestIds))
+	for i, id := range g.RequestIds {
+		req, err := m.rm.Get(id)
+		if err != nil {
+			return g, err
+		}
+		g.Requests[i] = req
+	}
+	return g, nil
+}
+
+func (m *manager) Stop(groupId string) error {
+	g, err := m.Get(groupId)
+	if err != nil {
+		return err
+	}
+	if g.State != proto.STATE_RUNNING {
+		return serr.NewErrInvalidState(proto.StateName[proto.STATE_RUNNING], proto.StateName[g.State])
+	}
+	finished, err := m.finish(groupId, proto.STATE_STOPPED)
+	if err != nil {
+		return err
+	}
+	if !finished {
+		return nil // finished in the meantime
+	}
+	return m.stopRequests(g)
+}
+
+func (m *manager) AdvanceAll() {
+	var groupIds []string
+	q := "SELECT group_id FROM request_groups WHERE state = ?"
+	rows, err := m.dbc.QueryContext(context.TODO(), q, proto.STATE_RUNNING)
+	if err != nil {
+		log.Errorf("error querying db for running request groups: %s", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Errorf("error scanning row: %s", err)
+			return
+		}
+		groupIds = append(groupIds, id)
+	}
+	rows.Close() // must close before new queries
+
+	for _, id := range groupIds {
+		if err := m.advance(id); err != nil {
+			log.Errorf("request group %s: error advancing group: %s", id, err)
+		}
+	}
+}
+
+// advance starts, stops, or finishes a running group based on the states of its
+// requests. It's safe for several RMs to advance the same group at once: the
+// group is finished and the next request is started only by the RM that
+// updates the group first.
+func (m *manager) advance(groupId string) error {
+	g, started, err := m.get(groupId)
+	if err != nil {
+		return err
+	}
+	if g.State != proto.STATE_RUNNING {
+		return nil
+	}
+
+	failed := false
+	complete := 0
+	finished := 0 // of the started requests
+	g.Requests = make([]proto.Request, len(g.RequestIds))
+	for i, id := range g.RequestIds {
+		req, err := m.rm.Get(id)
+		if err != nil {
+			return err
+		}
+		g.Requests[i] = req
+		switch req.State {
+		case proto.STATE_COMPLETE:
+			complete++
+		case proto.STATE_FAIL, proto.STATE_STOPPED:
+			failed = true
+		default:
+			continue
+		}
+		if i < started {
+			finished++
+		}
+	}
+
+	// Stop on failure: stop the rest of the group
+	if failed && g.StopOnFailure {
+		log.Infof("request group %s: request failed, stopping group", groupId)
+		ok, err := m.finish(groupId, proto.STATE_FAIL)
+		if err != nil || !ok {
+			return err
+		}
+		return m.stopRequests(g)
+	}
+
+	// Every request finished: finish the group
+	if started == len(g.RequestIds) && finished == started {
+		state := proto.STATE_COMPLETE
+		if complete != len(g.RequestIds) {
+			state = proto.STATE_FAIL
+		}
+		_, err := m.finish(groupId, state)
+		return err
+	}
+
+	// Sequential: start the next request when every started request finished
+	if started < len(g.RequestIds) && finished == started {
+		q := "UPDATE request_groups SET started = started + 1 WHERE group_id = ? AND started = ? AND state = ?"
+		res, err := m.dbc.ExecContext(context.TODO(), q, groupId, started, proto.STATE_RUNNING)
+		if err != nil {
+			return serr.NewDbError(err, "UPDATE request_groups")
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			return err // another RM started it
+		}
+		m.start(groupId, g.RequestIds[started])
+	}
+	return nil
+}
+
+// get returns the group without its requests, and how many of its requests
+// have been started.
+func (m *manager) get(groupId string) (proto.RequestGroup, int, error) {
+	g := proto.RequestGroup{Id: groupId}
+	var started int
+	var user sql.NullString
+	var requestIds []byte
+	finishedAt := mysql.NullTime{}
+	q := "SELECT policy, stop_on_failure, state, user, request_ids, started, created_at, finished_at FROM request_groups WHERE group_id = ?"
+	err := m.dbc.QueryRowContext(context.TODO(), q, groupId).Scan(
+		&g.Policy,
+		&g.StopOnFailure,
+		&g.State,
+		&user,
+		&requestIds,
+		&started,
+		&g.CreatedAt,
+		&finishedAt,
+	)
+	switch {
+	case err == sql.ErrNoRows:
+		return g, 0, serr.GroupNotFound{GroupId: groupId}
+	case err != nil:
+		return g, 0, serr.NewDbError(err, "SELECT request_groups")
+	}
+	g.User = user.String
+	if finishedAt.Valid {
+		g.FinishedAt = &finishedAt.Time
+	}
+	if err := json.Unmarshal(requestIds, &g.RequestIds); err != nil {
+		return g, 0, fmt.Errorf("cannot unmarshal request_ids: %s", err)
+	}
+	return g, started, nil
+}
+
+// finish sets the final state of a running group. It returns false if the group
+// is not running, like when another RM finished it first.
+func (m *manager) finish(groupId string, state byte) (bool, error) {
+	q := "UPDATE request_groups SET state = ?, finished_at = ? WHERE group_id = ? AND state = ?"
+	res, err := m.dbc.ExecContext(context.TODO(), q, state, time.Now().UTC(), groupId, proto.STATE_RUNNING)
+	if err != nil {
+		return false, serr.NewDbError(err, "UPDATE request_groups")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// start starts a request in the group, or fails it if it cannot be started.
+// A failed request is handled by the next advance, like a request that ran
+// and failed.
+func (m *manager) start(groupId, requestId string) {
+	if err := m.rm.Start(requestId); err != nil {
+		log.Errorf("request group %s: error starting request %s: %s", groupId, requestId, err)
+		if err := m.rm.FailPending(requestId); err != nil {
+			log.Errorf("request group %s: error failing request %s: %s", groupId, requestId, err)
+		}
+	}
+}
+
+// stopRequests stops running requests and fails pending requests in the group.
+// It tries every request and returns the last error.
+func (m *manager) stopRequests(g proto.RequestGroup) error {
+	var lastErr error
+	for _, req := range g.Requests {
+		var err error
+		switch req.State {
+		case proto.STATE_RUNNING:
+			err = m.rm.Stop(req.Id)
+		case proto.STATE_PENDING:
+			err = m.rm.FailPending(req.Id)
+		}
+		if err != nil {
+			log.Errorf("request group %s: error stopping request %s: %s", g.Id, req.Id, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
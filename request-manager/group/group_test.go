@@ -0,0 +1,209 @@
+// Copyright 2020, Square, Inc.
+
+package group_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/group"
+	testdb "github.com/square/spincycle/v2/request-manager/test/db"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+var dbm testdb.Manager
+var dbc *sql.DB
+
+func setup(t *testing.T, dataFile string) string {
+	var err error
+	if dbm == nil {
+		dbm, err = testdb.NewManager()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbName, err := dbm.Create(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbm.Connect(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbc = db
+	return dbName
+}
+
+func teardown(t *testing.T, dbName string) {
+	if err := dbm.Destroy(dbName); err != nil {
+		t.Fatal(err)
+	}
+	dbc.Close()
+}
+
+// testRM returns a mock request manager that gets requests in the given states
+// and records started, stopped, and failed requests.
+func testRM(states map[string]byte, calls *[]string) *mock.RequestManager {
+	return &mock.RequestManager{
+		GetFunc: func(id string) (proto.Request, error) {
+			return proto.Request{Id: id, State: states[id]}, nil
+		},
+		StartFunc: func(id string) error {
+			*calls = append(*calls, "start "+id)
+			states[id] = proto.STATE_RUNNING
+			return nil
+		},
+		StopFunc: func(id string) error {
+			*calls = append(*calls, "stop "+id)
+			states[id] = proto.STATE_STOPPED
+			return nil
+		},
+		FailPendingFunc: func(id string) error {
+			*calls = append(*calls, "fail "+id)
+			states[id] = proto.STATE_FAIL
+			return nil
+		},
+	}
+}
+
+// //////////////////////////////////////////////////////////////////////////
+// Tests
+// //////////////////////////////////////////////////////////////////////////
+
+func TestSequential(t *testing.T) {
+	dbName := setup(t, "")
+	defer teardown(t, dbName)
+
+	states := map[string]byte{"req1": proto.STATE_PENDING, "req2": proto.STATE_PENDING}
+	var calls []string
+	m := group.NewManager(group.ManagerConfig{DBConnector: dbc, RequestManager: testRM(states, &calls)})
+
+	g, err := m.Create(proto.RequestGroup{
+		Policy:     proto.GROUP_POLICY_SEQUENTIAL,
+		User:       "finch",
+		RequestIds: []string{"req1", "req2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(calls, []string{"start req1"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// req1 running: nothing to do
+	m.AdvanceAll()
+	if len(calls) != 1 {
+		t.Errorf("calls = %v, expected only start req1", calls)
+	}
+
+	// req1 failed, but group doesn't stop on failure: start req2
+	states["req1"] = proto.STATE_FAIL
+	m.AdvanceAll()
+	if diff := deep.Equal(calls, []string{"start req1", "start req2"}); diff != nil {
+		t.Error(diff)
+	}
+
+	states["req2"] = proto.STATE_COMPLETE
+	m.AdvanceAll()
+	got, err := m.Get(g.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != proto.STATE_FAIL {
+		t.Errorf("group state = %s, expected FAIL", proto.StateName[got.State])
+	}
+	if got.FinishedAt == nil {
+		t.Error("FinishedAt not set")
+	}
+	if got.User != "finch" || len(got.Requests) != 2 || got.Requests[1].State != proto.STATE_COMPLETE {
+		t.Errorf("got group %+v, expected user finch and 2 requests", got)
+	}
+}
+
+func TestStopOnFailure(t *testing.T) {
+	dbName := setup(t, "")
+	defer teardown(t, dbName)
+
+	states := map[string]byte{"req1": proto.STATE_PENDING, "req2": proto.STATE_PENDING, "req3": proto.STATE_PENDING}
+	var calls []string
+	m := group.NewManager(group.ManagerConfig{DBConnector: dbc, RequestManager: testRM(states, &calls)})
+
+	g, err := m.Create(proto.RequestGroup{
+		StopOnFailure: true,
+		RequestIds:    []string{"req1", "req2", "req3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Policy != proto.GROUP_POLICY_PARALLEL {
+		t.Errorf("policy = %s, expected parallel by default", g.Policy)
+	}
+	if diff := deep.Equal(calls, []string{"start req1", "start req2", "start req3"}); diff != nil {
+		t.Error(diff)
+	}
+
+	calls = nil
+	states["req1"] = proto.STATE_COMPLETE
+	states["req2"] = proto.STATE_FAIL
+	m.AdvanceAll()
+	if diff := deep.Equal(calls, []string{"stop req3"}); diff != nil {
+		t.Error(diff)
+	}
+	got, err := m.Get(g.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != proto.STATE_FAIL {
+		t.Errorf("group state = %s, expected FAIL", proto.StateName[got.State])
+	}
+}
+
+func TestStop(t *testing.T) {
+	dbName := setup(t, "")
+	defer teardown(t, dbName)
+
+	states := map[string]byte{"req1": proto.STATE_PENDING, "req2": proto.STATE_PENDING}
+	var calls []string
+	m := group.NewManager(group.ManagerConfig{DBConnector: dbc, RequestManager: testRM(states, &calls)})
+
+	g, err := m.Create(proto.RequestGroup{
+		Policy:     proto.GROUP_POLICY_SEQUENTIAL,
+		RequestIds: []string{"req1", "req2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls = nil
+	if err := m.Stop(g.Id); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(calls, []string{"stop req1", "fail req2"}); diff != nil {
+		t.Error(diff)
+	}
+	got, err := m.Get(g.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != proto.STATE_STOPPED {
+		t.Errorf("group state = %s, expected STOPPED", proto.StateName[got.State])
+	}
+
+	// Stopped group isn't running
+	if err := m.Stop(g.Id); err == nil {
+		t.Error("no error stopping stopped group, expected one")
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	m := group.NewManager(group.ManagerConfig{})
+	if _, err := m.Create(proto.RequestGroup{Policy: "random", RequestIds: []string{"req1"}}); err == nil {
+		t.Error("no error for invalid policy, expected one")
+	}
+	if _, err := m.Create(proto.RequestGroup{}); err == nil {
+		t.Error("no error for no requests, expected one")
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2020, Square, Inc.
+
+package joblog
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// ErrBacklog is returned by AsyncStore.Create when too many JLs are not saved
+// in the db yet (AsyncStoreConfig.MaxPending). The API returns 503 and the Job
+// Runner retries, so JLs are delayed, not lost, while the db is down.
+var ErrBacklog = errors.New("too many job log entries pending, try again later")
+
+const (
+	// MAX_BATCH_BYTES caps the size of one batch INSERT, approximately, to stay
+	// well under the MySQL max_allowed_packet.
+	MAX_BATCH_BYTES = 8 * 1024 * 1024
+
+	// MAX_FLUSH_BACKOFF is the max wait between flushes when saving fails.
+	MAX_FLUSH_BACKOFF = 5 * time.Second
+)
+
+// batchStore is a Store that can save many JLs at once. store implements it.
+type batchStore interface {
+	Store
+	createBatch(jls []proto.JobLog) error
+}
+
+type AsyncStoreConfig struct {
+	StoreConfig
+	WALDir        string        // WAL directory, must exist
+	BatchSize     uint          // max JLs per batch INSERT
+	FlushInterval time.Duration // max time a JL waits before it's saved in the db
+	MaxPending    uint          // max JLs in the WAL not saved in the db (0 = no limit)
+}
+
+// AsyncStore is a Store for high volume job logs: the JL write path when chains
+// finish thousands of jobs per second. Create appends the JL to a local
+// write-ahead log (WAL) and returns; a flusher goroutine saves pending JLs in the
+// db every FlushInterval, or as soon as BatchSize JLs are pending, in batch
+// INSERTs. If the db is down, pending JLs are kept in the WAL and retried with
+// backoff, and Create returns ErrBacklog once MaxPending JLs are pending.
+//
+// The WAL is written on every Create and synced on every flush, so JLs survive
+// an RM crash or restart: NewAsyncStore saves JLs left in the WAL before new ones.
+// A host crash can lose JLs written since the last flush.
+//
+// Reads include pending JLs, so a JL can be read as soon as it's created.
+// Delete and Purge save pending JLs first.
+type AsyncStore struct {
+	db            batchStore
+	batchSize     int
+	flushInterval time.Duration
+	maxPending    uint
+
+	mux      *sync.Mutex  // guards the fields below
+	wal      *wal         // current segment receives new JLs
+	current  []walEntry   // JLs in the current segment
+	segments []walSegment // closed segments not saved yet, oldest first
+	pending  uint         // JLs in segments and current
+
+	flushMux  *sync.Mutex // serializes flush
+	flushChan chan struct{}
+	stopChan  chan struct{}
+	doneChan  chan struct{}
+}
+
+// NewAsyncStore opens the WAL, saves JLs left in it by the last run, and starts
+// flushing. If JLs left in the WAL cannot be saved, they're kept pending and
+// retried like new JLs. Call Close to stop the store.
+func NewAsyncStore(cfg AsyncStoreConfig) (*AsyncStore, error) {
+	return newAsyncStore(NewStore(cfg.StoreConfig).(*store), cfg)
+}
+
+func newAsyncStore(db batchStore, cfg AsyncStoreConfig) (*AsyncStore, error) {
+	if cfg.BatchSize == 0 {
+		return nil, fmt.Errorf("invalid job log WAL batch size: 0")
+	}
+	if cfg.FlushInterval <= 0 {
+		return nil, fmt.Errorf("invalid job log WAL flush interval: %s", cfg.FlushInterval)
+	}
+	w, segments, err := openWAL(cfg.WALDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open job log WAL: %s", err)
+	}
+	s := &AsyncStore{
+		db:            db,
+		batchSize:     int(cfg.BatchSize),
+		flushInterval: cfg.FlushInterval,
+		maxPending:    cfg.MaxPending,
+		mux:           &sync.Mutex{},
+		wal:           w,
+		segments:      segments,
+		flushMux:      &sync.Mutex{},
+		flushChan:     make(chan struct{}, 1),
+		stopChan:      make(chan struct{}),
+		doneChan:      make(chan struct{}),
+	}
+	for _, seg := range segments {
+		s.pending += uint(len(seg.entries))
+	}
+	if s.pending > 0 {
+		log.Infof("job log WAL: saving %d JLs from %d segments", s.pending, len(segments))
+		if err := s.flush(); err != nil {
+			log.Warnf("job log WAL: error saving JLs, will retry: %s", err)
+		}
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *AsyncStore) Create(requestId string, jl proto.JobLog) (proto.JobLog, error) {
+	jl.RequestId = requestId
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.maxPending > 0 && s.pending >= s.maxPending {
+		return jl, ErrBacklog
+	}
+	size, err := s.wal.append(jl)
+	if err != nil {
+		return jl, err
+	}
+	s.current = append(s.current, walEntry{jl: jl, size: size})
+	s.pending++
+	if len(s.current) >= s.batchSize {
+		select {
+		case s.flushChan <- struct{}{}:
+		default: // flush already signaled
+		}
+	}
+	return jl, nil
+}
+
+func (s *AsyncStore) Get(requestId, jobId string) (proto.JobLog, error) {
+	jl, err := s.db.Get(requestId, jobId)
+	if err != nil && !errors.As(err, &serr.JobNotFound{}) {
+		return jl, err
+	}
+	for _, p := range s.pendingJLs(requestId) {
+		if p.JobId == jobId && (err != nil || p.Try >= jl.Try) {
+			jl, err = p, nil
+		}
+	}
+	return jl, err
+}
+
+func (s *AsyncStore) GetTry(requestId, jobId string, try uint) (proto.JobLog, error) {
+	for _, p := range s.pendingJLs(requestId) {
+		if p.JobId == jobId && p.Try == try {
+			return p, nil
+		}
+	}
+	return s.db.GetTry(requestId, jobId, try)
+}
+
+func (s *AsyncStore) GetFull(requestId string) ([]proto.JobLog, error) {
+	jls, err := s.db.GetFull(requestId)
+	if err != nil {
+		return nil, err
+	}
+	// A pending JL is in the db too if it was saved between the two reads
+	saved := map[string]map[uint]bool{}
+	for _, jl := range jls {
+		if saved[jl.JobId] == nil {
+			saved[jl.JobId] = map[uint]bool{}
+		}
+		saved[jl.JobId][jl.Try] = true
+	}
+	for _, p := range s.pendingJLs(requestId) {
+		if !saved[p.JobId][p.Try] {
+			jls = append(jls, p)
+		}
+	}
+	return jls, nil
+}
+
+func (s *AsyncStore) Delete(requestId string, oldTries bool) (int64, error) {
+	if err := s.flush(); err != nil {
+		return 0, err
+	}
+	return s.db.Delete(requestId, oldTries)
+}
+
+func (s *AsyncStore) Purge(finishedBefore time.Time) (int64, error) {
+	if err := s.flush(); err != nil {
+		return 0, err
+	}
+	return s.db.Purge(finishedBefore)
+}
+
+// Pending returns the number of JLs not saved in the db yet.
+func (s *AsyncStore) Pending() uint {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.pending
+}
+
+// Close stops flushing, saves pending JLs, and closes the WAL. JLs that cannot
+// be saved are kept in the WAL and saved on the next NewAsyncStore. Create must
+// not be called after Close.
+func (s *AsyncStore) Close() error {
+	close(s.stopChan)
+	<-s.doneChan
+	err := s.flush()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if cerr := s.wal.close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// run flushes every flush interval or when signaled, until Close. On error,
+// it waits with exponential backoff, up to MAX_FLUSH_BACKOFF, before retrying.
+func (s *AsyncStore) run() {
+	defer close(s.doneChan)
+	var backoff time.Duration
+	for {
+		wait := s.flushInterval
+		flushChan := s.flushChan
+		if backoff > 0 {
+			wait = backoff
+			flushChan = nil // don't retry early
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.stopChan:
+			timer.Stop()
+			return
+		case <-flushChan:
+			timer.Stop()
+		case <-timer.C:
+		}
+		if err := s.flush(); err != nil {
+			backoff *= 2
+			if backoff == 0 {
+				backoff = s.flushInterval
+			}
+			if backoff > MAX_FLUSH_BACKOFF {
+				backoff = MAX_FLUSH_BACKOFF
+			}
+			log.Warnf("job log WAL: error saving %d pending JLs, retrying in %s: %s", s.Pending(), backoff, err)
+			continue
+		}
+		backoff = 0
+	}
+}
+
+// flush saves all pending JLs in the db, oldest first. The current segment is
+// rotated so new JLs go to a new segment while the closed ones are saved.
+// Segments are removed once saved. On error, it stops and the remaining JLs
+// stay pending; JLs saved before the error are not saved twice because
+// createBatch ignores duplicates.
+func (s *AsyncStore) flush() error {
+	s.flushMux.Lock()
+	defer s.flushMux.Unlock()
+
+	s.mux.Lock()
+	if len(s.current) > 0 {
+		seq, err := s.wal.rotate()
+		if err != nil {
+			s.mux.Unlock()
+			return fmt.Errorf("cannot rotate job log WAL: %s", err)
+		}
+		s.segments = append(s.segments, walSegment{seq: seq, entries: s.current})
+		s.current = nil
+	}
+	segments := s.segments
+	s.mux.Unlock()
+
+	for _, seg := range segments {
+		entries := seg.entries
+		for len(entries) > 0 {
+			n, size := 0, 0
+			for n < len(entries) && n < s.batchSize && (n == 0 || size+entries[n].size <= MAX_BATCH_BYTES) {
+				size += entries[n].size
+				n++
+			}
+			batch := make([]proto.JobLog, n)
+			for i := range batch {
+				batch[i] = entries[i].jl
+			}
+			if err := s.db.createBatch(batch); err != nil {
+				return err
+			}
+			entries = entries[n:]
+
+			s.mux.Lock()
+			if i := s.segmentIndex(seg.seq); i >= 0 {
+				s.segments[i].entries = entries
+			}
+			s.pending -= uint(n)
+			s.mux.Unlock()
+		}
+
+		// Copy the other segments because segments shares their array
+		s.mux.Lock()
+		if i := s.segmentIndex(seg.seq); i >= 0 {
+			s.segments = append(s.segments[:i:i], s.segments[i+1:]...)
+		}
+		s.mux.Unlock()
+		if err := s.wal.remove(seg.seq); err != nil {
+			log.Warnf("job log WAL: cannot remove saved segment %d: %s", seg.seq, err)
+		}
+	}
+	return nil
+}
+
+// segmentIndex returns the index of the segment in s.segments, or -1 if it's
+// not there. The caller must lock s.mux.
+func (s *AsyncStore) segmentIndex(seq uint64) int {
+	for i := range s.segments {
+		if s.segments[i].seq == seq {
+			return i
+		}
+	}
+	return -1
+}
+
+// pendingJLs returns the pending JLs of the request.
+func (s *AsyncStore) pendingJLs(requestId string) []proto.JobLog {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var jls []proto.JobLog
+	for _, seg := range s.segments {
+		for _, e := range seg.entries {
+			if e.jl.RequestId == requestId {
+				jls = append(jls, e.jl)
+			}
+		}
+	}
+	for _, e := range s.current {
+		if e.jl.RequestId == requestId {
+			jls = append(jls, e.jl)
+		}
+	}
+	return jls
+}
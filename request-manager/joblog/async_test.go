@@ -0,0 +1,439 @@
+// Copyright 2020, Square, Inc.
+
+package joblog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// fakeDB is a batchStore that saves JLs in memory. createBatch returns err if set.
+type fakeDB struct {
+	*sync.Mutex
+	jls     []proto.JobLog
+	batches []int
+	err     error
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{Mutex: &sync.Mutex{}}
+}
+
+func (db *fakeDB) createBatch(jls []proto.JobLog) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.err != nil {
+		return db.err
+	}
+	for _, jl := range jls {
+		if _, err := db.getTry(jl.RequestId, jl.JobId, jl.Try); err == nil {
+			continue // INSERT IGNORE
+		}
+		db.jls = append(db.jls, jl)
+	}
+	db.batches = append(db.batches, len(jls))
+	return nil
+}
+
+func (db *fakeDB) setErr(err error) {
+	db.Lock()
+	db.err = err
+	db.Unlock()
+}
+
+func (db *fakeDB) saved() int {
+	db.Lock()
+	defer db.Unlock()
+	return len(db.jls)
+}
+
+func (db *fakeDB) Create(requestId string, jl proto.JobLog) (proto.JobLog, error) {
+	return jl, fmt.Errorf("Create called")
+}
+
+func (db *fakeDB) Get(requestId, jobId string) (proto.JobLog, error) {
+	db.Lock()
+	defer db.Unlock()
+	var last *proto.JobLog
+	for i, jl := range db.jls {
+		if jl.RequestId == requestId && jl.JobId == jobId && (last == nil || jl.Try > last.Try) {
+			last = &db.jls[i]
+		}
+	}
+	if last == nil {
+		return proto.JobLog{}, serr.JobNotFound{RequestId: requestId, JobId: jobId}
+	}
+	return *last, nil
+}
+
+func (db *fakeDB) GetTry(requestId, jobId string, try uint) (proto.JobLog, error) {
+	db.Lock()
+	defer db.Unlock()
+	return db.getTry(requestId, jobId, try)
+}
+
+func (db *fakeDB) getTry(requestId, jobId string, try uint) (proto.JobLog, error) {
+	for _, jl := range db.jls {
+		if jl.RequestId == requestId && jl.JobId == jobId && jl.Try == try {
+			return jl, nil
+		}
+	}
+	return proto.JobLog{}, serr.JobNotFound{RequestId: requestId, JobId: jobId}
+}
+
+func (db *fakeDB) GetFull(requestId string) ([]proto.JobLog, error) {
+	db.Lock()
+	defer db.Unlock()
+	jls := []proto.JobLog{}
+	for _, jl := range db.jls {
+		if jl.RequestId == requestId {
+			jls = append(jls, jl)
+		}
+	}
+	return jls, nil
+}
+
+func (db *fakeDB) Delete(requestId string, oldTries bool) (int64, error) {
+	return 0, nil
+}
+
+func (db *fakeDB) Purge(finishedBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+func walDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "joblog-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func walFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+walExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func testJL(jobId string, try uint) proto.JobLog {
+	return proto.JobLog{JobId: jobId, Try: try, Name: "job-" + jobId, Type: "test", State: proto.STATE_COMPLETE}
+}
+
+func TestAsyncStoreBatches(t *testing.T) {
+	dir := walDir(t)
+	defer os.RemoveAll(dir)
+
+	db := newFakeDB()
+	s, err := newAsyncStore(db, AsyncStoreConfig{
+		WALDir:        dir,
+		BatchSize:     10,
+		FlushInterval: time.Hour, // only flush when batch is full
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(from, to int) {
+		for i := from; i < to; i++ {
+			jl, err := s.Create("req1", testJL(fmt.Sprintf("job%d", i), 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jl.RequestId != "req1" {
+				t.Errorf("got request id %q, expected req1", jl.RequestId)
+			}
+		}
+	}
+
+	// Full batch is saved right away
+	create(0, 10)
+	timeout := time.After(2 * time.Second)
+	for db.saved() < 10 {
+		select {
+		case <-timeout:
+			t.Fatalf("%d JLs saved, expected 10", db.saved())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// Partial batch is pending until the next flush
+	create(10, 15)
+	if n := s.Pending(); n != 5 {
+		t.Errorf("%d JLs pending, expected 5", n)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db.saved() != 15 {
+		t.Errorf("%d JLs saved after Close, expected 15", db.saved())
+	}
+	if files := walFiles(t, dir); len(files) != 0 {
+		t.Errorf("WAL segments not removed: %v", files)
+	}
+}
+
+func TestAsyncStoreDbDown(t *testing.T) {
+	dir := walDir(t)
+	defer os.RemoveAll(dir)
+
+	db := newFakeDB()
+	db.setErr(fmt.Errorf("db down"))
+	s, err := newAsyncStore(db, AsyncStoreConfig{
+		WALDir:        dir,
+		BatchSize:     100,
+		FlushInterval: 5 * time.Millisecond,
+		MaxPending:    3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Create("req1", testJL(fmt.Sprintf("job%d", i), 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Create("req1", testJL("job3", 1)); err != ErrBacklog {
+		t.Errorf("got error %v, expected ErrBacklog", err)
+	}
+
+	// Pending JLs can be read while the db is down
+	jl, err := s.Get("req1", "job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jl.Name != "job-job1" {
+		t.Errorf("got JL %+v, expected job1", jl)
+	}
+	jls, err := s.GetFull("req1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jls) != 3 {
+		t.Errorf("got %d JLs, expected 3", len(jls))
+	}
+
+	// Retried when the db is back
+	time.Sleep(20 * time.Millisecond)
+	db.setErr(nil)
+	timeout := time.After(2 * time.Second)
+	for s.Pending() > 0 {
+		select {
+		case <-timeout:
+			t.Fatalf("%d JLs pending, expected 0", s.Pending())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if db.saved() != 3 {
+		t.Errorf("%d JLs saved, expected 3", db.saved())
+	}
+	if _, err := s.Create("req1", testJL("job3", 1)); err != nil {
+		t.Errorf("got error %v after db is back, expected nil", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAsyncStoreReplay(t *testing.T) {
+	dir := walDir(t)
+	defer os.RemoveAll(dir)
+
+	// Db down, so JLs are only in the WAL when the store is closed
+	db := newFakeDB()
+	db.setErr(fmt.Errorf("db down"))
+	s, err := newAsyncStore(db, AsyncStoreConfig{
+		WALDir:        dir,
+		BatchSize:     100,
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := s.Create("req1", testJL(fmt.Sprintf("job%d", i), 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err == nil {
+		t.Error("no error from Close, expected db error")
+	}
+	files := walFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("got WAL segments %v, expected 1", files)
+	}
+
+	// Simulate a crash while appending a JL: the partial line is ignored
+	f, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"requestId":"req1","jobId":"job5"`)
+	f.Close()
+
+	// Db back, so JLs in the WAL are saved when the store is opened
+	db.setErr(nil)
+	s, err = newAsyncStore(db, AsyncStoreConfig{
+		WALDir:        dir,
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.saved() != 5 {
+		t.Errorf("%d JLs saved, expected 5", db.saved())
+	}
+	if diff := deep.Equal(db.batches, []int{2, 2, 1}); diff != nil {
+		t.Error(diff)
+	}
+	if n := s.Pending(); n != 0 {
+		t.Errorf("%d JLs pending, expected 0", n)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files := walFiles(t, dir); len(files) != 0 {
+		t.Errorf("WAL segments not removed: %v", files)
+	}
+}
+
+func TestAsyncStoreReplayEmptySegment(t *testing.T) {
+	dir := walDir(t)
+	defer os.RemoveAll(dir)
+
+	// Segment 1 has only a partial line (crash while appending the first JL),
+	// so it's empty. Segment 2 has 3 JLs.
+	if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%020d%s", 1, walExt)), []byte(`{"requestId":"req1"`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	var lines []byte
+	for i := 0; i < 3; i++ {
+		jl := testJL(fmt.Sprintf("job%d", i), 1)
+		jl.RequestId = "req1"
+		line, _ := json.Marshal(jl)
+		lines = append(append(lines, line...), '\n')
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%020d%s", 2, walExt)), lines, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	db := newFakeDB()
+	s, err := newAsyncStore(db, AsyncStoreConfig{
+		WALDir:        dir,
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.saved() != 3 {
+		t.Errorf("%d JLs saved, expected 3", db.saved())
+	}
+	if diff := deep.Equal(db.batches, []int{2, 1}); diff != nil {
+		t.Error(diff)
+	}
+	if n := s.Pending(); n != 0 {
+		t.Errorf("%d JLs pending, expected 0", n)
+	}
+
+	// Nothing left to save: flushing again saves only the new JL
+	if _, err := s.Create("req1", testJL("job3", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(db.batches, []int{2, 1, 1}); diff != nil {
+		t.Error(diff)
+	}
+	if n := s.Pending(); n != 0 {
+		t.Errorf("%d JLs pending, expected 0", n)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files := walFiles(t, dir); len(files) != 0 {
+		t.Errorf("WAL segments not removed: %v", files)
+	}
+}
+
+func TestAsyncStoreGetPending(t *testing.T) {
+	dir := walDir(t)
+	defer os.RemoveAll(dir)
+
+	db := newFakeDB()
+	db.jls = []proto.JobLog{
+		{RequestId: "req1", JobId: "job1", Try: 1, State: proto.STATE_FAIL},
+		{RequestId: "req1", JobId: "job2", Try: 1, State: proto.STATE_COMPLETE},
+	}
+	s, err := newAsyncStore(db, AsyncStoreConfig{
+		WALDir:        dir,
+		BatchSize:     100,
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// job1 try 2 is pending, newer than try 1 in the db
+	if _, err := s.Create("req1", proto.JobLog{JobId: "job1", Try: 2, State: proto.STATE_COMPLETE}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create("req2", proto.JobLog{JobId: "job1", Try: 1, State: proto.STATE_COMPLETE}); err != nil {
+		t.Fatal(err)
+	}
+
+	jl, err := s.Get("req1", "job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jl.Try != 2 || jl.State != proto.STATE_COMPLETE {
+		t.Errorf("got try %d state %s, expected try 2 state COMPLETE", jl.Try, proto.StateName[jl.State])
+	}
+	jl, err = s.GetTry("req1", "job1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jl.State != proto.STATE_FAIL {
+		t.Errorf("got try 1 state %s, expected FAIL", proto.StateName[jl.State])
+	}
+	jl, err = s.Get("req1", "job2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jl.Try != 1 {
+		t.Errorf("got job2 try %d, expected 1", jl.Try)
+	}
+	if _, err := s.Get("req1", "job3"); err == nil {
+		t.Error("no error getting job3, expected JobNotFound")
+	}
+
+	jls, err := s.GetFull("req1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jls) != 3 {
+		t.Errorf("got %d JLs for req1, expected 3: %+v", len(jls), jls)
+	}
+	jls, err = s.GetFull("req2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jls) != 1 {
+		t.Errorf("got %d JLs for req2, expected 1: %+v", len(jls), jls)
+	}
+}
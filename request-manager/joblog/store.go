@@ -1,11 +1,26 @@
 // Copyright 2017-2019, Square, Inc.
 
 // Package joblog provides an interface for reading and writing job logs.
+//
+// Every try of every job is saved by default. To limit storage, the store can
+// keep only the last tries of each job (StoreConfig.MaxTries) and cap the
+// entries per request (StoreConfig.MaxPerRequest). Job logs of finished
+// requests are deleted only by Delete and Purge, which are called by the API.
+//
+// Resources affected by jobs (proto.JobLog.Resources) are indexed in the
+// request_resources table, so requests can be found by resource. The index is
+// kept when old tries are trimmed, and deleted with all JLs of a request.
 package joblog
 
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 
 	serr "github.com/square/spincycle/v2/errors"
 	"github.com/square/spincycle/v2/proto"
@@ -16,61 +31,291 @@ type Store interface {
 	// Create saves a JL to the db.
 	Create(requestId string, jl proto.JobLog) (proto.JobLog, error)
 
-	// Get gets a single JL.
+	// Get gets a single JL: the latest try of the job.
 	Get(requestId string, jobId string) (proto.JobLog, error)
 
+	// GetTry gets the JL of one try of the job.
+	GetTry(requestId string, jobId string, try uint) (proto.JobLog, error)
+
 	// GetFull gets all of the JLs for a request.
 	GetFull(requestId string) ([]proto.JobLog, error)
+
+	// Delete deletes the JLs of a request and returns how many were deleted.
+	// If oldTries is true, the latest try of every job is kept.
+	Delete(requestId string, oldTries bool) (int64, error)
+
+	// Purge deletes the JLs of requests finished before the given time and
+	// returns how many were deleted.
+	Purge(finishedBefore time.Time) (int64, error)
+}
+
+// PURGE_BATCH_SIZE is how many requests Purge deletes JLs for at once.
+const PURGE_BATCH_SIZE = 500
+
+// MAX_RESOURCE_LEN is the max length of an indexed resource name. Longer names
+// are not indexed.
+const MAX_RESOURCE_LEN = 255
+
+type StoreConfig struct {
+	DBConnector   *sql.DB
+	MaxTries      uint // keep the last N tries of each job (0 = all)
+	MaxPerRequest uint // cap JLs per request, keeping the last try of each job (0 = no cap)
 }
 
 // store implements the Store interface
 type store struct {
-	dbc *sql.DB
+	dbc           *sql.DB
+	maxTries      uint
+	maxPerRequest uint
 }
 
-func NewStore(dbc *sql.DB) Store {
+func NewStore(cfg StoreConfig) Store {
 	return &store{
-		dbc: dbc,
+		dbc:           cfg.DBConnector,
+		maxTries:      cfg.MaxTries,
+		maxPerRequest: cfg.MaxPerRequest,
 	}
 }
 
+// jlColumns are the job_log columns set by Create, in the order of jlRow values.
+const (
+	jlColumns = "request_id, job_id, name, try, type, started_at, finished_at, state, `exit`, " +
+		"error, error_class, stdout, stderr, data, log_entries, execs"
+	jlPlaceholders = "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+)
+
 func (s *store) Create(requestId string, jl proto.JobLog) (proto.JobLog, error) {
 	jl.RequestId = requestId
 	ctx := context.TODO()
 
-	q := "INSERT INTO job_log (request_id, job_id, name, try, type, started_at, finished_at, state, `exit`, " +
-		"error, stdout, stderr) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
-	_, err := s.dbc.ExecContext(ctx, q,
-		&jl.RequestId,
-		&jl.JobId,
-		&jl.Name,
-		&jl.Try,
-		&jl.Type,
-		&jl.StartedAt,
-		&jl.FinishedAt,
-		&jl.State,
-		&jl.Exit,
-		&jl.Error,
-		&jl.Stdout,
-		&jl.Stderr,
-	)
+	row, err := jlRow(jl)
 	if err != nil {
 		return jl, err
 	}
+	q := "INSERT INTO job_log (" + jlColumns + ") VALUES " + jlPlaceholders
+	if _, err := s.dbc.ExecContext(ctx, q, row...); err != nil {
+		return jl, err
+	}
+
+	// Index the resources affected by the try. Errors are not returned because
+	// the JL was saved.
+	if err := s.index(jl); err != nil {
+		log.Warnf("request %s: error indexing resources of job %s: %s", jl.RequestId, jl.JobId, err)
+	}
+
+	// Apply retention after saving the new try, so it's never the one deleted.
+	// Errors are not returned because the JL was saved.
+	if err := s.trim(jl); err != nil {
+		log.Warnf("request %s: error deleting old job log entries: %s", jl.RequestId, err)
+	}
 
 	return jl, nil
 }
 
+// createBatch saves JLs to the db in one multi-row INSERT, then indexes and
+// trims them like Create. JLs already saved are ignored (INSERT IGNORE), so a
+// batch can be saved again after an error, like when the WAL is replayed. JLs
+// that cannot be marshaled are logged and dropped because they'd never save.
+func (s *store) createBatch(jls []proto.JobLog) error {
+	values := make([]string, 0, len(jls))
+	args := make([]interface{}, 0, len(jls)*16)
+	saved := make([]proto.JobLog, 0, len(jls))
+	for _, jl := range jls {
+		row, err := jlRow(jl)
+		if err != nil {
+			log.Errorf("request %s: dropping job log of job %s try %d: %s", jl.RequestId, jl.JobId, jl.Try, err)
+			continue
+		}
+		values = append(values, jlPlaceholders)
+		args = append(args, row...)
+		saved = append(saved, jl)
+	}
+	if len(saved) == 0 {
+		return nil
+	}
+	q := "INSERT IGNORE INTO job_log (" + jlColumns + ") VALUES " + strings.Join(values, ", ")
+	if _, err := s.dbc.ExecContext(context.TODO(), q, args...); err != nil {
+		return serr.NewDbError(err, "INSERT job_log")
+	}
+
+	// Like Create, errors are not returned because the JLs were saved
+	for _, jl := range saved {
+		if err := s.index(jl); err != nil {
+			log.Warnf("request %s: error indexing resources of job %s: %s", jl.RequestId, jl.JobId, err)
+		}
+		if err := s.trim(jl); err != nil {
+			log.Warnf("request %s: error deleting old job log entries: %s", jl.RequestId, err)
+		}
+	}
+	return nil
+}
+
+// jlRow returns the values of jlColumns for the JL.
+func jlRow(jl proto.JobLog) ([]interface{}, error) {
+	var data []byte // NULL if no job data
+	if len(jl.Data) > 0 {
+		var err error
+		data, err = json.Marshal(jl.Data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal job data: %s", err)
+		}
+	}
+
+	var entries []byte // NULL if job logged no entries
+	if len(jl.Log) > 0 {
+		var err error
+		entries, err = json.Marshal(jl.Log)
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal log entries: %s", err)
+		}
+	}
+
+	var execs []byte // NULL if job ran no commands with its job.Execs
+	if len(jl.Execs) > 0 {
+		var err error
+		execs, err = json.Marshal(jl.Execs)
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal execs: %s", err)
+		}
+	}
+
+	var errClass *string // NULL if job did not classify its error
+	if jl.ErrorClass != "" {
+		errClass = &jl.ErrorClass
+	}
+
+	return []interface{}{
+		jl.RequestId,
+		jl.JobId,
+		jl.Name,
+		jl.Try,
+		jl.Type,
+		jl.StartedAt,
+		jl.FinishedAt,
+		jl.State,
+		jl.Exit,
+		jl.Error,
+		errClass,
+		jl.Stdout,
+		jl.Stderr,
+		data,
+		entries,
+		execs,
+	}, nil
+}
+
+// index saves the resources affected by the try in request_resources. A resource
+// affected by several tries of the job is saved once, with the time of the first.
+func (s *store) index(jl proto.JobLog) error {
+	var values []string
+	var args []interface{}
+	for _, res := range jl.Resources {
+		if len(res) > MAX_RESOURCE_LEN {
+			log.Warnf("request %s: job %s resource not indexed: longer than %d characters: %.50s...", jl.RequestId, jl.JobId, MAX_RESOURCE_LEN, res)
+			continue
+		}
+		values = append(values, "(?, ?, ?, ?)")
+		args = append(args, res, jl.RequestId, jl.JobId, jl.StartedAt)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	q := "INSERT IGNORE INTO request_resources (resource, request_id, job_id, affected_at) VALUES " + strings.Join(values, ", ")
+	if _, err := s.dbc.ExecContext(context.TODO(), q, args...); err != nil {
+		return serr.NewDbError(err, "INSERT request_resources")
+	}
+	return nil
+}
+
+// trim deletes old tries of the job if more than maxTries are saved, then the
+// oldest tries in the request if more than maxPerRequest JLs are saved.
+func (s *store) trim(jl proto.JobLog) error {
+	ctx := context.TODO()
+	if s.maxTries > 0 && jl.Try > s.maxTries {
+		q := "DELETE FROM job_log WHERE request_id = ? AND job_id = ? AND try <= ?"
+		if _, err := s.dbc.ExecContext(ctx, q, jl.RequestId, jl.JobId, jl.Try-s.maxTries); err != nil {
+			return err
+		}
+	}
+	if s.maxPerRequest == 0 {
+		return nil
+	}
+
+	var n uint
+	q := "SELECT COUNT(*) FROM job_log WHERE request_id = ?"
+	if err := s.dbc.QueryRowContext(ctx, q, jl.RequestId).Scan(&n); err != nil {
+		return err
+	}
+	if n <= s.maxPerRequest {
+		return nil
+	}
+
+	// Oldest tries first, except the last try of each job
+	type jobTry struct {
+		jobId string
+		try   uint
+	}
+	var tries []jobTry
+	lastTry := map[string]uint{}
+	q = "SELECT job_id, try FROM job_log WHERE request_id = ? ORDER BY finished_at, try"
+	rows, err := s.dbc.QueryContext(ctx, q, jl.RequestId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t jobTry
+		if err := rows.Scan(&t.jobId, &t.try); err != nil {
+			return err
+		}
+		tries = append(tries, t)
+		if t.try > lastTry[t.jobId] {
+			lastTry[t.jobId] = t.try
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close() // must close before new queries
+
+	excess := n - s.maxPerRequest
+	q = "DELETE FROM job_log WHERE request_id = ? AND job_id = ? AND try = ?"
+	for _, t := range tries {
+		if excess == 0 {
+			break
+		}
+		if t.try == lastTry[t.jobId] {
+			continue
+		}
+		if _, err := s.dbc.ExecContext(ctx, q, jl.RequestId, t.jobId, t.try); err != nil {
+			return err
+		}
+		excess--
+	}
+	return nil
+}
+
 func (s *store) Get(requestId, jobId string) (proto.JobLog, error) {
+	q := "SELECT request_id, job_id, name, type, state, started_at, finished_at, error, error_class, `exit`, stdout, stderr, try, data, log_entries, execs " +
+		" FROM job_log WHERE request_id = ? AND job_id = ? ORDER BY try DESC LIMIT 1"
+	return s.get(requestId, jobId, q, requestId, jobId)
+}
+
+func (s *store) GetTry(requestId, jobId string, try uint) (proto.JobLog, error) {
+	q := "SELECT request_id, job_id, name, type, state, started_at, finished_at, error, error_class, `exit`, stdout, stderr, try, data, log_entries, execs " +
+		" FROM job_log WHERE request_id = ? AND job_id = ? AND try = ?"
+	return s.get(requestId, jobId, q, requestId, jobId, try)
+}
+
+func (s *store) get(requestId, jobId, q string, args ...interface{}) (proto.JobLog, error) {
 	var jl proto.JobLog
 	ctx := context.TODO()
 
-	var jErr, stdout, stderr sql.NullString // nullable columns
+	var jErr, errClass, stdout, stderr sql.NullString // nullable columns
 	var exit sql.NullInt64
+	var data, entries, execs []byte
 
-	q := "SELECT request_id, job_id, name, type, state, started_at, finished_at, error, `exit`, stdout, stderr, try " +
-		" FROM job_log WHERE request_id = ? AND job_id = ? ORDER BY try DESC LIMIT 1"
-	err := s.dbc.QueryRowContext(ctx, q, requestId, jobId).Scan(
+	err := s.dbc.QueryRowContext(ctx, q, args...).Scan(
 		&jl.RequestId,
 		&jl.JobId,
 		&jl.Name,
@@ -79,14 +324,18 @@ func (s *store) Get(requestId, jobId string) (proto.JobLog, error) {
 		&jl.StartedAt,
 		&jl.FinishedAt,
 		&jErr,
+		&errClass,
 		&exit,
 		&stdout,
 		&stderr,
 		&jl.Try,
+		&data,
+		&entries,
+		&execs,
 	)
 	switch {
 	case err == sql.ErrNoRows:
-		return jl, serr.JobNotFound{RequestId: jl.RequestId, JobId: jl.JobId}
+		return jl, serr.JobNotFound{RequestId: requestId, JobId: jobId}
 	case err != nil:
 		return jl, err
 	}
@@ -94,6 +343,9 @@ func (s *store) Get(requestId, jobId string) (proto.JobLog, error) {
 	if jErr.Valid {
 		jl.Error = jErr.String
 	}
+	if errClass.Valid {
+		jl.ErrorClass = errClass.String
+	}
 	if stdout.Valid {
 		jl.Stdout = stdout.String
 	}
@@ -103,6 +355,15 @@ func (s *store) Get(requestId, jobId string) (proto.JobLog, error) {
 	if exit.Valid {
 		jl.Exit = exit.Int64
 	}
+	if err := unmarshalData(data, &jl); err != nil {
+		return jl, err
+	}
+	if err := unmarshalLog(entries, &jl); err != nil {
+		return jl, err
+	}
+	if err := unmarshalExecs(execs, &jl); err != nil {
+		return jl, err
+	}
 
 	return jl, nil
 }
@@ -110,10 +371,11 @@ func (s *store) Get(requestId, jobId string) (proto.JobLog, error) {
 func (s *store) GetFull(requestId string) ([]proto.JobLog, error) {
 	ctx := context.TODO()
 
-	var jErr, stdout, stderr sql.NullString // nullable columns
+	var jErr, errClass, stdout, stderr sql.NullString // nullable columns
 	var exit sql.NullInt64
+	var data, entries, execs []byte
 
-	q := "SELECT job_id, name, try, type, state, started_at, finished_at, error, `exit`, stdout, stderr" +
+	q := "SELECT job_id, name, try, type, state, started_at, finished_at, error, error_class, `exit`, stdout, stderr, data, log_entries, execs" +
 		" FROM job_log WHERE request_id = ?"
 	rows, err := s.dbc.QueryContext(ctx, q, requestId)
 	if err != nil {
@@ -136,9 +398,13 @@ func (s *store) GetFull(requestId string) ([]proto.JobLog, error) {
 			&l.StartedAt,
 			&l.FinishedAt,
 			&jErr,
+			&errClass,
 			&exit,
 			&stdout,
 			&stderr,
+			&data,
+			&entries,
+			&execs,
 		)
 		if err != nil {
 			return nil, err
@@ -147,6 +413,9 @@ func (s *store) GetFull(requestId string) ([]proto.JobLog, error) {
 		if jErr.Valid {
 			l.Error = jErr.String
 		}
+		if errClass.Valid {
+			l.ErrorClass = errClass.String
+		}
 		if stdout.Valid {
 			l.Stdout = stdout.String
 		}
@@ -156,6 +425,15 @@ func (s *store) GetFull(requestId string) ([]proto.JobLog, error) {
 		if exit.Valid {
 			l.Exit = exit.Int64
 		}
+		if err := unmarshalData(data, &l); err != nil {
+			return nil, err
+		}
+		if err := unmarshalLog(entries, &l); err != nil {
+			return nil, err
+		}
+		if err := unmarshalExecs(execs, &l); err != nil {
+			return nil, err
+		}
 
 		jl = append(jl, l)
 	}
@@ -165,3 +443,109 @@ func (s *store) GetFull(requestId string) ([]proto.JobLog, error) {
 
 	return jl, nil
 }
+
+func (s *store) Delete(requestId string, oldTries bool) (int64, error) {
+	if !oldTries {
+		q := "DELETE FROM request_resources WHERE request_id = ?"
+		if _, err := s.dbc.ExecContext(context.TODO(), q, requestId); err != nil {
+			return 0, serr.NewDbError(err, "DELETE request_resources")
+		}
+	}
+	q := "DELETE FROM job_log WHERE request_id = ?"
+	args := []interface{}{requestId}
+	if oldTries {
+		// MySQL cannot delete from a table it selects from in a subquery, so
+		// the latest tries are selected in a derived table
+		q = "DELETE j FROM job_log j JOIN (SELECT job_id, MAX(try) AS try FROM job_log WHERE request_id = ? GROUP BY job_id) l" +
+			" ON l.job_id = j.job_id WHERE j.request_id = ? AND j.try < l.try"
+		args = append(args, requestId)
+	}
+	res, err := s.dbc.ExecContext(context.TODO(), q, args...)
+	if err != nil {
+		return 0, serr.NewDbError(err, "DELETE job_log")
+	}
+	return res.RowsAffected()
+}
+
+func (s *store) Purge(finishedBefore time.Time) (int64, error) {
+	ctx := context.TODO()
+	var total int64
+	for {
+		// Requests finished before the time that still have JLs, in batches
+		// so one purge doesn't lock the whole table
+		var requestIds []interface{}
+		q := "SELECT DISTINCT j.request_id FROM job_log j JOIN requests r ON r.request_id = j.request_id" +
+			" WHERE r.finished_at < ? LIMIT ?"
+		rows, err := s.dbc.QueryContext(ctx, q, finishedBefore, PURGE_BATCH_SIZE)
+		if err != nil {
+			return total, serr.NewDbError(err, "SELECT job_log")
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return total, err
+			}
+			requestIds = append(requestIds, id)
+		}
+		rows.Close()
+		if len(requestIds) == 0 {
+			return total, nil
+		}
+
+		// Delete the resource index first so that, on error, the next purge
+		// finds the requests again because they still have JLs
+		in := "(" + strings.TrimSuffix(strings.Repeat("?,", len(requestIds)), ",") + ")"
+		q = "DELETE FROM request_resources WHERE request_id IN " + in
+		if _, err := s.dbc.ExecContext(ctx, q, requestIds...); err != nil {
+			return total, serr.NewDbError(err, "DELETE request_resources")
+		}
+
+		q = "DELETE FROM job_log WHERE request_id IN " + in
+		res, err := s.dbc.ExecContext(ctx, q, requestIds...)
+		if err != nil {
+			return total, serr.NewDbError(err, "DELETE job_log")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+}
+
+// unmarshalData sets jl.Data from the job_log.data column, which is NULL unless
+// the job completed with job data.
+func unmarshalData(data []byte, jl *proto.JobLog) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, &jl.Data); err != nil {
+		return fmt.Errorf("cannot unmarshal job data: %s", err)
+	}
+	return nil
+}
+
+// unmarshalLog sets jl.Log from the job_log.log_entries column, which is NULL
+// unless the job logged entries with its job.Logger.
+func unmarshalLog(entries []byte, jl *proto.JobLog) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(entries, &jl.Log); err != nil {
+		return fmt.Errorf("cannot unmarshal log entries: %s", err)
+	}
+	return nil
+}
+
+// unmarshalExecs sets jl.Execs from the job_log.execs column, which is NULL
+// unless the job ran commands with its job.Execs.
+func unmarshalExecs(execs []byte, jl *proto.JobLog) error {
+	if len(execs) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(execs, &jl.Execs); err != nil {
+		return fmt.Errorf("cannot unmarshal execs: %s", err)
+	}
+	return nil
+}
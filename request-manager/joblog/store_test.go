@@ -4,8 +4,12 @@ package joblog_test
 
 import (
 	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 
@@ -61,7 +65,7 @@ func TestGetNotFound(t *testing.T) {
 
 	reqId := "invalid"
 	jobId := "abcd"
-	s := joblog.NewStore(dbc)
+	s := joblog.NewStore(joblog.StoreConfig{DBConnector: dbc})
 	_, err := s.Get(reqId, jobId)
 	if err != nil {
 		switch v := err.(type) {
@@ -83,10 +87,11 @@ func TestCreateAndGet(t *testing.T) {
 	reqId := "fa0d862f16casg200lkf"
 	jobId1 := "fh17"
 	jl1 := proto.JobLog{
-		RequestId: reqId,
-		JobId:     jobId1,
-		Type:      "something",
-		State:     proto.STATE_FAIL,
+		RequestId:  reqId,
+		JobId:      jobId1,
+		Type:       "something",
+		State:      proto.STATE_FAIL,
+		ErrorClass: proto.ERROR_CLASS_PERMANENT,
 	}
 	jobId2 := "df2j"
 	jl2 := proto.JobLog{
@@ -94,10 +99,17 @@ func TestCreateAndGet(t *testing.T) {
 		JobId:     jobId2,
 		Type:      "something-else",
 		State:     proto.STATE_COMPLETE,
+		Log: []proto.LogEntry{
+			{Ts: 1, Level: proto.LOG_LEVEL_INFO, Msg: "checked host", Fields: map[string]interface{}{"host": "db1"}},
+		},
+		Execs: []proto.ExecRecord{
+			{Args: []string{"mysqladmin", "ping"}, Dir: "/tmp/ws", StartedAt: 1, Duration: 2, Exit: 0},
+			{Args: []string{"/nonexistent"}, StartedAt: 3, Exit: -1, Error: "fork/exec /nonexistent: no such file or directory"},
+		},
 	}
 	jls := []proto.JobLog{jl1, jl2}
 
-	s := joblog.NewStore(dbc)
+	s := joblog.NewStore(joblog.StoreConfig{DBConnector: dbc})
 	for _, j := range jls {
 		_, err := s.Create(reqId, j)
 		if err != nil {
@@ -114,6 +126,13 @@ func TestCreateAndGet(t *testing.T) {
 	if diff := deep.Equal(actualJl, jl2); diff != nil {
 		t.Error(diff)
 	}
+	actualJl, err = s.Get(reqId, jobId1)
+	if err != nil {
+		t.Errorf("error = %s, expected nil", err)
+	}
+	if diff := deep.Equal(actualJl, jl1); diff != nil {
+		t.Error(diff)
+	}
 }
 
 func TestGetFull(t *testing.T) {
@@ -121,7 +140,7 @@ func TestGetFull(t *testing.T) {
 	defer teardown(t, dbName)
 
 	reqId := "fa0d862f16casg200lkf"
-	s := joblog.NewStore(dbc)
+	s := joblog.NewStore(joblog.StoreConfig{DBConnector: dbc})
 	a, err := s.GetFull(reqId)
 	if err != nil {
 		t.Errorf("error = %s, expected nil", err)
@@ -146,3 +165,174 @@ func TestGetFull(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestMaxTries(t *testing.T) {
+	dbName := setup(t, "")
+	defer teardown(t, dbName)
+
+	reqId := "fa0d862f16casg200lkf"
+	s := joblog.NewStore(joblog.StoreConfig{DBConnector: dbc, MaxTries: 2})
+	for try := uint(1); try <= 3; try++ {
+		jl := proto.JobLog{JobId: "fh17", Try: try, Type: "restart", State: proto.STATE_FAIL}
+		if _, err := s.Create(reqId, jl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Try 1 deleted when try 3 saved
+	if _, err := s.GetTry(reqId, "fh17", 1); err == nil {
+		t.Error("got try 1, expected it to be deleted")
+	}
+	for try := uint(2); try <= 3; try++ {
+		jl, err := s.GetTry(reqId, "fh17", try)
+		if err != nil {
+			t.Errorf("try %d: error = %s, expected nil", try, err)
+		}
+		if jl.Try != try {
+			t.Errorf("got try %d, expected %d", jl.Try, try)
+		}
+	}
+}
+
+func TestMaxPerRequest(t *testing.T) {
+	dbName := setup(t, "")
+	defer teardown(t, dbName)
+
+	reqId := "fa0d862f16casg200lkf"
+	s := joblog.NewStore(joblog.StoreConfig{DBConnector: dbc, MaxPerRequest: 3})
+	jls := []proto.JobLog{
+		{JobId: "aaaa", Try: 1, FinishedAt: 1},
+		{JobId: "aaaa", Try: 2, FinishedAt: 2},
+		{JobId: "bbbb", Try: 1, FinishedAt: 3},
+		{JobId: "bbbb", Try: 2, FinishedAt: 4},
+		{JobId: "cccc", Try: 1, FinishedAt: 5},
+	}
+	for _, jl := range jls {
+		if _, err := s.Create(reqId, jl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Oldest tries that aren't the last try of a job are deleted
+	got, err := s.GetFull(reqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tries := []string{}
+	for _, jl := range got {
+		tries = append(tries, fmt.Sprintf("%s:%d", jl.JobId, jl.Try))
+	}
+	sort.Strings(tries)
+	if diff := deep.Equal(tries, []string{"aaaa:2", "bbbb:2", "cccc:1"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestDeleteAndPurge(t *testing.T) {
+	dbName := setup(t, test.DataPath+"/jl-default.sql")
+	defer teardown(t, dbName)
+
+	reqId := "fa0d862f16casg200lkf"
+	s := joblog.NewStore(joblog.StoreConfig{DBConnector: dbc})
+
+	// Old tries: only g89d has two tries
+	n, err := s.Delete(reqId, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("deleted %d, expected 1", n)
+	}
+	if _, err := s.GetTry(reqId, "g89d", 0); err == nil {
+		t.Error("got old try of g89d, expected it to be deleted")
+	}
+
+	// Purge ignores unfinished requests
+	n, err = s.Purge(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("purged %d, expected 0 for unfinished request", n)
+	}
+	_, err = dbc.Exec("UPDATE requests SET state = ?, finished_at = ? WHERE request_id = ?", proto.STATE_COMPLETE, time.Now().Add(-time.Hour), reqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = s.Purge(time.Now().Add(-2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("purged %d, expected 0 for request finished after time", n)
+	}
+	n, err = s.Purge(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("purged %d, expected 3", n)
+	}
+	got, err := s.GetFull(reqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %d job logs after purge, expected 0", len(got))
+	}
+}
+
+func TestAsyncStoreBatchInsert(t *testing.T) {
+	dbName := setup(t, test.DataPath+"/jl-default.sql")
+	defer teardown(t, dbName)
+
+	walDir, err := ioutil.TempDir("", "joblog-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(walDir)
+
+	s, err := joblog.NewAsyncStore(joblog.AsyncStoreConfig{
+		StoreConfig:   joblog.StoreConfig{DBConnector: dbc},
+		WALDir:        walDir,
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Three new JLs and one already in the db, which is ignored
+	reqId := "fa0d862f16casg200lkf"
+	jls := []proto.JobLog{
+		{JobId: "new1", Try: 1, Type: "something", State: proto.STATE_COMPLETE},
+		{JobId: "new2", Try: 1, Type: "something", State: proto.STATE_FAIL, ErrorClass: proto.ERROR_CLASS_PERMANENT},
+		{JobId: "new3", Try: 1, Type: "something", State: proto.STATE_COMPLETE},
+		testdb.SavedJLs[reqId][0],
+	}
+	for _, jl := range jls {
+		if _, err := s.Create(reqId, jl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db := joblog.NewStore(joblog.StoreConfig{DBConnector: dbc})
+	got, err := db.GetFull(reqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(testdb.SavedJLs[reqId])+3 {
+		t.Errorf("got %d job logs, expected %d", len(got), len(testdb.SavedJLs[reqId])+3)
+	}
+	jl, err := db.Get(reqId, "new2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jls[1].RequestId = reqId
+	if diff := deep.Equal(jl, jls[1]); diff != nil {
+		t.Error(diff)
+	}
+}
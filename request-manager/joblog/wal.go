@@ -0,0 +1,184 @@
+// Copyright 2020, Square, Inc.
+
+package joblog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/proto"
+)
+
+// walExt is the file extension of WAL segments.
+const walExt = ".wal"
+
+// A wal is an append-only write-ahead log of JLs in a directory. It's a sequence
+// of segment files named by number, like 00000000000000000001.wal, with one JSON
+// JL per line. JLs are appended to the current segment. When they're flushed,
+// the segment is rotated: closed and a new one opened, so the closed segment
+// can be removed once its JLs are saved in the db. A wal is not safe for
+// concurrent use; AsyncStore guards it.
+type wal struct {
+	dir  string
+	seq  uint64   // number of the current segment
+	file *os.File // current segment
+}
+
+// A walSegment is a closed segment and the JLs in it, which are not saved in the db.
+type walSegment struct {
+	seq     uint64
+	entries []walEntry
+}
+
+// A walEntry is a JL in the WAL and the size of its line, to limit batch size.
+type walEntry struct {
+	jl   proto.JobLog
+	size int
+}
+
+// openWAL opens the WAL in the directory and returns the segments left by the
+// last run, oldest first, which must be saved and removed. Empty segments, like
+// one with only a partial line, are removed. A new segment is opened after them
+// for new JLs.
+func openWAL(dir string) (*wal, []walSegment, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var seqs []uint64
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, walExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, walExt), 10, 64)
+		if err != nil {
+			continue // not a segment
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	w := &wal{dir: dir}
+	segments := make([]walSegment, 0, len(seqs))
+	for _, seq := range seqs {
+		entries, err := w.read(seq)
+		if err != nil {
+			return nil, nil, err
+		}
+		w.seq = seq
+		if len(entries) == 0 {
+			if err := w.remove(seq); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		segments = append(segments, walSegment{seq: seq, entries: entries})
+	}
+	if err := w.open(w.seq + 1); err != nil {
+		return nil, nil, err
+	}
+	return w, segments, nil
+}
+
+// read reads the JLs in a segment. A partial last line, from a crash while
+// appending, is ignored: the JL was not acknowledged, so the Job Runner resends it.
+func (w *wal) read(seq uint64) ([]walEntry, error) {
+	f, err := os.Open(w.path(seq))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []walEntry
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			if len(line) > 0 {
+				log.Warnf("job log WAL %s: ignoring partial last line (%d bytes)", w.path(seq), len(line))
+			}
+			break // io.EOF
+		}
+		var jl proto.JobLog
+		if err := json.Unmarshal(line, &jl); err != nil {
+			return nil, fmt.Errorf("job log WAL %s: invalid line %d: %s", w.path(seq), len(entries)+1, err)
+		}
+		entries = append(entries, walEntry{jl: jl, size: len(line)})
+	}
+	return entries, nil
+}
+
+// append appends the JL to the current segment and returns the size of its line.
+func (w *wal) append(jl proto.JobLog) (int, error) {
+	line, err := json.Marshal(jl)
+	if err != nil {
+		return 0, fmt.Errorf("cannot marshal job log: %s", err)
+	}
+	line = append(line, '\n')
+	if _, err := w.file.Write(line); err != nil {
+		return 0, fmt.Errorf("cannot write job log WAL %s: %s", w.file.Name(), err)
+	}
+	return len(line), nil
+}
+
+// rotate syncs the current segment, opens the next one, closes the current one,
+// and returns the number of the closed segment. The next segment is opened before
+// the current one is closed, so if it cannot be opened, the current segment is
+// still open and JLs are appended to it.
+func (w *wal) rotate() (uint64, error) {
+	if err := w.file.Sync(); err != nil {
+		return 0, err
+	}
+	file, closed := w.file, w.seq
+	if err := w.open(w.seq + 1); err != nil {
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		// Its JLs were synced, so the segment is complete
+		log.Warnf("job log WAL %s: error closing rotated segment: %s", file.Name(), err)
+	}
+	return closed, nil
+}
+
+// remove removes a closed segment.
+func (w *wal) remove(seq uint64) error {
+	return os.Remove(w.path(seq))
+}
+
+// close closes the current segment and removes it if it's empty.
+func (w *wal) close() error {
+	fi, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		return os.Remove(w.file.Name())
+	}
+	return nil
+}
+
+func (w *wal) open(seq uint64) error {
+	f, err := os.OpenFile(w.path(seq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	w.seq = seq
+	w.file = f
+	return nil
+}
+
+func (w *wal) path(seq uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d%s", seq, walExt))
+}
@@ -0,0 +1,59 @@
+// Copyright 2020, Square, Inc.
+
+package joblog
+
+import (
+	"os"
+	"testing"
+)
+
+func TestWALRotateOpenError(t *testing.T) {
+	dir := walDir(t)
+	defer os.RemoveAll(dir)
+
+	w, _, err := openWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	if _, err := w.append(testJL("job1", 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next segment cannot be opened because it's a directory
+	next := w.path(w.seq + 1)
+	if err := os.Mkdir(next, 0750); err != nil {
+		t.Fatal(err)
+	}
+	seq := w.seq
+	if _, err := w.rotate(); err == nil {
+		t.Fatal("rotate returned nil, expected an error opening the next segment")
+	}
+	if w.seq != seq {
+		t.Errorf("current segment %d, expected %d after rotate error", w.seq, seq)
+	}
+
+	// The current segment is still open, so JLs are still appended to it
+	if _, err := w.append(testJL("job2", 1)); err != nil {
+		t.Errorf("append after rotate error: %s", err)
+	}
+	entries, err := w.read(seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d JLs in current segment, expected 2", len(entries))
+	}
+
+	// Rotate works once the next segment can be opened
+	if err := os.Remove(next); err != nil {
+		t.Fatal(err)
+	}
+	closed, err := w.rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed != seq || w.seq != seq+1 {
+		t.Errorf("closed segment %d, current %d, expected %d and %d", closed, w.seq, seq, seq+1)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2020, Square, Inc.
+
+package rm
+
+import (
+	"github.com/square/spincycle/v2/proto"
+)
+
+// DEFAULT_PAGE_SIZE is the number of requests per FindRequests call made by
+// a RequestPager if no page size is given.
+const DEFAULT_PAGE_SIZE = 100
+
+// A RequestPager walks all requests matching a filter, one page (FindRequests
+// call with limit and offset) at a time:
+//
+//	p := rm.NewRequestPager(rmc, filter, 0)
+//	for p.HasMore() {
+//	    requests, err := p.Next()
+//	    ...
+//	}
+//
+// The filter Offset is where to start, and its Limit is the max number of
+// requests to return across all pages, or zero for all. If the RM returns fewer
+// requests than the page size on the first page, the RM might have capped it, so
+// the page size is lowered to that number. Then paging stops at the first page
+// with fewer requests. Requests created while paging shift later pages, so
+// requests already returned are not returned again. A RequestPager is not safe
+// for concurrent use.
+type RequestPager struct {
+	c        Client
+	filter   proto.RequestFilter
+	pageSize uint
+	limit    uint            // filter.Limit, 0 = no limit
+	n        uint            // requests returned
+	done     bool            // no more pages
+	capKnown bool            // page size is not more than the RM cap
+	seen     map[string]bool // request IDs returned
+}
+
+// NewRequestPager makes a RequestPager that calls FindRequests with the filter
+// and page size. If the page size is zero, DEFAULT_PAGE_SIZE is used.
+func NewRequestPager(c Client, filter proto.RequestFilter, pageSize uint) *RequestPager {
+	if pageSize == 0 {
+		pageSize = DEFAULT_PAGE_SIZE
+	}
+	return &RequestPager{
+		c:        c,
+		filter:   filter,
+		pageSize: pageSize,
+		limit:    filter.Limit,
+		seen:     map[string]bool{},
+	}
+}
+
+// HasMore returns true until Next has returned the last page or an error.
+func (p *RequestPager) HasMore() bool {
+	return !p.done
+}
+
+// Next returns the next page of requests. The last page can be empty. After an
+// error, HasMore returns false.
+func (p *RequestPager) Next() ([]proto.Request, error) {
+	if p.done {
+		return []proto.Request{}, nil
+	}
+	f := p.filter
+	f.Limit = p.pageSize
+	if p.limit > 0 && p.limit-p.n < f.Limit {
+		f.Limit = p.limit - p.n
+	}
+	page, err := p.c.FindRequests(f)
+	if err != nil {
+		p.done = true
+		return nil, err
+	}
+	p.filter.Offset += uint(len(page))
+
+	// A page with fewer requests than asked for is the last page, unless it's
+	// the first one: then the RM might have capped it
+	switch n := uint(len(page)); {
+	case n == 0:
+		p.done = true
+	case n < f.Limit:
+		if p.capKnown {
+			p.done = true
+		} else {
+			p.pageSize = n
+		}
+	}
+	p.capKnown = true
+
+	requests := make([]proto.Request, 0, len(page))
+	for _, r := range page {
+		if p.seen[r.Id] {
+			continue
+		}
+		p.seen[r.Id] = true
+		requests = append(requests, r)
+	}
+	p.n += uint(len(requests))
+	if p.limit > 0 && p.n >= p.limit {
+		p.done = true
+	}
+	return requests, nil
+}
+
+// ForEach calls fn for every request until there are no more requests or fn
+// returns an error, which ForEach returns.
+func (p *RequestPager) ForEach(fn func(proto.Request) error) error {
+	for p.HasMore() {
+		requests, err := p.Next()
+		if err != nil {
+			return err
+		}
+		for _, r := range requests {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// All returns all remaining requests.
+func (p *RequestPager) All() ([]proto.Request, error) {
+	all := []proto.Request{}
+	err := p.ForEach(func(r proto.Request) error {
+		all = append(all, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// FindAllRequests returns all requests matching the filter, up to filter.Limit
+// if set, calling FindRequests as many times as needed with DEFAULT_PAGE_SIZE.
+func FindAllRequests(c Client, filter proto.RequestFilter) ([]proto.Request, error) {
+	return NewRequestPager(c, filter, 0).All()
+}
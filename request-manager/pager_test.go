@@ -0,0 +1,143 @@
+// Copyright 2020, Square, Inc.
+
+package rm_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/proto"
+	rm "github.com/square/spincycle/v2/request-manager"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+// pagedRMClient returns n requests req0..req<n-1>, at most max per call
+func pagedRMClient(n, max int, filters *[]proto.RequestFilter) *mock.RMClient {
+	return &mock.RMClient{
+		FindRequestsFunc: func(f proto.RequestFilter) ([]proto.Request, error) {
+			*filters = append(*filters, f)
+			limit := int(f.Limit)
+			if limit == 0 || limit > max {
+				limit = max
+			}
+			page := []proto.Request{}
+			for i := int(f.Offset); i < n && len(page) < limit; i++ {
+				page = append(page, proto.Request{Id: fmt.Sprintf("req%d", i)})
+			}
+			return page, nil
+		},
+	}
+}
+
+func ids(requests []proto.Request) []string {
+	ids := make([]string, len(requests))
+	for i, r := range requests {
+		ids[i] = r.Id
+	}
+	return ids
+}
+
+func TestRequestPager(t *testing.T) {
+	// 7 requests, 3 per page: 3 calls, the last page is short
+	var filters []proto.RequestFilter
+	rmc := pagedRMClient(7, 100, &filters)
+	p := rm.NewRequestPager(rmc, proto.RequestFilter{User: "finch"}, 3)
+	var pages [][]string
+	for p.HasMore() {
+		requests, err := p.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, ids(requests))
+	}
+	expect := [][]string{{"req0", "req1", "req2"}, {"req3", "req4", "req5"}, {"req6"}}
+	if diff := deep.Equal(pages, expect); diff != nil {
+		t.Error(diff)
+	}
+	for i, f := range filters {
+		if f.User != "finch" || f.Limit != 3 || f.Offset != uint(i*3) {
+			t.Errorf("call %d: filter %+v, expected user finch, limit 3, offset %d", i, f, i*3)
+		}
+	}
+
+	// RM caps pages at 2: the page size is lowered, and all requests are
+	// returned
+	filters = nil
+	rmc = pagedRMClient(5, 2, &filters)
+	got, err := rm.FindAllRequests(rmc, proto.RequestFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(ids(got), []string{"req0", "req1", "req2", "req3", "req4"}); diff != nil {
+		t.Error(diff)
+	}
+	if len(filters) != 3 || filters[0].Limit != rm.DEFAULT_PAGE_SIZE || filters[1].Limit != 2 {
+		t.Errorf("got filters %+v, expected 3 calls, limit lowered to 2", filters)
+	}
+
+	// Filter offset and limit: start at req1, return at most 4 requests
+	filters = nil
+	rmc = pagedRMClient(10, 100, &filters)
+	got, err = rm.NewRequestPager(rmc, proto.RequestFilter{Offset: 1, Limit: 4}, 3).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(ids(got), []string{"req1", "req2", "req3", "req4"}); diff != nil {
+		t.Error(diff)
+	}
+	if len(filters) != 2 || filters[1].Limit != 1 {
+		t.Errorf("got filters %+v, expected 2 calls, limit 1 on the last", filters)
+	}
+}
+
+func TestRequestPagerShifted(t *testing.T) {
+	// A request is created after the first page, which shifts req2 onto the
+	// second page. It's not returned twice.
+	calls := 0
+	rmc := &mock.RMClient{
+		FindRequestsFunc: func(f proto.RequestFilter) ([]proto.Request, error) {
+			calls++
+			switch calls {
+			case 1:
+				return []proto.Request{{Id: "req1"}, {Id: "req2"}}, nil
+			case 2:
+				return []proto.Request{{Id: "req2"}, {Id: "req3"}}, nil
+			case 3:
+				return []proto.Request{}, nil
+			}
+			return nil, errors.New("too many calls")
+		},
+	}
+	var got []string
+	err := rm.NewRequestPager(rmc, proto.RequestFilter{}, 2).ForEach(func(r proto.Request) error {
+		got = append(got, r.Id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got, []string{"req1", "req2", "req3"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Errors from the RM and fn are returned
+	calls = 3
+	p := rm.NewRequestPager(rmc, proto.RequestFilter{}, 2)
+	if _, err := p.Next(); err == nil {
+		t.Error("no error from Next, expected RM error")
+	}
+	if p.HasMore() {
+		t.Error("HasMore true after error, expected false")
+	}
+	calls = 0
+	fnErr := errors.New("stop")
+	err = rm.NewRequestPager(rmc, proto.RequestFilter{}, 2).ForEach(func(r proto.Request) error {
+		return fnErr
+	})
+	if err != fnErr {
+		t.Errorf("got error %v, expected fn error", err)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2020, Square, Inc.
+
+// Package preset provides request presets: named requests (type and args), like
+// "nightly-backup-db01", saved by the Request Manager so users start them with
+// 'spinc start --preset <name>' instead of keeping them in shell scripts. Anyone
+// allowed to start the request type can create a preset. Only the user who
+// created a preset, or an admin, can change or delete it. Starting a preset is
+// starting its request, so request ACLs apply as usual.
+package preset
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+)
+
+const (
+	MAX_NAME_LEN        = 128
+	MAX_DESCRIPTION_LEN = 1000
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// A Manager saves request presets.
+type Manager interface {
+	// Put creates the preset, or replaces the type, args, and description of
+	// the preset with the same name. CreatedBy is set only when created.
+	Put(p proto.RequestPreset) (proto.RequestPreset, error)
+
+	// Get returns the preset, or serr.PresetNotFound.
+	Get(name string) (proto.RequestPreset, error)
+
+	// List returns all presets sorted by name.
+	List() ([]proto.RequestPreset, error)
+
+	// Delete deletes the preset, or returns serr.PresetNotFound.
+	Delete(name string) error
+}
+
+type ManagerConfig struct {
+	DBConnector *sql.DB // stores request_presets
+}
+
+type manager struct {
+	dbc *sql.DB
+}
+
+func NewManager(cfg ManagerConfig) Manager {
+	return &manager{
+		dbc: cfg.DBConnector,
+	}
+}
+
+func (m *manager) Put(p proto.RequestPreset) (proto.RequestPreset, error) {
+	if len(p.Name) > MAX_NAME_LEN || !validName.MatchString(p.Name) {
+		return p, serr.ValidationError{Message: fmt.Sprintf("invalid preset name %q: must be at most %d letters, digits, '.', '_', or '-', starting with a letter or digit", p.Name, MAX_NAME_LEN)}
+	}
+	if p.Type == "" {
+		return p, serr.ValidationError{Message: "preset type (request type) is required"}
+	}
+	if len(p.Description) > MAX_DESCRIPTION_LEN {
+		return p, serr.ValidationError{Message: fmt.Sprintf("preset description longer than %d characters", MAX_DESCRIPTION_LEN)}
+	}
+	if p.Args == nil {
+		p.Args = map[string]interface{}{}
+	}
+	argsBytes, err := json.Marshal(p.Args)
+	if err != nil {
+		return p, serr.ValidationError{Message: fmt.Sprintf("invalid preset args: %s", err)}
+	}
+
+	now := time.Now().UTC()
+	q := "INSERT INTO request_presets (name, type, args, description, created_by, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)" +
+		" ON DUPLICATE KEY UPDATE type = VALUES(type), args = VALUES(args), description = VALUES(description), updated_at = VALUES(updated_at)"
+	_, err = m.dbc.ExecContext(context.TODO(), q,
+		p.Name,
+		p.Type,
+		argsBytes,
+		p.Description,
+		p.CreatedBy,
+		now,
+		now,
+	)
+	if err != nil {
+		return p, serr.NewDbError(err, "INSERT request_presets")
+	}
+	return m.Get(p.Name)
+}
+
+func (m *manager) Get(name string) (proto.RequestPreset, error) {
+	q := "SELECT name, type, args, description, created_by, created_at, updated_at FROM request_presets WHERE name = ?"
+	p, err := scan(m.dbc.QueryRowContext(context.TODO(), q, name))
+	if err == sql.ErrNoRows {
+		return p, serr.PresetNotFound{Name: name}
+	}
+	return p, err
+}
+
+func (m *manager) List() ([]proto.RequestPreset, error) {
+	q := "SELECT name, type, args, description, created_by, created_at, updated_at FROM request_presets ORDER BY name"
+	rows, err := m.dbc.QueryContext(context.TODO(), q)
+	if err != nil {
+		return nil, serr.NewDbError(err, "SELECT request_presets")
+	}
+	defer rows.Close()
+	presets := []proto.RequestPreset{}
+	for rows.Next() {
+		p, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, serr.NewDbError(err, "SELECT request_presets")
+	}
+	return presets, nil
+}
+
+func (m *manager) Delete(name string) error {
+	res, err := m.dbc.ExecContext(context.TODO(), "DELETE FROM request_presets WHERE name = ?", name)
+	if err != nil {
+		return serr.NewDbError(err, "DELETE request_presets")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return serr.NewDbError(err, "DELETE request_presets")
+	}
+	if n == 0 {
+		return serr.PresetNotFound{Name: name}
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------------
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (proto.RequestPreset, error) {
+	var p proto.RequestPreset
+	var argsBytes []byte
+	err := row.Scan(
+		&p.Name,
+		&p.Type,
+		&argsBytes,
+		&p.Description,
+		&p.CreatedBy,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return p, err
+		}
+		return p, serr.NewDbError(err, "SELECT request_presets")
+	}
+	if err := json.Unmarshal(argsBytes, &p.Args); err != nil {
+		return p, fmt.Errorf("cannot unmarshal preset args: %s", err)
+	}
+	return p, nil
+}
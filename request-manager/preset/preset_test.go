@@ -0,0 +1,125 @@
+// Copyright 2020, Square, Inc.
+
+package preset_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/preset"
+	testdb "github.com/square/spincycle/v2/request-manager/test/db"
+)
+
+var dbm testdb.Manager
+var dbc *sql.DB
+
+func setup(t *testing.T, dataFile string) string {
+	var err error
+	if dbm == nil {
+		dbm, err = testdb.NewManager()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbName, err := dbm.Create(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbm.Connect(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbc = db
+	return dbName
+}
+
+func teardown(t *testing.T, dbName string) {
+	if err := dbm.Destroy(dbName); err != nil {
+		t.Fatal(err)
+	}
+	dbc.Close()
+}
+
+// //////////////////////////////////////////////////////////////////////////
+// Tests
+// //////////////////////////////////////////////////////////////////////////
+
+func TestPresets(t *testing.T) {
+	dbName := setup(t, "../test/data/request-default.sql")
+	defer teardown(t, dbName)
+
+	m := preset.NewManager(preset.ManagerConfig{DBConnector: dbc})
+
+	p, err := m.Put(proto.RequestPreset{
+		Name:        "nightly-backup-db01",
+		Type:        "backup-db",
+		Args:        map[string]interface{}{"host": "db01", "retain": float64(30)},
+		Description: "Nightly backup of db01",
+		CreatedBy:   "finch",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.CreatedAt.IsZero() || !p.UpdatedAt.Equal(p.CreatedAt) {
+		t.Errorf("created at %s, updated at %s, expected same non-zero time", p.CreatedAt, p.UpdatedAt)
+	}
+
+	// Replace keeps the creator
+	p2, err := m.Put(proto.RequestPreset{
+		Name:      "nightly-backup-db01",
+		Type:      "backup-db",
+		Args:      map[string]interface{}{"host": "db02"},
+		CreatedBy: "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2.CreatedBy != "finch" || p2.Args["host"] != "db02" || p2.Description != "" {
+		t.Errorf("got %+v, expected created by finch, host db02, no description", p2)
+	}
+
+	if _, err := m.Put(proto.RequestPreset{Name: "a-preset", Type: "restore-db"}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, p := range list {
+		names = append(names, p.Name)
+	}
+	if diff := deep.Equal(names, []string{"a-preset", "nightly-backup-db01"}); diff != nil {
+		t.Error(diff)
+	}
+
+	if err := m.Delete("a-preset"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("a-preset"); err != (serr.PresetNotFound{Name: "a-preset"}) {
+		t.Errorf("got error %v, expected serr.PresetNotFound", err)
+	}
+	if err := m.Delete("a-preset"); err != (serr.PresetNotFound{Name: "a-preset"}) {
+		t.Errorf("got error %v, expected serr.PresetNotFound", err)
+	}
+}
+
+func TestPutInvalid(t *testing.T) {
+	m := preset.NewManager(preset.ManagerConfig{}) // not saved, so no db
+	invalid := []proto.RequestPreset{
+		{Name: "", Type: "backup-db"},
+		{Name: "has space", Type: "backup-db"},
+		{Name: "-dash-first", Type: "backup-db"},
+		{Name: "no-type"},
+	}
+	for _, p := range invalid {
+		_, err := m.Put(p)
+		if _, ok := err.(serr.ValidationError); !ok {
+			t.Errorf("%+v: got error %v, expected serr.ValidationError", p, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2020, Square, Inc.
+
+// Package profile stores profiles attached to requests. When an admin asks, a
+// Job Runner with profiling enabled captures CPU and heap profiles (pprof format)
+// while the request runs and sends them to the Request Manager, which stores
+// them so they can be downloaded and analyzed with go tool pprof.
+package profile
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/rs/xid"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// MAX_SIZE is the max size of a profile (data), in bytes.
+const MAX_SIZE = 16 * 1024 * 1024
+
+// A Manager stores profiles attached to requests.
+type Manager interface {
+	// Create attaches the profile to its request and returns it, without data.
+	Create(p proto.Profile) (proto.Profile, error)
+
+	// List returns the profiles attached to the request, without data, in the
+	// order they were captured.
+	List(requestId string) ([]proto.Profile, error)
+
+	// Get returns the profile, with data, or serr.ProfileNotFound.
+	Get(requestId, profileId string) (proto.Profile, error)
+}
+
+type ManagerConfig struct {
+	DBConnector *sql.DB
+}
+
+type manager struct {
+	dbc *sql.DB
+}
+
+func NewManager(cfg ManagerConfig) Manager {
+	return &manager{
+		dbc: cfg.DBConnector,
+	}
+}
+
+func (m *manager) Create(p proto.Profile) (proto.Profile, error) {
+	if p.RequestId == "" {
+		return p, serr.ValidationError{Message: "requestId is required"}
+	}
+	if p.Type != proto.PROFILE_CPU && p.Type != proto.PROFILE_HEAP {
+		return p, serr.ValidationError{Message: "invalid type " + p.Type + ": valid types are " + proto.PROFILE_CPU + " and " + proto.PROFILE_HEAP}
+	}
+	if len(p.Data) == 0 || len(p.Data) > MAX_SIZE {
+		return p, serr.ValidationError{Message: "invalid data: must not be empty or larger than 16 MB"}
+	}
+	p.Id = xid.New().String()
+	p.Size = len(p.Data)
+
+	q := "INSERT INTO request_profiles (profile_id, request_id, type, job_runner, started_at, finished_at, size, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err := m.dbc.ExecContext(context.TODO(), q,
+		p.Id,
+		p.RequestId,
+		p.Type,
+		p.JobRunner,
+		p.StartedAt,
+		p.FinishedAt,
+		p.Size,
+		p.Data,
+	)
+	if err != nil {
+		return p, serr.NewDbError(err, "INSERT request_profiles")
+	}
+	p.Data = nil
+	return p, nil
+}
+
+func (m *manager) List(requestId string) ([]proto.Profile, error) {
+	q := "SELECT profile_id, request_id, type, job_runner, started_at, finished_at, size FROM request_profiles WHERE request_id = ? ORDER BY started_at, profile_id"
+	rows, err := m.dbc.QueryContext(context.TODO(), q, requestId)
+	if err != nil {
+		return nil, serr.NewDbError(err, "SELECT request_profiles")
+	}
+	defer rows.Close()
+	profiles := []proto.Profile{}
+	for rows.Next() {
+		var p proto.Profile
+		if err := rows.Scan(&p.Id, &p.RequestId, &p.Type, &p.JobRunner, &p.StartedAt, &p.FinishedAt, &p.Size); err != nil {
+			return nil, serr.NewDbError(err, "SELECT request_profiles")
+		}
+		profiles = append(profiles, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, serr.NewDbError(err, "SELECT request_profiles")
+	}
+	return profiles, nil
+}
+
+func (m *manager) Get(requestId, profileId string) (proto.Profile, error) {
+	var p proto.Profile
+	q := "SELECT profile_id, request_id, type, job_runner, started_at, finished_at, size, data FROM request_profiles WHERE request_id = ? AND profile_id = ?"
+	err := m.dbc.QueryRowContext(context.TODO(), q, requestId, profileId).Scan(&p.Id, &p.RequestId, &p.Type, &p.JobRunner, &p.StartedAt, &p.FinishedAt, &p.Size, &p.Data)
+	switch {
+	case err == sql.ErrNoRows:
+		return p, serr.ProfileNotFound{RequestId: requestId, ProfileId: profileId}
+	case err != nil:
+		return p, serr.NewDbError(err, "SELECT request_profiles")
+	}
+	return p, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2020, Square, Inc.
+
+package profile_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/profile"
+	testdb "github.com/square/spincycle/v2/request-manager/test/db"
+)
+
+var dbm testdb.Manager
+var dbc *sql.DB
+
+func setup(t *testing.T, dataFile string) string {
+	var err error
+	if dbm == nil {
+		dbm, err = testdb.NewManager()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbName, err := dbm.Create(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbm.Connect(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbc = db
+	return dbName
+}
+
+func teardown(t *testing.T, dbName string) {
+	if err := dbm.Destroy(dbName); err != nil {
+		t.Fatal(err)
+	}
+	dbc.Close()
+}
+
+// //////////////////////////////////////////////////////////////////////////
+// Tests
+// //////////////////////////////////////////////////////////////////////////
+
+func TestCreateListGet(t *testing.T) {
+	dbName := setup(t, "../test/data/request-default.sql")
+	defer teardown(t, dbName)
+
+	m := profile.NewManager(profile.ManagerConfig{DBConnector: dbc})
+
+	reqId := "454ae2f98a05cv16sdwt"
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	cpu, err := m.Create(proto.Profile{
+		RequestId:  reqId,
+		Type:       proto.PROFILE_CPU,
+		JobRunner:  "jr1",
+		StartedAt:  now,
+		FinishedAt: now.Add(30 * time.Second),
+		Data:       []byte("cpu profile"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu.Id == "" || cpu.Size != 11 || cpu.Data != nil {
+		t.Errorf("got profile %+v, expected ID, size 11, and no data", cpu)
+	}
+	heap, err := m.Create(proto.Profile{
+		RequestId:  reqId,
+		Type:       proto.PROFILE_HEAP,
+		JobRunner:  "jr1",
+		StartedAt:  now.Add(30 * time.Second),
+		FinishedAt: now.Add(30 * time.Second),
+		Data:       []byte("heap profile"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := m.List(reqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 || profiles[0].Id != cpu.Id || profiles[1].Id != heap.Id {
+		t.Fatalf("got profiles %+v, expected cpu then heap", profiles)
+	}
+	if profiles[0].Data != nil {
+		t.Errorf("List returned data, expected none")
+	}
+
+	got, err := m.Get(reqId, heap.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != proto.PROFILE_HEAP || string(got.Data) != "heap profile" {
+		t.Errorf("got profile %+v, expected heap with data", got)
+	}
+
+	// Profile of another request
+	_, err = m.Get("93ec156e204ety45sgf0", heap.Id)
+	if _, ok := err.(serr.ProfileNotFound); !ok {
+		t.Errorf("got error %v (%T), expected serr.ProfileNotFound", err, err)
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	m := profile.NewManager(profile.ManagerConfig{})
+	for _, p := range []proto.Profile{
+		{Type: proto.PROFILE_CPU, Data: []byte("x")},
+		{RequestId: "abc", Type: "goroutine", Data: []byte("x")},
+		{RequestId: "abc", Type: proto.PROFILE_HEAP},
+	} {
+		_, err := m.Create(p)
+		if _, ok := err.(serr.ValidationError); !ok {
+			t.Errorf("%+v: got error %v (%T), expected serr.ValidationError", p, err, err)
+		}
+	}
+}
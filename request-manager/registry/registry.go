@@ -0,0 +1,235 @@
+// Copyright 2020, Square, Inc.
+
+// Package registry tracks Job Runners that register with the Request Manager.
+// Job Runners register on startup, send heartbeats with their capacity and
+// number of running job chains, and deregister on shutdown. The Request Manager
+// uses the registry to choose a live Job Runner for new and resumed job chains,
+// which must have the capabilities that their jobs need (spec needs:), and to find dead Job Runners (no heartbeat within the timeout) whose running
+// requests must be recovered.
+package registry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// A Manager registers Job Runners and chooses which one runs a job chain.
+type Manager interface {
+	// Heartbeat registers the Job Runner or updates its registration.
+	Heartbeat(jr proto.JobRunner) error
+
+	// Deregister removes the Job Runner. Deregistering an unknown Job Runner
+	// is not an error.
+	Deregister(url string) error
+
+	// List returns all registered Job Runners, ordered by URL.
+	List() ([]proto.JobRunner, error)
+
+	// URL returns the base URL of the Job Runner to run a job chain whose jobs
+	// need the capabilities (validate.Needs): the live Job Runner with all of them
+	// running the fewest job chains, preferring those below capacity. If no such
+	// Job Runner is live, or on error, it returns the default URL.
+	URL(needs []string) string
+
+	// Dead returns the URLs of registered Job Runners that have not sent a
+	// heartbeat within the timeout. They remain registered until deregistered,
+	// which the caller does after recovering their running requests.
+	Dead() ([]string, error)
+}
+
+type ManagerConfig struct {
+	DBConnector *sql.DB
+	Timeout     time.Duration // Job Runner is dead if no heartbeat within
+	DefaultURL  string        // config jr_client.url
+}
+
+type manager struct {
+	dbc        *sql.DB
+	timeout    time.Duration
+	defaultURL string
+}
+
+func NewManager(cfg ManagerConfig) Manager {
+	return &manager{
+		dbc:        cfg.DBConnector,
+		timeout:    cfg.Timeout,
+		defaultURL: cfg.DefaultURL,
+	}
+}
+
+func (m *manager) Heartbeat(jr proto.JobRunner) error {
+	if jr.URL == "" {
+		return serr.ValidationError{Message: "url is required"}
+	}
+	var labels, capabilities []byte // NULL if none
+	if len(jr.Labels) > 0 {
+		var err error
+		labels, err = json.Marshal(jr.Labels)
+		if err != nil {
+			return fmt.Errorf("cannot marshal labels: %s", err)
+		}
+	}
+	if len(jr.Capabilities) > 0 {
+		var err error
+		capabilities, err = json.Marshal(jr.Capabilities)
+		if err != nil {
+			return fmt.Errorf("cannot marshal capabilities: %s", err)
+		}
+	}
+	if jr.StartedAt.IsZero() {
+		jr.StartedAt = time.Now()
+	}
+	q := "INSERT INTO job_runners (url, labels, capabilities, capacity, running, started_at, heartbeat_at) VALUES (?, ?, ?, ?, ?, ?, NOW(6))" +
+		" ON DUPLICATE KEY UPDATE labels = VALUES(labels), capabilities = VALUES(capabilities), capacity = VALUES(capacity)," +
+		" running = VALUES(running), started_at = VALUES(started_at), heartbeat_at = NOW(6)"
+	_, err := m.dbc.ExecContext(context.TODO(), q, jr.URL, labels, capabilities, jr.Capacity, jr.Running, jr.StartedAt.UTC())
+	if err != nil {
+		return serr.NewDbError(err, "INSERT job_runners")
+	}
+	return nil
+}
+
+func (m *manager) Deregister(url string) error {
+	_, err := m.dbc.ExecContext(context.TODO(), "DELETE FROM job_runners WHERE url = ?", url)
+	if err != nil {
+		return serr.NewDbError(err, "DELETE job_runners")
+	}
+	return nil
+}
+
+func (m *manager) List() ([]proto.JobRunner, error) {
+	q := "SELECT url, labels, capabilities, capacity, running, started_at, heartbeat_at, heartbeat_at >= NOW(6) - INTERVAL ? MICROSECOND" +
+		" FROM job_runners ORDER BY url"
+	rows, err := m.dbc.QueryContext(context.TODO(), q, m.timeout.Microseconds())
+	if err != nil {
+		return nil, serr.NewDbError(err, "SELECT job_runners")
+	}
+	defer rows.Close()
+	jrs := []proto.JobRunner{}
+	for rows.Next() {
+		var jr proto.JobRunner
+		var labels, capabilities []byte
+		if err := rows.Scan(&jr.URL, &labels, &capabilities, &jr.Capacity, &jr.Running, &jr.StartedAt, &jr.HeartbeatAt, &jr.Alive); err != nil {
+			return nil, serr.NewDbError(err, "SELECT job_runners")
+		}
+		if len(labels) > 0 {
+			if err := json.Unmarshal(labels, &jr.Labels); err != nil {
+				return nil, fmt.Errorf("cannot unmarshal labels of Job Runner %s: %s", jr.URL, err)
+			}
+		}
+		if len(capabilities) > 0 {
+			if err := json.Unmarshal(capabilities, &jr.Capabilities); err != nil {
+				return nil, fmt.Errorf("cannot unmarshal capabilities of Job Runner %s: %s", jr.URL, err)
+			}
+		}
+		jrs = append(jrs, jr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, serr.NewDbError(err, "SELECT job_runners")
+	}
+	return jrs, nil
+}
+
+func (m *manager) URL(needs []string) string {
+	ctx := context.TODO()
+	q := "SELECT url, capabilities, capacity, running FROM job_runners WHERE heartbeat_at >= NOW(6) - INTERVAL ? MICROSECOND" +
+		" ORDER BY running, url"
+	rows, err := m.dbc.QueryContext(ctx, q, m.timeout.Microseconds())
+	if err != nil {
+		log.Warnf("cannot get live Job Runners, using %s: %s", m.defaultURL, err)
+		return m.defaultURL
+	}
+	defer rows.Close()
+	var url, first string
+	for rows.Next() {
+		var jrURL string
+		var capabilities []byte
+		var capacity, running uint
+		if err := rows.Scan(&jrURL, &capabilities, &capacity, &running); err != nil {
+			log.Warnf("cannot get live Job Runners, using %s: %s", m.defaultURL, err)
+			return m.defaultURL
+		}
+		if !hasCapabilities(capabilities, needs) {
+			continue
+		}
+		if first == "" {
+			first = jrURL
+		}
+		if capacity == 0 || running < capacity {
+			url = jrURL
+			break
+		}
+	}
+	rows.Close()
+	if url == "" {
+		url = first // all at capacity: least loaded
+	}
+	if url == "" {
+		if len(needs) > 0 {
+			log.Warnf("no live Job Runner has capabilities %s, using %s", strings.Join(needs, ", "), m.defaultURL)
+		}
+		return m.defaultURL
+	}
+
+	// Count the job chain until the next heartbeat reports the real count,
+	// so job chains started between heartbeats are not all sent to this JR
+	q = "UPDATE job_runners SET running = running + 1 WHERE url = ?"
+	if _, err := m.dbc.ExecContext(ctx, q, url); err != nil {
+		log.Warnf("cannot update running job chains of Job Runner %s: %s", url, err)
+	}
+	return url
+}
+
+// hasCapabilities returns true if the capabilities, a JSON list or NULL, have
+// all the needs.
+func hasCapabilities(capabilities []byte, needs []string) bool {
+	if len(needs) == 0 {
+		return true
+	}
+	var have []string
+	if len(capabilities) > 0 {
+		if err := json.Unmarshal(capabilities, &have); err != nil {
+			return false
+		}
+	}
+NEEDS:
+	for _, need := range needs {
+		for _, c := range have {
+			if c == need {
+				continue NEEDS
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (m *manager) Dead() ([]string, error) {
+	q := "SELECT url FROM job_runners WHERE heartbeat_at < NOW(6) - INTERVAL ? MICROSECOND ORDER BY url"
+	rows, err := m.dbc.QueryContext(context.TODO(), q, m.timeout.Microseconds())
+	if err != nil {
+		return nil, serr.NewDbError(err, "SELECT job_runners")
+	}
+	defer rows.Close()
+	var urls []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, serr.NewDbError(err, "SELECT job_runners")
+		}
+		urls = append(urls, url)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, serr.NewDbError(err, "SELECT job_runners")
+	}
+	return urls, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2020, Square, Inc.
+
+package registry_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/registry"
+	"github.com/square/spincycle/v2/request-manager/test"
+	testdb "github.com/square/spincycle/v2/request-manager/test/db"
+)
+
+var dbm testdb.Manager
+var dbc *sql.DB
+
+const defaultURL = "http://jr-lb:32307"
+
+func setup(t *testing.T, dataFile string) string {
+	var err error
+	if dbm == nil {
+		dbm, err = testdb.NewManager()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbName, err := dbm.Create(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbm.Connect(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbc = db
+	return dbName
+}
+
+func teardown(t *testing.T, dbName string) {
+	if err := dbm.Destroy(dbName); err != nil {
+		t.Fatal(err)
+	}
+	dbc.Close()
+}
+
+func newManager() registry.Manager {
+	return registry.NewManager(registry.ManagerConfig{
+		DBConnector: dbc,
+		Timeout:     time.Minute,
+		DefaultURL:  defaultURL,
+	})
+}
+
+// //////////////////////////////////////////////////////////////////////////
+// Tests
+// //////////////////////////////////////////////////////////////////////////
+
+func TestHeartbeat(t *testing.T) {
+	dbName := setup(t, "")
+	defer teardown(t, dbName)
+	m := newManager()
+
+	// No JRs registered: default URL
+	if url := m.URL(nil); url != defaultURL {
+		t.Errorf("URL = %s, expected %s", url, defaultURL)
+	}
+
+	startedAt := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	jr := proto.JobRunner{
+		URL:       "http://jr1:32307",
+		Labels:       map[string]string{"zone": "a"},
+		Capabilities: []string{"docker"},
+		Capacity:     10,
+		Running:      2,
+		StartedAt:    startedAt,
+	}
+	if err := m.Heartbeat(jr); err != nil {
+		t.Fatal(err)
+	}
+	jr.Running = 3 // second heartbeat updates registration
+	if err := m.Heartbeat(jr); err != nil {
+		t.Fatal(err)
+	}
+
+	jrs, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jrs) != 1 {
+		t.Fatalf("got %d JRs, expected 1: %+v", len(jrs), jrs)
+	}
+	if jrs[0].HeartbeatAt.IsZero() {
+		t.Error("HeartbeatAt not set")
+	}
+	jrs[0].HeartbeatAt = time.Time{}
+	jrs[0].StartedAt = jrs[0].StartedAt.UTC()
+	expect := jr
+	expect.Alive = true
+	if diff := deep.Equal(jrs[0], expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Registered JR
+	if url := m.URL([]string{"docker"}); url != jr.URL {
+		t.Errorf("URL = %s, expected %s", url, jr.URL)
+	}
+
+	if err := m.Deregister(jr.URL); err != nil {
+		t.Fatal(err)
+	}
+	jrs, err = m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jrs) != 0 {
+		t.Errorf("got %d JRs, expected 0 after deregister: %+v", len(jrs), jrs)
+	}
+}
+
+func TestURLAndDead(t *testing.T) {
+	dbName := setup(t, test.DataPath+"/job-runners.sql")
+	defer teardown(t, dbName)
+	m := newManager()
+
+	// jr1 is at capacity and jr3 is dead, so jr2 even though it's running more
+	if url := m.URL(nil); url != "http://jr2:32307" {
+		t.Errorf("URL = %s, expected http://jr2:32307", url)
+	}
+
+	// Only jr1 has capability gpu, so jr1 even though it's at capacity; no JR
+	// has capability docker, so the default URL
+	if url := m.URL([]string{"gpu"}); url != "http://jr1:32307" {
+		t.Errorf("URL = %s, expected http://jr1:32307", url)
+	}
+	if url := m.URL([]string{"gpu", "docker"}); url != defaultURL {
+		t.Errorf("URL = %s, expected %s", url, defaultURL)
+	}
+
+	dead, err := m.Dead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(dead, []string{"http://jr3:32307"}); diff != nil {
+		t.Error(diff)
+	}
+
+	jrs, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alive := map[string]bool{}
+	running := map[string]uint{}
+	for _, jr := range jrs {
+		alive[jr.URL] = jr.Alive
+		running[jr.URL] = jr.Running
+	}
+	if diff := deep.Equal(alive, map[string]bool{"http://jr1:32307": true, "http://jr2:32307": true, "http://jr3:32307": false}); diff != nil {
+		t.Error(diff)
+	}
+	// URL counts the job chains sent to jr1 and jr2 until their next heartbeat
+	if running["http://jr1:32307"] != 6 {
+		t.Errorf("jr1 running = %d, expected 6", running["http://jr1:32307"])
+	}
+	if running["http://jr2:32307"] != 8 {
+		t.Errorf("jr2 running = %d, expected 8", running["http://jr2:32307"])
+	}
+}
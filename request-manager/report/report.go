@@ -0,0 +1,464 @@
+// Copyright 2020, Square, Inc.
+
+// Package report generates a self-contained report of a finished request: its
+// args, an image of its job chain, per-job timings, and the logs of failed job
+// tries. Reports are meant to be archived, for example attached to change
+// tickets, so they do not reference anything outside the report.
+package report
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/square/spincycle/v2/proto"
+)
+
+const (
+	FORMAT_HTML     = "html"
+	FORMAT_MARKDOWN = "markdown"
+)
+
+// MAX_GRAPH_JOBS is the max number of jobs in a job chain for which the report
+// includes an image of the job chain. Larger images are not readable anyway.
+const MAX_GRAPH_JOBS = 500
+
+// Report is a finished request and everything about it. Make one with New.
+type Report struct {
+	Request     proto.Request
+	GeneratedAt time.Time
+	Duration    time.Duration // request run time, FinishedAt - StartedAt
+	Jobs        []Job         // in topological order
+	Graph       string        // SVG image of job chain, empty if too many jobs
+}
+
+// Job is one job in a report.
+type Job struct {
+	Id         string
+	Name       string
+	Desc       string // from spec, shown in job chain image instead of Name
+	Type       string
+	State      byte // final state, STATE_PENDING if job did not run
+	Tries      int
+	StartedAt  time.Time // first try
+	FinishedAt time.Time // last try
+	Duration   time.Duration
+	Failed     []proto.JobLog // failed tries, in try order
+}
+
+// New makes a report of the request, which must have its job chain, from its
+// job logs.
+func New(req proto.Request, jls []proto.JobLog) Report {
+	r := Report{
+		Request:     req,
+		GeneratedAt: time.Now().UTC(),
+	}
+	if req.StartedAt != nil && req.FinishedAt != nil {
+		r.Duration = req.FinishedAt.Sub(*req.StartedAt)
+	}
+	if req.JobChain == nil {
+		return r
+	}
+
+	byJob := map[string][]proto.JobLog{}
+	for _, jl := range jls {
+		byJob[jl.JobId] = append(byJob[jl.JobId], jl)
+	}
+
+	levels := levels(req.JobChain)
+	for id, pj := range req.JobChain.Jobs {
+		job := Job{
+			Id:    id,
+			Name:  pj.Name,
+			Desc:  pj.Desc,
+			Type:  pj.Type,
+			State: proto.STATE_PENDING,
+		}
+		tries := byJob[id]
+		sort.Slice(tries, func(i, j int) bool { return tries[i].Try < tries[j].Try })
+		for _, jl := range tries {
+			if jl.State != proto.STATE_COMPLETE {
+				job.Failed = append(job.Failed, jl)
+			}
+		}
+		if len(tries) > 0 {
+			first, last := tries[0], tries[len(tries)-1]
+			job.Tries = len(tries)
+			job.State = last.State
+			job.StartedAt = time.Unix(0, first.StartedAt).UTC()
+			job.FinishedAt = time.Unix(0, last.FinishedAt).UTC()
+			if first.StartedAt > 0 && last.FinishedAt > first.StartedAt {
+				job.Duration = job.FinishedAt.Sub(job.StartedAt)
+			}
+		}
+		r.Jobs = append(r.Jobs, job)
+	}
+	sort.Slice(r.Jobs, func(i, j int) bool {
+		a, b := r.Jobs[i], r.Jobs[j]
+		if levels[a.Id] != levels[b.Id] {
+			return levels[a.Id] < levels[b.Id]
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Id < b.Id
+	})
+
+	if len(r.Jobs) <= MAX_GRAPH_JOBS {
+		r.Graph = graph(r.Jobs, levels, req.JobChain.AdjacencyList)
+	}
+	return r
+}
+
+// Write writes the report in the given format, FORMAT_HTML or FORMAT_MARKDOWN.
+func Write(w io.Writer, r Report, format string) error {
+	switch format {
+	case FORMAT_HTML:
+		return htmlTmpl.Execute(w, r)
+	case FORMAT_MARKDOWN:
+		return markdownTmpl.Execute(w, r)
+	}
+	return fmt.Errorf("invalid report format: %s (valid formats: %s, %s)", format, FORMAT_HTML, FORMAT_MARKDOWN)
+}
+
+// levels returns the level of every job in the job chain: the length of the
+// longest path to the job from a job with no previous jobs.
+func levels(jc *proto.JobChain) map[string]int {
+	inDegree := map[string]int{}
+	for id := range jc.Jobs {
+		inDegree[id] = 0
+	}
+	for _, next := range jc.AdjacencyList {
+		for _, id := range next {
+			inDegree[id]++
+		}
+	}
+	level := map[string]int{}
+	queue := []string{}
+	for id, n := range inDegree {
+		if n == 0 {
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range jc.AdjacencyList[id] {
+			if level[id]+1 > level[next] {
+				level[next] = level[id] + 1
+			}
+			inDegree[next]--
+			if inDegree[next] == 0 {
+				queue = append(queue, next)
+			}
+		}
+	}
+	return level
+}
+
+// --------------------------------------------------------------------------
+// Job chain image
+// --------------------------------------------------------------------------
+
+const (
+	boxWidth  = 180
+	boxHeight = 36
+	gapX      = 50
+	gapY      = 16
+	margin    = 10
+	maxLabel  = 26 // chars of job name shown in box
+)
+
+var stateColor = map[byte]string{
+	proto.STATE_COMPLETE: "#c8e6c9",
+	proto.STATE_FAIL:     "#ffcdd2",
+	proto.STATE_STOPPED:  "#ffe0b2",
+	proto.STATE_RUNNING:  "#bbdefb",
+}
+
+// graph returns an SVG image of the job chain: one column per level, jobs in
+// each column in report order, and a line from each job to its next jobs.
+// Jobs are colored by final state.
+func graph(jobs []Job, levels map[string]int, adjacencyList map[string][]string) string {
+	type point struct{ x, y int }
+	pos := map[string]point{}
+	rows := map[int]int{} // level => number of jobs
+	width, height := 0, 0
+	for _, job := range jobs {
+		l := levels[job.Id]
+		p := point{
+			x: margin + l*(boxWidth+gapX),
+			y: margin + rows[l]*(boxHeight+gapY),
+		}
+		rows[l]++
+		pos[job.Id] = p
+		if p.x+boxWidth+margin > width {
+			width = p.x + boxWidth + margin
+		}
+		if p.y+boxHeight+margin > height {
+			height = p.y + boxHeight + margin
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`, width, height)
+	for _, job := range jobs {
+		from := pos[job.Id]
+		for _, next := range adjacencyList[job.Id] {
+			to, ok := pos[next]
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#757575"/>`,
+				from.x+boxWidth, from.y+boxHeight/2, to.x, to.y+boxHeight/2)
+		}
+	}
+	for _, job := range jobs {
+		p := pos[job.Id]
+		color, ok := stateColor[job.State]
+		if !ok {
+			color = "#eeeeee"
+		}
+		label := job.Name
+		title := job.Name
+		if job.Desc != "" {
+			label = job.Desc
+			title = job.Desc + ": " + job.Name
+		}
+		if len(label) > maxLabel {
+			label = label[:maxLabel-3] + "..."
+		}
+		fmt.Fprintf(&b, `<g><title>%s (%s): %s</title>`, escape(title), escape(job.Id), proto.StateName[job.State])
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" rx="4" fill="%s" stroke="#424242"/>`,
+			p.x, p.y, boxWidth, boxHeight, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle" dominant-baseline="middle">%s</text></g>`,
+			p.x+boxWidth/2, p.y+boxHeight/2, escape(label))
+	}
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	htmltemplate.HTMLEscape(&b, []byte(s))
+	return b.String()
+}
+
+// --------------------------------------------------------------------------
+// Templates
+// --------------------------------------------------------------------------
+
+var funcs = map[string]interface{}{
+	"state": func(s byte) string { return proto.StateName[s] },
+	"time": func(t interface{}) string {
+		switch v := t.(type) {
+		case time.Time:
+			if v.IsZero() || v.Unix() <= 0 {
+				return ""
+			}
+			return v.UTC().Format(time.RFC3339)
+		case *time.Time:
+			if v == nil {
+				return ""
+			}
+			return v.UTC().Format(time.RFC3339)
+		case int64: // UnixNano
+			if v <= 0 {
+				return ""
+			}
+			return time.Unix(0, v).UTC().Format(time.RFC3339)
+		}
+		return fmt.Sprint(t)
+	},
+	"duration": func(d time.Duration) string {
+		if d <= 0 {
+			return ""
+		}
+		return d.Round(time.Millisecond).String()
+	},
+	"value": func(v interface{}) string {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprintf("%v", v)
+	},
+	"svg": func(s string) htmltemplate.HTML { return htmltemplate.HTML(s) },
+	"dataURI": func(s string) string {
+		return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"cell":  cell,
+	"fence": fence,
+}
+
+// cell returns s safe for a Markdown table cell.
+func cell(s string) string {
+	s = strings.Replace(s, "|", `\|`, -1)
+	s = strings.Replace(s, "\r", "", -1)
+	return strings.Replace(s, "\n", " ", -1)
+}
+
+// fence returns a Markdown code fence longer than any run of backticks in s,
+// so s cannot end the code block.
+func fence(s string) string {
+	max, n := 0, 0
+	for _, c := range s {
+		if c == '`' {
+			n++
+			if n > max {
+				max = n
+			}
+		} else {
+			n = 0
+		}
+	}
+	if max < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", max+1)
+}
+
+var markdownTmpl = template.Must(template.New("markdown").Funcs(funcs).Parse(`# Request {{.Request.Id}}
+
+| | |
+|-|-|
+| Type | {{cell .Request.Type}} |
+| State | {{state .Request.State}} |
+| User | {{cell .Request.User}} |
+| Created | {{time .Request.CreatedAt}} |
+| Started | {{time .Request.StartedAt}} |
+| Finished | {{time .Request.FinishedAt}} |
+| Duration | {{duration .Duration}} |
+| Jobs | {{.Request.FinishedJobs}} of {{.Request.TotalJobs}} complete |
+| Job Runner | {{cell .Request.JobRunnerURL}} |
+
+## Args
+{{if .Request.Args}}
+| Name | Type | Value |
+|------|------|-------|
+{{- range .Request.Args}}
+| {{cell .Name}} | {{.Type}} | {{cell (value .Value)}} |
+{{- end}}
+{{else}}
+None
+{{end}}
+## Job Chain
+{{if .Graph}}
+![job chain]({{dataURI .Graph}})
+{{else}}
+Job chain image omitted: more than {{.MaxGraphJobs}} jobs.
+{{end}}
+## Jobs
+
+| Job | Type | State | Tries | Started | Finished | Duration |
+|-----|------|-------|-------|---------|----------|----------|
+{{- range .Jobs}}
+| {{cell .Name}} ({{cell .Id}}) | {{cell .Type}} | {{state .State}} | {{.Tries}} | {{time .StartedAt}} | {{time .FinishedAt}} | {{duration .Duration}} |
+{{- end}}
+
+## Failed Tries
+{{range .Jobs}}{{$job := .}}{{range .Failed}}
+### {{$job.Name}} ({{$job.Id}}) try {{.Try}}
+
+| | |
+|-|-|
+| State | {{state .State}} |
+| Started | {{time .StartedAt}} |
+| Finished | {{time .FinishedAt}} |
+| Exit | {{.Exit}} |
+| Error | {{cell .Error}} |
+{{if .Stdout}}
+STDOUT:
+
+{{fence .Stdout}}
+{{.Stdout}}
+{{fence .Stdout}}
+{{end}}{{if .Stderr}}
+STDERR:
+
+{{fence .Stderr}}
+{{.Stderr}}
+{{fence .Stderr}}
+{{end}}{{end}}{{end}}
+---
+Generated {{time .GeneratedAt}}
+`))
+
+var htmlTmpl = htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Request {{.Request.Id}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #bdbdbd; padding: 4px 8px; text-align: left; vertical-align: top; }
+pre { background: #f5f5f5; padding: 8px; overflow-x: auto; }
+.graph { overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>Request {{.Request.Id}}</h1>
+<table>
+<tr><th>Type</th><td>{{.Request.Type}}</td></tr>
+<tr><th>State</th><td>{{state .Request.State}}</td></tr>
+<tr><th>User</th><td>{{.Request.User}}</td></tr>
+<tr><th>Created</th><td>{{time .Request.CreatedAt}}</td></tr>
+<tr><th>Started</th><td>{{time .Request.StartedAt}}</td></tr>
+<tr><th>Finished</th><td>{{time .Request.FinishedAt}}</td></tr>
+<tr><th>Duration</th><td>{{duration .Duration}}</td></tr>
+<tr><th>Jobs</th><td>{{.Request.FinishedJobs}} of {{.Request.TotalJobs}} complete</td></tr>
+<tr><th>Job Runner</th><td>{{.Request.JobRunnerURL}}</td></tr>
+</table>
+
+<h2>Args</h2>
+{{if .Request.Args}}<table>
+<tr><th>Name</th><th>Type</th><th>Value</th></tr>
+{{- range .Request.Args}}
+<tr><td>{{.Name}}</td><td>{{.Type}}</td><td>{{value .Value}}</td></tr>
+{{- end}}
+</table>
+{{else}}<p>None</p>
+{{end}}
+<h2>Job Chain</h2>
+{{if .Graph}}<div class="graph">{{svg .Graph}}</div>
+{{else}}<p>Job chain image omitted: more than {{.MaxGraphJobs}} jobs.</p>
+{{end}}
+<h2>Jobs</h2>
+<table>
+<tr><th>Job</th><th>Type</th><th>State</th><th>Tries</th><th>Started</th><th>Finished</th><th>Duration</th></tr>
+{{- range .Jobs}}
+<tr><td>{{.Name}} ({{.Id}})</td><td>{{.Type}}</td><td>{{state .State}}</td><td>{{.Tries}}</td><td>{{time .StartedAt}}</td><td>{{time .FinishedAt}}</td><td>{{duration .Duration}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Failed Tries</h2>
+{{range .Jobs}}{{$job := .}}{{range .Failed}}
+<h3>{{$job.Name}} ({{$job.Id}}) try {{.Try}}</h3>
+<table>
+<tr><th>State</th><td>{{state .State}}</td></tr>
+<tr><th>Started</th><td>{{time .StartedAt}}</td></tr>
+<tr><th>Finished</th><td>{{time .FinishedAt}}</td></tr>
+<tr><th>Exit</th><td>{{.Exit}}</td></tr>
+<tr><th>Error</th><td>{{.Error}}</td></tr>
+</table>
+{{if .Stdout}}<p>STDOUT:</p>
+<pre>{{.Stdout}}</pre>
+{{end}}{{if .Stderr}}<p>STDERR:</p>
+<pre>{{.Stderr}}</pre>
+{{end}}{{end}}{{end}}
+<hr>
+<p>Generated {{time .GeneratedAt}}</p>
+</body>
+</html>
+`))
+
+// MaxGraphJobs returns MAX_GRAPH_JOBS for the templates.
+func (r Report) MaxGraphJobs() int {
+	return MAX_GRAPH_JOBS
+}
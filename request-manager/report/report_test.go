@@ -0,0 +1,146 @@
+// Copyright 2020, Square, Inc.
+
+package report_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/report"
+)
+
+func testRequest() (proto.Request, []proto.JobLog) {
+	started := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	finished := started.Add(90 * time.Second)
+	req := proto.Request{
+		Id:         "req1",
+		Type:       "deploy",
+		State:      proto.STATE_FAIL,
+		User:       "finch",
+		Args:       []proto.RequestArg{{Name: "host", Type: proto.ARG_TYPE_REQUIRED, Value: "db|1"}},
+		CreatedAt:  started,
+		StartedAt:  &started,
+		FinishedAt: &finished,
+		JobChain: &proto.JobChain{
+			RequestId: "req1",
+			Jobs: map[string]proto.Job{
+				"j3": {Id: "j3", Name: "c", Type: "cleanup"},
+				"j2": {Id: "j2", Name: "b", Type: "restart", Desc: "Restart app"},
+				"j1": {Id: "j1", Name: "a", Type: "stop"},
+			},
+			AdjacencyList: map[string][]string{
+				"j1": {"j2"},
+				"j2": {"j3"},
+			},
+		},
+		TotalJobs:    3,
+		FinishedJobs: 1,
+	}
+	jls := []proto.JobLog{
+		{RequestId: "req1", JobId: "j2", Try: 2, State: proto.STATE_FAIL,
+			StartedAt: started.Add(20 * time.Second).UnixNano(), FinishedAt: started.Add(30 * time.Second).UnixNano(),
+			Error: "still down", Stderr: "```oops```"},
+		{RequestId: "req1", JobId: "j1", Try: 1, State: proto.STATE_COMPLETE,
+			StartedAt: started.UnixNano(), FinishedAt: started.Add(5 * time.Second).UnixNano()},
+		{RequestId: "req1", JobId: "j2", Try: 1, State: proto.STATE_FAIL,
+			StartedAt: started.Add(5 * time.Second).UnixNano(), FinishedAt: started.Add(10 * time.Second).UnixNano(),
+			Error: "<down>", Exit: 1},
+	}
+	return req, jls
+}
+
+func TestNew(t *testing.T) {
+	req, jls := testRequest()
+	r := report.New(req, jls)
+
+	if r.Duration != 90*time.Second {
+		t.Errorf("Duration = %s, expected 1m30s", r.Duration)
+	}
+	if len(r.Jobs) != 3 {
+		t.Fatalf("got %d jobs, expected 3", len(r.Jobs))
+	}
+	for i, id := range []string{"j1", "j2", "j3"} {
+		if r.Jobs[i].Id != id {
+			t.Errorf("job %d is %s, expected %s", i, r.Jobs[i].Id, id)
+		}
+	}
+
+	j2 := r.Jobs[1]
+	if j2.Tries != 2 {
+		t.Errorf("j2 Tries = %d, expected 2", j2.Tries)
+	}
+	if j2.State != proto.STATE_FAIL {
+		t.Errorf("j2 State = %s, expected FAIL", proto.StateName[j2.State])
+	}
+	if j2.Duration != 25*time.Second {
+		t.Errorf("j2 Duration = %s, expected 25s", j2.Duration)
+	}
+	if len(j2.Failed) != 2 || j2.Failed[0].Try != 1 || j2.Failed[1].Try != 2 {
+		t.Errorf("j2 Failed = %+v, expected tries 1 and 2", j2.Failed)
+	}
+
+	j3 := r.Jobs[2]
+	if j3.State != proto.STATE_PENDING || j3.Tries != 0 || len(j3.Failed) != 0 {
+		t.Errorf("j3 = %+v, expected PENDING with no tries", j3)
+	}
+
+	if !strings.HasPrefix(r.Graph, "<svg") {
+		t.Errorf("Graph is not an SVG: %s", r.Graph)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	req, jls := testRequest()
+	r := report.New(req, jls)
+
+	var buf bytes.Buffer
+	if err := report.Write(&buf, r, report.FORMAT_MARKDOWN); err != nil {
+		t.Fatal(err)
+	}
+	md := buf.String()
+	expect := []string{
+		"# Request req1",
+		"| host | required | db\\|1 |",
+		"![job chain](data:image/svg+xml;base64,",
+		"| b (j2) | restart | FAIL | 2 | 2020-06-01T12:00:05Z | 2020-06-01T12:00:30Z | 25s |",
+		"### b (j2) try 1",
+		"| Error | <down> |",
+		"````\n```oops```\n````",
+	}
+	for _, s := range expect {
+		if !strings.Contains(md, s) {
+			t.Errorf("markdown report does not contain %q:\n%s", s, md)
+		}
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	req, jls := testRequest()
+	r := report.New(req, jls)
+
+	var buf bytes.Buffer
+	if err := report.Write(&buf, r, report.FORMAT_HTML); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	expect := []string{
+		"<h1>Request req1</h1>",
+		"<div class=\"graph\"><svg ",
+		"<h3>b (j2) try 2</h3>",
+		"<title>Restart app: b (j2): FAIL</title>",
+		">Restart app</text>",
+		"<tr><th>Error</th><td>&lt;down&gt;</td></tr>",
+	}
+	for _, s := range expect {
+		if !strings.Contains(html, s) {
+			t.Errorf("HTML report does not contain %q:\n%s", s, html)
+		}
+	}
+
+	if err := report.Write(&buf, r, "pdf"); err == nil {
+		t.Error("no error for invalid format, expected one")
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"net/url"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
@@ -18,12 +18,21 @@ import (
 	"github.com/rs/xid"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/square/spincycle/v2/compress"
 	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/job"
 	jr "github.com/square/spincycle/v2/job-runner"
+	"github.com/square/spincycle/v2/metrics"
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/request-manager/graph"
+	"github.com/square/spincycle/v2/request-manager/id"
+	"github.com/square/spincycle/v2/request-manager/joblog"
+	"github.com/square/spincycle/v2/request-manager/registry"
+	"github.com/square/spincycle/v2/request-manager/shadow"
 	"github.com/square/spincycle/v2/request-manager/spec"
+	"github.com/square/spincycle/v2/request-manager/stats"
 	"github.com/square/spincycle/v2/retry"
+	"github.com/square/spincycle/v2/validate"
 )
 
 const (
@@ -31,14 +40,31 @@ const (
 	DB_RETRY_WAIT = time.Duration(500 * time.Millisecond)
 	JR_TRIES      = 5
 	JR_RETRY_WAIT = time.Duration(5 * time.Second)
+
+	JR_JOB_TYPES_TTL = time.Duration(1 * time.Minute) // how long job types of a JR are cached
+
+	MAX_DEDUP_KEY_LEN = 255 // requests.dedup_key
 )
 
 // A Manager creates and manages the life cycle of requests.
 type Manager interface {
 	// Create creates a request and saves it to the db. The request is not
-	// started; its state is pending until Start is called.
+	// started; its state is pending until Start is called. If the PreCreate
+	// hook rejects the request, serr.RequestRejected is returned.
 	Create(proto.CreateRequest) (proto.Request, error)
 
+	// Rerun creates a request that reruns a job and every job downstream of it
+	// in a finished request. The rerun is pinned to the spec version of the
+	// original request unless RerunRequest.Rebase is true. If RerunRequest.SkipSequence
+	// is set, the rerun skips the failed sequence, which must be skippable, and
+	// runs every job downstream of it. Like Create, the request is not started.
+	Rerun(proto.RerunRequest) (proto.Request, error)
+
+	// CreateFromChain creates a request that runs the given job chain instead
+	// of one built from a request spec. The job chain is validated like the Job
+	// Runner validates a new job chain. Like Create, the request is not started.
+	CreateFromChain(proto.CreateRequestFromChain) (proto.Request, error)
+
 	// Get retrieves the request corresponding to the provided id,
 	// without its job chain or parameters set.
 	Get(requestId string) (proto.Request, error)
@@ -47,12 +73,34 @@ type Manager interface {
 	// with its job chain and parameters.
 	GetWithJC(requestId string) (proto.Request, error)
 
-	// Start starts a request (sends it to the JR).
+	// Start starts a request (sends it to the JR). The request is saved in the
+	// outbox first, so if the RM crashes before the request is running, it is
+	// started by DispatchAll.
 	Start(requestId string) error
 
 	// Stop stops a request (sends a stop signal to the JR).
 	Stop(requestId string) error
 
+	// Pause pauses a running request: the JR doesn't start new jobs until it's
+	// resumed. The request is not suspended; it stays running.
+	Pause(requestId string) error
+
+	// Resume resumes a paused request.
+	Resume(requestId string) error
+
+	// CaptureProfile tells the JR running the request to capture profiles
+	// while the request runs. The JR attaches them to the request.
+	CaptureProfile(requestId string, pc proto.ProfileCapture) error
+
+	// AddJob adds a job to a running request. The job is made like other jobs,
+	// sent to the JR to run after the given job, and saved in the request's job
+	// chain. It returns the added job.
+	AddJob(requestId string, aj proto.AddJob) (proto.Job, error)
+
+	// ApproveJob approves a gate job (package gate) waiting for approval in a
+	// running request, so the request continues. The user is who approved it.
+	ApproveJob(requestId, jobId, user string) error
+
 	// Finish marks a request as being finished. It gets the request's final
 	// state from the proto.FinishRequest argument.
 	Finish(requestId string, finishParams proto.FinishRequest) error
@@ -60,16 +108,32 @@ type Manager interface {
 	// Fail a pending request (if it can't be started for some reason).
 	FailPending(requestId string) error
 
+	// DispatchAll starts requests in the outbox that were not started because
+	// the RM starting them crashed, and fails requests that were never put in
+	// the outbox or not started within an hour. All errors are logged, not
+	// returned.
+	DispatchAll()
+
 	// Specs returns a list of all the request specs the the RM knows about.
 	Specs() []proto.RequestSpec
 
 	// JobChain returns the job chain for the given request id.
 	JobChain(requestId string) (proto.JobChain, error)
 
+	// ArgsDiff returns the args submitted for the given request id compared
+	// to the final request args and the resolved jobArgs of every job.
+	ArgsDiff(requestId string) (proto.RequestArgsDiff, error)
+
+	// JobSnapshot returns the job of the given request id as it was run: its
+	// jobData is seeded from the job log of its upstream jobs, like Rerun, so
+	// they must have completed.
+	JobSnapshot(requestId, jobId string) (proto.JobSnapshot, error)
+
 	// Find returns a list of requests that match the given filter criteria,
-	// in descending order by create time (i.e. most recent first) and ascending
-	// by request id where create time is not unique. Returned requests do
-	// not have job chain or args set.
+	// by default in descending order by create time (i.e. most recent first)
+	// and ascending by request id where create time is not unique. Filter
+	// Sort and Order change the order. Returned requests do not have job chain
+	// or args set.
 	Find(filter proto.RequestFilter) ([]proto.Request, error)
 }
 
@@ -77,10 +141,24 @@ type Manager interface {
 type manager struct {
 	resolverFactory graph.ResolverFactory
 	sequences       map[string]*spec.Sequence
+	specVersions    map[string]string // request type => spec.Specs.Version
 	dbConnector     *sql.DB
 	jrClient        jr.Client
 	defaultJRURL    string
+	registry        registry.Manager
 	shutdownChan    chan struct{}
+	shadow          shadow.Manager
+	jls             joblog.Store
+	compression     string
+	jobFactory      job.Factory
+	idGenFactory    id.GeneratorFactory
+	addJobTypes     map[string]bool
+	host            string
+	metrics         metrics.Metrics
+	stats           stats.Manager
+	preCreate       func(spec.Sequence, *proto.CreateRequest) error
+	postCreate      func(proto.Request)
+	jrJobTypes      map[string]jrJobTypes // keyed on JR URL, guarded by Mutex
 	*sync.Mutex
 }
 
@@ -90,17 +168,61 @@ type ManagerConfig struct {
 	DBConnector     *sql.DB
 	JRClient        jr.Client
 	DefaultJRURL    string
+	Registry        registry.Manager // optional; chooses a live JR, else DefaultJRURL
 	ShutdownChan    chan struct{}
+	Shadow          shadow.Manager      // optional; starts shadow runs of started requests
+	JLStore         joblog.Store        // job data of original runs for Rerun
+	Compression     string              // optional; codec to compress stored job chains
+	JobFactory      job.Factory         // makes jobs added to running requests
+	IdGenFactory    id.GeneratorFactory // makes ids of jobs added to running requests
+	AddJobTypes     []string            // optional; job types that can be added to running requests
+	RMHost          string              // claims requests in the outbox
+	Metrics         metrics.Metrics     // optional; reports requests created and finished
+	Stats           stats.Manager       // optional; weighs jobs by the mean duration of their type
+
+	// Optional; called by Create for embedders (app.Hooks.PreCreateRequest
+	// and PostCreateRequest)
+	PreCreate  func(spec.Sequence, *proto.CreateRequest) error
+	PostCreate func(proto.Request)
 }
 
 func NewManager(config ManagerConfig) Manager {
+	addJobTypes := map[string]bool{}
+	for _, jobType := range config.AddJobTypes {
+		addJobTypes[jobType] = true
+	}
+	specs := spec.Specs{Sequences: config.Sequences}
+	m := config.Metrics
+	if m == nil {
+		m = metrics.Nop{}
+	}
+	specVersions := map[string]string{}
+	for name, seq := range config.Sequences {
+		if seq.Request {
+			specVersions[name] = specs.Version(name)
+		}
+	}
 	return &manager{
 		resolverFactory: config.ResolverFactory,
 		sequences:       config.Sequences,
+		specVersions:    specVersions,
 		dbConnector:     config.DBConnector,
 		jrClient:        config.JRClient,
 		defaultJRURL:    config.DefaultJRURL,
+		registry:        config.Registry,
 		shutdownChan:    config.ShutdownChan,
+		shadow:          config.Shadow,
+		jls:             config.JLStore,
+		compression:     config.Compression,
+		jobFactory:      config.JobFactory,
+		idGenFactory:    config.IdGenFactory,
+		addJobTypes:     addJobTypes,
+		host:            config.RMHost,
+		metrics:         m,
+		stats:           config.Stats,
+		preCreate:       config.PreCreate,
+		postCreate:      config.PostCreate,
+		jrJobTypes:      map[string]jrJobTypes{},
 		Mutex:           &sync.Mutex{},
 	}
 }
@@ -111,14 +233,45 @@ func (m *manager) Create(newReq proto.CreateRequest) (proto.Request, error) {
 		return req, serr.ErrInvalidCreateRequest{Message: "Type is empty, must be a request name"}
 	}
 
+	// PreCreate can reject or change the new request, except its type. It gets
+	// a copy of the args so it doesn't change the caller's map.
+	if seq, ok := m.sequences[newReq.Type]; ok && seq.Request && m.preCreate != nil {
+		reqType := newReq.Type
+		args := make(map[string]interface{}, len(newReq.Args))
+		for k, v := range newReq.Args {
+			args[k] = v
+		}
+		newReq.Args = args
+		if err := m.preCreate(*seq, &newReq); err != nil {
+			return req, serr.RequestRejected{Type: reqType, Reason: err.Error()}
+		}
+		if newReq.Type != reqType {
+			return req, fmt.Errorf("PreCreate hook changed request type from %s to %s, expected no change", reqType, newReq.Type)
+		}
+	}
+
+	if _, ok := proto.LogLevels[newReq.LogLevel]; newReq.LogLevel != "" && !ok {
+		return req, serr.ErrInvalidCreateRequest{Message: fmt.Sprintf("invalid log level %q: must be debug, info, warn, or error", newReq.LogLevel)}
+	}
+
+	// Overrides must be allowed by the request spec (overrides:). An unknown
+	// request type is an error from the resolver below.
+	if seq, ok := m.sequences[newReq.Type]; ok && len(newReq.Overrides) > 0 {
+		if err := seq.CheckOverrides(newReq.Overrides); err != nil {
+			return req, serr.ErrInvalidCreateRequest{Message: err.Error()}
+		}
+	}
+
 	reqIdBytes := xid.New()
 	reqId := reqIdBytes.String()
 	req = proto.Request{
-		Id:        reqId,
-		Type:      newReq.Type,
-		CreatedAt: time.Now().UTC(),
-		State:     proto.STATE_PENDING,
-		User:      newReq.User, // Caller.Name if not set by SetUsername
+		Id:          reqId,
+		Type:        newReq.Type,
+		CreatedAt:   time.Now().UTC(),
+		State:       proto.STATE_PENDING,
+		User:        newReq.User, // Caller.Name if not set by SetUsername
+		Operator:    newReq.Operator,
+		SpecVersion: m.specVersions[newReq.Type],
 	}
 
 	// ----------------------------------------------------------------------
@@ -131,6 +284,19 @@ func (m *manager) Create(newReq proto.CreateRequest) (proto.Request, error) {
 	}
 	req.Args = reqArgs
 
+	// The dedup key is made from the final request args, so an optional arg
+	// given as its default value dedups with the arg not given
+	if seq, ok := m.sequences[req.Type]; ok && seq.DedupKey != "" {
+		args := map[string]interface{}{}
+		for _, arg := range reqArgs {
+			args[arg.Name] = arg.Value
+		}
+		req.DedupKey = seq.MakeDedupKey(args)
+		if len(req.DedupKey) > MAX_DEDUP_KEY_LEN {
+			return req, serr.ErrInvalidCreateRequest{Message: fmt.Sprintf("dedup key is longer than %d characters: %s", MAX_DEDUP_KEY_LEN, req.DedupKey)}
+		}
+	}
+
 	// Copy requests args -> initial job args. We save the former as a record
 	// (request_archives.args) of every request arg that the request was started
 	// with. BuildRequestGraph modifies and greatly expands the latter (job args).
@@ -152,19 +318,47 @@ func (m *manager) Create(newReq proto.CreateRequest) (proto.Request, error) {
 		RequestId:     reqId,
 		State:         proto.STATE_PENDING,
 		Jobs:          map[string]proto.Job{},
+		User:          req.User,
+		Globals:       resolver.Globals(),
+		Overrides:     newReq.Overrides,
+		Webhooks:      resolver.Webhooks(),
+		Escalations:   resolver.Escalations(),
+		StrictFailure: newReq.StrictFailure,
+	}
+	if seq, ok := m.sequences[req.Type]; ok && seq.StrictFailure {
+		jc.StrictFailure = true
 	}
+	weights := m.jobWeights()
 	for jobId, node := range reqGraph.Nodes {
+		weight := node.Spec.Weight // spec weight: overrides historical weight
+		if weight == 0 {
+			weight = weights[*node.Spec.NodeType] // zero (weighs 1) if unknown
+		}
 		job := proto.Job{
 			Type:              *node.Spec.NodeType,
 			Id:                node.Id,
 			Name:              node.Name,
+			Desc:              node.Desc,
 			Bytes:             node.JobBytes,
 			Args:              node.Args,
+			ArgSources:        node.ArgSources,
 			Retry:             node.Retry,
 			RetryWait:         node.RetryWait,
+			RetryArgs:         node.RetryArgs,
+			KeepData:          node.KeepData,
 			SequenceId:        node.SequenceId,
 			SequenceRetry:     node.SequenceRetry,
 			SequenceRetryWait: node.SequenceRetryWait,
+			SequenceSkippable: node.SequenceSkippable,
+			Singleton:         node.Singleton,
+			SingletonPolicy:   node.SingletonPolicy,
+			Needs:             node.Needs,
+			LogLevel:          newReq.LogLevel,
+			Pace:              node.Pace,
+			PaceStart:         node.PaceStart,
+			Asserts:           node.Asserts,
+			Finally:           node.Finally,
+			Weight:            weight,
 			State:             proto.STATE_PENDING,
 		}
 		jc.Jobs[jobId] = job
@@ -173,26 +367,88 @@ func (m *manager) Create(newReq proto.CreateRequest) (proto.Request, error) {
 	req.JobChain = jc
 	req.TotalJobs = uint(len(jc.Jobs))
 
+	if err := m.save(req, newReq); err != nil {
+		return req, err
+	}
+	m.metrics.Count(metrics.REQUESTS_CREATED, 1, metrics.Tags{"type": req.Type})
+	if m.postCreate != nil {
+		m.postCreate(req)
+	}
+	return req, nil
+}
+
+// jobWeights returns the weight (proto.Job.Weight) of every job type with stats
+// in the last week: the mean duration of its tries in seconds, at least 1. It
+// returns nil if there are no stats yet, so jobs are weighed by their spec only.
+func (m *manager) jobWeights() map[string]uint {
+	if m.stats == nil {
+		return nil
+	}
+	s, ok := m.stats.Cached(proto.STATS_WINDOW_WEEK)
+	if !ok {
+		return nil
+	}
+	weights := make(map[string]uint, len(s.JobTypes))
+	for _, jt := range s.JobTypes {
+		w := uint(time.Duration(jt.MeanDuration).Round(time.Second) / time.Second)
+		if w == 0 {
+			w = 1
+		}
+		weights[jt.Type] = w
+	}
+	return weights
+}
+
+// save saves a new request and its job chain. request_archive is immutable data,
+// i.e. these never change now that request is fully created, except the job chain
+// when a job is added to a running request (AddJob). requests is highly
+// mutable, especially requests.state and requests.finished_jobs.
+func (m *manager) save(req proto.Request, newReq proto.CreateRequest) error {
+	reqIdBytes, err := xid.FromString(req.Id)
+	if err != nil {
+		return fmt.Errorf("invalid request ID %s: %s", req.Id, err)
+	}
+
 	// ----------------------------------------------------------------------
 	// Serial data for request_archives
 	jobChainBytes, err := json.Marshal(req.JobChain)
 	if err != nil {
-		return req, fmt.Errorf("cannot marshal job chain: %s", err)
+		return fmt.Errorf("cannot marshal job chain: %s", err)
+	}
+	jobChainBytes, err = compress.Pack(m.compression, jobChainBytes)
+	if err != nil {
+		return fmt.Errorf("cannot compress job chain: %s", err)
 	}
 	newReqBytes, err := json.Marshal(newReq)
 	if err != nil {
-		return req, fmt.Errorf("cannot marshal create request: %s", err)
+		return fmt.Errorf("cannot marshal create request: %s", err)
 	}
-	reqArgsBytes, err := json.Marshal(reqArgs)
+	reqArgsBytes, err := json.Marshal(req.Args)
 	if err != nil {
-		return req, fmt.Errorf("cannot marshal request args: %s", err)
+		return fmt.Errorf("cannot marshal request args: %s", err)
+	}
+
+	var dedupKey interface{} // NULL if no dedup key
+	if req.DedupKey != "" {
+		dedupKey = req.DedupKey
+	}
+	var specVersion interface{} // NULL if unknown
+	if req.SpecVersion != "" {
+		specVersion = req.SpecVersion
+	}
+	var operator interface{} // NULL if not run-as
+	if req.Operator != "" {
+		operator = req.Operator
 	}
 
 	// ----------------------------------------------------------------------
-	// Save everything in a transaction. request_archive is immutable data,
-	// i.e. these never change now that request is fully created. requests is
-	// highly mutable, especially requests.state and requests.finished_jobs.
+	// Save everything in a transaction. If the request has a dedup key, first
+	// check for an unfinished request with the same key. SELECT FOR UPDATE locks
+	// the key (or the gap where it would be) until commit, so concurrent creates
+	// with the same key are serialized: one waits, or deadlocks and is retried,
+	// then sees the other request.
 	ctx := context.TODO()
+	var dup *serr.DuplicateRequest
 	err = retry.Do(DB_TRIES, DB_RETRY_WAIT, func() error {
 		txn, err := m.dbConnector.BeginTx(ctx, nil)
 		if err != nil {
@@ -200,6 +456,24 @@ func (m *manager) Create(newReq proto.CreateRequest) (proto.Request, error) {
 		}
 		defer txn.Rollback()
 
+		if req.DedupKey != "" {
+			var dupId string
+			q := "SELECT request_id FROM requests WHERE dedup_key = ? AND state IN (?, ?, ?) LIMIT 1 FOR UPDATE"
+			err := txn.QueryRowContext(ctx, q, req.DedupKey, proto.STATE_PENDING, proto.STATE_RUNNING, proto.STATE_SUSPENDED).Scan(&dupId)
+			switch err {
+			case nil:
+				dup = &serr.DuplicateRequest{
+					RequestId: dupId,
+					DedupKey:  req.DedupKey,
+					Reject:    m.sequences[req.Type].DedupPolicy == spec.DEDUP_POLICY_ERROR,
+				}
+				return nil // don't try again
+			case sql.ErrNoRows:
+			default:
+				return serr.NewDbError(err, "SELECT requests")
+			}
+		}
+
 		q := "INSERT INTO request_archives (request_id, create_request, args, job_chain) VALUES (?, ?, ?, ?)"
 		_, err = txn.ExecContext(ctx, q,
 			reqIdBytes,
@@ -211,21 +485,382 @@ func (m *manager) Create(newReq proto.CreateRequest) (proto.Request, error) {
 			return serr.NewDbError(err, "INSERT request_archives")
 		}
 
-		q = "INSERT INTO requests (request_id, type, state, user, created_at, total_jobs) VALUES (?, ?, ?, ?, ?, ?)"
+		q = "INSERT INTO requests (request_id, type, state, user, operator, created_at, total_jobs, dedup_key, spec_version) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
 		_, err = txn.ExecContext(ctx, q,
 			reqIdBytes,
 			req.Type,
 			req.State,
 			req.User,
+			operator,
 			req.CreatedAt,
 			req.TotalJobs,
+			dedupKey,
+			specVersion,
 		)
 		if err != nil {
 			return serr.NewDbError(err, "INSERT requests")
 		}
+		if err := saveState(ctx, txn, req.Id, req.State, req.CreatedAt); err != nil {
+			return err
+		}
 		return txn.Commit()
 	}, nil)
-	return req, err
+	if err != nil {
+		return err
+	}
+	if dup != nil {
+		return *dup
+	}
+	return nil
+}
+
+func (m *manager) Rerun(rr proto.RerunRequest) (proto.Request, error) {
+	var req proto.Request
+	orig, err := m.GetWithJC(rr.RequestId)
+	if err != nil {
+		return req, err
+	}
+	switch orig.State {
+	case proto.STATE_COMPLETE, proto.STATE_FAIL, proto.STATE_STOPPED:
+	default:
+		return req, serr.NewErrInvalidState("COMPLETE, FAIL, or STOPPED", proto.StateName[orig.State])
+	}
+	if rr.SkipSequence != "" {
+		if rr.JobId != "" || rr.Rebase {
+			return req, serr.ValidationError{Message: "jobId and rebase cannot be set with skipSequence: the rerun starts after the skipped sequence"}
+		}
+		if orig.State != proto.STATE_FAIL {
+			return req, serr.NewErrInvalidState("FAIL", proto.StateName[orig.State])
+		}
+	}
+	if rr.Rebase {
+		return m.rebase(orig, rr)
+	}
+	jls, err := m.jls.GetFull(orig.Id)
+	if err != nil {
+		return req, err
+	}
+	var jc *proto.JobChain
+	if rr.SkipSequence != "" {
+		jc, err = skipSequenceChain(*orig.JobChain, rr.SkipSequence, lastTries(jls))
+	} else {
+		jc, err = rerunJobChain(*orig.JobChain, rr.JobId, lastTries(jls))
+	}
+	if err != nil {
+		return req, err
+	}
+
+	// The rerun is a new request of the same type with the same (final) args.
+	// Its jobs are copies, so they keep the resolved job args and bytes of the
+	// original request, even if the request spec has changed: the rerun is
+	// pinned to the spec version of the original request.
+	reqId := xid.New().String()
+	jc.RequestId = reqId
+	jc.User = rr.User
+	req = proto.Request{
+		Id:          reqId,
+		Type:        orig.Type,
+		CreatedAt:   time.Now().UTC(),
+		State:       proto.STATE_PENDING,
+		User:        rr.User,
+		Args:        orig.Args,
+		JobChain:    jc,
+		TotalJobs:   uint(len(jc.Jobs)),
+		SpecVersion: orig.SpecVersion,
+	}
+	if cur := m.specVersions[orig.Type]; orig.SpecVersion != "" && cur != orig.SpecVersion {
+		log.Infof("rerun request %s: spec changed from version %s to %s, rerun pinned to version %s", orig.Id, orig.SpecVersion, cur, orig.SpecVersion)
+	}
+	newReq := proto.CreateRequest{
+		Type:      orig.Type,
+		Args:      map[string]interface{}{},
+		User:      rr.User,
+		Overrides: jc.Overrides,
+	}
+	for _, arg := range orig.Args {
+		newReq.Args[arg.Name] = arg.Value
+	}
+	if rr.SkipSequence != "" {
+		log.Infof("rerun request %s skipping sequence %s as request %s (%d jobs)", orig.Id, rr.SkipSequence, reqId, len(jc.Jobs))
+	} else {
+		log.Infof("rerun request %s job %s as request %s (%d jobs)", orig.Id, rr.JobId, reqId, len(jc.Jobs))
+	}
+	return req, m.save(req, newReq)
+}
+
+func (m *manager) CreateFromChain(cr proto.CreateRequestFromChain) (proto.Request, error) {
+	var req proto.Request
+	if cr.Type == "" {
+		return req, serr.ErrInvalidCreateRequest{Message: "Type is empty, must be a request type"}
+	}
+	if len(cr.JobChain.Jobs) == 0 {
+		return req, serr.ErrInvalidCreateRequest{Message: "job chain has no jobs"}
+	}
+
+	// Copy the job chain for the new request. Jobs are reset to pending, as
+	// if the job chain was just made, but they keep their job data to seed
+	// the first jobs, like a rerun.
+	reqId := xid.New().String()
+	jc := &proto.JobChain{
+		RequestId:     reqId,
+		State:         proto.STATE_PENDING,
+		Jobs:          make(map[string]proto.Job, len(cr.JobChain.Jobs)),
+		AdjacencyList: cr.JobChain.AdjacencyList,
+		User:          cr.User,
+		Globals:       cr.JobChain.Globals,
+		Overrides:     cr.JobChain.Overrides,
+		Webhooks:      cr.JobChain.Webhooks,
+		Escalations:   cr.JobChain.Escalations,
+		StrictFailure: cr.JobChain.StrictFailure,
+	}
+	if jc.AdjacencyList == nil {
+		jc.AdjacencyList = map[string][]string{}
+	}
+	for jobId, job := range cr.JobChain.Jobs {
+		if err := validate.Job(cr.JobChain, jobId, job); err != nil {
+			return req, serr.ErrInvalidCreateRequest{Message: fmt.Sprintf("invalid job %s: %s", jobId, err)}
+		}
+		job.State = proto.STATE_PENDING
+		job.StopReason = ""
+		job.ReentryToken = ""
+		jc.Jobs[jobId] = job
+	}
+	if err := validate.Chain(*jc, true); err != nil {
+		return req, serr.ErrInvalidCreateRequest{Message: fmt.Sprintf("invalid job chain: %s", err)}
+	}
+
+	req = proto.Request{
+		Id:        reqId,
+		Type:      cr.Type,
+		CreatedAt: time.Now().UTC(),
+		State:     proto.STATE_PENDING,
+		User:      cr.User,
+		JobChain:  jc,
+		TotalJobs: uint(len(jc.Jobs)),
+	}
+	newReq := proto.CreateRequest{
+		Type: cr.Type,
+		User: cr.User,
+	}
+	if err := m.save(req, newReq); err != nil {
+		return req, err
+	}
+	log.Infof("created request %s from job chain (%d jobs)", reqId, len(jc.Jobs))
+	m.metrics.Count(metrics.REQUESTS_CREATED, 1, metrics.Tags{"type": req.Type})
+	return req, nil
+}
+
+// rebase reruns the whole request with a job chain made from the current spec,
+// like a new request with the args given to the original request. Optional args
+// not given take their current default values. Job IDs change when the spec
+// changes, so a rerun cannot be rebased from a job.
+func (m *manager) rebase(orig proto.Request, rr proto.RerunRequest) (proto.Request, error) {
+	if rr.JobId != "" {
+		return proto.Request{}, serr.ValidationError{Message: "jobId cannot be set with rebase: a rebased rerun reruns the whole request"}
+	}
+	if _, ok := m.specVersions[orig.Type]; !ok {
+		return proto.Request{}, serr.ValidationError{Message: fmt.Sprintf("cannot rebase request %s: request %s no longer exists in specs", orig.Id, orig.Type)}
+	}
+	newReq := proto.CreateRequest{
+		Type: orig.Type,
+		Args: map[string]interface{}{},
+		User: rr.User,
+	}
+	if orig.JobChain != nil {
+		newReq.StrictFailure = orig.JobChain.StrictFailure // keep if given when created
+		newReq.Overrides = orig.JobChain.Overrides
+		for _, job := range orig.JobChain.Jobs {
+			newReq.LogLevel = job.LogLevel // same for every job
+			break
+		}
+	}
+	for _, arg := range orig.Args {
+		if arg.Given {
+			newReq.Args[arg.Name] = arg.Value
+		}
+	}
+	req, err := m.Create(newReq)
+	if err != nil {
+		return req, err
+	}
+	log.Infof("rerun request %s as request %s rebased from spec version %s to %s (%d jobs)", orig.Id, req.Id, orig.SpecVersion, req.SpecVersion, req.TotalJobs)
+	return req, nil
+}
+
+// lastTries returns the job log entry of the last try of every job: its final
+// state and job data in the original run.
+func lastTries(jls []proto.JobLog) map[string]proto.JobLog {
+	last := map[string]proto.JobLog{}
+	for _, jl := range jls {
+		if prev, ok := last[jl.JobId]; !ok || jl.Try > prev.Try {
+			last[jl.JobId] = jl
+		}
+	}
+	return last
+}
+
+// downstream returns the job and every job downstream of it.
+func downstream(adjacencyList map[string][]string, jobId string) map[string]bool {
+	jobs := map[string]bool{jobId: true}
+	next := []string{jobId}
+	for len(next) > 0 {
+		jobId := next[0]
+		next = next[1:]
+		for _, nextJobId := range adjacencyList[jobId] {
+			if !jobs[nextJobId] {
+				jobs[nextJobId] = true
+				next = append(next, nextJobId)
+			}
+		}
+	}
+	return jobs
+}
+
+// rerunJobChain returns a new job chain with the job and every job downstream
+// of it from the original job chain. Jobs are reset to pending and seeded with
+// the final job data of their upstream jobs that are not rerun, which must have
+// completed in the original run (according to the last tries in the job log).
+// Jobs whose sequence starts upstream are made part of the first job's sequence.
+func rerunJobChain(orig proto.JobChain, firstJobId string, last map[string]proto.JobLog) (*proto.JobChain, error) {
+	if _, ok := orig.Jobs[firstJobId]; !ok {
+		return nil, serr.JobNotFound{RequestId: orig.RequestId, JobId: firstJobId}
+	}
+	rerun := downstream(orig.AdjacencyList, firstJobId)
+
+	prevJobs := map[string][]string{}
+	for jobId, nextJobIds := range orig.AdjacencyList {
+		for _, nextJobId := range nextJobIds {
+			prevJobs[nextJobId] = append(prevJobs[nextJobId], jobId)
+		}
+	}
+
+	jc := &proto.JobChain{
+		State:         proto.STATE_PENDING,
+		Jobs:          map[string]proto.Job{},
+		AdjacencyList: map[string][]string{},
+		Globals:       orig.Globals,
+		Overrides:     orig.Overrides,
+		Webhooks:      orig.Webhooks,
+		Escalations:   orig.Escalations,
+		StrictFailure: orig.StrictFailure,
+	}
+	for jobId := range rerun {
+		job := orig.Jobs[jobId]
+		job.State = proto.STATE_PENDING
+		if !rerun[job.SequenceId] {
+			job.SequenceId = firstJobId
+		}
+
+		// Seed job data from upstream jobs not rerun. Sort them so that, like
+		// the Job Runner, which copies job data from each parent job, parents
+		// overwrite each other's job data in the same order for every rerun.
+		job.Data = map[string]interface{}{}
+		prev := prevJobs[jobId]
+		sort.Strings(prev)
+		for _, prevJobId := range prev {
+			if rerun[prevJobId] {
+				continue
+			}
+			jl, ok := last[prevJobId]
+			if !ok || jl.State != proto.STATE_COMPLETE {
+				return nil, serr.ValidationError{
+					Message: fmt.Sprintf("cannot rerun job %s: upstream job %s (%s) did not complete in request %s",
+						firstJobId, orig.Jobs[prevJobId].Name, prevJobId, orig.RequestId),
+				}
+			}
+			for k, v := range jl.Data {
+				job.Data[k] = v
+			}
+		}
+		jc.Jobs[jobId] = job
+
+		if nextJobIds, ok := orig.AdjacencyList[jobId]; ok {
+			jc.AdjacencyList[jobId] = nextJobIds
+		}
+	}
+	return jc, nil
+}
+
+// skipSequenceChain returns a new job chain that skips the failed sequence which
+// starts with the job and reruns every job downstream of it. The sequence must be
+// skippable, its first job must have completed, and one of its jobs must have
+// failed. Its jobs, including nested sequences, are treated as completed with the
+// job data of its first job, so downstream jobs get the job data the sequence
+// was given. The new job chain starts with the last job of the sequence, which
+// is a no-op job like the first.
+func skipSequenceChain(orig proto.JobChain, seqId string, last map[string]proto.JobLog) (*proto.JobChain, error) {
+	first, ok := orig.Jobs[seqId]
+	if !ok {
+		return nil, serr.JobNotFound{RequestId: orig.RequestId, JobId: seqId}
+	}
+	if first.SequenceId != seqId {
+		return nil, serr.ValidationError{
+			Message: fmt.Sprintf("cannot skip sequence %s: job %s is not the first job of a sequence, it's in sequence %s", seqId, first.Name, first.SequenceId),
+		}
+	}
+	if !first.SequenceSkippable {
+		return nil, serr.ValidationError{
+			Message: fmt.Sprintf("cannot skip sequence %s (%s): its node in the request spec is not skippable", seqId, first.Name),
+		}
+	}
+	if jl, ok := last[seqId]; !ok || jl.State != proto.STATE_COMPLETE {
+		return nil, serr.ValidationError{
+			Message: fmt.Sprintf("cannot skip sequence %s (%s): its first job did not complete in request %s", seqId, first.Name, orig.RequestId),
+		}
+	}
+
+	// The last job of the sequence is the only job of the sequence (not nested
+	// sequences) with no other job of the sequence downstream of it
+	lastJobId := ""
+	for jobId, job := range orig.Jobs {
+		if job.SequenceId != seqId {
+			continue
+		}
+		n := 0
+		for downJobId := range downstream(orig.AdjacencyList, jobId) {
+			if orig.Jobs[downJobId].SequenceId == seqId {
+				n++
+			}
+		}
+		if n == 1 {
+			lastJobId = jobId
+			break
+		}
+	}
+	if lastJobId == "" || lastJobId == seqId {
+		return nil, serr.ValidationError{
+			Message: fmt.Sprintf("cannot skip sequence %s (%s): no last job in sequence", seqId, first.Name),
+		}
+	}
+
+	// Jobs of the sequence = downstream of its first job but not its last job
+	after := downstream(orig.AdjacencyList, lastJobId)
+	done := make(map[string]proto.JobLog, len(last))
+	for jobId, jl := range last {
+		done[jobId] = jl
+	}
+	failed := false
+	for jobId := range downstream(orig.AdjacencyList, seqId) {
+		if after[jobId] || jobId == seqId {
+			continue
+		}
+		if jl, ok := last[jobId]; ok && jl.State == proto.STATE_FAIL {
+			failed = true
+		}
+		done[jobId] = proto.JobLog{
+			RequestId: orig.RequestId,
+			JobId:     jobId,
+			State:     proto.STATE_COMPLETE,
+			Data:      last[seqId].Data,
+		}
+	}
+	if !failed {
+		return nil, serr.ValidationError{
+			Message: fmt.Sprintf("cannot skip sequence %s (%s): no job in the sequence failed in request %s", seqId, first.Name, orig.RequestId),
+		}
+	}
+
+	return rerunJobChain(orig, lastJobId, done)
 }
 
 // Retrieve the request without its corresponding Job Chain.
@@ -236,7 +871,10 @@ func (m *manager) Get(requestId string) (proto.Request, error) {
 
 	// Nullable columns.
 	var user sql.NullString
+	var operator sql.NullString
 	var jrURL sql.NullString
+	var dedupKey sql.NullString
+	var specVersion sql.NullString
 	startedAt := mysql.NullTime{}
 	finishedAt := mysql.NullTime{}
 
@@ -245,7 +883,7 @@ func (m *manager) Get(requestId string) (proto.Request, error) {
 	// Technically, a LEFT JOIN shouldn't be necessary, but we have tests that
 	// create a request but no corresponding request_archive which makes a plain
 	// JOIN not match any row.
-	q := "SELECT request_id, type, state, user, created_at, started_at, finished_at, total_jobs, finished_jobs, jr_url, args" +
+	q := "SELECT request_id, type, state, user, operator, created_at, started_at, finished_at, total_jobs, finished_jobs, progress, jr_url, dedup_key, spec_version, args" +
 		" FROM requests r LEFT JOIN request_archives a USING (request_id)" +
 		" WHERE request_id = ?"
 	notFound := false
@@ -255,12 +893,16 @@ func (m *manager) Get(requestId string) (proto.Request, error) {
 			&req.Type,
 			&req.State,
 			&user,
+			&operator,
 			&req.CreatedAt,
 			&startedAt,
 			&finishedAt,
 			&req.TotalJobs,
 			&req.FinishedJobs,
+			&req.Progress,
 			&jrURL,
+			&dedupKey,
+			&specVersion,
 			&reqArgsBytes,
 		)
 		if err != nil {
@@ -284,9 +926,18 @@ func (m *manager) Get(requestId string) (proto.Request, error) {
 	if user.Valid {
 		req.User = user.String
 	}
+	if operator.Valid {
+		req.Operator = operator.String
+	}
 	if jrURL.Valid {
 		req.JobRunnerURL = jrURL.String
 	}
+	if dedupKey.Valid {
+		req.DedupKey = dedupKey.String
+	}
+	if specVersion.Valid {
+		req.SpecVersion = specVersion.String
+	}
 	if startedAt.Valid {
 		req.StartedAt = &startedAt.Time
 	}
@@ -315,61 +966,267 @@ func (m *manager) Start(requestId string) error {
 		return serr.NewErrInvalidState(proto.StateName[proto.STATE_PENDING], proto.StateName[req.State])
 	}
 
-	// Send the request's job chain to the job runner, which will start running it.
-	var chainURL *url.URL
-	for i := 0; i < JR_TRIES; i++ {
-		if i != 0 {
-			time.Sleep(JR_RETRY_WAIT)
-		}
-		chainURL, err = m.jrClient.NewJobChain(m.defaultJRURL, *req.JobChain)
-		if err == nil {
-			break
-		}
+	// Save the request in the outbox, claimed by this RM, before sending its
+	// job chain to a JR. If this RM crashes before the request is running,
+	// another RM unclaims and dispatches it (see DispatchAll).
+	if err := m.enqueue(requestId); err != nil {
+		return err
 	}
+	return m.dispatch(req, "", JR_TRIES)
+}
+
+func (m *manager) Stop(requestId string) error {
+	req, err := m.Get(requestId)
 	if err != nil {
 		return err
 	}
 
-	now := time.Now().UTC()
-	req.StartedAt = &now
-	req.State = proto.STATE_RUNNING
+	if req.State == proto.STATE_COMPLETE {
+		return nil
+	}
 
-	req.JobRunnerURL = strings.TrimSuffix(chainURL.String(), chainURL.RequestURI())
+	// Return an error unless the request is in the running state, which prevents
+	// us from stopping a request which should not be able to be stopped.
+	if req.State != proto.STATE_RUNNING {
+		return serr.NewErrInvalidState(proto.StateName[proto.STATE_RUNNING], proto.StateName[req.State])
+	}
 
-	// This will only update the request if the current state is PENDING. The
-	// state should be PENDING since we checked this earlier, but it's possible
-	// something else has changed the state since then.
-	err = m.updateRequest(req, proto.STATE_PENDING)
+	// Tell the JR to stop running the job chain for the request. If the JR is
+	// not running it, the request might have finished since we got it.
+	err = m.jrClient.StopRequest(req.JobRunnerURL, requestId)
+	if _, ok := err.(jr.ErrNotFound); ok {
+		if req, getErr := m.Get(requestId); getErr == nil && req.State != proto.STATE_RUNNING {
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error stopping request in Job Runner: %s", err)
+	}
+
+	return nil
+}
+
+func (m *manager) Pause(requestId string) error {
+	return m.pause(requestId, true)
+}
+
+func (m *manager) Resume(requestId string) error {
+	return m.pause(requestId, false)
+}
+
+// pause tells the JR running the request to pause or resume its job chain.
+func (m *manager) pause(requestId string, pause bool) error {
+	req, err := m.Get(requestId)
 	if err != nil {
 		return err
 	}
+	if req.State != proto.STATE_RUNNING {
+		return serr.NewErrInvalidState(proto.StateName[proto.STATE_RUNNING], proto.StateName[req.State])
+	}
 
+	if pause {
+		err = m.jrClient.PauseRequest(req.JobRunnerURL, requestId)
+	} else {
+		err = m.jrClient.ResumeRequest(req.JobRunnerURL, requestId)
+	}
+	if _, ok := err.(jr.ErrNotFound); ok {
+		// Request finished or was suspended since we got it
+		if req, getErr := m.Get(requestId); getErr == nil && req.State != proto.STATE_RUNNING {
+			return serr.NewErrInvalidState(proto.StateName[proto.STATE_RUNNING], proto.StateName[req.State])
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error pausing or resuming request in Job Runner: %s", err)
+	}
 	return nil
 }
 
-func (m *manager) Stop(requestId string) error {
+func (m *manager) CaptureProfile(requestId string, pc proto.ProfileCapture) error {
 	req, err := m.Get(requestId)
 	if err != nil {
 		return err
 	}
+	if req.State != proto.STATE_RUNNING {
+		return serr.NewErrInvalidState(proto.StateName[proto.STATE_RUNNING], proto.StateName[req.State])
+	}
+	if err := m.jrClient.Profile(req.JobRunnerURL, requestId, pc); err != nil {
+		return fmt.Errorf("error capturing profiles in Job Runner: %s", err)
+	}
+	return nil
+}
 
-	if req.State == proto.STATE_COMPLETE {
+func (m *manager) ApproveJob(requestId, jobId, user string) error {
+	req, err := m.Get(requestId)
+	if err != nil {
+		return err
+	}
+	if req.State != proto.STATE_RUNNING {
+		return serr.NewErrInvalidState(proto.StateName[proto.STATE_RUNNING], proto.StateName[req.State])
+	}
+
+	err = m.jrClient.ApproveJob(req.JobRunnerURL, requestId, jobId, proto.ApproveJob{User: user})
+	switch e := err.(type) {
+	case nil:
 		return nil
+	case jr.ErrChainRejected:
+		return serr.ValidationError{Message: fmt.Sprintf("job %s: %s", jobId, e.Message)}
+	case jr.ErrNotFound:
+		// Request finished or was suspended since we got it
+		if req, getErr := m.Get(requestId); getErr == nil && req.State != proto.STATE_RUNNING {
+			return serr.NewErrInvalidState(proto.StateName[proto.STATE_RUNNING], proto.StateName[req.State])
+		}
+	}
+	return fmt.Errorf("error approving job in Job Runner: %s", err)
+}
+
+func (m *manager) AddJob(requestId string, aj proto.AddJob) (proto.Job, error) {
+	var newJob proto.Job
+	if !m.addJobTypes[aj.Type] {
+		return newJob, serr.ValidationError{Message: fmt.Sprintf("job type %s cannot be added to requests (see config add_job.types)", aj.Type)}
+	}
+	if aj.After == "" {
+		return newJob, serr.ValidationError{Message: "after is empty, must be a job ID"}
+	}
+	if aj.Name == "" {
+		aj.Name = aj.Type
 	}
 
-	// Return an error unless the request is in the running state, which prevents
-	// us from stopping a request which should not be able to be stopped.
+	req, err := m.Get(requestId)
+	if err != nil {
+		return newJob, err
+	}
 	if req.State != proto.STATE_RUNNING {
-		return serr.NewErrInvalidState(proto.StateName[proto.STATE_RUNNING], proto.StateName[req.State])
+		return newJob, serr.NewErrInvalidState(proto.StateName[proto.STATE_RUNNING], proto.StateName[req.State])
 	}
 
-	// Tell the JR to stop running the job chain for the request.
-	err = m.jrClient.StopRequest(req.JobRunnerURL, requestId)
+	// Lock the job chain until the job is added to it, so concurrent adds
+	// don't overwrite each other. The job chain is saved only if the JR adds
+	// the job, and the JR is told to add the job only if the job chain can
+	// be saved.
+	ctx := context.TODO()
+	txn, err := m.dbConnector.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("error stopping request in Job Runner: %s", err)
+		return newJob, serr.NewDbError(err, "BEGIN")
 	}
+	defer txn.Rollback()
 
-	return nil
+	var jobChainBytes []byte
+	q := "SELECT job_chain FROM request_archives WHERE request_id = ? FOR UPDATE"
+	if err := txn.QueryRowContext(ctx, q, requestId).Scan(&jobChainBytes); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return newJob, serr.RequestNotFound{requestId}
+		default:
+			return newJob, serr.NewDbError(err, "SELECT request_archives")
+		}
+	}
+	jobChainBytes, err = compress.Unpack(jobChainBytes)
+	if err != nil {
+		return newJob, fmt.Errorf("cannot decompress job chain: %s", err)
+	}
+	var jc proto.JobChain
+	if err := json.Unmarshal(jobChainBytes, &jc); err != nil {
+		return newJob, fmt.Errorf("cannot unmarshal job chain: %s", err)
+	}
+	if _, ok := jc.Jobs[aj.After]; !ok {
+		return newJob, serr.JobNotFound{RequestId: requestId, JobId: aj.After}
+	}
+
+	// Make the job like graph.Resolver makes jobs, with an id unique in the
+	// job chain
+	idGen := m.idGenFactory.Make()
+	jobId, err := idGen.UID()
+	for err == nil && jc.Jobs[jobId].Id != "" {
+		jobId, err = idGen.UID()
+	}
+	if err != nil {
+		return newJob, fmt.Errorf("Error making id for '%s %s' job: %s", aj.Type, aj.Name, err)
+	}
+	rj, err := m.jobFactory.Make(job.NewIdWithRequestId(aj.Type, aj.Name, jobId, requestId))
+	if err != nil {
+		return newJob, serr.ValidationError{Message: fmt.Sprintf("Error making '%s %s' job: %s", aj.Type, aj.Name, err)}
+	}
+	if gj, ok := rj.(job.UsesGlobals); ok {
+		globals := map[string]interface{}{}
+		for k, v := range jc.Globals {
+			globals[k] = v
+		}
+		gj.SetGlobals(globals)
+	}
+	jobArgs := map[string]interface{}{}
+	argSources := map[string]proto.ArgSource{}
+	for k, v := range aj.Args {
+		jobArgs[k] = v
+		argSources[k] = proto.ArgSource{Source: proto.ARG_SOURCE_GIVEN}
+	}
+	if err := rj.Create(jobArgs); err != nil {
+		return newJob, serr.ValidationError{Message: fmt.Sprintf("Error creating '%s %s' job: %s", aj.Type, aj.Name, err)}
+	}
+	bytes, err := rj.Serialize()
+	if err != nil {
+		return newJob, fmt.Errorf("Error serializing '%s %s' job: %s", aj.Type, aj.Name, err)
+	}
+	newJob = proto.Job{
+		Type:       aj.Type,
+		Id:         jobId,
+		Name:       aj.Name,
+		Bytes:      bytes,
+		Args:       aj.Args,
+		ArgSources: argSources,
+		SequenceId: jobId,
+		State:      proto.STATE_PENDING,
+	}
+
+	// Add the job to the job chain like the JR adds it (see chain.Chain.AddJob):
+	// after the given job and before the last job
+	for jobId, job := range jc.Jobs {
+		if len(jc.AdjacencyList[jobId]) == 0 {
+			jc.AdjacencyList[newJob.Id] = []string{job.Id}
+			break
+		}
+	}
+	jc.Jobs[newJob.Id] = newJob
+	jc.AdjacencyList[aj.After] = append(jc.AdjacencyList[aj.After], newJob.Id)
+
+	jobChainBytes, err = json.Marshal(jc)
+	if err != nil {
+		return newJob, fmt.Errorf("cannot marshal job chain: %s", err)
+	}
+	jobChainBytes, err = compress.Pack(m.compression, jobChainBytes)
+	if err != nil {
+		return newJob, fmt.Errorf("cannot compress job chain: %s", err)
+	}
+	q = "UPDATE request_archives SET job_chain = ? WHERE request_id = ?"
+	if _, err := txn.ExecContext(ctx, q, jobChainBytes, requestId); err != nil {
+		return newJob, serr.NewDbError(err, "UPDATE request_archives")
+	}
+	q = "UPDATE requests SET total_jobs = total_jobs + 1 WHERE request_id = ?"
+	if _, err := txn.ExecContext(ctx, q, requestId); err != nil {
+		return newJob, serr.NewDbError(err, "UPDATE requests")
+	}
+
+	// Tell the JR to add the job. It validates that the job can be added
+	// without changing sequence retries.
+	err = m.jrClient.AddJob(req.JobRunnerURL, requestId, proto.AddChainJob{After: aj.After, Job: newJob})
+	if err != nil {
+		switch err := err.(type) {
+		case jr.ErrChainRejected:
+			return newJob, serr.ValidationError{Message: err.Message}
+		case jr.ErrNotFound:
+			return newJob, serr.NewErrInvalidState(proto.StateName[proto.STATE_RUNNING], "not running in Job Runner")
+		default:
+			return newJob, fmt.Errorf("error adding job in Job Runner: %s", err)
+		}
+	}
+
+	if err := txn.Commit(); err != nil {
+		// The job is running but not in the saved job chain. Its job logs
+		// are still saved.
+		log.Errorf("request %s: job %s added in Job Runner but job chain not saved: %s", requestId, newJob.Id, err)
+		return newJob, serr.NewDbError(err, "COMMIT")
+	}
+	log.Infof("request %s: added job %s (%s %s) after job %s", requestId, newJob.Id, newJob.Type, newJob.Name, aj.After)
+	return newJob, nil
 }
 
 func (m *manager) Finish(requestId string, finishParams proto.FinishRequest) error {
@@ -384,6 +1241,10 @@ func (m *manager) Finish(requestId string, finishParams proto.FinishRequest) err
 	req.State = finishParams.State
 	req.FinishedAt = &finishParams.FinishedAt
 	req.FinishedJobs = finishParams.FinishedJobs
+	req.Progress = finishParams.Progress
+	if req.State == proto.STATE_COMPLETE {
+		req.Progress = 100 // in case the JR doesn't send progress
+	}
 	req.JobRunnerURL = ""
 
 	// This will only update the request if the current state is RUNNING.
@@ -396,6 +1257,12 @@ func (m *manager) Finish(requestId string, finishParams proto.FinishRequest) err
 		return err
 	}
 
+	tags := metrics.Tags{"type": req.Type, "state": proto.StateName[req.State]}
+	m.metrics.Count(metrics.REQUESTS_FINISHED, 1, tags)
+	if req.StartedAt != nil {
+		m.metrics.Timing(metrics.REQUEST_DURATION, req.FinishedAt.Sub(*req.StartedAt), tags)
+	}
+
 	return nil
 }
 
@@ -421,6 +1288,12 @@ func (m *manager) FailPending(requestId string) error {
 		return err
 	}
 
+	// Remove the request from the outbox, if it's there, so it's not dispatched.
+	// If this fails, DispatchAll removes it because it's not pending.
+	if err := m.dequeue(requestId); err != nil {
+		log.Errorf("request %s: error removing request from outbox: %s", requestId, err)
+	}
+
 	return nil
 }
 
@@ -446,8 +1319,9 @@ func (m *manager) Specs() []proto.RequestSpec {
 	requestList = make([]proto.RequestSpec, 0, len(sortedReqNames))
 	for _, name := range sortedReqNames {
 		s := proto.RequestSpec{
-			Name: name,
-			Args: []proto.RequestArg{},
+			Name:    name,
+			Args:    []proto.RequestArg{},
+			Version: m.specVersions[name],
 		}
 		for _, arg := range req[name].Args.Required {
 			a := proto.RequestArg{
@@ -466,6 +1340,14 @@ func (m *manager) Specs() []proto.RequestSpec {
 			}
 			s.Args = append(s.Args, a)
 		}
+		for _, o := range req[name].Overrides {
+			s.Overrides = append(s.Overrides, proto.RequestOverride{
+				Name:    o.Name,
+				Desc:    o.Desc,
+				Values:  o.Values,
+				Pattern: o.Pattern,
+			})
+		}
 		requestList = append(requestList, s)
 	}
 
@@ -490,6 +1372,10 @@ func (m *manager) JobChain(requestId string) (proto.JobChain, error) {
 	}
 
 	// Unmarshal the job chain into a proto.JobChain.
+	jobChainBytes, err := compress.Unpack(jobChainBytes)
+	if err != nil {
+		return jobChain, fmt.Errorf("cannot decompress job chain: %s", err)
+	}
 	if err := json.Unmarshal(jobChainBytes, &jobChain); err != nil {
 		return jobChain, fmt.Errorf("cannot unmarshal job chain: %s", err)
 	}
@@ -497,6 +1383,128 @@ func (m *manager) JobChain(requestId string) (proto.JobChain, error) {
 	return jobChain, nil
 }
 
+func (m *manager) JobSnapshot(requestId, jobId string) (proto.JobSnapshot, error) {
+	snap := proto.JobSnapshot{RequestId: requestId}
+	req, err := m.GetWithJC(requestId)
+	if err != nil {
+		return snap, err
+	}
+	if req.JobChain == nil {
+		return snap, serr.JobNotFound{RequestId: requestId, JobId: jobId}
+	}
+	jls, err := m.jls.GetFull(requestId)
+	if err != nil {
+		return snap, err
+	}
+	// The job data seeded for a rerun of the job is the jobData it got
+	jc, err := rerunJobChain(*req.JobChain, jobId, lastTries(jls))
+	if err != nil {
+		return snap, err
+	}
+	snap.User = req.User
+	snap.Globals = req.JobChain.Globals
+	snap.Overrides = req.JobChain.Overrides
+	snap.Job = jc.Jobs[jobId]
+	snap.Job.State = req.JobChain.Jobs[jobId].State
+	snap.Job.SequenceId = req.JobChain.Jobs[jobId].SequenceId
+	for i := range jls {
+		if jls[i].JobId == jobId && (snap.Last == nil || jls[i].Try > snap.Last.Try) {
+			snap.Last = &jls[i]
+		}
+	}
+	return snap, nil
+}
+
+func (m *manager) ArgsDiff(requestId string) (proto.RequestArgsDiff, error) {
+	argsDiff := proto.RequestArgsDiff{
+		RequestId: requestId,
+	}
+
+	req, err := m.Get(requestId)
+	if err != nil {
+		return argsDiff, err
+	}
+	argsDiff.Args = req.Args
+
+	ctx := context.TODO()
+
+	// The raw args are only saved in the create request
+	var newReqBytes, jobChainBytes []byte
+	q := "SELECT create_request, job_chain FROM request_archives WHERE request_id = ?"
+	if err := m.dbConnector.QueryRowContext(ctx, q, requestId).Scan(&newReqBytes, &jobChainBytes); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return argsDiff, serr.RequestNotFound{requestId}
+		default:
+			return argsDiff, serr.NewDbError(err, "SELECT request_archives")
+		}
+	}
+
+	var newReq proto.CreateRequest
+	if err := json.Unmarshal(newReqBytes, &newReq); err != nil {
+		return argsDiff, fmt.Errorf("cannot unmarshal create request: %s", err)
+	}
+	argsDiff.Submitted = newReq.Args
+
+	var jobChain proto.JobChain
+	jobChainBytes, err = compress.Unpack(jobChainBytes)
+	if err != nil {
+		return argsDiff, fmt.Errorf("cannot decompress job chain: %s", err)
+	}
+	if err := json.Unmarshal(jobChainBytes, &jobChain); err != nil {
+		return argsDiff, fmt.Errorf("cannot unmarshal job chain: %s", err)
+	}
+	argsDiff.Jobs = diffJobArgs(req.Args, jobChain)
+
+	return argsDiff, nil
+}
+
+// diffJobArgs compares the jobArgs of every job in the job chain to the final
+// request args.
+func diffJobArgs(reqArgs []proto.RequestArg, jc proto.JobChain) []proto.JobArgsDiff {
+	byName := make(map[string]proto.RequestArg, len(reqArgs))
+	for _, arg := range reqArgs {
+		byName[arg.Name] = arg
+	}
+
+	jobs := make([]proto.JobArgsDiff, 0, len(jc.Jobs))
+	for _, job := range jc.Jobs {
+		jobDiff := proto.JobArgsDiff{
+			JobId: job.Id,
+			Name:  job.Name,
+			Args:  make([]proto.ArgDiff, 0, len(job.Args)),
+		}
+		for name, val := range job.Args {
+			argDiff := proto.ArgDiff{
+				Name:   name,
+				Source: proto.ARG_SOURCE_DERIVED,
+				Value:  val,
+			}
+			if arg, ok := byName[name]; ok {
+				argDiff.Request = arg.Value
+				switch {
+				case !reflect.DeepEqual(arg.Value, val):
+					argDiff.Source = proto.ARG_SOURCE_CHANGED
+				case arg.Given:
+					argDiff.Source = proto.ARG_SOURCE_GIVEN
+				case arg.Type == proto.ARG_TYPE_DERIVED:
+					argDiff.Source = proto.ARG_SOURCE_COMPUTED
+				default:
+					argDiff.Source = proto.ARG_SOURCE_DEFAULT
+				}
+			}
+			if src, ok := job.ArgSources[name]; ok {
+				argDiff.Origin = &src
+			}
+			jobDiff.Args = append(jobDiff.Args, argDiff)
+		}
+		sort.Slice(jobDiff.Args, func(i, j int) bool { return jobDiff.Args[i].Name < jobDiff.Args[j].Name })
+		jobs = append(jobs, jobDiff)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].JobId < jobs[j].JobId })
+	return jobs
+}
+
 // Get a request with proto.Request.JobChain and proto.Request.Params set
 func (m *manager) GetWithJC(requestId string) (proto.Request, error) {
 	req, err := m.Get(requestId)
@@ -530,6 +1538,10 @@ func (m *manager) GetWithJC(requestId string) (proto.Request, error) {
 	}
 
 	var jobChain proto.JobChain
+	jobChainBytes, err = compress.Unpack(jobChainBytes)
+	if err != nil {
+		return req, fmt.Errorf("cannot decompress job chain: %s", err)
+	}
 	if err := json.Unmarshal(jobChainBytes, &jobChain); err != nil {
 		return req, fmt.Errorf("cannot unmarshal job chain: %s", err)
 	}
@@ -538,9 +1550,36 @@ func (m *manager) GetWithJC(requestId string) (proto.Request, error) {
 	return req, nil
 }
 
+// sortColumns maps proto.RequestFilter.Sort fields to requests columns.
+var sortColumns = map[string]string{
+	proto.SORT_CREATED:  "r.created_at",
+	proto.SORT_STARTED:  "r.started_at",
+	proto.SORT_FINISHED: "r.finished_at",
+	proto.SORT_STATE:    "r.state",
+	proto.SORT_TYPE:     "r.type",
+}
+
 func (m *manager) Find(filter proto.RequestFilter) ([]proto.Request, error) {
+	sortBy := filter.Sort
+	if sortBy == "" {
+		sortBy = proto.SORT_CREATED
+	}
+	sortCol, ok := sortColumns[sortBy]
+	if !ok {
+		return nil, serr.ValidationError{Message: fmt.Sprintf("invalid sort %q: expected %s, %s, %s, %s, or %s",
+			filter.Sort, proto.SORT_CREATED, proto.SORT_STARTED, proto.SORT_FINISHED, proto.SORT_STATE, proto.SORT_TYPE)}
+	}
+	order := strings.ToLower(filter.Order)
+	switch order {
+	case "":
+		order = proto.SORT_DESC
+	case proto.SORT_ASC, proto.SORT_DESC:
+	default:
+		return nil, serr.ValidationError{Message: fmt.Sprintf("invalid order %q: expected %s or %s", filter.Order, proto.SORT_ASC, proto.SORT_DESC)}
+	}
+
 	// Build the query from the filter.
-	query := "SELECT request_id, type, state, user, created_at, started_at, finished_at, total_jobs, finished_jobs, jr_url" +
+	query := "SELECT request_id, type, state, user, created_at, started_at, finished_at, total_jobs, finished_jobs, progress, jr_url" +
 		" FROM requests r LEFT JOIN request_archives ra USING (request_id) "
 
 	var fields []string
@@ -566,6 +1605,10 @@ func (m *manager) Find(filter proto.RequestFilter) ([]proto.Request, error) {
 			values = append(values, arg, val)
 		}
 	}
+	if filter.Resource != "" {
+		fields = append(fields, "r.request_id IN (SELECT request_id FROM request_resources WHERE resource = ?)")
+		values = append(values, filter.Resource)
+	}
 	if !filter.Since.IsZero() {
 		fields = append(fields, "(r.finished_at > ? OR r.finished_at IS NULL)")
 		values = append(values, filter.Since.Format(time.RFC3339Nano))
@@ -579,7 +1622,17 @@ func (m *manager) Find(filter proto.RequestFilter) ([]proto.Request, error) {
 		query += "WHERE " + strings.Join(fields, " AND ")
 	}
 
-	query += " ORDER BY r.created_at DESC, r.request_id "
+	// Order by the sort column, then create time in the same order, then id.
+	// Nulls (not started or finished) are last in both orders.
+	order = strings.ToUpper(order)
+	switch sortBy {
+	case proto.SORT_CREATED:
+		query += fmt.Sprintf(" ORDER BY r.created_at %s, r.request_id ", order)
+	case proto.SORT_STARTED, proto.SORT_FINISHED:
+		query += fmt.Sprintf(" ORDER BY %s IS NULL, %s %s, r.created_at %s, r.request_id ", sortCol, sortCol, order, order)
+	default:
+		query += fmt.Sprintf(" ORDER BY %s %s, r.created_at %s, r.request_id ", sortCol, order, order)
+	}
 
 	if filter.Limit != 0 {
 		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
@@ -624,6 +1677,7 @@ func (m *manager) Find(filter proto.RequestFilter) ([]proto.Request, error) {
 			&finishedAt,
 			&req.TotalJobs,
 			&req.FinishedJobs,
+			&req.Progress,
 			&jrURL,
 		)
 		if err != nil {
@@ -667,7 +1721,7 @@ func (m *manager) updateRequest(req proto.Request, curState byte) error {
 	}
 
 	// Fields that should never be updated by this package are not listed in this query.
-	q := "UPDATE requests SET state = ?, started_at = ?, finished_at = ?, finished_jobs = ?, jr_url = ?  WHERE request_id = ? AND state = ?"
+	q := "UPDATE requests SET state = ?, started_at = ?, finished_at = ?, finished_jobs = ?, progress = ?, jr_url = ?  WHERE request_id = ? AND state = ?"
 	var res sql.Result
 	err := retry.Do(DB_TRIES, DB_RETRY_WAIT, func() error {
 		var err error
@@ -676,6 +1730,7 @@ func (m *manager) updateRequest(req proto.Request, curState byte) error {
 			req.StartedAt,
 			req.FinishedAt,
 			req.FinishedJobs,
+			req.Progress,
 			jrURL,
 			req.Id,
 			curState,
@@ -702,5 +1757,28 @@ func (m *manager) updateRequest(req proto.Request, curState byte) error {
 		return ErrMultipleUpdated
 	}
 
+	// The state is updated, so only warn if its history isn't: status as of
+	// a past time will be off, but the request is fine
+	if req.State != curState {
+		if err := saveState(ctx, m.dbConnector, req.Id, req.State, time.Now().UTC()); err != nil {
+			log.Warnf("request %s: error saving state %s in history: %s", req.Id, proto.StateName[req.State], err)
+		}
+	}
+
+	return nil
+}
+
+// execer is a *sql.DB or *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// saveState records a request state change in request_state_history, which is
+// used for status as of a past time (see status.Manager.Running).
+func saveState(ctx context.Context, db execer, requestId string, state byte, at time.Time) error {
+	q := "INSERT INTO request_state_history (request_id, state, changed_at) VALUES (?, ?, ?)"
+	if _, err := db.ExecContext(ctx, q, requestId, state, at); err != nil {
+		return serr.NewDbError(err, "INSERT request_state_history")
+	}
 	return nil
 }
@@ -13,9 +13,11 @@ import (
 
 	serr "github.com/square/spincycle/v2/errors"
 	"github.com/square/spincycle/v2/job"
+	jr "github.com/square/spincycle/v2/job-runner"
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/request-manager/graph"
 	"github.com/square/spincycle/v2/request-manager/id"
+	"github.com/square/spincycle/v2/request-manager/joblog"
 	"github.com/square/spincycle/v2/request-manager/request"
 	"github.com/square/spincycle/v2/request-manager/spec"
 	rmtest "github.com/square/spincycle/v2/request-manager/test"
@@ -202,6 +204,421 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+func TestCreateStrictFailure(t *testing.T) {
+	dbName := setupManager(t, "")
+	defer teardownManager(t, dbName)
+
+	specs, result := spec.ParseSpec(rmtest.SpecPath + "/a-b-c.yaml")
+	if len(result.Errors) != 0 {
+		t.Fatal(result.Errors)
+	}
+	spec.ProcessSpecs(&specs)
+	seq := specs.Sequences["three-nodes"]
+
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		Sequences:       specs.Sequences,
+		DBConnector:     dbc,
+		JRClient:        &mock.JRClient{},
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+	}
+	m := request.NewManager(cfg)
+
+	create := func(strict bool) proto.Request {
+		req, err := m.Create(proto.CreateRequest{
+			Type:          "three-nodes",
+			User:          "john",
+			Args:          map[string]interface{}{"foo": "x"},
+			StrictFailure: strict,
+		})
+		if err != nil {
+			t.Fatalf("error = %s, expected nil", err)
+		}
+		return req
+	}
+
+	if req := create(false); req.JobChain.StrictFailure {
+		t.Errorf("job chain has strict failure, expected not strict by default")
+	}
+	if req := create(true); !req.JobChain.StrictFailure {
+		t.Errorf("job chain does not have strict failure, expected strict when given")
+	}
+	seq.StrictFailure = true
+	if req := create(false); !req.JobChain.StrictFailure {
+		t.Errorf("job chain does not have strict failure, expected strict from spec")
+	}
+}
+
+func TestCreateLogLevel(t *testing.T) {
+	dbName := setupManager(t, "")
+	defer teardownManager(t, dbName)
+
+	specs, result := spec.ParseSpec(rmtest.SpecPath + "/a-b-c.yaml")
+	if len(result.Errors) != 0 {
+		t.Fatal(result.Errors)
+	}
+	spec.ProcessSpecs(&specs)
+
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		Sequences:       specs.Sequences,
+		DBConnector:     dbc,
+		JRClient:        &mock.JRClient{},
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+	}
+	m := request.NewManager(cfg)
+
+	newReq := proto.CreateRequest{
+		Type:     "three-nodes",
+		User:     "john",
+		Args:     map[string]interface{}{"foo": "x"},
+		LogLevel: "verbose",
+	}
+	if _, err := m.Create(newReq); err == nil {
+		t.Errorf("no error creating request with invalid log level")
+	}
+
+	newReq.LogLevel = proto.LOG_LEVEL_DEBUG
+	req, err := m.Create(newReq)
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	for _, job := range req.JobChain.Jobs {
+		if job.LogLevel != proto.LOG_LEVEL_DEBUG {
+			t.Errorf("job %s log level %q, expected debug", job.Id, job.LogLevel)
+		}
+	}
+}
+
+func TestCreateOverrides(t *testing.T) {
+	dbName := setupManager(t, "")
+	defer teardownManager(t, dbName)
+
+	specs, result := spec.ParseSpec(rmtest.SpecPath + "/a-b-c.yaml")
+	if len(result.Errors) != 0 {
+		t.Fatal(result.Errors)
+	}
+	spec.ProcessSpecs(&specs)
+	specs.Sequences["three-nodes"].Overrides = []*spec.Override{
+		{Name: "new-lb", Values: []string{"on", "off"}},
+	}
+
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		Sequences:       specs.Sequences,
+		DBConnector:     dbc,
+		JRClient:        &mock.JRClient{},
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+	}
+	m := request.NewManager(cfg)
+
+	newReq := proto.CreateRequest{
+		Type:      "three-nodes",
+		User:      "john",
+		Args:      map[string]interface{}{"foo": "x"},
+		Overrides: map[string]string{"dns-endpoint": "https://dns.staging.example.com"},
+	}
+	if _, err := m.Create(newReq); err == nil {
+		t.Errorf("no error creating request with override not in spec")
+	}
+	newReq.Overrides = map[string]string{"new-lb": "maybe"}
+	if _, err := m.Create(newReq); err == nil {
+		t.Errorf("no error creating request with invalid override value")
+	}
+
+	newReq.Overrides = map[string]string{"new-lb": "on"}
+	req, err := m.Create(newReq)
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	if diff := deep.Equal(req.JobChain.Overrides, newReq.Overrides); diff != nil {
+		t.Error(diff)
+	}
+
+	// Rebased rerun is created with the same overrides
+	if err := m.FailPending(req.Id); err != nil {
+		t.Fatal(err)
+	}
+	rerun, err := m.Rerun(proto.RerunRequest{RequestId: req.Id, User: "finch", Rebase: true})
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	jc, err := m.JobChain(rerun.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(jc.Overrides, newReq.Overrides); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCreateHooks(t *testing.T) {
+	dbName := setupManager(t, "")
+	defer teardownManager(t, dbName)
+
+	specs, result := spec.ParseSpec(rmtest.SpecPath + "/a-b-c.yaml")
+	if len(result.Errors) != 0 {
+		t.Fatal(result.Errors)
+	}
+	spec.ProcessSpecs(&specs)
+
+	var created []proto.Request
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		Sequences:       specs.Sequences,
+		DBConnector:     dbc,
+		JRClient:        &mock.JRClient{},
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+		PreCreate: func(seq spec.Sequence, newReq *proto.CreateRequest) error {
+			if seq.Name != "three-nodes" {
+				t.Errorf("got spec %s, expected three-nodes", seq.Name)
+			}
+			if newReq.User == "mallory" {
+				return fmt.Errorf("user over quota")
+			}
+			newReq.Args["bar"] = 5 // enrich
+			return nil
+		},
+		PostCreate: func(req proto.Request) {
+			created = append(created, req)
+		},
+	}
+	m := request.NewManager(cfg)
+
+	args := map[string]interface{}{"foo": "x"}
+	_, err := m.Create(proto.CreateRequest{Type: "three-nodes", User: "mallory", Args: args})
+	if _, ok := err.(serr.RequestRejected); !ok {
+		t.Errorf("got error %v (%T), expected serr.RequestRejected", err, err)
+	}
+
+	req, err := m.Create(proto.CreateRequest{Type: "three-nodes", User: "john", Args: args})
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	if _, ok := args["bar"]; ok {
+		t.Errorf("PreCreate changed the caller's args map, expected a copy")
+	}
+	for _, arg := range req.Args {
+		if arg.Name == "bar" && arg.Value != 5 {
+			t.Errorf("request arg bar = %v, expected 5 set by PreCreate", arg.Value)
+		}
+	}
+	if len(created) != 1 || created[0].Id != req.Id {
+		t.Errorf("PostCreate called with %d requests, expected only request %s", len(created), req.Id)
+	}
+}
+
+func TestCreateDedup(t *testing.T) {
+	dbName := setupManager(t, "")
+	defer teardownManager(t, dbName)
+
+	specs, result := spec.ParseSpec(rmtest.SpecPath + "/a-b-c.yaml")
+	if len(result.Errors) != 0 {
+		t.Fatal(result.Errors)
+	}
+	spec.ProcessSpecs(&specs)
+	seq := specs.Sequences["three-nodes"]
+	seq.DedupKey = "three-{{foo}}"
+
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		Sequences:       specs.Sequences,
+		DBConnector:     dbc,
+		JRClient:        &mock.JRClient{},
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+	}
+	m := request.NewManager(cfg)
+
+	create := func(foo string) (proto.Request, error) {
+		return m.Create(proto.CreateRequest{
+			Type: "three-nodes",
+			User: "john",
+			Args: map[string]interface{}{"foo": foo},
+		})
+	}
+
+	req1, err := create("x")
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	if req1.DedupKey != "three-x" {
+		t.Errorf("DedupKey = %s, expected three-x", req1.DedupKey)
+	}
+
+	// Same key while first request is pending
+	_, err = create("x")
+	expectErr := serr.DuplicateRequest{RequestId: req1.Id, DedupKey: "three-x"}
+	if err != expectErr {
+		t.Errorf("got error %v, expected %v", err, expectErr)
+	}
+
+	// Different key
+	if _, err := create("y"); err != nil {
+		t.Errorf("error = %s, expected nil", err)
+	}
+
+	// Same key with dedupPolicy: error
+	seq.DedupPolicy = spec.DEDUP_POLICY_ERROR
+	_, err = create("x")
+	expectErr.Reject = true
+	if err != expectErr {
+		t.Errorf("got error %v, expected %v", err, expectErr)
+	}
+
+	// Same key after first request finished
+	if err := m.FailPending(req1.Id); err != nil {
+		t.Fatal(err)
+	}
+	req2, err := create("x")
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	got, err := m.Get(req2.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DedupKey != "three-x" {
+		t.Errorf("DedupKey = %s, expected three-x", got.DedupKey)
+	}
+}
+
+func TestSpecVersion(t *testing.T) {
+	dbName := setupManager(t, "")
+	defer teardownManager(t, dbName)
+
+	specs, result := spec.ParseSpec(rmtest.SpecPath + "/a-b-c.yaml")
+	if len(result.Errors) != 0 {
+		t.Fatal(result.Errors)
+	}
+	spec.ProcessSpecs(&specs)
+	v1 := specs.Version("three-nodes")
+
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		Sequences:       specs.Sequences,
+		DBConnector:     dbc,
+		JRClient:        &mock.JRClient{},
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+	}
+	m := request.NewManager(cfg)
+
+	req1, err := m.Create(proto.CreateRequest{
+		Type: "three-nodes",
+		User: "john",
+		Args: map[string]interface{}{"foo": "x"},
+	})
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	got, err := m.Get(req1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SpecVersion != v1 {
+		t.Errorf("SpecVersion = %s, expected %s", got.SpecVersion, v1)
+	}
+	if err := m.FailPending(req1.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spec changed (RM restarted with new specs): rebased rerun is made from
+	// the new spec
+	specs.Sequences["three-nodes"].Desc = "changed"
+	v2 := specs.Version("three-nodes")
+	m = request.NewManager(cfg)
+
+	_, err = m.Rerun(proto.RerunRequest{RequestId: req1.Id, JobId: "a1b2", Rebase: true})
+	switch err.(type) {
+	case serr.ValidationError:
+	default:
+		t.Errorf("error = %v, expected serr.ValidationError for rebase with jobId", err)
+	}
+
+	req2, err := m.Rerun(proto.RerunRequest{RequestId: req1.Id, User: "finch", Rebase: true})
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	if req2.SpecVersion != v2 || req2.SpecVersion == v1 {
+		t.Errorf("SpecVersion = %s, expected %s (not %s)", req2.SpecVersion, v2, v1)
+	}
+	if req2.User != "finch" || req2.TotalJobs != req1.TotalJobs {
+		t.Errorf("got user %s, %d jobs; expected finch, %d jobs", req2.User, req2.TotalJobs, req1.TotalJobs)
+	}
+}
+
+func TestArgsDiff(t *testing.T) {
+	dbName := setupManager(t, "")
+	defer teardownManager(t, dbName)
+
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		DBConnector:     dbc,
+		JRClient:        &mock.JRClient{},
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+	}
+	m := request.NewManager(cfg)
+
+	reqParams := proto.CreateRequest{
+		Type: "three-nodes",
+		User: "john",
+		Args: map[string]interface{}{
+			"foo": "foo-value",
+		},
+	}
+	req, err := m.Create(reqParams)
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+
+	argsDiff, err := m.ArgsDiff(req.Id)
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	if diff := deep.Equal(argsDiff.Submitted, reqParams.Args); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(argsDiff.Args, req.Args); diff != nil {
+		t.Error(diff)
+	}
+	if len(argsDiff.Jobs) != int(req.TotalJobs) {
+		t.Fatalf("got args for %d jobs, expected %d", len(argsDiff.Jobs), req.TotalJobs)
+	}
+
+	// Job names and IDs are non-deterministic (see TestCreate), but request
+	// args passed through to jobs unchanged must be given or default
+	for _, job := range argsDiff.Jobs {
+		for _, arg := range job.Args {
+			switch arg.Name {
+			case "foo":
+				expect := proto.ArgDiff{Name: "foo", Source: proto.ARG_SOURCE_GIVEN, Request: "foo-value", Value: "foo-value"}
+				if diff := deep.Equal(arg, expect); diff != nil {
+					t.Errorf("job %s: %v", job.JobId, diff)
+				}
+			case "bar":
+				expect := proto.ArgDiff{Name: "bar", Source: proto.ARG_SOURCE_DEFAULT, Request: "175", Value: "175"}
+				if diff := deep.Equal(arg, expect); diff != nil {
+					t.Errorf("job %s: %v", job.JobId, diff)
+				}
+			}
+		}
+	}
+
+	// Request not found
+	_, err = m.ArgsDiff("b9uvdi8tk9kahl8ppvbg")
+	switch err.(type) {
+	case serr.RequestNotFound:
+	default:
+		t.Errorf("error = %v, expected serr.RequestNotFound", err)
+	}
+}
+
 func TestGetNotFound(t *testing.T) {
 	dbName := setupManager(t, rmtest.DataPath+"/request-default.sql")
 	defer teardownManager(t, dbName)
@@ -338,6 +755,127 @@ func TestStart(t *testing.T) {
 	if req.State != proto.STATE_RUNNING {
 		t.Errorf("request state = %d, expected %d", req.State, proto.STATE_RUNNING)
 	}
+
+	// Request is removed from the outbox once running
+	var count int
+	if err := dbc.QueryRow("SELECT COUNT(*) FROM request_outbox WHERE request_id = ?", reqId).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("request %s still in outbox", reqId)
+	}
+}
+
+func TestStartMissingJobTypes(t *testing.T) {
+	dbName := setupManager(t, rmtest.DataPath+"/request-default.sql")
+	defer teardownManager(t, dbName)
+
+	// The JR cannot make the job chain's only job type (dummy), so the job
+	// chain is not sent
+	sent := false
+	mockJRc := &mock.JRClient{
+		JobTypesFunc: func(baseURL string) ([]string, error) {
+			return []string{"noop", "sleep"}, nil
+		},
+		NewJobChainFunc: func(baseURL string, jc proto.JobChain) (*url.URL, error) {
+			sent = true
+			url, _ := url.Parse("http://fake_host:1111/api/v1/job-chains/1")
+			return url, nil
+		},
+	}
+
+	reqId := "0874a524aa1edn3ysp00" // request is pending
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		DBConnector:     dbc,
+		JRClient:        mockJRc,
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+	}
+	m := request.NewManager(cfg)
+	err := m.Start(reqId)
+	expect := jr.ErrChainRejected{Message: "job types not registered in Job Runner http://defaulturl:1111 job factory: dummy"}
+	if err != expect {
+		t.Errorf("error = %v, expected %v", err, expect)
+	}
+	if sent {
+		t.Errorf("job chain sent to JR, expected it not sent")
+	}
+}
+
+func TestDispatchAll(t *testing.T) {
+	dbName := setupManager(t, rmtest.DataPath+"/request-outbox.sql")
+	defer teardownManager(t, dbName)
+
+	// jr1 is already running the job chain sent by the crashed RM
+	sent := map[string]string{} // request ID => JR URL
+	mockJRc := &mock.JRClient{
+		NewJobChainFunc: func(baseURL string, jc proto.JobChain) (*url.URL, error) {
+			sent[jc.RequestId] = baseURL
+			if baseURL == "http://jr1:32307" {
+				return nil, jr.ErrChainRunning{RequestId: jc.RequestId}
+			}
+			return url.Parse(baseURL + "/api/v1/job-chains/" + jc.RequestId)
+		},
+	}
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		DBConnector:     dbc,
+		JRClient:        mockJRc,
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+		RMHost:          "this_host",
+	}
+	m := request.NewManager(cfg)
+	m.DispatchAll()
+
+	expectSent := map[string]string{
+		"abandoned_dispatch__": "http://jr1:32307",
+		"unclaimed_dispatch__": "http://defaulturl:1111",
+	}
+	if diff := deep.Equal(sent, expectSent); diff != nil {
+		t.Error(diff)
+	}
+
+	expect := map[string]struct {
+		state byte
+		jrURL string
+	}{
+		"abandoned_dispatch__": {proto.STATE_RUNNING, "http://jr1:32307"},
+		"unclaimed_dispatch__": {proto.STATE_RUNNING, "http://defaulturl:1111"},
+		"claimed_dispatch____": {proto.STATE_PENDING, ""},
+		"old_dispatch________": {proto.STATE_FAIL, ""},
+		"running_dispatch____": {proto.STATE_RUNNING, "http://jr2:32307"},
+		"orphan______________": {proto.STATE_FAIL, ""},
+	}
+	for reqId, e := range expect {
+		req, err := m.Get(reqId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.State != e.state || req.JobRunnerURL != e.jrURL {
+			t.Errorf("request %s: state %s on %q, expected %s on %q", reqId,
+				proto.StateName[req.State], req.JobRunnerURL, proto.StateName[e.state], e.jrURL)
+		}
+	}
+
+	// Only the request claimed by another RM is left in the outbox
+	var ids []string
+	rows, err := dbc.Query("SELECT request_id FROM request_outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if diff := deep.Equal(ids, []string{"claimed_dispatch____"}); diff != nil {
+		t.Error(diff)
+	}
 }
 
 func TestStopNotRunning(t *testing.T) {
@@ -397,20 +935,58 @@ func TestStopComplete(t *testing.T) {
 	if recvdId != "" {
 		t.Errorf("request id = %s, expected an empty string", recvdId)
 	}
-
+
+}
+
+func TestStop(t *testing.T) {
+	dbName := setupManager(t, rmtest.DataPath+"/request-default.sql")
+	defer teardownManager(t, dbName)
+
+	// Create a mock JR client that records the requestId it receives.
+	var recvdId string
+	var recvdHost string
+	mockJRc := &mock.JRClient{
+		StopRequestFunc: func(baseURL string, reqId string) error {
+			recvdId = reqId
+			recvdHost = baseURL
+			return nil
+		},
+	}
+
+	reqId := "454ae2f98a05cv16sdwt" // request is running
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		DBConnector:     dbc,
+		JRClient:        mockJRc,
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+	}
+	m := request.NewManager(cfg)
+	err := m.Stop(reqId)
+	if err != nil {
+		t.Errorf("error = %s, expected nil", err)
+	}
+
+	if recvdId != reqId {
+		t.Errorf("request id = %s, expected %s", recvdId, reqId)
+	}
+	req := testdb.SavedRequests[reqId]
+	if recvdHost != req.JobRunnerURL {
+		t.Errorf("JR url = %s, expected %s", recvdHost, req.JobRunnerURL)
+	}
 }
 
-func TestStop(t *testing.T) {
+func TestAddJob(t *testing.T) {
 	dbName := setupManager(t, rmtest.DataPath+"/request-default.sql")
 	defer teardownManager(t, dbName)
 
-	// Create a mock JR client that records the requestId it receives.
-	var recvdId string
-	var recvdHost string
+	var recvdId, recvdHost string
+	var recvdAJ proto.AddChainJob
 	mockJRc := &mock.JRClient{
-		StopRequestFunc: func(baseURL string, reqId string) error {
-			recvdId = reqId
+		AddJobFunc: func(baseURL, reqId string, aj proto.AddChainJob) error {
 			recvdHost = baseURL
+			recvdId = reqId
+			recvdAJ = aj
 			return nil
 		},
 	}
@@ -422,19 +998,77 @@ func TestStop(t *testing.T) {
 		JRClient:        mockJRc,
 		ShutdownChan:    shutdownChan,
 		DefaultJRURL:    "http://defaulturl:1111",
+		JobFactory:      &mock.JobFactory{},
+		IdGenFactory:    id.NewGeneratorFactory(4, 100),
+		AddJobTypes:     []string{"collect-diagnostics"},
 	}
 	m := request.NewManager(cfg)
-	err := m.Stop(reqId)
+
+	// Job type not allowed
+	_, err := m.AddJob(reqId, proto.AddJob{After: "590s", Type: "reboot"})
+	if _, ok := err.(serr.ValidationError); !ok {
+		t.Errorf("error = %v, expected serr.ValidationError", err)
+	}
+
+	// Job to run after not found
+	_, err = m.AddJob(reqId, proto.AddJob{After: "nope", Type: "collect-diagnostics"})
+	if _, ok := err.(serr.JobNotFound); !ok {
+		t.Errorf("error = %v, expected serr.JobNotFound", err)
+	}
+
+	args := map[string]interface{}{"host": "db1"}
+	job, err := m.AddJob(reqId, proto.AddJob{After: "590s", Type: "collect-diagnostics", Args: args})
 	if err != nil {
-		t.Errorf("error = %s, expected nil", err)
+		t.Fatal(err)
+	}
+	if job.Name != "collect-diagnostics" || job.SequenceId != job.Id || job.State != proto.STATE_PENDING {
+		t.Errorf("got job %+v, expected pending job named collect-diagnostics in its own sequence", job)
+	}
+	if recvdId != reqId || recvdHost != testdb.SavedRequests[reqId].JobRunnerURL {
+		t.Errorf("JR got request %s at %s, expected %s at %s", recvdId, recvdHost, reqId, testdb.SavedRequests[reqId].JobRunnerURL)
+	}
+	if diff := deep.Equal(recvdAJ, proto.AddChainJob{After: "590s", Job: job}); diff != nil {
+		t.Error(diff)
 	}
 
-	if recvdId != reqId {
-		t.Errorf("request id = %s, expected %s", recvdId, reqId)
+	// Job chain and total jobs are saved
+	req, err := m.GetWithJC(reqId)
+	if err != nil {
+		t.Fatal(err)
 	}
-	req := testdb.SavedRequests[reqId]
-	if recvdHost != req.JobRunnerURL {
-		t.Errorf("JR url = %s, expected %s", recvdHost, req.JobRunnerURL)
+	if req.TotalJobs != testdb.SavedRequests[reqId].TotalJobs+1 {
+		t.Errorf("total jobs = %d, expected %d", req.TotalJobs, testdb.SavedRequests[reqId].TotalJobs+1)
+	}
+	if req.JobChain.Jobs[job.Id].Type != "collect-diagnostics" {
+		t.Errorf("job %s not saved in job chain", job.Id)
+	}
+	if diff := deep.Equal(req.JobChain.AdjacencyList["590s"], []string{"g012", job.Id}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(req.JobChain.AdjacencyList[job.Id], []string{"pzi8"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Job Runner rejects job: job chain not saved
+	mockJRc.AddJobFunc = func(baseURL, reqId string, aj proto.AddChainJob) error {
+		return jr.ErrChainRejected{Message: "job 9sa1 did not complete (state FAIL)"}
+	}
+	_, err = m.AddJob(reqId, proto.AddJob{After: "9sa1", Type: "collect-diagnostics"})
+	if _, ok := err.(serr.ValidationError); !ok {
+		t.Errorf("error = %v, expected serr.ValidationError", err)
+	}
+	req, err = m.GetWithJC(reqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.JobChain.Jobs) != len(testdb.SavedRequests[reqId].JobChain.Jobs)+1 {
+		t.Errorf("got %d jobs, expected %d", len(req.JobChain.Jobs), len(testdb.SavedRequests[reqId].JobChain.Jobs)+1)
+	}
+
+	// Request not running
+	_, err = m.AddJob("0874a524aa1edn3ysp00", proto.AddJob{After: "590s", Type: "collect-diagnostics"})
+	if _, ok := err.(serr.ErrInvalidState); !ok {
+		t.Errorf("error = %v, expected serr.ErrInvalidState", err)
 	}
 }
 
@@ -514,6 +1148,9 @@ func TestFinish(t *testing.T) {
 	if req.FinishedJobs != params.FinishedJobs {
 		t.Errorf("got FinishedJobs = %d, expected %d", req.FinishedJobs, params.FinishedJobs)
 	}
+	if req.Progress != 100 {
+		t.Errorf("got Progress = %d, expected 100 (complete)", req.Progress)
+	}
 	if req.FinishedAt.IsZero() {
 		t.Errorf("got FinishedAt = nil/NULL, expected a value")
 	}
@@ -904,4 +1541,428 @@ func TestFind(t *testing.T) {
 	if diff := deep.Equal(actual, expected); diff != nil {
 		t.Error(diff)
 	}
+
+	// 7. Sort by type ascending: running requests
+	filter = proto.RequestFilter{
+		States: []byte{proto.STATE_RUNNING},
+		Sort:   proto.SORT_TYPE,
+		Order:  proto.SORT_ASC,
+	}
+	actual, err = m.Find(filter)
+	if err != nil {
+		t.Errorf("error = %s, expected nil", err)
+	}
+	expected = []proto.Request{
+		// do-another-thing, same create time so ordered by id
+		testdb.SavedRequests["running_abandoned___"],
+		testdb.SavedRequests["running_with_old_sjc"],
+		// do-something
+		testdb.SavedRequests["454ae2f98a05cv16sdwt"],
+	}
+	for i, _ := range expected {
+		expected[i].JobChain = nil
+		expected[i].Args = nil
+	}
+	if diff := deep.Equal(actual, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	// 8. Sort by started ascending: not started last
+	filter = proto.RequestFilter{
+		Sort:  proto.SORT_STARTED,
+		Order: proto.SORT_ASC,
+	}
+	actual, err = m.Find(filter)
+	if err != nil {
+		t.Errorf("error = %s, expected nil", err)
+	}
+	expected = []proto.Request{
+		// same start and create time so ordered by id
+		testdb.SavedRequests["abandoned_old_sjc___"],
+		testdb.SavedRequests["abandoned_sjc_______"],
+		testdb.SavedRequests["old_sjc_____________"],
+		testdb.SavedRequests["running_abandoned___"],
+		testdb.SavedRequests["running_with_old_sjc"],
+		testdb.SavedRequests["suspended___________"],
+		// not started, ordered by ascending create time
+		testdb.SavedRequests["0874a524aa1edn3ysp00"],
+		testdb.SavedRequests["454ae2f98a05cv16sdwt"],
+		testdb.SavedRequests["93ec156e204ety45sgf0"],
+	}
+	for i, _ := range expected {
+		expected[i].JobChain = nil
+		expected[i].Args = nil
+	}
+	if diff := deep.Equal(actual, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	// 9. Filter resource
+	filter = proto.RequestFilter{
+		Resource: "db01",
+	}
+	actual, err = m.Find(filter)
+	if err != nil {
+		t.Errorf("error = %s, expected nil", err)
+	}
+	expected = []proto.Request{
+		testdb.SavedRequests["454ae2f98a05cv16sdwt"],
+	}
+	for i, _ := range expected {
+		expected[i].JobChain = nil
+		expected[i].Args = nil
+	}
+	if diff := deep.Equal(actual, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	// 10. Invalid sort and order
+	for _, filter := range []proto.RequestFilter{{Sort: "user"}, {Order: "up"}} {
+		_, err = m.Find(filter)
+		if _, ok := err.(serr.ValidationError); !ok {
+			t.Errorf("filter %+v: got error %v, expected serr.ValidationError", filter, err)
+		}
+	}
+}
+
+func TestRerun(t *testing.T) {
+	dbName := setupManager(t, rmtest.DataPath+"/rerun.sql")
+	defer teardownManager(t, dbName)
+
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		DBConnector:     dbc,
+		JRClient:        &mock.JRClient{},
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+		JLStore:         joblog.NewStore(joblog.StoreConfig{DBConnector: dbc}),
+	}
+	m := request.NewManager(cfg)
+
+	// Rerun failed job e5f6 and downstream job g7h8. Job data is seeded from
+	// a1b2 (upstream of e5f6) and c3d4 (upstream of g7h8), which completed.
+	req, err := m.Rerun(proto.RerunRequest{RequestId: "rerunfailed_________", JobId: "e5f6", User: "finch"})
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	if req.Id == "" || req.Id == "rerunfailed_________" {
+		t.Errorf("got request id '%s', expected new request id", req.Id)
+	}
+	if req.State != proto.STATE_PENDING {
+		t.Errorf("state = %d, expected %d", req.State, proto.STATE_PENDING)
+	}
+	if req.User != "finch" || req.Type != "some-type" || req.TotalJobs != 2 {
+		t.Errorf("got user %s, type %s, %d jobs; expected finch, some-type, 2 jobs", req.User, req.Type, req.TotalJobs)
+	}
+
+	gotJC, err := m.JobChain(req.Id)
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	expectJC := proto.JobChain{
+		RequestId: req.Id,
+		State:     proto.STATE_PENDING,
+		User:      "finch",
+		Jobs: map[string]proto.Job{
+			"e5f6": proto.Job{
+				Id:         "e5f6",
+				Name:       "e",
+				Type:       "fake",
+				State:      proto.STATE_PENDING,
+				SequenceId: "e5f6", // sequence started upstream (a1b2)
+				Data:       map[string]interface{}{"host": "h1"},
+			},
+			"g7h8": proto.Job{
+				Id:         "g7h8",
+				Name:       "g",
+				Type:       "fake",
+				State:      proto.STATE_PENDING,
+				SequenceId: "e5f6",
+				Data:       map[string]interface{}{"host": "h1", "ip": "10.0.0.1"},
+			},
+		},
+		AdjacencyList: map[string][]string{
+			"e5f6": []string{"g7h8"},
+		},
+		// From the original chain
+		Globals:   map[string]interface{}{"env": "prod"},
+		Overrides: map[string]string{"new-lb": "on"},
+		Webhooks: []proto.SequenceWebhook{
+			{
+				URL:        "http://hooks/seq",
+				Sequence:   "a",
+				StartJobId: "a1b2",
+				EndJobId:   "g7h8",
+				JobIds:     []string{"a1b2", "c3d4", "e5f6", "g7h8"},
+			},
+		},
+		Escalations: []proto.SequenceEscalation{
+			{
+				URL:        "http://pager/seq",
+				After:      "1h",
+				Step:       1,
+				Sequence:   "a",
+				StartJobId: "a1b2",
+				JobIds:     []string{"a1b2", "c3d4", "e5f6", "g7h8"},
+			},
+		},
+	}
+	if diff := deep.Equal(gotJC, expectJC); diff != nil {
+		test.Dump(gotJC)
+		t.Error(diff)
+	}
+
+	// Can't rerun c3d4 because upstream job of g7h8 (e5f6) failed
+	_, err = m.Rerun(proto.RerunRequest{RequestId: "rerunfailed_________", JobId: "c3d4"})
+	switch err.(type) {
+	case serr.ValidationError:
+	default:
+		t.Errorf("error = %v, expected serr.ValidationError", err)
+	}
+
+	// Job not found
+	_, err = m.Rerun(proto.RerunRequest{RequestId: "rerunfailed_________", JobId: "zzzz"})
+	switch err.(type) {
+	case serr.JobNotFound:
+	default:
+		t.Errorf("error = %v, expected serr.JobNotFound", err)
+	}
+
+	// Can't rerun a running request
+	_, err = m.Rerun(proto.RerunRequest{RequestId: "rerunrunning________", JobId: "a1b2"})
+	switch err.(type) {
+	case serr.ErrInvalidState:
+	default:
+		t.Errorf("error = %v, expected serr.ErrInvalidState", err)
+	}
+}
+
+func TestRerunSkipSequence(t *testing.T) {
+	dbName := setupManager(t, rmtest.DataPath+"/rerun.sql")
+	defer teardownManager(t, dbName)
+
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		DBConnector:     dbc,
+		JRClient:        &mock.JRClient{},
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+		JLStore:         joblog.NewStore(joblog.StoreConfig{DBConnector: dbc}),
+	}
+	m := request.NewManager(cfg)
+
+	// Skip sequence s1s1 in which x1x1 failed: the rerun starts with the last
+	// job of the sequence (t1t1), seeded with the job data of its first job
+	req, err := m.Rerun(proto.RerunRequest{RequestId: "rerunskipseq________", SkipSequence: "s1s1", User: "finch"})
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	if req.TotalJobs != 2 {
+		t.Errorf("got %d jobs, expected 2", req.TotalJobs)
+	}
+	gotJC, err := m.JobChain(req.Id)
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	expectJC := proto.JobChain{
+		RequestId: req.Id,
+		State:     proto.STATE_PENDING,
+		User:      "finch",
+		Jobs: map[string]proto.Job{
+			"t1t1": proto.Job{
+				Id:         "t1t1",
+				Name:       "t",
+				Type:       "noop",
+				State:      proto.STATE_PENDING,
+				SequenceId: "t1t1",
+				Data:       map[string]interface{}{"host": "h1"},
+			},
+			"z9z9": proto.Job{
+				Id:         "z9z9",
+				Name:       "z",
+				Type:       "noop",
+				State:      proto.STATE_PENDING,
+				SequenceId: "t1t1",
+				Data:       map[string]interface{}{},
+			},
+		},
+		AdjacencyList: map[string][]string{
+			"t1t1": []string{"z9z9"},
+		},
+	}
+	if diff := deep.Equal(gotJC, expectJC); diff != nil {
+		test.Dump(gotJC)
+		t.Error(diff)
+	}
+
+	// Can't skip the request sequence (a1b2) because it's not skippable,
+	// and can't skip x1x1 because it's not the first job of a sequence
+	for _, seqId := range []string{"a1b2", "x1x1"} {
+		_, err = m.Rerun(proto.RerunRequest{RequestId: "rerunskipseq________", SkipSequence: seqId})
+		switch err.(type) {
+		case serr.ValidationError:
+		default:
+			t.Errorf("skip %s: error = %v, expected serr.ValidationError", seqId, err)
+		}
+	}
+
+	// Can't skip a sequence and rerun a job
+	_, err = m.Rerun(proto.RerunRequest{RequestId: "rerunskipseq________", SkipSequence: "s1s1", JobId: "x1x1"})
+	switch err.(type) {
+	case serr.ValidationError:
+	default:
+		t.Errorf("error = %v, expected serr.ValidationError", err)
+	}
+}
+
+func TestCreateFromChain(t *testing.T) {
+	dbName := setupManager(t, "")
+	defer teardownManager(t, dbName)
+
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		DBConnector:     dbc,
+		JRClient:        &mock.JRClient{},
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+	}
+	m := request.NewManager(cfg)
+
+	// Job chain exported from a finished request: job states are reset, and
+	// job IDs, bytes, and data are kept
+	exported := proto.JobChain{
+		RequestId: "exported1",
+		State:     proto.STATE_COMPLETE,
+		Jobs: map[string]proto.Job{
+			"a1a1": proto.Job{
+				Id:         "a1a1",
+				Name:       "a",
+				Type:       "noop",
+				State:      proto.STATE_COMPLETE,
+				SequenceId: "a1a1",
+				Data:       map[string]interface{}{"host": "h1"},
+			},
+			"b2b2": proto.Job{
+				Id:         "b2b2",
+				Name:       "b",
+				Type:       "restart",
+				Bytes:      []byte("restart h1"),
+				State:      proto.STATE_FAIL,
+				SequenceId: "a1a1",
+				Retry:      1,
+				RetryWait:  "1s",
+			},
+		},
+		AdjacencyList: map[string][]string{
+			"a1a1": []string{"b2b2"},
+		},
+	}
+	req, err := m.CreateFromChain(proto.CreateRequestFromChain{Type: "planned", JobChain: exported, User: "finch"})
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	if req.State != proto.STATE_PENDING || req.TotalJobs != 2 || req.User != "finch" {
+		t.Errorf("got request %+v, expected pending with 2 jobs, user finch", req)
+	}
+	gotJC, err := m.JobChain(req.Id)
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	expectJC := exported
+	expectJC.RequestId = req.Id
+	expectJC.State = proto.STATE_PENDING
+	expectJC.User = "finch"
+	expectJC.Jobs = map[string]proto.Job{}
+	for jobId, job := range exported.Jobs {
+		job.State = proto.STATE_PENDING
+		expectJC.Jobs[jobId] = job
+	}
+	if diff := deep.Equal(gotJC, expectJC); diff != nil {
+		test.Dump(gotJC)
+		t.Error(diff)
+	}
+
+	// Invalid job chains
+	invalid := map[string]func(*proto.JobChain){
+		"wrong job ID": func(jc *proto.JobChain) {
+			jc.Jobs["b2b2"] = proto.Job{Id: "c3c3", Type: "restart", SequenceId: "a1a1"}
+		},
+		"no job type": func(jc *proto.JobChain) {
+			jc.Jobs["b2b2"] = proto.Job{Id: "b2b2", SequenceId: "a1a1"}
+		},
+		"unknown sequence": func(jc *proto.JobChain) {
+			jc.Jobs["b2b2"] = proto.Job{Id: "b2b2", Type: "restart", SequenceId: "z9z9"}
+		},
+		"invalid retry wait": func(jc *proto.JobChain) {
+			jc.Jobs["b2b2"] = proto.Job{Id: "b2b2", Type: "restart", SequenceId: "a1a1", RetryWait: "soon"}
+		},
+		"cycle": func(jc *proto.JobChain) {
+			jc.AdjacencyList = map[string][]string{"a1a1": {"b2b2"}, "b2b2": {"a1a1"}}
+		},
+		"two first jobs": func(jc *proto.JobChain) {
+			jc.AdjacencyList = map[string][]string{}
+		},
+	}
+	for name, change := range invalid {
+		jc := proto.JobChain{Jobs: map[string]proto.Job{}, AdjacencyList: exported.AdjacencyList}
+		for jobId, job := range exported.Jobs {
+			jc.Jobs[jobId] = job
+		}
+		change(&jc)
+		_, err := m.CreateFromChain(proto.CreateRequestFromChain{Type: "planned", JobChain: jc})
+		switch err.(type) {
+		case serr.ErrInvalidCreateRequest:
+		default:
+			t.Errorf("%s: error = %v, expected serr.ErrInvalidCreateRequest", name, err)
+		}
+	}
+}
+
+func TestJobSnapshot(t *testing.T) {
+	dbName := setupManager(t, rmtest.DataPath+"/rerun.sql")
+	defer teardownManager(t, dbName)
+
+	cfg := request.ManagerConfig{
+		ResolverFactory: ref,
+		DBConnector:     dbc,
+		JRClient:        &mock.JRClient{},
+		ShutdownChan:    shutdownChan,
+		DefaultJRURL:    "http://defaulturl:1111",
+		JLStore:         joblog.NewStore(joblog.StoreConfig{DBConnector: dbc}),
+	}
+	m := request.NewManager(cfg)
+
+	// Failed job e5f6: job data from upstream job a1b2, and its last try
+	snap, err := m.JobSnapshot("rerunfailed_________", "e5f6")
+	if err != nil {
+		t.Fatalf("error = %s, expected nil", err)
+	}
+	if snap.RequestId != "rerunfailed_________" || snap.User != "john" {
+		t.Errorf("got request %s user %s, expected rerunfailed_________ john", snap.RequestId, snap.User)
+	}
+	if snap.Job.Id != "e5f6" || snap.Job.Type != "fake" {
+		t.Errorf("got job %s type %s, expected e5f6 type fake", snap.Job.Id, snap.Job.Type)
+	}
+	if diff := deep.Equal(snap.Job.Data, map[string]interface{}{"host": "h1"}); diff != nil {
+		t.Error(diff)
+	}
+	if snap.Last == nil || snap.Last.State != proto.STATE_FAIL {
+		t.Errorf("got last try %+v, expected failed try", snap.Last)
+	}
+
+	// g7h8 did not run because upstream job e5f6 failed: no job data
+	_, err = m.JobSnapshot("rerunfailed_________", "g7h8")
+	switch err.(type) {
+	case serr.ValidationError:
+	default:
+		t.Errorf("error = %v, expected serr.ValidationError", err)
+	}
+
+	// Job not found
+	_, err = m.JobSnapshot("rerunfailed_________", "zzzz")
+	switch err.(type) {
+	case serr.JobNotFound:
+	default:
+		t.Errorf("error = %v, expected serr.JobNotFound", err)
+	}
 }
@@ -0,0 +1,345 @@
+// Copyright 2020, Square, Inc.
+
+package request
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	log "github.com/sirupsen/logrus"
+
+	serr "github.com/square/spincycle/v2/errors"
+	jr "github.com/square/spincycle/v2/job-runner"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/retry"
+	"github.com/square/spincycle/v2/validate"
+)
+
+// --------------------------------------------------------------------------
+// Request outbox:
+//
+// A request is created (saved in the db) before it is started, and the RM can
+// crash between the two, or while sending its job chain to a JR, which would
+// leave the request pending forever. To prevent that, Start saves the request
+// in the outbox (request_outbox table), claimed by this RM, before sending its
+// job chain, and removes it once the request is running. The JR that the job
+// chain is sent to is saved before each try.
+//
+// Every few seconds, DispatchAll unclaims requests that have been claimed for
+// a while, meaning the RM dispatching them probably crashed, then claims and
+// dispatches unclaimed requests. A request is first sent to the JR it was last
+// sent to: if that JR is already running its job chain (HTTP 409), the request
+// was dispatched and only its state needs to be set. A request is sent to a
+// JR at least once and, unless a JR dies with it, run by only one JR.
+//
+// Requests not dispatched within an hour, and pending requests that were never
+// put in the outbox (the RM crashed before Start) and are not in a request
+// group, are failed.
+// --------------------------------------------------------------------------
+
+// enqueue saves the request in the outbox, claimed by this RM.
+func (m *manager) enqueue(requestId string) error {
+	q := "INSERT INTO request_outbox (request_id, rm_host) VALUES (?, ?)"
+	_, err := m.dbConnector.ExecContext(context.TODO(), q, requestId, m.host)
+	if err != nil {
+		if myErr, ok := err.(*mysql.MySQLError); ok && myErr.Number == 1062 { // ER_DUP_ENTRY
+			return fmt.Errorf("request %s is already being started", requestId)
+		}
+		return serr.NewDbError(err, "INSERT request_outbox")
+	}
+	return nil
+}
+
+// dequeue removes the request from the outbox.
+func (m *manager) dequeue(requestId string) error {
+	q := "DELETE FROM request_outbox WHERE request_id = ?"
+	_, err := m.dbConnector.ExecContext(context.TODO(), q, requestId)
+	return err
+}
+
+// dispatch sends the request's job chain to a JR, then sets the request state to
+// RUNNING and removes the request from the outbox. If jrURL is set, the job chain
+// was sent to that JR before, so the first try sends it there again: that JR might
+// be running it.
+func (m *manager) dispatch(req proto.Request, jrURL string, tries int) error {
+	// Send the request's job chain to the job runner, which will start running it.
+	// With registered JRs, each try can choose a different JR. The JR client
+	// retries transient errors, so try again only if the JR is busy (shutting
+	// down) or unavailable; a rejected job chain will be rejected again.
+	var chainURL *url.URL
+	var err error
+	for i := 0; i < tries; i++ {
+		if i != 0 {
+			time.Sleep(JR_RETRY_WAIT)
+		}
+		if i != 0 || jrURL == "" {
+			jrURL = m.defaultJRURL
+			if m.registry != nil {
+				jrURL = m.registry.URL(validate.Needs(*req.JobChain))
+			}
+		}
+
+		// Save the JR before sending so a later dispatch sends it there first
+		q := "UPDATE request_outbox SET jr_url = ?, tries = tries + 1 WHERE request_id = ?"
+		err = retry.Do(DB_TRIES, DB_RETRY_WAIT, func() error {
+			_, err := m.dbConnector.ExecContext(context.TODO(), q, jrURL, req.Id)
+			return err
+		}, nil)
+		if err != nil {
+			return serr.NewDbError(err, "UPDATE request_outbox")
+		}
+
+		// Fail fast if the JR cannot make every job type in the job chain,
+		// instead of failing when the first unknown job runs
+		if err = m.checkJobTypes(jrURL, *req.JobChain); err != nil {
+			return err
+		}
+
+		chainURL, err = m.jrClient.NewJobChain(jrURL, *req.JobChain)
+		if err == nil {
+			req.JobRunnerURL = strings.TrimSuffix(chainURL.String(), chainURL.RequestURI())
+			break
+		}
+		if _, ok := err.(jr.ErrChainRunning); ok {
+			log.Infof("request %s: Job Runner %s is already running job chain", req.Id, jrURL)
+			req.JobRunnerURL = jrURL
+			err = nil
+			break
+		}
+		if _, ok := err.(jr.ErrChainRejected); ok {
+			return err
+		}
+		log.Warnf("request %s: error sending job chain to Job Runner %s (try %d of %d): %s", req.Id, jrURL, i+1, tries, err)
+	}
+	if req.JobRunnerURL == "" {
+		return err
+	}
+
+	now := time.Now().UTC()
+	req.StartedAt = &now
+	req.State = proto.STATE_RUNNING
+
+	// This will only update the request if the current state is PENDING. The
+	// state should be PENDING since we checked this earlier, but it's possible
+	// something else has changed the state since then.
+	if err := m.updateRequest(req, proto.STATE_PENDING); err != nil {
+		return err
+	}
+
+	// The request is running. If it cannot be removed from the outbox,
+	// DispatchAll removes it because it's not pending.
+	if err := m.dequeue(req.Id); err != nil {
+		log.Errorf("request %s: error removing request from outbox: %s", req.Id, err)
+	}
+
+	// Shadow the request only after it has started so a shadow run never
+	// exists for a request that did not run.
+	if m.shadow != nil {
+		m.shadow.Start(req)
+	}
+
+	return nil
+}
+
+// jrJobTypes are the job types that a JR can make, cached when fetched.
+type jrJobTypes struct {
+	types     map[string]bool
+	fetchedAt time.Time
+}
+
+// checkJobTypes returns ErrChainRejected listing the job types in the job chain
+// that the JR cannot make. The JR job types are cached for JR_JOB_TYPES_TTL. If
+// the JR does not list its job types, or they cannot be fetched, the job chain
+// is not checked: the JR rejects or fails unknown job types as before.
+func (m *manager) checkJobTypes(jrURL string, jc proto.JobChain) error {
+	m.Lock()
+	cached, ok := m.jrJobTypes[jrURL]
+	m.Unlock()
+	if !ok || time.Since(cached.fetchedAt) > JR_JOB_TYPES_TTL {
+		types, err := m.jrClient.JobTypes(jrURL)
+		if err != nil {
+			log.Warnf("error getting job types from Job Runner %s, not checking job chain %s: %s", jrURL, jc.RequestId, err)
+			return nil
+		}
+		cached = jrJobTypes{fetchedAt: time.Now()}
+		if types != nil {
+			cached.types = make(map[string]bool, len(types))
+			for _, t := range types {
+				cached.types[t] = true
+			}
+		}
+		m.Lock()
+		m.jrJobTypes[jrURL] = cached
+		m.Unlock()
+	}
+	if cached.types == nil {
+		return nil // JR does not list job types
+	}
+
+	missing := map[string]bool{}
+	for _, job := range jc.Jobs {
+		if !cached.types[job.Type] {
+			missing[job.Type] = true
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	list := make([]string, 0, len(missing))
+	for t := range missing {
+		list = append(list, t)
+	}
+	sort.Strings(list)
+	return jr.ErrChainRejected{
+		Message: fmt.Sprintf("job types not registered in Job Runner %s job factory: %s", jrURL, strings.Join(list, ", ")),
+	}
+}
+
+func (m *manager) DispatchAll() {
+	ctx := context.TODO()
+
+	// Unclaim abandoned requests: claimed but not updated in the last 5 minutes.
+	// The RM that claimed them probably crashed while dispatching them.
+	q := "UPDATE request_outbox SET rm_host = NULL WHERE rm_host IS NOT NULL AND updated_at < NOW() - INTERVAL 5 MINUTE"
+	if _, err := m.dbConnector.ExecContext(ctx, q); err != nil {
+		log.Errorf("error unclaiming abandoned requests in outbox: %s", err)
+		return
+	}
+
+	// Retrieve all unclaimed requests, the JR each was last sent to, and
+	// whether it's been in the outbox too long
+	type outboxRequest struct {
+		id    string
+		jrURL string
+		old   bool
+	}
+	var outbox []outboxRequest
+	q = "SELECT request_id, COALESCE(jr_url, ''), created_at < NOW() - INTERVAL 1 HOUR FROM request_outbox WHERE rm_host IS NULL"
+	rows, err := m.dbConnector.QueryContext(ctx, q)
+	if err != nil {
+		log.Errorf("error querying db for requests in outbox: %s", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r outboxRequest
+		if err := rows.Scan(&r.id, &r.jrURL, &r.old); err != nil {
+			log.Errorf("error scanning row: %s", err)
+			return
+		}
+		outbox = append(outbox, r)
+	}
+	rows.Close() // must close before new queries
+
+	for _, r := range outbox {
+		// If the RM is shutting down, stop dispatching requests.
+		select {
+		case <-m.shutdownChan:
+			log.Infof("Request Manager is shutting down - not dispatching any more requests")
+			return
+		default:
+		}
+
+		// Claim the request so no other RM dispatches it at the same time. If
+		// not claimed, another RM claimed it first.
+		claimed, err := m.claim(r.id)
+		if err != nil {
+			log.Errorf("request %s: error claiming request in outbox: %s", r.id, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := m.redispatch(r.id, r.jrURL, r.old); err != nil {
+			log.Errorf("request %s: error dispatching request: %s", r.id, err)
+			// Not dispatched, so unclaim it to try again later
+			q := "UPDATE request_outbox SET rm_host = NULL WHERE request_id = ? AND rm_host = ?"
+			if _, err := m.dbConnector.ExecContext(ctx, q, r.id, m.host); err != nil {
+				log.Errorf("request %s: error unclaiming request in outbox: %s", r.id, err)
+			}
+		}
+	}
+
+	// Fail orphaned requests: pending for 5 minutes but not in the outbox, so
+	// the RM crashed between Create and Start. They were never authorized to
+	// start, so they cannot be dispatched. Requests in a group are started (or
+	// failed) by the group manager, so they can be pending longer.
+	var orphans []string
+	q = "SELECT r.request_id FROM requests r LEFT JOIN request_outbox o ON o.request_id = r.request_id" +
+		" WHERE r.state = ? AND r.created_at < ? AND o.request_id IS NULL AND r.group_id IS NULL"
+	rows, err = m.dbConnector.QueryContext(ctx, q, proto.STATE_PENDING, time.Now().UTC().Add(-5*time.Minute))
+	if err != nil {
+		log.Errorf("error querying db for orphaned pending requests: %s", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Errorf("error scanning row: %s", err)
+			return
+		}
+		orphans = append(orphans, id)
+	}
+	rows.Close()
+
+	for _, id := range orphans {
+		log.Warnf("request %s: pending but never started, failing request", id)
+		if err := m.FailPending(id); err != nil {
+			log.Errorf("request %s: error failing request: %s", id, err)
+		}
+	}
+}
+
+// redispatch dispatches a claimed request in the outbox, or removes it if it's
+// not pending. A request that's been in the outbox too long (old) or whose job
+// chain is rejected is failed.
+func (m *manager) redispatch(requestId, jrURL string, old bool) error {
+	req, err := m.GetWithJC(requestId)
+	if err != nil {
+		if _, ok := err.(serr.RequestNotFound); ok {
+			return m.dequeue(requestId)
+		}
+		return err
+	}
+
+	// Dispatched or failed, but not removed from the outbox
+	if req.State != proto.STATE_PENDING {
+		return m.dequeue(requestId)
+	}
+
+	if old {
+		log.Warnf("request %s: not started within an hour, failing request", requestId)
+		return m.FailPending(requestId)
+	}
+
+	log.Infof("request %s: dispatching request (last sent to Job Runner %q)", requestId, jrURL)
+	err = m.dispatch(req, jrURL, 1) // one try; DispatchAll tries again later
+	if _, ok := err.(jr.ErrChainRejected); ok {
+		log.Errorf("request %s: job chain rejected, failing request: %s", requestId, err)
+		return m.FailPending(requestId)
+	}
+	return err
+}
+
+// claim claims a request in the outbox by setting rm_host to the host of this
+// RM, only if it's not already claimed. It returns false if another RM claimed
+// it or it's not in the outbox.
+func (m *manager) claim(requestId string) (bool, error) {
+	q := "UPDATE request_outbox SET rm_host = ? WHERE request_id = ? AND rm_host IS NULL"
+	result, err := m.dbConnector.ExecContext(context.TODO(), q, m.host, requestId)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count == 1, nil
+}
@@ -14,9 +14,12 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/square/spincycle/v2/compress"
 	serr "github.com/square/spincycle/v2/errors"
 	jr "github.com/square/spincycle/v2/job-runner"
 	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/registry"
+	"github.com/square/spincycle/v2/validate"
 )
 
 var (
@@ -46,6 +49,13 @@ type Resumer interface {
 	// creating the Resumer (rounded to the nearest second). They're deleted and
 	// their requests' states set to FAILED.
 	Cleanup()
+
+	// Recover suspends all requests running on the Job Runner with the given
+	// base URL, which is dead: it stopped sending heartbeats without suspending
+	// its job chains. The SJC of each request is made from its job chain and
+	// job log: completed jobs stay completed, and jobs that ran but did not
+	// complete are stopped, so they are run again when the SJC is resumed.
+	Recover(jrURL string) error
 }
 
 // TODO(felixp): This kind of comment can probably be moved out of the code
@@ -84,10 +94,12 @@ type resumer struct {
 	dbc          *sql.DB
 	jrc          jr.Client
 	defaultJRURL string
-	host         string // the host this request manager is currently running on
+	registry     registry.Manager // optional; chooses a live JR
+	host         string           // the host this request manager is currently running on
 	shutdownChan chan struct{}
 	logger       *log.Entry
 	sjcTTL       time.Duration // how long after being suspended do we keep an SJC
+	compression  string        // codec to compress stored SJCs
 }
 
 type ResumerConfig struct {
@@ -95,9 +107,11 @@ type ResumerConfig struct {
 	DBConnector          *sql.DB
 	JRClient             jr.Client
 	DefaultJRURL         string
+	Registry             registry.Manager // optional; chooses a live JR, else DefaultJRURL
 	RMHost               string
 	ShutdownChan         chan struct{}
 	SuspendedJobChainTTL time.Duration
+	Compression          string // optional; codec to compress stored SJCs
 }
 
 func NewResumer(cfg ResumerConfig) Resumer {
@@ -106,9 +120,11 @@ func NewResumer(cfg ResumerConfig) Resumer {
 		dbc:          cfg.DBConnector,
 		jrc:          cfg.JRClient,
 		defaultJRURL: cfg.DefaultJRURL,
+		registry:     cfg.Registry,
 		host:         cfg.RMHost,
 		shutdownChan: cfg.ShutdownChan,
 		sjcTTL:       cfg.SuspendedJobChainTTL,
+		compression:  cfg.Compression,
 	}
 }
 
@@ -127,6 +143,10 @@ func (r *resumer) Suspend(sjc proto.SuspendedJobChain) (err error) {
 	if err != nil {
 		return fmt.Errorf("cannot marshal Suspended Job Chain: %s", err)
 	}
+	rawSJC, err = compress.Pack(r.compression, rawSJC)
+	if err != nil {
+		return fmt.Errorf("cannot compress Suspended Job Chain: %s", err)
+	}
 
 	// Connect to database + start transaction.
 	ctx := context.TODO()
@@ -263,14 +283,29 @@ func (r *resumer) Resume(id string) error {
 		return fmt.Errorf("error querying db for request state: %s", err)
 	}
 
+	rawSJC, err = compress.Unpack(rawSJC)
+	if err != nil {
+		return fmt.Errorf("error decompressing SJC: %s", err)
+	}
+
 	var sjc proto.SuspendedJobChain
 	err = json.Unmarshal(rawSJC, &sjc)
 	if err != nil {
 		return fmt.Errorf("error unmarshaling SJC: %s", err)
 	}
 
+	// Don't send a corrupt SJC: the JR would reject it. It's not deleted, so it
+	// can be inspected, until Cleanup fails the request when the SJC is too old.
+	if err := validate.SJC(sjc); err != nil {
+		return err
+	}
+
 	// Send suspended job chain to JR, which will resume running it.
-	chainURL, err := r.jrc.ResumeJobChain(r.defaultJRURL, sjc)
+	jrURL := r.defaultJRURL
+	if r.registry != nil {
+		jrURL = r.registry.URL(validate.Needs(*sjc.JobChain))
+	}
+	chainURL, err := r.jrc.ResumeJobChain(jrURL, sjc)
 	if err != nil {
 		return fmt.Errorf("error sending SJC to Job Runner: %s", err)
 	}
@@ -415,6 +450,115 @@ func (r *resumer) Cleanup() {
 	return
 }
 
+// Recover suspends the requests running on a dead JR. Errors for one request are
+// logged and returned after trying the others, so the caller can try again later.
+func (r *resumer) Recover(jrURL string) error {
+	q := "SELECT request_id FROM requests WHERE state = ? AND jr_url = ?"
+	rows, err := r.dbc.QueryContext(context.TODO(), q, proto.STATE_RUNNING, jrURL)
+	if err != nil {
+		return fmt.Errorf("error querying db for running requests: %s", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("error scanning row: %s", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close() // must be closed before making new queries
+
+	var lastErr error
+	for _, id := range ids {
+		reqLogger := log.WithFields(log.Fields{"request": id, "jr_url": jrURL})
+		sjc, err := r.recoveredSJC(id)
+		if err != nil {
+			reqLogger.Errorf("cannot recover request from dead Job Runner: %s", err)
+			lastErr = err
+			continue
+		}
+		if err := r.Suspend(sjc); err != nil {
+			if _, ok := err.(serr.ErrInvalidState); ok || err == ErrNotUpdated {
+				continue // finished or stopped since the query
+			}
+			reqLogger.Errorf("cannot suspend request from dead Job Runner: %s", err)
+			lastErr = err
+			continue
+		}
+		reqLogger.Infof("suspended request from dead Job Runner, it will be resumed on another Job Runner")
+	}
+	return lastErr
+}
+
+// recoveredSJC makes an SJC for a request from its job chain and job log, as if
+// the JR running it had suspended it.
+func (r *resumer) recoveredSJC(requestId string) (proto.SuspendedJobChain, error) {
+	jc, err := r.rm.JobChain(requestId)
+	if err != nil {
+		return proto.SuspendedJobChain{}, err
+	}
+
+	// Latest try and its state of every job that ran
+	q := "SELECT job_id, try, state FROM job_log WHERE request_id = ?"
+	rows, err := r.dbc.QueryContext(context.TODO(), q, requestId)
+	if err != nil {
+		return proto.SuspendedJobChain{}, fmt.Errorf("error querying db for job log: %s", err)
+	}
+	defer rows.Close()
+	tries := map[string]uint{}
+	states := map[string]byte{}
+	for rows.Next() {
+		var jobId string
+		var try uint
+		var state byte
+		if err := rows.Scan(&jobId, &try, &state); err != nil {
+			return proto.SuspendedJobChain{}, fmt.Errorf("error scanning row: %s", err)
+		}
+		if _, ok := states[jobId]; !ok || try >= tries[jobId] {
+			tries[jobId] = try
+			states[jobId] = state
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return proto.SuspendedJobChain{}, fmt.Errorf("error querying db for job log: %s", err)
+	}
+
+	// Completed jobs stay completed. Jobs that ran but did not complete are
+	// stopped on their latest try, which the JR runs again. Jobs that did not
+	// run, including jobs running when the JR died, are pending.
+	sjc := proto.SuspendedJobChain{
+		RequestId:         requestId,
+		JobChain:          &jc,
+		TotalJobTries:     map[string]uint{},
+		LatestRunJobTries: map[string]uint{},
+		SequenceTries:     map[string]uint{},
+	}
+	jc.FinishedJobs = 0
+	for id, job := range jc.Jobs {
+		state, ran := states[id]
+		switch {
+		case !ran:
+			job.State = proto.STATE_PENDING
+		case state == proto.STATE_COMPLETE:
+			job.State = proto.STATE_COMPLETE
+			jc.FinishedJobs++
+		default:
+			job.State = proto.STATE_STOPPED
+			job.StopReason = proto.STOP_REASON_INTERRUPTED
+		}
+		if ran {
+			sjc.TotalJobTries[id] = tries[id]
+			sjc.LatestRunJobTries[id] = tries[id]
+			if job.SequenceId != "" {
+				sjc.SequenceTries[job.SequenceId] = 1
+			}
+		}
+		jc.Jobs[id] = job
+	}
+	return sjc, nil
+}
+
 // Update the State and JR url of a request. This is a wrapper around
 // updateRequestWithTxn that creates a transaction for updating the request.
 func (r *resumer) updateRequest(request proto.Request, curState byte) error {
@@ -461,7 +605,10 @@ func (r *resumer) updateRequestWithTxn(request proto.Request, curState byte, txn
 		// id given exists.
 		return ErrNotUpdated
 	case 1:
-		return nil
+		if request.State == curState {
+			return nil
+		}
+		return saveState(context.TODO(), txn, request.Id, request.State, time.Now().UTC())
 	default:
 		// This should be impossible since we specify the primary key (request id)
 		// in the WHERE clause of the update.
@@ -560,3 +560,74 @@ func TestCleanup(t *testing.T) {
 		t.Errorf("request %s state = %s, expected %s", req.Id, proto.StateName[req.State], "FAIL")
 	}
 }
+
+func TestRecover(t *testing.T) {
+	dbName := setupResumer(t, rmtest.DataPath+"/request-default.sql")
+	defer teardownResumer(t, dbName)
+
+	cfg := request.ResumerConfig{
+		RequestManager: rm,
+		DBConnector:    dbc,
+		JRClient:       &mock.JRClient{},
+		RMHost:         "hostname",
+		ShutdownChan:   shutdownChan,
+	}
+	r := request.NewResumer(cfg)
+
+	// Request 454ae2f98a05cv16sdwt is running on this JR, which is dead
+	reqId := "454ae2f98a05cv16sdwt"
+	if err := r.Recover("http://jr:0000"); err != nil {
+		t.Fatalf("err = %s, expected nil", err)
+	}
+
+	req, err := rm.Get(reqId)
+	if err != nil {
+		t.Fatalf("err = %s, expected nil", err)
+	}
+	if req.State != proto.STATE_SUSPENDED {
+		t.Errorf("request state = %s, expected SUSPENDED", proto.StateName[req.State])
+	}
+
+	// SJC is made from the job log: completed jobs stay completed, jobs that
+	// ran but did not complete are stopped, and jobs that did not run are pending
+	var rawSJC []byte
+	q := "SELECT suspended_job_chain FROM suspended_job_chains WHERE request_id = ?"
+	if err := dbc.QueryRowContext(context.TODO(), q, reqId).Scan(&rawSJC); err != nil {
+		t.Fatalf("suspended job chain not saved in db: %s", err)
+	}
+	var sjc proto.SuspendedJobChain
+	if err := json.Unmarshal(rawSJC, &sjc); err != nil {
+		t.Fatalf("cannot unmarshal suspended job chain: %s", err)
+	}
+	gotStates := map[string]byte{}
+	for id, job := range sjc.JobChain.Jobs {
+		gotStates[id] = job.State
+	}
+	expectStates := map[string]byte{
+		"di12": proto.STATE_COMPLETE,
+		"590s": proto.STATE_COMPLETE, // failed, then completed on 2nd try
+		"g012": proto.STATE_STOPPED,
+		"9sa1": proto.STATE_STOPPED,
+		"pzi8": proto.STATE_STOPPED,
+		"ldfi": proto.STATE_PENDING, // no job log
+	}
+	if diff := deep.Equal(gotStates, expectStates); diff != nil {
+		t.Error(diff)
+	}
+	if sjc.JobChain.FinishedJobs != 2 {
+		t.Errorf("FinishedJobs = %d, expected 2", sjc.JobChain.FinishedJobs)
+	}
+	expectTries := map[string]uint{"di12": 0, "590s": 1, "g012": 0, "9sa1": 0, "pzi8": 0}
+	if diff := deep.Equal(sjc.TotalJobTries, expectTries); diff != nil {
+		t.Error(diff)
+	}
+
+	// Requests on other JRs are not recovered
+	req, err = rm.Get("0874a524aa1edn3ysp00")
+	if err != nil {
+		t.Fatalf("err = %s, expected nil", err)
+	}
+	if req.State != proto.STATE_PENDING {
+		t.Errorf("request state = %s, expected PENDING", proto.StateName[req.State])
+	}
+}
@@ -9,6 +9,10 @@ Follow the naming convention of the other files in this package
 Do not edit a migration file after it has been commited to the master
 branch, but create a new migration file to fix any errors.
 
+Apply applies the migrations not applied to a db yet, in order, and
+records them in the schema_migrations table. It's run by
+"request-manager migrate".
+
 The test in migration_test.go ensures that the tables produced by
 applying all the migrations vs the tables produced by applying
 request_manager_schema.sql are identical (their CREATE TABLE
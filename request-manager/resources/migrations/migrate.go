@@ -0,0 +1,169 @@
+// Copyright 2020, Square, Inc.
+
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// TABLE records the migrations applied by Apply, one row per version.
+const TABLE = "schema_migrations"
+
+var fileRe = regexp.MustCompile(`^(v\d+)_\w+\.sql$`)
+
+// A Migration is one migration file.
+type Migration struct {
+	Version string // v001, from the file name
+	File    string
+}
+
+// List returns the migrations in the directory, ordered by version.
+func List(dir string) ([]Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read migrations dir: %s", err)
+	}
+	var migrations []Migration
+	seen := map[string]string{}
+	for _, f := range files {
+		m := fileRe.FindStringSubmatch(f.Name())
+		if f.IsDir() || m == nil {
+			continue
+		}
+		if prev, ok := seen[m[1]]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", prev, f.Name())
+		}
+		seen[m[1]] = f.Name()
+		migrations = append(migrations, Migration{Version: m[1], File: filepath.Join(dir, f.Name())})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Apply applies the migrations in the directory that are not applied to the db
+// yet, in order, and records each one in TABLE. It returns the versions applied.
+//
+// A db with the Request Manager schema but without TABLE was created from
+// request_manager_schema.sql or migrated by hand, so Apply cannot know which
+// migrations it has. Apply returns an error unless baseline is set to the last
+// version the db has: migrations up to and including baseline are recorded as
+// applied without running them.
+//
+// Statements in a migration file are split on ";", and MySQL does not roll back
+// schema changes, so if a statement fails, the migration is not recorded and
+// must be fixed by hand before running Apply again.
+func Apply(ctx context.Context, db *sql.DB, dir, baseline string) ([]string, error) {
+	migrations, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	if baseline != "" {
+		found := false
+		for _, m := range migrations {
+			if m.Version == baseline {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("baseline %s: no such migration in %s", baseline, dir)
+		}
+	}
+
+	applied, err := appliedVersions(ctx, db, baseline != "")
+	if err != nil {
+		return nil, err
+	}
+
+	var done []string
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if baseline == "" || m.Version > baseline {
+			bytes, err := ioutil.ReadFile(m.File)
+			if err != nil {
+				return done, fmt.Errorf("cannot read migration %s: %s", m.Version, err)
+			}
+			for _, stmt := range statements(string(bytes)) {
+				if _, err := db.ExecContext(ctx, stmt); err != nil {
+					return done, fmt.Errorf("migration %s failed, not recorded: %s", m.Version, err)
+				}
+			}
+			done = append(done, m.Version)
+		}
+		if _, err := db.ExecContext(ctx, "INSERT INTO "+TABLE+" (version) VALUES (?)", m.Version); err != nil {
+			return done, fmt.Errorf("migration %s applied but not recorded: %s", m.Version, err)
+		}
+	}
+	return done, nil
+}
+
+// appliedVersions returns the versions recorded in TABLE, creating it if it
+// does not exist. If the db has the RM schema but not TABLE, it returns an error
+// unless baseline is true.
+func appliedVersions(ctx context.Context, db *sql.DB, baseline bool) (map[string]bool, error) {
+	exists, err := tableExists(ctx, db, TABLE)
+	if err != nil {
+		return nil, err
+	}
+	if !exists && !baseline {
+		hasSchema, err := tableExists(ctx, db, "requests")
+		if err != nil {
+			return nil, err
+		}
+		if hasSchema {
+			return nil, fmt.Errorf("db has the Request Manager schema but no %s table: run migrate with the last migration applied to the db as baseline", TABLE)
+		}
+	}
+	if !exists {
+		q := "CREATE TABLE IF NOT EXISTS " + TABLE + " (" +
+			"version VARCHAR(32) NOT NULL, " +
+			"applied_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6), " +
+			"PRIMARY KEY (version)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return nil, fmt.Errorf("cannot create %s table: %s", TABLE, err)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version FROM "+TABLE)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[string]bool{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+func tableExists(ctx context.Context, db *sql.DB, table string) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?",
+		table).Scan(&n)
+	return n > 0, err
+}
+
+// statements splits the SQL on ";" and returns the non-empty statements.
+func statements(sql string) []string {
+	var stmts []string
+	for _, s := range strings.Split(sql, ";") {
+		if s = strings.TrimSpace(s); s != "" {
+			stmts = append(stmts, s)
+		}
+	}
+	return stmts
+}
@@ -0,0 +1,103 @@
+// Copyright 2020, Square, Inc.
+
+package migrations_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/square/spincycle/v2/request-manager/resources/migrations"
+	testdb "github.com/square/spincycle/v2/request-manager/test/db"
+)
+
+func TestList(t *testing.T) {
+	list, err := migrations.List(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) < 29 {
+		t.Fatalf("got %d migrations, expected at least 29", len(list))
+	}
+	if list[0].Version != "v001" || list[0].File != "v001_base_request_manager_schema.sql" {
+		t.Errorf("got first migration %+v, expected v001", list[0])
+	}
+	for i := 1; i < len(list); i++ {
+		if list[i].Version <= list[i-1].Version {
+			t.Errorf("migration %s after %s, expected ordered by version", list[i].Version, list[i-1].Version)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	dbm, err := testdb.NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// Blank db: all migrations are applied, then none
+	dbName, err := dbm.CreateBlank()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbm.Destroy(dbName)
+	db, err := dbm.Connect(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := migrations.List(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := migrations.Apply(ctx, db, ".", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(list) {
+		t.Errorf("applied %d migrations, expected %d", len(applied), len(list))
+	}
+	applied, err = migrations.Apply(ctx, db, ".", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("applied %v again, expected none", applied)
+	}
+
+	// Db created from the schema file: error without baseline
+	schemaFile, err := filepath.Abs("../request_manager_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbName2, err := dbm.CreateBlank()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbm.Destroy(dbName2)
+	if err := dbm.LoadSQLFile(dbName2, schemaFile); err != nil {
+		t.Fatal(err)
+	}
+	db2, err := dbm.Connect(dbName2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrations.Apply(ctx, db2, ".", ""); err == nil {
+		t.Error("no error applying migrations to db without schema_migrations, expected one")
+	}
+	last := list[len(list)-1].Version
+	applied, err = migrations.Apply(ctx, db2, ".", last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("applied %v with baseline %s, expected none", applied, last)
+	}
+	var n int
+	if err := db2.QueryRow("SELECT COUNT(*) FROM " + migrations.TABLE).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(list) {
+		t.Errorf("%d migrations recorded, expected %d", n, len(list))
+	}
+}
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `shadow_requests` (
+  `shadow_id`     BINARY(20)       NOT NULL, -- job chain request ID on shadow JR
+  `request_id`    BINARY(20)       NOT NULL, -- request that was shadowed
+  `state`         TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  `jr_url`        VARCHAR(2000)        NULL DEFAULT NULL,
+  `created_at`    TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `finished_at`   TIMESTAMP(6)         NULL DEFAULT NULL,
+
+  PRIMARY KEY (`shadow_id`),
+  INDEX (`request_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
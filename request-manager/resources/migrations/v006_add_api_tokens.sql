@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS `api_tokens` (
+  `token_id`      BINARY(20)       NOT NULL,
+  `token_hash`    BINARY(32)       NOT NULL, -- SHA-256 of the secret
+  `name`          VARCHAR(100)     NOT NULL DEFAULT '',
+  `user`          VARCHAR(100)     NOT NULL,
+  `roles`         BLOB             NOT NULL, -- JSON []string
+  `ops`           BLOB             NOT NULL, -- JSON []string, null = all ops
+  `requests`      BLOB             NOT NULL, -- JSON []string, null = all requests
+  `created_at`    TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `expires_at`    TIMESTAMP(6)     NOT NULL,
+  `revoked_at`    TIMESTAMP(6)         NULL DEFAULT NULL,
+
+  PRIMARY KEY (`token_id`),
+  UNIQUE INDEX (`token_hash`),
+  INDEX (`user`, `created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
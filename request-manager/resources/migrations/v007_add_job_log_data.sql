@@ -0,0 +1,2 @@
+ALTER TABLE `job_log`
+  ADD COLUMN `data` LONGBLOB NULL DEFAULT NULL AFTER `stderr`;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS `job_costs` (
+  `request_id`    BINARY(20)       NOT NULL,
+  `job_id`        BINARY(4)        NOT NULL,
+  `try`           SMALLINT         NOT NULL DEFAULT 0,
+  `job_type`      VARBINARY(75)    NOT NULL,
+  `request_type`  VARBINARY(75)    NOT NULL,
+  `user`          VARCHAR(100)     NOT NULL DEFAULT '',
+  `namespace`     VARCHAR(100)     NOT NULL DEFAULT '', -- from cost reporter plugin
+  `state`         TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  `duration`      BIGINT UNSIGNED  NOT NULL DEFAULT 0, -- nanoseconds
+  `finished_at`   TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`request_id`, `job_id`, `try`),
+  INDEX (`finished_at`),
+  INDEX (`namespace`, `finished_at`),
+  INDEX (`user`, `finished_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
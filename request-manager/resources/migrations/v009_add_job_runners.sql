@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `job_runners` (
+  `url`           VARCHAR(512)     NOT NULL, -- base URL, same as requests.jr_url
+  `labels`        BLOB                 NULL DEFAULT NULL, -- JSON
+  `capacity`      INT UNSIGNED     NOT NULL DEFAULT 0,
+  `running`       INT UNSIGNED     NOT NULL DEFAULT 0,
+  `started_at`    TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `heartbeat_at`  TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`url`),
+  INDEX (`heartbeat_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,3 @@
+ALTER TABLE `requests`
+  ADD COLUMN `dedup_key` VARCHAR(255) NULL DEFAULT NULL AFTER `jr_url`,
+  ADD INDEX (`dedup_key`);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `request_outbox` (
+  `request_id`  BINARY(20)    NOT NULL,
+  `jr_url`      VARCHAR(2000)     NULL DEFAULT NULL, -- JR the job chain was last sent to
+  `rm_host`     VARCHAR(64)       NULL DEFAULT NULL, -- RM dispatching the request
+  `tries`       INT UNSIGNED  NOT NULL DEFAULT 0,
+  `created_at`  TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `updated_at`  TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`request_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
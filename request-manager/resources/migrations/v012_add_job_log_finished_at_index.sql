@@ -0,0 +1,2 @@
+ALTER TABLE `job_log`
+  ADD INDEX (`finished_at`);
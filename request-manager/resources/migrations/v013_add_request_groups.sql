@@ -0,0 +1,18 @@
+ALTER TABLE `requests`
+  ADD COLUMN `group_id` BINARY(20) NULL DEFAULT NULL AFTER `dedup_key`,
+  ADD INDEX (`group_id`);
+
+CREATE TABLE IF NOT EXISTS `request_groups` (
+  `group_id`         BINARY(20)       NOT NULL,
+  `policy`           VARCHAR(16)      NOT NULL,
+  `stop_on_failure`  TINYINT(1)       NOT NULL DEFAULT 0,
+  `state`            TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  `user`             VARCHAR(100)         NULL DEFAULT NULL,
+  `request_ids`      BLOB             NOT NULL, -- JSON []string, in run order
+  `started`          INT UNSIGNED     NOT NULL DEFAULT 0, -- requests started
+  `created_at`       TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `finished_at`      TIMESTAMP(6)         NULL DEFAULT NULL,
+
+  PRIMARY KEY (`group_id`),
+  INDEX (`state`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+ALTER TABLE `requests`
+  ADD COLUMN `spec_version` VARCHAR(64) NULL DEFAULT NULL AFTER `group_id`;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `request_state_history` (
+  `id`          BIGINT UNSIGNED  NOT NULL AUTO_INCREMENT,
+  `request_id`  BINARY(20)       NOT NULL,
+  `state`       TINYINT UNSIGNED NOT NULL,
+  `changed_at`  TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`id`),
+  INDEX (`request_id`, `changed_at`) -- state as of a time
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
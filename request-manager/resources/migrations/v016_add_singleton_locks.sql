@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `singleton_locks` (
+  `name`         VARBINARY(512)   NOT NULL, -- job type or type/key arg value
+  `request_id`   BINARY(20)       NOT NULL, -- holder
+  `job_id`       BINARY(4)        NOT NULL, -- holder
+  `acquired_at`  TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
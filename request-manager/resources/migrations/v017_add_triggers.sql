@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS `trigger_dedup` (
+  `trigger_name`  VARBINARY(128)   NOT NULL,
+  `dedup_key`     VARBINARY(512)   NOT NULL,
+  `request_id`    BINARY(20)           NULL DEFAULT NULL, -- NULL until the request is created
+  `received_at`   TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`trigger_name`, `dedup_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `trigger_errors` (
+  `id`            BIGINT UNSIGNED  NOT NULL AUTO_INCREMENT,
+  `trigger_name`  VARBINARY(128)   NOT NULL,
+  `payload`       BLOB             NOT NULL,
+  `error`         TEXT             NOT NULL,
+  `received_at`   TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`id`),
+  INDEX (`trigger_name`, `id`) -- error queue of a trigger
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+ALTER TABLE `api_tokens`
+  ADD COLUMN `read_only` TINYINT(1) NOT NULL DEFAULT 0 AFTER `requests`;
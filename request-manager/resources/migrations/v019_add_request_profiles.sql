@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `request_profiles` (
+  `profile_id`   BINARY(20)       NOT NULL,
+  `request_id`   BINARY(20)       NOT NULL,
+  `type`         VARCHAR(16)      NOT NULL, -- cpu or heap
+  `job_runner`   VARCHAR(255)     NOT NULL, -- host that captured the profile
+  `started_at`   TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `finished_at`  TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `size`         INT UNSIGNED     NOT NULL, -- bytes
+  `data`         MEDIUMBLOB       NOT NULL, -- pprof format
+
+  PRIMARY KEY (`profile_id`),
+  INDEX (`request_id`, `started_at`) -- profiles of a request
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+ALTER TABLE `job_log`
+  ADD COLUMN `log_entries` LONGBLOB NULL DEFAULT NULL AFTER `data`;
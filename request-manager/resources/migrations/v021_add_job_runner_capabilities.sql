@@ -0,0 +1,2 @@
+ALTER TABLE `job_runners`
+  ADD COLUMN `capabilities` BLOB NULL DEFAULT NULL AFTER `labels`;
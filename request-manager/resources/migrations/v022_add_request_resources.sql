@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `request_resources` (
+  `resource`     VARBINARY(255)   NOT NULL, -- job.Resources name, like a host ID
+  `request_id`   BINARY(20)       NOT NULL,
+  `job_id`       BINARY(4)        NOT NULL,
+  `affected_at`  BIGINT UNSIGNED  NOT NULL DEFAULT 0, -- Unix time (nanoseconds) of the first try that affected it
+
+  PRIMARY KEY (`resource`, `request_id`, `job_id`),
+  INDEX (`request_id`) -- delete with job logs
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+ALTER TABLE `job_log`
+  ADD COLUMN `error_class` VARBINARY(16) NULL DEFAULT NULL AFTER `error`;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `backfills` (
+  `name`         VARCHAR(128)     NOT NULL,
+  `state`        VARCHAR(16)      NOT NULL, -- proto.BACKFILL_STATE_* const
+  `last_key`     VARBINARY(1024)  NOT NULL DEFAULT '', -- key of the last row migrated
+  `rows_done`    BIGINT UNSIGNED  NOT NULL DEFAULT 0,
+  `error`        TEXT                 NULL DEFAULT NULL, -- last error, if any
+  `rm_host`      VARCHAR(255)         NULL DEFAULT NULL, -- RM running it
+  `started_at`   TIMESTAMP(6)         NULL DEFAULT NULL,
+  `updated_at`   TIMESTAMP(6)         NULL DEFAULT NULL,
+  `finished_at`  TIMESTAMP(6)         NULL DEFAULT NULL,
+
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `request_exports` (
+  `request_id`   BINARY(20)       NOT NULL,
+  `location`     VARCHAR(2000)    NOT NULL, -- returned by the ObjectStore plugin, like s3://bucket/key
+  `size`         BIGINT UNSIGNED  NOT NULL DEFAULT 0, -- compressed bytes
+  `exported_at`  TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`request_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `request_presets` (
+  `name`         VARCHAR(128)     NOT NULL,
+  `type`         VARBINARY(75)    NOT NULL, -- request type
+  `args`         BLOB             NOT NULL, -- JSON request args
+  `description`  VARCHAR(1000)    NOT NULL DEFAULT '',
+  `created_by`   VARCHAR(100)     NOT NULL DEFAULT '', -- user who created it, can change and delete it
+  `created_at`   TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `updated_at`   TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+ALTER TABLE `job_log`
+  ADD COLUMN `execs` LONGBLOB NULL DEFAULT NULL AFTER `log_entries`;
@@ -0,0 +1,2 @@
+ALTER TABLE `requests`
+  ADD COLUMN `operator` VARCHAR(100) NULL DEFAULT NULL AFTER `user`;
@@ -0,0 +1,2 @@
+ALTER TABLE `requests`
+  ADD COLUMN `progress` TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER `finished_jobs`;
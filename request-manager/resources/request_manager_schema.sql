@@ -7,17 +7,24 @@ CREATE TABLE IF NOT EXISTS `requests` (
   `type`           VARBINARY(75)    NOT NULL,
   `state`          TINYINT UNSIGNED NOT NULL DEFAULT 0,
   `user`           VARCHAR(100)         NULL DEFAULT NULL,
+  `operator`       VARCHAR(100)         NULL DEFAULT NULL, -- admin who made the request as user (run-as)
   `created_at`     TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
   `started_at`     TIMESTAMP(6)         NULL DEFAULT NULL,
   `finished_at`    TIMESTAMP(6)         NULL DEFAULT NULL,
   `total_jobs`     INT UNSIGNED     NOT NULL DEFAULT 0,
   `finished_jobs`  INT UNSIGNED     NOT NULL DEFAULT 0,
+  `progress`       TINYINT UNSIGNED NOT NULL DEFAULT 0, -- proto.Request.Progress
   `jr_url`         VARCHAR(2000)        NULL DEFAULT NULL,
+  `dedup_key`      VARCHAR(255)         NULL DEFAULT NULL,
+  `group_id`       BINARY(20)           NULL DEFAULT NULL, -- request_groups.group_id
+  `spec_version`   VARCHAR(64)          NULL DEFAULT NULL, -- spec.Specs.Version of request type
 
   PRIMARY KEY (`request_id`),
-  INDEX (`created_at`),         -- recently created
-  INDEX (`finished_at`),        -- recently finished
-  INDEX (`state`, `created_at`) -- currently running
+  INDEX (`created_at`),          -- recently created
+  INDEX (`finished_at`),         -- recently finished
+  INDEX (`state`, `created_at`), -- currently running
+  INDEX (`dedup_key`),           -- unfinished request with same dedup key
+  INDEX (`group_id`)             -- requests in group
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE IF NOT EXISTS `request_archives` (
@@ -39,11 +46,16 @@ CREATE TABLE IF NOT EXISTS `job_log` (
   `started_at`    BIGINT UNSIGNED  NOT NULL DEFAULT 0, -- Unix time (nanoseconds)
   `finished_at`   BIGINT UNSIGNED  NOT NULL DEFAULT 0, -- Unix time (nanoseconds)
   `error`         TEXT                 NULL DEFAULT NULL,
+  `error_class`   VARBINARY(16)        NULL DEFAULT NULL, -- proto.ERROR_CLASS_* const returned by the job, if any
   `exit`          BIGINT               NULL DEFAULT NULL,
   `stdout`        LONGBLOB             NULL DEFAULT NULL,
   `stderr`        LONGBLOB             NULL DEFAULT NULL,
+  `data`          LONGBLOB             NULL DEFAULT NULL, -- JSON job data, if job completed
+  `log_entries`   LONGBLOB             NULL DEFAULT NULL, -- JSON job.Logger entries, if any
+  `execs`         LONGBLOB             NULL DEFAULT NULL, -- JSON job.Execs exec audit, if any
 
-  PRIMARY KEY (`request_id`, `job_id`, `try`)
+  PRIMARY KEY (`request_id`, `job_id`, `try`),
+  INDEX (`finished_at`) -- job type stats
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE IF NOT EXISTS `suspended_job_chains` (
@@ -55,3 +67,187 @@ CREATE TABLE IF NOT EXISTS `suspended_job_chains` (
 
   PRIMARY KEY (`request_id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `request_outbox` (
+  `request_id`  BINARY(20)    NOT NULL,
+  `jr_url`      VARCHAR(2000)     NULL DEFAULT NULL, -- JR the job chain was last sent to
+  `rm_host`     VARCHAR(64)       NULL DEFAULT NULL, -- RM dispatching the request
+  `tries`       INT UNSIGNED  NOT NULL DEFAULT 0,
+  `created_at`  TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `updated_at`  TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`request_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `shadow_requests` (
+  `shadow_id`     BINARY(20)       NOT NULL, -- job chain request ID on shadow JR
+  `request_id`    BINARY(20)       NOT NULL, -- request that was shadowed
+  `state`         TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  `jr_url`        VARCHAR(2000)        NULL DEFAULT NULL,
+  `created_at`    TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `finished_at`   TIMESTAMP(6)         NULL DEFAULT NULL,
+
+  PRIMARY KEY (`shadow_id`),
+  INDEX (`request_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `api_tokens` (
+  `token_id`      BINARY(20)       NOT NULL,
+  `token_hash`    BINARY(32)       NOT NULL, -- SHA-256 of the secret
+  `name`          VARCHAR(100)     NOT NULL DEFAULT '',
+  `user`          VARCHAR(100)     NOT NULL,
+  `ops`           BLOB             NOT NULL, -- JSON []string, JSON null or [] = all ops
+  `requests`      BLOB             NOT NULL, -- JSON []string, JSON null or [] = all requests
+  `read_only`     TINYINT(1)       NOT NULL DEFAULT 0,
+  `created_at`    TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `expires_at`    TIMESTAMP(6)     NOT NULL,
+  `revoked_at`    TIMESTAMP(6)         NULL DEFAULT NULL,
+
+  PRIMARY KEY (`token_id`),
+  UNIQUE INDEX (`token_hash`),
+  INDEX (`user`, `created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `job_costs` (
+  `request_id`    BINARY(20)       NOT NULL,
+  `job_id`        BINARY(4)        NOT NULL,
+  `try`           SMALLINT         NOT NULL DEFAULT 0,
+  `job_type`      VARBINARY(75)    NOT NULL,
+  `request_type`  VARBINARY(75)    NOT NULL,
+  `user`          VARCHAR(100)     NOT NULL DEFAULT '',
+  `namespace`     VARCHAR(100)     NOT NULL DEFAULT '', -- from cost reporter plugin
+  `state`         TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  `duration`      BIGINT UNSIGNED  NOT NULL DEFAULT 0, -- nanoseconds
+  `finished_at`   TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`request_id`, `job_id`, `try`),
+  INDEX (`finished_at`),
+  INDEX (`namespace`, `finished_at`),
+  INDEX (`user`, `finished_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `job_runners` (
+  `url`           VARCHAR(512)     NOT NULL, -- base URL, same as requests.jr_url
+  `labels`        BLOB                 NULL DEFAULT NULL, -- JSON
+  `capabilities`  BLOB                 NULL DEFAULT NULL, -- JSON
+  `capacity`      INT UNSIGNED     NOT NULL DEFAULT 0,
+  `running`       INT UNSIGNED     NOT NULL DEFAULT 0,
+  `started_at`    TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `heartbeat_at`  TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`url`),
+  INDEX (`heartbeat_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `request_groups` (
+  `group_id`         BINARY(20)       NOT NULL,
+  `policy`           VARCHAR(16)      NOT NULL,
+  `stop_on_failure`  TINYINT(1)       NOT NULL DEFAULT 0,
+  `state`            TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  `user`             VARCHAR(100)         NULL DEFAULT NULL,
+  `request_ids`      BLOB             NOT NULL, -- JSON []string, in run order
+  `started`          INT UNSIGNED     NOT NULL DEFAULT 0, -- requests started
+  `created_at`       TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `finished_at`      TIMESTAMP(6)         NULL DEFAULT NULL,
+
+  PRIMARY KEY (`group_id`),
+  INDEX (`state`) -- running groups
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `request_state_history` (
+  `id`          BIGINT UNSIGNED  NOT NULL AUTO_INCREMENT,
+  `request_id`  BINARY(20)       NOT NULL,
+  `state`       TINYINT UNSIGNED NOT NULL,
+  `changed_at`  TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`id`),
+  INDEX (`request_id`, `changed_at`) -- state as of a time
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `singleton_locks` (
+  `name`         VARBINARY(512)   NOT NULL, -- job type or type/key arg value
+  `request_id`   BINARY(20)       NOT NULL, -- holder
+  `job_id`       BINARY(4)        NOT NULL, -- holder
+  `acquired_at`  TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `trigger_dedup` (
+  `trigger_name`  VARBINARY(128)   NOT NULL,
+  `dedup_key`     VARBINARY(512)   NOT NULL,
+  `request_id`    BINARY(20)           NULL DEFAULT NULL, -- NULL until the request is created
+  `received_at`   TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`trigger_name`, `dedup_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `trigger_errors` (
+  `id`            BIGINT UNSIGNED  NOT NULL AUTO_INCREMENT,
+  `trigger_name`  VARBINARY(128)   NOT NULL,
+  `payload`       BLOB             NOT NULL,
+  `error`         TEXT             NOT NULL,
+  `received_at`   TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`id`),
+  INDEX (`trigger_name`, `id`) -- error queue of a trigger
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `request_profiles` (
+  `profile_id`   BINARY(20)       NOT NULL,
+  `request_id`   BINARY(20)       NOT NULL,
+  `type`         VARCHAR(16)      NOT NULL, -- cpu or heap
+  `job_runner`   VARCHAR(255)     NOT NULL, -- host that captured the profile
+  `started_at`   TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `finished_at`  TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `size`         INT UNSIGNED     NOT NULL, -- bytes
+  `data`         MEDIUMBLOB       NOT NULL, -- pprof format
+
+  PRIMARY KEY (`profile_id`),
+  INDEX (`request_id`, `started_at`) -- profiles of a request
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `request_resources` (
+  `resource`     VARBINARY(255)   NOT NULL, -- job.Resources name, like a host ID
+  `request_id`   BINARY(20)       NOT NULL,
+  `job_id`       BINARY(4)        NOT NULL,
+  `affected_at`  BIGINT UNSIGNED  NOT NULL DEFAULT 0, -- Unix time (nanoseconds) of the first try that affected it
+
+  PRIMARY KEY (`resource`, `request_id`, `job_id`),
+  INDEX (`request_id`) -- delete with job logs
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `backfills` (
+  `name`         VARCHAR(128)     NOT NULL,
+  `state`        VARCHAR(16)      NOT NULL, -- proto.BACKFILL_STATE_* const
+  `last_key`     VARBINARY(1024)  NOT NULL DEFAULT '', -- key of the last row migrated
+  `rows_done`    BIGINT UNSIGNED  NOT NULL DEFAULT 0,
+  `error`        TEXT                 NULL DEFAULT NULL, -- last error, if any
+  `rm_host`      VARCHAR(255)         NULL DEFAULT NULL, -- RM running it
+  `started_at`   TIMESTAMP(6)         NULL DEFAULT NULL,
+  `updated_at`   TIMESTAMP(6)         NULL DEFAULT NULL,
+  `finished_at`  TIMESTAMP(6)         NULL DEFAULT NULL,
+
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `request_exports` (
+  `request_id`   BINARY(20)       NOT NULL,
+  `location`     VARCHAR(2000)    NOT NULL, -- returned by the ObjectStore plugin, like s3://bucket/key
+  `size`         BIGINT UNSIGNED  NOT NULL DEFAULT 0, -- compressed bytes
+  `exported_at`  TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`request_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `request_presets` (
+  `name`         VARCHAR(128)     NOT NULL,
+  `type`         VARBINARY(75)    NOT NULL, -- request type
+  `args`         BLOB             NOT NULL, -- JSON request args
+  `description`  VARCHAR(1000)    NOT NULL DEFAULT '',
+  `created_by`   VARCHAR(100)     NOT NULL DEFAULT '', -- user who created it, can change and delete it
+  `created_at`   TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  `updated_at`   TIMESTAMP(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -4,28 +4,45 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/signal"
+	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/square/spincycle/v2/config"
+	"github.com/square/spincycle/v2/gate"
 	"github.com/square/spincycle/v2/jobs"
+	"github.com/square/spincycle/v2/metrics"
 	"github.com/square/spincycle/v2/request-manager/api"
 	"github.com/square/spincycle/v2/request-manager/app"
 	"github.com/square/spincycle/v2/request-manager/auth"
+	"github.com/square/spincycle/v2/request-manager/backfill"
+	"github.com/square/spincycle/v2/request-manager/cache"
+	"github.com/square/spincycle/v2/request-manager/cost"
+	"github.com/square/spincycle/v2/request-manager/export"
 	"github.com/square/spincycle/v2/request-manager/graph"
+	"github.com/square/spincycle/v2/request-manager/group"
 	"github.com/square/spincycle/v2/request-manager/id"
 	"github.com/square/spincycle/v2/request-manager/joblog"
+	"github.com/square/spincycle/v2/request-manager/preset"
+	"github.com/square/spincycle/v2/request-manager/profile"
+	"github.com/square/spincycle/v2/request-manager/registry"
 	"github.com/square/spincycle/v2/request-manager/request"
+	"github.com/square/spincycle/v2/request-manager/resources/migrations"
+	"github.com/square/spincycle/v2/request-manager/shadow"
+	"github.com/square/spincycle/v2/request-manager/singleton"
 	"github.com/square/spincycle/v2/request-manager/spec"
+	"github.com/square/spincycle/v2/request-manager/stats"
 	"github.com/square/spincycle/v2/request-manager/status"
+	"github.com/square/spincycle/v2/request-manager/token"
+	"github.com/square/spincycle/v2/request-manager/trigger"
+	"github.com/square/spincycle/v2/shutdown"
 )
 
 var (
@@ -34,17 +51,22 @@ var (
 
 	// How long Suspended Job Chains have to be resumed before they're deleted.
 	SJCTTL = 1 * time.Hour
+
+	// How often job type stats are computed.
+	StatsInterval = 5 * time.Minute
 )
 
 type Server struct {
 	appCtx app.Context
 	api    *api.API
-
-	shutdownChan   chan struct{}
-	resumerStopped chan struct{}
-	apiStopped     chan struct{}
-	stopped        bool
-	stopMux        sync.Mutex
+	jls    *joblog.AsyncStore // high-volume job log mode, else nil
+
+	shutdownChan    chan struct{}
+	resumerStopped  chan struct{}
+	apiStopped      chan struct{}
+	stopped         bool
+	shutdownSignals []os.Signal // OS signals that call Stop if Run(true)
+	stopMux         sync.Mutex
 }
 
 func NewServer(appCtx app.Context) *Server {
@@ -62,9 +84,10 @@ func NewServer(appCtx app.Context) *Server {
 // hook has been provided, it will be called to run the API instead of the default
 // api.Run.
 //
-// If stopOnSignal = true, the server will listen for TERM and INT signals from the
-// OS and call Stop to shut itself down when those signals are received. Else, the
-// caller must call Stop to shut down the server.
+// If stopOnSignal = true, the server will listen for shutdown signals from the OS
+// (config server.shutdown_signals, default TERM and INT) and call Stop to shut
+// itself down when those signals are received. Else, the caller must call Stop
+// to shut down the server, e.g. from a Windows service control handler.
 func (s *Server) Run(stopOnSignal bool) error {
 	if s.api == nil {
 		panic("Server.Run called before Server.Boot")
@@ -77,8 +100,9 @@ func (s *Server) Run(stopOnSignal bool) error {
 	go func() {
 		defer close(s.resumerStopped) // indicate the resumer is done running
 
-		// Every 10 seconds until the server is stopped, resume all Suspended Job
-		// Chains and clean up any that are in a bad state.
+		// Every 10 seconds until the server is stopped, dispatch requests left
+		// in the outbox, advance request groups, resume all Suspended Job Chains,
+		// and clean up any that are in a bad state.
 		ticker := time.NewTicker(ResumerInterval)
 	RESUMER:
 		for {
@@ -86,6 +110,9 @@ func (s *Server) Run(stopOnSignal bool) error {
 			case <-s.shutdownChan:
 				break RESUMER
 			case <-ticker.C:
+				s.recoverDeadJobRunners()
+				s.appCtx.RM.DispatchAll()
+				s.appCtx.Groups.AdvanceAll()
 				s.appCtx.RR.ResumeAll()
 				s.appCtx.RR.Cleanup()
 			}
@@ -93,7 +120,40 @@ func (s *Server) Run(stopOnSignal bool) error {
 		ticker.Stop()
 	}()
 
-	// If stopOnSignal = true, watch for TERM + INT signals from the OS and shut
+	// Compute job type stats now and periodically in a goroutine because it can
+	// take a while and must not delay the resumer. It's not waited for on Stop
+	// because it only reads the db.
+	go func() {
+		ticker := time.NewTicker(StatsInterval)
+		defer ticker.Stop()
+		for {
+			if err := s.appCtx.Stats.Aggregate(); err != nil {
+				log.Errorf("error computing job type stats: %s", err)
+			}
+			select {
+			case <-s.shutdownChan:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	// Receive messages from trigger source plugins in a goroutine until the
+	// server is stopped. Like stats, it's not waited for on Stop.
+	go s.appCtx.Triggers.Run(s.shutdownChan)
+
+	// Run backfills in a goroutine until all are done or the server is stopped.
+	// A backfill saves its progress after every chunk, so it's not waited for
+	// on Stop: it resumes after the last chunk on restart.
+	go s.appCtx.Backfills.Run(s.shutdownChan)
+
+	// Export finished requests in a goroutine until the server is stopped.
+	// Requests not exported on Stop are exported by a sweep after restart.
+	if s.appCtx.Exports != nil {
+		go s.appCtx.Exports.Run(s.shutdownChan)
+	}
+
+	// If stopOnSignal = true, watch for shutdown signals from the OS and shut
 	// down the Request Manager when we receive them.
 	if stopOnSignal {
 		go s.waitForShutdown()
@@ -128,7 +188,7 @@ func (s *Server) Run(stopOnSignal bool) error {
 // future calls to Run will return an error.
 //
 // If stopOnSignal was set when calling Run, Stop will automatically be called by
-// the server on receiving a TERM or INT signal from the OS. Otherwise, you must
+// the server on receiving a shutdown signal from the OS. Otherwise, you must
 // call Stop when you want to shut down the Request Manager.
 func (s *Server) Stop() error {
 	// Only stop once. We lock the whole Stop call, so that, if Stop is called
@@ -159,32 +219,136 @@ func (s *Server) Stop() error {
 	// Wait to return until the resumer has been stopped.
 	<-s.resumerStopped
 
+	// Save JLEs still in the WAL. No more are received because the API stopped.
+	// JLEs that cannot be saved stay in the WAL and are saved on next boot.
+	if s.jls != nil {
+		if jerr := s.jls.Close(); jerr != nil {
+			log.Errorf("error saving job log WAL: %s", jerr)
+		}
+	}
+
 	if err != nil {
 		return fmt.Errorf("error stopping API: %s", err)
 	}
 	return nil
 }
 
-// Boot sets up the server. It must be called before calling Run.
-func (s *Server) Boot() error {
-	// Load config file
+// loadConfig loads the config with the LoadConfig hook, overrides it with env
+// vars, and validates it.
+func (s *Server) loadConfig() (config.RequestManager, error) {
 	cfg, err := s.appCtx.Hooks.LoadConfig(s.appCtx)
 	if err != nil {
-		return fmt.Errorf("error loading config: %s", err)
+		return cfg, fmt.Errorf("error loading config: %s", err)
 	}
 	// Override with env vars, if set
 	cfg.Server.Addr = config.Env("SPINCYCLE_SERVER_ADDR", cfg.Server.Addr)
 	cfg.Server.TLS.CertFile = config.Env("SPINCYCLE_SERVER_TLS_CERT_FILE", cfg.Server.TLS.CertFile)
 	cfg.Server.TLS.KeyFile = config.Env("SPINCYCLE_SERVER_TLS_KEY_FILE", cfg.Server.TLS.KeyFile)
 	cfg.Server.TLS.CAFile = config.Env("SPINCYCLE_SERVER_TLS_CA_FILE", cfg.Server.TLS.CAFile)
+	if sigs := config.Env("SPINCYCLE_SERVER_SHUTDOWN_SIGNALS", ""); sigs != "" {
+		cfg.Server.ShutdownSignals = strings.Split(sigs, ",")
+	}
 	cfg.MySQL.DSN = config.Env("SPINCYCLE_MYSQL_DSN", cfg.MySQL.DSN)
 	cfg.Specs.Dir = config.Env("SPINCYCLE_SPECS_DIR", cfg.Specs.Dir)
 	cfg.JRClient.ServerURL = config.Env("SPINCYCLE_JR_CLIENT_URL", cfg.JRClient.ServerURL)
 	cfg.JRClient.TLS.CertFile = config.Env("SPINCYCLE_JR_CLIENT_TLS_CERT_FILE", cfg.JRClient.TLS.CertFile)
 	cfg.JRClient.TLS.KeyFile = config.Env("SPINCYCLE_JR_CLIENT_TLS_KEY_FILE", cfg.JRClient.TLS.KeyFile)
 	cfg.JRClient.TLS.CAFile = config.Env("SPINCYCLE_JR_CLIENT_TLS_CA_FILE", cfg.JRClient.TLS.CAFile)
+	cfg.JRClient.TLS.ServerName = config.Env("SPINCYCLE_JR_CLIENT_TLS_SERVER_NAME", cfg.JRClient.TLS.ServerName)
+	cfg.Shadow.JRClient.ServerURL = config.Env("SPINCYCLE_SHADOW_JR_CLIENT_URL", cfg.Shadow.JRClient.ServerURL)
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config:\n%s", err)
+	}
+	if _, err := mysql.ParseDSN(cfg.MySQL.DSN); err != nil {
+		return cfg, fmt.Errorf("invalid config:\nmysql.dsn: %s", err)
+	}
+	if _, err := shutdown.Signals(cfg.Server.ShutdownSignals); err != nil {
+		return cfg, fmt.Errorf("invalid config:\nserver.shutdown_signals: %s", err)
+	}
+	return cfg, nil
+}
+
+// Validate loads and validates the config, then checks that MySQL and the Job
+// Runners in the config are reachable. It does not boot or run the server. It's
+// used to check a config before deploying it (--validate-only).
+func (s *Server) Validate() error {
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return err
+	}
 	s.appCtx.Config = cfg
 
+	var errs []string
+	if _, fileResults, err := s.appCtx.Hooks.LoadSpecs(s.appCtx); err != nil {
+		errs = append(errs, fmt.Sprintf("specs.dir: %s", err))
+	} else if fileResults.AnyError {
+		errs = append(errs, "specs.dir: invalid request specs; run spinc-linter for details")
+	}
+	db, err := s.appCtx.Factories.MakeDbConnPool(s.appCtx)
+	if err == nil {
+		err = db.Ping()
+		db.Close()
+	}
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("mysql.dsn: cannot connect: %s", err))
+	}
+	if err := config.CheckAddr(cfg.JRClient.ServerURL, 5*time.Second); err != nil {
+		errs = append(errs, fmt.Sprintf("jr_client.url: cannot connect: %s", err))
+	}
+	if cfg.Shadow.JRClient.ServerURL != "" {
+		if err := config.CheckAddr(cfg.Shadow.JRClient.ServerURL, 5*time.Second); err != nil {
+			errs = append(errs, fmt.Sprintf("shadow.jr_client.url: cannot connect: %s", err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// ValidateSpecs loads the config and checks the request specs in specs.dir like
+// Boot: parsing, static checks, and graph checks. Warnings and errors are logged.
+// It does not boot or run the server. It's used to check specs before deploying
+// them (validate-specs).
+func (s *Server) ValidateSpecs() (spec.Specs, error) {
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return spec.Specs{}, err
+	}
+	s.appCtx.Config = cfg
+	specs, _, err := s.loadSpecs(id.NewGeneratorFactory(4, 100))
+	return specs, err
+}
+
+// Migrate loads the config and applies the schema migrations in the directory
+// that are not applied to the db yet (see migrations.Apply). It returns the
+// versions applied. It does not boot or run the server (migrate).
+func (s *Server) Migrate(dir, baseline string) ([]string, error) {
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	s.appCtx.Config = cfg
+	db, err := s.appCtx.Factories.MakeDbConnPool(s.appCtx)
+	if err != nil {
+		return nil, fmt.Errorf("MakeDbConnPool: %s", err)
+	}
+	defer db.Close()
+	return migrations.Apply(context.Background(), db, dir, baseline)
+}
+
+// Boot sets up the server. It must be called before calling Run.
+func (s *Server) Boot() error {
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return err
+	}
+	s.appCtx.Config = cfg
+	s.shutdownSignals, err = shutdown.Signals(cfg.Server.ShutdownSignals)
+	if err != nil {
+		return fmt.Errorf("error loading config: server.shutdown_signals: %s", err)
+	}
+
 	// Log the config. If a password exists in the MySQL DSN, obfuscate it before logging.
 	logCfg := cfg // Create a copy of cfg since we may mutate it.
 	dsn, err := mysql.ParseDSN(logCfg.MySQL.DSN)
@@ -198,63 +362,21 @@ func (s *Server) Boot() error {
 	cfgstr, _ := json.MarshalIndent(logCfg, "", "  ")
 	log.Printf("Config: %s", cfgstr)
 
-	// Load and check requests specification files (specs)
-	specs, fileResults, err := s.appCtx.Hooks.LoadSpecs(s.appCtx)
+	// Load and check requests specification files (specs), and get sequence
+	// graphs. The generator factory is used to generate IDs for nodes in
+	// sequence graphs and jobs in job chains.
+	gf := id.NewGeneratorFactory(4, 100)
+	specs, seqGraphs, err := s.loadSpecs(gf)
 	if err != nil {
-		return fmt.Errorf("LoadSpecs: %s", err)
+		return err
 	}
-	for file, result := range fileResults.Results {
-		for _, warn := range result.Warnings {
-			log.Errorf("Warning: %s: %s", file, warn)
-		}
-		for _, err := range result.Errors {
-			log.Errorf("Error: %s: %s", file, err)
-		}
-	}
-	if fileResults.AnyError {
-		return fmt.Errorf("Errors occurred during parsing; see log for details")
-	}
-	if len(specs.Sequences) == 0 {
-		log.Errorf("Warning: no specs found in directory")
-	}
-	spec.ProcessSpecs(&specs)
 	s.appCtx.Specs = specs
 
-	checkFactories := []spec.CheckFactory{spec.DefaultCheckFactory{specs}, spec.BaseCheckFactory{specs}}
-	checker, err := spec.NewChecker(checkFactories)
-	staticResults := checker.RunChecks(specs)
-	for seq, result := range staticResults.Results {
-		for _, warn := range result.Warnings {
-			log.Errorf("Warning: %s: %s", seq, warn)
-		}
-		for _, err := range result.Errors {
-			log.Errorf("Error: %s: %s", seq, err)
-		}
-	}
-	if staticResults.AnyError {
-		return fmt.Errorf("Static check(s) on request specification files failed; see log or run spinc-linter for details")
-	}
-
-	// Generator factory used to generate IDs for nodes in sequence graphs and jobs in job chains
-	gf := id.NewGeneratorFactory(4, 100)
-
-	// Do graph checks and get sequence graphs
-	tg := graph.NewGrapher(specs, gf)
-	seqGraphs, graphResults := tg.CheckSequences()
-	for seq, result := range graphResults.Results {
-		for _, warn := range result.Warnings {
-			log.Errorf("Warning: %s: %s", seq, warn)
-		}
-		for _, err := range result.Errors {
-			log.Errorf("Error: %s: %s", seq, err)
-		}
-	}
-	if graphResults.AnyError {
-		return fmt.Errorf("Graph check(s) on request specification files failed; see log or run spinc-linter for details")
-	}
-
-	// Resolver Factory: creates Resolvers, which resolve sequence graphs into request graphs
-	resolverFactory := graph.NewResolverFactory(jobs.Factory, specs.Sequences, seqGraphs, gf)
+	// Resolver Factory: creates Resolvers, which resolve sequence graphs into request graphs.
+	// Jobs are made by the jobs package factory, except built-in gate jobs, which the
+	// RM only creates (nil gates): they wait for approval on the Job Runner.
+	jf := gate.NewFactory(jobs.Factory, nil)
+	resolverFactory := graph.NewResolverFactory(jf, specs.Sequences, seqGraphs, gf)
 
 	// Job Runner Client: how the Request Manager talks to Job Runners
 	jrClient, err := s.appCtx.Factories.MakeJobRunnerClient(s.appCtx)
@@ -268,41 +390,223 @@ func (s *Server) Boot() error {
 		return fmt.Errorf("MakeDbConnPool: %s", err)
 	}
 
+	// Job log store: save job log entries (JLE) from Job Runners. With a WAL,
+	// JLEs are buffered in it and saved in batches (high-volume mode).
+	jlsConfig := joblog.StoreConfig{
+		DBConnector:   dbConnector,
+		MaxTries:      cfg.JobLog.MaxTries,
+		MaxPerRequest: cfg.JobLog.MaxPerRequest,
+	}
+	if cfg.JobLog.WAL.Dir != "" {
+		flushInterval, _ := time.ParseDuration(cfg.JobLog.WAL.FlushInterval) // already validated
+		s.jls, err = joblog.NewAsyncStore(joblog.AsyncStoreConfig{
+			StoreConfig:   jlsConfig,
+			WALDir:        cfg.JobLog.WAL.Dir,
+			BatchSize:     cfg.JobLog.WAL.BatchSize,
+			FlushInterval: flushInterval,
+			MaxPending:    cfg.JobLog.WAL.MaxPending,
+		})
+		if err != nil {
+			return err
+		}
+		s.appCtx.JLS = s.jls
+	} else {
+		s.appCtx.JLS = joblog.NewStore(jlsConfig)
+	}
+
+	// Shadow Manager: run copies of requests on the shadow Job Runner pool
+	shadowConfig := shadow.ManagerConfig{
+		Requests:    cfg.Shadow.Requests,
+		JRURL:       cfg.Shadow.JRClient.ServerURL,
+		DBConnector: dbConnector,
+		JLStore:     s.appCtx.JLS,
+	}
+	if len(cfg.Shadow.Requests) > 0 {
+		if cfg.Shadow.JRClient.ServerURL == "" {
+			return fmt.Errorf("error loading config: shadow.jr_client.url required when shadow.requests set")
+		}
+		shadowConfig.JRClient, err = app.NewJobRunnerClient(cfg.Shadow.JRClient)
+		if err != nil {
+			return fmt.Errorf("error making shadow Job Runner client: %s", err)
+		}
+	}
+	s.appCtx.Shadow = shadow.NewManager(shadowConfig)
+
+	// Job Runner registry: Job Runners that register and send heartbeats, used
+	// to choose a live JR for job chains and to recover requests from dead JRs
+	if cfg.Registry.Timeout == "" {
+		cfg.Registry.Timeout = config.DEFAULT_REGISTRY_TIMEOUT
+	}
+	registryTimeout, err := time.ParseDuration(cfg.Registry.Timeout)
+	if err != nil || registryTimeout <= 0 {
+		return fmt.Errorf("error loading config: registry.timeout: invalid duration %q", cfg.Registry.Timeout)
+	}
+	s.appCtx.Registry = registry.NewManager(registry.ManagerConfig{
+		DBConnector: dbConnector,
+		Timeout:     registryTimeout,
+		DefaultURL:  s.appCtx.Config.JRClient.ServerURL,
+	})
+
+	// Metrics plugin is optional: report nothing if not set
+	if s.appCtx.Plugins.Metrics == nil {
+		s.appCtx.Plugins.Metrics = metrics.Nop{}
+	}
+
+	// Stats Manager: job type failure analytics, computed periodically in Run.
+	// The request manager weighs jobs by their mean duration.
+	s.appCtx.Stats = stats.NewManager(stats.ManagerConfig{
+		DBConnector: dbConnector,
+	})
+
 	// Request Manager: core logic and coordination
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("error getting hostname: %s", err)
+	}
 	managerConfig := request.ManagerConfig{
 		ResolverFactory: resolverFactory,
 		Sequences:       specs.Sequences,
 		DBConnector:     dbConnector,
 		JRClient:        jrClient,
 		DefaultJRURL:    s.appCtx.Config.JRClient.ServerURL,
+		Registry:        s.appCtx.Registry,
 		ShutdownChan:    s.shutdownChan,
+		Shadow:          s.appCtx.Shadow,
+		JLStore:         s.appCtx.JLS,
+		Compression:     cfg.MySQL.Compression,
+		JobFactory:      jf,
+		IdGenFactory:    gf,
+		AddJobTypes:     cfg.AddJob.Types,
+		RMHost:          hostname,
+		Metrics:         s.appCtx.Plugins.Metrics,
+		PreCreate:       s.appCtx.Hooks.PreCreateRequest,
+		PostCreate:      s.appCtx.Hooks.PostCreateRequest,
+		Stats:           s.appCtx.Stats,
 	}
 	s.appCtx.RM = request.NewManager(managerConfig)
 
+	// Group Manager: request groups, advanced periodically in Run
+	s.appCtx.Groups = group.NewManager(group.ManagerConfig{
+		DBConnector:    dbConnector,
+		RequestManager: s.appCtx.RM,
+	})
+
+	// Singleton Manager: locks for singleton jobs, acquired by Job Runners
+	s.appCtx.Singletons = singleton.NewManager(singleton.ManagerConfig{
+		DBConnector: dbConnector,
+	})
+
+	// Profile Manager: CPU and heap profiles captured by Job Runners
+	s.appCtx.Profiles = profile.NewManager(profile.ManagerConfig{
+		DBConnector: dbConnector,
+	})
+
 	// Request Resumer: suspend + resume requests
-	hostname, err := os.Hostname()
-	if err != nil {
-		return fmt.Errorf("error getting hostname: %s", err)
-	}
 	resumerConfig := request.ResumerConfig{
 		RequestManager:       s.appCtx.RM,
 		DBConnector:          dbConnector,
 		JRClient:             jrClient,
 		DefaultJRURL:         s.appCtx.Config.JRClient.ServerURL,
+		Registry:             s.appCtx.Registry,
 		RMHost:               hostname,
 		ShutdownChan:         s.shutdownChan,
 		SuspendedJobChainTTL: SJCTTL,
+		Compression:          cfg.MySQL.Compression,
 	}
 	s.appCtx.RR = request.NewResumer(resumerConfig)
 
 	// Status: figure out request status using db and Job Runners (real-time)
 	s.appCtx.Status = status.NewManager(dbConnector, jrClient)
 
-	// Job log store: save job log entries (JLE) from Job Runners
-	s.appCtx.JLS = joblog.NewStore(dbConnector)
+	// Token Manager: per-user API tokens, an alternative to the auth plugin
+	if cfg.Auth.TokenMaxTTL == "" {
+		cfg.Auth.TokenMaxTTL = config.DEFAULT_TOKEN_MAX_TTL
+	}
+	tokenMaxTTL, err := time.ParseDuration(cfg.Auth.TokenMaxTTL)
+	if err != nil || tokenMaxTTL <= 0 {
+		return fmt.Errorf("error loading config: auth.token_max_ttl: invalid duration %q", cfg.Auth.TokenMaxTTL)
+	}
+	s.appCtx.Tokens = token.NewManager(token.ManagerConfig{
+		DBConnector: dbConnector,
+		MaxTTL:      tokenMaxTTL,
+		Roles: func(user string) ([]string, error) {
+			return s.appCtx.Auth.Roles(user) // set below, after specs are loaded
+		},
+	})
+
+	// Preset Manager: named requests (type and args) started by name
+	s.appCtx.Presets = preset.NewManager(preset.ManagerConfig{
+		DBConnector: dbConnector,
+	})
+
+	// Cost Manager: job cost accounting for chargeback, reported to the plugin
+	s.appCtx.Costs = cost.NewManager(cost.ManagerConfig{
+		DBConnector: dbConnector,
+		Reporter:    s.appCtx.Plugins.CostReporter,
+	})
+
+	// Trigger Manager: start requests on messages from the webhook receiver
+	// and trigger source plugins, received in Run. New requests are not started
+	// while the API is not accepting them (maintenance mode).
+	s.appCtx.Triggers, err = trigger.NewManager(trigger.ManagerConfig{
+		Triggers:       cfg.Triggers,
+		Sources:        s.appCtx.Plugins.TriggerSources,
+		RequestManager: s.appCtx.RM,
+		DBConnector:    dbConnector,
+		Accepting:      func() error { return s.api.AcceptingRequests() },
+	})
+	if err != nil {
+		return fmt.Errorf("error loading config: triggers: %s", err)
+	}
+
+	// Export Manager (optional): export finished requests to the object store
+	// plugin, in Run. It uses the request manager before it's cached below.
+	if s.appCtx.Plugins.ObjectStore != nil {
+		s.appCtx.Exports = export.NewManager(export.ManagerConfig{
+			Store:          s.appCtx.Plugins.ObjectStore,
+			RequestManager: s.appCtx.RM,
+			JLStore:        s.appCtx.JLS,
+			DBConnector:    dbConnector,
+		})
+	}
+
+	// Backfill Manager: online data migrations after schema migrations, run
+	// in Run. Every instance reports progress, even if it doesn't run them.
+	backfillThrottle, err := time.ParseDuration(cfg.Backfill.Throttle)
+	if err != nil || backfillThrottle < 0 {
+		return fmt.Errorf("error loading config: backfill.throttle: invalid duration %q", cfg.Backfill.Throttle)
+	}
+	if cfg.Backfill.ChunkSize == 0 {
+		return fmt.Errorf("error loading config: backfill.chunk_size: must be greater than zero")
+	}
+	s.appCtx.Backfills, err = backfill.NewManager(backfill.ManagerConfig{
+		Backfills:   backfill.Backfills,
+		DBConnector: dbConnector,
+		RMHost:      hostname,
+		ChunkSize:   cfg.Backfill.ChunkSize,
+		Throttle:    backfillThrottle,
+		Disabled:    cfg.Backfill.Disabled,
+	})
+	if err != nil {
+		return fmt.Errorf("error loading backfills: %s", err)
+	}
 
 	// Auth Manager: request authorization (pre- (built-in) and post- using plugin)
-	s.appCtx.Auth = auth.NewManager(s.appCtx.Plugins.Auth, mapACL(specs), cfg.Auth.AdminRoles, cfg.Auth.Strict)
+	s.appCtx.Auth = auth.NewManager(s.appCtx.Plugins.Auth, mapACL(specs), cfg.Auth.AdminRoles, cfg.Auth.Strict, cfg.Auth.ReadOnlyRoles)
+
+	// Cache (optional): short-TTL cache of request status and find queries for
+	// the API, to absorb polling. Only the API uses the cached managers, so the
+	// managers above never read cached requests.
+	if cfg.Cache.TTL != "" {
+		ttl, err := time.ParseDuration(cfg.Cache.TTL)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("error loading config: cache.ttl: invalid duration %q", cfg.Cache.TTL)
+		}
+		c := cache.NewCache(ttl, cfg.Cache.MaxEntries)
+		s.appCtx.RM = cache.NewRequestManager(s.appCtx.RM, c)
+		s.appCtx.RR = cache.NewResumer(s.appCtx.RR, c)
+		s.appCtx.Status = cache.NewStatusManager(s.appCtx.Status, c)
+	}
 
 	// API: endpoints and controllers, also handles auth via auth plugin
 	s.api = api.NewAPI(s.appCtx)
@@ -317,12 +621,10 @@ func (s *Server) API() *api.API {
 
 // --------------------------------------------------------------------------
 
-// Catch TERM and INT signals to gracefully shut down the Request Manager
+// Catch shutdown signals (default: TERM and INT) to gracefully shut down the Request Manager
 func (s *Server) waitForShutdown() {
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	<-sigChan
+	sig := shutdown.Wait(s.shutdownSignals)
+	log.Infof("received %s signal, shutting down", sig)
 
 	err := s.Stop()
 	if err != nil {
@@ -330,6 +632,83 @@ func (s *Server) waitForShutdown() {
 	}
 }
 
+// recoverDeadJobRunners suspends the running requests of dead Job Runners so the
+// resumer resumes them on live Job Runners. A dead JR is deregistered only after
+// all its requests are suspended, else it's tried again next time.
+func (s *Server) recoverDeadJobRunners() {
+	dead, err := s.appCtx.Registry.Dead()
+	if err != nil {
+		log.Errorf("error getting dead Job Runners: %s", err)
+		return
+	}
+	for _, url := range dead {
+		log.Warnf("Job Runner %s is dead (no heartbeat), recovering its requests", url)
+		if err := s.appCtx.RR.Recover(url); err != nil {
+			log.Errorf("error recovering requests from Job Runner %s: %s", url, err)
+			continue
+		}
+		if err := s.appCtx.Registry.Deregister(url); err != nil {
+			log.Errorf("error deregistering Job Runner %s: %s", url, err)
+		}
+	}
+}
+
+// loadSpecs loads the request specs with the LoadSpecs hook and checks them:
+// parsing, static checks, and graph checks. Warnings and errors are logged. It
+// returns the specs and their sequence graphs, or an error if any check failed.
+func (s *Server) loadSpecs(gf id.GeneratorFactory) (spec.Specs, map[string]*graph.Graph, error) {
+	specs, fileResults, err := s.appCtx.Hooks.LoadSpecs(s.appCtx)
+	if err != nil {
+		return specs, nil, fmt.Errorf("LoadSpecs: %s", err)
+	}
+	for file, result := range fileResults.Results {
+		for _, warn := range result.Warnings {
+			log.Errorf("Warning: %s: %s", file, warn)
+		}
+		for _, err := range result.Errors {
+			log.Errorf("Error: %s: %s", file, err)
+		}
+	}
+	if fileResults.AnyError {
+		return specs, nil, fmt.Errorf("Errors occurred during parsing; see log for details")
+	}
+	if len(specs.Sequences) == 0 {
+		log.Errorf("Warning: no specs found in directory")
+	}
+	spec.ProcessSpecs(&specs)
+
+	checkFactories := []spec.CheckFactory{spec.DefaultCheckFactory{specs}, spec.BaseCheckFactory{specs}}
+	checker, err := spec.NewChecker(checkFactories)
+	staticResults := checker.RunChecks(specs)
+	for seq, result := range staticResults.Results {
+		for _, warn := range result.Warnings {
+			log.Errorf("Warning: %s: %s", seq, warn)
+		}
+		for _, err := range result.Errors {
+			log.Errorf("Error: %s: %s", seq, err)
+		}
+	}
+	if staticResults.AnyError {
+		return specs, nil, fmt.Errorf("Static check(s) on request specification files failed; see log or run spinc-linter for details")
+	}
+
+	// Do graph checks and get sequence graphs
+	tg := graph.NewGrapher(specs, gf)
+	seqGraphs, graphResults := tg.CheckSequences()
+	for seq, result := range graphResults.Results {
+		for _, warn := range result.Warnings {
+			log.Errorf("Warning: %s: %s", seq, warn)
+		}
+		for _, err := range result.Errors {
+			log.Errorf("Error: %s: %s", seq, err)
+		}
+	}
+	if graphResults.AnyError {
+		return specs, nil, fmt.Errorf("Graph check(s) on request specification files failed; see log or run spinc-linter for details")
+	}
+	return specs, seqGraphs, nil
+}
+
 // MapACL maps spec file ACL to auth.ACL structure.
 func mapACL(specs spec.Specs) map[string][]auth.ACL {
 	acl := map[string][]auth.ACL{}
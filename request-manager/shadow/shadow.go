@@ -0,0 +1,253 @@
+// Copyright 2020, Square, Inc.
+
+// Package shadow provides shadow runs: when a request of a shadowed type is
+// started, a copy of its job chain is also sent to a shadow Job Runner pool,
+// for example one running a new job binary, and the results of the two runs
+// are compared. The copy has its own request ID (the shadow ID) so the shadow
+// JR's job logs and final state do not affect the real request.
+package shadow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/job-runner"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/joblog"
+)
+
+// A Manager starts and tracks shadow runs.
+type Manager interface {
+	// Start sends a copy of the request's job chain to the shadow JR pool if
+	// the request type is shadowed. The request must have its job chain. Start
+	// does not block, and errors are logged but do not affect the request.
+	Start(req proto.Request)
+
+	// IsShadow returns true if the ID is the shadow ID of a shadow run.
+	IsShadow(id string) (bool, error)
+
+	// Finish saves the final state of a shadow run. The shadow JR calls this
+	// (via the finish and suspend request endpoints) when it's done running
+	// the job chain copy.
+	Finish(shadowId string, finishParams proto.FinishRequest) error
+
+	// Get returns the latest shadow run of the request compared to the request.
+	// If the request has not been shadowed, a serr.ShadowNotFound is returned.
+	Get(requestId string) (proto.ShadowRun, error)
+}
+
+type ManagerConfig struct {
+	Requests    []string     // request types (names) to shadow
+	JRClient    jr.Client    // client for shadow JR pool
+	JRURL       string       // shadow JR pool base URL
+	DBConnector *sql.DB      // stores shadow_requests
+	JLStore     joblog.Store // job logs of both runs
+}
+
+type manager struct {
+	requests map[string]bool
+	jrc      jr.Client
+	jrURL    string
+	dbc      *sql.DB
+	jls      joblog.Store
+}
+
+// NewManager returns a Manager that shadows the configured requests. If no
+// requests are configured, the Manager does nothing and never queries the
+// database.
+func NewManager(cfg ManagerConfig) Manager {
+	requests := map[string]bool{}
+	for _, name := range cfg.Requests {
+		requests[name] = true
+	}
+	return &manager{
+		requests: requests,
+		jrc:      cfg.JRClient,
+		jrURL:    cfg.JRURL,
+		dbc:      cfg.DBConnector,
+		jls:      cfg.JLStore,
+	}
+}
+
+func (m *manager) Start(req proto.Request) {
+	if !m.requests[req.Type] || req.JobChain == nil {
+		return
+	}
+	go m.start(req)
+}
+
+func (m *manager) start(req proto.Request) {
+	shadowId := xid.New().String()
+	logger := log.WithFields(log.Fields{"request_id": req.Id, "shadow_id": shadowId})
+
+	// Save the shadow run before sending the chain so the RM recognizes the
+	// shadow ID when the shadow JR sends job logs and the final state.
+	ctx := context.TODO()
+	q := "INSERT INTO shadow_requests (shadow_id, request_id, state) VALUES (?, ?, ?)"
+	if _, err := m.dbc.ExecContext(ctx, q, shadowId, req.Id, proto.STATE_PENDING); err != nil {
+		logger.Errorf("shadow run not started: %s", serr.NewDbError(err, "INSERT shadow_requests"))
+		return
+	}
+
+	// Copy the job chain. Only the request ID changes: job IDs are the same
+	// so jobs in both runs can be compared.
+	jc := *req.JobChain
+	jc.RequestId = shadowId
+	jc.Jobs = make(map[string]proto.Job, len(req.JobChain.Jobs))
+	for id, job := range req.JobChain.Jobs {
+		jc.Jobs[id] = job
+	}
+
+	state := proto.STATE_RUNNING
+	var jrURL sql.NullString
+	chainURL, err := m.jrc.NewJobChain(m.jrURL, jc)
+	if err != nil {
+		logger.Errorf("error sending job chain to shadow Job Runner %s: %s", m.jrURL, err)
+		state = proto.STATE_FAIL
+	} else {
+		jrURL.Valid = true
+		jrURL.String = strings.TrimSuffix(chainURL.String(), chainURL.RequestURI())
+		logger.Infof("shadow run started on %s", jrURL.String)
+	}
+
+	q = "UPDATE shadow_requests SET state = ?, jr_url = ? WHERE shadow_id = ?"
+	if _, err := m.dbc.ExecContext(ctx, q, state, jrURL, shadowId); err != nil {
+		logger.Errorf("error updating shadow run: %s", serr.NewDbError(err, "UPDATE shadow_requests"))
+	}
+}
+
+func (m *manager) IsShadow(id string) (bool, error) {
+	if len(m.requests) == 0 {
+		return false, nil
+	}
+	var n int
+	q := "SELECT COUNT(*) FROM shadow_requests WHERE shadow_id = ?"
+	if err := m.dbc.QueryRowContext(context.TODO(), q, id).Scan(&n); err != nil {
+		return false, serr.NewDbError(err, "SELECT shadow_requests")
+	}
+	return n > 0, nil
+}
+
+func (m *manager) Finish(shadowId string, finishParams proto.FinishRequest) error {
+	log.WithFields(log.Fields{"shadow_id": shadowId}).Infof("finish shadow run: %+v", finishParams)
+	finishedAt := finishParams.FinishedAt
+	if finishedAt.IsZero() {
+		finishedAt = time.Now().UTC()
+	}
+	q := "UPDATE shadow_requests SET state = ?, finished_at = ? WHERE shadow_id = ?"
+	if _, err := m.dbc.ExecContext(context.TODO(), q, finishParams.State, finishedAt, shadowId); err != nil {
+		return serr.NewDbError(err, "UPDATE shadow_requests")
+	}
+	return nil
+}
+
+func (m *manager) Get(requestId string) (proto.ShadowRun, error) {
+	run := proto.ShadowRun{
+		RequestId: requestId,
+	}
+
+	var jrURL sql.NullString
+	finishedAt := mysql.NullTime{}
+	q := "SELECT s.shadow_id, s.state, s.jr_url, s.created_at, s.finished_at, r.state" +
+		" FROM shadow_requests s JOIN requests r USING (request_id)" +
+		" WHERE s.request_id = ? ORDER BY s.created_at DESC LIMIT 1"
+	err := m.dbc.QueryRowContext(context.TODO(), q, requestId).Scan(
+		&run.ShadowId,
+		&run.ShadowState,
+		&jrURL,
+		&run.CreatedAt,
+		&finishedAt,
+		&run.State,
+	)
+	switch {
+	case err == sql.ErrNoRows:
+		return run, serr.ShadowNotFound{RequestId: requestId}
+	case err != nil:
+		return run, serr.NewDbError(err, "SELECT shadow_requests")
+	}
+	run.JobRunnerURL = jrURL.String
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+
+	jls, err := m.jls.GetFull(requestId)
+	if err != nil {
+		return run, err
+	}
+	shadowJLs, err := m.jls.GetFull(run.ShadowId)
+	if err != nil {
+		return run, err
+	}
+	return compare(run, jls, shadowJLs), nil
+}
+
+// compare sets the jobs and match of the run from the job logs of the request
+// and its shadow run.
+func compare(run proto.ShadowRun, jls, shadowJLs []proto.JobLog) proto.ShadowRun {
+	jobs := map[string]*proto.ShadowJob{}
+	job := func(jl proto.JobLog) *proto.ShadowJob {
+		j, ok := jobs[jl.JobId]
+		if !ok {
+			j = &proto.ShadowJob{
+				JobId:       jl.JobId,
+				Name:        jl.Name,
+				State:       proto.STATE_PENDING,
+				ShadowState: proto.STATE_PENDING,
+			}
+			jobs[jl.JobId] = j
+		}
+		return j
+	}
+	// Job log entries are not ordered, so the final state is the state of the
+	// entry with the highest try
+	for _, jl := range jls {
+		j := job(jl)
+		if jl.Try > j.Tries {
+			j.State = jl.State
+			j.Tries = jl.Try
+		}
+	}
+	for _, jl := range shadowJLs {
+		j := job(jl)
+		if jl.Try > j.ShadowTries {
+			j.ShadowState = jl.State
+			j.ShadowTries = jl.Try
+		}
+	}
+
+	run.Jobs = make([]proto.ShadowJob, 0, len(jobs))
+	for _, j := range jobs {
+		run.Jobs = append(run.Jobs, *j)
+	}
+	sort.Slice(run.Jobs, func(i, j int) bool { return run.Jobs[i].JobId < run.Jobs[j].JobId })
+
+	run.Mismatches = nil
+	if !finished(run.State) || !finished(run.ShadowState) {
+		run.Mismatches = append(run.Mismatches, "request or shadow run not finished")
+	}
+	if run.State != run.ShadowState {
+		run.Mismatches = append(run.Mismatches, fmt.Sprintf("request state %s, shadow state %s",
+			proto.StateName[run.State], proto.StateName[run.ShadowState]))
+	}
+	for _, j := range run.Jobs {
+		if j.State != j.ShadowState {
+			run.Mismatches = append(run.Mismatches, fmt.Sprintf("job %s (%s) state %s, shadow state %s",
+				j.Name, j.JobId, proto.StateName[j.State], proto.StateName[j.ShadowState]))
+		}
+	}
+	run.Match = len(run.Mismatches) == 0
+	return run
+}
+
+func finished(state byte) bool {
+	return state != proto.STATE_PENDING && state != proto.STATE_RUNNING
+}
@@ -0,0 +1,80 @@
+// Copyright 2020, Square, Inc.
+
+package shadow
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/proto"
+)
+
+func TestCompareMatch(t *testing.T) {
+	run := proto.ShadowRun{
+		RequestId:   "req1",
+		ShadowId:    "shadow1",
+		State:       proto.STATE_COMPLETE,
+		ShadowState: proto.STATE_COMPLETE,
+	}
+	// job2 failed then completed on retry in the request; the job log order
+	// does not matter
+	jls := []proto.JobLog{
+		{JobId: "job2", Name: "b", Try: 2, State: proto.STATE_COMPLETE},
+		{JobId: "job1", Name: "a", Try: 1, State: proto.STATE_COMPLETE},
+		{JobId: "job2", Name: "b", Try: 1, State: proto.STATE_FAIL},
+	}
+	shadowJLs := []proto.JobLog{
+		{JobId: "job1", Name: "a", Try: 1, State: proto.STATE_COMPLETE},
+		{JobId: "job2", Name: "b", Try: 1, State: proto.STATE_COMPLETE},
+	}
+	got := compare(run, jls, shadowJLs)
+
+	expect := run
+	expect.Jobs = []proto.ShadowJob{
+		{JobId: "job1", Name: "a", State: proto.STATE_COMPLETE, ShadowState: proto.STATE_COMPLETE, Tries: 1, ShadowTries: 1},
+		{JobId: "job2", Name: "b", State: proto.STATE_COMPLETE, ShadowState: proto.STATE_COMPLETE, Tries: 2, ShadowTries: 1},
+	}
+	expect.Match = true
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCompareMismatch(t *testing.T) {
+	run := proto.ShadowRun{
+		RequestId:   "req1",
+		ShadowId:    "shadow1",
+		State:       proto.STATE_FAIL,
+		ShadowState: proto.STATE_FAIL,
+	}
+	// job2 did not run in the shadow run
+	jls := []proto.JobLog{
+		{JobId: "job1", Name: "a", Try: 1, State: proto.STATE_COMPLETE},
+		{JobId: "job2", Name: "b", Try: 1, State: proto.STATE_FAIL},
+	}
+	shadowJLs := []proto.JobLog{
+		{JobId: "job1", Name: "a", Try: 1, State: proto.STATE_FAIL},
+	}
+	got := compare(run, jls, shadowJLs)
+
+	expect := run
+	expect.Jobs = []proto.ShadowJob{
+		{JobId: "job1", Name: "a", State: proto.STATE_COMPLETE, ShadowState: proto.STATE_FAIL, Tries: 1, ShadowTries: 1},
+		{JobId: "job2", Name: "b", State: proto.STATE_FAIL, ShadowState: proto.STATE_PENDING, Tries: 1, ShadowTries: 0},
+	}
+	expect.Mismatches = []string{
+		"job a (job1) state COMPLETE, shadow state FAIL",
+		"job b (job2) state FAIL, shadow state PENDING",
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Shadow run still running: never a match even if states so far are equal
+	run.ShadowState = proto.STATE_RUNNING
+	got = compare(run, jls, jls)
+	if got.Match {
+		t.Errorf("match with shadow run running, expected no match")
+	}
+}
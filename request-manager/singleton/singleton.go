@@ -0,0 +1,135 @@
+// Copyright 2020, Square, Inc.
+
+// Package singleton provides locks for singleton jobs (spec singleton: true):
+// only one job of the type, or type and singletonKey arg value, runs at a time
+// across all Job Runners. Job Runners acquire the lock through the Request
+// Manager API before running a singleton job and release it after, so the
+// locks are held in the Request Manager database.
+//
+// A lock held by a request that is not running is stale: the Job Runner stopped
+// or crashed without releasing it. The next job to acquire a stale lock gets it.
+// A request recovered from a dead Job Runner re-acquires the locks it held
+// because the holder (request and job) is the same.
+package singleton
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// A Manager acquires and releases singleton locks.
+type Manager interface {
+	// Acquire acquires the lock for the job (RequestId and JobId) if it's not
+	// held, stale, or already held by the job. It returns the lock holder:
+	// the job if acquired, else the request and job holding it.
+	Acquire(l proto.SingletonLock) (proto.SingletonLock, error)
+
+	// Release releases the lock if held by the job. Releasing a lock not held
+	// by the job is not an error.
+	Release(l proto.SingletonLock) error
+
+	// List returns all held locks, including stale locks, ordered by name.
+	List() ([]proto.SingletonLock, error)
+}
+
+type ManagerConfig struct {
+	DBConnector *sql.DB
+}
+
+type manager struct {
+	dbc *sql.DB
+}
+
+func NewManager(cfg ManagerConfig) Manager {
+	return &manager{
+		dbc: cfg.DBConnector,
+	}
+}
+
+func (m *manager) Acquire(l proto.SingletonLock) (proto.SingletonLock, error) {
+	if l.Name == "" || l.RequestId == "" || l.JobId == "" {
+		return l, serr.ValidationError{Message: "name, requestId, and jobId are required"}
+	}
+	l.AcquiredAt = time.Now().UTC()
+
+	ctx := context.TODO()
+	tx, err := m.dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return l, serr.NewDbError(err, "BEGIN")
+	}
+	defer tx.Rollback()
+
+	// Not held: acquire
+	q := "INSERT IGNORE INTO singleton_locks (name, request_id, job_id, acquired_at) VALUES (?, ?, ?, ?)"
+	res, err := tx.ExecContext(ctx, q, l.Name, l.RequestId, l.JobId, l.AcquiredAt)
+	if err != nil {
+		return l, serr.NewDbError(err, "INSERT singleton_locks")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return l, err
+	} else if n == 1 {
+		if err := tx.Commit(); err != nil {
+			return l, serr.NewDbError(err, "COMMIT")
+		}
+		return l, nil
+	}
+
+	// Held: by the job, by a running request, or stale. State is NULL if
+	// the holder request doesn't exist.
+	holder := proto.SingletonLock{Name: l.Name}
+	var state sql.NullInt64
+	q = "SELECT l.request_id, l.job_id, l.acquired_at, r.state FROM singleton_locks l" +
+		" LEFT JOIN requests r ON r.request_id = l.request_id WHERE l.name = ? FOR UPDATE"
+	err = tx.QueryRowContext(ctx, q, l.Name).Scan(&holder.RequestId, &holder.JobId, &holder.AcquiredAt, &state)
+	if err != nil {
+		return l, serr.NewDbError(err, "SELECT singleton_locks")
+	}
+	if holder.RequestId == l.RequestId && holder.JobId == l.JobId {
+		return holder, nil
+	}
+	if state.Valid && byte(state.Int64) == proto.STATE_RUNNING {
+		return holder, nil
+	}
+
+	q = "UPDATE singleton_locks SET request_id = ?, job_id = ?, acquired_at = ? WHERE name = ?"
+	if _, err := tx.ExecContext(ctx, q, l.RequestId, l.JobId, l.AcquiredAt, l.Name); err != nil {
+		return l, serr.NewDbError(err, "UPDATE singleton_locks")
+	}
+	if err := tx.Commit(); err != nil {
+		return l, serr.NewDbError(err, "COMMIT")
+	}
+	return l, nil
+}
+
+func (m *manager) Release(l proto.SingletonLock) error {
+	q := "DELETE FROM singleton_locks WHERE name = ? AND request_id = ? AND job_id = ?"
+	if _, err := m.dbc.ExecContext(context.TODO(), q, l.Name, l.RequestId, l.JobId); err != nil {
+		return serr.NewDbError(err, "DELETE singleton_locks")
+	}
+	return nil
+}
+
+func (m *manager) List() ([]proto.SingletonLock, error) {
+	q := "SELECT name, request_id, job_id, acquired_at FROM singleton_locks ORDER BY name"
+	rows, err := m.dbc.QueryContext(context.TODO(), q)
+	if err != nil {
+		return nil, serr.NewDbError(err, "SELECT singleton_locks")
+	}
+	defer rows.Close()
+	locks := []proto.SingletonLock{}
+	for rows.Next() {
+		var l proto.SingletonLock
+		if err := rows.Scan(&l.Name, &l.RequestId, &l.JobId, &l.AcquiredAt); err != nil {
+			return nil, serr.NewDbError(err, "SELECT singleton_locks")
+		}
+		locks = append(locks, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, serr.NewDbError(err, "SELECT singleton_locks")
+	}
+	return locks, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2020, Square, Inc.
+
+package singleton_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/singleton"
+	testdb "github.com/square/spincycle/v2/request-manager/test/db"
+)
+
+var dbm testdb.Manager
+var dbc *sql.DB
+
+func setup(t *testing.T, dataFile string) string {
+	var err error
+	if dbm == nil {
+		dbm, err = testdb.NewManager()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbName, err := dbm.Create(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbm.Connect(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbc = db
+	return dbName
+}
+
+func teardown(t *testing.T, dbName string) {
+	if err := dbm.Destroy(dbName); err != nil {
+		t.Fatal(err)
+	}
+	dbc.Close()
+}
+
+// //////////////////////////////////////////////////////////////////////////
+// Tests
+// //////////////////////////////////////////////////////////////////////////
+
+func TestAcquireRelease(t *testing.T) {
+	dbName := setup(t, "../test/data/request-default.sql")
+	defer teardown(t, dbName)
+
+	m := singleton.NewManager(singleton.ManagerConfig{DBConnector: dbc})
+
+	// Request 454ae2f98a05cv16sdwt is running
+	running := proto.SingletonLock{Name: "restart/host1", RequestId: "454ae2f98a05cv16sdwt", JobId: "590s"}
+	holder, err := m.Acquire(running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder.RequestId != running.RequestId || holder.JobId != running.JobId {
+		t.Errorf("holder = %+v, expected %+v", holder, running)
+	}
+
+	// Held by the running request
+	other := proto.SingletonLock{Name: "restart/host1", RequestId: "running_abandoned___", JobId: "abcd"}
+	holder, err = m.Acquire(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder.RequestId != running.RequestId || holder.JobId != running.JobId {
+		t.Errorf("holder = %+v, expected %+v", holder, running)
+	}
+
+	// Re-acquired by the holder (e.g. after recovery from a dead JR)
+	holder, err = m.Acquire(running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder.RequestId != running.RequestId {
+		t.Errorf("holder = %+v, expected %+v", holder, running)
+	}
+
+	// Release by another job doesn't release it
+	if err := m.Release(other); err != nil {
+		t.Fatal(err)
+	}
+	locks, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || locks[0].RequestId != running.RequestId {
+		t.Errorf("got locks %+v, expected lock held by %s", locks, running.RequestId)
+	}
+
+	// Released: acquired by the other job
+	if err := m.Release(running); err != nil {
+		t.Fatal(err)
+	}
+	holder, err = m.Acquire(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder.RequestId != other.RequestId || holder.JobId != other.JobId {
+		t.Errorf("holder = %+v, expected %+v", holder, other)
+	}
+}
+
+func TestAcquireStale(t *testing.T) {
+	dbName := setup(t, "../test/data/request-default.sql")
+	defer teardown(t, dbName)
+
+	m := singleton.NewManager(singleton.ManagerConfig{DBConnector: dbc})
+
+	// Request 93ec156e204ety45sgf0 is complete, so its lock is stale
+	stale := proto.SingletonLock{Name: "restart", RequestId: "93ec156e204ety45sgf0", JobId: "590s"}
+	if _, err := m.Acquire(stale); err != nil {
+		t.Fatal(err)
+	}
+	l := proto.SingletonLock{Name: "restart", RequestId: "454ae2f98a05cv16sdwt", JobId: "g012"}
+	holder, err := m.Acquire(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder.RequestId != l.RequestId || holder.JobId != l.JobId {
+		t.Errorf("holder = %+v, expected %+v", holder, l)
+	}
+}
+
+func TestAcquireInvalid(t *testing.T) {
+	m := singleton.NewManager(singleton.ManagerConfig{})
+	if _, err := m.Acquire(proto.SingletonLock{Name: "restart"}); err == nil {
+		t.Error("no error without requestId and jobId, expected one")
+	}
+}
@@ -35,9 +35,31 @@ func (c BaseCheckFactory) MakeSequenceErrorChecks() ([]SequenceCheck, error) {
 		OptionalArgsHaveDefaultsSequenceCheck{},
 		StaticArgsHaveDefaultsSequenceCheck{},
 
+		DerivedArgsOnlyInRequestsSequenceCheck{},
+		ValidDerivedArgsSequenceCheck{},
+
 		ACLAdminXorOpsSequenceCheck{},
 		ACLsHaveRolesSequenceCheck{},
 		NoDuplicateACLRolesSequenceCheck{},
+
+		GlobalsNamedSequenceCheck{},
+		GlobalsOnlyInRequestsSequenceCheck{},
+		GlobalsHaveValuesSequenceCheck{},
+
+		DedupKeyOnlyInRequestsSequenceCheck{},
+		DedupKeyArgsSequenceCheck{},
+		DedupPolicySequenceCheck{},
+
+		StrictFailureOnlyInRequestsSequenceCheck{},
+
+		OverridesOnlyInRequestsSequenceCheck{},
+		ValidOverridesSequenceCheck{},
+
+		ValidWebhooksSequenceCheck{},
+		ValidEscalationsSequenceCheck{},
+		ValidAssertsSequenceCheck{},
+
+		ValidFinallySequenceCheck{},
 	}, nil
 }
 
@@ -59,6 +81,7 @@ func (c BaseCheckFactory) MakeNodeErrorChecks() ([]NodeCheck, error) {
 		SetsAreNamedNodeCheck{},
 
 		ValidParallelNodeCheck{},
+		ValidGroupByNodeCheck{},
 
 		ConditionalHasIfNodeCheck{},
 		ConditionalHasEqNodeCheck{},
@@ -66,6 +89,14 @@ func (c BaseCheckFactory) MakeNodeErrorChecks() ([]NodeCheck, error) {
 
 		ValidRetryWaitNodeCheck{},
 
+		SkippableIsSequenceNodeCheck{},
+
+		ValidSingletonNodeCheck{},
+
+		ValidNeedsNodeCheck{},
+
+		WeightIsJobNodeCheck{},
+
 		RequiredArgsProvidedNodeCheck{c.AllSpecs},
 	}, nil
 }
@@ -84,6 +115,7 @@ func (c DefaultCheckFactory) MakeSequenceErrorChecks() ([]SequenceCheck, error)
 		NoDuplicateArgsSequenceCheck{},
 		RequiredArgsHaveNoDefaultsSequenceCheck{},
 		HasNodesSequenceCheck{},
+		NoDuplicateGlobalsSequenceCheck{},
 	}, nil
 }
 
@@ -101,12 +133,15 @@ func (c DefaultCheckFactory) MakeNodeErrorChecks() ([]NodeCheck, error) {
 		SetsAsUniqueNodeCheck{},
 
 		EachIfParallelNodeCheck{},
+		EachIfGroupByNodeCheck{},
+		EachIfPaceNodeCheck{},
 
 		ConditionalNoTypeNodeCheck{},
 		NonconditionalNoIfNodeCheck{},
 		NonconditionalNoEqNodeCheck{},
 
 		RetryIfRetryWaitNodeCheck{},
+		RetryIfRetryArgsNodeCheck{},
 	}, nil
 }
 
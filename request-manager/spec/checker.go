@@ -65,7 +65,14 @@ func (checker *Checker) RunChecks(allSpecs Specs) *CheckResults {
 			}
 		}
 
+		nodes := make([]*Node, 0, len(sequence.Nodes)+len(sequence.Finally))
 		for _, node := range sequence.Nodes {
+			nodes = append(nodes, node)
+		}
+		for _, node := range sequence.Finally {
+			nodes = append(nodes, node)
+		}
+		for _, node := range nodes {
 			for _, nodeCheck := range checker.nodeErrorChecks {
 				if err := nodeCheck.CheckNode(*node); err != nil {
 					results.AddError(name, err)
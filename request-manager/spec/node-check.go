@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/square/spincycle/v2/proto"
 )
 
 type NodeCheck interface {
@@ -442,6 +444,68 @@ func (check ValidParallelNodeCheck) CheckNode(node Node) error {
 	return nil
 }
 
+/* ========================================================================== */
+type EachIfGroupByNodeCheck struct{}
+
+/* If 'groupBy' is set, 'each' must be set. */
+func (check EachIfGroupByNodeCheck) CheckNode(node Node) error {
+	if node.GroupBy != "" {
+		if node.Each == nil {
+			return MissingValueError{
+				Node:        &node.Name,
+				Field:       "each",
+				Explanation: "required when 'groupBy' field set",
+			}
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type EachIfPaceNodeCheck struct{}
+
+/* If 'pace' is set, 'each' must be set. */
+func (check EachIfPaceNodeCheck) CheckNode(node Node) error {
+	if node.Pace {
+		if node.Each == nil {
+			return MissingValueError{
+				Node:        &node.Name,
+				Field:       "each",
+				Explanation: "required when 'pace' field set",
+			}
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type ValidGroupByNodeCheck struct{}
+
+/* 'groupBy' must be an 'each' element or a field of one. */
+func (check ValidGroupByNodeCheck) CheckNode(node Node) error {
+	if node.GroupBy == "" || node.Each == nil { // Another check's problem if no each
+		return nil
+	}
+	if eachElementOf(node, node.GroupBy) != "" {
+		return nil
+	}
+	for _, each := range node.Each {
+		split := strings.Split(each, ":")
+		if len(split) == 2 && split[1] == node.GroupBy {
+			return nil
+		}
+	}
+
+	return InvalidValueError{
+		Node:     &node.Name,
+		Field:    "groupBy",
+		Values:   []string{node.GroupBy},
+		Expected: "an 'each' element (e.g. 'host' given 'each: hosts:host') or a field of one (e.g. 'host.zone')",
+	}
+}
+
 /* ========================================================================== */
 type ConditionalNoTypeNodeCheck struct{}
 
@@ -570,6 +634,22 @@ func (check RetryIfRetryWaitNodeCheck) CheckNode(node Node) error {
 	return nil
 }
 
+/* ========================================================================== */
+type RetryIfRetryArgsNodeCheck struct{}
+
+/* If 'retryArgs' is set, 'retry' must be set (nonzero). */
+func (check RetryIfRetryArgsNodeCheck) CheckNode(node Node) error {
+	if len(node.RetryArgs) > 0 && node.Retry == 0 {
+		return MissingValueError{
+			Node:        &node.Name,
+			Field:       "retry",
+			Explanation: "required when 'retryArgs' field set",
+		}
+	}
+
+	return nil
+}
+
 /* ========================================================================== */
 type ValidRetryWaitNodeCheck struct{}
 
@@ -589,6 +669,130 @@ func (check ValidRetryWaitNodeCheck) CheckNode(node Node) error {
 	return nil
 }
 
+/* ========================================================================== */
+type SkippableIsSequenceNodeCheck struct{}
+
+/* Only sequence and conditional nodes can be 'skippable'. */
+func (check SkippableIsSequenceNodeCheck) CheckNode(node Node) error {
+	if node.Skippable && node.IsJob() {
+		return InvalidValueError{
+			Node:     &node.Name,
+			Field:    "skippable",
+			Values:   []string{"true"},
+			Expected: "no value; only sequence and conditional nodes can be skipped",
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type ValidSingletonNodeCheck struct{}
+
+/* 'singleton' is only for jobs, 'singletonKey' must be a job arg, and 'singletonPolicy'
+ * must be valid. 'singletonKey' and 'singletonPolicy' require 'singleton'. */
+func (check ValidSingletonNodeCheck) CheckNode(node Node) error {
+	if !node.Singleton {
+		if node.SingletonKey != "" || node.SingletonPolicy != "" {
+			return MissingValueError{
+				Node:        &node.Name,
+				Field:       "singleton",
+				Explanation: "required when 'singletonKey' or 'singletonPolicy' field set",
+			}
+		}
+		return nil
+	}
+
+	if !node.IsJob() {
+		return InvalidValueError{
+			Node:     &node.Name,
+			Field:    "singleton",
+			Values:   []string{"true"},
+			Expected: "no value; only job nodes can be singletons",
+		}
+	}
+
+	switch node.SingletonPolicy {
+	case "", proto.SINGLETON_POLICY_QUEUE, proto.SINGLETON_POLICY_FAIL:
+	default:
+		return InvalidValueError{
+			Node:     &node.Name,
+			Field:    "singletonPolicy",
+			Values:   []string{node.SingletonPolicy},
+			Expected: fmt.Sprintf("%s or %s", proto.SINGLETON_POLICY_QUEUE, proto.SINGLETON_POLICY_FAIL),
+		}
+	}
+
+	if node.SingletonKey != "" && !getInputArgs(node)[node.SingletonKey] {
+		return InvalidValueError{
+			Node:     &node.Name,
+			Field:    "singletonKey",
+			Values:   []string{node.SingletonKey},
+			Expected: "an 'args' expected arg or an 'each' element",
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type ValidNeedsNodeCheck struct{}
+
+/* 'needs' is only for jobs, and its capabilities must not be empty or duplicated. */
+func (check ValidNeedsNodeCheck) CheckNode(node Node) error {
+	if len(node.Needs) == 0 {
+		return nil
+	}
+
+	if !node.IsJob() {
+		return InvalidValueError{
+			Node:     &node.Name,
+			Field:    "needs",
+			Values:   node.Needs,
+			Expected: "no value; only job nodes need Job Runner capabilities",
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, c := range node.Needs {
+		if c == "" {
+			return InvalidValueError{
+				Node:     &node.Name,
+				Field:    "needs",
+				Values:   node.Needs,
+				Expected: "non-empty capability names",
+			}
+		}
+		if seen[c] {
+			return DuplicateValueError{
+				Node:        &node.Name,
+				Field:       "needs",
+				Values:      []string{c},
+				Explanation: "capability listed more than once",
+			}
+		}
+		seen[c] = true
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type WeightIsJobNodeCheck struct{}
+
+/* 'weight' is only for jobs: request progress is weighed by job, not by sequence. */
+func (check WeightIsJobNodeCheck) CheckNode(node Node) error {
+	if node.Weight != 0 && !node.IsJob() {
+		return InvalidValueError{
+			Node:     &node.Name,
+			Field:    "weight",
+			Values:   []string{fmt.Sprintf("%d", node.Weight)},
+			Expected: "no value; only job nodes have a weight",
+		}
+	}
+	return nil
+}
+
 /* ========================================================================== */
 type RequiredArgsProvidedNodeCheck struct {
 	AllSpecs Specs
@@ -617,7 +821,7 @@ func (check RequiredArgsProvidedNodeCheck) CheckNode(node Node) error {
 			if reqArg == nil || reqArg.Name == nil {
 				continue
 			}
-			if _, ok = declaredArgs[*reqArg.Name]; !ok {
+			if _, ok = declaredArgs[*reqArg.Name]; !ok && eachElementOf(node, *reqArg.Name) == "" {
 				missing[*reqArg.Name] = append(missing[*reqArg.Name], seq.Name)
 			}
 		}
@@ -673,8 +877,13 @@ func (check NoExtraSequenceArgsProvidedNodeCheck) CheckNode(node Node) error {
 		}
 		args := append(seq.Args.Required, seq.Args.Optional...)
 		for _, arg := range args {
-			if arg != nil && arg.Name != nil && excessArgs[*arg.Name] {
-				delete(excessArgs, *arg.Name)
+			if arg == nil || arg.Name == nil {
+				continue
+			}
+			delete(excessArgs, *arg.Name)
+			// A field of an each element uses the element
+			if element := eachElementOf(node, *arg.Name); element != "" {
+				delete(excessArgs, element)
 			}
 		}
 	}
@@ -760,6 +969,23 @@ func getCalledSequences(node Node) []string {
 	return sequences
 }
 
+// eachElementOf returns the each element that the arg is a field of, or an
+// empty string. For example, given "each: shards:shard", arg "shard.host" is a
+// field of element "shard". Fields are bound when the element is a map, which
+// cannot be checked statically.
+func eachElementOf(node Node, arg string) string {
+	for _, each := range node.Each {
+		split := strings.Split(each, ":")
+		if len(split) != 2 {
+			continue
+		}
+		if strings.HasPrefix(arg, split[1]+".") && len(arg) > len(split[1])+1 {
+			return split[1]
+		}
+	}
+	return ""
+}
+
 // Get set of all (declared) inputs to a node (i.e. `args -> expected` and `each -> element`).
 func getInputArgs(node Node) map[string]bool {
 	var declaredArgs = map[string]bool{}
@@ -484,6 +484,156 @@ func TestFailValidParallelNodeCheck(t *testing.T) {
 	compareError(t, err, expectedErr, "accepted parallel = 0, expected error")
 }
 
+func TestFailEachIfGroupByNodeCheck(t *testing.T) {
+	check := EachIfGroupByNodeCheck{}
+	node := Node{
+		Name:    nodeA,
+		GroupBy: "host.zone",
+	}
+	expectedErr := MissingValueError{
+		Node:  &nodeA,
+		Field: "each",
+	}
+
+	err := check.CheckNode(node)
+	compareError(t, err, expectedErr, "accepted node with 'groupBy' field with empty 'each' field, expected error")
+}
+
+func TestFailEachIfPaceNodeCheck(t *testing.T) {
+	check := EachIfPaceNodeCheck{}
+	node := Node{
+		Name: nodeA,
+		Pace: true,
+	}
+	expectedErr := MissingValueError{
+		Node:  &nodeA,
+		Field: "each",
+	}
+
+	err := check.CheckNode(node)
+	compareError(t, err, expectedErr, "accepted node with 'pace' field with empty 'each' field, expected error")
+}
+
+func TestValidGroupByNodeCheck(t *testing.T) {
+	check := ValidGroupByNodeCheck{}
+	node := Node{
+		Name: nodeA,
+		Each: []string{"hosts:host"},
+	}
+	for _, groupBy := range []string{"host", "host.zone"} {
+		node.GroupBy = groupBy
+		if err := check.CheckNode(node); err != nil {
+			t.Errorf("groupBy %s: got error %s, expected nil", groupBy, err)
+		}
+	}
+
+	node.GroupBy = "zone"
+	expectedErr := InvalidValueError{
+		Node:   &nodeA,
+		Field:  "groupBy",
+		Values: []string{"zone"},
+	}
+	err := check.CheckNode(node)
+	compareError(t, err, expectedErr, "accepted groupBy that is not an each element, expected error")
+}
+
+func TestValidNeedsNodeCheck(t *testing.T) {
+	check := ValidNeedsNodeCheck{}
+	category := "job"
+	node := Node{
+		Name:     nodeA,
+		Category: &category,
+		Needs:    []string{"docker", "gpu"},
+	}
+	if err := check.CheckNode(node); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	node.Needs = []string{"gpu", "gpu"}
+	dupErr := DuplicateValueError{
+		Node:   &nodeA,
+		Field:  "needs",
+		Values: []string{"gpu"},
+	}
+	err := check.CheckNode(node)
+	compareError(t, err, dupErr, "accepted duplicate capability, expected error")
+
+	node.Needs = []string{""}
+	expectedErr := InvalidValueError{
+		Node:   &nodeA,
+		Field:  "needs",
+		Values: []string{""},
+	}
+	err = check.CheckNode(node)
+	compareError(t, err, expectedErr, "accepted empty capability, expected error")
+
+	category = "sequence"
+	node.Needs = []string{"gpu"}
+	expectedErr = InvalidValueError{
+		Node:   &nodeA,
+		Field:  "needs",
+		Values: []string{"gpu"},
+	}
+	err = check.CheckNode(node)
+	compareError(t, err, expectedErr, "accepted needs on sequence node, expected error")
+}
+
+func TestValidSingletonNodeCheck(t *testing.T) {
+	check := ValidSingletonNodeCheck{}
+	category := "job"
+	expected := "host"
+	node := Node{
+		Name:         nodeA,
+		Category:     &category,
+		Args:         []*NodeArg{{Expected: &expected, Given: &expected}},
+		Singleton:    true,
+		SingletonKey: "host",
+	}
+	if err := check.CheckNode(node); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	node.SingletonKey = "zone"
+	expectedErr := InvalidValueError{
+		Node:   &nodeA,
+		Field:  "singletonKey",
+		Values: []string{"zone"},
+	}
+	err := check.CheckNode(node)
+	compareError(t, err, expectedErr, "accepted singletonKey that is not a job arg, expected error")
+
+	node.SingletonKey = ""
+	node.SingletonPolicy = testVal
+	expectedErr = InvalidValueError{
+		Node:   &nodeA,
+		Field:  "singletonPolicy",
+		Values: []string{testVal},
+	}
+	err = check.CheckNode(node)
+	compareError(t, err, expectedErr, "accepted invalid singletonPolicy, expected error")
+
+	node.Singleton = false
+	node.SingletonPolicy = "fail"
+	missingErr := MissingValueError{
+		Node:  &nodeA,
+		Field: "singleton",
+	}
+	err = check.CheckNode(node)
+	compareError(t, err, missingErr, "accepted singletonPolicy without singleton, expected error")
+
+	sequence := "sequence"
+	node.Category = &sequence
+	node.Singleton = true
+	node.SingletonPolicy = ""
+	expectedErr = InvalidValueError{
+		Node:   &nodeA,
+		Field:  "singleton",
+		Values: []string{"true"},
+	}
+	err = check.CheckNode(node)
+	compareError(t, err, expectedErr, "accepted singleton sequence node, expected error")
+}
+
 func TestFailConditionalNoTypeNodeCheck(t *testing.T) {
 	check := ConditionalNoTypeNodeCheck{}
 	conditional := "conditional"
@@ -596,6 +746,21 @@ func TestFailRetryIfRetryWaitNodeCheck(t *testing.T) {
 	compareError(t, err, expectedErr, "accepted node with 'retryWait' field with retry: 0, expected error")
 }
 
+func TestFailRetryIfRetryArgsNodeCheck(t *testing.T) {
+	check := RetryIfRetryArgsNodeCheck{}
+	node := Node{
+		Name:      nodeA,
+		RetryArgs: map[string]string{testVal: testVal},
+	}
+	expectedErr := MissingValueError{
+		Node:  &nodeA,
+		Field: "retry",
+	}
+
+	err := check.CheckNode(node)
+	compareError(t, err, expectedErr, "accepted node with 'retryArgs' field with retry: 0, expected error")
+}
+
 func TestFailValidRetryWaitNodeCheck(t *testing.T) {
 	check := ValidRetryWaitNodeCheck{}
 	node := Node{
@@ -724,6 +889,40 @@ func TestFailRequiredArgsProvidedNodeCheck3(t *testing.T) {
 	compareError(t, err, expectedErr, "not all required args to expanded sequence node listed in 'args', expected error")
 }
 
+func TestRequiredArgsProvidedNodeCheckEachFields(t *testing.T) {
+	seqa := "seq-a"
+	shardId := "shard.id"
+	shardHost := "shard.host"
+	specs := Specs{
+		Sequences: map[string]*Sequence{
+			seqa: &Sequence{
+				Name: seqa,
+				Args: SequenceArgs{
+					Required: []*Arg{
+						&Arg{Name: &shardId},
+						&Arg{Name: &shardHost},
+					},
+				},
+			},
+		},
+	}
+	check := RequiredArgsProvidedNodeCheck{specs}
+	sequence := "sequence" // Test expanded sequence node
+	node := Node{
+		Name:     nodeA,
+		Category: &sequence,
+		NodeType: &seqa,
+		Each: []string{
+			"shards:shard", // Sets shard.id and shard.host
+		},
+	}
+
+	err := check.CheckNode(node)
+	if err != nil {
+		t.Errorf("RequiredArgsProvidedNodeCheck failed: %s, expected pass", err)
+	}
+}
+
 func TestNoExtraSequenceArgsProvidedNodeCheck1(t *testing.T) {
 	argA := "arg-a"
 	argB := "arg-b"
@@ -850,6 +1049,37 @@ func TestNoExtraSequenceArgsProvidedNodeCheck3(t *testing.T) {
 	}
 }
 
+func TestNoExtraSequenceArgsProvidedNodeCheck4(t *testing.T) {
+	shardId := "shard.id"
+	specs := Specs{
+		Sequences: map[string]*Sequence{
+			seqA: &Sequence{
+				Name: seqA,
+				Args: SequenceArgs{
+					Required: []*Arg{
+						&Arg{Name: &shardId},
+					},
+				},
+			},
+		},
+	}
+	check := NoExtraSequenceArgsProvidedNodeCheck{specs}
+	sequence := "sequence" // Test expanded sequence node, element used by its fields
+	node := Node{
+		Name:     nodeA,
+		Category: &sequence,
+		NodeType: &seqA,
+		Each: []string{
+			"shards:shard",
+		},
+	}
+
+	err := check.CheckNode(node)
+	if err != nil {
+		t.Errorf("NoExtraSequenceArgsProvidedNodeCheck failed, expected pass")
+	}
+}
+
 func TestFailNoExtraSequenceArgsProvidedNodeCheck1(t *testing.T) {
 	argA := "arg-a"
 	argB := "arg-b"
@@ -1037,3 +1267,47 @@ func TestFailSubsequencesExistNodeCheck2(t *testing.T) {
 	err := check.CheckNode(node)
 	compareError(t, err, expectedErr, "node calls seq that does not exist in specs, expected error")
 }
+
+func TestSkippableIsSequenceNodeCheck(t *testing.T) {
+	check := SkippableIsSequenceNodeCheck{}
+	category := "sequence"
+	node := Node{
+		Name:      nodeA,
+		Category:  &category,
+		Skippable: true,
+	}
+	if err := check.CheckNode(node); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	category = "job"
+	expectedErr := InvalidValueError{
+		Node:   &nodeA,
+		Field:  "skippable",
+		Values: []string{"true"},
+	}
+	err := check.CheckNode(node)
+	compareError(t, err, expectedErr, "accepted skippable job node, expected error")
+}
+
+func TestWeightIsJobNodeCheck(t *testing.T) {
+	check := WeightIsJobNodeCheck{}
+	category := "job"
+	node := Node{
+		Name:     nodeA,
+		Category: &category,
+		Weight:   30,
+	}
+	if err := check.CheckNode(node); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	category = "sequence"
+	expectedErr := InvalidValueError{
+		Node:   &nodeA,
+		Field:  "weight",
+		Values: []string{"30"},
+	}
+	err := check.CheckNode(node)
+	compareError(t, err, expectedErr, "accepted weight on sequence node, expected error")
+}
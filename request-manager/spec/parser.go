@@ -9,8 +9,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// POLICY_FILE is the name of a policy file in the specs directory or one of its
+// subdirectories, see Policy.
+const POLICY_FILE = "_policy.yaml"
+
 // Parse a single request (YAML) file.
 func ParseSpec(specFile string) (Specs, *CheckResult) {
 	spec := Specs{}
@@ -45,7 +50,8 @@ func ParseSpecsDir(specsDir string) (Specs, *CheckResults, error) {
 	}
 	fileResults := NewCheckResults()
 
-	seqFile := map[string]string{} // sequence name --> file it was first seen in
+	seqFile := map[string]string{}  // sequence name --> file it was first seen in
+	policies := map[string]Policy{} // dir (relative to specsDir) --> its policy
 	err := filepath.Walk(specsDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -58,6 +64,16 @@ func ParseSpecsDir(specsDir string) (Specs, *CheckResults, error) {
 			relPath = path
 		}
 
+		if info.Name() == POLICY_FILE {
+			policy, err := ParsePolicy(path)
+			if err != nil {
+				fileResults.AddError(relPath, err)
+				return nil
+			}
+			policies[filepath.Dir(relPath)] = policy
+			return nil
+		}
+
 		spec, result := ParseSpec(path)
 		fileResults.AddResult(relPath, result)
 		if len(result.Errors) != 0 {
@@ -86,9 +102,118 @@ func ParseSpecsDir(specsDir string) (Specs, *CheckResults, error) {
 		return specs, fileResults, fmt.Errorf("error traversing specs directory: %s", err)
 	}
 
+	if len(policies) > 0 {
+		for _, seq := range specs.Sequences {
+			applyPolicy(seq, dirPolicy(filepath.Dir(seq.Filename), policies))
+		}
+	}
+
 	return specs, fileResults, nil
 }
 
+// Parse a single policy (YAML) file. Unlike specs, unknown fields are errors
+// because a misspelled policy would silently not apply.
+func ParsePolicy(policyFile string) (Policy, error) {
+	var policy Policy
+	bytes, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return policy, err
+	}
+	if err := yaml.UnmarshalStrict(bytes, &policy); err != nil {
+		return policy, fmt.Errorf("invalid policy: %s", err)
+	}
+	for field, val := range map[string]string{"retryWait": policy.RetryWait, "sequenceRetryWait": policy.SequenceRetryWait} {
+		if val == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(val); err != nil {
+			return policy, fmt.Errorf("invalid policy: %s: %s", field, err)
+		}
+	}
+	return policy, nil
+}
+
+// dirPolicy returns the policy of the dir: the policies of the specs dir and
+// every subdirectory down to dir, merged in that order.
+func dirPolicy(dir string, policies map[string]Policy) Policy {
+	dirs := []string{"."}
+	if dir != "." {
+		parts := strings.Split(dir, string(filepath.Separator))
+		for i := range parts {
+			dirs = append(dirs, filepath.Join(parts[:i+1]...))
+		}
+	}
+	var merged Policy
+	for _, d := range dirs {
+		p, ok := policies[d]
+		if !ok {
+			continue
+		}
+		if p.Retry > 0 {
+			merged.Retry = p.Retry
+		}
+		if p.RetryWait != "" {
+			merged.RetryWait = p.RetryWait
+		}
+		if p.SequenceRetry > 0 {
+			merged.SequenceRetry = p.SequenceRetry
+		}
+		if p.SequenceRetryWait != "" {
+			merged.SequenceRetryWait = p.SequenceRetryWait
+		}
+		if len(p.ACL) > 0 {
+			merged.ACL = p.ACL
+		}
+		if p.StrictFailure {
+			merged.StrictFailure = true
+		}
+		merged.Webhooks = append(merged.Webhooks, p.Webhooks...)
+	}
+	return merged
+}
+
+// applyPolicy sets the policy values that the sequence and its nodes do not set.
+func applyPolicy(seq *Sequence, p Policy) {
+	nodes := make([]*Node, 0, len(seq.Nodes)+len(seq.Finally))
+	for _, node := range seq.Nodes {
+		nodes = append(nodes, node)
+	}
+	for _, node := range seq.Finally {
+		nodes = append(nodes, node)
+	}
+	for _, node := range nodes {
+		var retry uint
+		var retryWait string
+		switch {
+		case node.IsJob():
+			retry, retryWait = p.Retry, p.RetryWait
+		case node.IsSequence(), node.IsConditional():
+			retry, retryWait = p.SequenceRetry, p.SequenceRetryWait
+		default:
+			continue
+		}
+		if node.Retry == 0 {
+			node.Retry = retry
+		}
+		if node.RetryWait == "" && node.Retry > 0 {
+			node.RetryWait = retryWait
+		}
+	}
+	if !seq.Request {
+		return
+	}
+	if len(seq.ACL) == 0 && len(p.ACL) > 0 {
+		seq.ACL = append([]ACL{}, p.ACL...)
+	}
+	if p.StrictFailure {
+		seq.StrictFailure = true
+	}
+	for _, wh := range p.Webhooks {
+		cp := *wh
+		seq.Webhooks = append(seq.Webhooks, &cp)
+	}
+}
+
 // Specs require some processing after we've loaded them, but before we run the checker on them.
 // Function modifies specs passed in.
 func ProcessSpecs(specs *Specs) {
@@ -96,22 +221,30 @@ func ProcessSpecs(specs *Specs) {
 		sequence.Name = sequenceName
 
 		for nodeName, node := range sequence.Nodes {
-			node.Name = nodeName
+			processNode(nodeName, node)
+		}
+		for nodeName, node := range sequence.Finally {
+			processNode(nodeName, node)
+			node.Finally = true
+		}
+	}
+}
 
-			// Set various optional fields if they were excluded.
-			for i, nodeSet := range node.Sets {
-				if nodeSet != nil && nodeSet.As == nil {
-					node.Sets[i].As = node.Sets[i].Arg
-				}
-			}
-			for i, nodeArg := range node.Args {
-				if nodeArg != nil && nodeArg.Given == nil {
-					node.Args[i].Given = node.Args[i].Expected
-				}
-			}
-			if node.Retry > 0 && node.RetryWait == "" {
-				node.RetryWait = "0s"
-			}
+func processNode(nodeName string, node *Node) {
+	node.Name = nodeName
+
+	// Set various optional fields if they were excluded.
+	for i, nodeSet := range node.Sets {
+		if nodeSet != nil && nodeSet.As == nil {
+			node.Sets[i].As = node.Sets[i].Arg
 		}
 	}
+	for i, nodeArg := range node.Args {
+		if nodeArg != nil && nodeArg.Given == nil {
+			node.Args[i].Given = node.Args[i].Expected
+		}
+	}
+	if node.Retry > 0 && node.RetryWait == "" {
+		node.RetryWait = "0s"
+	}
 }
@@ -4,6 +4,8 @@ package spec_test
 
 import (
 	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/go-test/deep"
@@ -61,6 +63,81 @@ func TestFailParseSpecsDir(t *testing.T) {
 	}
 }
 
+func TestParseSpecsDirPolicy(t *testing.T) {
+	specsDir := specsDir + "policy-specs-dir"
+	specs, results, err := ParseSpecsDir(specsDir)
+	if err != nil || results.AnyError {
+		t.Fatalf("failed to parse specs directory, expected success: %s", err)
+	}
+
+	// Root policy only
+	app := specs.Sequences["restart-app"]
+	if app.Nodes["restart"].Retry != 1 || app.Nodes["restart"].RetryWait != "1s" {
+		t.Errorf("restart-app restart node retry %d retryWait %s, expected 1 1s", app.Nodes["restart"].Retry, app.Nodes["restart"].RetryWait)
+	}
+	if diff := deep.Equal(app.ACL, []ACL{{Role: "eng", Admin: true}}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(app.Webhooks, []*Webhook{{URL: "https://audit.example.com/events", Events: []string{"fail"}}}); diff != nil {
+		t.Error(diff)
+	}
+	if app.StrictFailure {
+		t.Errorf("restart-app strictFailure true, expected false")
+	}
+
+	// Root and db/ policies, and values set in the spec
+	db := specs.Sequences["restart-db"]
+	expectRetry := map[string]uint{"stop-db": 3, "start-db": 5, "check-db": 2}
+	expectWait := map[string]string{"stop-db": "1s", "start-db": "1s", "check-db": "10s"}
+	for name, node := range db.Nodes {
+		if node.Retry != expectRetry[name] || node.RetryWait != expectWait[name] {
+			t.Errorf("restart-db %s node retry %d retryWait %s, expected %d %s", name, node.Retry, node.RetryWait, expectRetry[name], expectWait[name])
+		}
+	}
+	if diff := deep.Equal(db.ACL, []ACL{{Role: "dba", Ops: []string{"start"}}}); diff != nil {
+		t.Error(diff)
+	}
+	expectWebhooks := []*Webhook{
+		{URL: "https://audit.example.com/events", Events: []string{"fail"}},
+		{URL: "https://dba.example.com/events"},
+	}
+	if diff := deep.Equal(db.Webhooks, expectWebhooks); diff != nil {
+		t.Error(diff)
+	}
+	if !db.StrictFailure {
+		t.Errorf("restart-db strictFailure false, expected true")
+	}
+
+	// Request-only values are not set on non-request sequences
+	check := specs.Sequences["check-db"]
+	if len(check.ACL) != 0 || len(check.Webhooks) != 0 || check.StrictFailure {
+		t.Errorf("check-db has request-only policy values, expected none")
+	}
+	if check.Nodes["ping-db"].Retry != 3 {
+		t.Errorf("check-db ping-db node retry %d, expected 3", check.Nodes["ping-db"].Retry)
+	}
+}
+
+func TestFailParsePolicy(t *testing.T) {
+	for _, policy := range []string{
+		"retries: 3\n",        // unknown field
+		"retryWait: 3 secs\n", // invalid duration
+	} {
+		file, err := ioutil.TempFile("", "spincycle-policy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(file.Name())
+		if _, err := file.WriteString(policy); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		if _, err := ParsePolicy(file.Name()); err == nil {
+			t.Errorf("parsed invalid policy %q, expected error", policy)
+		}
+	}
+}
+
 func TestProcessSpecs(t *testing.T) {
 	requiredA := "required-a"
 	optionalA := "optional-a"
@@ -246,3 +323,40 @@ func TestProcessSpecs(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestSpecsVersion(t *testing.T) {
+	sequencesFile := specsDir + "decomm.yaml"
+	specs, result := ParseSpec(sequencesFile)
+	if len(result.Errors) != 0 {
+		t.Fatalf("failed to parse decomm.yaml: %v", result.Errors)
+	}
+	ProcessSpecs(&specs)
+
+	v1 := specs.Version("decommission-cluster")
+	if v1 == "" {
+		t.Fatal("got empty version, expected a hash")
+	}
+	if v := specs.Version("nonexistent"); v != "" {
+		t.Errorf("got version %s for nonexistent sequence, expected empty string", v)
+	}
+
+	// Same specs, same version, regardless of file
+	specs2, _ := ParseSpec(sequencesFile)
+	ProcessSpecs(&specs2)
+	specs2.Sequences["decommission-cluster"].Filename = "moved.yaml"
+	if v := specs2.Version("decommission-cluster"); v != v1 {
+		t.Errorf("got version %s after reparse, expected %s", v, v1)
+	}
+
+	// Unused sequence changed: same version
+	specs2.Sequences["unused"] = &Sequence{Name: "unused"}
+	if v := specs2.Version("decommission-cluster"); v != v1 {
+		t.Errorf("got version %s after adding unused sequence, expected %s", v, v1)
+	}
+
+	// Subsequence changed: new version
+	specs2.Sequences["decommission-instance"].Desc = "changed"
+	if v := specs2.Version("decommission-cluster"); v == v1 {
+		t.Errorf("got same version %s after changing subsequence, expected new version", v)
+	}
+}
@@ -4,7 +4,14 @@ package spec
 
 import (
 	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/square/spincycle/v2/expr"
+	"github.com/square/spincycle/v2/proto"
 )
 
 type SequenceCheck interface {
@@ -131,6 +138,100 @@ func (check StaticArgsHaveDefaultsSequenceCheck) CheckSequence(sequence Sequence
 	return nil
 }
 
+/* ========================================================================== */
+type DerivedArgsOnlyInRequestsSequenceCheck struct{}
+
+/* Only requests can specify derived args. */
+func (check DerivedArgsOnlyInRequestsSequenceCheck) CheckSequence(sequence Sequence) error {
+	if len(sequence.Args.Derived) > 0 && !sequence.Request {
+		return InvalidValueError{
+			Node:     nil,
+			Field:    "args.derived",
+			Values:   []string{fmt.Sprintf("%d derived args", len(sequence.Args.Derived))},
+			Expected: "no derived args because sequence is not a request (request: true)",
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type ValidDerivedArgsSequenceCheck struct{}
+
+/* Derived args must be named, have exactly one of template, expr, or lookup, and
+ * use only request args and the derived args before them. */
+func (check ValidDerivedArgsSequenceCheck) CheckSequence(sequence Sequence) error {
+	args := map[string]bool{}
+	for _, seqArgs := range [][]*Arg{sequence.Args.Required, sequence.Args.Optional, sequence.Args.Static} {
+		for _, arg := range seqArgs {
+			if arg.Name != nil {
+				args[*arg.Name] = true
+			}
+		}
+	}
+
+	for _, arg := range sequence.Args.Derived {
+		if arg == nil || arg.Name == nil {
+			return MissingValueError{
+				Node:        nil,
+				Field:       "args.derived.name",
+				Explanation: "",
+			}
+		}
+		n := 0
+		for _, set := range []bool{arg.Template != "", arg.Expr != "", arg.Lookup != nil} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 {
+			return InvalidValueError{
+				Node:     nil,
+				Field:    "args.derived",
+				Values:   []string{*arg.Name},
+				Expected: "exactly one of template, expr, or lookup",
+			}
+		}
+		if arg.Expr != "" {
+			if _, err := expr.Parse(arg.Expr); err != nil {
+				return InvalidValueError{
+					Node:     nil,
+					Field:    "args.derived.expr",
+					Values:   []string{arg.Expr},
+					Expected: fmt.Sprintf("valid expression (%s)", err),
+				}
+			}
+		}
+		if arg.Lookup != nil && (arg.Lookup.Arg == "" || len(arg.Lookup.Map) == 0) {
+			return MissingValueError{
+				Node:        nil,
+				Field:       "args.derived.lookup",
+				Explanation: fmt.Sprintf("arg and map required for lookup of derived arg %s", *arg.Name),
+			}
+		}
+
+		missing := map[string]bool{}
+		for _, name := range arg.Args() {
+			if !args[name] {
+				missing[name] = true
+			}
+		}
+		if len(missing) > 0 {
+			values := stringSetToArray(missing)
+			sort.Strings(values)
+			return InvalidValueError{
+				Node:     nil,
+				Field:    "args.derived",
+				Values:   values,
+				Expected: fmt.Sprintf("request args or derived args before %s", *arg.Name),
+			}
+		}
+		args[*arg.Name] = true
+	}
+
+	return nil
+}
+
 /* ========================================================================== */
 type NoDuplicateArgsSequenceCheck struct{}
 
@@ -148,6 +249,14 @@ func (check NoDuplicateArgsSequenceCheck) CheckSequence(sequence Sequence) error
 			seen[*arg.Name] = true
 		}
 	}
+	for _, arg := range sequence.Args.Derived {
+		if arg != nil && arg.Name != nil {
+			if seen[*arg.Name] {
+				values[*arg.Name] = true
+			}
+			seen[*arg.Name] = true
+		}
+	}
 
 	if len(values) > 0 {
 		return DuplicateValueError{
@@ -192,6 +301,11 @@ func (check NodesSetsUniqueSequenceCheck) CheckSequence(sequence Sequence) error
 			}
 		}
 	}
+	for _, arg := range sequence.Args.Derived {
+		if arg != nil && arg.Name != nil {
+			set[*arg.Name] = "this sequence"
+		}
+	}
 
 	for _, node := range sequence.Nodes {
 		// Don't catch duplicates within a node--there's a node check that
@@ -292,3 +406,448 @@ func (check NoDuplicateACLRolesSequenceCheck) CheckSequence(sequence Sequence) e
 
 	return nil
 }
+
+/* ========================================================================== */
+type GlobalsNamedSequenceCheck struct{}
+
+/* Globals must be named, i.e. include a 'name' field. */
+func (check GlobalsNamedSequenceCheck) CheckSequence(sequence Sequence) error {
+	for _, global := range sequence.Globals {
+		if global.Name == nil {
+			return MissingValueError{
+				Node:        nil,
+				Field:       "globals.name",
+				Explanation: "",
+			}
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type GlobalsOnlyInRequestsSequenceCheck struct{}
+
+/* Only requests can specify globals. */
+func (check GlobalsOnlyInRequestsSequenceCheck) CheckSequence(sequence Sequence) error {
+	if len(sequence.Globals) > 0 && !sequence.Request {
+		return InvalidValueError{
+			Node:     nil,
+			Field:    "globals",
+			Values:   []string{fmt.Sprintf("%d globals", len(sequence.Globals))},
+			Expected: "no globals because sequence is not a request (request: true)",
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type GlobalsHaveValuesSequenceCheck struct{}
+
+/* Globals must be request args or have defaults. */
+func (check GlobalsHaveValuesSequenceCheck) CheckSequence(sequence Sequence) error {
+	args := map[string]bool{}
+	for _, seqArgs := range [][]*Arg{sequence.Args.Required, sequence.Args.Optional, sequence.Args.Static} {
+		for _, arg := range seqArgs {
+			if arg.Name != nil {
+				args[*arg.Name] = true
+			}
+		}
+	}
+	for _, arg := range sequence.Args.Derived {
+		if arg != nil && arg.Name != nil {
+			args[*arg.Name] = true
+		}
+	}
+
+	missing := map[string]bool{}
+	for _, global := range sequence.Globals {
+		if global.Name == nil { // Another check's problem
+			continue
+		}
+		if !args[*global.Name] && global.Default == nil {
+			missing[*global.Name] = true
+		}
+	}
+
+	if len(missing) > 0 {
+		names := stringSetToArray(missing)
+		sort.Strings(names)
+		return MissingValueError{
+			Node:        nil,
+			Field:       "globals.default",
+			Explanation: fmt.Sprintf("required for globals that are not request args: %s", strings.Join(names, ", ")),
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type NoDuplicateGlobalsSequenceCheck struct{}
+
+/* Globals should appear once per sequence. */
+func (check NoDuplicateGlobalsSequenceCheck) CheckSequence(sequence Sequence) error {
+	seen := map[string]bool{}
+	values := map[string]bool{}
+	for _, global := range sequence.Globals {
+		if global.Name == nil { // Another check's problem
+			continue
+		}
+		if seen[*global.Name] {
+			values[*global.Name] = true
+		}
+		seen[*global.Name] = true
+	}
+
+	if len(values) > 0 {
+		return DuplicateValueError{
+			Node:        nil,
+			Field:       "globals.name",
+			Values:      stringSetToArray(values),
+			Explanation: "",
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type DedupKeyOnlyInRequestsSequenceCheck struct{}
+
+/* Only requests can specify a dedup key and policy. */
+func (check DedupKeyOnlyInRequestsSequenceCheck) CheckSequence(sequence Sequence) error {
+	if (sequence.DedupKey != "" || sequence.DedupPolicy != "") && !sequence.Request {
+		return InvalidValueError{
+			Node:     nil,
+			Field:    "dedupKey",
+			Values:   []string{sequence.DedupKey},
+			Expected: "no dedupKey or dedupPolicy because sequence is not a request (request: true)",
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type StrictFailureOnlyInRequestsSequenceCheck struct{}
+
+/* Only requests can specify strict failure: it applies to the whole job chain. */
+func (check StrictFailureOnlyInRequestsSequenceCheck) CheckSequence(sequence Sequence) error {
+	if sequence.StrictFailure && !sequence.Request {
+		return InvalidValueError{
+			Node:     nil,
+			Field:    "strictFailure",
+			Values:   []string{"true"},
+			Expected: "no strictFailure because sequence is not a request (request: true)",
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type OverridesOnlyInRequestsSequenceCheck struct{}
+
+/* Only requests can specify overrides: they're set when the request is created. */
+func (check OverridesOnlyInRequestsSequenceCheck) CheckSequence(sequence Sequence) error {
+	if len(sequence.Overrides) > 0 && !sequence.Request {
+		return InvalidValueError{
+			Node:     nil,
+			Field:    "overrides",
+			Values:   []string{fmt.Sprintf("%d overrides", len(sequence.Overrides))},
+			Expected: "no overrides because sequence is not a request (request: true)",
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type ValidOverridesSequenceCheck struct{}
+
+/* Overrides must have a unique name, and their patterns must be valid regexps. */
+func (check ValidOverridesSequenceCheck) CheckSequence(sequence Sequence) error {
+	seen := map[string]bool{}
+	for _, o := range sequence.Overrides {
+		if o == nil || o.Name == "" {
+			return MissingValueError{
+				Node:        nil,
+				Field:       "overrides.name",
+				Explanation: "required for every override",
+			}
+		}
+		if seen[o.Name] {
+			return InvalidValueError{
+				Node:     nil,
+				Field:    "overrides.name",
+				Values:   []string{o.Name},
+				Expected: "unique override names",
+			}
+		}
+		seen[o.Name] = true
+		if o.Pattern != "" {
+			if _, err := regexp.Compile(o.Pattern); err != nil {
+				return InvalidValueError{
+					Node:     nil,
+					Field:    "overrides.pattern",
+					Values:   []string{o.Pattern},
+					Expected: fmt.Sprintf("valid regexp (%s)", err),
+				}
+			}
+		}
+		for _, v := range o.Values {
+			if o.Check(v) != nil {
+				return InvalidValueError{
+					Node:     nil,
+					Field:    "overrides.values",
+					Values:   []string{v},
+					Expected: fmt.Sprintf("values that match pattern %s", o.Pattern),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type DedupKeyArgsSequenceCheck struct{}
+
+/* Every {{arg}} in a dedup key must be a request arg. */
+func (check DedupKeyArgsSequenceCheck) CheckSequence(sequence Sequence) error {
+	args := map[string]bool{}
+	for _, seqArgs := range [][]*Arg{sequence.Args.Required, sequence.Args.Optional, sequence.Args.Static} {
+		for _, arg := range seqArgs {
+			if arg.Name != nil {
+				args[*arg.Name] = true
+			}
+		}
+	}
+	for _, arg := range sequence.Args.Derived {
+		if arg != nil && arg.Name != nil {
+			args[*arg.Name] = true
+		}
+	}
+
+	missing := map[string]bool{}
+	for _, name := range sequence.DedupKeyArgs() {
+		if !args[name] {
+			missing[name] = true
+		}
+	}
+
+	if len(missing) > 0 {
+		values := stringSetToArray(missing)
+		sort.Strings(values)
+		return InvalidValueError{
+			Node:     nil,
+			Field:    "dedupKey",
+			Values:   values,
+			Expected: "request args (required, optional, static, or derived)",
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type DedupPolicySequenceCheck struct{}
+
+/* Dedup policy must be valid and requires a dedup key. */
+func (check DedupPolicySequenceCheck) CheckSequence(sequence Sequence) error {
+	switch sequence.DedupPolicy {
+	case "", DEDUP_POLICY_RETURN, DEDUP_POLICY_ERROR:
+	default:
+		return InvalidValueError{
+			Node:     nil,
+			Field:    "dedupPolicy",
+			Values:   []string{sequence.DedupPolicy},
+			Expected: fmt.Sprintf("%s or %s", DEDUP_POLICY_RETURN, DEDUP_POLICY_ERROR),
+		}
+	}
+
+	if sequence.DedupPolicy != "" && sequence.DedupKey == "" {
+		return MissingValueError{
+			Node:        nil,
+			Field:       "dedupKey",
+			Explanation: "required when dedupPolicy is set",
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type ValidWebhooksSequenceCheck struct{}
+
+/* Webhooks must have an http or https URL and valid events. */
+func (check ValidWebhooksSequenceCheck) CheckSequence(sequence Sequence) error {
+	for _, wh := range sequence.Webhooks {
+		if wh == nil || wh.URL == "" {
+			return MissingValueError{
+				Node:        nil,
+				Field:       "webhooks.url",
+				Explanation: "required for every webhook",
+			}
+		}
+		u, err := url.Parse(wh.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return InvalidValueError{
+				Node:     nil,
+				Field:    "webhooks.url",
+				Values:   []string{wh.URL},
+				Expected: "http or https URL",
+			}
+		}
+		for _, event := range wh.Events {
+			switch event {
+			case proto.SEQUENCE_EVENT_START, proto.SEQUENCE_EVENT_COMPLETE, proto.SEQUENCE_EVENT_FAIL:
+			default:
+				return InvalidValueError{
+					Node:     nil,
+					Field:    "webhooks.events",
+					Values:   []string{event},
+					Expected: fmt.Sprintf("%s, %s, or %s", proto.SEQUENCE_EVENT_START, proto.SEQUENCE_EVENT_COMPLETE, proto.SEQUENCE_EVENT_FAIL),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type ValidEscalationsSequenceCheck struct{}
+
+/* Escalations must have an http or https URL and a positive 'after' duration. */
+func (check ValidEscalationsSequenceCheck) CheckSequence(sequence Sequence) error {
+	for _, esc := range sequence.Escalations {
+		if esc == nil || esc.URL == "" {
+			return MissingValueError{
+				Node:        nil,
+				Field:       "escalations.url",
+				Explanation: "required for every escalation",
+			}
+		}
+		u, err := url.Parse(esc.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return InvalidValueError{
+				Node:     nil,
+				Field:    "escalations.url",
+				Values:   []string{esc.URL},
+				Expected: "http or https URL",
+			}
+		}
+		if esc.After == "" {
+			return MissingValueError{
+				Node:        nil,
+				Field:       "escalations.after",
+				Explanation: "required for every escalation",
+			}
+		}
+		d, err := time.ParseDuration(esc.After)
+		if err != nil || d <= 0 {
+			return InvalidValueError{
+				Node:     nil,
+				Field:    "escalations.after",
+				Values:   []string{esc.After},
+				Expected: "positive duration string like 30m",
+			}
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type ValidAssertsSequenceCheck struct{}
+
+/* Assertions must be valid expressions. */
+func (check ValidAssertsSequenceCheck) CheckSequence(sequence Sequence) error {
+	for _, a := range sequence.Asserts {
+		if _, err := expr.Parse(a); err != nil {
+			return InvalidValueError{
+				Node:     nil,
+				Field:    "assert",
+				Values:   []string{a},
+				Expected: fmt.Sprintf("valid expression (%s)", err),
+			}
+		}
+	}
+
+	return nil
+}
+
+/* ========================================================================== */
+type ValidFinallySequenceCheck struct{}
+
+/* Finally nodes must be jobs without 'each' or 'after', with names unique among
+ * the sequence nodes, and 'deps' only on other finally nodes. */
+func (check ValidFinallySequenceCheck) CheckSequence(sequence Sequence) error {
+	names := make([]string, 0, len(sequence.Finally))
+	for name := range sequence.Finally {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	duplicated := []string{}
+	for _, name := range names {
+		if _, ok := sequence.Nodes[name]; ok {
+			duplicated = append(duplicated, name)
+		}
+	}
+	if len(duplicated) > 0 {
+		return DuplicateValueError{
+			Node:        nil,
+			Field:       "finally",
+			Values:      duplicated,
+			Explanation: "finally nodes must have different names than nodes",
+		}
+	}
+
+	for _, name := range names {
+		node := sequence.Finally[name]
+		if !node.IsJob() {
+			category := "none"
+			if node.Category != nil {
+				category = *node.Category
+			}
+			return InvalidValueError{
+				Node:     &node.Name,
+				Field:    "category",
+				Values:   []string{category},
+				Expected: "job; only job nodes can be in finally",
+			}
+		}
+		if len(node.Each) > 0 {
+			return InvalidValueError{
+				Node:     &node.Name,
+				Field:    "each",
+				Values:   node.Each,
+				Expected: "no value; finally nodes cannot be expanded",
+			}
+		}
+		if len(node.After) > 0 {
+			return InvalidValueError{
+				Node:     &node.Name,
+				Field:    "after",
+				Values:   node.After,
+				Expected: "no value; use deps on other finally nodes",
+			}
+		}
+		for _, dep := range node.Dependencies {
+			if _, ok := sequence.Finally[dep]; !ok {
+				return InvalidValueError{
+					Node:     &node.Name,
+					Field:    "deps",
+					Values:   []string{dep},
+					Expected: "finally node; finally nodes run after all nodes",
+				}
+			}
+		}
+	}
+
+	return nil
+}
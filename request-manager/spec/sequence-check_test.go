@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/go-test/deep"
+
 	. "github.com/square/spincycle/v2/request-manager/spec"
 )
 
@@ -236,3 +238,517 @@ func TestFailNoDuplicateACLRolesSequenceCheck(t *testing.T) {
 	err := check.CheckSequence(sequence)
 	compareError(t, err, expectedErr, "accepted duplicated acl roles, expected error")
 }
+
+func TestFailDerivedArgsOnlyInRequestsSequenceCheck(t *testing.T) {
+	check := DerivedArgsOnlyInRequestsSequenceCheck{}
+	sequence := Sequence{
+		Name: seqA,
+		Args: SequenceArgs{
+			Derived: []*DerivedArg{
+				&DerivedArg{Name: &testVal, Template: "{{host}}"},
+			},
+		},
+	}
+	expectedErr := InvalidValueError{
+		Field:  "args.derived",
+		Values: []string{"1 derived args"},
+	}
+
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted derived args in non-request sequence, expected error")
+}
+
+func TestValidDerivedArgsSequenceCheck(t *testing.T) {
+	check := ValidDerivedArgsSequenceCheck{}
+	host, dc, fqdn, batch := "host", "dc", "fqdn", "batch"
+	sequence := Sequence{
+		Name:    seqA,
+		Request: true,
+		Args: SequenceArgs{
+			Required: []*Arg{
+				&Arg{Name: &host},
+			},
+			Derived: []*DerivedArg{
+				&DerivedArg{Name: &dc, Lookup: &Lookup{Arg: "host", Map: map[string]string{"db1": "east"}}},
+				&DerivedArg{Name: &fqdn, Template: "{{host}}.{{dc}}.example.com"},
+				&DerivedArg{Name: &batch, Expr: "len(fqdn) / 2"},
+			},
+		},
+	}
+	if err := check.CheckSequence(sequence); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	// Uses a derived arg after it
+	sequence.Args.Derived[1].Template = "{{host}}.{{batch}}.example.com"
+	expectedErr := InvalidValueError{
+		Field:  "args.derived",
+		Values: []string{"batch"},
+	}
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted derived arg using a later derived arg, expected error")
+
+	// Template and expr
+	sequence.Args.Derived[1].Expr = "1 + 1"
+	expectedErr = InvalidValueError{
+		Field:  "args.derived",
+		Values: []string{"fqdn"},
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted derived arg with template and expr, expected error")
+
+	// Invalid expr
+	sequence.Args.Derived[1] = &DerivedArg{Name: &fqdn, Expr: "host +"}
+	expectedErr = InvalidValueError{
+		Field:  "args.derived.expr",
+		Values: []string{"host +"},
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted derived arg with invalid expr, expected error")
+
+	// Lookup without map
+	sequence.Args.Derived[0].Lookup.Map = nil
+	expectedErr2 := MissingValueError{
+		Field: "args.derived.lookup",
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr2, "accepted derived arg lookup without map, expected error")
+}
+
+func TestDerivedArgDerive(t *testing.T) {
+	unknown := "unknown"
+	args := map[string]interface{}{"host": "db1", "count": 8}
+	tests := []struct {
+		arg    DerivedArg
+		expect interface{}
+	}{
+		{DerivedArg{Template: "{{host}}.example.com"}, "db1.example.com"},
+		{DerivedArg{Expr: "count / 4"}, float64(2)},
+		{DerivedArg{Lookup: &Lookup{Arg: "host", Map: map[string]string{"db1": "east"}}}, "east"},
+		{DerivedArg{Lookup: &Lookup{Arg: "host", Map: map[string]string{"db2": "west"}, Default: &unknown}}, "unknown"},
+	}
+	for _, test := range tests {
+		got, err := test.arg.Derive(args)
+		if err != nil {
+			t.Errorf("%+v: %s", test.arg, err)
+			continue
+		}
+		if got != test.expect {
+			t.Errorf("%+v: got %v, expected %v", test.arg, got, test.expect)
+		}
+	}
+
+	arg := DerivedArg{Lookup: &Lookup{Arg: "host", Map: map[string]string{"db2": "west"}}}
+	if _, err := arg.Derive(args); err == nil {
+		t.Error("no error for value not in lookup map without default, expected one")
+	}
+}
+
+func TestFailGlobalsNamedSequenceCheck(t *testing.T) {
+	check := GlobalsNamedSequenceCheck{}
+	sequence := Sequence{
+		Name:    seqA,
+		Request: true,
+		Globals: []*Arg{
+			&Arg{Name: nil},
+		},
+	}
+	expectedErr := MissingValueError{
+		Field: "globals.name",
+	}
+
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted global with no name, expected error")
+}
+
+func TestFailGlobalsOnlyInRequestsSequenceCheck(t *testing.T) {
+	check := GlobalsOnlyInRequestsSequenceCheck{}
+	sequence := Sequence{
+		Name: seqA,
+		Globals: []*Arg{
+			&Arg{Name: &testVal, Default: &testVal},
+		},
+	}
+	expectedErr := InvalidValueError{
+		Field:  "globals",
+		Values: []string{"1 globals"},
+	}
+
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted globals in non-request sequence, expected error")
+}
+
+func TestGlobalsHaveValuesSequenceCheck(t *testing.T) {
+	check := GlobalsHaveValuesSequenceCheck{}
+	region := "region"
+	sequence := Sequence{
+		Name:    seqA,
+		Request: true,
+		Args: SequenceArgs{
+			Optional: []*Arg{
+				&Arg{Name: &region, Default: &testVal},
+			},
+		},
+		Globals: []*Arg{
+			&Arg{Name: &region},
+			&Arg{Name: &testVal, Default: &testVal},
+		},
+	}
+	if err := check.CheckSequence(sequence); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	sequence.Args.Optional = nil
+	expectedErr := MissingValueError{
+		Field: "globals.default",
+	}
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted global that is not a request arg and has no default, expected error")
+}
+
+func TestFailNoDuplicateGlobalsSequenceCheck(t *testing.T) {
+	check := NoDuplicateGlobalsSequenceCheck{}
+	sequence := Sequence{
+		Name:    seqA,
+		Request: true,
+		Globals: []*Arg{
+			&Arg{Name: &testVal, Default: &testVal},
+			&Arg{Name: &testVal, Default: &testVal},
+		},
+	}
+	expectedErr := DuplicateValueError{
+		Field:  "globals.name",
+		Values: []string{testVal},
+	}
+
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted duplicate globals, expected error")
+}
+
+func TestFailDedupKeyOnlyInRequestsSequenceCheck(t *testing.T) {
+	check := DedupKeyOnlyInRequestsSequenceCheck{}
+	sequence := Sequence{
+		Name:     seqA,
+		DedupKey: "restart",
+	}
+	expectedErr := InvalidValueError{
+		Field:  "dedupKey",
+		Values: []string{"restart"},
+	}
+
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted dedupKey in non-request sequence, expected error")
+}
+
+func TestFailStrictFailureOnlyInRequestsSequenceCheck(t *testing.T) {
+	check := StrictFailureOnlyInRequestsSequenceCheck{}
+	sequence := Sequence{
+		Name:          seqA,
+		StrictFailure: true,
+	}
+	expectedErr := InvalidValueError{
+		Field:  "strictFailure",
+		Values: []string{"true"},
+	}
+
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted strictFailure in non-request sequence, expected error")
+
+	sequence.Request = true
+	if err := check.CheckSequence(sequence); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+}
+
+func TestFailOverridesOnlyInRequestsSequenceCheck(t *testing.T) {
+	check := OverridesOnlyInRequestsSequenceCheck{}
+	sequence := Sequence{
+		Name:      seqA,
+		Overrides: []*Override{{Name: "new-lb"}},
+	}
+	expectedErr := InvalidValueError{
+		Field:  "overrides",
+		Values: []string{"1 overrides"},
+	}
+
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted overrides in non-request sequence, expected error")
+
+	sequence.Request = true
+	if err := check.CheckSequence(sequence); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+}
+
+func TestFailValidOverridesSequenceCheck(t *testing.T) {
+	check := ValidOverridesSequenceCheck{}
+	sequence := Sequence{
+		Name:    seqA,
+		Request: true,
+		Overrides: []*Override{
+			{Name: "new-lb", Values: []string{"on", "off"}},
+			{Name: "dns-endpoint", Pattern: `https://[a-z0-9.-]+\.staging\.example\.com`},
+		},
+	}
+	if err := check.CheckSequence(sequence); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	sequence.Overrides[1].Name = "new-lb"
+	expectedErr := InvalidValueError{
+		Field:  "overrides.name",
+		Values: []string{"new-lb"},
+	}
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted duplicate override names, expected error")
+
+	sequence.Overrides[1].Name = ""
+	expectedErr2 := MissingValueError{
+		Field: "overrides.name",
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr2, "accepted override without name, expected error")
+
+	sequence.Overrides[1] = &Override{Name: "dns-endpoint", Pattern: "https://(.*"}
+	expectedErr = InvalidValueError{
+		Field:  "overrides.pattern",
+		Values: []string{"https://(.*"},
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted invalid override pattern, expected error")
+
+	sequence.Overrides[1] = &Override{Name: "mode", Values: []string{"fast", "SLOW"}, Pattern: "[a-z]+"}
+	expectedErr = InvalidValueError{
+		Field:  "overrides.values",
+		Values: []string{"SLOW"},
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted override value that does not match pattern, expected error")
+}
+
+func TestCheckOverrides(t *testing.T) {
+	sequence := Sequence{
+		Name:    seqA,
+		Request: true,
+		Overrides: []*Override{
+			{Name: "new-lb", Values: []string{"on", "off"}},
+			{Name: "dns-endpoint", Pattern: `https://[a-z0-9.-]+\.staging\.example\.com`},
+		},
+	}
+	ok := []map[string]string{
+		nil,
+		{"new-lb": "on"},
+		{"new-lb": "off", "dns-endpoint": "https://dns.staging.example.com"},
+	}
+	for _, overrides := range ok {
+		if err := sequence.CheckOverrides(overrides); err != nil {
+			t.Errorf("%v: got error %s, expected nil", overrides, err)
+		}
+	}
+	notOk := []map[string]string{
+		{"new-lb": "maybe"},
+		{"log-level": "debug"},
+		{"dns-endpoint": "https://dns.example.com"},
+		{"dns-endpoint": "https://dns.staging.example.com.evil.com"}, // whole value must match
+	}
+	for _, overrides := range notOk {
+		if err := sequence.CheckOverrides(overrides); err == nil {
+			t.Errorf("%v: no error, expected one", overrides)
+		}
+	}
+}
+
+func TestDedupKeyArgsSequenceCheck(t *testing.T) {
+	check := DedupKeyArgsSequenceCheck{}
+	host := "host"
+	sequence := Sequence{
+		Name:    seqA,
+		Request: true,
+		Args: SequenceArgs{
+			Required: []*Arg{
+				&Arg{Name: &host},
+			},
+		},
+		DedupKey: "restart-{{host}}",
+	}
+	if err := check.CheckSequence(sequence); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	sequence.DedupKey = "restart-{{ host }}-{{port}}"
+	expectedErr := InvalidValueError{
+		Field:  "dedupKey",
+		Values: []string{"port"},
+	}
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted dedupKey arg that is not a request arg, expected error")
+}
+
+func TestFailDedupPolicySequenceCheck(t *testing.T) {
+	check := DedupPolicySequenceCheck{}
+	sequence := Sequence{
+		Name:        seqA,
+		Request:     true,
+		DedupKey:    "restart",
+		DedupPolicy: "ignore",
+	}
+	expectedErr := InvalidValueError{
+		Field:  "dedupPolicy",
+		Values: []string{"ignore"},
+	}
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted invalid dedupPolicy, expected error")
+
+	sequence.DedupKey = ""
+	sequence.DedupPolicy = DEDUP_POLICY_ERROR
+	expectedErr2 := MissingValueError{
+		Field: "dedupKey",
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr2, "accepted dedupPolicy without dedupKey, expected error")
+}
+
+func TestMakeDedupKey(t *testing.T) {
+	sequence := Sequence{
+		DedupKey: "restart-{{host}}:{{ port }}{{missing}}",
+	}
+	if diff := deep.Equal(sequence.DedupKeyArgs(), []string{"host", "port", "missing"}); diff != nil {
+		t.Error(diff)
+	}
+	key := sequence.MakeDedupKey(map[string]interface{}{"host": "db1", "port": 3306})
+	if key != "restart-db1:3306" {
+		t.Errorf("got key %q, expected restart-db1:3306", key)
+	}
+}
+
+func TestFailValidWebhooksSequenceCheck(t *testing.T) {
+	check := ValidWebhooksSequenceCheck{}
+	sequence := Sequence{
+		Name:     seqA,
+		Webhooks: []*Webhook{{URL: "https://hooks.example.com/seq", Events: []string{"start", "done"}}},
+	}
+	expectedErr := InvalidValueError{
+		Field:  "webhooks.events",
+		Values: []string{"done"},
+	}
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted invalid webhook event, expected error")
+
+	sequence.Webhooks[0].URL = "hooks.example.com/seq"
+	expectedErr = InvalidValueError{
+		Field:  "webhooks.url",
+		Values: []string{"hooks.example.com/seq"},
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted webhook url without scheme, expected error")
+
+	sequence.Webhooks[0].URL = ""
+	expectedErr2 := MissingValueError{
+		Field: "webhooks.url",
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr2, "accepted webhook without url, expected error")
+}
+
+func TestFailValidEscalationsSequenceCheck(t *testing.T) {
+	check := ValidEscalationsSequenceCheck{}
+	sequence := Sequence{
+		Name: seqA,
+		Escalations: []*Escalation{
+			{URL: "https://pager.example.com/oncall", After: "30m"},
+			{URL: "https://pager.example.com/manager", After: "0s"},
+		},
+	}
+	expectedErr := InvalidValueError{
+		Field:  "escalations.after",
+		Values: []string{"0s"},
+	}
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted zero escalation after, expected error")
+
+	sequence.Escalations[1].After = ""
+	expectedErr2 := MissingValueError{
+		Field: "escalations.after",
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr2, "accepted escalation without after, expected error")
+
+	sequence.Escalations[1].URL = "pager.example.com/manager"
+	expectedErr = InvalidValueError{
+		Field:  "escalations.url",
+		Values: []string{"pager.example.com/manager"},
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted escalation url without scheme, expected error")
+
+	sequence.Escalations = sequence.Escalations[:1]
+	if err := check.CheckSequence(sequence); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+}
+
+func TestFailValidAssertsSequenceCheck(t *testing.T) {
+	check := ValidAssertsSequenceCheck{}
+	sequence := Sequence{
+		Name:    seqA,
+		Asserts: []string{"len(failed_hosts) == 0", "len(failed_hosts = 0"},
+	}
+	expectedErr := InvalidValueError{
+		Field:  "assert",
+		Values: []string{"len(failed_hosts = 0"},
+	}
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted invalid assertion, expected error")
+
+	sequence.Asserts = sequence.Asserts[:1]
+	if err := check.CheckSequence(sequence); err != nil {
+		t.Errorf("error for valid assertion: %s", err)
+	}
+}
+
+func TestFailValidFinallySequenceCheck(t *testing.T) {
+	check := ValidFinallySequenceCheck{}
+	job := "job"
+	seq := "sequence"
+	sequence := Sequence{
+		Name: seqA,
+		Nodes: map[string]*Node{
+			nodeA: &Node{Name: nodeA, Category: &job},
+		},
+		Finally: map[string]*Node{
+			"unlock":    &Node{Name: "unlock", Category: &job},
+			"alerts-on": &Node{Name: "alerts-on", Category: &job, Dependencies: []string{"unlock"}},
+		},
+	}
+	if err := check.CheckSequence(sequence); err != nil {
+		t.Errorf("error for valid finally: %s", err)
+	}
+
+	sequence.Finally["alerts-on"].Dependencies = []string{nodeA}
+	expectedErr := InvalidValueError{
+		Node:   &sequence.Finally["alerts-on"].Name,
+		Field:  "deps",
+		Values: []string{nodeA},
+	}
+	err := check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted finally node deps on sequence node, expected error")
+
+	sequence.Finally["alerts-on"].Dependencies = nil
+	sequence.Finally["unlock"].Category = &seq
+	expectedErr = InvalidValueError{
+		Node:   &sequence.Finally["unlock"].Name,
+		Field:  "category",
+		Values: []string{"sequence"},
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr, "accepted finally sequence node, expected error")
+
+	sequence.Finally["unlock"].Category = &job
+	sequence.Finally[nodeA] = &Node{Name: nodeA, Category: &job}
+	expectedErr2 := DuplicateValueError{
+		Field:  "finally",
+		Values: []string{nodeA},
+	}
+	err = check.CheckSequence(sequence)
+	compareError(t, err, expectedErr2, "accepted finally node with same name as node, expected error")
+}
@@ -15,20 +15,60 @@
 
 package spec
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/square/spincycle/v2/expr"
+)
+
+// Dedup policies (dedupPolicy) for creating a request with the same dedup key
+// as an unfinished request.
+const (
+	DEDUP_POLICY_RETURN = "return" // return the unfinished request (default)
+	DEDUP_POLICY_ERROR  = "error"  // error, HTTP 409 conflict
+)
+
 // Nodes in a sequence.
 type Node struct {
 	Name         string            `yaml:"-"`         // unique name assigned to this node
 	Category     *string           `yaml:"category"`  // "job", "sequence", or "conditional"
 	NodeType     *string           `yaml:"type"`      // the type of job or sequence to create
+	Desc         string            `yaml:"desc"`      // human-readable description shown in status (optional)
 	Each         []string          `yaml:"each"`      // arguments to repeat over
 	Args         []*NodeArg        `yaml:"args"`      // expected arguments
 	Parallel     *uint             `yaml:"parallel"`  // max number of sequences to run in parallel
+	GroupBy      string            `yaml:"groupBy"`   // each element (field) to group sequences by, running one group at a time
+	Pace         bool              `yaml:"pace"`      // slow starting sequences when their jobs report throttling (job.Paced)
 	Sets         []*NodeSet        `yaml:"sets"`      // expected job args to be set
 	Dependencies []string          `yaml:"deps"`      // nodes with out-edges leading to this node
+	After        []string          `yaml:"after"`     // job args whose setting nodes have out-edges leading to this node
 	Retry        uint              `yaml:"retry"`     // the number of times to retry a "job" that fails
 	RetryWait    string            `yaml:"retryWait"` // the time to sleep between "job" retries
+	RetryArgs    map[string]string `yaml:"retryArgs"` // jobArg overrides given to the "job" on retries
+	KeepData     bool              `yaml:"keepData"`  // keep "job" data changes on sequence retry
+	Weight       uint              `yaml:"weight"`    // relative amount of work of the "job", for request progress (optional)
+	Skippable    bool              `yaml:"skippable"` // "sequence" or "conditional" is safe to skip when it fails
 	If           *string           `yaml:"if"`        // the name of the jobArg to check for a conditional value
 	Eq           map[string]string `yaml:"eq"`        // conditional values mapping to appropriate sequence names
+
+	// Singleton jobs: only one "job" of the type (or type and singletonKey
+	// arg value) runs at a time across all Job Runners
+	Singleton       bool   `yaml:"singleton"`       // run only one at a time
+	SingletonKey    string `yaml:"singletonKey"`    // jobArg to lock on with the type (optional)
+	SingletonPolicy string `yaml:"singletonPolicy"` // proto.SINGLETON_POLICY_* const (optional, default: queue)
+
+	// Job Runner capabilities (registration.capabilities) required to run the "job",
+	// like docker or gpu. The job chain runs on a Job Runner that has them all.
+	Needs []string `yaml:"needs"`
+
+	// True for nodes in the sequence finally: block (Sequence.Finally). Set by
+	// ProcessSpecs, not in the yaml file.
+	Finally bool `yaml:"-"`
 }
 
 // A node's args (i.e. the `args` field).
@@ -45,12 +85,22 @@ type NodeSet struct {
 
 // A single sequence.
 type Sequence struct {
-	Name     string           `yaml:"-"`       // name of the sequence
-	Args     SequenceArgs     `yaml:"args"`    // arguments to the sequence
-	Nodes    map[string]*Node `yaml:"nodes"`   // list of nodes that are a part of the sequence
-	Request  bool             `yaml:"request"` // whether or not the sequence spec is a user request
-	ACL      []ACL            `yaml:"acl"`     // allowed caller roles (optional)
-	Filename string           `yaml:"_"`       // name of file this sequence was in
+	Name          string           `yaml:"-"`             // name of the sequence
+	Args          SequenceArgs     `yaml:"args"`          // arguments to the sequence
+	Desc          string           `yaml:"desc"`          // human-readable description of its jobs (optional)
+	Nodes         map[string]*Node `yaml:"nodes"`         // list of nodes that are a part of the sequence
+	Finally       map[string]*Node `yaml:"finally"`       // "job" nodes run after the nodes even if they fail or are stopped (optional)
+	Request       bool             `yaml:"request"`       // whether or not the sequence spec is a user request
+	ACL           []ACL            `yaml:"acl"`           // allowed caller roles (optional)
+	Globals       []*Arg           `yaml:"globals"`       // chain globals given to all jobs (optional, request only)
+	DedupKey      string           `yaml:"dedupKey"`      // key template like "restart-{{host}}" (optional, request only)
+	DedupPolicy   string           `yaml:"dedupPolicy"`   // DEDUP_POLICY_* const (optional, default: return)
+	Webhooks      []*Webhook       `yaml:"webhooks"`      // notified when the sequence starts, completes, or fails (optional)
+	Escalations   []*Escalation    `yaml:"escalations"`   // notified while gate jobs in the sequence wait for approval (optional)
+	Overrides     []*Override      `yaml:"overrides"`     // execution overrides allowed when the request is created (optional, request only)
+	Asserts       []string         `yaml:"assert"`        // expressions over jobArgs that must be true when the sequence completes (optional)
+	StrictFailure bool             `yaml:"strictFailure"` // fail on first failure that cannot be retried (optional, request only)
+	Filename      string           `yaml:"_"`             // name of file this sequence was in
 }
 
 // A sequence's arguments. A sequence can have required arguments; any arguments
@@ -60,9 +110,10 @@ type Sequence struct {
 // missing will not result in an error. Additionally optional arguments can
 // have default values that will be used if not explicitly given.
 type SequenceArgs struct {
-	Required []*Arg `yaml:"required"`
-	Optional []*Arg `yaml:"optional"`
-	Static   []*Arg `yaml:"static"`
+	Required []*Arg        `yaml:"required"`
+	Optional []*Arg        `yaml:"optional"`
+	Static   []*Arg        `yaml:"static"`
+	Derived  []*DerivedArg `yaml:"derived"` // request only
 }
 
 // A sequence's args.
@@ -72,6 +123,58 @@ type Arg struct {
 	Default *string `yaml:"default"`
 }
 
+// A derived request arg, computed from the other request args when the request
+// is created, so trivial derivations don't need a job. It's computed after the
+// required, optional, and static args, in order, so it can use the derived args
+// before it. Exactly one of Template, Expr, and Lookup must be set.
+type DerivedArg struct {
+	Name     *string `yaml:"name"`
+	Desc     string  `yaml:"desc"`
+	Template string  `yaml:"template"` // string template like "{{host}}.{{dc}}.example.com"
+	Expr     string  `yaml:"expr"`     // expression like "len(hosts) / 4" (package expr)
+	Lookup   *Lookup `yaml:"lookup"`   // value of another arg mapped by a static map
+}
+
+// A derived arg lookup: the value of Arg, as a string, is looked up in Map. If
+// it's not in Map, the value is Default, else it's an error.
+type Lookup struct {
+	Arg     string            `yaml:"arg"`
+	Map     map[string]string `yaml:"map"`
+	Default *string           `yaml:"default"` // optional
+}
+
+// A sequence webhook: the Job Runner POSTs a proto.SequenceEvent to the URL
+// when the sequence starts, completes, or fails. Args are the jobArgs sent in
+// the event, as set when the sequence is built, including args set by its jobs.
+type Webhook struct {
+	URL    string   `yaml:"url"`
+	Events []string `yaml:"events"` // proto.SEQUENCE_EVENT_* consts (optional, default: all)
+	Args   []string `yaml:"args"`   // jobArgs to send (optional)
+}
+
+// A step of a sequence escalation chain: the Job Runner POSTs a proto.SequenceEvent
+// (event approval-pending) to the URL when a gate job in the sequence has waited
+// for approval for After. Each step is notified at most once each time the gate
+// job runs. Escalations of the request sequence apply to every gate job in the
+// request, so they are the escalation chain of the request type.
+type Escalation struct {
+	After string `yaml:"after"` // duration string like "30m"
+	URL   string `yaml:"url"`
+}
+
+// An execution override that callers can set when creating the request
+// (proto.CreateRequest.Overrides), like a feature flag or an endpoint, so a
+// request can be tweaked without editing the spec. Every job gets the overrides
+// set for its request (job.UsesOverrides). Overrides not listed in the request
+// spec cannot be set. If Values is set, the value must be one of them, and if
+// Pattern is set, the whole value must match it.
+type Override struct {
+	Name    string   `yaml:"name"`
+	Desc    string   `yaml:"desc"`
+	Values  []string `yaml:"values"`  // allowed values (optional)
+	Pattern string   `yaml:"pattern"` // regexp that values must match (optional)
+}
+
 // A single role-based ACL entry. Every auth.Caller (from the
 // user-provided auth plugin Authenticate method) is authorized with a matching
 // ACL, else the request is denied with HTTP 401 unauthorized. Roles are
@@ -82,6 +185,23 @@ type ACL struct {
 	Ops   []string `yaml:"ops"`   // proto.REQUEST_OP_*
 }
 
+// A policy sets defaults for all sequences in a specs subdirectory (a namespace),
+// like a team's requests, so platform policies are not copied into every sequence.
+// It's the POLICY_FILE in the directory, and it applies to the sequences in the
+// directory and its subdirectories. Policies are merged into the sequences when
+// specs are loaded (ParseSpecsDir): nearer policies take precedence over policies
+// in parent directories, and values in sequence specs take precedence over all
+// policies, except webhooks, which are added.
+type Policy struct {
+	Retry             uint       `yaml:"retry"`             // retry of "job" nodes that do not set it
+	RetryWait         string     `yaml:"retryWait"`         // retryWait of "job" nodes that do not set it
+	SequenceRetry     uint       `yaml:"sequenceRetry"`     // retry of "sequence" and "conditional" nodes that do not set it
+	SequenceRetryWait string     `yaml:"sequenceRetryWait"` // retryWait of "sequence" and "conditional" nodes that do not set it
+	ACL               []ACL      `yaml:"acl"`               // acl of requests that do not set it
+	StrictFailure     bool       `yaml:"strictFailure"`     // strictFailure of requests
+	Webhooks          []*Webhook `yaml:"webhooks"`          // added to the webhooks of requests
+}
+
 // A collection of sequences. This can be all the sequences in a single yaml file.
 // It is also used to hold all sequences in the specs directory.
 // Also contains the user defined no-op job.
@@ -89,6 +209,65 @@ type Specs struct {
 	Sequences map[string]*Sequence `yaml:"sequences"`
 }
 
+// Version returns a hash of the spec of the sequence and every sequence it uses,
+// directly or through conditionals and other sequences. A request records the
+// version of the spec it was made from, so it changes only when one of these
+// sequences changes, not when other specs change or sequences are moved to
+// other files. It returns an empty string if the sequence does not exist.
+func (s Specs) Version(sequence string) string {
+	if _, ok := s.Sequences[sequence]; !ok {
+		return ""
+	}
+
+	// Find every sequence used by the sequence, inclusive
+	used := map[string]bool{sequence: true}
+	next := []string{sequence}
+	for len(next) > 0 {
+		seq, ok := s.Sequences[next[0]]
+		next = next[1:]
+		if !ok {
+			continue // nonexistent sequence, caught by checks
+		}
+		for _, node := range seq.Nodes {
+			var names []string
+			switch {
+			case node.IsSequence() && node.NodeType != nil:
+				names = append(names, *node.NodeType)
+			case node.IsConditional():
+				for _, name := range node.Eq {
+					names = append(names, name)
+				}
+			}
+			for _, name := range names {
+				if !used[name] {
+					used[name] = true
+					next = append(next, name)
+				}
+			}
+		}
+	}
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Hash the sequences in order of name. Map keys (node names) are sorted
+	// by json.Marshal, so the same specs always have the same hash.
+	h := sha256.New()
+	for _, name := range names {
+		seq, ok := s.Sequences[name]
+		if !ok {
+			continue
+		}
+		cp := *seq
+		cp.Filename = ""
+		bytes, _ := json.Marshal(cp) // cannot fail: no channels, funcs, etc.
+		fmt.Fprintf(h, "%s\n%s\n", name, bytes)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
 func (j *Node) IsJob() bool {
 	return j.Category != nil && *j.Category == "job"
 }
@@ -100,3 +279,137 @@ func (j *Node) IsSequence() bool {
 func (j *Node) IsConditional() bool {
 	return j.Category != nil && *j.Category == "conditional"
 }
+
+// templateArg matches an arg in a template (dedup key or derived arg): {{arg}}
+var templateArg = regexp.MustCompile(`{{\s*([^{}\s]+)\s*}}`)
+
+// templateArgs returns the names of the args in the template, in order of appearance.
+func templateArgs(tmpl string) []string {
+	var args []string
+	for _, m := range templateArg.FindAllStringSubmatch(tmpl, -1) {
+		args = append(args, m[1])
+	}
+	return args
+}
+
+// expandTemplate returns the template with every {{arg}} replaced by the value
+// of the arg. Args without a value are replaced by an empty string.
+func expandTemplate(tmpl string, args map[string]interface{}) string {
+	return templateArg.ReplaceAllStringFunc(tmpl, func(m string) string {
+		v := args[templateArg.FindStringSubmatch(m)[1]]
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprintf("%v", v)
+	})
+}
+
+// DedupKeyArgs returns the names of the args in the dedup key template,
+// in order of appearance.
+func (s *Sequence) DedupKeyArgs() []string {
+	return templateArgs(s.DedupKey)
+}
+
+// MakeDedupKey returns the dedup key template with every {{arg}} replaced by
+// the value of the arg, or an empty string if the sequence has no dedup key.
+// Args without a value are replaced by an empty string.
+func (s *Sequence) MakeDedupKey(args map[string]interface{}) string {
+	return expandTemplate(s.DedupKey, args)
+}
+
+// CheckOverrides returns an error if an override is not allowed by the sequence
+// spec (overrides:) or its value is not allowed.
+func (s *Sequence) CheckOverrides(overrides map[string]string) error {
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names) // report the same error every time
+	for _, name := range names {
+		var o *Override
+		for _, allowed := range s.Overrides {
+			if allowed != nil && allowed.Name == name {
+				o = allowed
+				break
+			}
+		}
+		if o == nil {
+			return fmt.Errorf("override %s not allowed by %s request spec", name, s.Name)
+		}
+		if err := o.Check(overrides[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check returns an error if the value is not one of Values or does not match
+// Pattern.
+func (o *Override) Check(value string) error {
+	if len(o.Values) > 0 {
+		found := false
+		for _, v := range o.Values {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("invalid value for override %s: %q: must be one of %v", o.Name, value, o.Values)
+		}
+	}
+	if o.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + o.Pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid pattern for override %s: %s", o.Name, err) // checked when specs are loaded
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("invalid value for override %s: %q: must match %s", o.Name, value, o.Pattern)
+		}
+	}
+	return nil
+}
+
+// Args returns the names of the args that the derived arg uses, in order of
+// appearance (expressions: sorted). It returns nil if the expression is invalid.
+func (a *DerivedArg) Args() []string {
+	switch {
+	case a.Template != "":
+		return templateArgs(a.Template)
+	case a.Expr != "":
+		e, err := expr.Parse(a.Expr)
+		if err != nil {
+			return nil
+		}
+		return e.Vars()
+	case a.Lookup != nil && a.Lookup.Arg != "":
+		return []string{a.Lookup.Arg}
+	}
+	return nil
+}
+
+// Derive returns the value of the derived arg computed from args, which must
+// include the args it uses. Templates and lookups are strings, and expressions
+// are any type (numbers are float64).
+func (a *DerivedArg) Derive(args map[string]interface{}) (interface{}, error) {
+	switch {
+	case a.Template != "":
+		return expandTemplate(a.Template, args), nil
+	case a.Expr != "":
+		e, err := expr.Parse(a.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return e.Value(args)
+	case a.Lookup != nil:
+		key := fmt.Sprintf("%v", args[a.Lookup.Arg])
+		if v, ok := a.Lookup.Map[key]; ok {
+			return v, nil
+		}
+		if a.Lookup.Default != nil {
+			return *a.Lookup.Default, nil
+		}
+		return nil, fmt.Errorf("%s=%s not in lookup map", a.Lookup.Arg, key)
+	}
+	return nil, fmt.Errorf("no template, expr, or lookup")
+}
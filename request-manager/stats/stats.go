@@ -0,0 +1,200 @@
+// Copyright 2020, Square, Inc.
+
+// Package stats provides historical job type analytics: failure rate, retry
+// rate, mean duration, and top errors per job type, computed from job logs over
+// fixed windows. Stats are computed periodically by the Request Manager (see
+// Manager.Aggregate) because the queries scan every job log in the window, so
+// the API returns stats that are at most a few minutes old.
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// TOP_ERRORS is the number of most common errors returned per job type.
+const TOP_ERRORS = 5
+
+// windows are the proto.STATS_WINDOW_* consts, in the order they're aggregated.
+var windows = []string{proto.STATS_WINDOW_HOUR, proto.STATS_WINDOW_DAY, proto.STATS_WINDOW_WEEK}
+
+var windowDuration = map[string]time.Duration{
+	proto.STATS_WINDOW_HOUR: time.Hour,
+	proto.STATS_WINDOW_DAY:  24 * time.Hour,
+	proto.STATS_WINDOW_WEEK: 7 * 24 * time.Hour,
+}
+
+// A Manager computes and returns job type stats.
+type Manager interface {
+	// Aggregate computes job type stats for every window. It is called
+	// periodically by the Request Manager server.
+	Aggregate() error
+
+	// JobTypes returns the job type stats last computed for the window, a
+	// proto.STATS_WINDOW_* const. If stats have not been computed for the
+	// window yet, they are computed before returning.
+	JobTypes(window string) (proto.JobTypeStats, error)
+
+	// Cached returns the job type stats last computed for the window, and
+	// true. Unlike JobTypes, it never computes stats: it returns false if
+	// they have not been computed for the window yet.
+	Cached(window string) (proto.JobTypeStats, bool)
+}
+
+type ManagerConfig struct {
+	DBConnector *sql.DB // reads job_log
+}
+
+type manager struct {
+	dbc   *sql.DB
+	mux   *sync.Mutex
+	stats map[string]proto.JobTypeStats // keyed on window
+}
+
+func NewManager(cfg ManagerConfig) Manager {
+	return &manager{
+		dbc:   cfg.DBConnector,
+		mux:   &sync.Mutex{},
+		stats: map[string]proto.JobTypeStats{},
+	}
+}
+
+func (m *manager) Aggregate() error {
+	for _, window := range windows {
+		stats, err := m.compute(window)
+		if err != nil {
+			return fmt.Errorf("window %s: %s", window, err)
+		}
+		m.mux.Lock()
+		m.stats[window] = stats
+		m.mux.Unlock()
+	}
+	return nil
+}
+
+func (m *manager) JobTypes(window string) (proto.JobTypeStats, error) {
+	if window == "" {
+		window = proto.STATS_WINDOW_DAY
+	}
+	if _, ok := windowDuration[window]; !ok {
+		return proto.JobTypeStats{}, serr.ValidationError{Message: fmt.Sprintf("invalid window %q: valid values are %s, %s, and %s",
+			window, proto.STATS_WINDOW_HOUR, proto.STATS_WINDOW_DAY, proto.STATS_WINDOW_WEEK)}
+	}
+
+	m.mux.Lock()
+	stats, ok := m.stats[window]
+	m.mux.Unlock()
+	if ok {
+		return stats, nil
+	}
+
+	stats, err := m.compute(window)
+	if err != nil {
+		return proto.JobTypeStats{}, err
+	}
+	m.mux.Lock()
+	m.stats[window] = stats
+	m.mux.Unlock()
+	return stats, nil
+}
+
+func (m *manager) Cached(window string) (proto.JobTypeStats, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	stats, ok := m.stats[window]
+	return stats, ok
+}
+
+// compute queries job_log for job type stats over the window ending now.
+func (m *manager) compute(window string) (proto.JobTypeStats, error) {
+	ctx := context.TODO()
+	now := time.Now().UTC()
+	since := now.Add(-windowDuration[window]).UnixNano()
+
+	// Inner query: one row per job with its tries, whether it failed (a try
+	// failed and no try completed), and its total run time. Outer query: sum
+	// jobs by type.
+	q := "SELECT type, COUNT(*), SUM(tries), SUM(failed), SUM(tries > 1), SUM(duration) FROM (" +
+		"SELECT type, COUNT(*) AS tries," +
+		" SUM(state = ?) > 0 AND SUM(state = ?) = 0 AS failed," +
+		" SUM(IF(started_at > 0 AND finished_at > started_at, finished_at - started_at, 0)) AS duration" +
+		" FROM job_log WHERE finished_at >= ? GROUP BY request_id, job_id, type" +
+		") j GROUP BY type"
+	rows, err := m.dbc.QueryContext(ctx, q, proto.STATE_FAIL, proto.STATE_COMPLETE, since)
+	if err != nil {
+		return proto.JobTypeStats{}, serr.NewDbError(err, "SELECT job_log")
+	}
+	defer rows.Close()
+
+	jobTypes := []proto.JobTypeStat{}
+	index := map[string]int{} // type => jobTypes index
+	for rows.Next() {
+		var s proto.JobTypeStat
+		var duration int64
+		if err := rows.Scan(&s.Type, &s.Jobs, &s.Tries, &s.FailedJobs, &s.RetriedJobs, &duration); err != nil {
+			return proto.JobTypeStats{}, err
+		}
+		if s.Jobs > 0 {
+			s.FailureRate = float64(s.FailedJobs) / float64(s.Jobs)
+			s.RetryRate = float64(s.RetriedJobs) / float64(s.Jobs)
+		}
+		if s.Tries > 0 {
+			s.MeanDuration = duration / int64(s.Tries)
+		}
+		index[s.Type] = len(jobTypes)
+		jobTypes = append(jobTypes, s)
+	}
+	if err := rows.Err(); err != nil {
+		return proto.JobTypeStats{}, serr.NewDbError(err, "SELECT job_log")
+	}
+	rows.Close() // must close before new queries
+
+	// Most common errors of failed tries by type, most common first
+	q = "SELECT type, LEFT(error, 200) AS e, COUNT(*) AS n FROM job_log" +
+		" WHERE finished_at >= ? AND state = ? AND error IS NOT NULL AND error != ''" +
+		" GROUP BY type, e ORDER BY type, n DESC, e"
+	rows, err = m.dbc.QueryContext(ctx, q, since, proto.STATE_FAIL)
+	if err != nil {
+		return proto.JobTypeStats{}, serr.NewDbError(err, "SELECT job_log")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jobType string
+		var ec proto.ErrorCount
+		if err := rows.Scan(&jobType, &ec.Error, &ec.Count); err != nil {
+			return proto.JobTypeStats{}, err
+		}
+		i, ok := index[jobType]
+		if !ok || len(jobTypes[i].TopErrors) == TOP_ERRORS {
+			continue
+		}
+		jobTypes[i].TopErrors = append(jobTypes[i].TopErrors, ec)
+	}
+	if err := rows.Err(); err != nil {
+		return proto.JobTypeStats{}, serr.NewDbError(err, "SELECT job_log")
+	}
+
+	// Flakiest job types first
+	sort.SliceStable(jobTypes, func(i, j int) bool {
+		if jobTypes[i].FailureRate != jobTypes[j].FailureRate {
+			return jobTypes[i].FailureRate > jobTypes[j].FailureRate
+		}
+		if jobTypes[i].RetryRate != jobTypes[j].RetryRate {
+			return jobTypes[i].RetryRate > jobTypes[j].RetryRate
+		}
+		return jobTypes[i].Type < jobTypes[j].Type
+	})
+
+	return proto.JobTypeStats{
+		Window:     window,
+		ComputedAt: now,
+		JobTypes:   jobTypes,
+	}, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2020, Square, Inc.
+
+package stats_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/stats"
+	testdb "github.com/square/spincycle/v2/request-manager/test/db"
+)
+
+var dbm testdb.Manager
+var dbc *sql.DB
+
+func setup(t *testing.T, dataFile string) string {
+	var err error
+	if dbm == nil {
+		dbm, err = testdb.NewManager()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbName, err := dbm.Create(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbm.Connect(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbc = db
+	return dbName
+}
+
+func teardown(t *testing.T, dbName string) {
+	if err := dbm.Destroy(dbName); err != nil {
+		t.Fatal(err)
+	}
+	dbc.Close()
+}
+
+// insertJobLogs inserts job logs relative to now because stats windows end now.
+func insertJobLogs(t *testing.T) {
+	now := time.Now()
+	jls := []struct {
+		requestId string
+		jobId     string
+		try       uint
+		jobType   string
+		state     byte
+		err       string
+		ago       time.Duration // finished ago
+		duration  time.Duration
+	}{
+		// restart j1: failed then completed = retried
+		{"statreq1____________", "j1__", 1, "restart", proto.STATE_FAIL, "timeout", 10 * time.Minute, time.Second},
+		{"statreq1____________", "j1__", 2, "restart", proto.STATE_COMPLETE, "", 9 * time.Minute, time.Second},
+		// restart j2: failed twice = retried and failed
+		{"statreq1____________", "j2__", 1, "restart", proto.STATE_FAIL, "timeout", 8 * time.Minute, time.Second},
+		{"statreq1____________", "j2__", 2, "restart", proto.STATE_FAIL, "host down", 7 * time.Minute, time.Second},
+		// stop j3: completed
+		{"statreq1____________", "j3__", 1, "stop", proto.STATE_COMPLETE, "", 6 * time.Minute, 2 * time.Second},
+		// restart j1 in another request 2 hours ago: only in 24h window
+		{"statreq2____________", "j1__", 1, "restart", proto.STATE_FAIL, "old error", 2 * time.Hour, 4 * time.Second},
+	}
+	q := "INSERT INTO job_log (request_id, job_id, name, try, type, state, started_at, finished_at, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	for _, jl := range jls {
+		finishedAt := now.Add(-jl.ago)
+		startedAt := finishedAt.Add(-jl.duration)
+		_, err := dbc.Exec(q, jl.requestId, jl.jobId, jl.jobId, jl.try, jl.jobType, jl.state, startedAt.UnixNano(), finishedAt.UnixNano(), jl.err)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// //////////////////////////////////////////////////////////////////////////
+// Tests
+// //////////////////////////////////////////////////////////////////////////
+
+func TestJobTypes(t *testing.T) {
+	dbName := setup(t, "")
+	defer teardown(t, dbName)
+	insertJobLogs(t)
+
+	m := stats.NewManager(stats.ManagerConfig{DBConnector: dbc})
+	if err := m.Aggregate(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.JobTypes(proto.STATS_WINDOW_HOUR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Window != proto.STATS_WINDOW_HOUR {
+		t.Errorf("got window %q, expected %q", got.Window, proto.STATS_WINDOW_HOUR)
+	}
+	if got.ComputedAt.IsZero() {
+		t.Error("ComputedAt not set")
+	}
+	expect := []proto.JobTypeStat{
+		{
+			Type:         "restart",
+			Jobs:         2,
+			Tries:        4,
+			FailedJobs:   1,
+			RetriedJobs:  2,
+			FailureRate:  0.5,
+			RetryRate:    1,
+			MeanDuration: int64(time.Second),
+			TopErrors: []proto.ErrorCount{
+				{Error: "timeout", Count: 2},
+				{Error: "host down", Count: 1},
+			},
+		},
+		{
+			Type:         "stop",
+			Jobs:         1,
+			Tries:        1,
+			MeanDuration: int64(2 * time.Second),
+		},
+	}
+	if diff := deep.Equal(got.JobTypes, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// 24h includes the older job. Window defaults to 24h.
+	got, err = m.JobTypes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Window != proto.STATS_WINDOW_DAY {
+		t.Errorf("got window %q, expected %q", got.Window, proto.STATS_WINDOW_DAY)
+	}
+	expect[0] = proto.JobTypeStat{
+		Type:         "restart",
+		Jobs:         3,
+		Tries:        5,
+		FailedJobs:   2,
+		RetriedJobs:  2,
+		FailureRate:  2.0 / 3.0,
+		RetryRate:    2.0 / 3.0,
+		MeanDuration: int64(8 * time.Second / 5),
+		TopErrors: []proto.ErrorCount{
+			{Error: "timeout", Count: 2},
+			{Error: "host down", Count: 1},
+			{Error: "old error", Count: 1},
+		},
+	}
+	if diff := deep.Equal(got.JobTypes, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestJobTypesInvalidWindow(t *testing.T) {
+	m := stats.NewManager(stats.ManagerConfig{})
+	_, err := m.JobTypes("1y")
+	if _, ok := err.(serr.ValidationError); !ok {
+		t.Errorf("got error %v (%T), expected serr.ValidationError", err, err)
+	}
+}
@@ -6,6 +6,7 @@ package status
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
@@ -27,8 +28,16 @@ const (
 )
 
 type Manager interface {
+	// Running returns running jobs and their requests. If StatusFilter.AsOf is
+	// set, it returns the jobs that were running then and their requests as
+	// they were then, from the job log and request state history.
 	Running(proto.StatusFilter) (proto.RunningStatus, error)
 	UpdateProgress(proto.RequestProgress) error
+
+	// Requests returns the status of the requests, in the order given, in one
+	// query. Duplicate IDs are returned once. IDs of requests that do not
+	// exist are returned in RequestsStatus.NotFound.
+	Requests(requestIds []string) (proto.RequestsStatus, error)
 }
 
 type manager struct {
@@ -44,6 +53,10 @@ func NewManager(dbc *sql.DB, jrClient jr.Client) Manager {
 }
 
 func (m *manager) Running(f proto.StatusFilter) (proto.RunningStatus, error) {
+	if !f.AsOf.IsZero() {
+		return m.runningAsOf(f)
+	}
+
 	var noStatus proto.RunningStatus // returned on error
 	ctx := context.TODO()
 
@@ -113,7 +126,7 @@ func (m *manager) Running(f proto.StatusFilter) (proto.RunningStatus, error) {
 		ids = append(ids, j.RequestId)
 	}
 
-	q := "SELECT request_id, type, state, user, created_at, started_at, finished_at, total_jobs, finished_jobs" +
+	q := "SELECT request_id, type, state, user, created_at, started_at, finished_at, total_jobs, finished_jobs, progress" +
 		" FROM requests WHERE request_id IN (" + inList(ids) + ")"
 	rows, err := m.dbc.QueryContext(ctx, q)
 	if err != nil {
@@ -135,6 +148,7 @@ func (m *manager) Running(f proto.StatusFilter) (proto.RunningStatus, error) {
 			&finishedAt,
 			&r.TotalJobs,
 			&r.FinishedJobs,
+			&r.Progress,
 		)
 		if err != nil {
 			return noStatus, err
@@ -151,6 +165,143 @@ func (m *manager) Running(f proto.StatusFilter) (proto.RunningStatus, error) {
 	return all, err
 }
 
+// runningAsOf returns the jobs that were running at f.AsOf and their requests as
+// they were then. Job tries that finished after f.AsOf are in the job log, and
+// jobs still running come from the JRs, if they started before f.AsOf. If
+// f.RequestId is set, the request is returned even if no jobs were running.
+func (m *manager) runningAsOf(f proto.StatusFilter) (proto.RunningStatus, error) {
+	var noStatus proto.RunningStatus // returned on error
+	ctx := context.TODO()
+	asOf := f.AsOf.UTC()
+	if asOf.After(time.Now()) {
+		return noStatus, serr.ValidationError{Message: fmt.Sprintf("asOf %s is in the future", asOf.Format(time.RFC3339))}
+	}
+	t := asOf.UnixNano() // job_log times
+
+	all := proto.RunningStatus{
+		Jobs:     []proto.JobStatus{},
+		Requests: map[string]proto.Request{},
+	}
+
+	q := "SELECT request_id, job_id, type, name, try, started_at FROM job_log" +
+		" WHERE finished_at > ? AND started_at > 0 AND started_at <= ?"
+	args := []interface{}{t, t}
+	if f.RequestId != "" {
+		q += " AND request_id = ?"
+		args = append(args, f.RequestId)
+	}
+	rows, err := m.dbc.QueryContext(ctx, q, args...)
+	if err != nil {
+		return noStatus, serr.NewDbError(err, "SELECT job_log")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		j := proto.JobStatus{State: proto.STATE_RUNNING}
+		if err := rows.Scan(&j.RequestId, &j.JobId, &j.Type, &j.Name, &j.Try, &j.StartedAt); err != nil {
+			return noStatus, err
+		}
+		all.Jobs = append(all.Jobs, j)
+	}
+	rows.Close() // must close before new queries
+
+	// Jobs running now that started before asOf were running then. Their
+	// real-time status is now, not then, so it's dropped.
+	now, err := m.Running(proto.StatusFilter{RequestId: f.RequestId})
+	if err != nil {
+		return noStatus, err
+	}
+	for _, j := range now.Jobs {
+		if j.StartedAt <= t {
+			j.Status = ""
+			all.Jobs = append(all.Jobs, j)
+		}
+	}
+	sort.Sort(proto.JobStatusByStartTime(all.Jobs))
+
+	ids := []string{}
+	if f.RequestId != "" {
+		ids = append(ids, f.RequestId)
+	}
+	for _, j := range all.Jobs {
+		if j.RequestId != f.RequestId {
+			ids = append(ids, j.RequestId)
+		}
+	}
+	for _, id := range ids {
+		if _, ok := all.Requests[id]; ok {
+			continue
+		}
+		r, err := m.requestAsOf(id, asOf)
+		if err != nil {
+			return noStatus, err
+		}
+		all.Requests[id] = r
+	}
+	return all, nil
+}
+
+// requestAsOf returns the request as it was at the given time: its state then,
+// started and finished times if before then, and jobs finished by then. The state
+// is the last state in request_state_history before then. Requests created before
+// state history have no history, so their state is inferred from their started
+// and finished times, which misses suspended requests.
+func (m *manager) requestAsOf(requestId string, asOf time.Time) (proto.Request, error) {
+	ctx := context.TODO()
+	r := proto.Request{}
+	var user sql.NullString
+	startedAt := mysql.NullTime{}
+	finishedAt := mysql.NullTime{}
+	q := "SELECT request_id, type, state, user, created_at, started_at, finished_at, total_jobs FROM requests WHERE request_id = ?"
+	err := m.dbc.QueryRowContext(ctx, q, requestId).Scan(
+		&r.Id,
+		&r.Type,
+		&r.State,
+		&user,
+		&r.CreatedAt,
+		&startedAt,
+		&finishedAt,
+		&r.TotalJobs,
+	)
+	switch {
+	case err == sql.ErrNoRows:
+		return r, serr.RequestNotFound{RequestId: requestId}
+	case err != nil:
+		return r, serr.NewDbError(err, "SELECT requests")
+	}
+	if r.CreatedAt.After(asOf) {
+		return r, serr.ValidationError{Message: fmt.Sprintf("request %s was created after %s", requestId, asOf.Format(time.RFC3339))}
+	}
+	r.User = user.String
+	if startedAt.Valid && !startedAt.Time.After(asOf) {
+		r.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid && !finishedAt.Time.After(asOf) {
+		r.FinishedAt = &finishedAt.Time
+	}
+
+	q = "SELECT state FROM request_state_history WHERE request_id = ? AND changed_at <= ? ORDER BY changed_at DESC, id DESC LIMIT 1"
+	err = m.dbc.QueryRowContext(ctx, q, requestId, asOf).Scan(&r.State)
+	switch {
+	case err == sql.ErrNoRows:
+		switch {
+		case r.FinishedAt != nil: // final state = current state
+		case r.StartedAt != nil:
+			r.State = proto.STATE_RUNNING
+		default:
+			r.State = proto.STATE_PENDING
+		}
+	case err != nil:
+		return r, serr.NewDbError(err, "SELECT request_state_history")
+	}
+
+	q = "SELECT COUNT(DISTINCT job_id) FROM job_log WHERE request_id = ? AND state = ? AND finished_at <= ?"
+	err = m.dbc.QueryRowContext(ctx, q, requestId, proto.STATE_COMPLETE, asOf.UnixNano()).Scan(&r.FinishedJobs)
+	if err != nil {
+		return r, serr.NewDbError(err, "SELECT job_log")
+	}
+	return r, nil
+}
+
 // ["a","b"] -> "'a','b'"
 func inList(vals []string) string {
 	in := ""
@@ -166,11 +317,14 @@ func inList(vals []string) string {
 
 func (m *manager) UpdateProgress(prg proto.RequestProgress) error {
 	ctx := context.TODO()
-	q := "UPDATE requests SET finished_jobs = ? WHERE request_id = ? AND state = ?"
+	// Progress only goes up while the request is running. A Job Runner resuming
+	// a suspended request starts counting again from the jobs in the SJC, so
+	// its first reports can be lower than the progress before it was suspended.
+	q := "UPDATE requests SET finished_jobs = ?, progress = GREATEST(progress, ?) WHERE request_id = ? AND state = ?"
 	var res sql.Result
 	err := retry.Do(DB_TRIES, DB_RETRY_WAIT, func() error {
 		var err error
-		res, err = m.dbc.ExecContext(ctx, q, prg.FinishedJobs, prg.RequestId, proto.STATE_RUNNING)
+		res, err = m.dbc.ExecContext(ctx, q, prg.FinishedJobs, prg.Progress, prg.RequestId, proto.STATE_RUNNING)
 		return err
 	}, nil)
 	if err != nil {
@@ -188,11 +342,97 @@ func (m *manager) UpdateProgress(prg proto.RequestProgress) error {
 	}
 
 	// Presuming the request ID is correct and request is running, cnt = 0 happens
-	// when finished_jobs = prg.FinishedJobs, i.e. no more finished jobs since last
-	// update. cnt = 1 happens when we increment finished jobs.
+	// when finished_jobs = prg.FinishedJobs and progress >= prg.Progress, i.e. no
+	// more finished jobs since last update. cnt = 1 happens when either changed.
 	return nil
 }
 
+func (m *manager) Requests(requestIds []string) (proto.RequestsStatus, error) {
+	status := proto.RequestsStatus{Requests: []proto.Request{}}
+	ids := []string{}
+	args := []interface{}{}
+	seen := map[string]bool{}
+	for _, id := range requestIds {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+		args = append(args, id)
+	}
+	if len(ids) == 0 {
+		return status, nil
+	}
+
+	ctx := context.TODO()
+	q := "SELECT request_id, type, state, user, created_at, started_at, finished_at, total_jobs, finished_jobs, progress, jr_url, args" +
+		" FROM requests r LEFT JOIN request_archives a USING (request_id)" +
+		" WHERE request_id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")"
+	found := map[string]proto.Request{}
+	err := retry.Do(DB_TRIES, DB_RETRY_WAIT, func() error {
+		rows, err := m.dbc.QueryContext(ctx, q, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				r            proto.Request
+				user         sql.NullString
+				jrURL        sql.NullString
+				startedAt    mysql.NullTime
+				finishedAt   mysql.NullTime
+				reqArgsBytes []byte
+			)
+			err := rows.Scan(
+				&r.Id,
+				&r.Type,
+				&r.State,
+				&user,
+				&r.CreatedAt,
+				&startedAt,
+				&finishedAt,
+				&r.TotalJobs,
+				&r.FinishedJobs,
+				&r.Progress,
+				&jrURL,
+				&reqArgsBytes,
+			)
+			if err != nil {
+				return err
+			}
+			r.User = user.String
+			r.JobRunnerURL = jrURL.String
+			if startedAt.Valid {
+				r.StartedAt = &startedAt.Time
+			}
+			if finishedAt.Valid {
+				r.FinishedAt = &finishedAt.Time
+			}
+			if len(reqArgsBytes) > 0 {
+				if err := json.Unmarshal(reqArgsBytes, &r.Args); err != nil {
+					return fmt.Errorf("cannot unmarshal args of request %s: %s", r.Id, err)
+				}
+			}
+			found[r.Id] = r
+		}
+		return rows.Err()
+	}, nil)
+	if err != nil {
+		return status, serr.NewDbError(err, "SELECT requests")
+	}
+
+	for _, id := range ids {
+		r, ok := found[id]
+		if !ok {
+			status.NotFound = append(status.NotFound, id)
+			continue
+		}
+		status.Requests = append(status.Requests, r)
+	}
+	return status, nil
+}
+
 func (m *manager) jrURLS() ([]string, error) {
 	// Make a list of the URLs of all JR hosts currently running any requests.
 	ctx := context.TODO()
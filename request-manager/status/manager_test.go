@@ -9,6 +9,7 @@ import (
 
 	"github.com/go-test/deep"
 
+	serr "github.com/square/spincycle/v2/errors"
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/request-manager/status"
 	rmtest "github.com/square/spincycle/v2/request-manager/test"
@@ -83,6 +84,69 @@ func TestIncrementFinishedJobs(t *testing.T) {
 	}
 }
 
+func TestUpdateProgressNotLower(t *testing.T) {
+	reqId := "454ae2f98a05cv16sdwt" // running
+	dbName := setup(t, rmtest.DataPath+"/request-default.sql")
+	defer teardown(t, dbName)
+
+	m := status.NewManager(dbc, &mock.JRClient{})
+
+	// Progress 50, then 25 reported by a JR that resumed the request: it
+	// stays 50 until the resumed request reports more
+	for _, p := range []uint{50, 25, 75} {
+		err := m.UpdateProgress(proto.RequestProgress{RequestId: reqId, FinishedJobs: 2, Progress: p})
+		if err != nil {
+			t.Error(err)
+		}
+		expect := p
+		if p == 25 {
+			expect = 50
+		}
+		var got uint
+		if err := dbc.QueryRow("SELECT progress FROM requests WHERE request_id=?", reqId).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != expect {
+			t.Errorf("reported progress %d: got progress %d, expected %d", p, got, expect)
+		}
+	}
+}
+
+func TestRequests(t *testing.T) {
+	dbName := setup(t, rmtest.DataPath+"/request-default.sql")
+	defer teardown(t, dbName)
+
+	m := status.NewManager(dbc, &mock.JRClient{})
+
+	// In the order given, once each, with missing IDs not found
+	ids := []string{"454ae2f98a05cv16sdwt", "nope", "0874a524aa1edn3ysp00", "454ae2f98a05cv16sdwt"}
+	got, err := m.Requests(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Requests) != 2 {
+		t.Fatalf("got %d requests, expected 2: %+v", len(got.Requests), got.Requests)
+	}
+	r := got.Requests[0]
+	if r.Id != "454ae2f98a05cv16sdwt" || r.State != proto.STATE_RUNNING || r.User != "finch" || r.TotalJobs != 4 || r.FinishedJobs != 1 || r.JobRunnerURL != "http://jr:0000" {
+		t.Errorf("got request %+v, expected 454ae2f98a05cv16sdwt running", r)
+	}
+	if got.Requests[1].Id != "0874a524aa1edn3ysp00" {
+		t.Errorf("got request %s, expected 0874a524aa1edn3ysp00", got.Requests[1].Id)
+	}
+	if diff := deep.Equal(got.NotFound, []string{"nope"}); diff != nil {
+		t.Error(diff)
+	}
+
+	got, err = m.Requests(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Requests) != 0 || len(got.NotFound) != 0 {
+		t.Errorf("got %+v, expected no requests", got)
+	}
+}
+
 func TestRunningJobRetried(t *testing.T) {
 	// A test copied from rm/manager_test.go when RM did status stuff:
 	//   Bug fix: RM does not report live status for retried jobs. Problem is:
@@ -149,3 +213,62 @@ func TestRunningJobRetried(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestRunningAsOf(t *testing.T) {
+	reqId := "asof0000000000000000" // used in this data file:
+	dbName := setup(t, rmtest.DataPath+"/status-as-of.sql")
+	defer teardown(t, dbName)
+
+	m := status.NewManager(dbc, &mock.JRClient{})
+	at := func(min, sec int) time.Time {
+		return time.Date(2020, 6, 1, 12, min, sec, 0, time.UTC)
+	}
+
+	// 12:05: request resumed, job b2 running its 2nd try, job a1 completed
+	got, err := m.Running(proto.StatusFilter{AsOf: at(5, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectJobs := []proto.JobStatus{
+		{
+			RequestId: reqId,
+			JobId:     "b2b2",
+			Type:      "fake",
+			Name:      "b",
+			StartedAt: at(4, 0).UnixNano(),
+			State:     proto.STATE_RUNNING,
+			Try:       2,
+		},
+	}
+	if diff := deep.Equal(got.Jobs, expectJobs); diff != nil {
+		t.Error(diff)
+	}
+	r, ok := got.Requests[reqId]
+	if !ok {
+		t.Fatalf("request %s not returned, expected it", reqId)
+	}
+	if r.State != proto.STATE_RUNNING || r.FinishedJobs != 1 || r.StartedAt == nil || r.FinishedAt != nil {
+		t.Errorf("got request %+v, expected running with 1 finished job, started but not finished", r)
+	}
+
+	// 12:03:30: request suspended, no jobs running, but request returned
+	// because it's filtered on
+	got, err = m.Running(proto.StatusFilter{RequestId: reqId, AsOf: at(3, 30)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Jobs) != 0 {
+		t.Errorf("got jobs %+v, expected none", got.Jobs)
+	}
+	if got.Requests[reqId].State != proto.STATE_SUSPENDED {
+		t.Errorf("request state = %s, expected SUSPENDED", proto.StateName[got.Requests[reqId].State])
+	}
+
+	// Before request was created
+	_, err = m.Running(proto.StatusFilter{RequestId: reqId, AsOf: time.Date(2020, 6, 1, 11, 0, 0, 0, time.UTC)})
+	switch err.(type) {
+	case serr.ValidationError:
+	default:
+		t.Errorf("error = %v, expected serr.ValidationError", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2020, Square, Inc.
+
+// Package stoppreview makes stop previews: what stopping a running request
+// would interrupt, so operators can see the blast radius before stopping it
+// (GET /api/v1/requests/{id}/stop-preview and spinc stop preview=true).
+package stoppreview
+
+import (
+	"sort"
+
+	"github.com/square/spincycle/v2/proto"
+)
+
+// New makes the stop preview of the request from its job chain (req.JobChain;
+// the preview is empty without it), its job log, and its running jobs. The latest try of each
+// job in the job log is its state. Running jobs of other requests are ignored.
+//
+// When a request is stopped, the Job Runner stops its running jobs, does not run
+// jobs that have not run, and runs the finally jobs of sequences that started
+// (see proto.Job.Finally). So a sequence that started but is not complete is left
+// partially complete.
+func New(req proto.Request, jls []proto.JobLog, running []proto.JobStatus) proto.StopPreview {
+	sp := proto.StopPreview{
+		RequestId: req.Id,
+		Type:      req.Type,
+		State:     req.State,
+		Running:   []proto.StopPreviewJob{},
+		Sequences: []proto.StopPreviewSequence{},
+		Finally:   []proto.StopPreviewJob{},
+	}
+	if req.JobChain == nil {
+		return sp
+	}
+	jobs := req.JobChain.Jobs
+
+	// Latest state of every job that ran, then running jobs
+	state := map[string]byte{}
+	latest := map[string]uint{}
+	for _, jl := range jls {
+		if _, ok := jobs[jl.JobId]; !ok {
+			continue
+		}
+		if t, ok := latest[jl.JobId]; !ok || jl.Try >= t {
+			latest[jl.JobId] = jl.Try
+			state[jl.JobId] = jl.State
+		}
+	}
+	for _, js := range running {
+		if js.RequestId != req.Id {
+			continue
+		}
+		job, ok := jobs[js.JobId]
+		if !ok {
+			continue
+		}
+		state[js.JobId] = proto.STATE_RUNNING
+		sp.Running = append(sp.Running, previewJob(job))
+	}
+
+	started := func(sequenceId string) bool {
+		_, ok := state[sequenceId]
+		return ok
+	}
+	seqs := map[string]*proto.StopPreviewSequence{}
+	for _, job := range jobs {
+		s, ran := state[job.Id]
+		if job.Finally {
+			if !ran && started(job.SequenceId) {
+				sp.Finally = append(sp.Finally, previewJob(job))
+			}
+			continue
+		}
+		if !ran {
+			sp.NotRun++
+		}
+		seq, ok := seqs[job.SequenceId]
+		if !ok {
+			seq = &proto.StopPreviewSequence{SequenceId: job.SequenceId}
+			if start, ok := jobs[job.SequenceId]; ok {
+				seq.Name = start.Name
+				seq.Skippable = start.SequenceSkippable
+			}
+			seqs[job.SequenceId] = seq
+		}
+		seq.Total++
+		if s == proto.STATE_COMPLETE {
+			seq.Complete++
+		}
+	}
+	for id, seq := range seqs {
+		if !started(id) || seq.Complete == seq.Total {
+			continue
+		}
+		sp.Sequences = append(sp.Sequences, *seq)
+	}
+
+	sort.Slice(sp.Running, func(i, j int) bool { return sp.Running[i].JobId < sp.Running[j].JobId })
+	sort.Slice(sp.Sequences, func(i, j int) bool { return sp.Sequences[i].SequenceId < sp.Sequences[j].SequenceId })
+	sort.Slice(sp.Finally, func(i, j int) bool { return sp.Finally[i].JobId < sp.Finally[j].JobId })
+	return sp
+}
+
+func previewJob(job proto.Job) proto.StopPreviewJob {
+	return proto.StopPreviewJob{
+		JobId:      job.Id,
+		Name:       job.Name,
+		Type:       job.Type,
+		SequenceId: job.SequenceId,
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2020, Square, Inc.
+
+package stoppreview_test
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/stoppreview"
+)
+
+func TestNew(t *testing.T) {
+	// Sequence job1 started: job1 -> job2 -> job3, finally job4
+	// Sequence job5 did not start: job5 -> job6, finally job7
+	// Sequence job8 is complete
+	req := proto.Request{
+		Id:    "req1",
+		Type:  "restart-host",
+		State: proto.STATE_RUNNING,
+		JobChain: &proto.JobChain{
+			RequestId: "req1",
+			Jobs: map[string]proto.Job{
+				"job1": {Id: "job1", Name: "get-hosts", Type: "get-hosts", SequenceId: "job1", SequenceSkippable: true},
+				"job2": {Id: "job2", Name: "restart", Type: "restart", SequenceId: "job1"},
+				"job3": {Id: "job3", Name: "check", Type: "check", SequenceId: "job1"},
+				"job4": {Id: "job4", Name: "unlock", Type: "unlock", SequenceId: "job1", Finally: true},
+				"job5": {Id: "job5", Name: "drain", Type: "drain", SequenceId: "job5"},
+				"job6": {Id: "job6", Name: "undrain", Type: "undrain", SequenceId: "job5"},
+				"job7": {Id: "job7", Name: "unlock", Type: "unlock", SequenceId: "job5", Finally: true},
+				"job8": {Id: "job8", Name: "notify", Type: "notify", SequenceId: "job8"},
+			},
+		},
+	}
+	jls := []proto.JobLog{
+		{RequestId: "req1", JobId: "job8", Try: 1, State: proto.STATE_COMPLETE},
+		{RequestId: "req1", JobId: "job1", Try: 2, State: proto.STATE_COMPLETE},
+		{RequestId: "req1", JobId: "job1", Try: 1, State: proto.STATE_FAIL},
+	}
+	running := []proto.JobStatus{
+		{RequestId: "req1", JobId: "job2", Name: "restart", Type: "restart", State: proto.STATE_RUNNING},
+		{RequestId: "req2", JobId: "job9", Name: "other", Type: "other", State: proto.STATE_RUNNING},
+	}
+
+	got := stoppreview.New(req, jls, running)
+	expect := proto.StopPreview{
+		RequestId: "req1",
+		Type:      "restart-host",
+		State:     proto.STATE_RUNNING,
+		Running: []proto.StopPreviewJob{
+			{JobId: "job2", Name: "restart", Type: "restart", SequenceId: "job1"},
+		},
+		Sequences: []proto.StopPreviewSequence{
+			{SequenceId: "job1", Name: "get-hosts", Complete: 1, Total: 3, Skippable: true},
+		},
+		Finally: []proto.StopPreviewJob{
+			{JobId: "job4", Name: "unlock", Type: "unlock", SequenceId: "job1"},
+		},
+		NotRun: 3, // job3, job5, job6
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,12 @@
+/*
+  This data is used by tests in the request-manager/cost package.
+*/
+
+INSERT INTO requests (request_id, type, user, created_at, started_at, finished_at, state, total_jobs, finished_jobs) VALUES
+("costreq1____________", 'stop-host', 'finch', '2020-06-01 10:00:00', '2020-06-01 10:00:01', '2020-06-01 10:10:00', 3, 2, 2),
+("costreq2____________", 'start-host', 'dn', '2020-06-02 10:00:00', '2020-06-02 10:00:01', NULL, 2, 2, 0);
+
+INSERT INTO job_costs (request_id, job_id, try, job_type, request_type, user, namespace, state, duration, finished_at) VALUES
+("costreq1____________", "a1b2", 1, "stop", "stop-host", "finch", "team1", 3, 2000000000, '2020-06-01 10:05:00'),
+("costreq1____________", "c3d4", 1, "check", "stop-host", "finch", "team1", 4, 1000000000, '2020-06-01 10:06:00'),
+("costreq1____________", "c3d4", 2, "check", "stop-host", "finch", "team1", 3, 1000000000, '2020-06-01 10:07:00');
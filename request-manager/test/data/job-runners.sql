@@ -0,0 +1,12 @@
+/*
+  This data is used by tests in the request-manager/registry package.
+*/
+
+-- a live JR with capability gpu running 5 of 5 job chains (at capacity)
+INSERT INTO job_runners (url, labels, capabilities, capacity, running, started_at, heartbeat_at) VALUES ("http://jr1:32307", '{"zone":"a"}', '["gpu"]', 5, 5, '2020-06-01 00:00:00', NOW(6));
+
+-- a live JR running 7 job chains, no capacity (no limit)
+INSERT INTO job_runners (url, labels, capacity, running, started_at, heartbeat_at) VALUES ("http://jr2:32307", NULL, 0, 7, '2020-06-01 00:00:00', NOW(6));
+
+-- a dead JR running 0 job chains
+INSERT INTO job_runners (url, labels, capacity, running, started_at, heartbeat_at) VALUES ("http://jr3:32307", NULL, 0, 0, '2020-06-01 00:00:00', '2020-06-01 00:00:00');
@@ -15,6 +15,9 @@ INSERT INTO job_log (request_id, job_id, name, try, type, state) VALUES ("454ae2
 ("454ae2f98a05cv16sdwt", "g012", "", 0, "fake", 1),
 ("454ae2f98a05cv16sdwt", "9sa1", "", 0, "fake", 4), -- failed on its only try
 ("454ae2f98a05cv16sdwt", "pzi8", "", 0, "fake", 1);
+INSERT INTO request_resources (resource, request_id, job_id) VALUES ("db01", "454ae2f98a05cv16sdwt", "590s"),
+("db01", "454ae2f98a05cv16sdwt", "9sa1"), -- same resource affected by two jobs
+("db02", "454ae2f98a05cv16sdwt", "9sa1");
 
 -- a completed request
 INSERT INTO requests (request_id, type, created_at, finished_at, state) VALUES ("93ec156e204ety45sgf0", 'something-else', '2017-09-13 02:00:00', '2017-09-13 04:00:00', 3);
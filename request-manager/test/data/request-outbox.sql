@@ -0,0 +1,30 @@
+/*
+  This data is used by tests of the request outbox in the request-manager/request package.
+*/
+
+-- a pending request in the outbox claimed by an RM that crashed after sending it to a JR
+INSERT INTO requests (request_id, type, created_at, state) VALUES ("abandoned_dispatch__", 'do-something', NOW(6), 1);
+INSERT INTO request_archives (request_id, create_request, args, job_chain) VALUES ("abandoned_dispatch__", '{}', '', '{"requestId":"abandoned_dispatch__","jobs":{"1q2w":{"id":"1q2w","type":"dummy","bytes":null,"state":1,"args":null,"data":null,"retry":0}},"adjacencyList":null,"state":1}');
+INSERT INTO request_outbox (request_id, jr_url, rm_host, tries, updated_at) VALUES ("abandoned_dispatch__", "http://jr1:32307", "dead_host", 1, '2017-09-13 00:00:00');
+
+-- a pending request in the outbox never sent to a JR
+INSERT INTO requests (request_id, type, created_at, state) VALUES ("unclaimed_dispatch__", 'do-something', NOW(6), 1);
+INSERT INTO request_archives (request_id, create_request, args, job_chain) VALUES ("unclaimed_dispatch__", '{}', '', '{"requestId":"unclaimed_dispatch__","jobs":{"3e4r":{"id":"3e4r","type":"dummy","bytes":null,"state":1,"args":null,"data":null,"retry":0}},"adjacencyList":null,"state":1}');
+INSERT INTO request_outbox (request_id) VALUES ("unclaimed_dispatch__");
+
+-- a pending request in the outbox claimed by an RM that's dispatching it
+INSERT INTO requests (request_id, type, created_at, state) VALUES ("claimed_dispatch____", 'do-something', NOW(6), 1);
+INSERT INTO request_archives (request_id, create_request, args, job_chain) VALUES ("claimed_dispatch____", '{}', '', '{"requestId":"claimed_dispatch____","jobs":{"5t6y":{"id":"5t6y","type":"dummy","bytes":null,"state":1,"args":null,"data":null,"retry":0}},"adjacencyList":null,"state":1}');
+INSERT INTO request_outbox (request_id, rm_host) VALUES ("claimed_dispatch____", "another_host");
+
+-- a pending request in the outbox for more than an hour
+INSERT INTO requests (request_id, type, created_at, state) VALUES ("old_dispatch________", 'do-something', '2017-09-13 00:00:00', 1);
+INSERT INTO request_archives (request_id, create_request, args, job_chain) VALUES ("old_dispatch________", '{}', '', '{"requestId":"old_dispatch________","jobs":{"7u8i":{"id":"7u8i","type":"dummy","bytes":null,"state":1,"args":null,"data":null,"retry":0}},"adjacencyList":null,"state":1}');
+INSERT INTO request_outbox (request_id, created_at) VALUES ("old_dispatch________", '2017-09-13 00:00:00');
+
+-- a running request still in the outbox
+INSERT INTO requests (request_id, type, created_at, started_at, state, jr_url) VALUES ("running_dispatch____", 'do-something', NOW(6), NOW(6), 2, "http://jr2:32307");
+INSERT INTO request_outbox (request_id) VALUES ("running_dispatch____");
+
+-- a pending request that was never put in the outbox
+INSERT INTO requests (request_id, type, created_at, state) VALUES ("orphan______________", 'do-something', '2017-09-13 00:00:00', 1);
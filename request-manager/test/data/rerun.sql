@@ -0,0 +1,27 @@
+/*
+  This data is used by TestRerun and TestRerunSkipSequence in the request-manager/request package.
+*/
+
+-- a failed request + job chain + job logs. Job chain:
+--      c3d4
+--     /    \
+-- a1b2      g7h8
+--     \    /
+--      e5f6 (failed)
+INSERT INTO requests (request_id, type, user, created_at, started_at, finished_at, state, total_jobs, finished_jobs) VALUES ("rerunfailed_________", 'some-type', 'john', '2020-04-01 00:00:00', '2020-04-01 00:00:01', '2020-04-01 00:10:00', 4, 4, 2);
+INSERT INTO request_archives (request_id, create_request, args, job_chain) VALUES ("rerunfailed_________", '{"Type":"some-type","Args":{"host":"h1"},"User":"john"}', '[{"Pos":0,"Name":"host","Desc":"","Type":"required","Given":true,"Default":null,"Value":"h1"}]', '{"requestId":"rerunfailed_________","jobs":{"a1b2":{"id":"a1b2","name":"a","type":"fake","state":1,"sequenceId":"a1b2","sequenceRetry":0},"c3d4":{"id":"c3d4","name":"c","type":"fake","state":1,"sequenceId":"a1b2","sequenceRetry":0},"e5f6":{"id":"e5f6","name":"e","type":"fake","state":1,"sequenceId":"a1b2","sequenceRetry":0},"g7h8":{"id":"g7h8","name":"g","type":"fake","state":1,"sequenceId":"a1b2","sequenceRetry":0}},"adjacencyList":{"a1b2":["c3d4","e5f6"],"c3d4":["g7h8"],"e5f6":["g7h8"]},"state":1,"globals":{"env":"prod"},"overrides":{"new-lb":"on"},"webhooks":[{"url":"http://hooks/seq","sequence":"a","startJobId":"a1b2","endJobId":"g7h8","jobIds":["a1b2","c3d4","e5f6","g7h8"]}],"escalations":[{"url":"http://pager/seq","after":"1h","step":1,"sequence":"a","startJobId":"a1b2","jobIds":["a1b2","c3d4","e5f6","g7h8"]}]}');
+INSERT INTO job_log (request_id, job_id, name, try, type, state, data) VALUES ("rerunfailed_________", "a1b2", "a", 1, "fake", 3, '{"host":"h1"}'),
+("rerunfailed_________", "c3d4", "c", 1, "fake", 3, '{"host":"h1","ip":"10.0.0.1"}'),
+("rerunfailed_________", "e5f6", "e", 1, "fake", 4, NULL);
+
+-- a failed request with a failed skippable sequence (s1s1). Job chain:
+-- a1b2 -> s1s1 -> x1x1 (failed) -> t1t1 -> z9z9
+INSERT INTO requests (request_id, type, user, created_at, started_at, finished_at, state, total_jobs, finished_jobs) VALUES ("rerunskipseq________", 'some-type', 'john', '2020-04-01 00:00:00', '2020-04-01 00:00:01', '2020-04-01 00:10:00', 4, 5, 2);
+INSERT INTO request_archives (request_id, create_request, args, job_chain) VALUES ("rerunskipseq________", '{"Type":"some-type","Args":{"host":"h1"},"User":"john"}', '[{"Pos":0,"Name":"host","Desc":"","Type":"required","Given":true,"Default":null,"Value":"h1"}]', '{"requestId":"rerunskipseq________","jobs":{"a1b2":{"id":"a1b2","name":"a","type":"noop","state":1,"sequenceId":"a1b2","sequenceRetry":0},"s1s1":{"id":"s1s1","name":"s","type":"noop","state":1,"sequenceId":"s1s1","sequenceRetry":0,"sequenceSkippable":true},"x1x1":{"id":"x1x1","name":"x","type":"fake","state":1,"sequenceId":"s1s1","sequenceRetry":0},"t1t1":{"id":"t1t1","name":"t","type":"noop","state":1,"sequenceId":"s1s1","sequenceRetry":0},"z9z9":{"id":"z9z9","name":"z","type":"noop","state":1,"sequenceId":"a1b2","sequenceRetry":0}},"adjacencyList":{"a1b2":["s1s1"],"s1s1":["x1x1"],"x1x1":["t1t1"],"t1t1":["z9z9"]},"state":1}');
+INSERT INTO job_log (request_id, job_id, name, try, type, state, data) VALUES ("rerunskipseq________", "a1b2", "a", 1, "noop", 3, '{"host":"h1"}'),
+("rerunskipseq________", "s1s1", "s", 1, "noop", 3, '{"host":"h1"}'),
+("rerunskipseq________", "x1x1", "x", 1, "fake", 4, '{"host":"h1","ip":"10.0.0.1"}');
+
+-- a running request
+INSERT INTO requests (request_id, type, user, created_at, started_at, state, total_jobs, jr_url) VALUES ("rerunrunning________", 'some-type', 'john', '2020-04-01 00:00:00', '2020-04-01 00:00:01', 2, 4, "http://jr:0000");
+INSERT INTO request_archives (request_id, create_request, args, job_chain) VALUES ("rerunrunning________", '{"Type":"some-type","Args":{"host":"h1"},"User":"john"}', '[{"Pos":0,"Name":"host","Desc":"","Type":"required","Given":true,"Default":null,"Value":"h1"}]', '{"requestId":"rerunrunning________","jobs":{"a1b2":{"id":"a1b2","name":"a","type":"fake","state":1,"sequenceId":"a1b2","sequenceRetry":0}},"adjacencyList":{},"state":1}');
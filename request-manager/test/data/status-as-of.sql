@@ -0,0 +1,15 @@
+/*
+  This data is used by tests in the request-manager/status package.
+*/
+
+-- a complete request that was suspended and resumed, with state history and
+-- job logs: job a1 completed, job b2 was stopped by suspend then completed
+INSERT INTO requests (request_id, type, user, created_at, started_at, finished_at, state, total_jobs, finished_jobs, jr_url) VALUES ("asof0000000000000000", 'do-something', 'finch', '2020-06-01 12:00:00', '2020-06-01 12:00:01', '2020-06-01 12:10:00', 3, 2, 2, "http://jr:0000");
+INSERT INTO request_state_history (request_id, state, changed_at) VALUES ("asof0000000000000000", 1, '2020-06-01 12:00:00'),
+                                                                         ("asof0000000000000000", 2, '2020-06-01 12:00:01'),
+                                                                         ("asof0000000000000000", 7, '2020-06-01 12:03:00'),
+                                                                         ("asof0000000000000000", 2, '2020-06-01 12:04:00'),
+                                                                         ("asof0000000000000000", 3, '2020-06-01 12:10:00');
+INSERT INTO job_log (request_id, job_id, name, try, type, state, started_at, finished_at) VALUES ("asof0000000000000000", "a1a1", "a", 1, "fake", 3, 1591012801000000000, 1591012920000000000),
+                                                                                               ("asof0000000000000000", "b2b2", "b", 1, "fake", 6, 1591012920000000000, 1591012980000000000),
+                                                                                               ("asof0000000000000000", "b2b2", "b", 2, "fake", 3, 1591013040000000000, 1591013400000000000);
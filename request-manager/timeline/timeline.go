@@ -0,0 +1,85 @@
+// Copyright 2020, Square, Inc.
+
+// Package timeline makes request timelines: when each job in a request ran, to
+// show where the time went in a long request (GET /api/v1/requests/{id}/timeline
+// and spinc timeline).
+package timeline
+
+import (
+	"sort"
+
+	"github.com/square/spincycle/v2/proto"
+)
+
+// New makes the timeline of the request from its job log and, if the request is
+// running, its running jobs. Job log entries for tries that did not run (zero
+// StartedAt) and running jobs of other requests are ignored.
+func New(req proto.Request, jls []proto.JobLog, running []proto.JobStatus) proto.RequestTimeline {
+	tl := proto.RequestTimeline{
+		RequestId:  req.Id,
+		Type:       req.Type,
+		State:      req.State,
+		StartedAt:  req.StartedAt,
+		FinishedAt: req.FinishedAt,
+		Jobs:       []proto.TimelineJob{},
+	}
+
+	byJob := map[string]*proto.TimelineJob{}
+	var ids []string
+	add := func(jobId, name, jobType string, try proto.TimelineTry) {
+		j, ok := byJob[jobId]
+		if !ok {
+			j = &proto.TimelineJob{
+				JobId: jobId,
+				Name:  name,
+				Type:  jobType,
+			}
+			byJob[jobId] = j
+			ids = append(ids, jobId)
+		}
+		j.Tries = append(j.Tries, try)
+	}
+	for _, jl := range jls {
+		if jl.StartedAt == 0 {
+			continue
+		}
+		add(jl.JobId, jl.Name, jl.Type, proto.TimelineTry{
+			Try:        jl.Try,
+			State:      jl.State,
+			StartedAt:  jl.StartedAt,
+			FinishedAt: jl.FinishedAt,
+		})
+	}
+	for _, js := range running {
+		if js.RequestId != req.Id || js.StartedAt == 0 {
+			continue
+		}
+		add(js.JobId, js.Name, js.Type, proto.TimelineTry{
+			Try:       js.Try,
+			State:     proto.STATE_RUNNING,
+			StartedAt: js.StartedAt,
+		})
+	}
+
+	for _, id := range ids {
+		j := byJob[id]
+		sort.Slice(j.Tries, func(i, k int) bool {
+			if j.Tries[i].StartedAt == j.Tries[k].StartedAt {
+				return j.Tries[i].Try < j.Tries[k].Try
+			}
+			return j.Tries[i].StartedAt < j.Tries[k].StartedAt
+		})
+		last := j.Tries[len(j.Tries)-1]
+		j.StartedAt = j.Tries[0].StartedAt
+		j.FinishedAt = last.FinishedAt
+		j.State = last.State
+		tl.Jobs = append(tl.Jobs, *j)
+	}
+	sort.Slice(tl.Jobs, func(i, k int) bool {
+		if tl.Jobs[i].StartedAt == tl.Jobs[k].StartedAt {
+			return tl.Jobs[i].JobId < tl.Jobs[k].JobId
+		}
+		return tl.Jobs[i].StartedAt < tl.Jobs[k].StartedAt
+	})
+	return tl
+}
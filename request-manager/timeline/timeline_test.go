@@ -0,0 +1,66 @@
+// Copyright 2020, Square, Inc.
+
+package timeline_test
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/timeline"
+)
+
+func TestNew(t *testing.T) {
+	req := proto.Request{
+		Id:    "req1",
+		Type:  "restart-host",
+		State: proto.STATE_RUNNING,
+	}
+	jls := []proto.JobLog{
+		// job2 failed then completed, logged out of order
+		{RequestId: "req1", JobId: "job2", Name: "restart", Type: "restart", Try: 2, State: proto.STATE_COMPLETE, StartedAt: 400, FinishedAt: 500},
+		{RequestId: "req1", JobId: "job2", Name: "restart", Type: "restart", Try: 1, State: proto.STATE_FAIL, StartedAt: 200, FinishedAt: 300},
+		{RequestId: "req1", JobId: "job1", Name: "get-hosts", Type: "get-hosts", Try: 1, State: proto.STATE_COMPLETE, StartedAt: 100, FinishedAt: 200},
+		// job4 did not run
+		{RequestId: "req1", JobId: "job4", Name: "notify", Type: "notify", Try: 1, State: proto.STATE_FAIL},
+	}
+	running := []proto.JobStatus{
+		{RequestId: "req1", JobId: "job3", Name: "check", Type: "check", Try: 1, StartedAt: 500, State: proto.STATE_RUNNING},
+		{RequestId: "req2", JobId: "job9", Name: "other", Type: "other", Try: 1, StartedAt: 100, State: proto.STATE_RUNNING},
+	}
+
+	got := timeline.New(req, jls, running)
+	expect := proto.RequestTimeline{
+		RequestId: "req1",
+		Type:      "restart-host",
+		State:     proto.STATE_RUNNING,
+		Jobs: []proto.TimelineJob{
+			{
+				JobId: "job1", Name: "get-hosts", Type: "get-hosts", State: proto.STATE_COMPLETE, StartedAt: 100, FinishedAt: 200,
+				Tries: []proto.TimelineTry{{Try: 1, State: proto.STATE_COMPLETE, StartedAt: 100, FinishedAt: 200}},
+			},
+			{
+				JobId: "job2", Name: "restart", Type: "restart", State: proto.STATE_COMPLETE, StartedAt: 200, FinishedAt: 500,
+				Tries: []proto.TimelineTry{
+					{Try: 1, State: proto.STATE_FAIL, StartedAt: 200, FinishedAt: 300},
+					{Try: 2, State: proto.STATE_COMPLETE, StartedAt: 400, FinishedAt: 500},
+				},
+			},
+			{
+				JobId: "job3", Name: "check", Type: "check", State: proto.STATE_RUNNING, StartedAt: 500,
+				Tries: []proto.TimelineTry{{Try: 1, State: proto.STATE_RUNNING, StartedAt: 500}},
+			},
+		},
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestNewNoJobs(t *testing.T) {
+	got := timeline.New(proto.Request{Id: "req1", State: proto.STATE_PENDING}, nil, nil)
+	if got.Jobs == nil || len(got.Jobs) != 0 {
+		t.Errorf("got jobs %v, expected empty list", got.Jobs)
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2020, Square, Inc.
+
+// Package token provides per-user API tokens. A caller authenticated by the
+// auth plugin creates a token which has the caller's name, limited to certain
+// ops and requests, and which expires. The roles of the token are the current
+// roles of its user, resolved on every authentication, so a token never has
+// roles its user lost. Tokens are stored hashed
+// (SHA-256), so the secret is only known to the caller. Callers authenticate
+// with a token by sending an "Authorization: Bearer <secret>" header, which the
+// API checks before the auth plugin.
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/rs/xid"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+)
+
+// SECRET_PREFIX prefixes every token secret, so tokens are easy to recognize,
+// for example by secret scanners.
+const SECRET_PREFIX = "spin_"
+
+var (
+	ErrInvalidToken = errors.New("invalid API token")
+	ErrExpired      = errors.New("API token expired")
+	ErrRevoked      = errors.New("API token revoked")
+)
+
+// A Manager creates, authenticates, and revokes API tokens.
+type Manager interface {
+	// Create creates a token for the caller with the given name. The returned
+	// token has the secret, which is not stored.
+	Create(user string, ct proto.CreateToken) (proto.Token, error)
+
+	// Authenticate returns the token for the secret with the current roles of
+	// its user. An error is returned if the token does not exist, expired, or
+	// was revoked, or if the roles cannot be resolved.
+	Authenticate(secret string) (proto.Token, error)
+
+	// Get returns the token, without the secret, or serr.TokenNotFound.
+	Get(id string) (proto.Token, error)
+
+	// List returns the user's tokens, without secrets, most recent first.
+	List(user string) ([]proto.Token, error)
+
+	// Revoke revokes the token. Revoking a revoked token is not an error.
+	Revoke(id string) error
+}
+
+type ManagerConfig struct {
+	DBConnector *sql.DB       // stores api_tokens
+	MaxTTL      time.Duration // default and max TTL for new tokens
+	Roles       RoleFunc      // optional: resolves token roles; tokens have no roles if nil
+}
+
+// RoleFunc returns the current roles of the user. It's auth.Manager.Roles.
+type RoleFunc func(user string) ([]string, error)
+
+type manager struct {
+	dbc    *sql.DB
+	maxTTL time.Duration
+	roles  RoleFunc
+}
+
+func NewManager(cfg ManagerConfig) Manager {
+	return &manager{
+		dbc:    cfg.DBConnector,
+		maxTTL: cfg.MaxTTL,
+		roles:  cfg.Roles,
+	}
+}
+
+func (m *manager) Create(user string, ct proto.CreateToken) (proto.Token, error) {
+	ttl := m.maxTTL
+	if ct.TTL != "" {
+		d, err := time.ParseDuration(ct.TTL)
+		if err != nil {
+			return proto.Token{}, serr.ValidationError{Message: fmt.Sprintf("invalid ttl %q: %s", ct.TTL, err)}
+		}
+		if d <= 0 || d > m.maxTTL {
+			return proto.Token{}, serr.ValidationError{Message: fmt.Sprintf("invalid ttl %q: must be greater than zero and at most %s", ct.TTL, m.maxTTL)}
+		}
+		ttl = d
+	}
+OPS:
+	for _, op := range ct.Ops {
+		for _, valid := range proto.REQUEST_OPS {
+			if op == valid {
+				continue OPS
+			}
+		}
+		return proto.Token{}, serr.ValidationError{Message: fmt.Sprintf("invalid op %q: valid ops are %s", op, strings.Join(proto.REQUEST_OPS, ", "))}
+	}
+
+	rnd := make([]byte, 32)
+	if _, err := rand.Read(rnd); err != nil {
+		return proto.Token{}, fmt.Errorf("cannot generate token secret: %s", err)
+	}
+	now := time.Now().UTC()
+	tok := proto.Token{
+		Id:        xid.New().String(),
+		Name:      ct.Name,
+		User:      user,
+		Ops:       ct.Ops,
+		Requests:  ct.Requests,
+		ReadOnly:  ct.ReadOnly,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		Secret:    SECRET_PREFIX + hex.EncodeToString(rnd),
+	}
+
+	opsBytes, _ := json.Marshal(tok.Ops)
+	requestsBytes, _ := json.Marshal(tok.Requests)
+	q := "INSERT INTO api_tokens (token_id, token_hash, name, user, ops, requests, read_only, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err := m.dbc.ExecContext(context.TODO(), q,
+		tok.Id,
+		hash(tok.Secret),
+		tok.Name,
+		tok.User,
+		opsBytes,
+		requestsBytes,
+		tok.ReadOnly,
+		tok.CreatedAt,
+		tok.ExpiresAt,
+	)
+	if err != nil {
+		return proto.Token{}, serr.NewDbError(err, "INSERT api_tokens")
+	}
+	return tok, nil
+}
+
+func (m *manager) Authenticate(secret string) (proto.Token, error) {
+	if !strings.HasPrefix(secret, SECRET_PREFIX) {
+		return proto.Token{}, ErrInvalidToken
+	}
+	tok, err := m.get("token_hash = ?", hash(secret))
+	if err != nil {
+		if _, ok := err.(serr.TokenNotFound); ok {
+			return tok, ErrInvalidToken
+		}
+		return tok, err
+	}
+	if tok.RevokedAt != nil {
+		return tok, ErrRevoked
+	}
+	if time.Now().After(tok.ExpiresAt) {
+		return tok, ErrExpired
+	}
+	if m.roles != nil {
+		roles, err := m.roles(tok.User)
+		if err != nil {
+			return tok, fmt.Errorf("cannot resolve roles of user %s: %s", tok.User, err)
+		}
+		tok.Roles = roles
+	}
+	return tok, nil
+}
+
+func (m *manager) Get(id string) (proto.Token, error) {
+	tok, err := m.get("token_id = ?", id)
+	if _, ok := err.(serr.TokenNotFound); ok {
+		return tok, serr.TokenNotFound{TokenId: id}
+	}
+	return tok, err
+}
+
+func (m *manager) List(user string) ([]proto.Token, error) {
+	q := "SELECT token_id, name, user, ops, requests, read_only, created_at, expires_at, revoked_at FROM api_tokens WHERE user = ? ORDER BY created_at DESC"
+	rows, err := m.dbc.QueryContext(context.TODO(), q, user)
+	if err != nil {
+		return nil, serr.NewDbError(err, "SELECT api_tokens")
+	}
+	defer rows.Close()
+	tokens := []proto.Token{}
+	for rows.Next() {
+		tok, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, serr.NewDbError(err, "SELECT api_tokens")
+	}
+	return tokens, nil
+}
+
+func (m *manager) Revoke(id string) error {
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+	q := "UPDATE api_tokens SET revoked_at = ? WHERE token_id = ? AND revoked_at IS NULL"
+	if _, err := m.dbc.ExecContext(context.TODO(), q, time.Now().UTC(), id); err != nil {
+		return serr.NewDbError(err, "UPDATE api_tokens")
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------------
+
+func (m *manager) get(where string, val interface{}) (proto.Token, error) {
+	q := "SELECT token_id, name, user, ops, requests, read_only, created_at, expires_at, revoked_at FROM api_tokens WHERE " + where
+	tok, err := scan(m.dbc.QueryRowContext(context.TODO(), q, val))
+	if err == sql.ErrNoRows {
+		return tok, serr.TokenNotFound{}
+	}
+	return tok, err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (proto.Token, error) {
+	var tok proto.Token
+	var opsBytes, requestsBytes []byte
+	var revokedAt mysql.NullTime
+	err := row.Scan(
+		&tok.Id,
+		&tok.Name,
+		&tok.User,
+		&opsBytes,
+		&requestsBytes,
+		&tok.ReadOnly,
+		&tok.CreatedAt,
+		&tok.ExpiresAt,
+		&revokedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return tok, err
+		}
+		return tok, serr.NewDbError(err, "SELECT api_tokens")
+	}
+	if revokedAt.Valid {
+		tok.RevokedAt = &revokedAt.Time
+	}
+	if err := json.Unmarshal(opsBytes, &tok.Ops); err != nil {
+		return tok, fmt.Errorf("cannot unmarshal token ops: %s", err)
+	}
+	if err := json.Unmarshal(requestsBytes, &tok.Requests); err != nil {
+		return tok, fmt.Errorf("cannot unmarshal token requests: %s", err)
+	}
+	return tok, nil
+}
+
+// hash returns the SHA-256 hash of the secret, which is what's stored.
+func hash(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
@@ -0,0 +1,268 @@
+// Copyright 2020, Square, Inc.
+
+package token_test
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+	testdb "github.com/square/spincycle/v2/request-manager/test/db"
+	"github.com/square/spincycle/v2/request-manager/token"
+)
+
+var dbm testdb.Manager
+var dbc *sql.DB
+
+func setup(t *testing.T) string {
+	var err error
+	if dbm == nil {
+		dbm, err = testdb.NewManager()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbName, err := dbm.Create("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbm.Connect(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbc = db
+	return dbName
+}
+
+func teardown(t *testing.T, dbName string) {
+	if err := dbm.Destroy(dbName); err != nil {
+		t.Fatal(err)
+	}
+	dbc.Close()
+}
+
+const maxTTL = 8 * time.Hour
+
+// //////////////////////////////////////////////////////////////////////////
+// Tests
+// //////////////////////////////////////////////////////////////////////////
+
+func TestCreateInvalid(t *testing.T) {
+	m := token.NewManager(token.ManagerConfig{MaxTTL: maxTTL}) // not saved, so no db
+	invalid := []struct {
+		name string
+		ct   proto.CreateToken
+	}{
+		{"bad ttl", proto.CreateToken{TTL: "soon"}},
+		{"zero ttl", proto.CreateToken{TTL: "0s"}},
+		{"negative ttl", proto.CreateToken{TTL: "-1h"}},
+		{"ttl > max", proto.CreateToken{TTL: "9h"}},
+		{"unknown op", proto.CreateToken{Ops: []string{"delete"}}},
+		{"unknown op after valid op", proto.CreateToken{Ops: []string{proto.REQUEST_OP_STOP, "Stop"}}},
+	}
+	for _, c := range invalid {
+		_, err := m.Create("dn", c.ct)
+		if _, ok := err.(serr.ValidationError); !ok {
+			t.Errorf("%s: got error %v, expected serr.ValidationError", c.name, err)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dbName := setup(t)
+	defer teardown(t, dbName)
+
+	m := token.NewManager(token.ManagerConfig{DBConnector: dbc, MaxTTL: maxTTL})
+
+	tests := []struct {
+		name string
+		ct   proto.CreateToken
+		ttl  time.Duration
+	}{
+		{"defaults", proto.CreateToken{Name: "laptop"}, maxTTL},
+		{"ttl", proto.CreateToken{TTL: "1h"}, time.Hour},
+		{"max ttl", proto.CreateToken{TTL: "8h"}, maxTTL},
+		{"all ops", proto.CreateToken{Ops: proto.REQUEST_OPS}, maxTTL},
+		{"add-job op", proto.CreateToken{Ops: []string{proto.REQUEST_OP_ADD_JOB}}, maxTTL},
+		{"approve op", proto.CreateToken{Ops: []string{proto.REQUEST_OP_APPROVE}}, maxTTL},
+		{"requests", proto.CreateToken{Requests: []string{"reboot", "st*"}}, maxTTL},
+		{"read-only", proto.CreateToken{ReadOnly: true}, maxTTL},
+	}
+	for _, c := range tests {
+		tok, err := m.Create("dn", c.ct)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if !strings.HasPrefix(tok.Secret, token.SECRET_PREFIX) {
+			t.Errorf("%s: secret %q does not have prefix %s", c.name, tok.Secret, token.SECRET_PREFIX)
+		}
+		if d := tok.ExpiresAt.Sub(tok.CreatedAt); d != c.ttl {
+			t.Errorf("%s: expires %s after created, expected %s", c.name, d, c.ttl)
+		}
+
+		// Only the hash of the secret is stored
+		var gotHash []byte
+		err = dbc.QueryRow("SELECT token_hash FROM api_tokens WHERE token_id = ?", tok.Id).Scan(&gotHash)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		expectHash := sha256.Sum256([]byte(tok.Secret))
+		if diff := deep.Equal(gotHash, expectHash[:]); diff != nil {
+			t.Errorf("%s: token_hash: %v", c.name, diff)
+		}
+
+		got, err := m.Get(tok.Id)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if got.Secret != "" {
+			t.Errorf("%s: got secret %q, expected no secret", c.name, got.Secret)
+		}
+		if got.Name != c.ct.Name || got.User != "dn" || got.ReadOnly != c.ct.ReadOnly {
+			t.Errorf("%s: got %+v, expected name %q, user dn, read-only %t", c.name, got, c.ct.Name, c.ct.ReadOnly)
+		}
+		if diff := deep.Equal(got.Ops, c.ct.Ops); diff != nil {
+			t.Errorf("%s: ops: %v", c.name, diff)
+		}
+		if diff := deep.Equal(got.Requests, c.ct.Requests); diff != nil {
+			t.Errorf("%s: requests: %v", c.name, diff)
+		}
+	}
+
+	list, err := m.List("dn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != len(tests) {
+		t.Errorf("listed %d tokens, expected %d", len(list), len(tests))
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	dbName := setup(t)
+	defer teardown(t, dbName)
+
+	// Roles are resolved on every Authenticate, not stored when created
+	roles := map[string][]string{"dn": []string{"eng"}}
+	m := token.NewManager(token.ManagerConfig{
+		DBConnector: dbc,
+		MaxTTL:      maxTTL,
+		Roles: func(user string) ([]string, error) {
+			r, ok := roles[user]
+			if !ok {
+				return nil, fmt.Errorf("unknown user %s", user)
+			}
+			return r, nil
+		},
+	})
+
+	valid, err := m.Create("dn", proto.CreateToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := m.Create("dn", proto.CreateToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Revoke(revoked.Id); err != nil {
+		t.Fatal(err)
+	}
+	expired, err := m.Create("dn", proto.CreateToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbc.Exec("UPDATE api_tokens SET expires_at = ? WHERE token_id = ?", time.Now().UTC().Add(-time.Second), expired.Id); err != nil {
+		t.Fatal(err)
+	}
+	unknownUser, err := m.Create("finch", proto.CreateToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		expectErr error
+	}{
+		{"valid", valid.Secret, nil},
+		{"no prefix", strings.TrimPrefix(valid.Secret, token.SECRET_PREFIX), token.ErrInvalidToken},
+		{"unknown secret", valid.Secret + "0", token.ErrInvalidToken},
+		{"revoked", revoked.Secret, token.ErrRevoked},
+		{"expired", expired.Secret, token.ErrExpired},
+	}
+	for _, c := range tests {
+		tok, err := m.Authenticate(c.secret)
+		if err != c.expectErr {
+			t.Errorf("%s: got error %v, expected %v", c.name, err, c.expectErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if tok.Id != valid.Id || tok.User != "dn" {
+			t.Errorf("%s: got token %s user %s, expected token %s user dn", c.name, tok.Id, tok.User, valid.Id)
+		}
+		if diff := deep.Equal(tok.Roles, []string{"eng"}); diff != nil {
+			t.Errorf("%s: roles: %v", c.name, diff)
+		}
+	}
+
+	// User roles change: token has the new roles
+	roles["dn"] = []string{"viewer"}
+	tok, err := m.Authenticate(valid.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(tok.Roles, []string{"viewer"}); diff != nil {
+		t.Errorf("roles not resolved again: %v", diff)
+	}
+
+	// Roles cannot be resolved: not authenticated
+	if _, err := m.Authenticate(unknownUser.Secret); err == nil {
+		t.Errorf("authenticated token of unknown user, expected an error")
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	dbName := setup(t)
+	defer teardown(t, dbName)
+
+	m := token.NewManager(token.ManagerConfig{DBConnector: dbc, MaxTTL: maxTTL})
+	tok, err := m.Create("dn", proto.CreateToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		id        string
+		expectErr error
+	}{
+		{"revoke", tok.Id, nil},
+		{"revoke again", tok.Id, nil},
+		{"not found", "b0gus", serr.TokenNotFound{TokenId: "b0gus"}},
+	}
+	for _, c := range tests {
+		if err := m.Revoke(c.id); err != c.expectErr {
+			t.Errorf("%s: got error %v, expected %v", c.name, err, c.expectErr)
+		}
+	}
+
+	got, err := m.Get(tok.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RevokedAt == nil {
+		t.Errorf("revoked at not set, expected token to be revoked")
+	}
+}
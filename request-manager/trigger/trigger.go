@@ -0,0 +1,392 @@
+// Copyright 2020, Square, Inc.
+
+// Package trigger starts requests on external messages, for event-driven
+// automation without a separate glue service. Each trigger (config triggers)
+// receives messages from a source: the built-in webhook receiver (the API calls
+// Manager.Receive) or a Source plugin, like an SQS queue or Kafka topic consumer
+// (Manager.Run receives from every plugin source). The JSON payload of each
+// message is mapped to request args with templates, and the request is created
+// and started by user "trigger:<name>".
+//
+// A message with the same dedup key as a previous message of the trigger is a
+// duplicate: it's ignored, and the request started by the previous message is
+// returned. Messages that cannot be processed are saved in the trigger error
+// queue and, for plugin sources, failed so the source can move them to its own
+// error queue (e.g. an SQS dead-letter queue).
+package trigger
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/config"
+	serr "github.com/square/spincycle/v2/errors"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/request"
+)
+
+var (
+	// How long to wait before receiving again after a Source error, or while
+	// the Request Manager is not accepting new requests.
+	ReceiveRetryWait = 1 * time.Second
+
+	// Max number of messages returned by Manager.Errors.
+	MaxErrors = 100
+)
+
+// A Source is a trigger source plugin: a consumer of a queue or stream, like an
+// SQS queue or Kafka topic. Sources are named by the Request Manager Plugins,
+// and triggers use them by name (config trigger source).
+type Source interface {
+	// Receive returns the next message. It should block until a message is
+	// received or the context is canceled (the Request Manager is stopping).
+	// If it returns an error, it's called again after ReceiveRetryWait.
+	Receive(ctx context.Context) (Message, error)
+}
+
+// Message is a message received from a Source.
+type Message struct {
+	// Id is the source message ID, used only for logging. Optional.
+	Id string
+
+	// Payload is the message body, a JSON object.
+	Payload []byte
+
+	// Ack is called after the message is processed: a request was started or
+	// it's a duplicate. The source should delete or commit the message. Optional.
+	Ack func()
+
+	// Fail is called if the message cannot be processed. The source should move
+	// it to its error queue. The message is also saved in the trigger error queue.
+	// Optional.
+	Fail func(error)
+}
+
+// A Manager receives messages and starts requests for triggers.
+type Manager interface {
+	// Receive processes a message payload received by the trigger: it maps the
+	// payload to request args, then creates and starts the request, unless the
+	// message is a duplicate. If the message cannot be processed, it's saved in
+	// the trigger error queue and the error is returned.
+	Receive(trigger string, payload []byte) (proto.TriggerResult, error)
+
+	// Run receives and processes messages from every Source plugin used by a
+	// trigger until the stop channel is closed. It does not receive messages
+	// while Accepting returns an error, so messages wait in their source during
+	// maintenance mode. All errors are logged, not returned.
+	Run(stopChan <-chan struct{})
+
+	// Errors returns the most recent messages, at most MaxErrors, in the trigger
+	// error queue, newest first.
+	Errors(trigger string) ([]proto.TriggerError, error)
+}
+
+type ManagerConfig struct {
+	Triggers       []config.Trigger
+	Sources        map[string]Source // Source plugins by name
+	RequestManager request.Manager
+	DBConnector    *sql.DB
+
+	// Accepting returns an error if new requests cannot be started, like in
+	// maintenance mode. Optional.
+	Accepting func() error
+}
+
+type manager struct {
+	triggers  map[string]*trigger
+	sources   map[string]Source
+	rm        request.Manager
+	dbc       *sql.DB
+	accepting func() error
+}
+
+// trigger is a config.Trigger with its parsed templates.
+type trigger struct {
+	cfg      config.Trigger
+	args     map[string]*template.Template
+	dedupKey *template.Template // nil if no dedup
+}
+
+// NewManager makes a Manager. It returns an error if a trigger template is
+// invalid or a trigger uses a Source plugin that does not exist.
+func NewManager(cfg ManagerConfig) (Manager, error) {
+	m := &manager{
+		triggers:  map[string]*trigger{},
+		sources:   map[string]Source{},
+		rm:        cfg.RequestManager,
+		dbc:       cfg.DBConnector,
+		accepting: cfg.Accepting,
+	}
+	for _, t := range cfg.Triggers {
+		if t.Source != config.TRIGGER_SOURCE_WEBHOOK {
+			src, ok := cfg.Sources[t.Source]
+			if !ok {
+				return nil, fmt.Errorf("trigger %s: source %s: no such trigger source plugin", t.Name, t.Source)
+			}
+			m.sources[t.Name] = src
+		}
+		tr := &trigger{
+			cfg:  t,
+			args: map[string]*template.Template{},
+		}
+		for arg, text := range t.Args {
+			tmpl, err := template.New(arg).Option("missingkey=error").Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("trigger %s: arg %s: %s", t.Name, arg, err)
+			}
+			tr.args[arg] = tmpl
+		}
+		if t.DedupKey != "" {
+			tmpl, err := template.New("dedup_key").Option("missingkey=error").Parse(t.DedupKey)
+			if err != nil {
+				return nil, fmt.Errorf("trigger %s: dedup_key: %s", t.Name, err)
+			}
+			tr.dedupKey = tmpl
+		}
+		m.triggers[t.Name] = tr
+	}
+	return m, nil
+}
+
+func (m *manager) Receive(name string, payload []byte) (proto.TriggerResult, error) {
+	res := proto.TriggerResult{Trigger: name}
+	t, ok := m.triggers[name]
+	if !ok {
+		return res, serr.TriggerNotFound{Name: name}
+	}
+	res, err := m.receive(t, payload)
+	if err != nil {
+		log.Errorf("trigger %s: %s", name, err)
+		m.saveError(name, payload, err)
+	}
+	return res, err
+}
+
+func (m *manager) receive(t *trigger, payload []byte) (proto.TriggerResult, error) {
+	res := proto.TriggerResult{Trigger: t.cfg.Name}
+
+	// Map the payload to request args and the dedup key. Numbers are decoded
+	// as json.Number so large integer IDs are not printed like 1.2e+06.
+	var data map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil || data == nil {
+		if err == nil {
+			err = fmt.Errorf("null")
+		}
+		return res, serr.ValidationError{Message: fmt.Sprintf("invalid payload: must be a JSON object: %s", err)}
+	}
+	args := map[string]interface{}{}
+	for arg, tmpl := range t.args {
+		val, err := execute(tmpl, data)
+		if err != nil {
+			return res, serr.ValidationError{Message: fmt.Sprintf("arg %s: %s", arg, err)}
+		}
+		args[arg] = val
+	}
+	var dedupKey string
+	if t.dedupKey != nil {
+		var err error
+		dedupKey, err = execute(t.dedupKey, data)
+		if err != nil {
+			return res, serr.ValidationError{Message: fmt.Sprintf("dedup_key: %s", err)}
+		}
+	}
+
+	// Claim the dedup key before creating the request so concurrent deliveries
+	// of the same message start only one request
+	if dedupKey != "" {
+		dup, reqId, err := m.claim(t.cfg.Name, dedupKey)
+		if err != nil {
+			return res, err
+		}
+		if dup {
+			res.RequestId = reqId
+			res.Duplicate = true
+			return res, nil
+		}
+	}
+
+	reqId, err := m.start(t, args)
+	if err != nil {
+		if dedupKey != "" {
+			m.unclaim(t.cfg.Name, dedupKey)
+		}
+		return res, err
+	}
+	res.RequestId = reqId
+
+	if dedupKey != "" {
+		q := "UPDATE trigger_dedup SET request_id = ? WHERE trigger_name = ? AND dedup_key = ?"
+		if _, err := m.dbc.ExecContext(context.TODO(), q, reqId, t.cfg.Name, dedupKey); err != nil {
+			// The request was started and the key is claimed, so it's not a
+			// message error; duplicates just won't return the request ID
+			log.Errorf("trigger %s: error saving request %s for dedup key %s: %s", t.cfg.Name, reqId, dedupKey, err)
+		}
+	}
+	return res, nil
+}
+
+// start creates and starts the request, returning its ID. A request spec dedup
+// key (dedupPolicy) works as usual: if the request is a duplicate of an
+// unfinished request, that request's ID is returned.
+func (m *manager) start(t *trigger, args map[string]interface{}) (string, error) {
+	req, err := m.rm.Create(proto.CreateRequest{
+		Type: t.cfg.Request,
+		Args: args,
+		User: "trigger:" + t.cfg.Name,
+	})
+	if err != nil {
+		var dup serr.DuplicateRequest
+		if errors.As(err, &dup) && !dup.Reject {
+			return dup.RequestId, nil
+		}
+		return "", err
+	}
+	if err := m.rm.Start(req.Id); err != nil {
+		if err := m.rm.FailPending(req.Id); err != nil {
+			log.Errorf("trigger %s: error failing request %s: %s", t.cfg.Name, req.Id, err)
+		}
+		return "", err
+	}
+	log.Infof("trigger %s: started request %s", t.cfg.Name, req.Id)
+	return req.Id, nil
+}
+
+// claim inserts the dedup key. If it already exists, the message is a duplicate,
+// and the ID of the request started by the previous message is returned. It's
+// empty if the previous message is still being processed.
+func (m *manager) claim(name, dedupKey string) (bool, string, error) {
+	ctx := context.TODO()
+	q := "INSERT IGNORE INTO trigger_dedup (trigger_name, dedup_key, received_at) VALUES (?, ?, ?)"
+	res, err := m.dbc.ExecContext(ctx, q, name, dedupKey, time.Now().UTC())
+	if err != nil {
+		return false, "", serr.NewDbError(err, "INSERT trigger_dedup")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return false, "", err
+	} else if n == 1 {
+		return false, "", nil
+	}
+	var reqId sql.NullString
+	q = "SELECT request_id FROM trigger_dedup WHERE trigger_name = ? AND dedup_key = ?"
+	if err := m.dbc.QueryRowContext(ctx, q, name, dedupKey).Scan(&reqId); err != nil {
+		return false, "", serr.NewDbError(err, "SELECT trigger_dedup")
+	}
+	return true, reqId.String, nil
+}
+
+// unclaim deletes the dedup key after the request could not be started, so the
+// message can be received again.
+func (m *manager) unclaim(name, dedupKey string) {
+	q := "DELETE FROM trigger_dedup WHERE trigger_name = ? AND dedup_key = ? AND request_id IS NULL"
+	if _, err := m.dbc.ExecContext(context.TODO(), q, name, dedupKey); err != nil {
+		log.Errorf("trigger %s: error deleting dedup key %s: %s", name, dedupKey, err)
+	}
+}
+
+func (m *manager) saveError(name string, payload []byte, msgErr error) {
+	q := "INSERT INTO trigger_errors (trigger_name, payload, error, received_at) VALUES (?, ?, ?, ?)"
+	if _, err := m.dbc.ExecContext(context.TODO(), q, name, payload, msgErr.Error(), time.Now().UTC()); err != nil {
+		log.Errorf("trigger %s: error saving message in error queue: %s", name, err)
+	}
+}
+
+func (m *manager) Errors(name string) ([]proto.TriggerError, error) {
+	if _, ok := m.triggers[name]; !ok {
+		return nil, serr.TriggerNotFound{Name: name}
+	}
+	q := "SELECT id, trigger_name, payload, error, received_at FROM trigger_errors" +
+		" WHERE trigger_name = ? ORDER BY id DESC LIMIT ?"
+	rows, err := m.dbc.QueryContext(context.TODO(), q, name, MaxErrors)
+	if err != nil {
+		return nil, serr.NewDbError(err, "SELECT trigger_errors")
+	}
+	defer rows.Close()
+	errs := []proto.TriggerError{}
+	for rows.Next() {
+		var e proto.TriggerError
+		if err := rows.Scan(&e.Id, &e.Trigger, &e.Payload, &e.Error, &e.ReceivedAt); err != nil {
+			return nil, serr.NewDbError(err, "SELECT trigger_errors")
+		}
+		errs = append(errs, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, serr.NewDbError(err, "SELECT trigger_errors")
+	}
+	return errs, nil
+}
+
+func (m *manager) Run(stopChan <-chan struct{}) {
+	if len(m.sources) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for name, src := range m.sources {
+		go m.consume(ctx, name, src)
+	}
+	<-stopChan
+}
+
+// consume receives and processes messages from the source of the trigger until
+// the context is canceled.
+func (m *manager) consume(ctx context.Context, name string, src Source) {
+	log.Infof("trigger %s: receiving from source %s", name, m.triggers[name].cfg.Source)
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		if m.accepting != nil {
+			if err := m.accepting(); err != nil {
+				wait(ctx, ReceiveRetryWait)
+				continue
+			}
+		}
+		msg, err := src.Receive(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Errorf("trigger %s: error receiving message: %s", name, err)
+				wait(ctx, ReceiveRetryWait)
+			}
+			continue
+		}
+		res, err := m.Receive(name, msg.Payload)
+		if err != nil {
+			if msg.Fail != nil {
+				msg.Fail(err)
+			}
+			continue
+		}
+		if res.Duplicate {
+			log.Infof("trigger %s: message %s is a duplicate of request %s", name, msg.Id, res.RequestId)
+		}
+		if msg.Ack != nil {
+			msg.Ack()
+		}
+	}
+}
+
+// execute executes the template with the payload data and returns the output.
+func execute(tmpl *template.Template, data map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func wait(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
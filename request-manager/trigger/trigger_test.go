@@ -0,0 +1,261 @@
+// Copyright 2020, Square, Inc.
+
+package trigger_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/config"
+	"github.com/square/spincycle/v2/proto"
+	testdb "github.com/square/spincycle/v2/request-manager/test/db"
+	"github.com/square/spincycle/v2/request-manager/trigger"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+var dbm testdb.Manager
+var dbc *sql.DB
+
+func setup(t *testing.T, dataFile string) string {
+	var err error
+	if dbm == nil {
+		dbm, err = testdb.NewManager()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbName, err := dbm.Create(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbm.Connect(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbc = db
+	return dbName
+}
+
+func teardown(t *testing.T, dbName string) {
+	if err := dbm.Destroy(dbName); err != nil {
+		t.Fatal(err)
+	}
+	dbc.Close()
+}
+
+var deployTrigger = config.Trigger{
+	Name:     "deploy-finished",
+	Source:   config.TRIGGER_SOURCE_WEBHOOK,
+	Request:  "warm-cache",
+	Args:     map[string]string{"app": "{{.app}}", "build": "{{.build}}"},
+	DedupKey: "{{.deployId}}",
+}
+
+// rm returns a mock request manager that records created requests and names
+// them req1, req2, etc.
+func rm(created *[]proto.CreateRequest) *mock.RequestManager {
+	return &mock.RequestManager{
+		CreateFunc: func(cr proto.CreateRequest) (proto.Request, error) {
+			*created = append(*created, cr)
+			return proto.Request{Id: fmt.Sprintf("req%d", len(*created))}, nil
+		},
+	}
+}
+
+// //////////////////////////////////////////////////////////////////////////
+// Tests
+// //////////////////////////////////////////////////////////////////////////
+
+func TestReceive(t *testing.T) {
+	dbName := setup(t, "../test/data/request-default.sql")
+	defer teardown(t, dbName)
+
+	var created []proto.CreateRequest
+	m, err := trigger.NewManager(trigger.ManagerConfig{
+		Triggers:       []config.Trigger{deployTrigger},
+		RequestManager: rm(&created),
+		DBConnector:    dbc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Payload mapped to request args; large numbers not printed in exponent form
+	res, err := m.Receive("deploy-finished", []byte(`{"app":"web","build":1234567,"deployId":"d1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRes := proto.TriggerResult{Trigger: "deploy-finished", RequestId: "req1"}
+	if diff := deep.Equal(res, expectRes); diff != nil {
+		t.Error(diff)
+	}
+	expectCreated := []proto.CreateRequest{
+		{Type: "warm-cache", Args: map[string]interface{}{"app": "web", "build": "1234567"}, User: "trigger:deploy-finished"},
+	}
+	if diff := deep.Equal(created, expectCreated); diff != nil {
+		t.Error(diff)
+	}
+
+	// Same dedup key: duplicate of the first request, no new request
+	res, err = m.Receive("deploy-finished", []byte(`{"app":"web","build":1234567,"deployId":"d1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRes = proto.TriggerResult{Trigger: "deploy-finished", RequestId: "req1", Duplicate: true}
+	if diff := deep.Equal(res, expectRes); diff != nil {
+		t.Error(diff)
+	}
+	if len(created) != 1 {
+		t.Errorf("%d requests created, expected 1", len(created))
+	}
+
+	// Invalid payload and missing field are saved in the error queue
+	if _, err := m.Receive("deploy-finished", []byte(`[1,2]`)); err == nil {
+		t.Error("no error for invalid payload, expected one")
+	}
+	if _, err := m.Receive("deploy-finished", []byte(`{"app":"web","deployId":"d2"}`)); err == nil {
+		t.Error("no error for missing payload field, expected one")
+	}
+	errs, err := m.Errors("deploy-finished")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("got %d errors, expected 2: %+v", len(errs), errs)
+	}
+	if errs[0].Payload != `{"app":"web","deployId":"d2"}` || errs[1].Payload != `[1,2]` {
+		t.Errorf("got errors %+v, expected newest first", errs)
+	}
+
+	// No such trigger
+	if _, err := m.Receive("nope", []byte(`{}`)); err == nil {
+		t.Error("no error for unknown trigger, expected one")
+	}
+}
+
+func TestReceiveStartError(t *testing.T) {
+	dbName := setup(t, "../test/data/request-default.sql")
+	defer teardown(t, dbName)
+
+	var created []proto.CreateRequest
+	var failed string
+	rmc := rm(&created)
+	rmc.StartFunc = func(reqId string) error {
+		if len(created) == 1 {
+			return fmt.Errorf("no Job Runner")
+		}
+		return nil
+	}
+	rmc.FailPendingFunc = func(reqId string) error {
+		failed = reqId
+		return nil
+	}
+	m, err := trigger.NewManager(trigger.ManagerConfig{
+		Triggers:       []config.Trigger{deployTrigger},
+		RequestManager: rmc,
+		DBConnector:    dbc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Request not started: failed, and the dedup key is released so the
+	// message can be received again
+	payload := []byte(`{"app":"web","build":1,"deployId":"d1"}`)
+	if _, err := m.Receive("deploy-finished", payload); err == nil {
+		t.Error("no error, expected one")
+	}
+	if failed != "req1" {
+		t.Errorf("failed request %q, expected req1", failed)
+	}
+	res, err := m.Receive("deploy-finished", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Duplicate || res.RequestId != "req2" {
+		t.Errorf("got result %+v, expected request req2", res)
+	}
+}
+
+// source is a trigger.Source that returns its messages, then blocks.
+type source struct {
+	msgs chan trigger.Message
+}
+
+func (s source) Receive(ctx context.Context) (trigger.Message, error) {
+	select {
+	case msg := <-s.msgs:
+		return msg, nil
+	case <-ctx.Done():
+		return trigger.Message{}, ctx.Err()
+	}
+}
+
+func TestRun(t *testing.T) {
+	dbName := setup(t, "../test/data/request-default.sql")
+	defer teardown(t, dbName)
+
+	var created []proto.CreateRequest
+	src := source{msgs: make(chan trigger.Message, 2)}
+	cfg := deployTrigger
+	cfg.Source = "sqs"
+	m, err := trigger.NewManager(trigger.ManagerConfig{
+		Triggers:       []config.Trigger{cfg},
+		Sources:        map[string]trigger.Source{"sqs": src},
+		RequestManager: rm(&created),
+		DBConnector:    dbc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acked := make(chan bool, 2)
+	src.msgs <- trigger.Message{
+		Id:      "1",
+		Payload: []byte(`{"app":"web","build":1,"deployId":"d1"}`),
+		Ack:     func() { acked <- true },
+		Fail:    func(error) { acked <- false },
+	}
+	src.msgs <- trigger.Message{
+		Id:      "2",
+		Payload: []byte(`not json`),
+		Ack:     func() { acked <- true },
+		Fail:    func(error) { acked <- false },
+	}
+
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+	go func() {
+		m.Run(stopChan)
+		close(doneChan)
+	}()
+	for i, expect := range []bool{true, false} {
+		select {
+		case got := <-acked:
+			if got != expect {
+				t.Errorf("message %d: acked %t, expected %t", i+1, got, expect)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for message %d", i+1)
+		}
+	}
+	close(stopChan)
+	<-doneChan
+	if len(created) != 1 {
+		t.Errorf("%d requests created, expected 1", len(created))
+	}
+}
+
+func TestNewManagerNoSource(t *testing.T) {
+	cfg := deployTrigger
+	cfg.Source = "kafka"
+	_, err := trigger.NewManager(trigger.ManagerConfig{Triggers: []config.Trigger{cfg}})
+	if err == nil {
+		t.Error("no error for trigger source plugin that does not exist, expected one")
+	}
+}
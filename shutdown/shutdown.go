@@ -0,0 +1,55 @@
+// Copyright 2020, Square, Inc.
+
+// Package shutdown provides the OS signals that shut down the Request Manager
+// and Job Runner. Signal names and defaults are platform-specific: on Unix,
+// names are like "TERM" or "SIGTERM"; on Windows, console control events like
+// "CTRL_C" and "CTRL_SHUTDOWN" are also accepted.
+package shutdown
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// Signals returns the OS signals for the given signal names, or the platform
+// DefaultSignals if names is empty. Names are case-insensitive and the "SIG"
+// prefix is optional. An error is returned if a name is not a valid signal
+// on this platform.
+func Signals(names []string) ([]os.Signal, error) {
+	if len(names) == 0 {
+		names = DefaultSignals
+	}
+	sigs := make([]os.Signal, 0, len(names))
+	seen := map[os.Signal]bool{}
+	for _, name := range names {
+		n := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+		sig, ok := signalNames[n]
+		if !ok {
+			return nil, fmt.Errorf("invalid shutdown signal %q on this platform: valid signals are %s", name, validNames())
+		}
+		if seen[sig] {
+			continue // CTRL events on Windows map to the same signals
+		}
+		seen[sig] = true
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// Wait blocks until one of the signals is received, then returns it.
+func Wait(sigs []os.Signal) os.Signal {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, sigs...)
+	defer signal.Stop(sigChan)
+	return <-sigChan
+}
+
+func validNames() string {
+	names := make([]string, 0, len(signalNames))
+	for _, name := range signalOrder {
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
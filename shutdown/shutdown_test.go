@@ -0,0 +1,56 @@
+// Copyright 2020, Square, Inc.
+
+package shutdown_test
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/square/spincycle/v2/shutdown"
+)
+
+func TestSignalsDefault(t *testing.T) {
+	sigs, err := shutdown.Signals(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []os.Signal{os.Interrupt, syscall.SIGTERM}
+	if diff := deep.Equal(sigs, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSignalsNames(t *testing.T) {
+	sigs, err := shutdown.Signals([]string{"sigterm", " TERM", "INT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []os.Signal{syscall.SIGTERM, os.Interrupt}
+	if diff := deep.Equal(sigs, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	if _, err := shutdown.Signals([]string{"TERM", "BOGUS"}); err == nil {
+		t.Error("no error for invalid signal name")
+	}
+}
+
+func TestWait(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("cannot send signals to self on windows")
+	}
+	sigs, _ := shutdown.Signals([]string{"TERM"})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		p, _ := os.FindProcess(os.Getpid())
+		p.Signal(syscall.SIGTERM)
+	}()
+	sig := shutdown.Wait(sigs)
+	if sig != syscall.SIGTERM {
+		t.Errorf("got signal %s, expected %s", sig, syscall.SIGTERM)
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2020, Square, Inc.
+
+//go:build !windows
+// +build !windows
+
+package shutdown
+
+import (
+	"os"
+	"syscall"
+)
+
+// DefaultSignals are the shutdown signals if none are configured.
+var DefaultSignals = []string{"INT", "TERM"}
+
+var signalNames = map[string]os.Signal{
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+	"HUP":  syscall.SIGHUP,
+	"QUIT": syscall.SIGQUIT,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+var signalOrder = []string{"INT", "TERM", "HUP", "QUIT", "USR1", "USR2"}
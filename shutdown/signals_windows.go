@@ -0,0 +1,26 @@
+// Copyright 2020, Square, Inc.
+
+package shutdown
+
+import (
+	"os"
+	"syscall"
+)
+
+// DefaultSignals are the shutdown signals if none are configured. On Windows,
+// the Go runtime delivers CTRL_C and CTRL_BREAK events as os.Interrupt, and
+// CTRL_CLOSE, CTRL_LOGOFF, and CTRL_SHUTDOWN events as syscall.SIGTERM, so the
+// defaults handle all console control events.
+var DefaultSignals = []string{"INT", "TERM"}
+
+var signalNames = map[string]os.Signal{
+	"INT":           os.Interrupt,
+	"TERM":          syscall.SIGTERM,
+	"CTRL_C":        os.Interrupt,
+	"CTRL_BREAK":    os.Interrupt,
+	"CTRL_CLOSE":    syscall.SIGTERM,
+	"CTRL_LOGOFF":   syscall.SIGTERM,
+	"CTRL_SHUTDOWN": syscall.SIGTERM,
+}
+
+var signalOrder = []string{"INT", "TERM", "CTRL_C", "CTRL_BREAK", "CTRL_CLOSE", "CTRL_LOGOFF", "CTRL_SHUTDOWN"}
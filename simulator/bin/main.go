@@ -0,0 +1,15 @@
+// Copyright 2020, Square, Inc.
+
+package main
+
+import (
+	"os"
+
+	"github.com/square/spincycle/v2/simulator"
+)
+
+func main() {
+	if ok := simulator.Run(); !ok {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright 2020, Square, Inc.
+
+// Package simulator runs a request spec end-to-end on a local machine without
+// real jobs, so spec authors can test sequence logic (conditionals, each:,
+// retries, sequence retries, finally:) before job code exists. It builds the
+// job chain for the request args like the Request Manager, with stub jobs that
+// set the args their nodes set, then runs the chain with the Job Runner
+// traverser, printing every job try. Stub jobs complete unless configured to
+// fail. Nothing is sent to a Request Manager or Job Runner.
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/square/spincycle/v2/job-runner/chain"
+	"github.com/square/spincycle/v2/job-runner/runner"
+	"github.com/square/spincycle/v2/metrics"
+	"github.com/square/spincycle/v2/proto"
+	rm "github.com/square/spincycle/v2/request-manager"
+	"github.com/square/spincycle/v2/request-manager/graph"
+	"github.com/square/spincycle/v2/request-manager/id"
+	"github.com/square/spincycle/v2/request-manager/spec"
+	v "github.com/square/spincycle/v2/version"
+)
+
+// Config configures one simulated request.
+type Config struct {
+	SpecsDir string                 // request specs directory
+	Request  string                 // request name (type)
+	Args     map[string]interface{} // request args
+	Sets     map[string]interface{} // values that stub jobs set args to (NewStubFactory)
+	Fail     map[string]uint        // node name => tries to fail, 0 for all (NewStubFactory)
+	Out      io.Writer              // where the traversal is printed
+}
+
+// Simulate builds and runs the request with stub jobs, printing the traversal
+// to cfg.Out. It returns the final state of the request. Retry and sequence
+// retry waits are not waited, and pacing is ignored, so it finishes quickly.
+// An error is returned if the specs are invalid or the request cannot be built.
+func Simulate(cfg Config) (byte, error) {
+	// Load and check specs like the Request Manager on startup
+	specs, fileResults, err := spec.ParseSpecsDir(cfg.SpecsDir)
+	if err != nil {
+		return proto.STATE_UNKNOWN, err
+	}
+	if fileResults.AnyError {
+		return proto.STATE_UNKNOWN, fmt.Errorf("errors parsing specs: %s (run spinc-linter for details)", checkErrors(fileResults))
+	}
+	spec.ProcessSpecs(&specs)
+	checker, err := spec.NewChecker([]spec.CheckFactory{spec.DefaultCheckFactory{AllSpecs: specs}, spec.BaseCheckFactory{AllSpecs: specs}})
+	if err != nil {
+		return proto.STATE_UNKNOWN, err
+	}
+	if results := checker.RunChecks(specs); results.AnyError {
+		return proto.STATE_UNKNOWN, fmt.Errorf("static checks failed: %s (run spinc-linter for details)", checkErrors(results))
+	}
+	idf := id.NewGeneratorFactory(4, 100)
+	seqGraphs, graphResults := graph.NewGrapher(specs, idf).CheckSequences()
+	if graphResults.AnyError {
+		return proto.STATE_UNKNOWN, fmt.Errorf("graph checks failed: %s (run spinc-linter for details)", checkErrors(graphResults))
+	}
+
+	// Build the job chain like the Request Manager, but with stub jobs
+	jf := NewStubFactory(specs, cfg.Sets, cfg.Fail)
+	req := proto.Request{
+		Id:   xid.New().String(),
+		Type: cfg.Request,
+	}
+	resolver := graph.NewResolverFactory(jf, specs.Sequences, seqGraphs, idf).Make(req)
+	if _, err := resolver.RequestArgs(cfg.Args); err != nil {
+		return proto.STATE_UNKNOWN, err
+	}
+	jobArgs := map[string]interface{}{}
+	for k, v := range cfg.Args {
+		jobArgs[k] = v
+	}
+	reqGraph, err := resolver.BuildRequestGraph(jobArgs)
+	if err != nil {
+		return proto.STATE_UNKNOWN, fmt.Errorf("cannot build request: %s", err)
+	}
+	jc := &proto.JobChain{
+		RequestId:     req.Id,
+		AdjacencyList: reqGraph.Edges,
+		State:         proto.STATE_PENDING,
+		Jobs:          map[string]proto.Job{},
+		Globals:       resolver.Globals(),
+		StrictFailure: specs.Sequences[cfg.Request].StrictFailure,
+	}
+	internal := map[string]bool{} // sequence begin/end noop jobs, not printed
+	for jobId, node := range reqGraph.Nodes {
+		if node.Spec.Category == spec.NoopNode.Category { // copies of spec.NoopNode
+			internal[jobId] = true
+		}
+		jc.Jobs[jobId] = proto.Job{
+			Type:              *node.Spec.NodeType,
+			Id:                node.Id,
+			Name:              node.Name,
+			Desc:              node.Desc,
+			Bytes:             node.JobBytes,
+			Args:              node.Args,
+			Retry:             node.Retry,
+			RetryArgs:         node.RetryArgs,
+			KeepData:          node.KeepData,
+			SequenceId:        node.SequenceId,
+			SequenceRetry:     node.SequenceRetry,
+			SequenceSkippable: node.SequenceSkippable,
+			Asserts:           node.Asserts,
+			Finally:           node.Finally,
+			State:             proto.STATE_PENDING,
+		}
+	}
+	fmt.Fprintf(cfg.Out, "request %s: %d jobs\n", cfg.Request, len(jc.Jobs)-len(internal))
+
+	// Run the job chain with the Job Runner traverser. The local RM client
+	// prints every job try and returns when the request is finished.
+	rmc := &localRMClient{
+		out:      cfg.Out,
+		internal: internal,
+		finished: make(chan proto.FinishRequest, 1),
+		mux:      &sync.Mutex{},
+		state:    map[string]byte{},
+	}
+	rf := runner.NewFactory(runner.RunnerFactoryConfig{
+		JobFactory: jf,
+		RMClient:   rmc,
+	})
+	tf := chain.NewTraverserFactory(chain.TraverserFactoryConfig{
+		ChainRepo:     chain.NewMemoryRepo(),
+		RunnerFactory: rf,
+		RMClient:      rmc,
+		ShutdownChan:  make(chan struct{}),
+		Metrics:       metrics.Nop{},
+	})
+	t, err := tf.Make(jc)
+	if err != nil {
+		return proto.STATE_UNKNOWN, err
+	}
+	t.Run()
+	select {
+	case fr := <-rmc.finished:
+		fmt.Fprintf(cfg.Out, "request %s: %d of %d jobs complete\n", proto.StateName[fr.State], rmc.complete(), len(jc.Jobs)-len(internal))
+		return fr.State, nil
+	default:
+		return proto.STATE_UNKNOWN, fmt.Errorf("job chain done but request not finished")
+	}
+}
+
+// --------------------------------------------------------------------------
+
+// localRMClient is the Request Manager of a simulated request. It implements
+// only the methods that the Job Runner traverser and runners call; the others
+// panic because the embedded rm.Client is nil.
+type localRMClient struct {
+	rm.Client
+	out      io.Writer
+	internal map[string]bool
+	finished chan proto.FinishRequest
+	// --
+	mux   *sync.Mutex
+	n     uint            // job tries printed
+	state map[string]byte // job ID => state of last try
+}
+
+func (c *localRMClient) CreateJL(requestId string, jl proto.JobLog) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.internal[jl.JobId] {
+		return nil
+	}
+	c.state[jl.JobId] = jl.State
+	c.n++
+	line := fmt.Sprintf("%4d  %s [%s] (%s) try %d: %s", c.n, jl.Name, jl.JobId, jl.Type, jl.Try, proto.StateName[jl.State])
+	if jl.Error != "" {
+		line += ": " + jl.Error
+	}
+	fmt.Fprintln(c.out, line)
+	return nil
+}
+
+// complete returns the number of jobs whose last try completed.
+func (c *localRMClient) complete() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	n := 0
+	for _, state := range c.state {
+		if state == proto.STATE_COMPLETE {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *localRMClient) FinishRequest(fr proto.FinishRequest) error {
+	select {
+	case c.finished <- fr:
+	default:
+	}
+	return nil
+}
+
+func (c *localRMClient) SuspendRequest(requestId string, sjc proto.SuspendedJobChain) error {
+	return fmt.Errorf("simulated requests cannot be suspended")
+}
+
+func (c *localRMClient) AcquireLock(l proto.SingletonLock) (proto.SingletonLock, error) {
+	l.AcquiredAt = time.Now()
+	return l, nil // no other requests: always acquired
+}
+
+func (c *localRMClient) ReleaseLock(l proto.SingletonLock) error {
+	return nil
+}
+
+// --------------------------------------------------------------------------
+
+// CLI is the spinc-simulator command line.
+// Note that go-arg help message will show defaults if the default is not false.
+type CLI struct {
+	Request string   `arg:"positional,required" help:"request name"`
+	Args    []string `arg:"positional" help:"request args: key=value"`
+
+	SpecsDir string   `arg:"--specs" help:"path to spin cycle requests directory [default: current working dir]"`
+	Set      []string `arg:"--set,separate" help:"arg=value: value that stub jobs set arg to (JSON or string) [default: \"stub-<arg>\"]"`
+	Fail     []string `arg:"--fail,separate" help:"node[:N]: fail jobs of the node, only the first N tries if N is given"`
+	Debug    bool     `help:"print Job Runner log"`
+}
+
+func (cli *CLI) Version() string {
+	return "spinc-simulator " + v.Version()
+}
+
+// Run runs the spinc-simulator command line. It returns true if the request
+// completes.
+func Run() bool {
+	cli := CLI{
+		SpecsDir: "./",
+	}
+	p := arg.MustParse(&cli)
+	if !cli.Debug {
+		log.SetLevel(log.ErrorLevel)
+	}
+
+	cfg := Config{
+		SpecsDir: cli.SpecsDir,
+		Request:  cli.Request,
+		Args:     map[string]interface{}{},
+		Sets:     map[string]interface{}{},
+		Fail:     map[string]uint{},
+		Out:      os.Stdout,
+	}
+	for _, kv := range cli.Args {
+		split := strings.SplitN(kv, "=", 2)
+		if len(split) != 2 {
+			p.Fail(fmt.Sprintf("invalid request arg %q: must be key=value", kv))
+		}
+		cfg.Args[split[0]] = split[1]
+	}
+	for _, kv := range cli.Set {
+		split := strings.SplitN(kv, "=", 2)
+		if len(split) != 2 {
+			p.Fail(fmt.Sprintf("invalid --set %q: must be arg=value", kv))
+		}
+		var val interface{}
+		if err := json.Unmarshal([]byte(split[1]), &val); err != nil {
+			val = split[1] // not JSON, like "db01"
+		}
+		cfg.Sets[split[0]] = val
+	}
+	for _, f := range cli.Fail {
+		node, tries, err := parseFail(f)
+		if err != nil {
+			p.Fail(err.Error())
+		}
+		cfg.Fail[node] = tries
+	}
+
+	state, err := Simulate(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	return state == proto.STATE_COMPLETE
+}
+
+// parseFail parses a --fail value: "node" or "node:N".
+func parseFail(s string) (string, uint, error) {
+	p := strings.SplitN(s, ":", 2)
+	if p[0] == "" {
+		return "", 0, fmt.Errorf("invalid --fail %q: node name is empty", s)
+	}
+	if len(p) == 1 {
+		return p[0], 0, nil
+	}
+	n, err := strconv.ParseUint(p[1], 10, 32)
+	if err != nil || n == 0 {
+		return "", 0, fmt.Errorf("invalid --fail %q: tries must be an integer greater than zero", s)
+	}
+	return p[0], uint(n), nil
+}
+
+// checkErrors returns the errors in the spec check results, sorted.
+func checkErrors(results *spec.CheckResults) string {
+	errs := []string{}
+	for name, result := range results.Results {
+		for _, err := range result.Errors {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	sort.Strings(errs)
+	return strings.Join(errs, "; ")
+}
+
+// splitEach returns the list arg of an each: value, like "hosts" in "hosts:host".
+func splitEach(each string) string {
+	p := strings.SplitN(each, ":", 2)
+	if len(p) != 2 {
+		return ""
+	}
+	return p[0]
+}
@@ -0,0 +1,91 @@
+// Copyright 2020, Square, Inc.
+
+package simulator_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/simulator"
+)
+
+var jobIdRe = regexp.MustCompile(` \[[^\]]+\]`)
+
+// simulate runs the request and returns its final state and printed traversal
+// without job IDs, which are random.
+func simulate(t *testing.T, cfg simulator.Config) (byte, []string) {
+	out := &bytes.Buffer{}
+	cfg.SpecsDir = "test/specs"
+	cfg.Request = "restart-cluster"
+	cfg.Args = map[string]interface{}{"cluster": "c1"}
+	cfg.Out = out
+	state, err := simulator.Simulate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(jobIdRe.ReplaceAllString(out.String(), "")), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return state, lines
+}
+
+func TestSimulate(t *testing.T) {
+	// Stub get-hosts sets hosts (each: list) and engine to placeholders, so
+	// the conditional takes the default path
+	state, lines := simulate(t, simulator.Config{})
+	if state != proto.STATE_COMPLETE {
+		t.Errorf("got state %s, expected COMPLETE", proto.StateName[state])
+	}
+	expect := []string{
+		"request restart-cluster: 4 jobs",
+		"1  get-hosts (get-hosts) try 1: COMPLETE",
+		"2  stop (stop-host) try 1: COMPLETE",
+		"3  start (start-host) try 1: COMPLETE",
+		"4  reload-config (reload-config) try 1: COMPLETE",
+		"request COMPLETE: 4 of 4 jobs complete",
+	}
+	if diff := deep.Equal(lines, expect); diff != nil {
+		t.Log(lines)
+		t.Error(diff)
+	}
+}
+
+func TestSimulateSetsAndFail(t *testing.T) {
+	// Two hosts and the mysql path. The first stop fails: the sequence is
+	// retried, then the request completes.
+	state, lines := simulate(t, simulator.Config{
+		Sets: map[string]interface{}{
+			"hosts":  []interface{}{"h1"},
+			"engine": "mysql",
+		},
+		Fail: map[string]uint{"stop": 1},
+	})
+	if state != proto.STATE_COMPLETE {
+		t.Errorf("got state %s, expected COMPLETE", proto.StateName[state])
+	}
+	expect := []string{
+		"request restart-cluster: 4 jobs",
+		"1  get-hosts (get-hosts) try 1: COMPLETE",
+		"2  stop (stop-host) try 1: FAIL: stub job failed (try 1)",
+		"3  stop (stop-host) try 2: COMPLETE",
+		"4  start (start-host) try 1: COMPLETE",
+		"5  restart-mysql (restart-mysql) try 1: COMPLETE",
+		"request COMPLETE: 4 of 4 jobs complete",
+	}
+	if diff := deep.Equal(lines, expect); diff != nil {
+		t.Log(lines)
+		t.Error(diff)
+	}
+
+	// A job that always fails fails the request
+	state, _ = simulate(t, simulator.Config{Fail: map[string]uint{"start": 0}})
+	if state != proto.STATE_FAIL {
+		t.Errorf("got state %s, expected FAIL", proto.StateName[state])
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2020, Square, Inc.
+
+package simulator
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/square/spincycle/v2/job"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/spec"
+)
+
+// StubFactory is a job.Factory that makes stub jobs of every job type, so a
+// request can be built and run without real job code. A stub job sets the job
+// args that its nodes set (spec sets:), and completes unless it's configured to
+// fail. It's safe for concurrent use.
+type StubFactory struct {
+	sets   map[string][]string    // job type => args set by its nodes
+	lists  map[string]bool        // job type/arg => arg is an each: list
+	values map[string]interface{} // arg => value set by stub jobs (Config.Sets)
+	fail   map[string]uint        // node name => tries to fail, 0 for all (Config.Fail)
+	tries  map[string]uint        // job ID => tries run
+	*sync.Mutex
+}
+
+var _ job.Factory = &StubFactory{}
+
+// NewStubFactory makes a StubFactory for the specs. Stub jobs set args to the
+// values, else to a placeholder value: a one-element list if the arg is used
+// in an each: list, else a string like "stub-hosts". A job is failed if its
+// node name is in fail: the first N tries, or every try if N is zero.
+func NewStubFactory(specs spec.Specs, values map[string]interface{}, fail map[string]uint) *StubFactory {
+	f := &StubFactory{
+		sets:   map[string][]string{},
+		lists:  map[string]bool{},
+		values: values,
+		fail:   fail,
+		tries:  map[string]uint{},
+		Mutex:  &sync.Mutex{},
+	}
+	if f.values == nil {
+		f.values = map[string]interface{}{}
+	}
+	if f.fail == nil {
+		f.fail = map[string]uint{}
+	}
+	for _, seq := range specs.Sequences {
+		// Each list args in this sequence, like "hosts" in "each: hosts:host"
+		each := map[string]bool{}
+		for _, node := range seq.Nodes {
+			for _, e := range node.Each {
+				if p := splitEach(e); p != "" {
+					each[p] = true
+				}
+			}
+		}
+		nodes := make([]*spec.Node, 0, len(seq.Nodes)+len(seq.Finally))
+		for _, node := range seq.Nodes {
+			nodes = append(nodes, node)
+		}
+		for _, node := range seq.Finally {
+			nodes = append(nodes, node)
+		}
+		for _, node := range nodes {
+			if node.Category == nil || *node.Category != "job" || node.NodeType == nil {
+				continue
+			}
+			jobType := *node.NodeType
+			for _, set := range node.Sets {
+				if set == nil || set.Arg == nil {
+					continue
+				}
+				f.addSet(jobType, *set.Arg)
+				if set.As != nil && each[*set.As] {
+					f.lists[jobType+"/"+*set.Arg] = true
+				}
+			}
+		}
+	}
+	return f
+}
+
+func (f *StubFactory) addSet(jobType, arg string) {
+	for _, a := range f.sets[jobType] {
+		if a == arg {
+			return
+		}
+	}
+	f.sets[jobType] = append(f.sets[jobType], arg)
+}
+
+func (f *StubFactory) Make(id job.Id) (job.Job, error) {
+	return &stubJob{
+		id: id,
+		f:  f,
+	}, nil
+}
+
+// value returns the value a stub job of the type sets the arg to.
+func (f *StubFactory) value(jobType, arg string) interface{} {
+	if v, ok := f.values[arg]; ok {
+		return v
+	}
+	if f.lists[jobType+"/"+arg] {
+		return []interface{}{"stub-" + arg}
+	}
+	return "stub-" + arg
+}
+
+// try counts a try of the job and returns an error if it's configured to fail.
+func (f *StubFactory) try(id job.Id) error {
+	f.Lock()
+	defer f.Unlock()
+	f.tries[id.Id]++
+	n, ok := f.fail[id.Name]
+	if !ok {
+		return nil
+	}
+	if n > 0 && f.tries[id.Id] > n {
+		return nil
+	}
+	return fmt.Errorf("stub job failed (try %d)", f.tries[id.Id])
+}
+
+// --------------------------------------------------------------------------
+
+type stubJob struct {
+	id job.Id
+	f  *StubFactory
+}
+
+func (j *stubJob) Create(jobArgs map[string]interface{}) error {
+	for _, arg := range j.f.sets[j.id.Type] {
+		if _, given := j.f.values[arg]; !given {
+			if _, ok := jobArgs[arg]; ok {
+				continue
+			}
+		}
+		jobArgs[arg] = j.f.value(j.id.Type, arg)
+	}
+	return nil
+}
+
+func (j *stubJob) Serialize() ([]byte, error) {
+	return nil, nil
+}
+
+func (j *stubJob) Deserialize([]byte) error {
+	return nil
+}
+
+func (j *stubJob) Run(jobData map[string]interface{}) (job.Return, error) {
+	if err := j.f.try(j.id); err != nil {
+		return job.Return{State: proto.STATE_FAIL, Exit: 1, Error: err}, nil
+	}
+	return job.Return{State: proto.STATE_COMPLETE}, nil
+}
+
+func (j *stubJob) Stop() error {
+	return nil
+}
+
+func (j *stubJob) Status() string {
+	return "stub"
+}
+
+func (j *stubJob) Id() job.Id {
+	return j.id
+}
@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/square/spincycle/v2/job"
 	"github.com/square/spincycle/v2/request-manager"
 	"github.com/square/spincycle/v2/spinc/config"
 )
@@ -55,6 +56,7 @@ type HTTPClientFactory interface {
 type Factories struct {
 	HTTPClient HTTPClientFactory
 	Command    CommandFactory
+	Job        job.Factory // makes jobs for replay-job (default: jobs.Factory)
 }
 
 type Hooks struct {
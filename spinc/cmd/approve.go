@@ -0,0 +1,47 @@
+// Copyright 2020, Square, Inc.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/square/spincycle/v2/spinc/app"
+)
+
+type Approve struct {
+	ctx   app.Context
+	reqId string
+	jobId string
+}
+
+func NewApprove(ctx app.Context) *Approve {
+	return &Approve{
+		ctx: ctx,
+	}
+}
+
+func (c *Approve) Prepare() error {
+	if len(c.ctx.Command.Args) < 2 {
+		return fmt.Errorf("Usage: spinc approve <request ID> <job ID>\n")
+	}
+	c.reqId = c.ctx.Command.Args[0]
+	c.jobId = c.ctx.Command.Args[1]
+	return nil
+}
+
+func (c *Approve) Run() error {
+	if err := c.ctx.RMClient.ApproveJob(c.reqId, c.jobId); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.ctx.Out, "OK, approved job %s of %s\n", c.jobId, c.reqId)
+	return nil
+}
+
+func (c *Approve) Cmd() string {
+	return "approve " + c.reqId + " " + c.jobId
+}
+
+func (c *Approve) Help() string {
+	return "'spinc approve <request ID> <job ID>' approves a gate job waiting for approval, so the request continues.\n" +
+		"'spinc ps <request ID>' shows the job ID of gate jobs waiting for approval in column STATUS.\n"
+}
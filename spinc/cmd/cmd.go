@@ -6,6 +6,7 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/square/spincycle/v2/spinc/app"
@@ -24,15 +25,44 @@ func (e ErrUnknownArgs) Error() string {
 	return fmt.Sprintf("Unknown request args: %s. Run 'spinc help %s' to list valid args.", strings.Join(e.Args, ", "), e.Request)
 }
 
+// ErrMissingArgs is returned by start with --non-interactive when required
+// request args are not given, because spinc cannot prompt for them.
+type ErrMissingArgs struct {
+	Request string
+	Args    []string
+}
+
+func (e ErrMissingArgs) Error() string {
+	return fmt.Sprintf("Missing required request args: %s. Run 'spinc help %s' to list required args.", strings.Join(e.Args, ", "), e.Request)
+}
+
+// DefaultFactory makes the built-in commands. Other commands are made from
+// Commands, if set by wrapper code, else they are external commands: spinc-<cmd>
+// binaries in PATH. Built-in commands cannot be replaced.
 type DefaultFactory struct {
+	Commands map[string]func(app.Context) app.Command // custom commands
 }
 
 func (f *DefaultFactory) Make(name string, ctx app.Context) (app.Command, error) {
 	switch name {
+	case "approve":
+		return NewApprove(ctx), nil
 	case "log":
 		return NewLog(ctx), nil
+	case "pause":
+		return NewPause(ctx), nil
+	case "profile":
+		return NewProfile(ctx), nil
+	case "presets":
+		return NewPresets(ctx), nil
 	case "ps":
 		return NewPs(ctx), nil
+	case "replay-job":
+		return NewReplayJob(ctx), nil
+	case "report":
+		return NewReport(ctx), nil
+	case "resume":
+		return NewResume(ctx), nil
 	case "running":
 		return NewRunning(ctx), nil
 	case "find":
@@ -43,17 +73,75 @@ func (f *DefaultFactory) Make(name string, ctx app.Context) (app.Command, error)
 		return NewStatus(ctx), nil
 	case "stop":
 		return NewStop(ctx), nil
+	case "timeline":
+		return NewTimeline(ctx), nil
+	case "wait":
+		return NewWait(ctx), nil
 	case "help":
 		return NewHelp(ctx), nil
 	case "version":
 		return NewVersion(ctx), nil
 	case "info":
 		return NewInfo(ctx), nil
+	case "login":
+		return NewLogin(ctx), nil
+	case "logout":
+		return NewLogout(ctx), nil
 	default:
-		return nil, ErrNotExist
+		if newCmd, ok := f.Commands[name]; ok {
+			return newCmd(ctx), nil
+		}
+		path, err := FindExternal(name)
+		if err != nil {
+			return nil, err
+		}
+		return NewExternal(ctx, name, path), nil
 	}
 }
 
+// Custom returns the names of custom commands and external commands, sorted.
+// Names of built-in commands are not returned.
+func (f *DefaultFactory) Custom() []string {
+	names := ExternalCommands()
+	for name := range f.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	uniq := names[:0]
+	for i, name := range names {
+		if (i > 0 && name == names[i-1]) || builtin[name] {
+			continue
+		}
+		uniq = append(uniq, name)
+	}
+	return uniq
+}
+
+// builtin is the set of built-in command names, which DefaultFactory.Make makes.
+var builtin = map[string]bool{
+	"approve":    true,
+	"log":        true,
+	"pause":      true,
+	"profile":    true,
+	"presets":    true,
+	"ps":         true,
+	"replay-job": true,
+	"report":     true,
+	"resume":     true,
+	"running":    true,
+	"find":       true,
+	"start":      true,
+	"status":     true,
+	"stop":       true,
+	"timeline":   true,
+	"wait":       true,
+	"help":       true,
+	"version":    true,
+	"info":       true,
+	"login":      true,
+	"logout":     true,
+}
+
 // SqueezeString makes string s fit into n characters by truncating and replacing
 // middle characters with x. Example: "hello, world!" squeezed into 5 characters
 // with ".." replacement: "he..!". Preference is given to left-side characters
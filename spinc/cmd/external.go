@@ -0,0 +1,136 @@
+// Copyright 2020, Square, Inc.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/square/spincycle/v2/spinc/app"
+)
+
+// EXTERNAL_PREFIX is the prefix of external command binaries: spinc runs
+// "spinc foo" as the first "spinc-foo" binary in PATH.
+const EXTERNAL_PREFIX = "spinc-"
+
+// External runs an external command: a spinc-<cmd> binary in PATH. The binary
+// is given the command args, stdin and stdout, and the final spinc options as
+// SPINC_* environment variables, so it uses the same Request Manager and
+// credentials as spinc.
+type External struct {
+	ctx  app.Context
+	name string
+	path string
+}
+
+func NewExternal(ctx app.Context, name, path string) *External {
+	return &External{
+		ctx:  ctx,
+		name: name,
+		path: path,
+	}
+}
+
+func (c *External) Prepare() error {
+	return nil
+}
+
+func (c *External) Run() error {
+	if c.ctx.Options.Debug {
+		app.Debug("running %s %v", c.path, c.ctx.Command.Args)
+	}
+	ext := exec.Command(c.path, c.ctx.Command.Args...)
+	ext.Stdin = c.ctx.In
+	ext.Stdout = c.ctx.Out
+	ext.Stderr = os.Stderr
+	ext.Env = append(os.Environ(), c.env()...)
+	err := ext.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		// Exit with the command's exit code, like it was run directly
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		return fmt.Errorf("Error running %s: %s", c.path, err)
+	}
+	return nil
+}
+
+func (c *External) Cmd() string {
+	return c.name
+}
+
+func (c *External) Help() string {
+	return fmt.Sprintf("'spinc %s' runs external command %s. Run '%s --help' for its help, if any.\n", c.name, c.path, c.path)
+}
+
+// env returns the final spinc options as SPINC_* environment variables, which
+// override the ones that spinc was run with.
+func (c *External) env() []string {
+	o := c.ctx.Options
+	env := []string{
+		"SPINC_ADDR=" + o.Addr,
+		"SPINC_DEBUG=" + strconv.FormatBool(o.Debug),
+		"SPINC_NON_INTERACTIVE=" + strconv.FormatBool(o.NonInteractive),
+		"SPINC_READ_ONLY=" + strconv.FormatBool(o.ReadOnly),
+		"SPINC_TIMEOUT=" + strconv.FormatUint(uint64(o.Timeout), 10),
+	}
+	optional := []struct{ name, val string }{
+		{"SPINC_CONFIG", o.Config},
+		{"SPINC_ENV", o.Env},
+		{"SPINC_SPEC_CACHE", o.SpecCache},
+		{"SPINC_TLS_CA", o.TLSCA},
+		{"SPINC_TLS_CERT", o.TLSCert},
+		{"SPINC_TLS_KEY", o.TLSKey},
+		{"SPINC_TOKEN_FILE", o.TokenFile},
+	}
+	for _, v := range optional {
+		if v.val != "" {
+			env = append(env, v.name+"="+v.val)
+		}
+	}
+	return env
+}
+
+// FindExternal returns the path of the spinc-<name> binary in PATH, or
+// ErrNotExist if there is none.
+func FindExternal(name string) (string, error) {
+	if name == "" || strings.ContainsRune(name, os.PathSeparator) || strings.ContainsRune(name, '/') {
+		return "", ErrNotExist
+	}
+	path, err := exec.LookPath(EXTERNAL_PREFIX + name)
+	if err != nil {
+		return "", ErrNotExist
+	}
+	return path, nil
+}
+
+// ExternalCommands returns the names of all external commands in PATH, sorted.
+func ExternalCommands() []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if !strings.HasPrefix(f.Name(), EXTERNAL_PREFIX) || f.IsDir() || f.Mode()&0111 == 0 {
+				continue
+			}
+			name := strings.TrimPrefix(f.Name(), EXTERNAL_PREFIX)
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,116 @@
+// Copyright 2020, Square, Inc.
+
+package cmd_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/spinc/app"
+	"github.com/square/spincycle/v2/spinc/cmd"
+	"github.com/square/spincycle/v2/spinc/config"
+)
+
+// setPath makes a temp dir with a spinc-hello external command and sets PATH
+// to only that dir. The returned func restores PATH and removes the dir.
+func setPath(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "spinc-external")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho \"$SPINC_ADDR $SPINC_TIMEOUT $@\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "spinc-hello"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Not executable, so not a command
+	if err := ioutil.WriteFile(filepath.Join(dir, "spinc-readme"), []byte("hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir)
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestExternal(t *testing.T) {
+	defer setPath(t)()
+
+	output := &bytes.Buffer{}
+	ctx := app.Context{
+		Out: output,
+		Options: config.Options{
+			Addr:    "http://rm:32308",
+			Timeout: 3000,
+		},
+		Command: config.Command{
+			Cmd:  "hello",
+			Args: []string{"a", "b=c"},
+		},
+	}
+	f := &cmd.DefaultFactory{}
+	hello, err := f.Make("hello", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hello.(*cmd.External); !ok {
+		t.Fatalf("got %T, expected *cmd.External", hello)
+	}
+	if err := hello.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hello.Run(); err != nil {
+		t.Fatal(err)
+	}
+	expectOutput := "http://rm:32308 3000 a b=c\n"
+	if output.String() != expectOutput {
+		t.Errorf("got output %q, expected %q", output.String(), expectOutput)
+	}
+
+	if _, err := f.Make("readme", ctx); err != cmd.ErrNotExist {
+		t.Errorf("Make readme: got err %v, expected cmd.ErrNotExist", err)
+	}
+	if _, err := f.Make("../hello", ctx); err != cmd.ErrNotExist {
+		t.Errorf("Make ../hello: got err %v, expected cmd.ErrNotExist", err)
+	}
+}
+
+func TestCustomCommands(t *testing.T) {
+	defer setPath(t)()
+
+	f := &cmd.DefaultFactory{
+		Commands: map[string]func(app.Context) app.Command{
+			"hello":  func(ctx app.Context) app.Command { return cmd.NewVersion(ctx) },
+			"deploy": func(ctx app.Context) app.Command { return cmd.NewVersion(ctx) },
+			"start":  func(ctx app.Context) app.Command { return cmd.NewVersion(ctx) },
+		},
+	}
+
+	// Custom commands are made before external commands, but cannot replace
+	// built-in commands
+	c, err := f.Make("hello", app.Context{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*cmd.Version); !ok {
+		t.Errorf("hello: got %T, expected *cmd.Version", c)
+	}
+	c, err = f.Make("start", app.Context{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*cmd.Start); !ok {
+		t.Errorf("start: got %T, expected *cmd.Start", c)
+	}
+
+	expect := []string{"deploy", "hello"}
+	if diff := deep.Equal(f.Custom(), expect); diff != nil {
+		t.Error(diff)
+	}
+}
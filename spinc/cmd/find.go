@@ -4,12 +4,14 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/spinc/app"
+	"github.com/square/spincycle/v2/spinc/config"
 )
 
 const (
@@ -28,6 +30,8 @@ const (
 var (
 	findTimeColLen = len(findTimeFmt)
 	findUtcIndex   = strings.Index(findTimeFmt, "UTC")
+
+	findSorts = []string{proto.SORT_CREATED, proto.SORT_STARTED, proto.SORT_FINISHED, proto.SORT_STATE, proto.SORT_TYPE}
 )
 
 type Find struct {
@@ -35,6 +39,7 @@ type Find struct {
 
 	local  bool // If true, output times in local time, else output times in UTC
 	filter proto.RequestFilter
+	save   []string // filters to save as query --save, if set
 }
 
 func NewFind(ctx app.Context) *Find {
@@ -49,40 +54,65 @@ func (c *Find) Prepare() error {
 	validArgs := map[string]bool{
 		"timezone": true,
 
-		"type":   true,
-		"states": true,
-		"user":   true,
-		"args":   true,
-		"since":  true,
-		"until":  true,
-		"limit":  true,
-		"offset": true,
+		"type":     true,
+		"states":   true,
+		"user":     true,
+		"args":     true,
+		"resource": true,
+		"since":    true,
+		"until":    true,
+		"sort":     true,
+		"order":    true,
+		"limit":    true,
+		"offset":   true,
 	}
-	args := map[string]string{}
-	for _, arg := range c.ctx.Command.Args {
-		split := strings.SplitN(arg, "=", 2)
-		if len(split) != 2 {
-			return fmt.Errorf("Invalid command arg %s: expected arg of form filter=value (should contain exactly one '=')", arg)
+	parse := func(cmdArgs []string) (map[string]string, error) {
+		args := map[string]string{}
+		for _, arg := range cmdArgs {
+			split := strings.SplitN(arg, "=", 2)
+			if len(split) != 2 {
+				return nil, fmt.Errorf("Invalid command arg %s: expected arg of form filter=value (should contain exactly one '=')", arg)
+			}
+			arg := split[0]
+			value := split[1]
+
+			if !validArgs[arg] {
+				return nil, fmt.Errorf("Invalid arg '%s'", arg)
+			}
+			if _, ok := args[arg]; ok {
+				return nil, fmt.Errorf("Filter '%s' specified multiple times", arg)
+			}
+			args[arg] = value
+
+			if c.ctx.Options.Debug {
+				app.Debug("arg '%s'='%s'", arg, value)
+			}
 		}
-		arg := split[0]
-		value := split[1]
+		return args, nil
+	}
+	args, err := parse(c.ctx.Command.Args)
+	if err != nil {
+		return err
+	}
 
-		if !validArgs[arg] {
-			return fmt.Errorf("Invalid arg '%s'", arg)
+	// Saved query (--saved), overridden by args on the command line
+	if name := c.ctx.Options.Saved; name != "" {
+		saved, ok := c.ctx.Options.FindQueries[name]
+		if !ok {
+			return fmt.Errorf("No saved query '%s'. Run 'spinc help find' to list saved queries.", name)
 		}
-		if _, ok := args[arg]; ok {
-			return fmt.Errorf("Filter '%s' specified multiple times", arg)
+		savedArgs, err := parse(saved)
+		if err != nil {
+			return fmt.Errorf("Invalid saved query '%s': %s", name, err)
 		}
-		args[arg] = value
-
-		if c.ctx.Options.Debug {
-			app.Debug("arg '%s'='%s'", arg, value)
+		for arg, value := range savedArgs {
+			if _, ok := args[arg]; !ok {
+				args[arg] = value
+			}
 		}
 	}
 
 	/* Process some args. */
-	var err error
-
 	local := false
 	switch strings.ToLower(args["timezone"]) {
 	case "":
@@ -137,6 +167,20 @@ func (c *Find) Prepare() error {
 		}
 	}
 
+	sortBy := strings.ToLower(args["sort"])
+	switch sortBy {
+	case "", proto.SORT_CREATED, proto.SORT_STARTED, proto.SORT_FINISHED, proto.SORT_STATE, proto.SORT_TYPE:
+	default:
+		return fmt.Errorf("Invalid sort '%s', expected one of: %s", args["sort"], strings.Join(findSorts, ", "))
+	}
+
+	order := strings.ToLower(args["order"])
+	switch order {
+	case "", proto.SORT_ASC, proto.SORT_DESC:
+	default:
+		return fmt.Errorf("Invalid order '%s', expected '%s' or '%s'", args["order"], proto.SORT_ASC, proto.SORT_DESC)
+	}
+
 	var limit uint
 	if args["limit"] == "" {
 		limit = findLimitDefault
@@ -158,6 +202,16 @@ func (c *Find) Prepare() error {
 	}
 
 	/* Save args. */
+	if name := strings.TrimPrefix(c.ctx.Options.Save, "name="); name != "" {
+		if strings.ContainsAny(name, "= ") {
+			return fmt.Errorf("Invalid query name '%s': must not contain '=' or spaces", name)
+		}
+		c.save = make([]string, 0, len(args))
+		for arg, value := range args {
+			c.save = append(c.save, arg+"="+value)
+		}
+		sort.Strings(c.save)
+	}
 	c.local = local
 	c.filter = proto.RequestFilter{
 		Type:   args["type"],
@@ -165,9 +219,14 @@ func (c *Find) Prepare() error {
 		User:   args["user"],
 		Args:   requestArgs,
 
+		Resource: args["resource"],
+
 		Since: since,
 		Until: until,
 
+		Sort:  sortBy,
+		Order: order,
+
 		Limit:  limit,
 		Offset: offset,
 	}
@@ -176,6 +235,17 @@ func (c *Find) Prepare() error {
 }
 
 func (c *Find) Run() error {
+	if c.save != nil {
+		name := strings.TrimPrefix(c.ctx.Options.Save, "name=")
+		file := config.UserConfigFile(c.ctx.Options.Config)
+		if err := config.SaveFindQuery(file, name, c.save); err != nil {
+			return fmt.Errorf("Cannot save query '%s' to %s: %s", name, file, err)
+		}
+		if c.ctx.Hooks.CommandRunResult == nil {
+			fmt.Fprintf(c.ctx.Out, "OK, saved query '%s' to %s. Run 'spinc find --saved %s' to use it.\n", name, file, name)
+		}
+	}
+
 	requests, err := c.ctx.RMClient.FindRequests(c.filter)
 	if err != nil {
 		return err
@@ -240,10 +310,14 @@ func (c *Find) Run() error {
 }
 
 func (c *Find) Cmd() string {
+	cmd := "find"
+	if c.ctx.Options.Saved != "" {
+		cmd += " --saved " + c.ctx.Options.Saved
+	}
 	if len(c.ctx.Command.Args) > 0 {
-		return "find " + strings.Join(c.ctx.Command.Args, " ")
+		cmd += " " + strings.Join(c.ctx.Command.Args, " ")
 	}
-	return "find"
+	return cmd
 }
 
 func (c *Find) Help() string {
@@ -269,14 +343,44 @@ Filters:
   states      comma-separated list of request states to include
   user        return only requests made by this user
   args        return requests made with specific args (format: arg1=value1,arg2=value2)
+  resource    return requests with a job that affected this resource, like a host ID
   since       return requests created or run after this time
   until       return requests created or run before this time
+  sort        sort requests by %s (default: created)
+  order       sort order asc or desc (default: desc)
   limit       limit response to this many requests (default: %d)
   offset      skip the first <offset> requests
 Times should be formated as '%s'. Time should be specified in UTC.
-`, findLimitDefault,
+Requests not started or finished are last when sorting by started or finished.
+For example, oldest running requests first: states=RUNNING sort=started order=asc
+Resources are reported by jobs, so only requests with jobs that report them are found.
+
+Saved queries:
+  --save <name>   save the args and filters as a query in the config file (default: %s)
+  --saved <name>  use a saved query; args and filters on the command line override it
+For example, save then use a query: spinc find --save failed-restarts type=restart-host states=FAIL
+                                    spinc find --saved failed-restarts since='2020-06-01 00:00:00 UTC'
+%s`, findLimitDefault,
 		strings.Join(getAllProtoStates(), " | "), findTimeFmt,
-		findLimitDefault, findTimeFmt)
+		strings.Join(findSorts, " | "), findLimitDefault, findTimeFmt,
+		config.UserConfigFile(""), c.savedQueries())
+}
+
+// savedQueries returns the saved queries for help, sorted by name.
+func (c *Find) savedQueries() string {
+	if len(c.ctx.Options.FindQueries) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(c.ctx.Options.FindQueries))
+	for name := range c.ctx.Options.FindQueries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s := "Saved queries in config files:\n"
+	for _, name := range names {
+		s += fmt.Sprintf("  %-15s %s\n", name, strings.Join(c.ctx.Options.FindQueries[name], " "))
+	}
+	return s
 }
 
 func getAllProtoStates() []string {
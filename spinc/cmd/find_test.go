@@ -5,9 +5,14 @@ package cmd_test
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/go-test/deep"
+
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/spinc/app"
 	"github.com/square/spincycle/v2/spinc/cmd"
@@ -376,3 +381,130 @@ func TestFindRunNoRequests(t *testing.T) {
 		t.Errorf("Wrong output:\nactual output:\n%s\nexpected:\n%s\n", output, expectedOutput)
 	}
 }
+
+func TestFindSort(t *testing.T) {
+	var gotFilter proto.RequestFilter
+	rmc := &mock.RMClient{
+		FindRequestsFunc: func(f proto.RequestFilter) ([]proto.Request, error) {
+			gotFilter = f
+			return nil, nil
+		},
+	}
+	ctx := app.Context{
+		Out:      &bytes.Buffer{},
+		RMClient: rmc,
+		Command: config.Command{
+			Args: []string{"states=RUNNING", "sort=Started", "order=ASC"},
+		},
+	}
+	find := cmd.NewFind(ctx)
+	if err := find.Prepare(); err != nil {
+		t.Fatalf("Unexpected error in 'Prepare': %s", err)
+	}
+	if err := find.Run(); err != nil {
+		t.Fatalf("Unexpected error in 'Run': %s", err)
+	}
+	if gotFilter.Sort != proto.SORT_STARTED || gotFilter.Order != proto.SORT_ASC {
+		t.Errorf("got sort %q order %q, expected %q %q", gotFilter.Sort, gotFilter.Order, proto.SORT_STARTED, proto.SORT_ASC)
+	}
+
+	for _, arg := range []string{"sort=user", "order=up"} {
+		ctx.Command.Args = []string{arg}
+		find = cmd.NewFind(ctx)
+		if err := find.Prepare(); err == nil {
+			t.Errorf("No error in 'Prepare' with invalid input %s", arg)
+		}
+	}
+}
+
+func TestFindResource(t *testing.T) {
+	var gotFilter proto.RequestFilter
+	rmc := &mock.RMClient{
+		FindRequestsFunc: func(f proto.RequestFilter) ([]proto.Request, error) {
+			gotFilter = f
+			return nil, nil
+		},
+	}
+	ctx := app.Context{
+		Out:      &bytes.Buffer{},
+		RMClient: rmc,
+		Command: config.Command{
+			Args: []string{"resource=db01", "since=2020-08-02 15:00:00 UTC"},
+		},
+	}
+	find := cmd.NewFind(ctx)
+	if err := find.Prepare(); err != nil {
+		t.Fatalf("Unexpected error in 'Prepare': %s", err)
+	}
+	if err := find.Run(); err != nil {
+		t.Fatalf("Unexpected error in 'Run': %s", err)
+	}
+	if gotFilter.Resource != "db01" {
+		t.Errorf("got resource %q, expected db01", gotFilter.Resource)
+	}
+}
+
+func TestFindSavedQuery(t *testing.T) {
+	var gotFilter proto.RequestFilter
+	rmc := &mock.RMClient{
+		FindRequestsFunc: func(f proto.RequestFilter) ([]proto.Request, error) {
+			gotFilter = f
+			return nil, nil
+		},
+	}
+	dir, err := ioutil.TempDir("", "spinc-find")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "spinc.yaml")
+	if err := ioutil.WriteFile(file, []byte("addr: http://rm:32308\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Save
+	ctx := app.Context{
+		Out:      &bytes.Buffer{},
+		RMClient: rmc,
+		Options:  config.Options{Config: file, Save: "failed-restarts"},
+		Command: config.Command{
+			Args: []string{"type=restart-host", "states=FAIL", "args=env=prod"},
+		},
+	}
+	find := cmd.NewFind(ctx)
+	if err := find.Prepare(); err != nil {
+		t.Fatalf("Unexpected error in 'Prepare': %s", err)
+	}
+	if err := find.Run(); err != nil {
+		t.Fatalf("Unexpected error in 'Run': %s", err)
+	}
+	o := config.ParseConfigFiles(file, false)
+	if o.Addr != "http://rm:32308" {
+		t.Errorf("addr = %q, expected config file options to be kept", o.Addr)
+	}
+	expect := []string{"args=env=prod", "states=FAIL", "type=restart-host"}
+	if diff := deep.Equal(o.FindQueries["failed-restarts"], expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Use saved, overriding a filter
+	ctx.Options = o
+	ctx.Options.Saved = "failed-restarts"
+	ctx.Command.Args = []string{"type=stop-host"}
+	find = cmd.NewFind(ctx)
+	if err := find.Prepare(); err != nil {
+		t.Fatalf("Unexpected error in 'Prepare': %s", err)
+	}
+	if err := find.Run(); err != nil {
+		t.Fatalf("Unexpected error in 'Run': %s", err)
+	}
+	if gotFilter.Type != "stop-host" || len(gotFilter.States) != 1 || gotFilter.States[0] != proto.STATE_FAIL || gotFilter.Args["env"] != "prod" {
+		t.Errorf("got filter %+v, expected type stop-host, state FAIL, and arg env=prod", gotFilter)
+	}
+
+	ctx.Options.Saved = "nope"
+	find = cmd.NewFind(ctx)
+	if err := find.Prepare(); err == nil {
+		t.Error("No error in 'Prepare' with unknown saved query")
+	}
+}
@@ -4,6 +4,7 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/spinc/app"
@@ -79,24 +80,58 @@ func (c *Help) Usage() {
 	fmt.Fprintf(c.ctx.Out, "Usage: spinc [flags] command [request|id] [args]\n\n"+
 		"Flags:\n"+
 		"  --addr     Request Manager address (default: %s)\n"+
+		"  --args     Print submitted, request, and job args (status only)\n"+
+		"  --at       Print status as of a past time (status only)\n"+
 		"  --config   Config files (default: %s)\n"+
 		"  --debug    Print debug to stderr\n"+
 		"  --env      Environment (dev, staging, production)\n"+
 		"  --help     Print help\n"+
+		"  --log-level Log level of jobs: debug, info, warn, error (start only)\n"+
+		"  --non-interactive Never prompt, fail if input is missing (for scripts)\n"+
+		"  --override Execution override name=value allowed by the request spec, repeatable (start only)\n"+
+		"  --preset   Start a request preset: its request and args (start only)\n"+
+		"  --read-only Only view: refuse commands that change anything, login makes a read-only token\n"+
+		"  --run-as   Start or stop as another user (admins only), recorded as the operator\n"+
+		"  --save     Save filters as a named query in the config file (find only)\n"+
+		"  --saved    Use a saved query (find only)\n"+
+		"  --spec-cache Request list cache file, or 'off' (default: %s)\n"+
 		"  --timeout  API timeout, milliseconds (default: %d ms)\n"+
+		"  --tls-ca   CA file to verify Request Manager certificate (enables TLS)\n"+
+		"  --tls-cert Client certificate file for mutual TLS\n"+
+		"  --tls-key  Client key file for mutual TLS\n"+
+		"  --token-file API token file for login/logout (default: %s)\n"+
 		"  --version  Print version\n"+
 		"Commands:\n"+
+		"  approve <ID> <job ID>  Approve gate job waiting for approval\n"+
 		"  find    [filters]  Print (optionally) filtered request history\n"+
 		"  help    <cmd|req>  Print command or request help\n"+
 		"  info    <ID>       Print complete request information\n"+
 		"  log     <ID>       Print job log (tip: pipe output to less)\n"+
+		"  login   [args]     Create and save an API token for later commands\n"+
+		"  logout             Revoke and delete the saved API token\n"+
+		"  pause   <ID>       Pause request: start no new jobs until resumed\n"+
+		"  profile <ID> [args] List, capture, or save request profiles (admins capture)\n"+
+		"  presets            List request presets (spinc --preset <name> start)\n"+
 		"  ps      [ID]       Show running requests and jobs (request ID optional)\n"+
+		"  replay-job <ID> <job ID>  Run one job of a past request locally (args: real=true)\n"+
+		"  report  <ID>       Save report of finished request (args: format=html|markdown o=file)\n"+
+		"  resume  <ID>       Resume paused request\n"+
 		"  running <ID>       Exit 0 if request is pending or running, else exit 1\n"+
 		"  start   <request>  Start new request\n"+
-		"  status  <ID>       Print request status and basic information\n"+
-		"  stop    <ID>       Stop request\n"+
-		"  version            Print Spin Cycle version\n",
-		config.DEFAULT_ADDR, config.DEFAULT_CONFIG_FILES, config.DEFAULT_TIMEOUT)
+		"  status  <ID...>    Print request status and basic information\n"+
+		"  stop    <ID>       Stop request (args: preview=true to print what it would interrupt)\n"+
+		"  timeline <ID>      Print when each job ran (text Gantt chart)\n"+
+		"  version            Print Spin Cycle version\n"+
+		"  wait    <ID...>    Wait for requests to finish, exit 1 if any did not complete\n",
+		config.DEFAULT_ADDR, config.DEFAULT_CONFIG_FILES, config.DEFAULT_SPEC_CACHE_FILE, config.DEFAULT_TIMEOUT, config.DEFAULT_TOKEN_FILE)
+	if f, ok := c.ctx.Factories.Command.(*DefaultFactory); ok {
+		if custom := f.Custom(); len(custom) > 0 {
+			fmt.Fprintf(c.ctx.Out, "Custom commands (run 'spinc help <cmd>'):\n")
+			for _, name := range custom {
+				fmt.Fprintf(c.ctx.Out, "  %s\n", name)
+			}
+		}
+	}
 	fmt.Fprintf(c.ctx.Out, "\nRun spinc (no command) to lists requests\n")
 }
 
@@ -158,6 +193,26 @@ func (c *Help) RequestHelp(reqName string) error {
 		}
 		fmt.Fprintf(c.ctx.Out, line, star, a.Name, help)
 	}
+	if len(req.Overrides) > 0 {
+		fmt.Fprintf(c.ctx.Out, "\n%s request overrides (--override name=value)\n\n", req.Name)
+		l = 0
+		for _, o := range req.Overrides {
+			if len(o.Name) > l {
+				l = len(o.Name)
+			}
+		}
+		line = fmt.Sprintf("  %%-%ds  %%s\n", l)
+		for _, o := range req.Overrides {
+			help := o.Desc
+			if len(o.Values) > 0 {
+				help += " (values: " + strings.Join(o.Values, ", ") + ")"
+			}
+			if o.Pattern != "" {
+				help += " (pattern: " + o.Pattern + ")"
+			}
+			fmt.Fprintf(c.ctx.Out, line, o.Name, strings.TrimSpace(help))
+		}
+	}
 	fmt.Fprintf(c.ctx.Out, "\nTo start a %s request, run 'spinc start %s'\n", reqName, reqName)
 	return nil
 }
@@ -40,6 +40,10 @@ func TestHelpRequestHelp(t *testing.T) {
 								Default: "abc",
 							},
 						},
+						Overrides: []proto.RequestOverride{
+							{Name: "new-lb", Desc: "use new load balancer", Values: []string{"on", "off"}},
+							{Name: "dns-endpoint", Pattern: `https://.*\.staging\.example\.com`},
+						},
 					},
 				}
 				return req, nil
@@ -70,6 +74,11 @@ req1 request args (* required)
   * foo  foo arg
     bar  bar arg (default: abc)
 
+req1 request overrides (--override name=value)
+
+  new-lb        use new load balancer (values: on, off)
+  dns-endpoint  (pattern: https://.*\.staging\.example\.com)
+
 To start a req1 request, run 'spinc start req1'
 `
 	gotOutput := out.String()
@@ -71,6 +71,9 @@ func (c *Info) Run() error {
 	fmt.Fprintf(c.ctx.Out, "      id: %s\n", r.Id)
 	fmt.Fprintf(c.ctx.Out, " request: %s\n", r.Type)
 	fmt.Fprintf(c.ctx.Out, "  caller: %s\n", r.User)
+	if r.Operator != "" {
+		fmt.Fprintf(c.ctx.Out, "operator: %s (run-as)\n", r.Operator)
+	}
 	fmt.Fprintf(c.ctx.Out, " created: %s (%s ago)\n", r.CreatedAt.Format(tsFormat), now.Sub(r.CreatedAt).Round(time.Second))
 	fmt.Fprintf(c.ctx.Out, " started: %s\n", started)
 	fmt.Fprintf(c.ctx.Out, "finished: %s\n", finished)
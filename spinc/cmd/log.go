@@ -5,6 +5,7 @@ package cmd
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/square/spincycle/v2/proto"
@@ -72,13 +73,29 @@ func (c *Log) Run() error {
 		fmt.Printf("job type: %s\n", l.Type)
 		fmt.Printf("state:    %s\n", proto.StateName[l.State])
 		fmt.Printf("exit:     %d\n", l.Exit)
-		fmt.Printf("error:    %s\n", l.Error)
+		if l.ErrorClass != "" {
+			fmt.Printf("error:    %s (%s)\n", l.Error, l.ErrorClass)
+		} else {
+			fmt.Printf("error:    %s\n", l.Error)
+		}
 		fmt.Printf("try:      %d\n", l.Try)
 		fmt.Printf("runtime:  %fs\n", d.Seconds())
 		fmt.Printf("started:  %s\n", started)
 		fmt.Printf("finished: %s\n", finished)
 		fmt.Printf("stdout:   %s\n", l.Stdout)
 		fmt.Printf("stderr:   %s\n", l.Stderr)
+		if len(l.Log) > 0 {
+			fmt.Printf("log:\n")
+			for _, e := range l.Log {
+				fmt.Printf("  %s\n", logEntryString(e))
+			}
+		}
+		if len(l.Execs) > 0 {
+			fmt.Printf("execs:\n")
+			for _, e := range l.Execs {
+				fmt.Printf("  %s\n", execRecordString(e))
+			}
+		}
 
 		if i < n-1 {
 			fmt.Print(RECORD_SEPARATOR)
@@ -88,6 +105,35 @@ func (c *Log) Run() error {
 	return nil
 }
 
+// logEntryString returns a job log entry as one line, like
+// "2020-06-01T12:00:00.000Z WARN  msg host=db1".
+func logEntryString(e proto.LogEntry) string {
+	line := fmt.Sprintf("%s %-5s %s", time.Unix(0, e.Ts).UTC().Format("2006-01-02T15:04:05.000Z"), strings.ToUpper(e.Level), e.Msg)
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		line += fmt.Sprintf(" %s=%v", k, e.Fields[k])
+	}
+	return line
+}
+
+// execRecordString returns a command run by a job as one line, like
+// "2020-06-01T12:00:00.000Z exit=0 1.2s [/tmp/ws] mysqladmin ping".
+func execRecordString(e proto.ExecRecord) string {
+	line := fmt.Sprintf("%s exit=%d %s", time.Unix(0, e.StartedAt).UTC().Format("2006-01-02T15:04:05.000Z"), e.Exit, time.Duration(e.Duration))
+	if e.Dir != "" {
+		line += " [" + e.Dir + "]"
+	}
+	line += " " + strings.Join(e.Args, " ")
+	if e.Error != "" {
+		line += " (" + e.Error + ")"
+	}
+	return line
+}
+
 func (c *Log) Cmd() string {
 	return "log " + c.reqId
 }
@@ -0,0 +1,131 @@
+// Copyright 2020, Square, Inc.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/spinc/app"
+	"github.com/square/spincycle/v2/spinc/config"
+)
+
+type Login struct {
+	ctx app.Context
+	ct  proto.CreateToken
+}
+
+func NewLogin(ctx app.Context) *Login {
+	return &Login{
+		ctx: ctx,
+	}
+}
+
+func (c *Login) Prepare() error {
+	for _, arg := range c.ctx.Command.Args {
+		split := strings.SplitN(arg, "=", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("Invalid command arg %s: expected arg of form key=value", arg)
+		}
+		switch split[0] {
+		case "name":
+			c.ct.Name = split[1]
+		case "ttl":
+			c.ct.TTL = split[1]
+		case "ops":
+			c.ct.Ops = strings.Split(split[1], ",")
+		case "requests":
+			c.ct.Requests = strings.Split(split[1], ",")
+		default:
+			return fmt.Errorf("Invalid arg '%s'. Run 'spinc help login' to list valid args.", split[0])
+		}
+	}
+	if c.ct.Name == "" {
+		c.ct.Name = "spinc"
+	}
+	c.ct.ReadOnly = c.ctx.Options.ReadOnly
+	return nil
+}
+
+func (c *Login) Run() error {
+	tok, err := c.ctx.RMClient.CreateToken(c.ct)
+	if err != nil {
+		return err
+	}
+	file := tokenFile(c.ctx)
+	if err := config.SaveToken(file, tok); err != nil {
+		return fmt.Errorf("Created token %s but cannot save it to %s: %s", tok.Id, file, err)
+	}
+	fmt.Fprintf(c.ctx.Out, "OK, logged in as %s (token %s expires %s), saved token to %s\n",
+		tok.User, tok.Id, tok.ExpiresAt.Local().Format(time.RFC3339), file)
+	return nil
+}
+
+func (c *Login) Cmd() string {
+	return "login"
+}
+
+func (c *Login) Help() string {
+	return "'spinc login [name=N] [ttl=T] [ops=O] [requests=R]' creates an API token and saves it to --token-file.\n" +
+		"Later commands authenticate with the token until it expires or 'spinc logout'.\n" +
+		"Login authenticates like other commands without a token, and the token has your roles.\n" +
+		"With --read-only, the token can only view, for dashboards and scripts that only look.\n\n" +
+		"Args:\n" +
+		"  name       Token description (default: spinc)\n" +
+		"  ttl        How long the token is valid, like 8h (default and max: Request Manager auth.token_max_ttl)\n" +
+		"  ops        Comma-separated ops the token allows: start, stop (default: all)\n" +
+		"  requests   Comma-separated requests the token allows, \"prefix*\" matches a prefix (default: all)\n"
+}
+
+// --------------------------------------------------------------------------
+
+type Logout struct {
+	ctx app.Context
+}
+
+func NewLogout(ctx app.Context) *Logout {
+	return &Logout{
+		ctx: ctx,
+	}
+}
+
+func (c *Logout) Prepare() error {
+	return nil
+}
+
+func (c *Logout) Run() error {
+	file := tokenFile(c.ctx)
+	tok, err := config.LoadToken(file)
+	if err != nil {
+		return fmt.Errorf("Cannot load token from %s: %s", file, err)
+	}
+	if tok.Id == "" {
+		fmt.Fprintf(c.ctx.Out, "Not logged in (no token in %s)\n", file)
+		return nil
+	}
+	if err := c.ctx.RMClient.RevokeToken(tok.Id); err != nil {
+		return fmt.Errorf("Cannot revoke token %s: %s", tok.Id, err)
+	}
+	if err := config.DeleteToken(file); err != nil {
+		return fmt.Errorf("Revoked token %s but cannot delete %s: %s", tok.Id, file, err)
+	}
+	fmt.Fprintf(c.ctx.Out, "OK, logged out (revoked token %s)\n", tok.Id)
+	return nil
+}
+
+func (c *Logout) Cmd() string {
+	return "logout"
+}
+
+func (c *Logout) Help() string {
+	return "'spinc logout' revokes the API token saved by 'spinc login' and deletes --token-file.\n"
+}
+
+func tokenFile(ctx app.Context) string {
+	if ctx.Options.TokenFile != "" {
+		return ctx.Options.TokenFile
+	}
+	return config.DEFAULT_TOKEN_FILE
+}
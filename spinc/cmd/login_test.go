@@ -0,0 +1,106 @@
+// Copyright 2020, Square, Inc.
+
+package cmd_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/spinc/app"
+	"github.com/square/spincycle/v2/spinc/cmd"
+	"github.com/square/spincycle/v2/spinc/config"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+func TestLoginLogout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spinc-login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+
+	tok := proto.Token{
+		Id:        "tok1",
+		Name:      "laptop",
+		User:      "dn",
+		ExpiresAt: time.Now().Add(time.Hour).UTC().Round(time.Second),
+		Secret:    "spin_secret",
+	}
+	var gotCT proto.CreateToken
+	var revoked string
+	rmc := &mock.RMClient{
+		CreateTokenFunc: func(ct proto.CreateToken) (proto.Token, error) {
+			gotCT = ct
+			return tok, nil
+		},
+		RevokeTokenFunc: func(id string) error {
+			revoked = id
+			return nil
+		},
+	}
+	ctx := app.Context{
+		Out:      &bytes.Buffer{},
+		RMClient: rmc,
+		Options:  config.Options{TokenFile: tokenFile},
+		Command: config.Command{
+			Cmd:  "login",
+			Args: []string{"name=laptop", "ttl=1h", "ops=stop", "requests=a,b*"},
+		},
+	}
+	login := cmd.NewLogin(ctx)
+	if err := login.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := login.Run(); err != nil {
+		t.Fatal(err)
+	}
+	expectCT := proto.CreateToken{Name: "laptop", TTL: "1h", Ops: []string{"stop"}, Requests: []string{"a", "b*"}}
+	if diff := deep.Equal(gotCT, expectCT); diff != nil {
+		t.Error(diff)
+	}
+
+	// Token is cached with its secret
+	gotTok, err := config.LoadToken(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(gotTok, tok); diff != nil {
+		t.Error(diff)
+	}
+	fi, err := os.Stat(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("token file mode %o, expected 0600", fi.Mode().Perm())
+	}
+
+	ctx.Command = config.Command{Cmd: "logout"}
+	logout := cmd.NewLogout(ctx)
+	if err := logout.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := logout.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if revoked != tok.Id {
+		t.Errorf("revoked token %q, expected %s", revoked, tok.Id)
+	}
+	if _, err := os.Stat(tokenFile); !os.IsNotExist(err) {
+		t.Errorf("token file exists after logout, expected it to be deleted")
+	}
+
+	// Invalid arg
+	ctx.Command = config.Command{Cmd: "login", Args: []string{"foo=bar"}}
+	if err := cmd.NewLogin(ctx).Prepare(); err == nil {
+		t.Errorf("no error for invalid arg, expected one")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020, Square, Inc.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/square/spincycle/v2/spinc/app"
+)
+
+type Pause struct {
+	ctx   app.Context
+	reqId string
+}
+
+func NewPause(ctx app.Context) *Pause {
+	return &Pause{
+		ctx: ctx,
+	}
+}
+
+func (c *Pause) Prepare() error {
+	if len(c.ctx.Command.Args) == 0 {
+		return fmt.Errorf("Usage: spinc pause <id>\n")
+	}
+	c.reqId = c.ctx.Command.Args[0]
+	return nil
+}
+
+func (c *Pause) Run() error {
+	if err := c.ctx.RMClient.PauseRequest(c.reqId); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.ctx.Out, "OK, paused %s\n", c.reqId)
+	return nil
+}
+
+func (c *Pause) Cmd() string {
+	return "pause " + c.reqId
+}
+
+func (c *Pause) Help() string {
+	return "'spinc pause <request ID>' pauses the request: no new jobs are started, running jobs finish.\n" +
+		"The request keeps running until it's resumed with 'spinc resume <request ID>' or stopped.\n"
+}
+
+// --------------------------------------------------------------------------
+
+type Resume struct {
+	ctx   app.Context
+	reqId string
+}
+
+func NewResume(ctx app.Context) *Resume {
+	return &Resume{
+		ctx: ctx,
+	}
+}
+
+func (c *Resume) Prepare() error {
+	if len(c.ctx.Command.Args) == 0 {
+		return fmt.Errorf("Usage: spinc resume <id>\n")
+	}
+	c.reqId = c.ctx.Command.Args[0]
+	return nil
+}
+
+func (c *Resume) Run() error {
+	if err := c.ctx.RMClient.ResumeRequest(c.reqId); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.ctx.Out, "OK, resumed %s\n", c.reqId)
+	return nil
+}
+
+func (c *Resume) Cmd() string {
+	return "resume " + c.reqId
+}
+
+func (c *Resume) Help() string {
+	return "'spinc resume <request ID>' resumes a request paused with 'spinc pause <request ID>'.\n"
+}
@@ -0,0 +1,68 @@
+// Copyright 2020, Square, Inc.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/square/spincycle/v2/spinc/app"
+)
+
+type Presets struct {
+	ctx app.Context
+}
+
+func NewPresets(ctx app.Context) *Presets {
+	return &Presets{
+		ctx: ctx,
+	}
+}
+
+func (c *Presets) Prepare() error {
+	if len(c.ctx.Command.Args) != 0 {
+		return fmt.Errorf("Usage: spinc presets\n")
+	}
+	return nil
+}
+
+func (c *Presets) Run() error {
+	presets, err := c.ctx.RMClient.ListPresets()
+	if err != nil {
+		return err
+	}
+
+	if c.ctx.Hooks.CommandRunResult != nil {
+		c.ctx.Hooks.CommandRunResult(presets, err)
+		return nil
+	}
+
+	if len(presets) == 0 {
+		fmt.Fprintf(c.ctx.Out, "No presets. Run 'spinc help presets' to learn how to create them.\n")
+		return nil
+	}
+	w := tabwriter.NewWriter(c.ctx.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tREQUEST\tARGS\tDESCRIPTION\n")
+	for _, p := range presets {
+		args := make([]string, 0, len(p.Args))
+		for k, v := range p.Args {
+			args = append(args, k+"="+QuoteArgValue(presetArgValue(v)))
+		}
+		sort.Strings(args)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Name, p.Type, strings.Join(args, " "), p.Description)
+	}
+	return w.Flush()
+}
+
+func (c *Presets) Cmd() string {
+	return "presets"
+}
+
+func (c *Presets) Help() string {
+	return "'spinc presets' lists request presets: named requests with args, like a nightly backup of one database.\n" +
+		"Start a preset with 'spinc --preset <name> start [args]'. Args given override preset args.\n\n" +
+		"Presets are created, replaced, and deleted with the Request Manager API: PUT and DELETE /api/v1/presets/<name>.\n" +
+		"Anyone allowed to start the request can create a preset; only its creator or admins can change or delete it.\n"
+}
@@ -0,0 +1,118 @@
+// Copyright 2020, Square, Inc.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/spinc/app"
+)
+
+type Profile struct {
+	ctx       app.Context
+	reqId     string
+	capture   bool
+	duration  string
+	profileId string
+	file      string
+}
+
+func NewProfile(ctx app.Context) *Profile {
+	return &Profile{
+		ctx: ctx,
+	}
+}
+
+func (c *Profile) Prepare() error {
+	if len(c.ctx.Command.Args) == 0 {
+		return fmt.Errorf("Usage: spinc profile <id> [capture [duration=D] | <profile id> [o=file]]\n")
+	}
+	c.reqId = c.ctx.Command.Args[0]
+	args := c.ctx.Command.Args[1:]
+	if len(args) == 0 {
+		return nil // list
+	}
+	if args[0] == "capture" {
+		c.capture = true
+	} else {
+		c.profileId = args[0]
+	}
+	for _, arg := range args[1:] {
+		split := strings.SplitN(arg, "=", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("Invalid command arg %s: expected arg of form key=value", arg)
+		}
+		switch {
+		case split[0] == "duration" && c.capture:
+			c.duration = split[1]
+		case split[0] == "o" && !c.capture:
+			c.file = split[1]
+		default:
+			return fmt.Errorf("Invalid arg '%s'. Run 'spinc help profile' to list valid args.", split[0])
+		}
+	}
+	return nil
+}
+
+func (c *Profile) Run() error {
+	switch {
+	case c.capture:
+		if err := c.ctx.RMClient.CaptureProfile(c.reqId, proto.ProfileCapture{Duration: c.duration}); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.ctx.Out, "OK, capturing profiles of %s. Run 'spinc profile %s' to list them when done.\n", c.reqId, c.reqId)
+		return nil
+	case c.profileId != "":
+		data, err := c.ctx.RMClient.GetProfile(c.reqId, c.profileId)
+		if err != nil {
+			return err
+		}
+		file := c.file
+		if file == "" {
+			file = c.profileId + ".pprof"
+		}
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			return fmt.Errorf("Cannot write profile to %s: %s", file, err)
+		}
+		fmt.Fprintf(c.ctx.Out, "OK, wrote profile %s to %s. Run 'go tool pprof %s' to analyze it.\n", c.profileId, file, file)
+		return nil
+	}
+
+	profiles, err := c.ctx.RMClient.GetProfiles(c.reqId)
+	if err != nil {
+		return err
+	}
+
+	if c.ctx.Hooks.CommandRunResult != nil {
+		c.ctx.Hooks.CommandRunResult(profiles, err)
+		return nil
+	}
+
+	if len(profiles) == 0 {
+		fmt.Fprintf(c.ctx.Out, "No profiles. Run 'spinc profile %s capture' while the request is running.\n", c.reqId)
+		return nil
+	}
+	w := tabwriter.NewWriter(c.ctx.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tTYPE\tJOB RUNNER\tSTARTED\tSECONDS\tBYTES\n")
+	for _, p := range profiles {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.1f\t%d\n", p.Id, p.Type, p.JobRunner, p.StartedAt.Local().Format("2006-01-02 15:04:05"), p.FinishedAt.Sub(p.StartedAt).Seconds(), p.Size)
+	}
+	return w.Flush()
+}
+
+func (c *Profile) Cmd() string {
+	return "profile " + c.reqId
+}
+
+func (c *Profile) Help() string {
+	return "'spinc profile <request ID>' lists the CPU and heap profiles captured while the request ran.\n" +
+		"'spinc profile <request ID> capture [duration=D]' captures profiles on the Job Runner running the request:\n" +
+		"a CPU profile for the duration (default 30s) or until the request is done, then a heap profile.\n" +
+		"Only admins can capture profiles, and the Job Runner must have profiling enabled.\n" +
+		"'spinc profile <request ID> <profile ID> [o=file]' saves a profile (default file: <profile ID>.pprof)\n" +
+		"to analyze with 'go tool pprof'.\n"
+}
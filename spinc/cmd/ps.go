@@ -78,7 +78,7 @@ func (c *Ps) Run() error {
 		if r, ok := status.Requests[j.RequestId]; ok {
 			reqName = r.Type
 			reqId = r.Id
-			reqPrg = fmt.Sprintf("%.0f%%", float64(r.FinishedJobs)/float64(r.TotalJobs)*100)
+			reqPrg = requestProgress(r)
 			reqUser = r.User
 		}
 		runtime := now.Sub(time.Unix(0, j.StartedAt)).Round(time.Second)
@@ -0,0 +1,196 @@
+// Copyright 2020, Square, Inc.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/square/spincycle/v2/job"
+	"github.com/square/spincycle/v2/jobs"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/spinc/app"
+	"github.com/square/spincycle/v2/spinc/prompt"
+)
+
+// ReplayJob runs one job of a past request locally with the bytes, args, and
+// jobData it ran with, for debugging job code against real inputs. The job is
+// made by the job factory that spinc was built with (app.Factories.Job).
+type ReplayJob struct {
+	ctx   app.Context
+	reqId string
+	jobId string
+	real  bool // real=true: call Run (real side effects), else DryRun
+}
+
+func NewReplayJob(ctx app.Context) *ReplayJob {
+	return &ReplayJob{
+		ctx: ctx,
+	}
+}
+
+func (c *ReplayJob) Prepare() error {
+	if len(c.ctx.Command.Args) < 2 {
+		return fmt.Errorf("Usage: spinc replay-job <request ID> <job ID> [real=true]\n")
+	}
+	c.reqId = c.ctx.Command.Args[0]
+	c.jobId = c.ctx.Command.Args[1]
+	for _, arg := range c.ctx.Command.Args[2:] {
+		split := strings.SplitN(arg, "=", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("Invalid command arg %s: expected arg of form key=value", arg)
+		}
+		switch split[0] {
+		case "real":
+			switch split[1] {
+			case "true":
+				if c.ctx.Options.ReadOnly {
+					return fmt.Errorf("Cannot replay with real=true with --read-only: the job would have real side effects")
+				}
+				c.real = true
+			case "false":
+			default:
+				return fmt.Errorf("Invalid real=%s: expected true or false", split[1])
+			}
+		default:
+			return fmt.Errorf("Invalid arg '%s'. Run 'spinc help replay-job' to list valid args.", split[0])
+		}
+	}
+	return nil
+}
+
+func (c *ReplayJob) Run() error {
+	snap, err := c.ctx.RMClient.GetJobSnapshot(c.reqId, c.jobId)
+	if err != nil {
+		return err
+	}
+	if c.ctx.Options.Debug {
+		app.Debug("job snapshot: %#v", snap)
+	}
+	pJob := snap.Job
+
+	fmt.Fprintf(c.ctx.Out, "    job: %s (%s), type %s\n", pJob.Name, pJob.Id, pJob.Type)
+	fmt.Fprintf(c.ctx.Out, "request: %s, user %s\n", snap.RequestId, snap.User)
+	fmt.Fprintf(c.ctx.Out, "   args: %s\n", jsonString(pJob.Args))
+	fmt.Fprintf(c.ctx.Out, "   data: %s\n", jsonString(pJob.Data))
+	if snap.Last != nil {
+		fmt.Fprintf(c.ctx.Out, "   last: try %d %s, exit %d", snap.Last.Try, proto.StateName[snap.Last.State], snap.Last.Exit)
+		if snap.Last.Error != "" {
+			fmt.Fprintf(c.ctx.Out, ", error: %s", snap.Last.Error)
+		}
+		fmt.Fprintln(c.ctx.Out)
+	} else {
+		fmt.Fprintf(c.ctx.Out, "   last: did not run\n")
+	}
+
+	// Re-create the job like the Job Runner: make, deserialize, set globals
+	jf := c.ctx.Factories.Job
+	if jf == nil {
+		jf = jobs.Factory
+	}
+	realJob, err := jf.Make(job.NewIdWithRequestId(pJob.Type, pJob.Name, pJob.Id, snap.RequestId))
+	if err != nil {
+		return fmt.Errorf("cannot make job: %s", err)
+	}
+	if err := realJob.Deserialize(pJob.Bytes); err != nil {
+		return fmt.Errorf("cannot deserialize job: %s", err)
+	}
+	if gj, ok := realJob.(job.UsesGlobals); ok {
+		globals := make(map[string]interface{}, len(snap.Globals))
+		for k, v := range snap.Globals {
+			globals[k] = v
+		}
+		gj.SetGlobals(globals)
+	}
+	if oj, ok := realJob.(job.UsesOverrides); ok {
+		overrides := make(map[string]string, len(snap.Overrides))
+		for k, v := range snap.Overrides {
+			overrides[k] = v
+		}
+		oj.SetOverrides(overrides)
+	}
+	if aj, ok := realJob.(job.Authenticated); ok {
+		// No delegated token: the job runs as the user, but with the
+		// credentials of whoever runs spinc
+		aj.SetAuth(job.Auth{User: snap.User})
+	}
+
+	jobData := pJob.Data
+	if jobData == nil {
+		jobData = map[string]interface{}{}
+	}
+	var ret job.Return
+	if c.real {
+		if !c.ctx.Options.NonInteractive {
+			fmt.Fprintf(c.ctx.Out, "\nThe job will run with real side effects.\n")
+			ok := prompt.NewConfirmationPrompt("Enter 'ok' to run, or ctrl-c to abort: ", "ok", c.ctx.In, c.ctx.Out)
+			for {
+				if err := ok.Prompt(); err == nil {
+					break
+				}
+			}
+		}
+		ret, err = realJob.Run(jobData)
+	} else {
+		dr, ok := realJob.(job.DryRunner)
+		if !ok {
+			fmt.Fprintf(c.ctx.Out, "\nNot run: job type %s does not implement job.DryRunner. Add real=true to run it with real side effects.\n", pJob.Type)
+			return nil
+		}
+		ret, err = dr.DryRun(jobData)
+	}
+
+	if c.ctx.Hooks.CommandRunResult != nil {
+		c.ctx.Hooks.CommandRunResult(ret, err)
+		return nil
+	}
+
+	mode := "dry run"
+	if c.real {
+		mode = "run"
+	}
+	fmt.Fprintf(c.ctx.Out, "\n%s: %s, exit %d\n", mode, proto.StateName[ret.State], ret.Exit)
+	if err != nil {
+		fmt.Fprintf(c.ctx.Out, "  error: %s\n", err)
+	} else if ret.Error != nil {
+		fmt.Fprintf(c.ctx.Out, "  error: %s\n", ret.Error)
+	}
+	fmt.Fprintf(c.ctx.Out, "   data: %s\n", jsonString(jobData))
+	if ret.Stdout != "" {
+		fmt.Fprintf(c.ctx.Out, "STDOUT:\n%s\n", strings.TrimRight(ret.Stdout, "\n"))
+	}
+	if ret.Stderr != "" {
+		fmt.Fprintf(c.ctx.Out, "STDERR:\n%s\n", strings.TrimRight(ret.Stderr, "\n"))
+	}
+	return nil
+}
+
+// jsonString returns v as compact JSON, or its Go value if it cannot be
+// marshaled.
+func jsonString(v interface{}) string {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(bytes)
+}
+
+func (c *ReplayJob) Cmd() string {
+	cmd := "replay-job " + c.reqId + " " + c.jobId
+	if c.real {
+		cmd += " real=true"
+	}
+	return cmd
+}
+
+func (c *ReplayJob) Help() string {
+	return "'spinc replay-job <request ID> <job ID> [real=true]' runs one job of a past\n" +
+		"request locally with the bytes, args, and job data it ran with, for debugging\n" +
+		"job code against real inputs. The job data is from the job log of its upstream\n" +
+		"jobs, which must have completed. spinc must be built with your jobs package.\n\n" +
+		"By default, the job is dry run: DryRun is called if the job implements\n" +
+		"job.DryRunner, else the job is not run. With real=true, Run is called, with\n" +
+		"real side effects, after confirmation (unless --non-interactive).\n" +
+		"It prints the job return, the job data after running, and STDOUT and STDERR.\n"
+}
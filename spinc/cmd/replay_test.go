@@ -0,0 +1,166 @@
+// Copyright 2020, Square, Inc.
+
+package cmd_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/job"
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/spinc/app"
+	"github.com/square/spincycle/v2/spinc/cmd"
+	"github.com/square/spincycle/v2/spinc/config"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+// dryJob is a mock job that implements job.DryRunner.
+type dryJob struct {
+	*mock.Job
+	dryRunData map[string]interface{}
+}
+
+func (j *dryJob) DryRun(jobData map[string]interface{}) (job.Return, error) {
+	j.dryRunData = map[string]interface{}{}
+	for k, v := range jobData {
+		j.dryRunData[k] = v
+	}
+	jobData["drained"] = true
+	return job.Return{State: proto.STATE_COMPLETE, Stdout: "would drain host1"}, nil
+}
+
+type dryJobFactory struct {
+	job *dryJob
+}
+
+func (f dryJobFactory) Make(id job.Id) (job.Job, error) {
+	f.job.IdResp = id
+	return f.job, nil
+}
+
+func TestReplayJob(t *testing.T) {
+	var gotReqId, gotJobId string
+	rmc := &mock.RMClient{
+		GetJobSnapshotFunc: func(reqId, jobId string) (proto.JobSnapshot, error) {
+			gotReqId = reqId
+			gotJobId = jobId
+			return proto.JobSnapshot{
+				RequestId: reqId,
+				User:      "finch",
+				Job: proto.Job{
+					Id:   jobId,
+					Name: "drain",
+					Type: "drain-host",
+					Args: map[string]interface{}{"host": "host1"},
+					Data: map[string]interface{}{"ip": "10.0.0.1"},
+				},
+				Last: &proto.JobLog{Try: 2, State: proto.STATE_FAIL, Exit: 1, Error: "timeout"},
+			}, nil
+		},
+	}
+
+	// Dry run: DryRun called with the job data, Run not called
+	ran := false
+	dj := &dryJob{Job: &mock.Job{
+		RunFunc: func(map[string]interface{}) (job.Return, error) {
+			ran = true
+			return job.Return{State: proto.STATE_COMPLETE}, nil
+		},
+	}}
+	output := &bytes.Buffer{}
+	ctx := app.Context{
+		Out:       output,
+		RMClient:  rmc,
+		Factories: app.Factories{Job: dryJobFactory{job: dj}},
+		Command: config.Command{
+			Cmd:  "replay-job",
+			Args: []string{"b9uvdi8tk9kahl8ppvbg", "a1b2"},
+		},
+	}
+	replay := cmd.NewReplayJob(ctx)
+	if err := replay.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := replay.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if gotReqId != "b9uvdi8tk9kahl8ppvbg" || gotJobId != "a1b2" {
+		t.Errorf("got snapshot of %s %s, expected b9uvdi8tk9kahl8ppvbg a1b2", gotReqId, gotJobId)
+	}
+	if ran {
+		t.Error("job Run called on dry run")
+	}
+	if diff := deep.Equal(dj.dryRunData, map[string]interface{}{"ip": "10.0.0.1"}); diff != nil {
+		t.Error(diff)
+	}
+	expectOutput := `    job: drain (a1b2), type drain-host
+request: b9uvdi8tk9kahl8ppvbg, user finch
+   args: {"host":"host1"}
+   data: {"ip":"10.0.0.1"}
+   last: try 2 FAIL, exit 1, error: timeout
+
+dry run: COMPLETE, exit 0
+   data: {"drained":true,"ip":"10.0.0.1"}
+STDOUT:
+would drain host1
+`
+	if output.String() != expectOutput {
+		t.Errorf("got output:\n%s\nexpected:\n%s", output, expectOutput)
+	}
+
+	// real=true: Run called, no confirmation with --non-interactive
+	output.Reset()
+	ctx.Options = config.Options{NonInteractive: true}
+	ctx.Command.Args = []string{"b9uvdi8tk9kahl8ppvbg", "a1b2", "real=true"}
+	replay = cmd.NewReplayJob(ctx)
+	if err := replay.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := replay.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !ran {
+		t.Error("job Run not called with real=true")
+	}
+
+	// Job without DryRun: not run
+	ran = false
+	output.Reset()
+	ctx.Factories.Job = &mock.JobFactory{MockJobs: map[string]*mock.Job{"drain-host": dj.Job}}
+	ctx.Command.Args = []string{"b9uvdi8tk9kahl8ppvbg", "a1b2"}
+	replay = cmd.NewReplayJob(ctx)
+	if err := replay.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := replay.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if ran {
+		t.Error("job Run called on dry run")
+	}
+	if !strings.Contains(output.String(), "does not implement job.DryRunner") {
+		t.Errorf("output does not say job not run:\n%s", output)
+	}
+}
+
+func TestReplayJobArgs(t *testing.T) {
+	for _, args := range [][]string{{"b9uvdi8tk9kahl8ppvbg"}, {"b9uvdi8tk9kahl8ppvbg", "a1b2", "real=yes"}, {"b9uvdi8tk9kahl8ppvbg", "a1b2", "foo=bar"}} {
+		replay := cmd.NewReplayJob(app.Context{Command: config.Command{Cmd: "replay-job", Args: args}})
+		if err := replay.Prepare(); err == nil {
+			t.Errorf("no error for args %v, expected one", args)
+		}
+	}
+}
+
+func TestReplayJobReadOnly(t *testing.T) {
+	ctx := app.Context{
+		Options: config.Options{ReadOnly: true},
+		Command: config.Command{Cmd: "replay-job", Args: []string{"b9uvdi8tk9kahl8ppvbg", "a1b2", "real=true"}},
+	}
+	if err := cmd.NewReplayJob(ctx).Prepare(); err == nil {
+		t.Errorf("no error for real=true with --read-only, expected one")
+	}
+}
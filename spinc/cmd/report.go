@@ -0,0 +1,92 @@
+// Copyright 2020, Square, Inc.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/square/spincycle/v2/spinc/app"
+)
+
+type Report struct {
+	ctx    app.Context
+	reqId  string
+	format string
+	file   string
+}
+
+func NewReport(ctx app.Context) *Report {
+	return &Report{
+		ctx: ctx,
+	}
+}
+
+func (c *Report) Prepare() error {
+	if len(c.ctx.Command.Args) == 0 {
+		return fmt.Errorf("Usage: spinc report <id> [format=html|markdown] [o=file]\n")
+	}
+	c.reqId = c.ctx.Command.Args[0]
+	for _, arg := range c.ctx.Command.Args[1:] {
+		split := strings.SplitN(arg, "=", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("Invalid command arg %s: expected arg of form key=value", arg)
+		}
+		switch split[0] {
+		case "format":
+			c.format = split[1]
+		case "o":
+			c.file = split[1]
+		default:
+			return fmt.Errorf("Invalid arg '%s'. Run 'spinc help report' to list valid args.", split[0])
+		}
+	}
+	if c.format == "" {
+		switch strings.ToLower(filepath.Ext(c.file)) {
+		case ".md", ".markdown":
+			c.format = "markdown"
+		default:
+			c.format = "html"
+		}
+	}
+	if c.format != "html" && c.format != "markdown" {
+		return fmt.Errorf("Invalid format '%s': expected html or markdown", c.format)
+	}
+	return nil
+}
+
+func (c *Report) Run() error {
+	report, err := c.ctx.RMClient.GetReport(c.reqId, c.format)
+	if err != nil {
+		return err
+	}
+
+	if c.ctx.Hooks.CommandRunResult != nil {
+		c.ctx.Hooks.CommandRunResult(report, err)
+		return nil
+	}
+
+	if c.file == "" {
+		_, err := c.ctx.Out.Write(report)
+		return err
+	}
+	if err := ioutil.WriteFile(c.file, report, 0644); err != nil {
+		return fmt.Errorf("Cannot write report to %s: %s", c.file, err)
+	}
+	fmt.Fprintf(c.ctx.Out, "OK, wrote %s report of request %s to %s\n", c.format, c.reqId, c.file)
+	return nil
+}
+
+func (c *Report) Cmd() string {
+	return "report " + c.reqId
+}
+
+func (c *Report) Help() string {
+	return "'spinc report <request ID> [format=F] [o=file]' saves a self-contained report of a finished request:\n" +
+		"its args, job chain image, job timings, and the logs of failed job tries.\n\n" +
+		"Args:\n" +
+		"  format     html or markdown (default: markdown if file ends in .md, else html)\n" +
+		"  o          File to write report to (default: print report)\n"
+}
@@ -0,0 +1,88 @@
+// Copyright 2020, Square, Inc.
+
+package cmd_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/square/spincycle/v2/spinc/app"
+	"github.com/square/spincycle/v2/spinc/cmd"
+	"github.com/square/spincycle/v2/spinc/config"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+func TestReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spinc-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var gotId, gotFormat string
+	rmc := &mock.RMClient{
+		GetReportFunc: func(id, format string) ([]byte, error) {
+			gotId = id
+			gotFormat = format
+			return []byte("# Request " + id + "\n"), nil
+		},
+	}
+
+	// Format from file extension, written to file
+	file := filepath.Join(dir, "report.md")
+	output := &bytes.Buffer{}
+	ctx := app.Context{
+		Out:      output,
+		RMClient: rmc,
+		Options:  config.Options{},
+		Command: config.Command{
+			Cmd:  "report",
+			Args: []string{"b9uvdi8tk9kahl8ppvbg", "o=" + file},
+		},
+	}
+	report := cmd.NewReport(ctx)
+	if err := report.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := report.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if gotId != "b9uvdi8tk9kahl8ppvbg" || gotFormat != "markdown" {
+		t.Errorf("got report of %s in %s, expected b9uvdi8tk9kahl8ppvbg in markdown", gotId, gotFormat)
+	}
+	got, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "# Request b9uvdi8tk9kahl8ppvbg\n" {
+		t.Errorf("got report %q, expected %q", got, "# Request b9uvdi8tk9kahl8ppvbg\n")
+	}
+
+	// Default format, printed
+	output.Reset()
+	ctx.Command.Args = []string{"b9uvdi8tk9kahl8ppvbg"}
+	report = cmd.NewReport(ctx)
+	if err := report.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := report.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if gotFormat != "html" {
+		t.Errorf("got format %s, expected html", gotFormat)
+	}
+	if output.String() != "# Request b9uvdi8tk9kahl8ppvbg\n" {
+		t.Errorf("got output %q, expected report", output.String())
+	}
+
+	// Invalid args
+	for _, args := range [][]string{{}, {"id", "format=pdf"}, {"id", "file=x"}} {
+		ctx.Command.Args = args
+		if err := cmd.NewReport(ctx).Prepare(); err == nil {
+			t.Errorf("args %v: no error, expected one", args)
+		}
+	}
+}
@@ -3,6 +3,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
@@ -15,29 +16,63 @@ import (
 type Start struct {
 	ctx app.Context
 	// --
-	reqName      string
-	requiredArgs []prompt.Item
-	optionalArgs []prompt.Item
-	debug        bool
-	args         map[string]interface{}
-	fullCmd      string
+	reqName        string
+	requiredArgs   []prompt.Item
+	optionalArgs   []prompt.Item
+	debug          bool
+	nonInteractive bool
+	args           map[string]interface{}
+	overrides      map[string]string
+	fullCmd        string
 }
 
 func NewStart(ctx app.Context) *Start {
 	return &Start{
-		ctx:   ctx,
-		debug: ctx.Options.Debug, // brevity
+		ctx:            ctx,
+		debug:          ctx.Options.Debug, // brevity
+		nonInteractive: ctx.Options.NonInteractive,
 	}
 }
 
 func (c *Start) Prepare() error {
 	cmd := c.ctx.Command
 
-	if len(cmd.Args) == 0 {
-		return fmt.Errorf("Usage: spinc start <request> [args]\n'spinc' for request list")
+	// With --preset, the request and default args are the preset's. The request
+	// name is optional, but it must be the preset request if given.
+	var preset *proto.RequestPreset
+	if name := c.ctx.Options.Preset; name != "" {
+		p, err := c.ctx.RMClient.GetPreset(name)
+		if err != nil {
+			return fmt.Errorf("Cannot get preset %s from API: %s", name, err)
+		}
+		preset = &p
+		if len(cmd.Args) > 0 && !strings.Contains(cmd.Args[0], "=") {
+			if cmd.Args[0] != p.Type {
+				return fmt.Errorf("Preset %s starts request %s, not %s", name, p.Type, cmd.Args[0])
+			}
+			cmd.Args = cmd.Args[1:] // shift request name
+		}
+		c.reqName = p.Type
+	} else {
+		if len(cmd.Args) == 0 {
+			return fmt.Errorf("Usage: spinc start <request> [args]\n'spinc' for request list, 'spinc presets' for presets")
+		}
+		c.reqName = cmd.Args[0]
+		cmd.Args = cmd.Args[1:] // shift request name
+	}
+
+	// Split --override name=value. The RM checks that the request spec allows
+	// them (overrides:).
+	for _, keyval := range c.ctx.Options.Override {
+		p := strings.SplitN(keyval, "=", 2)
+		if len(p) != 2 || p[0] == "" {
+			return fmt.Errorf("Invalid --override: %s: expected name=value", keyval)
+		}
+		if c.overrides == nil {
+			c.overrides = map[string]string{}
+		}
+		c.overrides[p[0]] = p[1]
 	}
-	c.reqName = cmd.Args[0]
-	cmd.Args = cmd.Args[1:] // shift request name
 
 	// Get request list from API
 	reqList, err := c.ctx.RMClient.RequestList()
@@ -58,8 +93,13 @@ func (c *Start) Prepare() error {
 		return app.ErrUnknownRequest
 	}
 
-	// Split and save request args given on cmd line
+	// Split and save request args given on cmd line, which override preset args
 	given := map[string]string{}
+	if preset != nil {
+		for k, v := range preset.Args {
+			given[k] = presetArgValue(v)
+		}
+	}
 	for _, keyval := range cmd.Args {
 		p := strings.SplitN(keyval, "=", 2)
 		if len(p) != 2 {
@@ -74,7 +114,9 @@ func (c *Start) Prepare() error {
 	// optional args. But if any args are given, then we presume user knows
 	// what they're doing and we skip all optional args (let them use default
 	// values) and only prompt for missing required args.
-	argsGiven := len(given) > 0
+	// With --non-interactive, there are no prompts, so it's the same as if
+	// args were given: optional args not given use their default values.
+	argsGiven := len(given) > 0 || c.nonInteractive
 
 	// Group request args by required. We prompt for required first, then optional,
 	// both in the order as listed in the request spec because, normally, we list
@@ -84,6 +126,7 @@ func (c *Start) Prepare() error {
 	// the optional args.
 	c.requiredArgs = []prompt.Item{}
 	c.optionalArgs = []prompt.Item{}
+	missing := []string{}
 	for _, a := range req.Args {
 		defaultValue := ""
 		if a.Default != nil {
@@ -109,6 +152,9 @@ func (c *Start) Prepare() error {
 
 		// Save the arg/item
 		if i.Required {
+			if !i.Skip {
+				missing = append(missing, a.Name)
+			}
 			c.requiredArgs = append(c.requiredArgs, i)
 		} else {
 			// Optional arg
@@ -120,7 +166,7 @@ func (c *Start) Prepare() error {
 				// If optional arg not given, use its default value
 				if _, ok := given[a.Name]; !ok {
 					i.IsDefault = true
-					i.Value = a.Default.(string)
+					i.Value = defaultValue
 					if c.debug {
 						app.Debug("optional arg %s using default value %s", a.Name, i.Value)
 					}
@@ -149,15 +195,26 @@ func (c *Start) Prepare() error {
 		}
 	}
 
+	// Without prompts, missing required args cannot be entered, so fail now
+	// rather than start a request that the RM will reject
+	if c.nonInteractive && len(missing) != 0 {
+		return ErrMissingArgs{
+			Request: c.reqName,
+			Args:    missing,
+		}
+	}
+
 	return nil
 }
 
 func (c *Start) Run() error {
 	// Prompt user for missing required args and possibly optional args
-	p := prompt.NewGuidedPrompt(c.requiredArgs, c.ctx.In, c.ctx.Out)
-	p.Prompt()
-	p = prompt.NewGuidedPrompt(c.optionalArgs, c.ctx.In, c.ctx.Out)
-	p.Prompt()
+	if !c.nonInteractive {
+		p := prompt.NewGuidedPrompt(c.requiredArgs, c.ctx.In, c.ctx.Out)
+		p.Prompt()
+		p = prompt.NewGuidedPrompt(c.optionalArgs, c.ctx.In, c.ctx.Out)
+		p.Prompt()
+	}
 
 	if c.debug {
 		app.Debug("required args: %#v", c.requiredArgs)
@@ -170,25 +227,45 @@ func (c *Start) Run() error {
 	if c.debug {
 		app.Debug("request args: %#v", c.args)
 	}
-	fmt.Printf("\n# spinc %s\n\n", c.fullCmd)
-
-	// Prompt for 'ok' until user enters it or aborts
-	ok := prompt.NewConfirmationPrompt("Enter 'ok' to start, or ctrl-c to abort: ", "ok", c.ctx.In, c.ctx.Out)
-	for {
-		if err := ok.Prompt(); err == nil {
-			break
+	if !c.nonInteractive {
+		fmt.Fprintf(c.ctx.Out, "\n# spinc %s\n\n", c.fullCmd)
+
+		// Prompt for 'ok' until user enters it or aborts
+		ok := prompt.NewConfirmationPrompt("Enter 'ok' to start, or ctrl-c to abort: ", "ok", c.ctx.In, c.ctx.Out)
+		for {
+			if err := ok.Prompt(); err == nil {
+				break
+			}
 		}
 	}
 
 	// //////////////////////////////////////////////////////////////////////
 	// Start request
 	// //////////////////////////////////////////////////////////////////////
-	reqId, err := c.ctx.RMClient.CreateRequest(c.reqName, c.args)
+	var reqId string
+	var err error
+	if c.ctx.Options.LogLevel != "" || len(c.overrides) > 0 {
+		reqId, err = c.ctx.RMClient.CreateRequestWith(proto.CreateRequest{
+			Type:      c.reqName,
+			Args:      c.args,
+			LogLevel:  c.ctx.Options.LogLevel,
+			Overrides: c.overrides,
+		})
+	} else {
+		reqId, err = c.ctx.RMClient.CreateRequest(c.reqName, c.args)
+	}
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("OK, started %s request %s\n\n"+
+	// With --non-interactive, print only the request ID so callers can
+	// capture it. Do not change this output: scripts depend on it.
+	if c.nonInteractive {
+		fmt.Fprintln(c.ctx.Out, reqId)
+		return nil
+	}
+
+	fmt.Fprintf(c.ctx.Out, "OK, started %s request %s\n\n"+
 		"  spinc status %s%s\n\n", c.reqName, reqId, c.userOptionsString(), reqId)
 
 	return nil
@@ -219,6 +296,12 @@ func (c *Start) Cmd() string {
 	}
 
 	fullCmd := c.userOptionsString()
+	if c.ctx.Options.LogLevel != "" {
+		fullCmd += "--log-level " + escapeArg(c.ctx.Options.LogLevel) + " "
+	}
+	for _, keyval := range c.ctx.Options.Override {
+		fullCmd += "--override " + escapeArg(keyval) + " "
+	}
 
 	fullCmd += "start " + c.reqName
 	args := map[string]interface{}{}
@@ -241,7 +324,28 @@ func (c *Start) Cmd() string {
 
 func (c *Start) Help() string {
 	return "'spinc start <request> [args]' starts a new request.\n" +
-		"Request args can be provided, else spinc prompts for them. Run 'spinc help <request>' to list the request args.\n"
+		"Request args can be provided, else spinc prompts for them. Run 'spinc help <request>' to list the request args.\n\n" +
+		"With --non-interactive, spinc does not prompt: all required args must be given, optional args not given\n" +
+		"use their default values, the request is started without confirmation, and only the request ID is printed.\n\n" +
+		"With --preset <name>, the request and args are the preset's ('spinc presets' lists them). The request can be\n" +
+		"omitted, and args given override preset args.\n\n" +
+		"With --log-level, jobs that log save entries at or above the level (debug, info, warn, error) in the job log.\n" +
+		"The default is info. Use --log-level debug to enable verbose job logging for one request.\n\n" +
+		"With --override name=value (repeatable), jobs get execution overrides for this request only, like feature\n" +
+		"flags and endpoints. Only overrides allowed by the request spec can be set ('spinc help <request>').\n"
+}
+
+// presetArgValue returns a preset arg value as given on the command line: strings
+// as is, other values as JSON.
+func presetArgValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(bytes)
 }
 
 // Escapes strings with whitespace using double quotes
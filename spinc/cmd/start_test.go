@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"testing"
 
+	"github.com/go-test/deep"
+
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/spinc/app"
 	"github.com/square/spincycle/v2/spinc/cmd"
@@ -176,3 +178,275 @@ func TestStartTestRequestWithOptionsHavingSpaces(t *testing.T) {
 		t.Errorf("got cmd '%s', expected '%s'", gotCmd, expectCmd)
 	}
 }
+
+func TestStartNonInteractive(t *testing.T) {
+	specs := []proto.RequestSpec{
+		{
+			Name: "test",
+			Args: []proto.RequestArg{
+				{
+					Name: "foo",
+					Desc: "foo is required",
+					Type: proto.ARG_TYPE_REQUIRED,
+				},
+				{
+					Name: "baz",
+					Desc: "baz is required",
+					Type: proto.ARG_TYPE_REQUIRED,
+				},
+				{
+					Name:    "bar",
+					Desc:    "bar is optional",
+					Default: "brr",
+					Type:    proto.ARG_TYPE_OPTIONAL,
+				},
+			},
+		},
+	}
+	var gotArgs map[string]interface{}
+	output := &bytes.Buffer{}
+	ctx := app.Context{
+		In:  &bytes.Buffer{}, // nothing to read: any prompt would block or fail
+		Out: output,
+		RMClient: &mock.RMClient{
+			RequestListFunc: func() ([]proto.RequestSpec, error) {
+				return specs, nil
+			},
+			CreateRequestFunc: func(name string, args map[string]interface{}) (string, error) {
+				gotArgs = args
+				return "b9uvdi8tk9kahl8ppvbg", nil
+			},
+		},
+		Options: config.Options{NonInteractive: true},
+		Command: config.Command{
+			Cmd:  "start",
+			Args: []string{"test", "foo=val"},
+		},
+	}
+
+	// Missing required arg baz
+	start := cmd.NewStart(ctx)
+	err := start.Prepare()
+	if err == nil {
+		t.Fatal("no error, expected ErrMissingArgs")
+	}
+	missing, ok := err.(cmd.ErrMissingArgs)
+	if !ok {
+		t.Fatalf("got error type %T, expected ErrMissingArgs", err)
+	}
+	if missing.Request != "test" || len(missing.Args) != 1 || missing.Args[0] != "baz" {
+		t.Errorf("got %+v, expected request test missing arg baz", missing)
+	}
+
+	// All required args given: start without prompts, print only the request ID
+	ctx.Command.Args = []string{"test", "foo=val", "baz=z"}
+	start = cmd.NewStart(ctx)
+	if err := start.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := start.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != "b9uvdi8tk9kahl8ppvbg\n" {
+		t.Errorf("got output %q, expected only the request ID", output.String())
+	}
+	expectArgs := map[string]interface{}{"foo": "val", "baz": "z"}
+	if diff := deep.Equal(gotArgs, expectArgs); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestStartLogLevel(t *testing.T) {
+	specs := []proto.RequestSpec{
+		{
+			Name: "test",
+			Args: []proto.RequestArg{
+				{
+					Name: "foo",
+					Desc: "foo is required",
+					Type: proto.ARG_TYPE_REQUIRED,
+				},
+			},
+		},
+	}
+	var gotReq proto.CreateRequest
+	ctx := app.Context{
+		In:  &bytes.Buffer{},
+		Out: &bytes.Buffer{},
+		RMClient: &mock.RMClient{
+			RequestListFunc: func() ([]proto.RequestSpec, error) {
+				return specs, nil
+			},
+			CreateRequestFunc: func(name string, args map[string]interface{}) (string, error) {
+				t.Error("CreateRequest called, expected CreateRequestWith")
+				return "", nil
+			},
+			CreateRequestWithFunc: func(req proto.CreateRequest) (string, error) {
+				gotReq = req
+				return "b9uvdi8tk9kahl8ppvbg", nil
+			},
+		},
+		Options: config.Options{NonInteractive: true, LogLevel: "debug"},
+		Command: config.Command{
+			Cmd:  "start",
+			Args: []string{"test", "foo=val"},
+		},
+	}
+	start := cmd.NewStart(ctx)
+	if err := start.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := start.Run(); err != nil {
+		t.Fatal(err)
+	}
+	expect := proto.CreateRequest{
+		Type:     "test",
+		Args:     map[string]interface{}{"foo": "val"},
+		LogLevel: "debug",
+	}
+	if diff := deep.Equal(gotReq, expect); diff != nil {
+		t.Error(diff)
+	}
+	expectCmd := "--log-level debug start test foo=val"
+	if start.Cmd() != expectCmd {
+		t.Errorf("got cmd %q, expected %q", start.Cmd(), expectCmd)
+	}
+}
+
+func TestStartOverrides(t *testing.T) {
+	specs := []proto.RequestSpec{
+		{
+			Name: "test",
+			Args: []proto.RequestArg{
+				{
+					Name: "foo",
+					Desc: "foo is required",
+					Type: proto.ARG_TYPE_REQUIRED,
+				},
+			},
+		},
+	}
+	var gotReq proto.CreateRequest
+	ctx := app.Context{
+		In:  &bytes.Buffer{},
+		Out: &bytes.Buffer{},
+		RMClient: &mock.RMClient{
+			RequestListFunc: func() ([]proto.RequestSpec, error) {
+				return specs, nil
+			},
+			CreateRequestFunc: func(name string, args map[string]interface{}) (string, error) {
+				t.Error("CreateRequest called, expected CreateRequestWith")
+				return "", nil
+			},
+			CreateRequestWithFunc: func(req proto.CreateRequest) (string, error) {
+				gotReq = req
+				return "b9uvdi8tk9kahl8ppvbg", nil
+			},
+		},
+		Options: config.Options{
+			NonInteractive: true,
+			Override:       []string{"new-lb=on", "dns-endpoint=https://dns.staging.example.com"},
+		},
+		Command: config.Command{
+			Cmd:  "start",
+			Args: []string{"test", "foo=val"},
+		},
+	}
+	start := cmd.NewStart(ctx)
+	if err := start.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := start.Run(); err != nil {
+		t.Fatal(err)
+	}
+	expect := proto.CreateRequest{
+		Type: "test",
+		Args: map[string]interface{}{"foo": "val"},
+		Overrides: map[string]string{
+			"new-lb":       "on",
+			"dns-endpoint": "https://dns.staging.example.com",
+		},
+	}
+	if diff := deep.Equal(gotReq, expect); diff != nil {
+		t.Error(diff)
+	}
+	expectCmd := "--override new-lb=on --override dns-endpoint=https://dns.staging.example.com start test foo=val"
+	if start.Cmd() != expectCmd {
+		t.Errorf("got cmd %q, expected %q", start.Cmd(), expectCmd)
+	}
+
+	ctx.Options.Override = []string{"new-lb"}
+	if err := cmd.NewStart(ctx).Prepare(); err == nil {
+		t.Error("no error for --override without value, expected one")
+	}
+}
+
+func TestStartPreset(t *testing.T) {
+	specs := []proto.RequestSpec{
+		{
+			Name: "backup-db",
+			Args: []proto.RequestArg{
+				{
+					Name: "host",
+					Desc: "host is required",
+					Type: proto.ARG_TYPE_REQUIRED,
+				},
+				{
+					Name:    "retain",
+					Desc:    "retain is optional",
+					Default: "7",
+					Type:    proto.ARG_TYPE_OPTIONAL,
+				},
+			},
+		},
+	}
+	var gotName string
+	var gotArgs map[string]interface{}
+	ctx := app.Context{
+		In:  &bytes.Buffer{},
+		Out: &bytes.Buffer{},
+		RMClient: &mock.RMClient{
+			RequestListFunc: func() ([]proto.RequestSpec, error) {
+				return specs, nil
+			},
+			GetPresetFunc: func(name string) (proto.RequestPreset, error) {
+				return proto.RequestPreset{
+					Name: name,
+					Type: "backup-db",
+					Args: map[string]interface{}{"host": "db01", "retain": 30},
+				}, nil
+			},
+			CreateRequestFunc: func(name string, args map[string]interface{}) (string, error) {
+				gotName = name
+				gotArgs = args
+				return "b9uvdi8tk9kahl8ppvbg", nil
+			},
+		},
+		Options: config.Options{NonInteractive: true, Preset: "nightly-backup-db01"},
+		Command: config.Command{
+			Cmd:  "start",
+			Args: []string{"retain=14"}, // request name is optional, args override preset args
+		},
+	}
+	start := cmd.NewStart(ctx)
+	if err := start.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := start.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if gotName != "backup-db" {
+		t.Errorf("started request %s, expected backup-db", gotName)
+	}
+	expectArgs := map[string]interface{}{"host": "db01", "retain": "14"}
+	if diff := deep.Equal(gotArgs, expectArgs); diff != nil {
+		t.Error(diff)
+	}
+
+	// Request name given must be the preset request
+	ctx.Command.Args = []string{"restore-db"}
+	start = cmd.NewStart(ctx)
+	if err := start.Prepare(); err == nil {
+		t.Error("no error for request restore-db, expected preset request mismatch error")
+	}
+}
@@ -4,6 +4,7 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -11,9 +12,19 @@ import (
 	"github.com/square/spincycle/v2/spinc/app"
 )
 
+const (
+	// formatting for outputing many requests
+	statusIdColLen      = 20
+	statusStateColLen   = 9
+	statusProgColLen    = 8
+	statusRuntimeColLen = 10
+)
+
 type Status struct {
-	ctx   app.Context
-	reqId string
+	ctx    app.Context
+	reqId  string
+	reqIds []string  // if more than one request ID given
+	at     time.Time // --at, zero if not set
 }
 
 func NewStatus(ctx app.Context) *Status {
@@ -24,13 +35,42 @@ func NewStatus(ctx app.Context) *Status {
 
 func (c *Status) Prepare() error {
 	if len(c.ctx.Command.Args) == 0 {
-		return fmt.Errorf("Usage: spinc status <request ID>\n")
+		return fmt.Errorf("Usage: spinc status <request ID> [<request ID>...]\n")
 	}
 	c.reqId = c.ctx.Command.Args[0]
+	if len(c.ctx.Command.Args) > 1 {
+		if c.ctx.Options.At != "" || c.ctx.Options.Args {
+			return fmt.Errorf("--at and --args require only one request ID\n")
+		}
+		c.reqIds = c.ctx.Command.Args
+	}
+	if c.ctx.Options.At != "" {
+		at, err := parseAt(c.ctx.Options.At)
+		if err != nil {
+			return err
+		}
+		c.at = at
+	}
 	return nil
 }
 
+// parseAt parses --at: an RFC3339 time, like 2020-06-01T12:00:00Z, or a duration
+// ago, like 30m.
+func parseAt(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --at %s: must be an RFC3339 time, like 2020-06-01T12:00:00Z, or a duration ago, like 30m", s)
+}
+
 func (c *Status) Run() error {
+	if len(c.reqIds) > 0 {
+		return c.runMany()
+	}
+
 	r, err := c.ctx.RMClient.GetRequest(c.reqId)
 	if err != nil {
 		return err
@@ -38,19 +78,34 @@ func (c *Status) Run() error {
 	if c.ctx.Options.Debug {
 		app.Debug("request: %#v", r)
 	}
+
+	// With --at, the request as it was then and the jobs running then
+	now := time.Now()
+	var running []proto.JobStatus
+	if !c.at.IsZero() {
+		status, err := c.ctx.RMClient.Running(proto.StatusFilter{RequestId: c.reqId, AsOf: c.at})
+		if err != nil {
+			return err
+		}
+		if c.ctx.Options.Debug {
+			app.Debug("status as of %s: %#v", c.at, status)
+		}
+		then := status.Requests[c.reqId]
+		r.State = then.State
+		r.StartedAt = then.StartedAt
+		r.FinishedAt = then.FinishedAt
+		r.FinishedJobs = then.FinishedJobs
+		r.Progress = then.Progress
+		running = status.Jobs
+		now = c.at
+	}
+
 	if c.ctx.Hooks.CommandRunResult != nil {
 		c.ctx.Hooks.CommandRunResult(r, err)
 		return nil
 	}
 
-	var runtime string
-	if r.StartedAt == nil || r.StartedAt.IsZero() { // not started
-		runtime = "not started"
-	} else if r.FinishedAt == nil || r.FinishedAt.IsZero() { // still running
-		runtime = time.Now().Sub(*r.StartedAt).Round(time.Second).String()
-	} else { // finished
-		runtime = r.FinishedAt.Sub(*r.StartedAt).Round(time.Second).String()
-	}
+	runtime := requestRuntime(r, now)
 
 	// For status, we only print required args in the order they're given to us,
 	// which should be the order they're listed in the request spec. The idea is:
@@ -65,21 +120,238 @@ func (c *Status) Run() error {
 		args = append(args, fmt.Sprintf("%s=%s", arg.Name, QuoteArgValue(val)))
 	}
 
+	if !c.at.IsZero() {
+		fmt.Fprintf(c.ctx.Out, "      at: %s\n", c.at.Local().Format(time.RFC3339))
+	}
 	fmt.Fprintf(c.ctx.Out, "   state: %s\n", proto.StateName[r.State])
-	fmt.Fprintf(c.ctx.Out, "progress: %s\n", fmt.Sprintf("%.0f%%", float64(r.FinishedJobs)/float64(r.TotalJobs)*100))
+	fmt.Fprintf(c.ctx.Out, "progress: %s\n", requestProgress(r))
 	fmt.Fprintf(c.ctx.Out, " runtime: %s\n", runtime)
 	fmt.Fprintf(c.ctx.Out, " request: %s\n", r.Type)
 	fmt.Fprintf(c.ctx.Out, "  caller: %s\n", r.User)
+	if r.Operator != "" {
+		fmt.Fprintf(c.ctx.Out, "operator: %s (run-as)\n", r.Operator)
+	}
 	fmt.Fprintf(c.ctx.Out, "    args: %s\n", strings.Join(args, " "))
 
+	if r.State == proto.STATE_RUNNING {
+		if c.at.IsZero() {
+			status, err := c.ctx.RMClient.Running(proto.StatusFilter{RequestId: c.reqId, Held: true})
+			if err != nil {
+				return err
+			}
+			running = status.Jobs
+		}
+		c.printRunning(running)
+	}
+
+	if c.ctx.Options.Args {
+		return c.printArgsDiff()
+	}
+
+	return nil
+}
+
+// runMany prints the status of many requests, one per line, from one call to
+// the Request Manager.
+func (c *Status) runMany() error {
+	status, err := c.ctx.RMClient.GetRequests(c.reqIds)
+	if err != nil {
+		return err
+	}
+	if c.ctx.Options.Debug {
+		app.Debug("status: %#v", status)
+	}
+
+	if c.ctx.Hooks.CommandRunResult != nil {
+		c.ctx.Hooks.CommandRunResult(status, err)
+		return nil
+	}
+
+	/*
+	   ID                   STATE     PROGRESS RUNTIME    REQUEST
+	   -------------------- 123456789 12345678 1234567890 *
+	*/
+	line := fmt.Sprintf("%%-%ds %%-%ds %%-%ds %%-%ds %%s\n",
+		statusIdColLen, statusStateColLen, statusProgColLen, statusRuntimeColLen)
+	fmt.Fprintf(c.ctx.Out, line, "ID", "STATE", "PROGRESS", "RUNTIME", "REQUEST")
+
+	now := time.Now()
+	for _, r := range status.Requests {
+		state, ok := proto.StateName[r.State]
+		if !ok {
+			state = proto.StateName[proto.STATE_UNKNOWN]
+		}
+		fmt.Fprintf(c.ctx.Out, line,
+			SqueezeString(r.Id, statusIdColLen, ".."),
+			SqueezeString(state, statusStateColLen, ".."),
+			requestProgress(r),
+			requestRuntime(r, now),
+			r.Type)
+	}
+	for _, id := range status.NotFound {
+		fmt.Fprintf(c.ctx.Out, "%-*s NOT FOUND\n", statusIdColLen, SqueezeString(id, statusIdColLen, ".."))
+	}
+	return nil
+}
+
+// requestRuntime returns how long the request has run, or "not started".
+func requestRuntime(r proto.Request, now time.Time) string {
+	if r.StartedAt == nil || r.StartedAt.IsZero() { // not started
+		return "not started"
+	} else if r.FinishedAt == nil || r.FinishedAt.IsZero() { // still running
+		return now.Sub(*r.StartedAt).Round(time.Second).String()
+	}
+	return r.FinishedAt.Sub(*r.StartedAt).Round(time.Second).String() // finished
+}
+
+// requestProgress returns the percent of the request's work done, like "40%":
+// Request.Progress, which is weighted and does not go backwards when a sequence
+// is retried. If the RM did not report progress, like for requests run by an
+// older Job Runner or status as of a past time, it's the percent of jobs finished.
+func requestProgress(r proto.Request) string {
+	if r.Progress == 0 && r.FinishedJobs > 0 && r.TotalJobs > 0 {
+		return fmt.Sprintf("%.0f%%", float64(r.FinishedJobs)/float64(r.TotalJobs)*100)
+	}
+	return fmt.Sprintf("%d%%", r.Progress)
+}
+
+// printRunning prints the running jobs, by description if the spec has one,
+// like "Draining traffic from host (check-shift-lb-v2): 3 of 5 hosts". If the
+// job's sequence is being retried, like one branch of an each: expansion, the
+// sequence try is printed, too. Then it prints held jobs (runnable but not
+// running yet) and why, like "Restart host (restart-host): pace (until ...)".
+func (c *Status) printRunning(jobs []proto.JobStatus) {
+	sort.Sort(proto.JobStatusByStartTime(jobs))
+	held := []proto.JobStatus{}
+	prefix := " running: "
+	for _, j := range jobs {
+		if j.Hold != "" && j.State != proto.STATE_RUNNING {
+			held = append(held, j)
+			continue
+		}
+		job := j.Name
+		if j.Desc != "" {
+			job = j.Desc + " (" + j.Name + ")"
+		}
+		if j.Status != "" {
+			job += ": " + j.Status
+		}
+		if j.SequenceTry > 1 {
+			job += fmt.Sprintf(" [sequence try %d]", j.SequenceTry)
+		}
+		fmt.Fprintf(c.ctx.Out, "%s%s\n", prefix, job)
+		prefix = "          "
+	}
+	prefix = "    held: "
+	for _, j := range held {
+		job := j.Name
+		if j.Desc != "" {
+			job = j.Desc + " (" + j.Name + ")"
+		}
+		job += ": " + j.Hold
+		if j.HoldDetail != "" {
+			job += " (" + j.HoldDetail + ")"
+		}
+		fmt.Fprintf(c.ctx.Out, "%s%s\n", prefix, job)
+		prefix = "          "
+	}
+}
+
+// printArgsDiff prints the args as submitted, the final request args, and the
+// resolved jobArgs of every job with where each value came from.
+func (c *Status) printArgsDiff() error {
+	d, err := c.ctx.RMClient.GetArgsDiff(c.reqId)
+	if err != nil {
+		return err
+	}
+	if c.ctx.Options.Debug {
+		app.Debug("args diff: %#v", d)
+	}
+
+	names := make([]string, 0, len(d.Submitted))
+	for name := range d.Submitted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	submitted := make([]string, len(names))
+	for i, name := range names {
+		submitted[i] = argString(name, d.Submitted[name])
+	}
+	fmt.Fprintf(c.ctx.Out, "\nsubmitted: %s\n", strings.Join(submitted, " "))
+
+	fmt.Fprintf(c.ctx.Out, "request args:\n")
+	for _, arg := range d.Args {
+		source := proto.ARG_SOURCE_DEFAULT
+		if arg.Given {
+			source = proto.ARG_SOURCE_GIVEN
+		} else if arg.Type == proto.ARG_TYPE_DERIVED {
+			source = proto.ARG_SOURCE_COMPUTED
+		}
+		fmt.Fprintf(c.ctx.Out, "  %s (%s)\n", argString(arg.Name, arg.Value), source)
+	}
+
+	fmt.Fprintf(c.ctx.Out, "job args:\n")
+	for _, job := range d.Jobs {
+		fmt.Fprintf(c.ctx.Out, "  %s (%s):\n", job.Name, job.JobId)
+		for _, arg := range job.Args {
+			source := arg.Source
+			if arg.Source == proto.ARG_SOURCE_CHANGED {
+				source += ", request: " + QuoteArgValue(fmt.Sprintf("%v", arg.Request))
+			}
+			if arg.Origin != nil {
+				source += ", from " + originString(*arg.Origin)
+			}
+			fmt.Fprintf(c.ctx.Out, "    %s (%s)\n", argString(arg.Name, arg.Value), source)
+		}
+	}
+
 	return nil
 }
 
+// originString returns where an arg value came from, like "job get-hosts (j1)".
+func originString(src proto.ArgSource) string {
+	switch src.Source {
+	case proto.ARG_SOURCE_GIVEN:
+		return "caller"
+	case proto.ARG_SOURCE_DEFAULT:
+		return "default in sequence " + src.Sequence
+	case proto.ARG_SOURCE_COMPUTED:
+		return "derived in sequence " + src.Sequence
+	case proto.ARG_SOURCE_JOB:
+		s := fmt.Sprintf("job %s (%s)", src.Node, src.JobId)
+		if src.Arg != "" {
+			s += " arg " + src.Arg
+		}
+		return s
+	case proto.ARG_SOURCE_EACH:
+		return fmt.Sprintf("each: %s in sequence %s node %s", src.Arg, src.Sequence, src.Node)
+	}
+	return src.Source
+}
+
+func argString(name string, val interface{}) string {
+	return fmt.Sprintf("%s=%s", name, QuoteArgValue(fmt.Sprintf("%v", val)))
+}
+
 func (c *Status) Cmd() string {
+	if len(c.reqIds) > 0 {
+		return "status " + strings.Join(c.reqIds, " ")
+	}
 	return "status " + c.reqId
 }
 
 func (c *Status) Help() string {
-	return "'spinc status <request ID>' prints request status and basic information.\n" +
+	return "'spinc status <request ID> [<request ID>...]' prints request status and basic information.\n" +
+		"If the request is running, it also prints its running jobs, by description if set,\n" +
+		"and held jobs: runnable but not running yet, and why (paused, pace, sequence-retry).\n" +
+		"With many request IDs, it prints the status of each request on one line, from one\n" +
+		"call to the Request Manager.\n" +
+		"With --args, it also prints the args as submitted, the final request args,\n" +
+		"and the resolved args of every job, showing which values were given, defaults,\n" +
+		"changed by a job or sequence, or derived (not a request arg), and where each\n" +
+		"value came from: the caller, a sequence default, a job, or an each: list.\n" +
+		"With --at <time>, it prints the request and its running jobs as they were then,\n" +
+		"for incident timelines. The time is RFC3339, like 2020-06-01T12:00:00Z, or a\n" +
+		"duration ago, like 30m.\n" +
 		"For complete request information, use 'spinc info <request ID>'.\n"
 }
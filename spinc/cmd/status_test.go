@@ -5,9 +5,12 @@ package cmd_test
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-test/deep"
+
 	"github.com/square/spincycle/v2/proto"
 	"github.com/square/spincycle/v2/spinc/app"
 	"github.com/square/spincycle/v2/spinc/cmd"
@@ -37,6 +40,18 @@ func TestStatusRunning(t *testing.T) {
 			}
 			return proto.Request{}, nil
 		},
+		RunningFunc: func(f proto.StatusFilter) (proto.RunningStatus, error) {
+			if f.RequestId != request.Id || !f.Held {
+				return proto.RunningStatus{}, nil
+			}
+			return proto.RunningStatus{
+				Jobs: []proto.JobStatus{
+					{RequestId: request.Id, JobId: "j3", Name: "restart-db", State: proto.STATE_PENDING, Hold: proto.HOLD_PACE, HoldDetail: "until 2020-06-01T12:00:05Z"},
+					{RequestId: request.Id, JobId: "j2", Name: "restart-app", StartedAt: 2, SequenceId: "j2", SequenceTry: 2},
+					{RequestId: request.Id, JobId: "j1", Name: "check-shift-lb-v2", Desc: "Draining traffic from host", StartedAt: 1, Status: "50% drained"},
+				},
+			}, nil
+		},
 	}
 	ctx := app.Context{
 		Out:      output,
@@ -65,6 +80,9 @@ progress: 11%
  request: requestname
   caller: owner
     args: key=value key2=val2
+ running: Draining traffic from host (check-shift-lb-v2): 50% drained
+          restart-app [sequence try 2]
+    held: restart-db: pace (until 2020-06-01T12:00:05Z)
 `
 	if output.String() != expectOutput {
 		fmt.Printf("got output:\n%s\nexpected:\n%s\n", output, expectOutput)
@@ -131,6 +149,199 @@ progress: 100%
 	}
 }
 
+func TestStatusWeightedProgress(t *testing.T) {
+	output := &bytes.Buffer{}
+	startedAt := time.Now().Add(-10 * time.Minute)
+	finishedAt := time.Now().Add(-1 * time.Second)
+	request := proto.Request{
+		Id:           "b9uvdi8tk9kahl8ppvbg",
+		Type:         "requestname",
+		State:        proto.STATE_FAIL,
+		User:         "owner",
+		Args:         args,
+		TotalJobs:    9,
+		FinishedJobs: 3,
+		Progress:     60, // not 33%: weighted
+		CreatedAt:    startedAt,
+		StartedAt:    &startedAt,
+		FinishedAt:   &finishedAt,
+	}
+	rmc := &mock.RMClient{
+		GetRequestFunc: func(id string) (proto.Request, error) {
+			return request, nil
+		},
+	}
+	ctx := app.Context{
+		Out:      output,
+		RMClient: rmc,
+		Options:  config.Options{},
+		Command: config.Command{
+			Cmd:  "status",
+			Args: []string{request.Id},
+		},
+	}
+	status := cmd.NewStatus(ctx)
+	if err := status.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := status.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "progress: 60%\n") {
+		t.Errorf("got output:\n%s\nexpected progress: 60%%", output)
+	}
+}
+
+func TestStatusMany(t *testing.T) {
+	output := &bytes.Buffer{}
+	startedAt := time.Now().Add(-120 * time.Minute)
+	finishedAt := startedAt.Add(90 * time.Second)
+	var gotIds []string
+	rmc := &mock.RMClient{
+		GetRequestFunc: func(id string) (proto.Request, error) {
+			t.Errorf("GetRequest(%s) called, expected one call to GetRequests", id)
+			return proto.Request{}, nil
+		},
+		GetRequestsFunc: func(ids []string) (proto.RequestsStatus, error) {
+			gotIds = ids
+			return proto.RequestsStatus{
+				Requests: []proto.Request{
+					{
+						Id:           "b9uvdi8tk9kahl8ppvbg",
+						Type:         "requestname",
+						State:        proto.STATE_COMPLETE,
+						TotalJobs:    4,
+						FinishedJobs: 4,
+						StartedAt:    &startedAt,
+						FinishedAt:   &finishedAt,
+					},
+					{
+						Id:        "b9uvdi8tk9kahl8ppvc0",
+						Type:      "other",
+						State:     proto.STATE_PENDING,
+						TotalJobs: 2,
+					},
+				},
+				NotFound: []string{"nope"},
+			}, nil
+		},
+	}
+	ctx := app.Context{
+		Out:      output,
+		RMClient: rmc,
+		Options:  config.Options{},
+		Command: config.Command{
+			Cmd:  "status",
+			Args: []string{"b9uvdi8tk9kahl8ppvbg", "nope", "b9uvdi8tk9kahl8ppvc0"},
+		},
+	}
+	status := cmd.NewStatus(ctx)
+	if err := status.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := status.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(gotIds, ctx.Command.Args); diff != nil {
+		t.Error(diff)
+	}
+
+	expectOutput := `ID                   STATE     PROGRESS RUNTIME    REQUEST
+b9uvdi8tk9kahl8ppvbg COMPLETE  100%     1m30s      requestname
+b9uvdi8tk9kahl8ppvc0 PENDING   0%       not started other
+nope                 NOT FOUND
+`
+	if output.String() != expectOutput {
+		fmt.Printf("got output:\n%s\nexpected:\n%s\n", output, expectOutput)
+		t.Error("wrong output, see above")
+	}
+
+	// --args requires one request ID
+	ctx.Options.Args = true
+	if err := cmd.NewStatus(ctx).Prepare(); err == nil {
+		t.Error("no error with --args and many request IDs, expected an error")
+	}
+}
+
+func TestStatusAt(t *testing.T) {
+	output := &bytes.Buffer{}
+	at := time.Date(2020, 6, 1, 12, 5, 0, 0, time.UTC)
+	createdAt := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	startedAt := createdAt
+	finishedAt := time.Date(2020, 6, 1, 12, 10, 0, 0, time.UTC)
+	request := proto.Request{
+		Id:           "b9uvdi8tk9kahl8ppvbg",
+		Type:         "requestname",
+		State:        proto.STATE_COMPLETE,
+		User:         "owner",
+		Args:         args,
+		TotalJobs:    9,
+		FinishedJobs: 9,
+		CreatedAt:    createdAt,
+		StartedAt:    &startedAt,
+		FinishedAt:   &finishedAt,
+	}
+	var gotFilter proto.StatusFilter
+	rmc := &mock.RMClient{
+		GetRequestFunc: func(id string) (proto.Request, error) {
+			return request, nil
+		},
+		RunningFunc: func(f proto.StatusFilter) (proto.RunningStatus, error) {
+			gotFilter = f
+			then := request
+			then.State = proto.STATE_RUNNING
+			then.FinishedJobs = 3
+			then.FinishedAt = nil
+			return proto.RunningStatus{
+				Jobs: []proto.JobStatus{
+					{RequestId: request.Id, JobId: "j1", Name: "restart-app", StartedAt: 1},
+				},
+				Requests: map[string]proto.Request{request.Id: then},
+			}, nil
+		},
+	}
+	ctx := app.Context{
+		Out:      output,
+		RMClient: rmc,
+		Options:  config.Options{At: at.Format(time.RFC3339)},
+		Command: config.Command{
+			Cmd:  "status",
+			Args: []string{request.Id},
+		},
+	}
+	status := cmd.NewStatus(ctx)
+	if err := status.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := status.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if gotFilter.RequestId != request.Id || !gotFilter.AsOf.Equal(at) {
+		t.Errorf("got filter %+v, expected request ID %s as of %s", gotFilter, request.Id, at)
+	}
+
+	expectOutput := `      at: ` + at.Local().Format(time.RFC3339) + `
+   state: RUNNING
+progress: 33%
+ runtime: 5m0s
+ request: requestname
+  caller: owner
+    args: key=value key2=val2
+ running: restart-app
+`
+	if output.String() != expectOutput {
+		fmt.Printf("got output:\n%s\nexpected:\n%s\n", output, expectOutput)
+		t.Error("wrong output, see above")
+	}
+
+	// Invalid time
+	ctx.Options.At = "yesterday"
+	status = cmd.NewStatus(ctx)
+	if err := status.Prepare(); err == nil {
+		t.Error("no error for invalid --at, expected one")
+	}
+}
+
 func TestStatusArgValueQuoting(t *testing.T) {
 	var args []proto.RequestArg = []proto.RequestArg{
 		{
@@ -199,3 +410,102 @@ progress: 11%
 		t.Error("wrong output, see above")
 	}
 }
+
+func TestStatusArgsDiff(t *testing.T) {
+	output := &bytes.Buffer{}
+	createdAt := time.Now().Add(-5 * time.Second)
+	startedAt := time.Now().Add(-5 * time.Second)
+	request := proto.Request{
+		Id:           "b9uvdi8tk9kahl8ppvbg",
+		Type:         "requestname",
+		State:        proto.STATE_RUNNING,
+		User:         "owner",
+		Args:         args,
+		TotalJobs:    9,
+		FinishedJobs: 1,
+		CreatedAt:    createdAt,
+		StartedAt:    &startedAt,
+	}
+	argsDiff := proto.RequestArgsDiff{
+		RequestId: request.Id,
+		Submitted: map[string]interface{}{
+			"key2": "val2",
+			"key":  "value",
+		},
+		Args: []proto.RequestArg{
+			{Name: "key", Type: proto.ARG_TYPE_REQUIRED, Value: "value", Given: true},
+			{Name: "opt", Type: proto.ARG_TYPE_OPTIONAL, Value: 5, Default: 5},
+			{Name: "fqdn", Type: proto.ARG_TYPE_DERIVED, Value: "value.example.com"},
+		},
+		Jobs: []proto.JobArgsDiff{
+			{
+				JobId: "job1",
+				Name:  "first",
+				Args: []proto.ArgDiff{
+					{Name: "fqdn", Source: proto.ARG_SOURCE_COMPUTED, Request: "value.example.com", Value: "value.example.com",
+						Origin: &proto.ArgSource{Source: proto.ARG_SOURCE_COMPUTED, Sequence: "requestname"}},
+					{Name: "host", Source: proto.ARG_SOURCE_DERIVED, Value: "h1",
+						Origin: &proto.ArgSource{Source: proto.ARG_SOURCE_EACH, Sequence: "requestname", Node: "expand-hosts", Arg: "hosts"}},
+					{Name: "key", Source: proto.ARG_SOURCE_CHANGED, Request: "value", Value: "new value",
+						Origin: &proto.ArgSource{Source: proto.ARG_SOURCE_JOB, Node: "set-key", JobId: "job0", Arg: "key"}},
+					{Name: "opt", Source: proto.ARG_SOURCE_DEFAULT, Request: 5, Value: 5},
+				},
+			},
+		},
+	}
+	rmc := &mock.RMClient{
+		GetRequestFunc: func(id string) (proto.Request, error) {
+			return request, nil
+		},
+		GetArgsDiffFunc: func(id string) (proto.RequestArgsDiff, error) {
+			if id == request.Id {
+				return argsDiff, nil
+			}
+			return proto.RequestArgsDiff{}, nil
+		},
+	}
+	ctx := app.Context{
+		Out:      output,
+		RMClient: rmc,
+		Options:  config.Options{Args: true},
+		Command: config.Command{
+			Cmd:  "status",
+			Args: []string{request.Id},
+		},
+	}
+	status := cmd.NewStatus(ctx)
+
+	err := status.Prepare()
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = status.Run()
+	if err != nil {
+		t.Error(err)
+	}
+
+	expectOutput := `   state: RUNNING
+progress: 11%
+ runtime: 5s
+ request: requestname
+  caller: owner
+    args: key=value key2=val2
+
+submitted: key=value key2=val2
+request args:
+  key=value (given)
+  opt=5 (default)
+  fqdn=value.example.com (computed)
+job args:
+  first (job1):
+    fqdn=value.example.com (computed, from derived in sequence requestname)
+    host=h1 (derived, from each: hosts in sequence requestname node expand-hosts)
+    key="new value" (changed, request: value, from job set-key (job0) arg key)
+    opt=5 (default)
+`
+	if output.String() != expectOutput {
+		fmt.Printf("got output:\n%s\nexpected:\n%s\n", output, expectOutput)
+		t.Error("wrong output, see above")
+	}
+}
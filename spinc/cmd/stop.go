@@ -4,13 +4,15 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/square/spincycle/v2/spinc/app"
 )
 
 type Stop struct {
-	ctx   app.Context
-	reqId string
+	ctx     app.Context
+	reqId   string
+	preview bool // preview=true: print what stop would interrupt, don't stop
 }
 
 func NewStop(ctx app.Context) *Stop {
@@ -21,13 +23,34 @@ func NewStop(ctx app.Context) *Stop {
 
 func (c *Stop) Prepare() error {
 	if len(c.ctx.Command.Args) == 0 {
-		return fmt.Errorf("Usage: spinc stop <id>\n")
+		return fmt.Errorf("Usage: spinc stop <id> [preview=true]\n")
 	}
 	c.reqId = c.ctx.Command.Args[0]
+	for _, arg := range c.ctx.Command.Args[1:] {
+		split := strings.SplitN(arg, "=", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("Invalid command arg %s: expected arg of form key=value", arg)
+		}
+		switch split[0] {
+		case "preview":
+			switch split[1] {
+			case "true":
+				c.preview = true
+			case "false":
+			default:
+				return fmt.Errorf("Invalid preview=%s: expected true or false", split[1])
+			}
+		default:
+			return fmt.Errorf("Invalid arg '%s'. Run 'spinc help stop' to list valid args.", split[0])
+		}
+	}
 	return nil
 }
 
 func (c *Stop) Run() error {
+	if c.preview {
+		return c.runPreview()
+	}
 	if err := c.ctx.RMClient.StopRequest(c.reqId); err != nil {
 		return err
 	}
@@ -35,10 +58,53 @@ func (c *Stop) Run() error {
 	return nil
 }
 
+func (c *Stop) runPreview() error {
+	sp, err := c.ctx.RMClient.GetStopPreview(c.reqId)
+	if c.ctx.Options.Debug {
+		app.Debug("stop preview: %#v", sp)
+	}
+	if c.ctx.Hooks.CommandRunResult != nil {
+		c.ctx.Hooks.CommandRunResult(sp, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.ctx.Out, "request: %s (%s)\n", sp.Type, sp.RequestId)
+	fmt.Fprintf(c.ctx.Out, "running jobs stopped: %d\n", len(sp.Running))
+	for _, j := range sp.Running {
+		fmt.Fprintf(c.ctx.Out, "  %s (%s)\n", j.Name, j.JobId)
+	}
+	fmt.Fprintf(c.ctx.Out, "sequences left partially complete: %d\n", len(sp.Sequences))
+	for _, s := range sp.Sequences {
+		skippable := ""
+		if s.Skippable {
+			skippable = ", skippable"
+		}
+		fmt.Fprintf(c.ctx.Out, "  %s (%s): %d of %d jobs complete%s\n", s.Name, s.SequenceId, s.Complete, s.Total, skippable)
+	}
+	fmt.Fprintf(c.ctx.Out, "finally jobs run: %d\n", len(sp.Finally))
+	for _, j := range sp.Finally {
+		fmt.Fprintf(c.ctx.Out, "  %s (%s)\n", j.Name, j.JobId)
+	}
+	fmt.Fprintf(c.ctx.Out, "jobs not run: %d\n", sp.NotRun)
+	fmt.Fprintf(c.ctx.Out, "\nNot stopped (preview). Run 'spinc stop %s' to stop the request.\n", c.reqId)
+	return nil
+}
+
 func (c *Stop) Cmd() string {
+	if c.preview {
+		return "stop " + c.reqId + " preview=true"
+	}
 	return "stop " + c.reqId
 }
 
 func (c *Stop) Help() string {
-	return "'spinc stop <request ID>' stops the request immediately.\n"
+	return "'spinc stop <request ID> [preview=true]' stops the request immediately.\n" +
+		"Running jobs are stopped, jobs that have not run do not run, and finally jobs\n" +
+		"of sequences that started run after the running jobs stop.\n\n" +
+		"With preview=true, the request is not stopped. Instead, spinc prints what\n" +
+		"stopping it would interrupt: the running jobs, the sequences left partially\n" +
+		"complete, the finally jobs that would run, and how many jobs would not run.\n"
 }
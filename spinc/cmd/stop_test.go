@@ -0,0 +1,76 @@
+// Copyright 2020, Square, Inc.
+
+package cmd_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/spinc/app"
+	"github.com/square/spincycle/v2/spinc/cmd"
+	"github.com/square/spincycle/v2/spinc/config"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+func TestStopPreview(t *testing.T) {
+	stopped := false
+	rmc := &mock.RMClient{
+		StopRequestFunc: func(id string) error {
+			stopped = true
+			return nil
+		},
+		GetStopPreviewFunc: func(id string) (proto.StopPreview, error) {
+			return proto.StopPreview{
+				RequestId: id,
+				Type:      "restart-host",
+				State:     proto.STATE_RUNNING,
+				Running:   []proto.StopPreviewJob{{JobId: "job2", Name: "restart", SequenceId: "job1"}},
+				Sequences: []proto.StopPreviewSequence{{SequenceId: "job1", Name: "get-hosts", Complete: 1, Total: 3, Skippable: true}},
+				Finally:   []proto.StopPreviewJob{{JobId: "job4", Name: "unlock", SequenceId: "job1"}},
+				NotRun:    3,
+			}, nil
+		},
+	}
+	output := &bytes.Buffer{}
+	ctx := app.Context{
+		Out:      output,
+		RMClient: rmc,
+		Options:  config.Options{},
+		Command: config.Command{
+			Cmd:  "stop",
+			Args: []string{"b9uvdi8tk9kahl8ppvbg", "preview=true"},
+		},
+	}
+	stop := cmd.NewStop(ctx)
+	if err := stop.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stop.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if stopped {
+		t.Error("request stopped, expected only a preview")
+	}
+	expectOutput := `request: restart-host (b9uvdi8tk9kahl8ppvbg)
+running jobs stopped: 1
+  restart (job2)
+sequences left partially complete: 1
+  get-hosts (job1): 1 of 3 jobs complete, skippable
+finally jobs run: 1
+  unlock (job4)
+jobs not run: 3
+
+Not stopped (preview). Run 'spinc stop b9uvdi8tk9kahl8ppvbg' to stop the request.
+`
+	if output.String() != expectOutput {
+		t.Errorf("got output:\n%s\nexpected:\n%s", output, expectOutput)
+	}
+}
+
+func TestStopInvalidArg(t *testing.T) {
+	stop := cmd.NewStop(app.Context{Command: config.Command{Cmd: "stop", Args: []string{"b9uvdi8tk9kahl8ppvbg", "preview=yes"}}})
+	if err := stop.Prepare(); err == nil {
+		t.Error("no error for preview=yes, expected one")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020, Square, Inc.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/spinc/app"
+)
+
+const (
+	timelineNameLen  = 30 // max job name length, longer names are squeezed
+	timelineBarWidth = 50 // characters for the whole request runtime
+)
+
+type Timeline struct {
+	ctx   app.Context
+	reqId string
+}
+
+func NewTimeline(ctx app.Context) *Timeline {
+	return &Timeline{
+		ctx: ctx,
+	}
+}
+
+func (c *Timeline) Prepare() error {
+	if len(c.ctx.Command.Args) == 0 {
+		return fmt.Errorf("Usage: spinc timeline <request ID>\n")
+	}
+	c.reqId = c.ctx.Command.Args[0]
+	return nil
+}
+
+func (c *Timeline) Run() error {
+	tl, err := c.ctx.RMClient.GetTimeline(c.reqId)
+	if c.ctx.Options.Debug {
+		app.Debug("timeline: %#v", tl)
+	}
+	if c.ctx.Hooks.CommandRunResult != nil {
+		c.ctx.Hooks.CommandRunResult(tl, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.ctx.Out, "request: %s (%s)\n", tl.Type, tl.RequestId)
+	fmt.Fprintf(c.ctx.Out, "  state: %s\n", proto.StateName[tl.State])
+	if len(tl.Jobs) == 0 {
+		fmt.Fprintf(c.ctx.Out, "   jobs: none started\n")
+		return nil
+	}
+
+	// The timeline spans the request runtime: from when it started, or when its
+	// first job started, to when it finished, or now if still running
+	begin := tl.Jobs[0].StartedAt
+	if tl.StartedAt != nil && !tl.StartedAt.IsZero() && tl.StartedAt.UnixNano() < begin {
+		begin = tl.StartedAt.UnixNano()
+	}
+	end := time.Now().UnixNano()
+	if tl.FinishedAt != nil && !tl.FinishedAt.IsZero() {
+		end = tl.FinishedAt.UnixNano()
+	}
+	for _, j := range tl.Jobs {
+		for _, t := range j.Tries {
+			if t.FinishedAt > end {
+				end = t.FinishedAt
+			}
+		}
+	}
+	span := end - begin
+	if span <= 0 {
+		span = 1
+	}
+	fmt.Fprintf(c.ctx.Out, "runtime: %s\n\n", time.Duration(span).Round(time.Second))
+
+	line := "%-*s %5s %9s %9s  %s\n"
+	fmt.Fprintf(c.ctx.Out, line, timelineNameLen, "JOB", "TRIES", "START", "RUNTIME", "|"+strings.Repeat(" ", timelineBarWidth)+"|")
+	for _, j := range tl.Jobs {
+		finished := j.FinishedAt
+		if finished == 0 {
+			finished = end
+		}
+		fmt.Fprintf(c.ctx.Out, line,
+			timelineNameLen, SqueezeString(j.Name, timelineNameLen, ".."),
+			fmt.Sprintf("%d", len(j.Tries)),
+			"+"+time.Duration(j.StartedAt-begin).Round(time.Second).String(),
+			time.Duration(finished-j.StartedAt).Round(time.Second).String(),
+			"|"+timelineBar(j, begin, end, span)+"|",
+		)
+	}
+	fmt.Fprintf(c.ctx.Out, "\n# complete, x failed or stopped, > running\n")
+	return nil
+}
+
+// timelineBar returns the bar for the job: each try drawn where it ran in the
+// request runtime, at least one character wide so short tries are visible.
+func timelineBar(j proto.TimelineJob, begin, end, span int64) string {
+	bar := []byte(strings.Repeat(" ", timelineBarWidth))
+	for _, t := range j.Tries {
+		finished := t.FinishedAt
+		if finished == 0 {
+			finished = end
+		}
+		from := int((t.StartedAt - begin) * timelineBarWidth / span)
+		to := int((finished - begin) * timelineBarWidth / span)
+		if from >= timelineBarWidth {
+			from = timelineBarWidth - 1
+		}
+		if to <= from {
+			to = from + 1
+		}
+		if to > timelineBarWidth {
+			to = timelineBarWidth
+		}
+		ch := byte('x')
+		switch t.State {
+		case proto.STATE_COMPLETE:
+			ch = '#'
+		case proto.STATE_RUNNING:
+			ch = '>'
+		}
+		for i := from; i < to; i++ {
+			bar[i] = ch
+		}
+	}
+	return string(bar)
+}
+
+func (c *Timeline) Cmd() string {
+	return "timeline " + c.reqId
+}
+
+func (c *Timeline) Help() string {
+	return "'spinc timeline <request ID>' prints when each job in the request ran,\n" +
+		"as a text Gantt chart, to show where the time went in a long request.\n" +
+		"Jobs are listed in the order they started. START is when the job started\n" +
+		"relative to the request, RUNTIME is from its first try start to its last try\n" +
+		"finish, and each try is drawn in the bar: # complete, x failed or stopped,\n" +
+		"> running. The full timeline is returned by GET /api/v1/requests/<ID>/timeline.\n"
+}
@@ -0,0 +1,89 @@
+// Copyright 2020, Square, Inc.
+
+package cmd_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/spinc/app"
+	"github.com/square/spincycle/v2/spinc/cmd"
+	"github.com/square/spincycle/v2/spinc/config"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+func TestTimeline(t *testing.T) {
+	started := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	finished := started.Add(100 * time.Second)
+	at := func(s int) int64 { return started.Add(time.Duration(s) * time.Second).UnixNano() }
+	var gotId string
+	rmc := &mock.RMClient{
+		GetTimelineFunc: func(id string) (proto.RequestTimeline, error) {
+			gotId = id
+			return proto.RequestTimeline{
+				RequestId:  id,
+				Type:       "restart-host",
+				State:      proto.STATE_COMPLETE,
+				StartedAt:  &started,
+				FinishedAt: &finished,
+				Jobs: []proto.TimelineJob{
+					{
+						JobId: "job1", Name: "drain", State: proto.STATE_COMPLETE, StartedAt: at(0), FinishedAt: at(50),
+						Tries: []proto.TimelineTry{
+							{Try: 1, State: proto.STATE_FAIL, StartedAt: at(0), FinishedAt: at(20)},
+							{Try: 2, State: proto.STATE_COMPLETE, StartedAt: at(20), FinishedAt: at(50)},
+						},
+					},
+					{
+						JobId: "job2", Name: "restart", State: proto.STATE_COMPLETE, StartedAt: at(50), FinishedAt: at(100),
+						Tries: []proto.TimelineTry{
+							{Try: 1, State: proto.STATE_COMPLETE, StartedAt: at(50), FinishedAt: at(100)},
+						},
+					},
+				},
+			}, nil
+		},
+	}
+	output := &bytes.Buffer{}
+	ctx := app.Context{
+		Out:      output,
+		RMClient: rmc,
+		Options:  config.Options{},
+		Command: config.Command{
+			Cmd:  "timeline",
+			Args: []string{"b9uvdi8tk9kahl8ppvbg"},
+		},
+	}
+	timeline := cmd.NewTimeline(ctx)
+	if err := timeline.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := timeline.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if gotId != "b9uvdi8tk9kahl8ppvbg" {
+		t.Errorf("got timeline of %s, expected b9uvdi8tk9kahl8ppvbg", gotId)
+	}
+	expectOutput := `request: restart-host (b9uvdi8tk9kahl8ppvbg)
+  state: COMPLETE
+runtime: 1m40s
+
+JOB                            TRIES     START   RUNTIME  |                                                  |
+drain                              2       +0s       50s  |xxxxxxxxxx###############                         |
+restart                            1      +50s       50s  |                         #########################|
+
+# complete, x failed or stopped, > running
+`
+	if output.String() != expectOutput {
+		t.Errorf("got output:\n%s\nexpected:\n%s", output, expectOutput)
+	}
+}
+
+func TestTimelineNoArgs(t *testing.T) {
+	timeline := cmd.NewTimeline(app.Context{Command: config.Command{Cmd: "timeline"}})
+	if err := timeline.Prepare(); err == nil {
+		t.Error("no error without request ID, expected one")
+	}
+}
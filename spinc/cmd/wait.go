@@ -0,0 +1,163 @@
+// Copyright 2020, Square, Inc.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/spinc/app"
+)
+
+const (
+	waitIntervalDefault = 2 * time.Second
+
+	// formatting for outputing request info
+	waitIdColLen    = 20
+	waitStateColLen = 9
+	waitReqColLen   = 40
+)
+
+type Wait struct {
+	ctx app.Context
+
+	reqIds   []string
+	timeout  time.Duration // 0 = wait forever
+	interval time.Duration
+}
+
+func NewWait(ctx app.Context) *Wait {
+	return &Wait{
+		ctx:      ctx,
+		interval: waitIntervalDefault,
+	}
+}
+
+func (c *Wait) Prepare() error {
+	for _, arg := range c.ctx.Command.Args {
+		split := strings.SplitN(arg, "=", 2)
+		if len(split) != 2 {
+			c.reqIds = append(c.reqIds, arg)
+			continue
+		}
+		d, err := time.ParseDuration(split[1])
+		if err != nil || d <= 0 {
+			return fmt.Errorf("Invalid %s value '%s': expected a duration greater than zero, like 30m or 1h", split[0], split[1])
+		}
+		switch split[0] {
+		case "timeout":
+			c.timeout = d
+		case "interval":
+			c.interval = d
+		default:
+			return fmt.Errorf("Invalid arg '%s'. Run 'spinc help wait' to list valid args.", split[0])
+		}
+	}
+	if len(c.reqIds) == 0 {
+		return fmt.Errorf("Usage: spinc wait <request ID> [<request ID>...] [timeout=T] [interval=I]\n")
+	}
+	return nil
+}
+
+func (c *Wait) Run() error {
+	var deadline time.Time
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+
+	// Poll requests until all are done. A done request is not polled again.
+	reqs := make([]proto.Request, len(c.reqIds))
+	done := make([]bool, len(c.reqIds))
+	nDone := 0
+	for {
+		for i, id := range c.reqIds {
+			if done[i] {
+				continue
+			}
+			r, err := c.ctx.RMClient.GetRequest(id)
+			if err != nil {
+				return err
+			}
+			if c.ctx.Options.Debug {
+				app.Debug("request: %#v", r)
+			}
+			reqs[i] = r
+			if finished(r.State) {
+				done[i] = true
+				nDone++
+			}
+		}
+		if nDone == len(reqs) {
+			break
+		}
+		if !deadline.IsZero() && time.Now().Add(c.interval).After(deadline) {
+			break
+		}
+		time.Sleep(c.interval)
+	}
+
+	// Requests that did not finish or did not complete successfully
+	var err error
+	nFailed := 0
+	for _, r := range reqs {
+		if r.State != proto.STATE_COMPLETE {
+			nFailed++
+		}
+	}
+	if nDone < len(reqs) {
+		err = fmt.Errorf("Timeout after %s: %d of %d requests not finished", c.timeout, len(reqs)-nDone, len(reqs))
+	} else if nFailed > 0 {
+		err = fmt.Errorf("%d of %d requests did not complete successfully", nFailed, len(reqs))
+	}
+
+	if c.ctx.Hooks.CommandRunResult != nil {
+		c.ctx.Hooks.CommandRunResult(reqs, err)
+		return nil
+	}
+
+	line := fmt.Sprintf("%%-%ds  %%-%ds  %%8s  %%9s  %%s\n", waitIdColLen, waitStateColLen)
+	fmt.Fprintf(c.ctx.Out, line, "ID", "STATE", "PROGRESS", "RUNTIME", "REQUEST")
+	for _, r := range reqs {
+		progress := "-"
+		if r.TotalJobs > 0 {
+			progress = requestProgress(r)
+		}
+		runtime := "-"
+		if r.StartedAt != nil && !r.StartedAt.IsZero() && r.FinishedAt != nil && !r.FinishedAt.IsZero() {
+			runtime = r.FinishedAt.Sub(*r.StartedAt).Round(time.Second).String()
+		}
+		fmt.Fprintf(c.ctx.Out, line,
+			SqueezeString(r.Id, waitIdColLen, ".."),
+			proto.StateName[r.State],
+			progress,
+			runtime,
+			SqueezeString(r.Type, waitReqColLen, ".."),
+		)
+	}
+
+	return err
+}
+
+func (c *Wait) Cmd() string {
+	return "wait " + strings.Join(c.reqIds, " ")
+}
+
+func (c *Wait) Help() string {
+	return "'spinc wait <request ID> [<request ID>...] [timeout=T] [interval=I]' waits for all requests to finish,\n" +
+		"then prints a summary of the requests. It exits 0 if all requests completed successfully,\n" +
+		"else it exits 1: if any request failed or was stopped, or if the timeout is reached.\n\n" +
+		"Args:\n" +
+		"  timeout    How long to wait, like 1h (default: wait forever). Not --timeout, which is the API timeout.\n" +
+		"  interval   How often to check request status, like 10s (default: 2s)\n"
+}
+
+// finished returns true if a request in the state will not run again.
+func finished(state byte) bool {
+	switch state {
+	case proto.STATE_COMPLETE, proto.STATE_FAIL, proto.STATE_STOPPED:
+		return true
+	}
+	return false
+}
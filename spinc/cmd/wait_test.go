@@ -0,0 +1,113 @@
+// Copyright 2020, Square, Inc.
+
+package cmd_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/spinc/app"
+	"github.com/square/spincycle/v2/spinc/cmd"
+	"github.com/square/spincycle/v2/spinc/config"
+	"github.com/square/spincycle/v2/test/mock"
+)
+
+func TestWait(t *testing.T) {
+	output := &bytes.Buffer{}
+	startedAt := time.Now().Add(-10 * time.Second)
+	finishedAt := startedAt.Add(5 * time.Second)
+	polls := map[string]int{}
+	rmc := &mock.RMClient{
+		GetRequestFunc: func(id string) (proto.Request, error) {
+			polls[id]++
+			r := proto.Request{
+				Id:           id,
+				Type:         "requestname",
+				State:        proto.STATE_RUNNING,
+				TotalJobs:    4,
+				FinishedJobs: 2,
+				StartedAt:    &startedAt,
+			}
+			// req2 finishes on the second poll, req1 on the first
+			if id == "req1" || polls[id] > 1 {
+				r.State = proto.STATE_COMPLETE
+				r.FinishedJobs = 4
+				r.FinishedAt = &finishedAt
+			}
+			if id == "req2" && polls[id] > 1 {
+				r.State = proto.STATE_FAIL
+				r.FinishedJobs = 3
+			}
+			return r, nil
+		},
+	}
+	ctx := app.Context{
+		Out:      output,
+		RMClient: rmc,
+		Options:  config.Options{},
+		Command: config.Command{
+			Cmd:  "wait",
+			Args: []string{"req1", "req2", "interval=10ms"},
+		},
+	}
+	wait := cmd.NewWait(ctx)
+	if err := wait.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := wait.Run()
+	if err == nil {
+		t.Error("no error, expected one because req2 failed")
+	}
+	if polls["req1"] != 1 || polls["req2"] != 2 {
+		t.Errorf("got polls %v, expected req1=1, req2=2", polls)
+	}
+
+	expectOutput := `ID                    STATE      PROGRESS    RUNTIME  REQUEST
+req1                  COMPLETE       100%         5s  requestname
+req2                  FAIL            75%         5s  requestname
+`
+	if output.String() != expectOutput {
+		fmt.Printf("got output:\n%s\nexpected:\n%s\n", output, expectOutput)
+		t.Error("wrong output, see above")
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	rmc := &mock.RMClient{
+		GetRequestFunc: func(id string) (proto.Request, error) {
+			return proto.Request{Id: id, State: proto.STATE_RUNNING}, nil
+		},
+	}
+	ctx := app.Context{
+		Out:      &bytes.Buffer{},
+		RMClient: rmc,
+		Options:  config.Options{},
+		Command: config.Command{
+			Cmd:  "wait",
+			Args: []string{"req1", "timeout=50ms", "interval=10ms"},
+		},
+	}
+	wait := cmd.NewWait(ctx)
+	if err := wait.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Now()
+	if err := wait.Run(); err == nil {
+		t.Error("no error, expected timeout error")
+	}
+	if d := time.Now().Sub(t0); d > time.Second {
+		t.Errorf("waited %s, expected timeout after 50ms", d)
+	}
+
+	// Invalid args
+	for _, args := range [][]string{{}, {"timeout=1h"}, {"req1", "timeout=1x"}, {"req1", "foo=1h"}} {
+		ctx.Command.Args = args
+		if err := cmd.NewWait(ctx).Prepare(); err == nil {
+			t.Errorf("no error for args %v, expected one", args)
+		}
+	}
+}
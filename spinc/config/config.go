@@ -20,17 +20,38 @@ const (
 	DEFAULT_CONFIG_FILES = "/etc/spinc/spinc.yaml,~/.spinc.yaml"
 	DEFAULT_ADDR         = "http://127.0.0.1:32308"
 	DEFAULT_TIMEOUT      = 5000 // 5s
+	DEFAULT_TOKEN_FILE   = "~/.spinc-token"
+
+	// DEFAULT_SPEC_CACHE_FILE is where the request list is cached (--spec-cache).
+	// SPEC_CACHE_OFF disables the cache.
+	DEFAULT_SPEC_CACHE_FILE = "~/.spinc-specs"
+	SPEC_CACHE_OFF          = "off"
 )
 
 // An Options record for pulling the originally set user arguments
 type UserOptions struct {
-	Addr    *string
-	Config  *string
-	Debug   *bool
-	Env     *string
-	Help    *bool
-	Timeout *uint
-	Version *bool
+	Addr           *string
+	Args           *bool
+	At             *string
+	Config         *string
+	Debug          *bool
+	Env            *string
+	Help           *bool
+	LogLevel       *string `arg:"--log-level"`
+	NonInteractive *bool
+	Override       []string `arg:"--override,separate"`
+	Preset         *string  `arg:"--preset"`
+	ReadOnly       *bool
+	RunAs          *string `arg:"--run-as"`
+	Save           *string
+	Saved          *string
+	SpecCache      *string `arg:"--spec-cache"`
+	Timeout        *uint
+	TLSCert        *string
+	TLSKey         *string
+	TLSCA          *string
+	TokenFile      *string
+	Version        *bool
 }
 
 type UserCommandLine struct {
@@ -40,13 +61,33 @@ type UserCommandLine struct {
 
 // Options represents typical command line options: --addr, --config, etc.
 type Options struct {
-	Addr    string `arg:"env:SPINC_ADDR" yaml:"addr"`
-	Config  string `arg:"env:SPINC_CONFIG"`
-	Debug   bool   `arg:"env:SPINC_DEBUG" yaml:"debug"`
-	Env     string `arg:"env:SPINC_ENV" yaml:"env"`
-	Help    bool
-	Timeout uint `arg:"env:SPINC_TIMEOUT" yaml:"timeout"`
-	Version bool
+	Addr           string `arg:"env:SPINC_ADDR" yaml:"addr"`
+	Args           bool   `arg:"--args"`
+	At             string `arg:"--at"`
+	Config         string `arg:"env:SPINC_CONFIG"`
+	Debug          bool   `arg:"env:SPINC_DEBUG" yaml:"debug"`
+	Env            string `arg:"env:SPINC_ENV" yaml:"env"`
+	Help           bool
+	LogLevel       string   `arg:"--log-level"`
+	NonInteractive bool     `arg:"--non-interactive,env:SPINC_NON_INTERACTIVE" yaml:"non_interactive"`
+	Override       []string `arg:"--override,separate" yaml:"-"`
+	Preset         string   `arg:"--preset"`
+	ReadOnly       bool     `arg:"--read-only,env:SPINC_READ_ONLY" yaml:"read_only"`
+	RunAs          string   `arg:"--run-as" yaml:"-"`
+	Save           string   `arg:"--save"`
+	Saved          string   `arg:"--saved"`
+	SpecCache      string   `arg:"--spec-cache,env:SPINC_SPEC_CACHE" yaml:"spec_cache"`
+	Timeout        uint     `arg:"env:SPINC_TIMEOUT" yaml:"timeout"`
+	TLSCert        string   `arg:"--tls-cert,env:SPINC_TLS_CERT" yaml:"tls_cert"`
+	TLSKey         string   `arg:"--tls-key,env:SPINC_TLS_KEY" yaml:"tls_key"`
+	TLSCA          string   `arg:"--tls-ca,env:SPINC_TLS_CA" yaml:"tls_ca"`
+	TokenFile      string   `arg:"--token-file,env:SPINC_TOKEN_FILE" yaml:"token_file"`
+	Version        bool
+
+	// FindQueries are saved 'spinc find' filters keyed on name, like
+	// "failed-restarts": ["type=restart-host", "states=FAIL"]. They're only
+	// set in config files, by 'spinc find --save <name>'.
+	FindQueries map[string][]string `arg:"-" yaml:"find_queries"`
 }
 
 // Command represents a command (start, stop, etc.) and its values.
@@ -102,6 +143,14 @@ func (u *UserOptions) ToOptions() Options {
 		o.Addr = *u.Addr
 	}
 
+	if u.Args != nil {
+		o.Args = *u.Args
+	}
+
+	if u.At != nil {
+		o.At = *u.At
+	}
+
 	if u.Config != nil {
 		o.Config = *u.Config
 	}
@@ -118,10 +167,62 @@ func (u *UserOptions) ToOptions() Options {
 		o.Help = *u.Help
 	}
 
+	if u.LogLevel != nil {
+		o.LogLevel = *u.LogLevel
+	}
+
+	if u.NonInteractive != nil {
+		o.NonInteractive = *u.NonInteractive
+	}
+
+	if u.Override != nil {
+		o.Override = u.Override
+	}
+
+	if u.Preset != nil {
+		o.Preset = *u.Preset
+	}
+
+	if u.ReadOnly != nil {
+		o.ReadOnly = *u.ReadOnly
+	}
+
+	if u.RunAs != nil {
+		o.RunAs = *u.RunAs
+	}
+
+	if u.Save != nil {
+		o.Save = *u.Save
+	}
+
+	if u.Saved != nil {
+		o.Saved = *u.Saved
+	}
+
+	if u.SpecCache != nil {
+		o.SpecCache = *u.SpecCache
+	}
+
 	if u.Timeout != nil {
 		o.Timeout = *u.Timeout
 	}
 
+	if u.TLSCert != nil {
+		o.TLSCert = *u.TLSCert
+	}
+
+	if u.TLSKey != nil {
+		o.TLSKey = *u.TLSKey
+	}
+
+	if u.TLSCA != nil {
+		o.TLSCA = *u.TLSCA
+	}
+
+	if u.TokenFile != nil {
+		o.TokenFile = *u.TokenFile
+	}
+
 	if u.Version != nil {
 		o.Version = *u.Version
 	}
@@ -157,13 +258,7 @@ func ParseCommandLine(def Options) CommandLine {
 func ParseConfigFiles(files string, debug bool) Options {
 	var def Options
 	for _, file := range strings.Split(files, ",") {
-		// If file starts with ~/, we need to expand this to the user home dir
-		// because this is a shell expansion, not something Go knows about.
-		if file[:2] == "~/" {
-			usr, _ := user.Current()
-			file = filepath.Join(usr.HomeDir, file[2:])
-		}
-
+		file = ExpandHome(file)
 		absfile, err := filepath.Abs(file)
 		if err != nil {
 			if debug {
@@ -195,9 +290,101 @@ func ParseConfigFiles(files string, debug bool) Options {
 		if o.Addr != "" {
 			def.Addr = o.Addr
 		}
+		if o.NonInteractive {
+			def.NonInteractive = true
+		}
+		if o.ReadOnly {
+			def.ReadOnly = true
+		}
 		if o.Timeout != 0 {
 			def.Timeout = o.Timeout
 		}
+		if o.TLSCert != "" {
+			def.TLSCert = o.TLSCert
+		}
+		if o.TLSKey != "" {
+			def.TLSKey = o.TLSKey
+		}
+		if o.TLSCA != "" {
+			def.TLSCA = o.TLSCA
+		}
+		if o.TokenFile != "" {
+			def.TokenFile = o.TokenFile
+		}
+		if o.SpecCache != "" {
+			def.SpecCache = o.SpecCache
+		}
+		for name, filters := range o.FindQueries {
+			if def.FindQueries == nil {
+				def.FindQueries = map[string][]string{}
+			}
+			def.FindQueries[name] = filters
+		}
 	}
 	return def
 }
+
+// UserConfigFile returns the config file that spinc writes to, like for
+// 'spinc find --save': the last of the config files, which is ~/.spinc.yaml
+// by default. files is the --config option, or empty for the default files.
+func UserConfigFile(files string) string {
+	if files == "" {
+		files = DEFAULT_CONFIG_FILES
+	}
+	split := strings.Split(files, ",")
+	return ExpandHome(strings.TrimSpace(split[len(split)-1]))
+}
+
+// SaveFindQuery saves the 'spinc find' filters as the named query in the config
+// file, replacing a query with the same name. The file is created if it does not
+// exist. Other options in the file are kept, but comments are not.
+func SaveFindQuery(file, name string, filters []string) error {
+	var cfg yaml.MapSlice
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(bytes, &cfg); err != nil {
+		return fmt.Errorf("invalid YAML in %s: %s", file, err)
+	}
+
+	query := yaml.MapItem{Key: name, Value: filters}
+	found := false
+	for i := range cfg {
+		if cfg[i].Key != "find_queries" {
+			continue
+		}
+		queries, _ := cfg[i].Value.(yaml.MapSlice)
+		replaced := false
+		for j := range queries {
+			if queries[j].Key == name {
+				queries[j] = query
+				replaced = true
+			}
+		}
+		if !replaced {
+			queries = append(queries, query)
+		}
+		cfg[i].Value = queries
+		found = true
+	}
+	if !found {
+		cfg = append(cfg, yaml.MapItem{Key: "find_queries", Value: yaml.MapSlice{query}})
+	}
+
+	bytes, err = yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, bytes, 0644)
+}
+
+// ExpandHome expands a leading ~/ in file to the user home dir. This is a
+// shell expansion, not something Go knows about.
+func ExpandHome(file string) string {
+	if strings.HasPrefix(file, "~/") {
+		usr, _ := user.Current()
+		file = filepath.Join(usr.HomeDir, file[2:])
+	}
+	return file
+}
@@ -0,0 +1,70 @@
+// Copyright 2020, Square, Inc.
+
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SpecCache is the request list of one Request Manager cached by spinc, so
+// commands that need it (start, help) can revalidate it with its ETag instead of
+// getting the whole list, and use it when the Request Manager is unreachable.
+type SpecCache struct {
+	ETag      string          `json:"etag"`
+	CheckedAt time.Time       `json:"checkedAt"` // last time the RM returned or revalidated it
+	Body      json.RawMessage `json:"body"`      // GET /api/v1/request-list response
+}
+
+// LoadSpecCache loads the request list of the Request Manager at addr cached in
+// file. If the file does not exist or has no list for addr, it returns a zero
+// value SpecCache and no error.
+func LoadSpecCache(file, addr string) (SpecCache, error) {
+	all, err := loadSpecCaches(file)
+	if err != nil {
+		return SpecCache{}, err
+	}
+	return all[addr], nil
+}
+
+// SaveSpecCache caches the request list of the Request Manager at addr in file.
+// Lists of other Request Managers in the file are kept.
+func SaveSpecCache(file, addr string, sc SpecCache) error {
+	all, err := loadSpecCaches(file)
+	if err != nil {
+		all = map[string]SpecCache{} // overwrite invalid file
+	}
+	all[addr] = sc
+	bytes, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	file = ExpandHome(file)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, bytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// loadSpecCaches loads all cached request lists in file, keyed on RM address.
+func loadSpecCaches(file string) (map[string]SpecCache, error) {
+	all := map[string]SpecCache{}
+	bytes, err := ioutil.ReadFile(ExpandHome(file))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return all, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2020, Square, Inc.
+
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/square/spincycle/v2/proto"
+)
+
+// LoadToken loads the API token cached in file by spinc login. If the file does
+// not exist, it returns a zero value token and no error.
+func LoadToken(file string) (proto.Token, error) {
+	var tok proto.Token
+	bytes, err := ioutil.ReadFile(ExpandHome(file))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tok, nil
+		}
+		return tok, err
+	}
+	err = json.Unmarshal(bytes, &tok)
+	return tok, err
+}
+
+// SaveToken caches the API token, including its secret, in file. The file is
+// only readable by the user.
+func SaveToken(file string, tok proto.Token) error {
+	file = ExpandHome(file)
+	bytes, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, bytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// DeleteToken removes the cached API token file, if it exists.
+func DeleteToken(file string) error {
+	err := os.Remove(ExpandHome(file))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -4,11 +4,18 @@
 package spinc
 
 import (
+	"bytes"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	spinconfig "github.com/square/spincycle/v2/config"
+	"github.com/square/spincycle/v2/proto"
 	rm "github.com/square/spincycle/v2/request-manager"
 	"github.com/square/spincycle/v2/spinc/app"
 	"github.com/square/spincycle/v2/spinc/cmd"
@@ -163,10 +170,221 @@ func makeRMC(ctx app.Context) (rm.Client, error) {
 		httpClient = &http.Client{
 			Timeout: time.Duration(ctx.Options.Timeout) * time.Millisecond,
 		}
+		if ctx.Options.TLSCA != "" {
+			var tlsConfig *tls.Config
+			tlsConfig, err = spinconfig.NewClientTLSConfig(spinconfig.TLS{
+				CertFile:       ctx.Options.TLSCert,
+				KeyFile:        ctx.Options.TLSKey,
+				CAFile:         ctx.Options.TLSCA,
+				ReloadInterval: "0s", // spinc is short-lived
+			})
+			httpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+		}
 	}
 	if err != nil {
 		return nil, fmt.Errorf("Error making http.Client: %s", err)
 	}
+
+	// Authenticate with the API token saved by spinc login, if any. Login does
+	// not use it because tokens cannot create tokens.
+	if ctx.Command.Cmd != "login" {
+		tokenFile := ctx.Options.TokenFile
+		if tokenFile == "" {
+			tokenFile = config.DEFAULT_TOKEN_FILE
+		}
+		tok, err := config.LoadToken(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading API token from %s: %s", tokenFile, err)
+		}
+		if tok.Secret != "" {
+			if ctx.Options.Debug {
+				app.Debug("using API token %s from %s", tok.Id, tokenFile)
+			}
+			if time.Now().After(tok.ExpiresAt) && ctx.Command.Cmd != "logout" {
+				return nil, fmt.Errorf("API token in %s expired at %s, run 'spinc login' again", tokenFile, tok.ExpiresAt)
+			}
+			// Copy the client so the factory's client is not modified
+			c := *httpClient
+			c.Transport = &tokenTransport{base: httpClient.Transport, secret: tok.Secret}
+			httpClient = &c
+		}
+	}
+
+	// With --run-as, start and stop requests on behalf of another user. The RM
+	// allows it only for admins, and records the caller as the operator.
+	if ctx.Options.RunAs != "" {
+		c := *httpClient
+		c.Transport = &runAsTransport{base: httpClient.Transport, user: ctx.Options.RunAs}
+		httpClient = &c
+	}
+
+	// With --read-only, refuse calls that change anything before they're sent,
+	// in case the caller is not read-only in the RM
+	if ctx.Options.ReadOnly {
+		c := *httpClient
+		c.Transport = &readOnlyTransport{base: httpClient.Transport}
+		httpClient = &c
+	}
+
+	// Cache the request list so it's revalidated instead of sent every time,
+	// and used when the RM is unreachable
+	specCache := ctx.Options.SpecCache
+	if specCache == "" {
+		specCache = config.DEFAULT_SPEC_CACHE_FILE
+	}
+	if specCache != config.SPEC_CACHE_OFF {
+		c := *httpClient
+		c.Transport = &specCacheTransport{
+			base:  httpClient.Transport,
+			file:  specCache,
+			addr:  ctx.Options.Addr,
+			warn:  os.Stderr,
+			debug: ctx.Options.Debug,
+		}
+		httpClient = &c
+	}
+
 	rmc := rm.NewClient(httpClient, ctx.Options.Addr)
 	return rmc, nil
 }
+
+// specCacheTransport caches the request list (GET /api/v1/request-list) in a
+// file. The cached list is revalidated with its ETag, so the RM does not send
+// the list again if it has not changed. If the RM is unreachable, the cached
+// list is used and a warning that it might be stale is printed.
+type specCacheTransport struct {
+	base  http.RoundTripper
+	file  string
+	addr  string
+	warn  io.Writer
+	debug bool
+}
+
+func (t *specCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Method != http.MethodGet || !strings.HasSuffix(req.URL.Path, "/api/v1/request-list") {
+		return base.RoundTrip(req)
+	}
+
+	cache, err := config.LoadSpecCache(t.file, t.addr)
+	if err != nil && t.debug {
+		app.Debug("error loading request list cache from %s: %s", t.file, err)
+	}
+	if cache.ETag != "" && len(cache.Body) > 0 {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cache.ETag)
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil || resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout {
+		if len(cache.Body) == 0 {
+			return resp, err
+		}
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			resp.Body.Close()
+		}
+		fmt.Fprintf(t.warn, "Warning: Request Manager unreachable (%s). Using request list cached at %s, which might be stale.\n",
+			reason, cache.CheckedAt.Local().Format("2006-01-02 15:04:05 MST"))
+		return t.cached(req, cache), nil
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		resp.Body.Close()
+		if t.debug {
+			app.Debug("request list not modified since %s (ETag %s)", cache.CheckedAt, cache.ETag)
+		}
+		cache.CheckedAt = time.Now()
+		t.save(cache)
+		return t.cached(req, cache), nil
+	case http.StatusOK:
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			t.save(config.SpecCache{ETag: etag, CheckedAt: time.Now(), Body: body})
+		}
+	}
+	return resp, nil
+}
+
+// cached returns the cached request list as a response to the request.
+func (t *specCacheTransport) cached(req *http.Request, cache config.SpecCache) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(cache.Body)),
+		ContentLength: int64(len(cache.Body)),
+		Request:       req,
+	}
+}
+
+// save saves the cache. Errors are not returned because the cache is optional.
+func (t *specCacheTransport) save(cache config.SpecCache) {
+	if err := config.SaveSpecCache(t.file, t.addr, cache); err != nil && t.debug {
+		app.Debug("error saving request list cache to %s: %s", t.file, err)
+	}
+}
+
+// readOnlyTransport allows only GET requests to the RM, and managing API tokens
+// (login and logout).
+type readOnlyTransport struct {
+	base http.RoundTripper
+}
+
+func (t *readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead && !strings.Contains(req.URL.Path, "/api/v1/tokens") {
+		return nil, fmt.Errorf("spinc is read-only (--read-only): not sending %s %s", req.Method, req.URL.Path)
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// runAsTransport sends every request as another user (proto.RUN_AS_HEADER).
+type runAsTransport struct {
+	base http.RoundTripper
+	user string
+}
+
+func (t *runAsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(proto.RUN_AS_HEADER, t.user)
+	return base.RoundTrip(req)
+}
+
+// tokenTransport authenticates every request with an API token.
+type tokenTransport struct {
+	base   http.RoundTripper
+	secret string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.secret)
+	return base.RoundTrip(req)
+}
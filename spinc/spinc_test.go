@@ -4,7 +4,12 @@ package spinc_test
 
 import (
 	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/square/spincycle/v2/spinc"
@@ -38,3 +43,53 @@ func TestArgsHelpCommand(t *testing.T) {
 		t.Errorf("got error '%v', expected ErrHelp", err)
 	}
 }
+
+func TestSpecCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spinc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheFile := filepath.Join(dir, "specs")
+
+	// RM returns the request list with an ETag, or 304 if not modified
+	var gotETags []string
+	rm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotETags = append(gotETags, r.Header.Get("If-None-Match"))
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`[{"name":"restart-host"}]`))
+	}))
+
+	run := func() string {
+		out := &bytes.Buffer{}
+		ctx := app.Context{
+			In:  os.Stdin,
+			Out: out,
+		}
+		os.Args = []string{"spinc", "--addr", rm.URL, "--spec-cache", cacheFile}
+		if err := spinc.Run(ctx); err != app.ErrHelp {
+			t.Errorf("got error '%v', expected ErrHelp", err)
+		}
+		return out.String()
+	}
+
+	// Request list is cached, then revalidated with its ETag
+	for i := 0; i < 2; i++ {
+		if out := run(); !strings.Contains(out, "restart-host") {
+			t.Errorf("run %d: request list not printed: %s", i+1, out)
+		}
+	}
+	if len(gotETags) != 2 || gotETags[0] != "" || gotETags[1] != `"v1"` {
+		t.Errorf("got If-None-Match %v, expected none then \"v1\"", gotETags)
+	}
+
+	// RM unreachable: cached list is used
+	rm.Close()
+	if out := run(); !strings.Contains(out, "restart-host") {
+		t.Errorf("cached request list not printed: %s", out)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2020, Square, Inc.
+
+package mock
+
+import (
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/backfill"
+)
+
+var (
+	_ backfill.Manager = &BackfillManager{}
+)
+
+type BackfillManager struct {
+	RunFunc  func(<-chan struct{})
+	ListFunc func() ([]proto.Backfill, error)
+	GetFunc  func(string) (proto.Backfill, error)
+}
+
+func (m *BackfillManager) Run(stopChan <-chan struct{}) {
+	if m.RunFunc != nil {
+		m.RunFunc(stopChan)
+	}
+}
+
+func (m *BackfillManager) List() ([]proto.Backfill, error) {
+	if m.ListFunc != nil {
+		return m.ListFunc()
+	}
+	return []proto.Backfill{}, nil
+}
+
+func (m *BackfillManager) Get(name string) (proto.Backfill, error) {
+	if m.GetFunc != nil {
+		return m.GetFunc(name)
+	}
+	return proto.Backfill{}, nil
+}
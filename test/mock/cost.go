@@ -0,0 +1,51 @@
+// Copyright 2020, Square, Inc.
+
+package mock
+
+import (
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/cost"
+)
+
+var (
+	_ cost.Manager  = &CostManager{}
+	_ cost.Reporter = &CostReporter{}
+)
+
+type CostManager struct {
+	RecordFunc func(proto.JobLog) error
+	UsageFunc  func(proto.UsageFilter) ([]proto.Usage, error)
+}
+
+func (m *CostManager) Record(jl proto.JobLog) error {
+	if m.RecordFunc != nil {
+		return m.RecordFunc(jl)
+	}
+	return nil
+}
+
+func (m *CostManager) Usage(f proto.UsageFilter) ([]proto.Usage, error) {
+	if m.UsageFunc != nil {
+		return m.UsageFunc(f)
+	}
+	return []proto.Usage{}, nil
+}
+
+type CostReporter struct {
+	NamespaceFunc func(string, string) (string, error)
+	ReportFunc    func(proto.JobCost) error
+}
+
+func (r *CostReporter) Namespace(user, requestType string) (string, error) {
+	if r.NamespaceFunc != nil {
+		return r.NamespaceFunc(user, requestType)
+	}
+	return "", nil
+}
+
+func (r *CostReporter) Report(jc proto.JobCost) error {
+	if r.ReportFunc != nil {
+		return r.ReportFunc(jc)
+	}
+	return nil
+}
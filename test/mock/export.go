@@ -0,0 +1,59 @@
+// Copyright 2020, Square, Inc.
+
+package mock
+
+import (
+	"context"
+
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/export"
+)
+
+var (
+	_ export.Manager     = &ExportManager{}
+	_ export.ObjectStore = &ObjectStore{}
+)
+
+type ExportManager struct {
+	FinishedFunc func(string)
+	RunFunc      func(<-chan struct{})
+	ExportFunc   func(string) (proto.RequestExport, error)
+	GetFunc      func(string) (proto.RequestExport, error)
+}
+
+func (m *ExportManager) Finished(requestId string) {
+	if m.FinishedFunc != nil {
+		m.FinishedFunc(requestId)
+	}
+}
+
+func (m *ExportManager) Run(stopChan <-chan struct{}) {
+	if m.RunFunc != nil {
+		m.RunFunc(stopChan)
+	}
+}
+
+func (m *ExportManager) Export(requestId string) (proto.RequestExport, error) {
+	if m.ExportFunc != nil {
+		return m.ExportFunc(requestId)
+	}
+	return proto.RequestExport{}, nil
+}
+
+func (m *ExportManager) Get(requestId string) (proto.RequestExport, error) {
+	if m.GetFunc != nil {
+		return m.GetFunc(requestId)
+	}
+	return proto.RequestExport{}, nil
+}
+
+type ObjectStore struct {
+	PutFunc func(context.Context, string, []byte) (string, error)
+}
+
+func (s *ObjectStore) Put(ctx context.Context, key string, data []byte) (string, error) {
+	if s.PutFunc != nil {
+		return s.PutFunc(ctx, key, data)
+	}
+	return "mock://" + key, nil
+}
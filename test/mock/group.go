@@ -0,0 +1,46 @@
+// Copyright 2020, Square, Inc.
+
+package mock
+
+import (
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/group"
+)
+
+var (
+	_ group.Manager = &GroupManager{}
+)
+
+type GroupManager struct {
+	CreateFunc     func(proto.RequestGroup) (proto.RequestGroup, error)
+	GetFunc        func(string) (proto.RequestGroup, error)
+	StopFunc       func(string) error
+	AdvanceAllFunc func()
+}
+
+func (m *GroupManager) Create(g proto.RequestGroup) (proto.RequestGroup, error) {
+	if m.CreateFunc != nil {
+		return m.CreateFunc(g)
+	}
+	return g, nil
+}
+
+func (m *GroupManager) Get(groupId string) (proto.RequestGroup, error) {
+	if m.GetFunc != nil {
+		return m.GetFunc(groupId)
+	}
+	return proto.RequestGroup{}, nil
+}
+
+func (m *GroupManager) Stop(groupId string) error {
+	if m.StopFunc != nil {
+		return m.StopFunc(groupId)
+	}
+	return nil
+}
+
+func (m *GroupManager) AdvanceAll() {
+	if m.AdvanceAllFunc != nil {
+		m.AdvanceAllFunc()
+	}
+}
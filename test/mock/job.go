@@ -37,6 +37,26 @@ func (f *JobFactory) Make(jid job.Id) (job.Job, error) {
 	return job, f.MakeErr
 }
 
+// SameJobFactory makes Job for every job. It's for the mock jobs below that
+// implement optional job interfaces, like AuthJob, which JobFactory cannot make.
+// Make sets the ID of Job and returns MakeErr.
+type SameJobFactory struct {
+	Job     IdJob
+	MakeErr error
+}
+
+// An IdJob is a mock job that the factory that makes it gives its ID. Every
+// mock job that embeds Job is one.
+type IdJob interface {
+	job.Job
+	SetId(job.Id)
+}
+
+func (f *SameJobFactory) Make(jid job.Id) (job.Job, error) {
+	f.Job.SetId(jid)
+	return f.Job, f.MakeErr
+}
+
 type Job struct {
 	CreateErr       error
 	SerializeBytes  []byte
@@ -95,3 +115,134 @@ func (j *Job) Status() string {
 func (j *Job) Id() job.Id {
 	return j.IdResp
 }
+
+func (j *Job) SetId(jid job.Id) {
+	j.IdResp = jid
+}
+
+// AuthJob is a Job that implements job.Authenticated. It records every Auth
+// it's given.
+type AuthJob struct {
+	Job
+	Auths []job.Auth
+}
+
+func (j *AuthJob) SetAuth(auth job.Auth) {
+	j.Auths = append(j.Auths, auth)
+}
+
+// GlobalsJob is a Job that implements job.UsesGlobals. It records the globals
+// it's given.
+type GlobalsJob struct {
+	Job
+	Globals map[string]interface{}
+}
+
+func (j *GlobalsJob) SetGlobals(globals map[string]interface{}) {
+	j.Globals = globals
+}
+
+// OverridesJob is a Job that implements job.UsesOverrides. It records the
+// overrides it's given.
+type OverridesJob struct {
+	Job
+	Overrides map[string]string
+}
+
+func (j *OverridesJob) SetOverrides(overrides map[string]string) {
+	j.Overrides = overrides
+}
+
+// SandboxedJob is a Job that implements job.Sandboxed. It records every Sandbox
+// it's given.
+type SandboxedJob struct {
+	Job
+	Sandboxes []job.Sandbox
+}
+
+func (j *SandboxedJob) SetSandbox(sb job.Sandbox) {
+	j.Sandboxes = append(j.Sandboxes, sb)
+}
+
+// LoggingJob is a Job that implements job.Logging. It records every Logger
+// it's given.
+type LoggingJob struct {
+	Job
+	Loggers []job.Logger
+}
+
+func (j *LoggingJob) SetLogger(l job.Logger) {
+	j.Loggers = append(j.Loggers, l)
+}
+
+// ReentrantJob is a Job that implements job.Reentrant. It records every Reentry
+// it's given and returns Token from ReentryToken, which RunFunc can change.
+type ReentrantJob struct {
+	Job
+	Reentries []job.Reentry
+	Token     string
+}
+
+func (j *ReentrantJob) SetReentry(re job.Reentry) {
+	j.Reentries = append(j.Reentries, re)
+}
+
+func (j *ReentrantJob) ReentryToken() string {
+	return j.Token
+}
+
+// PacedJob is a Job that implements job.Paced. It records every Feedback it's
+// given.
+type PacedJob struct {
+	Job
+	Feedbacks []job.Feedback
+}
+
+func (j *PacedJob) SetFeedback(fb job.Feedback) {
+	j.Feedbacks = append(j.Feedbacks, fb)
+}
+
+// ResourcesJob is a Job that implements job.AffectsResources. It records every
+// Resources it's given.
+type ResourcesJob struct {
+	Job
+	Resources []job.Resources
+}
+
+func (j *ResourcesJob) SetResources(r job.Resources) {
+	j.Resources = append(j.Resources, r)
+}
+
+// ExecsJob is a Job that implements job.AuditsExecs. It records every Execs
+// it's given.
+type ExecsJob struct {
+	Job
+	Execs []job.Execs
+}
+
+func (j *ExecsJob) SetExecs(e job.Execs) {
+	j.Execs = append(j.Execs, e)
+}
+
+// WorkspaceJob is a Job that implements job.UsesWorkspace. It records every
+// Workspace it's given.
+type WorkspaceJob struct {
+	Job
+	Workspaces []job.Workspace
+}
+
+func (j *WorkspaceJob) SetWorkspace(ws job.Workspace) {
+	j.Workspaces = append(j.Workspaces, ws)
+}
+
+// ReasonStopperJob is a Job that implements job.ReasonStopper. It records the
+// reason given to StopWithReason.
+type ReasonStopperJob struct {
+	Job
+	StopReason string
+}
+
+func (j *ReasonStopperJob) StopWithReason(reason string) error {
+	j.StopReason = reason
+	return j.Stop()
+}
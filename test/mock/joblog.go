@@ -4,6 +4,7 @@ package mock
 
 import (
 	"errors"
+	"time"
 
 	"github.com/square/spincycle/v2/proto"
 )
@@ -15,7 +16,10 @@ var (
 type JLStore struct {
 	CreateFunc  func(string, proto.JobLog) (proto.JobLog, error)
 	GetFunc     func(string, string) (proto.JobLog, error)
+	GetTryFunc  func(string, string, uint) (proto.JobLog, error)
 	GetFullFunc func(string) ([]proto.JobLog, error)
+	DeleteFunc  func(string, bool) (int64, error)
+	PurgeFunc   func(time.Time) (int64, error)
 }
 
 func (j *JLStore) Create(reqId string, jl proto.JobLog) (proto.JobLog, error) {
@@ -38,3 +42,24 @@ func (j *JLStore) GetFull(reqId string) ([]proto.JobLog, error) {
 	}
 	return []proto.JobLog{}, nil
 }
+
+func (j *JLStore) GetTry(reqId, jobId string, try uint) (proto.JobLog, error) {
+	if j.GetTryFunc != nil {
+		return j.GetTryFunc(reqId, jobId, try)
+	}
+	return proto.JobLog{}, nil
+}
+
+func (j *JLStore) Delete(reqId string, oldTries bool) (int64, error) {
+	if j.DeleteFunc != nil {
+		return j.DeleteFunc(reqId, oldTries)
+	}
+	return 0, nil
+}
+
+func (j *JLStore) Purge(finishedBefore time.Time) (int64, error) {
+	if j.PurgeFunc != nil {
+		return j.PurgeFunc(finishedBefore)
+	}
+	return 0, nil
+}
@@ -18,7 +18,13 @@ type JRClient struct {
 	ResumeJobChainFunc func(string, proto.SuspendedJobChain) (*url.URL, error)
 	StartRequestFunc   func(string, string) error
 	StopRequestFunc    func(string, string) error
+	PauseRequestFunc   func(string, string) error
+	ResumeRequestFunc  func(string, string) error
+	AddJobFunc         func(string, string, proto.AddChainJob) error
+	ProfileFunc        func(string, string, proto.ProfileCapture) error
+	ApproveJobFunc     func(string, string, string, proto.ApproveJob) error
 	RunningFunc        func(string, proto.StatusFilter) ([]proto.JobStatus, error)
+	JobTypesFunc       func(string) ([]string, error)
 }
 
 func (c *JRClient) NewJobChain(baseURL string, jc proto.JobChain) (*url.URL, error) {
@@ -49,9 +55,51 @@ func (c *JRClient) StopRequest(baseURL string, requestId string) error {
 	return nil
 }
 
+func (c *JRClient) PauseRequest(baseURL string, requestId string) error {
+	if c.PauseRequestFunc != nil {
+		return c.PauseRequestFunc(baseURL, requestId)
+	}
+	return nil
+}
+
+func (c *JRClient) ResumeRequest(baseURL string, requestId string) error {
+	if c.ResumeRequestFunc != nil {
+		return c.ResumeRequestFunc(baseURL, requestId)
+	}
+	return nil
+}
+
+func (c *JRClient) AddJob(baseURL string, requestId string, aj proto.AddChainJob) error {
+	if c.AddJobFunc != nil {
+		return c.AddJobFunc(baseURL, requestId, aj)
+	}
+	return nil
+}
+
+func (c *JRClient) Profile(baseURL string, requestId string, pc proto.ProfileCapture) error {
+	if c.ProfileFunc != nil {
+		return c.ProfileFunc(baseURL, requestId, pc)
+	}
+	return nil
+}
+
+func (c *JRClient) ApproveJob(baseURL string, requestId, jobId string, aj proto.ApproveJob) error {
+	if c.ApproveJobFunc != nil {
+		return c.ApproveJobFunc(baseURL, requestId, jobId, aj)
+	}
+	return nil
+}
+
 func (c *JRClient) Running(baseURL string, f proto.StatusFilter) ([]proto.JobStatus, error) {
 	if c.RunningFunc != nil {
 		return c.RunningFunc(baseURL, f)
 	}
 	return []proto.JobStatus{}, nil
 }
+
+func (c *JRClient) JobTypes(baseURL string) ([]string, error) {
+	if c.JobTypesFunc != nil {
+		return c.JobTypesFunc(baseURL)
+	}
+	return nil, nil
+}
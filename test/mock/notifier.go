@@ -0,0 +1,17 @@
+// Copyright 2020, Square, Inc.
+
+package mock
+
+import (
+	"github.com/square/spincycle/v2/proto"
+)
+
+type Notifier struct {
+	NotifyFunc func(wh proto.SequenceWebhook, ev proto.SequenceEvent)
+}
+
+func (n *Notifier) Notify(wh proto.SequenceWebhook, ev proto.SequenceEvent) {
+	if n.NotifyFunc != nil {
+		n.NotifyFunc(wh, ev)
+	}
+}
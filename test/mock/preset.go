@@ -0,0 +1,47 @@
+// Copyright 2020, Square, Inc.
+
+package mock
+
+import (
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/preset"
+)
+
+var (
+	_ preset.Manager = &PresetManager{}
+)
+
+type PresetManager struct {
+	PutFunc    func(proto.RequestPreset) (proto.RequestPreset, error)
+	GetFunc    func(string) (proto.RequestPreset, error)
+	ListFunc   func() ([]proto.RequestPreset, error)
+	DeleteFunc func(string) error
+}
+
+func (m *PresetManager) Put(p proto.RequestPreset) (proto.RequestPreset, error) {
+	if m.PutFunc != nil {
+		return m.PutFunc(p)
+	}
+	return p, nil
+}
+
+func (m *PresetManager) Get(name string) (proto.RequestPreset, error) {
+	if m.GetFunc != nil {
+		return m.GetFunc(name)
+	}
+	return proto.RequestPreset{}, nil
+}
+
+func (m *PresetManager) List() ([]proto.RequestPreset, error) {
+	if m.ListFunc != nil {
+		return m.ListFunc()
+	}
+	return []proto.RequestPreset{}, nil
+}
+
+func (m *PresetManager) Delete(name string) error {
+	if m.DeleteFunc != nil {
+		return m.DeleteFunc(name)
+	}
+	return nil
+}
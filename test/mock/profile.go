@@ -0,0 +1,39 @@
+// Copyright 2020, Square, Inc.
+
+package mock
+
+import (
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/profile"
+)
+
+var (
+	_ profile.Manager = &ProfileManager{}
+)
+
+type ProfileManager struct {
+	CreateFunc func(proto.Profile) (proto.Profile, error)
+	ListFunc   func(string) ([]proto.Profile, error)
+	GetFunc    func(string, string) (proto.Profile, error)
+}
+
+func (m *ProfileManager) Create(p proto.Profile) (proto.Profile, error) {
+	if m.CreateFunc != nil {
+		return m.CreateFunc(p)
+	}
+	return p, nil
+}
+
+func (m *ProfileManager) List(requestId string) ([]proto.Profile, error) {
+	if m.ListFunc != nil {
+		return m.ListFunc(requestId)
+	}
+	return []proto.Profile{}, nil
+}
+
+func (m *ProfileManager) Get(requestId, profileId string) (proto.Profile, error) {
+	if m.GetFunc != nil {
+		return m.GetFunc(requestId, profileId)
+	}
+	return proto.Profile{}, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2020, Square, Inc.
+
+package mock
+
+import (
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/registry"
+)
+
+var (
+	_ registry.Manager = &Registry{}
+)
+
+type Registry struct {
+	HeartbeatFunc  func(proto.JobRunner) error
+	DeregisterFunc func(string) error
+	ListFunc       func() ([]proto.JobRunner, error)
+	URLFunc        func([]string) string
+	DeadFunc       func() ([]string, error)
+}
+
+func (r *Registry) Heartbeat(jr proto.JobRunner) error {
+	if r.HeartbeatFunc != nil {
+		return r.HeartbeatFunc(jr)
+	}
+	return nil
+}
+
+func (r *Registry) Deregister(url string) error {
+	if r.DeregisterFunc != nil {
+		return r.DeregisterFunc(url)
+	}
+	return nil
+}
+
+func (r *Registry) List() ([]proto.JobRunner, error) {
+	if r.ListFunc != nil {
+		return r.ListFunc()
+	}
+	return []proto.JobRunner{}, nil
+}
+
+func (r *Registry) URL(needs []string) string {
+	if r.URLFunc != nil {
+		return r.URLFunc(needs)
+	}
+	return ""
+}
+
+func (r *Registry) Dead() ([]string, error) {
+	if r.DeadFunc != nil {
+		return r.DeadFunc()
+	}
+	return nil, nil
+}
@@ -16,16 +16,26 @@ var (
 )
 
 type RequestManager struct {
-	CreateFunc      func(proto.CreateRequest) (proto.Request, error)
-	GetFunc         func(string) (proto.Request, error)
-	GetWithJCFunc   func(string) (proto.Request, error)
-	StartFunc       func(string) error
-	StopFunc        func(string) error
-	FinishFunc      func(string, proto.FinishRequest) error
-	FailPendingFunc func(string) error
-	SpecsFunc       func() []proto.RequestSpec
-	JobChainFunc    func(string) (proto.JobChain, error)
-	FindFunc        func(proto.RequestFilter) ([]proto.Request, error)
+	CreateFunc          func(proto.CreateRequest) (proto.Request, error)
+	RerunFunc           func(proto.RerunRequest) (proto.Request, error)
+	CreateFromChainFunc func(proto.CreateRequestFromChain) (proto.Request, error)
+	GetFunc             func(string) (proto.Request, error)
+	GetWithJCFunc       func(string) (proto.Request, error)
+	StartFunc           func(string) error
+	StopFunc            func(string) error
+	PauseFunc           func(string) error
+	ResumeFunc          func(string) error
+	CaptureProfileFunc  func(string, proto.ProfileCapture) error
+	AddJobFunc          func(string, proto.AddJob) (proto.Job, error)
+	ApproveJobFunc      func(string, string, string) error
+	FinishFunc          func(string, proto.FinishRequest) error
+	FailPendingFunc     func(string) error
+	DispatchAllFunc     func()
+	SpecsFunc           func() []proto.RequestSpec
+	JobChainFunc        func(string) (proto.JobChain, error)
+	ArgsDiffFunc        func(string) (proto.RequestArgsDiff, error)
+	JobSnapshotFunc     func(string, string) (proto.JobSnapshot, error)
+	FindFunc            func(proto.RequestFilter) ([]proto.Request, error)
 }
 
 func (r *RequestManager) Create(reqParams proto.CreateRequest) (proto.Request, error) {
@@ -56,6 +66,13 @@ func (r *RequestManager) Start(reqId string) error {
 	return nil
 }
 
+func (r *RequestManager) AddJob(reqId string, aj proto.AddJob) (proto.Job, error) {
+	if r.AddJobFunc != nil {
+		return r.AddJobFunc(reqId, aj)
+	}
+	return proto.Job{}, nil
+}
+
 func (r *RequestManager) Finish(reqId string, finishParams proto.FinishRequest) error {
 	if r.FinishFunc != nil {
 		return r.FinishFunc(reqId, finishParams)
@@ -77,6 +94,40 @@ func (r *RequestManager) Stop(reqId string) error {
 	return nil
 }
 
+func (r *RequestManager) Pause(reqId string) error {
+	if r.PauseFunc != nil {
+		return r.PauseFunc(reqId)
+	}
+	return nil
+}
+
+func (r *RequestManager) Resume(reqId string) error {
+	if r.ResumeFunc != nil {
+		return r.ResumeFunc(reqId)
+	}
+	return nil
+}
+
+func (r *RequestManager) CaptureProfile(reqId string, pc proto.ProfileCapture) error {
+	if r.CaptureProfileFunc != nil {
+		return r.CaptureProfileFunc(reqId, pc)
+	}
+	return nil
+}
+
+func (r *RequestManager) ApproveJob(reqId, jobId, user string) error {
+	if r.ApproveJobFunc != nil {
+		return r.ApproveJobFunc(reqId, jobId, user)
+	}
+	return nil
+}
+
+func (r *RequestManager) DispatchAll() {
+	if r.DispatchAllFunc != nil {
+		r.DispatchAllFunc()
+	}
+}
+
 func (r *RequestManager) Specs() []proto.RequestSpec {
 	if r.SpecsFunc != nil {
 		return r.SpecsFunc()
@@ -91,6 +142,34 @@ func (r *RequestManager) JobChain(reqId string) (proto.JobChain, error) {
 	return proto.JobChain{}, nil
 }
 
+func (r *RequestManager) ArgsDiff(reqId string) (proto.RequestArgsDiff, error) {
+	if r.ArgsDiffFunc != nil {
+		return r.ArgsDiffFunc(reqId)
+	}
+	return proto.RequestArgsDiff{}, nil
+}
+
+func (r *RequestManager) JobSnapshot(reqId, jobId string) (proto.JobSnapshot, error) {
+	if r.JobSnapshotFunc != nil {
+		return r.JobSnapshotFunc(reqId, jobId)
+	}
+	return proto.JobSnapshot{}, nil
+}
+
+func (r *RequestManager) Rerun(rr proto.RerunRequest) (proto.Request, error) {
+	if r.RerunFunc != nil {
+		return r.RerunFunc(rr)
+	}
+	return proto.Request{}, nil
+}
+
+func (r *RequestManager) CreateFromChain(cr proto.CreateRequestFromChain) (proto.Request, error) {
+	if r.CreateFromChainFunc != nil {
+		return r.CreateFromChainFunc(cr)
+	}
+	return proto.Request{}, nil
+}
+
 func (r *RequestManager) Find(filter proto.RequestFilter) ([]proto.Request, error) {
 	if r.FindFunc != nil {
 		return r.FindFunc(filter)
@@ -105,6 +184,7 @@ type RequestResumer struct {
 	CleanupFunc   func()
 	ResumeFunc    func(string) error
 	SuspendFunc   func(proto.SuspendedJobChain) error
+	RecoverFunc   func(string) error
 }
 
 func (r *RequestResumer) ResumeAll() {
@@ -121,6 +201,13 @@ func (r *RequestResumer) Cleanup() {
 	return
 }
 
+func (r *RequestResumer) Recover(jrURL string) error {
+	if r.RecoverFunc != nil {
+		return r.RecoverFunc(jrURL)
+	}
+	return nil
+}
+
 func (r *RequestResumer) Resume(id string) error {
 	if r.ResumeFunc != nil {
 		return r.ResumeFunc(id)
@@ -140,6 +227,7 @@ func (r *RequestResumer) Suspend(sjc proto.SuspendedJobChain) error {
 type AuthPlugin struct {
 	AuthenticateFunc func(*http.Request) (auth.Caller, error)
 	AuthorizeFunc    func(c auth.Caller, op string, req proto.Request) error
+	RolesFunc        func(user string) ([]string, error)
 }
 
 func (a AuthPlugin) Authenticate(req *http.Request) (auth.Caller, error) {
@@ -155,3 +243,10 @@ func (a AuthPlugin) Authorize(c auth.Caller, op string, req proto.Request) error
 	}
 	return nil
 }
+
+func (a AuthPlugin) Roles(user string) ([]string, error) {
+	if a.RolesFunc != nil {
+		return a.RolesFunc(user)
+	}
+	return nil, nil
+}
@@ -21,6 +21,9 @@ func (f *ResolverFactory) Make(req proto.Request) graph.Resolver {
 type Resolver struct {
 	RequestArgsFunc       func(jobArgs map[string]interface{}) ([]proto.RequestArg, error)
 	BuildRequestGraphFunc func(jobArgs map[string]interface{}) (*graph.Graph, error)
+	GlobalsFunc           func() map[string]interface{}
+	WebhooksFunc          func() []proto.SequenceWebhook
+	EscalationsFunc       func() []proto.SequenceEscalation
 }
 
 func (o *Resolver) RequestArgs(jobArgs map[string]interface{}) ([]proto.RequestArg, error) {
@@ -35,3 +38,24 @@ func (o *Resolver) BuildRequestGraph(jobArgs map[string]interface{}) (*graph.Gra
 	}
 	return nil, nil
 }
+
+func (o *Resolver) Globals() map[string]interface{} {
+	if o.GlobalsFunc != nil {
+		return o.GlobalsFunc()
+	}
+	return nil
+}
+
+func (o *Resolver) Webhooks() []proto.SequenceWebhook {
+	if o.WebhooksFunc != nil {
+		return o.WebhooksFunc()
+	}
+	return nil
+}
+
+func (o *Resolver) Escalations() []proto.SequenceEscalation {
+	if o.EscalationsFunc != nil {
+		return o.EscalationsFunc()
+	}
+	return nil
+}
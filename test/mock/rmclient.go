@@ -13,19 +13,48 @@ var (
 )
 
 type RMClient struct {
-	CreateRequestFunc  func(string, map[string]interface{}) (string, error)
-	GetRequestFunc     func(string) (proto.Request, error)
-	FindRequestsFunc   func(proto.RequestFilter) ([]proto.Request, error)
-	StartRequestFunc   func(string) error
-	FinishRequestFunc  func(proto.FinishRequest) error
-	StopRequestFunc    func(string) error
-	SuspendRequestFunc func(string, proto.SuspendedJobChain) error
-	GetJobChainFunc    func(string) (proto.JobChain, error)
-	GetJLFunc          func(string) ([]proto.JobLog, error)
-	CreateJLFunc       func(string, proto.JobLog) error
-	RunningFunc        func(proto.StatusFilter) (proto.RunningStatus, error)
-	RequestListFunc    func() ([]proto.RequestSpec, error)
-	UpdateProgressFunc func(proto.RequestProgress) error
+	CreateRequestFunc          func(string, map[string]interface{}) (string, error)
+	CreateRequestWithFunc      func(proto.CreateRequest) (string, error)
+	CreateRequestFromChainFunc func(proto.CreateRequestFromChain) (string, error)
+	GetRequestFunc             func(string) (proto.Request, error)
+	GetRequestsFunc            func([]string) (proto.RequestsStatus, error)
+	RerunRequestFunc           func(string, string) (string, error)
+	CreateGroupFunc            func(proto.CreateRequestGroup) (proto.RequestGroup, error)
+	GetGroupFunc               func(string) (proto.RequestGroup, error)
+	StopGroupFunc              func(string) error
+	FindRequestsFunc           func(proto.RequestFilter) ([]proto.Request, error)
+	StartRequestFunc           func(string) error
+	FinishRequestFunc          func(proto.FinishRequest) error
+	StopRequestFunc            func(string) error
+	PauseRequestFunc           func(string) error
+	ResumeRequestFunc          func(string) error
+	ApproveJobFunc             func(string, string) error
+	SuspendRequestFunc         func(string, proto.SuspendedJobChain) error
+	GetJobChainFunc            func(string) (proto.JobChain, error)
+	GetArgsDiffFunc            func(string) (proto.RequestArgsDiff, error)
+	GetReportFunc              func(string, string) ([]byte, error)
+	GetTimelineFunc            func(string) (proto.RequestTimeline, error)
+	GetStopPreviewFunc         func(string) (proto.StopPreview, error)
+	GetJobSnapshotFunc         func(string, string) (proto.JobSnapshot, error)
+	GetJLFunc                  func(string) ([]proto.JobLog, error)
+	CreateJLFunc               func(string, proto.JobLog) error
+	CaptureProfileFunc         func(string, proto.ProfileCapture) error
+	AttachProfileFunc          func(string, proto.Profile) error
+	GetProfilesFunc            func(string) ([]proto.Profile, error)
+	GetProfileFunc             func(string, string) ([]byte, error)
+	RunningFunc                func(proto.StatusFilter) (proto.RunningStatus, error)
+	RequestListFunc            func() ([]proto.RequestSpec, error)
+	UpdateProgressFunc         func(proto.RequestProgress) error
+	UpdateProgressBatchFunc    func([]proto.RequestProgress) error
+	CreateTokenFunc            func(proto.CreateToken) (proto.Token, error)
+	ListTokensFunc             func() ([]proto.Token, error)
+	RevokeTokenFunc            func(string) error
+	ListPresetsFunc            func() ([]proto.RequestPreset, error)
+	GetPresetFunc              func(string) (proto.RequestPreset, error)
+	HeartbeatFunc              func(proto.JobRunner) error
+	DeregisterFunc             func(string) error
+	AcquireLockFunc            func(proto.SingletonLock) (proto.SingletonLock, error)
+	ReleaseLockFunc            func(proto.SingletonLock) error
 }
 
 func (c *RMClient) CreateRequest(requestId string, args map[string]interface{}) (string, error) {
@@ -35,6 +64,20 @@ func (c *RMClient) CreateRequest(requestId string, args map[string]interface{})
 	return "", nil
 }
 
+func (c *RMClient) CreateRequestWith(cr proto.CreateRequest) (string, error) {
+	if c.CreateRequestWithFunc != nil {
+		return c.CreateRequestWithFunc(cr)
+	}
+	return "", nil
+}
+
+func (c *RMClient) CreateRequestFromChain(cr proto.CreateRequestFromChain) (string, error) {
+	if c.CreateRequestFromChainFunc != nil {
+		return c.CreateRequestFromChainFunc(cr)
+	}
+	return "", nil
+}
+
 func (c *RMClient) GetRequest(requestId string) (proto.Request, error) {
 	if c.GetRequestFunc != nil {
 		return c.GetRequestFunc(requestId)
@@ -42,6 +85,41 @@ func (c *RMClient) GetRequest(requestId string) (proto.Request, error) {
 	return proto.Request{}, nil
 }
 
+func (c *RMClient) GetRequests(requestIds []string) (proto.RequestsStatus, error) {
+	if c.GetRequestsFunc != nil {
+		return c.GetRequestsFunc(requestIds)
+	}
+	return proto.RequestsStatus{}, nil
+}
+
+func (c *RMClient) RerunRequest(requestId, jobId string) (string, error) {
+	if c.RerunRequestFunc != nil {
+		return c.RerunRequestFunc(requestId, jobId)
+	}
+	return "", nil
+}
+
+func (c *RMClient) CreateGroup(cg proto.CreateRequestGroup) (proto.RequestGroup, error) {
+	if c.CreateGroupFunc != nil {
+		return c.CreateGroupFunc(cg)
+	}
+	return proto.RequestGroup{}, nil
+}
+
+func (c *RMClient) GetGroup(groupId string) (proto.RequestGroup, error) {
+	if c.GetGroupFunc != nil {
+		return c.GetGroupFunc(groupId)
+	}
+	return proto.RequestGroup{}, nil
+}
+
+func (c *RMClient) StopGroup(groupId string) error {
+	if c.StopGroupFunc != nil {
+		return c.StopGroupFunc(groupId)
+	}
+	return nil
+}
+
 func (c *RMClient) FindRequests(filter proto.RequestFilter) ([]proto.Request, error) {
 	if c.FindRequestsFunc != nil {
 		return c.FindRequestsFunc(filter)
@@ -63,6 +141,27 @@ func (c *RMClient) FinishRequest(fr proto.FinishRequest) error {
 	return nil
 }
 
+func (c *RMClient) PauseRequest(requestId string) error {
+	if c.PauseRequestFunc != nil {
+		return c.PauseRequestFunc(requestId)
+	}
+	return nil
+}
+
+func (c *RMClient) ResumeRequest(requestId string) error {
+	if c.ResumeRequestFunc != nil {
+		return c.ResumeRequestFunc(requestId)
+	}
+	return nil
+}
+
+func (c *RMClient) ApproveJob(requestId, jobId string) error {
+	if c.ApproveJobFunc != nil {
+		return c.ApproveJobFunc(requestId, jobId)
+	}
+	return nil
+}
+
 func (c *RMClient) StopRequest(requestId string) error {
 	if c.StopRequestFunc != nil {
 		return c.StopRequestFunc(requestId)
@@ -84,6 +183,41 @@ func (c *RMClient) GetJobChain(requestId string) (proto.JobChain, error) {
 	return proto.JobChain{}, nil
 }
 
+func (c *RMClient) GetArgsDiff(requestId string) (proto.RequestArgsDiff, error) {
+	if c.GetArgsDiffFunc != nil {
+		return c.GetArgsDiffFunc(requestId)
+	}
+	return proto.RequestArgsDiff{}, nil
+}
+
+func (c *RMClient) GetReport(requestId, format string) ([]byte, error) {
+	if c.GetReportFunc != nil {
+		return c.GetReportFunc(requestId, format)
+	}
+	return nil, nil
+}
+
+func (c *RMClient) GetJobSnapshot(requestId, jobId string) (proto.JobSnapshot, error) {
+	if c.GetJobSnapshotFunc != nil {
+		return c.GetJobSnapshotFunc(requestId, jobId)
+	}
+	return proto.JobSnapshot{}, nil
+}
+
+func (c *RMClient) GetTimeline(requestId string) (proto.RequestTimeline, error) {
+	if c.GetTimelineFunc != nil {
+		return c.GetTimelineFunc(requestId)
+	}
+	return proto.RequestTimeline{}, nil
+}
+
+func (c *RMClient) GetStopPreview(requestId string) (proto.StopPreview, error) {
+	if c.GetStopPreviewFunc != nil {
+		return c.GetStopPreviewFunc(requestId)
+	}
+	return proto.StopPreview{}, nil
+}
+
 func (c *RMClient) GetJL(requestId string) ([]proto.JobLog, error) {
 	if c.GetJLFunc != nil {
 		return c.GetJLFunc(requestId)
@@ -98,6 +232,34 @@ func (c *RMClient) CreateJL(requestId string, jl proto.JobLog) error {
 	return nil
 }
 
+func (c *RMClient) CaptureProfile(requestId string, pc proto.ProfileCapture) error {
+	if c.CaptureProfileFunc != nil {
+		return c.CaptureProfileFunc(requestId, pc)
+	}
+	return nil
+}
+
+func (c *RMClient) AttachProfile(requestId string, p proto.Profile) error {
+	if c.AttachProfileFunc != nil {
+		return c.AttachProfileFunc(requestId, p)
+	}
+	return nil
+}
+
+func (c *RMClient) GetProfiles(requestId string) ([]proto.Profile, error) {
+	if c.GetProfilesFunc != nil {
+		return c.GetProfilesFunc(requestId)
+	}
+	return nil, nil
+}
+
+func (c *RMClient) GetProfile(requestId, profileId string) ([]byte, error) {
+	if c.GetProfileFunc != nil {
+		return c.GetProfileFunc(requestId, profileId)
+	}
+	return nil, nil
+}
+
 func (c *RMClient) RequestList() ([]proto.RequestSpec, error) {
 	if c.RequestListFunc != nil {
 		return c.RequestListFunc()
@@ -113,8 +275,78 @@ func (c *RMClient) Running(f proto.StatusFilter) (proto.RunningStatus, error) {
 }
 
 func (c *RMClient) UpdateProgress(prg proto.RequestProgress) error {
-	if c.RequestListFunc != nil {
+	if c.UpdateProgressFunc != nil {
 		return c.UpdateProgressFunc(prg)
 	}
 	return nil
 }
+
+func (c *RMClient) UpdateProgressBatch(batch []proto.RequestProgress) error {
+	if c.UpdateProgressBatchFunc != nil {
+		return c.UpdateProgressBatchFunc(batch)
+	}
+	return nil
+}
+
+func (c *RMClient) CreateToken(ct proto.CreateToken) (proto.Token, error) {
+	if c.CreateTokenFunc != nil {
+		return c.CreateTokenFunc(ct)
+	}
+	return proto.Token{}, nil
+}
+
+func (c *RMClient) ListTokens() ([]proto.Token, error) {
+	if c.ListTokensFunc != nil {
+		return c.ListTokensFunc()
+	}
+	return []proto.Token{}, nil
+}
+
+func (c *RMClient) RevokeToken(tokenId string) error {
+	if c.RevokeTokenFunc != nil {
+		return c.RevokeTokenFunc(tokenId)
+	}
+	return nil
+}
+
+func (c *RMClient) ListPresets() ([]proto.RequestPreset, error) {
+	if c.ListPresetsFunc != nil {
+		return c.ListPresetsFunc()
+	}
+	return []proto.RequestPreset{}, nil
+}
+
+func (c *RMClient) GetPreset(name string) (proto.RequestPreset, error) {
+	if c.GetPresetFunc != nil {
+		return c.GetPresetFunc(name)
+	}
+	return proto.RequestPreset{}, nil
+}
+
+func (c *RMClient) Heartbeat(jr proto.JobRunner) error {
+	if c.HeartbeatFunc != nil {
+		return c.HeartbeatFunc(jr)
+	}
+	return nil
+}
+
+func (c *RMClient) Deregister(url string) error {
+	if c.DeregisterFunc != nil {
+		return c.DeregisterFunc(url)
+	}
+	return nil
+}
+
+func (c *RMClient) AcquireLock(l proto.SingletonLock) (proto.SingletonLock, error) {
+	if c.AcquireLockFunc != nil {
+		return c.AcquireLockFunc(l)
+	}
+	return l, nil
+}
+
+func (c *RMClient) ReleaseLock(l proto.SingletonLock) error {
+	if c.ReleaseLockFunc != nil {
+		return c.ReleaseLockFunc(l)
+	}
+	return nil
+}
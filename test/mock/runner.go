@@ -6,6 +6,7 @@ import (
 	"errors"
 	"sync"
 
+	"github.com/square/spincycle/v2/job"
 	"github.com/square/spincycle/v2/job-runner/runner"
 	"github.com/square/spincycle/v2/proto"
 )
@@ -17,16 +18,27 @@ var (
 type RunnerFactory struct {
 	RunnersToReturn map[string]*Runner // Keyed on job name.
 	MakeErr         error
-	MakeFunc        func(job proto.Job, requestId string, prevTries uint, totalTries uint) (runner.Runner, error)
+	MakeFunc        func(job proto.Job, req runner.Request, prevTries uint, totalTries uint) (runner.Runner, error)
 }
 
-func (f *RunnerFactory) Make(job proto.Job, requestId string, prevTries uint, totalTries uint) (runner.Runner, error) {
+func (f *RunnerFactory) Make(job proto.Job, req runner.Request, prevTries uint, totalTries uint) (runner.Runner, error) {
 	if f.MakeFunc != nil {
-		return f.MakeFunc(job, requestId, prevTries, totalTries)
+		return f.MakeFunc(job, req, prevTries, totalTries)
 	}
 	return f.RunnersToReturn[job.Id], f.MakeErr
 }
 
+type TokenProvider struct {
+	TokenFunc func(user string, jobId job.Id) (string, error)
+}
+
+func (tp *TokenProvider) Token(user string, jobId job.Id) (string, error) {
+	if tp.TokenFunc != nil {
+		return tp.TokenFunc(user, jobId)
+	}
+	return "", nil
+}
+
 type Runner struct {
 	RunReturn    runner.Return
 	RunErr       error
@@ -36,6 +48,7 @@ type Runner struct {
 	RunBlock     chan struct{}                             // Channel that runner.Run() will block on, if defined.
 	IgnoreStop   bool                                      // false: return immediately after Stop, true: keep running after Stop
 	StatusResp   runner.Status
+	StopReason   string // reason given to Stop
 
 	stopped bool // if Stop was called
 }
@@ -67,8 +80,9 @@ func (r *Runner) Run(jobData map[string]interface{}) runner.Return {
 	return r.RunReturn
 }
 
-func (r *Runner) Stop() error {
+func (r *Runner) Stop(reason string) error {
 	r.stopped = true
+	r.StopReason = reason
 	if r.RunBlock != nil {
 		close(r.RunBlock)
 	}
@@ -0,0 +1,44 @@
+// Copyright 2020, Square, Inc.
+
+package mock
+
+import (
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/shadow"
+)
+
+var _ shadow.Manager = &ShadowManager{}
+
+type ShadowManager struct {
+	StartFunc    func(proto.Request)
+	IsShadowFunc func(string) (bool, error)
+	FinishFunc   func(string, proto.FinishRequest) error
+	GetFunc      func(string) (proto.ShadowRun, error)
+}
+
+func (s *ShadowManager) Start(req proto.Request) {
+	if s.StartFunc != nil {
+		s.StartFunc(req)
+	}
+}
+
+func (s *ShadowManager) IsShadow(id string) (bool, error) {
+	if s.IsShadowFunc != nil {
+		return s.IsShadowFunc(id)
+	}
+	return false, nil
+}
+
+func (s *ShadowManager) Finish(shadowId string, finishParams proto.FinishRequest) error {
+	if s.FinishFunc != nil {
+		return s.FinishFunc(shadowId, finishParams)
+	}
+	return nil
+}
+
+func (s *ShadowManager) Get(requestId string) (proto.ShadowRun, error) {
+	if s.GetFunc != nil {
+		return s.GetFunc(requestId)
+	}
+	return proto.ShadowRun{}, nil
+}
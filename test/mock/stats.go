@@ -0,0 +1,39 @@
+// Copyright 2020, Square, Inc.
+
+package mock
+
+import (
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/stats"
+)
+
+var (
+	_ stats.Manager = &StatsManager{}
+)
+
+type StatsManager struct {
+	AggregateFunc func() error
+	JobTypesFunc  func(string) (proto.JobTypeStats, error)
+	CachedFunc    func(string) (proto.JobTypeStats, bool)
+}
+
+func (m *StatsManager) Aggregate() error {
+	if m.AggregateFunc != nil {
+		return m.AggregateFunc()
+	}
+	return nil
+}
+
+func (m *StatsManager) JobTypes(window string) (proto.JobTypeStats, error) {
+	if m.JobTypesFunc != nil {
+		return m.JobTypesFunc(window)
+	}
+	return proto.JobTypeStats{}, nil
+}
+
+func (m *StatsManager) Cached(window string) (proto.JobTypeStats, bool) {
+	if m.CachedFunc != nil {
+		return m.CachedFunc(window)
+	}
+	return proto.JobTypeStats{}, false
+}
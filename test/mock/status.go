@@ -7,7 +7,9 @@ import (
 )
 
 type JRStatus struct {
-	RunningFunc func(proto.StatusFilter) ([]proto.JobStatus, error)
+	RunningFunc    func(proto.StatusFilter) ([]proto.JobStatus, error)
+	SchedulingFunc func(proto.StatusFilter) (proto.SchedulingStatus, error)
+	DebugFunc      func(proto.StatusFilter) (proto.RunnerDebug, error)
 }
 
 func (s *JRStatus) Running(f proto.StatusFilter) ([]proto.JobStatus, error) {
@@ -17,11 +19,26 @@ func (s *JRStatus) Running(f proto.StatusFilter) ([]proto.JobStatus, error) {
 	return []proto.JobStatus{}, nil
 }
 
+func (s *JRStatus) Scheduling(f proto.StatusFilter) (proto.SchedulingStatus, error) {
+	if s.SchedulingFunc != nil {
+		return s.SchedulingFunc(f)
+	}
+	return proto.SchedulingStatus{}, nil
+}
+
+func (s *JRStatus) Debug(f proto.StatusFilter) (proto.RunnerDebug, error) {
+	if s.DebugFunc != nil {
+		return s.DebugFunc(f)
+	}
+	return proto.RunnerDebug{}, nil
+}
+
 // --------------------------------------------------------------------------
 
 type RMStatus struct {
 	RunningFunc        func(proto.StatusFilter) (proto.RunningStatus, error)
 	UpdateProgressFunc func(proto.RequestProgress) error
+	RequestsFunc       func([]string) (proto.RequestsStatus, error)
 }
 
 func (s *RMStatus) Running(f proto.StatusFilter) (proto.RunningStatus, error) {
@@ -37,3 +54,10 @@ func (s *RMStatus) UpdateProgress(prg proto.RequestProgress) error {
 	}
 	return nil
 }
+
+func (s *RMStatus) Requests(requestIds []string) (proto.RequestsStatus, error) {
+	if s.RequestsFunc != nil {
+		return s.RequestsFunc(requestIds)
+	}
+	return proto.RequestsStatus{}, nil
+}
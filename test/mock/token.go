@@ -0,0 +1,53 @@
+// Copyright 2020, Square, Inc.
+
+package mock
+
+import (
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/token"
+)
+
+var _ token.Manager = &TokenManager{}
+
+type TokenManager struct {
+	CreateFunc       func(string, proto.CreateToken) (proto.Token, error)
+	AuthenticateFunc func(string) (proto.Token, error)
+	GetFunc          func(string) (proto.Token, error)
+	ListFunc         func(string) ([]proto.Token, error)
+	RevokeFunc       func(string) error
+}
+
+func (t *TokenManager) Create(user string, ct proto.CreateToken) (proto.Token, error) {
+	if t.CreateFunc != nil {
+		return t.CreateFunc(user, ct)
+	}
+	return proto.Token{}, nil
+}
+
+func (t *TokenManager) Authenticate(secret string) (proto.Token, error) {
+	if t.AuthenticateFunc != nil {
+		return t.AuthenticateFunc(secret)
+	}
+	return proto.Token{}, nil
+}
+
+func (t *TokenManager) Get(id string) (proto.Token, error) {
+	if t.GetFunc != nil {
+		return t.GetFunc(id)
+	}
+	return proto.Token{}, nil
+}
+
+func (t *TokenManager) List(user string) ([]proto.Token, error) {
+	if t.ListFunc != nil {
+		return t.ListFunc(user)
+	}
+	return []proto.Token{}, nil
+}
+
+func (t *TokenManager) Revoke(id string) error {
+	if t.RevokeFunc != nil {
+		return t.RevokeFunc(id)
+	}
+	return nil
+}
@@ -14,10 +14,16 @@ var (
 )
 
 type Traverser struct {
-	RunErr    error
-	StopErr   error
-	StatusErr error
-	JobStatus []proto.JobStatus
+	RunErr     error
+	StopErr    error
+	PauseErr   error
+	ResumeErr  error
+	StatusErr  error
+	AddJobFunc func(job proto.Job, after string) error
+	JobStatus  []proto.JobStatus
+	HeldStatus []proto.JobStatus
+	SchedStats proto.SchedulingStats
+	DebugStats proto.ChainDebug
 }
 
 func (t *Traverser) Run() {
@@ -28,6 +34,14 @@ func (t *Traverser) Stop() error {
 	return t.StopErr
 }
 
+func (t *Traverser) Pause() error {
+	return t.PauseErr
+}
+
+func (t *Traverser) Resume() error {
+	return t.ResumeErr
+}
+
 func (t *Traverser) Running() []proto.JobStatus {
 	if t.JobStatus != nil {
 		return t.JobStatus
@@ -35,6 +49,28 @@ func (t *Traverser) Running() []proto.JobStatus {
 	return []proto.JobStatus{}
 }
 
+func (t *Traverser) Held() []proto.JobStatus {
+	if t.HeldStatus != nil {
+		return t.HeldStatus
+	}
+	return []proto.JobStatus{}
+}
+
+func (t *Traverser) Scheduling() proto.SchedulingStats {
+	return t.SchedStats
+}
+
+func (t *Traverser) Debug() proto.ChainDebug {
+	return t.DebugStats
+}
+
+func (t *Traverser) AddJob(job proto.Job, after string) error {
+	if t.AddJobFunc != nil {
+		return t.AddJobFunc(job, after)
+	}
+	return nil
+}
+
 type TraverserFactory struct {
 	MakeFunc        func(*proto.JobChain) (chain.Traverser, error)
 	MakeFromSJCFunc func(*proto.SuspendedJobChain) (chain.Traverser, error)
@@ -0,0 +1,38 @@
+// Copyright 2020, Square, Inc.
+
+package mock
+
+import (
+	"github.com/square/spincycle/v2/proto"
+	"github.com/square/spincycle/v2/request-manager/trigger"
+)
+
+var (
+	_ trigger.Manager = &TriggerManager{}
+)
+
+type TriggerManager struct {
+	ReceiveFunc func(string, []byte) (proto.TriggerResult, error)
+	RunFunc     func(<-chan struct{})
+	ErrorsFunc  func(string) ([]proto.TriggerError, error)
+}
+
+func (m *TriggerManager) Receive(name string, payload []byte) (proto.TriggerResult, error) {
+	if m.ReceiveFunc != nil {
+		return m.ReceiveFunc(name, payload)
+	}
+	return proto.TriggerResult{}, nil
+}
+
+func (m *TriggerManager) Run(stopChan <-chan struct{}) {
+	if m.RunFunc != nil {
+		m.RunFunc(stopChan)
+	}
+}
+
+func (m *TriggerManager) Errors(name string) ([]proto.TriggerError, error) {
+	if m.ErrorsFunc != nil {
+		return m.ErrorsFunc(name)
+	}
+	return []proto.TriggerError{}, nil
+}
@@ -0,0 +1,479 @@
+// Copyright 2017-2020, Square, Inc.
+
+// Package validate checks job chains (proto.JobChain) with the rules that the
+// Request Manager and Job Runner apply. It does not depend on the grapher or
+// request specs, so services that make job chains outside the RM, like planners
+// that create requests from job chains (POST /api/v1/requests/job-chain), can
+// check a job chain before sending it.
+package validate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/square/spincycle/v2/proto"
+)
+
+// ErrInvalidChain is the error returned when a chain is not valid.
+type ErrInvalidChain struct {
+	Message string
+}
+
+func (e ErrInvalidChain) Error() string {
+	return e.Message
+}
+
+// NewJob checks if a job in a new job chain is valid: its state must be
+// PENDING. Chain checks this for all jobs, but callers reading a streamed
+// job chain can check each job as it's read.
+func NewJob(job proto.Job) error {
+	if job.State != proto.STATE_PENDING {
+		return fmt.Errorf("invalid job state for new job chain: %s (%d), job %s (ID %s); all job states must be PENDING",
+			proto.StateName[job.State], job.State, job.Name, job.Id)
+	}
+	return nil
+}
+
+// Chain checks if a job chain is valid like the Job Runner does before running
+// it: its Graph and job states. It returns an error if it's not. new indicates
+// if the job chain is new (true) or suspended (false). New job chains can have
+// only PENDING jobs, but suspended jobs chains can have PENDING or STOPPED jobs.
+func Chain(jobChain proto.JobChain, new bool) error {
+	if err := Graph(jobChain); err != nil {
+		return err
+	}
+
+	// Validate job states. For new job chains, all jobs must be PENDING.
+	// For suspended/resumed (not-new) chains, jobs must be PENDING, COMPLETE,
+	// or STOPPED.
+	if new {
+		for _, job := range jobChain.Jobs {
+			if err := NewJob(job); err != nil {
+				return err
+			}
+		}
+		if jobChain.FinishedJobs != 0 {
+			return fmt.Errorf("FinishedJobs = %d, expected 0 for new job chain", jobChain.FinishedJobs)
+		}
+	} else {
+		completedJobs := uint(0)
+		for _, job := range jobChain.Jobs {
+			switch job.State {
+			case proto.STATE_COMPLETE:
+				completedJobs += 1
+			case proto.STATE_PENDING, proto.STATE_STOPPED:
+			default:
+				return fmt.Errorf("invalid job state for existing job chain: %s (%d), job %s (ID %s); all job states must be PENDING, COMPLETE, or STOPPED",
+					proto.StateName[job.State], job.State, job.Name, job.Id)
+			}
+		}
+		if jobChain.FinishedJobs != completedJobs {
+			return fmt.Errorf("FinishedJobs = %d but there are %d jobs with state = COMPLETE", jobChain.FinishedJobs, completedJobs)
+		}
+	}
+
+	return nil
+}
+
+// Graph checks the graph of a job chain: every job in the adjacency list is a
+// job in the chain, there is one first job and one last job, and there are no
+// cycles. It returns an ErrInvalidChain if not.
+func Graph(jobChain proto.JobChain) error {
+	// Make sure the adjacency list is valid.
+	if !adjacencyListIsValid(jobChain) {
+		return ErrInvalidChain{
+			Message: "invalid adjacency list: some jobs exist in " +
+				"chain.AdjacencyList but not chain.Jobs",
+		}
+	}
+
+	// Make sure there is one first job.
+	if !hasFirstJob(jobChain) {
+		return ErrInvalidChain{
+			Message: "job chain has more than one start job (node with indegree count > 0)",
+		}
+	}
+
+	// Make sure there is one last job.
+	if !hasLastJob(jobChain) {
+		return ErrInvalidChain{
+			Message: "job chain has more than one start job (node with indegree count > 0)",
+		}
+	}
+
+	// Make sure there are no cycles.
+	if !isAcyclic(jobChain) {
+		return ErrInvalidChain{Message: "chain is cyclic"}
+	}
+
+	return nil
+}
+
+// Plan checks if a job chain made outside the Request Manager can be run, like
+// the RM does when creating a request from a job chain: every Job and the Graph.
+// Job states are not checked because the RM resets them to PENDING. Job chains
+// made by the RM from request specs always pass.
+func Plan(jobChain proto.JobChain) error {
+	if len(jobChain.Jobs) == 0 {
+		return ErrInvalidChain{Message: "job chain has no jobs"}
+	}
+	for jobId, job := range jobChain.Jobs {
+		if err := Job(jobChain, jobId, job); err != nil {
+			return ErrInvalidChain{Message: fmt.Sprintf("invalid job %s: %s", jobId, err)}
+		}
+	}
+	return Graph(jobChain)
+}
+
+// Job checks if the job, keyed on jobId in the job chain, can be run: its ID is
+// its key, it has a type, its Sequence and Retry are valid, and its log level
+// is valid.
+func Job(jobChain proto.JobChain, jobId string, job proto.Job) error {
+	if job.Id != jobId {
+		return fmt.Errorf("job ID %s does not match its key in the job chain", job.Id)
+	}
+	if job.Type == "" {
+		return fmt.Errorf("type is empty")
+	}
+	if err := Sequence(jobChain, job); err != nil {
+		return err
+	}
+	if err := Retry(job); err != nil {
+		return err
+	}
+	if _, ok := proto.LogLevels[job.LogLevel]; job.LogLevel != "" && !ok {
+		return fmt.Errorf("invalid log level %q", job.LogLevel)
+	}
+	return nil
+}
+
+// Sequence checks that the sequence of the job is consistent: the sequence ID
+// is the ID of the first job in the sequence, which is a job in the chain whose
+// sequence ID is its own ID, and only the first job sets sequence retry.
+func Sequence(jobChain proto.JobChain, job proto.Job) error {
+	start, ok := jobChain.Jobs[job.SequenceId]
+	if !ok {
+		return fmt.Errorf("sequence %q does not exist: sequenceId must be the ID of the first job in its sequence", job.SequenceId)
+	}
+	if start.SequenceId != start.Id {
+		return fmt.Errorf("sequence %q is not the first job in a sequence: its sequenceId is %q, expected its ID", job.SequenceId, start.SequenceId)
+	}
+	if job.Id != job.SequenceId && (job.SequenceRetry > 0 || !zeroWait(job.SequenceRetryWait)) {
+		return fmt.Errorf("sequenceRetry and sequenceRetryWait can only be set on the first job in a sequence (%s)", job.SequenceId)
+	}
+	return nil
+}
+
+// Retry checks that the retry waits of the job are valid durations, and that
+// they are only set (not zero) if the job or sequence is retried.
+func Retry(job proto.Job) error {
+	for _, wait := range []string{job.RetryWait, job.SequenceRetryWait} {
+		if wait == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(wait); err != nil {
+			return fmt.Errorf("invalid retry wait %q: %s", wait, err)
+		}
+	}
+	if job.Retry == 0 && !zeroWait(job.RetryWait) {
+		return fmt.Errorf("retryWait %s is set but retry is 0", job.RetryWait)
+	}
+	if job.SequenceRetry == 0 && !zeroWait(job.SequenceRetryWait) {
+		return fmt.Errorf("sequenceRetryWait %s is set but sequenceRetry is 0", job.SequenceRetryWait)
+	}
+	return nil
+}
+
+// Needs returns the runner capabilities needed by jobs in the job chain that
+// have not completed (Job.Needs), sorted. It returns nil if none are needed.
+func Needs(jobChain proto.JobChain) []string {
+	seen := map[string]bool{}
+	var needs []string
+	for _, job := range jobChain.Jobs {
+		if job.State == proto.STATE_COMPLETE {
+			continue
+		}
+		for _, c := range job.Needs {
+			if !seen[c] {
+				seen[c] = true
+				needs = append(needs, c)
+			}
+		}
+	}
+	sort.Strings(needs)
+	return needs
+}
+
+// Capabilities checks that a Job Runner with the capabilities can run the job
+// chain: it has every capability in Needs. It returns an ErrInvalidChain listing
+// the missing capabilities if not.
+func Capabilities(jobChain proto.JobChain, capabilities []string) error {
+	has := make(map[string]bool, len(capabilities))
+	for _, c := range capabilities {
+		has[c] = true
+	}
+	var missing []string
+	for _, c := range Needs(jobChain) {
+		if !has[c] {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		return ErrInvalidChain{Message: fmt.Sprintf("job chain needs capabilities this Job Runner does not have: %s", strings.Join(missing, ", "))}
+	}
+	return nil
+}
+
+// MAX_SJC_PROBLEMS is the max number of problems listed in the error returned
+// by SJC. More are counted but not listed.
+const MAX_SJC_PROBLEMS = 20
+
+// SJC checks if a suspended job chain can be resumed: the job chain is valid
+// (Chain), and its job IDs, adjacency list, sequence IDs, and tries maps are
+// consistent with each other. A suspended job chain that is not would make the
+// Job Runner fail or panic while running it, so it's rejected instead. SJC
+// returns an ErrInvalidChain listing every problem found (up to MAX_SJC_PROBLEMS),
+// sorted, or nil if the suspended job chain is valid.
+func SJC(sjc proto.SuspendedJobChain) error {
+	jc := sjc.JobChain
+	if jc == nil {
+		return ErrInvalidChain{Message: fmt.Sprintf("invalid suspended job chain for request %s: no job chain", sjc.RequestId)}
+	}
+	var problems []string
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if sjc.RequestId == "" {
+		problem("request ID is empty")
+	} else if jc.RequestId != sjc.RequestId {
+		problem("job chain request ID %q does not match request ID %q", jc.RequestId, sjc.RequestId)
+	}
+	if len(jc.Jobs) == 0 {
+		problem("job chain has no jobs")
+	}
+
+	// Job IDs and sequence IDs
+	for jobId, job := range jc.Jobs {
+		if job.Id != jobId {
+			problem("job %s: ID %q does not match its key in the job chain", jobId, job.Id)
+			continue
+		}
+		if err := Sequence(*jc, job); err != nil {
+			problem("job %s: %s", jobId, err)
+		}
+	}
+
+	// Every job in the adjacency list is a job in the chain
+	for jobId, next := range jc.AdjacencyList {
+		if _, ok := jc.Jobs[jobId]; !ok {
+			problem("adjacency list: job %s does not exist", jobId)
+		}
+		for _, nextId := range next {
+			if _, ok := jc.Jobs[nextId]; !ok {
+				problem("adjacency list: job %s (next of %s) does not exist", nextId, jobId)
+			}
+		}
+	}
+
+	// Tries are only for jobs in the chain, and sequence tries only for the
+	// first job in a sequence. Latest run tries are part of total tries. A
+	// stopped job was tried (the Job Runner decrements its tries to run it
+	// again), and so was its sequence if it's the first job in the sequence.
+	for jobId, n := range sjc.TotalJobTries {
+		if _, ok := jc.Jobs[jobId]; !ok {
+			problem("totalJobTries: job %s does not exist", jobId)
+		} else if latest := sjc.LatestRunJobTries[jobId]; latest > n {
+			problem("job %s: latest run tries %d greater than total tries %d", jobId, latest, n)
+		}
+	}
+	for jobId, n := range sjc.LatestRunJobTries {
+		if _, ok := jc.Jobs[jobId]; !ok {
+			problem("latestRunJobTries: job %s does not exist", jobId)
+		} else if _, ok := sjc.TotalJobTries[jobId]; !ok && n > 0 {
+			problem("job %s: latest run tries %d but no total tries", jobId, n)
+		}
+	}
+	for seqId := range sjc.SequenceTries {
+		job, ok := jc.Jobs[seqId]
+		if !ok {
+			problem("sequenceTries: job %s does not exist", seqId)
+		} else if job.SequenceId != job.Id {
+			problem("sequenceTries: job %s is not the first job in a sequence", seqId)
+		}
+	}
+	for jobId, job := range jc.Jobs {
+		if job.State != proto.STATE_STOPPED {
+			continue
+		}
+		if sjc.LatestRunJobTries[jobId] == 0 {
+			problem("job %s: state is STOPPED but latest run tries is 0", jobId)
+		}
+		if job.Id == job.SequenceId && sjc.SequenceTries[jobId] == 0 {
+			problem("job %s: state is STOPPED but sequence tries is 0", jobId)
+		}
+	}
+
+	// Graph and job states, if the job chain is consistent enough to check
+	if len(problems) == 0 {
+		if err := Chain(*jc, false); err != nil {
+			problem("%s", err)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	n := len(problems)
+	if n > MAX_SJC_PROBLEMS {
+		problems = append(problems[:MAX_SJC_PROBLEMS], fmt.Sprintf("and %d more", n-MAX_SJC_PROBLEMS))
+	}
+	return ErrInvalidChain{
+		Message: fmt.Sprintf("invalid suspended job chain for request %s: %d problems: %s", sjc.RequestId, n, strings.Join(problems, "; ")),
+	}
+}
+
+// zeroWait returns true if the retry wait, which must be valid, is not set or
+// zero, like the default "0s" of sequences.
+func zeroWait(wait string) bool {
+	if wait == "" {
+		return true
+	}
+	d, _ := time.ParseDuration(wait)
+	return d == 0
+}
+
+// adjacencyListIsValid returns whether or not the chain's adjacency list is
+// not valid. An adjacency list is not valid if any of the jobs in it do not
+// exist in chain.Jobs.
+func adjacencyListIsValid(jobChain proto.JobChain) bool {
+	for job, adjJobs := range jobChain.AdjacencyList {
+		if _, ok := jobChain.Jobs[job]; !ok {
+			return false
+		}
+
+		for _, adjJob := range adjJobs {
+			if _, ok := jobChain.Jobs[adjJob]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hasFirstJob finds the job in the chain with indegree 0. If there is not
+// exactly one of these jobs, it returns an error.
+func hasFirstJob(jobChain proto.JobChain) bool {
+	n := 0
+	for _, count := range indegreeCounts(jobChain) {
+		if count == 0 {
+			n++
+		}
+		if n > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// indegreeCounts finds the indegree for each job in the chain.
+func indegreeCounts(jobChain proto.JobChain) map[string]int {
+	indegreeCounts := make(map[string]int)
+	for job := range jobChain.Jobs {
+		indegreeCounts[job] = 0
+	}
+	for _, nextJobs := range jobChain.AdjacencyList {
+		for _, nextJob := range nextJobs {
+			if _, ok := indegreeCounts[nextJob]; ok {
+				indegreeCounts[nextJob] += 1
+			}
+		}
+	}
+	return indegreeCounts
+}
+
+// hasLastJob finds the job in the chain with outdegree 0. If there is not
+// exactly one of these jobs, it returns an error.
+func hasLastJob(jobChain proto.JobChain) bool {
+	n := 0
+	for _, count := range outdegreeCounts(jobChain) {
+		if count == 0 {
+			n++
+		}
+		if n > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// outdegreeCounts finds the outdegree for each job in the chain.
+func outdegreeCounts(jobChain proto.JobChain) map[string]int {
+	outdegreeCounts := make(map[string]int)
+	for job := range jobChain.Jobs {
+		outdegreeCounts[job] = len(jobChain.AdjacencyList[job])
+	}
+	return outdegreeCounts
+}
+
+// isAcyclic returns whether or not a job chain is acyclic. It essentially
+// works by moving through the job chain from the top (the first job)
+// down to the bottom (the last job), and if there are any cycles in the
+// chain (dependencies that go in the opposite direction...i.e., bottom to
+// top), it returns false.
+func isAcyclic(jobChain proto.JobChain) bool {
+	indegreeCounts := indegreeCounts(jobChain)
+	queue := make(map[string]struct{})
+
+	// Add all of the first jobs to the queue (in reality there should
+	// only be 1).
+	for job, indegreeCount := range indegreeCounts {
+		if indegreeCount == 0 {
+			queue[job] = struct{}{}
+		}
+	}
+
+	jobsVisited := 0
+	for {
+		// Break when there are no more jobs in the queue. This happens
+		// when either there are no first jobs, or when a cycle
+		// prevents us from enqueuing a job below.
+		if len(queue) == 0 {
+			break
+		}
+
+		// Get a job from the queue.
+		var curJob string
+		for k := range queue {
+			curJob = k
+		}
+		delete(queue, curJob)
+
+		// Visit each job adjacent to the current job and decrement
+		// their indegree count by 1. When a job's indegree count
+		// becomes 0, add it to the queue.
+		//
+		// If there is a cycle somewhere, at least one jobs indegree
+		// count will never reach 0, and therefore it will never be
+		// enqueued and visited.
+		for _, adjJob := range jobChain.AdjacencyList[curJob] {
+			indegreeCounts[adjJob] -= 1
+			if indegreeCounts[adjJob] == 0 {
+				queue[adjJob] = struct{}{}
+			}
+		}
+
+		// Keep track of the number of jobs we've visited. If there is
+		// a cycle in the chain, we won't end up visiting some jobs.
+		jobsVisited += 1
+	}
+
+	if jobsVisited != len(jobChain.Jobs) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,424 @@
+// Copyright 2017-2020, Square, Inc.
+
+package validate
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/square/spincycle/v2/proto"
+	testutil "github.com/square/spincycle/v2/test"
+)
+
+func TestValidateJobChain(t *testing.T) {
+	jc := proto.JobChain{
+		Jobs: testutil.InitJobs(4),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"}, // first job
+			"job2": {"job4"},
+			"job3": {"job4"},
+		},
+	}
+	if err := Chain(jc, true); err != nil {
+		t.Error(err)
+	}
+
+	// Invalid adjacency list
+	jc = proto.JobChain{
+		Jobs: testutil.InitJobs(2),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+			"job7": {},
+		},
+	}
+	if err := Chain(jc, true); err == nil {
+		t.Error("no error, expected error on invalid chain")
+	}
+
+	// Invalid first job
+	jc = proto.JobChain{
+		Jobs: testutil.InitJobs(4),
+		AdjacencyList: map[string][]string{
+			"job1": {"job3"}, // first job
+			"job2": {"job3"}, // first job
+		},
+	}
+	if err := Chain(jc, true); err == nil {
+		t.Error("no error, expected error on invalid chain")
+	}
+}
+
+func TestValidateFirstJobMultiple(t *testing.T) {
+	jc := proto.JobChain{
+		Jobs: testutil.InitJobs(4),
+		AdjacencyList: map[string][]string{
+			"job1": {"job3"}, // first job
+			"job2": {"job3"}, // first job
+			"job3": {"job4"},
+		},
+	}
+	if hasFirstJob(jc) {
+		t.Errorf("hasFirstJob = true, expected false")
+	}
+}
+
+func TestValidateFirstJobOne(t *testing.T) {
+	jc := proto.JobChain{
+		Jobs: testutil.InitJobs(4),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"}, // first job
+			"job2": {"job4"},
+			"job3": {"job4"},
+		},
+	}
+	if !hasFirstJob(jc) {
+		t.Errorf("hasFirstJob = false, expected true")
+	}
+}
+
+func TestValidateLastJobMultiple(t *testing.T) {
+	jc := proto.JobChain{
+		Jobs: testutil.InitJobs(3),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+		},
+	}
+	if hasLastJob(jc) {
+		t.Errorf("hasLastJob = true, expected false")
+	}
+}
+
+func TestValidateLastJobOne(t *testing.T) {
+	jc := proto.JobChain{
+		Jobs: testutil.InitJobs(4),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+			"job2": {"job4"},
+			"job3": {"job4"},
+		},
+	}
+	if !hasLastJob(jc) {
+		t.Errorf("hasLastJob = false, expected true")
+	}
+}
+
+func TestValidateIndegreeCounts(t *testing.T) {
+	jc := proto.JobChain{
+		Jobs: testutil.InitJobs(9),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+			"job2": {"job4", "job5"},
+			"job3": {"job5", "job6"},
+			"job4": {"job6", "job7"},
+			"job5": {"job6"},
+			"job6": {"job8"},
+			"job7": {"job8"},
+		},
+	}
+	expectedCounts := map[string]int{
+		"job1": 0,
+		"job2": 1,
+		"job3": 1,
+		"job4": 1,
+		"job5": 2,
+		"job6": 3,
+		"job7": 1,
+		"job8": 2,
+		"job9": 0,
+	}
+	counts := indegreeCounts(jc)
+	if !reflect.DeepEqual(counts, expectedCounts) {
+		t.Errorf("counts = %v, want %v", counts, expectedCounts)
+	}
+}
+
+func TestValidateOutdegreeCounts(t *testing.T) {
+	jc := proto.JobChain{
+		Jobs: testutil.InitJobs(7),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+			"job2": {"job4", "job5", "job6"},
+			"job3": {"job5", "job6"},
+			"job4": {"job5", "job6"},
+			"job5": {"job6"},
+			"job6": {"job7"},
+		},
+	}
+	expectedCounts := map[string]int{
+		"job1": 2,
+		"job2": 3,
+		"job3": 2,
+		"job4": 2,
+		"job5": 1,
+		"job6": 1,
+		"job7": 0,
+	}
+	counts := outdegreeCounts(jc)
+	if !reflect.DeepEqual(counts, expectedCounts) {
+		t.Errorf("counts = %v, want %v", counts, expectedCounts)
+	}
+}
+
+func TestValidateIsAcyclic(t *testing.T) {
+	// No cycles in the chain.
+	jc := proto.JobChain{
+		Jobs: testutil.InitJobs(4),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+			"job2": {"job4"},
+			"job3": {"job4"},
+		},
+	}
+	if !isAcyclic(jc) {
+		t.Error("isAcyclic = false, expected true")
+	}
+
+	// Cycle from end (job4) to start (job1). Also means no first job.
+	jc = proto.JobChain{
+		Jobs: testutil.InitJobs(4),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+			"job2": {"job4"},
+			"job3": {"job4"},
+			"job4": {"job1"}, // creates cycle: 4 -> 1 -> 2, 3 -> 4
+		},
+	}
+	if isAcyclic(jc) {
+		t.Error("isAcyclic = true, expected false")
+	}
+
+	// Cycle in the middle of the chain.
+	jc = proto.JobChain{
+		Jobs: testutil.InitJobs(4),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+			"job2": {"job4"},
+			"job3": {"job5"},
+			"job4": {"job5"},
+			"job5": {"job2", "job6"}, // creates cycle: 5 -> 2 - 4 -> 5
+		},
+	}
+	if isAcyclic(jc) {
+		t.Error("isAcyclic = true, expected false")
+	}
+
+	// No cycle, but multiple first jobs and last jobs.
+	jc = proto.JobChain{
+		Jobs: testutil.InitJobs(5),
+		AdjacencyList: map[string][]string{
+			"job1": {"job3"},
+			"job2": {"job3"},
+			"job3": {"job4", "job5"},
+		},
+	}
+	if !isAcyclic(jc) {
+		t.Error("isAcyclic = false, expected true")
+	}
+}
+
+func TestValidateAdjacencyList(t *testing.T) {
+	// Valid, no cycles
+	jc := proto.JobChain{
+		Jobs: testutil.InitJobs(3),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+			"job2": {"job3"},
+		},
+	}
+	if !adjacencyListIsValid(jc) {
+		t.Error("adjacencyListIsValid = false, expected true")
+	}
+
+	// Invalid: job2 and job3 have no edges, and job3 doesn't exist
+	jc = proto.JobChain{
+		Jobs: testutil.InitJobs(2),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2", "job3"},
+		},
+	}
+	if adjacencyListIsValid(jc) {
+		t.Error("adjacencyListIsValid = true, expected false")
+	}
+
+	// Invalid: job7 doesn't exist
+	jc = proto.JobChain{
+		Jobs: testutil.InitJobs(2),
+		AdjacencyList: map[string][]string{
+			"job1": {"job2"},
+			"job7": {},
+		},
+	}
+	if adjacencyListIsValid(jc) {
+		t.Error("adjacencyListIsValid = true, expected false")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	newChain := func() proto.JobChain {
+		return proto.JobChain{
+			Jobs: map[string]proto.Job{
+				"a1a1": {Id: "a1a1", Type: "noop", SequenceId: "a1a1", SequenceRetry: 1, SequenceRetryWait: "5s"},
+				"b2b2": {Id: "b2b2", Type: "restart", SequenceId: "a1a1", Retry: 2, RetryWait: "1s"},
+				"c3c3": {Id: "c3c3", Type: "noop", SequenceId: "c3c3", SequenceRetryWait: "0s"}, // like grapher default
+				"d4d4": {Id: "d4d4", Type: "check", SequenceId: "c3c3", LogLevel: proto.LOG_LEVEL_DEBUG},
+			},
+			AdjacencyList: map[string][]string{
+				"a1a1": {"b2b2"},
+				"b2b2": {"c3c3"},
+				"c3c3": {"d4d4"},
+			},
+		}
+	}
+	if err := Plan(newChain()); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	invalid := map[string]func(jc *proto.JobChain){
+		"no jobs":                func(jc *proto.JobChain) { jc.Jobs = nil },
+		"ID not key":             func(jc *proto.JobChain) { jc.Jobs["b2b2"] = proto.Job{Id: "z9z9", Type: "restart", SequenceId: "a1a1"} },
+		"no type":                func(jc *proto.JobChain) { jc.Jobs["b2b2"] = proto.Job{Id: "b2b2", SequenceId: "a1a1"} },
+		"sequence not in chain":  func(jc *proto.JobChain) { jc.Jobs["b2b2"] = proto.Job{Id: "b2b2", Type: "restart", SequenceId: "z9z9"} },
+		"sequence not first job": func(jc *proto.JobChain) { jc.Jobs["d4d4"] = proto.Job{Id: "d4d4", Type: "check", SequenceId: "b2b2"} },
+		"sequence retry not first": func(jc *proto.JobChain) {
+			jc.Jobs["d4d4"] = proto.Job{Id: "d4d4", Type: "check", SequenceId: "c3c3", SequenceRetry: 1}
+		},
+		"invalid retry wait": func(jc *proto.JobChain) {
+			jc.Jobs["b2b2"] = proto.Job{Id: "b2b2", Type: "restart", SequenceId: "a1a1", Retry: 1, RetryWait: "soon"}
+		},
+		"retry wait without retry": func(jc *proto.JobChain) {
+			jc.Jobs["b2b2"] = proto.Job{Id: "b2b2", Type: "restart", SequenceId: "a1a1", RetryWait: "1s"}
+		},
+		"seq wait without seq retry": func(jc *proto.JobChain) {
+			jc.Jobs["c3c3"] = proto.Job{Id: "c3c3", Type: "noop", SequenceId: "c3c3", SequenceRetryWait: "1s"}
+		},
+		"invalid log level": func(jc *proto.JobChain) {
+			jc.Jobs["d4d4"] = proto.Job{Id: "d4d4", Type: "check", SequenceId: "c3c3", LogLevel: "loud"}
+		},
+		"two last jobs": func(jc *proto.JobChain) { jc.AdjacencyList["b2b2"] = []string{} },
+		"cycle":         func(jc *proto.JobChain) { jc.AdjacencyList["d4d4"] = []string{"b2b2"} },
+	}
+	for name, change := range invalid {
+		jc := newChain()
+		change(&jc)
+		if err := Plan(jc); err == nil {
+			t.Errorf("%s: no error, expected one", name)
+		} else if _, ok := err.(ErrInvalidChain); !ok {
+			t.Errorf("%s: got %T, expected ErrInvalidChain", name, err)
+		}
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	jc := proto.JobChain{
+		Jobs: map[string]proto.Job{
+			"job1": {Id: "job1", State: proto.STATE_COMPLETE, Needs: []string{"docker"}},
+			"job2": {Id: "job2", State: proto.STATE_PENDING, Needs: []string{"gpu", "docker"}},
+			"job3": {Id: "job3", State: proto.STATE_PENDING, Needs: []string{"gpu"}},
+			"job4": {Id: "job4", State: proto.STATE_PENDING},
+		},
+	}
+	if diff := deep.Equal(Needs(jc), []string{"docker", "gpu"}); diff != nil {
+		t.Error(diff)
+	}
+	if err := Capabilities(jc, []string{"gpu", "docker", "ssd"}); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+	if _, ok := Capabilities(jc, []string{"docker"}).(ErrInvalidChain); !ok {
+		t.Error("no ErrInvalidChain for missing capability gpu")
+	}
+
+	// Completed jobs do not run again, so their needs do not count
+	jc.Jobs["job2"] = proto.Job{Id: "job2", State: proto.STATE_COMPLETE, Needs: []string{"gpu", "docker"}}
+	if diff := deep.Equal(Needs(jc), []string{"gpu"}); diff != nil {
+		t.Error(diff)
+	}
+	jc.Jobs["job3"] = proto.Job{Id: "job3", State: proto.STATE_COMPLETE, Needs: []string{"gpu"}}
+	if needs := Needs(jc); needs != nil {
+		t.Errorf("got needs %v, expected nil", needs)
+	}
+	if err := Capabilities(jc, nil); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+}
+
+func TestSJC(t *testing.T) {
+	// job1 -> job2 -> job3, job1 and job3 start sequences, suspended while
+	// job2 was running (stopped on its 2nd try)
+	newSJC := func() proto.SuspendedJobChain {
+		return proto.SuspendedJobChain{
+			RequestId: "req1",
+			JobChain: &proto.JobChain{
+				RequestId: "req1",
+				Jobs: map[string]proto.Job{
+					"job1": {Id: "job1", Type: "t", State: proto.STATE_COMPLETE, SequenceId: "job1"},
+					"job2": {Id: "job2", Type: "t", State: proto.STATE_STOPPED, SequenceId: "job1", Retry: 2},
+					"job3": {Id: "job3", Type: "t", State: proto.STATE_PENDING, SequenceId: "job3"},
+				},
+				AdjacencyList: map[string][]string{
+					"job1": {"job2"},
+					"job2": {"job3"},
+				},
+				State:        proto.STATE_SUSPENDED,
+				FinishedJobs: 1,
+			},
+			TotalJobTries:     map[string]uint{"job1": 1, "job2": 3},
+			LatestRunJobTries: map[string]uint{"job1": 1, "job2": 2},
+			SequenceTries:     map[string]uint{"job1": 2},
+		}
+	}
+	if err := SJC(newSJC()); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	// Tries maps are optional if no job was tried
+	sjc := newSJC()
+	sjc.JobChain.Jobs["job1"] = proto.Job{Id: "job1", Type: "t", State: proto.STATE_PENDING, SequenceId: "job1"}
+	sjc.JobChain.Jobs["job2"] = proto.Job{Id: "job2", Type: "t", State: proto.STATE_PENDING, SequenceId: "job1"}
+	sjc.JobChain.FinishedJobs = 0
+	sjc.TotalJobTries = nil
+	sjc.LatestRunJobTries = nil
+	sjc.SequenceTries = nil
+	if err := SJC(sjc); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	invalid := map[string]func(sjc *proto.SuspendedJobChain){
+		"no job chain":        func(sjc *proto.SuspendedJobChain) { sjc.JobChain = nil },
+		"request ID mismatch": func(sjc *proto.SuspendedJobChain) { sjc.JobChain.RequestId = "req2" },
+		"job ID mismatch": func(sjc *proto.SuspendedJobChain) {
+			sjc.JobChain.Jobs["job3"] = proto.Job{Id: "job4", Type: "t", SequenceId: "job3"}
+		},
+		"missing sequence": func(sjc *proto.SuspendedJobChain) {
+			sjc.JobChain.Jobs["job3"] = proto.Job{Id: "job3", Type: "t", SequenceId: "job9"}
+		},
+		"missing next job":          func(sjc *proto.SuspendedJobChain) { sjc.JobChain.AdjacencyList["job3"] = []string{"job9"} },
+		"tries for missing job":     func(sjc *proto.SuspendedJobChain) { sjc.TotalJobTries["job9"] = 1 },
+		"latest tries > total":      func(sjc *proto.SuspendedJobChain) { sjc.LatestRunJobTries["job1"] = 2 },
+		"sequence tries not start":  func(sjc *proto.SuspendedJobChain) { sjc.SequenceTries["job2"] = 1 },
+		"stopped job without tries": func(sjc *proto.SuspendedJobChain) { delete(sjc.LatestRunJobTries, "job2") },
+		"invalid job state": func(sjc *proto.SuspendedJobChain) {
+			sjc.JobChain.Jobs["job3"] = proto.Job{Id: "job3", Type: "t", State: proto.STATE_RUNNING, SequenceId: "job3"}
+		},
+	}
+	for name, change := range invalid {
+		sjc := newSJC()
+		change(&sjc)
+		if err := SJC(sjc); err == nil {
+			t.Errorf("%s: no error, expected one", name)
+		} else if _, ok := err.(ErrInvalidChain); !ok {
+			t.Errorf("%s: got %T, expected ErrInvalidChain", name, err)
+		}
+	}
+
+	// Every problem is reported
+	sjc = newSJC()
+	sjc.TotalJobTries["job9"] = 1
+	sjc.SequenceTries["job2"] = 1
+	expect := "invalid suspended job chain for request req1: 2 problems: " +
+		"sequenceTries: job job2 is not the first job in a sequence; totalJobTries: job job9 does not exist"
+	if err := SJC(sjc); err == nil || err.Error() != expect {
+		t.Errorf("got error %v, expected %s", err, expect)
+	}
+}